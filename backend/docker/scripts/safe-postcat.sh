@@ -0,0 +1,24 @@
+#!/bin/bash
+# Wrapper script for postcat with queue ID validation
+# Prints a queued message's headers and body for content scanning
+
+set -euo pipefail
+
+# Queue ID must be 10-12 uppercase hex characters
+QUEUEID_REGEX='^[A-F0-9]{10,12}$'
+
+if [ $# -ne 1 ]; then
+    echo "Usage: $0 QUEUE_ID"
+    exit 1
+fi
+
+QUEUE_ID="$1"
+
+# Validate queue ID format
+if [[ ! "$QUEUE_ID" =~ $QUEUEID_REGEX ]]; then
+    echo "Error: Invalid queue ID format '$QUEUE_ID'" >&2
+    exit 1
+fi
+
+# -h headers, -b body; envelope records are omitted
+exec /usr/sbin/postcat -h -b -q "$QUEUE_ID"
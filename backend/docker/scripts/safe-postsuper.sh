@@ -8,13 +8,28 @@ set -euo pipefail
 QUEUEID_REGEX='^[A-F0-9]{10,12}$'
 
 usage() {
-    echo "Usage: $0 -h|-H|-d QUEUE_ID"
+    echo "Usage: $0 -h|-H|-d|-r QUEUE_ID"
+    echo "       $0 -h|-H|-d|-r - < QUEUE_IDS"
+    echo "       $0 -d ALL [deferred]"
     echo "  -h QUEUE_ID  Hold message"
     echo "  -H QUEUE_ID  Release message from hold"
     echo "  -d QUEUE_ID  Delete message"
+    echo "  -r QUEUE_ID  Requeue message"
+    echo "  -            Read queue IDs from standard input, one per line"
+    echo "  -d ALL       Delete every message, or only deferred ones"
     exit 1
 }
 
+# Bulk delete, optionally limited to the deferred queue
+if [ $# -ge 2 ] && [ "$1" = "-d" ] && [ "$2" = "ALL" ]; then
+    if [ $# -eq 2 ]; then
+        exec /usr/sbin/postsuper -d ALL
+    elif [ $# -eq 3 ] && [ "$3" = "deferred" ]; then
+        exec /usr/sbin/postsuper -d ALL deferred
+    fi
+    usage
+fi
+
 if [ $# -ne 2 ]; then
     usage
 fi
@@ -24,7 +39,7 @@ QUEUE_ID="$2"
 
 # Validate action
 case "$ACTION" in
-    -h|-H|-d)
+    -h|-H|-d|-r)
         ;;
     *)
         echo "Error: Invalid action '$ACTION'" >&2
@@ -32,6 +47,24 @@ case "$ACTION" in
         ;;
 esac
 
+# Batch mode: validate every queue ID before postsuper sees any of them
+if [ "$QUEUE_ID" = "-" ]; then
+    IDS=()
+    while IFS= read -r ID || [ -n "$ID" ]; do
+        [ -z "$ID" ] && continue
+        if [[ ! "$ID" =~ $QUEUEID_REGEX ]]; then
+            echo "Error: Invalid queue ID format '$ID'" >&2
+            exit 1
+        fi
+        IDS+=("$ID")
+    done
+    if [ ${#IDS[@]} -eq 0 ]; then
+        exit 0
+    fi
+    printf '%s\n' "${IDS[@]}" | /usr/sbin/postsuper "$ACTION" -
+    exit 0
+fi
+
 # Validate queue ID format
 if [[ ! "$QUEUE_ID" =~ $QUEUEID_REGEX ]]; then
     echo "Error: Invalid queue ID format '$QUEUE_ID'" >&2
@@ -0,0 +1,83 @@
+#!/bin/bash
+# Wrapper script for starting, stopping and checking the mail services
+# Only postfix and dovecot can be controlled, with a fixed set of actions
+
+set -euo pipefail
+
+usage() {
+    echo "Usage: $0 postfix|dovecot start|stop|restart|status"
+    exit 1
+}
+
+if [ $# -ne 2 ]; then
+    usage
+fi
+
+SERVICE="$1"
+ACTION="$2"
+
+case "$SERVICE" in
+    postfix|dovecot)
+        ;;
+    *)
+        echo "Error: Invalid service '$SERVICE'" >&2
+        exit 1
+        ;;
+esac
+
+case "$ACTION" in
+    start|stop|restart|status)
+        ;;
+    *)
+        echo "Error: Invalid action '$ACTION'" >&2
+        exit 1
+        ;;
+esac
+
+# Hosts managed by systemd
+if command -v systemctl >/dev/null 2>&1 && [ -d /run/systemd/system ]; then
+    if [ "$ACTION" = "status" ]; then
+        exec systemctl is-active --quiet "$SERVICE"
+    fi
+    exec systemctl "$ACTION" "$SERVICE"
+fi
+
+# Containers running the services under supervisord
+if command -v supervisorctl >/dev/null 2>&1 && supervisorctl status "$SERVICE" >/dev/null 2>&1; then
+    if [ "$ACTION" = "status" ]; then
+        supervisorctl status "$SERVICE" | grep -q RUNNING
+        exit $?
+    fi
+    exec supervisorctl "$ACTION" "$SERVICE"
+fi
+
+# Otherwise use the services' own commands
+case "$SERVICE" in
+    postfix)
+        case "$ACTION" in
+            status) exec /usr/sbin/postfix status ;;
+            start) exec /usr/sbin/postfix start ;;
+            stop) exec /usr/sbin/postfix stop ;;
+            restart)
+                /usr/sbin/postfix stop || true
+                exec /usr/sbin/postfix start
+                ;;
+        esac
+        ;;
+    dovecot)
+        case "$ACTION" in
+            status) exec /usr/bin/doveadm service status >/dev/null ;;
+            start) exec /usr/sbin/dovecot ;;
+            stop) exec /usr/bin/doveadm stop ;;
+            restart)
+                /usr/bin/doveadm stop || true
+                # Wait for the old master process to exit before starting again
+                for _ in $(seq 1 20); do
+                    pidof dovecot >/dev/null 2>&1 || break
+                    sleep 0.5
+                done
+                exec /usr/sbin/dovecot
+                ;;
+        esac
+        ;;
+esac
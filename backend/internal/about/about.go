@@ -0,0 +1,245 @@
+// Package about describes what an installation runs: the backend's build,
+// the versions of the mail components next to it and, when the Docker
+// socket is reachable, the containers of its compose project.
+package about
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Version is the release the backend was built from, set with
+// -ldflags "-X github.com/postfixrelay/postfixrelay/internal/about.Version=..."
+var Version = "dev"
+
+// Build is the backend's build information
+type Build struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+	Revision  string `json:"revision,omitempty"`
+	BuiltAt   string `json:"builtAt,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// BackendBuild returns the running binary's build information
+func BackendBuild() Build {
+	b := Build{
+		Version:   Version,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				b.Revision = setting.Value
+			case "vcs.time":
+				b.BuiltAt = setting.Value
+			case "vcs.modified":
+				b.Modified = setting.Value == "true"
+			}
+		}
+	}
+	return b
+}
+
+// Component is the version of a program the panel works with. Version is
+// empty when the program isn't installed where the panel runs.
+type Component struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Present bool   `json:"present"`
+}
+
+var versionNumber = regexp.MustCompile(`\d+(\.\d+)+\S*`)
+
+// commandVersion runs a program's version command and picks the version
+// number from its first line
+func commandVersion(name string, args ...string) Component {
+	c := Component{Name: name}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return c
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	c.Present = true
+	c.Version = versionNumber.FindString(line)
+	if c.Version == "" {
+		c.Version = strings.TrimSpace(line)
+	}
+	return c
+}
+
+// Components returns the versions of Postfix, Dovecot and rspamd found
+// next to the backend
+func Components() []Component {
+	return []Component{
+		commandVersion("postfix", "postconf", "-d", "-h", "mail_version"),
+		commandVersion("dovecot", "dovecot", "--version"),
+		commandVersion("rspamd", "rspamd", "--version"),
+	}
+}
+
+// Container is one container of the compose project
+type Container struct {
+	Name        string   `json:"name"`
+	Service     string   `json:"service,omitempty"`
+	Image       string   `json:"image"`
+	ImageID     string   `json:"imageId"`
+	RepoDigests []string `json:"repoDigests,omitempty"`
+	State       string   `json:"state"`
+	Status      string   `json:"status,omitempty"`
+	Self        bool     `json:"self,omitempty"`
+}
+
+// Deployment is how the backend is deployed
+type Deployment struct {
+	InContainer bool        `json:"inContainer"`
+	Hostname    string      `json:"hostname"`
+	Project     string      `json:"project,omitempty"` // compose project
+	Containers  []Container `json:"containers,omitempty"`
+	DockerError string      `json:"dockerError,omitempty"`
+}
+
+// inContainer reports whether the backend runs in a container
+func inContainer() bool {
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	f, err := os.Open("/proc/1/cgroup")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, "docker") || strings.Contains(line, "containerd") || strings.Contains(line, "kubepods") {
+			return true
+		}
+	}
+	return false
+}
+
+// dockerClient talks to the Docker Engine API over its unix socket
+type dockerClient struct {
+	http *http.Client
+}
+
+func newDockerClient(socket string) *dockerClient {
+	return &dockerClient{http: &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}}
+}
+
+func (c *dockerClient) get(path string, v interface{}) error {
+	resp, err := c.http.Get("http://docker" + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		return fmt.Errorf("docker %s: %s: %s", path, resp.Status, strings.TrimSpace(body.String()))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+const composeProjectLabel = "com.docker.compose.project"
+const composeServiceLabel = "com.docker.compose.service"
+
+// Inspect describes the deployment. Containers are listed when the Docker
+// socket is mounted into the backend's container.
+func Inspect(dockerSocket string) Deployment {
+	d := Deployment{InContainer: inContainer()}
+	d.Hostname, _ = os.Hostname()
+	if _, err := os.Stat(dockerSocket); err != nil {
+		return d
+	}
+	client := newDockerClient(dockerSocket)
+
+	// A container's hostname is its short ID unless compose overrides it
+	var self struct {
+		ID     string `json:"Id"`
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+	}
+	if err := client.get("/containers/"+url.PathEscape(d.Hostname)+"/json", &self); err != nil {
+		d.DockerError = err.Error()
+		return d
+	}
+	d.Project = self.Config.Labels[composeProjectLabel]
+
+	path := "/containers/json?all=true"
+	if d.Project != "" {
+		filters, _ := json.Marshal(map[string][]string{"label": {composeProjectLabel + "=" + d.Project}})
+		path += "&filters=" + url.QueryEscape(string(filters))
+	} else {
+		path += "&filters=" + url.QueryEscape(`{"id":["`+self.ID+`"]}`)
+	}
+	var list []struct {
+		ID      string            `json:"Id"`
+		Names   []string          `json:"Names"`
+		Image   string            `json:"Image"`
+		ImageID string            `json:"ImageID"`
+		State   string            `json:"State"`
+		Status  string            `json:"Status"`
+		Labels  map[string]string `json:"Labels"`
+	}
+	if err := client.get(path, &list); err != nil {
+		d.DockerError = err.Error()
+		return d
+	}
+
+	digests := map[string][]string{}
+	for _, ct := range list {
+		name := ""
+		if len(ct.Names) > 0 {
+			name = strings.TrimPrefix(ct.Names[0], "/")
+		}
+		if _, ok := digests[ct.ImageID]; !ok {
+			var image struct {
+				RepoDigests []string `json:"RepoDigests"`
+			}
+			client.get("/images/"+url.PathEscape(ct.ImageID)+"/json", &image)
+			digests[ct.ImageID] = image.RepoDigests
+		}
+		d.Containers = append(d.Containers, Container{
+			Name:        name,
+			Service:     ct.Labels[composeServiceLabel],
+			Image:       ct.Image,
+			ImageID:     ct.ImageID,
+			RepoDigests: digests[ct.ImageID],
+			State:       ct.State,
+			Status:      ct.Status,
+			Self:        ct.ID == self.ID,
+		})
+	}
+	return d
+}
@@ -0,0 +1,26 @@
+package alerts
+
+// BackgroundFailureSource returns the background task with the most
+// consecutive failed runs, how many, and its last error; failures is 0
+// when none is failing
+type BackgroundFailureSource func() (task string, failures int, lastError string)
+
+// SetBackgroundFailureSource sets where background_failure rules find
+// failing background tasks
+func (e *Engine) SetBackgroundFailureSource(source BackgroundFailureSource) {
+	e.mu.Lock()
+	e.failures = source
+	e.mu.Unlock()
+}
+
+// backgroundFailures returns the worst failing background task, or
+// nothing without a source
+func (e *Engine) backgroundFailures() (string, int, string) {
+	e.mu.RLock()
+	source := e.failures
+	e.mu.RUnlock()
+	if source == nil {
+		return "", 0, ""
+	}
+	return source()
+}
@@ -0,0 +1,155 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Microsoft Teams and Discord channels post to an incoming webhook
+// (config key "webhook_url"), with the title and text rendered from the
+// channel's notification templates (see templates.go).
+
+// sendTeams sends an alert to a Teams channel as an Adaptive Card
+func (n *Notifier) sendTeams(ch NotificationChannel, alert Alert) error {
+	color := "Warning"
+	if alert.Severity == SeverityCritical {
+		color = "Attention"
+	}
+
+	facts := []map[string]string{
+		{"title": "Status", "value": string(alert.Status)},
+		{"title": "Triggered At", "value": alert.TriggeredAt.Format(time.RFC3339)},
+	}
+	if alert.IncidentID != 0 {
+		facts = append(facts, map[string]string{"title": "Incident", "value": fmt.Sprintf("#%d", alert.IncidentID)})
+	}
+
+	var actions []map[string]string
+	if alert.RunbookURL != "" {
+		actions = append(actions, map[string]string{"type": "Action.OpenUrl", "title": "Open runbook", "url": alert.RunbookURL})
+	}
+
+	title, text := n.render(ch, alertTemplateData(ch, alert))
+	return n.postTeams(ch, []map[string]interface{}{
+		{"type": "TextBlock", "text": title, "weight": "Bolder", "size": "Medium", "color": color, "wrap": true},
+		{"type": "TextBlock", "text": text, "wrap": true},
+		{"type": "FactSet", "facts": facts},
+	}, actions)
+}
+
+// sendIncidentResolvedTeams posts an incident's resolution to Teams
+func (n *Notifier) sendIncidentResolvedTeams(ch NotificationChannel, incident Incident) error {
+	title, text := n.render(ch, IncidentTemplateData(ch.Name, incident))
+	return n.postTeams(ch, []map[string]interface{}{
+		{"type": "TextBlock", "text": title, "weight": "Bolder", "size": "Medium", "color": "Good", "wrap": true},
+		{"type": "TextBlock", "text": text, "wrap": true},
+	}, nil)
+}
+
+// postTeams wraps card body elements in an Adaptive Card message and posts
+// it to the channel's webhook
+func (n *Notifier) postTeams(ch NotificationChannel, body []map[string]interface{}, actions []map[string]string) error {
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+	}
+	if len(actions) > 0 {
+		card["actions"] = actions
+	}
+
+	return n.postChat(ch, "Teams", map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{
+			{"contentType": "application/vnd.microsoft.card.adaptive", "content": card},
+		},
+	})
+}
+
+// sendDiscord sends an alert to a Discord channel as an embed
+func (n *Notifier) sendDiscord(ch NotificationChannel, alert Alert) error {
+	color := 0xffcc00
+	if alert.Severity == SeverityCritical {
+		color = 0xff0000
+	}
+
+	footer := "PostfixRelay Alert System"
+	if alert.IncidentID != 0 {
+		footer = fmt.Sprintf("%s · Incident #%d", footer, alert.IncidentID)
+	}
+
+	title, text := n.render(ch, alertTemplateData(ch, alert))
+	embed := map[string]interface{}{
+		"title":       title,
+		"description": text,
+		"color":       color,
+		"fields": []map[string]interface{}{
+			{"name": "Status", "value": string(alert.Status), "inline": true},
+			{"name": "Severity", "value": string(alert.Severity), "inline": true},
+		},
+		"footer":    map[string]string{"text": footer},
+		"timestamp": alert.TriggeredAt.Format(time.RFC3339),
+	}
+	if alert.RunbookURL != "" {
+		embed["url"] = alert.RunbookURL
+	}
+
+	return n.postDiscord(ch, embed)
+}
+
+// sendIncidentResolvedDiscord posts an incident's resolution to Discord
+func (n *Notifier) sendIncidentResolvedDiscord(ch NotificationChannel, incident Incident) error {
+	resolvedAt := time.Now().UTC()
+	if incident.ResolvedAt != nil {
+		resolvedAt = *incident.ResolvedAt
+	}
+
+	title, text := n.render(ch, IncidentTemplateData(ch.Name, incident))
+	return n.postDiscord(ch, map[string]interface{}{
+		"title":       title,
+		"description": text,
+		"color":       0x36a64f,
+		"footer":      map[string]string{"text": fmt.Sprintf("PostfixRelay Alert System · Incident #%d", incident.ID)},
+		"timestamp":   resolvedAt.Format(time.RFC3339),
+	})
+}
+
+// postDiscord posts an embed to the channel's webhook, under the
+// channel's "username" if one is configured
+func (n *Notifier) postDiscord(ch NotificationChannel, embed map[string]interface{}) error {
+	payload := map[string]interface{}{
+		"embeds": []map[string]interface{}{embed},
+	}
+	if username := ch.Config["username"]; username != "" {
+		payload["username"] = username
+	}
+	return n.postChat(ch, "Discord", payload)
+}
+
+// postChat posts a JSON payload to a chat channel's webhook_url
+func (n *Notifier) postChat(ch NotificationChannel, service string, payload map[string]interface{}) error {
+	webhookURL := ch.Config["webhook_url"]
+	if webhookURL == "" {
+		return fmt.Errorf("missing %s webhook URL", service)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.client.Post(webhookURL, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s returned status %d", service, resp.StatusCode)
+	}
+
+	return nil
+}
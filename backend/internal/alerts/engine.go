@@ -2,6 +2,10 @@ package alerts
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
 	"sync"
 	"time"
 
@@ -40,6 +44,16 @@ type Alert struct {
 	SilencedUntil  *time.Time             `json:"silencedUntil,omitempty"`
 	Context        map[string]interface{} `json:"context"`
 	Message        string                 `json:"message"`
+	RunbookURL     string                 `json:"runbookUrl,omitempty"`
+	IncidentID     int64                  `json:"incidentId,omitempty"`
+	References     []Reference            `json:"references,omitempty"`
+
+	// opensIncident is set on the alert that opened its incident, whose
+	// notification starts the incident's thread
+	opensIncident bool
+
+	// rule is the rule that fired, for notification templates
+	rule AlertRule
 }
 
 // AlertRule defines a detection rule
@@ -52,6 +66,7 @@ type AlertRule struct {
 	ThresholdValue    float64       `json:"thresholdValue"`
 	ThresholdDuration int           `json:"thresholdDuration"` // seconds
 	Severity          AlertSeverity `json:"severity"`
+	RunbookURL        string        `json:"runbookUrl,omitempty"` // External runbook, if set on the rule
 }
 
 // Metrics holds current system metrics for alert evaluation
@@ -72,7 +87,12 @@ type Engine struct {
 	rules    []AlertRule
 	metrics  Metrics
 	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
 	notifier *Notifier
+	queueIDs QueueIDSource
+	failures BackgroundFailureSource
+	hook     AlertHook
 }
 
 // NewEngine creates a new alert engine
@@ -87,24 +107,93 @@ func NewEngine(db *sql.DB) *Engine {
 
 // Start begins the alert detection loop
 func (e *Engine) Start() {
-	// Load rules from database
+	// Load rules and notification channels from database
 	e.loadRules()
+	e.ReloadChannels()
 
 	// Start detection loop
+	e.done = make(chan struct{})
 	go e.detectionLoop()
 
 	log.Info().Msg("Alert engine started")
 }
 
-// Stop stops the alert engine
+// Stop stops the alert engine and waits for an in-progress evaluation to finish
 func (e *Engine) Stop() {
-	close(e.stopCh)
+	e.stopOnce.Do(func() {
+		close(e.stopCh)
+		if e.done != nil {
+			<-e.done
+		}
+		log.Info().Msg("Alert engine stopped")
+	})
+}
+
+// ReloadRules re-reads alert rules from the database, picking up rule and
+// settings changes without restarting the engine
+func (e *Engine) ReloadRules() {
+	e.loadRules()
+}
+
+// ReloadChannels re-reads notification channels, templates and alert
+// routes from the database
+func (e *Engine) ReloadChannels() {
+	rows, err := e.db.Query(`SELECT id, name, type, config, enabled FROM notification_channels`)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load notification channels")
+		return
+	}
+	defer rows.Close()
+
+	var channels []NotificationChannel
+	for rows.Next() {
+		var ch NotificationChannel
+		var configJSON string
+		if err := rows.Scan(&ch.ID, &ch.Name, &ch.Type, &configJSON, &ch.Enabled); err != nil {
+			continue
+		}
+		json.Unmarshal([]byte(configJSON), &ch.Config)
+		channels = append(channels, ch)
+	}
+	e.notifier.SetChannels(channels)
+
+	trows, err := e.db.Query(`SELECT id, channel_id, event, subject, body FROM notification_templates`)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load notification templates")
+		return
+	}
+	defer trows.Close()
+
+	var templates []MessageTemplate
+	for trows.Next() {
+		var t MessageTemplate
+		if err := trows.Scan(&t.ID, &t.ChannelID, &t.Event, &t.Subject, &t.Body); err == nil {
+			templates = append(templates, t)
+		}
+	}
+	e.notifier.SetTemplates(templates)
+
+	routes, err := LoadRoutes(e.db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load alert routes")
+		return
+	}
+	e.notifier.SetRoutes(routes, e.defaultChannels())
+}
+
+// defaultChannels reads alert_default_channels, the channels alerts no
+// route matches go to
+func (e *Engine) defaultChannels() []int64 {
+	var value string
+	e.db.QueryRow(`SELECT value FROM settings WHERE key = 'alert_default_channels'`).Scan(&value)
+	return ParseChannelIDs(value)
 }
 
 // loadRules loads alert rules from the database
 func (e *Engine) loadRules() {
 	rows, err := e.db.Query(`
-		SELECT id, name, description, type, enabled, threshold_value, threshold_duration_seconds, severity
+		SELECT id, name, description, type, enabled, threshold_value, threshold_duration_seconds, severity,
+			COALESCE(runbook_url, '')
 		FROM alert_rules WHERE enabled = 1
 	`)
 	if err != nil {
@@ -116,7 +205,7 @@ func (e *Engine) loadRules() {
 	var rules []AlertRule
 	for rows.Next() {
 		var rule AlertRule
-		if err := rows.Scan(&rule.ID, &rule.Name, &rule.Description, &rule.Type, &rule.Enabled, &rule.ThresholdValue, &rule.ThresholdDuration, &rule.Severity); err != nil {
+		if err := rows.Scan(&rule.ID, &rule.Name, &rule.Description, &rule.Type, &rule.Enabled, &rule.ThresholdValue, &rule.ThresholdDuration, &rule.Severity, &rule.RunbookURL); err != nil {
 			continue
 		}
 		rules = append(rules, rule)
@@ -138,6 +227,8 @@ func (e *Engine) UpdateMetrics(m Metrics) {
 
 // detectionLoop runs the periodic alert detection
 func (e *Engine) detectionLoop() {
+	defer close(e.done)
+
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
@@ -189,6 +280,7 @@ func (e *Engine) evaluateRule(rule AlertRule, m Metrics) (bool, string, map[stri
 		ctx["deferredCount"] = m.QueueDeferred
 		ctx["threshold"] = rule.ThresholdValue
 		if float64(m.QueueDeferred) > rule.ThresholdValue {
+			ctx["queueIds"] = e.recentQueueIDs("deferred")
 			return true, "Deferred mail count exceeds threshold", ctx
 		}
 
@@ -210,6 +302,7 @@ func (e *Engine) evaluateRule(rule AlertRule, m Metrics) (bool, string, map[stri
 		ctx["bounceRate"] = m.BounceRate
 		ctx["threshold"] = rule.ThresholdValue
 		if m.BounceRate > rule.ThresholdValue {
+			ctx["queueIds"] = e.recentQueueIDs("bounced")
 			return true, "Bounce rate exceeds threshold", ctx
 		}
 
@@ -219,30 +312,470 @@ func (e *Engine) evaluateRule(rule AlertRule, m Metrics) (bool, string, map[stri
 		if m.ConnectionRate > rule.ThresholdValue {
 			return true, "Connection rate exceeds threshold", ctx
 		}
+
+	case "connection_flood":
+		window := time.Duration(rule.ThresholdDuration) * time.Second
+		if window <= 0 {
+			window = 5 * time.Minute
+		}
+		ip, count := e.busiestClient(window)
+		ctx["clientIp"] = ip
+		ctx["connections"] = count
+		ctx["windowSeconds"] = int(window.Seconds())
+		ctx["threshold"] = rule.ThresholdValue
+		if float64(count) > rule.ThresholdValue {
+			return true, fmt.Sprintf("Connection flood from %s", ip), ctx
+		}
+
+	case "login_failures":
+		window := time.Duration(rule.ThresholdDuration) * time.Second
+		if window <= 0 {
+			window = 15 * time.Minute
+		}
+		ip, failures, accounts := e.loginFailureSource(window)
+		ctx["clientIp"] = ip
+		ctx["failures"] = failures
+		ctx["accounts"] = accounts
+		ctx["windowSeconds"] = int(window.Seconds())
+		ctx["threshold"] = rule.ThresholdValue
+		if failures > 0 && float64(failures) > rule.ThresholdValue {
+			return true, fmt.Sprintf("%d failed sign-ins from %s", failures, ip), ctx
+		}
+
+	case "login_new_ip":
+		window := time.Duration(rule.ThresholdDuration) * time.Second
+		if window <= 0 {
+			window = time.Hour
+		}
+		days := int(rule.ThresholdValue)
+		if days <= 0 {
+			days = 30
+		}
+		username, ip := e.adminLoginFromNewIP(window, days)
+		ctx["username"] = username
+		ctx["clientIp"] = ip
+		ctx["lookbackDays"] = days
+		if username != "" {
+			return true, fmt.Sprintf("Admin %s signed in from %s, an address not seen for the account in %d days", username, ip, days), ctx
+		}
+
+	case "canary_failure":
+		failures := e.canaryConsecutiveFailures(int(rule.ThresholdValue))
+		ctx["consecutiveFailures"] = failures
+		ctx["threshold"] = rule.ThresholdValue
+		if failures > 0 && float64(failures) >= rule.ThresholdValue {
+			return true, "Synthetic delivery probes are failing", ctx
+		}
+
+	case "canary_latency":
+		latency, ok := e.canaryLatestLatency()
+		ctx["latencySeconds"] = latency
+		ctx["threshold"] = rule.ThresholdValue
+		if ok && latency > rule.ThresholdValue {
+			return true, "Synthetic delivery latency exceeds threshold", ctx
+		}
+
+	case "archive_failure":
+		window := time.Duration(rule.ThresholdDuration) * time.Second
+		if window <= 0 {
+			window = 15 * time.Minute
+		}
+		failures, last := e.archiveFailures(window)
+		ctx["failures"] = failures
+		ctx["lastError"] = last
+		ctx["windowSeconds"] = int(window.Seconds())
+		ctx["threshold"] = rule.ThresholdValue
+		if failures > 0 && float64(failures) > rule.ThresholdValue {
+			ctx["queueIds"] = e.archiveQueueIDs(window)
+			return true, fmt.Sprintf("%d archive deliveries failed", failures), ctx
+		}
+
+	case "config_auto_rollback":
+		window := time.Duration(rule.ThresholdDuration) * time.Second
+		if window <= 0 {
+			window = time.Hour
+		}
+		version, reason := e.recentAutoRollback(window)
+		ctx["version"] = version
+		ctx["reason"] = reason
+		if version > 0 {
+			return true, fmt.Sprintf("Config version %d was rolled back automatically: %s", version, reason), ctx
+		}
+
+	case "replication_lag":
+		email, lag := e.worstReplicationLag()
+		ctx["email"] = email
+		ctx["lagSeconds"] = lag
+		ctx["threshold"] = rule.ThresholdValue
+		if email != "" && float64(lag) > rule.ThresholdValue {
+			return true, fmt.Sprintf("Replication of %s is %s behind", email, time.Duration(lag)*time.Second), ctx
+		}
+
+	case "disk_usage", "inode_usage":
+		volume, used, ok := e.fullestVolume(rule.Type == "inode_usage")
+		ctx["volume"] = volume
+		ctx["usedPercent"] = used
+		ctx["threshold"] = rule.ThresholdValue
+		if ok && used > rule.ThresholdValue {
+			if rule.Type == "inode_usage" {
+				return true, fmt.Sprintf("Volume %s has used %.1f%% of its inodes", volume, used), ctx
+			}
+			return true, fmt.Sprintf("Volume %s is %.1f%% full", volume, used), ctx
+		}
+
+	case "memory_usage":
+		used, ok := e.latestHostValue("CASE WHEN memory_total > 0 THEN 100.0 * (memory_total - memory_available) / memory_total ELSE 0 END")
+		ctx["usedPercent"] = used
+		ctx["threshold"] = rule.ThresholdValue
+		if ok && used > rule.ThresholdValue {
+			return true, fmt.Sprintf("Memory usage is %.1f%%", used), ctx
+		}
+
+	case "cpu_usage":
+		window := time.Duration(rule.ThresholdDuration) * time.Second
+		if window <= 0 {
+			window = 10 * time.Minute
+		}
+		avg, ok := e.averageCPU(window)
+		ctx["cpuPercent"] = avg
+		ctx["windowSeconds"] = int(window.Seconds())
+		ctx["threshold"] = rule.ThresholdValue
+		if ok && avg > rule.ThresholdValue {
+			return true, fmt.Sprintf("CPU usage averaged %.1f%% over %s", avg, window), ctx
+		}
+
+	case "load_average":
+		load, ok := e.latestHostValue("load5 / MAX(cpus, 1)")
+		ctx["loadPerCpu"] = load
+		ctx["threshold"] = rule.ThresholdValue
+		if ok && load > rule.ThresholdValue {
+			return true, fmt.Sprintf("Load average is %.2f per CPU", load), ctx
+		}
+
+	case "clock_skew":
+		offset, ok := e.clockOffset()
+		ctx["offsetSeconds"] = offset
+		ctx["threshold"] = rule.ThresholdValue
+		if ok && math.Abs(offset) > rule.ThresholdValue {
+			return true, fmt.Sprintf("Host clock is off by %.1f seconds", offset), ctx
+		}
+
+	case "dns_failure":
+		failing := e.failingResolvers()
+		ctx["resolvers"] = failing
+		ctx["threshold"] = rule.ThresholdValue
+		if len(failing) > 0 && float64(len(failing)) > rule.ThresholdValue {
+			return true, fmt.Sprintf("DNS resolvers failing: %s", strings.Join(failing, ", ")), ctx
+		}
+
+	case "dns_latency":
+		resolver, latency := e.slowestResolver()
+		ctx["resolver"] = resolver
+		ctx["latencyMs"] = latency
+		ctx["threshold"] = rule.ThresholdValue
+		if resolver != "" && latency > rule.ThresholdValue {
+			return true, fmt.Sprintf("DNS resolver %s took %.0f ms", resolver, latency), ctx
+		}
+
+	case "background_failure":
+		task, failures, lastErr := e.backgroundFailures()
+		ctx["task"] = task
+		ctx["consecutiveFailures"] = failures
+		ctx["lastError"] = lastErr
+		ctx["threshold"] = rule.ThresholdValue
+		if failures > 0 && float64(failures) >= rule.ThresholdValue {
+			return true, fmt.Sprintf("Background task %s has failed %d times in a row: %s", task, failures, lastErr), ctx
+		}
+
+	case "saved_search":
+		names, worst := e.savedSearchesOverThreshold()
+		ctx["searches"] = names
+		ctx["resultCount"] = worst
+		if len(names) > 0 {
+			return true, fmt.Sprintf("Saved search results over threshold: %s", strings.Join(names, ", ")), ctx
+		}
 	}
 
 	return false, "", ctx
 }
 
+// busiestClient returns the client IP with the most smtpd connections in
+// the window
+func (e *Engine) busiestClient(window time.Duration) (string, int) {
+	var ip string
+	var count int
+	e.db.QueryRow(`
+		SELECT client_ip, SUM(connections) AS n FROM smtpd_client_stats
+		WHERE bucket >= ? GROUP BY client_ip ORDER BY n DESC LIMIT 1
+	`, time.Now().UTC().Add(-window).Format(time.RFC3339)).Scan(&ip, &count)
+	return ip, count
+}
+
+// loginFailureSource returns the client IP with the most failed admin and
+// webmail sign-ins in the window, how many, and the accounts it tried
+func (e *Engine) loginFailureSource(window time.Duration) (string, int, []string) {
+	since := time.Now().UTC().Add(-window).Format(time.RFC3339)
+	var ip string
+	var count int
+	err := e.db.QueryRow(`
+		SELECT ip_address, COUNT(*) AS n FROM login_history
+		WHERE success = FALSE AND occurred_at >= ? AND ip_address IS NOT NULL
+		GROUP BY ip_address ORDER BY n DESC LIMIT 1
+	`, since).Scan(&ip, &count)
+	if err != nil {
+		return "", 0, nil
+	}
+
+	accounts := []string{}
+	rows, err := e.db.Query(`
+		SELECT DISTINCT username FROM login_history
+		WHERE success = FALSE AND occurred_at >= ? AND ip_address = ? ORDER BY username LIMIT 10
+	`, since, ip)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var username string
+			if rows.Scan(&username) == nil {
+				accounts = append(accounts, username)
+			}
+		}
+	}
+	return ip, count, accounts
+}
+
+// adminLoginFromNewIP returns the most recent admin sign-in in the window
+// from an address the account hadn't signed in from in the days before.
+// Accounts with no earlier sign-in in that period are skipped, so a first
+// login doesn't count.
+func (e *Engine) adminLoginFromNewIP(window time.Duration, days int) (string, string) {
+	now := time.Now().UTC()
+	var username, ip string
+	e.db.QueryRow(`
+		SELECT h.username, h.ip_address FROM login_history h
+		WHERE h.kind = 'admin' AND h.success = TRUE AND h.occurred_at >= ?1
+		AND EXISTS (SELECT 1 FROM login_history p WHERE p.kind = 'admin' AND p.success = TRUE
+			AND p.username = h.username AND p.occurred_at < h.occurred_at AND p.occurred_at >= ?2)
+		AND NOT EXISTS (SELECT 1 FROM login_history p WHERE p.kind = 'admin' AND p.success = TRUE
+			AND p.username = h.username AND p.ip_address = h.ip_address
+			AND p.occurred_at < h.occurred_at AND p.occurred_at >= ?2)
+		ORDER BY h.occurred_at DESC, h.id DESC LIMIT 1
+	`, now.Add(-window).Format(time.RFC3339), now.AddDate(0, 0, -days).Format(time.RFC3339)).Scan(&username, &ip)
+	return username, ip
+}
+
+// canaryConsecutiveFailures counts failed probes among the most recent
+// finished ones, stopping at the first success (looks at up to n probes)
+func (e *Engine) canaryConsecutiveFailures(n int) int {
+	if n < 1 {
+		n = 1
+	}
+	rows, err := e.db.Query(`
+		SELECT status FROM canary_probes WHERE status != 'pending' ORDER BY id DESC LIMIT ?
+	`, n)
+	if err != nil {
+		return 0
+	}
+	defer rows.Close()
+
+	failures := 0
+	for rows.Next() {
+		var status string
+		if rows.Scan(&status) != nil || status != "failed" {
+			break
+		}
+		failures++
+	}
+	return failures
+}
+
+// canaryLatestLatency returns the delivery time of the most recent
+// delivered probe, in seconds
+func (e *Engine) canaryLatestLatency() (float64, bool) {
+	var ms int64
+	err := e.db.QueryRow(`
+		SELECT latency_ms FROM canary_probes WHERE status = 'delivered' ORDER BY id DESC LIMIT 1
+	`).Scan(&ms)
+	if err != nil {
+		return 0, false
+	}
+	return float64(ms) / 1000, true
+}
+
+// archiveFailures returns the number of failed archive deliveries in the
+// window and the most recent failure's detail
+func (e *Engine) archiveFailures(window time.Duration) (int, string) {
+	var count int
+	var last string
+	e.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(MAX(CASE WHEN id = (SELECT MAX(id) FROM archive_events) THEN detail END), '')
+		FROM archive_events WHERE occurred_at >= ?
+	`, time.Now().UTC().Add(-window).Format(time.RFC3339)).Scan(&count, &last)
+	return count, last
+}
+
+// savedSearchesOverThreshold returns the scheduled searches whose last run
+// returned more rows than their alert threshold, and the largest count
+func (e *Engine) savedSearchesOverThreshold() ([]string, int) {
+	rows, err := e.db.Query(`
+		SELECT name, last_result_count FROM saved_searches
+		WHERE schedule_minutes > 0 AND alert_threshold IS NOT NULL AND last_result_count > alert_threshold
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, 0
+	}
+	defer rows.Close()
+
+	names := []string{}
+	worst := 0
+	for rows.Next() {
+		var name string
+		var count int
+		if rows.Scan(&name, &count) != nil {
+			continue
+		}
+		names = append(names, name)
+		if count > worst {
+			worst = count
+		}
+	}
+	return names, worst
+}
+
+// recentAutoRollback returns the latest config version rolled back by a
+// post-apply bake within the window, and why
+func (e *Engine) recentAutoRollback(window time.Duration) (int64, string) {
+	var version int64
+	var reason string
+	e.db.QueryRow(`
+		SELECT version_number, COALESCE(auto_rollback_reason, '') FROM config_versions
+		WHERE auto_rollback_at >= ? ORDER BY auto_rollback_at DESC LIMIT 1
+	`, time.Now().UTC().Add(-window).Format(time.RFC3339)).Scan(&version, &reason)
+	return version, reason
+}
+
+// worstReplicationLag returns the replicated mailbox that synced least
+// recently and how many seconds ago that was. Mailboxes that never synced
+// count from when the replicator first listed them.
+func (e *Engine) worstReplicationLag() (string, int64) {
+	var email, last string
+	err := e.db.QueryRow(`
+		SELECT email, COALESCE(last_success_sync, first_seen) FROM replication_status
+		ORDER BY COALESCE(last_success_sync, first_seen) LIMIT 1
+	`).Scan(&email, &last)
+	if err != nil {
+		return "", 0
+	}
+	t, err := time.Parse(time.RFC3339, last)
+	if err != nil {
+		return "", 0
+	}
+	return email, int64(time.Since(t).Seconds())
+}
+
+// hostSampleMaxAge is how old the latest host sample may be before host
+// rules stop evaluating it, so a stopped sampler doesn't keep an alert firing
+const hostSampleMaxAge = 5 * time.Minute
+
+// latestHostValue evaluates an expression over the most recent host sample
+func (e *Engine) latestHostValue(expr string) (float64, bool) {
+	var value float64
+	err := e.db.QueryRow(`SELECT `+expr+` FROM host_samples WHERE sampled_at >= ? ORDER BY sampled_at DESC LIMIT 1`,
+		time.Now().UTC().Add(-hostSampleMaxAge).Format(time.RFC3339)).Scan(&value)
+	return value, err == nil
+}
+
+// averageCPU returns the average CPU usage over the window
+func (e *Engine) averageCPU(window time.Duration) (float64, bool) {
+	var avg sql.NullFloat64
+	e.db.QueryRow(`SELECT AVG(cpu_percent) FROM host_samples WHERE sampled_at >= ?`,
+		time.Now().UTC().Add(-window).Format(time.RFC3339)).Scan(&avg)
+	return avg.Float64, avg.Valid
+}
+
+// fullestVolume returns the watched volume with the highest disk, or with
+// inodes set inode, usage in the most recent sample
+func (e *Engine) fullestVolume(inodes bool) (string, float64, bool) {
+	column := "used_percent"
+	if inodes {
+		column = "inodes_used_percent"
+	}
+	var volume string
+	var used float64
+	err := e.db.QueryRow(`
+		SELECT volume, `+column+` FROM host_disk_samples
+		WHERE sampled_at = (SELECT MAX(sampled_at) FROM host_disk_samples) AND sampled_at >= ?
+		ORDER BY `+column+` DESC LIMIT 1
+	`, time.Now().UTC().Add(-hostSampleMaxAge).Format(time.RFC3339)).Scan(&volume, &used)
+	return volume, used, err == nil
+}
+
+// hostCheckMaxAge is how old a clock or DNS check may be before rules
+// ignore it
+const hostCheckMaxAge = 30 * time.Minute
+
+// clockOffset returns the latest successful clock check's offset
+func (e *Engine) clockOffset() (float64, bool) {
+	var offset float64
+	err := e.db.QueryRow(`SELECT value FROM host_checks WHERE name = 'clock' AND ok = TRUE AND checked_at >= ?`,
+		time.Now().UTC().Add(-hostCheckMaxAge).Format(time.RFC3339)).Scan(&offset)
+	return offset, err == nil
+}
+
+// failingResolvers returns the nameservers whose latest check failed
+func (e *Engine) failingResolvers() []string {
+	rows, err := e.db.Query(`SELECT name FROM host_checks WHERE name LIKE 'dns:%' AND ok = FALSE AND checked_at >= ? ORDER BY name`,
+		time.Now().UTC().Add(-hostCheckMaxAge).Format(time.RFC3339))
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var failing []string
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil {
+			failing = append(failing, strings.TrimPrefix(name, "dns:"))
+		}
+	}
+	return failing
+}
+
+// slowestResolver returns the nameserver with the slowest successful
+// lookup in its latest check
+func (e *Engine) slowestResolver() (string, float64) {
+	var name string
+	var latency float64
+	e.db.QueryRow(`
+		SELECT name, value FROM host_checks WHERE name LIKE 'dns:%' AND ok = TRUE AND checked_at >= ?
+		ORDER BY value DESC LIMIT 1
+	`, time.Now().UTC().Add(-hostCheckMaxAge).Format(time.RFC3339)).Scan(&name, &latency)
+	return strings.TrimPrefix(name, "dns:"), latency
+}
+
 // fireAlert creates or updates an alert
 func (e *Engine) fireAlert(rule AlertRule, message string, context map[string]interface{}) {
 	// Check if alert already exists and is firing
 	var existingID int64
 	err := e.db.QueryRow(`
-		SELECT id FROM alerts WHERE rule_id = ? AND status = 'firing'
+		SELECT id FROM alerts WHERE rule_id = ? AND status IN ('firing', 'acknowledged', 'silenced')
 	`, rule.ID).Scan(&existingID)
 
 	if err == nil {
-		// Alert already firing, don't create duplicate
+		// Alert already active, don't create duplicate
 		return
 	}
 
-	// Create new alert
+	// Create new alert, keeping the context for the messages it refers to
 	now := time.Now().UTC()
+	contextJSON, err := json.Marshal(context)
+	if err != nil {
+		contextJSON = []byte("{}")
+	}
 	result, err := e.db.Exec(`
 		INSERT INTO alerts (rule_id, status, severity, triggered_at, message, context)
 		VALUES (?, 'firing', ?, ?, ?, ?)
-	`, rule.ID, rule.Severity, now.Format(time.RFC3339), message, "{}")
+	`, rule.ID, rule.Severity, now.Format(time.RFC3339), message, string(contextJSON))
 	if err != nil {
 		log.Error().Err(err).Str("rule", rule.Name).Msg("Failed to create alert")
 		return
@@ -256,6 +789,8 @@ func (e *Engine) fireAlert(rule AlertRule, message string, context map[string]in
 		Str("message", message).
 		Msg("Alert fired")
 
+	incidentID, opened := e.attachIncident(alertID, rule, message, now)
+
 	// Send notifications
 	alert := Alert{
 		ID:          alertID,
@@ -266,24 +801,74 @@ func (e *Engine) fireAlert(rule AlertRule, message string, context map[string]in
 		TriggeredAt: now,
 		Message:     message,
 		Context:     context,
+		RunbookURL:  e.runbookLink(rule),
+		IncidentID:  incidentID,
+		References:  ReferencesFromContext(context),
+
+		opensIncident: opened,
+		rule:          rule,
+	}
+	if !e.runHook(&alert) {
+		log.Info().Int64("alertId", alertID).Str("rule", rule.Name).Msg("Alert notifications dropped by hook")
+		return
 	}
 	e.notifier.Notify(alert)
 }
 
-// resolveAlert marks an alert as resolved
+// runbookLink returns the runbook notifications should point to: the
+// rule's external runbook if it has one, otherwise the rule's runbook in
+// this installation when public_url is configured
+func (e *Engine) runbookLink(rule AlertRule) string {
+	if rule.RunbookURL != "" {
+		return rule.RunbookURL
+	}
+
+	var base string
+	e.db.QueryRow(`SELECT value FROM settings WHERE key = 'public_url'`).Scan(&base)
+	if base == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/api/v1/alerts/rules/%d/runbook?format=markdown", strings.TrimRight(base, "/"), rule.ID)
+}
+
+// resolveAlert marks a rule's active alerts as resolved
 func (e *Engine) resolveAlert(rule AlertRule) {
+	rows, err := e.db.Query(`
+		SELECT id, COALESCE(incident_id, 0) FROM alerts
+		WHERE rule_id = ? AND status IN ('firing', 'acknowledged', 'silenced')
+	`, rule.ID)
+	if err != nil {
+		return
+	}
+	resolved := make(map[int64]int64) // alert ID -> incident ID
+	for rows.Next() {
+		var alertID, incidentID int64
+		if err := rows.Scan(&alertID, &incidentID); err == nil {
+			resolved[alertID] = incidentID
+		}
+	}
+	rows.Close()
+	if len(resolved) == 0 {
+		return
+	}
+
 	now := time.Now().UTC()
-	result, err := e.db.Exec(`
+	_, err = e.db.Exec(`
 		UPDATE alerts SET status = 'resolved', resolved_at = ?
-		WHERE rule_id = ? AND status = 'firing'
+		WHERE rule_id = ? AND status IN ('firing', 'acknowledged', 'silenced')
 	`, now.Format(time.RFC3339), rule.ID)
 	if err != nil {
 		return
 	}
+	log.Info().Str("rule", rule.Name).Msg("Alert resolved")
 
-	affected, _ := result.RowsAffected()
-	if affected > 0 {
-		log.Info().Str("rule", rule.Name).Msg("Alert resolved")
+	for alertID, incidentID := range resolved {
+		if incidentID == 0 {
+			continue
+		}
+		id := alertID
+		e.addIncidentEvent(incidentID, now, "alert_resolved", &id, "", rule.Name+" resolved")
+		e.resolveIncidentIfClear(incidentID, now)
 	}
 }
 
@@ -291,7 +876,7 @@ func (e *Engine) resolveAlert(rule AlertRule) {
 func (e *Engine) GetActiveAlerts() ([]Alert, error) {
 	rows, err := e.db.Query(`
 		SELECT a.id, a.rule_id, r.name, a.status, a.severity, a.triggered_at,
-		       a.acknowledged_at, a.acknowledged_by, a.resolved_at, a.message
+		       a.acknowledged_at, a.acknowledged_by, a.resolved_at, a.message, COALESCE(a.context, '{}')
 		FROM alerts a
 		JOIN alert_rules r ON a.rule_id = r.id
 		WHERE a.status IN ('firing', 'acknowledged')
@@ -307,10 +892,12 @@ func (e *Engine) GetActiveAlerts() ([]Alert, error) {
 		var a Alert
 		var triggeredAt, ackAt, resolvedAt sql.NullString
 		var ackBy sql.NullString
+		var context string
 
-		if err := rows.Scan(&a.ID, &a.RuleID, &a.RuleName, &a.Status, &a.Severity, &triggeredAt, &ackAt, &ackBy, &resolvedAt, &a.Message); err != nil {
+		if err := rows.Scan(&a.ID, &a.RuleID, &a.RuleName, &a.Status, &a.Severity, &triggeredAt, &ackAt, &ackBy, &resolvedAt, &a.Message, &context); err != nil {
 			continue
 		}
+		a.setContext(context)
 
 		if triggeredAt.Valid {
 			t, _ := time.Parse(time.RFC3339, triggeredAt.String)
@@ -338,7 +925,7 @@ func (e *Engine) GetActiveAlerts() ([]Alert, error) {
 func (e *Engine) GetAllAlerts(limit int) ([]Alert, error) {
 	rows, err := e.db.Query(`
 		SELECT a.id, a.rule_id, r.name, a.status, a.severity, a.triggered_at,
-		       a.acknowledged_at, a.acknowledged_by, a.resolved_at, a.message
+		       a.acknowledged_at, a.acknowledged_by, a.resolved_at, a.message, COALESCE(a.context, '{}')
 		FROM alerts a
 		JOIN alert_rules r ON a.rule_id = r.id
 		ORDER BY a.triggered_at DESC
@@ -354,10 +941,12 @@ func (e *Engine) GetAllAlerts(limit int) ([]Alert, error) {
 		var a Alert
 		var triggeredAt, ackAt, resolvedAt sql.NullString
 		var ackBy sql.NullString
+		var context string
 
-		if err := rows.Scan(&a.ID, &a.RuleID, &a.RuleName, &a.Status, &a.Severity, &triggeredAt, &ackAt, &ackBy, &resolvedAt, &a.Message); err != nil {
+		if err := rows.Scan(&a.ID, &a.RuleID, &a.RuleName, &a.Status, &a.Severity, &triggeredAt, &ackAt, &ackBy, &resolvedAt, &a.Message, &context); err != nil {
 			continue
 		}
+		a.setContext(context)
 
 		if triggeredAt.Valid {
 			t, _ := time.Parse(time.RFC3339, triggeredAt.String)
@@ -381,14 +970,34 @@ func (e *Engine) GetAllAlerts(limit int) ([]Alert, error) {
 	return alerts, nil
 }
 
-// AcknowledgeAlert marks an alert as acknowledged
+// AcknowledgeAlert marks an alert as acknowledged and records it on the
+// alert's incident timeline
 func (e *Engine) AcknowledgeAlert(alertID int64, username string, note string) error {
 	now := time.Now().UTC()
-	_, err := e.db.Exec(`
-		UPDATE alerts SET status = 'acknowledged', acknowledged_at = ?, acknowledged_by = ?
+	result, err := e.db.Exec(`
+		UPDATE alerts SET status = 'acknowledged', acknowledged_at = ?, acknowledged_by = ?, notes = ?
 		WHERE id = ? AND status = 'firing'
-	`, now.Format(time.RFC3339), username, alertID)
-	return err
+	`, now.Format(time.RFC3339), username, note, alertID)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n > 0 {
+		var incidentID int64
+		var ruleName string
+		e.db.QueryRow(`
+			SELECT COALESCE(a.incident_id, 0), r.name FROM alerts a JOIN alert_rules r ON a.rule_id = r.id
+			WHERE a.id = ?
+		`, alertID).Scan(&incidentID, &ruleName)
+		if incidentID != 0 {
+			msg := ruleName + " acknowledged"
+			if note != "" {
+				msg += ": " + note
+			}
+			e.addIncidentEvent(incidentID, now, "alert_acknowledged", &alertID, username, msg)
+		}
+	}
+	return nil
 }
 
 // SilenceAlert silences an alert for a duration
@@ -401,22 +1010,51 @@ func (e *Engine) SilenceAlert(alertID int64, durationMinutes int) error {
 	return err
 }
 
+// ResolveAlert manually resolves an active alert. The rule fires again on
+// its next evaluation if its condition still holds.
+func (e *Engine) ResolveAlert(alertID int64, username string) error {
+	now := time.Now().UTC()
+	result, err := e.db.Exec(`
+		UPDATE alerts SET status = 'resolved', resolved_at = ?
+		WHERE id = ? AND status IN ('firing', 'acknowledged', 'silenced')
+	`, now.Format(time.RFC3339), alertID)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n > 0 {
+		var incidentID int64
+		var ruleName string
+		e.db.QueryRow(`
+			SELECT COALESCE(a.incident_id, 0), r.name FROM alerts a JOIN alert_rules r ON a.rule_id = r.id
+			WHERE a.id = ?
+		`, alertID).Scan(&incidentID, &ruleName)
+		if incidentID != 0 {
+			e.addIncidentEvent(incidentID, now, "alert_resolved", &alertID, username, ruleName+" resolved by "+username)
+			e.resolveIncidentIfClear(incidentID, now)
+		}
+	}
+	return nil
+}
+
 // GetAlert returns a single alert by ID
 func (e *Engine) GetAlert(alertID int64) (*Alert, error) {
 	var a Alert
 	var triggeredAt, ackAt, resolvedAt sql.NullString
 	var ackBy sql.NullString
+	var context string
 
 	err := e.db.QueryRow(`
 		SELECT a.id, a.rule_id, r.name, a.status, a.severity, a.triggered_at,
-		       a.acknowledged_at, a.acknowledged_by, a.resolved_at, a.message
+		       a.acknowledged_at, a.acknowledged_by, a.resolved_at, a.message, COALESCE(a.context, '{}')
 		FROM alerts a
 		JOIN alert_rules r ON a.rule_id = r.id
 		WHERE a.id = ?
-	`, alertID).Scan(&a.ID, &a.RuleID, &a.RuleName, &a.Status, &a.Severity, &triggeredAt, &ackAt, &ackBy, &resolvedAt, &a.Message)
+	`, alertID).Scan(&a.ID, &a.RuleID, &a.RuleName, &a.Status, &a.Severity, &triggeredAt, &ackAt, &ackBy, &resolvedAt, &a.Message, &context)
 	if err != nil {
 		return nil, err
 	}
+	a.setContext(context)
 
 	if triggeredAt.Valid {
 		t, _ := time.Parse(time.RFC3339, triggeredAt.String)
@@ -0,0 +1,25 @@
+package alerts
+
+// AlertHook sees each alert after it fires and before its notifications
+// go out. It may change the alert, and returns false to drop the
+// notifications; the alert itself is kept either way.
+type AlertHook func(a *Alert) bool
+
+// SetAlertHook sets the hook fired alerts pass through
+func (e *Engine) SetAlertHook(hook AlertHook) {
+	e.mu.Lock()
+	e.hook = hook
+	e.mu.Unlock()
+}
+
+// runHook passes an alert through the hook, if any, and reports whether
+// to notify
+func (e *Engine) runHook(a *Alert) bool {
+	e.mu.RLock()
+	hook := e.hook
+	e.mu.RUnlock()
+	if hook == nil {
+		return true
+	}
+	return hook(a)
+}
@@ -0,0 +1,386 @@
+package alerts
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// IncidentStatus represents the status of an incident
+type IncidentStatus string
+
+const (
+	IncidentOpen         IncidentStatus = "open"
+	IncidentAcknowledged IncidentStatus = "acknowledged"
+	IncidentResolved     IncidentStatus = "resolved"
+)
+
+var (
+	// ErrIncidentResolved is returned when acknowledging a resolved incident
+	ErrIncidentResolved = errors.New("incident is already resolved")
+	// ErrIncidentUnresolved is returned when summarizing an open incident
+	ErrIncidentUnresolved = errors.New("incident is not resolved yet")
+)
+
+// Incident groups alerts that fired close together. A new alert joins the
+// most recent unresolved incident if that incident has seen activity within
+// incident_window_minutes (0 disables grouping); otherwise it opens a new
+// one. An incident resolves once all of its alerts have.
+type Incident struct {
+	ID             int64          `json:"id"`
+	Title          string         `json:"title"`
+	Status         IncidentStatus `json:"status"`
+	Severity       AlertSeverity  `json:"severity"`
+	OpenedAt       time.Time      `json:"openedAt"`
+	AcknowledgedAt *time.Time     `json:"acknowledgedAt,omitempty"`
+	AcknowledgedBy *string        `json:"acknowledgedBy,omitempty"`
+	ResolvedAt     *time.Time     `json:"resolvedAt,omitempty"`
+	AlertCount     int            `json:"alertCount"`
+}
+
+// IncidentEvent is an entry on an incident's timeline
+type IncidentEvent struct {
+	ID        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"` // alert_fired, alert_acknowledged, alert_resolved, acknowledged, note, resolved
+	AlertID   *int64    `json:"alertId,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	Message   string    `json:"message"`
+}
+
+// IncidentSummary is the post-resolution report for an incident
+type IncidentSummary struct {
+	Incident                 Incident        `json:"incident"`
+	DurationSeconds          int64           `json:"durationSeconds"`
+	TimeToAcknowledgeSeconds *int64          `json:"timeToAcknowledgeSeconds,omitempty"`
+	Rules                    []string        `json:"rules"`
+	Alerts                   []Alert         `json:"alerts"`
+	Timeline                 []IncidentEvent `json:"timeline"`
+}
+
+// attachIncident adds a newly fired alert to the current incident, or
+// opens a new one. It returns the incident ID (0 if grouping is disabled)
+// and whether the incident was opened by this alert.
+func (e *Engine) attachIncident(alertID int64, rule AlertRule, message string, now time.Time) (int64, bool) {
+	window := e.settingInt("incident_window_minutes", 15)
+	if window <= 0 {
+		return 0, false
+	}
+
+	var incidentID int64
+	var severity AlertSeverity
+	err := e.db.QueryRow(`
+		SELECT id, severity FROM incidents
+		WHERE status != 'resolved' AND last_activity_at >= ?
+		ORDER BY last_activity_at DESC LIMIT 1
+	`, now.Add(-time.Duration(window)*time.Minute).Format(time.RFC3339)).Scan(&incidentID, &severity)
+
+	opened := false
+	if err == sql.ErrNoRows {
+		result, err := e.db.Exec(`
+			INSERT INTO incidents (title, status, severity, opened_at, last_activity_at)
+			VALUES (?, 'open', ?, ?, ?)
+		`, rule.Name, rule.Severity, now.Format(time.RFC3339), now.Format(time.RFC3339))
+		if err != nil {
+			log.Error().Err(err).Str("rule", rule.Name).Msg("Failed to open incident")
+			return 0, false
+		}
+		incidentID, _ = result.LastInsertId()
+		opened = true
+		log.Warn().Int64("incidentId", incidentID).Str("rule", rule.Name).Msg("Incident opened")
+	} else if err != nil {
+		log.Error().Err(err).Msg("Failed to look up open incident")
+		return 0, false
+	} else if rule.Severity == SeverityCritical && severity != SeverityCritical {
+		e.db.Exec(`UPDATE incidents SET severity = 'critical' WHERE id = ?`, incidentID)
+	}
+
+	e.db.Exec(`UPDATE alerts SET incident_id = ? WHERE id = ?`, incidentID, alertID)
+	e.addIncidentEvent(incidentID, now, "alert_fired", &alertID, "", rule.Name+": "+message)
+	return incidentID, opened
+}
+
+// addIncidentEvent appends to an incident's timeline and bumps its last
+// activity time
+func (e *Engine) addIncidentEvent(incidentID int64, at time.Time, eventType string, alertID *int64, username, message string) {
+	_, err := e.db.Exec(`
+		INSERT INTO incident_events (incident_id, timestamp, type, alert_id, username, message)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, incidentID, at.Format(time.RFC3339), eventType, alertID, username, message)
+	if err != nil {
+		log.Error().Err(err).Int64("incidentId", incidentID).Msg("Failed to record incident event")
+		return
+	}
+	e.db.Exec(`UPDATE incidents SET last_activity_at = ? WHERE id = ?`, at.Format(time.RFC3339), incidentID)
+}
+
+// resolveIncidentIfClear resolves an incident once none of its alerts are
+// still active, and sends the resolution into the incident's thread
+func (e *Engine) resolveIncidentIfClear(incidentID int64, now time.Time) {
+	var active int
+	e.db.QueryRow(`
+		SELECT COUNT(*) FROM alerts
+		WHERE incident_id = ? AND status IN ('firing', 'acknowledged', 'silenced')
+	`, incidentID).Scan(&active)
+	if active > 0 {
+		return
+	}
+
+	result, err := e.db.Exec(`
+		UPDATE incidents SET status = 'resolved', resolved_at = ?
+		WHERE id = ? AND status != 'resolved'
+	`, now.Format(time.RFC3339), incidentID)
+	if err != nil {
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return
+	}
+	e.addIncidentEvent(incidentID, now, "resolved", nil, "", "All alerts resolved")
+	log.Info().Int64("incidentId", incidentID).Msg("Incident resolved")
+
+	if incident, err := e.GetIncident(incidentID); err == nil {
+		e.notifier.NotifyIncidentResolved(*incident)
+	}
+}
+
+// AcknowledgeIncident acknowledges an incident and all of its firing alerts
+func (e *Engine) AcknowledgeIncident(incidentID int64, username, note string) error {
+	incident, err := e.GetIncident(incidentID)
+	if err != nil {
+		return err
+	}
+	if incident.Status == IncidentResolved {
+		return ErrIncidentResolved
+	}
+
+	now := time.Now().UTC()
+	if incident.Status == IncidentOpen {
+		if _, err := e.db.Exec(`
+			UPDATE incidents SET status = 'acknowledged', acknowledged_at = ?, acknowledged_by = ?
+			WHERE id = ?
+		`, now.Format(time.RFC3339), username, incidentID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := e.db.Exec(`
+		UPDATE alerts SET status = 'acknowledged', acknowledged_at = ?, acknowledged_by = ?, notes = ?
+		WHERE incident_id = ? AND status = 'firing'
+	`, now.Format(time.RFC3339), username, note, incidentID); err != nil {
+		return err
+	}
+
+	msg := "Incident acknowledged"
+	if note != "" {
+		msg += ": " + note
+	}
+	e.addIncidentEvent(incidentID, now, "acknowledged", nil, username, msg)
+	return nil
+}
+
+// AddIncidentNote adds a note to an incident's timeline
+func (e *Engine) AddIncidentNote(incidentID int64, username, note string) error {
+	if _, err := e.GetIncident(incidentID); err != nil {
+		return err
+	}
+	e.addIncidentEvent(incidentID, time.Now().UTC(), "note", nil, username, note)
+	return nil
+}
+
+const incidentColumns = `
+	i.id, i.title, i.status, i.severity, i.opened_at, i.acknowledged_at, i.acknowledged_by, i.resolved_at,
+	(SELECT COUNT(*) FROM alerts a WHERE a.incident_id = i.id)`
+
+func scanIncident(scan func(dest ...interface{}) error) (*Incident, error) {
+	var inc Incident
+	var openedAt string
+	var ackAt, ackBy, resolvedAt sql.NullString
+	if err := scan(&inc.ID, &inc.Title, &inc.Status, &inc.Severity, &openedAt, &ackAt, &ackBy, &resolvedAt, &inc.AlertCount); err != nil {
+		return nil, err
+	}
+
+	inc.OpenedAt, _ = time.Parse(time.RFC3339, openedAt)
+	if ackAt.Valid {
+		t, _ := time.Parse(time.RFC3339, ackAt.String)
+		inc.AcknowledgedAt = &t
+	}
+	if ackBy.Valid {
+		inc.AcknowledgedBy = &ackBy.String
+	}
+	if resolvedAt.Valid {
+		t, _ := time.Parse(time.RFC3339, resolvedAt.String)
+		inc.ResolvedAt = &t
+	}
+	return &inc, nil
+}
+
+// GetIncident returns a single incident by ID
+func (e *Engine) GetIncident(incidentID int64) (*Incident, error) {
+	row := e.db.QueryRow(`SELECT `+incidentColumns+` FROM incidents i WHERE i.id = ?`, incidentID)
+	return scanIncident(row.Scan)
+}
+
+// GetIncidents returns the most recent incidents, optionally filtered by
+// status
+func (e *Engine) GetIncidents(status string, limit int) ([]Incident, error) {
+	query := `SELECT ` + incidentColumns + ` FROM incidents i`
+	args := []interface{}{}
+	if status != "" {
+		query += ` WHERE i.status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY i.opened_at DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := e.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	incidents := []Incident{}
+	for rows.Next() {
+		inc, err := scanIncident(rows.Scan)
+		if err != nil {
+			continue
+		}
+		incidents = append(incidents, *inc)
+	}
+	return incidents, nil
+}
+
+// GetIncidentAlerts returns the alerts grouped into an incident, oldest
+// first
+func (e *Engine) GetIncidentAlerts(incidentID int64) ([]Alert, error) {
+	rows, err := e.db.Query(`
+		SELECT a.id, a.rule_id, r.name, a.status, a.severity, a.triggered_at,
+		       a.acknowledged_at, a.acknowledged_by, a.resolved_at, a.message, COALESCE(a.context, '{}')
+		FROM alerts a
+		JOIN alert_rules r ON a.rule_id = r.id
+		WHERE a.incident_id = ?
+		ORDER BY a.triggered_at
+	`, incidentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		var a Alert
+		var triggeredAt, ackAt, resolvedAt sql.NullString
+		var ackBy sql.NullString
+		var context string
+
+		if err := rows.Scan(&a.ID, &a.RuleID, &a.RuleName, &a.Status, &a.Severity, &triggeredAt, &ackAt, &ackBy, &resolvedAt, &a.Message, &context); err != nil {
+			continue
+		}
+		a.setContext(context)
+
+		a.IncidentID = incidentID
+		if triggeredAt.Valid {
+			t, _ := time.Parse(time.RFC3339, triggeredAt.String)
+			a.TriggeredAt = t
+		}
+		if ackAt.Valid {
+			t, _ := time.Parse(time.RFC3339, ackAt.String)
+			a.AcknowledgedAt = &t
+		}
+		if ackBy.Valid {
+			a.AcknowledgedBy = &ackBy.String
+		}
+		if resolvedAt.Valid {
+			t, _ := time.Parse(time.RFC3339, resolvedAt.String)
+			a.ResolvedAt = &t
+		}
+
+		alerts = append(alerts, a)
+	}
+	return alerts, nil
+}
+
+// GetIncidentTimeline returns an incident's events in order
+func (e *Engine) GetIncidentTimeline(incidentID int64) ([]IncidentEvent, error) {
+	rows, err := e.db.Query(`
+		SELECT id, timestamp, type, alert_id, COALESCE(username, ''), COALESCE(message, '')
+		FROM incident_events WHERE incident_id = ?
+		ORDER BY timestamp, id
+	`, incidentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []IncidentEvent{}
+	for rows.Next() {
+		var ev IncidentEvent
+		var timestamp string
+		var alertID sql.NullInt64
+		if err := rows.Scan(&ev.ID, &timestamp, &ev.Type, &alertID, &ev.Username, &ev.Message); err != nil {
+			continue
+		}
+		ev.Timestamp, _ = time.Parse(time.RFC3339, timestamp)
+		if alertID.Valid {
+			ev.AlertID = &alertID.Int64
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// GetIncidentSummary builds the post-resolution summary of an incident
+func (e *Engine) GetIncidentSummary(incidentID int64) (*IncidentSummary, error) {
+	incident, err := e.GetIncident(incidentID)
+	if err != nil {
+		return nil, err
+	}
+	if incident.Status != IncidentResolved || incident.ResolvedAt == nil {
+		return nil, ErrIncidentUnresolved
+	}
+
+	alerts, err := e.GetIncidentAlerts(incidentID)
+	if err != nil {
+		return nil, err
+	}
+	timeline, err := e.GetIncidentTimeline(incidentID)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &IncidentSummary{
+		Incident:        *incident,
+		DurationSeconds: int64(incident.ResolvedAt.Sub(incident.OpenedAt).Seconds()),
+		Rules:           []string{},
+		Alerts:          alerts,
+		Timeline:        timeline,
+	}
+	if incident.AcknowledgedAt != nil {
+		tta := int64(incident.AcknowledgedAt.Sub(incident.OpenedAt).Seconds())
+		summary.TimeToAcknowledgeSeconds = &tta
+	}
+
+	seen := make(map[string]bool)
+	for _, a := range alerts {
+		if !seen[a.RuleName] {
+			seen[a.RuleName] = true
+			summary.Rules = append(summary.Rules, a.RuleName)
+		}
+	}
+	return summary, nil
+}
+
+func (e *Engine) settingInt(key string, def int) int {
+	var value string
+	if err := e.db.QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value); err != nil {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return def
+	}
+	return n
+}
@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"net/http"
 	"net/smtp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/postfixrelay/postfixrelay/internal/i18n"
 	"github.com/rs/zerolog/log"
 )
 
@@ -17,22 +19,30 @@ import (
 type NotificationChannel struct {
 	ID       int64             `json:"id"`
 	Name     string            `json:"name"`
-	Type     string            `json:"type"` // email, webhook, slack
+	Type     string            `json:"type"` // email, webhook, slack, teams, discord
 	Enabled  bool              `json:"enabled"`
 	Config   map[string]string `json:"config"`
 }
 
 // Notifier sends alert notifications through configured channels
 type Notifier struct {
-	mu       sync.RWMutex
-	channels []NotificationChannel
-	client   *http.Client
+	mu              sync.RWMutex
+	channels        []NotificationChannel
+	templates       map[templateKey]MessageTemplate
+	routes          []Route
+	defaultChannels []int64
+	client          *http.Client
+
+	// incidentChannels remembers where an incident's alerts were routed,
+	// so its resolution goes to the same channels
+	incidentChannels map[int64][]int64
 }
 
 // NewNotifier creates a new notifier
 func NewNotifier() *Notifier {
 	return &Notifier{
-		channels: []NotificationChannel{},
+		channels:         []NotificationChannel{},
+		incidentChannels: make(map[int64][]int64),
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
@@ -46,28 +56,77 @@ func (n *Notifier) SetChannels(channels []NotificationChannel) {
 	n.channels = channels
 }
 
-// Notify sends an alert to all configured channels
+// Notify sends an alert to the channels it is routed to
 func (n *Notifier) Notify(alert Alert) {
-	n.mu.RLock()
-	channels := n.channels
-	n.mu.RUnlock()
+	channels := n.routeChannels(alert)
+	if alert.IncidentID != 0 {
+		n.mu.Lock()
+		for _, ch := range channels {
+			if !containsInt64(n.incidentChannels[alert.IncidentID], ch.ID) {
+				n.incidentChannels[alert.IncidentID] = append(n.incidentChannels[alert.IncidentID], ch.ID)
+			}
+		}
+		n.mu.Unlock()
+	}
+
+	n.dispatch(channels, func(channel NotificationChannel) error {
+		switch channel.Type {
+		case "email":
+			return n.sendEmail(channel, alert)
+		case "webhook":
+			return n.sendWebhook(channel, alert)
+		case "slack":
+			return n.sendSlack(channel, alert)
+		case "teams":
+			return n.sendTeams(channel, alert)
+		case "discord":
+			return n.sendDiscord(channel, alert)
+		}
+		return nil
+	})
+}
+
+// NotifyIncidentResolved sends an incident's resolution to the channels its
+// alerts went to (all channels if that is no longer known); email
+// notifications are threaded with the incident's alerts
+func (n *Notifier) NotifyIncidentResolved(incident Incident) {
+	n.mu.Lock()
+	ids, ok := n.incidentChannels[incident.ID]
+	delete(n.incidentChannels, incident.ID)
+	var channels []NotificationChannel
+	if ok {
+		channels = n.channelsByID(ids)
+	} else {
+		channels = n.channels
+	}
+	n.mu.Unlock()
+
+	n.dispatch(channels, func(channel NotificationChannel) error {
+		switch channel.Type {
+		case "email":
+			return n.sendIncidentResolvedEmail(channel, incident)
+		case "webhook":
+			return n.sendIncidentResolvedWebhook(channel, incident)
+		case "slack":
+			return n.sendIncidentResolvedSlack(channel, incident)
+		case "teams":
+			return n.sendIncidentResolvedTeams(channel, incident)
+		case "discord":
+			return n.sendIncidentResolvedDiscord(channel, incident)
+		}
+		return nil
+	})
+}
 
+// dispatch runs send for each enabled channel in the background
+func (n *Notifier) dispatch(channels []NotificationChannel, send func(NotificationChannel) error) {
 	for _, ch := range channels {
 		if !ch.Enabled {
 			continue
 		}
 
 		go func(channel NotificationChannel) {
-			var err error
-			switch channel.Type {
-			case "email":
-				err = n.sendEmail(channel, alert)
-			case "webhook":
-				err = n.sendWebhook(channel, alert)
-			case "slack":
-				err = n.sendSlack(channel, alert)
-			}
-			if err != nil {
+			if err := send(channel); err != nil {
 				log.Error().
 					Err(err).
 					Str("channel", channel.Name).
@@ -78,56 +137,99 @@ func (n *Notifier) Notify(alert Alert) {
 	}
 }
 
-// sendEmail sends an alert notification via email
-func (n *Notifier) sendEmail(ch NotificationChannel, alert Alert) error {
+// incidentThreadID is the Message-ID of the email that opened an incident,
+// which later emails about the incident reference
+func incidentThreadID(incidentID int64, from string) string {
+	domain := "postfixrelay"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = strings.Trim(from[at+1:], "> ")
+	}
+	return fmt.Sprintf("<incident-%d@%s>", incidentID, domain)
+}
+
+// smtpConfig returns an email channel's server address, auth and
+// recipients
+func smtpConfig(ch NotificationChannel) (addr string, auth smtp.Auth, from string, to []string, err error) {
 	smtpHost := ch.Config["smtp_host"]
 	smtpPort := ch.Config["smtp_port"]
-	from := ch.Config["from"]
-	to := ch.Config["to"]
+	from = ch.Config["from"]
 	username := ch.Config["username"]
 	password := ch.Config["password"]
 
-	if smtpHost == "" || from == "" || to == "" {
-		return fmt.Errorf("missing email configuration")
+	if smtpHost == "" || from == "" || ch.Config["to"] == "" {
+		return "", nil, "", nil, fmt.Errorf("missing email configuration")
 	}
 
 	if smtpPort == "" {
 		smtpPort = "587"
 	}
 
-	// Build message
-	subject := fmt.Sprintf("[%s] %s: %s", strings.ToUpper(string(alert.Severity)), alert.RuleName, alert.Message)
-	body := fmt.Sprintf(`Alert: %s
-Severity: %s
-Status: %s
-Triggered At: %s
+	if username != "" && password != "" {
+		auth = smtp.PlainAuth("", username, password, smtpHost)
+	}
+
+	return fmt.Sprintf("%s:%s", smtpHost, smtpPort), auth, from, strings.Split(ch.Config["to"], ","), nil
+}
 
-Message: %s
+// channelLocale returns the language an email channel is configured for
+// (config key "locale")
+func channelLocale(ch NotificationChannel) string {
+	if locale := ch.Config["locale"]; locale != "" {
+		return locale
+	}
+	return i18n.DefaultLocale
+}
+
+// headerValue keeps a rendered subject on one header line
+func headerValue(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// sendEmail sends an alert notification via email. Alerts that belong to
+// an incident share one thread: the alert that opened the incident sets
+// the thread's Message-ID and later ones reply to it.
+func (n *Notifier) sendEmail(ch NotificationChannel, alert Alert) error {
+	addr, auth, from, to, err := smtpConfig(ch)
+	if err != nil {
+		return err
+	}
 
---
-PostfixRelay Alert System
-`, alert.RuleName, alert.Severity, alert.Status, alert.TriggeredAt.Format(time.RFC3339), alert.Message)
+	subject, body := n.render(ch, alertTemplateData(ch, alert))
+	headers := ""
+	if alert.IncidentID != 0 {
+		thread := incidentThreadID(alert.IncidentID, from)
+		if alert.opensIncident {
+			headers = "Message-ID: " + thread + "\r\n"
+		} else {
+			headers = "In-Reply-To: " + thread + "\r\nReferences: " + thread + "\r\n"
+		}
+	}
 
-	msg := []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
-		from, to, subject, body))
+	msg := []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n%s\r\n%s",
+		from, ch.Config["to"], headerValue(subject), headers, body))
 
-	addr := fmt.Sprintf("%s:%s", smtpHost, smtpPort)
+	return smtp.SendMail(addr, auth, from, to, msg)
+}
 
-	var auth smtp.Auth
-	if username != "" && password != "" {
-		auth = smtp.PlainAuth("", username, password, smtpHost)
+// sendIncidentResolvedEmail replies to an incident's thread with its
+// resolution
+func (n *Notifier) sendIncidentResolvedEmail(ch NotificationChannel, incident Incident) error {
+	addr, auth, from, to, err := smtpConfig(ch)
+	if err != nil {
+		return err
 	}
 
-	return smtp.SendMail(addr, auth, from, strings.Split(to, ","), msg)
+	thread := incidentThreadID(incident.ID, from)
+	subject, body := n.render(ch, IncidentTemplateData(ch.Name, incident))
+
+	msg := []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nIn-Reply-To: %s\r\nReferences: %s\r\n\r\n%s",
+		from, ch.Config["to"], headerValue(subject), thread, thread, body))
+
+	return smtp.SendMail(addr, auth, from, to, msg)
 }
 
 // sendWebhook sends an alert notification via webhook
 func (n *Notifier) sendWebhook(ch NotificationChannel, alert Alert) error {
-	url := ch.Config["url"]
-	if url == "" {
-		return fmt.Errorf("missing webhook URL")
-	}
-
 	payload := map[string]interface{}{
 		"alert": map[string]interface{}{
 			"id":          alert.ID,
@@ -137,9 +239,40 @@ func (n *Notifier) sendWebhook(ch NotificationChannel, alert Alert) error {
 			"message":     alert.Message,
 			"triggeredAt": alert.TriggeredAt.Format(time.RFC3339),
 			"context":     alert.Context,
+			"runbookUrl":  alert.RunbookURL,
+			"references":  alert.References,
 		},
 		"timestamp": time.Now().UTC().Format(time.RFC3339),
 	}
+	payload["title"], payload["text"] = n.render(ch, alertTemplateData(ch, alert))
+	if alert.IncidentID != 0 {
+		payload["incident"] = map[string]interface{}{
+			"id":     alert.IncidentID,
+			"opened": alert.opensIncident,
+		}
+	}
+
+	return n.postWebhook(ch, payload)
+}
+
+// sendIncidentResolvedWebhook posts an incident's resolution to a webhook
+func (n *Notifier) sendIncidentResolvedWebhook(ch NotificationChannel, incident Incident) error {
+	title, text := n.render(ch, IncidentTemplateData(ch.Name, incident))
+	return n.postWebhook(ch, map[string]interface{}{
+		"event":     "incident_resolved",
+		"incident":  incident,
+		"title":     title,
+		"text":      text,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// postWebhook sends a JSON payload to a webhook channel
+func (n *Notifier) postWebhook(ch NotificationChannel, payload map[string]interface{}) error {
+	url := ch.Config["url"]
+	if url == "" {
+		return fmt.Errorf("missing webhook URL")
+	}
 
 	data, err := json.Marshal(payload)
 	if err != nil {
@@ -173,41 +306,116 @@ func (n *Notifier) sendWebhook(ch NotificationChannel, alert Alert) error {
 
 // sendSlack sends an alert notification to Slack
 func (n *Notifier) sendSlack(ch NotificationChannel, alert Alert) error {
-	webhookURL := ch.Config["webhook_url"]
-	if webhookURL == "" {
-		return fmt.Errorf("missing Slack webhook URL")
-	}
-
 	// Build Slack message
 	color := "#ffcc00" // warning
 	if alert.Severity == SeverityCritical {
 		color = "#ff0000" // critical
 	}
 
+	fields := []map[string]interface{}{
+		{
+			"title": "Status",
+			"value": string(alert.Status),
+			"short": true,
+		},
+		{
+			"title": "Triggered At",
+			"value": alert.TriggeredAt.Format(time.RFC3339),
+			"short": true,
+		},
+	}
+	if alert.RunbookURL != "" {
+		fields = append(fields, map[string]interface{}{
+			"title": "Runbook",
+			"value": fmt.Sprintf("<%s|Open runbook>", alert.RunbookURL),
+			"short": false,
+		})
+	}
+
+	footer := "PostfixRelay Alert System"
+	if alert.IncidentID != 0 {
+		footer = fmt.Sprintf("%s · Incident #%d", footer, alert.IncidentID)
+	}
+
+	title, text := n.render(ch, alertTemplateData(ch, alert))
 	payload := map[string]interface{}{
 		"attachments": []map[string]interface{}{
 			{
 				"color":  color,
-				"title":  fmt.Sprintf("[%s] %s", strings.ToUpper(string(alert.Severity)), alert.RuleName),
-				"text":   alert.Message,
-				"fields": []map[string]interface{}{
-					{
-						"title": "Status",
-						"value": string(alert.Status),
-						"short": true,
-					},
-					{
-						"title": "Triggered At",
-						"value": alert.TriggeredAt.Format(time.RFC3339),
-						"short": true,
-					},
-				},
-				"footer": "PostfixRelay Alert System",
+				"title":  title,
+				"text":   text,
+				"fields": fields,
+				"footer": footer,
 				"ts":     alert.TriggeredAt.Unix(),
 			},
 		},
 	}
 
+	// Buttons post back to the alert action webhook through the Slack
+	// app's interactivity request URL
+	if ch.Config["action_buttons"] == "true" && alert.ID != 0 {
+		value := strconv.FormatInt(alert.ID, 10)
+		payload["text"] = title
+		payload["blocks"] = []map[string]interface{}{
+			{
+				"type":     "actions",
+				"block_id": "psfx_alert_" + value,
+				"elements": []map[string]interface{}{
+					slackButton("Acknowledge", "acknowledge", value, "primary"),
+					slackButton("Silence 1h", "silence", value, ""),
+					slackButton("Resolve", "resolve", value, "danger"),
+				},
+			},
+		}
+	}
+
+	return n.postSlack(ch, payload)
+}
+
+// slackButton builds a Block Kit button element
+func slackButton(label, actionID, value, style string) map[string]interface{} {
+	button := map[string]interface{}{
+		"type":      "button",
+		"text":      map[string]interface{}{"type": "plain_text", "text": label},
+		"action_id": actionID,
+		"value":     value,
+	}
+	if style != "" {
+		button["style"] = style
+	}
+	return button
+}
+
+// sendIncidentResolvedSlack posts an incident's resolution to Slack
+func (n *Notifier) sendIncidentResolvedSlack(ch NotificationChannel, incident Incident) error {
+	resolvedAt := time.Now().UTC()
+	if incident.ResolvedAt != nil {
+		resolvedAt = *incident.ResolvedAt
+	}
+
+	title, text := n.render(ch, IncidentTemplateData(ch.Name, incident))
+	payload := map[string]interface{}{
+		"attachments": []map[string]interface{}{
+			{
+				"color":  "#36a64f",
+				"title":  title,
+				"text":   text,
+				"footer": fmt.Sprintf("PostfixRelay Alert System · Incident #%d", incident.ID),
+				"ts":     resolvedAt.Unix(),
+			},
+		},
+	}
+
+	return n.postSlack(ch, payload)
+}
+
+// postSlack sends a message payload to a Slack channel's webhook
+func (n *Notifier) postSlack(ch NotificationChannel, payload map[string]interface{}) error {
+	webhookURL := ch.Config["webhook_url"]
+	if webhookURL == "" {
+		return fmt.Errorf("missing Slack webhook URL")
+	}
+
 	// Add channel override if specified
 	if channel := ch.Config["channel"]; channel != "" {
 		payload["channel"] = channel
@@ -239,6 +447,39 @@ type RunbookContent struct {
 	Links    []string `json:"links,omitempty"`
 }
 
+// Localize returns a copy of the runbook translated into locale
+func (rb *RunbookContent) Localize(locale string) *RunbookContent {
+	localized := &RunbookContent{
+		Title:    i18n.T(locale, rb.Title),
+		Overview: i18n.T(locale, rb.Overview),
+		Steps:    make([]string, len(rb.Steps)),
+		Links:    rb.Links,
+	}
+	for i, step := range rb.Steps {
+		localized.Steps[i] = i18n.T(locale, step)
+	}
+	return localized
+}
+
+// Markdown renders the runbook as a markdown document
+func (rb *RunbookContent) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n%s\n", rb.Title, rb.Overview)
+	if len(rb.Steps) > 0 {
+		b.WriteString("\n")
+		for i, step := range rb.Steps {
+			fmt.Fprintf(&b, "%d. %s\n", i+1, step)
+		}
+	}
+	if len(rb.Links) > 0 {
+		b.WriteString("\n")
+		for _, link := range rb.Links {
+			fmt.Fprintf(&b, "- %s\n", link)
+		}
+	}
+	return b.String()
+}
+
 // GetRunbook returns the runbook for a specific alert type
 func GetRunbook(alertType string) *RunbookContent {
 	runbooks := map[string]*RunbookContent{
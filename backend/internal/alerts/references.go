@@ -0,0 +1,96 @@
+package alerts
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// maxQueueRefs bounds how many queue IDs an alert refers to
+const maxQueueRefs = 20
+
+// RefQueueID is the type of a reference to a message by Postfix queue ID
+const RefQueueID = "queue_id"
+
+// Reference points from an alert to something it is about, so the UI can
+// link to it (a queue ID opens the message trace)
+type Reference struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// QueueIDSource returns the queue IDs of recent deliveries with a status
+// (bounced or deferred), newest first
+type QueueIDSource func(status string, limit int) []string
+
+// SetQueueIDSource sets where bounce and deferral alerts find the messages
+// behind them
+func (e *Engine) SetQueueIDSource(source QueueIDSource) {
+	e.mu.Lock()
+	e.queueIDs = source
+	e.mu.Unlock()
+}
+
+// recentQueueIDs returns the queue IDs of recent deliveries with status, or
+// nil without a source
+func (e *Engine) recentQueueIDs(status string) []string {
+	e.mu.RLock()
+	source := e.queueIDs
+	e.mu.RUnlock()
+	if source == nil {
+		return nil
+	}
+	return source(status, maxQueueRefs)
+}
+
+// archiveQueueIDs returns the queue IDs of archive deliveries that failed
+// within window, newest first
+func (e *Engine) archiveQueueIDs(window time.Duration) []string {
+	rows, err := e.db.Query(`
+		SELECT queue_id FROM archive_events
+		WHERE occurred_at >= ? AND queue_id IS NOT NULL AND queue_id != ''
+		GROUP BY queue_id ORDER BY MAX(id) DESC LIMIT ?
+	`, time.Now().UTC().Add(-window).Format(time.RFC3339), maxQueueRefs)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ReferencesFromContext returns the references an alert's context holds:
+// its queueIds, whether set by a rule or decoded from the stored JSON
+func ReferencesFromContext(ctx map[string]interface{}) []Reference {
+	var ids []string
+	switch v := ctx["queueIds"].(type) {
+	case []string:
+		ids = v
+	case []interface{}:
+		for _, id := range v {
+			if s, ok := id.(string); ok {
+				ids = append(ids, s)
+			}
+		}
+	}
+
+	refs := make([]Reference, 0, len(ids))
+	for _, id := range ids {
+		refs = append(refs, Reference{Type: RefQueueID, ID: id})
+	}
+	return refs
+}
+
+// setContext fills in the alert's context and references from the stored
+// JSON
+func (a *Alert) setContext(raw string) {
+	if json.Unmarshal([]byte(raw), &a.Context) == nil {
+		a.References = ReferencesFromContext(a.Context)
+	}
+}
@@ -0,0 +1,225 @@
+package alerts
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Route sends matching alerts to a set of channels. Routes are evaluated
+// in Position order; the first match wins unless it sets Continue, in which
+// case later matching routes add their channels too. Alerts no route
+// matches go to the default channels.
+//
+// Empty conditions match anything. A route with no channels drops the
+// alerts it matches, which is how quiet hours are expressed.
+type Route struct {
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Position   int      `json:"position"`
+	Enabled    bool     `json:"enabled"`
+	Severity   string   `json:"severity"`  // warning or critical
+	RuleTypes  []string `json:"ruleTypes"` // alert rule types, e.g. queue_growth
+	Domain     string   `json:"domain"`    // matched against the alert's "domain" context
+	Days       []string `json:"days"`      // mon..sun
+	StartTime  string   `json:"startTime"` // HH:MM, may be after EndTime to span midnight
+	EndTime    string   `json:"endTime"`
+	Timezone   string   `json:"timezone"` // IANA zone for Days and times, server local if empty
+	ChannelIDs []int64  `json:"channelIds"`
+	Continue   bool     `json:"continue"`
+}
+
+// RouteDays are the day names a route's Days may contain
+var RouteDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseClock parses an HH:MM time of day into minutes after midnight
+func ParseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Matches reports whether the route applies to an alert from a rule of
+// ruleType at now
+func (r Route) Matches(alert Alert, ruleType string, now time.Time) bool {
+	if !r.Enabled {
+		return false
+	}
+	if r.Severity != "" && r.Severity != string(alert.Severity) {
+		return false
+	}
+	if len(r.RuleTypes) > 0 && !containsString(r.RuleTypes, ruleType) {
+		return false
+	}
+	if r.Domain != "" {
+		domain, _ := alert.Context["domain"].(string)
+		domain = strings.ToLower(domain)
+		want := strings.ToLower(r.Domain)
+		if domain != want && !strings.HasSuffix(domain, "."+want) {
+			return false
+		}
+	}
+	return r.inWindow(now)
+}
+
+// inWindow checks the route's days and time of day
+func (r Route) inWindow(now time.Time) bool {
+	if r.Timezone != "" {
+		if loc, err := time.LoadLocation(r.Timezone); err == nil {
+			now = now.In(loc)
+		}
+	}
+	if len(r.Days) > 0 && !containsString(r.Days, RouteDays[now.Weekday()]) {
+		return false
+	}
+	if r.StartTime == "" || r.EndTime == "" {
+		return true
+	}
+
+	start, err1 := ParseClock(r.StartTime)
+	end, err2 := ParseClock(r.EndTime)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	minute := now.Hour()*60 + now.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt64(list []int64, n int64) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// RouteAlert returns the IDs of the routes an alert matches and the
+// channels it goes to. defaults are the catch-all channels (nil for every
+// enabled channel, reported as a nil channel list).
+func RouteAlert(routes []Route, defaults []int64, alert Alert, ruleType string, now time.Time) (matched []int64, channels []int64) {
+	seen := make(map[int64]bool)
+	for _, r := range routes {
+		if !r.Matches(alert, ruleType, now) {
+			continue
+		}
+		matched = append(matched, r.ID)
+		for _, id := range r.ChannelIDs {
+			if !seen[id] {
+				seen[id] = true
+				channels = append(channels, id)
+			}
+		}
+		if !r.Continue {
+			break
+		}
+	}
+	if len(matched) > 0 {
+		if channels == nil {
+			channels = []int64{}
+		}
+		return matched, channels
+	}
+	return nil, defaults
+}
+
+// routeColumns are the alert_routes columns scanRoute reads
+const routeColumns = `id, name, position, enabled, severity, rule_types, domain, days,
+	start_time, end_time, timezone, channel_ids, continue_matching`
+
+// scanRoute reads a route selected with routeColumns
+func scanRoute(scan func(...interface{}) error) (Route, error) {
+	var r Route
+	var ruleTypes, days, channelIDs string
+	err := scan(&r.ID, &r.Name, &r.Position, &r.Enabled, &r.Severity, &ruleTypes, &r.Domain, &days,
+		&r.StartTime, &r.EndTime, &r.Timezone, &channelIDs, &r.Continue)
+	if err != nil {
+		return r, err
+	}
+	json.Unmarshal([]byte(ruleTypes), &r.RuleTypes)
+	json.Unmarshal([]byte(days), &r.Days)
+	json.Unmarshal([]byte(channelIDs), &r.ChannelIDs)
+	return r, nil
+}
+
+// LoadRoutes returns all alert routes in evaluation order
+func LoadRoutes(db *sql.DB) ([]Route, error) {
+	rows, err := db.Query(`SELECT ` + routeColumns + ` FROM alert_routes ORDER BY position, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	routes := []Route{}
+	for rows.Next() {
+		if r, err := scanRoute(rows.Scan); err == nil {
+			routes = append(routes, r)
+		}
+	}
+	return routes, nil
+}
+
+// ParseChannelIDs parses the alert_default_channels setting: a
+// comma-separated list of channel IDs, or empty (nil) for every channel
+func ParseChannelIDs(value string) []int64 {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	ids := []int64{}
+	for _, part := range strings.Split(value, ",") {
+		if id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// SetRoutes configures alert routing; defaults are the channels for alerts
+// no route matches, nil meaning every enabled channel
+func (n *Notifier) SetRoutes(routes []Route, defaults []int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.routes = routes
+	n.defaultChannels = defaults
+}
+
+// routeChannels returns the channels an alert is routed to
+func (n *Notifier) routeChannels(alert Alert) []NotificationChannel {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	_, ids := RouteAlert(n.routes, n.defaultChannels, alert, alert.rule.Type, time.Now())
+	return n.channelsByID(ids)
+}
+
+// channelsByID returns the channels with the given IDs, or all of them for
+// nil. The caller holds n.mu.
+func (n *Notifier) channelsByID(ids []int64) []NotificationChannel {
+	if ids == nil {
+		return n.channels
+	}
+	var out []NotificationChannel
+	for _, ch := range n.channels {
+		if containsInt64(ids, ch.ID) {
+			out = append(out, ch)
+		}
+	}
+	return out
+}
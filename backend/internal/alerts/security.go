@@ -0,0 +1,87 @@
+package alerts
+
+import (
+	"fmt"
+	"net/smtp"
+	"time"
+)
+
+// SecurityEvent is a high-risk action taken by a user, such as creating an
+// admin or purging the queue. Unlike alerts it has no state: it is sent
+// once, as it happens.
+type SecurityEvent struct {
+	Type       string    `json:"type"` // the audit action, such as user_create
+	Summary    string    `json:"summary"`
+	Username   string    `json:"username"`
+	IPAddress  string    `json:"ipAddress,omitempty"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+// title is the one-line heading of a security event notification
+func (ev SecurityEvent) title() string {
+	return "Security: " + ev.Summary
+}
+
+// text describes who did it, from where and when
+func (ev SecurityEvent) text() string {
+	text := fmt.Sprintf("%s by %s at %s", ev.Summary, ev.Username, ev.OccurredAt.UTC().Format(time.RFC3339))
+	if ev.IPAddress != "" {
+		text += " from " + ev.IPAddress
+	}
+	return text
+}
+
+// NotifySecurityEvent sends a security event to the channels with the
+// given IDs, or all channels for nil
+func (n *Notifier) NotifySecurityEvent(ev SecurityEvent, channelIDs []int64) {
+	n.mu.RLock()
+	channels := n.channelsByID(channelIDs)
+	n.mu.RUnlock()
+
+	title, text := ev.title(), ev.text()
+	n.dispatch(channels, func(channel NotificationChannel) error {
+		switch channel.Type {
+		case "email":
+			addr, auth, from, to, err := smtpConfig(channel)
+			if err != nil {
+				return err
+			}
+			msg := []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
+				from, channel.Config["to"], headerValue(title), text))
+			return smtp.SendMail(addr, auth, from, to, msg)
+		case "webhook":
+			return n.postWebhook(channel, map[string]interface{}{
+				"event":     "security_event",
+				"security":  ev,
+				"title":     title,
+				"text":      text,
+				"timestamp": time.Now().UTC().Format(time.RFC3339),
+			})
+		case "slack":
+			return n.postSlack(channel, map[string]interface{}{
+				"attachments": []map[string]interface{}{
+					{"color": "#ff0000", "title": title, "text": text, "ts": ev.OccurredAt.Unix()},
+				},
+			})
+		case "teams":
+			return n.postTeams(channel, []map[string]interface{}{
+				{"type": "TextBlock", "text": title, "weight": "Bolder", "size": "Medium", "color": "Attention", "wrap": true},
+				{"type": "TextBlock", "text": text, "wrap": true},
+			}, nil)
+		case "discord":
+			return n.postDiscord(channel, map[string]interface{}{
+				"title":       title,
+				"description": text,
+				"color":       0xff0000,
+				"timestamp":   ev.OccurredAt.Format(time.RFC3339),
+			})
+		}
+		return nil
+	})
+}
+
+// NotifySecurityEvent sends a security event through the engine's
+// notification channels
+func (e *Engine) NotifySecurityEvent(ev SecurityEvent, channelIDs []int64) {
+	e.notifier.NotifySecurityEvent(ev, channelIDs)
+}
@@ -0,0 +1,258 @@
+package alerts
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/i18n"
+	"github.com/rs/zerolog/log"
+)
+
+// Notification events a template can be defined for
+const (
+	EventAlertFired       = "alert_fired"
+	EventIncidentResolved = "incident_resolved"
+)
+
+// TemplateEvents lists the notification events
+var TemplateEvents = []string{EventAlertFired, EventIncidentResolved}
+
+// MessageTemplate overrides the subject and body of one event's
+// notifications, for one channel or (ChannelID 0) for every channel.
+// An empty subject or body keeps the default for that part.
+type MessageTemplate struct {
+	ID        int64  `json:"id"`
+	ChannelID int64  `json:"channelId"`
+	Event     string `json:"event"`
+	Subject   string `json:"subject"`
+	Body      string `json:"body"`
+}
+
+// TemplateData is what notification templates are rendered against. The
+// alert's fields are promoted, so {{.RuleName}} and {{.Message}} work
+// directly; incident events set Incident and OpenFor instead.
+type TemplateData struct {
+	Event   string
+	Channel string
+	Alert
+	Rule     AlertRule
+	Incident Incident
+	OpenFor  time.Duration
+}
+
+// Default templates. Email gets a localized plain-text message; chat
+// channels (and the webhook "text" field) get a title and short text.
+const (
+	defaultEmailAlertSubject = `{{if .IncidentID}}[{{t "Incident"}} #{{.IncidentID}}] {{end}}[{{upper (t .Severity)}}] {{.RuleName}}: {{.Message}}`
+	defaultEmailAlertBody    = `{{t "Alert"}}: {{.RuleName}}
+{{t "Severity"}}: {{t .Severity}}
+{{t "Status"}}: {{t .Status}}
+{{t "Triggered At"}}: {{rfc3339 .TriggeredAt}}
+
+{{t "Message"}}: {{.Message}}
+{{if .RunbookURL}}
+{{t "Runbook"}}: {{.RunbookURL}}
+{{end}}
+--
+{{t "PostfixRelay Alert System"}}
+`
+	defaultEmailIncidentSubject = `[{{t "Incident"}} #{{.Incident.ID}}] {{t "Incident resolved"}}: {{.Incident.Title}}`
+	defaultEmailIncidentBody    = `{{t "Incident"}}: {{.Incident.Title}}
+{{t "Severity"}}: {{t .Incident.Severity}}
+{{t "Opened At"}}: {{rfc3339 .Incident.OpenedAt}}
+{{t "Resolved At"}}: {{rfc3339 .Incident.ResolvedAt}}
+{{t "Alerts"}}: {{.Incident.AlertCount}}
+
+--
+{{t "PostfixRelay Alert System"}}
+`
+	defaultChatAlertSubject    = `[{{upper .Severity}}] {{.RuleName}}`
+	defaultChatAlertBody       = `{{.Message}}`
+	defaultChatIncidentSubject = `[RESOLVED] Incident #{{.Incident.ID}}: {{.Incident.Title}}`
+	defaultChatIncidentBody    = `{{.Incident.AlertCount}} alerts, open for {{.OpenFor}}`
+)
+
+// DefaultTemplate returns the built-in template for an event on a channel type
+func DefaultTemplate(event, channelType string) MessageTemplate {
+	t := MessageTemplate{Event: event}
+	email := channelType == "email"
+	switch {
+	case event == EventIncidentResolved && email:
+		t.Subject, t.Body = defaultEmailIncidentSubject, defaultEmailIncidentBody
+	case event == EventIncidentResolved:
+		t.Subject, t.Body = defaultChatIncidentSubject, defaultChatIncidentBody
+	case email:
+		t.Subject, t.Body = defaultEmailAlertSubject, defaultEmailAlertBody
+	default:
+		t.Subject, t.Body = defaultChatAlertSubject, defaultChatAlertBody
+	}
+	return t
+}
+
+// templateFuncs are the functions available to templates; t translates
+// into the channel's locale
+func templateFuncs(locale string) template.FuncMap {
+	return template.FuncMap{
+		"t": func(v interface{}) string { return i18n.T(locale, fmt.Sprint(v)) },
+		"upper": func(v interface{}) string {
+			return strings.ToUpper(fmt.Sprint(v))
+		},
+		"lower": func(v interface{}) string {
+			return strings.ToLower(fmt.Sprint(v))
+		},
+		"rfc3339": func(v interface{}) string {
+			switch t := v.(type) {
+			case time.Time:
+				return t.Format(time.RFC3339)
+			case *time.Time:
+				if t != nil {
+					return t.Format(time.RFC3339)
+				}
+			}
+			return ""
+		},
+		"date": func(layout string, t time.Time) string { return t.Format(layout) },
+	}
+}
+
+// ParseTemplate parses a notification template
+func ParseTemplate(text string) (*template.Template, error) {
+	return template.New("message").Funcs(templateFuncs(i18n.DefaultLocale)).Option("missingkey=zero").Parse(text)
+}
+
+// RenderTemplate renders a subject and body template in a locale
+func RenderTemplate(subject, body string, data TemplateData, locale string) (string, string, error) {
+	var out [2]string
+	for i, text := range []string{subject, body} {
+		tmpl, err := template.New("message").Funcs(templateFuncs(locale)).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return "", "", err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return "", "", err
+		}
+		out[i] = buf.String()
+	}
+	return out[0], out[1], nil
+}
+
+// SampleTemplateData returns made-up data for previewing an event's templates
+func SampleTemplateData(event string) TemplateData {
+	now := time.Now().UTC().Truncate(time.Second)
+	rule := AlertRule{
+		ID:             1,
+		Name:           "High Queue Size",
+		Description:    "Mail queue exceeds threshold",
+		Type:           "queue_size",
+		Enabled:        true,
+		ThresholdValue: 1000,
+		Severity:       SeverityWarning,
+	}
+	data := TemplateData{
+		Event: event,
+		Rule:  rule,
+		Alert: Alert{
+			ID:          42,
+			RuleID:      rule.ID,
+			RuleName:    rule.Name,
+			Status:      StatusFiring,
+			Severity:    rule.Severity,
+			TriggeredAt: now,
+			Message:     "Queue size 1250 exceeds threshold 1000",
+			Context:     map[string]interface{}{"queueSize": 1250},
+			IncidentID:  7,
+		},
+	}
+	if event == EventIncidentResolved {
+		resolved := now
+		data.Incident = Incident{
+			ID:         7,
+			Title:      rule.Name,
+			Status:     IncidentResolved,
+			Severity:   rule.Severity,
+			OpenedAt:   now.Add(-25 * time.Minute),
+			ResolvedAt: &resolved,
+			AlertCount: 2,
+		}
+		data.OpenFor = 25 * time.Minute
+	}
+	return data
+}
+
+// alertTemplateData builds the template data for a fired alert
+func alertTemplateData(ch NotificationChannel, alert Alert) TemplateData {
+	return TemplateData{Event: EventAlertFired, Channel: ch.Name, Alert: alert, Rule: alert.rule}
+}
+
+// IncidentTemplateData builds the template data for a resolved incident
+func IncidentTemplateData(channel string, incident Incident) TemplateData {
+	resolvedAt := time.Now().UTC()
+	if incident.ResolvedAt != nil {
+		resolvedAt = *incident.ResolvedAt
+	} else {
+		incident.ResolvedAt = &resolvedAt
+	}
+	return TemplateData{
+		Event:    EventIncidentResolved,
+		Channel:  channel,
+		Incident: incident,
+		OpenFor:  resolvedAt.Sub(incident.OpenedAt).Round(time.Second),
+	}
+}
+
+// render renders an event's subject and body for a channel. The channel's
+// own template wins over the all-channels one, empty parts keep the
+// default, and a template that fails to render is replaced by the default.
+func (n *Notifier) render(ch NotificationChannel, data TemplateData) (string, string) {
+	def := DefaultTemplate(data.Event, ch.Type)
+	tmpl := def
+
+	n.mu.RLock()
+	global := n.templates[templateKey{0, data.Event}]
+	own := n.templates[templateKey{ch.ID, data.Event}]
+	n.mu.RUnlock()
+
+	// Channels from before per-event templates keep their "template"
+	// config as the alert body
+	if data.Event == EventAlertFired && own.Body == "" && ch.Config["template"] != "" {
+		own.Body = ch.Config["template"]
+	}
+
+	for _, t := range []MessageTemplate{global, own} {
+		if t.Subject != "" {
+			tmpl.Subject = t.Subject
+		}
+		if t.Body != "" {
+			tmpl.Body = t.Body
+		}
+	}
+
+	locale := channelLocale(ch)
+	subject, body, err := RenderTemplate(tmpl.Subject, tmpl.Body, data, locale)
+	if err != nil {
+		log.Warn().Err(err).Str("channel", ch.Name).Str("event", data.Event).Msg("Notification template failed, using default")
+		subject, body, _ = RenderTemplate(def.Subject, def.Body, data, locale)
+	}
+	return subject, body
+}
+
+// templateKey identifies a template by channel (0 for all) and event
+type templateKey struct {
+	channelID int64
+	event     string
+}
+
+// SetTemplates configures the notification templates
+func (n *Notifier) SetTemplates(templates []MessageTemplate) {
+	byKey := make(map[templateKey]MessageTemplate, len(templates))
+	for _, t := range templates {
+		byKey[templateKey{t.ChannelID, t.Event}] = t
+	}
+	n.mu.Lock()
+	n.templates = byKey
+	n.mu.Unlock()
+}
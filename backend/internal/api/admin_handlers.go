@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"os"
 	"strconv"
@@ -9,22 +10,33 @@ import (
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/postfixrelay/postfixrelay/internal/retention"
+	"github.com/postfixrelay/postfixrelay/internal/supervisor"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // Domain represents a mail domain
 type Domain struct {
-	ID           int64     `json:"id"`
-	Domain       string    `json:"domain"`
-	Description  string    `json:"description"`
-	MaxMailboxes int       `json:"maxMailboxes"`
-	MaxAliases   int       `json:"maxAliases"`
-	QuotaBytes   int64     `json:"quotaBytes"`
-	Active       bool      `json:"active"`
-	CreatedAt    time.Time `json:"createdAt"`
-	CreatedBy    *int64    `json:"createdBy,omitempty"`
-	UpdatedAt    time.Time `json:"updatedAt"`
+	ID             int64     `json:"id"`
+	Domain         string    `json:"domain"`
+	Description    string    `json:"description"`
+	MaxMailboxes   int       `json:"maxMailboxes"`
+	MaxAliases     int       `json:"maxAliases"`
+	QuotaBytes     int64     `json:"quotaBytes"`
+	Active         bool      `json:"active"`
+	ArchiveEnabled bool      `json:"archiveEnabled"`
+	TenantID       *int64    `json:"tenantId,omitempty"`
+	CreatedAt      time.Time `json:"createdAt"`
+	CreatedBy      *int64    `json:"createdBy,omitempty"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+	// DirectoryEnabled shares the domain's mailboxes and aliases with its
+	// webmail users as a global address list
+	DirectoryEnabled bool `json:"directoryEnabled"`
+	// Confidential redacts the subjects and bodies of the domain's mail in
+	// queue and trace views
+	Confidential bool `json:"confidential"`
 	// Computed fields
 	MailboxCount int `json:"mailboxCount"`
 	AliasCount   int `json:"aliasCount"`
@@ -43,8 +55,12 @@ type Mailbox struct {
 	LastLogin    *time.Time `json:"lastLogin"`
 	CreatedAt    time.Time  `json:"createdAt"`
 	UpdatedAt    time.Time  `json:"updatedAt"`
+	// Features the mailbox may use, set from its domain's defaults
+	WebmailEnabled      bool `json:"webmailEnabled"`
+	AppPasswordsEnabled bool `json:"appPasswordsEnabled"`
 	// Computed fields
 	UsedBytes int64 `json:"usedBytes"`
+	LegalHold bool  `json:"legalHold"`
 }
 
 // Alias represents an email alias
@@ -61,15 +77,25 @@ type Alias struct {
 // Domain handlers
 
 func (s *Server) listDomains(w http.ResponseWriter, r *http.Request) {
-	rows, err := s.db.Query(`
+	query := `
 		SELECT
 			d.id, d.domain, d.description, d.max_mailboxes, d.max_aliases,
-			d.quota_bytes, d.active, d.created_at, d.created_by, d.updated_at,
+			d.quota_bytes, d.active, d.archive_enabled, d.directory_enabled, d.confidential, d.tenant_id, d.created_at, d.created_by, d.updated_at,
 			(SELECT COUNT(*) FROM mailboxes WHERE domain_id = d.id) as mailbox_count,
 			(SELECT COUNT(*) FROM mail_aliases WHERE domain_id = d.id) as alias_count
 		FROM mail_domains d
-		ORDER BY d.domain ASC
-	`)
+	`
+	var args []interface{}
+	if tenantID := tenantOf(r); tenantID != 0 {
+		query += " WHERE d.tenant_id = ?"
+		args = append(args, tenantID)
+	} else if t := r.URL.Query().Get("tenant_id"); t != "" {
+		query += " WHERE d.tenant_id = ?"
+		args = append(args, t)
+	}
+	query += " ORDER BY d.domain ASC"
+
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to query domains")
 		http.Error(w, "Failed to query domains", http.StatusInternalServerError)
@@ -83,7 +109,7 @@ func (s *Server) listDomains(w http.ResponseWriter, r *http.Request) {
 		var description, createdBy *string
 		err := rows.Scan(
 			&d.ID, &d.Domain, &description, &d.MaxMailboxes, &d.MaxAliases,
-			&d.QuotaBytes, &d.Active, &d.CreatedAt, &createdBy, &d.UpdatedAt,
+			&d.QuotaBytes, &d.Active, &d.ArchiveEnabled, &d.DirectoryEnabled, &d.Confidential, &d.TenantID, &d.CreatedAt, &createdBy, &d.UpdatedAt,
 			&d.MailboxCount, &d.AliasCount,
 		)
 		if err != nil {
@@ -110,6 +136,7 @@ type createDomainRequest struct {
 	MaxMailboxes int    `json:"maxMailboxes"`
 	MaxAliases   int    `json:"maxAliases"`
 	QuotaBytes   int64  `json:"quotaBytes"`
+	TenantID     int64  `json:"tenantId"` // platform admins only
 }
 
 func (s *Server) createDomain(w http.ResponseWriter, r *http.Request) {
@@ -128,10 +155,18 @@ func (s *Server) createDomain(w http.ResponseWriter, r *http.Request) {
 
 	user := GetUser(r.Context())
 
+	// Tenant admins add domains to their own tenant
+	if user.TenantID != 0 {
+		req.TenantID = user.TenantID
+	} else if req.TenantID != 0 && !s.tenantExists(req.TenantID) {
+		http.Error(w, "Tenant not found", http.StatusBadRequest)
+		return
+	}
+
 	result, err := s.db.Exec(`
-		INSERT INTO mail_domains (domain, description, max_mailboxes, max_aliases, quota_bytes, created_by)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, req.Domain, req.Description, req.MaxMailboxes, req.MaxAliases, req.QuotaBytes, user.ID)
+		INSERT INTO mail_domains (domain, description, max_mailboxes, max_aliases, quota_bytes, created_by, tenant_id)
+		VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, 0))
+	`, req.Domain, req.Description, req.MaxMailboxes, req.MaxAliases, req.QuotaBytes, user.ID, req.TenantID)
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE") {
 			http.Error(w, "Domain already exists", http.StatusConflict)
@@ -161,9 +196,9 @@ func (s *Server) getDomain(w http.ResponseWriter, r *http.Request) {
 	var d Domain
 	var description *string
 	err := s.db.QueryRow(`
-		SELECT id, domain, description, max_mailboxes, max_aliases, quota_bytes, active, created_at, updated_at
+		SELECT id, domain, description, max_mailboxes, max_aliases, quota_bytes, active, archive_enabled, directory_enabled, confidential, tenant_id, created_at, updated_at
 		FROM mail_domains WHERE id = ?
-	`, id).Scan(&d.ID, &d.Domain, &description, &d.MaxMailboxes, &d.MaxAliases, &d.QuotaBytes, &d.Active, &d.CreatedAt, &d.UpdatedAt)
+	`, id).Scan(&d.ID, &d.Domain, &description, &d.MaxMailboxes, &d.MaxAliases, &d.QuotaBytes, &d.Active, &d.ArchiveEnabled, &d.DirectoryEnabled, &d.Confidential, &d.TenantID, &d.CreatedAt, &d.UpdatedAt)
 	if err != nil {
 		http.Error(w, "Domain not found", http.StatusNotFound)
 		return
@@ -178,11 +213,19 @@ func (s *Server) getDomain(w http.ResponseWriter, r *http.Request) {
 }
 
 type updateDomainRequest struct {
-	Description  string `json:"description"`
-	MaxMailboxes int    `json:"maxMailboxes"`
-	MaxAliases   int    `json:"maxAliases"`
-	QuotaBytes   int64  `json:"quotaBytes"`
-	Active       *bool  `json:"active"`
+	Description    string `json:"description"`
+	MaxMailboxes   int    `json:"maxMailboxes"`
+	MaxAliases     int    `json:"maxAliases"`
+	QuotaBytes     int64  `json:"quotaBytes"`
+	Active         *bool  `json:"active"`
+	ArchiveEnabled *bool  `json:"archiveEnabled"`
+	// DirectoryEnabled turns the domain's global address list on or off
+	DirectoryEnabled *bool `json:"directoryEnabled"`
+	// Confidential turns redaction of the domain's mail on or off
+	Confidential *bool `json:"confidential"`
+	// TenantID moves the domain to a tenant, or to the platform with 0.
+	// Platform admins only.
+	TenantID *int64 `json:"tenantId"`
 }
 
 func (s *Server) updateDomain(w http.ResponseWriter, r *http.Request) {
@@ -198,10 +241,35 @@ func (s *Server) updateDomain(w http.ResponseWriter, r *http.Request) {
 	query := `UPDATE mail_domains SET description = ?, max_mailboxes = ?, max_aliases = ?, quota_bytes = ?, updated_at = CURRENT_TIMESTAMP`
 	args := []interface{}{req.Description, req.MaxMailboxes, req.MaxAliases, req.QuotaBytes}
 
+	if req.TenantID != nil {
+		if user.TenantID != 0 {
+			http.Error(w, "Only platform admins can move domains between tenants", http.StatusForbidden)
+			return
+		}
+		if *req.TenantID != 0 && !s.tenantExists(*req.TenantID) {
+			http.Error(w, "Tenant not found", http.StatusBadRequest)
+			return
+		}
+		query += ", tenant_id = NULLIF(?, 0)"
+		args = append(args, *req.TenantID)
+	}
+
 	if req.Active != nil {
 		query += ", active = ?"
 		args = append(args, *req.Active)
 	}
+	if req.ArchiveEnabled != nil {
+		query += ", archive_enabled = ?"
+		args = append(args, *req.ArchiveEnabled)
+	}
+	if req.DirectoryEnabled != nil {
+		query += ", directory_enabled = ?"
+		args = append(args, *req.DirectoryEnabled)
+	}
+	if req.Confidential != nil {
+		query += ", confidential = ?"
+		args = append(args, *req.Confidential)
+	}
 	query += " WHERE id = ?"
 	args = append(args, id)
 
@@ -216,11 +284,10 @@ func (s *Server) updateDomain(w http.ResponseWriter, r *http.Request) {
 
 	// If active status changed, sync all mail configuration
 	if req.Active != nil {
-		go func() {
-			if err := s.dovecotSyncer.SyncAll(); err != nil {
-				log.Error().Err(err).Msg("Failed to sync mail configuration after domain update")
-			}
-		}()
+		s.workers.Go("mail_sync", supervisor.Retry, s.dovecotSyncer.SyncAll)
+		s.syncDKIMTables()
+	} else if req.ArchiveEnabled != nil {
+		s.workers.Go("archive_bcc_sync", supervisor.Retry, s.dovecotSyncer.SyncArchiveBCC)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -231,11 +298,23 @@ func (s *Server) deleteDomain(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	user := GetUser(r.Context())
 
-	// Check for existing mailboxes
+	// Check for existing mailboxes. Deleting them along with the domain
+	// (?withData=true) needs a confirmed approval request.
 	var count int
 	s.db.QueryRow("SELECT COUNT(*) FROM mailboxes WHERE domain_id = ?", id).Scan(&count)
 	if count > 0 {
-		http.Error(w, "Cannot delete domain with existing mailboxes", http.StatusConflict)
+		if r.URL.Query().Get("withData") != "true" {
+			http.Error(w, "Cannot delete domain with existing mailboxes", http.StatusConflict)
+			return
+		}
+		if s.domainHeld(id) {
+			http.Error(w, "Domain has a mailbox under legal hold", http.StatusConflict)
+			return
+		}
+		var domain string
+		s.db.QueryRow("SELECT domain FROM mail_domains WHERE id = ?", id).Scan(&domain)
+		s.requestApproval(w, r, approvalDomainDelete, id, "DELETE",
+			fmt.Sprintf("Delete domain %s with its %d mailboxes and aliases", domain, count))
 		return
 	}
 
@@ -247,6 +326,7 @@ func (s *Server) deleteDomain(w http.ResponseWriter, r *http.Request) {
 	}
 
 	s.auditLog(user.ID, user.Username, "delete", "mail_domain", id, "Deleted mail domain", "success", "", r)
+	s.syncDKIMTables()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Domain deleted successfully"})
@@ -261,16 +341,26 @@ func (s *Server) listMailboxes(w http.ResponseWriter, r *http.Request) {
 		SELECT
 			m.id, m.email, m.local_part, m.domain_id, d.domain, m.display_name,
 			m.quota_bytes, m.active, m.last_login, m.created_at, m.updated_at,
-			COALESCE(q.bytes_used, 0) as bytes_used
+			m.webmail_enabled, m.app_passwords_enabled,
+			COALESCE(q.bytes_used, 0) as bytes_used,
+			EXISTS(SELECT 1 FROM legal_holds h WHERE h.scope = 'mailbox' AND h.mailbox_id = m.id AND h.released_at IS NULL)
 		FROM mailboxes m
 		JOIN mail_domains d ON m.domain_id = d.id
 		LEFT JOIN mailbox_quota q ON m.id = q.mailbox_id
 	`
+	var conds []string
 	var args []interface{}
 	if domainID != "" {
-		query += " WHERE m.domain_id = ?"
+		conds = append(conds, "m.domain_id = ?")
 		args = append(args, domainID)
 	}
+	if tenantID := tenantOf(r); tenantID != 0 {
+		conds = append(conds, "d.tenant_id = ?")
+		args = append(args, tenantID)
+	}
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
 	query += " ORDER BY m.email ASC"
 
 	rows, err := s.db.Query(query, args...)
@@ -289,7 +379,8 @@ func (s *Server) listMailboxes(w http.ResponseWriter, r *http.Request) {
 		err := rows.Scan(
 			&m.ID, &m.Email, &m.LocalPart, &m.DomainID, &m.Domain, &displayName,
 			&m.QuotaBytes, &m.Active, &lastLogin, &m.CreatedAt, &m.UpdatedAt,
-			&m.UsedBytes,
+			&m.WebmailEnabled, &m.AppPasswordsEnabled,
+			&m.UsedBytes, &m.LegalHold,
 		)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to scan mailbox row")
@@ -329,7 +420,7 @@ func (s *Server) createMailbox(w http.ResponseWriter, r *http.Request) {
 	// Get domain
 	var domain string
 	err := s.db.QueryRow("SELECT domain FROM mail_domains WHERE id = ?", req.DomainID).Scan(&domain)
-	if err != nil {
+	if err != nil || !s.domainInScope(r, req.DomainID) {
 		http.Error(w, "Domain not found", http.StatusBadRequest)
 		return
 	}
@@ -338,6 +429,18 @@ func (s *Server) createMailbox(w http.ResponseWriter, r *http.Request) {
 	req.LocalPart = strings.ToLower(strings.TrimSpace(req.LocalPart))
 	email := req.LocalPart + "@" + domain
 
+	// Apply the domain's defaults and password policy
+	defaults, err := s.loadDomainDefaults(req.DomainID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load domain defaults")
+		http.Error(w, "Failed to load domain defaults", http.StatusInternalServerError)
+		return
+	}
+	if problem := defaults.CheckPassword(req.Password); problem != "" {
+		http.Error(w, problem, http.StatusBadRequest)
+		return
+	}
+
 	// Hash password
 	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -345,15 +448,16 @@ func (s *Server) createMailbox(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Default quota: 1GB
 	if req.QuotaBytes <= 0 {
-		req.QuotaBytes = 1073741824
+		req.QuotaBytes = defaults.QuotaBytes
 	}
 
 	result, err := s.db.Exec(`
-		INSERT INTO mailboxes (email, local_part, domain_id, password_hash, display_name, quota_bytes)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, email, req.LocalPart, req.DomainID, string(hash), req.DisplayName, req.QuotaBytes)
+		INSERT INTO mailboxes (email, local_part, domain_id, password_hash, display_name, quota_bytes,
+			webmail_enabled, app_passwords_enabled)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, email, req.LocalPart, req.DomainID, string(hash), req.DisplayName, req.QuotaBytes,
+		defaults.WebmailEnabled, defaults.AppPasswordsEnabled)
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE") {
 			http.Error(w, "Mailbox already exists", http.StatusConflict)
@@ -369,14 +473,14 @@ func (s *Server) createMailbox(w http.ResponseWriter, r *http.Request) {
 	// Create quota entry
 	s.db.Exec("INSERT INTO mailbox_quota (mailbox_id) VALUES (?)", id)
 
+	if err := applySignatureTemplate(s.db, defaults, email, req.DisplayName); err != nil {
+		log.Warn().Err(err).Str("email", email).Msg("Failed to create signature from domain template")
+	}
+
 	s.auditLog(user.ID, user.Username, "create", "mailbox", strconv.FormatInt(id, 10), "Created mailbox: "+email, "success", "", r)
 
 	// Sync Dovecot users and Postfix maps
-	go func() {
-		if err := s.dovecotSyncer.SyncAll(); err != nil {
-			log.Error().Err(err).Msg("Failed to sync mail configuration after mailbox creation")
-		}
-	}()
+	s.workers.Go("mail_sync", supervisor.Retry, s.dovecotSyncer.SyncAll)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -394,13 +498,16 @@ func (s *Server) getMailbox(w http.ResponseWriter, r *http.Request) {
 	var lastLogin *time.Time
 	err := s.db.QueryRow(`
 		SELECT m.id, m.email, m.local_part, m.domain_id, d.domain, m.display_name,
-		       m.quota_bytes, m.active, m.last_login, m.created_at, m.updated_at
+		       m.quota_bytes, m.active, m.last_login, m.created_at, m.updated_at,
+		       m.webmail_enabled, m.app_passwords_enabled,
+		       EXISTS(SELECT 1 FROM legal_holds h WHERE h.scope = 'mailbox' AND h.mailbox_id = m.id AND h.released_at IS NULL)
 		FROM mailboxes m
 		JOIN mail_domains d ON m.domain_id = d.id
 		WHERE m.id = ?
 	`, id).Scan(
 		&m.ID, &m.Email, &m.LocalPart, &m.DomainID, &m.Domain, &displayName,
 		&m.QuotaBytes, &m.Active, &lastLogin, &m.CreatedAt, &m.UpdatedAt,
+		&m.WebmailEnabled, &m.AppPasswordsEnabled, &m.LegalHold,
 	)
 	if err != nil {
 		http.Error(w, "Mailbox not found", http.StatusNotFound)
@@ -421,9 +528,11 @@ func (s *Server) updateMailbox(w http.ResponseWriter, r *http.Request) {
 	user := GetUser(r.Context())
 
 	var req struct {
-		DisplayName string `json:"displayName"`
-		QuotaBytes  int64  `json:"quotaBytes"`
-		Active      *bool  `json:"active"`
+		DisplayName         string `json:"displayName"`
+		QuotaBytes          int64  `json:"quotaBytes"`
+		Active              *bool  `json:"active"`
+		WebmailEnabled      *bool  `json:"webmailEnabled"`
+		AppPasswordsEnabled *bool  `json:"appPasswordsEnabled"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -436,6 +545,14 @@ func (s *Server) updateMailbox(w http.ResponseWriter, r *http.Request) {
 		query += ", active = ?"
 		args = append(args, *req.Active)
 	}
+	if req.WebmailEnabled != nil {
+		query += ", webmail_enabled = ?"
+		args = append(args, *req.WebmailEnabled)
+	}
+	if req.AppPasswordsEnabled != nil {
+		query += ", app_passwords_enabled = ?"
+		args = append(args, *req.AppPasswordsEnabled)
+	}
 	query += " WHERE id = ?"
 	args = append(args, id)
 
@@ -449,11 +566,10 @@ func (s *Server) updateMailbox(w http.ResponseWriter, r *http.Request) {
 	s.auditLog(user.ID, user.Username, "update", "mailbox", id, "Updated mailbox", "success", "", r)
 
 	// Sync Dovecot users (quota or active status may have changed)
-	go func() {
-		if err := s.dovecotSyncer.SyncDovecotUsers(); err != nil {
-			log.Error().Err(err).Msg("Failed to sync Dovecot users after mailbox update")
-		}
-	}()
+	s.workers.Go("dovecot_users_sync", supervisor.Retry, s.dovecotSyncer.SyncDovecotUsers)
+	if req.AppPasswordsEnabled != nil {
+		s.syncAppPasswords()
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Mailbox updated successfully"})
@@ -466,6 +582,12 @@ func (s *Server) deleteMailbox(w http.ResponseWriter, r *http.Request) {
 	var email string
 	s.db.QueryRow("SELECT email FROM mailboxes WHERE id = ?", id).Scan(&email)
 
+	if email != "" && retention.MailboxHeld(s.db.DB, email) {
+		http.Error(w, "Mailbox is under legal hold", http.StatusConflict)
+		return
+	}
+
+	s.db.Exec("DELETE FROM mailbox_app_passwords WHERE mailbox_id = ?", id)
 	_, err := s.db.Exec("DELETE FROM mailboxes WHERE id = ?", id)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to delete mailbox")
@@ -476,11 +598,7 @@ func (s *Server) deleteMailbox(w http.ResponseWriter, r *http.Request) {
 	s.auditLog(user.ID, user.Username, "delete", "mailbox", id, "Deleted mailbox: "+email, "success", "", r)
 
 	// Sync Dovecot users and Postfix maps
-	go func() {
-		if err := s.dovecotSyncer.SyncAll(); err != nil {
-			log.Error().Err(err).Msg("Failed to sync mail configuration after mailbox deletion")
-		}
-	}()
+	s.workers.Go("mail_sync", supervisor.Retry, s.dovecotSyncer.SyncAll)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Mailbox deleted successfully"})
@@ -498,8 +616,18 @@ func (s *Server) resetMailboxPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(req.Password) < 8 {
-		http.Error(w, "Password must be at least 8 characters", http.StatusBadRequest)
+	var domainID int64
+	if err := s.db.QueryRow("SELECT domain_id FROM mailboxes WHERE id = ?", id).Scan(&domainID); err != nil {
+		http.Error(w, "Mailbox not found", http.StatusNotFound)
+		return
+	}
+	defaults, err := s.loadDomainDefaults(domainID)
+	if err != nil {
+		http.Error(w, "Failed to load domain defaults", http.StatusInternalServerError)
+		return
+	}
+	if problem := defaults.CheckPassword(req.Password); problem != "" {
+		http.Error(w, problem, http.StatusBadRequest)
 		return
 	}
 
@@ -519,11 +647,7 @@ func (s *Server) resetMailboxPassword(w http.ResponseWriter, r *http.Request) {
 	s.auditLog(user.ID, user.Username, "password_reset", "mailbox", id, "Reset mailbox password", "success", "", r)
 
 	// Sync Dovecot passwd file
-	go func() {
-		if err := s.dovecotSyncer.SyncDovecotUsers(); err != nil {
-			log.Error().Err(err).Msg("Failed to sync Dovecot users after password reset")
-		}
-	}()
+	s.workers.Go("dovecot_users_sync", supervisor.Retry, s.dovecotSyncer.SyncDovecotUsers)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Password reset successfully"})
@@ -539,11 +663,19 @@ func (s *Server) listAliases(w http.ResponseWriter, r *http.Request) {
 		FROM mail_aliases a
 		JOIN mail_domains d ON a.domain_id = d.id
 	`
+	var conds []string
 	var args []interface{}
 	if domainID != "" {
-		query += " WHERE a.domain_id = ?"
+		conds = append(conds, "a.domain_id = ?")
 		args = append(args, domainID)
 	}
+	if tenantID := tenantOf(r); tenantID != 0 {
+		conds = append(conds, "d.tenant_id = ?")
+		args = append(args, tenantID)
+	}
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
 	query += " ORDER BY a.source_email ASC"
 
 	rows, err := s.db.Query(query, args...)
@@ -590,7 +722,7 @@ func (s *Server) createAlias(w http.ResponseWriter, r *http.Request) {
 	// Get domain
 	var domain string
 	err := s.db.QueryRow("SELECT domain FROM mail_domains WHERE id = ?", req.DomainID).Scan(&domain)
-	if err != nil {
+	if err != nil || !s.domainInScope(r, req.DomainID) {
 		http.Error(w, "Domain not found", http.StatusBadRequest)
 		return
 	}
@@ -600,6 +732,27 @@ func (s *Server) createAlias(w http.ResponseWriter, r *http.Request) {
 	sourceEmail := req.LocalPart + "@" + domain
 	req.DestinationEmail = strings.ToLower(strings.TrimSpace(req.DestinationEmail))
 
+	if isDryRun(r) {
+		var exists int
+		s.db.QueryRow("SELECT COUNT(*) FROM mail_aliases WHERE source_email = ? AND destination_email = ?",
+			sourceEmail, req.DestinationEmail).Scan(&exists)
+		if exists > 0 {
+			http.Error(w, "Alias already exists", http.StatusConflict)
+			return
+		}
+		change, err := s.dovecotSyncer.PreviewAddAlias(sourceEmail, req.DestinationEmail)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to preview alias")
+			http.Error(w, "Failed to preview alias", http.StatusInternalServerError)
+			return
+		}
+		writeDryRun(w, DryRunResult{
+			Summary: "Would create alias " + sourceEmail + " -> " + req.DestinationEmail,
+			Files:   []postfix.FileChange{change},
+		})
+		return
+	}
+
 	result, err := s.db.Exec(`
 		INSERT INTO mail_aliases (source_email, destination_email, domain_id)
 		VALUES (?, ?, ?)
@@ -616,15 +769,12 @@ func (s *Server) createAlias(w http.ResponseWriter, r *http.Request) {
 
 	id, _ := result.LastInsertId()
 
-	s.auditLog(user.ID, user.Username, "create", "mail_alias", strconv.FormatInt(id, 10),
-		"Created alias: "+sourceEmail+" -> "+req.DestinationEmail, "success", "", r)
+	s.logAuditDiff(user, "create", "mail_alias", strconv.FormatInt(id, 10),
+		"Created alias: "+sourceEmail+" -> "+req.DestinationEmail,
+		auditDiff(nil, map[string]string{"source": sourceEmail, "destination": req.DestinationEmail}), r)
 
 	// Sync Postfix virtual alias map
-	go func() {
-		if err := s.dovecotSyncer.SyncPostfixMaps(); err != nil {
-			log.Error().Err(err).Msg("Failed to sync Postfix maps after alias creation")
-		}
-	}()
+	s.workers.Go("postfix_maps_sync", supervisor.Retry, s.dovecotSyncer.SyncPostfixMaps)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -648,14 +798,11 @@ func (s *Server) deleteAlias(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.auditLog(user.ID, user.Username, "delete", "mail_alias", id, "Deleted alias: "+source+" -> "+dest, "success", "", r)
+	s.logAuditDiff(user, "delete", "mail_alias", id, "Deleted alias: "+source+" -> "+dest,
+		auditDiff(map[string]string{"source": source, "destination": dest}, nil), r)
 
 	// Sync Postfix virtual alias map
-	go func() {
-		if err := s.dovecotSyncer.SyncPostfixMaps(); err != nil {
-			log.Error().Err(err).Msg("Failed to sync Postfix maps after alias deletion")
-		}
-	}()
+	s.workers.Go("postfix_maps_sync", supervisor.Retry, s.dovecotSyncer.SyncPostfixMaps)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Alias deleted successfully"})
@@ -672,12 +819,24 @@ func (s *Server) getAdminStats(w http.ResponseWriter, r *http.Request) {
 		ActiveDomains int   `json:"activeDomains"`
 	}
 
-	s.db.QueryRow("SELECT COUNT(*) FROM mail_domains").Scan(&stats.Domains)
-	s.db.QueryRow("SELECT COUNT(*) FROM mail_domains WHERE active = TRUE").Scan(&stats.ActiveDomains)
-	s.db.QueryRow("SELECT COUNT(*) FROM mailboxes").Scan(&stats.Mailboxes)
-	s.db.QueryRow("SELECT COUNT(*) FROM mail_aliases").Scan(&stats.Aliases)
-	s.db.QueryRow("SELECT COALESCE(SUM(quota_bytes), 0) FROM mailboxes").Scan(&stats.TotalQuota)
-	s.db.QueryRow("SELECT COALESCE(SUM(bytes_used), 0) FROM mailbox_quota").Scan(&stats.UsedQuota)
+	// Tenant users count their own tenant's domains only
+	domains := "SELECT id FROM mail_domains"
+	var args []interface{}
+	if tenantID := tenantOf(r); tenantID != 0 {
+		domains += " WHERE tenant_id = ?"
+		args = append(args, tenantID)
+	}
+
+	s.db.QueryRow("SELECT COUNT(*) FROM mail_domains WHERE id IN ("+domains+")", args...).Scan(&stats.Domains)
+	s.db.QueryRow("SELECT COUNT(*) FROM mail_domains WHERE active = TRUE AND id IN ("+domains+")", args...).Scan(&stats.ActiveDomains)
+	s.db.QueryRow("SELECT COUNT(*) FROM mailboxes WHERE domain_id IN ("+domains+")", args...).Scan(&stats.Mailboxes)
+	s.db.QueryRow("SELECT COUNT(*) FROM mail_aliases WHERE domain_id IN ("+domains+")", args...).Scan(&stats.Aliases)
+	s.db.QueryRow("SELECT COALESCE(SUM(quota_bytes), 0) FROM mailboxes WHERE domain_id IN ("+domains+")", args...).Scan(&stats.TotalQuota)
+	s.db.QueryRow(`
+		SELECT COALESCE(SUM(q.bytes_used), 0) FROM mailbox_quota q
+		JOIN mailboxes m ON q.mailbox_id = m.id
+		WHERE m.domain_id IN (`+domains+`)
+	`, args...).Scan(&stats.UsedQuota)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// The alert action webhook lets chat integrations acknowledge, silence or
+// resolve an alert. Requests are signed the way Slack signs interactivity
+// requests, so a Slack app's request URL can point straight at it:
+//
+//	X-Slack-Request-Timestamp: <unix seconds>
+//	X-Slack-Signature: v0=<hex HMAC-SHA256 of "v0:<timestamp>:<body>">
+//
+// keyed with the alert_action_secret setting (the Slack app's signing
+// secret). The webhook is disabled while the setting is empty.
+
+// alertActionMaxSkew is how old a signed request may be, which limits replays
+const alertActionMaxSkew = 5 * time.Minute
+
+// alertActionRequest is the JSON body for integrations other than Slack
+type alertActionRequest struct {
+	AlertID         int64  `json:"alertId"`
+	Action          string `json:"action"`
+	User            string `json:"user"`
+	Note            string `json:"note"`
+	DurationMinutes int    `json:"durationMinutes"`
+}
+
+// slackInteraction is the part of a Slack block_actions payload we use
+type slackInteraction struct {
+	Type string `json:"type"`
+	User struct {
+		Username string `json:"username"`
+		Name     string `json:"name"`
+	} `json:"user"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+	ResponseURL string `json:"response_url"`
+}
+
+// verifyAlertActionSignature checks the request signature and timestamp
+func verifyAlertActionSignature(secret string, r *http.Request, body []byte) bool {
+	ts := r.Header.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := time.Since(time.Unix(sec, 0)); skew > alertActionMaxSkew || skew < -alertActionMaxSkew {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:", ts)
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature")))
+}
+
+// parseAlertAction reads the action from a Slack form post or a JSON body,
+// returning the Slack response_url if there is one
+func parseAlertAction(r *http.Request, body []byte) (alertActionRequest, string, error) {
+	var req alertActionRequest
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if err := json.Unmarshal(body, &req); err != nil {
+			return req, "", err
+		}
+		return req, "", nil
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return req, "", err
+	}
+	var in slackInteraction
+	if err := json.Unmarshal([]byte(form.Get("payload")), &in); err != nil {
+		return req, "", err
+	}
+	if in.Type != "block_actions" || len(in.Actions) == 0 {
+		return req, "", fmt.Errorf("unsupported interaction %q", in.Type)
+	}
+	req.Action = in.Actions[0].ActionID
+	req.AlertID, _ = strconv.ParseInt(in.Actions[0].Value, 10, 64)
+	req.User = in.User.Username
+	if req.User == "" {
+		req.User = in.User.Name
+	}
+	return req, in.ResponseURL, nil
+}
+
+// alertActionWebhook applies a signed alert action
+func (s *Server) alertActionWebhook(w http.ResponseWriter, r *http.Request) {
+	secret := s.db.GetSetting("alert_action_secret", "")
+	if secret == "" {
+		http.Error(w, "Alert action webhook is disabled", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !verifyAlertActionSignature(secret, r, body) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	req, responseURL, err := parseAlertAction(r, body)
+	if err != nil || req.AlertID <= 0 {
+		http.Error(w, "Invalid alert action", http.StatusBadRequest)
+		return
+	}
+	user := "chat"
+	if req.User != "" {
+		user = "chat:" + req.User
+	}
+
+	s.initAlertEngine()
+	alert, err := alertEngine.GetAlert(req.AlertID)
+	if err != nil {
+		http.Error(w, "Alert not found", http.StatusNotFound)
+		return
+	}
+
+	var summary string
+	switch req.Action {
+	case "acknowledge":
+		err = alertEngine.AcknowledgeAlert(req.AlertID, user, req.Note)
+		summary = fmt.Sprintf("Acknowledged alert %d", req.AlertID)
+	case "silence":
+		if req.DurationMinutes <= 0 {
+			req.DurationMinutes = 60
+		}
+		err = alertEngine.SilenceAlert(req.AlertID, req.DurationMinutes)
+		summary = fmt.Sprintf("Silenced alert %d for %d minutes", req.AlertID, req.DurationMinutes)
+	case "resolve":
+		err = alertEngine.ResolveAlert(req.AlertID, user)
+		summary = fmt.Sprintf("Resolved alert %d", req.AlertID)
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	id := strconv.FormatInt(req.AlertID, 10)
+	if err != nil {
+		s.logAudit(0, user, "alert_"+req.Action, "alert", id, summary, "failure", r.RemoteAddr)
+		http.Error(w, "Failed to update alert", http.StatusInternalServerError)
+		return
+	}
+	s.logAudit(0, user, "alert_"+req.Action, "alert", id, summary+" via chat", "success", r.RemoteAddr)
+
+	reply := map[string]interface{}{
+		"response_type":    "in_channel",
+		"replace_original": false,
+		"text":             fmt.Sprintf("%s (%s) by %s", summary, alert.RuleName, strings.TrimPrefix(user, "chat:")),
+	}
+	if responseURL != "" {
+		go postSlackResponse(responseURL, reply)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+// postSlackResponse posts a confirmation into the channel the button was
+// clicked in; Slack ignores the response body of block_actions requests
+func postSlackResponse(responseURL string, reply map[string]interface{}) {
+	data, _ := json.Marshal(reply)
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(responseURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to post alert action confirmation to Slack")
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// API token scopes, one per integration endpoint group
+const (
+	ScopeRecipientCheck = "recipient-check"
+	ScopeSend           = "send"
+)
+
+// apiTokenScopes lists the scopes a token can be given
+var apiTokenScopes = []string{ScopeRecipientCheck, ScopeSend}
+
+// apiTokenPrefix marks integration tokens so they are recognisable in
+// config files and secret scanners
+const apiTokenPrefix = "psfx_"
+
+// APIToken is a token external systems use for the integration API. The
+// token itself is only returned when it is created.
+type APIToken struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token,omitempty"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	Senders    []string   `json:"senders"` // addresses or @domains it may send from; empty for any managed domain
+	CreatedBy  string     `json:"createdBy"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// HasScope reports whether the token may call endpoints of a scope
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type apiTokenKey struct{}
+
+// getAPIToken returns the token a request was authenticated with
+func getAPIToken(ctx context.Context) *APIToken {
+	token, _ := ctx.Value(apiTokenKey{}).(*APIToken)
+	return token
+}
+
+// splitSenders parses the stored senders list
+func splitSenders(senders string) []string {
+	if senders == "" {
+		return []string{}
+	}
+	return strings.Split(senders, ",")
+}
+
+// MaySendFrom reports whether the token may send from an address. Tokens
+// without a senders list may use any managed domain, which the send
+// handler checks separately.
+func (t *APIToken) MaySendFrom(address string) bool {
+	if len(t.Senders) == 0 {
+		return true
+	}
+	domain := "@" + address[strings.LastIndex(address, "@")+1:]
+	for _, sender := range t.Senders {
+		if sender == address || sender == domain {
+			return true
+		}
+	}
+	return false
+}
+
+func hashAPIToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// apiTokenAuth authenticates a request with an unrevoked API token that has
+// the scope. The token goes in the Authorization header as a bearer token.
+func (s *Server) apiTokenAuth(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if presented == "" || !strings.HasPrefix(presented, apiTokenPrefix) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			var token APIToken
+			var scopes, senders string
+			err := s.db.QueryRow(`
+				SELECT id, name, token_prefix, scopes, senders, created_by, created_at
+				FROM api_tokens WHERE token_hash = ? AND revoked_at IS NULL
+			`, hashAPIToken(presented)).Scan(&token.ID, &token.Name, &token.Prefix, &scopes, &senders, &token.CreatedBy, &token.CreatedAt)
+			if err != nil {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			token.Scopes = strings.Split(scopes, ",")
+			token.Senders = splitSenders(senders)
+			if !token.HasScope(scope) {
+				http.Error(w, "token lacks the "+scope+" scope", http.StatusForbidden)
+				return
+			}
+
+			_, _ = s.db.Exec(`UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?`, token.ID)
+
+			ctx := context.WithValue(r.Context(), apiTokenKey{}, &token)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// listAPITokens returns all API tokens, revoked ones last
+func (s *Server) listAPITokens(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(`
+		SELECT id, name, token_prefix, scopes, senders, created_by, created_at, last_used_at, revoked_at
+		FROM api_tokens ORDER BY revoked_at IS NOT NULL, created_at DESC
+	`)
+	if err != nil {
+		http.Error(w, "Failed to load API tokens", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		var t APIToken
+		var scopes, senders string
+		var lastUsed, revoked sql.NullTime
+		if err := rows.Scan(&t.ID, &t.Name, &t.Prefix, &scopes, &senders, &t.CreatedBy, &t.CreatedAt, &lastUsed, &revoked); err != nil {
+			log.Error().Err(err).Msg("Failed to scan API token")
+			continue
+		}
+		t.Scopes = strings.Split(scopes, ",")
+		t.Senders = splitSenders(senders)
+		if lastUsed.Valid {
+			t.LastUsedAt = &lastUsed.Time
+		}
+		if revoked.Valid {
+			t.RevokedAt = &revoked.Time
+		}
+		tokens = append(tokens, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tokens": tokens,
+		"scopes": apiTokenScopes,
+	})
+}
+
+// createAPIToken issues a token with the requested scopes. The token is in
+// the response and can't be retrieved later.
+func (s *Server) createAPIToken(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+
+	var req struct {
+		Name    string   `json:"name"`
+		Scopes  []string `json:"scopes"`
+		Senders []string `json:"senders"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+
+	v := NewValidator()
+	v.ValidateRequired("name", req.Name)
+	v.ValidateMaxLength("name", req.Name, 100)
+	if len(req.Scopes) == 0 {
+		v.AddError("scopes", "this field is required")
+	}
+	for _, scope := range req.Scopes {
+		valid := false
+		for _, known := range apiTokenScopes {
+			valid = valid || scope == known
+		}
+		if !valid {
+			v.AddErrorf("scopes", "must be one of: %s", strings.Join(apiTokenScopes, ", "))
+			break
+		}
+	}
+	for i, sender := range req.Senders {
+		sender = strings.ToLower(strings.TrimSpace(sender))
+		if domain, ok := strings.CutPrefix(sender, "@"); ok {
+			v.ValidateDomain("senders", domain)
+		} else {
+			v.ValidateEmail("senders", sender)
+		}
+		req.Senders[i] = sender
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	token := apiTokenPrefix + hex.EncodeToString(b)
+	prefix := token[:len(apiTokenPrefix)+8]
+
+	result, err := s.db.Exec(`
+		INSERT INTO api_tokens (name, token_hash, token_prefix, scopes, senders, created_by)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, req.Name, hashAPIToken(token), prefix, strings.Join(req.Scopes, ","), strings.Join(req.Senders, ","), user.Username)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create API token")
+		http.Error(w, "Failed to create API token", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	s.auditLog(user.ID, user.Username, "api_token_create", "api_token", req.Name,
+		"Created API token "+req.Name+" with scopes "+strings.Join(req.Scopes, ", "), "success", "", r)
+	for _, scope := range req.Scopes {
+		if apiTokenWriteScopes[scope] {
+			s.notifyPrivileged(r, "api_token_create",
+				"API token "+req.Name+" created with write scopes "+strings.Join(req.Scopes, ", "))
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(APIToken{
+		ID:        id,
+		Name:      req.Name,
+		Token:     token,
+		Prefix:    prefix,
+		Scopes:    req.Scopes,
+		Senders:   splitSenders(strings.Join(req.Senders, ",")),
+		CreatedBy: user.Username,
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+// revokeAPIToken revokes a token. It is kept so the audit log and its
+// statistics still resolve.
+func (s *Server) revokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	id := chi.URLParam(r, "id")
+
+	var name string
+	if err := s.db.QueryRow(`SELECT name FROM api_tokens WHERE id = ? AND revoked_at IS NULL`, id).Scan(&name); err != nil {
+		http.Error(w, "API token not found", http.StatusNotFound)
+		return
+	}
+	if _, err := s.db.Exec(`UPDATE api_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = ?`, id); err != nil {
+		http.Error(w, "Failed to revoke API token", http.StatusInternalServerError)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "api_token_revoke", "api_token", id,
+		"Revoked API token "+name, "success", "", r)
+
+	w.WriteHeader(http.StatusNoContent)
+}
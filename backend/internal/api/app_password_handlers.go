@@ -0,0 +1,305 @@
+package api
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/dovecot"
+	"github.com/postfixrelay/postfixrelay/internal/supervisor"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AppPassword is an app-specific IMAP/SMTP password. The password itself is
+// only returned when it is created.
+type AppPassword struct {
+	ID        int64     `json:"id"`
+	Label     string    `json:"label"`
+	Password  string    `json:"password,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// appPasswordLabel is what a label may contain. It is written into the
+// passdb as an extra field, which can't hold spaces or colons.
+var appPasswordLabel = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// generateAppPassword returns a random password in four groups of four
+// lowercase characters, easy to type on a phone
+func generateAppPassword() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	encoded := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return encoded[0:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:16], nil
+}
+
+// listMailboxAppPasswords returns a mailbox's app passwords, without the
+// passwords
+func (s *Server) listMailboxAppPasswords(mailboxID int64) ([]AppPassword, error) {
+	rows, err := s.db.Query(`
+		SELECT id, label, created_at FROM mailbox_app_passwords
+		WHERE mailbox_id = ? ORDER BY created_at, id
+	`, mailboxID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passwords := []AppPassword{}
+	for rows.Next() {
+		var p AppPassword
+		if err := rows.Scan(&p.ID, &p.Label, &p.CreatedAt); err != nil {
+			log.Error().Err(err).Msg("Failed to scan app password")
+			continue
+		}
+		passwords = append(passwords, p)
+	}
+	return passwords, nil
+}
+
+// mailSessionMailboxID returns the ID of the logged-in mail user's mailbox
+func (s *Server) mailSessionMailboxID(r *http.Request) (int64, bool) {
+	session := getMailSession(r.Context())
+	if session == nil {
+		return 0, false
+	}
+	var id int64
+	if err := s.db.QueryRow(`SELECT id FROM mailboxes WHERE email = ?`, session.Email).Scan(&id); err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// syncAppPasswords rewrites the app password passdb files in the
+// background. Dovecot rereads passwd-files when they change.
+func (s *Server) syncAppPasswords() {
+	s.workers.Go("app_password_sync", supervisor.Retry, s.dovecotSyncer.SyncAppPasswords)
+}
+
+// getAppPasswords lists the logged-in mail user's app passwords
+func (s *Server) getAppPasswords(w http.ResponseWriter, r *http.Request) {
+	mailboxID, ok := s.mailSessionMailboxID(r)
+	if !ok {
+		http.Error(w, "Session not found", http.StatusUnauthorized)
+		return
+	}
+
+	passwords, err := s.listMailboxAppPasswords(mailboxID)
+	if err != nil {
+		http.Error(w, "Failed to load app passwords", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"appPasswords": passwords,
+		"max":          dovecot.AppPasswordSlots,
+	})
+}
+
+// createAppPassword generates a named app password for the logged-in mail
+// user. The password is in the response and can't be retrieved later.
+func (s *Server) createAppPassword(w http.ResponseWriter, r *http.Request) {
+	session := getMailSession(r.Context())
+	mailboxID, ok := s.mailSessionMailboxID(r)
+	if !ok {
+		http.Error(w, "Session not found", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Label string `json:"label"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Label = strings.TrimSpace(req.Label)
+
+	var allowed bool
+	s.db.QueryRow(`SELECT app_passwords_enabled FROM mailboxes WHERE id = ?`, mailboxID).Scan(&allowed)
+	if !allowed {
+		http.Error(w, "App passwords are disabled for this mailbox", http.StatusForbidden)
+		return
+	}
+
+	v := NewValidator()
+	v.ValidateRequired("label", req.Label)
+	if req.Label != "" && !appPasswordLabel.MatchString(req.Label) {
+		v.AddError("label", "must be up to 64 letters, digits, dots, dashes or underscores")
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	// Take the lowest free slot
+	used := map[int]bool{}
+	rows, err := s.db.Query(`SELECT slot, label FROM mailbox_app_passwords WHERE mailbox_id = ?`, mailboxID)
+	if err != nil {
+		http.Error(w, "Failed to load app passwords", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var slot int
+		var label string
+		if rows.Scan(&slot, &label) != nil {
+			continue
+		}
+		if label == req.Label {
+			rows.Close()
+			http.Error(w, "An app password with this label already exists", http.StatusConflict)
+			return
+		}
+		used[slot] = true
+	}
+	rows.Close()
+
+	slot := -1
+	for i := 0; i < dovecot.AppPasswordSlots; i++ {
+		if !used[i] {
+			slot = i
+			break
+		}
+	}
+	if slot < 0 {
+		http.Error(w, fmt.Sprintf("A mailbox can have at most %d app passwords; revoke one first", dovecot.AppPasswordSlots), http.StatusConflict)
+		return
+	}
+
+	password, err := generateAppPassword()
+	if err != nil {
+		http.Error(w, "Failed to generate app password", http.StatusInternalServerError)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO mailbox_app_passwords (mailbox_id, label, slot, password_hash)
+		VALUES (?, ?, ?, ?)
+	`, mailboxID, req.Label, slot, string(hash))
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint") {
+			http.Error(w, "An app password with this label already exists", http.StatusConflict)
+			return
+		}
+		log.Error().Err(err).Msg("Failed to create app password")
+		http.Error(w, "Failed to create app password", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	log.Info().Str("email", session.Email).Str("label", req.Label).Msg("App password created")
+	s.syncAppPasswords()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(AppPassword{
+		ID:        id,
+		Label:     req.Label,
+		Password:  password,
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+// deleteAppPassword revokes one of the logged-in mail user's app passwords
+func (s *Server) deleteAppPassword(w http.ResponseWriter, r *http.Request) {
+	session := getMailSession(r.Context())
+	mailboxID, ok := s.mailSessionMailboxID(r)
+	if !ok {
+		http.Error(w, "Session not found", http.StatusUnauthorized)
+		return
+	}
+
+	label, err := s.revokeAppPassword(mailboxID, chi.URLParam(r, "id"))
+	if err == sql.ErrNoRows {
+		http.Error(w, "App password not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke app password", http.StatusInternalServerError)
+		return
+	}
+
+	log.Info().Str("email", session.Email).Str("label", label).Msg("App password revoked")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeAppPassword deletes a mailbox's app password and syncs the passdb,
+// returning its label
+func (s *Server) revokeAppPassword(mailboxID int64, id string) (string, error) {
+	var label string
+	err := s.db.QueryRow(`SELECT label FROM mailbox_app_passwords WHERE id = ? AND mailbox_id = ?`, id, mailboxID).Scan(&label)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.db.Exec(`DELETE FROM mailbox_app_passwords WHERE id = ? AND mailbox_id = ?`, id, mailboxID); err != nil {
+		log.Error().Err(err).Msg("Failed to revoke app password")
+		return "", err
+	}
+	s.syncAppPasswords()
+	return label, nil
+}
+
+// getMailboxAppPasswords lists a mailbox's app passwords for admins
+func (s *Server) getMailboxAppPasswords(w http.ResponseWriter, r *http.Request) {
+	var mailboxID int64
+	if err := s.db.QueryRow(`SELECT id FROM mailboxes WHERE id = ?`, chi.URLParam(r, "id")).Scan(&mailboxID); err != nil {
+		http.Error(w, "Mailbox not found", http.StatusNotFound)
+		return
+	}
+
+	passwords, err := s.listMailboxAppPasswords(mailboxID)
+	if err != nil {
+		http.Error(w, "Failed to load app passwords", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"appPasswords": passwords,
+		"max":          dovecot.AppPasswordSlots,
+	})
+}
+
+// deleteMailboxAppPassword revokes a mailbox's app password for admins,
+// e.g. when a phone is lost
+func (s *Server) deleteMailboxAppPassword(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	id := chi.URLParam(r, "id")
+
+	var mailboxID int64
+	var email string
+	if err := s.db.QueryRow(`SELECT id, email FROM mailboxes WHERE id = ?`, id).Scan(&mailboxID, &email); err != nil {
+		http.Error(w, "Mailbox not found", http.StatusNotFound)
+		return
+	}
+
+	label, err := s.revokeAppPassword(mailboxID, chi.URLParam(r, "appPasswordId"))
+	if err == sql.ErrNoRows {
+		http.Error(w, "App password not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke app password", http.StatusInternalServerError)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "app_password_revoke", "mailbox", id,
+		fmt.Sprintf("Revoked app password %s of %s", label, email), "success", "", r)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,425 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/retention"
+	"github.com/postfixrelay/postfixrelay/internal/services"
+	"github.com/postfixrelay/postfixrelay/internal/supervisor"
+	"github.com/rs/zerolog/log"
+)
+
+// Destructive actions that are only carried out through an approval request
+const (
+	approvalQueueDeleteDeferred = "queue_delete_deferred"
+	approvalQueuePurge          = "queue_purge"
+	approvalQueueBulkDelete     = "queue_bulk_delete"
+	approvalDomainDelete        = "domain_delete"
+	approvalServiceStop         = "service_stop"
+	approvalServiceRestart      = "service_restart"
+)
+
+// approvalTTL is how long a request waits for confirmation and approval
+const approvalTTL = 30 * time.Minute
+
+// ActionApproval is a pending or decided destructive action. The requester
+// confirms it by typing back ConfirmationToken; with
+// destructive_second_admin set another admin must then approve it.
+type ActionApproval struct {
+	ID                int64      `json:"id"`
+	Action            string     `json:"action"`
+	Target            string     `json:"target,omitempty"`
+	Summary           string     `json:"summary"`
+	ConfirmationToken string     `json:"confirmationToken,omitempty"` // only returned to the requester on creation
+	Status            string     `json:"status"`                      // pending, awaiting_approval, executing, executed, failed, rejected, expired
+	RequiresApproval  bool       `json:"requiresApproval"`
+	RequestedByID     int64      `json:"requestedById"`
+	RequestedBy       string     `json:"requestedBy"`
+	RequestedAt       time.Time  `json:"requestedAt"`
+	ExpiresAt         time.Time  `json:"expiresAt"`
+	DecidedBy         string     `json:"decidedBy,omitempty"`
+	DecidedAt         *time.Time `json:"decidedAt,omitempty"`
+	Result            string     `json:"result,omitempty"`
+}
+
+const approvalColumns = `id, action, COALESCE(target, ''), summary, token, status, requires_approval,
+	requested_by_id, requested_by, requested_at, expires_at, COALESCE(decided_by, ''), decided_at, COALESCE(result, '')`
+
+func scanApproval(scan func(...interface{}) error) (ActionApproval, error) {
+	var a ActionApproval
+	var requestedAt, expiresAt string
+	var decidedAt sql.NullString
+	err := scan(&a.ID, &a.Action, &a.Target, &a.Summary, &a.ConfirmationToken, &a.Status, &a.RequiresApproval,
+		&a.RequestedByID, &a.RequestedBy, &requestedAt, &expiresAt, &a.DecidedBy, &decidedAt, &a.Result)
+	if err != nil {
+		return a, err
+	}
+	a.RequestedAt, _ = time.Parse(time.RFC3339, requestedAt)
+	a.ExpiresAt, _ = time.Parse(time.RFC3339, expiresAt)
+	if decidedAt.Valid {
+		if t, err := time.Parse(time.RFC3339, decidedAt.String); err == nil {
+			a.DecidedAt = &t
+		}
+	}
+	if (a.Status == "pending" || a.Status == "awaiting_approval") && time.Now().After(a.ExpiresAt) {
+		a.Status = "expired"
+	}
+	return a, nil
+}
+
+// newConfirmationToken returns a short token the requester has to type
+// back, prefixed with the action's verb so it reads as a confirmation
+func newConfirmationToken(verb string) string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return verb + "-" + strings.ToUpper(hex.EncodeToString(b))
+}
+
+// requestApproval records a destructive action and responds with the
+// approval request instead of carrying it out
+func (s *Server) requestApproval(w http.ResponseWriter, r *http.Request, action, target, verb, summary string) {
+	user := GetUser(r.Context())
+	now := time.Now().UTC().Truncate(time.Second)
+	a := ActionApproval{
+		Action:            action,
+		Target:            target,
+		Summary:           summary,
+		ConfirmationToken: newConfirmationToken(verb),
+		Status:            "pending",
+		RequiresApproval:  s.db.GetSetting("destructive_second_admin", "false") == "true",
+		RequestedByID:     user.ID,
+		RequestedBy:       user.Username,
+		RequestedAt:       now,
+		ExpiresAt:         now.Add(approvalTTL),
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO action_approvals (action, target, summary, token, status, requires_approval,
+			requested_by_id, requested_by, requested_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, a.Action, a.Target, a.Summary, a.ConfirmationToken, a.Status, a.RequiresApproval,
+		a.RequestedByID, a.RequestedBy, a.RequestedAt.Format(time.RFC3339), a.ExpiresAt.Format(time.RFC3339))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create approval request")
+		http.Error(w, "Failed to create approval request", http.StatusInternalServerError)
+		return
+	}
+	a.ID, _ = result.LastInsertId()
+
+	s.logAudit(user.ID, user.Username, "approval_request", "approval", strconv.FormatInt(a.ID, 10),
+		"Requested: "+a.Summary, "success", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(a)
+}
+
+// listApprovals returns approval requests, newest first; ?status= filters
+// by status. Confirmation tokens are never listed.
+func (s *Server) listApprovals(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(`SELECT ` + approvalColumns + ` FROM action_approvals ORDER BY id DESC LIMIT 200`)
+	if err != nil {
+		http.Error(w, "Failed to load approval requests", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	status := r.URL.Query().Get("status")
+	approvals := []ActionApproval{}
+	for rows.Next() {
+		a, err := scanApproval(rows.Scan)
+		if err != nil {
+			continue
+		}
+		if status != "" && a.Status != status {
+			continue
+		}
+		a.ConfirmationToken = ""
+		approvals = append(approvals, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(approvals)
+}
+
+// loadApproval reads the approval request named in the URL, writing an
+// error response if there is none
+func (s *Server) loadApproval(w http.ResponseWriter, r *http.Request) (ActionApproval, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid approval request ID", http.StatusBadRequest)
+		return ActionApproval{}, false
+	}
+	a, err := scanApproval(s.db.QueryRow(`SELECT `+approvalColumns+` FROM action_approvals WHERE id = ?`, id).Scan)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Approval request not found", http.StatusNotFound)
+		return a, false
+	} else if err != nil {
+		http.Error(w, "Failed to load approval request", http.StatusInternalServerError)
+		return a, false
+	}
+	return a, true
+}
+
+// confirmApproval takes the typed confirmation token from the requester.
+// The action runs now, or waits for a second admin if that is required.
+func (s *Server) confirmApproval(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.loadApproval(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user := GetUser(r.Context())
+	if user.ID != a.RequestedByID {
+		http.Error(w, "Only the requester can confirm this action", http.StatusForbidden)
+		return
+	}
+	if a.Status != "pending" {
+		http.Error(w, "Approval request is "+a.Status, http.StatusConflict)
+		return
+	}
+	token := strings.ToUpper(strings.TrimSpace(req.Token))
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.ConfirmationToken)) != 1 {
+		v := NewValidator()
+		v.AddError("token", "does not match the confirmation token")
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	if a.RequiresApproval {
+		if !s.transitionApproval(w, &a, "pending", "awaiting_approval", "", "") {
+			return
+		}
+		s.logAudit(user.ID, user.Username, "approval_confirm", "approval", strconv.FormatInt(a.ID, 10),
+			"Confirmed, awaiting second admin: "+a.Summary, "success", r.RemoteAddr)
+		a.ConfirmationToken = ""
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a)
+		return
+	}
+
+	s.runApproval(w, r, a, "pending")
+}
+
+// approveApproval lets an admin other than the requester approve a
+// confirmed request, which then runs
+func (s *Server) approveApproval(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.loadApproval(w, r)
+	if !ok {
+		return
+	}
+	if GetUser(r.Context()).ID == a.RequestedByID {
+		http.Error(w, "A different admin must approve this action", http.StatusForbidden)
+		return
+	}
+	if a.Status != "awaiting_approval" {
+		http.Error(w, "Approval request is "+a.Status, http.StatusConflict)
+		return
+	}
+	s.runApproval(w, r, a, "awaiting_approval")
+}
+
+// rejectApproval rejects a request that hasn't run yet; requesters can
+// also use it to cancel their own
+func (s *Server) rejectApproval(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.loadApproval(w, r)
+	if !ok {
+		return
+	}
+	if a.Status != "pending" && a.Status != "awaiting_approval" {
+		http.Error(w, "Approval request is "+a.Status, http.StatusConflict)
+		return
+	}
+
+	user := GetUser(r.Context())
+	if !s.transitionApproval(w, &a, a.Status, "rejected", user.Username, "") {
+		return
+	}
+	s.logAudit(user.ID, user.Username, "approval_reject", "approval", strconv.FormatInt(a.ID, 10),
+		"Rejected: "+a.Summary, "success", r.RemoteAddr)
+
+	a.ConfirmationToken = ""
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+// transitionApproval moves a request from one status to another. The
+// update only matches the expected status, so two admins acting at once
+// can't both run the action.
+func (s *Server) transitionApproval(w http.ResponseWriter, a *ActionApproval, from, to, decidedBy, result string) bool {
+	var decider, decidedAt interface{}
+	if decidedBy != "" {
+		now := time.Now().UTC().Truncate(time.Second)
+		a.DecidedBy, a.DecidedAt = decidedBy, &now
+		decider, decidedAt = decidedBy, now.Format(time.RFC3339)
+	}
+	res, err := s.db.Exec(`
+		UPDATE action_approvals SET status = ?, decided_by = COALESCE(?, decided_by),
+			decided_at = COALESCE(?, decided_at), result = ?
+		WHERE id = ? AND status = ? AND expires_at > ?
+	`, to, decider, decidedAt, result, a.ID, from, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		http.Error(w, "Failed to update approval request", http.StatusInternalServerError)
+		return false
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Approval request has changed or expired", http.StatusConflict)
+		return false
+	}
+	a.Status, a.Result = to, result
+	return true
+}
+
+// runApproval claims a request and carries out its action
+func (s *Server) runApproval(w http.ResponseWriter, r *http.Request, a ActionApproval, from string) {
+	user := GetUser(r.Context())
+	if !s.transitionApproval(w, &a, from, "executing", user.Username, "") {
+		return
+	}
+
+	status, result := "executed", "success"
+	if err := s.executeApproval(a); err != nil {
+		log.Error().Err(err).Str("action", a.Action).Msg("Approved action failed")
+		status, result = "failed", err.Error()
+	}
+	s.db.Exec(`UPDATE action_approvals SET status = ?, result = ? WHERE id = ?`, status, result, a.ID)
+	a.Status, a.Result = status, result
+
+	auditStatus := "success"
+	if status == "failed" {
+		auditStatus = "failure"
+	}
+	s.logAudit(user.ID, user.Username, a.Action, "approval", strconv.FormatInt(a.ID, 10),
+		fmt.Sprintf("%s (requested by %s)", a.Summary, a.RequestedBy), auditStatus, r.RemoteAddr)
+	if status == "executed" && (a.Action == approvalQueuePurge || a.Action == approvalQueueDeleteDeferred ||
+		a.Action == approvalQueueBulkDelete) {
+		s.notifyPrivileged(r, a.Action, fmt.Sprintf("%s (requested by %s)", a.Summary, a.RequestedBy))
+	}
+
+	a.ConfirmationToken = ""
+	w.Header().Set("Content-Type", "application/json")
+	if status == "failed" {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(a)
+}
+
+// executeApproval carries out an approved action
+func (s *Server) executeApproval(a ActionApproval) error {
+	switch a.Action {
+	case approvalQueueDeleteDeferred:
+		s.initQueueManager()
+		return queueMgr.DeleteAll("deferred")
+	case approvalQueuePurge:
+		s.initQueueManager()
+		return queueMgr.DeleteAll("")
+	case approvalQueueBulkDelete:
+		return s.deleteApprovedBulk(a.Target)
+	case approvalDomainDelete:
+		return s.deleteDomainData(a.Target)
+	case approvalServiceStop:
+		return s.beginServiceAction(a.Target, services.ActionStop, a.RequestedByID, a.RequestedBy, "")
+	case approvalServiceRestart:
+		return s.beginServiceAction(a.Target, services.ActionRestart, a.RequestedByID, a.RequestedBy, "")
+	}
+	return fmt.Errorf("unknown action: %s", a.Action)
+}
+
+// errDomainHeld is returned when a domain to be deleted has a mailbox
+// under legal hold
+var errDomainHeld = errors.New("domain has a mailbox under legal hold")
+
+// domainHeld reports whether any of a domain's mailboxes is under legal hold
+func (s *Server) domainHeld(domainID string) bool {
+	rows, err := s.db.Query(`SELECT email FROM mailboxes WHERE domain_id = ?`, domainID)
+	if err != nil {
+		return false
+	}
+	var emails []string
+	for rows.Next() {
+		var email string
+		if rows.Scan(&email) == nil {
+			emails = append(emails, email)
+		}
+	}
+	rows.Close()
+
+	for _, email := range emails {
+		if retention.MailboxHeld(s.db.DB, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteDomainData deletes a domain with its mailboxes and aliases
+func (s *Server) deleteDomainData(domainID string) error {
+	if s.domainHeld(domainID) {
+		return errDomainHeld
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		`DELETE FROM mailbox_quota WHERE mailbox_id IN (SELECT id FROM mailboxes WHERE domain_id = ?)`,
+		`DELETE FROM mail_aliases WHERE domain_id = ?`,
+		`DELETE FROM mailboxes WHERE domain_id = ?`,
+		`DELETE FROM mail_domains WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(stmt, domainID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.workers.Go("mail_sync", supervisor.Retry, s.dovecotSyncer.SyncAll)
+	return nil
+}
+
+// requestDeleteDeferred asks to delete every deferred message
+func (s *Server) requestDeleteDeferred(w http.ResponseWriter, r *http.Request) {
+	s.initQueueManager()
+	if isDryRun(r) {
+		s.writeQueueDryRun(w, "deferred", "Would delete %d deferred messages")
+		return
+	}
+	_, deferred, _, _ := queueMgr.GetQueueSummary()
+	s.requestApproval(w, r, approvalQueueDeleteDeferred, "", "DELETE",
+		fmt.Sprintf("Delete all deferred messages (%d queued)", deferred))
+}
+
+// requestPurgeQueue asks to delete every queued message
+func (s *Server) requestPurgeQueue(w http.ResponseWriter, r *http.Request) {
+	s.initQueueManager()
+	if isDryRun(r) {
+		s.writeQueueDryRun(w, "all", "Would delete %d queued messages")
+		return
+	}
+	active, deferred, hold, _ := queueMgr.GetQueueSummary()
+	s.requestApproval(w, r, approvalQueuePurge, "", "PURGE",
+		fmt.Sprintf("Purge the mail queue (%d queued)", active+deferred+hold))
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/archive"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	archiveMu       sync.Mutex
+	archiveReceiver *archive.Receiver
+
+	// archiveMonitor records deferred and bounced archive copies from the
+	// log pipeline
+	archiveMonitor *archive.Monitor
+)
+
+// startArchive starts the receiver in s3 mode and points the log monitor
+// at the archive address. The Postfix side is only rewritten when the
+// settings change (applyArchiveConfig).
+func (s *Server) startArchive() {
+	archiveMonitor = archive.NewMonitor(s.db.DB)
+	s.startArchiveReceiver()
+}
+
+// startArchiveReceiver starts the receiver if archive_mode is s3,
+// replacing a running one, and updates the monitored address
+func (s *Server) startArchiveReceiver() {
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+
+	if archiveReceiver != nil {
+		archiveReceiver.Stop()
+		archiveReceiver = nil
+	}
+
+	mode := s.db.GetSetting("archive_mode", archive.ModeOff)
+	if archiveMonitor != nil {
+		archiveMonitor.SetAddress(archive.BCCAddress(mode, s.db.GetSetting("archive_smtp_address", "")))
+	}
+	if mode != archive.ModeS3 {
+		return
+	}
+
+	receiver := archive.NewReceiver(s.db.GetSetting("archive_listen", "127.0.0.1:10027"), s.store, s.db.DB)
+	if err := receiver.Start(); err != nil {
+		log.Error().Err(err).Msg("Failed to start archive receiver")
+		return
+	}
+	archiveReceiver = receiver
+}
+
+// stopArchiveReceiver stops the receiver if it is running
+func (s *Server) stopArchiveReceiver() {
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+
+	if archiveReceiver != nil {
+		archiveReceiver.Stop()
+		archiveReceiver = nil
+	}
+}
+
+// archiveTransport returns the transport map entry that routes s3-mode
+// copies to the receiver
+func (s *Server) archiveTransport() postfix.TransportMap {
+	host, portStr, _ := net.SplitHostPort(s.db.GetSetting("archive_listen", "127.0.0.1:10027"))
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	port, _ := strconv.Atoi(portStr)
+	return postfix.TransportMap{Domain: archive.JournalDomain, NextHop: host, Port: port, Enabled: true}
+}
+
+// applyArchiveConfig brings Postfix in line with the archive settings:
+// the BCC map, the main.cf parameters that use it and, in s3 mode, the
+// transport to the receiver
+func (s *Server) applyArchiveConfig() error {
+	if err := s.dovecotSyncer.SyncArchiveBCC(); err != nil {
+		return err
+	}
+
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+
+	mode := s.db.GetSetting("archive_mode", archive.ModeOff)
+	bccMaps := ""
+	if mode != archive.ModeOff {
+		bccMaps = postfix.MapRef(postfix.MapType("archive_bcc"), s.dovecotSyncer.MapPath("archive_bcc"))
+	}
+	if err := postfixMgr.UpdateConfig(map[string]string{
+		"sender_bcc_maps":    bccMaps,
+		"recipient_bcc_maps": bccMaps,
+	}); err != nil {
+		return err
+	}
+
+	maps, err := postfixMgr.GetTransportMaps()
+	if err != nil {
+		return err
+	}
+	exists := false
+	for _, tm := range maps {
+		if tm.Domain == archive.JournalDomain {
+			exists = true
+		}
+	}
+	switch {
+	case mode == archive.ModeS3 && exists:
+		err = postfixMgr.UpdateTransportMap(archive.JournalDomain, s.archiveTransport())
+	case mode == archive.ModeS3:
+		err = postfixMgr.AddTransportMap(s.archiveTransport())
+	case exists:
+		err = postfixMgr.DeleteTransportMap(archive.JournalDomain)
+	}
+	if err != nil {
+		return err
+	}
+
+	return postfixMgr.Reload()
+}
+
+// onArchiveSettingsChanged restarts the receiver and rewrites the Postfix
+// configuration when an archive_ setting changes
+func (s *Server) onArchiveSettingsChanged(changed map[string]string) {
+	for key := range changed {
+		if strings.HasPrefix(key, "archive_") && key != "archive_retention_days" {
+			s.startArchiveReceiver()
+			if err := s.applyArchiveConfig(); err != nil {
+				log.Error().Err(err).Msg("Failed to apply archive configuration")
+			}
+			return
+		}
+	}
+}
+
+// getArchiveStatus reports the archive configuration, the receiver's
+// counters and recent delivery failures
+func (s *Server) getArchiveStatus(w http.ResponseWriter, r *http.Request) {
+	mode := s.db.GetSetting("archive_mode", archive.ModeOff)
+
+	domains := []string{}
+	if rows, err := s.db.Query(`SELECT domain FROM mail_domains WHERE archive_enabled = TRUE ORDER BY domain`); err == nil {
+		for rows.Next() {
+			var d string
+			if rows.Scan(&d) == nil {
+				domains = append(domains, d)
+			}
+		}
+		rows.Close()
+	}
+
+	status := map[string]interface{}{
+		"mode":            mode,
+		"address":         archive.BCCAddress(mode, s.db.GetSetting("archive_smtp_address", "")),
+		"domains":         domains,
+		"receiverRunning": false,
+	}
+
+	archiveMu.Lock()
+	if archiveReceiver != nil {
+		stored, last := archiveReceiver.Stats()
+		status["receiverRunning"] = true
+		status["stored"] = stored
+		if !last.IsZero() {
+			status["lastStoredAt"] = last.Format(time.RFC3339)
+		}
+	}
+	archiveMu.Unlock()
+
+	events, err := archive.RecentEvents(s.db.DB, 50)
+	if err != nil {
+		http.Error(w, "Failed to load archive events", http.StatusInternalServerError)
+		return
+	}
+	status["failures"] = events
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
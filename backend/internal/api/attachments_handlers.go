@@ -0,0 +1,411 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/mail"
+	"github.com/postfixrelay/postfixrelay/internal/scan"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxAttachmentSize is the largest single webmail attachment accepted
+	maxAttachmentSize = 25 << 20
+
+	// attachmentTTL is how long an uploaded attachment is kept if it is
+	// never sent
+	attachmentTTL = 24 * time.Hour
+)
+
+// Attachment is an uploaded webmail attachment awaiting send
+type Attachment struct {
+	ID          string       `json:"id"`
+	Filename    string       `json:"filename"`
+	ContentType string       `json:"contentType"`
+	Size        int64        `json:"size"`
+	Scan        *scan.Result `json:"scan,omitempty"`
+	CreatedAt   time.Time    `json:"createdAt"`
+}
+
+// attachmentKey returns the object storage key for a staged attachment
+func attachmentKey(id string) string {
+	return "attachments/" + id
+}
+
+// newAttachmentID returns a random attachment ID that is safe to use as a
+// file name
+func newAttachmentID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newScanner builds the malware scanner from the scan_* settings. A nil
+// scanner means scanning is disabled.
+func (s *Server) newScanner() (scan.Scanner, error) {
+	return scan.New(scan.Config{
+		Engine:  s.db.GetSetting("scan_engine", "none"),
+		Address: s.db.GetSetting("scan_address", ""),
+	})
+}
+
+// scanContent scans data with the configured engine. Configuration errors
+// are reported as a scan error so the scan_on_error policy applies.
+func (s *Server) scanContent(ctx context.Context, data []byte) *scan.Result {
+	scanner, err := s.newScanner()
+	if err != nil {
+		return &scan.Result{Status: scan.StatusError, Engine: s.db.GetSetting("scan_engine", "none"), Error: err.Error(), ScannedAt: time.Now().UTC()}
+	}
+	return scan.Run(ctx, scanner, bytes.NewReader(data))
+}
+
+// scanBlocks reports whether a scan result should stop the content being
+// accepted, according to the scan_action and scan_on_error settings
+func (s *Server) scanBlocks(result *scan.Result) bool {
+	switch result.Status {
+	case scan.StatusInfected:
+		return s.db.GetSetting("scan_action", "reject") == "reject"
+	case scan.StatusError:
+		return s.db.GetSetting("scan_on_error", "allow") == "reject"
+	}
+	return false
+}
+
+// recordScanResult stores a scan result and returns its ID
+func (s *Server) recordScanResult(source, reference, owner, filename string, result *scan.Result) int64 {
+	res, err := s.db.Exec(`
+		INSERT INTO scan_results (source, reference, owner_email, filename, status, signature, engine, error, scanned_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, source, reference, owner, filename, result.Status, result.Signature, result.Engine, result.Error, result.ScannedAt)
+	if err != nil {
+		log.Error().Err(err).Str("reference", reference).Msg("Failed to record scan result")
+		return 0
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+// uploadAttachment stages a webmail attachment after scanning it
+func (s *Server) uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	session := getMailSession(r.Context())
+	if session == nil {
+		http.Error(w, "Session not found", http.StatusUnauthorized)
+		return
+	}
+
+	s.cleanupAttachments(r.Context())
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		http.Error(w, "Attachment too large or invalid upload", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "File is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAttachmentSize+1))
+	if err != nil {
+		http.Error(w, "Failed to read attachment", http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxAttachmentSize {
+		http.Error(w, fmt.Sprintf("Attachment exceeds the %d MB limit", maxAttachmentSize>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	filename := filepath.Base(header.Filename)
+	contentType := header.Header.Get("Content-Type")
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		contentType = "application/octet-stream"
+	}
+
+	id, err := newAttachmentID()
+	if err != nil {
+		http.Error(w, "Failed to store attachment", http.StatusInternalServerError)
+		return
+	}
+
+	result := s.scanContent(r.Context(), data)
+	scanID := s.recordScanResult("webmail_upload", id, session.Email, filename, result)
+
+	if s.scanBlocks(result) {
+		log.Warn().
+			Str("owner", session.Email).
+			Str("filename", filename).
+			Str("status", result.Status).
+			Str("signature", result.Signature).
+			Msg("Attachment rejected by malware scan")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		msg := "Attachment rejected: malware detected"
+		if result.Status == scan.StatusError {
+			msg = "Attachment rejected: it could not be scanned"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": msg,
+			"scan":  result,
+		})
+		return
+	}
+
+	store, err := s.store()
+	if err != nil {
+		log.Error().Err(err).Msg("Object storage unavailable")
+		http.Error(w, "Failed to store attachment", http.StatusInternalServerError)
+		return
+	}
+	key := attachmentKey(id)
+	if err := store.Put(r.Context(), key, bytes.NewReader(data), contentType); err != nil {
+		log.Error().Err(err).Msg("Failed to write attachment")
+		http.Error(w, "Failed to store attachment", http.StatusInternalServerError)
+		return
+	}
+
+	var scanRef interface{}
+	if scanID > 0 {
+		scanRef = scanID
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO mail_attachments (id, owner_email, filename, content_type, size, storage_path, scan_result_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, id, session.Email, filename, contentType, len(data), key, scanRef)
+	if err != nil {
+		store.Delete(r.Context(), key)
+		http.Error(w, "Failed to store attachment", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(Attachment{
+		ID:          id,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Scan:        result,
+		CreatedAt:   time.Now().UTC(),
+	})
+}
+
+// deleteAttachment removes an uploaded attachment that will not be sent
+func (s *Server) deleteAttachment(w http.ResponseWriter, r *http.Request) {
+	session := getMailSession(r.Context())
+	if session == nil {
+		http.Error(w, "Session not found", http.StatusUnauthorized)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	var key string
+	err := s.db.QueryRow(`SELECT storage_path FROM mail_attachments WHERE id = ? AND owner_email = ?`,
+		id, session.Email).Scan(&key)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	s.db.Exec(`DELETE FROM mail_attachments WHERE id = ?`, id)
+	s.removeObject(r.Context(), key)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resolveAttachments loads the uploaded attachments referenced by a message
+// into msg.Files. Attachments must belong to the sender, and ones that were
+// flagged as infected are refused while the reject policy is active.
+func (s *Server) resolveAttachments(ctx context.Context, owner string, msg *mail.ComposeMessage) error {
+	msg.Files = nil
+	if len(msg.Attachments) == 0 {
+		return nil
+	}
+
+	store, err := s.store()
+	if err != nil {
+		return fmt.Errorf("Attachment storage is unavailable")
+	}
+
+	for _, id := range msg.Attachments {
+		var filename, contentType, key string
+		var status sql.NullString
+		err := s.db.QueryRow(`
+			SELECT a.filename, a.content_type, a.storage_path, r.status
+			FROM mail_attachments a
+			LEFT JOIN scan_results r ON r.id = a.scan_result_id
+			WHERE a.id = ? AND a.owner_email = ?
+		`, id, owner).Scan(&filename, &contentType, &key, &status)
+		if err != nil {
+			return fmt.Errorf("Attachment %s not found", id)
+		}
+		if status.String == scan.StatusInfected && s.db.GetSetting("scan_action", "reject") == "reject" {
+			return fmt.Errorf("Attachment %s is infected and cannot be sent", filename)
+		}
+
+		data, err := readObject(ctx, store, key)
+		if err != nil {
+			return fmt.Errorf("Attachment %s is no longer available", filename)
+		}
+		msg.Files = append(msg.Files, mail.AttachmentFile{
+			Filename:    filename,
+			ContentType: contentType,
+			Data:        data,
+		})
+	}
+	return nil
+}
+
+// finishAttachments links the scan results of sent attachments to the
+// message and removes the staged files
+func (s *Server) finishAttachments(ctx context.Context, owner, messageID string, ids []string) {
+	for _, id := range ids {
+		var key string
+		var scanID sql.NullInt64
+		err := s.db.QueryRow(`SELECT storage_path, scan_result_id FROM mail_attachments WHERE id = ? AND owner_email = ?`,
+			id, owner).Scan(&key, &scanID)
+		if err != nil {
+			continue
+		}
+		if scanID.Valid {
+			s.db.Exec(`UPDATE scan_results SET message_id = ? WHERE id = ?`, messageID, scanID.Int64)
+		}
+		s.db.Exec(`DELETE FROM mail_attachments WHERE id = ?`, id)
+		s.removeObject(ctx, key)
+	}
+}
+
+// cleanupAttachments removes uploads that were never sent
+func (s *Server) cleanupAttachments(ctx context.Context) {
+	cutoff := time.Now().Add(-attachmentTTL).UTC()
+	rows, err := s.db.Query(`SELECT id, storage_path FROM mail_attachments WHERE created_at < ?`, cutoff)
+	if err != nil {
+		return
+	}
+
+	var ids, keys []string
+	for rows.Next() {
+		var id, key string
+		if err := rows.Scan(&id, &key); err == nil {
+			ids = append(ids, id)
+			keys = append(keys, key)
+		}
+	}
+	rows.Close()
+
+	for i, id := range ids {
+		s.db.Exec(`DELETE FROM mail_attachments WHERE id = ?`, id)
+		s.removeObject(ctx, keys[i])
+	}
+}
+
+// scanQueuedMessage scans a held message before it is released. It returns
+// false, having written the response, if the release must not go ahead.
+func (s *Server) scanQueuedMessage(w http.ResponseWriter, r *http.Request, queueID string) bool {
+	if s.db.GetSetting("scan_queue_release", "false") != "true" {
+		return true
+	}
+
+	content, err := queueMgr.GetMessageContent(queueID)
+	var result *scan.Result
+	if err != nil {
+		result = &scan.Result{Status: scan.StatusError, Engine: s.db.GetSetting("scan_engine", "none"), Error: err.Error(), ScannedAt: time.Now().UTC()}
+	} else {
+		result = s.scanContent(r.Context(), content)
+	}
+	s.recordScanResult("queue_release", queueID, "", "", result)
+
+	if !s.scanBlocks(result) {
+		return true
+	}
+
+	if u := GetUser(r.Context()); u != nil {
+		s.logAudit(u.ID, u.Username, "queue_release", "message", queueID,
+			"Release blocked by malware scan ("+result.Status+")", "failure", r.RemoteAddr)
+	}
+
+	status := http.StatusConflict
+	msg := "message is infected and remains on hold"
+	if result.Status == scan.StatusError {
+		status = http.StatusBadGateway
+		msg = "message could not be scanned and remains on hold"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": msg,
+		"scan":  result,
+	})
+	return false
+}
+
+// listScanResults returns recent scan results, optionally filtered by
+// status, source or message ID
+func (s *Server) listScanResults(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT id, source, reference, COALESCE(owner_email, ''), COALESCE(filename, ''), status,
+			COALESCE(signature, ''), engine, COALESCE(error, ''), COALESCE(message_id, ''), scanned_at
+		FROM scan_results WHERE 1=1`
+	var args []interface{}
+	for param, column := range map[string]string{"status": "status", "source": "source", "messageId": "message_id"} {
+		if v := r.URL.Query().Get(param); v != "" {
+			query += " AND " + column + " = ?"
+			args = append(args, v)
+		}
+	}
+	query += " ORDER BY scanned_at DESC LIMIT 200"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	results := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id int64
+		var source, reference, owner, filename, status, signature, engine, scanErr, messageID string
+		var scannedAt time.Time
+		if err := rows.Scan(&id, &source, &reference, &owner, &filename, &status,
+			&signature, &engine, &scanErr, &messageID, &scannedAt); err != nil {
+			continue
+		}
+		results = append(results, map[string]interface{}{
+			"id":        id,
+			"source":    source,
+			"reference": reference,
+			"owner":     owner,
+			"filename":  filename,
+			"status":    status,
+			"signature": signature,
+			"engine":    engine,
+			"error":     scanErr,
+			"messageId": messageID,
+			"scannedAt": scannedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
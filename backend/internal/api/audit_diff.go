@@ -0,0 +1,157 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// AuditChange is one field changed by an audited action. Before is empty for
+// added fields and After for removed ones.
+type AuditChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// auditDiffPermissions is the permission needed to see the diff of an audit
+// entry, by resource type. Entries of other types have no diff.
+var auditDiffPermissions = map[string]Permission{
+	"config":          PermViewConfig,
+	"transport_map":   PermViewConfig,
+	"sender_relay":    PermViewConfig,
+	"sender_class":    PermViewConfig,
+	"mail_alias":      PermViewMail,
+	"domain_defaults": PermViewMail,
+}
+
+// auditFields flattens a value to field paths and their values through its
+// JSON form, e.g. "relay.relayhost". A nil value has no fields.
+func auditFields(v interface{}) map[string]string {
+	fields := map[string]string{}
+	if v == nil {
+		return fields
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return fields
+	}
+
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, value := range v {
+				if prefix != "" {
+					key = prefix + "." + key
+				}
+				walk(key, value)
+			}
+		case nil:
+		case string:
+			if v != "" {
+				fields[prefix] = v
+			}
+		default:
+			fields[prefix] = fmt.Sprint(v)
+		}
+	}
+	walk("", decoded)
+	return fields
+}
+
+// auditDiff returns the fields that differ between before and after, either
+// of which may be nil for a created or deleted resource
+func auditDiff(before, after interface{}) []AuditChange {
+	b, a := auditFields(before), auditFields(after)
+	changes := []AuditChange{}
+	for field, value := range b {
+		if a[field] != value {
+			changes = append(changes, AuditChange{Field: field, Before: value, After: a[field]})
+		}
+	}
+	for field, value := range a {
+		if _, ok := b[field]; !ok {
+			changes = append(changes, AuditChange{Field: field, After: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// logAuditDiff records a successful change with the fields it changed
+func (s *Server) logAuditDiff(user *User, action, resourceType, resourceID, summary string, changes []AuditChange, r *http.Request) {
+	diff, _ := json.Marshal(changes)
+	_, err := s.db.Exec(`
+		INSERT INTO audit_log (user_id, username, action, resource_type, resource_id, summary, diff, status, ip_address, user_agent, tenant_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 'success', ?, ?, NULLIF(?, 0))
+	`, user.ID, user.Username, action, resourceType, resourceID, summary, string(diff), r.RemoteAddr, r.UserAgent(), user.TenantID)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to write audit log")
+	}
+}
+
+// getAuditEntry returns one audit entry with its diff, if the user's role
+// may see the changed resource
+func (s *Server) getAuditEntry(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	id := chi.URLParam(r, "id")
+
+	var entryID int64
+	var userID sql.NullInt64
+	var timestamp, action string
+	var username, resourceType, resourceID, summary, details, diff, status, errorMsg, ipAddress, userAgent sql.NullString
+	err := s.db.QueryRow(`
+		SELECT id, timestamp, user_id, username, action, resource_type, resource_id, summary, details, diff,
+			status, error_message, ip_address, user_agent
+		FROM audit_log WHERE id = ?
+	`, id).Scan(&entryID, &timestamp, &userID, &username, &action, &resourceType, &resourceID, &summary, &details, &diff,
+		&status, &errorMsg, &ipAddress, &userAgent)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Audit entry not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	entry := map[string]interface{}{
+		"id":           entryID,
+		"timestamp":    timestamp,
+		"userId":       userID.Int64,
+		"username":     username.String,
+		"action":       action,
+		"resourceType": resourceType.String,
+		"resourceId":   resourceID.String,
+		"summary":      summary.String,
+		"details":      details.String,
+		"status":       status.String,
+		"errorMessage": errorMsg.String,
+		"ipAddress":    ipAddress.String,
+		"userAgent":    userAgent.String,
+	}
+	if diff.Valid && diff.String != "" {
+		perm, ok := auditDiffPermissions[resourceType.String]
+		if ok && HasPermission(user.Role, perm) {
+			var changes []AuditChange
+			if err := json.Unmarshal([]byte(diff.String), &changes); err == nil {
+				entry["diff"] = changes
+			}
+		} else {
+			entry["diffHidden"] = true
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
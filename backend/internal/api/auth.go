@@ -6,7 +6,6 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -31,6 +30,7 @@ type userResponse struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Role     string `json:"role"`
+	TenantID int64  `json:"tenantId,omitempty"`
 }
 
 func (s *Server) login(w http.ResponseWriter, r *http.Request) {
@@ -46,27 +46,34 @@ func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 		Username            string
 		Email               string
 		Role                string
+		TenantID            int64
+		TenantActive        bool
 		PasswordHash        string
 		FailedLoginAttempts int
 		LockedUntil         *time.Time
 	}
 
 	err := s.db.QueryRow(`
-		SELECT id, username, email, role, password_hash, failed_login_attempts, locked_until
-		FROM users WHERE username = ?
+		SELECT u.id, u.username, u.email, u.role, COALESCE(u.tenant_id, 0), COALESCE(t.active, TRUE),
+			u.password_hash, u.failed_login_attempts, u.locked_until
+		FROM users u
+		LEFT JOIN tenants t ON u.tenant_id = t.id
+		WHERE u.username = ?
 	`, req.Username).Scan(
-		&user.ID, &user.Username, &user.Email, &user.Role,
+		&user.ID, &user.Username, &user.Email, &user.Role, &user.TenantID, &user.TenantActive,
 		&user.PasswordHash, &user.FailedLoginAttempts, &user.LockedUntil,
 	)
 
 	if err != nil {
 		log.Debug().Err(err).Str("username", req.Username).Msg("login failed: user not found")
+		s.recordLogin(r, loginAdmin, 0, req.Username, "local", "unknown_user")
 		http.Error(w, "invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
 	// Check if account is locked
 	if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+		s.recordLogin(r, loginAdmin, user.ID, user.Username, "local", "locked")
 		http.Error(w, "account locked", http.StatusUnauthorized)
 		return
 	}
@@ -81,10 +88,18 @@ func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 		`, user.ID)
 
 		log.Debug().Str("username", req.Username).Msg("login failed: invalid password")
+		s.recordLogin(r, loginAdmin, user.ID, user.Username, "local", "bad_password")
 		http.Error(w, "invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
+	// Users of a suspended tenant cannot sign in
+	if !user.TenantActive {
+		s.recordLogin(r, loginAdmin, user.ID, user.Username, "local", "disabled")
+		http.Error(w, "account disabled", http.StatusUnauthorized)
+		return
+	}
+
 	// Generate session token
 	tokenBytes := make([]byte, 32)
 	if _, err := rand.Read(tokenBytes); err != nil {
@@ -123,17 +138,17 @@ func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 	`, user.ID)
 
 	// Log successful login
+	s.recordLogin(r, loginAdmin, user.ID, user.Username, "local", "")
 	s.auditLog(user.ID, user.Username, "login", "user", "", "User logged in", "success", "", r)
 
 	// Set httpOnly session cookie
-	isSecure := os.Getenv("ENV") == "production"
 	http.SetCookie(w, &http.Cookie{
 		Name:     sessionCookieName,
 		Value:    token,
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   isSecure,
-		SameSite: http.SameSiteStrictMode,
+		Secure:   s.cookieSecure(r),
+		SameSite: s.cookieSameSite(),
 		MaxAge:   int(s.cfg.SessionTimeoutHours * 3600),
 	})
 
@@ -144,6 +159,7 @@ func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 			Username: user.Username,
 			Email:    user.Email,
 			Role:     user.Role,
+			TenantID: user.TenantID,
 		},
 	}
 
@@ -167,8 +183,8 @@ func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
 		Value:    "",
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   os.Getenv("ENV") == "production",
-		SameSite: http.SameSiteStrictMode,
+		Secure:   s.cookieSecure(r),
+		SameSite: s.cookieSameSite(),
 		MaxAge:   -1, // Delete cookie immediately
 	})
 
@@ -189,6 +205,7 @@ func (s *Server) me(w http.ResponseWriter, r *http.Request) {
 		Username: user.Username,
 		Email:    user.Email,
 		Role:     user.Role,
+		TenantID: user.TenantID,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -257,9 +274,9 @@ func (s *Server) changePassword(w http.ResponseWriter, r *http.Request) {
 // Helper to write audit log entries
 func (s *Server) auditLog(userID int64, username, action, resourceType, resourceID, summary, status, errorMsg string, r *http.Request) {
 	_, err := s.db.Exec(`
-		INSERT INTO audit_log (user_id, username, action, resource_type, resource_id, summary, status, error_message, ip_address, user_agent)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, userID, username, action, resourceType, resourceID, summary, status, errorMsg, r.RemoteAddr, r.UserAgent())
+		INSERT INTO audit_log (user_id, username, action, resource_type, resource_id, summary, status, error_message, ip_address, user_agent, tenant_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, (SELECT tenant_id FROM users WHERE id = ?))
+	`, userID, username, action, resourceType, resourceID, summary, status, errorMsg, r.RemoteAddr, r.UserAgent(), userID)
 
 	if err != nil {
 		log.Error().Err(err).Msg("failed to write audit log")
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/postfixrelay/postfixrelay/internal/autoconfig"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/rs/zerolog/log"
+)
+
+// mailClientSettings returns the servers advertised to mail clients for a
+// domain, or false if the domain isn't an active managed domain. Without a
+// mail_client_hostname the Postfix myhostname is advertised.
+func (s *Server) mailClientSettings(domain string) (autoconfig.Settings, bool) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	var active bool
+	if err := s.db.QueryRow(`SELECT active FROM mail_domains WHERE domain = ?`, domain).Scan(&active); err != nil || !active {
+		return autoconfig.Settings{}, false
+	}
+
+	hostname := s.db.GetSetting("mail_client_hostname", "")
+	if hostname == "" {
+		if postfixMgr == nil {
+			postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+		}
+		if cfg, err := postfixMgr.ReadConfig(); err == nil {
+			hostname = cfg.General.Myhostname
+		}
+	}
+	if hostname == "" {
+		hostname = "mail." + domain
+	}
+
+	endpoint := func(proto, defaultPort, defaultSecurity string) autoconfig.Endpoint {
+		port, err := strconv.Atoi(s.db.GetSetting("mail_client_"+proto+"_port", defaultPort))
+		if err != nil {
+			port, _ = strconv.Atoi(defaultPort)
+		}
+		return autoconfig.Endpoint{
+			Hostname: hostname,
+			Port:     port,
+			Security: s.db.GetSetting("mail_client_"+proto+"_tls", defaultSecurity),
+		}
+	}
+
+	return autoconfig.Settings{
+		Domain: domain,
+		IMAP:   endpoint("imap", "993", autoconfig.SecuritySSL),
+		SMTP:   endpoint("smtp", "587", autoconfig.SecurityStartTLS),
+	}, true
+}
+
+// getAutoconfig serves Thunderbird's config-v1.1.xml. The domain comes from
+// the emailaddress parameter, or from the autoconfig.<domain> host the
+// client asked.
+func (s *Server) getAutoconfig(w http.ResponseWriter, r *http.Request) {
+	domain := ""
+	if email := r.URL.Query().Get("emailaddress"); email != "" {
+		if at := strings.LastIndex(email, "@"); at >= 0 {
+			domain = email[at+1:]
+		}
+	} else {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		domain = strings.TrimPrefix(host, "autoconfig.")
+	}
+
+	settings, ok := s.mailClientSettings(domain)
+	if !ok {
+		http.Error(w, "Domain not found", http.StatusNotFound)
+		return
+	}
+	data, err := autoconfig.Thunderbird(settings)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to render autoconfig")
+		http.Error(w, "Failed to render autoconfig", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(data)
+}
+
+// postAutodiscover answers an Outlook autodiscover request for an address
+// in a managed domain
+func (s *Server) postAutodiscover(w http.ResponseWriter, r *http.Request) {
+	email, err := autoconfig.ParseAutodiscoverRequest(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	settings, ok := s.mailClientSettings(email[at+1:])
+	if !ok {
+		http.Error(w, "Domain not found", http.StatusNotFound)
+		return
+	}
+	data, err := autoconfig.Outlook(settings, email)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to render autodiscover response")
+		http.Error(w, "Failed to render autodiscover response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(data)
+}
@@ -0,0 +1,15 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// getBackgroundTasks returns the run and failure counters of background
+// work started by handlers, such as Dovecot syncs and Sent folder saves
+func (s *Server) getBackgroundTasks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tasks": s.workers.Stats(),
+	})
+}
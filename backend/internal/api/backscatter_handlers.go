@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/rs/zerolog/log"
+)
+
+// loadBackscatterDomains returns the domains whose recipients are verified
+// before mail for them is accepted
+func (s *Server) loadBackscatterDomains() ([]string, error) {
+	rows, err := s.db.Query(`SELECT domain FROM backscatter_domains ORDER BY domain`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	domains := []string{}
+	for rows.Next() {
+		var d string
+		if rows.Scan(&d) == nil {
+			domains = append(domains, d)
+		}
+	}
+	return domains, nil
+}
+
+// syncBackscatterDomains rewrites the backscatter map from the database
+func (s *Server) syncBackscatterDomains() error {
+	domains, err := s.loadBackscatterDomains()
+	if err != nil {
+		return err
+	}
+	return postfixMgr.SaveBackscatterDomains(domains)
+}
+
+// onBackscatterSettingsChanged sets soft_bounce in main.cf when the setting
+// changes
+func (s *Server) onBackscatterSettingsChanged(changed map[string]string) {
+	value, ok := changed["soft_bounce"]
+	if !ok {
+		return
+	}
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+	err := postfixMgr.SetSoftBounce(value == "true")
+	if err == nil {
+		err = postfixMgr.Reload()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to apply soft_bounce")
+	}
+}
+
+// getBackscatter reports the backscatter settings: soft_bounce, the bounce
+// templates (custom and default) and the protected domains
+func (s *Server) getBackscatter(w http.ResponseWriter, r *http.Request) {
+	templates, err := s.loadBounceTemplates()
+	if err != nil {
+		http.Error(w, "Failed to load bounce templates", http.StatusInternalServerError)
+		return
+	}
+	domains, err := s.loadBackscatterDomains()
+	if err != nil {
+		http.Error(w, "Failed to load backscatter domains", http.StatusInternalServerError)
+		return
+	}
+
+	defaults := map[string]postfix.BounceTemplate{}
+	for _, class := range postfix.BounceTemplateClasses {
+		defaults[class] = postfix.DefaultBounceTemplate(class)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"softBounce": s.db.GetSetting("soft_bounce", "false") == "true",
+		"templates":  templates,
+		"defaults":   defaults,
+		"classes":    postfix.BounceTemplateClasses,
+		"domains":    domains,
+	})
+}
+
+// addBackscatterDomain turns on recipient verification for a domain, so
+// mail to its unknown recipients is rejected rather than bounced
+func (s *Server) addBackscatterDomain(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Domain string `json:"domain"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Domain = strings.ToLower(strings.TrimSpace(req.Domain))
+
+	v := NewValidator()
+	v.ValidateRequired("domain", req.Domain)
+	v.ValidateDomain("domain", req.Domain)
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	username := ""
+	if u := GetUser(r.Context()); u != nil {
+		username = u.Username
+	}
+	if _, err := s.db.Exec(`INSERT OR IGNORE INTO backscatter_domains (domain, created_by) VALUES (?, ?)`, req.Domain, username); err != nil {
+		http.Error(w, "Failed to save backscatter domain", http.StatusInternalServerError)
+		return
+	}
+	if err := s.applyBackscatterDomains(); err != nil {
+		http.Error(w, "Failed to apply backscatter protection: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if u := GetUser(r.Context()); u != nil {
+		s.logAudit(u.ID, u.Username, "backscatter_domain_add", "domain", req.Domain,
+			"Enabled backscatter protection for "+req.Domain, "success", r.RemoteAddr)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// deleteBackscatterDomain turns recipient verification off for a domain
+func (s *Server) deleteBackscatterDomain(w http.ResponseWriter, r *http.Request) {
+	domain := strings.ToLower(chi.URLParam(r, "domain"))
+
+	result, err := s.db.Exec(`DELETE FROM backscatter_domains WHERE domain = ?`, domain)
+	if err != nil {
+		http.Error(w, "Failed to delete backscatter domain", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Domain not found", http.StatusNotFound)
+		return
+	}
+	if err := s.applyBackscatterDomains(); err != nil {
+		http.Error(w, "Failed to apply backscatter protection: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if u := GetUser(r.Context()); u != nil {
+		s.logAudit(u.ID, u.Username, "backscatter_domain_delete", "domain", domain,
+			"Disabled backscatter protection for "+domain, "success", r.RemoteAddr)
+	}
+
+	s.writeDeleted(w, r, trashBackscatterEntry, domain, map[string]string{"domain": domain})
+}
+
+// applyBackscatterDomains rewrites the backscatter map and reloads Postfix
+func (s *Server) applyBackscatterDomains() error {
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+	if err := s.syncBackscatterDomains(); err != nil {
+		return err
+	}
+	return postfixMgr.Reload()
+}
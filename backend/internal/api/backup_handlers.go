@@ -0,0 +1,211 @@
+package api
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// Object storage prefixes for generated artifacts
+const (
+	backupPrefix    = "backups/"
+	logExportPrefix = "exports/logs/"
+)
+
+// artifactKey joins a prefix and a user-supplied object name, rejecting
+// names that would escape the prefix
+func artifactKey(prefix, name string) (string, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("invalid name")
+	}
+	return storage.CleanKey(prefix + name)
+}
+
+// listArtifacts writes the objects under prefix as JSON, with keys shown
+// relative to the prefix
+func (s *Server) listArtifacts(w http.ResponseWriter, r *http.Request, prefix string) {
+	store, err := s.store()
+	if err != nil {
+		http.Error(w, "Object storage unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	objects, err := store.List(r.Context(), prefix)
+	if err != nil {
+		log.Error().Err(err).Str("prefix", prefix).Msg("Failed to list objects")
+		http.Error(w, "Failed to list objects", http.StatusBadGateway)
+		return
+	}
+	for i := range objects {
+		objects[i].Key = strings.TrimPrefix(objects[i].Key, prefix)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"backend": store.Name(),
+		"objects": objects,
+	})
+}
+
+// serveArtifact streams an object as a download
+func (s *Server) serveArtifact(w http.ResponseWriter, r *http.Request, prefix, contentType string) {
+	name := chi.URLParam(r, "name")
+	key, err := artifactKey(prefix, name)
+	if err != nil {
+		http.Error(w, "Invalid name", http.StatusBadRequest)
+		return
+	}
+
+	store, err := s.store()
+	if err != nil {
+		http.Error(w, "Object storage unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	rc, err := store.Get(r.Context(), key)
+	if err == storage.ErrNotFound {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Error().Err(err).Str("key", key).Msg("Failed to read object")
+		http.Error(w, "Failed to read object", http.StatusBadGateway)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", "attachment; filename="+name)
+	io.Copy(w, rc)
+}
+
+// createBackup snapshots the database with VACUUM INTO and stores it
+// gzip-compressed in object storage
+func (s *Server) createBackup(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+
+	store, err := s.store()
+	if err != nil {
+		http.Error(w, "Object storage unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	name := fmt.Sprintf("postfixrelay-%s.db.gz", time.Now().UTC().Format("20060102-150405"))
+	size, err := s.writeBackup(r.Context(), store, backupPrefix+name)
+	if err != nil {
+		log.Error().Err(err).Msg("Backup failed")
+		s.auditLog(user.ID, user.Username, "backup_create", "backup", name, "Database backup", "failure", err.Error(), r)
+		http.Error(w, "Backup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "backup_create", "backup", name,
+		fmt.Sprintf("Database backup to %s storage", store.Name()), "success", "", r)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(storage.Object{Key: name, Size: size, LastModified: time.Now().UTC()})
+}
+
+// writeBackup writes a compressed database snapshot to key and returns the
+// compressed size
+func (s *Server) writeBackup(ctx context.Context, store storage.Store, key string) (int64, error) {
+	tmpDir, err := os.MkdirTemp("", "psfx-backup-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	snapshot := filepath.Join(tmpDir, "snapshot.db")
+	if _, err := s.db.ExecContext(ctx, `VACUUM INTO ?`, snapshot); err != nil {
+		return 0, fmt.Errorf("failed to snapshot database: %w", err)
+	}
+
+	src, err := os.Open(snapshot)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	compressed := filepath.Join(tmpDir, "snapshot.db.gz")
+	dst, err := os.Create(compressed)
+	if err != nil {
+		return 0, err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return 0, err
+	}
+	if err := dst.Close(); err != nil {
+		return 0, err
+	}
+
+	f, err := os.Open(compressed)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := store.Put(ctx, key, f, "application/gzip"); err != nil {
+		return 0, fmt.Errorf("failed to upload backup: %w", err)
+	}
+	return info.Size(), nil
+}
+
+func (s *Server) listBackups(w http.ResponseWriter, r *http.Request) {
+	s.listArtifacts(w, r, backupPrefix)
+}
+
+func (s *Server) downloadBackup(w http.ResponseWriter, r *http.Request) {
+	s.serveArtifact(w, r, backupPrefix, "application/gzip")
+}
+
+func (s *Server) deleteBackup(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	name := chi.URLParam(r, "name")
+	key, err := artifactKey(backupPrefix, name)
+	if err != nil {
+		http.Error(w, "Invalid name", http.StatusBadRequest)
+		return
+	}
+
+	store, err := s.store()
+	if err != nil {
+		http.Error(w, "Object storage unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if err := store.Delete(r.Context(), key); err != nil {
+		http.Error(w, "Failed to delete backup", http.StatusBadGateway)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "backup_delete", "backup", name, "Deleted backup", "success", "", r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listLogExports(w http.ResponseWriter, r *http.Request) {
+	s.listArtifacts(w, r, logExportPrefix)
+}
+
+func (s *Server) downloadLogExport(w http.ResponseWriter, r *http.Request) {
+	s.serveArtifact(w, r, logExportPrefix, "text/csv")
+}
@@ -0,0 +1,332 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+)
+
+// Bounce template changes are staged under bounce_template_<class>, with
+// the template as JSON or "" to restore Postfix's default
+const bounceTemplateKeyPrefix = "bounce_template_"
+
+// loadBounceTemplates returns the customised bounce templates in class
+// order. Classes that aren't customised are left out and keep Postfix's
+// default text.
+func (s *Server) loadBounceTemplates() ([]postfix.BounceTemplate, error) {
+	rows, err := s.db.Query(`SELECT class, from_header, subject, postmaster_subject, body FROM bounce_templates`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byClass := map[string]postfix.BounceTemplate{}
+	for rows.Next() {
+		var t postfix.BounceTemplate
+		if err := rows.Scan(&t.Class, &t.From, &t.Subject, &t.PostmasterSubject, &t.Body); err == nil {
+			byClass[t.Class] = t
+		}
+	}
+
+	templates := []postfix.BounceTemplate{}
+	for _, class := range postfix.BounceTemplateClasses {
+		if t, ok := byClass[class]; ok {
+			templates = append(templates, t)
+		}
+	}
+	return templates, nil
+}
+
+// saveBounceTemplates replaces the stored templates once an apply has
+// written them
+func (s *Server) saveBounceTemplates(templates []postfix.BounceTemplate, username string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM bounce_templates`); err != nil {
+		return err
+	}
+	for _, t := range templates {
+		if _, err := tx.Exec(`
+			INSERT INTO bounce_templates (class, from_header, subject, postmaster_subject, body, updated_at, updated_by)
+			VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?)
+		`, t.Class, t.From, t.Subject, t.PostmasterSubject, t.Body, username); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// stagedBounceTemplates returns the templates an apply of the staged
+// changes would write, and whether any are staged
+func (s *Server) stagedBounceTemplates(updates map[string]interface{}) ([]postfix.BounceTemplate, bool, error) {
+	templates, err := s.loadBounceTemplates()
+	if err != nil {
+		return nil, false, err
+	}
+
+	staged := false
+	for _, class := range postfix.BounceTemplateClasses {
+		value, ok := updates[bounceTemplateKeyPrefix+class].(string)
+		if !ok {
+			continue
+		}
+		staged = true
+		if value == "" {
+			kept := templates[:0]
+			for _, t := range templates {
+				if t.Class != class {
+					kept = append(kept, t)
+				}
+			}
+			templates = kept
+			continue
+		}
+		var t postfix.BounceTemplate
+		if err := json.Unmarshal([]byte(value), &t); err != nil {
+			return nil, false, err
+		}
+		templates = withBounceTemplate(templates, t)
+	}
+	return templates, staged, nil
+}
+
+// bounceTemplateValue is how a template is staged and compared in diffs
+func bounceTemplateValue(t postfix.BounceTemplate) string {
+	data, _ := json.Marshal(t)
+	return string(data)
+}
+
+// isBounceClass reports whether class is a bounce template class
+func isBounceClass(class string) bool {
+	for _, c := range postfix.BounceTemplateClasses {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// withBounceTemplate returns templates with t replacing the one of its
+// class, or added in class order
+func withBounceTemplate(templates []postfix.BounceTemplate, t postfix.BounceTemplate) []postfix.BounceTemplate {
+	var result []postfix.BounceTemplate
+	for _, class := range postfix.BounceTemplateClasses {
+		if class == t.Class {
+			result = append(result, t)
+			continue
+		}
+		for _, existing := range templates {
+			if existing.Class == class {
+				result = append(result, existing)
+			}
+		}
+	}
+	return result
+}
+
+// decodeBounceTemplate reads a template for the class in the URL from the
+// request body and validates it. Empty parts are taken from Postfix's
+// default. Variables must name Postfix parameters; they are only checked
+// when postconf can list them.
+func decodeBounceTemplate(w http.ResponseWriter, r *http.Request) (postfix.BounceTemplate, bool) {
+	var t postfix.BounceTemplate
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return t, false
+		}
+	}
+	t.Class = chi.URLParam(r, "class")
+
+	v := NewValidator()
+	if !isBounceClass(t.Class) {
+		v.AddErrorf("class", "must be one of: %s", strings.Join(postfix.BounceTemplateClasses, ", "))
+		writeValidationErrors(w, r, v)
+		return t, false
+	}
+
+	def := postfix.DefaultBounceTemplate(t.Class)
+	if t.From == "" {
+		t.From = def.From
+	}
+	if t.Subject == "" {
+		t.Subject = def.Subject
+	}
+	if t.PostmasterSubject == "" {
+		t.PostmasterSubject = def.PostmasterSubject
+	}
+	if t.Body == "" {
+		t.Body = def.Body
+	}
+
+	if err := postfix.ValidateBounceTemplate(t); err != nil {
+		v.AddErrorf("template", "invalid template: %s", err.Error())
+	}
+	if parameters, err := postfix.ParameterNames(); err == nil {
+		for _, name := range postfix.UnknownBounceVariables(t, parameters) {
+			v.AddErrorf("template", "unknown variable: %s", "$"+name)
+		}
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return t, false
+	}
+	return t, true
+}
+
+// getBounceTemplates returns the templates in use, the changes staged for
+// them and Postfix's defaults
+func (s *Server) getBounceTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := s.loadBounceTemplates()
+	if err != nil {
+		http.Error(w, "Failed to load bounce templates", http.StatusInternalServerError)
+		return
+	}
+
+	staged := map[string]*postfix.BounceTemplate{}
+	rows, err := s.db.Query(`SELECT key, value FROM staged_config WHERE key LIKE ?`, bounceTemplateKeyPrefix+"%")
+	if err != nil {
+		http.Error(w, "Failed to load staged templates", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value string
+		if rows.Scan(&key, &value) != nil {
+			continue
+		}
+		class := strings.TrimPrefix(key, bounceTemplateKeyPrefix)
+		if value == "" {
+			staged[class] = nil // default restored
+			continue
+		}
+		var t postfix.BounceTemplate
+		if json.Unmarshal([]byte(value), &t) == nil {
+			staged[class] = &t
+		}
+	}
+
+	defaults := map[string]postfix.BounceTemplate{}
+	for _, class := range postfix.BounceTemplateClasses {
+		defaults[class] = postfix.DefaultBounceTemplate(class)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"templates": templates,
+		"staged":    staged,
+		"defaults":  defaults,
+		"classes":   postfix.BounceTemplateClasses,
+	})
+}
+
+// putBounceTemplate stages a customised template for a notification class.
+// It is written to bounce_template_file by the next config apply.
+func (s *Server) putBounceTemplate(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	t, ok := decodeBounceTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	if isDryRun(r) {
+		if postfixMgr == nil {
+			postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+		}
+		templates, err := s.loadBounceTemplates()
+		if err != nil {
+			http.Error(w, "Failed to load bounce templates", http.StatusInternalServerError)
+			return
+		}
+		change, err := postfixMgr.PreviewBounceTemplates(withBounceTemplate(templates, t))
+		if err != nil {
+			http.Error(w, "Failed to preview bounce templates: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeDryRun(w, DryRunResult{Summary: "Would stage the " + t.Class + " bounce template", Files: []postfix.FileChange{change}})
+		return
+	}
+
+	if err := s.stageConfigEntry(user, bounceTemplateKeyPrefix+t.Class, bounceTemplateValue(t), "bounce"); err != nil {
+		http.Error(w, "Failed to stage bounce template", http.StatusInternalServerError)
+		return
+	}
+	s.logAudit(user.ID, user.Username, "config_submit", "config", t.Class,
+		"Staged "+t.Class+" bounce template", "success", r.RemoteAddr)
+
+	s.getStagedConfig(w, r)
+}
+
+// deleteBounceTemplate stages restoring Postfix's default for a
+// notification class
+func (s *Server) deleteBounceTemplate(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	class := chi.URLParam(r, "class")
+	if !isBounceClass(class) {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+
+	if err := s.stageConfigEntry(user, bounceTemplateKeyPrefix+class, "", "bounce"); err != nil {
+		http.Error(w, "Failed to stage bounce template", http.StatusInternalServerError)
+		return
+	}
+	s.logAudit(user.ID, user.Username, "config_submit", "config", class,
+		"Staged restoring the default "+class+" bounce template", "success", r.RemoteAddr)
+
+	s.getStagedConfig(w, r)
+}
+
+// previewBounceTemplate renders a template as it would be sent, with its
+// variables expanded from main.cf, and shows the template file it would
+// produce, without staging it
+func (s *Server) previewBounceTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := decodeBounceTemplate(w, r)
+	if !ok {
+		return
+	}
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+
+	templates, err := s.loadBounceTemplates()
+	if err != nil {
+		http.Error(w, "Failed to load bounce templates", http.StatusInternalServerError)
+		return
+	}
+	templates = withBounceTemplate(templates, t)
+
+	rendered, err := postfixMgr.ExpandBounceTemplate(t)
+	if err != nil {
+		http.Error(w, "Failed to render bounce template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	change, err := postfixMgr.PreviewBounceTemplates(templates)
+	if err != nil {
+		http.Error(w, "Failed to preview bounce templates: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"template":  t,
+		"rendered":  rendered,
+		"variables": postfix.BounceTemplateVariables(t),
+		"change":    change,
+	})
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// defaultProductName is shown when branding_product_name is empty
+const defaultProductName = "PSFX Suite"
+
+// maxProductNameLength bounds branding_product_name, which fits in the
+// navigation bar and the browser title
+const maxProductNameLength = 64
+
+var brandingColorRe = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// logoOrigins are the schemes and hosts of absolute branding_logo_url
+// values, the platform's and its tenants', which the
+// Content-Security-Policy lets images load from
+var logoOrigins atomic.Value // string, space separated
+
+// Branding is how the portal presents itself. It is public so the login
+// page can be branded before anyone signs in.
+type Branding struct {
+	ProductName  string `json:"productName"`
+	LogoURL      string `json:"logoUrl,omitempty"`
+	SupportEmail string `json:"supportEmail,omitempty"`
+	SupportURL   string `json:"supportUrl,omitempty"`
+	PrimaryColor string `json:"primaryColor,omitempty"` // #rrggbb
+	AccentColor  string `json:"accentColor,omitempty"`  // #rrggbb
+	DefaultTheme string `json:"defaultTheme"`           // light, dark or system
+}
+
+// loadBranding reads the branding settings, with a tenant's own settings
+// over the platform's when tenantID is set
+func (s *Server) loadBranding(tenantID int64) Branding {
+	overrides := map[string]string{}
+	if tenantID != 0 {
+		overrides = s.tenantSettings(tenantID)
+	}
+	get := func(key, def string) string {
+		if v, ok := overrides[key]; ok {
+			return v
+		}
+		return s.db.GetSetting(key, def)
+	}
+
+	b := Branding{
+		ProductName:  get("branding_product_name", ""),
+		LogoURL:      get("branding_logo_url", ""),
+		SupportEmail: get("branding_support_email", ""),
+		SupportURL:   get("branding_support_url", ""),
+		PrimaryColor: strings.ToLower(get("branding_primary_color", "")),
+		AccentColor:  strings.ToLower(get("branding_accent_color", "")),
+		DefaultTheme: get("branding_default_theme", "system"),
+	}
+	if b.ProductName == "" {
+		b.ProductName = defaultProductName
+	}
+	return b
+}
+
+// loadLogoOrigins caches the origins of the branding logos for the
+// security headers
+func (s *Server) loadLogoOrigins() {
+	logos := []string{s.db.GetSetting("branding_logo_url", "")}
+	if rows, err := s.db.Query(`SELECT value FROM tenant_settings WHERE key = 'branding_logo_url'`); err == nil {
+		for rows.Next() {
+			var v string
+			if rows.Scan(&v) == nil {
+				logos = append(logos, v)
+			}
+		}
+		rows.Close()
+	}
+
+	seen := map[string]bool{}
+	var origins []string
+	for _, logo := range logos {
+		u, err := url.Parse(logo)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		if origin := u.Scheme + "://" + u.Host; !seen[origin] {
+			seen[origin] = true
+			origins = append(origins, origin)
+		}
+	}
+	logoOrigins.Store(strings.Join(origins, " "))
+}
+
+// onBrandingSettingsChanged follows a new logo URL
+func (s *Server) onBrandingSettingsChanged(changed map[string]string) {
+	if _, ok := changed["branding_logo_url"]; ok {
+		s.loadLogoOrigins()
+	}
+}
+
+// imgSrc is the img-src directive of the Content-Security-Policy
+func imgSrc() string {
+	src := "img-src 'self' data: blob:"
+	if origins, _ := logoOrigins.Load().(string); origins != "" {
+		src += " " + origins
+	}
+	return src + "; "
+}
+
+// getBranding returns the product name, logo, support contact and colors
+// the UI should use, of the tenant in ?tenant=<slug> if given. No
+// authentication is required; an unknown tenant gets the platform's.
+func (s *Server) getBranding(w http.ResponseWriter, r *http.Request) {
+	var tenantID int64
+	if slug := r.URL.Query().Get("tenant"); slug != "" {
+		tenantID, _ = s.tenantBySlug(slug)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	json.NewEncoder(w).Encode(s.loadBranding(tenantID))
+}
+
+// validateBrandingSetting checks one branding_* setting
+func validateBrandingSetting(v *Validator, key, value string) {
+	switch key {
+	case "branding_product_name":
+		v.ValidateMaxLength(key, value, maxProductNameLength)
+	case "branding_logo_url":
+		// A path served from this host or an absolute URL
+		if !strings.HasPrefix(value, "/") || strings.HasPrefix(value, "//") {
+			v.ValidateHTTPURL(key, value)
+		} else if _, err := url.Parse(value); err != nil {
+			v.AddError(key, "must be a path or an http or https URL")
+		}
+	case "branding_support_url":
+		v.ValidateHTTPURL(key, value)
+	case "branding_support_email":
+		if value != "" {
+			v.ValidateEmail(key, value)
+		}
+	case "branding_primary_color", "branding_accent_color":
+		if value != "" && !brandingColorRe.MatchString(value) {
+			v.AddError(key, "must be a hex color such as #1d4ed8")
+		}
+	case "branding_default_theme":
+		if value != "light" && value != "dark" && value != "system" {
+			v.AddErrorf(key, "must be one of: %s", "light, dark, system")
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/canary"
+	"github.com/postfixrelay/postfixrelay/internal/mail"
+)
+
+// canaryMonitor sends synthetic delivery probes to the seed mailbox
+var canaryMonitor *canary.Monitor
+
+// startCanary starts the delivery canary; it stays idle until
+// canary_enabled is set
+func (s *Server) startCanary() {
+	canaryMonitor = canary.NewMonitor(s.db.DB, s.sendCanaryProbe, s.canaryConfig)
+	canaryMonitor.Start()
+}
+
+// canaryConfig reads the canary_* settings
+func (s *Server) canaryConfig() canary.Config {
+	return canary.Config{
+		Enabled:      s.db.GetSetting("canary_enabled", "false") == "true",
+		Interval:     time.Duration(s.db.GetSettingInt("canary_interval_minutes", 15)) * time.Minute,
+		Timeout:      time.Duration(s.db.GetSettingInt("canary_timeout_seconds", 600)) * time.Second,
+		From:         s.db.GetSetting("canary_from", ""),
+		To:           s.db.GetSetting("canary_to", ""),
+		IMAPAddress:  s.db.GetSetting("canary_imap_address", ""),
+		IMAPUsername: s.db.GetSetting("canary_imap_username", ""),
+		IMAPPassword: s.db.GetSetting("canary_imap_password", ""),
+		IMAPTLS:      s.db.GetSetting("canary_imap_tls", "true") == "true",
+		Mailbox:      s.db.GetSetting("canary_imap_mailbox", "INBOX"),
+	}
+}
+
+// sendCanaryProbe submits a probe through the local relay
+func (s *Server) sendCanaryProbe(from, to, subject, body string) error {
+	if relaySender == nil {
+		return fmt.Errorf("mail services are not initialized")
+	}
+	if from == "" {
+		hostname, _ := os.Hostname()
+		from = "canary@" + hostname
+	}
+
+	_, err := relaySender.Send(from, "", &mail.ComposeMessage{
+		To:      []string{to},
+		Subject: subject,
+		Body:    body,
+	})
+	return err
+}
+
+// getCanaryStatus returns the canary configuration, the latest probe and
+// statistics for the last 24 hours
+func (s *Server) getCanaryStatus(w http.ResponseWriter, r *http.Request) {
+	cfg := s.canaryConfig()
+
+	stats, err := canaryMonitor.Stats(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		http.Error(w, "Failed to load canary statistics", http.StatusInternalServerError)
+		return
+	}
+	recent, err := canaryMonitor.Recent(1)
+	if err != nil {
+		http.Error(w, "Failed to load canary probes", http.StatusInternalServerError)
+		return
+	}
+
+	status := map[string]interface{}{
+		"enabled":         cfg.Enabled,
+		"configured":      cfg.Validate() == nil,
+		"recipient":       cfg.To,
+		"intervalMinutes": int(cfg.Interval / time.Minute),
+		"timeoutSeconds":  int(cfg.Timeout / time.Second),
+		"last24h":         stats,
+	}
+	if len(recent) > 0 {
+		status["lastProbe"] = recent[0]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// listCanaryProbes returns recent probes
+func (s *Server) listCanaryProbes(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if l := r.URL.Query().Get("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	probes, err := canaryMonitor.Recent(limit)
+	if err != nil {
+		http.Error(w, "Failed to load canary probes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"probes": probes,
+	})
+}
+
+// runCanaryProbe sends a probe now; its result is recorded once it arrives
+// or times out
+func (s *Server) runCanaryProbe(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+
+	probe, err := canaryMonitor.Trigger()
+	if err != nil {
+		s.auditLog(user.ID, user.Username, "canary_probe", "canary", "", "Manual delivery probe", "failure", err.Error(), r)
+		if probe == nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to send probe: "+err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "canary_probe", "canary", probe.Token,
+		"Manual delivery probe to "+probe.Recipient, "success", "", r)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(probe)
+}
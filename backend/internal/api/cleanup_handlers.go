@@ -0,0 +1,284 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/metrics"
+	"github.com/postfixrelay/postfixrelay/internal/scheduler"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// mailSessionIdle is how long an unused webmail IMAP session is kept
+	mailSessionIdle = 30 * time.Minute
+
+	// tempFileMaxAge is the age past which a leftover temporary file or
+	// directory is taken to be abandoned rather than in use
+	tempFileMaxAge = 24 * time.Hour
+)
+
+// tempDirPrefixes name the directories created under the system temp
+// directory by backups and config renders. They are removed when the
+// operation finishes, unless the process died first.
+var tempDirPrefixes = []string{"psfx-backup-", "postfixrelay-render-"}
+
+// cleanupJob is a scheduled cleanup. run returns what it removed, counted
+// by the table or in-memory set it came from.
+type cleanupJob struct {
+	name        string
+	description string
+	schedule    string
+	run         func(ctx context.Context) (map[string]int64, error)
+}
+
+// CleanupRun is the outcome of a cleanup job's last run
+type CleanupRun struct {
+	RanAt  time.Time        `json:"ranAt"`
+	Purged map[string]int64 `json:"purged"`
+	Error  string           `json:"error,omitempty"`
+}
+
+// CleanupJobStatus is a cleanup job's schedule and last run
+type CleanupJobStatus struct {
+	scheduler.Status
+	LastRun *CleanupRun `json:"lastRun"`
+}
+
+var (
+	cleanupMu      sync.Mutex
+	cleanupLastRun = map[string]*CleanupRun{}
+
+	// cleanupPurged counts rows and items removed by cleanup jobs for
+	// /metrics
+	cleanupPurged = metrics.NewCounterVec("postfixrelay_cleanup_purged_total",
+		"Rows and items removed by cleanup jobs, by job and table.", "job", "table")
+
+	// cleanupRuns counts cleanup job runs for /metrics
+	cleanupRuns = metrics.NewCounterVec("postfixrelay_cleanup_runs_total",
+		"Cleanup job runs, by job and outcome.", "job", "status")
+)
+
+// cleanupJobNames lists the jobs GET /system/cleanup reports on, the
+// retention pruner included
+var cleanupJobNames []string
+
+// startCleanupJobs schedules the jobs that remove expired sessions, idle
+// rate limiters and abandoned temporary files. Their intervals are changed
+// like any other scheduled task's.
+func (s *Server) startCleanupJobs() {
+	jobs := []cleanupJob{
+		{
+			name:        "session_cleanup",
+			description: "Delete expired login sessions and log out idle webmail sessions",
+			schedule:    "*/15 * * * *",
+			run:         s.cleanupSessions,
+		},
+		{
+			name:        "rate_limit_cleanup",
+			description: "Drop rate limiters of clients that are no longer being throttled",
+			schedule:    "@hourly",
+			run:         cleanupRateLimiters,
+		},
+		{
+			name:        "temp_file_cleanup",
+			description: "Remove temporary files and directories left behind by interrupted operations",
+			schedule:    "@daily",
+			run:         s.cleanupTempFiles,
+		},
+	}
+	for _, job := range jobs {
+		job := job
+		cleanupJobNames = append(cleanupJobNames, job.name)
+		s.scheduler.Register(scheduler.Task{
+			Name:        job.name,
+			Description: job.description,
+			Schedule:    job.schedule,
+			Run: func(ctx context.Context) error {
+				purged, err := job.run(ctx)
+				recordCleanup(job.name, time.Now(), purged, err)
+				return err
+			},
+		})
+	}
+}
+
+// recordCleanup keeps a job's last run and adds what it removed to the
+// metrics
+func recordCleanup(job string, ranAt time.Time, purged map[string]int64, err error) {
+	run := &CleanupRun{RanAt: ranAt.UTC(), Purged: purged}
+	status := "success"
+	if err != nil {
+		run.Error = err.Error()
+		status = "failed"
+	}
+	if run.Purged == nil {
+		run.Purged = map[string]int64{}
+	}
+	for table, n := range run.Purged {
+		cleanupPurged.Add(float64(n), job, table)
+	}
+	cleanupRuns.Inc(job, status)
+
+	cleanupMu.Lock()
+	cleanupLastRun[job] = run
+	cleanupMu.Unlock()
+}
+
+// cleanupSessions deletes login sessions past their expiry, which the auth
+// middleware already refuses, and logs out webmail sessions left unused
+func (s *Server) cleanupSessions(ctx context.Context) (map[string]int64, error) {
+	purged := map[string]int64{}
+	if mailSessionManager != nil {
+		purged["mail_sessions"] = int64(mailSessionManager.CleanupStale(mailSessionIdle))
+	}
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE datetime(expires_at) <= datetime('now')`)
+	if err != nil {
+		return purged, fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+	purged["sessions"], _ = res.RowsAffected()
+	return purged, nil
+}
+
+// cleanupRateLimiters drops the limiters of every rate limit group whose
+// clients have their full burst back
+func cleanupRateLimiters(ctx context.Context) (map[string]int64, error) {
+	purged := map[string]int64{}
+	for _, g := range rateLimitGroups {
+		purged["rate_limit_"+g.name] = int64(g.limiter.cleanup())
+	}
+	return purged, nil
+}
+
+// cleanupTempFiles removes what interrupted operations left behind: the
+// temp directories of backups and renders, half-written main.cf
+// replacements and partial local storage uploads
+func (s *Server) cleanupTempFiles(ctx context.Context) (map[string]int64, error) {
+	cutoff := time.Now().Add(-tempFileMaxAge)
+	purged := map[string]int64{}
+	var errs []string
+
+	n, err := removeStale(os.TempDir(), cutoff, func(name string) bool {
+		for _, prefix := range tempDirPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+		return false
+	})
+	purged["temp_dirs"] = n
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	n, err = removeStale(s.cfg.PostfixConfigDir, cutoff, func(name string) bool {
+		return strings.HasPrefix(name, ".main.cf.") && strings.HasSuffix(name, ".tmp")
+	})
+	purged["config_temp_files"] = n
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	n, err = removeStaleUploads(ctx, s.storageConfig().LocalPath, cutoff)
+	purged["storage_uploads"] = n
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return purged, fmt.Errorf("temp file cleanup failed: %s", strings.Join(errs, "; "))
+	}
+	return purged, nil
+}
+
+// removeStale removes the entries of dir that match and were last modified
+// before cutoff. A missing dir has nothing to remove.
+func removeStale(dir string, cutoff time.Time, match func(name string) bool) (int64, error) {
+	if dir == "" {
+		return 0, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	var removed int64
+	for _, e := range entries {
+		if !match(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if err := os.RemoveAll(path); err != nil {
+			log.Warn().Err(err).Str("path", path).Msg("Failed to remove stale temp file")
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// removeStaleUploads removes the .upload-* files the local object store
+// writes before renaming them into place
+func removeStaleUploads(ctx context.Context, base string, cutoff time.Time) (int64, error) {
+	if base == "" {
+		return 0, nil
+	}
+	var removed int64
+	err := filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() || !strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().Before(cutoff) {
+			if os.Remove(path) == nil {
+				removed++
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// getCleanup returns each cleanup job's schedule, last run and rows purged
+// per table, with the retention pruner alongside
+func (s *Server) getCleanup(w http.ResponseWriter, r *http.Request) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+
+	jobs := []CleanupJobStatus{}
+	for _, name := range cleanupJobNames {
+		st, err := s.scheduler.Get(name)
+		if err != nil {
+			continue
+		}
+		jobs = append(jobs, CleanupJobStatus{Status: st, LastRun: cleanupLastRun[name]})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jobs": jobs,
+	})
+}
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	netmail "net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/rs/zerolog/log"
+)
+
+// redactedText stands in for a confidential subject or log detail
+const redactedText = "[redacted]"
+
+// maxMessageContentBytes caps how much of a queued message's body is shown
+const maxMessageContentBytes = 1 << 20
+
+// confidentialDomains returns the domains flagged confidential
+func (s *Server) confidentialDomains() map[string]bool {
+	domains := map[string]bool{}
+	rows, err := s.db.Query(`SELECT domain FROM mail_domains WHERE confidential = TRUE`)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load confidential domains")
+		return domains
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d string
+		if rows.Scan(&d) == nil {
+			domains[strings.ToLower(d)] = true
+		}
+	}
+	return domains
+}
+
+// isConfidential reports whether the sender or any recipient is in one of
+// the confidential domains
+func isConfidential(domains map[string]bool, sender string, recipients []string) bool {
+	if len(domains) == 0 {
+		return false
+	}
+	if domains[domainOf(sender)] {
+		return true
+	}
+	for _, rcpt := range recipients {
+		if domains[domainOf(rcpt)] {
+			return true
+		}
+	}
+	return false
+}
+
+// unredactRequest is a request to see confidential messages in full, from
+// ?unredact=true&justification=...
+type unredactRequest struct {
+	Requested     bool
+	Justification string
+}
+
+// parseUnredact reads an unredact request. Asking to unredact needs
+// unredact:messages and a justification; otherwise it writes the error
+// and returns false.
+func parseUnredact(w http.ResponseWriter, r *http.Request) (unredactRequest, bool) {
+	q := r.URL.Query()
+	req := unredactRequest{
+		Requested:     q.Get("unredact") == "true",
+		Justification: strings.TrimSpace(q.Get("justification")),
+	}
+	if !req.Requested {
+		return req, true
+	}
+	if user := GetUser(r.Context()); user == nil || !HasPermission(user.Role, PermUnredactMessages) {
+		http.Error(w, "forbidden: insufficient permissions to unredact messages", http.StatusForbidden)
+		return req, false
+	}
+	v := NewValidator()
+	v.ValidateRequired("justification", req.Justification)
+	v.ValidateMaxLength("justification", req.Justification, 500)
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return req, false
+	}
+	return req, true
+}
+
+// auditUnredact records that a confidential message was shown in full
+func (s *Server) auditUnredact(r *http.Request, queueID, view, justification string) {
+	if u := GetUser(r.Context()); u != nil {
+		s.logAudit(u.ID, u.Username, "message_unredact", "message", queueID,
+			"Unredacted "+view+" of message "+queueID+": "+justification, "success", r.RemoteAddr)
+	}
+}
+
+// logDetail matches the header or body text a cleanup log line quotes,
+// such as "warning: header Subject: hello from host[1.2.3.4]; from=<...>"
+var logDetail = regexp.MustCompile(`(?i)(\b(?:mime-header|nested-header|header|body) ).*?( from \S+; from=|; from=|$)`)
+
+// redactLogMessage hides the header or body text a log line quotes
+func redactLogMessage(msg string) string {
+	return logDetail.ReplaceAllString(msg, "${1}"+redactedText+"${2}")
+}
+
+// messageContent is what a queued message says, as shown to operators
+type messageContent struct {
+	QueueID   string   `json:"queueId"`
+	From      string   `json:"from" redact:"view:addresses,mask"`
+	To        []string `json:"to" redact:"view:addresses,mask"`
+	Cc        []string `json:"cc,omitempty" redact:"view:addresses,mask"`
+	Date      string   `json:"date,omitempty"`
+	MessageID string   `json:"messageId,omitempty"`
+	Subject   string   `json:"subject"`
+	Body      string   `json:"body"`
+	Truncated bool     `json:"truncated,omitempty"`
+	// Confidential is set for mail of a confidential domain, and Redacted
+	// when its subject and body are withheld, for that or because the
+	// caller may only see the envelope (view:queue_content)
+	Confidential bool `json:"confidential"`
+	Redacted     bool `json:"redacted"`
+}
+
+// addressList returns the addresses of a header, or its raw value when it
+// doesn't parse
+func addressList(h netmail.Header, name string) []string {
+	value := h.Get(name)
+	if value == "" {
+		return nil
+	}
+	list, err := h.AddressList(name)
+	if err != nil {
+		return []string{value}
+	}
+	addrs := make([]string, len(list))
+	for i, a := range list {
+		addrs[i] = a.Address
+	}
+	return addrs
+}
+
+// getQueueMessageContent returns the headers and body of a queued message.
+// Mail of a confidential domain has its subject and body redacted unless
+// the caller may unredact it and says why, which is audited.
+func (s *Server) getQueueMessageContent(w http.ResponseWriter, r *http.Request) {
+	s.initQueueManager()
+	queueId := chi.URLParam(r, "queueId")
+
+	unredact, ok := parseUnredact(w, r)
+	if !ok {
+		return
+	}
+
+	queued, err := queueMgr.GetMessage(queueId)
+	if err != nil {
+		if errors.Is(err, postfix.ErrInvalidQueueID) {
+			http.Error(w, "invalid queue ID format", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "message not found", http.StatusNotFound)
+		return
+	}
+	raw, err := queueMgr.GetMessageContent(queueId)
+	if err != nil {
+		http.Error(w, "failed to read message content", http.StatusInternalServerError)
+		return
+	}
+
+	content := messageContent{QueueID: queueId, From: queued.Sender, To: queued.Recipients}
+	if msg, err := netmail.ReadMessage(bytes.NewReader(raw)); err == nil {
+		content.Cc = addressList(msg.Header, "Cc")
+		content.Date = msg.Header.Get("Date")
+		content.MessageID = msg.Header.Get("Message-Id")
+		content.Subject = decodeHeader(msg.Header.Get("Subject"))
+		body, _ := io.ReadAll(io.LimitReader(msg.Body, maxMessageContentBytes+1))
+		content.Body, content.Truncated = truncateBody(body)
+	} else {
+		// Not a message we can parse; show what postcat printed
+		content.Body, content.Truncated = truncateBody(raw)
+	}
+
+	content.Confidential = isConfidential(s.confidentialDomains(), queued.Sender, queued.Recipients)
+	switch {
+	case !HasPermission(GetUser(r.Context()).Role, PermViewQueueContent):
+		content.Subject = ""
+		content.Body = ""
+		content.Truncated = false
+		content.Redacted = true
+	case content.Confidential && unredact.Requested:
+		s.auditUnredact(r, queueId, "content", unredact.Justification)
+	case content.Confidential:
+		content.Subject = redactedText
+		content.Body = ""
+		content.Truncated = false
+		content.Redacted = true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(redactFor(r, content))
+}
+
+// truncateBody caps a body at maxMessageContentBytes
+func truncateBody(body []byte) (string, bool) {
+	if len(body) > maxMessageContentBytes {
+		return string(body[:maxMessageContentBytes]), true
+	}
+	return string(body), false
+}
+
+// decodeHeader decodes RFC 2047 encoded words, keeping the raw value when
+// it doesn't decode
+func decodeHeader(value string) string {
+	dec := new(mime.WordDecoder)
+	if decoded, err := dec.DecodeHeader(value); err == nil {
+		return decoded
+	}
+	return value
+}
@@ -0,0 +1,202 @@
+//go:build integration
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/postfixrelay/postfixrelay/internal/config"
+	"github.com/postfixrelay/postfixrelay/internal/testharness"
+)
+
+var (
+	alice = &User{ID: 1, Username: "alice", Email: "alice@example.com", Role: "admin"}
+	bob   = &User{ID: 2, Username: "bob", Email: "bob@example.com", Role: "admin"}
+	carol = &User{ID: 3, Username: "carol", Email: "carol@example.com", Role: "operator"}
+)
+
+const relayConfig = `{"config": {"general": {"myhostname": "relay.example.com", "mydomain": "example.com",
+	"myorigin": "example.com", "inet_interfaces": "all", "inet_protocols": "ipv4"}}}`
+
+// newConfigServer starts a server on the fake Postfix and a database
+// loaded from testdata/config_review.yaml
+func newConfigServer(t *testing.T) (*Server, *testharness.Postfix) {
+	t.Helper()
+	pf := testharness.NewPostfix(t)
+	db := testharness.NewDB(t, "testdata/config_review.yaml")
+
+	cfg := config.Defaults()
+	cfg.DBPath = filepath.Join(t.TempDir(), "postfixrelay.db")
+	cfg.PostfixConfigDir = pf.ConfigDir
+	cfg.HooksDir = t.TempDir()
+	cfg.LogPath = filepath.Join(t.TempDir(), "mail.log")
+	cfg.LogSource = cfg.LogPath
+
+	// The managers and the log pipeline are package globals, made for
+	// one server per process
+	postfixMgr, queueMgr = nil, nil
+	logPipelineStop, logPipelineDone = make(chan struct{}), make(chan struct{})
+	t.Cleanup(func() { postfixMgr, queueMgr = nil, nil })
+
+	s := NewServer(cfg, db)
+	t.Cleanup(s.Close)
+	return s, pf
+}
+
+// call runs a handler as user and decodes its JSON response into out,
+// when given
+func call(t *testing.T, h http.HandlerFunc, user *User, method, target, body string, out interface{}) int {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r = r.WithContext(context.WithValue(r.Context(), contextKeyUser, user))
+	w := httptest.NewRecorder()
+	h(w, r)
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v: %s", method, target, err, w.Body.String())
+		}
+	}
+	return w.Code
+}
+
+func readMainCf(t *testing.T, pf *testharness.Postfix) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(pf.ConfigDir, "main.cf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func stagedCount(t *testing.T, s *Server) int {
+	t.Helper()
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM staged_config`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestStagedConfigApplyNeedsSecondAdmin(t *testing.T) {
+	s, pf := newConfigServer(t)
+
+	if code := call(t, s.submitConfig, carol, "POST", "/api/v1/config/submit", relayConfig, nil); code != http.StatusOK {
+		t.Fatalf("submit: status %d", code)
+	}
+	if got := readMainCf(t, pf); strings.Contains(got, "relay.example.com") {
+		t.Fatalf("submit wrote main.cf:\n%s", got)
+	}
+
+	// A dry run previews the change before anyone has reviewed it
+	var preview DryRunResult
+	if code := call(t, s.applyConfig, alice, "POST", "/api/v1/config/apply?dryRun=true", "", &preview); code != http.StatusOK {
+		t.Fatalf("dry run: status %d", code)
+	}
+	if !preview.DryRun || len(preview.Files) == 0 || !strings.Contains(preview.Files[0].Diff, "myhostname = relay.example.com") {
+		t.Errorf("dry run = %+v", preview)
+	}
+
+	var refused map[string]interface{}
+	if code := call(t, s.applyConfig, alice, "POST", "/api/v1/config/apply", "", &refused); code != http.StatusConflict {
+		t.Fatalf("apply before approval: status %d, want 409", code)
+	}
+	if refused["message"] != errStagedNotApproved.Error() {
+		t.Errorf("apply before approval: %v", refused)
+	}
+
+	// Nobody approves their own change
+	if code := call(t, s.submitConfig, alice, "PUT", "/api/v1/config", relayConfig, nil); code != http.StatusOK {
+		t.Fatalf("PUT /config: status %d", code)
+	}
+	if code := call(t, s.approveStagedConfig, alice, "POST", "/api/v1/config/staged/approve", "", nil); code != http.StatusForbidden {
+		t.Errorf("self-approval: status %d, want 403", code)
+	}
+	if got := readMainCf(t, pf); strings.Contains(got, "relay.example.com") {
+		t.Fatalf("PUT /config wrote main.cf instead of staging:\n%s", got)
+	}
+
+	if code := call(t, s.approveStagedConfig, bob, "POST", "/api/v1/config/staged/approve", `{"comment": "looks good"}`, nil); code != http.StatusOK {
+		t.Fatalf("approve: status %d", code)
+	}
+
+	var applied map[string]interface{}
+	if code := call(t, s.applyConfig, alice, "POST", "/api/v1/config/apply", `{"notes": "new hostname"}`, &applied); code != http.StatusOK {
+		t.Fatalf("apply: status %d", code)
+	}
+	if applied["success"] != true {
+		t.Fatalf("apply: %v", applied)
+	}
+	if got := readMainCf(t, pf); !strings.Contains(got, "myhostname = relay.example.com") {
+		t.Errorf("main.cf after apply:\n%s", got)
+	}
+	calls := strings.Join(pf.Calls(), "\n")
+	for _, want := range []string{"postfix check", "postfix reload"} {
+		if !strings.Contains(calls, want) {
+			t.Errorf("no %q in calls:\n%s", want, calls)
+		}
+	}
+	if n := stagedCount(t, s); n != 0 {
+		t.Errorf("%d staged rows left after apply", n)
+	}
+	var versions int
+	s.db.QueryRow(`SELECT COUNT(*) FROM config_versions`).Scan(&versions)
+	if versions != 1 {
+		t.Errorf("%d config versions recorded, want 1", versions)
+	}
+}
+
+func TestStagedConfigRejected(t *testing.T) {
+	s, pf := newConfigServer(t)
+
+	call(t, s.submitConfig, carol, "POST", "/api/v1/config/submit", relayConfig, nil)
+	if code := call(t, s.rejectStagedConfig, bob, "POST", "/api/v1/config/staged/reject", `{"comment": "wrong host"}`, nil); code != http.StatusOK {
+		t.Fatalf("reject: status %d", code)
+	}
+	var refused map[string]interface{}
+	if code := call(t, s.applyConfig, alice, "POST", "/api/v1/config/apply", "", &refused); code != http.StatusConflict {
+		t.Fatalf("apply after rejection: status %d, want 409", code)
+	}
+	if refused["message"] != errStagedRejected.Error() {
+		t.Errorf("apply after rejection: %v", refused)
+	}
+	if got := readMainCf(t, pf); got != "" {
+		t.Errorf("main.cf written after rejection:\n%s", got)
+	}
+}
+
+func TestStagedConfigApplyFailures(t *testing.T) {
+	s, pf := newConfigServer(t)
+	if _, err := s.db.Exec(`UPDATE settings SET value = 'false' WHERE key = 'config_second_admin'`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without config_second_admin an admin applies unreviewed changes
+	call(t, s.submitConfig, carol, "POST", "/api/v1/config/submit", relayConfig, nil)
+	pf.Fail("postfix", "postfix: fatal: /etc/postfix/main.cf: bad parameter")
+	var failed map[string]interface{}
+	call(t, s.applyConfig, alice, "POST", "/api/v1/config/apply", "", &failed)
+	if failed["success"] != false || !strings.Contains(failed["message"].(string), "bad parameter") {
+		t.Errorf("apply with postfix check failing: %v", failed)
+	}
+	if n := stagedCount(t, s); n == 0 {
+		t.Error("a failed apply cleared the staged changes")
+	}
+
+	pf.Succeed("postfix")
+	var applied map[string]interface{}
+	call(t, s.applyConfig, alice, "POST", "/api/v1/config/apply", "", &applied)
+	if applied["success"] != true {
+		t.Errorf("apply: %v", applied)
+	}
+	if n := stagedCount(t, s); n != 0 {
+		t.Errorf("%d staged rows left after apply", n)
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/bake"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/postfixrelay/postfixrelay/internal/supervisor"
+	"github.com/rs/zerolog/log"
+)
+
+// bakeInterval is how often Postfix's health is sampled during a bake
+const bakeInterval = 30 * time.Second
+
+// bakeFailures is how many samples in a row must regress before rolling
+// back, so a single slow status check doesn't undo an apply
+const bakeFailures = 2
+
+// bakeSample takes a health sample for a bake
+func (s *Server) bakeSample() bake.Sample {
+	queue := s.getQueueStatus()
+	sample := bake.Sample{
+		Running: s.getPostfixStatus().Running,
+		Queue:   queue.Active + queue.Deferred + queue.Hold,
+	}
+	if smtpdErrors != nil {
+		sample.SMTPDLines, sample.SMTPDErrors = smtpdErrors.Snapshot()
+	}
+	return sample
+}
+
+// startBake watches Postfix for config_bake_minutes after version was
+// applied and rolls back to the version before it if health regresses.
+// baseline is sampled before the apply. Nothing is watched when baking is
+// off or there is no earlier version to return to.
+func (s *Server) startBake(version int64, baseline bake.Sample) {
+	minutes := s.db.GetSettingInt("config_bake_minutes", 0)
+	if minutes <= 0 {
+		return
+	}
+	var previous int64
+	if err := s.db.QueryRow(`
+		SELECT version_number FROM config_versions WHERE version_number < ? ORDER BY version_number DESC LIMIT 1
+	`, version).Scan(&previous); err != nil {
+		return
+	}
+
+	limits := bake.Limits{
+		QueueGrowth:   s.db.GetSettingInt("config_bake_queue_growth", 200),
+		ErrorPercent:  s.db.GetSettingInt("config_bake_smtpd_errors", 20),
+		MinSMTPDLines: 20,
+	}
+	log.Info().Int64("version", version).Int("minutes", minutes).Msg("Watching Postfix after config apply")
+
+	s.workers.Go("config_bake", supervisor.Once, func() error {
+		deadline := time.Now().Add(time.Duration(minutes) * time.Minute)
+		ticker := time.NewTicker(bakeInterval)
+		defer ticker.Stop()
+
+		failures := 0
+		for {
+			select {
+			case <-s.workers.Done():
+				return nil
+			case <-ticker.C:
+			}
+
+			// A later apply or rollback takes over from this bake
+			var latest int64
+			var status string
+			s.db.QueryRow(`SELECT MAX(version_number) FROM config_versions`).Scan(&latest)
+			s.db.QueryRow(`SELECT status FROM config_versions WHERE version_number = ?`, version).Scan(&status)
+			if latest != version || status != "applied" {
+				return nil
+			}
+
+			reason := bake.Regression(baseline, s.bakeSample(), limits)
+			if reason == "" {
+				failures = 0
+			} else if failures++; failures >= bakeFailures {
+				return s.autoRollback(version, previous, reason)
+			}
+			if time.Now().After(deadline) {
+				log.Info().Int64("version", version).Msg("Config apply passed its bake period")
+				return nil
+			}
+		}
+	})
+}
+
+// autoRollback restores the previous version after a bake found a
+// regression. The rolled back version keeps the reason, and the Config
+// Auto-Rollback alert rule picks it up from there. A rollback that fails
+// is returned as well as audited.
+func (s *Server) autoRollback(version, previous int64, reason string) error {
+	log.Warn().Int64("version", version).Int64("previous", previous).Str("reason", reason).Msg("Config apply regressed health, rolling back")
+	id := strconv.FormatInt(version, 10)
+	summary := fmt.Sprintf("Automatically rolled back version %d to %d: %s", version, previous, reason)
+
+	var content string
+	if err := s.db.QueryRow(`SELECT config_content FROM config_versions WHERE version_number = ?`, previous).Scan(&content); err != nil {
+		s.logAudit(0, "system", "config_auto_rollback", "config", id, "Automatic rollback failed: previous version not found", "failed", "")
+		return fmt.Errorf("previous version %d not found", previous)
+	}
+	var config postfix.Config
+	if err := json.Unmarshal([]byte(content), &config); err != nil {
+		s.logAudit(0, "system", "config_auto_rollback", "config", id, "Automatic rollback failed: "+err.Error(), "failed", "")
+		return err
+	}
+
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+	err := postfixMgr.WriteConfig(&config)
+	if err == nil {
+		if valid, errs := postfixMgr.Validate(); !valid {
+			err = fmt.Errorf("validation failed: %s", errs[0])
+		}
+	}
+	if err == nil {
+		err = postfixMgr.Reload()
+	}
+	if err != nil {
+		log.Error().Err(err).Int64("version", version).Msg("Automatic config rollback failed")
+		s.logAudit(0, "system", "config_auto_rollback", "config", id, "Automatic rollback failed: "+err.Error(), "failed", "")
+		return err
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	s.db.Exec(`
+		UPDATE config_versions SET status = 'rolled_back', auto_rollback_at = ?, auto_rollback_reason = ?
+		WHERE version_number = ?
+	`, now, reason, version)
+	s.db.Exec(`UPDATE config_versions SET status = 'applied', applied_at = ? WHERE version_number = ?`, now, previous)
+
+	s.logAudit(0, "system", "config_auto_rollback", "config", id, summary, "success", "")
+	return nil
+}
@@ -0,0 +1,426 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/rs/zerolog/log"
+)
+
+// configVersionTag is what a version tag may contain. Tags start with a
+// letter so they can't be mistaken for version numbers.
+var configVersionTag = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9._-]{0,63}$`)
+
+// ApplyNotes describes a config apply for the version it records
+type ApplyNotes struct {
+	Notes  string `json:"notes"`
+	Ticket string `json:"ticket"` // change ticket reference, e.g. CHG-1234
+}
+
+// validateApplyNotes checks an apply's description, and that it has a ticket
+// when config_require_ticket is set
+func (s *Server) validateApplyNotes(v *Validator, n *ApplyNotes) {
+	n.Notes = strings.TrimSpace(n.Notes)
+	n.Ticket = strings.TrimSpace(n.Ticket)
+	v.ValidateMaxLength("notes", n.Notes, 1000)
+	v.ValidateMaxLength("ticket", n.Ticket, 100)
+	if s.db.GetSetting("config_require_ticket", "false") == "true" {
+		v.ValidateRequired("ticket", n.Ticket)
+	}
+}
+
+// resolveConfigVersion returns the version number a reference names: either
+// a version number or a tag
+func (s *Server) resolveConfigVersion(ref string) (int, error) {
+	if n, err := strconv.Atoi(ref); err == nil {
+		return n, nil
+	}
+	var n int
+	err := s.db.QueryRow(`SELECT version_number FROM config_version_tags WHERE tag = ?`, ref).Scan(&n)
+	return n, err
+}
+
+// configVersionTags returns the tags of every version
+func (s *Server) configVersionTags() map[int64][]string {
+	tags := map[int64][]string{}
+	rows, err := s.db.Query(`SELECT tag, version_number FROM config_version_tags ORDER BY tag`)
+	if err != nil {
+		return tags
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag string
+		var version int64
+		if rows.Scan(&tag, &version) == nil {
+			tags[version] = append(tags[version], tag)
+		}
+	}
+	return tags
+}
+
+// tagConfigVersion names a version. A tag already on another version is
+// only moved when the request says so.
+func (s *Server) tagConfigVersion(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	version := chi.URLParam(r, "version")
+
+	var req struct {
+		Tag  string `json:"tag"`
+		Move bool   `json:"move"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Tag = strings.TrimSpace(req.Tag)
+
+	v := NewValidator()
+	v.ValidateRequired("tag", req.Tag)
+	if req.Tag != "" && !configVersionTag.MatchString(req.Tag) {
+		v.AddError("tag", "must start with a letter and be up to 64 letters, digits, dots, dashes or underscores")
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	var versionNum int
+	if err := s.db.QueryRow(`SELECT version_number FROM config_versions WHERE version_number = ?`, version).Scan(&versionNum); err != nil {
+		http.Error(w, "version not found", http.StatusNotFound)
+		return
+	}
+
+	var current int
+	err := s.db.QueryRow(`SELECT version_number FROM config_version_tags WHERE tag = ?`, req.Tag).Scan(&current)
+	if err == nil && current != versionNum && !req.Move {
+		http.Error(w, "tag is already on version "+strconv.Itoa(current), http.StatusConflict)
+		return
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO config_version_tags (tag, version_number, created_by) VALUES (?, ?, ?)
+		ON CONFLICT(tag) DO UPDATE SET version_number = excluded.version_number,
+			created_by = excluded.created_by, created_at = CURRENT_TIMESTAMP
+	`, req.Tag, versionNum, user.Username)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to tag config version")
+		http.Error(w, "failed to tag version", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "config_tag", "config", version,
+		"Tagged version "+version+" as "+req.Tag, "success", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tag":           req.Tag,
+		"versionNumber": versionNum,
+	})
+}
+
+// deleteConfigTag removes a tag; the version itself is kept
+func (s *Server) deleteConfigTag(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	tag := chi.URLParam(r, "tag")
+
+	var versionNum int
+	err := s.db.QueryRow(`SELECT version_number FROM config_version_tags WHERE tag = ?`, tag).Scan(&versionNum)
+	if err == sql.ErrNoRows {
+		http.Error(w, "tag not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to delete tag", http.StatusInternalServerError)
+		return
+	}
+	if _, err := s.db.Exec(`DELETE FROM config_version_tags WHERE tag = ?`, tag); err != nil {
+		http.Error(w, "failed to delete tag", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "config_untag", "config", strconv.Itoa(versionNum),
+		"Removed tag "+tag+" from version "+strconv.Itoa(versionNum), "success", r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ConfigParamChange is a parameter that differs between a version and the
+// one before it
+type ConfigParamChange struct {
+	Parameter string `json:"parameter"`
+	Before    string `json:"before"`
+	After     string `json:"after"`
+	// Redacted is set when the values are hidden from the caller
+	Redacted bool `json:"redacted,omitempty"`
+	// field is the change's path in audit diffs, e.g. relay.relayhost
+	field string
+}
+
+// ConfigAuditEntry is an audit entry that led to a version
+type ConfigAuditEntry struct {
+	ID        int64  `json:"id"`
+	Timestamp string `json:"timestamp"`
+	Username  string `json:"username"`
+	Action    string `json:"action"`
+	Summary   string `json:"summary"`
+	Status    string `json:"status"`
+}
+
+// ConfigHistoryMatch is a version with changes matching a history search
+type ConfigHistoryMatch struct {
+	VersionNumber   int64               `json:"versionNumber"`
+	PreviousVersion int64               `json:"previousVersion,omitempty"`
+	CreatedAt       string              `json:"createdAt"`
+	CreatedBy       string              `json:"createdBy"`
+	Status          string              `json:"status"`
+	Notes           string              `json:"notes,omitempty"`
+	Ticket          string              `json:"ticket,omitempty"`
+	Tags            []string            `json:"tags,omitempty"`
+	Changes         []ConfigParamChange `json:"changes"`
+	// OtherChanges counts the version's changes the search didn't match
+	OtherChanges int                `json:"otherChanges"`
+	Audit        []ConfigAuditEntry `json:"audit"`
+}
+
+// configParams flattens a stored version to its main.cf parameters, keyed
+// by name, with the audit diff path of each
+func configParams(cfg interface{}) (map[string]string, map[string]string) {
+	data, _ := json.Marshal(cfg)
+	var sections map[string]map[string]string
+	json.Unmarshal(data, &sections)
+	params, fields := map[string]string{}, map[string]string{}
+	for section, values := range sections {
+		for name, value := range values {
+			params[name] = value
+			fields[name] = section + "." + name
+		}
+	}
+	return params, fields
+}
+
+// configChanges lists the parameters that differ between two flattened
+// versions. Values come from the redacted copies, so a search can't probe
+// values the caller may not see.
+func configChanges(before, after, beforeShown, afterShown, fields map[string]string) []ConfigParamChange {
+	var changes []ConfigParamChange
+	seen := map[string]bool{}
+	for _, params := range []map[string]string{before, after} {
+		for name := range params {
+			if seen[name] || before[name] == after[name] {
+				continue
+			}
+			seen[name] = true
+			c := ConfigParamChange{Parameter: name, Before: beforeShown[name], After: afterShown[name], field: fields[name]}
+			c.Redacted = c.Before == c.After
+			changes = append(changes, c)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Parameter < changes[j].Parameter })
+	return changes
+}
+
+// configHistoryQuery is what a history search matches changes on. Strings
+// are matched as case-insensitive substrings, parameter exactly.
+type configHistoryQuery struct {
+	parameter, value, from, to, text string
+}
+
+func (q configHistoryQuery) matches(c ConfigParamChange) bool {
+	contains := func(s, sub string) bool {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(sub))
+	}
+	if q.parameter != "" && !strings.EqualFold(c.Parameter, q.parameter) {
+		return false
+	}
+	if c.Redacted && (q.value != "" || q.from != "" || q.to != "") {
+		return false
+	}
+	if q.value != "" && !contains(c.Before, q.value) && !contains(c.After, q.value) {
+		return false
+	}
+	if q.from != "" && !contains(c.Before, q.from) {
+		return false
+	}
+	if q.to != "" && !contains(c.After, q.to) {
+		return false
+	}
+	if q.text != "" && !contains(c.Parameter, q.text) && !contains(c.Before, q.text) && !contains(c.After, q.text) {
+		return false
+	}
+	return true
+}
+
+// searchConfigHistory finds the versions that changed a parameter, e.g.
+// ?parameter=relayhost&from=smtp.old.example for when relayhost moved away
+// from a host. ?value= matches either side of a change, ?q= the parameter
+// name or either value, and ?user=, ?since= and ?until= narrow the
+// versions. Each match lists its matching changes and the audit entries
+// that staged and applied it, newest first.
+func (s *Server) searchConfigHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := configHistoryQuery{
+		parameter: strings.TrimSpace(q.Get("parameter")),
+		value:     strings.TrimSpace(q.Get("value")),
+		from:      strings.TrimSpace(q.Get("from")),
+		to:        strings.TrimSpace(q.Get("to")),
+		text:      strings.TrimSpace(q.Get("q")),
+	}
+	user := strings.TrimSpace(q.Get("user"))
+
+	v := NewValidator()
+	if query == (configHistoryQuery{}) {
+		v.AddError("parameter", "give at least one of parameter, value, from, to or q")
+	}
+	var since, until time.Time
+	for _, p := range []struct {
+		name string
+		dest *time.Time
+	}{{"since", &since}, {"until", &until}} {
+		if value := q.Get(p.name); value != "" {
+			t, err := parseDeliveryTime(value)
+			if err != nil {
+				v.AddError(p.name, "must be an RFC 3339 time or a YYYY-MM-DD date")
+				continue
+			}
+			*p.dest = t
+		}
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+	limit := 50
+	if l := q.Get("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	// Changes are worked out against the version before, so every version
+	// is read in order
+	rows, err := s.db.Query(`
+		SELECT version_number, config_content, created_at, COALESCE(created_by_username, ''), COALESCE(status, ''),
+			COALESCE(notes, ''), COALESCE(ticket, '')
+		FROM config_versions ORDER BY version_number
+	`)
+	if err != nil {
+		http.Error(w, "failed to search history", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	matches := []ConfigHistoryMatch{}
+	var prevParams, prevShown map[string]string
+	var prevVersion int64
+	var prevCreated string
+	for rows.Next() {
+		var m ConfigHistoryMatch
+		var content string
+		if err := rows.Scan(&m.VersionNumber, &content, &m.CreatedAt, &m.CreatedBy, &m.Status, &m.Notes, &m.Ticket); err != nil {
+			continue
+		}
+		var cfg postfix.Config
+		if json.Unmarshal([]byte(content), &cfg) != nil {
+			continue
+		}
+		params, fields := configParams(&cfg)
+		shown, _ := configParams(redactFor(r, &cfg))
+		changes := configChanges(prevParams, params, prevShown, shown, fields)
+		m.PreviousVersion, m.Audit = prevVersion, []ConfigAuditEntry{}
+		windowStart := prevCreated
+		prevParams, prevShown, prevVersion, prevCreated = params, shown, m.VersionNumber, m.CreatedAt
+
+		created, _ := time.Parse(time.RFC3339, m.CreatedAt)
+		if (user != "" && !strings.EqualFold(m.CreatedBy, user)) ||
+			(!since.IsZero() && created.Before(since)) || (!until.IsZero() && !created.Before(until)) {
+			continue
+		}
+		for _, c := range changes {
+			if query.matches(c) {
+				m.Changes = append(m.Changes, c)
+			}
+		}
+		if len(m.Changes) == 0 {
+			continue
+		}
+		m.OtherChanges = len(changes) - len(m.Changes)
+		m.Audit = s.configVersionAudit(m.VersionNumber, windowStart, m.CreatedAt, m.Changes)
+		matches = append(matches, m)
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].VersionNumber > matches[j].VersionNumber })
+	total := len(matches)
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	tags := s.configVersionTags()
+	for i := range matches {
+		matches[i].Tags = tags[matches[i].VersionNumber]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"matches": matches,
+		"total":   total,
+	})
+}
+
+// configVersionAudit returns the audit entries behind a version: those
+// recorded against it (its apply, tags and rollbacks), and the staging
+// since the version before it (after) that touched the changed fields
+func (s *Server) configVersionAudit(version int64, after, until string, changes []ConfigParamChange) []ConfigAuditEntry {
+	touched := map[string]bool{}
+	for _, c := range changes {
+		touched[c.field] = true
+	}
+	if after == "" {
+		after = "0001-01-01T00:00:00Z"
+	}
+
+	entries := []ConfigAuditEntry{}
+	rows, err := s.db.Query(`
+		SELECT id, timestamp, COALESCE(username, ''), action, COALESCE(summary, ''), COALESCE(status, ''),
+			COALESCE(resource_id, ''), COALESCE(diff, '')
+		FROM audit_log
+		WHERE resource_type = 'config' AND (resource_id = ? OR (
+			action IN ('config_submit', 'config_update')
+			AND datetime(timestamp) > datetime(?) AND datetime(timestamp) <= datetime(?)))
+		ORDER BY id
+	`, strconv.FormatInt(version, 10), after, until)
+	if err != nil {
+		return entries
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e ConfigAuditEntry
+		var resourceID, diff string
+		if rows.Scan(&e.ID, &e.Timestamp, &e.Username, &e.Action, &e.Summary, &e.Status, &resourceID, &diff) != nil {
+			continue
+		}
+		// A direct update is only relevant if it changed one of the
+		// matched parameters
+		if e.Action == "config_update" && resourceID != strconv.FormatInt(version, 10) {
+			var diffChanges []AuditChange
+			json.Unmarshal([]byte(diff), &diffChanges)
+			relevant := false
+			for _, c := range diffChanges {
+				relevant = relevant || touched[c.Field]
+			}
+			if !relevant {
+				continue
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
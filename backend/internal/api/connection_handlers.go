@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/connstats"
+	"github.com/postfixrelay/postfixrelay/internal/tlsstats"
+)
+
+// statsWindow parses the window query parameter (a Go duration of at most
+// 31 days), writing a 400 response if it is invalid
+func statsWindow(w http.ResponseWriter, r *http.Request, def time.Duration) (time.Duration, bool) {
+	v := r.URL.Query().Get("window")
+	if v == "" {
+		return def, true
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 || d > 31*24*time.Hour {
+		http.Error(w, "Window must be a duration up to 744h", http.StatusBadRequest)
+		return 0, false
+	}
+	return d, true
+}
+
+// getConnectionStats returns per-client smtpd statistics (top talkers).
+// Query parameters: window (Go duration, default 1h), sort (connections,
+// plaintext, lost or auth_failures), ip and limit.
+func (s *Server) getConnectionStats(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	window, ok := statsWindow(w, r, time.Hour)
+	if !ok {
+		return
+	}
+
+	sortBy := q.Get("sort")
+	if sortBy == "" {
+		sortBy = "connections"
+	} else if !connstats.ValidSort(sortBy) {
+		http.Error(w, "Sort must be connections, plaintext, lost or auth_failures", http.StatusBadRequest)
+		return
+	}
+
+	ip := q.Get("ip")
+	if ip != "" && net.ParseIP(ip) == nil {
+		http.Error(w, "Invalid IP address", http.StatusBadRequest)
+		return
+	}
+
+	limit := 20
+	if l := q.Get("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 500 {
+		limit = 20
+	}
+
+	clients, err := connstats.TopClients(s.db.DB, time.Now().Add(-window), sortBy, ip, limit)
+	if err != nil {
+		http.Error(w, "Failed to load connection statistics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"window":  window.String(),
+		"sort":    sortBy,
+		"clients": clients,
+	})
+}
+
+// getTLSReport returns protocol and cipher usage per direction and peer,
+// with peers still negotiating legacy protocols or plaintext flagged.
+// Query parameters: window (default 168h), direction (inbound or outbound),
+// ip, flagged=true and limit.
+func (s *Server) getTLSReport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	window, ok := statsWindow(w, r, 7*24*time.Hour)
+	if !ok {
+		return
+	}
+
+	opts := tlsstats.ReportOptions{
+		Direction:   q.Get("direction"),
+		IP:          q.Get("ip"),
+		FlaggedOnly: q.Get("flagged") == "true",
+		Limit:       100,
+	}
+	if opts.Direction != "" && opts.Direction != tlsstats.Inbound && opts.Direction != tlsstats.Outbound {
+		http.Error(w, "Direction must be inbound or outbound", http.StatusBadRequest)
+		return
+	}
+	if opts.IP != "" && net.ParseIP(opts.IP) == nil {
+		http.Error(w, "Invalid IP address", http.StatusBadRequest)
+		return
+	}
+	if l := q.Get("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &opts.Limit)
+	}
+	if opts.Limit < 1 || opts.Limit > 1000 {
+		opts.Limit = 100
+	}
+
+	report, err := tlsstats.BuildReport(s.db.DB, time.Now().Add(-window), opts)
+	if err != nil {
+		http.Error(w, "Failed to load TLS statistics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -23,6 +23,9 @@ type Contact struct {
 	Favorite  bool      `json:"favorite"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
+	// Directory marks an autocomplete match from the domain's global
+	// address list rather than the user's own contacts
+	Directory bool `json:"directory,omitempty"`
 }
 
 // ContactRequest represents a create/update contact request
@@ -241,7 +244,8 @@ func (s *Server) deleteContact(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"message": "Contact deleted"})
 }
 
-// searchContacts searches contacts for autocomplete
+// searchContacts searches contacts for autocomplete, followed by matches
+// from the domain's global address list when it is shared
 func (s *Server) searchContacts(w http.ResponseWriter, r *http.Request) {
 	session := getMailSession(r.Context())
 	if session == nil {
@@ -287,6 +291,7 @@ func (s *Server) searchContacts(w http.ResponseWriter, r *http.Request) {
 		c.Notes = notes.String
 		contacts = append(contacts, c)
 	}
+	contacts = s.addDirectoryMatches(contacts, session.Email, q, 10)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(contacts)
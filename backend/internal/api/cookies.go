@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gorilla/csrf"
+)
+
+// cookieSecure reports whether cookies set on a response to r get the
+// Secure attribute, per cookie_secure
+func (s *Server) cookieSecure(r *http.Request) bool {
+	switch s.cfg.CookieSecure {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return os.Getenv("ENV") == "production" || (r != nil && r.TLS != nil)
+}
+
+// cookieSameSite returns the SameSite attribute of session cookies, per
+// cookie_samesite
+func (s *Server) cookieSameSite() http.SameSite {
+	switch s.cfg.CookieSameSite {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteStrictMode
+}
+
+// csrfSameSite is cookieSameSite for the CSRF cookie
+func (s *Server) csrfSameSite() csrf.SameSiteMode {
+	switch s.cookieSameSite() {
+	case http.SameSiteLaxMode:
+		return csrf.SameSiteLaxMode
+	case http.SameSiteNoneMode:
+		return csrf.SameSiteNoneMode
+	}
+	return csrf.SameSiteStrictMode
+}
+
+// isTokenAuthenticated reports whether a request authenticates with a
+// bearer token rather than the session cookie. Browsers never add an
+// Authorization header on their own, so such a request can't be forged
+// from another site and needs no CSRF token. A request that also carries
+// the session cookie is authenticated by the cookie, and stays protected.
+func isTokenAuthenticated(r *http.Request) bool {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return false
+	}
+	cookie, err := r.Cookie(sessionCookieName)
+	return err != nil || cookie.Value == ""
+}
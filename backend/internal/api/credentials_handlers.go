@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+)
+
+// savedCredential is a sasl_passwd entry without its password
+type savedCredential struct {
+	Key       string `json:"key"`
+	Relayhost string `json:"relayhost,omitempty"`
+	Sender    string `json:"sender,omitempty"`
+	Username  string `json:"username"`
+}
+
+// getCredentials lists the relay host and sender credentials Postfix
+// authenticates with, without their passwords
+func (s *Server) getCredentials(w http.ResponseWriter, r *http.Request) {
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+
+	creds := []savedCredential{}
+	for _, c := range postfixMgr.GetSASLCredentials() {
+		creds = append(creds, savedCredential{Key: c.Key(), Relayhost: c.Relayhost, Sender: c.Sender, Username: c.Username})
+	}
+	senderAuth, _ := postfixMgr.GetParameter("smtp_sender_dependent_authentication")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"credentials":                   creds,
+		"senderDependentAuthentication": senderAuth == "yes",
+	})
+}
+
+// deleteCredentials removes the credentials for a relay host or sender
+func (s *Server) deleteCredentials(w http.ResponseWriter, r *http.Request) {
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+
+	key, err := url.PathUnescape(chi.URLParam(r, "key"))
+	if err != nil || key == "" {
+		http.Error(w, "invalid key", http.StatusBadRequest)
+		return
+	}
+	found := false
+	for _, c := range postfixMgr.GetSASLCredentials() {
+		found = found || c.Key() == key
+	}
+	if !found {
+		http.Error(w, "no credentials for "+key, http.StatusNotFound)
+		return
+	}
+
+	if err := postfixMgr.DeleteSASLCredentials(key); err != nil {
+		http.Error(w, "failed to delete credentials: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if u := GetUser(r.Context()); u != nil {
+		s.logAudit(u.ID, u.Username, "credentials_delete", "sasl", key, "Deleted SASL credentials for "+key, "success", r.RemoteAddr)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/alerts"
+	"github.com/postfixrelay/postfixrelay/internal/deliverystats"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/rs/zerolog/log"
+)
+
+// dashboardCacheTTL bounds how often the landing page re-runs the queue and
+// certificate checks
+const dashboardCacheTTL = 15 * time.Second
+
+var (
+	dashboardMu     sync.Mutex
+	dashboardCached *dashboardResponse
+)
+
+type dashboardResponse struct {
+	GeneratedAt     time.Time            `json:"generatedAt"`
+	Queue           queueStatus          `json:"queue"`
+	Deliveries      dashboardDeliveries  `json:"deliveries"`
+	Alerts          dashboardAlerts      `json:"alerts"`
+	Certificates    []certificateWarning `json:"certificateWarnings"`
+	LastConfigApply *configApply         `json:"lastConfigApply"`
+}
+
+// trend is a total for the current period and its change from the
+// previous one. DeltaPercent is omitted when the previous period was zero.
+type trend struct {
+	Current      int      `json:"current"`
+	Previous     int      `json:"previous"`
+	Delta        int      `json:"delta"`
+	DeltaPercent *float64 `json:"deltaPercent,omitempty"`
+}
+
+func newTrend(current, previous int) trend {
+	t := trend{Current: current, Previous: previous, Delta: current - previous}
+	if previous > 0 {
+		pct := float64(current-previous) / float64(previous) * 100
+		t.DeltaPercent = &pct
+	}
+	return t
+}
+
+type dashboardDeliveries struct {
+	PeriodHours int   `json:"periodHours"`
+	Sent        trend `json:"sent"`
+	Bounced     trend `json:"bounced"`
+	Deferred    trend `json:"deferred"`
+	// RecentBounces are the latest bounced messages, for linking to their
+	// traces
+	RecentBounces []deliverystats.Delivery `json:"recentBounces"`
+}
+
+type dashboardAlerts struct {
+	Active   int            `json:"active"`
+	Critical int            `json:"critical"`
+	Recent   []alerts.Alert `json:"recent"` // Newest active alerts, at most 5
+}
+
+type certificateWarning struct {
+	Type          string    `json:"type"`
+	Subject       string    `json:"subject,omitempty"`
+	ValidTo       time.Time `json:"validTo"`
+	DaysRemaining int       `json:"daysRemaining"`
+	Expired       bool      `json:"expired"`
+}
+
+type configApply struct {
+	Version   int       `json:"version"`
+	AppliedAt time.Time `json:"appliedAt"`
+	AppliedBy string    `json:"appliedBy,omitempty"`
+	Status    string    `json:"status"`
+}
+
+// getDashboard returns everything the landing page shows in one response
+func (s *Server) getDashboard(w http.ResponseWriter, r *http.Request) {
+	dashboardMu.Lock()
+	resp := dashboardCached
+	if resp == nil || time.Since(resp.GeneratedAt) > dashboardCacheTTL {
+		resp = s.buildDashboard(time.Now().UTC())
+		dashboardCached = resp
+	}
+	dashboardMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) buildDashboard(now time.Time) *dashboardResponse {
+	resp := &dashboardResponse{
+		GeneratedAt:  now,
+		Certificates: []certificateWarning{},
+	}
+
+	// The queue and certificate checks shell out and read files, so run
+	// them alongside the database queries
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.initQueueManager()
+		active, deferred, hold, corrupt := queueMgr.GetQueueSummary()
+		resp.Queue = queueStatus{Active: active, Deferred: deferred, Hold: hold, Corrupt: corrupt}
+	}()
+	go func() {
+		defer wg.Done()
+		resp.Certificates = s.certificateWarnings(now)
+	}()
+
+	resp.Deliveries = s.deliveryTrends(now)
+	resp.Alerts = s.dashboardAlerts()
+	resp.LastConfigApply = s.lastConfigApply()
+
+	wg.Wait()
+	return resp
+}
+
+// deliveryTrends compares the last 24 hourly buckets (including the
+// current hour) with the 24 before them
+func (s *Server) deliveryTrends(now time.Time) dashboardDeliveries {
+	end := now.Truncate(time.Hour).Add(time.Hour)
+	start := end.Add(-24 * time.Hour)
+
+	current, err := deliverystats.Totals(s.db.DB, start, end)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load delivery statistics")
+	}
+	previous, err := deliverystats.Totals(s.db.DB, start.Add(-24*time.Hour), start)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load delivery statistics")
+	}
+
+	result := dashboardDeliveries{
+		PeriodHours:   24,
+		Sent:          newTrend(current[deliverystats.StatusSent], previous[deliverystats.StatusSent]),
+		Bounced:       newTrend(current[deliverystats.StatusBounced], previous[deliverystats.StatusBounced]),
+		Deferred:      newTrend(current[deliverystats.StatusDeferred], previous[deliverystats.StatusDeferred]),
+		RecentBounces: []deliverystats.Delivery{},
+	}
+	if deliveryStats != nil {
+		result.RecentBounces = deliveryStats.Recent(deliverystats.StatusBounced, 10)
+	}
+	return result
+}
+
+func (s *Server) dashboardAlerts() dashboardAlerts {
+	result := dashboardAlerts{Recent: []alerts.Alert{}}
+	if alertEngine == nil {
+		return result
+	}
+
+	active, err := alertEngine.GetActiveAlerts()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load active alerts")
+		return result
+	}
+	result.Active = len(active)
+	for _, a := range active {
+		if a.Severity == alerts.SeverityCritical {
+			result.Critical++
+		}
+	}
+	if len(active) > 5 {
+		active = active[:5]
+	}
+	result.Recent = append(result.Recent, active...)
+	return result
+}
+
+// certificateWarnings returns installed certificates that expire within
+// cert_expiry_warning_days or already have
+func (s *Server) certificateWarnings(now time.Time) []certificateWarning {
+	warnings := []certificateWarning{}
+
+	mgr := postfixMgr
+	if mgr == nil {
+		mgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+	certs, err := mgr.GetCertificates()
+	if err != nil {
+		return warnings
+	}
+
+	threshold := time.Duration(s.db.GetSettingInt("cert_expiry_warning_days", 30)) * 24 * time.Hour
+	for _, c := range certs {
+		if c.ValidTo.IsZero() || c.ValidTo.Sub(now) > threshold {
+			continue
+		}
+		warnings = append(warnings, certificateWarning{
+			Type:          c.Type,
+			Subject:       c.Subject,
+			ValidTo:       c.ValidTo,
+			DaysRemaining: int(c.ValidTo.Sub(now).Hours() / 24),
+			Expired:       !c.ValidTo.After(now),
+		})
+	}
+	return warnings
+}
+
+func (s *Server) lastConfigApply() *configApply {
+	var apply configApply
+	err := s.db.QueryRow(`
+		SELECT v.version_number, v.applied_at, COALESCE(u.username, ''), v.status
+		FROM config_versions v LEFT JOIN users u ON u.id = v.applied_by_id
+		WHERE v.applied_at IS NOT NULL
+		ORDER BY v.applied_at DESC
+		LIMIT 1
+	`).Scan(&apply.Version, &apply.AppliedAt, &apply.AppliedBy, &apply.Status)
+	if err != nil {
+		return nil
+	}
+	return &apply
+}
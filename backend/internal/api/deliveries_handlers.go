@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/deliveries"
+)
+
+// deliveryStatuses are the statuses GET /deliveries filters on
+var deliveryStatuses = map[string]bool{
+	deliveries.StatusSent:     true,
+	deliveries.StatusBounced:  true,
+	deliveries.StatusDeferred: true,
+	deliveries.StatusExpired:  true,
+}
+
+// deliveryScope limits tenant users to mail from or to their tenant's
+// domains; nil means no limit
+func (s *Server) deliveryScope(r *http.Request) []string {
+	tenantID := tenantOf(r)
+	if tenantID == 0 {
+		return nil
+	}
+	domains := []string{}
+	for d := range s.tenantDomains(tenantID) {
+		domains = append(domains, d)
+	}
+	return domains
+}
+
+// parseDeliveryTime reads an RFC 3339 time or a YYYY-MM-DD date (midnight
+// UTC)
+func parseDeliveryTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// getDeliveries answers "was this message delivered?": one row per
+// message and recipient with its latest status, relay and delays. Filters:
+// ?recipient=, ?sender= (substrings), ?status=, ?queueId=, and ?since= and
+// ?until= bounding the latest attempt.
+func (s *Server) getDeliveries(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := deliveries.Filter{
+		Recipient: strings.TrimSpace(q.Get("recipient")),
+		Sender:    strings.TrimSpace(q.Get("sender")),
+		Status:    strings.ToLower(strings.TrimSpace(q.Get("status"))),
+		QueueID:   strings.ToUpper(strings.TrimSpace(q.Get("queueId"))),
+		Limit:     100,
+	}
+
+	v := NewValidator()
+	if f.Status != "" && !deliveryStatuses[f.Status] {
+		v.AddError("status", "must be sent, bounced, deferred or expired")
+	}
+	for _, p := range []struct {
+		name string
+		dest *time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		if value := q.Get(p.name); value != "" {
+			t, err := parseDeliveryTime(value)
+			if err != nil {
+				v.AddError(p.name, "must be an RFC 3339 time or a YYYY-MM-DD date")
+				continue
+			}
+			*p.dest = t
+		}
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	if l := q.Get("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &f.Limit)
+	}
+	if f.Limit < 1 || f.Limit > 1000 {
+		f.Limit = 100
+	}
+	if o := q.Get("offset"); o != "" {
+		fmt.Sscanf(o, "%d", &f.Offset)
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+	f.Domains = s.deliveryScope(r)
+
+	// Attempts still waiting to be written would be missed
+	if deliveryTracker != nil {
+		deliveryTracker.Flush()
+	}
+	results, total, err := deliveries.Query(s.db.DB, f)
+	if err != nil {
+		http.Error(w, "Failed to load deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deliveries": redactFor(r, results),
+		"total":      total,
+		"limit":      f.Limit,
+		"offset":     f.Offset,
+	})
+}
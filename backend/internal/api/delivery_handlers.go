@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+)
+
+// deliveryKeys are the main.cf parameters of the delivery config section
+var deliveryKeys = []string{"virtual_transport", "mailbox_size_limit", "lmtp_destination_recipient_limit"}
+
+// validateDelivery checks a delivery config section. Empty values leave
+// the Postfix defaults in place.
+func validateDelivery(v *Validator, d *postfix.DeliveryConfig) {
+	if d.VirtualTransport != "" {
+		if _, err := postfix.ParseLMTPTransport(d.VirtualTransport); err != nil {
+			v.AddError("virtual_transport", "must be an LMTP destination such as lmtp:unix:private/dovecot-lmtp or lmtp:inet:dovecot:24")
+		}
+	}
+	if d.MailboxSizeLimit != "" {
+		if _, err := strconv.ParseUint(d.MailboxSizeLimit, 10, 64); err != nil {
+			v.AddError("mailbox_size_limit", "must be zero (unlimited) or a positive integer")
+		}
+	}
+	if d.LMTPDestinationRecipientLimit != "" {
+		if n, err := strconv.ParseUint(d.LMTPDestinationRecipientLimit, 10, 32); err != nil || n == 0 {
+			v.AddError("lmtp_destination_recipient_limit", "must be a positive integer")
+		}
+	}
+}
+
+// stagesDelivery reports whether staged changes touch the delivery section
+func stagesDelivery(updates map[string]interface{}) bool {
+	for _, key := range deliveryKeys {
+		if _, ok := updates[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// getDeliveryCheck checks that Postfix's virtual_transport and Dovecot's
+// LMTP service agree and that Dovecot answers on the socket
+func (s *Server) getDeliveryCheck(w http.ResponseWriter, r *http.Request) {
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+
+	cfg, err := postfixMgr.ReadConfig()
+	if err != nil {
+		http.Error(w, "failed to read current config", http.StatusInternalServerError)
+		return
+	}
+	check := s.dovecotSyncer.CheckDelivery(cfg.Delivery.VirtualTransport)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":    check.OK(),
+		"check": check,
+	})
+}
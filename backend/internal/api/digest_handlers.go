@@ -0,0 +1,188 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/digest"
+	"github.com/postfixrelay/postfixrelay/internal/i18n"
+	"github.com/postfixrelay/postfixrelay/internal/mail"
+)
+
+// digestScheduler sends scheduled digest emails to subscribed users
+var digestScheduler *digest.Scheduler
+
+// NotificationPreferences are a user's personal notification settings
+type NotificationPreferences struct {
+	DigestFrequency digest.Period `json:"digestFrequency"` // none, daily or weekly
+	Locale          string        `json:"locale"`          // Language for digest emails
+	UpdatedAt       *time.Time    `json:"updatedAt,omitempty"`
+}
+
+// startDigestScheduler starts the background digest sender
+func (s *Server) startDigestScheduler() {
+	hostname, _ := os.Hostname()
+	digestScheduler = digest.NewScheduler(s.db.DB, hostname, s.sendDigestEmail, s.digestQueueHealth)
+	digestScheduler.Start()
+}
+
+// sendDigestEmail delivers a digest through the local relay
+func (s *Server) sendDigestEmail(to string, d *digest.Rendered) error {
+	if relaySender == nil {
+		return fmt.Errorf("mail services are not initialized")
+	}
+
+	from := s.db.GetSetting("digest_from", "")
+	if from == "" {
+		hostname, _ := os.Hostname()
+		from = "postfixrelay@" + hostname
+	}
+
+	_, err := relaySender.Send(from, "", &mail.ComposeMessage{
+		To:       []string{to},
+		Subject:  d.Subject,
+		Body:     d.Text,
+		HTMLBody: d.HTML,
+	})
+	return err
+}
+
+func (s *Server) digestQueueHealth() digest.QueueHealth {
+	s.initQueueManager()
+	active, deferred, hold, corrupt := queueMgr.GetQueueSummary()
+	return digest.QueueHealth{Active: active, Deferred: deferred, Hold: hold, Corrupt: corrupt}
+}
+
+// getNotificationPreferences returns the current user's preferences
+func (s *Server) getNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	prefs := NotificationPreferences{
+		DigestFrequency: digest.PeriodNone,
+		Locale:          i18n.FromContext(r.Context()),
+	}
+	var frequency, locale string
+	var updatedAt time.Time
+	err := s.db.QueryRow(`
+		SELECT digest_frequency, locale, updated_at FROM user_notification_preferences WHERE user_id = ?
+	`, user.ID).Scan(&frequency, &locale, &updatedAt)
+	if err == nil {
+		prefs.Locale = locale
+		prefs.DigestFrequency = digest.Period(frequency)
+		prefs.UpdatedAt = &updatedAt
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prefs)
+}
+
+// updateNotificationPreferences sets the current user's preferences
+func (s *Server) updateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req NotificationPreferences
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	v := NewValidator()
+	if !req.DigestFrequency.Valid() {
+		v.AddErrorf("digestFrequency", "must be one of: %s", "none, daily, weekly")
+	}
+	if req.Locale == "" {
+		req.Locale = i18n.FromContext(r.Context())
+	} else if !i18n.IsSupported(req.Locale) {
+		v.AddErrorf("locale", "must be one of: %s", strings.Join(i18n.Supported(), ", "))
+	}
+	if req.DigestFrequency != digest.PeriodNone && user.Email == "" {
+		v.AddError("digestFrequency", "an email address is required to receive digests")
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO user_notification_preferences (user_id, digest_frequency, locale, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id) DO UPDATE SET
+			digest_frequency = excluded.digest_frequency,
+			locale = excluded.locale,
+			updated_at = CURRENT_TIMESTAMP
+	`, user.ID, string(req.DigestFrequency), req.Locale)
+	if err != nil {
+		http.Error(w, "failed to save preferences", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "notification_preferences_update", "user", fmt.Sprintf("%d", user.ID),
+		"Digest frequency set to "+string(req.DigestFrequency), "success", r.RemoteAddr)
+
+	s.getNotificationPreferences(w, r)
+}
+
+// digestPeriodParam reads the period query parameter (default daily)
+func digestPeriodParam(r *http.Request) (digest.Period, bool) {
+	period := digest.Period(r.URL.Query().Get("period"))
+	if period == "" {
+		period = digest.PeriodDaily
+	}
+	return period, period == digest.PeriodDaily || period == digest.PeriodWeekly
+}
+
+// previewDigest renders a digest for the current period without sending it
+func (s *Server) previewDigest(w http.ResponseWriter, r *http.Request) {
+	period, ok := digestPeriodParam(r)
+	if !ok {
+		http.Error(w, "period must be daily or weekly", http.StatusBadRequest)
+		return
+	}
+
+	rendered, err := digestScheduler.Preview(period, time.Now(), i18n.FromContext(r.Context()))
+	if err != nil {
+		http.Error(w, "failed to build digest: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rendered)
+}
+
+// sendDigest sends a digest to its subscribers immediately
+func (s *Server) sendDigest(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	period, ok := digestPeriodParam(r)
+	if !ok {
+		http.Error(w, "period must be daily or weekly", http.StatusBadRequest)
+		return
+	}
+
+	sent, err := digestScheduler.SendNow(period, time.Now())
+	if err != nil {
+		s.auditLog(user.ID, user.Username, "digest_send", "digest", string(period), "Manual digest send", "failure", err.Error(), r)
+		http.Error(w, "Failed to send digest: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "digest_send", "digest", string(period),
+		fmt.Sprintf("Sent %s digest to %d recipients", period, sent), "success", "", r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"period": period,
+		"sent":   sent,
+	})
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DirectoryEntry is an address in a domain's global address list
+type DirectoryEntry struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"` // the mailbox's display name
+	Kind  string `json:"kind"`           // mailbox or alias
+}
+
+// maxDirectoryEntries caps a directory listing
+const maxDirectoryEntries = 1000
+
+// directoryEnabled reports whether the domain of a mailbox shares its
+// global address list
+func (s *Server) directoryEnabled(email string) bool {
+	var enabled bool
+	err := s.db.QueryRow(`
+		SELECT d.directory_enabled FROM mail_domains d
+		WHERE d.domain = ? AND d.active = TRUE
+	`, domainOf(email)).Scan(&enabled)
+	return err == nil && enabled
+}
+
+// domainOf returns the lowercased domain of an address
+func domainOf(email string) string {
+	if i := strings.LastIndexByte(email, '@'); i >= 0 {
+		return strings.ToLower(email[i+1:])
+	}
+	return ""
+}
+
+// directoryEntries returns the active mailboxes and aliases of the
+// mailbox's domain matching q (all when empty), when the domain shares
+// them. Catch-alls aren't addresses anyone can write to, so they're left
+// out, as are aliases that are also a mailbox.
+func (s *Server) directoryEntries(email, q string, limit int) ([]DirectoryEntry, error) {
+	entries := []DirectoryEntry{}
+	if !s.directoryEnabled(email) {
+		return entries, nil
+	}
+
+	pattern := "%" + q + "%"
+	rows, err := s.db.Query(`
+		SELECT m.email, COALESCE(m.display_name, ''), 'mailbox'
+		FROM mailboxes m JOIN mail_domains d ON d.id = m.domain_id
+		WHERE d.domain = ? AND m.active = TRUE AND (m.email LIKE ? OR m.display_name LIKE ?)
+		UNION
+		SELECT DISTINCT a.source_email, '', 'alias'
+		FROM mail_aliases a JOIN mail_domains d ON d.id = a.domain_id
+		WHERE d.domain = ? AND a.active = TRUE AND a.source_email NOT LIKE '@%' AND a.source_email LIKE ?
+			AND a.source_email NOT IN (SELECT email FROM mailboxes)
+		ORDER BY 1
+		LIMIT ?
+	`, domainOf(email), pattern, pattern, domainOf(email), pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e DirectoryEntry
+		if err := rows.Scan(&e.Email, &e.Name, &e.Kind); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// getDirectory lists the global address list of the user's domain
+func (s *Server) getDirectory(w http.ResponseWriter, r *http.Request) {
+	session := getMailSession(r.Context())
+	if session == nil {
+		http.Error(w, "Session not found", http.StatusUnauthorized)
+		return
+	}
+
+	entries, err := s.directoryEntries(session.Email, strings.TrimSpace(r.URL.Query().Get("q")), maxDirectoryEntries)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query directory")
+		http.Error(w, "Failed to load directory", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": s.directoryEnabled(session.Email),
+		"domain":  domainOf(session.Email),
+		"entries": entries,
+	})
+}
+
+// addDirectoryMatches appends directory entries to autocomplete results,
+// up to limit in all, skipping addresses already among the personal
+// contacts
+func (s *Server) addDirectoryMatches(contacts []Contact, email, q string, limit int) []Contact {
+	if len(contacts) >= limit {
+		return contacts
+	}
+	entries, err := s.directoryEntries(email, q, limit)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to search directory")
+		return contacts
+	}
+
+	seen := make(map[string]bool, len(contacts))
+	for _, c := range contacts {
+		seen[strings.ToLower(c.Email)] = true
+	}
+	for _, e := range entries {
+		if len(contacts) >= limit {
+			break
+		}
+		if seen[strings.ToLower(e.Email)] {
+			continue
+		}
+		contacts = append(contacts, Contact{Email: e.Email, Name: e.Name, Directory: true})
+	}
+	return contacts
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/disclaimer"
+	"github.com/postfixrelay/postfixrelay/internal/mail"
+	"github.com/rs/zerolog/log"
+)
+
+// applyDisclaimer appends the sender's domain footer to an outbound
+// message unless it is exempt. A footer that can't be loaded is logged
+// rather than holding up the message.
+func (s *Server) applyDisclaimer(from string, msg *mail.ComposeMessage) {
+	d, err := disclaimer.ForSender(s.db.DB, from)
+	if err != nil {
+		log.Error().Err(err).Str("from", from).Msg("Failed to load disclaimer; sending without it")
+		return
+	}
+	if d == nil {
+		return
+	}
+	if reason := d.Exempt(from, messageRecipients(msg)); reason != "" {
+		log.Debug().Str("from", from).Str("reason", reason).Msg("Disclaimer not added")
+		return
+	}
+	d.Apply(msg)
+}
+
+// messageRecipients returns every To, Cc and Bcc address of msg
+func messageRecipients(msg *mail.ComposeMessage) []string {
+	return append(append(append([]string{}, msg.To...), msg.Cc...), msg.Bcc...)
+}
+
+// DisclaimerRequest creates or replaces a domain's disclaimer
+type DisclaimerRequest struct {
+	Enabled          *bool    `json:"enabled"`
+	Text             string   `json:"text"`
+	HTML             string   `json:"html"`
+	SkipInternal     bool     `json:"skipInternal"`
+	ExemptSenders    []string `json:"exemptSenders"`
+	ExemptRecipients []string `json:"exemptRecipients"`
+}
+
+// disclaimer builds the disclaimer a request describes for domain,
+// validating it
+func (req *DisclaimerRequest) disclaimer(domain string) (*disclaimer.Disclaimer, *Validator) {
+	d := &disclaimer.Disclaimer{
+		Domain:           strings.ToLower(strings.TrimSpace(domain)),
+		Enabled:          req.Enabled == nil || *req.Enabled,
+		Text:             strings.TrimSpace(req.Text),
+		HTML:             strings.TrimSpace(req.HTML),
+		SkipInternal:     req.SkipInternal,
+		ExemptSenders:    req.ExemptSenders,
+		ExemptRecipients: req.ExemptRecipients,
+	}
+	v := NewValidator()
+	v.ValidateDomain("domain", d.Domain)
+	v.ValidateMaxLength("text", d.Text, 10000)
+	v.ValidateMaxLength("html", d.HTML, 50000)
+	for field, problem := range d.Validate() {
+		v.AddError(field, problem)
+	}
+	return d, v
+}
+
+// listDisclaimers returns every domain's disclaimer
+func (s *Server) listDisclaimers(w http.ResponseWriter, r *http.Request) {
+	list, err := disclaimer.List(s.db.DB)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list disclaimers")
+		http.Error(w, "Failed to list disclaimers", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"disclaimers": list,
+	})
+}
+
+// saveDisclaimer creates or replaces the disclaimer of the domain in the
+// URL
+func (s *Server) saveDisclaimer(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+
+	var req DisclaimerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	d, v := req.disclaimer(chi.URLParam(r, "domain"))
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	before, err := disclaimer.Get(s.db.DB, d.Domain)
+	if err != nil && !errors.Is(err, disclaimer.ErrNotFound) {
+		http.Error(w, "Failed to load disclaimer", http.StatusInternalServerError)
+		return
+	}
+	d.UpdatedBy = user.Username
+	if err := disclaimer.Save(s.db.DB, d); err != nil {
+		log.Error().Err(err).Str("domain", d.Domain).Msg("Failed to save disclaimer")
+		http.Error(w, "Failed to save disclaimer", http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := disclaimer.Get(s.db.DB, d.Domain)
+	if err != nil {
+		saved = d
+	}
+	s.logAuditDiff(user, "update", "disclaimer", d.Domain, "Saved the disclaimer of "+d.Domain, auditDiff(before, saved), r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+// deleteDisclaimer removes the disclaimer of the domain in the URL
+func (s *Server) deleteDisclaimer(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	domain := strings.ToLower(chi.URLParam(r, "domain"))
+
+	if err := disclaimer.Delete(s.db.DB, domain); err != nil {
+		if errors.Is(err, disclaimer.ErrNotFound) {
+			http.Error(w, "Disclaimer not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete disclaimer", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "disclaimer_delete", "disclaimer", domain, "Deleted the disclaimer of "+domain, "success", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// previewDisclaimer shows what a disclaimer, saved or not, does to a
+// sample message: whether the message is exempt, and its text and HTML
+// bodies with the footer added
+func (s *Server) previewDisclaimer(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Disclaimer DisclaimerRequest `json:"disclaimer"`
+		From       string            `json:"from"`
+		To         []string          `json:"to"`
+		Text       string            `json:"text"`
+		HTML       string            `json:"html"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	v := NewValidator()
+	v.ValidateEmail("from", req.From)
+	d, dv := req.Disclaimer.disclaimer(disclaimer.Domain(req.From))
+	for _, e := range dv.Errors() {
+		v.AddError("disclaimer."+e.Field, e.Message)
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+	if req.Text == "" && req.HTML == "" {
+		req.Text = "Hello,\n\nThis is a sample message.\n"
+		req.HTML = "<html><body><p>Hello,</p><p>This is a sample message.</p></body></html>"
+	}
+
+	msg := &mail.ComposeMessage{To: req.To, Body: req.Text, HTMLBody: req.HTML}
+	exempt := d.Exempt(req.From, req.To)
+	if exempt == "" {
+		d.Apply(msg)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"exempt":       exempt != "",
+		"exemptReason": exempt,
+		"text":         msg.Body,
+		"html":         msg.HTMLBody,
+	})
+}
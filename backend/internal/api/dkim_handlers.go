@@ -0,0 +1,383 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/crypto"
+	"github.com/postfixrelay/postfixrelay/internal/dkim"
+	"github.com/postfixrelay/postfixrelay/internal/supervisor"
+	"github.com/rs/zerolog/log"
+)
+
+// DKIMKey is a domain's signing key without its private half
+type DKIMKey struct {
+	ID           int64      `json:"id"`
+	DomainID     int64      `json:"domainId"`
+	Domain       string     `json:"domain"`
+	Selector     string     `json:"selector"`
+	Algorithm    string     `json:"algorithm"`
+	Bits         int        `json:"bits,omitempty"`
+	Active       bool       `json:"active"`
+	RecordName   string     `json:"recordName"`
+	Record       string     `json:"record"`
+	ZoneEntry    string     `json:"zoneEntry"`
+	DNSStatus    string     `json:"dnsStatus,omitempty"`
+	DNSCheckedAt *time.Time `json:"dnsCheckedAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+
+	key dkim.Key
+}
+
+const dkimKeyColumns = `k.id, k.domain_id, d.domain, k.selector, k.algorithm, k.bits, k.public_key, k.active,
+	COALESCE(k.dns_status, ''), k.dns_checked_at, k.created_at`
+
+func scanDKIMKey(row interface{ Scan(...interface{}) error }) (DKIMKey, error) {
+	var k DKIMKey
+	var publicKey string
+	err := row.Scan(&k.ID, &k.DomainID, &k.Domain, &k.Selector, &k.Algorithm, &k.Bits, &publicKey, &k.Active,
+		&k.DNSStatus, &k.DNSCheckedAt, &k.CreatedAt)
+	if err != nil {
+		return k, err
+	}
+	k.key = dkim.Key{Domain: k.Domain, Selector: k.Selector, Algorithm: k.Algorithm, Bits: k.Bits, PublicKey: publicKey}
+	k.RecordName, k.Record, k.ZoneEntry = k.key.RecordName(), k.key.Record(), k.key.ZoneEntry()
+	return k, nil
+}
+
+// dkimEncryptor encrypts private keys at rest
+func (s *Server) dkimEncryptor() (*crypto.Encryptor, error) {
+	return crypto.NewEncryptor(s.cfg.DBEncryptionKey)
+}
+
+// loadDKIMKey returns a key the caller may manage, or writes a 404
+func (s *Server) loadDKIMKey(w http.ResponseWriter, r *http.Request) (DKIMKey, bool) {
+	k, err := scanDKIMKey(s.db.QueryRow(`
+		SELECT `+dkimKeyColumns+`
+		FROM dkim_keys k JOIN mail_domains d ON k.domain_id = d.id
+		WHERE k.id = ?
+	`, chi.URLParam(r, "id")))
+	if err != nil || !s.domainInScope(r, k.DomainID) {
+		http.Error(w, "DKIM key not found", http.StatusNotFound)
+		return k, false
+	}
+	return k, true
+}
+
+// listDKIMKeys returns the signing keys, optionally of one domain
+// (?domain_id=), with the DNS record each needs
+func (s *Server) listDKIMKeys(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT ` + dkimKeyColumns + ` FROM dkim_keys k JOIN mail_domains d ON k.domain_id = d.id`
+	var conds []string
+	var args []interface{}
+	if domainID := r.URL.Query().Get("domain_id"); domainID != "" {
+		conds = append(conds, "k.domain_id = ?")
+		args = append(args, domainID)
+	}
+	if tenantID := tenantOf(r); tenantID != 0 {
+		conds = append(conds, "d.tenant_id = ?")
+		args = append(args, tenantID)
+	}
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY d.domain, k.active DESC, k.created_at DESC"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query DKIM keys")
+		http.Error(w, "Failed to query DKIM keys", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	keys := []DKIMKey{}
+	for rows.Next() {
+		k, err := scanDKIMKey(rows)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to scan DKIM key")
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys":   keys,
+		"signer": s.db.GetSetting("dkim_signer", "none"),
+	})
+}
+
+// getDKIMKey returns one signing key
+func (s *Server) getDKIMKey(w http.ResponseWriter, r *http.Request) {
+	k, ok := s.loadDKIMKey(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(k)
+}
+
+// createDKIMKey generates a keypair for a domain. The domain's first key
+// becomes its active one; later keys, for rotation, are activated once
+// their record is published.
+func (s *Server) createDKIMKey(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	var req struct {
+		DomainID  int64  `json:"domainId"`
+		Selector  string `json:"selector"`
+		Algorithm string `json:"algorithm"`
+		Bits      int    `json:"bits"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var domain string
+	if err := s.db.QueryRow("SELECT domain FROM mail_domains WHERE id = ?", req.DomainID).Scan(&domain); err != nil || !s.domainInScope(r, req.DomainID) {
+		http.Error(w, "Domain not found", http.StatusBadRequest)
+		return
+	}
+	req.Selector = strings.TrimSpace(req.Selector)
+	if req.Selector == "" {
+		req.Selector = "psfx" + time.Now().UTC().Format("200601")
+	}
+	if req.Algorithm == "" {
+		req.Algorithm = dkim.AlgorithmRSA
+	}
+
+	v := NewValidator()
+	if !dkim.ValidSelector(req.Selector) {
+		v.AddError("selector", "must be DNS labels of letters, digits and dashes")
+	}
+	if req.Algorithm != dkim.AlgorithmRSA && req.Algorithm != dkim.AlgorithmEd25519 {
+		v.AddErrorf("algorithm", "must be one of: %s", "rsa, ed25519")
+	}
+	if req.Algorithm == dkim.AlgorithmRSA && req.Bits != 0 && req.Bits != 1024 && req.Bits != 2048 && req.Bits != 4096 {
+		v.AddErrorf("bits", "must be one of: %s", "1024, 2048, 4096")
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	key, err := dkim.Generate(domain, req.Selector, req.Algorithm, req.Bits)
+	if err != nil {
+		http.Error(w, "Failed to generate key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	enc, err := s.dkimEncryptor()
+	if err != nil {
+		http.Error(w, "Private keys can't be stored: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sealed, err := enc.Encrypt(key.PrivateKeyPEM)
+	if err != nil {
+		http.Error(w, "Failed to encrypt private key", http.StatusInternalServerError)
+		return
+	}
+
+	var existing int
+	s.db.QueryRow("SELECT COUNT(*) FROM dkim_keys WHERE domain_id = ? AND active = TRUE", req.DomainID).Scan(&existing)
+	result, err := s.db.Exec(`
+		INSERT INTO dkim_keys (domain_id, selector, algorithm, bits, private_key, public_key, active, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, req.DomainID, key.Selector, key.Algorithm, key.Bits, sealed, key.PublicKey, existing == 0, user.ID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			http.Error(w, "The domain already has a key with this selector", http.StatusConflict)
+			return
+		}
+		log.Error().Err(err).Msg("Failed to store DKIM key")
+		http.Error(w, "Failed to store DKIM key", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	s.auditLog(user.ID, user.Username, "create", "dkim_key", strconv.FormatInt(id, 10),
+		fmt.Sprintf("Generated %s DKIM key %s for %s", key.Algorithm, key.RecordName(), domain), "success", "", r)
+	if existing == 0 {
+		s.syncDKIMTables()
+	}
+
+	k, err := scanDKIMKey(s.db.QueryRow(`SELECT `+dkimKeyColumns+` FROM dkim_keys k JOIN mail_domains d ON k.domain_id = d.id WHERE k.id = ?`, id))
+	if err != nil {
+		http.Error(w, "Failed to load DKIM key", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(k)
+}
+
+// activateDKIMKey makes a key the one its domain signs with
+func (s *Server) activateDKIMKey(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	k, ok := s.loadDKIMKey(w, r)
+	if !ok {
+		return
+	}
+	if k.DNSStatus != dkim.DNSOK && r.URL.Query().Get("force") != "true" {
+		http.Error(w, "The key's DNS record hasn't been verified; verify it first or pass ?force=true", http.StatusConflict)
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to activate DKIM key", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE dkim_keys SET active = (id = ?) WHERE domain_id = ?", k.ID, k.DomainID); err != nil {
+		http.Error(w, "Failed to activate DKIM key", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to activate DKIM key", http.StatusInternalServerError)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "activate", "dkim_key", strconv.FormatInt(k.ID, 10),
+		"Signing "+k.Domain+" with selector "+k.Selector, "success", "", r)
+	s.syncDKIMTables()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteDKIMKey removes a key that isn't active
+func (s *Server) deleteDKIMKey(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	k, ok := s.loadDKIMKey(w, r)
+	if !ok {
+		return
+	}
+	if k.Active {
+		http.Error(w, "The domain signs with this key; activate another key first", http.StatusConflict)
+		return
+	}
+	if _, err := s.db.Exec("DELETE FROM dkim_keys WHERE id = ?", k.ID); err != nil {
+		http.Error(w, "Failed to delete DKIM key", http.StatusInternalServerError)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "delete", "dkim_key", strconv.FormatInt(k.ID, 10),
+		"Deleted DKIM key "+k.RecordName, "success", "", r)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifyDKIMKey checks that the key's record is published and records the
+// outcome
+func (s *Server) verifyDKIMKey(w http.ResponseWriter, r *http.Request) {
+	k, ok := s.loadDKIMKey(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	check := dkim.Verify(ctx, net.DefaultResolver, &k.key)
+
+	s.db.Exec("UPDATE dkim_keys SET dns_status = ?, dns_checked_at = ? WHERE id = ?",
+		check.Status, time.Now().UTC(), k.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(check)
+}
+
+// syncDKIMKeyTables rewrites the signer's key tables now, e.g. after the
+// signer settings changed
+func (s *Server) syncDKIMKeyTables(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	if err := s.writeDKIMTables(); err != nil {
+		s.auditLog(user.ID, user.Username, "sync", "dkim_key", "", "Wrote DKIM key tables", "failure", err.Error(), r)
+		http.Error(w, "Failed to write key tables: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.auditLog(user.ID, user.Username, "sync", "dkim_key", "", "Wrote DKIM key tables", "success", "", r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// onDKIMSettingsChanged writes the key tables for a new signer or
+// directory
+func (s *Server) onDKIMSettingsChanged(changed map[string]string) {
+	_, signer := changed["dkim_signer"]
+	_, dir := changed["dkim_key_dir"]
+	if signer || dir {
+		s.syncDKIMTables()
+	}
+}
+
+// syncDKIMTables rewrites the key tables in the background
+func (s *Server) syncDKIMTables() {
+	s.workers.Go("dkim_sync", supervisor.Retry, s.writeDKIMTables)
+}
+
+// writeDKIMTables writes the active key of every active domain in the
+// format of the configured signer (dkim_signer) under dkim_key_dir. With
+// no signer configured it does nothing.
+func (s *Server) writeDKIMTables() error {
+	signer := s.db.GetSetting("dkim_signer", "none")
+	if signer == "none" {
+		return nil
+	}
+	dir := s.db.GetSetting("dkim_key_dir", "/etc/postfixrelay/dkim")
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("dkim_key_dir must be an absolute path")
+	}
+
+	enc, err := s.dkimEncryptor()
+	if err != nil {
+		return err
+	}
+	rows, err := s.db.Query(`
+		SELECT d.domain, k.selector, k.algorithm, k.bits, k.private_key, k.public_key
+		FROM dkim_keys k JOIN mail_domains d ON k.domain_id = d.id
+		WHERE k.active = TRUE AND d.active = TRUE
+	`)
+	if err != nil {
+		return err
+	}
+	var keys []dkim.Key
+	for rows.Next() {
+		var k dkim.Key
+		var sealed string
+		if err := rows.Scan(&k.Domain, &k.Selector, &k.Algorithm, &k.Bits, &sealed, &k.PublicKey); err != nil {
+			rows.Close()
+			return err
+		}
+		if k.PrivateKeyPEM, err = enc.Decrypt(sealed); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to decrypt the key of %s: %w", k.RecordName(), err)
+		}
+		keys = append(keys, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	var tables dkim.Tables
+	switch signer {
+	case dkim.SignerOpenDKIM:
+		tables = dkim.OpenDKIMTables(dir, keys)
+	case dkim.SignerRspamd:
+		tables = dkim.RspamdTables(dir, keys)
+	default:
+		return fmt.Errorf("unknown dkim_signer %q", signer)
+	}
+	if err := tables.Write(dir); err != nil {
+		return err
+	}
+	log.Info().Int("keys", len(keys)).Str("signer", signer).Msg("Wrote DKIM key tables")
+	return nil
+}
@@ -0,0 +1,387 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/dlp"
+	"github.com/postfixrelay/postfixrelay/internal/mail"
+	"github.com/rs/zerolog/log"
+)
+
+// loadDLPRules returns all enabled DLP rules
+func (s *Server) loadDLPRules() ([]dlp.Rule, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, COALESCE(description, ''), type, COALESCE(pattern, ''), action, enabled, created_at
+		FROM dlp_rules WHERE enabled = 1
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]dlp.Rule, 0)
+	for rows.Next() {
+		var rule dlp.Rule
+		if err := rows.Scan(&rule.ID, &rule.Name, &rule.Description, &rule.Type, &rule.Pattern,
+			&rule.Action, &rule.Enabled, &rule.CreatedAt); err != nil {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// dlpResult is the outcome of checking an outgoing webmail message
+type dlpResult struct {
+	Action       dlp.Action
+	Matches      []dlp.Match
+	QuarantineID int64
+}
+
+// checkOutgoingDLP evaluates a message against the DLP rules, records an
+// event for any match and quarantines the message if a rule requires it.
+// Rule loading failures fail open so a broken rule table can't stop mail.
+func (s *Server) checkOutgoingDLP(sender string, msg *mail.ComposeMessage) dlpResult {
+	rules, err := s.loadDLPRules()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load DLP rules")
+		return dlpResult{}
+	}
+
+	matches := dlp.Evaluate(rules, msg.Subject, msg.Body, msg.HTMLBody)
+	result := dlpResult{Action: dlp.Decide(matches), Matches: matches}
+	if result.Action == "" {
+		return result
+	}
+
+	matchesJSON, _ := json.Marshal(matches)
+
+	if result.Action == dlp.ActionQuarantine {
+		msgJSON, _ := json.Marshal(msg)
+		res, err := s.db.Exec(`
+			INSERT INTO dlp_quarantine (sender_email, message, matches) VALUES (?, ?, ?)
+		`, sender, string(msgJSON), string(matchesJSON))
+		if err != nil {
+			// Can't hold it for review, so don't let it through either
+			log.Error().Err(err).Msg("Failed to quarantine message")
+			result.Action = dlp.ActionBlock
+		} else {
+			result.QuarantineID, _ = res.LastInsertId()
+		}
+	}
+
+	recipients := append(append(append([]string{}, msg.To...), msg.Cc...), msg.Bcc...)
+	if _, err := s.db.Exec(`
+		INSERT INTO dlp_events (sender_email, recipients, subject, action, matches, quarantine_id)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, sender, strings.Join(recipients, ","), msg.Subject, string(result.Action), string(matchesJSON),
+		sql.NullInt64{Int64: result.QuarantineID, Valid: result.QuarantineID != 0}); err != nil {
+		log.Error().Err(err).Msg("Failed to record DLP event")
+	}
+
+	log.Info().
+		Str("from", sender).
+		Str("action", string(result.Action)).
+		Int("matches", len(matches)).
+		Msg("DLP rules matched outgoing message")
+
+	return result
+}
+
+// Admin handlers
+
+func (s *Server) listDLPRules(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(`
+		SELECT id, name, COALESCE(description, ''), type, COALESCE(pattern, ''), action, enabled, created_at
+		FROM dlp_rules ORDER BY name ASC
+	`)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	rules := make([]dlp.Rule, 0)
+	for rows.Next() {
+		var rule dlp.Rule
+		if err := rows.Scan(&rule.ID, &rule.Name, &rule.Description, &rule.Type, &rule.Pattern,
+			&rule.Action, &rule.Enabled, &rule.CreatedAt); err != nil {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+func (s *Server) createDLPRule(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+
+	var rule dlp.Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := rule.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO dlp_rules (name, description, type, pattern, action, enabled)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, rule.Name, rule.Description, rule.Type, rule.Pattern, rule.Action, rule.Enabled)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create DLP rule")
+		http.Error(w, "Failed to create rule", http.StatusInternalServerError)
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	s.auditLog(user.ID, user.Username, "dlp_rule_create", "dlp_rule", rule.Name, "Created DLP rule "+rule.Name, "success", "", r)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "message": "Rule created"})
+}
+
+func (s *Server) updateDLPRule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	user := GetUser(r.Context())
+
+	var rule dlp.Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := rule.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE dlp_rules SET name = ?, description = ?, type = ?, pattern = ?, action = ?, enabled = ?
+		WHERE id = ?
+	`, rule.Name, rule.Description, rule.Type, rule.Pattern, rule.Action, rule.Enabled, id)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update DLP rule")
+		http.Error(w, "Failed to update rule", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Rule not found", http.StatusNotFound)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "dlp_rule_update", "dlp_rule", id, "Updated DLP rule "+rule.Name, "success", "", r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Rule updated"})
+}
+
+func (s *Server) deleteDLPRule(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	user := GetUser(r.Context())
+
+	result, err := s.db.Exec("DELETE FROM dlp_rules WHERE id = ?", id)
+	if err != nil {
+		http.Error(w, "Failed to delete rule", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Rule not found", http.StatusNotFound)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "dlp_rule_delete", "dlp_rule", id, "Deleted DLP rule", "success", "", r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Rule deleted"})
+}
+
+// listDLPEvents returns recent rule matches
+func (s *Server) listDLPEvents(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(`
+		SELECT id, sender_email, recipients, COALESCE(subject, ''), action, matches, quarantine_id, created_at
+		FROM dlp_events ORDER BY created_at DESC LIMIT 200
+	`)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	events := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id int64
+		var sender, recipients, subject, action, matches string
+		var quarantineID sql.NullInt64
+		var createdAt time.Time
+		if err := rows.Scan(&id, &sender, &recipients, &subject, &action, &matches, &quarantineID, &createdAt); err != nil {
+			continue
+		}
+		event := map[string]interface{}{
+			"id":         id,
+			"sender":     sender,
+			"recipients": strings.Split(recipients, ","),
+			"subject":    subject,
+			"action":     action,
+			"matches":    json.RawMessage(matches),
+			"createdAt":  createdAt,
+		}
+		if quarantineID.Valid {
+			event["quarantineId"] = quarantineID.Int64
+		}
+		events = append(events, event)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// listDLPQuarantine returns quarantined messages, pending first
+func (s *Server) listDLPQuarantine(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "pending"
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, sender_email, message, matches, status, COALESCE(reviewed_by, ''), reviewed_at, created_at
+		FROM dlp_quarantine WHERE status = ? ORDER BY created_at DESC
+	`, status)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	items := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id int64
+		var sender, message, matches, itemStatus, reviewedBy string
+		var reviewedAt sql.NullTime
+		var createdAt time.Time
+		if err := rows.Scan(&id, &sender, &message, &matches, &itemStatus, &reviewedBy, &reviewedAt, &createdAt); err != nil {
+			continue
+		}
+		item := map[string]interface{}{
+			"id":        id,
+			"sender":    sender,
+			"message":   json.RawMessage(message),
+			"matches":   json.RawMessage(matches),
+			"status":    itemStatus,
+			"createdAt": createdAt,
+		}
+		if reviewedAt.Valid {
+			item["reviewedBy"] = reviewedBy
+			item["reviewedAt"] = reviewedAt.Time
+		}
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// approveDLPQuarantine releases a quarantined message for delivery. The row
+// is claimed before sending, so a concurrent approve or reject can't send
+// it twice or after it was discarded.
+func (s *Server) approveDLPQuarantine(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	user := GetUser(r.Context())
+
+	claim, err := s.db.Exec(`
+		UPDATE dlp_quarantine SET status = 'approved', reviewed_by = ?, reviewed_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = 'pending'
+	`, user.Username, id)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := claim.RowsAffected(); n == 0 {
+		var status string
+		if err := s.db.QueryRow(`SELECT status FROM dlp_quarantine WHERE id = ?`, id).Scan(&status); err == nil {
+			http.Error(w, "Message was already "+status, http.StatusConflict)
+			return
+		}
+		http.Error(w, "Pending message not found", http.StatusNotFound)
+		return
+	}
+
+	// unclaim puts the message back for review when it can't be released
+	unclaim := func() {
+		if _, err := s.db.Exec(`
+			UPDATE dlp_quarantine SET status = 'pending', reviewed_by = NULL, reviewed_at = NULL
+			WHERE id = ? AND status = 'approved'
+		`, id); err != nil {
+			log.Error().Err(err).Str("id", id).Msg("Failed to return quarantined message to pending")
+		}
+	}
+
+	var sender, message string
+	if err := s.db.QueryRow(`SELECT sender_email, message FROM dlp_quarantine WHERE id = ?`, id).Scan(&sender, &message); err != nil {
+		unclaim()
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	var msg mail.ComposeMessage
+	if err := json.Unmarshal([]byte(message), &msg); err != nil {
+		unclaim()
+		http.Error(w, "Stored message is corrupt", http.StatusInternalServerError)
+		return
+	}
+
+	// The footer is added on release, as the held copy is the one the
+	// sender wrote
+	s.applyDisclaimer(sender, &msg)
+
+	// The sender's password isn't kept, so release goes through the trusted relay port
+	result, err := relaySender.Send(sender, "", &msg)
+	if err != nil {
+		unclaim()
+		log.Error().Err(err).Str("id", id).Msg("Failed to release quarantined message")
+		s.auditLog(user.ID, user.Username, "dlp_quarantine_approve", "dlp_quarantine", id, "Failed to release message from "+sender, "failure", err.Error(), r)
+		http.Error(w, "Failed to send message: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "dlp_quarantine_approve", "dlp_quarantine", id, "Released quarantined message from "+sender, "success", "", r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   "Message released",
+		"messageId": result.MessageID,
+	})
+}
+
+// rejectDLPQuarantine discards a quarantined message
+func (s *Server) rejectDLPQuarantine(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	user := GetUser(r.Context())
+
+	result, err := s.db.Exec(`
+		UPDATE dlp_quarantine SET status = 'rejected', reviewed_by = ?, reviewed_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = 'pending'
+	`, user.Username, id)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Pending message not found", http.StatusNotFound)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "dlp_quarantine_reject", "dlp_quarantine", id, "Rejected quarantined message", "success", "", r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Message rejected"})
+}
@@ -0,0 +1,177 @@
+//go:build integration
+
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/postfixrelay/postfixrelay/internal/mail"
+	"github.com/postfixrelay/postfixrelay/internal/smtpserver"
+)
+
+// relayStub is a local SMTP server standing in for Postfix. Each message
+// waits on hold, when set, before it is accepted.
+type relayStub struct {
+	mu       sync.Mutex
+	messages []string
+	hold     chan struct{}
+}
+
+func (r *relayStub) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.messages)
+}
+
+// startRelay points the package's SMTP senders at a relay stub
+func startRelay(t *testing.T, hold chan struct{}) *relayStub {
+	t.Helper()
+	stub := &relayStub{hold: hold}
+	srv := smtpserver.New("127.0.0.1:0", smtpserver.Options{
+		Banner:         "relay stub",
+		MaxMessageSize: 1 << 20,
+		Save: func(_, _ string, _ []string, msg []byte) (string, error) {
+			if stub.hold != nil {
+				<-stub.hold
+			}
+			stub.mu.Lock()
+			defer stub.mu.Unlock()
+			stub.messages = append(stub.messages, string(msg))
+			return "queued", nil
+		},
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Stop)
+
+	host, port, _ := net.SplitHostPort(srv.Addr().String())
+	prevRelay, prevSMTP := relaySender, smtpSender
+	relaySender = mail.NewSMTPSender(&mail.SMTPConfig{Host: host, Port: port})
+	smtpSender = relaySender
+	t.Cleanup(func() { relaySender, smtpSender = prevRelay, prevSMTP })
+	return stub
+}
+
+// withID runs h as if routed with {id} set
+func withID(h http.HandlerFunc, id string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		h(w, r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, rctx)))
+	}
+}
+
+func quarantine(t *testing.T, s *Server) string {
+	t.Helper()
+	res, err := s.db.Exec(`
+		INSERT INTO dlp_quarantine (sender_email, message, matches)
+		VALUES ('carol@example.com', '{"to": ["partner@example.net"], "subject": "Q3 numbers", "body": "see attached"}', '[]')
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return strconv.FormatInt(id, 10)
+}
+
+func quarantineStatus(t *testing.T, s *Server, id string) string {
+	t.Helper()
+	var status string
+	if err := s.db.QueryRow(`SELECT status FROM dlp_quarantine WHERE id = ?`, id).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestDLPQuarantineApproveOnce(t *testing.T) {
+	s, _ := newConfigServer(t)
+	hold := make(chan struct{})
+	relay := startRelay(t, hold)
+	id := quarantine(t, s)
+
+	// The first approval is mid-send when the second and a reject arrive
+	first := make(chan int)
+	go func() { first <- call(t, withID(s.approveDLPQuarantine, id), alice, "POST", "/", "", nil) }()
+	for quarantineStatus(t, s, id) != "approved" {
+		time.Sleep(time.Millisecond)
+	}
+	if code := call(t, withID(s.approveDLPQuarantine, id), bob, "POST", "/", "", nil); code != http.StatusConflict {
+		t.Errorf("concurrent approve: status %d, want 409", code)
+	}
+	if code := call(t, withID(s.rejectDLPQuarantine, id), bob, "POST", "/", "", nil); code != http.StatusNotFound {
+		t.Errorf("reject during approve: status %d, want 404", code)
+	}
+	close(hold)
+	if code := <-first; code != http.StatusOK {
+		t.Fatalf("approve: status %d", code)
+	}
+
+	if n := relay.count(); n != 1 {
+		t.Errorf("released %d times, want once", n)
+	}
+	if status := quarantineStatus(t, s, id); status != "approved" {
+		t.Errorf("status = %s, want approved", status)
+	}
+	if code := call(t, withID(s.approveDLPQuarantine, "99"), alice, "POST", "/", "", nil); code != http.StatusNotFound {
+		t.Errorf("unknown id: status %d, want 404", code)
+	}
+}
+
+func TestDLPQuarantineApproveAfterReject(t *testing.T) {
+	s, _ := newConfigServer(t)
+	relay := startRelay(t, nil)
+	id := quarantine(t, s)
+
+	if code := call(t, withID(s.rejectDLPQuarantine, id), bob, "POST", "/", "", nil); code != http.StatusOK {
+		t.Fatalf("reject: status %d", code)
+	}
+	if code := call(t, withID(s.approveDLPQuarantine, id), alice, "POST", "/", "", nil); code != http.StatusConflict {
+		t.Errorf("approve after reject: status %d, want 409", code)
+	}
+	if n := relay.count(); n != 0 {
+		t.Errorf("a rejected message was sent %d times", n)
+	}
+	if status := quarantineStatus(t, s, id); status != "rejected" {
+		t.Errorf("status = %s, want rejected", status)
+	}
+}
+
+func TestDLPQuarantineSendFailure(t *testing.T) {
+	s, _ := newConfigServer(t)
+	id := quarantine(t, s)
+
+	// Nothing listens on the relay port
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+	prev := relaySender
+	relaySender = mail.NewSMTPSender(&mail.SMTPConfig{Host: host, Port: port})
+	t.Cleanup(func() { relaySender = prev })
+
+	if code := call(t, withID(s.approveDLPQuarantine, id), alice, "POST", "/", "", nil); code != http.StatusBadGateway {
+		t.Errorf("approve with the relay down: status %d, want 502", code)
+	}
+	var status string
+	var reviewedBy *string
+	s.db.QueryRow(`SELECT status, reviewed_by FROM dlp_quarantine WHERE id = ?`, id).Scan(&status, &reviewedBy)
+	if status != "pending" || reviewedBy != nil {
+		t.Errorf("after a failed send: status %s, reviewed by %v; want pending for review again", status, reviewedBy)
+	}
+
+	relay := startRelay(t, nil)
+	if code := call(t, withID(s.approveDLPQuarantine, id), alice, "POST", "/", "", nil); code != http.StatusOK {
+		t.Errorf("retried approve: status %d", code)
+	}
+	if n := relay.count(); n != 1 {
+		t.Errorf("sent %d times, want once", n)
+	}
+}
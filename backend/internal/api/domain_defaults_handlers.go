@@ -0,0 +1,429 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/supervisor"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultMailboxQuota is the quota of a mailbox when neither the request
+// nor its domain sets one: 1 GB
+const defaultMailboxQuota = 1073741824
+
+// DomainDefaults are the settings a domain gives mailboxes created under it
+type DomainDefaults struct {
+	DomainID   int64 `json:"domainId"`
+	QuotaBytes int64 `json:"quotaBytes"`
+	// Features a new mailbox may use
+	WebmailEnabled      bool `json:"webmailEnabled"`
+	AppPasswordsEnabled bool `json:"appPasswordsEnabled"`
+	// The signature template becomes each new mailbox's default signature.
+	// {{.Email}}, {{.LocalPart}}, {{.Domain}} and {{.DisplayName}} are
+	// filled in; nothing is created while both contents are empty.
+	SignatureName string `json:"signatureName"`
+	SignatureHTML string `json:"signatureHtml"`
+	SignatureText string `json:"signatureText"`
+	// Passwords need PasswordMinLength characters from at least
+	// PasswordMinClasses of lowercase, uppercase, digits and symbols
+	PasswordMinLength  int `json:"passwordMinLength"`
+	PasswordMinClasses int `json:"passwordMinClasses"`
+}
+
+// builtinDomainDefaults are used for a domain that hasn't set its own
+func builtinDomainDefaults(domainID int64) DomainDefaults {
+	return DomainDefaults{
+		DomainID:            domainID,
+		QuotaBytes:          defaultMailboxQuota,
+		WebmailEnabled:      true,
+		AppPasswordsEnabled: true,
+		SignatureName:       "Default",
+		PasswordMinLength:   8,
+		PasswordMinClasses:  1,
+	}
+}
+
+// loadDomainDefaults returns a domain's mailbox defaults
+func (s *Server) loadDomainDefaults(domainID int64) (DomainDefaults, error) {
+	d := builtinDomainDefaults(domainID)
+	err := s.db.QueryRow(`
+		SELECT quota_bytes, webmail_enabled, app_passwords_enabled, signature_name, signature_html, signature_text,
+			password_min_length, password_min_classes
+		FROM domain_mailbox_defaults WHERE domain_id = ?
+	`, domainID).Scan(&d.QuotaBytes, &d.WebmailEnabled, &d.AppPasswordsEnabled, &d.SignatureName, &d.SignatureHTML,
+		&d.SignatureText, &d.PasswordMinLength, &d.PasswordMinClasses)
+	if err == sql.ErrNoRows {
+		return d, nil
+	}
+	return d, err
+}
+
+// CheckPassword returns why a password doesn't meet the domain's policy,
+// or "" if it does
+func (d DomainDefaults) CheckPassword(password string) string {
+	if len(password) < d.PasswordMinLength {
+		return fmt.Sprintf("Password must be at least %d characters", d.PasswordMinLength)
+	}
+	var lower, upper, digit, other bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, has := range []bool{lower, upper, digit, other} {
+		if has {
+			classes++
+		}
+	}
+	if classes < d.PasswordMinClasses {
+		return fmt.Sprintf("Password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", d.PasswordMinClasses)
+	}
+	return ""
+}
+
+// signatureFields are what a signature template can refer to
+type signatureFields struct {
+	Email       string
+	LocalPart   string
+	Domain      string
+	DisplayName string
+}
+
+func newSignatureFields(email, displayName string) signatureFields {
+	localPart, domain, _ := strings.Cut(email, "@")
+	if displayName == "" {
+		displayName = localPart
+	}
+	return signatureFields{Email: email, LocalPart: localPart, Domain: domain, DisplayName: displayName}
+}
+
+// renderSignature fills in the signature template for one mailbox. The
+// HTML template escapes the fields.
+func (d DomainDefaults) renderSignature(f signatureFields) (html, text string, err error) {
+	var buf bytes.Buffer
+	if d.SignatureHTML != "" {
+		t, err := htmltemplate.New("html").Option("missingkey=error").Parse(d.SignatureHTML)
+		if err != nil {
+			return "", "", fmt.Errorf("HTML template: %w", err)
+		}
+		if err := t.Execute(&buf, f); err != nil {
+			return "", "", fmt.Errorf("HTML template: %w", err)
+		}
+		html = buf.String()
+		buf.Reset()
+	}
+	if d.SignatureText != "" {
+		t, err := template.New("text").Option("missingkey=error").Parse(d.SignatureText)
+		if err != nil {
+			return "", "", fmt.Errorf("text template: %w", err)
+		}
+		if err := t.Execute(&buf, f); err != nil {
+			return "", "", fmt.Errorf("text template: %w", err)
+		}
+		text = buf.String()
+	}
+	return html, text, nil
+}
+
+// sqlExecer is a database or a transaction
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// applySignatureTemplate replaces a mailbox's signature made from the
+// domain template with a fresh one. The new signature becomes the default
+// unless the user has picked another.
+func applySignatureTemplate(db sqlExecer, d DomainDefaults, email, displayName string) error {
+	if d.SignatureHTML == "" && d.SignatureText == "" {
+		return nil
+	}
+	html, text, err := d.renderSignature(newSignatureFields(email, displayName))
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM mail_signatures WHERE owner_email = ? AND domain_template = TRUE`, email); err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO mail_signatures (owner_email, name, content_html, content_text, is_default, domain_template)
+		VALUES (?, ?, ?, ?, NOT EXISTS (SELECT 1 FROM mail_signatures WHERE owner_email = ? AND is_default = TRUE), TRUE)
+	`, email, d.SignatureName, html, text, email)
+	return err
+}
+
+// domainDefaultsTarget reads the domain ID from the URL and checks the
+// caller may manage it. It writes the response and returns false if not.
+func (s *Server) domainDefaultsTarget(w http.ResponseWriter, r *http.Request) (int64, string, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	var domain string
+	if err == nil {
+		err = s.db.QueryRow("SELECT domain FROM mail_domains WHERE id = ?", id).Scan(&domain)
+	}
+	if err != nil || !s.domainInScope(r, id) {
+		http.Error(w, "Domain not found", http.StatusNotFound)
+		return 0, "", false
+	}
+	return id, domain, true
+}
+
+// getDomainDefaults returns the mailbox defaults of a domain
+func (s *Server) getDomainDefaults(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := s.domainDefaultsTarget(w, r)
+	if !ok {
+		return
+	}
+	d, err := s.loadDomainDefaults(id)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load domain defaults")
+		http.Error(w, "Failed to load domain defaults", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// updateDomainDefaults sets the mailbox defaults of a domain. Existing
+// mailboxes keep their settings until the defaults are re-applied.
+func (s *Server) updateDomainDefaults(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	id, domain, ok := s.domainDefaultsTarget(w, r)
+	if !ok {
+		return
+	}
+	before, err := s.loadDomainDefaults(id)
+	if err != nil {
+		http.Error(w, "Failed to load domain defaults", http.StatusInternalServerError)
+		return
+	}
+
+	d := before
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	d.DomainID = id
+	d.SignatureName = strings.TrimSpace(d.SignatureName)
+	if d.SignatureName == "" {
+		d.SignatureName = "Default"
+	}
+
+	v := NewValidator()
+	if d.QuotaBytes <= 0 {
+		v.AddError("quotaBytes", "must be positive")
+	}
+	if d.PasswordMinLength < 8 || d.PasswordMinLength > 128 {
+		v.AddError("passwordMinLength", "must be between 8 and 128")
+	}
+	if d.PasswordMinClasses < 1 || d.PasswordMinClasses > 4 {
+		v.AddError("passwordMinClasses", "must be between 1 and 4")
+	}
+	v.ValidateMaxLength("signatureName", d.SignatureName, 100)
+	if _, _, err := d.renderSignature(newSignatureFields("user@"+domain, "Example User")); err != nil {
+		v.AddError("signature", err.Error())
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	_, err = s.db.Exec(`
+		INSERT INTO domain_mailbox_defaults (domain_id, quota_bytes, webmail_enabled, app_passwords_enabled,
+			signature_name, signature_html, signature_text, password_min_length, password_min_classes, updated_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(domain_id) DO UPDATE SET
+			quota_bytes = excluded.quota_bytes, webmail_enabled = excluded.webmail_enabled,
+			app_passwords_enabled = excluded.app_passwords_enabled, signature_name = excluded.signature_name,
+			signature_html = excluded.signature_html, signature_text = excluded.signature_text,
+			password_min_length = excluded.password_min_length, password_min_classes = excluded.password_min_classes,
+			updated_by = excluded.updated_by, updated_at = CURRENT_TIMESTAMP
+	`, id, d.QuotaBytes, d.WebmailEnabled, d.AppPasswordsEnabled, d.SignatureName, d.SignatureHTML, d.SignatureText,
+		d.PasswordMinLength, d.PasswordMinClasses, user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to save domain defaults")
+		http.Error(w, "Failed to save domain defaults", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAuditDiff(user, "update", "domain_defaults", strconv.FormatInt(id, 10),
+		"Updated mailbox defaults of "+domain, auditDiff(before, d), r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// Parts of the defaults that can be re-applied to existing mailboxes
+const (
+	defaultsQuota     = "quota"
+	defaultsFeatures  = "features"
+	defaultsSignature = "signature"
+)
+
+// applyDomainDefaults re-applies the domain's current quota, features or
+// signature to its existing mailboxes. The password policy applies the
+// next time a password is set. With ?dryRun=true it lists the mailboxes
+// that would change.
+func (s *Server) applyDomainDefaults(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	id, domain, ok := s.domainDefaultsTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Fields []string `json:"fields"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	fields := map[string]bool{}
+	v := NewValidator()
+	for _, f := range req.Fields {
+		switch f {
+		case defaultsQuota, defaultsFeatures, defaultsSignature:
+			fields[f] = true
+		default:
+			v.AddErrorf("fields", "must be among: %s", "quota, features, signature")
+		}
+	}
+	if len(fields) == 0 {
+		v.AddError("fields", "name at least one of quota, features or signature")
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	d, err := s.loadDomainDefaults(id)
+	if err != nil {
+		http.Error(w, "Failed to load domain defaults", http.StatusInternalServerError)
+		return
+	}
+
+	type mailboxChange struct {
+		ID      int64    `json:"id"`
+		Email   string   `json:"email"`
+		Changes []string `json:"changes"`
+	}
+	rows, err := s.db.Query(`
+		SELECT id, email, COALESCE(display_name, ''), quota_bytes, webmail_enabled, app_passwords_enabled
+		FROM mailboxes WHERE domain_id = ? ORDER BY email
+	`, id)
+	if err != nil {
+		http.Error(w, "Failed to query mailboxes", http.StatusInternalServerError)
+		return
+	}
+	var changed []mailboxChange
+	displayNames := map[int64]string{}
+	for rows.Next() {
+		var c mailboxChange
+		var displayName string
+		var quota int64
+		var webmail, appPasswords bool
+		if err := rows.Scan(&c.ID, &c.Email, &displayName, &quota, &webmail, &appPasswords); err != nil {
+			continue
+		}
+		if fields[defaultsQuota] && quota != d.QuotaBytes {
+			c.Changes = append(c.Changes, fmt.Sprintf("quota %d -> %d bytes", quota, d.QuotaBytes))
+		}
+		if fields[defaultsFeatures] && webmail != d.WebmailEnabled {
+			c.Changes = append(c.Changes, fmt.Sprintf("webmail %t -> %t", webmail, d.WebmailEnabled))
+		}
+		if fields[defaultsFeatures] && appPasswords != d.AppPasswordsEnabled {
+			c.Changes = append(c.Changes, fmt.Sprintf("app passwords %t -> %t", appPasswords, d.AppPasswordsEnabled))
+		}
+		if fields[defaultsSignature] && (d.SignatureHTML != "" || d.SignatureText != "") {
+			c.Changes = append(c.Changes, "signature from template")
+		}
+		if len(c.Changes) > 0 {
+			displayNames[c.ID] = displayName
+			changed = append(changed, c)
+		}
+	}
+	rows.Close()
+	if changed == nil {
+		changed = []mailboxChange{}
+	}
+
+	if isDryRun(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"dryRun":    true,
+			"summary":   fmt.Sprintf("Would update %d mailboxes of %s", len(changed), domain),
+			"mailboxes": changed,
+		})
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to apply domain defaults", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	for _, c := range changed {
+		if fields[defaultsQuota] {
+			_, err = tx.Exec(`UPDATE mailboxes SET quota_bytes = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, d.QuotaBytes, c.ID)
+		}
+		if err == nil && fields[defaultsFeatures] {
+			_, err = tx.Exec(`UPDATE mailboxes SET webmail_enabled = ?, app_passwords_enabled = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+				d.WebmailEnabled, d.AppPasswordsEnabled, c.ID)
+		}
+		if err == nil && fields[defaultsSignature] {
+			err = applySignatureTemplate(tx, d, c.Email, displayNames[c.ID])
+		}
+		if err != nil {
+			log.Error().Err(err).Str("email", c.Email).Msg("Failed to apply domain defaults")
+			http.Error(w, "Failed to apply domain defaults to "+c.Email, http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to apply domain defaults", http.StatusInternalServerError)
+		return
+	}
+
+	applied := make([]string, 0, len(req.Fields))
+	for _, f := range []string{defaultsQuota, defaultsFeatures, defaultsSignature} {
+		if fields[f] {
+			applied = append(applied, f)
+		}
+	}
+	s.auditLog(user.ID, user.Username, "apply_defaults", "mail_domain", strconv.FormatInt(id, 10),
+		fmt.Sprintf("Re-applied %s defaults of %s to %d mailboxes", strings.Join(applied, ", "), domain, len(changed)),
+		"success", "", r)
+
+	if len(changed) > 0 {
+		if fields[defaultsQuota] {
+			s.workers.Go("dovecot_users_sync", supervisor.Retry, s.dovecotSyncer.SyncDovecotUsers)
+		}
+		if fields[defaultsFeatures] {
+			s.syncAppPasswords()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"updated":   len(changed),
+		"mailboxes": changed,
+	})
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/dkim"
+	"github.com/postfixrelay/postfixrelay/internal/mailauth"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+)
+
+// DomainDNSCheck is how a mail domain's SPF, DKIM and DMARC records hold up
+// for mail sent through this server
+type DomainDNSCheck struct {
+	Domain string `json:"domain"`
+	Status string `json:"status"`
+	// SendingVia is the host mail leaves through: the relayhost, or this
+	// server's myhostname without one
+	SendingVia       string               `json:"sendingVia"`
+	SendingAddresses []string             `json:"sendingAddresses"`
+	SPF              mailauth.RecordCheck `json:"spf"`
+	DKIM             mailauth.RecordCheck `json:"dkim"`
+	DMARC            mailauth.RecordCheck `json:"dmarc"`
+	CheckedAt        time.Time            `json:"checkedAt"`
+}
+
+// checkDomainDNS resolves and evaluates a domain's sender authentication
+// records. ?ip= (repeatable) names the addresses mail leaves from, for
+// servers behind NAT whose myhostname doesn't resolve to them.
+func (s *Server) checkDomainDNS(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	var domain string
+	if err := s.db.QueryRow("SELECT domain FROM mail_domains WHERE id = ?", id).Scan(&domain); err != nil || !s.domainInScope(r, id) {
+		http.Error(w, "Domain not found", http.StatusNotFound)
+		return
+	}
+	domain = strings.ToLower(domain)
+
+	v := NewValidator()
+	var senders []net.IP
+	for _, ip := range r.URL.Query()["ip"] {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			senders = append(senders, parsed)
+		} else {
+			v.AddError("ip", "invalid IP address")
+		}
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
+	defer cancel()
+	res := mailauth.DefaultResolver
+
+	check := DomainDNSCheck{Domain: domain, SendingAddresses: []string{}, CheckedAt: time.Now().UTC()}
+	check.SendingVia, senders = s.sendingAddresses(ctx, senders)
+	for _, ip := range senders {
+		check.SendingAddresses = append(check.SendingAddresses, ip.String())
+	}
+
+	check.SPF = mailauth.CheckSPFRecord(ctx, res, domain, senders)
+	check.DKIM = s.checkDomainDKIM(ctx, id, domain)
+	check.DMARC = mailauth.CheckDMARCRecord(ctx, res, domain)
+	check.Status = mailauth.WorstStatus(check.SPF.Status, check.DKIM.Status, check.DMARC.Status)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(check)
+}
+
+// sendingAddresses works out where outbound mail leaves from: the given
+// addresses, else the relayhost's, else myhostname's
+func (s *Server) sendingAddresses(ctx context.Context, given []net.IP) (string, []net.IP) {
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+	relayhost, _ := postfixMgr.GetParameter("relayhost")
+	myhostname, _ := postfixMgr.GetParameter("myhostname")
+
+	host, useMX := myhostname, false
+	if relayhost != "" {
+		// [host]:port skips the MX lookup, host:port doesn't
+		host, useMX = relayhost, !strings.HasPrefix(relayhost, "[")
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+	}
+	if len(given) > 0 || host == "" {
+		return host, given
+	}
+	ips, _ := mailauth.HostAddresses(ctx, mailauth.DefaultResolver, host, useMX)
+	return host, ips
+}
+
+// checkDomainDKIM checks the published record of the domain's active
+// signing key, recording the result on the key like a verify does
+func (s *Server) checkDomainDKIM(ctx context.Context, domainID int64, domain string) mailauth.RecordCheck {
+	check := mailauth.RecordCheck{Status: mailauth.CheckPass}
+	k, err := scanDKIMKey(s.db.QueryRow(`
+		SELECT `+dkimKeyColumns+`
+		FROM dkim_keys k JOIN mail_domains d ON k.domain_id = d.id
+		WHERE k.domain_id = ? AND k.active = TRUE
+	`, domainID))
+	if err == sql.ErrNoRows {
+		check.Status, check.Reason = mailauth.CheckWarn, "no signing key is managed for "+domain
+		check.Hints = append(check.Hints, "Create one with POST /api/v1/dkim/keys and publish its record, "+
+			"unless another system signs the domain's mail")
+		return check
+	} else if err != nil {
+		check.Status, check.Reason = mailauth.CheckFail, "failed to load the signing key"
+		return check
+	}
+
+	result := dkim.Verify(ctx, mailauth.DefaultResolver, &k.key)
+	s.db.Exec("UPDATE dkim_keys SET dns_status = ?, dns_checked_at = ? WHERE id = ?",
+		result.Status, time.Now().UTC(), k.ID)
+	check.Record, check.Reason = result.Published, result.Reason
+
+	switch result.Status {
+	case dkim.DNSOK:
+	case dkim.DNSError:
+		check.Status = mailauth.CheckFail
+		check.Hints = append(check.Hints, "Retry the check later")
+	default:
+		check.Status = mailauth.CheckFail
+		check.Hints = append(check.Hints, "Publish the selector's record: "+k.ZoneEntry)
+	}
+	if signer := s.db.GetSetting("dkim_signer", "none"); signer == "none" {
+		if check.Status == mailauth.CheckPass {
+			check.Status, check.Reason = mailauth.CheckWarn, "dkim_signer is none, so the key isn't used to sign"
+		}
+		check.Hints = append(check.Hints, "Set dkim_signer to opendkim or rspamd")
+	}
+	return check
+}
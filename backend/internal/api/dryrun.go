@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+)
+
+// DryRunResult is what a mutating endpoint called with ?dryRun=true
+// returns instead of making its change
+type DryRunResult struct {
+	DryRun   bool                   `json:"dryRun"`
+	Summary  string                 `json:"summary"`
+	Files    []postfix.FileChange   `json:"files,omitempty"`
+	Messages []postfix.QueueMessage `json:"messages,omitempty"`
+	// Config is the rendered main.cf of a config apply
+	Config *postfix.RenderedConfig `json:"config,omitempty"`
+}
+
+// isDryRun reports whether the request only asks what would happen
+func isDryRun(r *http.Request) bool {
+	return r.URL.Query().Get("dryRun") == "true"
+}
+
+// writeDryRun responds with the outcome of a dry run
+func writeDryRun(w http.ResponseWriter, result DryRunResult) {
+	result.DryRun = true
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// writeQueueDryRun lists the queued messages a bulk queue action would
+// touch: the deferred ones, "all", or with "" everything not on hold
+// (what a flush retries)
+func (s *Server) writeQueueDryRun(w http.ResponseWriter, queue, summary string) {
+	status := queue
+	if queue == "all" || queue == "" {
+		status = ""
+	}
+	messages, err := queueMgr.ListMessages(status)
+	if err != nil {
+		http.Error(w, "failed to list queue: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if queue == "" {
+		retried := messages[:0]
+		for _, msg := range messages {
+			if msg.Status != "hold" {
+				retried = append(retried, msg)
+			}
+		}
+		messages = retried
+	}
+	writeDryRun(w, DryRunResult{Summary: fmt.Sprintf(summary, len(messages)), Messages: messages})
+}
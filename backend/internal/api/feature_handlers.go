@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/features"
+	"github.com/rs/zerolog/log"
+)
+
+// featureEnabled reports whether an experimental subsystem is switched on
+// for this deployment. Handlers of such subsystems check it, or mount
+// their routes behind requireFeature.
+func (s *Server) featureEnabled(key string) bool {
+	return s.features.Enabled(key)
+}
+
+// requireFeature answers 404 for the routes of a subsystem whose flag is
+// off, as if they weren't there
+func (s *Server) requireFeature(key string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.featureEnabled(key) {
+				http.NotFound(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// getFeatures returns the keys of the enabled feature flags, for the UI to
+// show or hide experimental pages
+func (s *Server) getFeatures(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled": s.features.EnabledKeys(),
+	})
+}
+
+// getFeatureFlags lists every feature flag with its setting
+func (s *Server) getFeatureFlags(w http.ResponseWriter, r *http.Request) {
+	flags, err := s.features.List()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list feature flags")
+		http.Error(w, "Failed to list feature flags", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"flags": flags,
+	})
+}
+
+// updateFeatureFlag switches a feature flag on or off
+func (s *Server) updateFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	key := chi.URLParam(r, "key")
+
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+		http.Error(w, "enabled is required", http.StatusBadRequest)
+		return
+	}
+
+	before := s.featureEnabled(key)
+	if err := s.features.Set(key, *req.Enabled, user.Username); err != nil {
+		if errors.Is(err, features.ErrUnknown) {
+			http.Error(w, "Unknown feature flag", http.StatusNotFound)
+			return
+		}
+		log.Error().Err(err).Str("flag", key).Msg("Failed to set feature flag")
+		http.Error(w, "Failed to set feature flag", http.StatusInternalServerError)
+		return
+	}
+
+	state := "off"
+	if *req.Enabled {
+		state = "on"
+	}
+	log.Info().Str("flag", key).Bool("enabled", *req.Enabled).Str("by", user.Username).Msg("Feature flag changed")
+	s.logAuditDiff(user, "update", "feature_flag", key, "Turned feature flag "+key+" "+state,
+		auditDiff(map[string]string{"enabled": strconv.FormatBool(before)}, map[string]string{"enabled": strconv.FormatBool(*req.Enabled)}), r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"key":     key,
+		"enabled": *req.Enabled,
+	})
+}
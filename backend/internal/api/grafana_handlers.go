@@ -0,0 +1,289 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/deliverystats"
+	"github.com/postfixrelay/postfixrelay/internal/queuestats"
+)
+
+// The Grafana datasource implements the JSON (SimpleJSON) datasource
+// protocol: GET / to test the connection, POST /search for metric names,
+// POST /query for time series and POST /annotations for alerts. Grafana
+// authenticates with the grafana_token setting as a bearer token or basic
+// auth password; the datasource is disabled while the setting is empty.
+
+// grafanaMetrics are the series /search offers
+var grafanaMetrics = []string{
+	"queue.total",
+	"queue.active",
+	"queue.deferred",
+	"queue.hold",
+	"queue.corrupt",
+	"deliveries.sent",
+	"deliveries.bounced",
+	"deliveries.deferred",
+	"background.failures",
+}
+
+// queueSampler records the queue size for the queue.* series
+var queueSampler *queuestats.Sampler
+
+// startQueueSampler starts recording the queue size every minute
+func (s *Server) startQueueSampler() {
+	queueSampler = queuestats.NewSampler(s.db.DB, func() (int, int, int, int) {
+		s.initQueueManager()
+		return queueMgr.GetQueueSummary()
+	})
+	queueSampler.Start()
+}
+
+type grafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type grafanaTarget struct {
+	Target string `json:"target"`
+	RefID  string `json:"refId"`
+}
+
+type grafanaQueryRequest struct {
+	Range         grafanaRange    `json:"range"`
+	IntervalMs    int64           `json:"intervalMs"`
+	MaxDataPoints int64           `json:"maxDataPoints"`
+	Targets       []grafanaTarget `json:"targets"`
+}
+
+// grafanaSeries is a time series in Grafana's format: [value, unix ms] pairs
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+type grafanaAnnotationRequest struct {
+	Range      grafanaRange    `json:"range"`
+	Annotation json.RawMessage `json:"annotation"`
+}
+
+type grafanaAnnotation struct {
+	Annotation json.RawMessage `json:"annotation"`
+	Time       int64           `json:"time"`
+	TimeEnd    int64           `json:"timeEnd,omitempty"`
+	Title      string          `json:"title"`
+	Text       string          `json:"text"`
+	Tags       []string        `json:"tags"`
+}
+
+// grafanaTest answers Grafana's "Save & test"
+func (s *Server) grafanaTest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// grafanaSearch returns the metric names containing the requested text
+func (s *Server) grafanaSearch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Target string `json:"target"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	names := []string{}
+	for _, m := range grafanaMetrics {
+		if strings.Contains(m, req.Target) {
+			names = append(names, m)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(names)
+}
+
+// grafanaQuery returns the requested series over the range, with points
+// no closer together than the panel's interval
+func (s *Server) grafanaQuery(w http.ResponseWriter, r *http.Request) {
+	var req grafanaQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Range.From.IsZero() || !req.Range.To.After(req.Range.From) {
+		http.Error(w, "Invalid range", http.StatusBadRequest)
+		return
+	}
+
+	step := time.Duration(req.IntervalMs) * time.Millisecond
+	if req.MaxDataPoints > 0 {
+		if minStep := req.Range.To.Sub(req.Range.From) / time.Duration(req.MaxDataPoints); minStep > step {
+			step = minStep
+		}
+	}
+	if step < time.Minute {
+		step = time.Minute
+	}
+
+	var samples []queuestats.Sample
+	var buckets []deliverystats.Bucket
+	series := []grafanaSeries{}
+	for _, t := range req.Targets {
+		switch {
+		case strings.HasPrefix(t.Target, "queue."):
+			if samples == nil {
+				var err error
+				if samples, err = queuestats.Series(s.db.DB, req.Range.From, req.Range.To); err != nil {
+					http.Error(w, "Failed to load queue samples", http.StatusInternalServerError)
+					return
+				}
+			}
+			series = append(series, queueSeries(t.Target, samples, step))
+
+		case strings.HasPrefix(t.Target, "deliveries."):
+			if buckets == nil {
+				var err error
+				if buckets, err = deliverystats.Series(s.db.DB, req.Range.From, req.Range.To); err != nil {
+					http.Error(w, "Failed to load delivery statistics", http.StatusInternalServerError)
+					return
+				}
+			}
+			series = append(series, deliverySeries(t.Target, buckets, req.Range, step))
+
+		case t.Target == "background.failures":
+			series = append(series, s.backgroundFailureSeries(req.Range))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(series)
+}
+
+// backgroundFailureSeries returns the number of failed background task
+// runs since startup. It is a counter with no history, so the series is
+// the current value, when now is in the range.
+func (s *Server) backgroundFailureSeries(rng grafanaRange) grafanaSeries {
+	out := grafanaSeries{Target: "background.failures", Datapoints: [][2]float64{}}
+	now := time.Now()
+	if now.Before(rng.From) || now.After(rng.To) {
+		return out
+	}
+	var failures int64
+	for _, t := range s.workers.Stats() {
+		failures += t.Failures
+	}
+	out.Datapoints = append(out.Datapoints, [2]float64{float64(failures), float64(now.UnixMilli())})
+	return out
+}
+
+// queueSeries returns one queue counter, keeping the highest sample in
+// each step
+func queueSeries(target string, samples []queuestats.Sample, step time.Duration) grafanaSeries {
+	value := func(sm queuestats.Sample) int {
+		switch target {
+		case "queue.active":
+			return sm.Active
+		case "queue.deferred":
+			return sm.Deferred
+		case "queue.hold":
+			return sm.Hold
+		case "queue.corrupt":
+			return sm.Corrupt
+		}
+		return sm.Total()
+	}
+
+	out := grafanaSeries{Target: target, Datapoints: [][2]float64{}}
+	for _, sm := range samples {
+		at := sm.Time.Truncate(step)
+		ms := float64(at.UnixMilli())
+		v := float64(value(sm))
+		if n := len(out.Datapoints); n > 0 && out.Datapoints[n-1][1] == ms {
+			if v > out.Datapoints[n-1][0] {
+				out.Datapoints[n-1][0] = v
+			}
+			continue
+		}
+		out.Datapoints = append(out.Datapoints, [2]float64{v, ms})
+	}
+	return out
+}
+
+// deliverySeries returns the deliveries with one status per step (at least
+// an hour, the resolution they are stored at), with zeros for empty steps
+func deliverySeries(target string, buckets []deliverystats.Bucket, rng grafanaRange, step time.Duration) grafanaSeries {
+	status := strings.TrimPrefix(target, "deliveries.")
+	if step < time.Hour {
+		step = time.Hour
+	}
+
+	counts := make(map[int64]int)
+	for _, b := range buckets {
+		if b.Status == status {
+			counts[b.Hour.Truncate(step).UnixMilli()] += b.Count
+		}
+	}
+
+	out := grafanaSeries{Target: target, Datapoints: [][2]float64{}}
+	for at := rng.From.UTC().Truncate(step); !at.After(rng.To); at = at.Add(step) {
+		ms := at.UnixMilli()
+		out.Datapoints = append(out.Datapoints, [2]float64{float64(counts[ms]), float64(ms)})
+	}
+	return out
+}
+
+// grafanaAnnotations returns the alerts triggered in the range. An
+// annotation query of "critical" or "warning" limits them to that severity.
+func (s *Server) grafanaAnnotations(w http.ResponseWriter, r *http.Request) {
+	var req grafanaAnnotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	var annotation struct {
+		Query string `json:"query"`
+	}
+	json.Unmarshal(req.Annotation, &annotation)
+
+	query := `
+		SELECT a.triggered_at, a.resolved_at, r.name, a.severity, a.message
+		FROM alerts a JOIN alert_rules r ON a.rule_id = r.id
+		WHERE a.triggered_at >= ? AND a.triggered_at <= ?`
+	args := []interface{}{req.Range.From.UTC().Format(time.RFC3339), req.Range.To.UTC().Format(time.RFC3339)}
+	if severity := strings.TrimSpace(annotation.Query); severity != "" {
+		query += ` AND a.severity = ?`
+		args = append(args, severity)
+	}
+	query += ` ORDER BY a.triggered_at LIMIT 1000`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		http.Error(w, "Failed to load alerts", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	annotations := []grafanaAnnotation{}
+	for rows.Next() {
+		var triggeredAt time.Time
+		var resolvedAt *time.Time
+		var rule, severity, message string
+		if err := rows.Scan(&triggeredAt, &resolvedAt, &rule, &severity, &message); err != nil {
+			continue
+		}
+		a := grafanaAnnotation{
+			Annotation: req.Annotation,
+			Time:       triggeredAt.UnixMilli(),
+			Title:      rule,
+			Text:       message,
+			Tags:       []string{"psfxsuite", severity},
+		}
+		if resolvedAt != nil {
+			a.TimeEnd = resolvedAt.UnixMilli()
+		}
+		annotations = append(annotations, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(annotations)
+}
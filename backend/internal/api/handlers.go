@@ -1,20 +1,33 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
 	"github.com/postfixrelay/postfixrelay/internal/alerts"
+	"github.com/postfixrelay/postfixrelay/internal/archive"
+	"github.com/postfixrelay/postfixrelay/internal/autoconfig"
+	"github.com/postfixrelay/postfixrelay/internal/bake"
+	"github.com/postfixrelay/postfixrelay/internal/deliveries"
+	"github.com/postfixrelay/postfixrelay/internal/dkim"
+	"github.com/postfixrelay/postfixrelay/internal/i18n"
 	"github.com/postfixrelay/postfixrelay/internal/logs"
 	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/postfixrelay/postfixrelay/internal/snmp"
+	"github.com/postfixrelay/postfixrelay/internal/storage"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -35,10 +48,17 @@ func (s *Server) getSetupStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	status := map[string]interface{}{
 		"setupRequired": adminCount == 0,
-	})
+		"demo":          s.cfg.Demo,
+	}
+	// Offer to adopt an existing Postfix install's maps on first run
+	if adminCount == 0 {
+		status["existingMaps"] = s.unmanagedMaps()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
 }
 
 // completeSetup creates the first admin user
@@ -83,11 +103,7 @@ func (s *Server) completeSetup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if v.HasErrors() {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"errors": v.Errors(),
-		})
+		writeValidationErrors(w, r, v)
 		return
 	}
 
@@ -145,9 +161,9 @@ func (s *Server) getConfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	json.NewEncoder(w).Encode(redactFor(r, map[string]interface{}{
 		"config": config,
-	})
+	}))
 }
 
 func (s *Server) getConfigFull(w http.ResponseWriter, r *http.Request) {
@@ -193,108 +209,6 @@ func (s *Server) getConfigFull(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (s *Server) updateConfig(w http.ResponseWriter, r *http.Request) {
-	if postfixMgr == nil {
-		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
-	}
-
-	var req struct {
-		Config struct {
-			General      *postfix.GeneralConfig      `json:"general,omitempty"`
-			Relay        *postfix.RelayConfig        `json:"relay,omitempty"`
-			TLS          *postfix.TLSConfig          `json:"tls,omitempty"`
-			SASL         *postfix.SASLConfig         `json:"sasl,omitempty"`
-			Restrictions *postfix.RestrictionsConfig `json:"restrictions,omitempty"`
-		} `json:"config"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Validate input
-	v := NewValidator()
-
-	if g := req.Config.General; g != nil {
-		v.ValidateHostname("myhostname", g.Myhostname)
-		v.ValidateDomain("mydomain", g.Mydomain)
-		v.ValidateDomain("myorigin", g.Myorigin)
-	}
-
-	if rl := req.Config.Relay; rl != nil {
-		v.ValidateRelayhost("relayhost", rl.Relayhost)
-		v.ValidateCIDR("mynetworks", rl.Mynetworks)
-	}
-
-	if t := req.Config.TLS; t != nil {
-		v.ValidateTLSLevel("smtp_tls_security_level", t.SMTPTLSSecurityLevel)
-		v.ValidateTLSLevel("smtpd_tls_security_level", t.SMTPDTLSSecurityLevel)
-	}
-
-	if v.HasErrors() {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"errors": v.Errors(),
-		})
-		return
-	}
-
-	// Build updates map
-	updates := make(map[string]string)
-
-	if g := req.Config.General; g != nil {
-		updates["myhostname"] = g.Myhostname
-		updates["mydomain"] = g.Mydomain
-		updates["myorigin"] = g.Myorigin
-		updates["inet_interfaces"] = g.InetInterfaces
-		updates["inet_protocols"] = g.InetProtocols
-	}
-
-	if r := req.Config.Relay; r != nil {
-		updates["relayhost"] = r.Relayhost
-		updates["mynetworks"] = r.Mynetworks
-		updates["relay_domains"] = r.RelayDomains
-	}
-
-	if t := req.Config.TLS; t != nil {
-		updates["smtp_tls_security_level"] = t.SMTPTLSSecurityLevel
-		updates["smtpd_tls_security_level"] = t.SMTPDTLSSecurityLevel
-		updates["smtp_tls_cert_file"] = t.SMTPTLSCertFile
-		updates["smtp_tls_key_file"] = t.SMTPTLSKeyFile
-		updates["smtpd_tls_cert_file"] = t.SMTPDTLSCertFile
-		updates["smtpd_tls_key_file"] = t.SMTPDTLSKeyFile
-		updates["smtp_tls_CAfile"] = t.SMTPTLSCAFile
-		updates["smtp_tls_loglevel"] = t.SMTPTLSLoglevel
-	}
-
-	if s := req.Config.SASL; s != nil {
-		updates["smtp_sasl_auth_enable"] = s.SMTPSASLAuthEnable
-		updates["smtp_sasl_password_maps"] = s.SMTPSASLPasswordMaps
-		updates["smtp_sasl_security_options"] = s.SMTPSASLSecurityOptions
-		updates["smtp_sasl_tls_security_options"] = s.SMTPSASLTLSSecurityOptions
-	}
-
-	if re := req.Config.Restrictions; re != nil {
-		updates["smtpd_relay_restrictions"] = re.SMTPDRelayRestrictions
-		updates["smtpd_recipient_restrictions"] = re.SMTPDRecipientRestrictions
-		updates["smtpd_sender_restrictions"] = re.SMTPDSenderRestrictions
-	}
-
-	if err := postfixMgr.UpdateConfig(updates); err != nil {
-		http.Error(w, "failed to update config: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Log audit entry
-	if u := GetUser(r.Context()); u != nil {
-		s.logAudit(u.ID, u.Username, "config_update", "config", "", "Updated configuration", "success", r.RemoteAddr)
-	}
-
-	w.WriteHeader(http.StatusNoContent)
-}
-
 func (s *Server) validateConfig(w http.ResponseWriter, r *http.Request) {
 	if postfixMgr == nil {
 		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
@@ -320,14 +234,37 @@ func (s *Server) applyConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if there are staged changes to apply
-	var stagedCount int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM staged_config").Scan(&stagedCount)
-	if err != nil {
-		http.Error(w, "failed to check staged config", http.StatusInternalServerError)
+	// The body is optional and describes the change for the version history
+	var notes ApplyNotes
+	if err := json.NewDecoder(r.Body).Decode(&notes); err != nil && err != io.EOF {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	v := NewValidator()
+	s.validateApplyNotes(v, &notes)
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
 		return
 	}
 
+	// Reviewers can dry-run changes before approving them, so the review
+	// is only enforced for a real apply
+	staged, err := s.stagedForApply(isDryRun(r))
+	if err == errStagedNotApproved || err == errStagedRejected {
+		s.logAudit(user.ID, user.Username, "config_apply", "config", "", "Refused: "+err.Error(), "failed", r.RemoteAddr)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	} else if err != nil {
+		http.Error(w, "failed to read staged config", http.StatusInternalServerError)
+		return
+	}
+
+	stagedCount := len(staged)
 	if stagedCount == 0 {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadRequest)
@@ -344,23 +281,12 @@ func (s *Server) applyConfig(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to read current config", http.StatusInternalServerError)
 		return
 	}
-
-	// Get staged changes and merge them
-	rows, err := s.db.Query("SELECT key, value FROM staged_config")
-	if err != nil {
-		http.Error(w, "failed to query staged config", http.StatusInternalServerError)
-		return
-	}
-	defer rows.Close()
+	liveConfig := *currentConfig
 
 	// Build updates map from staged changes
 	updates := make(map[string]interface{})
-	for rows.Next() {
-		var key, value string
-		if err := rows.Scan(&key, &value); err != nil {
-			continue
-		}
-		updates[key] = value
+	for _, c := range staged {
+		updates[c.key] = c.value
 	}
 
 	// Merge staged changes into current config
@@ -433,6 +359,99 @@ func (s *Server) applyConfig(w http.ResponseWriter, r *http.Request) {
 	if v, ok := updates["smtpd_sender_restrictions"].(string); ok {
 		currentConfig.Restrictions.SMTPDSenderRestrictions = v
 	}
+	if v, ok := updates["virtual_transport"].(string); ok {
+		currentConfig.Delivery.VirtualTransport = v
+	}
+	if v, ok := updates["mailbox_size_limit"].(string); ok {
+		currentConfig.Delivery.MailboxSizeLimit = v
+	}
+	if v, ok := updates["lmtp_destination_recipient_limit"].(string); ok {
+		currentConfig.Delivery.LMTPDestinationRecipientLimit = v
+	}
+	if v, ok := updates["smtpd_sasl_auth_enable"].(string); ok {
+		currentConfig.SMTPDSASL.SMTPDSASLAuthEnable = v
+	}
+	if v, ok := updates["smtpd_sasl_type"].(string); ok {
+		currentConfig.SMTPDSASL.SMTPDSASLType = v
+	}
+	if v, ok := updates["smtpd_sasl_path"].(string); ok {
+		currentConfig.SMTPDSASL.SMTPDSASLPath = v
+	}
+	if v, ok := updates["smtpd_sasl_security_options"].(string); ok {
+		currentConfig.SMTPDSASL.SMTPDSASLSecurityOptions = v
+	}
+	if v, ok := updates["smtpd_sasl_tls_security_options"].(string); ok {
+		currentConfig.SMTPDSASL.SMTPDSASLTLSSecurityOptions = v
+	}
+	if v, ok := updates["smtpd_tls_auth_only"].(string); ok {
+		currentConfig.SMTPDSASL.SMTPDTLSAuthOnly = v
+	}
+
+	bounceTemplates, bounceStaged, err := s.stagedBounceTemplates(updates)
+	if err != nil {
+		http.Error(w, "failed to read staged bounce templates", http.StatusInternalServerError)
+		return
+	}
+
+	if isDryRun(r) {
+		change, err := postfixMgr.PreviewConfig(currentConfig)
+		if err != nil {
+			http.Error(w, "failed to preview config: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		files := []postfix.FileChange{change}
+		if bounceStaged {
+			bounceChange, err := postfixMgr.PreviewBounceTemplates(bounceTemplates)
+			if err != nil {
+				http.Error(w, "failed to preview bounce templates: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			files = append(files, bounceChange)
+		}
+		rendered, err := postfixMgr.RenderConfig(currentConfig)
+		if err != nil {
+			http.Error(w, "failed to render config: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeDryRun(w, DryRunResult{
+			Summary: fmt.Sprintf("Would apply %d staged configuration changes and reload Postfix", stagedCount),
+			Files:   files,
+			Config:  rendered,
+		})
+		return
+	}
+
+	// SMTP AUTH is only switched to Dovecot once its auth socket answers,
+	// since smtpd can't authenticate anyone otherwise
+	if stagesSMTPDSASL(updates) {
+		if check := s.checkSubmissionAuth(currentConfig.SMTPDSASL); check != nil && !check.Reachable {
+			s.logAudit(user.ID, user.Username, "config_apply", "config", "", "Dovecot auth socket not reachable: "+check.Error, "failed", r.RemoteAddr)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": "Dovecot auth socket is not reachable: " + check.Error,
+				"check":   check,
+			})
+			return
+		}
+	}
+
+	// Site hooks may veto the apply
+	if err := s.runConfigApplyPreHooks(r, user.Username, updates, notes); err != nil {
+		s.logAudit(user.ID, user.Username, "config_apply", "config", "", "Stopped by hook: "+err.Error(), "failed", r.RemoteAddr)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"message": "Apply stopped: " + err.Error(),
+		})
+		return
+	}
+
+	// Health before the apply, for the bake to compare against
+	var baseline bake.Sample
+	if s.db.GetSettingInt("config_bake_minutes", 0) > 0 {
+		baseline = s.bakeSample()
+	}
 
 	// Write merged config to filesystem
 	if err := postfixMgr.WriteConfig(currentConfig); err != nil {
@@ -444,6 +463,17 @@ func (s *Server) applyConfig(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	if bounceStaged {
+		if err := postfixMgr.WriteBounceTemplates(bounceTemplates); err != nil {
+			s.logAudit(user.ID, user.Username, "config_apply", "config", "", "Failed to write bounce templates: "+err.Error(), "failed", r.RemoteAddr)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"message": "Failed to write bounce templates: " + err.Error(),
+			})
+			return
+		}
+	}
 
 	// Validate written config
 	valid, validationErrors := postfixMgr.Validate()
@@ -468,24 +498,42 @@ func (s *Server) applyConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Clear staged config on successful apply
-	_, err = s.db.Exec("DELETE FROM staged_config")
-	if err != nil {
+	// Clear the applied changes on successful apply
+	if err := s.clearAppliedStaged(staged); err != nil {
 		// Log but don't fail - config was applied successfully
 		s.logAudit(user.ID, user.Username, "config_apply", "config", "", "Warning: failed to clear staged config", "success", r.RemoteAddr)
 	}
+	if bounceStaged {
+		if err := s.saveBounceTemplates(bounceTemplates, user.Username); err != nil {
+			s.logAudit(user.ID, user.Username, "config_apply", "config", "", "Warning: failed to save bounce templates", "success", r.RemoteAddr)
+		}
+	}
 
 	// Record config version
-	s.recordConfigVersion(user.ID, user.Username)
-	s.logAudit(user.ID, user.Username, "config_apply", "config", "",
-		fmt.Sprintf("Applied %d staged configuration changes", stagedCount), "success", r.RemoteAddr)
+	versionNum := s.recordConfigVersion(user.ID, user.Username, notes)
+	summary := fmt.Sprintf("Applied %d staged configuration changes as version %d", stagedCount, versionNum)
+	if notes.Ticket != "" {
+		summary += " (" + notes.Ticket + ")"
+	}
+	s.logAuditDiff(user, "config_apply", "config", strconv.FormatInt(versionNum, 10), summary, auditDiff(&liveConfig, currentConfig), r)
+	s.startBake(versionNum, baseline)
+	s.runConfigApplyPostHooks(user.Username, updates, notes, versionNum)
+
+	resp := map[string]interface{}{
+		"success":       true,
+		"message":       "Configuration applied successfully",
+		"changesCount":  stagedCount,
+		"versionNumber": versionNum,
+		"bakeMinutes":   s.db.GetSettingInt("config_bake_minutes", 0),
+	}
+	// Delivery changes are checked against Dovecot straight away, since a
+	// mismatch leaves mail stuck in the queue rather than failing the apply
+	if stagesDelivery(updates) {
+		resp["delivery"] = s.dovecotSyncer.CheckDelivery(currentConfig.Delivery.VirtualTransport)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success":      true,
-		"message":      "Configuration applied successfully",
-		"changesCount": stagedCount,
-	})
+	json.NewEncoder(w).Encode(resp)
 }
 
 // Staged config handlers for submit/apply workflow
@@ -498,11 +546,17 @@ type StagedConfigEntry struct {
 	StagedByID      int64  `json:"stagedById"`
 	StagedByUsername string `json:"stagedByUsername"`
 	StagedAt        string `json:"stagedAt"`
+	// Status is pending, approved or rejected
+	Status        string `json:"status"`
+	ReviewedBy    string `json:"reviewedBy,omitempty"`
+	ReviewedAt    string `json:"reviewedAt,omitempty"`
+	ReviewComment string `json:"reviewComment,omitempty"`
 }
 
 func (s *Server) getStagedConfig(w http.ResponseWriter, r *http.Request) {
 	rows, err := s.db.Query(`
-		SELECT id, key, value, category, staged_by_id, staged_by_username, staged_at
+		SELECT id, key, value, category, staged_by_id, staged_by_username, staged_at,
+			status, COALESCE(reviewed_by, ''), COALESCE(reviewed_at, ''), COALESCE(review_comment, '')
 		FROM staged_config
 		ORDER BY category, key
 	`)
@@ -516,16 +570,19 @@ func (s *Server) getStagedConfig(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var entry StagedConfigEntry
 		if err := rows.Scan(&entry.ID, &entry.Key, &entry.Value, &entry.Category,
-			&entry.StagedByID, &entry.StagedByUsername, &entry.StagedAt); err != nil {
+			&entry.StagedByID, &entry.StagedByUsername, &entry.StagedAt,
+			&entry.Status, &entry.ReviewedBy, &entry.ReviewedAt, &entry.ReviewComment); err != nil {
 			continue
 		}
 		staged = append(staged, entry)
 	}
+	review, _ := s.stagedReview()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"staged": staged,
 		"count":  len(staged),
+		"review": review,
 	})
 }
 
@@ -537,6 +594,8 @@ func (s *Server) submitConfig(w http.ResponseWriter, r *http.Request) {
 			TLS          *postfix.TLSConfig          `json:"tls,omitempty"`
 			SASL         *postfix.SASLConfig         `json:"sasl,omitempty"`
 			Restrictions *postfix.RestrictionsConfig `json:"restrictions,omitempty"`
+			Delivery     *postfix.DeliveryConfig     `json:"delivery,omitempty"`
+			SMTPDSASL    *postfix.SMTPDSASLConfig    `json:"smtpd_sasl,omitempty"`
 		} `json:"config"`
 	}
 
@@ -556,6 +615,7 @@ func (s *Server) submitConfig(w http.ResponseWriter, r *http.Request) {
 	if g := req.Config.General; g != nil {
 		v.ValidateHostname("myhostname", g.Myhostname)
 		v.ValidateDomain("mydomain", g.Mydomain)
+		v.ValidateDomain("myorigin", g.Myorigin)
 	}
 	if rl := req.Config.Relay; rl != nil {
 		v.ValidateRelayhost("relayhost", rl.Relayhost)
@@ -566,28 +626,22 @@ func (s *Server) submitConfig(w http.ResponseWriter, r *http.Request) {
 		v.ValidateTLSLevel("smtpd_tls_security_level", t.SMTPDTLSSecurityLevel)
 	}
 
+	if d := req.Config.Delivery; d != nil {
+		validateDelivery(v, d)
+	}
+
+	if sa := req.Config.SMTPDSASL; sa != nil {
+		validateSMTPDSASL(v, sa)
+	}
+
 	if v.HasErrors() {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"errors": v.Errors(),
-		})
+		writeValidationErrors(w, r, v)
 		return
 	}
 
 	// Stage config changes to database
 	stageEntry := func(key, value, category string) error {
-		_, err := s.db.Exec(`
-			INSERT INTO staged_config (key, value, category, staged_by_id, staged_by_username, staged_at)
-			VALUES (?, ?, ?, ?, ?, datetime('now'))
-			ON CONFLICT(key) DO UPDATE SET
-				value = excluded.value,
-				category = excluded.category,
-				staged_by_id = excluded.staged_by_id,
-				staged_by_username = excluded.staged_by_username,
-				staged_at = datetime('now')
-		`, key, value, category, user.ID, user.Username)
-		return err
+		return s.stageConfigEntry(user, key, value, category)
 	}
 
 	if g := req.Config.General; g != nil {
@@ -628,12 +682,49 @@ func (s *Server) submitConfig(w http.ResponseWriter, r *http.Request) {
 		stageEntry("smtpd_sender_restrictions", re.SMTPDSenderRestrictions, "restrictions")
 	}
 
+	if d := req.Config.Delivery; d != nil {
+		stageEntry("virtual_transport", d.VirtualTransport, "delivery")
+		stageEntry("mailbox_size_limit", d.MailboxSizeLimit, "delivery")
+		stageEntry("lmtp_destination_recipient_limit", d.LMTPDestinationRecipientLimit, "delivery")
+	}
+
+	if sa := req.Config.SMTPDSASL; sa != nil {
+		stageEntry("smtpd_sasl_auth_enable", sa.SMTPDSASLAuthEnable, "smtpd_sasl")
+		stageEntry("smtpd_sasl_type", sa.SMTPDSASLType, "smtpd_sasl")
+		stageEntry("smtpd_sasl_path", sa.SMTPDSASLPath, "smtpd_sasl")
+		stageEntry("smtpd_sasl_security_options", sa.SMTPDSASLSecurityOptions, "smtpd_sasl")
+		stageEntry("smtpd_sasl_tls_security_options", sa.SMTPDSASLTLSSecurityOptions, "smtpd_sasl")
+		stageEntry("smtpd_tls_auth_only", sa.SMTPDTLSAuthOnly, "smtpd_sasl")
+	}
+
 	s.logAudit(user.ID, user.Username, "config_submit", "config", "", "Staged configuration changes", "success", r.RemoteAddr)
 
 	// Return current staged config
 	s.getStagedConfig(w, r)
 }
 
+// stageConfigEntry stages a change to be written by the next apply,
+// replacing any change already staged for the key. A replaced change
+// loses its review and waits for approval again.
+func (s *Server) stageConfigEntry(user *User, key, value, category string) error {
+	_, err := s.db.Exec(`
+		INSERT INTO staged_config (key, value, category, staged_by_id, staged_by_username, staged_at)
+		VALUES (?, ?, ?, ?, ?, datetime('now'))
+		ON CONFLICT(key) DO UPDATE SET
+			value = excluded.value,
+			category = excluded.category,
+			staged_by_id = excluded.staged_by_id,
+			staged_by_username = excluded.staged_by_username,
+			staged_at = datetime('now'),
+			status = 'pending',
+			reviewed_by_id = NULL,
+			reviewed_by = NULL,
+			reviewed_at = NULL,
+			review_comment = NULL
+	`, key, value, category, user.ID, user.Username)
+	return err
+}
+
 func (s *Server) discardStagedConfig(w http.ResponseWriter, r *http.Request) {
 	user := GetUser(r.Context())
 	if user == nil {
@@ -684,15 +775,31 @@ func (s *Server) getStagedDiff(w http.ResponseWriter, r *http.Request) {
 	currentValues["mynetworks"] = currentConfig.Relay.Mynetworks
 	currentValues["smtp_tls_security_level"] = currentConfig.TLS.SMTPTLSSecurityLevel
 	currentValues["smtpd_tls_security_level"] = currentConfig.TLS.SMTPDTLSSecurityLevel
+	currentValues["virtual_transport"] = currentConfig.Delivery.VirtualTransport
+	currentValues["mailbox_size_limit"] = currentConfig.Delivery.MailboxSizeLimit
+	currentValues["lmtp_destination_recipient_limit"] = currentConfig.Delivery.LMTPDestinationRecipientLimit
+	currentValues["smtpd_sasl_auth_enable"] = currentConfig.SMTPDSASL.SMTPDSASLAuthEnable
+	currentValues["smtpd_sasl_type"] = currentConfig.SMTPDSASL.SMTPDSASLType
+	currentValues["smtpd_sasl_path"] = currentConfig.SMTPDSASL.SMTPDSASLPath
+	currentValues["smtpd_sasl_security_options"] = currentConfig.SMTPDSASL.SMTPDSASLSecurityOptions
+	currentValues["smtpd_sasl_tls_security_options"] = currentConfig.SMTPDSASL.SMTPDSASLTLSSecurityOptions
+	currentValues["smtpd_tls_auth_only"] = currentConfig.SMTPDSASL.SMTPDTLSAuthOnly
+	if templates, err := s.loadBounceTemplates(); err == nil {
+		for _, t := range templates {
+			currentValues[bounceTemplateKeyPrefix+t.Class] = bounceTemplateValue(t)
+		}
+	}
 	// Add more fields as needed...
 
 	// Build diff
 	type DiffEntry struct {
-		Key      string `json:"key"`
-		OldValue string `json:"oldValue"`
-		NewValue string `json:"newValue"`
+		Key      string              `json:"key"`
+		OldValue string              `json:"oldValue"`
+		NewValue string              `json:"newValue"`
+		Impact   postfix.BlastRadius `json:"impact"`
 	}
 	diff := make([]DiffEntry, 0)
+	var radii []postfix.BlastRadius
 
 	for rows.Next() {
 		var key, value string
@@ -701,10 +808,13 @@ func (s *Server) getStagedDiff(w http.ResponseWriter, r *http.Request) {
 		}
 		oldValue := currentValues[key]
 		if oldValue != value {
+			impact := stagedKeyBlastRadius(key)
+			radii = append(radii, impact)
 			diff = append(diff, DiffEntry{
 				Key:      key,
 				OldValue: oldValue,
 				NewValue: value,
+				Impact:   impact,
 			})
 		}
 	}
@@ -713,14 +823,26 @@ func (s *Server) getStagedDiff(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"diff":        diff,
 		"changeCount": len(diff),
+		"impact":      postfix.CombineBlastRadius(radii),
 	})
 }
 
+// stagedKeyBlastRadius is the impact of a staged key. Bounce templates
+// are staged one class at a time but all live in bounce_template_file.
+func stagedKeyBlastRadius(key string) postfix.BlastRadius {
+	br := postfix.ParameterBlastRadius(key)
+	if strings.HasPrefix(key, bounceTemplateKeyPrefix) {
+		br = postfix.ParameterBlastRadius("bounce_template_file")
+		br.Parameter = key
+	}
+	return br
+}
+
 func (s *Server) rollbackConfig(w http.ResponseWriter, r *http.Request) {
 	version := chi.URLParam(r, "version")
-	versionNum, err := strconv.Atoi(version)
+	versionNum, err := s.resolveConfigVersion(version)
 	if err != nil {
-		http.Error(w, "invalid version number", http.StatusBadRequest)
+		http.Error(w, "version not found", http.StatusNotFound)
 		return
 	}
 
@@ -834,6 +956,7 @@ func (s *Server) rollbackConfig(w http.ResponseWriter, r *http.Request) {
 
 	s.logAudit(user.ID, user.Username, "config_rollback", "config", version,
 		fmt.Sprintf("Rolled back to version %d", versionNum), "success", r.RemoteAddr)
+	s.notifyPrivileged(r, "config_rollback", fmt.Sprintf("Configuration rolled back to version %d", versionNum))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -844,7 +967,8 @@ func (s *Server) rollbackConfig(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) getConfigHistory(w http.ResponseWriter, r *http.Request) {
 	rows, err := s.db.Query(`
-		SELECT id, version_number, created_at, created_by_username, applied_at, status, notes
+		SELECT id, version_number, created_at, created_by_username, applied_at, status, notes, ticket,
+			auto_rollback_at, auto_rollback_reason
 		FROM config_versions
 		ORDER BY version_number DESC
 		LIMIT 50
@@ -855,13 +979,15 @@ func (s *Server) getConfigHistory(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
+	tags := s.configVersionTags()
 	var versions []map[string]interface{}
 	for rows.Next() {
 		var id, versionNum int64
 		var createdAt, createdBy, status string
-		var appliedAt, notes *string
+		var appliedAt, notes, ticket, autoRollbackAt, autoRollbackReason *string
 
-		if err := rows.Scan(&id, &versionNum, &createdAt, &createdBy, &appliedAt, &status, &notes); err != nil {
+		if err := rows.Scan(&id, &versionNum, &createdAt, &createdBy, &appliedAt, &status, &notes, &ticket,
+			&autoRollbackAt, &autoRollbackReason); err != nil {
 			continue
 		}
 
@@ -878,6 +1004,18 @@ func (s *Server) getConfigHistory(w http.ResponseWriter, r *http.Request) {
 		if notes != nil {
 			v["notes"] = *notes
 		}
+		if ticket != nil {
+			v["ticket"] = *ticket
+		}
+		if autoRollbackAt != nil {
+			v["autoRollbackAt"] = *autoRollbackAt
+			if autoRollbackReason != nil {
+				v["autoRollbackReason"] = *autoRollbackReason
+			}
+		}
+		if t := tags[versionNum]; len(t) > 0 {
+			v["tags"] = t
+		}
 		versions = append(versions, v)
 	}
 
@@ -888,27 +1026,44 @@ func (s *Server) getConfigHistory(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) getConfigVersion(w http.ResponseWriter, r *http.Request) {
-	version := chi.URLParam(r, "version")
+	version, err := s.resolveConfigVersion(chi.URLParam(r, "version"))
+	if err != nil {
+		http.Error(w, "version not found", http.StatusNotFound)
+		return
+	}
 
 	var configContent, createdAt, createdBy, status string
-	err := s.db.QueryRow(`
-		SELECT config_content, created_at, created_by_username, status
+	var notes, ticket *string
+	err = s.db.QueryRow(`
+		SELECT config_content, created_at, created_by_username, status, notes, ticket
 		FROM config_versions WHERE version_number = ?
-	`, version).Scan(&configContent, &createdAt, &createdBy, &status)
+	`, version).Scan(&configContent, &createdAt, &createdBy, &status, &notes, &ticket)
 
 	if err != nil {
 		http.Error(w, "version not found", http.StatusNotFound)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	resp := map[string]interface{}{
 		"versionNumber": version,
 		"configContent": configContent,
 		"createdAt":     createdAt,
 		"createdBy":     createdBy,
 		"status":        status,
-	})
+		"tags":          []string{},
+	}
+	if notes != nil {
+		resp["notes"] = *notes
+	}
+	if ticket != nil {
+		resp["ticket"] = *ticket
+	}
+	if t := s.configVersionTags()[int64(version)]; len(t) > 0 {
+		resp["tags"] = t
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
 
 // Certificate handlers
@@ -925,9 +1080,9 @@ func (s *Server) getCertificates(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	json.NewEncoder(w).Encode(redactFor(r, map[string]interface{}{
 		"certificates": certs,
-	})
+	}))
 }
 
 func (s *Server) uploadCertificate(w http.ResponseWriter, r *http.Request) {
@@ -1074,8 +1229,10 @@ func (s *Server) saveCredentials(w http.ResponseWriter, r *http.Request) {
 		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
 	}
 
+	// Sender is set instead of relayhost for sender-dependent credentials
 	var req struct {
 		Relayhost string `json:"relayhost"`
+		Sender    string `json:"sender"`
 		Username  string `json:"username"`
 		Password  string `json:"password"`
 	}
@@ -1085,20 +1242,44 @@ func (s *Server) saveCredentials(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Relayhost == "" || req.Username == "" || req.Password == "" {
+	key := req.Relayhost
+	if req.Sender != "" {
+		key = req.Sender
+		v := NewValidator()
+		if strings.HasPrefix(req.Sender, "@") {
+			v.ValidateDomain("sender", strings.TrimPrefix(req.Sender, "@"))
+		} else {
+			v.ValidateEmail("sender", req.Sender)
+		}
+		if v.HasErrors() {
+			writeValidationErrors(w, r, v)
+			return
+		}
+	}
+
+	// An update that leaves the password out keeps the saved one
+	if req.Password == "" {
+		for _, c := range postfixMgr.GetSASLCredentials() {
+			if c.Key() == key {
+				req.Password = c.Password
+			}
+		}
+	}
+
+	if key == "" || req.Username == "" || req.Password == "" {
 		http.Error(w, "missing required fields", http.StatusBadRequest)
 		return
 	}
 
 	// Save credentials via postfix manager
-	if err := postfixMgr.SaveSASLCredentials(req.Relayhost, req.Username, req.Password); err != nil {
+	if err := postfixMgr.SaveSASLCredentials(key, req.Username, req.Password); err != nil {
 		http.Error(w, "failed to save credentials: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// Log audit
 	if u := GetUser(r.Context()); u != nil {
-		s.logAudit(u.ID, u.Username, "credentials_update", "sasl", req.Relayhost, "Updated SASL credentials for "+req.Relayhost, "success", r.RemoteAddr)
+		s.logAudit(u.ID, u.Username, "credentials_update", "sasl", key, "Updated SASL credentials for "+key, "success", r.RemoteAddr)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -1110,18 +1291,30 @@ func (s *Server) saveCredentials(w http.ResponseWriter, r *http.Request) {
 // Log handlers
 
 var logReader *logs.Reader
+var logReaderMu sync.Mutex
 
 func (s *Server) initLogReader() {
+	logReaderMu.Lock()
+	defer logReaderMu.Unlock()
+
 	if logReader == nil {
-		logPath := "/var/log/mail.log"
-		if s.cfg.LogPath != "" {
-			logPath = s.cfg.LogPath
-		}
-		logReader = logs.NewReader(logPath)
+		logReader = logs.NewReader(s.logPath())
 		logReader.Start()
 	}
 }
 
+// logPath returns the mail log file to read. A log_source setting holding an
+// absolute path takes precedence over the startup configuration.
+func (s *Server) logPath() string {
+	if source := s.db.GetSetting("log_source", ""); strings.HasPrefix(source, "/") {
+		return source
+	}
+	if s.cfg.LogPath != "" {
+		return s.cfg.LogPath
+	}
+	return "/var/log/mail.log"
+}
+
 func (s *Server) getLogs(w http.ResponseWriter, r *http.Request) {
 	s.initLogReader()
 
@@ -1145,6 +1338,8 @@ func (s *Server) getLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	entries = maskLogEntries(r, s.scopeLogEntries(r, entries))
+
 	// Apply search filter if provided
 	search := r.URL.Query().Get("search")
 	if search != "" {
@@ -1169,7 +1364,7 @@ func (s *Server) streamLogs(w http.ResponseWriter, r *http.Request) {
 	s.initLogReader()
 
 	// Check if it's a WebSocket upgrade request
-	if r.Header.Get("Upgrade") == "websocket" {
+	if websocket.IsWebSocketUpgrade(r) {
 		s.handleWebSocketLogs(w, r)
 		return
 	}
@@ -1191,9 +1386,12 @@ func (s *Server) handleSSELogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Subscribe to log entries
+	// Subscribe to log entries, filtered as for WebSocket clients
 	ch := logReader.Subscribe()
 	defer logReader.Unsubscribe(ch)
+	filter := logStreamFilterFrom(r.URL.Query())
+	inScope := s.logStreamScope(r)
+	masked := !mayViewAddresses(r)
 
 	// Send initial connection event
 	fmt.Fprintf(w, "event: connected\ndata: {\"status\":\"connected\"}\n\n")
@@ -1204,10 +1402,20 @@ func (s *Server) handleSSELogs(w http.ResponseWriter, r *http.Request) {
 		select {
 		case <-r.Context().Done():
 			return
+		case <-s.drainCh:
+			fmt.Fprintf(w, "event: shutdown\ndata: {\"status\":\"shutdown\"}\n\n")
+			flusher.Flush()
+			return
 		case entry, ok := <-ch:
 			if !ok {
 				return
 			}
+			if !inScope(entry) || !filter.match(entry) {
+				continue
+			}
+			if masked {
+				entry = maskLogEntry(entry)
+			}
 			data, _ := json.Marshal(entry)
 			fmt.Fprintf(w, "event: log\ndata: %s\n\n", data)
 			flusher.Flush()
@@ -1215,12 +1423,6 @@ func (s *Server) handleSSELogs(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) handleWebSocketLogs(w http.ResponseWriter, r *http.Request) {
-	// WebSocket upgrade handled by gorilla/websocket
-	// For now, fall back to SSE
-	s.handleSSELogs(w, r)
-}
-
 func (s *Server) getLogsByQueueId(w http.ResponseWriter, r *http.Request) {
 	s.initLogReader()
 	queueId := chi.URLParam(r, "queueId")
@@ -1241,10 +1443,23 @@ func (s *Server) getLogsByQueueId(w http.ResponseWriter, r *http.Request) {
 			filtered = append(filtered, e)
 		}
 	}
+	filtered = maskLogEntries(r, s.scopeLogEntries(r, filtered))
+
+	// Where each recipient stands, from lines older than the reader holds
+	if deliveryTracker != nil {
+		deliveryTracker.Flush()
+	}
+	recipients, _, err := deliveries.Query(s.db.DB, deliveries.Filter{
+		QueueID: queueId, Domains: s.deliveryScope(r), Limit: 1000,
+	})
+	if err != nil {
+		recipients = []deliveries.Delivery{}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"logs": filtered,
+		"logs":       filtered,
+		"deliveries": redactFor(r, recipients),
 	})
 }
 
@@ -1257,10 +1472,44 @@ func (s *Server) exportLogs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// With ?store=true the export is written to object storage for later
+	// download instead of being streamed back. Stored exports are unmasked,
+	// so only callers who see addresses can store or download them.
+	if r.URL.Query().Get("store") == "true" {
+		if !mayViewAddresses(r) {
+			http.Error(w, "forbidden: insufficient permissions", http.StatusForbidden)
+			return
+		}
+		var buf bytes.Buffer
+		writeLogsCSV(&buf, entries)
+
+		store, err := s.store()
+		if err != nil {
+			http.Error(w, "object storage unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		name := fmt.Sprintf("mail-logs-%s.csv", time.Now().UTC().Format("20060102-150405"))
+		size := int64(buf.Len())
+		if err := store.Put(r.Context(), logExportPrefix+name, &buf, "text/csv"); err != nil {
+			http.Error(w, "failed to store export: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(storage.Object{Key: name, Size: size, LastModified: time.Now().UTC()})
+		return
+	}
+
 	// Export as CSV
+	entries = maskLogEntries(r, entries)
 	w.Header().Set("Content-Type", "text/csv")
 	w.Header().Set("Content-Disposition", "attachment; filename=mail-logs.csv")
+	writeLogsCSV(w, entries)
+}
 
+// writeLogsCSV writes log entries in the export CSV format
+func writeLogsCSV(w io.Writer, entries []logs.Entry) {
 	fmt.Fprintln(w, "timestamp,hostname,process,pid,queue_id,from,to,status,relay,message")
 	for _, e := range entries {
 		fmt.Fprintf(w, "%s,%s,%s,%d,%s,%s,%s,%s,%s,\"%s\"\n",
@@ -1283,6 +1532,17 @@ func (s *Server) exportLogs(w http.ResponseWriter, r *http.Request) {
 func (s *Server) initAlertEngine() {
 	if alertEngine == nil {
 		alertEngine = alerts.NewEngine(s.db.DB)
+		alertEngine.SetAlertHook(s.alertHook)
+		alertEngine.SetQueueIDSource(func(status string, limit int) []string {
+			if deliveryStats == nil {
+				return nil
+			}
+			return deliveryStats.RecentQueueIDs(status, limit)
+		})
+		alertEngine.SetBackgroundFailureSource(func() (string, int, string) {
+			t, _ := s.workers.WorstFailing()
+			return t.Name, t.ConsecutiveFailures, t.LastError
+		})
 		alertEngine.Start()
 	}
 }
@@ -1298,7 +1558,8 @@ func (s *Server) getAlerts(w http.ResponseWriter, r *http.Request) {
 	var alertsData []map[string]interface{}
 	rows, err := s.db.Query(`
 		SELECT a.id, a.rule_id, r.name, a.status, a.severity, a.triggered_at,
-		       a.acknowledged_at, a.acknowledged_by, a.resolved_at, a.message
+		       a.acknowledged_at, a.acknowledged_by, a.resolved_at, a.message, a.incident_id,
+		       COALESCE(a.context, '{}')
 		FROM alerts a
 		JOIN alert_rules r ON a.rule_id = r.id
 		ORDER BY a.triggered_at DESC
@@ -1315,8 +1576,10 @@ func (s *Server) getAlerts(w http.ResponseWriter, r *http.Request) {
 		var ruleName, status, severity string
 		var triggeredAt string
 		var ackAt, ackBy, resolvedAt, message *string
+		var incidentID *int64
+		var context string
 
-		if err := rows.Scan(&id, &ruleID, &ruleName, &status, &severity, &triggeredAt, &ackAt, &ackBy, &resolvedAt, &message); err != nil {
+		if err := rows.Scan(&id, &ruleID, &ruleName, &status, &severity, &triggeredAt, &ackAt, &ackBy, &resolvedAt, &message, &incidentID, &context); err != nil {
 			continue
 		}
 
@@ -1340,6 +1603,10 @@ func (s *Server) getAlerts(w http.ResponseWriter, r *http.Request) {
 		if message != nil {
 			alert["message"] = *message
 		}
+		if incidentID != nil {
+			alert["incidentId"] = *incidentID
+		}
+		addAlertContext(alert, context)
 		alertsData = append(alertsData, alert)
 	}
 
@@ -1355,14 +1622,17 @@ func (s *Server) getAlert(w http.ResponseWriter, r *http.Request) {
 	var alertID, ruleID int64
 	var ruleName, status, severity, triggeredAt string
 	var ackAt, ackBy, resolvedAt, message *string
+	var incidentID *int64
+	var context string
 
 	err := s.db.QueryRow(`
 		SELECT a.id, a.rule_id, r.name, a.status, a.severity, a.triggered_at,
-		       a.acknowledged_at, a.acknowledged_by, a.resolved_at, a.message
+		       a.acknowledged_at, a.acknowledged_by, a.resolved_at, a.message, a.incident_id,
+		       COALESCE(a.context, '{}')
 		FROM alerts a
 		JOIN alert_rules r ON a.rule_id = r.id
 		WHERE a.id = ?
-	`, id).Scan(&alertID, &ruleID, &ruleName, &status, &severity, &triggeredAt, &ackAt, &ackBy, &resolvedAt, &message)
+	`, id).Scan(&alertID, &ruleID, &ruleName, &status, &severity, &triggeredAt, &ackAt, &ackBy, &resolvedAt, &message, &incidentID, &context)
 
 	if err != nil {
 		http.Error(w, "alert not found", http.StatusNotFound)
@@ -1389,11 +1659,28 @@ func (s *Server) getAlert(w http.ResponseWriter, r *http.Request) {
 	if message != nil {
 		alert["message"] = *message
 	}
+	if incidentID != nil {
+		alert["incidentId"] = *incidentID
+	}
+	addAlertContext(alert, context)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(alert)
 }
 
+// addAlertContext adds an alert's stored context, and the queue IDs and
+// such it refers to, to its API representation
+func addAlertContext(alert map[string]interface{}, raw string) {
+	var ctx map[string]interface{}
+	if json.Unmarshal([]byte(raw), &ctx) != nil || len(ctx) == 0 {
+		return
+	}
+	alert["context"] = ctx
+	if refs := alerts.ReferencesFromContext(ctx); len(refs) > 0 {
+		alert["references"] = refs
+	}
+}
+
 func (s *Server) acknowledgeAlert(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
@@ -1408,13 +1695,14 @@ func (s *Server) acknowledgeAlert(w http.ResponseWriter, r *http.Request) {
 		username = u.Username
 	}
 
-	now := time.Now().UTC().Format(time.RFC3339)
-	_, err := s.db.Exec(`
-		UPDATE alerts SET status = 'acknowledged', acknowledged_at = ?, acknowledged_by = ?, notes = ?
-		WHERE id = ? AND status = 'firing'
-	`, now, username, req.Note, id)
-
+	alertID, err := strconv.ParseInt(id, 10, 64)
 	if err != nil {
+		http.Error(w, "invalid alert id", http.StatusBadRequest)
+		return
+	}
+
+	s.initAlertEngine()
+	if err := alertEngine.AcknowledgeAlert(alertID, username, req.Note); err != nil {
 		http.Error(w, "failed to acknowledge alert", http.StatusInternalServerError)
 		return
 	}
@@ -1528,6 +1816,11 @@ func (s *Server) updateAlertRule(w http.ResponseWriter, r *http.Request) {
 		s.db.Exec(`UPDATE alert_rules SET severity = ? WHERE id = ?`, *req.Severity, id)
 	}
 
+	// Pick up the change in the running engine
+	if alertEngine != nil {
+		alertEngine.ReloadRules()
+	}
+
 	// Log audit
 	if u := GetUser(r.Context()); u != nil {
 		s.logAudit(u.ID, u.Username, "alert_rule_update", "alert_rule", id, "Updated alert rule "+id, "success", r.RemoteAddr)
@@ -1536,71 +1829,14 @@ func (s *Server) updateAlertRule(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// getRunbook returns the built-in runbook for an alert type. Per-rule
+// runbooks are served by getRuleRunbook.
 func (s *Server) getRunbook(w http.ResponseWriter, r *http.Request) {
 	alertType := chi.URLParam(r, "type")
-
-	// Runbook content for each alert type
-	runbooks := map[string]map[string]interface{}{
-		"queue_growth": {
-			"title":    "Mail Queue Growth",
-			"overview": "The mail queue has grown beyond the configured threshold, indicating potential delivery issues.",
-			"steps": []string{
-				"Check the queue status using 'mailq' or the Queue page",
-				"Look for common recipients or domains that may be causing delays",
-				"Check if the relay host is reachable and accepting connections",
-				"Review the mail logs for error messages",
-				"Consider flushing the queue if the issue is resolved",
-				"If messages are stuck, consider putting problematic messages on hold",
-			},
-		},
-		"deferred_spike": {
-			"title":    "Deferred Mail Spike",
-			"overview": "A large number of messages have been deferred, indicating delivery problems.",
-			"steps": []string{
-				"Check relay host connectivity and DNS resolution",
-				"Verify SMTP authentication credentials are still valid",
-				"Check if the relay host has rate limiting in place",
-				"Review TLS certificate validity",
-				"Check for blacklisting of your IP or domain",
-			},
-		},
-		"auth_failures": {
-			"title":    "Authentication Failures",
-			"overview": "Multiple authentication failures have been detected.",
-			"steps": []string{
-				"Check if relay credentials need to be updated",
-				"Verify the authentication mechanism is configured correctly",
-				"Check for unauthorized connection attempts in logs",
-				"Consider blocking suspicious IPs if this is an attack",
-			},
-		},
-		"tls_failures": {
-			"title":    "TLS Connection Failures",
-			"overview": "TLS connections are failing, which could impact secure mail delivery.",
-			"steps": []string{
-				"Verify TLS certificates are valid and not expired",
-				"Check certificate chain completeness",
-				"Verify the CA bundle is up to date",
-				"Check if the relay host supports your TLS version",
-			},
-		},
-	}
-
-	content, ok := runbooks[alertType]
-	if !ok {
-		content = map[string]interface{}{
-			"title":    "General Alert",
-			"overview": "An alert has been triggered. Review the alert details and logs.",
-			"steps": []string{
-				"Review the alert message and context",
-				"Check the mail logs for related errors",
-				"Verify Postfix service status",
-			},
-		}
-	}
+	runbook := alerts.GetRunbook(alertType).Localize(i18n.FromContext(r.Context()))
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(content)
+	json.NewEncoder(w).Encode(runbook)
 }
 
 // Queue handlers
@@ -1637,9 +1873,9 @@ func (s *Server) getQueueMessages(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	json.NewEncoder(w).Encode(redactFor(r, map[string]interface{}{
 		"messages": messages,
-	})
+	}))
 }
 
 func (s *Server) getQueueMessage(w http.ResponseWriter, r *http.Request) {
@@ -1657,7 +1893,7 @@ func (s *Server) getQueueMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(msg)
+	json.NewEncoder(w).Encode(redactFor(r, msg))
 }
 
 func (s *Server) holdMessage(w http.ResponseWriter, r *http.Request) {
@@ -1685,6 +1921,14 @@ func (s *Server) releaseMessage(w http.ResponseWriter, r *http.Request) {
 	s.initQueueManager()
 	queueId := chi.URLParam(r, "queueId")
 
+	if err := postfix.ValidateQueueID(queueId); err != nil {
+		http.Error(w, "invalid queue ID format", http.StatusBadRequest)
+		return
+	}
+	if !s.scanQueuedMessage(w, r, queueId) {
+		return
+	}
+
 	if err := queueMgr.ReleaseMessage(queueId); err != nil {
 		if errors.Is(err, postfix.ErrInvalidQueueID) {
 			http.Error(w, "invalid queue ID format", http.StatusBadRequest)
@@ -1726,6 +1970,11 @@ func (s *Server) deleteMessage(w http.ResponseWriter, r *http.Request) {
 func (s *Server) flushQueue(w http.ResponseWriter, r *http.Request) {
 	s.initQueueManager()
 
+	if isDryRun(r) {
+		s.writeQueueDryRun(w, "", "Would retry delivery of %d queued messages")
+		return
+	}
+
 	if err := queueMgr.FlushQueue(); err != nil {
 		http.Error(w, "failed to flush queue: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -1745,12 +1994,20 @@ func (s *Server) getAuditLog(w http.ResponseWriter, r *http.Request) {
 	// Get query parameters
 	limit := 50 // Default limit
 
-	rows, err := s.db.Query(`
-		SELECT id, timestamp, user_id, username, action, resource_type, resource_id, summary, status, ip_address
+	query := `
+		SELECT id, timestamp, user_id, username, action, resource_type, resource_id, summary, status, ip_address,
+			COALESCE(diff, '') != ''
 		FROM audit_log
-		ORDER BY timestamp DESC
-		LIMIT ?
-	`, limit)
+	`
+	var args []interface{}
+	if tenantID := tenantOf(r); tenantID != 0 {
+		query += " WHERE tenant_id = ?"
+		args = append(args, tenantID)
+	}
+	query += " ORDER BY timestamp DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
@@ -1761,8 +2018,9 @@ func (s *Server) getAuditLog(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var id, userID int64
 		var timestamp, username, action, resourceType, resourceID, summary, status, ipAddress string
+		var hasDiff bool
 
-		if err := rows.Scan(&id, &timestamp, &userID, &username, &action, &resourceType, &resourceID, &summary, &status, &ipAddress); err != nil {
+		if err := rows.Scan(&id, &timestamp, &userID, &username, &action, &resourceType, &resourceID, &summary, &status, &ipAddress, &hasDiff); err != nil {
 			continue
 		}
 
@@ -1777,6 +2035,7 @@ func (s *Server) getAuditLog(w http.ResponseWriter, r *http.Request) {
 			"summary":      summary,
 			"status":       status,
 			"ipAddress":    ipAddress,
+			"hasDiff":      hasDiff,
 		})
 	}
 
@@ -1790,11 +2049,21 @@ func (s *Server) getAuditLog(w http.ResponseWriter, r *http.Request) {
 // User management handlers
 
 func (s *Server) getUsers(w http.ResponseWriter, r *http.Request) {
-	rows, err := s.db.Query(`
-		SELECT id, username, email, role, last_login, created_at
+	query := `
+		SELECT id, username, email, role, COALESCE(tenant_id, 0), last_login, created_at
 		FROM users
-		ORDER BY username
-	`)
+	`
+	var args []interface{}
+	if tenantID := tenantOf(r); tenantID != 0 {
+		query += " WHERE tenant_id = ?"
+		args = append(args, tenantID)
+	} else if t := r.URL.Query().Get("tenant_id"); t != "" {
+		query += " WHERE tenant_id = ?"
+		args = append(args, t)
+	}
+	query += " ORDER BY username"
+
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
@@ -1803,11 +2072,11 @@ func (s *Server) getUsers(w http.ResponseWriter, r *http.Request) {
 
 	var users []map[string]interface{}
 	for rows.Next() {
-		var id int64
+		var id, tenantID int64
 		var username, email, role string
 		var lastLogin, createdAt *string
 
-		if err := rows.Scan(&id, &username, &email, &role, &lastLogin, &createdAt); err != nil {
+		if err := rows.Scan(&id, &username, &email, &role, &tenantID, &lastLogin, &createdAt); err != nil {
 			continue
 		}
 
@@ -1818,6 +2087,9 @@ func (s *Server) getUsers(w http.ResponseWriter, r *http.Request) {
 			"role":      role,
 			"createdAt": createdAt,
 		}
+		if tenantID != 0 {
+			user["tenantId"] = tenantID
+		}
 		if lastLogin != nil {
 			user["lastLogin"] = *lastLogin
 		}
@@ -1837,6 +2109,7 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 		Role     string `json:"role"`
+		TenantID int64  `json:"tenantId"` // platform admins only
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1844,6 +2117,14 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Tenant admins create users in their own tenant
+	if tenantID := tenantOf(r); tenantID != 0 {
+		req.TenantID = tenantID
+	} else if req.TenantID != 0 && !s.tenantExists(req.TenantID) {
+		http.Error(w, "tenant not found", http.StatusBadRequest)
+		return
+	}
+
 	// Validate
 	if req.Username == "" || req.Email == "" || req.Password == "" || req.Role == "" {
 		http.Error(w, "missing required fields", http.StatusBadRequest)
@@ -1864,9 +2145,9 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 
 	// Insert user
 	result, err := s.db.Exec(`
-		INSERT INTO users (username, email, password_hash, role, must_change_password)
-		VALUES (?, ?, ?, ?, FALSE)
-	`, req.Username, req.Email, hashedPassword, req.Role)
+		INSERT INTO users (username, email, password_hash, role, must_change_password, tenant_id)
+		VALUES (?, ?, ?, ?, FALSE, NULLIF(?, 0))
+	`, req.Username, req.Email, hashedPassword, req.Role, req.TenantID)
 	if err != nil {
 		if strings.Contains(err.Error(), "UNIQUE constraint") {
 			http.Error(w, "username or email already exists", http.StatusConflict)
@@ -1882,6 +2163,9 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 	if u := GetUser(r.Context()); u != nil {
 		s.logAudit(u.ID, u.Username, "user_create", "user", fmt.Sprintf("%d", id), "Created user "+req.Username, "success", r.RemoteAddr)
 	}
+	if req.Role == "admin" {
+		s.notifyPrivileged(r, "user_create", "New admin "+req.Username+" created")
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -1890,6 +2174,7 @@ func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 		"username": req.Username,
 		"email":    req.Email,
 		"role":     req.Role,
+		"tenantId": req.TenantID,
 	})
 }
 
@@ -1901,14 +2186,15 @@ func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
 		Username  string
 		Email     string
 		Role      string
+		TenantID  int64
 		LastLogin *string
 		CreatedAt string
 	}
 
 	err := s.db.QueryRow(`
-		SELECT id, username, email, role, last_login, created_at
+		SELECT id, username, email, role, COALESCE(tenant_id, 0), last_login, created_at
 		FROM users WHERE id = ?
-	`, id).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.LastLogin, &user.CreatedAt)
+	`, id).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.TenantID, &user.LastLogin, &user.CreatedAt)
 
 	if err != nil {
 		http.Error(w, "user not found", http.StatusNotFound)
@@ -1922,6 +2208,9 @@ func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
 		"role":      user.Role,
 		"createdAt": user.CreatedAt,
 	}
+	if user.TenantID != 0 {
+		resp["tenantId"] = user.TenantID
+	}
 	if user.LastLogin != nil {
 		resp["lastLogin"] = *user.LastLogin
 	}
@@ -1957,11 +2246,16 @@ func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "invalid role", http.StatusBadRequest)
 			return
 		}
+		var username, oldRole string
+		s.db.QueryRow(`SELECT username, role FROM users WHERE id = ?`, id).Scan(&username, &oldRole)
 		_, err := s.db.Exec(`UPDATE users SET role = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, *req.Role, id)
 		if err != nil {
 			http.Error(w, "failed to update user", http.StatusInternalServerError)
 			return
 		}
+		if roleRank[*req.Role] > roleRank[oldRole] {
+			s.notifyPrivileged(r, "user_update", fmt.Sprintf("User %s escalated from %s to %s", username, oldRole, *req.Role))
+		}
 	}
 
 	// Log audit
@@ -1981,12 +2275,13 @@ func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if this is the last admin
-	var adminCount int
-	s.db.QueryRow(`SELECT COUNT(*) FROM users WHERE role = 'admin'`).Scan(&adminCount)
-
+	// Check if this is the last admin of the platform or of its tenant
 	var userRole string
-	s.db.QueryRow(`SELECT role FROM users WHERE id = ?`, id).Scan(&userRole)
+	var userTenant int64
+	s.db.QueryRow(`SELECT role, COALESCE(tenant_id, 0) FROM users WHERE id = ?`, id).Scan(&userRole, &userTenant)
+
+	var adminCount int
+	s.db.QueryRow(`SELECT COUNT(*) FROM users WHERE role = 'admin' AND COALESCE(tenant_id, 0) = ?`, userTenant).Scan(&adminCount)
 
 	if userRole == "admin" && adminCount <= 1 {
 		http.Error(w, "cannot delete the last admin user", http.StatusBadRequest)
@@ -2105,6 +2400,29 @@ func (s *Server) getNotificationChannels(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// notificationChannelTypes are the channel types the notifier can send to
+var notificationChannelTypes = []string{"email", "webhook", "slack", "teams", "discord"}
+
+// validateNotificationChannel checks a channel's type and the config keys
+// the notifier needs for it
+func validateNotificationChannel(channelType string, config map[string]string) *Validator {
+	v := NewValidator()
+	switch channelType {
+	case "teams", "discord":
+		v.ValidateRequired("config.webhook_url", config["webhook_url"])
+		v.ValidateHTTPURL("config.webhook_url", config["webhook_url"])
+		if text := config["template"]; text != "" {
+			if _, err := alerts.ParseTemplate(text); err != nil {
+				v.AddErrorf("config.template", "invalid template: %s", err.Error())
+			}
+		}
+	case "email", "webhook", "slack":
+	default:
+		v.AddErrorf("type", "must be one of: %s", strings.Join(notificationChannelTypes, ", "))
+	}
+	return v
+}
+
 func (s *Server) createNotificationChannel(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Name    string            `json:"name"`
@@ -2122,6 +2440,10 @@ func (s *Server) createNotificationChannel(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "name and type are required", http.StatusBadRequest)
 		return
 	}
+	if v := validateNotificationChannel(req.Type, req.Config); v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
 
 	configJSON, _ := json.Marshal(req.Config)
 
@@ -2135,6 +2457,9 @@ func (s *Server) createNotificationChannel(w http.ResponseWriter, r *http.Reques
 	}
 
 	id, _ := result.LastInsertId()
+	if alertEngine != nil {
+		alertEngine.ReloadChannels()
+	}
 
 	// Log audit
 	if u := GetUser(r.Context()); u != nil {
@@ -2166,6 +2491,10 @@ func (s *Server) updateNotificationChannel(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "invalid request", http.StatusBadRequest)
 		return
 	}
+	if v := validateNotificationChannel(req.Type, req.Config); v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
 
 	configJSON, _ := json.Marshal(req.Config)
 
@@ -2177,6 +2506,9 @@ func (s *Server) updateNotificationChannel(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "failed to update channel", http.StatusInternalServerError)
 		return
 	}
+	if alertEngine != nil {
+		alertEngine.ReloadChannels()
+	}
 
 	// Log audit
 	if u := GetUser(r.Context()); u != nil {
@@ -2194,6 +2526,10 @@ func (s *Server) deleteNotificationChannel(w http.ResponseWriter, r *http.Reques
 		http.Error(w, "failed to delete channel", http.StatusInternalServerError)
 		return
 	}
+	s.db.Exec(`DELETE FROM notification_templates WHERE channel_id = ?`, id)
+	if alertEngine != nil {
+		alertEngine.ReloadChannels()
+	}
 
 	// Log audit
 	if u := GetUser(r.Context()); u != nil {
@@ -2209,6 +2545,20 @@ func (s *Server) testNotificationChannel(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// secretSettings are settings whose values are never returned by the API
+var secretSettings = map[string]bool{
+	"storage_s3_secret_key": true,
+	"canary_imap_password":  true,
+	"grafana_token":         true,
+	"metrics_token":         true,
+	"snmp_community":        true,
+	"alert_action_secret":   true,
+	"usage_webhook_secret":  true,
+}
+
+// secretSettingMask replaces secret values in settings responses
+const secretSettingMask = "********"
+
 func (s *Server) getSystemSettings(w http.ResponseWriter, r *http.Request) {
 	rows, err := s.db.Query(`SELECT key, value FROM settings`)
 	if err != nil {
@@ -2223,6 +2573,9 @@ func (s *Server) getSystemSettings(w http.ResponseWriter, r *http.Request) {
 		if err := rows.Scan(&key, &value); err != nil {
 			continue
 		}
+		if secretSettings[key] && value != "" {
+			value = secretSettingMask
+		}
 		settings[key] = value
 	}
 
@@ -2240,7 +2593,179 @@ func (s *Server) updateSystemSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	v := NewValidator()
+	for key, value := range settings {
+		switch {
+		case strings.HasPrefix(key, "rate_limit_") && strings.HasSuffix(key, "_rps"):
+			if f, err := strconv.ParseFloat(value, 64); err != nil || f <= 0 {
+				v.AddError(key, "must be a positive number")
+			}
+		case strings.HasPrefix(key, "rate_limit_") && strings.HasSuffix(key, "_burst"):
+			if n, err := strconv.Atoi(value); err != nil || n < 1 {
+				v.AddError(key, "must be a positive integer")
+			}
+		case key == "mail_send_max_per_hour" || key == "mail_send_max_recipients":
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				v.AddError(key, "must be zero (unlimited) or a positive integer")
+			}
+		case key == "scan_engine":
+			if value != "none" && value != "clamd" && value != "icap" {
+				v.AddErrorf(key, "must be one of: %s", "none, clamd, icap")
+			}
+		case key == "scan_action":
+			if value != "reject" && value != "flag" {
+				v.AddErrorf(key, "must be one of: %s", "reject, flag")
+			}
+		case key == "digest_hour":
+			if n, err := strconv.Atoi(value); err != nil || n < 0 || n > 23 {
+				v.AddError(key, "must be an hour between 0 and 23 (UTC)")
+			}
+		case key == "digest_weekday":
+			if n, err := strconv.Atoi(value); err != nil || n < 0 || n > 6 {
+				v.AddError(key, "must be a weekday between 0 (Sunday) and 6")
+			}
+		case key == "storage_backend":
+			if value != "local" && value != "s3" {
+				v.AddErrorf(key, "must be one of: %s", "local, s3")
+			}
+		case key == "incident_window_minutes" || key == "config_bake_minutes":
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				v.AddError(key, "must be zero (disabled) or a positive number of minutes")
+			}
+		case key == "log_retention_days" || key == "audit_retention_days" ||
+			key == "incident_retention_days" || key == "canary_retention_days" ||
+			key == "export_retention_days" || key == "contact_retention_days" ||
+			key == "archive_retention_days" || key == "trash_retention_days" ||
+			key == "login_retention_days":
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				v.AddError(key, "must be zero (keep forever) or a positive number of days")
+			}
+		case key == "canary_interval_minutes" || key == "canary_timeout_seconds":
+			if n, err := strconv.Atoi(value); err != nil || n < 1 {
+				v.AddError(key, "must be a positive integer")
+			}
+		case key == "connstats_retention_days" || key == "tlsstats_retention_days" ||
+			key == "delivery_retention_days" || key == "cert_expiry_warning_days" ||
+			key == "queuestats_retention_days" || key == "mailbox_growth_days" ||
+			key == "hoststats_retention_days" || key == "sink_max_messages" ||
+			key == "flowstats_retention_days":
+			if n, err := strconv.Atoi(value); err != nil || n < 1 {
+				v.AddError(key, "must be a positive integer")
+			}
+		case key == "ntp_server":
+			host := value
+			if h, _, err := net.SplitHostPort(value); err == nil {
+				host = h
+			}
+			v.ValidateHostname(key, host)
+		case key == "dns_check_names":
+			for _, name := range strings.Split(value, ",") {
+				v.ValidateDomain(key, strings.TrimSpace(name))
+			}
+		case key == "canary_to" && value != "":
+			v.ValidateEmail(key, value)
+		case (key == "grafana_token" || key == "metrics_token" || key == "alert_action_secret" || key == "usage_webhook_secret") &&
+			value != "" && value != secretSettingMask:
+			if len(value) < 24 {
+				v.AddErrorf(key, "must be empty or at least %d characters", 24)
+			}
+		case key == "alert_default_channels" || key == "security_notify_channels":
+			for _, part := range strings.Split(value, ",") {
+				if part = strings.TrimSpace(part); part == "" {
+					continue
+				}
+				if _, err := strconv.ParseInt(part, 10, 64); err != nil {
+					v.AddError(key, "must be a comma-separated list of channel IDs")
+					break
+				}
+			}
+		case key == "archive_mode":
+			switch value {
+			case archive.ModeOff, archive.ModeSMTP, archive.ModeS3:
+			default:
+				v.AddErrorf(key, "must be one of: %s", strings.Join(archive.Modes, ", "))
+			}
+		case key == "archive_smtp_address":
+			v.ValidateEmail(key, value)
+		case key == "dkim_signer":
+			if value != "none" && value != dkim.SignerOpenDKIM && value != dkim.SignerRspamd {
+				v.AddErrorf(key, "must be one of: %s", "none, opendkim, rspamd")
+			}
+		case key == "dkim_key_dir":
+			if !filepath.IsAbs(value) {
+				v.AddError(key, "must be an absolute path")
+			}
+		case strings.HasPrefix(key, "map_type_") && value != "":
+			if m, ok := postfix.FindManagedMap(strings.TrimPrefix(key, "map_type_")); ok {
+				valid := validMapTypes(m)
+				found := false
+				for _, t := range valid {
+					found = found || t == value
+				}
+				if !found {
+					v.AddErrorf(key, "must be one of: %s", strings.Join(valid, ", "))
+				}
+			}
+		case key == "snmp_listen" || key == "archive_listen" || key == "sink_listen":
+			if _, port, err := net.SplitHostPort(value); err != nil || port == "" {
+				v.AddError(key, "must be an address of the form host:port or :port")
+			}
+		case key == "snmp_base_oid":
+			if _, err := snmp.ParseOID(value); err != nil {
+				v.AddError(key, "must be a dotted OID such as 1.3.6.1.4.1.99999.1")
+			}
+		case key == "public_url" || key == "update_manifest_url" || key == "usage_webhook_url":
+			v.ValidateHTTPURL(key, value)
+		case key == "soft_bounce", key == "config_require_ticket", key == "config_second_admin", key == "update_check_enabled",
+			key == "sink_enabled", key == "security_notify_enabled":
+			if value != "true" && value != "false" {
+				v.AddErrorf(key, "must be one of: %s", "true, false")
+			}
+		case key == "mail_client_hostname":
+			v.ValidateHostname(key, value)
+		case key == "mail_client_imap_port" || key == "mail_client_smtp_port":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				n = 0
+			}
+			v.ValidatePort(key, n)
+		case key == "mail_client_imap_tls" || key == "mail_client_smtp_tls":
+			if value != autoconfig.SecuritySSL && value != autoconfig.SecurityStartTLS {
+				v.AddErrorf(key, "must be one of: %s", strings.Join(autoconfig.Securities, ", "))
+			}
+		case key == "config_bake_queue_growth":
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				v.AddError(key, "must be zero (disabled) or a positive integer")
+			}
+		case key == "config_bake_smtpd_errors":
+			if n, err := strconv.Atoi(value); err != nil || n < 0 || n > 100 {
+				v.AddError(key, "must be a percentage between 0 and 100")
+			}
+		case strings.HasPrefix(key, "branding_"):
+			validateBrandingSetting(v, key, value)
+		case key == "scan_on_error":
+			if value != "allow" && value != "reject" {
+				v.AddErrorf(key, "must be one of: %s", "allow, reject")
+			}
+		}
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	// Masked secrets sent back unchanged keep their stored value
 	for key, value := range settings {
+		if secretSettings[key] && value == secretSettingMask {
+			delete(settings, key)
+		}
+	}
+
+	changed := make(map[string]string)
+	for key, value := range settings {
+		if s.db.GetSetting(key, "") != value {
+			changed[key] = value
+		}
 		_, err := s.db.Exec(`
 			INSERT OR REPLACE INTO settings (key, value, updated_at)
 			VALUES (?, ?, CURRENT_TIMESTAMP)
@@ -2251,6 +2776,9 @@ func (s *Server) updateSystemSettings(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Let running subsystems pick up the new values
+	settingsChanges.Publish(changed)
+
 	// Log audit
 	if u := GetUser(r.Context()); u != nil {
 		s.logAudit(u.ID, u.Username, "settings_update", "settings", "", "Updated system settings", "success", r.RemoteAddr)
@@ -2263,15 +2791,17 @@ func (s *Server) updateSystemSettings(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) logAudit(userID int64, username, action, resourceType, resourceID, summary, status, ipAddress string) {
 	_, err := s.db.Exec(`
-		INSERT INTO audit_log (timestamp, user_id, username, action, resource_type, resource_id, summary, status, ip_address)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, time.Now().UTC().Format(time.RFC3339), userID, username, action, resourceType, resourceID, summary, status, ipAddress)
+		INSERT INTO audit_log (timestamp, user_id, username, action, resource_type, resource_id, summary, status, ip_address, tenant_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, (SELECT tenant_id FROM users WHERE id = ?))
+	`, time.Now().UTC().Format(time.RFC3339), userID, username, action, resourceType, resourceID, summary, status, ipAddress, userID)
 	if err != nil {
 		// Log error but don't fail the request
 	}
 }
 
-func (s *Server) recordConfigVersion(userID int64, username string) {
+// recordConfigVersion stores the live config as a new applied version and
+// returns its number
+func (s *Server) recordConfigVersion(userID int64, username string, notes ApplyNotes) int64 {
 	// Get next version number
 	var maxVersion int64
 	s.db.QueryRow(`SELECT COALESCE(MAX(version_number), 0) FROM config_versions`).Scan(&maxVersion)
@@ -2286,12 +2816,13 @@ func (s *Server) recordConfigVersion(userID int64, username string) {
 
 	// Insert version record
 	_, err := s.db.Exec(`
-		INSERT INTO config_versions (version_number, created_at, created_by_id, created_by_username, config_content, status, applied_at)
-		VALUES (?, ?, ?, ?, ?, 'applied', ?)
-	`, nextVersion, time.Now().UTC().Format(time.RFC3339), userID, username, string(configJSON), time.Now().UTC().Format(time.RFC3339))
+		INSERT INTO config_versions (version_number, created_at, created_by_id, created_by_username, config_content, status, applied_at, notes, ticket)
+		VALUES (?, ?, ?, ?, ?, 'applied', ?, NULLIF(?, ''), NULLIF(?, ''))
+	`, nextVersion, time.Now().UTC().Format(time.RFC3339), userID, username, string(configJSON), time.Now().UTC().Format(time.RFC3339), notes.Notes, notes.Ticket)
 	if err != nil {
 		// Log error but don't fail
 	}
+	return nextVersion
 }
 
 // Transport maps handlers
@@ -2335,11 +2866,7 @@ func (s *Server) createTransportMap(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if v.HasErrors() {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"errors": v.Errors(),
-		})
+		writeValidationErrors(w, r, v)
 		return
 	}
 
@@ -2348,6 +2875,21 @@ func (s *Server) createTransportMap(w http.ResponseWriter, r *http.Request) {
 	}
 	req.Enabled = true
 
+	if isStaged(r) {
+		s.stageTransportChange(w, r, postfix.TransportChange{Operation: postfix.MapAdd, TransportMap: req})
+		return
+	}
+
+	if isDryRun(r) {
+		change, err := postfixMgr.PreviewAddTransportMap(req)
+		if err != nil {
+			http.Error(w, "failed to create transport map: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeDryRun(w, DryRunResult{Summary: "Would create transport map for " + req.Domain, Files: []postfix.FileChange{change}})
+		return
+	}
+
 	if err := postfixMgr.AddTransportMap(req); err != nil {
 		http.Error(w, "failed to create transport map: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -2355,7 +2897,7 @@ func (s *Server) createTransportMap(w http.ResponseWriter, r *http.Request) {
 
 	// Log audit
 	if u := GetUser(r.Context()); u != nil {
-		s.logAudit(u.ID, u.Username, "transport_create", "transport_map", req.Domain, "Created transport map for "+req.Domain, "success", r.RemoteAddr)
+		s.logAuditDiff(u, "transport_create", "transport_map", req.Domain, "Created transport map for "+req.Domain, auditDiff(nil, req), r)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -2380,6 +2922,26 @@ func (s *Server) updateTransportMap(w http.ResponseWriter, r *http.Request) {
 		req.Domain = domain
 	}
 
+	if isStaged(r) {
+		if req.Domain != domain {
+			http.Error(w, "a staged update can't change the domain", http.StatusBadRequest)
+			return
+		}
+		s.stageTransportChange(w, r, postfix.TransportChange{Operation: postfix.MapUpdate, TransportMap: req})
+		return
+	}
+
+	if isDryRun(r) {
+		change, err := postfixMgr.PreviewUpdateTransportMap(domain, req)
+		if err != nil {
+			http.Error(w, "failed to update transport map: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeDryRun(w, DryRunResult{Summary: "Would update transport map for " + domain, Files: []postfix.FileChange{change}})
+		return
+	}
+
+	before := s.findTransportMap(domain)
 	if err := postfixMgr.UpdateTransportMap(domain, req); err != nil {
 		http.Error(w, "failed to update transport map: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -2387,7 +2949,7 @@ func (s *Server) updateTransportMap(w http.ResponseWriter, r *http.Request) {
 
 	// Log audit
 	if u := GetUser(r.Context()); u != nil {
-		s.logAudit(u.ID, u.Username, "transport_update", "transport_map", domain, "Updated transport map for "+domain, "success", r.RemoteAddr)
+		s.logAuditDiff(u, "transport_update", "transport_map", domain, "Updated transport map for "+domain, auditDiff(before, s.findTransportMap(req.Domain)), r)
 	}
 
 	w.WriteHeader(http.StatusNoContent)
@@ -2400,6 +2962,22 @@ func (s *Server) deleteTransportMap(w http.ResponseWriter, r *http.Request) {
 
 	domain := chi.URLParam(r, "domain")
 
+	if isStaged(r) {
+		s.stageTransportChange(w, r, postfix.TransportChange{Operation: postfix.MapDelete, TransportMap: postfix.TransportMap{Domain: domain}})
+		return
+	}
+
+	if isDryRun(r) {
+		change, err := postfixMgr.PreviewDeleteTransportMap(domain)
+		if err != nil {
+			http.Error(w, "failed to delete transport map: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeDryRun(w, DryRunResult{Summary: "Would delete transport map for " + domain, Files: []postfix.FileChange{change}})
+		return
+	}
+
+	before := s.findTransportMap(domain)
 	if err := postfixMgr.DeleteTransportMap(domain); err != nil {
 		http.Error(w, "failed to delete transport map: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -2407,10 +2985,28 @@ func (s *Server) deleteTransportMap(w http.ResponseWriter, r *http.Request) {
 
 	// Log audit
 	if u := GetUser(r.Context()); u != nil {
-		s.logAudit(u.ID, u.Username, "transport_delete", "transport_map", domain, "Deleted transport map for "+domain, "success", r.RemoteAddr)
+		s.logAuditDiff(u, "transport_delete", "transport_map", domain, "Deleted transport map for "+domain, auditDiff(before, nil), r)
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	if before == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.writeDeleted(w, r, trashTransportMap, domain, before)
+}
+
+// findTransportMap returns the transport map for a domain, or nil
+func (s *Server) findTransportMap(domain string) *postfix.TransportMap {
+	maps, err := postfixMgr.GetTransportMaps()
+	if err != nil {
+		return nil
+	}
+	for _, m := range maps {
+		if m.Domain == domain {
+			return &m
+		}
+	}
+	return nil
 }
 
 // Sender-dependent relay handlers
@@ -2450,6 +3046,11 @@ func (s *Server) createSenderRelay(w http.ResponseWriter, r *http.Request) {
 
 	req.Enabled = true
 
+	if isStaged(r) {
+		s.stageSenderRelayChange(w, r, postfix.SenderRelayChange{Operation: postfix.MapAdd, SenderDependentRelay: req})
+		return
+	}
+
 	if err := postfixMgr.AddSenderDependentRelay(req); err != nil {
 		http.Error(w, "failed to create sender relay: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -2482,6 +3083,15 @@ func (s *Server) updateSenderRelay(w http.ResponseWriter, r *http.Request) {
 		req.Sender = sender
 	}
 
+	if isStaged(r) {
+		if req.Sender != sender {
+			http.Error(w, "a staged update can't change the sender", http.StatusBadRequest)
+			return
+		}
+		s.stageSenderRelayChange(w, r, postfix.SenderRelayChange{Operation: postfix.MapUpdate, SenderDependentRelay: req})
+		return
+	}
+
 	if err := postfixMgr.UpdateSenderDependentRelay(sender, req); err != nil {
 		http.Error(w, "failed to update sender relay: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -2502,6 +3112,19 @@ func (s *Server) deleteSenderRelay(w http.ResponseWriter, r *http.Request) {
 
 	sender := chi.URLParam(r, "sender")
 
+	if isStaged(r) {
+		s.stageSenderRelayChange(w, r, postfix.SenderRelayChange{Operation: postfix.MapDelete, SenderDependentRelay: postfix.SenderDependentRelay{Sender: sender}})
+		return
+	}
+
+	var before *postfix.SenderDependentRelay
+	if relays, err := postfixMgr.GetSenderDependentRelays(); err == nil {
+		for i := range relays {
+			if relays[i].Sender == sender {
+				before = &relays[i]
+			}
+		}
+	}
 	if err := postfixMgr.DeleteSenderDependentRelay(sender); err != nil {
 		http.Error(w, "failed to delete sender relay: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -2512,5 +3135,9 @@ func (s *Server) deleteSenderRelay(w http.ResponseWriter, r *http.Request) {
 		s.logAudit(u.ID, u.Username, "sender_relay_delete", "sender_relay", sender, "Deleted sender relay for "+sender, "success", r.RemoteAddr)
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	if before == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.writeDeleted(w, r, trashSenderRelay, sender, before)
 }
@@ -0,0 +1,361 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/alerts"
+	"github.com/postfixrelay/postfixrelay/internal/hooks"
+	"github.com/postfixrelay/postfixrelay/internal/mail"
+	"github.com/postfixrelay/postfixrelay/internal/supervisor"
+	"github.com/rs/zerolog/log"
+)
+
+// sendHookPayload is what send.pre hooks see and may change: the message
+// fields, not its attachments
+type sendHookPayload struct {
+	Source  string `json:"source"` // webmail or api
+	From    string `json:"from"`
+	Message struct {
+		To       []string `json:"to"`
+		Cc       []string `json:"cc,omitempty"`
+		Bcc      []string `json:"bcc,omitempty"`
+		Subject  string   `json:"subject"`
+		Body     string   `json:"body"`
+		HTMLBody string   `json:"htmlBody,omitempty"`
+	} `json:"message"`
+}
+
+// runSendHooks passes a message about to be sent through the send.pre
+// hooks, applying their changes to msg. A rejection or failure is
+// returned as an error.
+func (s *Server) runSendHooks(r *http.Request, source, from string, msg *mail.ComposeMessage) error {
+	in := sendHookPayload{Source: source, From: from}
+	in.Message.To, in.Message.Cc, in.Message.Bcc = msg.To, msg.Cc, msg.Bcc
+	in.Message.Subject, in.Message.Body, in.Message.HTMLBody = msg.Subject, msg.Body, msg.HTMLBody
+
+	var out sendHookPayload
+	if err := s.hooks.Run(r.Context(), hooks.SendPre, in, &out); err != nil {
+		return err
+	}
+	if out.Message.To == nil {
+		return nil // unchanged
+	}
+	msg.To, msg.Cc, msg.Bcc = out.Message.To, out.Message.Cc, out.Message.Bcc
+	msg.Subject, msg.Body, msg.HTMLBody = out.Message.Subject, out.Message.Body, out.Message.HTMLBody
+	return nil
+}
+
+// writeSendHookError answers a message the send.pre hooks stopped
+func writeSendHookError(w http.ResponseWriter, err error) {
+	if reason, rejected := hooks.IsRejected(err); rejected {
+		http.Error(w, "Message "+reason, http.StatusUnprocessableEntity)
+		return
+	}
+	log.Error().Err(err).Msg("Send hook failed")
+	http.Error(w, "Message not sent: a send hook failed", http.StatusBadGateway)
+}
+
+// alertHook passes a fired alert through the alert.post hooks. A hook
+// may rewrite the message, severity or context, or reject to drop the
+// notifications. A failing hook never silences an alert.
+func (s *Server) alertHook(a *alerts.Alert) bool {
+	var out alerts.Alert
+	err := s.hooks.Run(context.Background(), hooks.AlertPost, a, &out)
+	if _, rejected := hooks.IsRejected(err); rejected {
+		return false
+	}
+	if err != nil {
+		log.Error().Err(err).Int64("alertId", a.ID).Msg("Alert hook failed; notifying unchanged")
+		return true
+	}
+	if out.Message != "" {
+		a.Message = out.Message
+	}
+	if out.Severity != "" {
+		a.Severity = out.Severity
+	}
+	if out.Context != nil {
+		a.Context = out.Context
+	}
+	return true
+}
+
+// configApplyPayload is what the config.apply hooks see
+type configApplyPayload struct {
+	User    string                 `json:"user"`
+	Changes map[string]interface{} `json:"changes"`
+	Notes   string                 `json:"notes,omitempty"`
+	Ticket  string                 `json:"ticket,omitempty"`
+	Version int64                  `json:"version,omitempty"` // config.apply.post only
+}
+
+// runConfigApplyPreHooks lets the config.apply.pre hooks veto an apply
+// before anything is written
+func (s *Server) runConfigApplyPreHooks(r *http.Request, username string, changes map[string]interface{}, notes ApplyNotes) error {
+	return s.hooks.Run(r.Context(), hooks.ConfigApplyPre, configApplyPayload{
+		User: username, Changes: changes, Notes: notes.Notes, Ticket: notes.Ticket,
+	}, nil)
+}
+
+// runConfigApplyPostHooks tells the config.apply.post hooks about a
+// successful apply, in the background so a slow hook doesn't hold up the
+// response
+func (s *Server) runConfigApplyPostHooks(username string, changes map[string]interface{}, notes ApplyNotes, version int64) {
+	payload := configApplyPayload{
+		User: username, Changes: changes, Notes: notes.Notes, Ticket: notes.Ticket, Version: version,
+	}
+	s.workers.Go("config_apply_hooks", supervisor.Once, func() error {
+		return s.hooks.Run(context.Background(), hooks.ConfigApplyPost, payload, nil)
+	})
+}
+
+// HookRequest creates or updates an external hook
+type HookRequest struct {
+	Name           string  `json:"name"`
+	Point          string  `json:"point"`
+	Kind           string  `json:"kind"`
+	Target         string  `json:"target"`
+	Secret         *string `json:"secret"` // omitted keeps the current secret, "" clears it
+	TimeoutSeconds int     `json:"timeoutSeconds"`
+	FailOpen       bool    `json:"failOpen"`
+	Enabled        *bool   `json:"enabled"`
+	Position       int     `json:"position"`
+}
+
+func (s *Server) validateHook(req *HookRequest) *Validator {
+	v := NewValidator()
+	req.Name = strings.TrimSpace(req.Name)
+	v.ValidateRequired("name", req.Name)
+	v.ValidateMaxLength("name", req.Name, 100)
+	if !hooks.ValidPoint(req.Point) {
+		names := make([]string, len(hooks.Points))
+		for i, p := range hooks.Points {
+			names[i] = p.Name
+		}
+		v.AddErrorf("point", "must be one of: %s", strings.Join(names, ", "))
+	}
+
+	switch req.Kind {
+	case hooks.KindScript:
+		path, err := s.hooks.ScriptPath(req.Target)
+		if err != nil {
+			v.AddError("target", err.Error())
+		} else if fi, err := os.Stat(path); err != nil || fi.IsDir() || fi.Mode()&0111 == 0 {
+			v.AddErrorf("target", "no executable %s in %s", req.Target, s.hooks.Dir())
+		}
+	case hooks.KindWebhook:
+		v.ValidateHTTPURL("target", req.Target)
+	default:
+		v.AddErrorf("kind", "must be one of: %s", "script, webhook")
+	}
+	if req.Secret != nil && *req.Secret != "" && req.Kind != hooks.KindWebhook {
+		v.AddError("secret", "only applies to webhooks")
+	}
+
+	if req.TimeoutSeconds == 0 {
+		req.TimeoutSeconds = 5
+	}
+	if req.TimeoutSeconds < 1 || req.TimeoutSeconds > 60 {
+		v.AddError("timeoutSeconds", "must be between 1 and 60")
+	}
+	return v
+}
+
+// loadHook loads the hook in the URL. It writes the error response and
+// returns nil on failure.
+func (s *Server) loadHook(w http.ResponseWriter, r *http.Request) *hooks.Hook {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid hook ID", http.StatusBadRequest)
+		return nil
+	}
+	h, err := hooks.Get(s.db.DB, id)
+	if errors.Is(err, hooks.ErrNotFound) {
+		http.Error(w, "Hook not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, "Failed to load hook", http.StatusInternalServerError)
+		return nil
+	}
+	return h
+}
+
+// listHooks returns the external hooks, the extension points and the
+// compiled-in plugins
+func (s *Server) listHooks(w http.ResponseWriter, r *http.Request) {
+	list, err := hooks.List(s.db.DB)
+	if err != nil {
+		http.Error(w, "Failed to list hooks", http.StatusInternalServerError)
+		return
+	}
+	type plugin struct {
+		Name   string   `json:"name"`
+		Points []string `json:"points"`
+	}
+	plugins := []plugin{}
+	for _, p := range hooks.Plugins() {
+		plugins = append(plugins, plugin{p.Name(), p.Points()})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"hooks":   list,
+		"points":  hooks.Points,
+		"plugins": plugins,
+		"dir":     s.hooks.Dir(),
+	})
+}
+
+// createHook adds an external hook
+func (s *Server) createHook(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+
+	var req HookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if v := s.validateHook(&req); v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	h := &hooks.Hook{
+		Name:           req.Name,
+		Point:          req.Point,
+		Kind:           req.Kind,
+		Target:         req.Target,
+		TimeoutSeconds: req.TimeoutSeconds,
+		FailOpen:       req.FailOpen,
+		Enabled:        req.Enabled == nil || *req.Enabled,
+		Position:       req.Position,
+	}
+	if req.Secret != nil {
+		h.Secret = *req.Secret
+	}
+	if err := hooks.Create(s.db.DB, h); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint") {
+			http.Error(w, "A hook with this name already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to create hook", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "hook_create", "hook", strconv.FormatInt(h.ID, 10),
+		"Added "+h.Kind+" hook "+h.Name+" at "+h.Point, "success", r.RemoteAddr)
+
+	created, err := hooks.Get(s.db.DB, h.ID)
+	if err != nil {
+		created = h
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// updateHook replaces an external hook's settings
+func (s *Server) updateHook(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	h := s.loadHook(w, r)
+	if h == nil {
+		return
+	}
+
+	var req HookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if v := s.validateHook(&req); v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	h.Name, h.Point, h.Kind, h.Target = req.Name, req.Point, req.Kind, req.Target
+	h.TimeoutSeconds, h.FailOpen, h.Position = req.TimeoutSeconds, req.FailOpen, req.Position
+	if req.Enabled != nil {
+		h.Enabled = *req.Enabled
+	}
+	if req.Secret != nil {
+		h.Secret = *req.Secret
+	}
+	if h.Kind != hooks.KindWebhook {
+		h.Secret = ""
+	}
+	if err := hooks.Update(s.db.DB, h); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint") {
+			http.Error(w, "A hook with this name already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to update hook", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "hook_update", "hook", strconv.FormatInt(h.ID, 10),
+		"Updated hook "+h.Name, "success", r.RemoteAddr)
+
+	updated, err := hooks.Get(s.db.DB, h.ID)
+	if err != nil {
+		updated = h
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// deleteHook removes an external hook
+func (s *Server) deleteHook(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	h := s.loadHook(w, r)
+	if h == nil {
+		return
+	}
+
+	if err := hooks.Delete(s.db.DB, h.ID); err != nil && !errors.Is(err, hooks.ErrNotFound) {
+		http.Error(w, "Failed to delete hook", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "hook_delete", "hook", strconv.FormatInt(h.ID, 10),
+		"Deleted hook "+h.Name, "success", r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// testHook calls one hook with a sample payload, enabled or not, and
+// returns its answer, so a script or webhook can be checked before it
+// goes live
+func (s *Server) testHook(w http.ResponseWriter, r *http.Request) {
+	h := s.loadHook(w, r)
+	if h == nil {
+		return
+	}
+
+	var req struct {
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Payload) == 0 {
+		req.Payload = json.RawMessage("{}")
+	}
+
+	resp, err := s.hooks.Call(r.Context(), *h, req.Payload)
+	result := map[string]interface{}{"success": err == nil, "response": resp}
+	if err != nil {
+		result["error"] = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/rs/zerolog/log"
+)
+
+// impactWindow is how far back the mail log is searched for traffic
+const impactWindow = 30 * 24 * time.Hour
+
+// impactExamples is how many names of each kind of dependent are listed
+const impactExamples = 5
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// DeletionImpact is what deleting a domain, alias or transport map would
+// affect, so something that only looks unused isn't removed from under
+// live mail
+type DeletionImpact struct {
+	Type       string            `json:"type"`
+	Name       string            `json:"name"`
+	Dependents []ImpactDependent `json:"dependents"`
+	Traffic    ImpactTraffic     `json:"traffic"`
+	Warnings   []string          `json:"warnings"`
+	// InUse is set when anything depends on the object or mail used it
+	// within the window
+	InUse bool `json:"inUse"`
+}
+
+// ImpactDependent is a kind of object that refers to the one being deleted
+type ImpactDependent struct {
+	Type     string   `json:"type"`
+	Count    int      `json:"count"`
+	Examples []string `json:"examples,omitempty"`
+	// Effect is what the deletion does to them
+	Effect string `json:"effect"`
+}
+
+// ImpactTraffic counts the messages that went through the object
+type ImpactTraffic struct {
+	Since     time.Time  `json:"since"`
+	Messages  int        `json:"messages"`
+	Delivered int        `json:"delivered"`
+	Deferred  int        `json:"deferred"`
+	Bounced   int        `json:"bounced"`
+	Senders   int        `json:"senders"`
+	LastSeen  *time.Time `json:"lastSeen,omitempty"`
+	// Queued is the number of messages waiting in the queue now
+	Queued int `json:"queued"`
+}
+
+func newDeletionImpact(typ, name string) *DeletionImpact {
+	return &DeletionImpact{
+		Type:       typ,
+		Name:       name,
+		Dependents: []ImpactDependent{},
+		Warnings:   []string{},
+		Traffic:    ImpactTraffic{Since: time.Now().Add(-impactWindow).UTC()},
+	}
+}
+
+// addDependents runs query, which selects one name per dependent, and
+// records the dependents it finds
+func (s *Server) addDependents(imp *DeletionImpact, typ, effect, query string, args ...interface{}) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Warn().Err(err).Str("type", typ).Msg("Failed to look up dependents")
+		return
+	}
+	defer rows.Close()
+
+	dep := ImpactDependent{Type: typ, Effect: effect}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			continue
+		}
+		dep.Count++
+		if len(dep.Examples) < impactExamples {
+			dep.Examples = append(dep.Examples, name)
+		}
+	}
+	if dep.Count > 0 {
+		imp.Dependents = append(imp.Dependents, dep)
+	}
+}
+
+// addTraffic counts the delivery attempts in the window that match cond,
+// a condition on mail_logs
+func (s *Server) addTraffic(imp *DeletionImpact, cond string, args ...interface{}) {
+	where := `timestamp >= ? AND mail_to IS NOT NULL AND (` + cond + `)`
+	args = append([]interface{}{imp.Traffic.Since.Format(time.RFC3339)}, args...)
+
+	t := &imp.Traffic
+	err := s.db.QueryRow(`
+		SELECT COUNT(DISTINCT queue_id),
+			COUNT(DISTINCT CASE WHEN status = 'sent' THEN queue_id END),
+			COUNT(DISTINCT CASE WHEN status = 'deferred' THEN queue_id END),
+			COUNT(DISTINCT CASE WHEN status IN ('bounced', 'expired') THEN queue_id END),
+			COUNT(DISTINCT mail_from)
+		FROM mail_logs WHERE `+where, args...).Scan(&t.Messages, &t.Delivered, &t.Deferred, &t.Bounced, &t.Senders)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to count traffic")
+		return
+	}
+
+	if t.Messages == 0 {
+		return
+	}
+	warning := fmt.Sprintf("%d messages in the last %d days", t.Messages, int(impactWindow.Hours()/24))
+	var last time.Time
+	if s.db.QueryRow(`SELECT timestamp FROM mail_logs WHERE `+where+` ORDER BY timestamp DESC LIMIT 1`, args...).Scan(&last) == nil {
+		t.LastSeen = &last
+		warning += ", most recently " + last.UTC().Format(time.RFC3339)
+	}
+	imp.Warnings = append(imp.Warnings, warning)
+}
+
+// addQueued counts the queued messages with a recipient matching pattern
+func (s *Server) addQueued(imp *DeletionImpact, pattern string) {
+	s.initQueueManager()
+	messages, err := queueMgr.SelectMessages(postfix.QueueFilter{Recipient: pattern})
+	if err != nil {
+		return
+	}
+	imp.Traffic.Queued = len(messages)
+	if imp.Traffic.Queued > 0 {
+		imp.Warnings = append(imp.Warnings, fmt.Sprintf("%d messages for %s are in the queue now", imp.Traffic.Queued, pattern))
+	}
+}
+
+func (s *Server) writeImpact(w http.ResponseWriter, imp *DeletionImpact) {
+	imp.InUse = len(imp.Dependents) > 0 || imp.Traffic.Messages > 0 || imp.Traffic.Queued > 0
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(imp)
+}
+
+// getDomainImpact reports what deleting a mail domain would affect
+func (s *Server) getDomainImpact(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	var domain string
+	if err := s.db.QueryRow("SELECT domain FROM mail_domains WHERE id = ?", id).Scan(&domain); err != nil || !s.domainInScope(r, id) {
+		http.Error(w, "Domain not found", http.StatusNotFound)
+		return
+	}
+	domain = strings.ToLower(domain)
+	imp := newDeletionImpact("mail_domain", domain)
+	atDomain := "%@" + likeEscaper.Replace(domain)
+
+	s.addDependents(imp, "mailbox", "deleted with the domain and its mail, after approval",
+		"SELECT email FROM mailboxes WHERE domain_id = ? ORDER BY email", id)
+	s.addDependents(imp, "mail_alias", "deleted with the domain",
+		"SELECT source_email || ' -> ' || destination_email FROM mail_aliases WHERE domain_id = ? ORDER BY source_email", id)
+	s.addDependents(imp, "forwarding_alias", "kept, forwarding to addresses that no longer exist",
+		`SELECT source_email || ' -> ' || destination_email FROM mail_aliases
+		 WHERE domain_id != ? AND LOWER(destination_email) LIKE ? ESCAPE '\' ORDER BY source_email`, id, atDomain)
+	s.addDependents(imp, "dkim_key", "deleted; outbound mail is no longer signed",
+		"SELECT selector FROM dkim_keys WHERE domain_id = ? ORDER BY selector", id)
+	s.addDependents(imp, "cleanup_policy", "deleted with the domain",
+		"SELECT folder || ' (' || action || ')' FROM mail_cleanup_policies WHERE domain_id = ?", id)
+	s.addDependents(imp, "disclaimer", "kept until deleted separately",
+		"SELECT domain FROM domain_disclaimers WHERE LOWER(domain) = ?", domain)
+	s.addDependents(imp, "backscatter_domain", "kept until deleted separately",
+		"SELECT domain FROM backscatter_domains WHERE LOWER(domain) = ?", domain)
+
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+	if tm := s.findTransportMap(domain); tm != nil {
+		imp.Dependents = append(imp.Dependents, ImpactDependent{
+			Type: "transport_map", Count: 1, Examples: []string{domain + " -> " + tm.Transport},
+			Effect: "kept; mail for the domain is still routed by it",
+		})
+	}
+	if relays, err := postfixMgr.GetSenderDependentRelays(); err == nil {
+		for _, relay := range relays {
+			if strings.EqualFold(relay.Sender, "@"+domain) {
+				imp.Dependents = append(imp.Dependents, ImpactDependent{
+					Type: "sender_relay", Count: 1, Examples: []string{relay.Sender + " -> " + relay.Relayhost},
+					Effect: "kept until deleted separately",
+				})
+			}
+		}
+	}
+
+	s.addTraffic(imp, `LOWER(mail_to) LIKE ? ESCAPE '\'`, atDomain)
+	s.addQueued(imp, "*@"+domain)
+	s.writeImpact(w, imp)
+}
+
+// getAliasImpact reports what deleting one alias would affect. Postfix
+// logs mail rewritten by an alias with the alias as orig_to, so the
+// traffic is the mail that reached this destination through it.
+func (s *Server) getAliasImpact(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var source, dest string
+	var domainID int64
+	err := s.db.QueryRow("SELECT source_email, destination_email, domain_id FROM mail_aliases WHERE id = ?", id).
+		Scan(&source, &dest, &domainID)
+	if err != nil || !s.domainInScope(r, domainID) {
+		http.Error(w, "Alias not found", http.StatusNotFound)
+		return
+	}
+	source, dest = strings.ToLower(source), strings.ToLower(dest)
+	imp := newDeletionImpact("mail_alias", source+" -> "+dest)
+
+	s.addDependents(imp, "chained_alias", "kept; mail they forward to "+source+" no longer reaches "+dest,
+		"SELECT source_email FROM mail_aliases WHERE LOWER(destination_email) = ? AND id != ? ORDER BY source_email", source, id)
+
+	// What is left to deliver mail for the source once the alias is gone
+	var others, mailboxes int
+	s.db.QueryRow("SELECT COUNT(*) FROM mail_aliases WHERE LOWER(source_email) = ? AND id != ?", source, id).Scan(&others)
+	s.db.QueryRow("SELECT COUNT(*) FROM mailboxes WHERE LOWER(email) = ?", source).Scan(&mailboxes)
+	if others == 0 && mailboxes == 0 && !strings.HasPrefix(source, "@") {
+		imp.Warnings = append(imp.Warnings, "nothing else receives mail for "+source+"; it will be rejected as an unknown recipient")
+	}
+
+	origTo := "%orig_to=<" + likeEscaper.Replace(source) + ">%"
+	if strings.HasPrefix(source, "@") {
+		// A catch-all rewrites any address of the domain
+		origTo = "%orig_to=<%" + likeEscaper.Replace(source) + ">%"
+	}
+	s.addTraffic(imp, `LOWER(mail_to) = ? AND message LIKE ? ESCAPE '\'`, dest, origTo)
+	s.writeImpact(w, imp)
+}
+
+// getTransportMapImpact reports what deleting a transport map entry would
+// affect. Mail for the domain then takes the default route.
+func (s *Server) getTransportMapImpact(w http.ResponseWriter, r *http.Request) {
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+	domain := chi.URLParam(r, "domain")
+	tm := s.findTransportMap(domain)
+	if tm == nil {
+		http.Error(w, "transport map not found", http.StatusNotFound)
+		return
+	}
+	domain = strings.ToLower(domain)
+	imp := newDeletionImpact("transport_map", domain+" -> "+tm.Transport)
+
+	bare := strings.TrimPrefix(domain, ".")
+	s.addDependents(imp, "mail_domain", "kept; its mail goes back to local delivery",
+		"SELECT domain FROM mail_domains WHERE LOWER(domain) = ?", bare)
+	s.addDependents(imp, "staged_change", "still applied with the other staged changes",
+		"SELECT operation || ' ' || domain FROM staged_transport_maps WHERE LOWER(domain) = ?", domain)
+
+	if relayDomains, err := postfixMgr.GetParameter("relay_domains"); err == nil {
+		for _, d := range strings.FieldsFunc(relayDomains, func(c rune) bool { return c == ',' || c == ' ' }) {
+			if strings.EqualFold(d, bare) {
+				imp.Warnings = append(imp.Warnings, bare+" is in relay_domains; its mail will be relayed by MX lookup or relayhost instead")
+			}
+		}
+	}
+
+	switch {
+	case domain == "*":
+		s.addTraffic(imp, `1 = 1`)
+		s.addQueued(imp, "*")
+	case strings.HasPrefix(domain, "."):
+		// .example.com matches the subdomains of example.com
+		s.addTraffic(imp, `LOWER(mail_to) LIKE ? ESCAPE '\'`, "%@%"+likeEscaper.Replace(domain))
+		s.addQueued(imp, "*@*"+domain)
+	default:
+		s.addTraffic(imp, `LOWER(mail_to) LIKE ? ESCAPE '\'`, "%@"+likeEscaper.Replace(domain))
+		s.addQueued(imp, "*@"+domain)
+	}
+	s.writeImpact(w, imp)
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/rs/zerolog/log"
+)
+
+// mapImport is what adopting one existing map file would do
+type mapImport struct {
+	postfix.ExistingMap
+	Adoptable      int          `json:"adoptable"`
+	AlreadyManaged int          `json:"alreadyManaged"`
+	adopt          func() error // nil when there is nothing to adopt
+}
+
+// existingMapPath returns where an importable map lives: the syncer's path
+// for the virtual map, the Postfix config dir for the rest
+func (s *Server) existingMapPath(name string) string {
+	if path := s.dovecotSyncer.MapPath(name); path != "" {
+		return path
+	}
+	return filepath.Join(s.cfg.PostfixConfigDir, name)
+}
+
+// planMapImport reads an existing map and works out which of its entries
+// can be adopted into managed state. Files the suite wrote itself have
+// nothing to adopt.
+func (s *Server) planMapImport(name, username string) (*mapImport, error) {
+	existing, err := postfix.ReadExistingMap(name, s.existingMapPath(name))
+	if err != nil {
+		return nil, err
+	}
+	plan := &mapImport{ExistingMap: existing}
+	if !existing.Exists || existing.Managed {
+		return plan, nil
+	}
+
+	var conflicts []postfix.ImportConflict
+	switch name {
+	case "transport":
+		var maps []postfix.TransportMap
+		maps, conflicts = postfix.ImportTransportMaps(existing.Entries)
+		plan.Adoptable = len(maps)
+		plan.adopt = func() error { return postfixMgr.SaveTransportMaps(maps) }
+
+	case "sender_relay":
+		var relays []postfix.SenderDependentRelay
+		relays, conflicts = postfix.ImportSenderRelays(existing.Entries)
+		plan.Adoptable = len(relays)
+		plan.adopt = func() error { return postfixMgr.SaveSenderDependentRelays(relays) }
+
+	case "sasl_passwd":
+		var creds []postfix.SASLCredential
+		creds, conflicts = postfix.ImportSASLCredentials(existing.Entries)
+		plan.Entries = postfix.MaskMapValues(existing.Entries)
+		plan.Adoptable = len(creds)
+		plan.adopt = func() error { return postfixMgr.AdoptSASLCredentials(creds) }
+
+	case "virtual":
+		var aliases []postfix.VirtualAlias
+		aliases, conflicts = postfix.ImportVirtualAliases(existing.Entries)
+		type newAlias struct {
+			source, destination string
+			domainID            int64
+		}
+		var adopt []newAlias
+		for _, a := range aliases {
+			var domainID int64
+			domain := a.Source[strings.LastIndex(a.Source, "@")+1:]
+			if s.db.QueryRow(`SELECT id FROM mail_domains WHERE domain = ?`, domain).Scan(&domainID) != nil {
+				conflicts = append(conflicts, postfix.ImportConflict{
+					Line: a.Line, Key: a.Source, Reason: "domain " + domain + " isn't a mail domain",
+				})
+				continue
+			}
+			var exists int
+			s.db.QueryRow(`SELECT COUNT(*) FROM mail_aliases WHERE source_email = ? AND destination_email = ?`,
+				a.Source, a.Destination).Scan(&exists)
+			if exists > 0 {
+				plan.AlreadyManaged++
+				continue
+			}
+			adopt = append(adopt, newAlias{a.Source, a.Destination, domainID})
+		}
+		plan.Adoptable = len(adopt)
+		plan.adopt = func() error {
+			for _, a := range adopt {
+				if _, err := s.db.Exec(`INSERT OR IGNORE INTO mail_aliases (source_email, destination_email, domain_id) VALUES (?, ?, ?)`,
+					a.source, a.destination, a.domainID); err != nil {
+					return err
+				}
+			}
+			return s.dovecotSyncer.SyncPostfixMaps()
+		}
+
+	case "access":
+		var domains []string
+		domains, conflicts = postfix.ImportBackscatterDomains(existing.Entries)
+		var adopt []string
+		for _, d := range domains {
+			var exists int
+			s.db.QueryRow(`SELECT COUNT(*) FROM backscatter_domains WHERE domain = ?`, d).Scan(&exists)
+			if exists > 0 {
+				plan.AlreadyManaged++
+				continue
+			}
+			adopt = append(adopt, d)
+		}
+		plan.Adoptable = len(adopt)
+		plan.adopt = func() error {
+			for _, d := range adopt {
+				if _, err := s.db.Exec(`INSERT OR IGNORE INTO backscatter_domains (domain, created_by) VALUES (?, ?)`, d, username); err != nil {
+					return err
+				}
+			}
+			return s.syncBackscatterDomains()
+		}
+	}
+	plan.Conflicts = append(plan.Conflicts, conflicts...)
+	return plan, nil
+}
+
+// unmanagedMaps returns the names of importable maps that exist and that
+// the suite didn't write
+func (s *Server) unmanagedMaps() []string {
+	names := []string{}
+	for _, name := range postfix.ImportableMaps {
+		existing, err := postfix.ReadExistingMap(name, s.existingMapPath(name))
+		if err == nil && existing.Exists && !existing.Managed {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// getMapImport reports the map files of an existing Postfix install that
+// can be adopted, with the entries that can't
+func (s *Server) getMapImport(w http.ResponseWriter, r *http.Request) {
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+	plans := []*mapImport{}
+	for _, name := range postfix.ImportableMaps {
+		plan, err := s.planMapImport(name, "")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		plans = append(plans, plan)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"maps": plans,
+	})
+}
+
+// importMaps adopts existing map files into managed state. Adopting a map
+// rewrites its file, so maps with conflicts are only adopted when the
+// request accepts dropping the conflicting entries.
+func (s *Server) importMaps(w http.ResponseWriter, r *http.Request) {
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+	user := GetUser(r.Context())
+
+	var req struct {
+		Maps          []string `json:"maps"`
+		DropConflicts bool     `json:"dropConflicts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	v := NewValidator()
+	if len(req.Maps) == 0 {
+		v.AddError("maps", "at least one map is required")
+	}
+	known := map[string]bool{}
+	for _, name := range postfix.ImportableMaps {
+		known[name] = true
+	}
+	for _, name := range req.Maps {
+		if !known[name] {
+			v.AddErrorf("maps", "unknown map %q", name)
+		}
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	plans := []*mapImport{}
+	for _, name := range req.Maps {
+		plan, err := s.planMapImport(name, user.Username)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if plan.adopt == nil {
+			http.Error(w, name+" has nothing to import", http.StatusConflict)
+			return
+		}
+		plans = append(plans, plan)
+	}
+
+	var conflicted []*mapImport
+	for _, plan := range plans {
+		if len(plan.Conflicts) > 0 {
+			conflicted = append(conflicted, plan)
+		}
+	}
+	if len(conflicted) > 0 && !req.DropConflicts {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "some entries can't be adopted; set dropConflicts to import without them",
+			"maps":  conflicted,
+		})
+		return
+	}
+
+	summaries := []string{}
+	for _, plan := range plans {
+		summaries = append(summaries, fmt.Sprintf("%s (%d entries, %d dropped)", plan.Name, plan.Adoptable, len(plan.Conflicts)))
+	}
+	summary := "Imported existing maps: " + strings.Join(summaries, ", ")
+
+	if isDryRun(r) {
+		writeDryRun(w, DryRunResult{Summary: "Would import existing maps: " + strings.Join(summaries, ", ")})
+		return
+	}
+
+	for _, plan := range plans {
+		if err := plan.adopt(); err != nil {
+			log.Error().Err(err).Str("map", plan.Name).Msg("Failed to import existing map")
+			s.logAudit(user.ID, user.Username, "config_import", "config", plan.Name,
+				"Failed to import "+plan.Name+": "+err.Error(), "failed", r.RemoteAddr)
+			http.Error(w, "failed to import "+plan.Name+": "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := postfixMgr.Reload(); err != nil {
+		log.Warn().Err(err).Msg("Failed to reload Postfix after importing maps")
+	}
+
+	s.logAudit(user.ID, user.Username, "config_import", "config", strings.Join(req.Maps, ","), summary, "success", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"maps":    plans,
+	})
+}
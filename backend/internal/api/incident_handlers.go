@@ -0,0 +1,187 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/alerts"
+)
+
+// incidentIDParam parses the incident ID from the URL
+func incidentIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid incident id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// listIncidents returns recent incidents, optionally filtered by status
+func (s *Server) listIncidents(w http.ResponseWriter, r *http.Request) {
+	s.initAlertEngine()
+
+	status := r.URL.Query().Get("status")
+	switch alerts.IncidentStatus(status) {
+	case "", alerts.IncidentOpen, alerts.IncidentAcknowledged, alerts.IncidentResolved:
+	default:
+		http.Error(w, "status must be open, acknowledged or resolved", http.StatusBadRequest)
+		return
+	}
+
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+
+	incidents, err := alertEngine.GetIncidents(status, limit)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"incidents": incidents,
+	})
+}
+
+// getIncident returns an incident with its alerts and timeline
+func (s *Server) getIncident(w http.ResponseWriter, r *http.Request) {
+	s.initAlertEngine()
+	id, ok := incidentIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	incident, err := alertEngine.GetIncident(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "incident not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	incidentAlerts, err := alertEngine.GetIncidentAlerts(id)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	timeline, err := alertEngine.GetIncidentTimeline(id)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"incident": incident,
+		"alerts":   incidentAlerts,
+		"timeline": timeline,
+	})
+}
+
+// getIncidentSummary returns the post-resolution summary of an incident
+func (s *Server) getIncidentSummary(w http.ResponseWriter, r *http.Request) {
+	s.initAlertEngine()
+	id, ok := incidentIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	summary, err := alertEngine.GetIncidentSummary(id)
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "incident not found", http.StatusNotFound)
+		return
+	case err == alerts.ErrIncidentUnresolved:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// acknowledgeIncident acknowledges an incident and all of its firing alerts
+func (s *Server) acknowledgeIncident(w http.ResponseWriter, r *http.Request) {
+	s.initAlertEngine()
+	user := GetUser(r.Context())
+	id, ok := incidentIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Note string `json:"note"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	err := alertEngine.AcknowledgeIncident(id, user.Username, req.Note)
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "incident not found", http.StatusNotFound)
+		return
+	case err == alerts.ErrIncidentResolved:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "failed to acknowledge incident", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "incident_acknowledge", "incident", strconv.FormatInt(id, 10),
+		fmt.Sprintf("Acknowledged incident %d", id), "success", r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addIncidentNote adds a note to an incident's timeline
+func (s *Server) addIncidentNote(w http.ResponseWriter, r *http.Request) {
+	s.initAlertEngine()
+	user := GetUser(r.Context())
+	id, ok := incidentIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Note string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+
+	v := NewValidator()
+	v.ValidateRequired("note", req.Note)
+	v.ValidateMaxLength("note", req.Note, 4000)
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	err := alertEngine.AddIncidentNote(id, user.Username, req.Note)
+	if err == sql.ErrNoRows {
+		http.Error(w, "incident not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "failed to add note", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "incident_note", "incident", strconv.FormatInt(id, 10),
+		fmt.Sprintf("Added note to incident %d", id), "success", r.RemoteAddr)
+
+	w.WriteHeader(http.StatusCreated)
+}
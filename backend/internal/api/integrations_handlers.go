@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Recipient types reported by the recipient check
+const (
+	recipientMailbox  = "mailbox"
+	recipientAlias    = "alias"
+	recipientCatchAll = "catchall"
+	recipientNone     = "none"
+)
+
+// RecipientCheck is the answer to whether an address is delivered locally
+type RecipientCheck struct {
+	Address     string `json:"address"`
+	LocalDomain bool   `json:"localDomain"` // the domain is managed here
+	Deliverable bool   `json:"deliverable"`
+	Type        string `json:"type"` // mailbox, alias, catchall or none
+}
+
+// checkRecipient looks an address up the way Postfix resolves it for
+// managed domains: a mailbox, then an alias, then the domain's catch-all.
+// Inactive mailboxes, aliases and domains don't deliver.
+func (s *Server) checkRecipient(address string) (RecipientCheck, error) {
+	check := RecipientCheck{Address: address, Type: recipientNone}
+	domain := address[strings.LastIndex(address, "@")+1:]
+
+	var domainActive bool
+	if err := s.db.QueryRow(`SELECT active FROM mail_domains WHERE domain = ?`, domain).Scan(&domainActive); err != nil {
+		return check, nil
+	}
+	check.LocalDomain = true
+	if !domainActive {
+		return check, nil
+	}
+
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM mailboxes WHERE email = ? AND active = TRUE`, address).Scan(&n); err != nil {
+		return check, err
+	}
+	if n > 0 {
+		check.Deliverable, check.Type = true, recipientMailbox
+		return check, nil
+	}
+
+	for _, lookup := range []struct{ source, kind string }{
+		{address, recipientAlias},
+		{"@" + domain, recipientCatchAll},
+	} {
+		if err := s.db.QueryRow(`SELECT COUNT(*) FROM mail_aliases WHERE source_email = ? AND active = TRUE`, lookup.source).Scan(&n); err != nil {
+			return check, err
+		}
+		if n > 0 {
+			check.Deliverable, check.Type = true, lookup.kind
+			return check, nil
+		}
+	}
+	return check, nil
+}
+
+// postRecipientCheck tells provisioning systems whether an address is
+// delivered locally, and as a mailbox or an alias
+func (s *Server) postRecipientCheck(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Address string `json:"address"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Address = strings.ToLower(strings.TrimSpace(req.Address))
+
+	v := NewValidator()
+	v.ValidateRequired("address", req.Address)
+	v.ValidateEmail("address", req.Address)
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	check, err := s.checkRecipient(req.Address)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to check recipient")
+		http.Error(w, "Failed to check recipient", http.StatusInternalServerError)
+		return
+	}
+
+	if token := getAPIToken(r.Context()); token != nil {
+		log.Debug().Str("token", token.Name).Str("address", req.Address).Str("type", check.Type).Msg("Recipient check")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(check)
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/retention"
+)
+
+// listLegalHolds returns legal holds, newest first; ?active=true leaves out
+// released ones
+func (s *Server) listLegalHolds(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT ` + retention.HoldColumns + ` FROM legal_holds`
+	if r.URL.Query().Get("active") == "true" {
+		query += ` WHERE released_at IS NULL`
+	}
+	rows, err := s.db.Query(query + ` ORDER BY placed_at DESC, id DESC`)
+	if err != nil {
+		http.Error(w, "Failed to load legal holds", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	holds := []retention.Hold{}
+	for rows.Next() {
+		if h, err := retention.ScanHold(rows.Scan); err == nil {
+			holds = append(holds, h)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(holds)
+}
+
+// placeLegalHold puts a mailbox or a log time range under legal hold
+func (s *Server) placeLegalHold(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Scope      string `json:"scope"`
+		MailboxID  int64  `json:"mailboxId"`
+		RangeStart string `json:"rangeStart"`
+		RangeEnd   string `json:"rangeEnd"`
+		Reason     string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+
+	v := NewValidator()
+	v.ValidateRequired("reason", req.Reason)
+	v.ValidateMaxLength("reason", req.Reason, 1000)
+
+	var email string
+	var start, end time.Time
+	switch req.Scope {
+	case retention.HoldMailbox:
+		err := s.db.QueryRow(`SELECT email FROM mailboxes WHERE id = ?`, req.MailboxID).Scan(&email)
+		if err != nil {
+			v.AddError("mailboxId", "mailbox not found")
+		}
+	case retention.HoldLogs:
+		var err1, err2 error
+		start, err1 = time.Parse(time.RFC3339, req.RangeStart)
+		end, err2 = time.Parse(time.RFC3339, req.RangeEnd)
+		if err1 != nil {
+			v.AddError("rangeStart", "must be an RFC 3339 timestamp")
+		}
+		if err2 != nil {
+			v.AddError("rangeEnd", "must be an RFC 3339 timestamp")
+		}
+		if err1 == nil && err2 == nil && !end.After(start) {
+			v.AddErrorf("rangeEnd", "must be after %s", "rangeStart")
+		}
+	default:
+		v.AddErrorf("scope", "must be one of: %s", "mailbox, logs")
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	user := GetUser(r.Context())
+	hold := retention.Hold{
+		Scope:    req.Scope,
+		Reason:   req.Reason,
+		PlacedBy: user.Username,
+		PlacedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	var mailboxID, mailboxEmail, rangeStart, rangeEnd interface{}
+	if req.Scope == retention.HoldMailbox {
+		hold.MailboxID, hold.MailboxEmail = req.MailboxID, email
+		mailboxID, mailboxEmail = req.MailboxID, email
+	} else {
+		start, end = start.UTC(), end.UTC()
+		hold.RangeStart, hold.RangeEnd = &start, &end
+		rangeStart, rangeEnd = start.Format(time.RFC3339), end.Format(time.RFC3339)
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO legal_holds (scope, mailbox_id, mailbox_email, range_start, range_end, reason, placed_by, placed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, hold.Scope, mailboxID, mailboxEmail, rangeStart, rangeEnd, hold.Reason, hold.PlacedBy, hold.PlacedAt.Format(time.RFC3339))
+	if err != nil {
+		http.Error(w, "Failed to place legal hold", http.StatusInternalServerError)
+		return
+	}
+	hold.ID, _ = result.LastInsertId()
+
+	s.logAudit(user.ID, user.Username, "legal_hold_place", "legal_hold", fmt.Sprintf("%d", hold.ID),
+		"Placed legal hold on "+holdTarget(hold)+": "+hold.Reason, "success", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hold)
+}
+
+// releaseLegalHold releases an active hold, recording who released it
+func (s *Server) releaseLegalHold(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid legal hold ID", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	v := NewValidator()
+	v.ValidateRequired("reason", req.Reason)
+	v.ValidateMaxLength("reason", req.Reason, 1000)
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	hold, err := retention.ScanHold(s.db.QueryRow(`SELECT `+retention.HoldColumns+` FROM legal_holds WHERE id = ?`, id).Scan)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Legal hold not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to load legal hold", http.StatusInternalServerError)
+		return
+	}
+	if !hold.Active() {
+		http.Error(w, "Legal hold already released", http.StatusConflict)
+		return
+	}
+
+	user := GetUser(r.Context())
+	now := time.Now().UTC().Truncate(time.Second)
+	_, err = s.db.Exec(`
+		UPDATE legal_holds SET released_by = ?, released_at = ?, release_reason = ?
+		WHERE id = ? AND released_at IS NULL
+	`, user.Username, now.Format(time.RFC3339), req.Reason, id)
+	if err != nil {
+		http.Error(w, "Failed to release legal hold", http.StatusInternalServerError)
+		return
+	}
+	hold.ReleasedBy, hold.ReleasedAt, hold.ReleaseReason = user.Username, &now, req.Reason
+
+	s.logAudit(user.ID, user.Username, "legal_hold_release", "legal_hold", fmt.Sprintf("%d", id),
+		"Released legal hold on "+holdTarget(hold)+": "+req.Reason, "success", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hold)
+}
+
+// holdTarget describes what a hold covers, for audit summaries
+func holdTarget(h retention.Hold) string {
+	if h.Scope == retention.HoldMailbox {
+		return "mailbox " + h.MailboxEmail
+	}
+	if h.RangeStart == nil || h.RangeEnd == nil {
+		return "logs"
+	}
+	return fmt.Sprintf("logs from %s to %s", h.RangeStart.Format(time.RFC3339), h.RangeEnd.Format(time.RFC3339))
+}
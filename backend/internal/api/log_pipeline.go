@@ -0,0 +1,107 @@
+package api
+
+import (
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/bake"
+	"github.com/postfixrelay/postfixrelay/internal/connstats"
+	"github.com/postfixrelay/postfixrelay/internal/deliveries"
+	"github.com/postfixrelay/postfixrelay/internal/deliverystats"
+	"github.com/postfixrelay/postfixrelay/internal/flowstats"
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/postfixrelay/postfixrelay/internal/sendapi"
+	"github.com/postfixrelay/postfixrelay/internal/tlsstats"
+	"github.com/postfixrelay/postfixrelay/internal/usage"
+)
+
+// Background consumers of new mail log entries
+var (
+	connStats       *connstats.Collector
+	tlsStats        *tlsstats.Collector
+	deliveryStats   *deliverystats.Collector
+	flowStats       *flowstats.Collector
+	usageMeter      *usage.Meter
+	sendTracker     *sendapi.Tracker
+	deliveryTracker *deliveries.Tracker
+	logIngester     *logs.Ingester
+	smtpdErrors     *bake.Counter
+	logPipelineStop = make(chan struct{})
+	logPipelineDone = make(chan struct{})
+)
+
+// startLogPipeline feeds every new log entry to the statistics collectors
+func (s *Server) startLogPipeline() {
+	connStats = connstats.NewCollector(s.db.DB)
+	connStats.Start()
+	tlsStats = tlsstats.NewCollector(s.db.DB)
+	tlsStats.Start()
+	deliveryStats = deliverystats.NewCollector(s.db.DB)
+	deliveryStats.Start()
+	flowStats = flowstats.NewCollector(s.db.DB)
+	flowStats.Start()
+	usageMeter = usage.NewMeter(s.db.DB)
+	usageMeter.Start()
+	sendTracker = sendapi.NewTracker(s.db.DB)
+	deliveryTracker = deliveries.NewTracker(s.db.DB)
+	deliveryTracker.Start()
+	logIngester = logs.NewIngester(s.db.DB)
+	logIngester.Start()
+	smtpdErrors = bake.NewCounter()
+
+	go s.runLogPipeline(connStats.Consume, tlsStats.Consume, deliveryStats.Consume, flowStats.Consume, usageMeter.Consume,
+		snmpCounters.Consume, metricsCounters.Consume, archiveMonitor.Consume, sendTracker.Consume, smtpdErrors.Consume, replicationMonitor.Consume,
+		deliveryTracker.Consume, logIngester.Consume)
+}
+
+// runLogPipeline subscribes to the log reader and hands entries to the
+// consumers. The reader is replaced when log_source changes, which closes
+// the subscription, so it resubscribes to whichever reader is current.
+func (s *Server) runLogPipeline(consumers ...func(logs.Entry)) {
+	defer close(logPipelineDone)
+
+	for {
+		s.initLogReader()
+		logReaderMu.Lock()
+		reader := logReader
+		ch := reader.Subscribe()
+		logReaderMu.Unlock()
+
+	consume:
+		for {
+			select {
+			case <-logPipelineStop:
+				reader.Unsubscribe(ch)
+				return
+			case entry, ok := <-ch:
+				if !ok {
+					break consume
+				}
+				for _, consume := range consumers {
+					consume(entry)
+				}
+			}
+		}
+
+		select {
+		case <-logPipelineStop:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// stopLogPipeline stops consuming log entries and flushes the collectors
+func (s *Server) stopLogPipeline() {
+	if connStats == nil {
+		return
+	}
+	close(logPipelineStop)
+	<-logPipelineDone
+	connStats.Stop()
+	tlsStats.Stop()
+	deliveryStats.Stop()
+	flowStats.Stop()
+	usageMeter.Stop()
+	deliveryTracker.Stop()
+	logIngester.Stop()
+}
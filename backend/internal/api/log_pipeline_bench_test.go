@@ -0,0 +1,107 @@
+package api
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/connstats"
+	"github.com/postfixrelay/postfixrelay/internal/database"
+	"github.com/postfixrelay/postfixrelay/internal/deliverystats"
+	"github.com/postfixrelay/postfixrelay/internal/flowstats"
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/postfixrelay/postfixrelay/internal/tlsstats"
+	"github.com/rs/zerolog"
+)
+
+// BenchmarkLogIngest measures log entries through the collectors the log
+// pipeline feeds, including flushing their counts to SQLite. One op is
+// one entry.
+func BenchmarkLogIngest(b *testing.B) {
+	// Log lines would land in the middle of the result line
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	defer zerolog.SetGlobalLevel(level)
+
+	db, err := database.New(filepath.Join(b.TempDir(), "ingest.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Migrate(); err != nil {
+		b.Fatal(err)
+	}
+	entries := benchLogEntries(time.Now().Add(-time.Hour), 10000)
+
+	b.ReportAllocs()
+	conn := connstats.NewCollector(db.DB)
+	tls := tlsstats.NewCollector(db.DB)
+	delivery := deliverystats.NewCollector(db.DB)
+	flow := flowstats.NewCollector(db.DB)
+	conn.Start()
+	tls.Start()
+	delivery.Start()
+	flow.Start()
+	consumers := []func(logs.Entry){conn.Consume, tls.Consume, delivery.Consume, flow.Consume}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		e := entries[i%len(entries)]
+		for _, consume := range consumers {
+			consume(e)
+		}
+	}
+	// Stop flushes the pending counts
+	conn.Stop()
+	tls.Stop()
+	delivery.Stop()
+	flow.Stop()
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "entries/s")
+}
+
+// benchLogEntries returns n entries of a relay's steady traffic: a client
+// connects over TLS, authenticates and submits a message, which is then
+// relayed with a mix of sent, deferred and bounced outcomes
+func benchLogEntries(start time.Time, n int) []logs.Entry {
+	entries := make([]logs.Entry, 0, n+8)
+	for i := 0; len(entries) < n; i++ {
+		ts := start.Add(time.Duration(i) * 50 * time.Millisecond)
+		pid := 2000 + i%50
+		queueID := fmt.Sprintf("%010X", i+1)
+		client := fmt.Sprintf("client%d.example.com[192.0.2.%d]", i%40, 10+i%40)
+		sender := fmt.Sprintf("app%d@example.com", i%20)
+		recipient := fmt.Sprintf("user%d@example.net", i%500)
+
+		status, dsn := "sent", "2.0.0"
+		switch i % 100 {
+		case 7, 31, 64:
+			status, dsn = "deferred", "4.4.1"
+		case 90:
+			status, dsn = "bounced", "5.1.1"
+		}
+
+		smtpd := func(msg string) logs.Entry {
+			return logs.Entry{Timestamp: ts, Hostname: "relay", Process: "postfix/smtpd", PID: pid, Message: msg}
+		}
+		entries = append(entries,
+			smtpd("connect from "+client),
+			smtpd("Anonymous TLS connection established from "+client+": TLSv1.3 with cipher TLS_AES_256_GCM_SHA384 (256/256 bits)"),
+			logs.Entry{Timestamp: ts, Hostname: "relay", Process: "postfix/smtpd", PID: pid, QueueID: queueID,
+				Message: "client=" + client + ", sasl_method=PLAIN, sasl_username=" + sender},
+			logs.Entry{Timestamp: ts, Hostname: "relay", Process: "postfix/qmgr", PID: 1001, QueueID: queueID,
+				Message: "from=<" + sender + ">, size=4210, nrcpt=1 (queue active)", MailFrom: sender},
+			logs.Entry{Timestamp: ts, Hostname: "relay", Process: "postfix/smtp", PID: pid + 1000,
+				Message: "Trusted TLS connection established to mx.example.net[198.51.100.25]:25: TLSv1.3 with cipher TLS_AES_256_GCM_SHA384 (256/256 bits)"},
+			logs.Entry{Timestamp: ts, Hostname: "relay", Process: "postfix/smtp", PID: pid + 1000, QueueID: queueID,
+				Message: "to=<" + recipient + ">, relay=mx.example.net[198.51.100.25]:25, delay=0.4, dsn=" + dsn + ", status=" + status,
+				MailTo:  recipient, Status: status, Relay: "mx.example.net[198.51.100.25]:25", Delay: 0.4, DSN: dsn},
+			smtpd("disconnect from "+client+" ehlo=2 starttls=1 auth=1 mail=1 rcpt=1 data=1 quit=1 commands=8"),
+		)
+		if status != "deferred" {
+			entries = append(entries, logs.Entry{Timestamp: ts, Hostname: "relay", Process: "postfix/qmgr", PID: 1001,
+				QueueID: queueID, Message: "removed"})
+		}
+	}
+	return entries[:n]
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+)
+
+// searchLogs searches every stored mail log line, not just those the log
+// reader still holds. ?q= is the full-text query (see logs.MatchQuery);
+// ?queueId=, ?severity=, and ?since= and ?until= narrow it, and ?limit= and
+// ?offset= page through the matches, newest first.
+func (s *Server) searchLogs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := logs.SearchFilter{
+		Query:    strings.TrimSpace(q.Get("q")),
+		QueueID:  strings.ToUpper(strings.TrimSpace(q.Get("queueId"))),
+		Severity: strings.ToLower(strings.TrimSpace(q.Get("severity"))),
+		Limit:    100,
+	}
+
+	v := NewValidator()
+	v.ValidateRequired("q", f.Query)
+	v.ValidateMaxLength("q", f.Query, 500)
+	if f.Severity != "" && f.Severity != "info" && f.Severity != "warning" && f.Severity != "error" {
+		v.AddError("severity", "must be info, warning or error")
+	}
+	for _, p := range []struct {
+		name string
+		dest *time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		if value := q.Get(p.name); value != "" {
+			t, err := parseDeliveryTime(value)
+			if err != nil {
+				v.AddError(p.name, "must be an RFC 3339 time or a YYYY-MM-DD date")
+				continue
+			}
+			*p.dest = t
+		}
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	if l := q.Get("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &f.Limit)
+	}
+	if f.Limit < 1 || f.Limit > 1000 {
+		f.Limit = 100
+	}
+	if o := q.Get("offset"); o != "" {
+		fmt.Sscanf(o, "%d", &f.Offset)
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+	f.Domains = s.deliveryScope(r)
+
+	// Lines still waiting to be written would be missed
+	if deliveryTracker != nil {
+		deliveryTracker.Flush()
+	}
+	if logIngester != nil {
+		logIngester.Flush()
+	}
+	results, total, err := logs.Search(s.db.DB, f)
+	if errors.Is(err, logs.ErrBadQuery) {
+		v.AddError("q", strings.TrimPrefix(err.Error(), logs.ErrBadQuery.Error()+": "))
+		writeValidationErrors(w, r, v)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to search logs", http.StatusInternalServerError)
+		return
+	}
+	if !mayViewAddresses(r) {
+		for i := range results {
+			results[i].Entry = maskLogEntry(results[i].Entry)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"logs":   results,
+		"total":  total,
+		"limit":  f.Limit,
+		"offset": f.Offset,
+	})
+}
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Kinds of login recorded in login_history
+const (
+	loginAdmin   = "admin"
+	loginWebmail = "webmail"
+)
+
+// LoginRecord is one sign-in attempt
+type LoginRecord struct {
+	ID            int64     `json:"id"`
+	OccurredAt    time.Time `json:"occurredAt"`
+	Kind          string    `json:"kind"`
+	UserID        int64     `json:"userId,omitempty"`
+	Username      string    `json:"username"`
+	Success       bool      `json:"success"`
+	FailureReason string    `json:"failureReason,omitempty"`
+	AuthSource    string    `json:"authSource"`
+	IPAddress     string    `json:"ipAddress"`
+	UserAgent     string    `json:"userAgent"`
+}
+
+// recordLogin stores a sign-in attempt. userID is 0 when the account isn't
+// known; reason is empty for a success.
+func (s *Server) recordLogin(r *http.Request, kind string, userID int64, username, source, reason string) {
+	var uid interface{}
+	if userID != 0 {
+		uid = userID
+	}
+	var failure interface{}
+	if reason != "" {
+		failure = reason
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO login_history (occurred_at, kind, user_id, username, success, failure_reason, auth_source, ip_address, user_agent)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, time.Now().UTC().Format(time.RFC3339), kind, uid, strings.ToLower(username), reason == "", failure,
+		source, clientIP(r), r.UserAgent())
+	if err != nil {
+		log.Error().Err(err).Str("username", username).Msg("Failed to record login")
+	}
+}
+
+// loginHistoryFilter selects login_history rows
+type loginHistoryFilter struct {
+	kind     string
+	username string
+	userID   int64
+	ip       string
+	success  *bool
+	since    time.Time
+	until    time.Time
+	// tenantID, when set, limits admin logins to the tenant's users
+	tenantID int64
+	limit    int
+}
+
+// queryLoginHistory returns matching attempts, newest first, and how many
+// match in all
+func (s *Server) queryLoginHistory(f loginHistoryFilter) ([]LoginRecord, int, error) {
+	where := []string{"1=1"}
+	var args []interface{}
+	if f.kind != "" {
+		where = append(where, "kind = ?")
+		args = append(args, f.kind)
+	}
+	if f.userID != 0 {
+		where = append(where, "user_id = ?")
+		args = append(args, f.userID)
+	}
+	if f.username != "" {
+		where = append(where, "username = ?")
+		args = append(args, strings.ToLower(f.username))
+	}
+	if f.ip != "" {
+		where = append(where, "ip_address = ?")
+		args = append(args, f.ip)
+	}
+	if f.success != nil {
+		where = append(where, "success = ?")
+		args = append(args, *f.success)
+	}
+	if !f.since.IsZero() {
+		where = append(where, "occurred_at >= ?")
+		args = append(args, f.since.UTC().Format(time.RFC3339))
+	}
+	if !f.until.IsZero() {
+		where = append(where, "occurred_at < ?")
+		args = append(args, f.until.UTC().Format(time.RFC3339))
+	}
+	if f.tenantID != 0 {
+		where = append(where, "kind = 'admin' AND user_id IN (SELECT id FROM users WHERE tenant_id = ?)")
+		args = append(args, f.tenantID)
+	}
+	cond := " WHERE " + strings.Join(where, " AND ")
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM login_history"+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	limit := f.limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	rows, err := s.db.Query(`
+		SELECT id, occurred_at, kind, COALESCE(user_id, 0), username, success, COALESCE(failure_reason, ''),
+			auth_source, COALESCE(ip_address, ''), COALESCE(user_agent, '')
+		FROM login_history`+cond+`
+		ORDER BY occurred_at DESC, id DESC LIMIT ?
+	`, append(args, limit)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	records := []LoginRecord{}
+	for rows.Next() {
+		var rec LoginRecord
+		var occurred string
+		if err := rows.Scan(&rec.ID, &occurred, &rec.Kind, &rec.UserID, &rec.Username, &rec.Success,
+			&rec.FailureReason, &rec.AuthSource, &rec.IPAddress, &rec.UserAgent); err != nil {
+			return nil, 0, err
+		}
+		rec.OccurredAt, _ = time.Parse(time.RFC3339, occurred)
+		records = append(records, rec)
+	}
+	return records, total, rows.Err()
+}
+
+// parseLoginHistoryFilter reads ?success=, ?since=, ?until= and ?limit=,
+// the filters every login history listing takes
+func parseLoginHistoryFilter(w http.ResponseWriter, r *http.Request) (loginHistoryFilter, bool) {
+	q := r.URL.Query()
+	var f loginHistoryFilter
+	v := NewValidator()
+	if raw := q.Get("success"); raw != "" {
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			v.AddError("success", "must be true or false")
+		}
+		f.success = &b
+	}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &f.since}, {"until", &f.until}} {
+		if raw := q.Get(p.name); raw != "" {
+			t, err := parseDeliveryTime(raw)
+			if err != nil {
+				v.AddError(p.name, "must be an RFC 3339 time or a YYYY-MM-DD date")
+			}
+			*p.t = t
+		}
+	}
+	if raw := q.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			v.AddError("limit", "must be a positive integer")
+		}
+		f.limit = n
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return f, false
+	}
+	return f, true
+}
+
+// writeLoginHistory answers with the matching attempts and the last
+// successful and failed ones among them
+func (s *Server) writeLoginHistory(w http.ResponseWriter, f loginHistoryFilter) {
+	records, total, err := s.queryLoginHistory(f)
+	if err != nil {
+		http.Error(w, "Failed to load login history", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"entries": records,
+		"total":   total,
+	}
+	// For a single account, surface its last sign-in and last failure
+	// even when they fall outside the page
+	if f.userID != 0 || f.username != "" {
+		for key, success := range map[string]bool{"lastLogin": true, "lastFailure": false} {
+			last := f
+			last.success, last.since, last.until, last.limit = &success, time.Time{}, time.Time{}, 1
+			if rec, _, err := s.queryLoginHistory(last); err == nil && len(rec) > 0 {
+				resp[key] = rec[0]
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getMyLoginHistory returns the signed-in admin user's own login history
+func (s *Server) getMyLoginHistory(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	f, ok := parseLoginHistoryFilter(w, r)
+	if !ok {
+		return
+	}
+	f.kind, f.userID = loginAdmin, user.ID
+	s.writeLoginHistory(w, f)
+}
+
+// getMailLoginHistory returns the webmail logins of the signed-in mailbox
+func (s *Server) getMailLoginHistory(w http.ResponseWriter, r *http.Request) {
+	session := getMailSession(r.Context())
+	if session == nil {
+		http.Error(w, "Mail session required", http.StatusUnauthorized)
+		return
+	}
+	f, ok := parseLoginHistoryFilter(w, r)
+	if !ok {
+		return
+	}
+	f.kind, f.username = loginWebmail, session.Email
+	s.writeLoginHistory(w, f)
+}
+
+// listLoginHistory returns everyone's login history for admins. Filters:
+// ?kind= (admin or webmail), ?username=, ?userId=, ?ip=, ?success=,
+// ?since=, ?until= and ?limit= (default 100, at most 1000).
+func (s *Server) listLoginHistory(w http.ResponseWriter, r *http.Request) {
+	f, ok := parseLoginHistoryFilter(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	v := NewValidator()
+	f.kind = q.Get("kind")
+	if f.kind != "" && f.kind != loginAdmin && f.kind != loginWebmail {
+		v.AddErrorf("kind", "must be one of: %s", "admin, webmail")
+	}
+	if raw := q.Get("userId"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id < 1 {
+			v.AddError("userId", "must be a positive integer")
+		}
+		f.userID = id
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+	f.username = strings.TrimSpace(q.Get("username"))
+	f.ip = strings.TrimSpace(q.Get("ip"))
+	f.tenantID = tenantOf(r)
+	s.writeLoginHistory(w, f)
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/rs/zerolog/log"
+)
+
+// WebSocket log stream timings. Pings go out well within the idle timeouts
+// of common proxies (60s for nginx), and a client that hasn't answered
+// one within logStreamPongWait is gone.
+const (
+	logStreamPingInterval = 25 * time.Second
+	logStreamPongWait     = 60 * time.Second
+	logStreamWriteWait    = 10 * time.Second
+
+	// logStreamMaxMessage caps what a client may send; filter changes
+	// are small
+	logStreamMaxMessage = 64 << 10
+
+	// logStreamBuffer is how many messages may wait for a slow client
+	// before entries are dropped
+	logStreamBuffer = 256
+)
+
+// logStreamFilter narrows a log stream. Empty fields match everything.
+type logStreamFilter struct {
+	Process  string `json:"process,omitempty"`  // substring, e.g. "smtpd"
+	Severity string `json:"severity,omitempty"` // info, warning or error
+	QueueID  string `json:"queueId,omitempty"`
+}
+
+// logStreamFilterFrom reads a filter from ?process=&severity=&queueId=
+func logStreamFilterFrom(q url.Values) logStreamFilter {
+	return logStreamFilter{
+		Process:  strings.TrimSpace(q.Get("process")),
+		Severity: strings.ToLower(strings.TrimSpace(q.Get("severity"))),
+		QueueID:  strings.ToUpper(strings.TrimSpace(q.Get("queueId"))),
+	}
+}
+
+func (f logStreamFilter) match(e logs.Entry) bool {
+	if f.Process != "" && !strings.Contains(strings.ToLower(e.Process), strings.ToLower(f.Process)) {
+		return false
+	}
+	if f.Severity != "" && !strings.EqualFold(e.Severity, f.Severity) {
+		return false
+	}
+	return f.QueueID == "" || strings.EqualFold(e.QueueID, f.QueueID)
+}
+
+// logStreamMessage is one message of the WebSocket log stream. Clients
+// send {"type": "filter", "filter": {...}} to change the filter, and get
+// the filter in force back.
+type logStreamMessage struct {
+	Type    string           `json:"type"` // connected, log, filter, dropped, error or shutdown
+	Entry   *logs.Entry      `json:"entry,omitempty"`
+	Filter  *logStreamFilter `json:"filter,omitempty"`
+	Dropped int              `json:"dropped,omitempty"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// allowLogStreamOrigin accepts WebSocket handshakes from the panel itself
+// and from the CORS allowed origins. Browsers send cookies with WebSocket
+// handshakes from any site and the stream is exempt from CSRF protection,
+// so this is what keeps other sites from opening it with the user's
+// cookie. Requests without an Origin header don't come from a browser
+// page and are allowed.
+func (s *Server) allowLogStreamOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range s.getAllowedOrigins() {
+		if strings.EqualFold(strings.TrimSpace(allowed), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWebSocketLogs tails the mail log over a WebSocket. Entries are
+// queued for the client and dropped, with a "dropped" message saying how
+// many, when it falls behind, so a slow client never holds up the log
+// reader.
+func (s *Server) handleWebSocketLogs(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: s.allowLogStreamOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has written the error response
+		log.Debug().Err(err).Str("ip", r.RemoteAddr).Msg("WebSocket log stream refused")
+		return
+	}
+	conn.SetReadLimit(logStreamMaxMessage)
+	closeConn := func(code int, reason string) {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(logStreamWriteWait))
+		conn.Close()
+	}
+
+	var filterMu sync.Mutex
+	filter := logStreamFilterFrom(r.URL.Query())
+	inScope := s.logStreamScope(r)
+	masked := !mayViewAddresses(r)
+
+	out := make(chan logStreamMessage, logStreamBuffer)
+	writerDone := make(chan struct{})
+	readerDone := make(chan struct{})
+
+	// Writer: sends queued messages and keepalive pings. It is the only
+	// goroutine writing messages; control frames may go out from others.
+	go func() {
+		defer close(writerDone)
+		ticker := time.NewTicker(logStreamPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case msg, ok := <-out:
+				if !ok {
+					return
+				}
+				data, _ := json.Marshal(msg)
+				conn.SetWriteDeadline(time.Now().Add(logStreamWriteWait))
+				if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+					return
+				}
+				if msg.Type == "shutdown" {
+					closeConn(websocket.CloseGoingAway, "server shutting down")
+					return
+				}
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(logStreamWriteWait)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	// Reader: handles pongs and filter changes until the client goes away
+	conn.SetReadDeadline(time.Now().Add(logStreamPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(logStreamPongWait))
+	})
+	reply := func(msg logStreamMessage) {
+		select {
+		case out <- msg:
+		default:
+		}
+	}
+	go func() {
+		defer close(readerDone)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+					log.Debug().Err(err).Msg("WebSocket log stream read ended")
+				}
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(logStreamPongWait))
+
+			var msg logStreamMessage
+			if err := json.Unmarshal(data, &msg); err != nil || msg.Type != "filter" || msg.Filter == nil {
+				reply(logStreamMessage{Type: "error", Error: `expected {"type": "filter", "filter": {...}}`})
+				continue
+			}
+			f := logStreamFilter{
+				Process:  strings.TrimSpace(msg.Filter.Process),
+				Severity: strings.ToLower(strings.TrimSpace(msg.Filter.Severity)),
+				QueueID:  strings.ToUpper(strings.TrimSpace(msg.Filter.QueueID)),
+			}
+			filterMu.Lock()
+			filter = f
+			filterMu.Unlock()
+			reply(logStreamMessage{Type: "filter", Filter: &f})
+		}
+	}()
+
+	ch := logReader.Subscribe()
+	defer func() {
+		logReader.Unsubscribe(ch)
+		closeConn(websocket.CloseNormalClosure, "")
+		<-readerDone
+		close(out)
+		<-writerDone
+	}()
+
+	filterMu.Lock()
+	initial := filter
+	filterMu.Unlock()
+	out <- logStreamMessage{Type: "connected", Filter: &initial}
+
+	dropped := 0
+	for {
+		select {
+		case <-readerDone:
+			return
+		case <-writerDone:
+			return
+		case <-s.drainCh:
+			// Make room so the shutdown notice always goes out
+			for len(out) == cap(out) {
+				select {
+				case <-out:
+				default:
+				}
+			}
+			select {
+			case out <- logStreamMessage{Type: "shutdown"}:
+				<-writerDone
+			case <-writerDone:
+			}
+			return
+		case entry, ok := <-ch:
+			if !ok {
+				return
+			}
+			filterMu.Lock()
+			f := filter
+			filterMu.Unlock()
+			if !inScope(entry) || !f.match(entry) {
+				continue
+			}
+			if masked {
+				entry = maskLogEntry(entry)
+			}
+
+			if dropped > 0 {
+				select {
+				case out <- logStreamMessage{Type: "dropped", Dropped: dropped}:
+					dropped = 0
+				default:
+					dropped++
+					continue
+				}
+			}
+			select {
+			case out <- logStreamMessage{Type: "log", Entry: &entry}:
+			default:
+				dropped++
+			}
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/mailcleanup"
+)
+
+// mailCleanup runs the mail cleanup policies once a day
+var mailCleanup *mailcleanup.Scheduler
+
+// startMailCleanup starts the daily mail cleanup
+func (s *Server) startMailCleanup() {
+	mailCleanup = mailcleanup.NewScheduler(s.db.DB)
+	mailCleanup.Start()
+}
+
+// getMailCleanup returns the cleanup policies and the last run
+func (s *Server) getMailCleanup(w http.ResponseWriter, r *http.Request) {
+	policies, err := mailcleanup.Load(s.db.DB)
+	if err != nil {
+		http.Error(w, "Failed to load cleanup policies", http.StatusInternalServerError)
+		return
+	}
+	var last *mailcleanup.Result
+	if mailCleanup != nil {
+		last = mailCleanup.LastRun()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"policies": policies,
+		"lastRun":  last,
+	})
+}
+
+// decodeCleanupPolicy reads and validates a cleanup policy, writing the
+// error response itself when it isn't valid
+func (s *Server) decodeCleanupPolicy(w http.ResponseWriter, r *http.Request) (mailcleanup.Policy, bool) {
+	var p mailcleanup.Policy
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return p, false
+	}
+	p.Folder = strings.TrimSpace(p.Folder)
+	p.TargetFolder = strings.TrimSpace(p.TargetFolder)
+
+	v := NewValidator()
+	v.ValidateRequired("folder", p.Folder)
+	v.ValidateMaxLength("folder", p.Folder, 255)
+	switch p.Action {
+	case mailcleanup.ActionDelete:
+		p.TargetFolder = ""
+	case mailcleanup.ActionMove:
+		v.ValidateRequired("targetFolder", p.TargetFolder)
+		v.ValidateMaxLength("targetFolder", p.TargetFolder, 255)
+		if p.TargetFolder != "" && strings.EqualFold(p.TargetFolder, p.Folder) {
+			v.AddError("targetFolder", "must differ from the folder")
+		}
+	default:
+		v.AddErrorf("action", "must be one of: %s", "delete, move")
+	}
+	if p.OlderThanDays < 1 {
+		v.AddError("olderThanDays", "must be a positive integer")
+	}
+	if p.DomainID != 0 {
+		if err := s.db.QueryRow("SELECT domain FROM mail_domains WHERE id = ?", p.DomainID).Scan(&p.Domain); err != nil {
+			v.AddError("domainId", "domain not found")
+		}
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return p, false
+	}
+	return p, true
+}
+
+// nullableDomain stores the every-domain policy's domain as NULL
+func nullableDomain(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// cleanupPolicyExists reports whether another policy covers the same
+// domain and folder
+func (s *Server) cleanupPolicyExists(p mailcleanup.Policy, exceptID int64) bool {
+	var n int
+	s.db.QueryRow(`
+		SELECT COUNT(*) FROM mail_cleanup_policies
+		WHERE COALESCE(domain_id, 0) = ? AND lower(folder) = lower(?) AND id != ?
+	`, p.DomainID, p.Folder, exceptID).Scan(&n)
+	return n > 0
+}
+
+// describeCleanupPolicy summarizes a policy for the audit log
+func describeCleanupPolicy(p mailcleanup.Policy) string {
+	scope := "all domains"
+	if p.Domain != "" {
+		scope = p.Domain
+	}
+	if p.Action == mailcleanup.ActionMove {
+		return fmt.Sprintf("move %s older than %d days to %s (%s)", p.Folder, p.OlderThanDays, p.TargetFolder, scope)
+	}
+	return fmt.Sprintf("delete %s older than %d days (%s)", p.Folder, p.OlderThanDays, scope)
+}
+
+// createCleanupPolicy adds a cleanup policy
+func (s *Server) createCleanupPolicy(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	p, ok := s.decodeCleanupPolicy(w, r)
+	if !ok {
+		return
+	}
+	if s.cleanupPolicyExists(p, 0) {
+		http.Error(w, "A cleanup policy for this folder and domain already exists", http.StatusConflict)
+		return
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO mail_cleanup_policies (domain_id, folder, action, target_folder, older_than_days, enabled, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, nullableDomain(p.DomainID), p.Folder, p.Action, p.TargetFolder, p.OlderThanDays, p.Enabled, user.Username)
+	if err != nil {
+		http.Error(w, "Failed to create cleanup policy", http.StatusInternalServerError)
+		return
+	}
+	p.ID, _ = result.LastInsertId()
+
+	s.logAudit(user.ID, user.Username, "cleanup_policy_create", "cleanup_policy", fmt.Sprint(p.ID),
+		"Created mail cleanup policy: "+describeCleanupPolicy(p), "success", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(p)
+}
+
+// updateCleanupPolicy replaces a cleanup policy
+func (s *Server) updateCleanupPolicy(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	id := chi.URLParam(r, "id")
+	p, ok := s.decodeCleanupPolicy(w, r)
+	if !ok {
+		return
+	}
+	if err := s.db.QueryRow("SELECT id FROM mail_cleanup_policies WHERE id = ?", id).Scan(&p.ID); err == sql.ErrNoRows {
+		http.Error(w, "Cleanup policy not found", http.StatusNotFound)
+		return
+	}
+	if s.cleanupPolicyExists(p, p.ID) {
+		http.Error(w, "A cleanup policy for this folder and domain already exists", http.StatusConflict)
+		return
+	}
+
+	_, err := s.db.Exec(`
+		UPDATE mail_cleanup_policies SET domain_id = ?, folder = ?, action = ?, target_folder = ?,
+			older_than_days = ?, enabled = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, nullableDomain(p.DomainID), p.Folder, p.Action, p.TargetFolder, p.OlderThanDays, p.Enabled, p.ID)
+	if err != nil {
+		http.Error(w, "Failed to update cleanup policy", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "cleanup_policy_update", "cleanup_policy", id,
+		"Updated mail cleanup policy: "+describeCleanupPolicy(p), "success", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// deleteCleanupPolicy removes a cleanup policy
+func (s *Server) deleteCleanupPolicy(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	id := chi.URLParam(r, "id")
+
+	result, err := s.db.Exec("DELETE FROM mail_cleanup_policies WHERE id = ?", id)
+	if err != nil {
+		http.Error(w, "Failed to delete cleanup policy", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Cleanup policy not found", http.StatusNotFound)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "cleanup_policy_delete", "cleanup_policy", id,
+		"Deleted mail cleanup policy "+id, "success", r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runMailCleanup applies the cleanup policies now, or with ?dryRun=true
+// reports how many messages each would delete or move
+func (s *Server) runMailCleanup(w http.ResponseWriter, r *http.Request) {
+	if mailCleanup == nil {
+		http.Error(w, "Mail cleanup is not running", http.StatusServiceUnavailable)
+		return
+	}
+	dryRun := isDryRun(r)
+	result := mailCleanup.RunNow(dryRun)
+
+	if !dryRun {
+		user := GetUser(r.Context())
+		messages := 0
+		for _, a := range result.Actions {
+			messages += a.Messages
+		}
+		s.logAudit(user.ID, user.Username, "cleanup_run", "cleanup_policy", "",
+			fmt.Sprintf("Ran mail cleanup: %d messages in %d folders", messages, len(result.Actions)), "success", r.RemoteAddr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
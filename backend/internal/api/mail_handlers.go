@@ -3,12 +3,18 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
 	"strconv"
 
 	"github.com/emersion/go-imap"
 	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/dlp"
 	"github.com/postfixrelay/postfixrelay/internal/mail"
+	"github.com/postfixrelay/postfixrelay/internal/retention"
+	"github.com/postfixrelay/postfixrelay/internal/supervisor"
 	"github.com/rs/zerolog/log"
 )
 
@@ -17,11 +23,22 @@ var mailSessionManager *mail.SessionManager
 var emailSanitizer *mail.EmailSanitizer
 var smtpSender *mail.SMTPSender
 
+// relaySender submits mail without user credentials (e.g. releasing
+// quarantined messages); the backend must be in Postfix's mynetworks
+var relaySender *mail.SMTPSender
+
 // InitMailServices initializes mail-related services
 func InitMailServices() {
 	mailSessionManager = mail.NewSessionManager()
 	emailSanitizer = mail.NewEmailSanitizer()
 	smtpSender = mail.NewSMTPSender(nil) // Uses default config from environment
+
+	relayCfg := mail.DefaultSMTPConfig()
+	relayCfg.Port = os.Getenv("SMTP_RELAY_PORT")
+	if relayCfg.Port == "" {
+		relayCfg.Port = "25"
+	}
+	relaySender = mail.NewSMTPSender(relayCfg)
 }
 
 // Cookie name for mail session
@@ -47,18 +64,34 @@ func (s *Server) authenticateMail(w http.ResponseWriter, r *http.Request) {
 	session, err := mailSessionManager.Authenticate(req.Email, req.Password)
 	if err != nil {
 		log.Warn().Err(err).Str("email", req.Email).Msg("Mail authentication failed")
+		reason := "unavailable"
+		if errors.Is(err, mail.ErrAuthFailed) {
+			reason = "bad_password"
+		}
+		s.recordLogin(r, loginWebmail, 0, req.Email, "imap", reason)
 		http.Error(w, "Authentication failed", http.StatusUnauthorized)
 		return
 	}
 
+	// The domain defaults or an admin may have turned webmail off
+	var webmail bool
+	if err := s.db.QueryRow(`SELECT webmail_enabled FROM mailboxes WHERE email = ?`, session.Email).Scan(&webmail); err == nil && !webmail {
+		mailSessionManager.CloseSession(session.ID)
+		s.recordLogin(r, loginWebmail, 0, session.Email, "imap", "disabled")
+		http.Error(w, "Webmail is disabled for this mailbox", http.StatusForbidden)
+		return
+	}
+	s.recordLogin(r, loginWebmail, 0, session.Email, "imap", "")
+	s.db.Exec(`UPDATE mailboxes SET last_login = datetime('now') WHERE email = ?`, session.Email)
+
 	// Set session cookie
 	http.SetCookie(w, &http.Cookie{
 		Name:     mailSessionCookie,
 		Value:    session.ID,
 		Path:     "/api/v1/mail",
 		HttpOnly: true,
-		Secure:   r.TLS != nil,
-		SameSite: http.SameSiteStrictMode,
+		Secure:   s.cookieSecure(r),
+		SameSite: s.cookieSameSite(),
 		MaxAge:   3600, // 1 hour
 	})
 
@@ -82,6 +115,8 @@ func (s *Server) logoutMail(w http.ResponseWriter, r *http.Request) {
 		Value:    "",
 		Path:     "/api/v1/mail",
 		HttpOnly: true,
+		Secure:   s.cookieSecure(r),
+		SameSite: s.cookieSameSite(),
 		MaxAge:   -1,
 	})
 
@@ -347,7 +382,12 @@ func (s *Server) deleteMailMessage(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	} else {
-		// If already in trash, permanently delete
+		// If already in trash, permanently delete - unless the mailbox is
+		// under legal hold
+		if retention.MailboxHeld(s.db.DB, session.Email) {
+			http.Error(w, "Mailbox is under legal hold", http.StatusConflict)
+			return
+		}
 		if err := session.DeleteMessage(folder, uint32(uid)); err != nil {
 			log.Error().Err(err).Msg("Failed to delete message")
 			http.Error(w, "Failed to delete message", http.StatusInternalServerError)
@@ -381,28 +421,94 @@ func (s *Server) sendMessage(w http.ResponseWriter, r *http.Request) {
 	if req.Subject == "" {
 		req.Subject = "(No Subject)"
 	}
+	if err := mail.ValidateHeaders(session.Email, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.runSendHooks(r, "webmail", session.Email, &req); err != nil {
+		writeSendHookError(w, err)
+		return
+	}
+
+	// Enforce the mailbox's outbound quota. The slot is given back unless
+	// the message is sent.
+	recipients := len(req.To) + len(req.Cc) + len(req.Bcc)
+	reservation, status, err := s.reserveSend(session.Email, recipients)
+	if err != nil {
+		log.Warn().Str("from", session.Email).Int("recipients", recipients).Msg("Send quota exceeded")
+		http.Error(w, err.Error(), status)
+		return
+	}
+	sent := false
+	defer func() {
+		if !sent {
+			s.releaseSend(reservation)
+		}
+	}()
+
+	// Load uploaded attachments, which were scanned when they were staged
+	if err := s.resolveAttachments(r.Context(), session.Email, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Check data-loss-prevention rules
+	dlpCheck := s.checkOutgoingDLP(session.Email, &req)
+	switch dlpCheck.Action {
+	case dlp.ActionBlock:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "Message blocked by data-loss-prevention policy",
+			"matches": dlpCheck.Matches,
+		})
+		return
+	case dlp.ActionQuarantine:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":      true,
+			"quarantined":  true,
+			"quarantineId": dlpCheck.QuarantineID,
+			"message":      "Message held for review by an administrator",
+			"matches":      dlpCheck.Matches,
+		})
+		return
+	}
+
+	s.applyDisclaimer(session.Email, &req)
 
 	// Send via SMTP
 	result, err := smtpSender.Send(session.Email, session.Password, &req)
+	if errors.Is(err, mail.ErrHeaderInjection) {
+		// A hook rewrote the message into one that can't be sent
+		log.Warn().Err(err).Str("from", session.Email).Msg("Refused to send message with a line break in a header")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		log.Error().Err(err).Str("from", session.Email).Msg("Failed to send email")
 		http.Error(w, "Failed to send email: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Try to save to Sent folder (non-blocking, errors are logged but don't fail the send)
-	go func() {
+	sent = true
+	s.finishAttachments(r.Context(), session.Email, result.MessageID, req.Attachments)
+
+	// Try to save to Sent folder (non-blocking, errors are counted but don't fail the send).
+	// Not retried, since a failed append may still have stored the message.
+	s.workers.Go("sent_folder_save", supervisor.Once, func() error {
 		mimeMsg, err := buildMIMEForSent(session.Email, &req, result.MessageID)
 		if err != nil {
-			log.Warn().Err(err).Msg("Failed to build message for Sent folder")
-			return
+			return fmt.Errorf("build message for Sent folder: %w", err)
 		}
 		if err := session.AppendMessage("Sent", mimeMsg, []string{"\\Seen"}); err != nil {
-			log.Warn().Err(err).Msg("Failed to save message to Sent folder")
-		} else {
-			log.Debug().Str("messageId", result.MessageID).Msg("Saved to Sent folder")
+			return fmt.Errorf("save message to Sent folder: %w", err)
 		}
-	}()
+		log.Debug().Str("messageId", result.MessageID).Msg("Saved to Sent folder")
+		return nil
+	})
 
 	log.Info().
 		Str("from", session.Email).
@@ -411,11 +517,16 @@ func (s *Server) sendMessage(w http.ResponseWriter, r *http.Request) {
 		Str("messageId", result.MessageID).
 		Msg("Email sent successfully")
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+	resp := map[string]interface{}{
 		"success":   true,
 		"messageId": result.MessageID,
-	})
+	}
+	if dlpCheck.Action == dlp.ActionWarn {
+		resp["warnings"] = dlpCheck.Matches
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
 
 // searchMessages searches for emails
@@ -457,6 +568,9 @@ func (s *Server) searchMessages(w http.ResponseWriter, r *http.Request) {
 
 // buildMIMEForSent creates a MIME message for saving to Sent folder
 func buildMIMEForSent(from string, msg *mail.ComposeMessage, msgID string) ([]byte, error) {
+	if err := mail.ValidateHeaders(from, msg); err != nil {
+		return nil, err
+	}
 	var buf []byte
 	buf = append(buf, []byte("From: "+from+"\r\n")...)
 	buf = append(buf, []byte("To: "+joinAddresses(msg.To)+"\r\n")...)
@@ -486,7 +600,7 @@ func buildMIMEForSent(from string, msg *mail.ComposeMessage, msgID string) ([]by
 		buf = append(buf, []byte(msg.Body)...)
 	}
 
-	return buf, nil
+	return mail.NormalizeLineEndings(buf), nil
 }
 
 func joinAddresses(addrs []string) string {
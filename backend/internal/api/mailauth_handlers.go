@@ -0,0 +1,306 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/canary"
+	"github.com/postfixrelay/postfixrelay/internal/mail"
+	"github.com/postfixrelay/postfixrelay/internal/mailauth"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/postfixrelay/postfixrelay/internal/smtpsink"
+	"github.com/postfixrelay/postfixrelay/internal/supervisor"
+	"github.com/rs/zerolog/log"
+)
+
+// Signing test probes are looked for every mailAuthPollInterval until
+// mailAuthProbeTimeout, and kept for mailAuthProbeRetention to be read
+const (
+	mailAuthPollInterval   = 5 * time.Second
+	mailAuthProbeTimeout   = 3 * time.Minute
+	mailAuthProbeRetention = time.Hour
+
+	// mailAuthSubjectPrefix starts the subject of every signing test; the
+	// probe's ID follows it
+	mailAuthSubjectPrefix = "PostfixRelay signing test"
+
+	// mailAuthMaxMessage caps messages uploaded for verification
+	mailAuthMaxMessage = 10 << 20
+)
+
+// How a signing test probe is read back
+const (
+	probeViaSink      = "sink"      // captured by the SMTP sink
+	probeViaSeed      = "seed"      // delivered to the canary seed mailbox
+	probeViaReflector = "reflector" // an auto-responder mails its verdict to the sender
+)
+
+// mailAuthProbe is one signing test
+type mailAuthProbe struct {
+	ID          string           `json:"id"`
+	From        string           `json:"from"`
+	To          string           `json:"to"`
+	Via         string           `json:"via"`
+	Status      string           `json:"status"` // pending, verified, sent, timeout or failed
+	SentAt      time.Time        `json:"sentAt"`
+	CompletedAt *time.Time       `json:"completedAt,omitempty"`
+	Report      *mailauth.Report `json:"report,omitempty"`
+	Error       string           `json:"error,omitempty"`
+
+	clientIP net.IP
+}
+
+var (
+	mailAuthProbesMu sync.Mutex
+	mailAuthProbes   = map[string]*mailAuthProbe{}
+)
+
+func storeMailAuthProbe(p *mailAuthProbe) {
+	mailAuthProbesMu.Lock()
+	defer mailAuthProbesMu.Unlock()
+	for id, old := range mailAuthProbes {
+		if time.Since(old.SentAt) > mailAuthProbeRetention {
+			delete(mailAuthProbes, id)
+		}
+	}
+	mailAuthProbes[p.ID] = p
+}
+
+func finishMailAuthProbe(id, status string, report *mailauth.Report, errMsg string) {
+	mailAuthProbesMu.Lock()
+	defer mailAuthProbesMu.Unlock()
+	if p, ok := mailAuthProbes[id]; ok {
+		now := time.Now().UTC()
+		p.Status, p.Report, p.Error, p.CompletedAt = status, report, errMsg, &now
+	}
+}
+
+// runMailAuthProbe sends a message through Postfix, so whatever signs
+// outbound mail signs it, and reads it back to verify the signature and
+// evaluate SPF and DMARC. Without a seed mailbox or a capturing sink, the
+// probe goes to a verification reflector such as an auto-responder that
+// mails its verdict back to the sender.
+func (s *Server) runMailAuthProbe(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	var req struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+		// ClientIP is the relay's public address, for SPF on sink captures
+		ClientIP string `json:"clientIp"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	canaryCfg := s.canaryConfig()
+	req.From = strings.TrimSpace(req.From)
+	if req.From == "" {
+		req.From = canaryCfg.From
+	}
+	req.To = strings.TrimSpace(req.To)
+
+	v := NewValidator()
+	v.ValidateRequired("from", req.From)
+	v.ValidateEmail("from", req.From)
+	v.ValidateEmail("to", req.To)
+	v.ValidateIPAddress("clientIp", req.ClientIP)
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	probe := &mailAuthProbe{From: req.From, To: req.To, Status: "pending", clientIP: net.ParseIP(req.ClientIP)}
+	switch {
+	case s.sinkCapturing():
+		probe.Via = probeViaSink
+		if probe.To == "" {
+			probe.To = req.From
+		}
+	case (probe.To == "" || strings.EqualFold(probe.To, canaryCfg.To)) && canaryCfg.Validate() == nil:
+		probe.Via, probe.To = probeViaSeed, canaryCfg.To
+	case probe.To != "":
+		probe.Via, probe.Status = probeViaReflector, "sent"
+	default:
+		http.Error(w, "Set a reflector address in \"to\", configure the canary seed mailbox, or enable the SMTP sink", http.StatusBadRequest)
+		return
+	}
+
+	token := make([]byte, 8)
+	rand.Read(token)
+	probe.ID = hex.EncodeToString(token)
+	probe.SentAt = time.Now().UTC()
+	subject := mailAuthSubjectPrefix + " " + probe.ID
+
+	if relaySender == nil {
+		http.Error(w, "Mail services are not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	_, err := relaySender.Send(probe.From, "", &mail.ComposeMessage{
+		To:      []string{probe.To},
+		Subject: subject,
+		Body: "This message tests DKIM signing, SPF and DMARC for " + probe.From + ".\r\n" +
+			"It was sent from the PostfixRelay admin panel and can be deleted.\r\n",
+	})
+	if err != nil {
+		s.auditLog(user.ID, user.Username, "mail_auth_probe", "mail_auth", probe.ID,
+			"Signing test from "+probe.From+" to "+probe.To, "failure", err.Error(), r)
+		http.Error(w, "Failed to send probe: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	s.auditLog(user.ID, user.Username, "mail_auth_probe", "mail_auth", probe.ID,
+		"Signing test from "+probe.From+" to "+probe.To, "success", "", r)
+
+	storeMailAuthProbe(probe)
+	if probe.Via != probeViaReflector {
+		id, via, clientIP := probe.ID, probe.Via, probe.clientIP
+		s.workers.Go("mail_auth_probe", supervisor.Once, func() error {
+			return s.awaitMailAuthProbe(id, via, subject, clientIP, canaryCfg)
+		})
+	}
+
+	mailAuthProbesMu.Lock()
+	resp := *probe
+	mailAuthProbesMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// awaitMailAuthProbe polls for the probe until it arrives, the timeout
+// passes or the server shuts down
+func (s *Server) awaitMailAuthProbe(id, via, subject string, clientIP net.IP, canaryCfg canary.Config) error {
+	deadline := time.NewTimer(mailAuthProbeTimeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(mailAuthPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.drainCh:
+			finishMailAuthProbe(id, "failed", nil, "the server shut down before the probe arrived")
+			return nil
+		case <-deadline.C:
+			finishMailAuthProbe(id, "timeout", nil,
+				fmt.Sprintf("the probe didn't arrive within %s; check the queue for it", mailAuthProbeTimeout))
+			return nil
+		case <-ticker.C:
+		}
+
+		var raw []byte
+		var err error
+		if via == probeViaSink {
+			raw, err = s.sinkMessageBySubject(subject)
+		} else {
+			raw, err = canary.FetchMessage(canaryCfg, subject)
+		}
+		if err != nil {
+			log.Debug().Err(err).Str("probe", id).Msg("Signing test probe not readable yet")
+			continue
+		}
+		if raw == nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		report, err := mailauth.Evaluate(ctx, mailauth.DefaultResolver, raw, clientIP, "")
+		cancel()
+		if err != nil {
+			finishMailAuthProbe(id, "failed", nil, "failed to evaluate the probe: "+err.Error())
+			return err
+		}
+		finishMailAuthProbe(id, "verified", report, "")
+		return nil
+	}
+}
+
+// sinkMessageBySubject returns the raw content of the newest captured
+// message with the given subject
+func (s *Server) sinkMessageBySubject(subject string) ([]byte, error) {
+	messages, _, err := smtpsink.List(s.db.DB, subject, 1, 0)
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+	_, raw, err := smtpsink.Get(s.db.DB, messages[0].ID)
+	return raw, err
+}
+
+// sinkCapturing reports whether the SMTP sink is running with Postfix's
+// content_filter pointed at it
+func (s *Server) sinkCapturing() bool {
+	sinkMu.Lock()
+	running := smtpSink != nil
+	sinkMu.Unlock()
+	if !running {
+		return false
+	}
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+	current, err := postfixMgr.GetParameter("content_filter")
+	return err == nil && current == s.sinkContentFilter()
+}
+
+// getMailAuthProbe returns a signing test and, once it has arrived, its
+// DKIM, SPF and DMARC results
+func (s *Server) getMailAuthProbe(w http.ResponseWriter, r *http.Request) {
+	mailAuthProbesMu.Lock()
+	p, ok := mailAuthProbes[chi.URLParam(r, "id")]
+	var probe mailAuthProbe
+	if ok {
+		probe = *p
+	}
+	mailAuthProbesMu.Unlock()
+	if !ok {
+		http.Error(w, "Probe not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(probe)
+}
+
+// verifyMailAuth evaluates an uploaded message (.eml), for example one
+// received from the relay or a reflector's copy. ?ip= is the address the
+// message came from and ?mailFrom= its envelope sender; without them they
+// are read from the Received and Return-Path headers.
+func (s *Server) verifyMailAuth(w http.ResponseWriter, r *http.Request) {
+	v := NewValidator()
+	v.ValidateIPAddress("ip", r.URL.Query().Get("ip"))
+	v.ValidateEmail("mailFrom", r.URL.Query().Get("mailFrom"))
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(r.Body, mailAuthMaxMessage+1))
+	if err != nil {
+		http.Error(w, "Failed to read message", http.StatusBadRequest)
+		return
+	}
+	if len(raw) == 0 || len(raw) > mailAuthMaxMessage {
+		http.Error(w, "Send the message (up to 10 MB) as the request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	report, err := mailauth.Evaluate(ctx, mailauth.DefaultResolver, raw,
+		net.ParseIP(r.URL.Query().Get("ip")), r.URL.Query().Get("mailFrom"))
+	if err != nil {
+		http.Error(w, "Failed to parse message: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/postfixrelay/postfixrelay/internal/dovecot"
+	"github.com/postfixrelay/postfixrelay/internal/supervisor"
+	"github.com/rs/zerolog/log"
+)
+
+// maxImportUsers caps how many users one import request can carry
+const maxImportUsers = 10000
+
+type importMailboxesRequest struct {
+	Format        string            `json:"format"`  // "passwd" or "rows"
+	Content       string            `json:"content"` // the passwd-file, for "passwd"
+	Rows          []dovecot.UserRow `json:"rows"`    // the SQL userdb rows, for "rows"
+	DefaultScheme string            `json:"defaultScheme"`
+	CreateDomains bool              `json:"createDomains"`
+}
+
+// importMailboxes creates mailboxes from an existing Dovecot userdb, keeping
+// the password hashes. Users that already have a mailbox are skipped, and
+// users of unknown domains are skipped unless createDomains is set.
+func (s *Server) importMailboxes(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	var req importMailboxesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	v := NewValidator()
+	if req.Format != "passwd" && req.Format != "rows" {
+		v.AddErrorf("format", "must be one of: %s", "passwd, rows")
+	}
+	if req.Format == "passwd" {
+		v.ValidateRequired("content", req.Content)
+	}
+	if req.Format == "rows" && len(req.Rows) == 0 {
+		v.AddError("rows", "at least one row is required")
+	}
+	if len(req.Rows) > maxImportUsers {
+		v.AddErrorf("rows", "must have at most %d rows", maxImportUsers)
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	var users []dovecot.ImportedUser
+	var problems []dovecot.ImportProblem
+	if req.Format == "passwd" {
+		var err error
+		users, problems, err = dovecot.ParsePasswdFile(strings.NewReader(req.Content), req.DefaultScheme)
+		if err != nil {
+			http.Error(w, "Failed to read passwd file: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(users) > maxImportUsers {
+			http.Error(w, fmt.Sprintf("passwd file has more than %d users", maxImportUsers), http.StatusBadRequest)
+			return
+		}
+	} else {
+		users, problems = dovecot.ParseUserRows(req.Rows, req.DefaultScheme)
+	}
+
+	// Work out which users need a domain and which already have a mailbox
+	domainIDs := map[string]int64{}
+	newDomains := []string{}
+	toCreate := []dovecot.ImportedUser{}
+	seen := map[string]bool{}
+	for _, u := range users {
+		if seen[u.Email] {
+			problems = append(problems, dovecot.ImportProblem{Line: u.Line, Email: u.Email, Reason: "duplicate user"})
+			continue
+		}
+		seen[u.Email] = true
+
+		var exists int
+		s.db.QueryRow("SELECT COUNT(*) FROM mailboxes WHERE email = ?", u.Email).Scan(&exists)
+		if exists > 0 {
+			problems = append(problems, dovecot.ImportProblem{Line: u.Line, Email: u.Email, Reason: "mailbox already exists"})
+			continue
+		}
+
+		domain := u.Email[strings.Index(u.Email, "@")+1:]
+		if _, ok := domainIDs[domain]; !ok {
+			var id int64
+			if s.db.QueryRow("SELECT id FROM mail_domains WHERE domain = ?", domain).Scan(&id) == nil {
+				domainIDs[domain] = id
+			} else if req.CreateDomains {
+				domainIDs[domain] = 0
+				newDomains = append(newDomains, domain)
+			} else {
+				problems = append(problems, dovecot.ImportProblem{Line: u.Line, Email: u.Email, Reason: "domain " + domain + " not found"})
+				continue
+			}
+		}
+		toCreate = append(toCreate, u)
+	}
+
+	if isDryRun(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"dryRun":  true,
+			"summary": fmt.Sprintf("Would import %d mailboxes and create %d domains", len(toCreate), len(newDomains)),
+			"users":   toCreate,
+			"domains": newDomains,
+			"skipped": problems,
+		})
+		return
+	}
+
+	// New mailboxes get their domain's defaults. Imported passwords are
+	// kept as they are; the password policy applies when they change.
+	defaults := map[string]DomainDefaults{}
+	for domain, id := range domainIDs {
+		d, err := s.loadDomainDefaults(id)
+		if err != nil {
+			http.Error(w, "Failed to load defaults of "+domain, http.StatusInternalServerError)
+			return
+		}
+		defaults[domain] = d
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to import mailboxes", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, domain := range newDomains {
+		result, err := tx.Exec("INSERT INTO mail_domains (domain, description, created_by) VALUES (?, ?, ?)",
+			domain, "Imported from Dovecot", user.ID)
+		if err != nil {
+			log.Error().Err(err).Str("domain", domain).Msg("Failed to create imported domain")
+			http.Error(w, "Failed to create domain "+domain, http.StatusInternalServerError)
+			return
+		}
+		domainIDs[domain], _ = result.LastInsertId()
+	}
+	for _, u := range toCreate {
+		localPart, domain, _ := strings.Cut(u.Email, "@")
+		domainID := domainIDs[domain]
+		d := defaults[domain]
+		quota := u.QuotaBytes
+		if quota <= 0 {
+			quota = d.QuotaBytes
+		}
+		result, err := tx.Exec(`
+			INSERT INTO mailboxes (email, local_part, domain_id, password_hash, quota_bytes, webmail_enabled, app_passwords_enabled)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, u.Email, localPart, domainID, u.PasswordHash, quota, d.WebmailEnabled, d.AppPasswordsEnabled)
+		if err != nil {
+			log.Error().Err(err).Str("email", u.Email).Msg("Failed to create imported mailbox")
+			http.Error(w, "Failed to create mailbox "+u.Email, http.StatusInternalServerError)
+			return
+		}
+		id, _ := result.LastInsertId()
+		tx.Exec("INSERT INTO mailbox_quota (mailbox_id) VALUES (?)", id)
+		if err := applySignatureTemplate(tx, d, u.Email, ""); err != nil {
+			log.Warn().Err(err).Str("email", u.Email).Msg("Failed to create signature from domain template")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to import mailboxes", http.StatusInternalServerError)
+		return
+	}
+
+	summary := fmt.Sprintf("Imported %d mailboxes and created %d domains from a Dovecot %s userdb (%d skipped)",
+		len(toCreate), len(newDomains), req.Format, len(problems))
+	s.auditLog(user.ID, user.Username, "import", "mailbox", "", summary, "success", "", r)
+
+	s.workers.Go("mail_sync", supervisor.Retry, s.dovecotSyncer.SyncAll)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"imported":       len(toCreate),
+		"domainsCreated": newDomains,
+		"skipped":        problems,
+	})
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/rs/zerolog/log"
+)
+
+// loadMapTypes passes the map_type_ settings to the map writers
+func (s *Server) loadMapTypes() {
+	types := make(map[string]string, len(postfix.ManagedMaps))
+	for _, m := range postfix.ManagedMaps {
+		types[m.Name] = s.db.GetSetting("map_type_"+m.Name, "")
+	}
+	postfix.SetMapTypes(types)
+}
+
+// mapPath returns the file of a managed map
+func (s *Server) mapPath(name string) string {
+	if path := s.dovecotSyncer.MapPath(name); path != "" {
+		return path
+	}
+	return postfixMgr.MapPath(name)
+}
+
+// applyMapTypes rewrites every managed map as its configured type, points
+// main.cf at the new tables and reloads Postfix
+func (s *Server) applyMapTypes() error {
+	s.loadMapTypes()
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+
+	// Maps the config manager writes are only rewritten if they are in use
+	transports, err := postfixMgr.GetTransportMaps()
+	if err != nil {
+		return err
+	}
+	if len(transports) > 0 {
+		if err := postfixMgr.SaveTransportMaps(transports); err != nil {
+			return err
+		}
+	}
+	relays, err := postfixMgr.GetSenderDependentRelays()
+	if err != nil {
+		return err
+	}
+	if len(relays) > 0 {
+		if err := postfixMgr.SaveSenderDependentRelays(relays); err != nil {
+			return err
+		}
+	}
+	if err := postfixMgr.RecompileSASLCredentials(); err != nil {
+		return err
+	}
+	if err := s.syncBackscatterDomains(); err != nil {
+		return err
+	}
+	if err := s.syncSenderClasses(); err != nil {
+		return err
+	}
+	if err := s.dovecotSyncer.SyncPostfixMaps(); err != nil {
+		return err
+	}
+
+	for _, m := range postfix.ManagedMaps {
+		path := s.mapPath(m.Name)
+		if err := postfixMgr.ReplaceMapRef(m.Parameters, path, postfix.MapRef(postfix.MapType(m.Name), path)); err != nil {
+			return err
+		}
+	}
+
+	return postfixMgr.Reload()
+}
+
+// onMapTypeSettingsChanged rewrites the maps when a map_type_ setting
+// changes
+func (s *Server) onMapTypeSettingsChanged(changed map[string]string) {
+	for key := range changed {
+		if strings.HasPrefix(key, "map_type_") {
+			if err := s.applyMapTypes(); err != nil {
+				log.Error().Err(err).Msg("Failed to rewrite Postfix lookup tables")
+			}
+			return
+		}
+	}
+}
+
+// validMapTypes returns the types a map can be set to: those it allows,
+// less any this Postfix doesn't support
+func validMapTypes(m postfix.ManagedMap) []string {
+	allowed := m.AllowedMapTypes()
+	supported, err := postfix.SupportedMapTypes()
+	if err != nil {
+		return allowed
+	}
+
+	var valid []string
+	for _, t := range allowed {
+		for _, st := range supported {
+			if st == t {
+				valid = append(valid, t)
+				break
+			}
+		}
+	}
+	return valid
+}
+
+// getMapTypes reports the lookup table types Postfix supports and the
+// type each managed map is configured as and written as
+func (s *Server) getMapTypes(w http.ResponseWriter, r *http.Request) {
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+
+	type mapInfo struct {
+		postfix.ManagedMap
+		Path       string   `json:"path"`
+		Configured string   `json:"configured"` // "" when detected
+		Effective  string   `json:"effective"`
+		Valid      []string `json:"valid"`
+	}
+
+	maps := make([]mapInfo, 0, len(postfix.ManagedMaps))
+	for _, m := range postfix.ManagedMaps {
+		maps = append(maps, mapInfo{
+			ManagedMap: m,
+			Path:       s.mapPath(m.Name),
+			Configured: s.db.GetSetting("map_type_"+m.Name, ""),
+			Effective:  postfix.MapType(m.Name),
+			Valid:      validMapTypes(m),
+		})
+	}
+
+	resp := map[string]interface{}{
+		"default": postfix.DefaultMapType(),
+		"maps":    maps,
+	}
+	if supported, err := postfix.SupportedMapTypes(); err != nil {
+		resp["error"] = err.Error()
+	} else {
+		resp["supported"] = supported
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
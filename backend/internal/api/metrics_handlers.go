@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/postfixrelay/postfixrelay/internal/alerts"
+	"github.com/postfixrelay/postfixrelay/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// metricsCounters count deliveries and failures for /metrics from the
+	// log pipeline
+	metricsCounters = metrics.NewLogCounters()
+
+	// apiLatency times API requests for /metrics
+	apiLatency = metrics.NewRequestDurations()
+)
+
+// getMetrics serves the Prometheus scrape: queue depth, Postfix status and
+// active alerts as of now, and the counters and API latencies gathered
+// since the service started
+func (s *Server) getMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	mw := metrics.NewWriter(w)
+
+	s.initQueueManager()
+	active, deferred, hold, corrupt := queueMgr.GetQueueSummary()
+	mw.Family("postfixrelay_queue_messages", "Messages in the Postfix queue, by state.", metrics.Gauge)
+	for _, q := range []struct {
+		state string
+		count int
+	}{{"active", active}, {"deferred", deferred}, {"hold", hold}, {"corrupt", corrupt}} {
+		mw.Sample("postfixrelay_queue_messages", float64(q.count), metrics.Label{Name: "state", Value: q.state})
+	}
+
+	up := 0.0
+	if s.getPostfixStatus().Running {
+		up = 1
+	}
+	mw.Family("postfixrelay_postfix_up", "Whether Postfix is running.", metrics.Gauge)
+	mw.Sample("postfixrelay_postfix_up", up)
+
+	bySeverity := map[alerts.AlertSeverity]int{alerts.SeverityWarning: 0, alerts.SeverityCritical: 0}
+	if alertEngine != nil {
+		if firing, err := alertEngine.GetActiveAlerts(); err == nil {
+			for _, a := range firing {
+				bySeverity[a.Severity]++
+			}
+		}
+	}
+	mw.Family("postfixrelay_alerts_active", "Alerts firing or acknowledged, by severity.", metrics.Gauge)
+	for _, severity := range []alerts.AlertSeverity{alerts.SeverityWarning, alerts.SeverityCritical} {
+		mw.Sample("postfixrelay_alerts_active", float64(bySeverity[severity]), metrics.Label{Name: "severity", Value: string(severity)})
+	}
+
+	metricsCounters.Write(mw)
+	apiLatency.Write(mw)
+	cleanupPurged.Write(mw)
+	cleanupRuns.Write(mw)
+
+	if err := mw.Flush(); err != nil {
+		log.Debug().Err(err).Msg("Failed to write metrics")
+	}
+}
@@ -3,10 +3,13 @@ package api
 import (
 	"context"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/i18n"
 )
 
 type contextKey string
@@ -28,6 +31,13 @@ const (
 	PermViewAudit    Permission = "view:audit"
 	PermViewUsers    Permission = "view:users"
 	PermViewSettings Permission = "view:settings"
+	PermViewMail     Permission = "view:mail"
+	// Mail addresses in the queue, mail log and message traces; others see
+	// them masked
+	PermViewAddresses Permission = "view:addresses"
+	// Subjects and bodies of queued messages; others see only their
+	// envelope and headers
+	PermViewQueueContent Permission = "view:queue_content"
 
 	// Edit/Write permissions
 	PermEditConfig        Permission = "edit:config"
@@ -39,6 +49,9 @@ const (
 	PermManageSettings    Permission = "manage:settings"
 	PermManageCerts       Permission = "manage:certs"
 	PermManageTransport   Permission = "manage:transport"
+	// Subjects and bodies of mail of confidential domains, which others
+	// see redacted
+	PermUnredactMessages Permission = "unredact:messages"
 )
 
 // rolePermissions defines what each role can do
@@ -46,16 +59,17 @@ var rolePermissions = map[string][]Permission{
 	"admin": {
 		// Admins can do everything
 		PermViewStatus, PermViewConfig, PermViewLogs, PermViewAlerts, PermViewQueue, PermViewAudit, PermViewUsers, PermViewSettings,
-		PermEditConfig, PermApplyConfig, PermManageQueue, PermAcknowledgeAlerts, PermEditAlertRules,
-		PermManageUsers, PermManageSettings, PermManageCerts, PermManageTransport,
+		PermViewMail, PermViewAddresses, PermViewQueueContent, PermEditConfig, PermApplyConfig, PermManageQueue, PermAcknowledgeAlerts, PermEditAlertRules,
+		PermManageUsers, PermManageSettings, PermManageCerts, PermManageTransport, PermUnredactMessages,
 	},
 	"operator": {
 		// Operators can view everything and manage queue/alerts, but cannot change config or users
 		PermViewStatus, PermViewConfig, PermViewLogs, PermViewAlerts, PermViewQueue, PermViewAudit,
-		PermManageQueue, PermAcknowledgeAlerts,
+		PermViewAddresses, PermViewQueueContent, PermManageQueue, PermAcknowledgeAlerts,
 	},
 	"auditor": {
-		// Auditors can only view (read-only access)
+		// Auditors can only view (read-only access): queued mail by its
+		// envelope, and the mail log, with addresses masked
 		PermViewStatus, PermViewConfig, PermViewLogs, PermViewAlerts, PermViewQueue, PermViewAudit,
 	},
 }
@@ -98,6 +112,8 @@ type User struct {
 	Username string
 	Email    string
 	Role     string
+	// TenantID confines the user to one tenant; 0 is a platform user
+	TenantID int64
 }
 
 // GetUser retrieves the authenticated user from context
@@ -139,11 +155,13 @@ func (s *Server) authMiddleware(next http.Handler) http.Handler {
 		var user User
 		var expiresAt time.Time
 		err := s.db.QueryRow(`
-			SELECT u.id, u.username, u.email, u.role, s.expires_at
+			SELECT u.id, u.username, u.email, u.role, COALESCE(u.tenant_id, 0), s.expires_at
 			FROM sessions s
 			JOIN users u ON s.user_id = u.id
+			LEFT JOIN tenants t ON u.tenant_id = t.id
 			WHERE s.token_hash = ? AND s.expires_at > datetime('now')
-		`, tokenHash).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &expiresAt)
+				AND (u.tenant_id IS NULL OR t.active = TRUE)
+		`, tokenHash).Scan(&user.ID, &user.Username, &user.Email, &user.Role, &user.TenantID, &expiresAt)
 
 		if err != nil {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
@@ -196,3 +214,43 @@ func (s *Server) operatorOnly(h http.HandlerFunc) http.HandlerFunc {
 		h(w, r)
 	}
 }
+
+// settingTokenAuth lets clients presenting the token stored in settingKey,
+// as a bearer token or basic auth password, through. The endpoint answers
+// 404 while the setting is unset.
+func (s *Server) settingTokenAuth(settingKey, realm string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := s.db.GetSetting(settingKey, "")
+			if token == "" {
+				http.Error(w, "Endpoint is disabled", http.StatusNotFound)
+				return
+			}
+
+			presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if _, password, ok := r.BasicAuth(); ok {
+				presented = password
+			}
+			if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// localeMiddleware negotiates the response language from the lang query
+// parameter or the Accept-Language header
+func (s *Server) localeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale := r.URL.Query().Get("lang")
+		if !i18n.IsSupported(locale) {
+			locale = i18n.Negotiate(r.Header.Get("Accept-Language"))
+		}
+		w.Header().Set("Content-Language", locale)
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(w, r.WithContext(i18n.WithLocale(r.Context(), locale)))
+	})
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/postfixrelay/postfixrelay/internal/config"
+	"github.com/rs/zerolog/log"
+)
+
+// realIPMiddleware sets r.RemoteAddr to the client's IP address, which
+// rate limiting, sessions and the audit log all read. Forwarding headers
+// are only believed from trusted_proxies: X-Forwarded-For is read right to
+// left, skipping trusted proxies, and X-Real-IP is the fallback. Anyone
+// else's forwarding headers are removed unread, so they can't pose as
+// another client. Peers on a unix socket listener have no address; they
+// show as "unix", and are trusted when trusted_proxies lists "unix".
+func (s *Server) realIPMiddleware(next http.Handler) http.Handler {
+	// Validated when the configuration was loaded
+	trusted, _ := config.ParseTrustedProxies(s.cfg.TrustedProxies)
+	trustUnix := false
+	for _, p := range s.cfg.TrustedProxies {
+		trustUnix = trustUnix || p == "unix"
+	}
+	isTrusted := func(ip net.IP) bool {
+		for _, n := range trusted {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(peer); err == nil {
+			peer = host
+		}
+		unixPeer := isUnixConn(r)
+		if unixPeer {
+			peer = "unix"
+		}
+		client := peer
+
+		forwarded := r.Header.Get("X-Forwarded-For") != "" || r.Header.Get("X-Real-IP") != ""
+		if ip := net.ParseIP(peer); (ip != nil && isTrusted(ip)) || (unixPeer && trustUnix) {
+			if c := forwardedClient(r, isTrusted); c != "" {
+				client = c
+			}
+		} else if forwarded {
+			log.Debug().Str("ip", peer).Msg("Ignoring forwarding headers from an untrusted peer")
+			r.Header.Del("X-Forwarded-For")
+			r.Header.Del("X-Real-IP")
+		}
+
+		r.RemoteAddr = client
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isUnixConn reports whether r came in on a unix domain socket
+func isUnixConn(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// forwardedClient returns the client a trusted proxy names: the last
+// untrusted address in X-Forwarded-For, or else X-Real-IP
+func forwardedClient(r *http.Request, isTrusted func(net.IP) bool) string {
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// A malformed hop means nothing before it can be believed
+			break
+		}
+		if !isTrusted(ip) {
+			return ip.String()
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
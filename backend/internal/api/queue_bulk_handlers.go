@@ -0,0 +1,382 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/postfixrelay/postfixrelay/internal/scan"
+	"github.com/postfixrelay/postfixrelay/internal/supervisor"
+)
+
+const (
+	// queueBulkBatchSize is how many queue IDs go to one postsuper run
+	queueBulkBatchSize = 100
+
+	// queueBulkRetention is how long a finished job's progress is kept
+	queueBulkRetention = time.Hour
+
+	// queueBulkDeleteMax is how many messages a bulk delete can remove
+	// before it needs an approval request like the queue purge
+	queueBulkDeleteMax = 500
+)
+
+// queueBulkFilter is the JSON form of a postfix.QueueFilter. Ages are Go
+// durations such as "30m" or "48h".
+type queueBulkFilter struct {
+	Status    string `json:"status,omitempty"`
+	Sender    string `json:"sender,omitempty"`
+	Recipient string `json:"recipient,omitempty"`
+	MinAge    string `json:"minAge,omitempty"`
+	MaxAge    string `json:"maxAge,omitempty"`
+	Relay     string `json:"relay,omitempty"`
+}
+
+func (f queueBulkFilter) String() string {
+	var parts []string
+	for _, p := range [][2]string{
+		{"status", f.Status}, {"sender", f.Sender}, {"recipient", f.Recipient},
+		{"minAge", f.MinAge}, {"maxAge", f.MaxAge}, {"relay", f.Relay},
+	} {
+		if p[1] != "" {
+			parts = append(parts, p[0]+"="+p[1])
+		}
+	}
+	if len(parts) == 0 {
+		return "any message"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// narrowed reports whether the filter picks messages by more than their
+// queue. A delete filtered only by status empties a whole queue.
+func (f queueBulkFilter) narrowed() bool {
+	return f.Sender != "" || f.Recipient != "" || f.Relay != "" || f.MinAge != "" || f.MaxAge != ""
+}
+
+// queueFilter validates the filter into v and converts it
+func (f queueBulkFilter) queueFilter(v *Validator) postfix.QueueFilter {
+	filter := postfix.QueueFilter{Status: f.Status, Sender: f.Sender, Recipient: f.Recipient, Relay: f.Relay}
+	switch f.Status {
+	case "", "active", "deferred", "hold":
+	default:
+		v.AddError("filter.status", "must be active, deferred or hold")
+	}
+	for field, pattern := range map[string]string{"filter.sender": f.Sender, "filter.recipient": f.Recipient} {
+		if err := postfix.ValidatePattern(pattern); err != nil {
+			v.AddError(field, err.Error())
+		}
+	}
+	filter.MinAge = parseQueueAge(v, "filter.minAge", f.MinAge)
+	filter.MaxAge = parseQueueAge(v, "filter.maxAge", f.MaxAge)
+	return filter
+}
+
+// queueBulkJob is the progress of one bulk queue action
+type queueBulkJob struct {
+	ID          string          `json:"id"`
+	Action      string          `json:"action"`
+	Filter      queueBulkFilter `json:"filter"`
+	Status      string          `json:"status"` // running, completed, failed or interrupted
+	Total       int             `json:"total"`
+	Processed   int             `json:"processed"`
+	Failed      int             `json:"failed"`
+	Skipped     int             `json:"skipped"` // releases blocked by the malware scan
+	QueueIDs    []string        `json:"queueIds"`
+	Errors      []string        `json:"errors,omitempty"`
+	StartedAt   time.Time       `json:"startedAt"`
+	CompletedAt *time.Time      `json:"completedAt,omitempty"`
+}
+
+var (
+	queueBulkJobsMu sync.Mutex
+	queueBulkJobs   = map[string]*queueBulkJob{}
+)
+
+func storeQueueBulkJob(job *queueBulkJob) {
+	queueBulkJobsMu.Lock()
+	defer queueBulkJobsMu.Unlock()
+	for id, old := range queueBulkJobs {
+		if old.CompletedAt != nil && time.Since(*old.CompletedAt) > queueBulkRetention {
+			delete(queueBulkJobs, id)
+		}
+	}
+	queueBulkJobs[job.ID] = job
+}
+
+// updateQueueBulkJob applies fn to a job under the lock
+func updateQueueBulkJob(id string, fn func(*queueBulkJob)) {
+	queueBulkJobsMu.Lock()
+	defer queueBulkJobsMu.Unlock()
+	if job, ok := queueBulkJobs[id]; ok {
+		fn(job)
+	}
+}
+
+// parseQueueAge reads an optional age bound of a queue filter
+func parseQueueAge(v *Validator, field, value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		v.AddError(field, "must be a duration such as 30m or 48h")
+		return 0
+	}
+	return d
+}
+
+// bulkQueueAction holds, releases, deletes or requeues every queued
+// message matching a filter. The matching messages are selected when the
+// request arrives and processed in the background in batches; the
+// response carries a job ID to follow progress with getBulkQueueJob.
+// With ?dryRun=true it lists the messages that would be affected.
+//
+// A delete filtered only by status, or matching more than
+// queueBulkDeleteMax messages, becomes an approval request instead.
+func (s *Server) bulkQueueAction(w http.ResponseWriter, r *http.Request) {
+	s.initQueueManager()
+	user := GetUser(r.Context())
+
+	var req struct {
+		Action string          `json:"action"`
+		Filter queueBulkFilter `json:"filter"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	v := NewValidator()
+	if !postfix.ValidBulkAction(req.Action) {
+		v.AddError("action", "must be hold, release, delete or requeue")
+	}
+	f := req.Filter
+	filter := f.queueFilter(v)
+	if req.Action == "delete" && f == (queueBulkFilter{}) {
+		// Emptying the whole queue goes through the purge approval
+		v.AddError("filter", "deleting every message needs a filter; use the queue purge instead")
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+	if req.Action == "delete" && user.Role != "admin" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	messages, err := queueMgr.SelectMessages(filter)
+	if err != nil {
+		http.Error(w, "failed to list queue: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if isDryRun(r) {
+		writeDryRun(w, DryRunResult{
+			Summary:  fmt.Sprintf("Would %s %d queued messages matching %s", req.Action, len(messages), f),
+			Messages: messages,
+		})
+		return
+	}
+
+	// Deleting a whole queue, or a lot of mail, is typed back and possibly
+	// approved by a second admin first; the messages are picked again when
+	// it runs
+	if req.Action == "delete" && (!f.narrowed() || len(messages) > queueBulkDeleteMax) {
+		target, _ := json.Marshal(f)
+		s.requestApproval(w, r, approvalQueueBulkDelete, string(target), "DELETE",
+			fmt.Sprintf("Delete queued messages matching %s (%d queued)", f, len(messages)))
+		return
+	}
+
+	token := make([]byte, 8)
+	rand.Read(token)
+	job := &queueBulkJob{
+		ID:        hex.EncodeToString(token),
+		Action:    req.Action,
+		Filter:    f,
+		Status:    "running",
+		Total:     len(messages),
+		QueueIDs:  []string{},
+		StartedAt: time.Now().UTC(),
+	}
+	ids := make([]string, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.QueueID
+	}
+	storeQueueBulkJob(job)
+
+	userID, username, remoteAddr := user.ID, user.Username, r.RemoteAddr
+	s.workers.Go("queue_bulk", supervisor.Once, func() error {
+		s.runQueueBulkJob(job.ID, req.Action, ids)
+		s.auditQueueBulkJob(job.ID, userID, username, remoteAddr)
+		return nil
+	})
+
+	queueBulkJobsMu.Lock()
+	resp := *job
+	queueBulkJobsMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// runQueueBulkJob works through the selected messages a batch at a time,
+// stopping early if the server shuts down
+func (s *Server) runQueueBulkJob(jobID, action string, ids []string) {
+	scanRelease := action == "release" && s.db.GetSetting("scan_queue_release", "false") == "true"
+
+	for start := 0; start < len(ids); start += queueBulkBatchSize {
+		select {
+		case <-s.drainCh:
+			updateQueueBulkJob(jobID, func(job *queueBulkJob) {
+				job.Status = "interrupted"
+				job.Errors = append(job.Errors, "the server shut down before the job finished")
+			})
+			return
+		default:
+		}
+
+		end := start + queueBulkBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := ids[start:end]
+
+		// Held mail is scanned before release, as it is one at a time
+		var skipped int
+		if scanRelease {
+			cleared := make([]string, 0, len(batch))
+			for _, id := range batch {
+				if s.releaseBlockedByScan(id) {
+					skipped++
+					continue
+				}
+				cleared = append(cleared, id)
+			}
+			batch = cleared
+		}
+
+		err := queueMgr.BulkAction(action, batch)
+		updateQueueBulkJob(jobID, func(job *queueBulkJob) {
+			job.Processed += end - start
+			job.Skipped += skipped
+			if err != nil {
+				job.Failed += len(batch)
+				job.Errors = append(job.Errors, err.Error())
+				return
+			}
+			job.QueueIDs = append(job.QueueIDs, batch...)
+		})
+	}
+
+	updateQueueBulkJob(jobID, func(job *queueBulkJob) {
+		job.Status = "completed"
+		if job.Failed > 0 && job.Failed == job.Total {
+			job.Status = "failed"
+		}
+	})
+}
+
+// deleteApprovedBulk deletes the messages matching an approved bulk
+// delete's filter, in batches as a bulk job does
+func (s *Server) deleteApprovedBulk(target string) error {
+	var f queueBulkFilter
+	if err := json.Unmarshal([]byte(target), &f); err != nil {
+		return fmt.Errorf("invalid bulk delete filter: %w", err)
+	}
+	v := NewValidator()
+	filter := f.queueFilter(v)
+	if v.HasErrors() || f == (queueBulkFilter{}) {
+		return fmt.Errorf("invalid bulk delete filter: %s", f)
+	}
+
+	s.initQueueManager()
+	messages, err := queueMgr.SelectMessages(filter)
+	if err != nil {
+		return fmt.Errorf("failed to list queue: %w", err)
+	}
+	for start := 0; start < len(messages); start += queueBulkBatchSize {
+		end := start + queueBulkBatchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+		batch := make([]string, 0, end-start)
+		for _, msg := range messages[start:end] {
+			batch = append(batch, msg.QueueID)
+		}
+		if err := queueMgr.BulkAction("delete", batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseBlockedByScan scans a held message and reports whether the scan
+// keeps it on hold
+func (s *Server) releaseBlockedByScan(queueID string) bool {
+	content, err := queueMgr.GetMessageContent(queueID)
+	var result *scan.Result
+	if err != nil {
+		result = &scan.Result{Status: scan.StatusError, Engine: s.db.GetSetting("scan_engine", "none"), Error: err.Error(), ScannedAt: time.Now().UTC()}
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		result = s.scanContent(ctx, content)
+		cancel()
+	}
+	s.recordScanResult("queue_release", queueID, "", "", result)
+	return s.scanBlocks(result)
+}
+
+// auditQueueBulkJob records a finished job with every queue ID it acted on
+func (s *Server) auditQueueBulkJob(jobID string, userID int64, username, remoteAddr string) {
+	now := time.Now().UTC()
+	var job queueBulkJob
+	updateQueueBulkJob(jobID, func(j *queueBulkJob) {
+		j.CompletedAt = &now
+		job = *j
+	})
+
+	verbs := map[string]string{"hold": "Held", "release": "Released", "delete": "Deleted", "requeue": "Requeued"}
+	summary := fmt.Sprintf("%s %d of %d queued messages matching %s", verbs[job.Action], len(job.QueueIDs), job.Total, job.Filter)
+	if job.Skipped > 0 {
+		summary += fmt.Sprintf(" (%d kept on hold by the malware scan)", job.Skipped)
+	}
+	if len(job.QueueIDs) > 0 {
+		summary += ": " + strings.Join(job.QueueIDs, ", ")
+	}
+	status := "success"
+	if job.Status != "completed" {
+		status = "failure"
+	}
+	s.logAudit(userID, username, "queue_bulk_"+job.Action, "queue", job.ID, summary, status, remoteAddr)
+}
+
+// getBulkQueueJob returns the progress of a bulk queue action
+func (s *Server) getBulkQueueJob(w http.ResponseWriter, r *http.Request) {
+	queueBulkJobsMu.Lock()
+	j, ok := queueBulkJobs[chi.URLParam(r, "id")]
+	var job queueBulkJob
+	if ok {
+		job = *j
+		job.QueueIDs = append([]string{}, j.QueueIDs...)
+		job.Errors = append([]string(nil), j.Errors...)
+	}
+	queueBulkJobsMu.Unlock()
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+// Rate limiter implementation
+type ipRateLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	rate     rate.Limit
+	burst    int
+}
+
+func newIPRateLimiter(r rate.Limit, b int) *ipRateLimiter {
+	return &ipRateLimiter{
+		limiters: make(map[string]*rate.Limiter),
+		rate:     r,
+		burst:    b,
+	}
+}
+
+func (l *ipRateLimiter) getLimiter(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, exists := l.limiters[ip]
+	if !exists {
+		limiter = rate.NewLimiter(l.rate, l.burst)
+		l.limiters[ip] = limiter
+	}
+
+	return limiter
+}
+
+// setLimit changes the rate and burst for new and existing limiters
+func (l *ipRateLimiter) setLimit(r rate.Limit, b int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate = r
+	l.burst = b
+	for _, limiter := range l.limiters {
+		limiter.SetLimit(r)
+		limiter.SetBurst(b)
+	}
+}
+
+// cleanup drops the limiters whose bucket has refilled, so memory doesn't
+// grow with every client ever seen. A key still being throttled keeps its
+// limiter, where clearing the map would hand it a fresh burst. Returns how
+// many were dropped.
+func (l *ipRateLimiter) cleanup() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	dropped := 0
+	for key, limiter := range l.limiters {
+		if limiter.Tokens() >= float64(limiter.Burst()) {
+			delete(l.limiters, key)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// rateLimitBucket is a point-in-time view of one key's limiter
+type rateLimitBucket struct {
+	Key       string  `json:"key"`
+	Remaining float64 `json:"remaining"`
+}
+
+// buckets returns the current token count for every tracked key
+func (l *ipRateLimiter) buckets() []rateLimitBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	buckets := make([]rateLimitBucket, 0, len(l.limiters))
+	for key, limiter := range l.limiters {
+		buckets = append(buckets, rateLimitBucket{
+			Key:       key,
+			Remaining: math.Floor(limiter.Tokens()),
+		})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Remaining < buckets[j].Remaining })
+	return buckets
+}
+
+// rateLimitGroup is a named, independently configurable rate limit.
+// Rate and burst are read from the rate_limit_<name>_rps and
+// rate_limit_<name>_burst settings.
+type rateLimitGroup struct {
+	name         string
+	description  string
+	defaultRate  float64
+	defaultBurst int
+	limiter      *ipRateLimiter
+}
+
+// Global rate limiter: 10 req/s, burst 30 per client IP
+var globalLimiter = newIPRateLimiter(10, 30)
+
+// Login rate limiter: 1 req/s, burst 5 (stricter for auth endpoints)
+var loginLimiter = newIPRateLimiter(1, 5)
+
+// Per-user limiter for authenticated API calls: 20 req/s, burst 60
+var userLimiter = newIPRateLimiter(20, 60)
+
+// Webmail send limiter: one message every 2s, burst 10
+var mailSendLimiter = newIPRateLimiter(0.5, 10)
+
+// Export limiter: one export every 10s, burst 3
+var exportLimiter = newIPRateLimiter(0.1, 3)
+
+// REST send API limiter: one message a second, burst 20 per API token
+var apiSendLimiter = newIPRateLimiter(1, 20)
+
+var rateLimitGroups = []*rateLimitGroup{
+	{"global", "All requests, per client IP", 10, 30, globalLimiter},
+	{"auth", "Login endpoints, per client IP", 1, 5, loginLimiter},
+	{"user", "Authenticated API requests, per user", 20, 60, userLimiter},
+	{"mail_send", "Webmail send, per mailbox", 0.5, 10, mailSendLimiter},
+	{"export", "Log and data exports, per user", 0.1, 3, exportLimiter},
+	{"api_send", "REST send API, per API token", 1, 20, apiSendLimiter},
+}
+
+// clientIP returns the request's remote IP without the port
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// rateLimitKey identifies the caller: the authenticated admin user,
+// webmail mailbox or API token if there is one, otherwise the client IP
+func rateLimitKey(r *http.Request) string {
+	if u := GetUser(r.Context()); u != nil {
+		return fmt.Sprintf("user:%d", u.ID)
+	}
+	if session := getMailSession(r.Context()); session != nil {
+		return "mail:" + session.Email
+	}
+	if token := getAPIToken(r.Context()); token != nil {
+		return fmt.Sprintf("token:%d", token.ID)
+	}
+	return "ip:" + clientIP(r)
+}
+
+// limitBy returns middleware enforcing a rate limit keyed by keyFn. It sets
+// X-RateLimit-Limit and X-RateLimit-Remaining on every response; when limits
+// are nested, the innermost (most specific) one wins.
+func limitBy(l *ipRateLimiter, keyFn func(*http.Request) string, message string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := keyFn(r)
+			limiter := l.getLimiter(key)
+
+			allowed := limiter.Allow()
+			remaining := int(math.Max(0, math.Floor(limiter.Tokens())))
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limiter.Burst()))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+
+			if !allowed {
+				if limit := limiter.Limit(); limit > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(1/float64(limit)))))
+				}
+				log.Warn().
+					Str("key", key).
+					Str("path", r.URL.Path).
+					Msg("Rate limit exceeded")
+				http.Error(w, message, http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitMiddleware applies global rate limiting
+func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
+	return limitBy(globalLimiter, clientIP, "rate limit exceeded")(next)
+}
+
+// loginRateLimitMiddleware applies stricter rate limiting for auth endpoints
+func (s *Server) loginRateLimitMiddleware(next http.Handler) http.Handler {
+	return limitBy(loginLimiter, clientIP, "too many login attempts, please try again later")(next)
+}
+
+// userRateLimitMiddleware limits authenticated API calls per user rather
+// than per IP, so several users behind one NAT don't share a bucket
+func (s *Server) userRateLimitMiddleware(next http.Handler) http.Handler {
+	return limitBy(userLimiter, rateLimitKey, "rate limit exceeded")(next)
+}
+
+// mailSendRateLimit limits webmail sends per mailbox
+func (s *Server) mailSendRateLimit(h http.HandlerFunc) http.HandlerFunc {
+	return limitBy(mailSendLimiter, rateLimitKey, "sending too fast, please wait before sending again")(h).ServeHTTP
+}
+
+// apiSendRateLimit limits REST API sends per token
+func (s *Server) apiSendRateLimit(h http.HandlerFunc) http.HandlerFunc {
+	return limitBy(apiSendLimiter, rateLimitKey, "sending too fast, please wait before sending again")(h).ServeHTTP
+}
+
+// exportRateLimit limits expensive export endpoints per user
+func (s *Server) exportRateLimit(h http.HandlerFunc) http.HandlerFunc {
+	return limitBy(exportLimiter, rateLimitKey, "too many exports, please try again later")(h).ServeHTTP
+}
+
+// applyRateLimitSettings loads every rate limit group from settings
+func (s *Server) applyRateLimitSettings() {
+	for _, g := range rateLimitGroups {
+		rps := g.defaultRate
+		if v, err := strconv.ParseFloat(s.db.GetSetting("rate_limit_"+g.name+"_rps", ""), 64); err == nil {
+			rps = v
+		}
+		burst := s.db.GetSettingInt("rate_limit_"+g.name+"_burst", g.defaultBurst)
+
+		if rps <= 0 || burst < 1 {
+			log.Warn().Str("group", g.name).Float64("rps", rps).Int("burst", burst).Msg("Ignoring invalid rate limit settings")
+			continue
+		}
+		g.limiter.setLimit(rate.Limit(rps), burst)
+	}
+}
+
+// getRateLimits returns each rate limit group's configuration and the
+// current state of its buckets
+func (s *Server) getRateLimits(w http.ResponseWriter, r *http.Request) {
+	groups := make([]map[string]interface{}, 0, len(rateLimitGroups))
+	for _, g := range rateLimitGroups {
+		g.limiter.mu.Lock()
+		rps, burst := float64(g.limiter.rate), g.limiter.burst
+		g.limiter.mu.Unlock()
+
+		groups = append(groups, map[string]interface{}{
+			"name":        g.name,
+			"description": g.description,
+			"rps":         rps,
+			"burst":       burst,
+			"buckets":     g.limiter.buckets(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"groups": groups,
+	})
+}
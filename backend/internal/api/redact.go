@@ -0,0 +1,240 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+)
+
+// Response fields a role should not see are tagged with the permission
+// needed to see them:
+//
+//	KeyFile string `json:"keyFile" redact:"edit:config"`
+//	Sender  string `json:"sender" redact:"view:addresses,mask"`
+//	Detail  string `json:"detail" redact:"view:addresses,inline"`
+//
+// For callers without the permission, redactFor clears the field, or with
+// ",mask" masks it: addresses keep their first letter and domain, other
+// strings become secretSettingMask. ",inline" masks only the addresses
+// within the text, for log lines and server replies that quote them.
+// Masking applies to strings and string slices; other types are cleared.
+
+// redactTag is the struct tag naming the permission a field needs
+const redactTag = "redact"
+
+// redactFor returns v with the fields the caller may not see redacted. v
+// itself is left alone; the parts holding redacted fields are copied.
+func redactFor(r *http.Request, v interface{}) interface{} {
+	return redactForRole(requestRole(r), v)
+}
+
+// redactForRole is redactFor for a role rather than a request, for
+// results sent on a user's behalf outside of one
+func redactForRole(role string, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return redactValue(reflect.ValueOf(v), role).Interface()
+}
+
+// requestRole returns the role of the request's user, or "" without one
+func requestRole(r *http.Request) string {
+	if u := GetUser(r.Context()); u != nil {
+		return u.Role
+	}
+	return ""
+}
+
+func redactValue(v reflect.Value, role string) reflect.Value {
+	t := v.Type()
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(t).Elem()
+		out.Set(redactValue(v.Elem(), role))
+		return out
+	case reflect.Ptr:
+		if v.IsNil() || !mayRedact(t) {
+			return v
+		}
+		out := reflect.New(t.Elem())
+		out.Elem().Set(redactValue(v.Elem(), role))
+		return out
+	case reflect.Slice:
+		if v.IsNil() || !mayRedact(t) {
+			return v
+		}
+		out := reflect.MakeSlice(t, v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(redactValue(v.Index(i), role))
+		}
+		return out
+	case reflect.Array:
+		if !mayRedact(t) {
+			return v
+		}
+		out := reflect.New(t).Elem()
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(redactValue(v.Index(i), role))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() || !mayRedact(t) {
+			return v
+		}
+		out := reflect.MakeMapWithSize(t, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out.SetMapIndex(iter.Key(), redactValue(iter.Value(), role))
+		}
+		return out
+	case reflect.Struct:
+		if !mayRedact(t) {
+			return v
+		}
+		out := reflect.New(t).Elem()
+		out.Set(v)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			perm, opt, tagged := parseRedactTag(f)
+			switch {
+			case !tagged || HasPermission(role, perm):
+				out.Field(i).Set(redactValue(v.Field(i), role))
+			case opt == "mask":
+				out.Field(i).Set(maskValue(v.Field(i), maskString))
+			case opt == "inline":
+				out.Field(i).Set(maskValue(v.Field(i), maskAddresses))
+			default:
+				out.Field(i).Set(reflect.Zero(f.Type))
+			}
+		}
+		return out
+	}
+	return v
+}
+
+// parseRedactTag reads a field's redact tag: the permission, and the
+// option after the comma (mask or inline) if any
+func parseRedactTag(f reflect.StructField) (perm Permission, opt string, tagged bool) {
+	tag, ok := f.Tag.Lookup(redactTag)
+	if !ok || tag == "" {
+		return "", "", false
+	}
+	name, opt, _ := strings.Cut(tag, ",")
+	return Permission(name), opt, true
+}
+
+// maskValue masks a string or string slice with mask, and clears anything
+// else
+func maskValue(v reflect.Value, mask func(string) string) reflect.Value {
+	switch {
+	case v.Kind() == reflect.String:
+		out := reflect.New(v.Type()).Elem()
+		out.SetString(mask(v.String()))
+		return out
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String && !v.IsNil():
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).SetString(mask(v.Index(i).String()))
+		}
+		return out
+	}
+	return reflect.Zero(v.Type())
+}
+
+// maskString hides a value, keeping the first letter and the domain of an
+// address so masked addresses can still be told apart
+func maskString(s string) string {
+	if s == "" {
+		return ""
+	}
+	if i := strings.LastIndexByte(s, '@'); i > 0 {
+		return s[:1] + "***" + s[i:]
+	}
+	return secretSettingMask
+}
+
+// textAddress matches a mail address within free text, such as
+// "to=<jane@example.com>" in a log line or a remote server's reply
+var textAddress = regexp.MustCompile(`[A-Za-z0-9._%+=/-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*`)
+
+// maskAddresses masks every address within s, leaving the rest as is
+func maskAddresses(s string) string {
+	return textAddress.ReplaceAllStringFunc(s, maskString)
+}
+
+// mayViewAddresses reports whether the caller sees mail addresses unmasked
+func mayViewAddresses(r *http.Request) bool {
+	return HasPermission(requestRole(r), PermViewAddresses)
+}
+
+// maskLogEntry masks the addresses of a mail log line. logs.Entry is also
+// written to the log store and can't carry redact tags, so its handlers
+// mask it with this instead.
+func maskLogEntry(e logs.Entry) logs.Entry {
+	e.MailFrom = maskAddresses(e.MailFrom)
+	e.MailTo = maskAddresses(e.MailTo)
+	e.Message = maskAddresses(e.Message)
+	return e
+}
+
+// maskLogEntries returns entries with their addresses masked for callers
+// without view:addresses. entries itself is left alone.
+func maskLogEntries(r *http.Request, entries []logs.Entry) []logs.Entry {
+	return maskLogEntriesFor(requestRole(r), entries)
+}
+
+// maskLogEntriesFor is maskLogEntries for a role
+func maskLogEntriesFor(role string, entries []logs.Entry) []logs.Entry {
+	if HasPermission(role, PermViewAddresses) {
+		return entries
+	}
+	masked := make([]logs.Entry, len(entries))
+	for i, e := range entries {
+		masked[i] = maskLogEntry(e)
+	}
+	return masked
+}
+
+// redactTypes caches whether a type holds redacted fields, by reflect.Type
+var redactTypes sync.Map
+
+// mayRedact reports whether values of type t can hold a tagged field.
+// Interfaces can hold anything, so they are always walked.
+func mayRedact(t reflect.Type) bool {
+	if cached, ok := redactTypes.Load(t); ok {
+		return cached.(bool)
+	}
+	// Assume not while looking, which ends recursive types
+	redactTypes.Store(t, false)
+
+	found := false
+	switch t.Kind() {
+	case reflect.Interface:
+		found = true
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		found = mayRedact(t.Elem())
+	case reflect.Map:
+		found = mayRedact(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField() && !found; i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			_, _, tagged := parseRedactTag(f)
+			found = tagged || mayRedact(f.Type)
+		}
+	}
+	redactTypes.Store(t, found)
+	return found
+}
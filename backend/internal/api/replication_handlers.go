@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/replication"
+)
+
+// replicationMonitor follows Dovecot replication, on installs that use it
+var replicationMonitor *replication.Monitor
+
+// startReplicationMonitor starts polling Dovecot's replicator
+func (s *Server) startReplicationMonitor() {
+	replicationMonitor = replication.NewMonitor(s.db.DB)
+	replicationMonitor.Start()
+}
+
+// getReplication returns the replication state of every mailbox: when it
+// last synced, whether the last sync failed and the last dsync error logged
+// for it. available is false when Dovecot has no replicator.
+func (s *Server) getReplication(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	mailboxes, err := replication.List(s.db.DB, now)
+	if err != nil {
+		http.Error(w, "Failed to get replication status", http.StatusInternalServerError)
+		return
+	}
+	var available bool
+	var checkedAt time.Time
+	var lastErr string
+	if replicationMonitor != nil {
+		available, checkedAt, lastErr = replicationMonitor.State()
+	}
+
+	failed := 0
+	for _, m := range mailboxes {
+		if m.Failed {
+			failed++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"available": available,
+		"checkedAt": checkedAt,
+		"error":     lastErr,
+		"failed":    failed,
+		"mailboxes": mailboxes,
+	})
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/flowstats"
+)
+
+// getFlowReport returns the mail flow from sources through relays to
+// destination domains as nodes and edges for a Sankey or topology diagram.
+// Query parameters: window (default 24h), source (client or sender) and
+// limit (nodes kept per layer before the rest are folded into "(other)").
+func (s *Server) getFlowReport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	window, ok := statsWindow(w, r, 24*time.Hour)
+	if !ok {
+		return
+	}
+
+	source := q.Get("source")
+	if source == "" {
+		source = flowstats.SourceClient
+	} else if source != flowstats.SourceClient && source != flowstats.SourceSender {
+		http.Error(w, "Source must be client or sender", http.StatusBadRequest)
+		return
+	}
+
+	limit := 10
+	if l := q.Get("limit"); l != "" {
+		fmt.Sscanf(l, "%d", &limit)
+	}
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	now := time.Now()
+	graph, err := flowstats.Flow(s.db.DB, now.Add(-window), now, source, limit)
+	if err != nil {
+		http.Error(w, "Failed to load mail flow", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"window": window.String(),
+		"flow":   graph,
+	})
+}
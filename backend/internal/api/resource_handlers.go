@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/hostcheck"
+	"github.com/postfixrelay/postfixrelay/internal/hoststats"
+)
+
+// hostSampler records the host's resource usage every minute
+var hostSampler *hoststats.Sampler
+
+// postfixQueueDir is the Postfix queue directory, POSTFIX_QUEUE_DIR or
+// /var/spool/postfix
+func postfixQueueDir() string {
+	if dir := os.Getenv("POSTFIX_QUEUE_DIR"); dir != "" {
+		return dir
+	}
+	return "/var/spool/postfix"
+}
+
+// hostVolumes are the directories whose disk and inode usage is watched:
+// the Postfix queue, the mail store and the panel's own data
+func (s *Server) hostVolumes() []hoststats.Volume {
+	return []hoststats.Volume{
+		{Name: "queue", Path: postfixQueueDir()},
+		{Name: "mail", Path: s.dovecotSyncer.Config().MailDir},
+		{Name: "data", Path: filepath.Dir(s.cfg.DBPath)},
+	}
+}
+
+// startHostSampler starts recording host resource usage
+func (s *Server) startHostSampler() {
+	hostSampler = hoststats.NewSampler(s.db.DB, s.hostVolumes())
+	hostSampler.Start()
+}
+
+// hostChecker checks the clock and DNS resolvers every few minutes
+var hostChecker *hostcheck.Checker
+
+// startHostChecker starts the clock and DNS checks
+func (s *Server) startHostChecker() {
+	resolvConf := os.Getenv("RESOLV_CONF")
+	if resolvConf == "" {
+		resolvConf = "/etc/resolv.conf"
+	}
+	hostChecker = hostcheck.NewChecker(s.db.DB, resolvConf)
+	hostChecker.Start()
+}
+
+// hostCheckResults returns the latest clock and DNS check results
+func hostCheckResults() []hostcheck.Result {
+	if hostChecker == nil {
+		return []hostcheck.Result{}
+	}
+	if results := hostChecker.Results(); results != nil {
+		return results
+	}
+	return []hostcheck.Result{}
+}
+
+// getResources returns the host's current CPU, memory, load and volume
+// usage, its samples over the last ?hours= hours (default 24) and the
+// clock and DNS check results
+func (s *Server) getResources(w http.ResponseWriter, r *http.Request) {
+	hours := 24
+	if v := r.URL.Query().Get("hours"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 24*90 {
+			hours = n
+		}
+	}
+
+	var current *hoststats.Sample
+	if hostSampler != nil {
+		current = hostSampler.Latest()
+		if current == nil {
+			current = hostSampler.Collect(time.Now())
+		}
+	}
+
+	now := time.Now()
+	history, err := hoststats.Series(s.db.DB, now.Add(-time.Duration(hours)*time.Hour), now)
+	if err != nil {
+		http.Error(w, "Failed to load resource history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"current": current,
+		"history": history,
+		"checks":  hostCheckResults(),
+	})
+}
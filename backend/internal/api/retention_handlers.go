@@ -0,0 +1,135 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/retention"
+	"github.com/postfixrelay/postfixrelay/internal/scheduler"
+	"github.com/rs/zerolog/log"
+)
+
+// retentionPruner deletes logs, alerts, exports and contacts past their
+// retention period
+var retentionPruner *retention.Pruner
+
+// exportPrefix covers every generated export, log exports included
+const exportPrefix = "exports/"
+
+// startRetentionPruner schedules the retention pruner, hourly by default
+func (s *Server) startRetentionPruner() {
+	retentionPruner = retention.NewPruner(s.db.DB, s.store, exportPrefix)
+	cleanupJobNames = append(cleanupJobNames, "retention_prune")
+	s.scheduler.Register(scheduler.Task{
+		Name:        "retention_prune",
+		Description: "Delete logs, alerts, exports and contacts past their retention period",
+		Schedule:    "@hourly",
+		Run: func(ctx context.Context) error {
+			return recordRetention(retentionPruner.Run(ctx, time.Now()))
+		},
+	})
+}
+
+// recordRetention reports a prune to the cleanup status and metrics,
+// returning its failures as one error
+func recordRetention(result *retention.Result) error {
+	var err error
+	if len(result.Errors) > 0 {
+		err = fmt.Errorf("retention prune failed: %s", strings.Join(result.Errors, "; "))
+	}
+	recordCleanup("retention_prune", result.RanAt, result.Deleted, err)
+	return err
+}
+
+// getRetention returns the retention policies and the last prune
+func (s *Server) getRetention(w http.ResponseWriter, r *http.Request) {
+	var last *retention.Result
+	if retentionPruner != nil {
+		last = retentionPruner.LastRun()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"policies": retention.CurrentPolicies(s.db.DB),
+		"lastRun":  last,
+	})
+}
+
+// runRetention prunes now instead of waiting for the next scheduled run
+func (s *Server) runRetention(w http.ResponseWriter, r *http.Request) {
+	if retentionPruner == nil {
+		http.Error(w, "Retention pruner is not running", http.StatusServiceUnavailable)
+		return
+	}
+	result := retentionPruner.Run(r.Context(), time.Now())
+	recordRetention(result)
+
+	if u := GetUser(r.Context()); u != nil {
+		s.logAudit(u.ID, u.Username, "retention_run", "retention", "", "Ran retention pruning", "success", r.RemoteAddr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// erasePersonalData scrubs an email address from logs and contact data.
+// The audit record names the address only by a hash, so erasures can be
+// matched against a request without keeping the address itself.
+func (s *Server) erasePersonalData(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Address string `json:"address"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Address = strings.TrimSpace(req.Address)
+	v := NewValidator()
+	v.ValidateRequired("address", req.Address)
+	if req.Address != "" {
+		v.ValidateEmail("address", req.Address)
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	counts, err := retention.Erase(s.db.DB, req.Address)
+	if err == retention.ErrHeld {
+		http.Error(w, "Address belongs to a mailbox under legal hold", http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Error().Err(err).Msg("Personal data erasure failed")
+		http.Error(w, "Failed to erase personal data", http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256([]byte(strings.ToLower(req.Address)))
+	hash := hex.EncodeToString(sum[:])
+
+	tables := make([]string, 0, len(counts))
+	var total int64
+	for table, n := range counts {
+		tables = append(tables, fmt.Sprintf("%s=%d", table, n))
+		total += n
+	}
+	sort.Strings(tables)
+
+	if u := GetUser(r.Context()); u != nil {
+		s.logAudit(u.ID, u.Username, "personal_data_erase", "address", "sha256:"+hash,
+			fmt.Sprintf("Erased personal data for an address (%d rows: %s)", total, strings.Join(tables, ", ")), "success", r.RemoteAddr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"addressHash": "sha256:" + hash,
+		"affected":    counts,
+	})
+}
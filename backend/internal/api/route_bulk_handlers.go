@@ -0,0 +1,261 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+)
+
+// maxRouteImportSize limits a bulk route import
+const maxRouteImportSize = 5 << 20
+
+// routeImportResult is what a bulk route import would change or changed
+type routeImportResult struct {
+	DryRunResult
+	Mode         string                   `json:"mode"`
+	Transport    postfix.RouteDiff        `json:"transport"`
+	SenderRelays postfix.RouteDiff        `json:"senderRelays"`
+	Conflicts    []postfix.ImportConflict `json:"conflicts"`
+	Routing      []postfix.RoutingFinding `json:"routing"`
+}
+
+// exportRoutes downloads the transport maps and sender relays as CSV, or
+// JSON with ?format=json
+func (s *Server) exportRoutes(w http.ResponseWriter, r *http.Request) {
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+	maps, err := postfixMgr.GetTransportMaps()
+	if err != nil {
+		http.Error(w, "failed to get transport maps: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	relays, err := postfixMgr.GetSenderDependentRelays()
+	if err != nil {
+		http.Error(w, "failed to get sender relays: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	name := "routes-" + time.Now().UTC().Format("20060102")
+	if r.URL.Query().Get("format") == "json" {
+		if maps == nil {
+			maps = []postfix.TransportMap{}
+		}
+		if relays == nil {
+			relays = []postfix.SenderDependentRelay{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment; filename="+name+".json")
+		json.NewEncoder(w).Encode(postfix.RouteBundle{TransportMaps: maps, SenderRelays: relays})
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename="+name+".csv")
+	w.Write(postfix.RoutesCSV(maps, relays))
+}
+
+// validateRouteBundle checks imported routes as the single-entry handlers
+// would, plus duplicates within the import
+func validateRouteBundle(bundle *postfix.RouteBundle) []postfix.ImportConflict {
+	var conflicts []postfix.ImportConflict
+	reject := func(kind, key string, v *Validator) {
+		for _, e := range v.Errors() {
+			conflicts = append(conflicts, postfix.ImportConflict{
+				Line: bundle.RouteLine(kind, key), Key: key, Reason: e.Field + ": " + e.Message,
+			})
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, tm := range bundle.TransportMaps {
+		v := NewValidator()
+		v.ValidateRequired("domain", tm.Domain)
+		v.ValidateRequired("nextHop", tm.NextHop)
+		v.ValidateDomain("domain", tm.Domain)
+		v.ValidateHostname("nextHop", tm.NextHop)
+		v.ValidatePort("port", tm.Port)
+		if seen[tm.Domain] {
+			v.AddError("domain", "appears more than once in the import")
+		}
+		seen[tm.Domain] = true
+		reject(postfix.RouteTransport, tm.Domain, v)
+	}
+
+	seen = map[string]bool{}
+	for _, relay := range bundle.SenderRelays {
+		v := NewValidator()
+		v.ValidateRequired("sender", relay.Sender)
+		v.ValidateRequired("relayhost", relay.Relayhost)
+		if strings.HasPrefix(relay.Sender, "@") {
+			v.ValidateDomain("sender", strings.TrimPrefix(relay.Sender, "@"))
+		} else {
+			v.ValidateEmail("sender", relay.Sender)
+		}
+		v.ValidateRelayhost("relayhost", relay.Relayhost)
+		if seen[relay.Sender] {
+			v.AddError("sender", "appears more than once in the import")
+		}
+		seen[relay.Sender] = true
+		reject(postfix.RouteSenderRelay, relay.Sender, v)
+	}
+	return conflicts
+}
+
+// importRoutes imports transport maps and sender relays from CSV or JSON
+// (?format=, else by Content-Type). ?mode=merge (the default) adds and
+// updates entries; ?mode=replace also removes entries of a kind the import
+// has that it doesn't list. Nothing is written if any row is invalid, and
+// the maps are written together: if the second fails the first is put
+// back. ?dryRun=true shows the diff and file changes.
+func (s *Server) importRoutes(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "merge"
+	}
+	if mode != "merge" && mode != "replace" {
+		http.Error(w, "mode must be merge or replace", http.StatusBadRequest)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRouteImportSize))
+	if err != nil {
+		http.Error(w, "import too large (max 5 MB)", http.StatusRequestEntityTooLarge)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+		if strings.Contains(r.Header.Get("Content-Type"), "csv") {
+			format = "csv"
+		}
+	}
+	var bundle *postfix.RouteBundle
+	var conflicts []postfix.ImportConflict
+	switch format {
+	case "csv":
+		bundle, conflicts, err = postfix.ParseRoutesCSV(data)
+	case "json":
+		bundle, conflicts, err = postfix.ParseRoutesJSON(data)
+	default:
+		http.Error(w, "format must be csv or json", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conflicts = append(conflicts, validateRouteBundle(bundle)...)
+
+	// An import on top of staged changes would make them not apply
+	var staged int
+	s.db.QueryRow(`SELECT (SELECT COUNT(*) FROM staged_transport_maps) + (SELECT COUNT(*) FROM staged_sender_relays)`).Scan(&staged)
+	if staged > 0 {
+		http.Error(w, "apply or discard the staged map changes before importing", http.StatusConflict)
+		return
+	}
+
+	currentMaps, err := postfixMgr.GetTransportMaps()
+	if err != nil {
+		http.Error(w, "failed to get transport maps: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	currentRelays, err := postfixMgr.GetSenderDependentRelays()
+	if err != nil {
+		http.Error(w, "failed to get sender relays: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// A replace only covers the kinds of route the import has
+	maps, transportDiff := postfix.MergeTransportMaps(currentMaps, bundle.TransportMaps, mode == "replace" && len(bundle.TransportMaps) > 0)
+	relays, relayDiff := postfix.MergeSenderRelays(currentRelays, bundle.SenderRelays, mode == "replace" && len(bundle.SenderRelays) > 0)
+
+	result := routeImportResult{
+		Mode:         mode,
+		Transport:    transportDiff,
+		SenderRelays: relayDiff,
+		Conflicts:    conflicts,
+	}
+	if result.Conflicts == nil {
+		result.Conflicts = []postfix.ImportConflict{}
+	}
+	result.Summary = fmt.Sprintf("%d transport maps added, %d updated, %d removed; %d sender relays added, %d updated, %d removed",
+		len(transportDiff.Added), len(transportDiff.Updated), len(transportDiff.Removed),
+		len(relayDiff.Added), len(relayDiff.Updated), len(relayDiff.Removed))
+	if cfg, err := postfixMgr.RoutingConfig(nil); err == nil {
+		cfg.Transports, cfg.SenderRelays = maps, relays
+		result.Routing = postfix.LintRouting(cfg)
+	}
+
+	if isDryRun(r) {
+		if transportDiff.Changed() {
+			if change, err := postfixMgr.PreviewTransportMaps(maps); err == nil {
+				result.Files = append(result.Files, change)
+			}
+		}
+		if relayDiff.Changed() {
+			if change, err := postfixMgr.PreviewSenderRelays(relays); err == nil {
+				result.Files = append(result.Files, change)
+			}
+		}
+		result.DryRun = true
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	if len(conflicts) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	if transportDiff.Changed() {
+		if err := postfixMgr.SaveTransportMaps(maps); err != nil {
+			s.logAudit(user.ID, user.Username, "routes_import", "transport_map", "", "Failed to import routes: "+err.Error(), "failed", r.RemoteAddr)
+			http.Error(w, "failed to write transport maps: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if relayDiff.Changed() {
+		if err := postfixMgr.SaveSenderDependentRelays(relays); err != nil {
+			if transportDiff.Changed() {
+				postfixMgr.SaveTransportMaps(currentMaps)
+			}
+			s.logAudit(user.ID, user.Username, "routes_import", "sender_relay", "", "Failed to import routes: "+err.Error(), "failed", r.RemoteAddr)
+			http.Error(w, "failed to write sender relays; no routes were changed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Replaced entries can be restored like deleted ones
+	removedMaps := transportMapsByDomain(currentMaps)
+	for _, domain := range transportDiff.Removed {
+		s.trashEntry(r, trashTransportMap, domain, removedMaps[domain])
+	}
+	removedRelays := senderRelaysBySender(currentRelays)
+	for _, sender := range relayDiff.Removed {
+		s.trashEntry(r, trashSenderRelay, sender, removedRelays[sender])
+	}
+
+	if transportDiff.Changed() {
+		s.logAuditDiff(user, "routes_import", "transport_map", "", "Imported transport maps: "+result.Summary,
+			auditDiff(transportMapsByDomain(currentMaps), transportMapsByDomain(maps)), r)
+	}
+	if relayDiff.Changed() {
+		s.logAuditDiff(user, "routes_import", "sender_relay", "", "Imported sender relays: "+result.Summary,
+			auditDiff(senderRelaysBySender(currentRelays), senderRelaysBySender(relays)), r)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,318 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/alerts"
+)
+
+// getAlertRoutes returns the routes in evaluation order along with the
+// catch-all channels
+func (s *Server) getAlertRoutes(w http.ResponseWriter, r *http.Request) {
+	routes, err := alerts.LoadRoutes(s.db.DB)
+	if err != nil {
+		http.Error(w, "Failed to load routes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"routes":          routes,
+		"defaultChannels": s.db.GetSetting("alert_default_channels", ""),
+	})
+}
+
+// validateRoute checks a route's conditions and channels
+func (s *Server) validateRoute(rt *alerts.Route) *Validator {
+	v := NewValidator()
+	v.ValidateRequired("name", rt.Name)
+	v.ValidateMaxLength("name", rt.Name, 100)
+
+	switch alerts.AlertSeverity(rt.Severity) {
+	case "", alerts.SeverityWarning, alerts.SeverityCritical:
+	default:
+		v.AddErrorf("severity", "must be one of: %s", "warning, critical")
+	}
+
+	if len(rt.RuleTypes) > 0 {
+		known := make(map[string]bool)
+		if rows, err := s.db.Query(`SELECT DISTINCT type FROM alert_rules`); err == nil {
+			for rows.Next() {
+				var t string
+				if rows.Scan(&t) == nil {
+					known[t] = true
+				}
+			}
+			rows.Close()
+		}
+		for _, t := range rt.RuleTypes {
+			if !known[t] {
+				v.AddErrorf("ruleTypes", "unknown rule type %q", t)
+			}
+		}
+	}
+
+	if rt.Domain != "" {
+		v.ValidateDomain("domain", rt.Domain)
+	}
+	for _, day := range rt.Days {
+		if !containsDay(day) {
+			v.AddErrorf("days", "must be one of: %s", strings.Join(alerts.RouteDays, ", "))
+			break
+		}
+	}
+	for field, value := range map[string]string{"startTime": rt.StartTime, "endTime": rt.EndTime} {
+		if value == "" {
+			continue
+		}
+		if _, err := alerts.ParseClock(value); err != nil {
+			v.AddError(field, "must be a time of day (HH:MM)")
+		}
+	}
+	if (rt.StartTime == "") != (rt.EndTime == "") {
+		v.AddErrorf("endTime", "must be set together with %s", "startTime")
+	}
+	if rt.Timezone != "" {
+		if _, err := time.LoadLocation(rt.Timezone); err != nil {
+			v.AddError("timezone", "unknown time zone")
+		}
+	}
+
+	for _, id := range rt.ChannelIDs {
+		var n int
+		s.db.QueryRow(`SELECT COUNT(*) FROM notification_channels WHERE id = ?`, id).Scan(&n)
+		if n == 0 {
+			v.AddErrorf("channelIds", "unknown channel %d", id)
+		}
+	}
+	return v
+}
+
+func containsDay(day string) bool {
+	for _, d := range alerts.RouteDays {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// saveRoute inserts a route (ID 0) or updates it
+func (s *Server) saveRoute(rt *alerts.Route) error {
+	if rt.RuleTypes == nil {
+		rt.RuleTypes = []string{}
+	}
+	if rt.Days == nil {
+		rt.Days = []string{}
+	}
+	if rt.ChannelIDs == nil {
+		rt.ChannelIDs = []int64{}
+	}
+	ruleTypes, _ := json.Marshal(rt.RuleTypes)
+	days, _ := json.Marshal(rt.Days)
+	channelIDs, _ := json.Marshal(rt.ChannelIDs)
+
+	if rt.ID == 0 {
+		if rt.Position == 0 {
+			s.db.QueryRow(`SELECT COALESCE(MAX(position), 0) + 1 FROM alert_routes`).Scan(&rt.Position)
+		}
+		result, err := s.db.Exec(`
+			INSERT INTO alert_routes (name, position, enabled, severity, rule_types, domain, days,
+				start_time, end_time, timezone, channel_ids, continue_matching)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, rt.Name, rt.Position, rt.Enabled, rt.Severity, string(ruleTypes), strings.ToLower(rt.Domain), string(days),
+			rt.StartTime, rt.EndTime, rt.Timezone, string(channelIDs), rt.Continue)
+		if err != nil {
+			return err
+		}
+		rt.ID, _ = result.LastInsertId()
+		return nil
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE alert_routes SET name = ?, position = ?, enabled = ?, severity = ?, rule_types = ?, domain = ?,
+			days = ?, start_time = ?, end_time = ?, timezone = ?, channel_ids = ?, continue_matching = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, rt.Name, rt.Position, rt.Enabled, rt.Severity, string(ruleTypes), strings.ToLower(rt.Domain), string(days),
+		rt.StartTime, rt.EndTime, rt.Timezone, string(channelIDs), rt.Continue, rt.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// reloadRouting makes the running alert engine pick up route changes
+func (s *Server) reloadRouting() {
+	if alertEngine != nil {
+		alertEngine.ReloadChannels()
+	}
+}
+
+// createAlertRoute adds a route, at the end unless a position is given
+func (s *Server) createAlertRoute(w http.ResponseWriter, r *http.Request) {
+	var rt alerts.Route
+	if err := json.NewDecoder(r.Body).Decode(&rt); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	rt.ID = 0
+	if v := s.validateRoute(&rt); v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+	if err := s.saveRoute(&rt); err != nil {
+		http.Error(w, "Failed to create route", http.StatusInternalServerError)
+		return
+	}
+	s.reloadRouting()
+
+	if u := GetUser(r.Context()); u != nil {
+		s.logAudit(u.ID, u.Username, "route_create", "alert_route", fmt.Sprintf("%d", rt.ID), "Created alert route "+rt.Name, "success", r.RemoteAddr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rt)
+}
+
+// updateAlertRoute replaces a route
+func (s *Server) updateAlertRoute(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid route ID", http.StatusBadRequest)
+		return
+	}
+	var rt alerts.Route
+	if err := json.NewDecoder(r.Body).Decode(&rt); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	rt.ID = id
+	if v := s.validateRoute(&rt); v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+	if err := s.saveRoute(&rt); err == sql.ErrNoRows {
+		http.Error(w, "Route not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to update route", http.StatusInternalServerError)
+		return
+	}
+	s.reloadRouting()
+
+	if u := GetUser(r.Context()); u != nil {
+		s.logAudit(u.ID, u.Username, "route_update", "alert_route", fmt.Sprintf("%d", id), "Updated alert route "+rt.Name, "success", r.RemoteAddr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rt)
+}
+
+// deleteAlertRoute removes a route
+func (s *Server) deleteAlertRoute(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	result, err := s.db.Exec(`DELETE FROM alert_routes WHERE id = ?`, id)
+	if err != nil {
+		http.Error(w, "Failed to delete route", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Route not found", http.StatusNotFound)
+		return
+	}
+	s.reloadRouting()
+
+	if u := GetUser(r.Context()); u != nil {
+		s.logAudit(u.ID, u.Username, "route_delete", "alert_route", id, "Deleted alert route "+id, "success", r.RemoteAddr)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reorderAlertRoutes sets the evaluation order from a list of route IDs
+func (s *Server) reorderAlertRoutes(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs []int64 `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.IDs) == 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to reorder routes", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	for i, id := range req.IDs {
+		if _, err := tx.Exec(`UPDATE alert_routes SET position = ? WHERE id = ?`, i+1, id); err != nil {
+			http.Error(w, "Failed to reorder routes", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to reorder routes", http.StatusInternalServerError)
+		return
+	}
+	s.reloadRouting()
+
+	if u := GetUser(r.Context()); u != nil {
+		s.logAudit(u.ID, u.Username, "route_reorder", "alert_route", "", "Reordered alert routes", "success", r.RemoteAddr)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// testAlertRoutes reports which routes and channels an alert with the
+// given severity, rule type and domain would go to, now or at a given time
+func (s *Server) testAlertRoutes(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Severity string    `json:"severity"`
+		RuleType string    `json:"ruleType"`
+		Domain   string    `json:"domain"`
+		At       time.Time `json:"at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.At.IsZero() {
+		req.At = time.Now()
+	}
+
+	routes, err := alerts.LoadRoutes(s.db.DB)
+	if err != nil {
+		http.Error(w, "Failed to load routes", http.StatusInternalServerError)
+		return
+	}
+	alert := alerts.Alert{
+		Severity: alerts.AlertSeverity(req.Severity),
+		Context:  map[string]interface{}{"domain": req.Domain},
+	}
+
+	defaults := alerts.ParseChannelIDs(s.db.GetSetting("alert_default_channels", ""))
+	matched, channels := alerts.RouteAlert(routes, defaults, alert, req.RuleType, req.At)
+	if matched == nil {
+		matched = []int64{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"matchedRoutes": matched,
+		"channels":      channels, // null: every enabled channel
+	})
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+)
+
+// routingConfig reads what decides where relayed mail goes. With staged
+// set, the staged main.cf and map changes are included as they would be
+// after apply.
+func (s *Server) routingConfig(staged bool) (*postfix.RoutingConfig, error) {
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+
+	overrides := map[string]string{}
+	if staged {
+		var err error
+		if overrides, err = s.stagedConfigOverrides(); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg, err := postfixMgr.RoutingConfig(overrides)
+	if err != nil || !staged {
+		return cfg, err
+	}
+
+	transports, err := s.loadStagedTransportChanges()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Transports, err = postfix.ApplyTransportChanges(cfg.Transports, transportChanges(transports)); err != nil {
+		return nil, err
+	}
+	relays, err := s.loadStagedSenderRelayChanges()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SenderRelays, err = postfix.ApplySenderRelayChanges(cfg.SenderRelays, senderRelayChanges(relays)); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// stagedConfigOverrides returns the staged main.cf changes by parameter
+func (s *Server) stagedConfigOverrides() (map[string]string, error) {
+	rows, err := s.db.Query(`SELECT key, value FROM staged_config`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	overrides := map[string]string{}
+	for rows.Next() {
+		var key, value string
+		if rows.Scan(&key, &value) == nil {
+			overrides[key] = value
+		}
+	}
+	return overrides, rows.Err()
+}
+
+// lintRouting checks relayhost, relay_domains, the transport maps and the
+// sender relays against each other and explains Postfix's precedence
+// between them. ?staged=true checks the config as it would be after apply.
+func (s *Server) lintRouting(w http.ResponseWriter, r *http.Request) {
+	staged := r.URL.Query().Get("staged") == "true"
+	cfg, err := s.routingConfig(staged)
+	if err != nil {
+		http.Error(w, "failed to read routing config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	findings := postfix.LintRouting(cfg)
+	counts := map[string]int{postfix.LintError: 0, postfix.LintWarning: 0, postfix.LintInfo: 0}
+	for _, f := range findings {
+		counts[f.Severity]++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"staged":     staged,
+		"findings":   findings,
+		"counts":     counts,
+		"precedence": postfix.RoutingPrecedence,
+		"config":     cfg,
+	})
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/alerts"
+	"github.com/postfixrelay/postfixrelay/internal/i18n"
+)
+
+// maxRunbookLength caps the size of a rule's runbook content
+const maxRunbookLength = 64 * 1024
+
+// RuleRunbook is the runbook attached to an alert rule. Rules without
+// custom content serve the built-in runbook for their type.
+type RuleRunbook struct {
+	RuleID     int64      `json:"ruleId"`
+	RuleName   string     `json:"ruleName"`
+	RuleType   string     `json:"ruleType"`
+	Content    string     `json:"content"` // Markdown
+	URL        string     `json:"url,omitempty"`
+	Custom     bool       `json:"custom"`
+	UpdatedAt  *time.Time `json:"updatedAt,omitempty"`
+	UpdatedBy  string     `json:"updatedBy,omitempty"`
+	ReviewedAt *time.Time `json:"reviewedAt,omitempty"`
+	ReviewedBy string     `json:"reviewedBy,omitempty"`
+}
+
+// loadRuleRunbook reads a rule's runbook, falling back to the built-in
+// content in locale
+func (s *Server) loadRuleRunbook(id, locale string) (*RuleRunbook, error) {
+	var rb RuleRunbook
+	var updatedAt, reviewedAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT id, name, type, COALESCE(runbook_content, ''), COALESCE(runbook_url, ''),
+			runbook_updated_at, COALESCE(runbook_updated_by, ''),
+			runbook_reviewed_at, COALESCE(runbook_reviewed_by, '')
+		FROM alert_rules WHERE id = ?
+	`, id).Scan(&rb.RuleID, &rb.RuleName, &rb.RuleType, &rb.Content, &rb.URL,
+		&updatedAt, &rb.UpdatedBy, &reviewedAt, &rb.ReviewedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	if updatedAt.Valid {
+		rb.UpdatedAt = &updatedAt.Time
+	}
+	if reviewedAt.Valid {
+		rb.ReviewedAt = &reviewedAt.Time
+	}
+
+	rb.Custom = rb.Content != ""
+	if !rb.Custom {
+		rb.Content = alerts.GetRunbook(rb.RuleType).Localize(locale).Markdown()
+	}
+	return &rb, nil
+}
+
+// getRuleRunbook returns a rule's runbook as JSON, or as plain markdown
+// with ?format=markdown (the form alert notifications link to)
+func (s *Server) getRuleRunbook(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	rb, err := s.loadRuleRunbook(id, i18n.FromContext(r.Context()))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Alert rule not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to load runbook", http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "markdown" {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Write([]byte(rb.Content))
+		if rb.URL != "" {
+			w.Write([]byte("\n" + rb.URL + "\n"))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rb)
+}
+
+// updateRuleRunbook sets a rule's runbook content and external URL
+func (s *Server) updateRuleRunbook(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	id := chi.URLParam(r, "id")
+
+	var req struct {
+		Content      string `json:"content"`
+		URL          string `json:"url"`
+		MarkReviewed bool   `json:"markReviewed"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.URL = strings.TrimSpace(req.URL)
+
+	v := NewValidator()
+	v.ValidateMaxLength("content", req.Content, maxRunbookLength)
+	v.ValidateHTTPURL("url", req.URL)
+	if strings.TrimSpace(req.Content) == "" && req.URL == "" {
+		v.AddError("content", "this field is required")
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	query := `
+		UPDATE alert_rules SET runbook_content = ?, runbook_url = ?,
+			runbook_updated_at = CURRENT_TIMESTAMP, runbook_updated_by = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`
+	args := []interface{}{req.Content, req.URL, user.Username, id}
+	if req.MarkReviewed {
+		query = `
+		UPDATE alert_rules SET runbook_content = ?, runbook_url = ?,
+			runbook_updated_at = CURRENT_TIMESTAMP, runbook_updated_by = ?, updated_at = CURRENT_TIMESTAMP,
+			runbook_reviewed_at = CURRENT_TIMESTAMP, runbook_reviewed_by = ?
+		WHERE id = ?`
+		args = []interface{}{req.Content, req.URL, user.Username, user.Username, id}
+	}
+
+	if !s.execRunbookUpdate(w, query, args...) {
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "alert_runbook_update", "alert_rule", id, "Updated runbook for alert rule "+id, "success", "", r)
+	s.getRuleRunbook(w, r)
+}
+
+// deleteRuleRunbook removes a rule's custom runbook so it serves the
+// built-in one again
+func (s *Server) deleteRuleRunbook(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	id := chi.URLParam(r, "id")
+
+	if !s.execRunbookUpdate(w, `
+		UPDATE alert_rules SET runbook_content = NULL, runbook_url = NULL,
+			runbook_updated_at = NULL, runbook_updated_by = NULL,
+			runbook_reviewed_at = NULL, runbook_reviewed_by = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, id) {
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "alert_runbook_delete", "alert_rule", id, "Reset runbook for alert rule "+id, "success", "", r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reviewRuleRunbook records that the current user has reviewed a rule's
+// runbook and found it up to date
+func (s *Server) reviewRuleRunbook(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	id := chi.URLParam(r, "id")
+
+	if !s.execRunbookUpdate(w, `
+		UPDATE alert_rules SET runbook_reviewed_at = CURRENT_TIMESTAMP, runbook_reviewed_by = ?
+		WHERE id = ?
+	`, user.Username, id) {
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "alert_runbook_review", "alert_rule", id, "Reviewed runbook for alert rule "+id, "success", "", r)
+	s.getRuleRunbook(w, r)
+}
+
+// execRunbookUpdate runs an update against one alert rule and reloads the
+// engine so notifications pick up the new runbook link. It writes the error
+// response and returns false if the update failed or matched no rule.
+func (s *Server) execRunbookUpdate(w http.ResponseWriter, query string, args ...interface{}) bool {
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		http.Error(w, "Failed to update runbook", http.StatusInternalServerError)
+		return false
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Alert rule not found", http.StatusNotFound)
+		return false
+	}
+
+	if alertEngine != nil {
+		alertEngine.ReloadRules()
+	}
+	return true
+}
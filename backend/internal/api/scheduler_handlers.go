@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/scheduler"
+	"github.com/postfixrelay/postfixrelay/internal/supervisor"
+	"github.com/rs/zerolog/log"
+)
+
+// runScheduledTask starts a scheduled run as a background task, so it is
+// counted and waited for on shutdown like other background work. The
+// scheduler only runs a task again at its next time, so it isn't retried.
+func (s *Server) runScheduledTask(name string, fn func() error) {
+	s.workers.Go(name, supervisor.Once, fn)
+}
+
+// listScheduledTasks returns the scheduled tasks with their settings, last
+// run and next run
+func (s *Server) listScheduledTasks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tasks": s.scheduler.List(),
+	})
+}
+
+// updateScheduledTask changes a task's schedule, jitter or whether it runs
+func (s *Server) updateScheduledTask(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	name := chi.URLParam(r, "name")
+
+	var req scheduler.Settings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	before, err := s.scheduler.Get(name)
+	if errors.Is(err, scheduler.ErrUnknown) {
+		http.Error(w, "Unknown scheduled task", http.StatusNotFound)
+		return
+	}
+	after, err := s.scheduler.Update(name, req, user.Username)
+	if errors.Is(err, scheduler.ErrUnknown) {
+		http.Error(w, "Unknown scheduled task", http.StatusNotFound)
+		return
+	}
+	var invalid *scheduler.InvalidSettingError
+	if errors.As(err, &invalid) {
+		v := NewValidator()
+		v.AddError(invalid.Field, invalid.Err.Error())
+		writeValidationErrors(w, r, v)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("task", name).Msg("Failed to update scheduled task")
+		http.Error(w, "Failed to update scheduled task", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAuditDiff(user, "update", "scheduled_task", name, "Changed scheduled task "+name,
+		auditDiff(taskSettings(before), taskSettings(after)), r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(after)
+}
+
+// taskSettings is the part of a task's status an admin sets, for audit
+// diffs
+func taskSettings(st scheduler.Status) map[string]string {
+	return map[string]string{
+		"schedule":      st.Schedule,
+		"enabled":       strconv.FormatBool(st.Enabled),
+		"jitterSeconds": strconv.Itoa(st.JitterSeconds),
+	}
+}
+
+// runScheduledTaskNow runs a task now instead of waiting for its schedule.
+// It answers once the run has started; the outcome shows in the task's
+// last run.
+func (s *Server) runScheduledTaskNow(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	name := chi.URLParam(r, "name")
+
+	switch err := s.scheduler.Trigger(name, user.Username); {
+	case errors.Is(err, scheduler.ErrUnknown):
+		http.Error(w, "Unknown scheduled task", http.StatusNotFound)
+		return
+	case errors.Is(err, scheduler.ErrRunning):
+		http.Error(w, "Task is already running", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to run task", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "scheduled_task_run", "scheduled_task", name, "Ran scheduled task "+name, "success", r.RemoteAddr)
+
+	st, _ := s.scheduler.Get(name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(st)
+}
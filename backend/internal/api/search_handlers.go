@@ -0,0 +1,355 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/postfixrelay/postfixrelay/internal/mail"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/postfixrelay/postfixrelay/internal/savedsearch"
+)
+
+// searchScheduler runs saved searches that have a schedule
+var searchScheduler *savedsearch.Scheduler
+
+// SavedSearchRequest is the body of a create/update saved search request
+type SavedSearchRequest struct {
+	Name            string              `json:"name"`
+	Source          string              `json:"source"`
+	Filters         savedsearch.Filters `json:"filters"`
+	ScheduleMinutes int                 `json:"scheduleMinutes"`
+	EmailTo         string              `json:"emailTo"`
+	AlertThreshold  *int                `json:"alertThreshold"`
+}
+
+// startSearchScheduler starts running scheduled saved searches
+func (s *Server) startSearchScheduler() {
+	searchScheduler = savedsearch.NewScheduler(s.db.DB, s.searchSources(), s.sendSearchResults)
+	searchScheduler.Start()
+}
+
+// searchSources gives saved searches access to the mail log and queue
+func (s *Server) searchSources() savedsearch.Sources {
+	return savedsearch.Sources{
+		ReadLogs: func(n int) ([]logs.Entry, error) {
+			s.initLogReader()
+			logReaderMu.Lock()
+			reader := logReader
+			logReaderMu.Unlock()
+			return reader.ReadRecent(n)
+		},
+		ListQueue: func(status string) ([]postfix.QueueMessage, error) {
+			s.initQueueManager()
+			return queueMgr.ListMessages(status)
+		},
+	}
+}
+
+// sendSearchResults mails a scheduled search's results as a CSV attachment,
+// with addresses masked as its owner would see them
+func (s *Server) sendSearchResults(search *savedsearch.Search, result *savedsearch.Result) error {
+	if relaySender == nil {
+		return fmt.Errorf("mail services are not initialized")
+	}
+	result = s.redactSearchResult(search.UserID, result)
+
+	hostname, _ := os.Hostname()
+	from := s.db.GetSetting("digest_from", "")
+	if from == "" {
+		from = "postfixrelay@" + hostname
+	}
+
+	body := fmt.Sprintf("Saved search %q (%s) on %s returned %d result(s) at %s.\r\nThe results are attached as CSV.\r\n",
+		search.Name, search.Source, hostname, result.Count, result.RanAt.Format("2006-01-02 15:04 MST"))
+	_, err := relaySender.Send(from, "", &mail.ComposeMessage{
+		To:      []string{search.EmailTo},
+		Subject: fmt.Sprintf("[PostfixRelay] %s: %d result(s)", search.Name, result.Count),
+		Body:    body,
+		Files: []mail.AttachmentFile{{
+			Filename:    fmt.Sprintf("search-%d-%s.csv", search.ID, result.RanAt.Format("20060102-1504")),
+			ContentType: "text/csv",
+			Data:        result.CSV(),
+		}},
+	})
+	return err
+}
+
+// redactSearchResult returns a copy of result masked for the role of the
+// given user. A user that no longer exists gets everything masked.
+func (s *Server) redactSearchResult(userID int64, result *savedsearch.Result) *savedsearch.Result {
+	var role string
+	s.db.QueryRow(`SELECT role FROM users WHERE id = ?`, userID).Scan(&role)
+	masked := *result
+	masked.Logs = maskLogEntriesFor(role, result.Logs)
+	masked.Messages = redactForRole(role, result.Messages).([]postfix.QueueMessage)
+	return &masked
+}
+
+// checkSearchDelivery refuses schedules and emailTo to users who would see
+// the results masked, since the mailed results leave the panel. It writes
+// the error response and returns false when refused.
+func checkSearchDelivery(w http.ResponseWriter, r *http.Request, req *SavedSearchRequest) bool {
+	if (req.ScheduleMinutes > 0 || req.EmailTo != "") && !mayViewAddresses(r) {
+		http.Error(w, "forbidden: scheduling or mailing a saved search needs the view:addresses permission",
+			http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// validateSavedSearch checks a create/update request
+func validateSavedSearch(req *SavedSearchRequest) *Validator {
+	v := NewValidator()
+	req.Name = strings.TrimSpace(req.Name)
+	v.ValidateRequired("name", req.Name)
+	v.ValidateMaxLength("name", req.Name, 100)
+
+	switch req.Source {
+	case savedsearch.SourceLogs:
+		if req.Filters.MinAge != 0 {
+			v.AddErrorf("filters.minAgeMinutes", "only applies to %s searches", savedsearch.SourceQueue)
+		}
+	case savedsearch.SourceQueue:
+		if req.Filters.Process != "" {
+			v.AddErrorf("filters.process", "only applies to %s searches", savedsearch.SourceLogs)
+		}
+		if f := req.Filters.Status; f != "" && f != "active" && f != "deferred" && f != "hold" {
+			v.AddErrorf("filters.status", "must be one of: %s", "active, deferred, hold")
+		}
+		if req.Filters.MinAge < 0 {
+			v.AddError("filters.minAgeMinutes", "must not be negative")
+		}
+	default:
+		v.AddErrorf("source", "must be one of: %s", "logs, queue")
+	}
+
+	if req.ScheduleMinutes < 0 {
+		v.AddError("scheduleMinutes", "must be zero (disabled) or a positive number of minutes")
+	}
+	if req.EmailTo != "" {
+		v.ValidateEmail("emailTo", req.EmailTo)
+		if req.ScheduleMinutes == 0 {
+			v.AddError("emailTo", "requires a schedule")
+		}
+	}
+	if req.AlertThreshold != nil {
+		if *req.AlertThreshold < 0 {
+			v.AddError("alertThreshold", "must not be negative")
+		}
+		if req.ScheduleMinutes == 0 {
+			v.AddError("alertThreshold", "requires a schedule")
+		}
+	}
+	return v
+}
+
+// loadOwnSearch loads the saved search in the URL, which must belong to the
+// current user (admins may access any). It writes the error response and
+// returns nil on failure.
+func (s *Server) loadOwnSearch(w http.ResponseWriter, r *http.Request) *savedsearch.Search {
+	user := GetUser(r.Context())
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid search ID", http.StatusBadRequest)
+		return nil
+	}
+
+	search, err := savedsearch.Get(s.db.DB, id)
+	if errors.Is(err, savedsearch.ErrNotFound) || (err == nil && search.UserID != user.ID && user.Role != "admin") {
+		http.Error(w, "Saved search not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, "Failed to load saved search", http.StatusInternalServerError)
+		return nil
+	}
+	return search
+}
+
+// listSavedSearches returns the current user's saved searches
+func (s *Server) listSavedSearches(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+
+	searches, err := savedsearch.List(s.db.DB, user.ID)
+	if err != nil {
+		http.Error(w, "Failed to load saved searches", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"searches": searches,
+	})
+}
+
+// createSavedSearch saves a new named search
+func (s *Server) createSavedSearch(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+
+	var req SavedSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if v := validateSavedSearch(&req); v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+	if !checkSearchDelivery(w, r, &req) {
+		return
+	}
+
+	search := &savedsearch.Search{
+		UserID:          user.ID,
+		Name:            req.Name,
+		Source:          req.Source,
+		Filters:         req.Filters,
+		ScheduleMinutes: req.ScheduleMinutes,
+		EmailTo:         req.EmailTo,
+		AlertThreshold:  req.AlertThreshold,
+	}
+	if err := savedsearch.Create(s.db.DB, search); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint") {
+			http.Error(w, "A saved search with this name already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to save search", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "saved_search_create", "saved_search", strconv.FormatInt(search.ID, 10),
+		"Saved search "+search.Name, "success", r.RemoteAddr)
+
+	created, err := savedsearch.Get(s.db.DB, search.ID)
+	if err != nil {
+		created = search
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// getSavedSearch returns one saved search
+func (s *Server) getSavedSearch(w http.ResponseWriter, r *http.Request) {
+	search := s.loadOwnSearch(w, r)
+	if search == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(search)
+}
+
+// updateSavedSearch replaces a saved search's name, filters and schedule
+func (s *Server) updateSavedSearch(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	search := s.loadOwnSearch(w, r)
+	if search == nil {
+		return
+	}
+
+	var req SavedSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if v := validateSavedSearch(&req); v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+	if !checkSearchDelivery(w, r, &req) {
+		return
+	}
+
+	search.Name = req.Name
+	search.Source = req.Source
+	search.Filters = req.Filters
+	search.ScheduleMinutes = req.ScheduleMinutes
+	search.EmailTo = req.EmailTo
+	search.AlertThreshold = req.AlertThreshold
+	if err := savedsearch.Update(s.db.DB, search); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint") {
+			http.Error(w, "A saved search with this name already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to update saved search", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "saved_search_update", "saved_search", strconv.FormatInt(search.ID, 10),
+		"Updated saved search "+search.Name, "success", r.RemoteAddr)
+
+	updated, err := savedsearch.Get(s.db.DB, search.ID)
+	if err != nil {
+		updated = search
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// deleteSavedSearch removes a saved search
+func (s *Server) deleteSavedSearch(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	search := s.loadOwnSearch(w, r)
+	if search == nil {
+		return
+	}
+
+	if err := savedsearch.Delete(s.db.DB, search.ID); err != nil && !errors.Is(err, savedsearch.ErrNotFound) {
+		http.Error(w, "Failed to delete saved search", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "saved_search_delete", "saved_search", strconv.FormatInt(search.ID, 10),
+		"Deleted saved search "+search.Name, "success", r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runSavedSearch runs a saved search now and returns its results. With
+// ?notify=true a scheduled search also records the run and mails the
+// results as its schedule would.
+func (s *Server) runSavedSearch(w http.ResponseWriter, r *http.Request) {
+	search := s.loadOwnSearch(w, r)
+	if search == nil {
+		return
+	}
+
+	notify := r.URL.Query().Get("notify") == "true"
+	if notify && !mayViewAddresses(r) {
+		http.Error(w, "forbidden: mailing a saved search's results needs the view:addresses permission",
+			http.StatusForbidden)
+		return
+	}
+
+	var result *savedsearch.Result
+	var err error
+	if notify && search.ScheduleMinutes > 0 {
+		result, err = searchScheduler.RunScheduled(search)
+	} else {
+		result, err = s.searchSources().Run(search)
+	}
+	if err != nil {
+		http.Error(w, "Search failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result.Logs = maskLogEntries(r, result.Logs)
+	result.Messages = redactFor(r, result.Messages).([]postfix.QueueMessage)
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=search-%d.csv", search.ID))
+		w.Write(result.CSV())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/alerts"
+	"github.com/postfixrelay/postfixrelay/internal/mail"
+	"github.com/rs/zerolog/log"
+)
+
+// High-risk actions notify admins immediately while security_notify_enabled
+// is on: through the notification channels in security_notify_channels
+// (all channels when empty), and by email to every platform admin.
+
+// roleRank orders roles by privilege, for telling escalations apart
+var roleRank = map[string]int{"auditor": 1, "operator": 2, "admin": 3}
+
+// apiTokenWriteScopes are the token scopes that change something rather
+// than only look it up
+var apiTokenWriteScopes = map[string]bool{ScopeSend: true}
+
+// notifyPrivileged reports a high-risk action, if enabled
+func (s *Server) notifyPrivileged(r *http.Request, action, summary string) {
+	if s.db.GetSetting("security_notify_enabled", "false") != "true" {
+		return
+	}
+
+	ev := alerts.SecurityEvent{
+		Type:       action,
+		Summary:    summary,
+		IPAddress:  r.RemoteAddr,
+		OccurredAt: time.Now().UTC(),
+	}
+	if u := GetUser(r.Context()); u != nil {
+		ev.Username = u.Username
+	}
+
+	if alertEngine != nil {
+		alertEngine.NotifySecurityEvent(ev, alerts.ParseChannelIDs(s.db.GetSetting("security_notify_channels", "")))
+	}
+	go s.emailAdmins(ev)
+}
+
+// emailAdmins mails a security event to every platform admin through the
+// local relay
+func (s *Server) emailAdmins(ev alerts.SecurityEvent) {
+	if relaySender == nil {
+		return
+	}
+	rows, err := s.db.Query(`SELECT email FROM users WHERE role = 'admin' AND tenant_id IS NULL AND email != ''`)
+	if err != nil {
+		return
+	}
+	var to []string
+	for rows.Next() {
+		var email string
+		if rows.Scan(&email) == nil {
+			to = append(to, email)
+		}
+	}
+	rows.Close()
+	if len(to) == 0 {
+		return
+	}
+
+	from := s.db.GetSetting("digest_from", "")
+	if from == "" {
+		hostname, _ := os.Hostname()
+		from = "postfixrelay@" + hostname
+	}
+	body := fmt.Sprintf("%s by %s at %s", ev.Summary, ev.Username, ev.OccurredAt.Format(time.RFC3339))
+	if ev.IPAddress != "" {
+		body += " from " + ev.IPAddress
+	}
+	body += ".\n\nIf this was not expected, review the audit log now.\n"
+
+	if _, err := relaySender.Send(from, "", &mail.ComposeMessage{
+		To:      to,
+		Subject: "Security: " + ev.Summary,
+		Body:    body,
+	}); err != nil {
+		log.Error().Err(err).Str("action", ev.Type).Msg("Failed to email admins about a privileged action")
+	}
+}
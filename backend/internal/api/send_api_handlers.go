@@ -0,0 +1,293 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/mail"
+	"github.com/postfixrelay/postfixrelay/internal/sendapi"
+	"github.com/rs/zerolog/log"
+)
+
+// APISendRequest is a message sent through the REST send API. Subject,
+// text and html, given directly or taken from a stored template, may
+// contain {{name}} placeholders filled from variables; values are escaped
+// in html.
+type APISendRequest struct {
+	From            string            `json:"from"`
+	To              []string          `json:"to"`
+	Cc              []string          `json:"cc,omitempty"`
+	Bcc             []string          `json:"bcc,omitempty"`
+	Subject         string            `json:"subject"`
+	Text            string            `json:"text"`
+	HTML            string            `json:"html,omitempty"`
+	Template        string            `json:"template,omitempty"`
+	TemplateVersion int               `json:"templateVersion,omitempty"` // 0 for the current version
+	Variables       map[string]string `json:"variables,omitempty"`
+}
+
+// APISentRecipient is the delivery status of one recipient of an API send
+type APISentRecipient struct {
+	Recipient string    `json:"recipient"`
+	Status    string    `json:"status"` // pending, sent, deferred, bounced or expired
+	DSN       string    `json:"dsn,omitempty"`
+	Relay     string    `json:"relay,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// APISentMessage is a message sent through the REST send API
+type APISentMessage struct {
+	ID         int64              `json:"id"`
+	MessageID  string             `json:"messageId"`
+	QueueID    string             `json:"queueId,omitempty"`
+	From       string             `json:"from"`
+	Subject    string             `json:"subject"`
+	Status     string             `json:"status"` // accepted by Postfix, or failed
+	Error      string             `json:"error,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt"`
+	Recipients []APISentRecipient `json:"recipients"`
+}
+
+// validateAPISend checks a send request against the token's senders and the
+// recipient limit, and renders its content. It returns the stored template
+// the content came from, if any.
+func (s *Server) validateAPISend(v *Validator, token *APIToken, req *APISendRequest) *SendTemplate {
+	req.From = strings.ToLower(strings.TrimSpace(req.From))
+	v.ValidateRequired("from", req.From)
+	v.ValidateEmail("from", req.From)
+	if req.From != "" && !v.HasErrors() {
+		var active bool
+		domain := req.From[strings.LastIndex(req.From, "@")+1:]
+		if err := s.db.QueryRow(`SELECT active FROM mail_domains WHERE domain = ?`, domain).Scan(&active); err != nil || !active {
+			v.AddError("from", "must be an address in a managed domain")
+		} else if !token.MaySendFrom(req.From) {
+			v.AddError("from", "is not an allowed sender for this token")
+		}
+	}
+
+	if len(req.To) == 0 {
+		v.AddError("to", "this field is required")
+	}
+	for field, addresses := range map[string][]string{"to": req.To, "cc": req.Cc, "bcc": req.Bcc} {
+		for _, address := range addresses {
+			v.ValidateEmail(field, address)
+		}
+	}
+	recipients := len(req.To) + len(req.Cc) + len(req.Bcc)
+	if max := s.db.GetSettingInt("mail_send_max_recipients", 50); max > 0 && recipients > max {
+		v.AddErrorf("to", "too many recipients (limit is %d per message)", max)
+	}
+
+	var template *SendTemplate
+	if req.Template != "" {
+		if req.Subject != "" || req.Text != "" || req.HTML != "" {
+			v.AddError("template", "can't be combined with subject, text or html")
+			return nil
+		}
+		t, err := s.loadSendTemplate(req.Template, req.TemplateVersion)
+		if err != nil {
+			v.AddError("template", "template or version not found")
+			return nil
+		}
+		template = t
+		req.Subject, req.Text, req.HTML = t.Subject, t.Text, t.HTML
+	}
+
+	v.ValidateRequired("subject", req.Subject)
+	if req.Text == "" && req.HTML == "" {
+		v.AddError("text", "text or html is required")
+	}
+
+	var missing []string
+	req.Subject, req.Text, req.HTML, missing = sendapi.RenderMessage(req.Subject, req.Text, req.HTML, req.Variables)
+	for _, name := range missing {
+		v.AddErrorf("variables", "missing variable: %s", name)
+	}
+	// Variables end up in the Subject header, where a line break would
+	// start a header of their choosing
+	if strings.ContainsAny(req.Subject, "\r\n\x00") {
+		v.AddError("subject", "must not contain line breaks")
+	}
+	return template
+}
+
+// postAPISend sends a message for an internal application. It is handed to
+// Postfix over SMTP like webmail mail, and its delivery is followed in the
+// mail log.
+func (s *Server) postAPISend(w http.ResponseWriter, r *http.Request) {
+	token := getAPIToken(r.Context())
+
+	var req APISendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	v := NewValidator()
+	template := s.validateAPISend(v, token, &req)
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+	if smtpSender == nil {
+		http.Error(w, "Mail sending is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	msg := &mail.ComposeMessage{
+		To:        req.To,
+		Cc:        req.Cc,
+		Bcc:       req.Bcc,
+		Subject:   req.Subject,
+		Body:      req.Text,
+		HTMLBody:  req.HTML,
+		MessageID: mail.GenerateMessageID(req.From),
+	}
+	if err := s.runSendHooks(r, "api", req.From, msg); err != nil {
+		writeSendHookError(w, err)
+		return
+	}
+	s.applyDisclaimer(req.From, msg)
+	recipients := messageRecipients(msg)
+
+	var templateID, templateVersion interface{}
+	if template != nil {
+		templateID, templateVersion = template.ID, template.Version
+	}
+	result, err := s.db.Exec(`
+		INSERT INTO api_send_messages (token_id, message_id, sender, subject, recipient_count, status, template_id, template_version)
+		VALUES (?, ?, ?, ?, ?, 'accepted', ?, ?)
+	`, token.ID, msg.MessageID, req.From, msg.Subject, len(recipients), templateID, templateVersion)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to record API send")
+		http.Error(w, "Failed to record message", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+	for _, rcpt := range recipients {
+		s.db.Exec(`INSERT OR IGNORE INTO api_send_recipients (message_id, recipient) VALUES (?, ?)`, id, strings.ToLower(rcpt))
+	}
+
+	// Track before sending, as cleanup logs the Message-ID during DATA
+	if sendTracker != nil {
+		sendTracker.Track(id, msg.MessageID)
+	}
+
+	if _, err := smtpSender.Send(req.From, "", msg); err != nil {
+		s.db.Exec(`UPDATE api_send_messages SET status = 'failed', error = ? WHERE id = ?`, err.Error(), id)
+		log.Error().Err(err).Str("token", token.Name).Str("from", req.From).Msg("API send failed")
+		http.Error(w, "Failed to send message: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	log.Info().
+		Str("token", token.Name).
+		Str("from", req.From).
+		Int("recipients", len(recipients)).
+		Str("messageId", msg.MessageID).
+		Msg("API message sent")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":         id,
+		"messageId":  msg.MessageID,
+		"status":     "accepted",
+		"recipients": len(recipients),
+	})
+}
+
+// loadAPISentMessage returns a message sent through the API with its
+// recipients' delivery status
+func (s *Server) loadAPISentMessage(id int64) (*APISentMessage, int64, error) {
+	var m APISentMessage
+	var tokenID int64
+	var queueID, sendErr sql.NullString
+	err := s.db.QueryRow(`
+		SELECT id, token_id, message_id, queue_id, sender, subject, status, error, created_at
+		FROM api_send_messages WHERE id = ?
+	`, id).Scan(&m.ID, &tokenID, &m.MessageID, &queueID, &m.From, &m.Subject, &m.Status, &sendErr, &m.CreatedAt)
+	if err != nil {
+		return nil, 0, err
+	}
+	m.QueueID = queueID.String
+	m.Error = sendErr.String
+
+	rows, err := s.db.Query(`
+		SELECT recipient, status, COALESCE(dsn, ''), COALESCE(relay, ''), COALESCE(detail, ''), updated_at
+		FROM api_send_recipients WHERE message_id = ? ORDER BY recipient
+	`, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	m.Recipients = []APISentRecipient{}
+	for rows.Next() {
+		var rcpt APISentRecipient
+		if err := rows.Scan(&rcpt.Recipient, &rcpt.Status, &rcpt.DSN, &rcpt.Relay, &rcpt.Detail, &rcpt.UpdatedAt); err != nil {
+			continue
+		}
+		m.Recipients = append(m.Recipients, rcpt)
+	}
+	return &m, tokenID, nil
+}
+
+// getAPISend reports the delivery of a message the calling token sent
+func (s *Server) getAPISend(w http.ResponseWriter, r *http.Request) {
+	token := getAPIToken(r.Context())
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	m, tokenID, err := s.loadAPISentMessage(id)
+	if err != nil || tokenID != token.ID {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m)
+}
+
+// getAPITokenStats returns a token's sending statistics for the last day
+// and the last 30 days
+func (s *Server) getAPITokenStats(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "API token not found", http.StatusNotFound)
+		return
+	}
+	var name string
+	if err := s.db.QueryRow(`SELECT name FROM api_tokens WHERE id = ?`, id).Scan(&name); err != nil {
+		http.Error(w, "API token not found", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	day, err := sendapi.TokenStats(s.db.DB, id, now.Add(-24*time.Hour))
+	if err != nil {
+		http.Error(w, "Failed to load statistics", http.StatusInternalServerError)
+		return
+	}
+	month, err := sendapi.TokenStats(s.db.DB, id, now.AddDate(0, 0, -30))
+	if err != nil {
+		http.Error(w, "Failed to load statistics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      id,
+		"name":    name,
+		"last24h": day,
+		"last30d": month,
+	})
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// SendQuota is the effective outbound webmail limit for a mailbox
+type SendQuota struct {
+	MaxMessagesPerHour      int  `json:"maxMessagesPerHour"`
+	MaxRecipientsPerMessage int  `json:"maxRecipientsPerMessage"`
+	Override                bool `json:"override"`
+}
+
+// SendLimitsRequest sets per-mailbox overrides; nil fields fall back to the
+// system defaults
+type SendLimitsRequest struct {
+	MaxMessagesPerHour      *int `json:"maxMessagesPerHour"`
+	MaxRecipientsPerMessage *int `json:"maxRecipientsPerMessage"`
+}
+
+// getSendQuota returns the send quota for a mailbox: the per-mailbox
+// override where set, otherwise the mail_send_* settings
+func (s *Server) getSendQuota(email string) SendQuota {
+	quota := SendQuota{
+		MaxMessagesPerHour:      s.db.GetSettingInt("mail_send_max_per_hour", 100),
+		MaxRecipientsPerMessage: s.db.GetSettingInt("mail_send_max_recipients", 50),
+	}
+
+	var perHour, perMessage sql.NullInt64
+	err := s.db.QueryRow(`
+		SELECT l.max_messages_per_hour, l.max_recipients_per_message
+		FROM mailbox_send_limits l
+		JOIN mailboxes m ON m.id = l.mailbox_id
+		WHERE m.email = ?
+	`, email).Scan(&perHour, &perMessage)
+	if err != nil {
+		return quota
+	}
+
+	if perHour.Valid {
+		quota.MaxMessagesPerHour = int(perHour.Int64)
+		quota.Override = true
+	}
+	if perMessage.Valid {
+		quota.MaxRecipientsPerMessage = int(perMessage.Int64)
+		quota.Override = true
+	}
+	return quota
+}
+
+// sentLastHour counts messages sent by a mailbox in the last hour
+func (s *Server) sentLastHour(email string) int {
+	var count int
+	s.db.QueryRow(`
+		SELECT COUNT(*) FROM mail_send_log
+		WHERE sender_email = ? AND sent_at > datetime('now', '-1 hour')
+	`, email).Scan(&count)
+	return count
+}
+
+// reserveSend takes a slot in the mailbox's hourly quota for a message
+// with the given number of recipients. The count and the insert are one
+// statement, so concurrent sends can't all pass the check. It returns the
+// reservation, which must be released if the message isn't sent, or an
+// HTTP status and error when the quota doesn't allow the message.
+func (s *Server) reserveSend(email string, recipients int) (int64, int, error) {
+	quota := s.getSendQuota(email)
+
+	if quota.MaxRecipientsPerMessage > 0 && recipients > quota.MaxRecipientsPerMessage {
+		return 0, http.StatusBadRequest, fmt.Errorf("Too many recipients: %d (limit is %d per message)",
+			recipients, quota.MaxRecipientsPerMessage)
+	}
+
+	s.db.Exec(`DELETE FROM mail_send_log WHERE sent_at < datetime('now', '-1 day')`)
+	result, err := s.db.Exec(`
+		INSERT INTO mail_send_log (sender_email, recipient_count)
+		SELECT ?, ? WHERE ? <= 0 OR (
+			SELECT COUNT(*) FROM mail_send_log
+			WHERE sender_email = ? AND sent_at > datetime('now', '-1 hour')
+		) < ?
+	`, email, recipients, quota.MaxMessagesPerHour, email, quota.MaxMessagesPerHour)
+	if err != nil {
+		log.Error().Err(err).Str("email", email).Msg("Failed to reserve send quota")
+		return 0, http.StatusInternalServerError, fmt.Errorf("Failed to check the send quota")
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, http.StatusTooManyRequests, fmt.Errorf("Hourly send limit reached (%d messages per hour), please try again later",
+			quota.MaxMessagesPerHour)
+	}
+	id, _ := result.LastInsertId()
+	return id, 0, nil
+}
+
+// releaseSend gives back a reservation for a message that wasn't sent
+func (s *Server) releaseSend(id int64) {
+	if _, err := s.db.Exec(`DELETE FROM mail_send_log WHERE id = ?`, id); err != nil {
+		log.Error().Err(err).Int64("id", id).Msg("Failed to release send quota reservation")
+	}
+}
+
+// getMailSendQuota returns the logged-in webmail user's quota and usage
+func (s *Server) getMailSendQuota(w http.ResponseWriter, r *http.Request) {
+	session := getMailSession(r.Context())
+	if session == nil {
+		http.Error(w, "Session not found", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"quota":        s.getSendQuota(session.Email),
+		"sentLastHour": s.sentLastHour(session.Email),
+	})
+}
+
+// getMailboxSendLimits returns a mailbox's effective send quota
+func (s *Server) getMailboxSendLimits(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var email string
+	err := s.db.QueryRow("SELECT email FROM mailboxes WHERE id = ?", id).Scan(&email)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Mailbox not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"quota":        s.getSendQuota(email),
+		"sentLastHour": s.sentLastHour(email),
+	})
+}
+
+// updateMailboxSendLimits sets per-mailbox send quota overrides
+func (s *Server) updateMailboxSendLimits(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	user := GetUser(r.Context())
+
+	var req SendLimitsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.MaxMessagesPerHour != nil && *req.MaxMessagesPerHour < 0 {
+		http.Error(w, "maxMessagesPerHour cannot be negative", http.StatusBadRequest)
+		return
+	}
+	if req.MaxRecipientsPerMessage != nil && *req.MaxRecipientsPerMessage < 0 {
+		http.Error(w, "maxRecipientsPerMessage cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	var email string
+	if err := s.db.QueryRow("SELECT email FROM mailboxes WHERE id = ?", id).Scan(&email); err != nil {
+		http.Error(w, "Mailbox not found", http.StatusNotFound)
+		return
+	}
+
+	_, err := s.db.Exec(`
+		INSERT INTO mailbox_send_limits (mailbox_id, max_messages_per_hour, max_recipients_per_message, updated_at)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(mailbox_id) DO UPDATE SET
+			max_messages_per_hour = excluded.max_messages_per_hour,
+			max_recipients_per_message = excluded.max_recipients_per_message,
+			updated_at = CURRENT_TIMESTAMP
+	`, id, req.MaxMessagesPerHour, req.MaxRecipientsPerMessage)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to update send limits")
+		http.Error(w, "Failed to update send limits", http.StatusInternalServerError)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "send_limits_update", "mailbox", id, "Updated send limits for "+email, "success", "", r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"quota": s.getSendQuota(email),
+	})
+}
+
+// deleteMailboxSendLimits removes a mailbox's overrides so the defaults apply
+func (s *Server) deleteMailboxSendLimits(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	user := GetUser(r.Context())
+
+	if _, err := s.db.Exec("DELETE FROM mailbox_send_limits WHERE mailbox_id = ?", id); err != nil {
+		log.Error().Err(err).Msg("Failed to reset send limits")
+		http.Error(w, "Failed to reset send limits", http.StatusInternalServerError)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "send_limits_reset", "mailbox", id, "Reset send limits to defaults", "success", "", r)
+
+	w.WriteHeader(http.StatusNoContent)
+}
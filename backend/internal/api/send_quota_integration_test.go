@@ -0,0 +1,78 @@
+//go:build integration
+
+package api
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestReserveSendConcurrent(t *testing.T) {
+	s, _ := newConfigServer(t)
+	if err := s.db.SetSetting("mail_send_max_per_hour", "5"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Twenty sends in flight at once get five slots between them
+	var mu sync.Mutex
+	var reserved []int64
+	refused := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, status, err := s.reserveSend("carol@example.com", 1)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				reserved = append(reserved, id)
+			case status == http.StatusTooManyRequests:
+				refused++
+			default:
+				t.Errorf("reserveSend: %d %v", status, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(reserved) != 5 || refused != 15 {
+		t.Fatalf("%d reserved, %d refused; want 5 and 15", len(reserved), refused)
+	}
+	if n := s.sentLastHour("carol@example.com"); n != 5 {
+		t.Errorf("sentLastHour = %d, want 5", n)
+	}
+
+	// A send that fails gives its slot back
+	s.releaseSend(reserved[0])
+	if _, _, err := s.reserveSend("carol@example.com", 1); err != nil {
+		t.Errorf("after a release: %v", err)
+	}
+	if _, status, err := s.reserveSend("carol@example.com", 1); status != http.StatusTooManyRequests {
+		t.Errorf("over the limit: %d %v, want 429", status, err)
+	}
+	// Other mailboxes have their own quota
+	if _, _, err := s.reserveSend("dave@example.com", 1); err != nil {
+		t.Errorf("another mailbox: %v", err)
+	}
+}
+
+func TestReserveSendLimits(t *testing.T) {
+	s, _ := newConfigServer(t)
+	s.db.SetSetting("mail_send_max_recipients", "3")
+	s.db.SetSetting("mail_send_max_per_hour", "0")
+
+	if _, status, _ := s.reserveSend("carol@example.com", 4); status != http.StatusBadRequest {
+		t.Errorf("4 recipients: status %d, want 400", status)
+	}
+	if n := s.sentLastHour("carol@example.com"); n != 0 {
+		t.Errorf("a refused message took a slot")
+	}
+	// Zero is no hourly limit
+	for i := 0; i < 10; i++ {
+		if _, _, err := s.reserveSend("carol@example.com", 3); err != nil {
+			t.Fatalf("send %d without an hourly limit: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/sendapi"
+	"github.com/rs/zerolog/log"
+)
+
+// SendTemplate is a stored message template for the send API, at one of
+// its versions
+type SendTemplate struct {
+	ID             int64     `json:"id"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	Version        int       `json:"version"`
+	CurrentVersion int       `json:"currentVersion"`
+	Subject        string    `json:"subject"`
+	Text           string    `json:"text"`
+	HTML           string    `json:"html"`
+	Variables      []string  `json:"variables"`
+	CreatedBy      string    `json:"createdBy"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// SendTemplateVersion is one saved version of a template
+type SendTemplateVersion struct {
+	Version   int       `json:"version"`
+	Subject   string    `json:"subject"`
+	Text      string    `json:"text"`
+	HTML      string    `json:"html"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// sendTemplateName is what a template name may contain; apps refer to
+// templates by name
+var sendTemplateName = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// loadSendTemplate returns a template at a version, or at its current
+// version if version is 0. It returns sql.ErrNoRows if either doesn't exist.
+func (s *Server) loadSendTemplate(name string, version int) (*SendTemplate, error) {
+	var t SendTemplate
+	err := s.db.QueryRow(`
+		SELECT id, name, description, current_version, created_by, created_at, updated_at
+		FROM send_templates WHERE name = ?
+	`, name).Scan(&t.ID, &t.Name, &t.Description, &t.CurrentVersion, &t.CreatedBy, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Version = version
+	if version == 0 {
+		t.Version = t.CurrentVersion
+	}
+	err = s.db.QueryRow(`
+		SELECT subject, text_body, html_body FROM send_template_versions
+		WHERE template_id = ? AND version = ?
+	`, t.ID, t.Version).Scan(&t.Subject, &t.Text, &t.HTML)
+	if err != nil {
+		return nil, err
+	}
+	t.Variables = sendapi.Variables(t.Subject, t.Text, t.HTML)
+	return &t, nil
+}
+
+// decodeSendTemplate reads and validates a template from the request body
+func decodeSendTemplate(w http.ResponseWriter, r *http.Request, requireName bool) (*SendTemplate, bool) {
+	var t SendTemplate
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return nil, false
+	}
+	t.Name = strings.TrimSpace(t.Name)
+
+	v := NewValidator()
+	if requireName {
+		v.ValidateRequired("name", t.Name)
+		if t.Name != "" && !sendTemplateName.MatchString(t.Name) {
+			v.AddError("name", "must be up to 64 lowercase letters, digits, dots, dashes or underscores")
+		}
+	}
+	v.ValidateMaxLength("description", t.Description, 500)
+	v.ValidateRequired("subject", t.Subject)
+	if t.Text == "" && t.HTML == "" {
+		v.AddError("text", "text or html is required")
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return nil, false
+	}
+	return &t, true
+}
+
+// listSendTemplates returns every template at its current version
+func (s *Server) listSendTemplates(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(`SELECT name FROM send_templates ORDER BY name`)
+	if err != nil {
+		http.Error(w, "Failed to load templates", http.StatusInternalServerError)
+		return
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil {
+			names = append(names, name)
+		}
+	}
+	rows.Close()
+
+	templates := []SendTemplate{}
+	for _, name := range names {
+		if t, err := s.loadSendTemplate(name, 0); err == nil {
+			templates = append(templates, *t)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templates)
+}
+
+// createSendTemplate stores a new template as version 1
+func (s *Server) createSendTemplate(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	t, ok := decodeSendTemplate(w, r, true)
+	if !ok {
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to create template", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO send_templates (name, description, current_version, created_by) VALUES (?, ?, 1, ?)
+	`, t.Name, t.Description, user.Username)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint") {
+			http.Error(w, "A template with this name already exists", http.StatusConflict)
+			return
+		}
+		log.Error().Err(err).Msg("Failed to create send template")
+		http.Error(w, "Failed to create template", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+	if _, err := tx.Exec(`
+		INSERT INTO send_template_versions (template_id, version, subject, text_body, html_body, created_by)
+		VALUES (?, 1, ?, ?, ?, ?)
+	`, id, t.Subject, t.Text, t.HTML, user.Username); err != nil {
+		http.Error(w, "Failed to create template", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to create template", http.StatusInternalServerError)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "send_template_create", "send_template", t.Name,
+		"Created send template "+t.Name, "success", "", r)
+
+	created, err := s.loadSendTemplate(t.Name, 0)
+	if err != nil {
+		http.Error(w, "Failed to load template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// getSendTemplate returns a template at its current version, or at the
+// version in the query string
+func (s *Server) getSendTemplate(w http.ResponseWriter, r *http.Request) {
+	version, _ := strconv.Atoi(r.URL.Query().Get("version"))
+	t, err := s.loadSendTemplate(chi.URLParam(r, "name"), version)
+	if err != nil {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+// updateSendTemplate saves an edit as a new version, which becomes current.
+// Changing only the description keeps the version.
+func (s *Server) updateSendTemplate(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	current, err := s.loadSendTemplate(chi.URLParam(r, "name"), 0)
+	if err != nil {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+	t, ok := decodeSendTemplate(w, r, false)
+	if !ok {
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "Failed to update template", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	version := current.CurrentVersion
+	if t.Subject != current.Subject || t.Text != current.Text || t.HTML != current.HTML {
+		version++
+		if _, err := tx.Exec(`
+			INSERT INTO send_template_versions (template_id, version, subject, text_body, html_body, created_by)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, current.ID, version, t.Subject, t.Text, t.HTML, user.Username); err != nil {
+			http.Error(w, "Failed to update template", http.StatusInternalServerError)
+			return
+		}
+	}
+	if _, err := tx.Exec(`
+		UPDATE send_templates SET description = ?, current_version = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?
+	`, t.Description, version, current.ID); err != nil {
+		http.Error(w, "Failed to update template", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update template", http.StatusInternalServerError)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "send_template_update", "send_template", current.Name,
+		"Updated send template "+current.Name+" to version "+strconv.Itoa(version), "success", "", r)
+
+	updated, err := s.loadSendTemplate(current.Name, 0)
+	if err != nil {
+		http.Error(w, "Failed to load template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// deleteSendTemplate removes a template and its versions. Messages already
+// sent with it keep their record.
+func (s *Server) deleteSendTemplate(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	name := chi.URLParam(r, "name")
+
+	var id int64
+	if err := s.db.QueryRow(`SELECT id FROM send_templates WHERE name = ?`, name).Scan(&id); err != nil {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+	s.db.Exec(`DELETE FROM send_template_versions WHERE template_id = ?`, id)
+	if _, err := s.db.Exec(`DELETE FROM send_templates WHERE id = ?`, id); err != nil {
+		http.Error(w, "Failed to delete template", http.StatusInternalServerError)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "send_template_delete", "send_template", name,
+		"Deleted send template "+name, "success", "", r)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listSendTemplateVersions returns a template's versions, newest first
+func (s *Server) listSendTemplateVersions(w http.ResponseWriter, r *http.Request) {
+	var id int64
+	if err := s.db.QueryRow(`SELECT id FROM send_templates WHERE name = ?`, chi.URLParam(r, "name")).Scan(&id); err != nil {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := s.db.Query(`
+		SELECT version, subject, text_body, html_body, created_by, created_at
+		FROM send_template_versions WHERE template_id = ? ORDER BY version DESC
+	`, id)
+	if err != nil {
+		http.Error(w, "Failed to load versions", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	versions := []SendTemplateVersion{}
+	for rows.Next() {
+		var v SendTemplateVersion
+		if err := rows.Scan(&v.Version, &v.Subject, &v.Text, &v.HTML, &v.CreatedBy, &v.CreatedAt); err == nil {
+			versions = append(versions, v)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// renderSendTemplate fills a template in with test variables, without
+// sending it, and reports the variables that had no value
+func (s *Server) renderSendTemplate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Version   int               `json:"version"`
+		Variables map[string]string `json:"variables"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	t, err := s.loadSendTemplate(chi.URLParam(r, "name"), req.Version)
+	if err != nil {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+
+	subject, text, html, missing := sendapi.RenderMessage(t.Subject, t.Text, t.HTML, req.Variables)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":      t.Name,
+		"version":   t.Version,
+		"subject":   subject,
+		"text":      text,
+		"html":      html,
+		"variables": t.Variables,
+		"missing":   missing,
+	})
+}
+
+// getSendTemplateStats returns a template's sending statistics for the
+// last day and the last 30 days, over all its versions
+func (s *Server) getSendTemplateStats(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	var id int64
+	if err := s.db.QueryRow(`SELECT id FROM send_templates WHERE name = ?`, name).Scan(&id); err != nil {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	day, err := sendapi.TemplateStats(s.db.DB, id, now.Add(-24*time.Hour))
+	if err != nil {
+		http.Error(w, "Failed to load statistics", http.StatusInternalServerError)
+		return
+	}
+	month, err := sendapi.TemplateStats(s.db.DB, id, now.AddDate(0, 0, -30))
+	if err != nil {
+		http.Error(w, "Failed to load statistics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":    name,
+		"last24h": day,
+		"last30d": month,
+	})
+}
@@ -0,0 +1,346 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/rs/zerolog/log"
+)
+
+// SenderClassRecord is a sender class as stored
+type SenderClassRecord struct {
+	ID int64 `json:"id"`
+	postfix.SenderClass
+	Description string    `json:"description"`
+	Transport   string    `json:"transport"`
+	UpdatedBy   string    `json:"updatedBy,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// SenderClassRequest creates or replaces a sender class
+type SenderClassRequest struct {
+	Name                   string   `json:"name"`
+	Description            string   `json:"description"`
+	Senders                []string `json:"senders"`
+	ProcessLimit           int      `json:"processLimit"`
+	DestinationConcurrency int      `json:"destinationConcurrency"`
+	RateDelay              string   `json:"rateDelay"`
+	Enabled                *bool    `json:"enabled"`
+}
+
+// loadSenderClasses returns the sender classes, by name
+func (s *Server) loadSenderClasses() ([]SenderClassRecord, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, description, senders, process_limit, destination_concurrency,
+			rate_delay, enabled, COALESCE(updated_by, ''), updated_at
+		FROM sender_classes ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	classes := []SenderClassRecord{}
+	for rows.Next() {
+		var c SenderClassRecord
+		var senders string
+		if err := rows.Scan(&c.ID, &c.Name, &c.Description, &senders, &c.ProcessLimit, &c.DestinationConcurrency,
+			&c.RateDelay, &c.Enabled, &c.UpdatedBy, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(senders), &c.Senders)
+		if c.Senders == nil {
+			c.Senders = []string{}
+		}
+		c.Transport = c.SenderClass.Transport()
+		classes = append(classes, c)
+	}
+	return classes, rows.Err()
+}
+
+// senderClassesOf strips the records down to what Postfix is given
+func senderClassesOf(records []SenderClassRecord) []postfix.SenderClass {
+	classes := make([]postfix.SenderClass, 0, len(records))
+	for _, c := range records {
+		classes = append(classes, c.SenderClass)
+	}
+	return classes
+}
+
+// syncSenderClasses rewrites the sender transport map, the classes'
+// services and their limits from the database
+func (s *Server) syncSenderClasses() error {
+	records, err := s.loadSenderClasses()
+	if err != nil {
+		return err
+	}
+	return postfixMgr.SaveSenderClasses(senderClassesOf(records))
+}
+
+// applySenderClasses syncs the sender classes and reloads Postfix, which
+// picks up the master.cf services too
+func (s *Server) applySenderClasses() error {
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+	if err := s.syncSenderClasses(); err != nil {
+		return err
+	}
+	return postfixMgr.Reload()
+}
+
+// senderClass builds the class a request describes, validating it against
+// the other classes: a sender can only belong to one
+func (req *SenderClassRequest) senderClass(others []SenderClassRecord) (SenderClassRecord, *Validator) {
+	c := SenderClassRecord{
+		SenderClass: postfix.SenderClass{
+			Name:                   strings.ToLower(strings.TrimSpace(req.Name)),
+			Senders:                []string{},
+			ProcessLimit:           req.ProcessLimit,
+			DestinationConcurrency: req.DestinationConcurrency,
+			RateDelay:              strings.TrimSpace(req.RateDelay),
+			Enabled:                req.Enabled == nil || *req.Enabled,
+		},
+		Description: strings.TrimSpace(req.Description),
+	}
+	c.Transport = c.SenderClass.Transport()
+
+	v := NewValidator()
+	if !postfix.ValidSenderClassName(c.Name) {
+		v.AddError("name", "must start with a letter and contain only lowercase letters, digits and underscores (at most 30)")
+	}
+	v.ValidateMaxLength("description", c.Description, 500)
+
+	claimed := map[string]string{}
+	for _, other := range others {
+		for _, sender := range other.Senders {
+			claimed[sender] = other.Name
+		}
+	}
+	seen := map[string]bool{}
+	for i, sender := range req.Senders {
+		sender = strings.ToLower(strings.TrimSpace(sender))
+		if sender == "" {
+			continue
+		}
+		field := fmt.Sprintf("senders[%d]", i)
+		v.ValidateSenderPattern(field, sender)
+		// Sender-dependent lookups try user@domain, then @domain; a bare
+		// domain would never match
+		if !strings.Contains(sender, "@") {
+			sender = "@" + sender
+		}
+		if class, ok := claimed[sender]; ok {
+			v.AddErrorf(field, "%s already belongs to sender class %s", sender, class)
+		}
+		if !seen[sender] {
+			seen[sender] = true
+			c.Senders = append(c.Senders, sender)
+		}
+	}
+	if len(c.Senders) == 0 {
+		v.AddError("senders", "at least one sender is required")
+	}
+
+	if c.ProcessLimit < 0 {
+		v.AddError("processLimit", "must be 0 (the default) or more")
+	}
+	if c.DestinationConcurrency < 0 {
+		v.AddError("destinationConcurrency", "must be 0 (the default) or more")
+	}
+	if c.RateDelay != "" && !postfix.ValidPostfixTime(c.RateDelay) {
+		v.AddError("rateDelay", "must be a Postfix time such as 1s or 5m")
+	}
+	return c, v
+}
+
+// getSenderClasses returns the sender classes
+func (s *Server) getSenderClasses(w http.ResponseWriter, r *http.Request) {
+	classes, err := s.loadSenderClasses()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load sender classes")
+		http.Error(w, "Failed to load sender classes", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"senderClasses": classes,
+	})
+}
+
+// createSenderClass adds a sender class and sets up its transport
+func (s *Server) createSenderClass(w http.ResponseWriter, r *http.Request) {
+	s.saveSenderClass(w, r, "")
+}
+
+// updateSenderClass replaces the sender class in the URL. The name can't
+// change, as it names the transport.
+func (s *Server) updateSenderClass(w http.ResponseWriter, r *http.Request) {
+	s.saveSenderClass(w, r, strings.ToLower(chi.URLParam(r, "name")))
+}
+
+// saveSenderClass creates a class, or with name replaces that one, then
+// rewrites the Postfix configuration for every class
+func (s *Server) saveSenderClass(w http.ResponseWriter, r *http.Request, name string) {
+	user := GetUser(r.Context())
+
+	var req SenderClassRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if name != "" {
+		if req.Name != "" && strings.ToLower(req.Name) != name {
+			http.Error(w, "A sender class can't be renamed", http.StatusBadRequest)
+			return
+		}
+		req.Name = name
+	}
+
+	existing, err := s.loadSenderClasses()
+	if err != nil {
+		http.Error(w, "Failed to load sender classes", http.StatusInternalServerError)
+		return
+	}
+	var before *SenderClassRecord
+	others := []SenderClassRecord{}
+	for i := range existing {
+		if existing[i].Name == strings.ToLower(strings.TrimSpace(req.Name)) {
+			before = &existing[i]
+		} else {
+			others = append(others, existing[i])
+		}
+	}
+	if name != "" && before == nil {
+		http.Error(w, "Sender class not found", http.StatusNotFound)
+		return
+	}
+	if name == "" && before != nil {
+		http.Error(w, "A sender class with this name already exists", http.StatusConflict)
+		return
+	}
+
+	c, v := req.senderClass(others)
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	if isDryRun(r) {
+		if postfixMgr == nil {
+			postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+		}
+		files, err := postfixMgr.PreviewSenderClasses(senderClassesOf(append(others, c)))
+		if err != nil {
+			http.Error(w, "Failed to preview sender classes: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeDryRun(w, DryRunResult{
+			Summary: fmt.Sprintf("Would route %d sender(s) through %s", len(c.Senders), c.Transport),
+			Files:   files,
+		})
+		return
+	}
+
+	senders, _ := json.Marshal(c.Senders)
+	if before == nil {
+		res, err := s.db.Exec(`
+			INSERT INTO sender_classes (name, description, senders, process_limit, destination_concurrency,
+				rate_delay, enabled, updated_by)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, c.Name, c.Description, string(senders), c.ProcessLimit, c.DestinationConcurrency,
+			c.RateDelay, c.Enabled, user.Username)
+		if err == nil {
+			c.ID, _ = res.LastInsertId()
+		}
+	} else {
+		c.ID = before.ID
+		_, err = s.db.Exec(`
+			UPDATE sender_classes SET description = ?, senders = ?, process_limit = ?, destination_concurrency = ?,
+				rate_delay = ?, enabled = ?, updated_by = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, c.Description, string(senders), c.ProcessLimit, c.DestinationConcurrency,
+			c.RateDelay, c.Enabled, user.Username, c.ID)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("class", c.Name).Msg("Failed to save sender class")
+		http.Error(w, "Failed to save sender class", http.StatusInternalServerError)
+		return
+	}
+	if err := s.applySenderClasses(); err != nil {
+		http.Error(w, "Failed to apply sender classes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	c.UpdatedBy, c.UpdatedAt = user.Username, time.Now().UTC()
+	action, summary, status := "create", "Created sender class "+c.Name, http.StatusCreated
+	if before != nil {
+		action, summary, status = "update", "Updated sender class "+c.Name, http.StatusOK
+		s.logAuditDiff(user, action, "sender_class", c.Name, summary, auditDiff(before.SenderClass, c.SenderClass), r)
+	} else {
+		s.logAuditDiff(user, action, "sender_class", c.Name, summary, auditDiff(nil, c.SenderClass), r)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(c)
+}
+
+// deleteSenderClass removes a sender class; its senders go back to the
+// default transport
+func (s *Server) deleteSenderClass(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	name := strings.ToLower(chi.URLParam(r, "name"))
+
+	existing, err := s.loadSenderClasses()
+	if err != nil {
+		http.Error(w, "Failed to load sender classes", http.StatusInternalServerError)
+		return
+	}
+	var before *SenderClassRecord
+	remaining := []SenderClassRecord{}
+	for i := range existing {
+		if existing[i].Name == name {
+			before = &existing[i]
+		} else {
+			remaining = append(remaining, existing[i])
+		}
+	}
+	if before == nil {
+		http.Error(w, "Sender class not found", http.StatusNotFound)
+		return
+	}
+
+	if isDryRun(r) {
+		if postfixMgr == nil {
+			postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+		}
+		files, err := postfixMgr.PreviewSenderClasses(senderClassesOf(remaining))
+		if err != nil {
+			http.Error(w, "Failed to preview sender classes: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeDryRun(w, DryRunResult{
+			Summary: fmt.Sprintf("Would return %d sender(s) to the default transport", len(before.Senders)),
+			Files:   files,
+		})
+		return
+	}
+
+	if _, err := s.db.Exec(`DELETE FROM sender_classes WHERE id = ?`, before.ID); err != nil {
+		http.Error(w, "Failed to delete sender class", http.StatusInternalServerError)
+		return
+	}
+	if err := s.applySenderClasses(); err != nil {
+		http.Error(w, "Failed to apply sender classes: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.logAuditDiff(user, "delete", "sender_class", name, "Deleted sender class "+name, auditDiff(before.SenderClass, nil), r)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"net/http"
@@ -15,9 +16,13 @@ import (
 	"github.com/gorilla/csrf"
 	"github.com/postfixrelay/postfixrelay/internal/config"
 	"github.com/postfixrelay/postfixrelay/internal/database"
+	"github.com/postfixrelay/postfixrelay/internal/demo"
 	"github.com/postfixrelay/postfixrelay/internal/dovecot"
+	"github.com/postfixrelay/postfixrelay/internal/features"
+	"github.com/postfixrelay/postfixrelay/internal/hooks"
+	"github.com/postfixrelay/postfixrelay/internal/scheduler"
+	"github.com/postfixrelay/postfixrelay/internal/supervisor"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/time/rate"
 )
 
 // Server holds the API server dependencies
@@ -25,6 +30,24 @@ type Server struct {
 	cfg          *config.Config
 	db           *database.DB
 	dovecotSyncer *dovecot.Syncer
+
+	// workers runs background work started by handlers
+	workers *supervisor.Supervisor
+
+	// features says which experimental subsystems are switched on
+	features *features.Store
+
+	// hooks runs site-specific plugins and external hooks at the
+	// extension points
+	hooks *hooks.Runner
+
+	// scheduler runs recurring jobs on their cron schedules
+	scheduler *scheduler.Scheduler
+
+	// drainCh is closed when the server starts shutting down so that
+	// long-lived streaming handlers can say goodbye and return
+	drainCh   chan struct{}
+	drainOnce sync.Once
 }
 
 // NewServer creates a new API server
@@ -34,20 +57,143 @@ func NewServer(cfg *config.Config, db *database.DB) *Server {
 	if path := os.Getenv("DOVECOT_PASSWD_FILE"); path != "" {
 		dovecotCfg.DovecotPasswdFile = path
 	}
+	if path := os.Getenv("DOVECOT_CONF_DIR"); path != "" {
+		dovecotCfg.DovecotConfDir = path
+	}
+	if path := os.Getenv("DOVECOT_APP_PASSWORD_DIR"); path != "" {
+		dovecotCfg.DovecotAppPasswordDir = path
+	}
 	if path := os.Getenv("POSTFIX_VMAILBOX_FILE"); path != "" {
 		dovecotCfg.PostfixVirtualMailbox = path
 	}
 	if path := os.Getenv("POSTFIX_VIRTUAL_FILE"); path != "" {
 		dovecotCfg.PostfixVirtualAlias = path
 	}
+	if path := os.Getenv("POSTFIX_ARCHIVE_BCC_FILE"); path != "" {
+		dovecotCfg.PostfixArchiveBCC = path
+	}
 	if path := os.Getenv("MAIL_DIR"); path != "" {
 		dovecotCfg.MailDir = path
 	}
 
-	return &Server{
+	s := &Server{
 		cfg:           cfg,
 		db:            db,
 		dovecotSyncer: dovecot.NewSyncer(db.DB, dovecotCfg),
+		drainCh:       make(chan struct{}),
+		workers:       supervisor.New(),
+		features:      features.NewStore(db.DB),
+		hooks:         hooks.NewRunner(db.DB, cfg.HooksDir),
+	}
+	s.dovecotSyncer.SetAliasHook(s.hooks.ResolveAliases)
+	s.scheduler = scheduler.New(db.DB, s.runScheduledTask)
+
+	// Demo mode serves a made-up queue so nothing reaches Postfix
+	if cfg.Demo {
+		s.initQueueManager()
+		if cfg.DemoQueueSize > 0 {
+			queueMgr.UseDemoQueue(demo.LargeQueue(time.Now(), cfg.DemoQueueSize))
+		} else {
+			queueMgr.UseDemoQueue(demo.Queue(time.Now()))
+		}
+	}
+
+	// Apply runtime settings and follow later changes
+	s.applyRateLimitSettings()
+	s.loadMapTypes()
+	s.loadLogoOrigins()
+	s.subscribeSettings()
+
+	// Background jobs
+	s.startDigestScheduler()
+	s.startCanary()
+	s.startSearchScheduler()
+	s.startQueueSampler()
+	s.startHostSampler()
+	s.startHostChecker()
+	s.startReleaseChecker()
+	s.startMailboxSampler()
+	s.startMailCleanup()
+	s.startReplicationMonitor()
+	s.startRetentionPruner()
+	s.startCleanupJobs()
+	s.startArchive()
+	s.startSNMPAgent()
+	s.startSMTPSink()
+	s.startLogPipeline()
+	s.initAlertEngine()
+	s.scheduler.Start()
+
+	return s
+}
+
+// Drain signals streaming connections (log SSE/WebSocket) to send a
+// shutdown event and close. Call before http.Server.Shutdown, which
+// otherwise waits on them until its deadline.
+func (s *Server) Drain() {
+	s.drainOnce.Do(func() {
+		close(s.drainCh)
+		log.Info().Msg("Draining streaming connections")
+	})
+}
+
+// Close stops background subsystems started by the API: the alert engine,
+// the log reader and webmail IMAP sessions. Call after the HTTP server has
+// shut down and before closing the database.
+func (s *Server) Close() {
+	if alertEngine != nil {
+		alertEngine.Stop()
+	}
+	if digestScheduler != nil {
+		digestScheduler.Stop()
+	}
+	if canaryMonitor != nil {
+		canaryMonitor.Stop()
+	}
+	if searchScheduler != nil {
+		searchScheduler.Stop()
+	}
+	if queueSampler != nil {
+		queueSampler.Stop()
+	}
+	if hostSampler != nil {
+		hostSampler.Stop()
+	}
+	if hostChecker != nil {
+		hostChecker.Stop()
+	}
+	if releaseChecker != nil {
+		releaseChecker.Stop()
+	}
+	if mailboxSampler != nil {
+		mailboxSampler.Stop()
+	}
+	if mailCleanup != nil {
+		mailCleanup.Stop()
+	}
+	if replicationMonitor != nil {
+		replicationMonitor.Stop()
+	}
+	s.scheduler.Stop()
+	s.stopSNMPAgent()
+	s.stopSMTPSink()
+	s.stopArchiveReceiver()
+	s.stopLogPipeline()
+	logReaderMu.Lock()
+	if logReader != nil {
+		logReader.Stop()
+	}
+	logReaderMu.Unlock()
+	if mailSessionManager != nil {
+		mailSessionManager.Close()
+	}
+
+	// Let syncs started by the last requests finish before the database
+	// is closed under them
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.workers.Stop(ctx); err != nil {
+		log.Warn().Err(err).Msg("Background tasks still running at shutdown")
 	}
 }
 
@@ -57,12 +203,15 @@ func (s *Server) Router() http.Handler {
 
 	// Middleware
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(s.realIPMiddleware) // Client IP, from trusted proxies' headers
 	r.Use(s.loggerMiddleware)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Timeout(60 * time.Second))
 	r.Use(s.rateLimitMiddleware)        // Global rate limiting
 	r.Use(s.securityHeadersMiddleware)  // Security headers
+	r.Use(s.localeMiddleware)           // Accept-Language negotiation
+	// API latencies for /metrics
+	r.Use(apiLatency.Middleware)
 
 	// CORS - configure from environment in production
 	allowedOrigins := s.getAllowedOrigins()
@@ -77,12 +226,11 @@ func (s *Server) Router() http.Handler {
 
 	// CSRF Protection - derive key from AppSecret
 	csrfKey := s.deriveCSRFKey()
-	isSecure := os.Getenv("ENV") == "production"
 	csrfMiddleware := csrf.Protect(
 		csrfKey,
-		csrf.Secure(isSecure),
+		csrf.Secure(s.cookieSecure(nil)),
 		csrf.HttpOnly(true),
-		csrf.SameSite(csrf.SameSiteStrictMode),
+		csrf.SameSite(s.csrfSameSite()),
 		csrf.Path("/"),
 		csrf.ErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			log.Warn().
@@ -101,61 +249,139 @@ func (s *Server) Router() http.Handler {
 	r.Get("/healthz", s.healthz)
 	r.Get("/readyz", s.readyz)
 
+	// Prometheus metrics, for scrapers presenting metrics_token
+	r.With(s.settingTokenAuth("metrics_token", "metrics")).Get("/metrics", s.getMetrics)
+
+	// Mail client autoconfiguration (no auth): Thunderbird fetches
+	// autoconfig.<domain>/mail/config-v1.1.xml or the well-known path,
+	// Outlook posts to autodiscover.<domain>/autodiscover/autodiscover.xml
+	r.Get("/mail/config-v1.1.xml", s.getAutoconfig)
+	r.Get("/.well-known/autoconfig/mail/config-v1.1.xml", s.getAutoconfig)
+	r.Post("/autodiscover/autodiscover.xml", s.postAutodiscover)
+	r.Post("/Autodiscover/Autodiscover.xml", s.postAutodiscover)
+
 	// API routes
 	r.Route("/api/v1", func(r chi.Router) {
 		// CSRF token endpoint (no auth required, but CSRF protected)
 		r.Get("/csrf-token", s.getCSRFToken)
 
+		// Branding for the login page and navigation (no auth required)
+		r.Get("/branding", s.getBranding)
+
 		// Setup routes (no auth required, only work when no admin exists)
 		r.Get("/setup/status", s.getSetupStatus)
-		r.Post("/setup/complete", s.completeSetup)
+		r.With(s.loginRateLimitMiddleware).Post("/setup/complete", s.completeSetup)
+
+		// Grafana JSON datasource, for clients presenting grafana_token
+		r.Route("/grafana", func(r chi.Router) {
+			r.Use(s.settingTokenAuth("grafana_token", "grafana"))
+			r.Get("/", s.grafanaTest)
+			r.Post("/search", s.grafanaSearch)
+			r.Post("/query", s.grafanaQuery)
+			r.Post("/annotations", s.grafanaAnnotations)
+		})
+
+		// Alert actions from chat integrations (HMAC-signed, see
+		// alert_actions.go)
+		r.Post("/alerts/actions", s.alertActionWebhook)
+
+		// Integration API for external systems (scoped API tokens, see
+		// api_tokens.go)
+		r.Route("/integrations", func(r chi.Router) {
+			r.With(s.apiTokenAuth(ScopeRecipientCheck)).Post("/recipient-check", s.postRecipientCheck)
+		})
+
+		// REST send API for internal applications (API token with the send
+		// scope, see send_api_handlers.go)
+		r.Route("/send", func(r chi.Router) {
+			r.Use(s.apiTokenAuth(ScopeSend))
+			r.Post("/", s.apiSendRateLimit(s.postAPISend))
+			r.Get("/{id}", s.getAPISend)
+		})
 
 		// Auth routes (no auth required)
-		r.Post("/auth/login", s.login)
+		r.With(s.loginRateLimitMiddleware).Post("/auth/login", s.login)
 
 		// Protected routes
 		r.Group(func(r chi.Router) {
 			r.Use(s.authMiddleware)
+			r.Use(s.userRateLimitMiddleware)
+			r.Use(s.tenantScopeMiddleware)
 
 			// Auth
 			r.Post("/auth/logout", s.logout)
 			r.Get("/auth/me", s.me)
+			r.Get("/auth/login-history", s.getMyLoginHistory)
 			r.Put("/auth/password", s.changePassword)
+			r.Get("/auth/notification-preferences", s.getNotificationPreferences)
+			r.Put("/auth/notification-preferences", s.updateNotificationPreferences)
 
 			// Status
 			r.Get("/status", s.getStatus)
+			r.Get("/dashboard", s.getDashboard)
+			r.Get("/features", s.getFeatures)
 
 			// Config
 			r.Route("/config", func(r chi.Router) {
 				r.Get("/", s.getConfig)
 				r.Get("/full", s.adminOnly(s.getConfigFull))
-				// Legacy direct update (deprecated - use submit/apply workflow)
-				r.Put("/", s.adminOnly(s.updateConfig))
+				// Legacy update, which now stages like submit
+				r.Put("/", s.adminOnly(s.submitConfig))
 				// New submit/apply workflow
 				r.Get("/staged", s.getStagedConfig)
-				r.Post("/submit", s.adminOnly(s.submitConfig))
+				r.Post("/submit", s.operatorOnly(s.submitConfig))
 				r.Delete("/staged", s.adminOnly(s.discardStagedConfig))
+				r.Post("/staged/approve", s.adminOnly(s.approveStagedConfig))
+				r.Post("/staged/reject", s.adminOnly(s.rejectStagedConfig))
 				r.Get("/staged/diff", s.getStagedDiff)
+				r.Get("/staged/maps", s.getStagedMaps)
+				r.Post("/staged/maps/apply", s.adminOnly(s.applyStagedMaps))
+				r.Delete("/staged/maps", s.adminOnly(s.discardStagedMaps))
+				r.Get("/routing/lint", s.lintRouting)
+				r.Get("/smuggling/lint", s.lintSMTPSmuggling)
 				// Validation and apply
 				r.Post("/validate", s.adminOnly(s.validateConfig))
 				r.Post("/apply", s.adminOnly(s.applyConfig))
 				r.Post("/rollback/{version}", s.adminOnly(s.rollbackConfig))
 				r.Get("/history", s.getConfigHistory)
+				r.Get("/history/search", s.searchConfigHistory)
 				r.Get("/history/{version}", s.getConfigVersion)
+				r.Post("/history/{version}/tags", s.adminOnly(s.tagConfigVersion))
+				r.Delete("/tags/{tag}", s.adminOnly(s.deleteConfigTag))
 				// Certificate management
 				r.Get("/certificates", s.getCertificates)
 				r.Post("/certificates", s.adminOnly(s.uploadCertificate))
 				r.Delete("/certificates/{type}", s.adminOnly(s.deleteCertificate))
 				// Credentials management
+				r.Get("/credentials", s.adminOnly(s.getCredentials))
 				r.Post("/credentials", s.adminOnly(s.saveCredentials))
+				r.Delete("/credentials/{key}", s.adminOnly(s.deleteCredentials))
+				// Adopting an existing install's maps
+				r.Get("/import", s.adminOnly(s.getMapImport))
+				r.Post("/import", s.adminOnly(s.importMaps))
 			})
 
-			// Logs
+			// Message traces by queue ID, for links from alerts and stats
+			r.Get("/trace", s.requirePermission(PermViewLogs)(s.getTraces))
+
+			// Per-recipient delivery status correlated from the mail log
+			r.Get("/deliveries", s.requirePermission(PermViewLogs)(s.getDeliveries))
+
+			// Mail flow from sources through relays to destinations
+			r.Get("/reports/flow", s.getFlowReport)
+
+			// Logs; addresses are masked for roles without view:addresses
 			r.Route("/logs", func(r chi.Router) {
-				r.Get("/", s.getLogs)
-				r.Get("/stream", s.streamLogs) // WebSocket
-				r.Get("/queue/{queueId}", s.getLogsByQueueId)
-				r.Get("/export", s.exportLogs)
+				viewLogs := s.requirePermission(PermViewLogs)
+				r.Get("/", viewLogs(s.getLogs))
+				r.Get("/search", viewLogs(s.searchLogs))
+				r.Get("/stream", viewLogs(s.streamLogs)) // WebSocket
+				r.Get("/queue/{queueId}", viewLogs(s.getLogsByQueueId))
+				r.Get("/export", viewLogs(s.exportRateLimit(s.exportLogs)))
+				r.Get("/exports", viewLogs(s.listLogExports))
+				r.Get("/exports/{name}", s.requirePermission(PermViewAddresses)(s.downloadLogExport))
+				r.Get("/connections", viewLogs(s.getConnectionStats))
+				r.Get("/tls", viewLogs(s.getTLSReport))
 			})
 
 			// Alerts
@@ -166,18 +392,48 @@ func (s *Server) Router() http.Handler {
 				r.Post("/{id}/silence", s.operatorOnly(s.silenceAlert))
 				r.Get("/rules", s.getAlertRules)
 				r.Put("/rules/{id}", s.adminOnly(s.updateAlertRule))
+				r.Get("/rules/{id}/runbook", s.getRuleRunbook)
+				r.Put("/rules/{id}/runbook", s.adminOnly(s.updateRuleRunbook))
+				r.Delete("/rules/{id}/runbook", s.adminOnly(s.deleteRuleRunbook))
+				r.Post("/rules/{id}/runbook/review", s.adminOnly(s.reviewRuleRunbook))
 				r.Get("/runbook/{type}", s.getRunbook)
+
+				// Incidents group related alerts
+				r.Get("/incidents", s.listIncidents)
+				r.Get("/incidents/{id}", s.getIncident)
+				r.Get("/incidents/{id}/summary", s.getIncidentSummary)
+				r.Post("/incidents/{id}/acknowledge", s.operatorOnly(s.acknowledgeIncident))
+				r.Post("/incidents/{id}/notes", s.operatorOnly(s.addIncidentNote))
 			})
 
-			// Queue
+			// Saved searches (per user) read the mail log and queue
+			r.Route("/searches", func(r chi.Router) {
+				viewLogs := s.requirePermission(PermViewLogs)
+				r.Get("/", viewLogs(s.listSavedSearches))
+				r.Post("/", viewLogs(s.createSavedSearch))
+				r.Get("/{id}", viewLogs(s.getSavedSearch))
+				r.Put("/{id}", viewLogs(s.updateSavedSearch))
+				r.Delete("/{id}", viewLogs(s.deleteSavedSearch))
+				r.Post("/{id}/run", viewLogs(s.runSavedSearch)) // ?notify=true also needs view:addresses
+			})
+
+			// Queue. Auditors get a read-only view: envelopes with masked
+			// addresses, and no subjects, bodies or actions.
 			r.Route("/queue", func(r chi.Router) {
-				r.Get("/", s.getQueueSummary)
-				r.Get("/messages", s.getQueueMessages)
-				r.Get("/messages/{queueId}", s.getQueueMessage)
-				r.Post("/messages/{queueId}/hold", s.operatorOnly(s.holdMessage))
-				r.Post("/messages/{queueId}/release", s.operatorOnly(s.releaseMessage))
+				viewQueue := s.requirePermission(PermViewQueue)
+				manageQueue := s.requirePermission(PermManageQueue)
+				r.Get("/", viewQueue(s.getQueueSummary))
+				r.Get("/messages", viewQueue(s.getQueueMessages))
+				r.Get("/messages/{queueId}", viewQueue(s.getQueueMessage))
+				r.Get("/messages/{queueId}/content", viewQueue(s.getQueueMessageContent))
+				r.Post("/messages/{queueId}/hold", manageQueue(s.holdMessage))
+				r.Post("/messages/{queueId}/release", manageQueue(s.releaseMessage))
 				r.Delete("/messages/{queueId}", s.adminOnly(s.deleteMessage))
-				r.Post("/flush", s.operatorOnly(s.flushQueue))
+				r.Post("/bulk", manageQueue(s.bulkQueueAction))
+				r.Get("/bulk/{id}", viewQueue(s.getBulkQueueJob))
+				r.Post("/flush", manageQueue(s.flushQueue))
+				r.Post("/delete-deferred", s.adminOnly(s.requestDeleteDeferred))
+				r.Post("/purge", s.adminOnly(s.requestPurgeQueue))
 			})
 
 			// Transport maps (domain routing)
@@ -186,6 +442,7 @@ func (s *Server) Router() http.Handler {
 				r.Post("/", s.adminOnly(s.createTransportMap))
 				r.Put("/{domain}", s.adminOnly(s.updateTransportMap))
 				r.Delete("/{domain}", s.adminOnly(s.deleteTransportMap))
+				r.Get("/{domain}/impact", s.getTransportMapImpact)
 			})
 
 			// Sender-dependent relays
@@ -196,8 +453,28 @@ func (s *Server) Router() http.Handler {
 				r.Delete("/{sender}", s.adminOnly(s.deleteSenderRelay))
 			})
 
+			// Sender classes: senders with an outbound transport of their own
+			r.Route("/sender-classes", func(r chi.Router) {
+				r.Get("/", s.getSenderClasses)
+				r.Post("/", s.adminOnly(s.createSenderClass))
+				r.Put("/{name}", s.adminOnly(s.updateSenderClass))
+				r.Delete("/{name}", s.adminOnly(s.deleteSenderClass))
+			})
+
+			// Bulk import and export of transport maps and sender relays
+			r.Get("/routes/export", s.exportRoutes)
+			r.Post("/routes/import", s.adminOnly(s.importRoutes))
+
+			// Deleted routing entries, restorable until purged
+			r.Route("/trash", func(r chi.Router) {
+				r.Get("/", s.listTrash)
+				r.Post("/{id}/restore", s.adminOnly(s.restoreTrash))
+				r.Delete("/{id}", s.adminOnly(s.purgeTrash))
+			})
+
 			// Audit
 			r.Get("/audit", s.getAuditLog)
+			r.Get("/audit/{id}", s.getAuditEntry)
 
 			// Users (admin only)
 			r.Route("/users", func(r chi.Router) {
@@ -210,6 +487,26 @@ func (s *Server) Router() http.Handler {
 				r.Post("/{id}/reset-password", s.resetPassword)
 			})
 
+			// Tenants (platform admins)
+			r.Route("/tenants", func(r chi.Router) {
+				r.Get("/", s.platformOnly(s.listTenants))
+				r.Get("/usage", s.platformOnly(s.exportTenantUsage))
+				r.Post("/", s.platformOnly(s.createTenant))
+				r.Get("/{id}", s.platformOnly(s.getTenant))
+				r.Put("/{id}", s.platformOnly(s.updateTenant))
+				r.Delete("/{id}", s.platformOnly(s.deleteTenant))
+				r.Get("/{id}/settings", s.platformOnly(s.getTenantSettings))
+				r.Put("/{id}/settings", s.platformOnly(s.updateTenantSettings))
+				r.Get("/{id}/usage", s.platformOnly(s.getTenantUsage))
+				r.Put("/{id}/usage/thresholds", s.platformOnly(s.updateUsageThresholds))
+			})
+
+			// The signed-in tenant user's own tenant
+			r.Get("/tenant", s.getCurrentTenant)
+			r.Get("/tenant/settings", s.adminOnly(s.getTenantSettings))
+			r.Put("/tenant/settings", s.adminOnly(s.updateTenantSettings))
+			r.Get("/tenant/usage", s.adminOnly(s.getTenantUsage))
+
 			// Settings (admin only)
 			r.Route("/settings", func(r chi.Router) {
 				r.Use(s.adminOnlyMiddleware)
@@ -217,6 +514,16 @@ func (s *Server) Router() http.Handler {
 				r.Route("/notifications", func(r chi.Router) {
 					r.Get("/", s.getNotificationChannels)
 					r.Post("/", s.createNotificationChannel)
+					r.Get("/templates", s.getNotificationTemplates)
+					r.Put("/templates", s.putNotificationTemplate)
+					r.Post("/templates/preview", s.previewNotificationTemplate)
+					r.Delete("/templates/{id}", s.deleteNotificationTemplate)
+					r.Get("/routes", s.getAlertRoutes)
+					r.Post("/routes", s.createAlertRoute)
+					r.Put("/routes/order", s.reorderAlertRoutes)
+					r.Post("/routes/test", s.testAlertRoutes)
+					r.Put("/routes/{id}", s.updateAlertRoute)
+					r.Delete("/routes/{id}", s.deleteAlertRoute)
 					r.Put("/{id}", s.updateNotificationChannel)
 					r.Delete("/{id}", s.deleteNotificationChannel)
 					r.Post("/{id}/test", s.testNotificationChannel)
@@ -226,6 +533,96 @@ func (s *Server) Router() http.Handler {
 				r.Put("/system", s.updateSystemSettings)
 			})
 
+			// System (admin only)
+			r.Route("/system", func(r chi.Router) {
+				r.Use(s.adminOnlyMiddleware)
+				r.Get("/config", s.getSystemConfig)
+				r.Get("/about", s.getSystemAbout)
+				r.Get("/updates", s.getUpdates)
+				r.Post("/updates/check", s.checkUpdates)
+				r.Get("/rate-limits", s.getRateLimits)
+				r.Get("/background-tasks", s.getBackgroundTasks)
+				r.Get("/feature-flags", s.getFeatureFlags)
+				r.Put("/feature-flags/{key}", s.updateFeatureFlag)
+				r.Get("/hooks", s.listHooks)
+				r.Post("/hooks", s.createHook)
+				r.Put("/hooks/{id}", s.updateHook)
+				r.Delete("/hooks/{id}", s.deleteHook)
+				r.Post("/hooks/{id}/test", s.testHook)
+				r.Get("/scheduled-tasks", s.listScheduledTasks)
+				r.Put("/scheduled-tasks/{name}", s.updateScheduledTask)
+				r.Post("/scheduled-tasks/{name}/run", s.runScheduledTaskNow)
+				r.Get("/backups", s.listBackups)
+				r.Post("/backups", s.createBackup)
+				r.Get("/backups/{name}", s.downloadBackup)
+				r.Delete("/backups/{name}", s.deleteBackup)
+				r.Get("/digests/preview", s.previewDigest)
+				r.Post("/digests/send", s.sendDigest)
+				r.Get("/canary", s.getCanaryStatus)
+				r.Get("/canary/probes", s.listCanaryProbes)
+				r.Post("/canary/run", s.runCanaryProbe)
+				r.Post("/mail-auth/probes", s.runMailAuthProbe)
+				r.Get("/mail-auth/probes/{id}", s.getMailAuthProbe)
+				r.Post("/mail-auth/verify", s.verifyMailAuth)
+				r.Get("/retention", s.getRetention)
+				r.Post("/retention/run", s.runRetention)
+				r.Get("/cleanup", s.getCleanup)
+				r.Get("/login-history", s.listLoginHistory)
+				r.Post("/privacy/erase", s.erasePersonalData)
+				r.Get("/legal-holds", s.listLegalHolds)
+				r.Post("/legal-holds", s.placeLegalHold)
+				r.Post("/legal-holds/{id}/release", s.releaseLegalHold)
+				r.Get("/archive", s.getArchiveStatus)
+				r.Get("/map-types", s.getMapTypes)
+				r.Get("/delivery", s.getDeliveryCheck)
+				r.Get("/submission-auth", s.getSubmissionAuthCheck)
+				r.Get("/backscatter", s.getBackscatter)
+				r.Get("/backscatter/templates", s.getBounceTemplates)
+				r.Put("/backscatter/templates/{class}", s.putBounceTemplate)
+				r.Delete("/backscatter/templates/{class}", s.deleteBounceTemplate)
+				r.Post("/backscatter/templates/{class}/preview", s.previewBounceTemplate)
+				r.Post("/backscatter/domains", s.addBackscatterDomain)
+				r.Delete("/backscatter/domains/{domain}", s.deleteBackscatterDomain)
+				r.Get("/api-tokens", s.listAPITokens)
+				r.Post("/api-tokens", s.createAPIToken)
+				r.Delete("/api-tokens/{id}", s.revokeAPIToken)
+				r.Get("/api-tokens/{id}/stats", s.getAPITokenStats)
+				r.Get("/send-templates", s.listSendTemplates)
+				r.Post("/send-templates", s.createSendTemplate)
+				r.Get("/send-templates/{name}", s.getSendTemplate)
+				r.Put("/send-templates/{name}", s.updateSendTemplate)
+				r.Delete("/send-templates/{name}", s.deleteSendTemplate)
+				r.Get("/send-templates/{name}/versions", s.listSendTemplateVersions)
+				r.Post("/send-templates/{name}/render", s.renderSendTemplate)
+				r.Get("/send-templates/{name}/stats", s.getSendTemplateStats)
+				r.Get("/resources", s.getResources)
+				r.Get("/sink", s.getSinkStatus)
+				r.Get("/sink/messages", s.listSinkMessages)
+				r.Delete("/sink/messages", s.clearSinkMessages)
+				r.Get("/sink/messages/{id}", s.getSinkMessage)
+				r.Get("/sink/messages/{id}/raw", s.downloadSinkMessage)
+				r.Delete("/sink/messages/{id}", s.deleteSinkMessage)
+				r.Get("/services", s.listServices)
+				r.Get("/services/{name}", s.getService)
+				r.Post("/services/{name}/{action}", s.controlService)
+				r.Get("/approvals", s.listApprovals)
+				r.Post("/approvals/{id}/confirm", s.confirmApproval)
+				r.Post("/approvals/{id}/approve", s.approveApproval)
+				r.Post("/approvals/{id}/reject", s.rejectApproval)
+			})
+
+			// DKIM signing keys of mail domains (admin only)
+			r.Route("/dkim", func(r chi.Router) {
+				r.Use(s.adminOnlyMiddleware)
+				r.Get("/keys", s.listDKIMKeys)
+				r.Post("/keys", s.createDKIMKey)
+				r.Get("/keys/{id}", s.getDKIMKey)
+				r.Delete("/keys/{id}", s.deleteDKIMKey)
+				r.Post("/keys/{id}/activate", s.activateDKIMKey)
+				r.Post("/keys/{id}/verify", s.verifyDKIMKey)
+				r.Post("/sync", s.syncDKIMKeyTables)
+			})
+
 			// PSFXAdmin - Mail domain and mailbox management (admin only)
 			r.Route("/admin", func(r chi.Router) {
 				r.Use(s.adminOnlyMiddleware)
@@ -240,16 +637,40 @@ func (s *Server) Router() http.Handler {
 					r.Get("/{id}", s.getDomain)
 					r.Put("/{id}", s.updateDomain)
 					r.Delete("/{id}", s.deleteDomain)
+					r.Get("/{id}/impact", s.getDomainImpact)
+					r.Get("/{id}/dns-check", s.checkDomainDNS)
+					r.Get("/{id}/defaults", s.getDomainDefaults)
+					r.Put("/{id}/defaults", s.updateDomainDefaults)
+					r.Post("/{id}/defaults/apply", s.applyDomainDefaults)
 				})
 
 				// Mailboxes
 				r.Route("/mailboxes", func(r chi.Router) {
 					r.Get("/", s.listMailboxes)
 					r.Post("/", s.createMailbox)
+					r.Post("/import", s.importMailboxes)
 					r.Get("/{id}", s.getMailbox)
+					r.Get("/{id}/storage", s.getMailboxStorage)
 					r.Put("/{id}", s.updateMailbox)
 					r.Delete("/{id}", s.deleteMailbox)
 					r.Post("/{id}/password", s.resetMailboxPassword)
+					r.Get("/{id}/send-limits", s.getMailboxSendLimits)
+					r.Put("/{id}/send-limits", s.updateMailboxSendLimits)
+					r.Delete("/{id}/send-limits", s.deleteMailboxSendLimits)
+					r.Get("/{id}/app-passwords", s.getMailboxAppPasswords)
+					r.Delete("/{id}/app-passwords/{appPasswordId}", s.deleteMailboxAppPassword)
+				})
+
+				// Dovecot replication
+				r.Get("/replication", s.getReplication)
+
+				// Old-mail cleanup policies
+				r.Route("/cleanup", func(r chi.Router) {
+					r.Get("/", s.getMailCleanup)
+					r.Post("/policies", s.createCleanupPolicy)
+					r.Put("/policies/{id}", s.updateCleanupPolicy)
+					r.Delete("/policies/{id}", s.deleteCleanupPolicy)
+					r.Post("/run", s.runMailCleanup)
 				})
 
 				// Aliases
@@ -257,8 +678,32 @@ func (s *Server) Router() http.Handler {
 					r.Get("/", s.listAliases)
 					r.Post("/", s.createAlias)
 					r.Delete("/{id}", s.deleteAlias)
+					r.Get("/{id}/impact", s.getAliasImpact)
 				})
 
+				// Outbound footers by sender domain
+				r.Route("/disclaimers", func(r chi.Router) {
+					r.Get("/", s.listDisclaimers)
+					r.Post("/preview", s.previewDisclaimer)
+					r.Put("/{domain}", s.saveDisclaimer)
+					r.Delete("/{domain}", s.deleteDisclaimer)
+				})
+
+				// Data-loss-prevention rules and quarantine
+				r.Route("/dlp", func(r chi.Router) {
+					r.Get("/rules", s.listDLPRules)
+					r.Post("/rules", s.createDLPRule)
+					r.Put("/rules/{id}", s.updateDLPRule)
+					r.Delete("/rules/{id}", s.deleteDLPRule)
+					r.Get("/events", s.listDLPEvents)
+					r.Get("/quarantine", s.listDLPQuarantine)
+					r.Post("/quarantine/{id}/approve", s.approveDLPQuarantine)
+					r.Post("/quarantine/{id}/reject", s.rejectDLPQuarantine)
+				})
+
+				// Malware scan results
+				r.Get("/scan-results", s.listScanResults)
+
 				// Mail server sync (for debugging)
 				r.Post("/sync", s.triggerMailSync)
 				r.Get("/sync/status", s.getMailSyncStatus)
@@ -268,7 +713,7 @@ func (s *Server) Router() http.Handler {
 		// PSFXMail - Webmail API (separate auth from admin)
 		r.Route("/mail", func(r chi.Router) {
 			// Mail authentication (no admin auth required)
-			r.Post("/auth", s.authenticateMail)
+			r.With(s.loginRateLimitMiddleware).Post("/auth", s.authenticateMail)
 			r.Post("/logout", s.logoutMail)
 
 			// Protected mail routes (require mail session)
@@ -286,7 +731,11 @@ func (s *Server) Router() http.Handler {
 				r.Post("/messages/move", s.moveMessage)
 
 				// Compose/Send
-				r.Post("/send", s.sendMessage)
+				r.Post("/send", s.mailSendRateLimit(s.sendMessage))
+				r.Get("/send-quota", s.getMailSendQuota)
+				r.Get("/login-history", s.getMailLoginHistory)
+				r.Post("/attachments", s.uploadAttachment)
+				r.Delete("/attachments/{id}", s.deleteAttachment)
 
 				// Search
 				r.Get("/search", s.searchMessages)
@@ -304,6 +753,7 @@ func (s *Server) Router() http.Handler {
 				r.Put("/contacts/{id}", s.updateContact)
 				r.Delete("/contacts/{id}", s.deleteContact)
 				r.Put("/contacts/{id}/favorite", s.toggleContactFavorite)
+				r.Get("/directory", s.getDirectory)
 
 				// Signatures
 				r.Get("/signatures", s.listSignatures)
@@ -313,6 +763,11 @@ func (s *Server) Router() http.Handler {
 				r.Put("/signatures/{id}", s.updateSignature)
 				r.Delete("/signatures/{id}", s.deleteSignature)
 				r.Put("/signatures/{id}/default", s.setDefaultSignature)
+
+				// App passwords for IMAP/SMTP clients
+				r.Get("/app-passwords", s.getAppPasswords)
+				r.Post("/app-passwords", s.createAppPassword)
+				r.Delete("/app-passwords/{id}", s.deleteAppPassword)
 			})
 		})
 	})
@@ -344,10 +799,40 @@ func (s *Server) loggerMiddleware(next http.Handler) http.Handler {
 
 // Health check handlers
 func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("verbose") == "true" {
+		s.healthzVerbose(w)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("ok"))
 }
 
+// healthzVerbose adds the clock and DNS checks. A failing check marks the
+// host degraded but the endpoint still answers 200, since restarting the
+// panel wouldn't fix it. Error details are left out of this
+// unauthenticated view.
+func (s *Server) healthzVerbose(w http.ResponseWriter) {
+	status := "ok"
+	checks := []map[string]interface{}{}
+	for _, res := range hostCheckResults() {
+		if !res.OK {
+			status = "degraded"
+		}
+		checks = append(checks, map[string]interface{}{
+			"name":      res.Name,
+			"ok":        res.OK,
+			"value":     res.Value,
+			"checkedAt": res.CheckedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
 func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
 	// Check database connection
 	if err := s.db.Ping(); err != nil {
@@ -384,6 +869,21 @@ func (s *Server) csrfExemptMiddleware(csrfHandler func(http.Handler) http.Handle
 				return
 			}
 
+			// Exempt the Grafana datasource, the alert action webhook, the
+			// integration API and the send API, which authenticate with a
+			// token or signature rather than the session cookie
+			if strings.HasPrefix(r.URL.Path, "/api/v1/grafana") || r.URL.Path == "/api/v1/alerts/actions" ||
+				strings.HasPrefix(r.URL.Path, "/api/v1/integrations/") || r.URL.Path == "/api/v1/send" || strings.HasPrefix(r.URL.Path, "/api/v1/send/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Exempt API clients authenticating with a bearer token
+			if isTokenAuthenticated(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			// Exempt static file requests
 			if !strings.HasPrefix(r.URL.Path, "/api/") {
 				next.ServeHTTP(w, r)
@@ -413,106 +913,6 @@ func (s *Server) getAllowedOrigins() []string {
 	return []string{}
 }
 
-// Rate limiter implementation
-type ipRateLimiter struct {
-	mu       sync.Mutex
-	limiters map[string]*rate.Limiter
-	rate     rate.Limit
-	burst    int
-}
-
-func newIPRateLimiter(r rate.Limit, b int) *ipRateLimiter {
-	return &ipRateLimiter{
-		limiters: make(map[string]*rate.Limiter),
-		rate:     r,
-		burst:    b,
-	}
-}
-
-func (l *ipRateLimiter) getLimiter(ip string) *rate.Limiter {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	limiter, exists := l.limiters[ip]
-	if !exists {
-		limiter = rate.NewLimiter(l.rate, l.burst)
-		l.limiters[ip] = limiter
-	}
-
-	return limiter
-}
-
-// Cleanup old limiters periodically (called from a goroutine)
-func (l *ipRateLimiter) cleanup() {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	// Simple cleanup: clear all limiters every hour
-	// This prevents memory growth from many unique IPs
-	l.limiters = make(map[string]*rate.Limiter)
-}
-
-// Global rate limiter: 10 req/s, burst 30
-var globalLimiter = newIPRateLimiter(10, 30)
-
-// Login rate limiter: 1 req/s, burst 5 (stricter for auth endpoints)
-var loginLimiter = newIPRateLimiter(1, 5)
-
-func init() {
-	// Start cleanup goroutine
-	go func() {
-		for {
-			time.Sleep(time.Hour)
-			globalLimiter.cleanup()
-			loginLimiter.cleanup()
-		}
-	}()
-}
-
-// rateLimitMiddleware applies global rate limiting
-func (s *Server) rateLimitMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr
-		// Extract IP without port if present
-		if idx := strings.LastIndex(ip, ":"); idx != -1 {
-			ip = ip[:idx]
-		}
-
-		limiter := globalLimiter.getLimiter(ip)
-		if !limiter.Allow() {
-			log.Warn().
-				Str("ip", ip).
-				Str("path", r.URL.Path).
-				Msg("Rate limit exceeded")
-			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-
-// loginRateLimitMiddleware applies stricter rate limiting for auth endpoints
-func (s *Server) loginRateLimitMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr
-		if idx := strings.LastIndex(ip, ":"); idx != -1 {
-			ip = ip[:idx]
-		}
-
-		limiter := loginLimiter.getLimiter(ip)
-		if !limiter.Allow() {
-			log.Warn().
-				Str("ip", ip).
-				Str("path", r.URL.Path).
-				Msg("Login rate limit exceeded")
-			http.Error(w, "too many login attempts, please try again later", http.StatusTooManyRequests)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
-
 // securityHeadersMiddleware adds security headers to all responses
 func (s *Server) securityHeadersMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -534,7 +934,7 @@ func (s *Server) securityHeadersMiddleware(next http.Handler) http.Handler {
 			"default-src 'self'; "+
 				"script-src 'self' 'unsafe-inline' 'unsafe-eval'; "+
 				"style-src 'self' 'unsafe-inline'; "+
-				"img-src 'self' data: blob:; "+
+				imgSrc()+
 				"font-src 'self' data:; "+
 				"connect-src 'self' ws: wss:; "+
 				"frame-ancestors 'none'; "+
@@ -544,8 +944,8 @@ func (s *Server) securityHeadersMiddleware(next http.Handler) http.Handler {
 		w.Header().Set("Permissions-Policy",
 			"accelerometer=(), camera=(), geolocation=(), gyroscope=(), magnetometer=(), microphone=(), payment=(), usb=()")
 
-		// HSTS - only in production with HTTPS
-		if os.Getenv("ENV") == "production" {
+		// HSTS - in production, or when serving HTTPS ourselves
+		if os.Getenv("ENV") == "production" || r.TLS != nil {
 			w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
 		}
 
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/services"
+	"github.com/rs/zerolog/log"
+)
+
+// serviceCtl runs start, stop and restart actions on the mail services
+var serviceCtl = services.NewController()
+
+// listServices returns the state of Postfix and Dovecot
+func (s *Server) listServices(w http.ResponseWriter, r *http.Request) {
+	statuses := make([]services.Status, 0, len(services.Names))
+	for _, name := range services.Names {
+		statuses = append(statuses, serviceCtl.Status(name))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// getService returns one service's state, for polling while an action runs
+func (s *Server) getService(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if !services.Valid(name) {
+		http.Error(w, "Unknown service", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(serviceCtl.Status(name))
+}
+
+// controlService starts, stops or restarts a service. Starting runs right
+// away; stopping and restarting interrupt mail, so they go through an
+// approval request the admin has to confirm.
+func (s *Server) controlService(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	action := chi.URLParam(r, "action")
+	if !services.Valid(name) {
+		http.Error(w, "Unknown service", http.StatusNotFound)
+		return
+	}
+
+	switch action {
+	case services.ActionStart:
+		user := GetUser(r.Context())
+		if err := s.beginServiceAction(name, action, user.ID, user.Username, r.RemoteAddr); err != nil {
+			writeServiceError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(serviceCtl.Status(name))
+	case services.ActionStop:
+		s.requestApproval(w, r, approvalServiceStop, name, "STOP", "Stop "+name)
+	case services.ActionRestart:
+		s.requestApproval(w, r, approvalServiceRestart, name, "RESTART", "Restart "+name)
+	default:
+		http.Error(w, "Unknown action", http.StatusNotFound)
+	}
+}
+
+// serviceDone is how a finished action reads in the audit log
+var serviceDone = map[string]string{
+	services.ActionStart:   "Started ",
+	services.ActionStop:    "Stopped ",
+	services.ActionRestart: "Restarted ",
+}
+
+// beginServiceAction starts an action in the background and records its
+// outcome in the audit log when it finishes
+func (s *Server) beginServiceAction(name, action string, userID int64, username, remoteAddr string) error {
+	return serviceCtl.Begin(name, action, username, func(err error) {
+		status, summary := "success", serviceDone[action]+name
+		if err != nil {
+			log.Error().Err(err).Str("service", name).Str("action", action).Msg("Service action failed")
+			status, summary = "failure", err.Error()
+		}
+		s.logAudit(userID, username, "service_"+action, "service", name, summary, status, remoteAddr)
+	})
+}
+
+// writeServiceError reports a service action that couldn't be started
+func writeServiceError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrBusy) {
+		http.Error(w, "An action is already in progress for this service", http.StatusConflict)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/rs/zerolog/log"
+)
+
+// SettingsListener is called with the keys and new values of the settings
+// that changed in a single update
+type SettingsListener func(changed map[string]string)
+
+// settingsBus fans out settings changes to subsystems that cache their
+// configuration, so they can pick up new values without a restart
+type settingsBus struct {
+	mu        sync.RWMutex
+	listeners []SettingsListener
+}
+
+var settingsChanges = &settingsBus{}
+
+// Subscribe registers a listener for settings changes
+func (b *settingsBus) Subscribe(fn SettingsListener) {
+	b.mu.Lock()
+	b.listeners = append(b.listeners, fn)
+	b.mu.Unlock()
+}
+
+// Publish notifies all listeners of changed settings. A panicking listener
+// is logged and does not prevent the others from running.
+func (b *settingsBus) Publish(changed map[string]string) {
+	if len(changed) == 0 {
+		return
+	}
+
+	b.mu.RLock()
+	listeners := append([]SettingsListener(nil), b.listeners...)
+	b.mu.RUnlock()
+
+	for _, fn := range listeners {
+		func() {
+			defer func() {
+				if rec := recover(); rec != nil {
+					log.Error().Interface("panic", rec).Msg("Settings listener panicked")
+				}
+			}()
+			fn(changed)
+		}()
+	}
+}
+
+// subscribeSettings registers the server's own subsystems on the settings bus
+func (s *Server) subscribeSettings() {
+	settingsChanges.Subscribe(s.onLogSettingsChanged)
+	settingsChanges.Subscribe(s.onAlertSettingsChanged)
+	settingsChanges.Subscribe(s.onRateLimitSettingsChanged)
+	settingsChanges.Subscribe(s.onStorageSettingsChanged)
+	settingsChanges.Subscribe(s.onSNMPSettingsChanged)
+	settingsChanges.Subscribe(s.onArchiveSettingsChanged)
+	settingsChanges.Subscribe(s.onMapTypeSettingsChanged)
+	settingsChanges.Subscribe(s.onBackscatterSettingsChanged)
+	settingsChanges.Subscribe(s.onSinkSettingsChanged)
+	settingsChanges.Subscribe(s.onBrandingSettingsChanged)
+	settingsChanges.Subscribe(s.onDKIMSettingsChanged)
+}
+
+// onLogSettingsChanged restarts the log reader when the log source moves
+func (s *Server) onLogSettingsChanged(changed map[string]string) {
+	if _, ok := changed["log_source"]; !ok {
+		return
+	}
+
+	logReaderMu.Lock()
+	defer logReaderMu.Unlock()
+
+	if logReader == nil {
+		// Not started yet; the next request picks up the new source
+		return
+	}
+
+	old := logReader
+	logReader = logs.NewReader(s.logPath())
+	logReader.Start()
+	old.Stop()
+
+	log.Info().Str("path", s.logPath()).Msg("Log reader restarted after settings change")
+}
+
+// onAlertSettingsChanged makes the alert engine re-read its rules and
+// routing
+func (s *Server) onAlertSettingsChanged(changed map[string]string) {
+	if alertEngine == nil {
+		return
+	}
+	for key := range changed {
+		if strings.HasPrefix(key, "alert_") {
+			alertEngine.ReloadRules()
+			alertEngine.ReloadChannels()
+			return
+		}
+	}
+}
+
+// onRateLimitSettingsChanged applies new rate limits
+func (s *Server) onRateLimitSettingsChanged(changed map[string]string) {
+	for key := range changed {
+		if strings.HasPrefix(key, "rate_limit_") {
+			s.applyRateLimitSettings()
+			return
+		}
+	}
+}
+
+// onSNMPSettingsChanged restarts the SNMP agent with the new settings
+func (s *Server) onSNMPSettingsChanged(changed map[string]string) {
+	for key := range changed {
+		if strings.HasPrefix(key, "snmp_") {
+			s.startSNMPAgent()
+			return
+		}
+	}
+}
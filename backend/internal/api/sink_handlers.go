@@ -0,0 +1,278 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/mail"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/postfixrelay/postfixrelay/internal/smtpsink"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	sinkMu   sync.Mutex
+	smtpSink *smtpsink.Sink
+)
+
+// startSMTPSink starts the sink if sink_enabled is set, replacing a running
+// one
+func (s *Server) startSMTPSink() {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+
+	if smtpSink != nil {
+		smtpSink.Stop()
+		smtpSink = nil
+	}
+	if s.db.GetSetting("sink_enabled", "false") != "true" {
+		return
+	}
+
+	sink := smtpsink.New(s.db.GetSetting("sink_listen", smtpsink.DefaultListen), s.db.DB,
+		s.db.GetSettingInt("sink_max_messages", smtpsink.DefaultMaxMessages))
+	if err := sink.Start(); err != nil {
+		log.Error().Err(err).Msg("Failed to start SMTP sink")
+		return
+	}
+	smtpSink = sink
+}
+
+// stopSMTPSink stops the sink if it is running
+func (s *Server) stopSMTPSink() {
+	sinkMu.Lock()
+	defer sinkMu.Unlock()
+
+	if smtpSink != nil {
+		smtpSink.Stop()
+		smtpSink = nil
+	}
+}
+
+// onSinkSettingsChanged restarts the sink and reroutes Postfix when a
+// sink_ setting changes
+func (s *Server) onSinkSettingsChanged(changed map[string]string) {
+	_, enabled := changed["sink_enabled"]
+	_, listen := changed["sink_listen"]
+	_, limit := changed["sink_max_messages"]
+	if !enabled && !listen && !limit {
+		return
+	}
+	s.startSMTPSink()
+
+	if enabled || listen {
+		if err := s.applySinkConfig(); err != nil {
+			log.Error().Err(err).Msg("Failed to apply SMTP sink configuration")
+		}
+	}
+}
+
+// sinkContentFilter is the content_filter that hands every queued message
+// to the sink
+func (s *Server) sinkContentFilter() string {
+	host, port, _ := net.SplitHostPort(s.db.GetSetting("sink_listen", smtpsink.DefaultListen))
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return fmt.Sprintf("smtp:[%s]:%s", host, port)
+}
+
+// applySinkConfig points Postfix's content_filter at the sink while it is
+// enabled, so everything Postfix accepts is captured rather than
+// delivered. The filter the sink set is kept in sink_content_filter; one
+// that was there before is kept in sink_saved_content_filter and put back
+// when the sink is turned off.
+func (s *Server) applySinkConfig() error {
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+	current, err := postfixMgr.GetParameter("content_filter")
+	if err != nil {
+		return err
+	}
+	ours := s.db.GetSetting("sink_content_filter", "")
+
+	var filter string
+	if s.db.GetSetting("sink_enabled", "false") == "true" {
+		if current != "" && current != ours {
+			if err := s.db.SetSetting("sink_saved_content_filter", current); err != nil {
+				return err
+			}
+		}
+		filter = s.sinkContentFilter()
+		if err := s.db.SetSetting("sink_content_filter", filter); err != nil {
+			return err
+		}
+	} else {
+		if ours == "" || current != ours {
+			// The sink isn't routing mail; leave content_filter alone
+			return nil
+		}
+		filter = s.db.GetSetting("sink_saved_content_filter", "")
+		s.db.SetSetting("sink_content_filter", "")
+		s.db.SetSetting("sink_saved_content_filter", "")
+	}
+	if filter == current {
+		return nil
+	}
+
+	if err := postfixMgr.UpdateConfig(map[string]string{"content_filter": filter}); err != nil {
+		return err
+	}
+	return postfixMgr.Reload()
+}
+
+// getSinkStatus reports whether the sink is enabled and whether Postfix
+// is routing mail to it
+func (s *Server) getSinkStatus(w http.ResponseWriter, r *http.Request) {
+	sinkMu.Lock()
+	running := smtpSink != nil
+	sinkMu.Unlock()
+
+	contentFilter := ""
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+	if current, err := postfixMgr.GetParameter("content_filter"); err == nil {
+		contentFilter = current
+	}
+
+	var count int
+	s.db.QueryRow(`SELECT COUNT(*) FROM sink_messages`).Scan(&count)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":       s.db.GetSetting("sink_enabled", "false") == "true",
+		"running":       running,
+		"listen":        s.db.GetSetting("sink_listen", smtpsink.DefaultListen),
+		"contentFilter": contentFilter,
+		"capturing":     running && contentFilter == s.sinkContentFilter(),
+		"maxMessages":   s.db.GetSettingInt("sink_max_messages", smtpsink.DefaultMaxMessages),
+		"count":         count,
+	})
+}
+
+// listSinkMessages lists captured messages, newest first, filtered by ?q=
+func (s *Server) listSinkMessages(w http.ResponseWriter, r *http.Request) {
+	limit, offset := 50, 0
+	if n, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && n > 0 && n <= 500 {
+		limit = n
+	}
+	if n, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && n > 0 {
+		offset = n
+	}
+
+	messages, total, err := smtpsink.List(s.db.DB, r.URL.Query().Get("q"), limit, offset)
+	if err != nil {
+		http.Error(w, "Failed to list captured messages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"messages": messages,
+		"total":    total,
+	})
+}
+
+// loadSinkMessage loads the captured message named in the URL
+func (s *Server) loadSinkMessage(w http.ResponseWriter, r *http.Request) (*smtpsink.Message, []byte, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	msg, raw, err := smtpsink.Get(s.db.DB, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Captured message not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to load captured message", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return msg, raw, true
+}
+
+// getSinkMessage returns a captured message with its headers and bodies.
+// HTML is sanitized as it is for webmail.
+func (s *Server) getSinkMessage(w http.ResponseWriter, r *http.Request) {
+	msg, raw, ok := s.loadSinkMessage(w, r)
+	if !ok {
+		return
+	}
+
+	resp := map[string]interface{}{"message": msg}
+	if parsed, err := mail.ParseEmail(string(raw)); err == nil {
+		resp["headerFrom"] = parsed.From
+		resp["headerTo"] = parsed.To
+		resp["cc"] = parsed.Cc
+		resp["date"] = parsed.Date
+		resp["textBody"] = parsed.TextBody
+		if parsed.HTMLBody != "" {
+			resp["htmlBody"] = mail.NewEmailSanitizer().SanitizeHTML(parsed.HTMLBody)
+		}
+		resp["attachments"] = parsed.Attachments
+	} else {
+		resp["parseError"] = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// downloadSinkMessage returns a captured message as an .eml file
+func (s *Server) downloadSinkMessage(w http.ResponseWriter, r *http.Request) {
+	msg, raw, ok := s.loadSinkMessage(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=captured-%d.eml", msg.ID))
+	w.Write(raw)
+}
+
+// deleteSinkMessage deletes one captured message
+func (s *Server) deleteSinkMessage(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil || id < 1 {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+	n, err := smtpsink.Delete(s.db.DB, id)
+	if err != nil {
+		http.Error(w, "Failed to delete captured message", http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		http.Error(w, "Captured message not found", http.StatusNotFound)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "sink_message_delete", "sink_message", strconv.FormatInt(id, 10),
+		"Deleted captured message", "success", "", r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clearSinkMessages deletes every captured message
+func (s *Server) clearSinkMessages(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	n, err := smtpsink.Delete(s.db.DB, 0)
+	if err != nil {
+		http.Error(w, "Failed to delete captured messages", http.StatusInternalServerError)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "sink_clear", "sink_message", "",
+		fmt.Sprintf("Deleted %d captured messages", n), "success", "", r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"deleted": n})
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+)
+
+// lintSMTPSmuggling checks smtpd_forbid_bare_newline,
+// smtpd_forbid_unauth_pipelining and their exclusions against what the
+// installed Postfix supports. ?staged=true checks the config as it would
+// be after apply.
+func (s *Server) lintSMTPSmuggling(w http.ResponseWriter, r *http.Request) {
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+
+	staged := r.URL.Query().Get("staged") == "true"
+	overrides := map[string]string{}
+	if staged {
+		var err error
+		if overrides, err = s.stagedConfigOverrides(); err != nil {
+			http.Error(w, "failed to read staged config: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	findings, version, err := postfixMgr.SmugglingLint(overrides)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	counts := map[string]int{postfix.LintError: 0, postfix.LintWarning: 0, postfix.LintInfo: 0}
+	for _, f := range findings {
+		counts[f.Severity]++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"staged":         staged,
+		"postfixVersion": version,
+		"findings":       findings,
+		"counts":         counts,
+	})
+}
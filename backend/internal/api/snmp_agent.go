@@ -0,0 +1,89 @@
+package api
+
+import (
+	"os"
+	"sync"
+
+	"github.com/postfixrelay/postfixrelay/internal/alerts"
+	"github.com/postfixrelay/postfixrelay/internal/snmp"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	snmpMu    sync.Mutex
+	snmpAgent *snmp.Agent
+
+	// snmpCounters count deliveries for the SNMP agent from the log
+	// pipeline, so they survive agent restarts
+	snmpCounters = &snmp.DeliveryCounters{}
+)
+
+// startSNMPAgent starts the SNMP agent if snmp_enabled is set, replacing a
+// running one
+func (s *Server) startSNMPAgent() {
+	snmpMu.Lock()
+	defer snmpMu.Unlock()
+
+	if snmpAgent != nil {
+		snmpAgent.Stop()
+		snmpAgent = nil
+	}
+	if s.db.GetSetting("snmp_enabled", "false") != "true" {
+		return
+	}
+
+	base, err := snmp.ParseOID(s.db.GetSetting("snmp_base_oid", snmp.DefaultBaseOID))
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid snmp_base_oid, SNMP agent not started")
+		return
+	}
+	agent := snmp.NewAgent(snmp.Config{
+		Listen:    s.db.GetSetting("snmp_listen", ":1161"),
+		Community: s.db.GetSetting("snmp_community", ""),
+		BaseOID:   base,
+	}, s.snmpSnapshot)
+	if err := agent.Start(); err != nil {
+		log.Error().Err(err).Msg("Failed to start SNMP agent")
+		return
+	}
+	snmpAgent = agent
+}
+
+// stopSNMPAgent stops the SNMP agent if it is running
+func (s *Server) stopSNMPAgent() {
+	snmpMu.Lock()
+	defer snmpMu.Unlock()
+
+	if snmpAgent != nil {
+		snmpAgent.Stop()
+		snmpAgent = nil
+	}
+}
+
+// snmpSnapshot collects the values the SNMP agent serves
+func (s *Server) snmpSnapshot() snmp.Snapshot {
+	hostname, _ := os.Hostname()
+	postfixStatus := s.getPostfixStatus()
+	snap := snmp.Snapshot{
+		Hostname:       hostname,
+		PostfixRunning: postfixStatus.Running,
+		PostfixVersion: postfixStatus.Version,
+	}
+
+	s.initQueueManager()
+	snap.QueueActive, snap.QueueDeferred, snap.QueueHold, snap.QueueCorrupt = queueMgr.GetQueueSummary()
+
+	if alertEngine != nil {
+		if active, err := alertEngine.GetActiveAlerts(); err == nil {
+			snap.ActiveAlerts = len(active)
+			for _, a := range active {
+				if a.Severity == alerts.SeverityCritical {
+					snap.CriticalAlerts++
+				}
+			}
+		}
+	}
+
+	snap.Sent, snap.Bounced, snap.Deferred = snmpCounters.Values()
+	return snap
+}
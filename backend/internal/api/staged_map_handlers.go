@@ -0,0 +1,436 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/rs/zerolog/log"
+)
+
+// StagedTransportChange is a transport map change waiting to be applied
+type StagedTransportChange struct {
+	postfix.TransportChange
+	StagedBy string    `json:"stagedBy"`
+	StagedAt time.Time `json:"stagedAt"`
+}
+
+// StagedSenderRelayChange is a sender relay change waiting to be applied
+type StagedSenderRelayChange struct {
+	postfix.SenderRelayChange
+	StagedBy string    `json:"stagedBy"`
+	StagedAt time.Time `json:"stagedAt"`
+}
+
+// isStaged reports whether a map change should be staged rather than
+// written straight away
+func isStaged(r *http.Request) bool {
+	return r.URL.Query().Get("stage") == "true"
+}
+
+// mergeStagedOp combines an operation with the one already staged for the
+// same entry, so each entry has at most one staged change. It returns ""
+// when the two cancel out, and false when next can't follow staged.
+func mergeStagedOp(staged, next string) (string, bool) {
+	switch {
+	case staged == "":
+		return next, true
+	case next == postfix.MapAdd:
+		// Only an entry staged for deletion can be added again
+		return postfix.MapUpdate, staged == postfix.MapDelete
+	case staged == postfix.MapAdd && next == postfix.MapDelete:
+		return "", true
+	case staged == postfix.MapAdd:
+		return postfix.MapAdd, true
+	default:
+		return next, true
+	}
+}
+
+func (s *Server) loadStagedTransportChanges() ([]StagedTransportChange, error) {
+	rows, err := s.db.Query(`
+		SELECT domain, operation, COALESCE(next_hop, ''), COALESCE(port, 0), COALESCE(enabled, FALSE),
+			COALESCE(staged_by_username, ''), staged_at
+		FROM staged_transport_maps ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []StagedTransportChange{}
+	for rows.Next() {
+		var c StagedTransportChange
+		if err := rows.Scan(&c.Domain, &c.Operation, &c.NextHop, &c.Port, &c.Enabled, &c.StagedBy, &c.StagedAt); err != nil {
+			return nil, err
+		}
+		c.Transport = fmt.Sprintf("smtp:[%s]:%d", c.NextHop, c.Port)
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+func (s *Server) loadStagedSenderRelayChanges() ([]StagedSenderRelayChange, error) {
+	rows, err := s.db.Query(`
+		SELECT sender, operation, COALESCE(relayhost, ''), COALESCE(enabled, FALSE),
+			COALESCE(staged_by_username, ''), staged_at
+		FROM staged_sender_relays ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []StagedSenderRelayChange{}
+	for rows.Next() {
+		var c StagedSenderRelayChange
+		if err := rows.Scan(&c.Sender, &c.Operation, &c.Relayhost, &c.Enabled, &c.StagedBy, &c.StagedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+func transportChanges(staged []StagedTransportChange) []postfix.TransportChange {
+	changes := make([]postfix.TransportChange, len(staged))
+	for i, c := range staged {
+		changes[i] = c.TransportChange
+	}
+	return changes
+}
+
+func senderRelayChanges(staged []StagedSenderRelayChange) []postfix.SenderRelayChange {
+	changes := make([]postfix.SenderRelayChange, len(staged))
+	for i, c := range staged {
+		changes[i] = c.SenderRelayChange
+	}
+	return changes
+}
+
+// transportMapsByDomain keys maps by domain, so audit diffs name entries
+// rather than positions
+func transportMapsByDomain(maps []postfix.TransportMap) map[string]postfix.TransportMap {
+	byDomain := make(map[string]postfix.TransportMap, len(maps))
+	for _, m := range maps {
+		byDomain[m.Domain] = m
+	}
+	return byDomain
+}
+
+// senderRelaysBySender keys relays by sender for audit diffs
+func senderRelaysBySender(relays []postfix.SenderDependentRelay) map[string]postfix.SenderDependentRelay {
+	bySender := make(map[string]postfix.SenderDependentRelay, len(relays))
+	for _, r := range relays {
+		bySender[r.Sender] = r
+	}
+	return bySender
+}
+
+// stagedTransportMaps returns the transport maps with the staged changes
+// made to them
+func (s *Server) stagedTransportMaps(changes []postfix.TransportChange) ([]postfix.TransportMap, error) {
+	maps, err := postfixMgr.GetTransportMaps()
+	if err != nil {
+		return nil, err
+	}
+	return postfix.ApplyTransportChanges(maps, changes)
+}
+
+// stagedSenderRelays returns the sender relays with the staged changes made
+// to them
+func (s *Server) stagedSenderRelays(changes []postfix.SenderRelayChange) ([]postfix.SenderDependentRelay, error) {
+	relays, err := postfixMgr.GetSenderDependentRelays()
+	if err != nil {
+		return nil, err
+	}
+	return postfix.ApplySenderRelayChanges(relays, changes)
+}
+
+// stageTransportChange adds a change to the staged transport maps. It is
+// refused if it doesn't fit the live maps with the other staged changes.
+func (s *Server) stageTransportChange(w http.ResponseWriter, r *http.Request, change postfix.TransportChange) {
+	user := GetUser(r.Context())
+	staged, err := s.loadStagedTransportChanges()
+	if err != nil {
+		http.Error(w, "failed to read staged transport maps", http.StatusInternalServerError)
+		return
+	}
+
+	changes := []postfix.TransportChange{}
+	current := ""
+	for _, c := range staged {
+		if c.Domain == change.Domain {
+			current = c.Operation
+			continue
+		}
+		changes = append(changes, c.TransportChange)
+	}
+	requested := change.Operation
+	op, ok := mergeStagedOp(current, requested)
+	if !ok {
+		http.Error(w, "a change to transport map for "+change.Domain+" is already staged", http.StatusConflict)
+		return
+	}
+	change.Operation = op
+	if op != "" {
+		changes = append(changes, change)
+	}
+	if _, err := s.stagedTransportMaps(changes); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if op == "" {
+		_, err = s.db.Exec(`DELETE FROM staged_transport_maps WHERE domain = ?`, change.Domain)
+	} else {
+		_, err = s.db.Exec(`
+			INSERT OR REPLACE INTO staged_transport_maps (domain, operation, next_hop, port, enabled, staged_by_id, staged_by_username)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, change.Domain, op, change.NextHop, change.Port, change.Enabled, user.ID, user.Username)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to stage transport map change")
+		http.Error(w, "failed to stage transport map change", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "config_submit", "transport_map", change.Domain,
+		"Staged "+requested+" of transport map for "+change.Domain, "success", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"staged": true,
+		"change": change,
+	})
+}
+
+// stageSenderRelayChange adds a change to the staged sender relays
+func (s *Server) stageSenderRelayChange(w http.ResponseWriter, r *http.Request, change postfix.SenderRelayChange) {
+	user := GetUser(r.Context())
+	staged, err := s.loadStagedSenderRelayChanges()
+	if err != nil {
+		http.Error(w, "failed to read staged sender relays", http.StatusInternalServerError)
+		return
+	}
+
+	changes := []postfix.SenderRelayChange{}
+	current := ""
+	for _, c := range staged {
+		if c.Sender == change.Sender {
+			current = c.Operation
+			continue
+		}
+		changes = append(changes, c.SenderRelayChange)
+	}
+	requested := change.Operation
+	op, ok := mergeStagedOp(current, requested)
+	if !ok {
+		http.Error(w, "a change to sender relay for "+change.Sender+" is already staged", http.StatusConflict)
+		return
+	}
+	change.Operation = op
+	if op != "" {
+		changes = append(changes, change)
+	}
+	if _, err := s.stagedSenderRelays(changes); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if op == "" {
+		_, err = s.db.Exec(`DELETE FROM staged_sender_relays WHERE sender = ?`, change.Sender)
+	} else {
+		_, err = s.db.Exec(`
+			INSERT OR REPLACE INTO staged_sender_relays (sender, operation, relayhost, enabled, staged_by_id, staged_by_username)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, change.Sender, op, change.Relayhost, change.Enabled, user.ID, user.Username)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to stage sender relay change")
+		http.Error(w, "failed to stage sender relay change", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "config_submit", "sender_relay", change.Sender,
+		"Staged "+requested+" of sender relay for "+change.Sender, "success", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"staged": true,
+		"change": change,
+	})
+}
+
+// stagedMapPreview is the staged map changes and the files they would
+// write
+type stagedMapPreview struct {
+	Transport    []StagedTransportChange   `json:"transport"`
+	SenderRelays []StagedSenderRelayChange `json:"senderRelays"`
+	Files        []postfix.FileChange      `json:"files"`
+	// Routing lists conflicts the routing would have once applied; only
+	// set when listing
+	Routing []postfix.RoutingFinding `json:"routing,omitempty"`
+}
+
+// previewStagedMaps works out the files the staged map changes would write.
+// Only maps with staged changes are included.
+func (s *Server) previewStagedMaps() (*stagedMapPreview, error) {
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+
+	preview := &stagedMapPreview{Files: []postfix.FileChange{}}
+	var err error
+	if preview.Transport, err = s.loadStagedTransportChanges(); err != nil {
+		return nil, err
+	}
+	if preview.SenderRelays, err = s.loadStagedSenderRelayChanges(); err != nil {
+		return nil, err
+	}
+
+	if len(preview.Transport) > 0 {
+		maps, err := s.stagedTransportMaps(transportChanges(preview.Transport))
+		if err != nil {
+			return nil, err
+		}
+		change, err := postfixMgr.PreviewTransportMaps(maps)
+		if err != nil {
+			return nil, err
+		}
+		preview.Files = append(preview.Files, change)
+	}
+	if len(preview.SenderRelays) > 0 {
+		relays, err := s.stagedSenderRelays(senderRelayChanges(preview.SenderRelays))
+		if err != nil {
+			return nil, err
+		}
+		change, err := postfixMgr.PreviewSenderRelays(relays)
+		if err != nil {
+			return nil, err
+		}
+		preview.Files = append(preview.Files, change)
+	}
+	return preview, nil
+}
+
+// getStagedMaps lists the staged transport map and sender relay changes
+// with the content and diff of each file they would write
+func (s *Server) getStagedMaps(w http.ResponseWriter, r *http.Request) {
+	preview, err := s.previewStagedMaps()
+	if err != nil {
+		// The live maps changed since staging in a way the staged changes
+		// no longer fit
+		http.Error(w, "staged map changes no longer apply: "+err.Error(), http.StatusConflict)
+		return
+	}
+	if len(preview.Transport)+len(preview.SenderRelays) > 0 {
+		if cfg, err := s.routingConfig(true); err == nil {
+			preview.Routing = postfix.LintRouting(cfg)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
+// applyStagedMaps writes the staged transport map and sender relay changes
+// and clears them
+func (s *Server) applyStagedMaps(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	preview, err := s.previewStagedMaps()
+	if err != nil {
+		http.Error(w, "staged map changes no longer apply: "+err.Error(), http.StatusConflict)
+		return
+	}
+	count := len(preview.Transport) + len(preview.SenderRelays)
+	if count == 0 {
+		http.Error(w, "No staged map changes to apply", http.StatusBadRequest)
+		return
+	}
+
+	if isDryRun(r) {
+		writeDryRun(w, DryRunResult{
+			Summary: fmt.Sprintf("Would apply %d staged map changes", count),
+			Files:   preview.Files,
+		})
+		return
+	}
+
+	if len(preview.Transport) > 0 {
+		before, _ := postfixMgr.GetTransportMaps()
+		maps, err := s.stagedTransportMaps(transportChanges(preview.Transport))
+		if err == nil {
+			err = postfixMgr.SaveTransportMaps(maps)
+		}
+		if err != nil {
+			s.logAudit(user.ID, user.Username, "config_apply", "transport_map", "", "Failed to apply staged transport maps: "+err.Error(), "failed", r.RemoteAddr)
+			http.Error(w, "failed to apply staged transport maps: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.db.Exec(`DELETE FROM staged_transport_maps`)
+		after := transportMapsByDomain(maps)
+		for _, m := range before {
+			if _, ok := after[m.Domain]; !ok {
+				s.trashEntry(r, trashTransportMap, m.Domain, m)
+			}
+		}
+		s.logAuditDiff(user, "config_apply", "transport_map", "",
+			fmt.Sprintf("Applied %d staged transport map changes", len(preview.Transport)),
+			auditDiff(transportMapsByDomain(before), transportMapsByDomain(maps)), r)
+	}
+	if len(preview.SenderRelays) > 0 {
+		before, _ := postfixMgr.GetSenderDependentRelays()
+		relays, err := s.stagedSenderRelays(senderRelayChanges(preview.SenderRelays))
+		if err == nil {
+			err = postfixMgr.SaveSenderDependentRelays(relays)
+		}
+		if err != nil {
+			s.logAudit(user.ID, user.Username, "config_apply", "sender_relay", "", "Failed to apply staged sender relays: "+err.Error(), "failed", r.RemoteAddr)
+			http.Error(w, "failed to apply staged sender relays: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.db.Exec(`DELETE FROM staged_sender_relays`)
+		after := senderRelaysBySender(relays)
+		for _, relay := range before {
+			if _, ok := after[relay.Sender]; !ok {
+				s.trashEntry(r, trashSenderRelay, relay.Sender, relay)
+			}
+		}
+		s.logAuditDiff(user, "config_apply", "sender_relay", "",
+			fmt.Sprintf("Applied %d staged sender relay changes", len(preview.SenderRelays)),
+			auditDiff(senderRelaysBySender(before), senderRelaysBySender(relays)), r)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"changesCount": count,
+		"files":        preview.Files,
+	})
+}
+
+// discardStagedMaps drops the staged transport map and sender relay changes
+func (s *Server) discardStagedMaps(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+
+	var count int64
+	for _, table := range []string{"staged_transport_maps", "staged_sender_relays"} {
+		result, err := s.db.Exec(`DELETE FROM ` + table)
+		if err != nil {
+			http.Error(w, "failed to discard staged map changes", http.StatusInternalServerError)
+			return
+		}
+		n, _ := result.RowsAffected()
+		count += n
+	}
+
+	s.logAudit(user.ID, user.Username, "config_discard", "config", "",
+		fmt.Sprintf("Discarded %d staged map changes", count), "success", r.RemoteAddr)
+
+	w.WriteHeader(http.StatusNoContent)
+}
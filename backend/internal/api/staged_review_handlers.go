@@ -0,0 +1,260 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Review states of a staged config change
+const (
+	stagedPending  = "pending"
+	stagedApproved = "approved"
+	stagedRejected = "rejected"
+)
+
+// errStagedNotApproved is returned when config_second_admin is on and a
+// staged change hasn't been approved yet. The setting is off by default,
+// since a new install has a single admin who couldn't apply anything.
+var errStagedNotApproved = errors.New("staged changes are awaiting approval by another admin")
+
+// errStagedRejected is returned while a rejected change is still staged
+var errStagedRejected = errors.New("staged changes were rejected; discard or resubmit them")
+
+// StagedReview is the review state of the staged changes as a whole
+type StagedReview struct {
+	// Status is rejected if any change is, pending if any still is,
+	// otherwise approved
+	Status   string `json:"status"`
+	Pending  int    `json:"pending"`
+	Approved int    `json:"approved"`
+	Rejected int    `json:"rejected"`
+	// ApprovalRequired is whether apply waits for approval
+	// (config_second_admin)
+	ApprovalRequired bool `json:"approvalRequired"`
+}
+
+// stagedReview counts the staged changes by review state
+func (s *Server) stagedReview() (StagedReview, error) {
+	review := StagedReview{
+		ApprovalRequired: s.db.GetSetting("config_second_admin", "false") == "true",
+	}
+	err := s.db.QueryRow(`
+		SELECT
+			COALESCE(SUM(status = 'pending'), 0),
+			COALESCE(SUM(status = 'approved'), 0),
+			COALESCE(SUM(status = 'rejected'), 0)
+		FROM staged_config
+	`).Scan(&review.Pending, &review.Approved, &review.Rejected)
+	switch {
+	case review.Rejected > 0:
+		review.Status = stagedRejected
+	case review.Pending > 0:
+		review.Status = stagedPending
+	default:
+		review.Status = stagedApproved
+	}
+	return review, err
+}
+
+// stagedChange is a staged config row as an apply read it
+type stagedChange struct {
+	id    int64
+	key   string
+	value string
+}
+
+// stagedForApply reads the staged changes an apply writes, or returns why
+// they can't be applied yet. The review is checked and the rows read in
+// one transaction, and only approved rows are taken while approval is
+// required, so a change staged in between waits for its own review. A dry
+// run previews every change, reviewed or not.
+func (s *Server) stagedForApply(dryRun bool) ([]stagedChange, error) {
+	approvalRequired := s.db.GetSetting("config_second_admin", "false") == "true"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if !dryRun {
+		var pending, rejected int
+		if err := tx.QueryRow(`
+			SELECT COALESCE(SUM(status = 'pending'), 0), COALESCE(SUM(status = 'rejected'), 0) FROM staged_config
+		`).Scan(&pending, &rejected); err != nil {
+			return nil, err
+		}
+		if rejected > 0 {
+			return nil, errStagedRejected
+		}
+		if approvalRequired && pending > 0 {
+			return nil, errStagedNotApproved
+		}
+	}
+
+	query := `SELECT id, key, value FROM staged_config`
+	if !dryRun && approvalRequired {
+		query += ` WHERE status = 'approved'`
+	}
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []stagedChange
+	for rows.Next() {
+		var c stagedChange
+		if err := rows.Scan(&c.id, &c.key, &c.value); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return changes, tx.Commit()
+}
+
+// clearAppliedStaged removes the staged rows an apply wrote. A key staged
+// again with a new value since the apply read it stays for the next apply.
+func (s *Server) clearAppliedStaged(changes []stagedChange) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`DELETE FROM staged_config WHERE id = ? AND value = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, c := range changes {
+		if _, err := stmt.Exec(c.id, c.value); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// stagedReviewRequest is the optional body of an approve or reject
+type stagedReviewRequest struct {
+	Comment string `json:"comment"`
+}
+
+// decodeStagedReview reads and validates a review body, writing the
+// error response itself when it isn't valid
+func decodeStagedReview(w http.ResponseWriter, r *http.Request) (stagedReviewRequest, bool) {
+	var req stagedReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return req, false
+	}
+	v := NewValidator()
+	v.ValidateMaxLength("comment", req.Comment, 1000)
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return req, false
+	}
+	return req, true
+}
+
+// approveStagedConfig approves the pending staged changes. Nobody can
+// approve a change they staged themselves, so with config_second_admin on
+// every change passes two people before it is applied.
+func (s *Server) approveStagedConfig(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	req, ok := decodeStagedReview(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "failed to approve staged config", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var pending, own int
+	if err := tx.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(staged_by_id = ?), 0) FROM staged_config WHERE status = 'pending'
+	`, user.ID).Scan(&pending, &own); err != nil {
+		http.Error(w, "failed to approve staged config", http.StatusInternalServerError)
+		return
+	}
+	if pending == 0 {
+		http.Error(w, "No staged changes are awaiting approval", http.StatusConflict)
+		return
+	}
+	if own > 0 {
+		tx.Rollback()
+		s.logAudit(user.ID, user.Username, "config_approve", "config", "",
+			"Tried to approve staged changes they staged", "failed", r.RemoteAddr)
+		http.Error(w, "Staged changes you submitted must be approved by another admin", http.StatusForbidden)
+		return
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE staged_config
+		SET status = 'approved', reviewed_by_id = ?, reviewed_by = ?, reviewed_at = datetime('now'), review_comment = ?
+		WHERE status = 'pending'
+	`, user.ID, user.Username, req.Comment); err != nil {
+		http.Error(w, "failed to approve staged config", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to approve staged config", http.StatusInternalServerError)
+		return
+	}
+
+	s.logAudit(user.ID, user.Username, "config_approve", "config", "",
+		fmt.Sprintf("Approved %d staged config changes", pending), "success", r.RemoteAddr)
+	s.getStagedConfig(w, r)
+}
+
+// rejectStagedConfig rejects the staged changes that haven't been
+// rejected yet, approved ones included. Rejected changes stay staged, for
+// the submitter to see why, and block apply until they are discarded or
+// staged again.
+func (s *Server) rejectStagedConfig(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	req, ok := decodeStagedReview(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE staged_config
+		SET status = 'rejected', reviewed_by_id = ?, reviewed_by = ?, reviewed_at = datetime('now'), review_comment = ?
+		WHERE status != 'rejected'
+	`, user.ID, user.Username, req.Comment)
+	if err != nil {
+		http.Error(w, "failed to reject staged config", http.StatusInternalServerError)
+		return
+	}
+	affected, _ := result.RowsAffected()
+	if affected == 0 {
+		http.Error(w, "No staged changes to reject", http.StatusConflict)
+		return
+	}
+
+	summary := fmt.Sprintf("Rejected %d staged config changes", affected)
+	if req.Comment != "" {
+		summary += ": " + req.Comment
+	}
+	s.logAudit(user.ID, user.Username, "config_reject", "config", "", summary, "success", r.RemoteAddr)
+	s.getStagedConfig(w, r)
+}
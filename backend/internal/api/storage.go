@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/postfixrelay/postfixrelay/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// Object store for large artifacts (backups, exports, attachment staging),
+// built lazily from the storage_* settings
+var (
+	objectStore   storage.Store
+	objectStoreMu sync.Mutex
+)
+
+// storageConfig reads the object storage settings
+func (s *Server) storageConfig() storage.Config {
+	localPath := s.db.GetSetting("storage_local_path", "")
+	if localPath == "" {
+		localPath = filepath.Join(filepath.Dir(s.cfg.DBPath), "storage")
+	}
+	return storage.Config{
+		Backend:   s.db.GetSetting("storage_backend", "local"),
+		LocalPath: localPath,
+		Endpoint:  s.db.GetSetting("storage_s3_endpoint", ""),
+		Region:    s.db.GetSetting("storage_s3_region", ""),
+		Bucket:    s.db.GetSetting("storage_s3_bucket", ""),
+		AccessKey: s.db.GetSetting("storage_s3_access_key", ""),
+		SecretKey: s.db.GetSetting("storage_s3_secret_key", ""),
+		Prefix:    s.db.GetSetting("storage_s3_prefix", ""),
+		PathStyle: s.db.GetSetting("storage_s3_path_style", "false") == "true",
+	}
+}
+
+// store returns the configured object store. If the configured backend
+// can't be created the local store is used so uploads keep working.
+func (s *Server) store() (storage.Store, error) {
+	objectStoreMu.Lock()
+	defer objectStoreMu.Unlock()
+
+	if objectStore != nil {
+		return objectStore, nil
+	}
+
+	cfg := s.storageConfig()
+	st, err := storage.New(cfg)
+	if err != nil {
+		log.Error().Err(err).Str("backend", cfg.Backend).Msg("Failed to configure object storage, falling back to local")
+		st, err = storage.NewLocal(cfg.LocalPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	objectStore = st
+	log.Info().Str("backend", st.Name()).Msg("Object storage ready")
+	return objectStore, nil
+}
+
+// onStorageSettingsChanged drops the cached store so the next use picks up
+// the new backend
+func (s *Server) onStorageSettingsChanged(changed map[string]string) {
+	for key := range changed {
+		if strings.HasPrefix(key, "storage_") {
+			objectStoreMu.Lock()
+			objectStore = nil
+			objectStoreMu.Unlock()
+			return
+		}
+	}
+}
+
+// readObject reads a whole object into memory
+func readObject(ctx context.Context, store storage.Store, key string) ([]byte, error) {
+	rc, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// removeObject deletes an object, logging rather than returning failures
+func (s *Server) removeObject(ctx context.Context, key string) {
+	store, err := s.store()
+	if err == nil {
+		err = store.Delete(ctx, key)
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("Failed to delete object")
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/mailboxstats"
+	"github.com/rs/zerolog/log"
+)
+
+// mailboxSampler records mailbox sizes daily for the growth series
+var mailboxSampler *mailboxstats.Sampler
+
+// startMailboxSampler starts recording mailbox sizes once a day
+func (s *Server) startMailboxSampler() {
+	mailboxSampler = mailboxstats.NewSampler(s.db.DB)
+	mailboxSampler.Start()
+}
+
+// getMailboxStorage reports where a mailbox's space goes: the size and
+// message count of each folder, its largest attachments and its daily size
+// over the last ?days= days (default 90)
+func (s *Server) getMailboxStorage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid mailbox ID", http.StatusBadRequest)
+		return
+	}
+	days := 90
+	if v := r.URL.Query().Get("days"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= 3650 {
+			days = n
+		}
+	}
+
+	var email string
+	var quota int64
+	err = s.db.QueryRow("SELECT email, quota_bytes FROM mailboxes WHERE id = ?", id).Scan(&email, &quota)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Mailbox not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to get mailbox", http.StatusInternalServerError)
+		return
+	}
+
+	folders, err := mailboxstats.Folders(email)
+	if err != nil {
+		log.Error().Err(err).Str("email", email).Msg("Failed to read mailbox folders")
+		http.Error(w, "Failed to read mailbox status from Dovecot", http.StatusServiceUnavailable)
+		return
+	}
+	var totalBytes, totalMessages int64
+	for _, f := range folders {
+		totalBytes += f.Bytes
+		totalMessages += f.Messages
+	}
+
+	attachments, err := mailboxstats.LargestAttachments(email, 20)
+	if err != nil {
+		log.Warn().Err(err).Str("email", email).Msg("Failed to read mailbox attachments")
+		attachments = []mailboxstats.Attachment{}
+	}
+
+	growth, err := mailboxstats.Growth(s.db.DB, id, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		http.Error(w, "Failed to get mailbox growth", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"email":              email,
+		"quotaBytes":         quota,
+		"totalBytes":         totalBytes,
+		"totalMessages":      totalMessages,
+		"folders":            folders,
+		"largestAttachments": attachments,
+		"growth":             growth,
+	})
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/postfixrelay/postfixrelay/internal/dovecot"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+)
+
+// smtpdSASLKeys are the main.cf parameters of the smtpd_sasl config section
+var smtpdSASLKeys = []string{
+	"smtpd_sasl_auth_enable", "smtpd_sasl_type", "smtpd_sasl_path",
+	"smtpd_sasl_security_options", "smtpd_sasl_tls_security_options", "smtpd_tls_auth_only",
+}
+
+// saslSecurityOptions are the values smtpd_sasl_security_options and
+// smtpd_sasl_tls_security_options accept
+var saslSecurityOptions = []string{"noanonymous", "noplaintext", "noactive", "nodictionary", "mutual_auth", "forward_secrecy"}
+
+// defaultSASLPath is Postfix's smtpd_sasl_path default
+const defaultSASLPath = "smtpd"
+
+// validateSMTPDSASL checks an smtpd_sasl config section. Only Dovecot SASL
+// is managed; empty values leave the Postfix defaults in place.
+func validateSMTPDSASL(v *Validator, c *postfix.SMTPDSASLConfig) {
+	for field, value := range map[string]string{
+		"smtpd_sasl_auth_enable": c.SMTPDSASLAuthEnable,
+		"smtpd_tls_auth_only":    c.SMTPDTLSAuthOnly,
+	} {
+		if value != "" && value != "yes" && value != "no" {
+			v.AddErrorf(field, "must be one of: %s", "yes, no")
+		}
+	}
+	if c.SMTPDSASLType != "" && c.SMTPDSASLType != "dovecot" {
+		v.AddErrorf("smtpd_sasl_type", "must be one of: %s", "dovecot")
+	}
+	if c.SMTPDSASLPath != "" {
+		if _, _, err := postfix.ParseSASLPath(c.SMTPDSASLPath); err != nil {
+			v.AddError("smtpd_sasl_path", "must be a socket path such as private/auth or inet:dovecot:12345")
+		}
+	}
+	for field, value := range map[string]string{
+		"smtpd_sasl_security_options":     c.SMTPDSASLSecurityOptions,
+		"smtpd_sasl_tls_security_options": c.SMTPDSASLTLSSecurityOptions,
+	} {
+		for _, opt := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+			valid := false
+			for _, known := range saslSecurityOptions {
+				valid = valid || opt == known
+			}
+			if !valid {
+				v.AddErrorf(field, "must be one of: %s", strings.Join(saslSecurityOptions, ", "))
+				break
+			}
+		}
+	}
+}
+
+// stagesSMTPDSASL reports whether staged changes touch the smtpd_sasl
+// section
+func stagesSMTPDSASL(updates map[string]interface{}) bool {
+	for _, key := range smtpdSASLKeys {
+		if _, ok := updates[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSubmissionAuth connects to the Dovecot auth socket a config points
+// smtpd at. It returns nil if SMTP AUTH is off or not done by Dovecot.
+func (s *Server) checkSubmissionAuth(c postfix.SMTPDSASLConfig) *dovecot.AuthSocketCheck {
+	if c.SMTPDSASLAuthEnable != "yes" || c.SMTPDSASLType != "dovecot" {
+		return nil
+	}
+	path := c.SMTPDSASLPath
+	if path == "" {
+		path = defaultSASLPath
+	}
+	network, address, err := postfix.ParseSASLPath(path)
+	if err != nil {
+		return &dovecot.AuthSocketCheck{Error: err.Error()}
+	}
+	return s.dovecotSyncer.CheckAuthSocket(network, address)
+}
+
+// getSubmissionAuthCheck reports whether the Dovecot auth socket the
+// current config uses for SMTP AUTH answers
+func (s *Server) getSubmissionAuthCheck(w http.ResponseWriter, r *http.Request) {
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+
+	cfg, err := postfixMgr.ReadConfig()
+	if err != nil {
+		http.Error(w, "failed to read current config", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"enabled":  cfg.SMTPDSASL.SMTPDSASLAuthEnable == "yes",
+		"type":     cfg.SMTPDSASL.SMTPDSASLType,
+		"tlsOnly":  cfg.SMTPDSASL.SMTPDTLSAuthOnly == "yes",
+		"authPath": cfg.SMTPDSASL.SMTPDSASLPath,
+	}
+	if check := s.checkSubmissionAuth(cfg.SMTPDSASL); check != nil {
+		resp["check"] = check
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
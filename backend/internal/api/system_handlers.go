@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/about"
+	"github.com/postfixrelay/postfixrelay/internal/release"
+)
+
+// releaseChecker compares the running version with published releases
+var releaseChecker *release.Checker
+
+// startReleaseChecker starts the daily release check
+func (s *Server) startReleaseChecker() {
+	releaseChecker = release.NewChecker(s.db.DB, about.Version)
+	releaseChecker.Start()
+}
+
+// getSystemConfig returns the effective startup configuration with secrets redacted
+func (s *Server) getSystemConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.cfg.Redacted())
+}
+
+// getSystemAbout describes what this installation runs, for support: the
+// backend build, component versions, containers, paths and the optional
+// features that are turned on
+func (s *Server) getSystemAbout(w http.ResponseWriter, r *http.Request) {
+	dockerSocket := os.Getenv("DOCKER_SOCKET")
+	if dockerSocket == "" {
+		dockerSocket = "/var/run/docker.sock"
+	}
+	mail := s.dovecotSyncer.Config()
+
+	replication := false
+	if replicationMonitor != nil {
+		replication, _, _ = replicationMonitor.State()
+	}
+	setting := func(key string) string { return s.db.GetSetting(key, "") }
+	schemaVersion, schemaDirty, _ := s.db.SchemaVersion()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"backend":    about.BackendBuild(),
+		"components": about.Components(),
+		"deployment": about.Inspect(dockerSocket),
+		"schema": map[string]interface{}{
+			"version": schemaVersion,
+			"dirty":   schemaDirty,
+		},
+		"paths": map[string]string{
+			"configFile":        s.cfg.File,
+			"database":          s.cfg.DBPath,
+			"postfixConfigDir":  s.cfg.PostfixConfigDir,
+			"postfixQueueDir":   postfixQueueDir(),
+			"logSource":         s.cfg.LogSource,
+			"logPath":           s.cfg.LogPath,
+			"dovecotConfDir":    mail.DovecotConfDir,
+			"dovecotPasswdFile": mail.DovecotPasswdFile,
+			"appPasswordDir":    mail.DovecotAppPasswordDir,
+			"virtualMailboxMap": mail.PostfixVirtualMailbox,
+			"virtualAliasMap":   mail.PostfixVirtualAlias,
+			"archiveBccMap":     mail.PostfixArchiveBCC,
+			"mailDir":           mail.MailDir,
+		},
+		"features": map[string]interface{}{
+			"canary":              setting("canary_enabled") == "true",
+			"archive":             setting("archive_mode"),
+			"scanEngine":          setting("scan_engine"),
+			"storageBackend":      setting("storage_backend"),
+			"snmp":                setting("snmp_enabled") == "true",
+			"grafana":             setting("grafana_token") != "",
+			"metrics":             setting("metrics_token") != "",
+			"secondAdminApproval": setting("destructive_second_admin") == "true",
+			"configTickets":       setting("config_require_ticket") == "true",
+			"configApproval":      setting("config_second_admin") == "true",
+			"configBake":          setting("config_bake_minutes") != "0" && setting("config_bake_minutes") != "",
+			"softBounce":          setting("soft_bounce") == "true",
+			"replication":         replication,
+			"demo":                s.cfg.Demo,
+		},
+	})
+}
+
+// getUpdates returns the latest release check: whether a newer release is
+// out and the breaking changes, migrations and intermediate versions
+// between it and the running one
+func (s *Server) getUpdates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(releaseChecker.Last())
+}
+
+// checkUpdates fetches the release manifest now
+func (s *Server) checkUpdates(w http.ResponseWriter, r *http.Request) {
+	if !releaseChecker.Enabled() {
+		http.Error(w, "Release checks are turned off (update_check_enabled)", http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(releaseChecker.Check(time.Now()))
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/alerts"
+	"github.com/postfixrelay/postfixrelay/internal/i18n"
+)
+
+// getNotificationTemplates returns the custom templates along with the
+// built-in defaults they override
+func (s *Server) getNotificationTemplates(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(`
+		SELECT id, channel_id, event, subject, body FROM notification_templates
+		ORDER BY channel_id, event
+	`)
+	if err != nil {
+		http.Error(w, "Failed to load templates", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	templates := []alerts.MessageTemplate{}
+	for rows.Next() {
+		var t alerts.MessageTemplate
+		if err := rows.Scan(&t.ID, &t.ChannelID, &t.Event, &t.Subject, &t.Body); err == nil {
+			templates = append(templates, t)
+		}
+	}
+
+	defaults := map[string]map[string]alerts.MessageTemplate{"email": {}, "chat": {}}
+	for _, event := range alerts.TemplateEvents {
+		defaults["email"][event] = alerts.DefaultTemplate(event, "email")
+		defaults["chat"][event] = alerts.DefaultTemplate(event, "slack")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"templates": templates,
+		"defaults":  defaults,
+		"events":    alerts.TemplateEvents,
+	})
+}
+
+// isTemplateEvent reports whether templates can be defined for event
+func isTemplateEvent(event string) bool {
+	for _, e := range alerts.TemplateEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTemplate checks a template's event, channel and syntax
+func (s *Server) validateTemplate(t *alerts.MessageTemplate) *Validator {
+	v := NewValidator()
+	if !isTemplateEvent(t.Event) {
+		v.AddErrorf("event", "must be one of: %s", strings.Join(alerts.TemplateEvents, ", "))
+	}
+	if t.ChannelID != 0 {
+		var n int
+		s.db.QueryRow(`SELECT COUNT(*) FROM notification_channels WHERE id = ?`, t.ChannelID).Scan(&n)
+		if n == 0 {
+			v.AddError("channelId", "channel not found")
+		}
+	}
+	if t.Subject == "" && t.Body == "" {
+		v.AddError("body", "this field is required")
+	}
+	for field, text := range map[string]string{"subject": t.Subject, "body": t.Body} {
+		if _, err := alerts.ParseTemplate(text); err != nil {
+			v.AddErrorf(field, "invalid template: %s", err.Error())
+		}
+	}
+	return v
+}
+
+// putNotificationTemplate creates or replaces the template for a channel
+// (channelId 0 for all channels) and event
+func (s *Server) putNotificationTemplate(w http.ResponseWriter, r *http.Request) {
+	var req alerts.MessageTemplate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if v := s.validateTemplate(&req); v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	username := ""
+	if u := GetUser(r.Context()); u != nil {
+		username = u.Username
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO notification_templates (channel_id, event, subject, body, updated_at, updated_by)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, ?)
+		ON CONFLICT(channel_id, event) DO UPDATE SET
+			subject = excluded.subject, body = excluded.body,
+			updated_at = excluded.updated_at, updated_by = excluded.updated_by
+	`, req.ChannelID, req.Event, req.Subject, req.Body, username)
+	if err != nil {
+		http.Error(w, "Failed to save template", http.StatusInternalServerError)
+		return
+	}
+	s.db.QueryRow(`SELECT id FROM notification_templates WHERE channel_id = ? AND event = ?`, req.ChannelID, req.Event).Scan(&req.ID)
+
+	if alertEngine != nil {
+		alertEngine.ReloadChannels()
+	}
+	if u := GetUser(r.Context()); u != nil {
+		s.logAudit(u.ID, u.Username, "template_update", "notification", fmt.Sprintf("%d", req.ID),
+			fmt.Sprintf("Updated %s template for channel %d", req.Event, req.ChannelID), "success", r.RemoteAddr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
+
+// deleteNotificationTemplate removes a template, restoring the default
+func (s *Server) deleteNotificationTemplate(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	result, err := s.db.Exec(`DELETE FROM notification_templates WHERE id = ?`, id)
+	if err != nil {
+		http.Error(w, "Failed to delete template", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+
+	if alertEngine != nil {
+		alertEngine.ReloadChannels()
+	}
+	if u := GetUser(r.Context()); u != nil {
+		s.logAudit(u.ID, u.Username, "template_delete", "notification", id, "Deleted notification template "+id, "success", r.RemoteAddr)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// previewNotificationTemplate renders a template without saving it. Empty
+// parts use the default for the channel type; the data is a made-up alert
+// or incident unless alertId or incidentId names a real one.
+func (s *Server) previewNotificationTemplate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChannelID   int64  `json:"channelId"`
+		ChannelType string `json:"channelType"`
+		Event       string `json:"event"`
+		Subject     string `json:"subject"`
+		Body        string `json:"body"`
+		AlertID     int64  `json:"alertId"`
+		IncidentID  int64  `json:"incidentId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Event == "" {
+		req.Event = alerts.EventAlertFired
+	}
+
+	channelName := "preview"
+	locale := ""
+	if req.ChannelID != 0 {
+		var configJSON string
+		err := s.db.QueryRow(`SELECT name, type, config FROM notification_channels WHERE id = ?`, req.ChannelID).
+			Scan(&channelName, &req.ChannelType, &configJSON)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Channel not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to load channel", http.StatusInternalServerError)
+			return
+		}
+		var config map[string]string
+		json.Unmarshal([]byte(configJSON), &config)
+		locale = config["locale"]
+	}
+	if locale == "" {
+		locale = i18n.DefaultLocale
+	}
+
+	if !isTemplateEvent(req.Event) {
+		v := NewValidator()
+		v.AddErrorf("event", "must be one of: %s", strings.Join(alerts.TemplateEvents, ", "))
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	def := alerts.DefaultTemplate(req.Event, req.ChannelType)
+	if req.Subject == "" {
+		req.Subject = def.Subject
+	}
+	if req.Body == "" {
+		req.Body = def.Body
+	}
+
+	data := alerts.SampleTemplateData(req.Event)
+	s.initAlertEngine()
+	switch {
+	case req.Event == alerts.EventAlertFired && req.AlertID != 0:
+		alert, err := alertEngine.GetAlert(req.AlertID)
+		if err != nil {
+			http.Error(w, "Alert not found", http.StatusNotFound)
+			return
+		}
+		data = alerts.TemplateData{Event: req.Event, Alert: *alert}
+		if rules, err := alertEngine.GetRules(); err == nil {
+			for _, rule := range rules {
+				if rule.ID == alert.RuleID {
+					data.Rule = rule
+				}
+			}
+		}
+	case req.Event == alerts.EventIncidentResolved && req.IncidentID != 0:
+		incident, err := alertEngine.GetIncident(req.IncidentID)
+		if err != nil {
+			http.Error(w, "Incident not found", http.StatusNotFound)
+			return
+		}
+		data = alerts.IncidentTemplateData(channelName, *incident)
+	}
+	data.Channel = channelName
+
+	subject, body, err := alerts.RenderTemplate(req.Subject, req.Body, data, locale)
+	if err != nil {
+		v := NewValidator()
+		v.AddErrorf("template", "invalid template: %s", err.Error())
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"subject": subject,
+		"body":    body,
+	})
+}
@@ -0,0 +1,531 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/rs/zerolog/log"
+)
+
+// Tenant is a customer organization. Its users only see its own domains,
+// mailboxes, aliases, users, audit entries and mail log lines.
+type Tenant struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Slug        string    `json:"slug"`
+	Description string    `json:"description"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	// Computed fields
+	DomainCount int `json:"domainCount"`
+	UserCount   int `json:"userCount"`
+}
+
+var tenantSlugRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// tenantRoutes are the API paths (below /api/v1) open to tenant users.
+// Everything else, from the Postfix config to system settings, belongs to
+// the platform.
+var tenantRoutes = []*regexp.Regexp{
+	regexp.MustCompile(`^/auth/`),
+	regexp.MustCompile(`^/admin/stats$`),
+	regexp.MustCompile(`^/admin/domains(/\d+)?/?$`),
+	regexp.MustCompile(`^/admin/mailboxes(/\d+(/(storage|password|send-limits|app-passwords(/\d+)?))?)?/?$`),
+	regexp.MustCompile(`^/admin/aliases(/\d+)?/?$`),
+	regexp.MustCompile(`^/users(/\d+(/reset-password)?)?/?$`),
+	regexp.MustCompile(`^/audit(/\d+)?/?$`),
+	regexp.MustCompile(`^/logs/?$`),
+	regexp.MustCompile(`^/logs/queue/[^/]+$`),
+	regexp.MustCompile(`^/tenant(/settings|/usage)?/?$`),
+}
+
+// tenantResourceRe matches paths naming one resource by ID
+var tenantResourceRe = regexp.MustCompile(`^/(admin/domains|admin/mailboxes|admin/aliases|users|audit)/(\d+)`)
+
+// tenantOwnership checks that a resource ID belongs to a tenant
+var tenantOwnership = map[string]string{
+	"admin/domains":   `SELECT 1 FROM mail_domains WHERE id = ? AND tenant_id = ?`,
+	"admin/mailboxes": `SELECT 1 FROM mailboxes m JOIN mail_domains d ON m.domain_id = d.id WHERE m.id = ? AND d.tenant_id = ?`,
+	"admin/aliases":   `SELECT 1 FROM mail_aliases a JOIN mail_domains d ON a.domain_id = d.id WHERE a.id = ? AND d.tenant_id = ?`,
+	"users":           `SELECT 1 FROM users WHERE id = ? AND tenant_id = ?`,
+	"audit":           `SELECT 1 FROM audit_log WHERE id = ? AND tenant_id = ?`,
+}
+
+// tenantOf returns the tenant a request is confined to, or 0 for platform
+// users
+func tenantOf(r *http.Request) int64 {
+	if u := GetUser(r.Context()); u != nil {
+		return u.TenantID
+	}
+	return 0
+}
+
+// tenantScopeMiddleware confines tenant users to the routes in
+// tenantRoutes and to resources of their own tenant. Resources of other
+// tenants are reported as not found. List and create handlers scope their
+// queries with tenantOf.
+func (s *Server) tenantScopeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID := tenantOf(r)
+		if tenantID == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/api/v1")
+		allowed := false
+		for _, re := range tenantRoutes {
+			if re.MatchString(path) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			http.Error(w, "forbidden: not available to tenant users", http.StatusForbidden)
+			return
+		}
+
+		if m := tenantResourceRe.FindStringSubmatch(path); m != nil {
+			var one int
+			if err := s.db.QueryRow(tenantOwnership[m[1]], m[2], tenantID).Scan(&one); err != nil {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// platformOnly wraps a handler to require an admin who is not confined to
+// a tenant
+func (s *Server) platformOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r.Context())
+		if user == nil || user.Role != "admin" || user.TenantID != 0 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// domainInScope reports whether the request may use a mail domain
+func (s *Server) domainInScope(r *http.Request, domainID int64) bool {
+	tenantID := tenantOf(r)
+	if tenantID == 0 {
+		return true
+	}
+	var one int
+	return s.db.QueryRow(`SELECT 1 FROM mail_domains WHERE id = ? AND tenant_id = ?`, domainID, tenantID).Scan(&one) == nil
+}
+
+// tenantExists reports whether a tenant ID is known
+func (s *Server) tenantExists(id int64) bool {
+	var one int
+	return s.db.QueryRow(`SELECT 1 FROM tenants WHERE id = ?`, id).Scan(&one) == nil
+}
+
+// tenantDomains returns the mail domains of a tenant
+func (s *Server) tenantDomains(tenantID int64) map[string]bool {
+	domains := map[string]bool{}
+	rows, err := s.db.Query(`SELECT domain FROM mail_domains WHERE tenant_id = ?`, tenantID)
+	if err != nil {
+		return domains
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d string
+		if rows.Scan(&d) == nil {
+			domains[strings.ToLower(d)] = true
+		}
+	}
+	return domains
+}
+
+// scopeLogEntries keeps the log entries of messages sent from or to the
+// request's tenant's domains. Platform users get every entry.
+func (s *Server) scopeLogEntries(r *http.Request, entries []logs.Entry) []logs.Entry {
+	tenantID := tenantOf(r)
+	if tenantID == 0 {
+		return entries
+	}
+	domains := s.tenantDomains(tenantID)
+	inTenant := func(addr string) bool { return addressInDomains(domains, addr) }
+
+	// The sender and recipients are on different lines of a message, so
+	// first find the messages, then keep all their lines
+	queueIDs := map[string]bool{}
+	for _, e := range entries {
+		if e.QueueID != "" && (inTenant(e.MailFrom) || inTenant(e.MailTo)) {
+			queueIDs[e.QueueID] = true
+		}
+	}
+	scoped := make([]logs.Entry, 0)
+	for _, e := range entries {
+		if queueIDs[e.QueueID] {
+			scoped = append(scoped, e)
+		}
+	}
+	return scoped
+}
+
+// addressInDomains reports whether a logged address, with or without
+// angle brackets, is in one of the domains
+func addressInDomains(domains map[string]bool, addr string) bool {
+	i := strings.LastIndexByte(addr, '@')
+	return i >= 0 && domains[strings.ToLower(strings.Trim(addr[i+1:], "<>"))]
+}
+
+// maxStreamQueueIDs bounds the queue IDs a tenant's log stream remembers
+const maxStreamQueueIDs = 10000
+
+// logStreamScope is scopeLogEntries for entries streamed one at a time. It
+// remembers the queue IDs of the tenant's messages as their sender and
+// recipient lines go by, so lines of a message logged before either of
+// those aren't shown.
+func (s *Server) logStreamScope(r *http.Request) func(logs.Entry) bool {
+	tenantID := tenantOf(r)
+	if tenantID == 0 {
+		return func(logs.Entry) bool { return true }
+	}
+	domains := s.tenantDomains(tenantID)
+	queueIDs := map[string]bool{}
+	return func(e logs.Entry) bool {
+		if e.QueueID == "" {
+			return false
+		}
+		if addressInDomains(domains, e.MailFrom) || addressInDomains(domains, e.MailTo) {
+			if len(queueIDs) >= maxStreamQueueIDs {
+				queueIDs = map[string]bool{}
+			}
+			queueIDs[e.QueueID] = true
+		}
+		return queueIDs[e.QueueID]
+	}
+}
+
+// Tenant handlers (platform admins)
+
+func (s *Server) listTenants(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.db.Query(`
+		SELECT t.id, t.name, t.slug, COALESCE(t.description, ''), t.active, t.created_at, t.updated_at,
+			(SELECT COUNT(*) FROM mail_domains WHERE tenant_id = t.id),
+			(SELECT COUNT(*) FROM users WHERE tenant_id = t.id)
+		FROM tenants t
+		ORDER BY t.name
+	`)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query tenants")
+		http.Error(w, "Failed to query tenants", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	tenants := []Tenant{}
+	for rows.Next() {
+		var t Tenant
+		if err := rows.Scan(&t.ID, &t.Name, &t.Slug, &t.Description, &t.Active, &t.CreatedAt, &t.UpdatedAt,
+			&t.DomainCount, &t.UserCount); err != nil {
+			log.Error().Err(err).Msg("Failed to scan tenant row")
+			continue
+		}
+		tenants = append(tenants, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tenants)
+}
+
+// loadTenant reads one tenant with its counts
+func (s *Server) loadTenant(id int64) (*Tenant, error) {
+	var t Tenant
+	err := s.db.QueryRow(`
+		SELECT t.id, t.name, t.slug, COALESCE(t.description, ''), t.active, t.created_at, t.updated_at,
+			(SELECT COUNT(*) FROM mail_domains WHERE tenant_id = t.id),
+			(SELECT COUNT(*) FROM users WHERE tenant_id = t.id)
+		FROM tenants t WHERE t.id = ?
+	`, id).Scan(&t.ID, &t.Name, &t.Slug, &t.Description, &t.Active, &t.CreatedAt, &t.UpdatedAt,
+		&t.DomainCount, &t.UserCount)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *Server) getTenant(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	t, err := s.loadTenant(id)
+	if err != nil {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+type tenantRequest struct {
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	Description string `json:"description"`
+	Active      *bool  `json:"active"`
+}
+
+func (s *Server) createTenant(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	var req tenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	req.Slug = strings.ToLower(strings.TrimSpace(req.Slug))
+	v := NewValidator()
+	v.ValidateRequired("name", req.Name)
+	v.ValidateMaxLength("name", req.Name, 128)
+	if !tenantSlugRe.MatchString(req.Slug) {
+		v.AddError("slug", "must be lowercase letters, digits and hyphens")
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	result, err := s.db.Exec(`
+		INSERT INTO tenants (name, slug, description) VALUES (?, ?, ?)
+	`, req.Name, req.Slug, req.Description)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			http.Error(w, "Tenant slug already exists", http.StatusConflict)
+			return
+		}
+		log.Error().Err(err).Msg("Failed to create tenant")
+		http.Error(w, "Failed to create tenant", http.StatusInternalServerError)
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	s.auditLog(user.ID, user.Username, "create", "tenant", strconv.FormatInt(id, 10), "Created tenant: "+req.Name, "success", "", r)
+
+	t, _ := s.loadTenant(id)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+func (s *Server) updateTenant(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if !s.tenantExists(id) {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+
+	var req tenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	v := NewValidator()
+	v.ValidateRequired("name", req.Name)
+	v.ValidateMaxLength("name", req.Name, 128)
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	query := `UPDATE tenants SET name = ?, description = ?, updated_at = CURRENT_TIMESTAMP`
+	args := []interface{}{req.Name, req.Description}
+	if req.Active != nil {
+		query += ", active = ?"
+		args = append(args, *req.Active)
+	}
+	query += " WHERE id = ?"
+	args = append(args, id)
+	if _, err := s.db.Exec(query, args...); err != nil {
+		log.Error().Err(err).Msg("Failed to update tenant")
+		http.Error(w, "Failed to update tenant", http.StatusInternalServerError)
+		return
+	}
+
+	// Signing a suspended tenant's users out makes it take effect at once
+	if req.Active != nil && !*req.Active {
+		s.db.Exec(`DELETE FROM sessions WHERE user_id IN (SELECT id FROM users WHERE tenant_id = ?)`, id)
+	}
+
+	s.auditLog(user.ID, user.Username, "update", "tenant", strconv.FormatInt(id, 10), "Updated tenant: "+req.Name, "success", "", r)
+
+	t, _ := s.loadTenant(id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+func (s *Server) deleteTenant(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	t, err := s.loadTenant(id)
+	if err != nil {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+	if t.DomainCount > 0 || t.UserCount > 0 {
+		http.Error(w, "Cannot delete a tenant that still has domains or users", http.StatusConflict)
+		return
+	}
+
+	if _, err := s.db.Exec(`DELETE FROM tenants WHERE id = ?`, id); err != nil {
+		log.Error().Err(err).Msg("Failed to delete tenant")
+		http.Error(w, "Failed to delete tenant", http.StatusInternalServerError)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "delete", "tenant", strconv.FormatInt(id, 10), "Deleted tenant: "+t.Name, "success", "", r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Tenant settings
+
+// tenantSettingKeys are the settings a tenant can override
+var tenantSettingKeys = map[string]bool{
+	"branding_product_name":  true,
+	"branding_logo_url":      true,
+	"branding_support_email": true,
+	"branding_support_url":   true,
+	"branding_primary_color": true,
+	"branding_accent_color":  true,
+	"branding_default_theme": true,
+}
+
+// tenantSettings returns a tenant's overridden settings
+func (s *Server) tenantSettings(tenantID int64) map[string]string {
+	settings := map[string]string{}
+	rows, err := s.db.Query(`SELECT key, value FROM tenant_settings WHERE tenant_id = ?`, tenantID)
+	if err != nil {
+		return settings
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value string
+		if rows.Scan(&key, &value) == nil {
+			settings[key] = value
+		}
+	}
+	return settings
+}
+
+// tenantIDParam returns the tenant a settings request is about: the
+// user's own tenant, or {id} for platform admins
+func tenantIDParam(r *http.Request) int64 {
+	if id := tenantOf(r); id != 0 {
+		return id
+	}
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	return id
+}
+
+// getCurrentTenant returns the tenant of a tenant user
+func (s *Server) getCurrentTenant(w http.ResponseWriter, r *http.Request) {
+	t, err := s.loadTenant(tenantOf(r))
+	if err != nil {
+		http.Error(w, "Not a tenant user", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
+
+func (s *Server) getTenantSettings(w http.ResponseWriter, r *http.Request) {
+	tenantID := tenantIDParam(r)
+	if !s.tenantExists(tenantID) {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"settings": s.tenantSettings(tenantID),
+	})
+}
+
+// updateTenantSettings sets a tenant's overrides. An empty value removes
+// the override, so the platform setting applies again.
+func (s *Server) updateTenantSettings(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	tenantID := tenantIDParam(r)
+	if !s.tenantExists(tenantID) {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+
+	var settings map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	v := NewValidator()
+	for key, value := range settings {
+		if !tenantSettingKeys[key] {
+			v.AddError(key, "cannot be set per tenant")
+			continue
+		}
+		if value != "" {
+			validateBrandingSetting(v, key, value)
+		}
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		http.Error(w, "failed to update settings", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	for key, value := range settings {
+		if value == "" {
+			_, err = tx.Exec(`DELETE FROM tenant_settings WHERE tenant_id = ? AND key = ?`, tenantID, key)
+		} else {
+			_, err = tx.Exec(`
+				INSERT OR REPLACE INTO tenant_settings (tenant_id, key, value, updated_at)
+				VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+			`, tenantID, key, value)
+		}
+		if err != nil {
+			http.Error(w, "failed to update settings", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to update settings", http.StatusInternalServerError)
+		return
+	}
+	if _, ok := settings["branding_logo_url"]; ok {
+		s.loadLogoOrigins()
+	}
+
+	s.auditLog(user.ID, user.Username, "settings_update", "tenant", strconv.FormatInt(tenantID, 10), "Updated tenant settings", "success", "", r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tenantBySlug returns the ID of an active tenant
+func (s *Server) tenantBySlug(slug string) (int64, bool) {
+	var id int64
+	if err := s.db.QueryRow(`SELECT id FROM tenants WHERE slug = ? AND active = TRUE`, slug).Scan(&id); err != nil {
+		return 0, false
+	}
+	return id, true
+}
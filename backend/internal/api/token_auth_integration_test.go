@@ -0,0 +1,53 @@
+//go:build integration
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSettingTokenAuth(t *testing.T) {
+	s, _ := newConfigServer(t)
+	h := s.settingTokenAuth("metrics_token", "metrics")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	request := func(bearer, password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if bearer != "" {
+			r.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		if password != "" {
+			r.SetBasicAuth("prometheus", password)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := request("anything", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unset token: status %d, want 404", w.Code)
+	}
+
+	s.db.SetSetting("metrics_token", "s3cret")
+	tests := []struct {
+		name, bearer, password string
+		want                   int
+	}{
+		{"bearer", "s3cret", "", http.StatusNoContent},
+		{"basic auth", "", "s3cret", http.StatusNoContent},
+		{"wrong bearer", "s3cre", "", http.StatusUnauthorized},
+		{"wrong password", "", "nope", http.StatusUnauthorized},
+		{"none", "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := request(tt.bearer, tt.password)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != `Basic realm="metrics"` {
+			t.Errorf("%s: WWW-Authenticate = %q", tt.name, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+)
+
+// maxTraceIDs limits how many queue IDs one trace request can ask for
+const maxTraceIDs = 100
+
+// messageTrace is what the mail log and the queue show of one message
+type messageTrace struct {
+	QueueID string `json:"queueId"`
+	// Found is false when neither the recent log nor the queue has it
+	Found     bool       `json:"found"`
+	From      string     `json:"from,omitempty" redact:"view:addresses,mask"`
+	To        []string   `json:"to" redact:"view:addresses,mask"`
+	Status    string     `json:"status,omitempty"` // last delivery status logged
+	FirstSeen *time.Time `json:"firstSeen,omitempty"`
+	LastSeen  *time.Time `json:"lastSeen,omitempty"`
+	// Queued is set while the message is still in the queue
+	Queued  *postfix.QueueMessage `json:"queued,omitempty"`
+	Entries []logs.Entry          `json:"entries"`
+	Error   string                `json:"error,omitempty"`
+	// Confidential is set for mail of a confidential domain, and Redacted
+	// when the header and body text its log lines quote is withheld
+	Confidential bool `json:"confidential,omitempty"`
+	Redacted     bool `json:"redacted,omitempty"`
+}
+
+// traceIDs reads the queue IDs of ?ids=A,B (or ids repeated), without
+// duplicates, in order
+func traceIDs(r *http.Request) []string {
+	seen := map[string]bool{}
+	var ids []string
+	for _, v := range r.URL.Query()["ids"] {
+		for _, id := range strings.Split(v, ",") {
+			id = strings.ToUpper(strings.TrimSpace(id))
+			if id != "" && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// getTraces returns the message trace of each queue ID in ?ids=, for the
+// links from alerts and delivery statistics. IDs that aren't valid queue
+// IDs get an error in their trace rather than failing the request. Traces
+// of confidential mail are redacted as the queue content view is.
+func (s *Server) getTraces(w http.ResponseWriter, r *http.Request) {
+	unredact, ok := parseUnredact(w, r)
+	if !ok {
+		return
+	}
+	ids := traceIDs(r)
+	if len(ids) == 0 {
+		http.Error(w, "ids is required", http.StatusBadRequest)
+		return
+	}
+	if len(ids) > maxTraceIDs {
+		http.Error(w, "too many queue IDs (max 100)", http.StatusBadRequest)
+		return
+	}
+
+	traces := make([]*messageTrace, len(ids))
+	byID := map[string]*messageTrace{}
+	for i, id := range ids {
+		traces[i] = &messageTrace{QueueID: id, To: []string{}, Entries: []logs.Entry{}}
+		if err := postfix.ValidateQueueID(id); err != nil {
+			traces[i].Error = err.Error()
+			continue
+		}
+		byID[id] = traces[i]
+	}
+
+	s.initLogReader()
+	entries, _ := logReader.ReadRecent(10000)
+	seenTo := map[string]bool{}
+	for _, e := range entries {
+		t := byID[e.QueueID]
+		if t == nil {
+			continue
+		}
+		t.Found = true
+		t.Entries = append(t.Entries, e)
+		if e.MailFrom != "" {
+			t.From = e.MailFrom
+		}
+		if e.MailTo != "" && !seenTo[e.QueueID+" "+e.MailTo] {
+			seenTo[e.QueueID+" "+e.MailTo] = true
+			t.To = append(t.To, e.MailTo)
+		}
+		if e.Status != "" {
+			t.Status = e.Status
+		}
+		if ts := e.Timestamp; !ts.IsZero() {
+			if t.FirstSeen == nil || ts.Before(*t.FirstSeen) {
+				t.FirstSeen = &ts
+			}
+			if t.LastSeen == nil || ts.After(*t.LastSeen) {
+				t.LastSeen = &ts
+			}
+		}
+	}
+
+	if len(byID) > 0 {
+		s.initQueueManager()
+		if queued, err := queueMgr.ListMessages(""); err == nil {
+			for i := range queued {
+				if t := byID[queued[i].QueueID]; t != nil {
+					t.Found = true
+					t.Queued = &queued[i]
+					if t.From == "" {
+						t.From = queued[i].Sender
+					}
+				}
+			}
+		}
+	}
+
+	confidential := s.confidentialDomains()
+	found := 0
+	for _, t := range traces {
+		if t.Found {
+			found++
+		}
+		t.Entries = maskLogEntries(r, t.Entries)
+		if !isConfidential(confidential, t.From, t.To) &&
+			(t.Queued == nil || !isConfidential(confidential, t.Queued.Sender, t.Queued.Recipients)) {
+			continue
+		}
+		t.Confidential = true
+		if unredact.Requested {
+			s.auditUnredact(r, t.QueueID, "trace", unredact.Justification)
+			continue
+		}
+		t.Redacted = true
+		for i := range t.Entries {
+			t.Entries[i].Message = redactLogMessage(t.Entries[i].Message)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(redactFor(r, map[string]interface{}{
+		"traces": traces,
+		"found":  found,
+	}))
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/rs/zerolog/log"
+)
+
+// Kinds of routing entry kept in the trash when deleted
+const (
+	trashTransportMap     = "transport_map"
+	trashSenderRelay      = "sender_relay"
+	trashBackscatterEntry = "backscatter_domain"
+)
+
+// TrashEntry is a deleted routing entry that can still be restored
+type TrashEntry struct {
+	ID        int64           `json:"id"`
+	Kind      string          `json:"kind"`
+	Key       string          `json:"key"`
+	Entry     json.RawMessage `json:"entry"`
+	DeletedAt time.Time       `json:"deletedAt"`
+	DeletedBy string          `json:"deletedBy,omitempty"`
+	// PurgeAt is when the retention pruner removes it; unset when deleted
+	// entries are kept forever
+	PurgeAt *time.Time `json:"purgeAt,omitempty"`
+}
+
+// trashEntry keeps a deleted routing entry and returns its trash ID. A
+// failure is returned but shouldn't undo the delete that already happened.
+func (s *Server) trashEntry(r *http.Request, kind, key string, entry interface{}) (int64, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	deletedBy := ""
+	if u := GetUser(r.Context()); u != nil {
+		deletedBy = u.Username
+	}
+	res, err := s.db.Exec(`
+		INSERT INTO routing_trash (kind, entry_key, entry, deleted_at, deleted_by) VALUES (?, ?, ?, ?, ?)
+	`, kind, key, string(data), time.Now().UTC().Format(time.RFC3339), deletedBy)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// writeDeleted keeps a routing entry that was just deleted and answers with
+// the trash ID it can be restored from. If it couldn't be kept the answer is
+// 204 and there is nothing to undo.
+func (s *Server) writeDeleted(w http.ResponseWriter, r *http.Request, kind, key string, entry interface{}) {
+	trashID, err := s.trashEntry(r, kind, key, entry)
+	if err != nil {
+		log.Error().Err(err).Str("kind", kind).Str("key", key).Msg("Failed to keep deleted entry for restore")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"trashId": trashID})
+}
+
+// listTrash lists deleted routing entries, newest first, optionally of one
+// ?kind=
+func (s *Server) listTrash(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT id, kind, entry_key, entry, deleted_at, COALESCE(deleted_by, '') FROM routing_trash`
+	var args []interface{}
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		query += ` WHERE kind = ?`
+		args = append(args, kind)
+	}
+	rows, err := s.db.Query(query+` ORDER BY id DESC`, args...)
+	if err != nil {
+		http.Error(w, "Failed to list deleted entries", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	days := s.db.GetSettingInt("trash_retention_days", 30)
+	entries := []TrashEntry{}
+	for rows.Next() {
+		var e TrashEntry
+		var entry, deletedAt string
+		if err := rows.Scan(&e.ID, &e.Kind, &e.Key, &entry, &deletedAt, &e.DeletedBy); err != nil {
+			continue
+		}
+		e.Entry = json.RawMessage(entry)
+		e.DeletedAt = parseTrashTime(deletedAt)
+		if days > 0 {
+			purge := e.DeletedAt.AddDate(0, 0, days)
+			e.PurgeAt = &purge
+		}
+		entries = append(entries, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries":       entries,
+		"retentionDays": days,
+	})
+}
+
+// parseTrashTime reads deleted_at, written as RFC 3339 or by SQLite's
+// CURRENT_TIMESTAMP
+func parseTrashTime(s string) time.Time {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t
+	}
+	t, _ := time.Parse("2006-01-02 15:04:05", s)
+	return t
+}
+
+// loadTrashEntry loads the trash entry named in the URL
+func (s *Server) loadTrashEntry(w http.ResponseWriter, r *http.Request) (*TrashEntry, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid trash ID", http.StatusBadRequest)
+		return nil, false
+	}
+	var e TrashEntry
+	var entry string
+	err = s.db.QueryRow(`SELECT id, kind, entry_key, entry FROM routing_trash WHERE id = ?`, id).
+		Scan(&e.ID, &e.Kind, &e.Key, &entry)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Deleted entry not found; it may have been restored or purged", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to load deleted entry", http.StatusInternalServerError)
+		return nil, false
+	}
+	e.Entry = json.RawMessage(entry)
+	return &e, true
+}
+
+// restoreTrash puts a deleted routing entry back. It fails with 409 when an
+// entry with the same key has been added since.
+func (s *Server) restoreTrash(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	e, ok := s.loadTrashEntry(w, r)
+	if !ok {
+		return
+	}
+	if postfixMgr == nil {
+		postfixMgr = postfix.NewConfigManager(s.cfg.PostfixConfigDir)
+	}
+
+	var restored interface{}
+	var err error
+	conflict := false
+	switch e.Kind {
+	case trashTransportMap:
+		var tm postfix.TransportMap
+		if err = json.Unmarshal(e.Entry, &tm); err != nil {
+			break
+		}
+		if conflict = s.findTransportMap(tm.Domain) != nil; conflict {
+			break
+		}
+		err = postfixMgr.AddTransportMap(tm)
+		restored = tm
+	case trashSenderRelay:
+		var relay postfix.SenderDependentRelay
+		if err = json.Unmarshal(e.Entry, &relay); err != nil {
+			break
+		}
+		relays, _ := postfixMgr.GetSenderDependentRelays()
+		for _, existing := range relays {
+			conflict = conflict || existing.Sender == relay.Sender
+		}
+		if conflict {
+			break
+		}
+		err = postfixMgr.AddSenderDependentRelay(relay)
+		restored = relay
+	case trashBackscatterEntry:
+		var exists int
+		s.db.QueryRow(`SELECT COUNT(*) FROM backscatter_domains WHERE domain = ?`, e.Key).Scan(&exists)
+		if conflict = exists > 0; conflict {
+			break
+		}
+		if _, err = s.db.Exec(`INSERT INTO backscatter_domains (domain, created_by) VALUES (?, ?)`, e.Key, user.Username); err == nil {
+			err = s.applyBackscatterDomains()
+		}
+		restored = map[string]string{"domain": e.Key}
+	default:
+		err = fmt.Errorf("unknown kind %q", e.Kind)
+	}
+	if conflict {
+		http.Error(w, fmt.Sprintf("%s already has an entry; delete or edit it instead", e.Key), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		s.logAudit(user.ID, user.Username, e.Kind+"_restore", e.Kind, e.Key, "Failed to restore "+e.Key+": "+err.Error(), "failed", r.RemoteAddr)
+		http.Error(w, "Failed to restore entry: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.db.Exec(`DELETE FROM routing_trash WHERE id = ?`, e.ID)
+	s.logAuditDiff(user, e.Kind+"_restore", e.Kind, e.Key, "Restored deleted entry for "+e.Key, auditDiff(nil, restored), r)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"kind":  e.Kind,
+		"key":   e.Key,
+		"entry": restored,
+	})
+}
+
+// purgeTrash removes a deleted entry for good, before the retention period
+// would
+func (s *Server) purgeTrash(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	e, ok := s.loadTrashEntry(w, r)
+	if !ok {
+		return
+	}
+	if _, err := s.db.Exec(`DELETE FROM routing_trash WHERE id = ?`, e.ID); err != nil {
+		http.Error(w, "Failed to purge deleted entry", http.StatusInternalServerError)
+		return
+	}
+	s.auditLog(user.ID, user.Username, "trash_purge", e.Kind, e.Key, "Purged deleted entry for "+e.Key, "success", "", r)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/usage"
+	"github.com/rs/zerolog/log"
+)
+
+// exportTenantUsage returns every tenant's usage in ?month=YYYY-MM (the
+// current month by default) for billing, as CSV or with ?format=json
+func (s *Server) exportTenantUsage(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = time.Now().UTC().Format(usage.MonthFormat)
+	} else if _, err := time.Parse(usage.MonthFormat, month); err != nil {
+		http.Error(w, "month must be of the form YYYY-MM", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := usage.Month(s.db.DB, month)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query tenant usage")
+		http.Error(w, "Failed to query tenant usage", http.StatusInternalServerError)
+		return
+	}
+
+	name := "usage-" + month
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment; filename="+name+".json")
+		json.NewEncoder(w).Encode(rows)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename="+name+".csv")
+	w.Write(usage.CSV(rows))
+}
+
+// getTenantUsage returns a tenant's usage in its last ?months= months
+// (default 12), newest first. Tenant admins see their own tenant's.
+func (s *Server) getTenantUsage(w http.ResponseWriter, r *http.Request) {
+	tenantID := tenantIDParam(r)
+	if !s.tenantExists(tenantID) {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+	months := 12
+	if v := r.URL.Query().Get("months"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 120 {
+			http.Error(w, "months must be between 1 and 120", http.StatusBadRequest)
+			return
+		}
+		months = n
+	}
+
+	history, err := usage.History(s.db.DB, tenantID, months)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query tenant usage")
+		http.Error(w, "Failed to query tenant usage", http.StatusInternalServerError)
+		return
+	}
+	thresholds, err := usage.Thresholds(s.db.DB, tenantID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to query usage thresholds")
+		http.Error(w, "Failed to query usage thresholds", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"usage":      history,
+		"thresholds": thresholds,
+	})
+}
+
+// updateUsageThresholds replaces a tenant's usage thresholds
+func (s *Server) updateUsageThresholds(w http.ResponseWriter, r *http.Request) {
+	user := GetUser(r.Context())
+	tenantID := tenantIDParam(r)
+	if !s.tenantExists(tenantID) {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+
+	var thresholds []usage.Threshold
+	if err := json.NewDecoder(r.Body).Decode(&thresholds); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	v := NewValidator()
+	for i, t := range thresholds {
+		field := "thresholds[" + strconv.Itoa(i) + "]"
+		switch t.Metric {
+		case usage.MetricMessages, usage.MetricStorage, usage.MetricMailboxes:
+		default:
+			v.AddErrorf(field+".metric", "must be one of: %s", "messages, storage_bytes, mailboxes")
+		}
+		if t.Threshold < 1 {
+			v.AddError(field+".threshold", "must be a positive integer")
+		}
+	}
+	if v.HasErrors() {
+		writeValidationErrors(w, r, v)
+		return
+	}
+
+	if err := usage.SetThresholds(s.db.DB, tenantID, thresholds); err != nil {
+		log.Error().Err(err).Msg("Failed to update usage thresholds")
+		http.Error(w, "Failed to update usage thresholds", http.StatusInternalServerError)
+		return
+	}
+
+	s.auditLog(user.ID, user.Username, "usage_thresholds_update", "tenant", strconv.FormatInt(tenantID, 10),
+		"Updated usage thresholds", "success", "", r)
+	w.WriteHeader(http.StatusNoContent)
+}
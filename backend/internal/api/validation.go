@@ -1,15 +1,25 @@
 package api
 
 import (
+	"encoding/json"
+	"fmt"
 	"net"
+	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
+
+	"github.com/postfixrelay/postfixrelay/internal/i18n"
 )
 
 // ValidationError represents a single validation error
 type ValidationError struct {
 	Field   string `json:"field"`
 	Message string `json:"message"`
+
+	// Untranslated message format and arguments, used by Localize
+	format string
+	args   []interface{}
 }
 
 // Validator accumulates validation errors
@@ -50,7 +60,18 @@ var validTLSLevels = map[string]bool{
 
 // AddError adds a validation error
 func (v *Validator) AddError(field, message string) {
-	v.errors = append(v.errors, ValidationError{Field: field, Message: message})
+	v.errors = append(v.errors, ValidationError{Field: field, Message: message, format: message})
+}
+
+// AddErrorf adds a validation error with a formatted message. The format
+// string is what gets translated, so keep variable parts in the arguments.
+func (v *Validator) AddErrorf(field, format string, args ...interface{}) {
+	v.errors = append(v.errors, ValidationError{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+		format:  format,
+		args:    args,
+	})
 }
 
 // HasErrors returns true if there are validation errors
@@ -63,6 +84,24 @@ func (v *Validator) Errors() []ValidationError {
 	return v.errors
 }
 
+// Localize returns the validation errors translated into locale
+func (v *Validator) Localize(locale string) []ValidationError {
+	localized := make([]ValidationError, len(v.errors))
+	for i, e := range v.errors {
+		localized[i] = ValidationError{Field: e.Field, Message: i18n.T(locale, e.format, e.args...)}
+	}
+	return localized
+}
+
+// writeValidationErrors responds 400 with the errors in the request locale
+func writeValidationErrors(w http.ResponseWriter, r *http.Request, v *Validator) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": v.Localize(i18n.FromContext(r.Context())),
+	})
+}
+
 // ValidateDomain validates a domain name (RFC 1123)
 func (v *Validator) ValidateDomain(field, value string) {
 	if value == "" {
@@ -112,7 +151,7 @@ func (v *Validator) ValidateCIDR(field, value string) {
 		if !strings.Contains(line, "/") {
 			// Try parsing as single IP
 			if net.ParseIP(line) == nil {
-				v.AddError(field, "invalid IP address at line "+string(rune('1'+i))+": "+line)
+				v.AddErrorf(field, "invalid IP address at line %d: %s", i+1, line)
 			}
 			continue
 		}
@@ -120,7 +159,7 @@ func (v *Validator) ValidateCIDR(field, value string) {
 		// Parse as CIDR
 		_, _, err := net.ParseCIDR(line)
 		if err != nil {
-			v.AddError(field, "invalid CIDR notation at line "+string(rune('1'+i))+": "+line)
+			v.AddErrorf(field, "invalid CIDR notation at line %d: %s", i+1, line)
 		}
 	}
 }
@@ -174,7 +213,7 @@ func (v *Validator) ValidateRequired(field, value string) {
 // ValidateMaxLength validates maximum string length
 func (v *Validator) ValidateMaxLength(field, value string, maxLen int) {
 	if len(value) > maxLen {
-		v.AddError(field, "value too long (max "+string(rune('0'+maxLen/100))+string(rune('0'+(maxLen%100)/10))+string(rune('0'+maxLen%10))+" characters)")
+		v.AddErrorf(field, "value too long (max %d characters)", maxLen)
 	}
 }
 
@@ -196,6 +235,18 @@ func (v *Validator) ValidateIPAddress(field, value string) {
 	}
 }
 
+// ValidateHTTPURL validates an absolute http or https URL (empty is allowed)
+func (v *Validator) ValidateHTTPURL(field, value string) {
+	if value == "" {
+		return
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.AddError(field, "must be an http or https URL")
+	}
+}
+
 // ValidateCommaSeparatedEmails validates a comma-separated list of email addresses
 func (v *Validator) ValidateCommaSeparatedEmails(field, value string) {
 	if value == "" {
@@ -209,7 +260,7 @@ func (v *Validator) ValidateCommaSeparatedEmails(field, value string) {
 			continue
 		}
 		if !emailRegex.MatchString(email) {
-			v.AddError(field, "invalid email address: "+email)
+			v.AddErrorf(field, "invalid email address: %s", email)
 			return // Only report first error
 		}
 	}
@@ -0,0 +1,153 @@
+// Package archive sends a copy of every message to and from archived
+// domains to an external archive. Postfix adds the copy through
+// sender_bcc_maps and recipient_bcc_maps; it goes either to an SMTP
+// journaling address or to a local receiver that stores it in object
+// storage as an .eml file.
+package archive
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/rs/zerolog/log"
+)
+
+// Archive modes (the archive_mode setting)
+const (
+	ModeOff  = "off"
+	ModeSMTP = "smtp" // BCC to archive_smtp_address
+	ModeS3   = "s3"   // BCC to the local receiver, which writes to object storage
+)
+
+// Modes lists the valid archive modes
+var Modes = []string{ModeOff, ModeSMTP, ModeS3}
+
+// JournalDomain is the domain of the local receiver's address. It is under
+// .invalid so a misrouted copy can never leave the host; a transport map
+// entry sends it to the receiver.
+const JournalDomain = "archive.psfxsuite.invalid"
+
+// JournalAddress is the address copies are sent to in s3 mode
+const JournalAddress = "journal@" + JournalDomain
+
+// ObjectPrefix is where the receiver stores archived messages
+const ObjectPrefix = "archive/"
+
+// BCCAddress returns the address copies go to for a mode, or "" when
+// archiving is off or not configured
+func BCCAddress(mode, smtpAddress string) string {
+	switch mode {
+	case ModeSMTP:
+		return strings.TrimSpace(smtpAddress)
+	case ModeS3:
+		return JournalAddress
+	}
+	return ""
+}
+
+// BCCMap renders a Postfix lookup table of the given type that copies mail
+// from (as sender_bcc_maps) or to (as recipient_bcc_maps) each domain to
+// address
+func BCCMap(domains []string, address, mapType string) string {
+	var b strings.Builder
+	b.WriteString("# Generated by PSFX Admin - DO NOT EDIT MANUALLY\n")
+	if address == "" {
+		return b.String()
+	}
+	sorted := append([]string(nil), domains...)
+	sort.Strings(sorted)
+	for _, domain := range sorted {
+		fmt.Fprintf(&b, "%s\t%s\n", postfix.MapKey(mapType, "@"+domain), address)
+	}
+	return b.String()
+}
+
+// Event is a failed delivery to the archive
+type Event struct {
+	ID         int64     `json:"id"`
+	OccurredAt time.Time `json:"occurredAt"`
+	Status     string    `json:"status"` // failed (receiver), deferred or bounced (Postfix)
+	QueueID    string    `json:"queueId,omitempty"`
+	Detail     string    `json:"detail"`
+}
+
+// Record stores an archive delivery failure
+func Record(db *sql.DB, status, queueID, detail string) {
+	_, err := db.Exec(`
+		INSERT INTO archive_events (occurred_at, status, queue_id, detail) VALUES (?, ?, ?, ?)
+	`, time.Now().UTC().Format(time.RFC3339), status, queueID, detail)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to record archive event")
+	}
+}
+
+// RecentEvents returns the latest archive delivery failures, newest first
+func RecentEvents(db *sql.DB, limit int) ([]Event, error) {
+	rows, err := db.Query(`
+		SELECT id, occurred_at, status, COALESCE(queue_id, ''), detail FROM archive_events
+		ORDER BY id DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var e Event
+		var occurred string
+		if err := rows.Scan(&e.ID, &occurred, &e.Status, &e.QueueID, &e.Detail); err != nil {
+			continue
+		}
+		e.OccurredAt, _ = time.Parse(time.RFC3339, occurred)
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// Monitor watches the mail log for deliveries to the archive address that
+// Postfix deferred or bounced
+type Monitor struct {
+	db *sql.DB
+
+	mu      sync.RWMutex
+	address string
+}
+
+// NewMonitor creates a monitor; it watches nothing until SetAddress
+func NewMonitor(db *sql.DB) *Monitor {
+	return &Monitor{db: db}
+}
+
+// SetAddress sets the archive address to watch, "" for none
+func (m *Monitor) SetAddress(address string) {
+	m.mu.Lock()
+	m.address = strings.ToLower(address)
+	m.mu.Unlock()
+}
+
+// Consume records a failure if the entry is a deferred or bounced
+// delivery to the archive address
+func (m *Monitor) Consume(e logs.Entry) {
+	if e.Status != "deferred" && e.Status != "bounced" {
+		return
+	}
+	m.mu.RLock()
+	address := m.address
+	m.mu.RUnlock()
+	if address == "" || strings.ToLower(strings.Trim(e.MailTo, "<>")) != address {
+		return
+	}
+
+	detail := e.Message
+	if e.DSN != "" {
+		detail = e.DSN + " " + detail
+	}
+	Record(m.db, e.Status, e.QueueID, detail)
+}
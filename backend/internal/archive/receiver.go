@@ -0,0 +1,116 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/smtpserver"
+	"github.com/postfixrelay/postfixrelay/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// maxMessageSize is the largest archive copy accepted
+const maxMessageSize = 100 << 20
+
+// Receiver is a minimal SMTP server that accepts archive copies from the
+// local Postfix and stores each one as an .eml object. It only accepts
+// JournalAddress as a recipient and should listen on a loopback address.
+type Receiver struct {
+	store func() (storage.Store, error)
+	db    *sql.DB
+	srv   *smtpserver.Server
+
+	stored     atomic.Int64
+	lastStored atomic.Value // time.Time
+}
+
+// NewReceiver creates a receiver listening on listen (host:port) and
+// storing messages in the store returned by store
+func NewReceiver(listen string, store func() (storage.Store, error), db *sql.DB) *Receiver {
+	r := &Receiver{store: store, db: db}
+	r.srv = smtpserver.New(listen, smtpserver.Options{
+		Banner:         "archive",
+		MaxMessageSize: maxMessageSize,
+		AcceptRecipient: func(addr string) bool {
+			return strings.HasSuffix(strings.ToLower(addr), "@"+JournalDomain)
+		},
+		Save: r.save,
+	})
+	return r
+}
+
+// Start opens the listener and begins accepting connections
+func (r *Receiver) Start() error {
+	if err := r.srv.Start(); err != nil {
+		return fmt.Errorf("failed to listen for archive copies: %w", err)
+	}
+	log.Info().Str("listen", r.srv.Addr().String()).Msg("Archive receiver started")
+	return nil
+}
+
+// Addr is the address the receiver listens on, nil before Start
+func (r *Receiver) Addr() net.Addr {
+	return r.srv.Addr()
+}
+
+// Stop closes the listener and waits for open sessions to finish
+func (r *Receiver) Stop() {
+	if r.srv.Addr() == nil {
+		return
+	}
+	r.srv.Stop()
+	log.Info().Msg("Archive receiver stopped")
+}
+
+// Stats returns the number of messages stored since start and when the
+// last one was
+func (r *Receiver) Stats() (int64, time.Time) {
+	last, _ := r.lastStored.Load().(time.Time)
+	return r.stored.Load(), last
+}
+
+// save stores a message under archive/YYYY/MM/DD/, with the envelope
+// sender recorded in a Return-Path header, and returns its key
+func (r *Receiver) save(_, from string, _ []string, msg []byte) (string, error) {
+	key, err := r.put(from, msg)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to store archive copy")
+		Record(r.db, "failed", "", err.Error())
+		return "", err
+	}
+	return "stored as " + key, nil
+}
+
+func (r *Receiver) put(from string, msg []byte) (string, error) {
+	store, err := r.store()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	var id [6]byte
+	rand.Read(id[:])
+	key := fmt.Sprintf("%s%s/%s-%s.eml", ObjectPrefix, now.Format("2006/01/02"), now.Format("150405.000000"), hex.EncodeToString(id[:]))
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "Return-Path: <%s>\r\n", from)
+	body.Write(msg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := store.Put(ctx, key, &body, "message/rfc822"); err != nil {
+		return "", err
+	}
+
+	r.stored.Add(1)
+	r.lastStored.Store(now)
+	return key, nil
+}
@@ -0,0 +1,58 @@
+//go:build integration
+
+package archive_test
+
+import (
+	"context"
+	"io"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/postfixrelay/postfixrelay/internal/archive"
+	"github.com/postfixrelay/postfixrelay/internal/storage"
+	"github.com/postfixrelay/postfixrelay/internal/testharness"
+)
+
+func TestReceiverStoresJournalCopies(t *testing.T) {
+	db := testharness.NewDB(t)
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := archive.NewReceiver("127.0.0.1:0", func() (storage.Store, error) { return store, nil }, db.DB)
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Stop)
+	addr := r.Addr().String()
+
+	msg := "Subject: archived\r\n\r\nbody\r\n"
+	if err := smtp.SendMail(addr, nil, "alice@example.com", []string{archive.JournalAddress}, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	// Only the journal address is taken
+	if err := smtp.SendMail(addr, nil, "alice@example.com", []string{"bob@example.com"}, []byte(msg)); err == nil {
+		t.Error("a message for another recipient was accepted")
+	}
+
+	if n, _ := r.Stats(); n != 1 {
+		t.Fatalf("stored %d messages, want 1", n)
+	}
+	objects, err := store.List(context.Background(), archive.ObjectPrefix)
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("List = %v, %v", objects, err)
+	}
+	rc, err := store.Get(context.Background(), objects[0].Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	data, _ := io.ReadAll(rc)
+	if want := "Return-Path: <alice@example.com>\r\n" + msg; string(data) != want {
+		t.Errorf("stored %q, want %q", data, want)
+	}
+	if !strings.HasSuffix(objects[0].Key, ".eml") {
+		t.Errorf("key = %q", objects[0].Key)
+	}
+}
@@ -0,0 +1,184 @@
+// Package autoconfig renders the documents mail clients fetch to set
+// themselves up: Thunderbird's autoconfig (config-v1.1.xml) and Outlook's
+// POX autodiscover. Both describe the same IMAP and SMTP servers for a
+// managed domain.
+package autoconfig
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Connection security of an advertised port
+const (
+	SecuritySSL      = "ssl"      // implicit TLS, e.g. 993 or 465
+	SecurityStartTLS = "starttls" // STARTTLS, e.g. 143 or 587
+)
+
+// Securities lists the valid connection securities
+var Securities = []string{SecuritySSL, SecurityStartTLS}
+
+// Endpoint is a server a client connects to
+type Endpoint struct {
+	Hostname string
+	Port     int
+	Security string
+}
+
+// Settings describes the servers of one mail domain
+type Settings struct {
+	Domain string
+	IMAP   Endpoint
+	SMTP   Endpoint
+}
+
+type clientConfig struct {
+	XMLName  xml.Name      `xml:"clientConfig"`
+	Version  string        `xml:"version,attr"`
+	Provider emailProvider `xml:"emailProvider"`
+}
+
+type emailProvider struct {
+	ID               string       `xml:"id,attr"`
+	Domain           string       `xml:"domain"`
+	DisplayName      string       `xml:"displayName"`
+	DisplayShortName string       `xml:"displayShortName"`
+	Incoming         clientServer `xml:"incomingServer"`
+	Outgoing         clientServer `xml:"outgoingServer"`
+}
+
+type clientServer struct {
+	Type           string `xml:"type,attr"`
+	Hostname       string `xml:"hostname"`
+	Port           int    `xml:"port"`
+	SocketType     string `xml:"socketType"`
+	Authentication string `xml:"authentication"`
+	Username       string `xml:"username"`
+}
+
+// Thunderbird returns the config-v1.1.xml document for a domain. The
+// username placeholder makes clients log in with the full address.
+func Thunderbird(s Settings) ([]byte, error) {
+	server := func(kind string, e Endpoint) clientServer {
+		socketType := "SSL"
+		if e.Security == SecurityStartTLS {
+			socketType = "STARTTLS"
+		}
+		return clientServer{
+			Type:           kind,
+			Hostname:       e.Hostname,
+			Port:           e.Port,
+			SocketType:     socketType,
+			Authentication: "password-cleartext",
+			Username:       "%EMAILADDRESS%",
+		}
+	}
+
+	return marshal(clientConfig{
+		Version: "1.1",
+		Provider: emailProvider{
+			ID:               s.Domain,
+			Domain:           s.Domain,
+			DisplayName:      s.Domain,
+			DisplayShortName: s.Domain,
+			Incoming:         server("imap", s.IMAP),
+			Outgoing:         server("smtp", s.SMTP),
+		},
+	})
+}
+
+// Outlook's autodiscover schemas
+const (
+	responseSchema        = "http://schemas.microsoft.com/exchange/autodiscover/responseschema/2006"
+	outlookResponseSchema = "http://schemas.microsoft.com/exchange/autodiscover/outlook/responseschema/2006a"
+)
+
+type autodiscoverRequest struct {
+	EmailAddress string `xml:"Request>EMailAddress"`
+}
+
+type autodiscoverResponse struct {
+	XMLName  xml.Name        `xml:"Autodiscover"`
+	XMLNS    string          `xml:"xmlns,attr"`
+	Response outlookResponse `xml:"Response"`
+}
+
+type outlookResponse struct {
+	XMLNS   string         `xml:"xmlns,attr"`
+	Account outlookAccount `xml:"Account"`
+}
+
+type outlookAccount struct {
+	AccountType string            `xml:"AccountType"`
+	Action      string            `xml:"Action"`
+	Protocols   []outlookProtocol `xml:"Protocol"`
+}
+
+type outlookProtocol struct {
+	Type           string `xml:"Type"`
+	Server         string `xml:"Server"`
+	Port           int    `xml:"Port"`
+	DomainRequired string `xml:"DomainRequired"`
+	LoginName      string `xml:"LoginName"`
+	SPA            string `xml:"SPA"`
+	SSL            string `xml:"SSL"`
+	Encryption     string `xml:"Encryption"`
+	AuthRequired   string `xml:"AuthRequired"`
+}
+
+// ParseAutodiscoverRequest returns the address in an Outlook autodiscover
+// request body
+func ParseAutodiscoverRequest(body io.Reader) (string, error) {
+	var req autodiscoverRequest
+	if err := xml.NewDecoder(io.LimitReader(body, 64*1024)).Decode(&req); err != nil {
+		return "", fmt.Errorf("invalid autodiscover request: %w", err)
+	}
+	email := strings.TrimSpace(req.EmailAddress)
+	if email == "" {
+		return "", fmt.Errorf("autodiscover request has no EMailAddress")
+	}
+	return email, nil
+}
+
+// Outlook returns the autodiscover response for an address
+func Outlook(s Settings, email string) ([]byte, error) {
+	protocol := func(kind string, e Endpoint) outlookProtocol {
+		encryption := "SSL"
+		if e.Security == SecurityStartTLS {
+			encryption = "TLS"
+		}
+		return outlookProtocol{
+			Type:           kind,
+			Server:         e.Hostname,
+			Port:           e.Port,
+			DomainRequired: "off",
+			LoginName:      email,
+			SPA:            "off",
+			SSL:            "on",
+			Encryption:     encryption,
+			AuthRequired:   "on",
+		}
+	}
+
+	return marshal(autodiscoverResponse{
+		XMLNS: responseSchema,
+		Response: outlookResponse{
+			XMLNS: outlookResponseSchema,
+			Account: outlookAccount{
+				AccountType: "email",
+				Action:      "settings",
+				Protocols:   []outlookProtocol{protocol("IMAP", s.IMAP), protocol("SMTP", s.SMTP)},
+			},
+		},
+	})
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
@@ -0,0 +1,90 @@
+// Package bake watches Postfix after a config apply and decides whether
+// the new config made things worse: Postfix stopping, the queue growing,
+// or smtpd logging errors.
+package bake
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+)
+
+// Counter counts smtpd log lines and the errors among them. It is fed by
+// the log pipeline; a bake compares snapshots taken at its start and end.
+type Counter struct {
+	lines  atomic.Uint64
+	errors atomic.Uint64
+}
+
+// NewCounter creates a counter
+func NewCounter() *Counter {
+	return &Counter{}
+}
+
+// Consume counts one log entry; entries from other processes are ignored
+func (c *Counter) Consume(e logs.Entry) {
+	if !strings.HasSuffix(e.Process, "smtpd") {
+		return
+	}
+	c.lines.Add(1)
+	if isError(e) {
+		c.errors.Add(1)
+	}
+}
+
+// Snapshot returns the number of smtpd lines and errors counted so far
+func (c *Counter) Snapshot() (lines, errors uint64) {
+	return c.lines.Load(), c.errors.Load()
+}
+
+// isError reports whether an smtpd line shows smtpd failing rather than a
+// client misbehaving, e.g. a broken lookup table or restriction
+func isError(e logs.Entry) bool {
+	if e.Severity == "warning" || e.Severity == "error" {
+		return true
+	}
+	for _, prefix := range []string{"warning:", "error:", "fatal:", "panic:"} {
+		if strings.HasPrefix(e.Message, prefix) {
+			return true
+		}
+	}
+	return strings.Contains(e.Message, "Server configuration error") ||
+		strings.Contains(e.Message, "Temporary lookup failure")
+}
+
+// Sample is the health of Postfix at one point in a bake
+type Sample struct {
+	Running     bool
+	Queue       int // messages in the active, deferred and hold queues
+	SMTPDLines  uint64
+	SMTPDErrors uint64
+}
+
+// Limits are how far a sample may drift from the baseline before the
+// config is considered bad
+type Limits struct {
+	QueueGrowth   int // messages; 0 disables the check
+	ErrorPercent  int // share of smtpd lines that are errors; 0 disables the check
+	MinSMTPDLines int // lines needed before the error share is judged
+}
+
+// Regression compares a sample taken during a bake with the baseline taken
+// just before the apply. It returns why the config looks bad, or "".
+func Regression(baseline, now Sample, limits Limits) string {
+	if baseline.Running && !now.Running {
+		return "Postfix is not running"
+	}
+	if limits.QueueGrowth > 0 && now.Queue-baseline.Queue > limits.QueueGrowth {
+		return fmt.Sprintf("queue grew by %d messages (limit %d)", now.Queue-baseline.Queue, limits.QueueGrowth)
+	}
+	lines := now.SMTPDLines - baseline.SMTPDLines
+	errors := now.SMTPDErrors - baseline.SMTPDErrors
+	if limits.ErrorPercent > 0 && lines > 0 && lines >= uint64(limits.MinSMTPDLines) {
+		if percent := errors * 100 / lines; percent > uint64(limits.ErrorPercent) {
+			return fmt.Sprintf("%d%% of smtpd log lines are errors (limit %d%%)", percent, limits.ErrorPercent)
+		}
+	}
+	return ""
+}
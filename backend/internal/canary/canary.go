@@ -0,0 +1,332 @@
+// Package canary sends synthetic probe messages through the relay to an
+// external seed mailbox and measures how long they take to arrive.
+package canary
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Probe statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// SubjectPrefix starts the subject of every probe message; the probe's
+// token follows it
+const SubjectPrefix = "PostfixRelay delivery probe"
+
+// Config controls the canary. It is re-read before every probe so setting
+// changes apply without a restart.
+type Config struct {
+	Enabled  bool
+	Interval time.Duration
+	Timeout  time.Duration // How long to wait for a probe to arrive
+	From     string
+	To       string // External seed mailbox
+
+	IMAPAddress  string // host:port of the seed mailbox's IMAP server
+	IMAPUsername string
+	IMAPPassword string
+	IMAPTLS      bool // Implicit TLS (usually port 993)
+	Mailbox      string
+}
+
+// Validate checks that probes can be sent and checked
+func (c Config) Validate() error {
+	if c.To == "" {
+		return fmt.Errorf("no seed mailbox configured")
+	}
+	if c.IMAPAddress == "" || c.IMAPUsername == "" {
+		return fmt.Errorf("no IMAP account configured for the seed mailbox")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("probe timeout must be positive")
+	}
+	return nil
+}
+
+// Probe is one synthetic delivery
+type Probe struct {
+	ID          int64      `json:"id"`
+	Token       string     `json:"token"`
+	Recipient   string     `json:"recipient"`
+	SentAt      time.Time  `json:"sentAt"`
+	DeliveredAt *time.Time `json:"deliveredAt,omitempty"`
+	LatencyMs   *int64     `json:"latencyMs,omitempty"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// SendFunc submits a probe message to the relay
+type SendFunc func(from, to, subject, body string) error
+
+// ConfigFunc returns the current canary configuration
+type ConfigFunc func() Config
+
+// Monitor sends probes on a schedule and records the results
+type Monitor struct {
+	db     *sql.DB
+	send   SendFunc
+	config ConfigFunc
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewMonitor creates a canary monitor
+func NewMonitor(db *sql.DB, send SendFunc, config ConfigFunc) *Monitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Monitor{
+		db:     db,
+		send:   send,
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start begins the probe loop. Probes left pending by a previous run can
+// no longer be checked and are marked failed.
+func (m *Monitor) Start() {
+	m.db.Exec(`UPDATE canary_probes SET status = ?, error = ? WHERE status = ?`,
+		StatusFailed, "interrupted by restart", StatusPending)
+
+	m.wg.Add(1)
+	go m.loop()
+	log.Info().Msg("Delivery canary started")
+}
+
+// Stop stops the loop, abandoning probes still waiting for delivery
+func (m *Monitor) Stop() {
+	m.stopOnce.Do(func() {
+		m.cancel()
+		m.wg.Wait()
+		log.Info().Msg("Delivery canary stopped")
+	})
+}
+
+func (m *Monitor) loop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			cfg := m.config()
+			if !cfg.Enabled || time.Since(m.lastProbeTime()) < cfg.Interval {
+				continue
+			}
+			if err := cfg.Validate(); err != nil {
+				log.Warn().Err(err).Msg("Delivery canary is enabled but not configured")
+				continue
+			}
+			if probe, err := m.sendProbe(cfg); err == nil {
+				m.await(probe, cfg)
+			}
+		}
+	}
+}
+
+// Trigger sends a probe immediately and waits for its delivery in the
+// background. The returned probe is still pending.
+func (m *Monitor) Trigger() (*Probe, error) {
+	cfg := m.config()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	probe, err := m.sendProbe(cfg)
+	if err != nil {
+		return probe, err
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.await(probe, cfg)
+	}()
+	return probe, nil
+}
+
+// sendProbe records a new probe and submits its message. A send failure is
+// recorded on the probe and returned.
+func (m *Monitor) sendProbe(cfg Config) (*Probe, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	probe := &Probe{
+		Token:     token,
+		Recipient: cfg.To,
+		SentAt:    time.Now().UTC(),
+		Status:    StatusPending,
+	}
+	result, err := m.db.Exec(`
+		INSERT INTO canary_probes (token, recipient, sent_at, status) VALUES (?, ?, ?, ?)
+	`, probe.Token, probe.Recipient, probe.SentAt.Format(time.RFC3339Nano), probe.Status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record probe: %w", err)
+	}
+	probe.ID, _ = result.LastInsertId()
+
+	subject := SubjectPrefix + " " + token
+	body := fmt.Sprintf("Synthetic delivery probe sent at %s.\r\nThis message can be deleted.\r\n", probe.SentAt.Format(time.RFC3339))
+	if err := m.send(cfg.From, cfg.To, subject, body); err != nil {
+		m.fail(probe, fmt.Errorf("send failed: %w", err))
+		return probe, err
+	}
+	return probe, nil
+}
+
+// await polls the seed mailbox until the probe arrives or times out
+func (m *Monitor) await(probe *Probe, cfg Config) {
+	deadline := probe.SentAt.Add(cfg.Timeout)
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		arrived, err := checkMailbox(cfg, SubjectPrefix+" "+probe.Token)
+		if err != nil {
+			lastErr = err
+			log.Debug().Err(err).Str("token", probe.Token).Msg("Canary mailbox check failed")
+		} else if arrived != nil {
+			m.deliver(probe, *arrived)
+			return
+		}
+
+		if time.Now().After(deadline) {
+			if lastErr != nil {
+				m.fail(probe, fmt.Errorf("not delivered within %s (last mailbox error: %v)", cfg.Timeout, lastErr))
+			} else {
+				m.fail(probe, fmt.Errorf("not delivered within %s", cfg.Timeout))
+			}
+			return
+		}
+	}
+}
+
+func (m *Monitor) deliver(probe *Probe, arrivedAt time.Time) {
+	if arrivedAt.Before(probe.SentAt) {
+		// INTERNALDATE has one-second resolution
+		arrivedAt = probe.SentAt
+	}
+	latency := arrivedAt.Sub(probe.SentAt).Milliseconds()
+	probe.Status = StatusDelivered
+	probe.DeliveredAt = &arrivedAt
+	probe.LatencyMs = &latency
+
+	m.db.Exec(`
+		UPDATE canary_probes SET status = ?, delivered_at = ?, latency_ms = ? WHERE id = ?
+	`, probe.Status, arrivedAt.UTC().Format(time.RFC3339Nano), latency, probe.ID)
+	log.Info().Str("token", probe.Token).Int64("latencyMs", latency).Msg("Canary probe delivered")
+}
+
+func (m *Monitor) fail(probe *Probe, err error) {
+	probe.Status = StatusFailed
+	probe.Error = err.Error()
+	m.db.Exec(`UPDATE canary_probes SET status = ?, error = ? WHERE id = ?`, probe.Status, probe.Error, probe.ID)
+	log.Warn().Err(err).Str("token", probe.Token).Msg("Canary probe failed")
+}
+
+func (m *Monitor) lastProbeTime() time.Time {
+	var sentAt string
+	m.db.QueryRow(`SELECT sent_at FROM canary_probes ORDER BY id DESC LIMIT 1`).Scan(&sentAt)
+	t, _ := time.Parse(time.RFC3339Nano, sentAt)
+	return t
+}
+
+// Stats summarizes probes over a period
+type Stats struct {
+	Sent         int     `json:"sent"`
+	Delivered    int     `json:"delivered"`
+	Failed       int     `json:"failed"`
+	Pending      int     `json:"pending"`
+	AvgLatencyMs float64 `json:"avgLatencyMs"`
+	MaxLatencyMs int64   `json:"maxLatencyMs"`
+}
+
+// Stats returns probe statistics since a time
+func (m *Monitor) Stats(since time.Time) (Stats, error) {
+	var st Stats
+	var avg sql.NullFloat64
+	var max sql.NullInt64
+	err := m.db.QueryRow(`
+		SELECT COUNT(*),
+			COALESCE(SUM(CASE WHEN status = 'delivered' THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN status = 'failed' THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN status = 'pending' THEN 1 ELSE 0 END), 0),
+			AVG(latency_ms), MAX(latency_ms)
+		FROM canary_probes WHERE sent_at >= ?
+	`, since.UTC().Format(time.RFC3339Nano)).Scan(&st.Sent, &st.Delivered, &st.Failed, &st.Pending, &avg, &max)
+	if err != nil {
+		return st, err
+	}
+	st.AvgLatencyMs = avg.Float64
+	st.MaxLatencyMs = max.Int64
+	return st, nil
+}
+
+// Recent returns the most recent probes, newest first
+func (m *Monitor) Recent(limit int) ([]Probe, error) {
+	rows, err := m.db.Query(`
+		SELECT id, token, recipient, sent_at, delivered_at, latency_ms, status, COALESCE(error, '')
+		FROM canary_probes ORDER BY id DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	probes := []Probe{}
+	for rows.Next() {
+		var p Probe
+		var sentAt string
+		var deliveredAt sql.NullString
+		var latency sql.NullInt64
+		if err := rows.Scan(&p.ID, &p.Token, &p.Recipient, &sentAt, &deliveredAt, &latency, &p.Status, &p.Error); err != nil {
+			continue
+		}
+		p.SentAt, _ = time.Parse(time.RFC3339Nano, sentAt)
+		if deliveredAt.Valid {
+			t, _ := time.Parse(time.RFC3339Nano, deliveredAt.String)
+			p.DeliveredAt = &t
+		}
+		if latency.Valid {
+			p.LatencyMs = &latency.Int64
+		}
+		probes = append(probes, p)
+	}
+	return probes, nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,132 @@
+package canary
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// checkMailbox looks for a message with the given subject in the seed
+// mailbox. If found, it returns the time the server received it and deletes
+// the message so probes don't accumulate.
+func checkMailbox(cfg Config, subject string) (*time.Time, error) {
+	c, err := openMailbox(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Logout()
+
+	uids, err := searchSubject(c, subject)
+	if err != nil || len(uids) == 0 {
+		return nil, err
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+
+	messages := make(chan *imap.Message, len(uids))
+	if err := c.UidFetch(seqSet, []imap.FetchItem{imap.FetchInternalDate}, messages); err != nil {
+		return nil, fmt.Errorf("fetch failed: %w", err)
+	}
+	arrived := time.Now().UTC()
+	for msg := range messages {
+		if !msg.InternalDate.IsZero() && msg.InternalDate.Before(arrived) {
+			arrived = msg.InternalDate.UTC()
+		}
+	}
+
+	// Best effort: a leftover probe is harmless
+	flags := []interface{}{imap.DeletedFlag}
+	if err := c.UidStore(seqSet, imap.FormatFlagsOp(imap.AddFlags, true), flags, nil); err == nil {
+		c.Expunge(nil)
+	}
+
+	return &arrived, nil
+}
+
+// FetchMessage returns the raw content of the message with the given
+// subject in the seed mailbox, or nil if it hasn't arrived, and deletes it
+func FetchMessage(cfg Config, subject string) ([]byte, error) {
+	c, err := openMailbox(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Logout()
+
+	uids, err := searchSubject(c, subject)
+	if err != nil || len(uids) == 0 {
+		return nil, err
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids[0])
+	section := &imap.BodySectionName{Peek: true}
+	messages := make(chan *imap.Message, 1)
+	if err := c.UidFetch(seqSet, []imap.FetchItem{section.FetchItem()}, messages); err != nil {
+		return nil, fmt.Errorf("fetch failed: %w", err)
+	}
+	var raw []byte
+	for msg := range messages {
+		if body := msg.GetBody(section); body != nil {
+			if raw, err = io.ReadAll(body); err != nil {
+				return nil, fmt.Errorf("fetch failed: %w", err)
+			}
+		}
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("the server returned no message content")
+	}
+
+	flags := []interface{}{imap.DeletedFlag}
+	if err := c.UidStore(seqSet, imap.FormatFlagsOp(imap.AddFlags, true), flags, nil); err == nil {
+		c.Expunge(nil)
+	}
+	return raw, nil
+}
+
+// openMailbox logs in to the seed mailbox and selects its folder
+func openMailbox(cfg Config) (*client.Client, error) {
+	var c *client.Client
+	var err error
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if cfg.IMAPTLS {
+		host, _, _ := net.SplitHostPort(cfg.IMAPAddress)
+		c, err = client.DialWithDialerTLS(dialer, cfg.IMAPAddress, &tls.Config{ServerName: host})
+	} else {
+		c, err = client.DialWithDialer(dialer, cfg.IMAPAddress)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", cfg.IMAPAddress, err)
+	}
+	c.Timeout = 30 * time.Second
+
+	if err := c.Login(cfg.IMAPUsername, cfg.IMAPPassword); err != nil {
+		c.Logout()
+		return nil, fmt.Errorf("login failed: %w", err)
+	}
+
+	mailbox := cfg.Mailbox
+	if mailbox == "" {
+		mailbox = "INBOX"
+	}
+	if _, err := c.Select(mailbox, false); err != nil {
+		c.Logout()
+		return nil, fmt.Errorf("failed to select %s: %w", mailbox, err)
+	}
+	return c, nil
+}
+
+func searchSubject(c *client.Client, subject string) ([]uint32, error) {
+	criteria := imap.NewSearchCriteria()
+	criteria.Header.Set("Subject", subject)
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	return uids, nil
+}
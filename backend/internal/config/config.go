@@ -1,71 +1,399 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
 )
 
+// Minimum length for APP_SECRET and DB_ENCRYPTION_KEY
+const minSecretLength = 32
+
 // Config holds application configuration
 type Config struct {
-	// Server settings
-	ListenAddr string
+	// Server settings. ListenAddr is host:port, or unix:/path for a unix
+	// domain socket created with ListenSocketMode (octal). Under systemd
+	// socket activation the inherited socket is used instead.
+	ListenAddr       string `yaml:"listen_addr"`
+	ListenSocketMode string `yaml:"listen_socket_mode"`
+
+	// HTTPS served by the backend itself, from certificate files or with
+	// certificates from an ACME CA such as Let's Encrypt. HTTPRedirectAddr
+	// is a plain HTTP listener redirecting to HTTPS, which also answers
+	// ACME http-01 challenges.
+	TLSCertFile      string   `yaml:"tls_cert_file"`
+	TLSKeyFile       string   `yaml:"tls_key_file"`
+	ACMEDomains      []string `yaml:"acme_domains"`
+	ACMEEmail        string   `yaml:"acme_email"`
+	ACMECacheDir     string   `yaml:"acme_cache_dir"` // default: acme next to the database
+	ACMEDirectoryURL string   `yaml:"acme_directory_url"`
+	HTTPRedirectAddr string   `yaml:"http_redirect_addr"`
 
 	// Database
-	DBPath string
+	DBPath string `yaml:"db_path"`
 
 	// Security
-	AppSecret       string
-	DBEncryptionKey string
+	AppSecret       string `yaml:"app_secret"`
+	DBEncryptionKey string `yaml:"db_encryption_key"`
 
 	// Postfix paths
-	PostfixConfigDir string
-	PostfixBinary    string
+	PostfixConfigDir string `yaml:"postfix_config_dir"`
+	PostfixBinary    string `yaml:"postfix_binary"`
+
+	// HooksDir holds the executables script hooks may run; hooks name a
+	// file in it, never an arbitrary path
+	HooksDir string `yaml:"hooks_dir"`
 
 	// Log settings
-	LogSource string // "auto", "journald", or file path
-	LogPath   string // Path to mail log file
+	LogSource string `yaml:"log_source"` // "auto", "journald", or file path
+	LogPath   string `yaml:"log_path"`   // Path to mail log file
 
 	// Retention
-	LogRetentionDays   int
-	AuditRetentionDays int
+	LogRetentionDays   int `yaml:"log_retention_days"`
+	AuditRetentionDays int `yaml:"audit_retention_days"`
 
 	// Session
-	SessionTimeoutHours int
+	SessionTimeoutHours int `yaml:"session_timeout_hours"`
+
+	// Cookies. CookieSecure is "auto" (Secure in production or over TLS),
+	// "true" or "false"; behind a proxy that terminates TLS set "true".
+	// CookieSameSite is "strict", "lax" or "none".
+	CookieSecure   string `yaml:"cookie_secure"`
+	CookieSameSite string `yaml:"cookie_samesite"`
+
+	// TrustedProxies are the addresses or CIDR ranges of reverse proxies
+	// whose X-Forwarded-For and X-Real-IP headers name the client
+	TrustedProxies []string `yaml:"trusted_proxies"`
+
+	// File is the config file the settings were read from (empty if env-only)
+	File string `yaml:"-"`
+
+	// Demo serves seeded sample data instead of a real mail server (-demo)
+	Demo bool `yaml:"-"`
+	// DemoQueueSize is how many messages the demo queue holds, when set
+	// (-demo-queue-size)
+	DemoQueueSize int `yaml:"-"`
+}
+
+// ValidationError collects every problem found in a configuration so they
+// can be reported together instead of one per restart.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+func (e *ValidationError) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
 }
 
-// Load reads configuration from environment variables
+// Defaults returns the built-in configuration used before the config file
+// and environment are applied
+func Defaults() *Config {
+	return &Config{
+		ListenAddr:          ":8080",
+		ListenSocketMode:    "0660",
+		DBPath:              "./data/postfixrelay.db",
+		PostfixConfigDir:    "/etc/postfix",
+		PostfixBinary:       "/usr/sbin/postfix",
+		HooksDir:            "/etc/postfixrelay/hooks",
+		LogSource:           "auto",
+		LogPath:             "/var/log/mail.log",
+		LogRetentionDays:    7,
+		AuditRetentionDays:  90,
+		SessionTimeoutHours: 8,
+		CookieSecure:        "auto",
+		CookieSameSite:      "strict",
+	}
+}
+
+// Load reads configuration from the file named by CONFIG_FILE (if set)
+// and environment variables
 func Load() (*Config, error) {
-	// Get required security secrets - fail startup if not set or too weak
-	appSecret, err := getEnvRequiredMinLength("APP_SECRET", 32)
-	if err != nil {
-		return nil, fmt.Errorf("security configuration error: %w", err)
+	return LoadFile(os.Getenv("CONFIG_FILE"))
+}
+
+// LoadFile reads configuration from a YAML file, applies environment
+// variable overrides and validates the result. An empty path skips the file.
+func LoadFile(path string) (*Config, error) {
+	cfg := Defaults()
+
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+		cfg.File = path
 	}
 
-	dbEncryptionKey, err := getEnvRequiredMinLength("DB_ENCRYPTION_KEY", 32)
-	if err != nil {
-		return nil, fmt.Errorf("security configuration error: %w", err)
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
 	}
 
-	cfg := &Config{
-		ListenAddr:          getEnv("LISTEN_ADDR", ":8080"),
-		DBPath:              getEnv("DB_PATH", "./data/postfixrelay.db"),
-		AppSecret:           appSecret,
-		DBEncryptionKey:     dbEncryptionKey,
-		PostfixConfigDir:    getEnv("POSTFIX_CONFIG_DIR", "/etc/postfix"),
-		PostfixBinary:       getEnv("POSTFIX_BINARY", "/usr/sbin/postfix"),
-		LogSource:           getEnv("LOG_SOURCE", "auto"),
-		LogPath:             getEnv("LOG_PATH", "/var/log/mail.log"),
-		LogRetentionDays:    getEnvInt("LOG_RETENTION_DAYS", 7),
-		AuditRetentionDays:  getEnvInt("AUDIT_RETENTION_DAYS", 90),
-		SessionTimeoutHours: getEnvInt("SESSION_TIMEOUT_HOURS", 8),
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
 
-	log.Info().Msg("Configuration loaded successfully")
+	log.Info().Str("file", cfg.File).Msg("Configuration loaded successfully")
 	return cfg, nil
 }
 
+// readFile decodes the YAML config file on top of the current values.
+// Unknown keys are rejected so typos don't silently fall back to defaults.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides values with any environment variables that are set
+func (c *Config) applyEnv() error {
+	verr := &ValidationError{}
+
+	c.ListenAddr = getEnv("LISTEN_ADDR", c.ListenAddr)
+	c.ListenSocketMode = getEnv("LISTEN_SOCKET_MODE", c.ListenSocketMode)
+	c.TLSCertFile = getEnv("TLS_CERT_FILE", c.TLSCertFile)
+	c.TLSKeyFile = getEnv("TLS_KEY_FILE", c.TLSKeyFile)
+	c.ACMEDomains = getEnvList("ACME_DOMAINS", c.ACMEDomains)
+	c.ACMEEmail = getEnv("ACME_EMAIL", c.ACMEEmail)
+	c.ACMECacheDir = getEnv("ACME_CACHE_DIR", c.ACMECacheDir)
+	c.ACMEDirectoryURL = getEnv("ACME_DIRECTORY_URL", c.ACMEDirectoryURL)
+	c.HTTPRedirectAddr = getEnv("HTTP_REDIRECT_ADDR", c.HTTPRedirectAddr)
+	c.DBPath = getEnv("DB_PATH", c.DBPath)
+	c.AppSecret = getEnv("APP_SECRET", c.AppSecret)
+	c.DBEncryptionKey = getEnv("DB_ENCRYPTION_KEY", c.DBEncryptionKey)
+	c.PostfixConfigDir = getEnv("POSTFIX_CONFIG_DIR", c.PostfixConfigDir)
+	c.PostfixBinary = getEnv("POSTFIX_BINARY", c.PostfixBinary)
+	c.HooksDir = getEnv("HOOKS_DIR", c.HooksDir)
+	c.LogSource = getEnv("LOG_SOURCE", c.LogSource)
+	c.LogPath = getEnv("LOG_PATH", c.LogPath)
+	c.LogRetentionDays = getEnvInt("LOG_RETENTION_DAYS", c.LogRetentionDays, verr)
+	c.AuditRetentionDays = getEnvInt("AUDIT_RETENTION_DAYS", c.AuditRetentionDays, verr)
+	c.SessionTimeoutHours = getEnvInt("SESSION_TIMEOUT_HOURS", c.SessionTimeoutHours, verr)
+	c.CookieSecure = getEnv("COOKIE_SECURE", c.CookieSecure)
+	c.CookieSameSite = getEnv("COOKIE_SAMESITE", c.CookieSameSite)
+	c.TrustedProxies = getEnvList("TRUSTED_PROXIES", c.TrustedProxies)
+
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// Validate checks the configuration and returns a *ValidationError listing
+// every problem found
+func (c *Config) Validate() error {
+	verr := &ValidationError{}
+
+	if c.ListenAddr == "" {
+		verr.add("listen_addr (LISTEN_ADDR) is required")
+	} else if path, ok := c.ListenSocket(); ok {
+		if path == "" {
+			verr.add("listen_addr (LISTEN_ADDR) needs a socket path, e.g. \"unix:/run/postfixrelay/api.sock\"")
+		}
+		if _, err := c.SocketMode(); err != nil {
+			verr.add("listen_socket_mode (LISTEN_SOCKET_MODE) must be an octal file mode, e.g. \"0660\" (got %q)", c.ListenSocketMode)
+		}
+	} else if _, port, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		verr.add("listen_addr (LISTEN_ADDR) must be host:port, e.g. \":8080\" (got %q)", c.ListenAddr)
+	} else if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		verr.add("listen_addr (LISTEN_ADDR) has an invalid port %q", port)
+	}
+
+	if c.DBPath == "" {
+		verr.add("db_path (DB_PATH) is required")
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		verr.add("tls_cert_file (TLS_CERT_FILE) and tls_key_file (TLS_KEY_FILE) must be set together")
+	}
+	if c.TLSCertFile != "" && len(c.ACMEDomains) > 0 {
+		verr.add("tls_cert_file (TLS_CERT_FILE) and acme_domains (ACME_DOMAINS) can't both be set")
+	}
+	if c.HTTPRedirectAddr != "" {
+		if !c.TLSEnabled() {
+			verr.add("http_redirect_addr (HTTP_REDIRECT_ADDR) needs TLS: set tls_cert_file or acme_domains")
+		} else if _, _, err := net.SplitHostPort(c.HTTPRedirectAddr); err != nil {
+			verr.add("http_redirect_addr (HTTP_REDIRECT_ADDR) must be host:port, e.g. \":80\" (got %q)", c.HTTPRedirectAddr)
+		}
+	}
+
+	// Security secrets - fail startup if not set or too weak
+	checkSecret(verr, "app_secret", "APP_SECRET", c.AppSecret)
+	checkSecret(verr, "db_encryption_key", "DB_ENCRYPTION_KEY", c.DBEncryptionKey)
+
+	if !filepath.IsAbs(c.PostfixConfigDir) {
+		verr.add("postfix_config_dir (POSTFIX_CONFIG_DIR) must be an absolute path (got %q)", c.PostfixConfigDir)
+	}
+	if !filepath.IsAbs(c.PostfixBinary) {
+		verr.add("postfix_binary (POSTFIX_BINARY) must be an absolute path (got %q)", c.PostfixBinary)
+	}
+	if !filepath.IsAbs(c.HooksDir) {
+		verr.add("hooks_dir (HOOKS_DIR) must be an absolute path (got %q)", c.HooksDir)
+	}
+
+	switch c.LogSource {
+	case "auto", "journald":
+	default:
+		if !filepath.IsAbs(c.LogSource) {
+			verr.add("log_source (LOG_SOURCE) must be \"auto\", \"journald\" or an absolute file path (got %q)", c.LogSource)
+		}
+	}
+	if c.LogPath != "" && !filepath.IsAbs(c.LogPath) {
+		verr.add("log_path (LOG_PATH) must be an absolute path (got %q)", c.LogPath)
+	}
+
+	if c.LogRetentionDays < 1 || c.LogRetentionDays > 365 {
+		verr.add("log_retention_days (LOG_RETENTION_DAYS) must be between 1 and 365 (got %d)", c.LogRetentionDays)
+	}
+	if c.AuditRetentionDays < 1 || c.AuditRetentionDays > 3650 {
+		verr.add("audit_retention_days (AUDIT_RETENTION_DAYS) must be between 1 and 3650 (got %d)", c.AuditRetentionDays)
+	}
+	if c.SessionTimeoutHours < 1 || c.SessionTimeoutHours > 720 {
+		verr.add("session_timeout_hours (SESSION_TIMEOUT_HOURS) must be between 1 and 720 (got %d)", c.SessionTimeoutHours)
+	}
+
+	switch c.CookieSecure {
+	case "auto", "true", "false":
+	default:
+		verr.add("cookie_secure (COOKIE_SECURE) must be \"auto\", \"true\" or \"false\" (got %q)", c.CookieSecure)
+	}
+	switch c.CookieSameSite {
+	case "strict", "lax":
+	case "none":
+		// Browsers drop SameSite=None cookies that aren't Secure
+		if c.CookieSecure != "true" {
+			verr.add("cookie_samesite (COOKIE_SAMESITE) \"none\" requires cookie_secure (COOKIE_SECURE) \"true\"")
+		}
+	default:
+		verr.add("cookie_samesite (COOKIE_SAMESITE) must be \"strict\", \"lax\" or \"none\" (got %q)", c.CookieSameSite)
+	}
+	if _, err := ParseTrustedProxies(c.TrustedProxies); err != nil {
+		verr.add("trusted_proxies (TRUSTED_PROXIES) %v", err)
+	}
+
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// Redacted returns the effective settings with secrets masked, suitable for
+// display in the UI or logs
+func (c *Config) Redacted() map[string]interface{} {
+	return map[string]interface{}{
+		"configFile":          c.File,
+		"listenAddr":          c.ListenAddr,
+		"listenSocketMode":    c.ListenSocketMode,
+		"tlsCertFile":         c.TLSCertFile,
+		"tlsKeyFile":          c.TLSKeyFile,
+		"acmeDomains":         c.ACMEDomains,
+		"acmeEmail":           c.ACMEEmail,
+		"acmeCacheDir":        c.ACMECacheDir,
+		"acmeDirectoryUrl":    c.ACMEDirectoryURL,
+		"httpRedirectAddr":    c.HTTPRedirectAddr,
+		"dbPath":              c.DBPath,
+		"appSecret":           redact(c.AppSecret),
+		"dbEncryptionKey":     redact(c.DBEncryptionKey),
+		"postfixConfigDir":    c.PostfixConfigDir,
+		"postfixBinary":       c.PostfixBinary,
+		"hooksDir":            c.HooksDir,
+		"logSource":           c.LogSource,
+		"logPath":             c.LogPath,
+		"logRetentionDays":    c.LogRetentionDays,
+		"auditRetentionDays":  c.AuditRetentionDays,
+		"sessionTimeoutHours": c.SessionTimeoutHours,
+		"cookieSecure":        c.CookieSecure,
+		"cookieSameSite":      c.CookieSameSite,
+		"trustedProxies":      c.TrustedProxies,
+	}
+}
+
+// TLSEnabled reports whether the backend serves HTTPS itself
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || len(c.ACMEDomains) > 0
+}
+
+// ListenSocket returns the unix socket path of a "unix:" listen_addr
+func (c *Config) ListenSocket() (string, bool) {
+	if !strings.HasPrefix(c.ListenAddr, "unix:") {
+		return "", false
+	}
+	return strings.TrimPrefix(c.ListenAddr, "unix:"), true
+}
+
+// SocketMode parses listen_socket_mode
+func (c *Config) SocketMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(c.ListenSocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid socket mode %q", c.ListenSocketMode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// ParseTrustedProxies parses trusted proxy addresses and CIDR ranges. A
+// bare address is a range of one. "unix", which stands for clients of a
+// unix socket listener, is left to the caller.
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, p := range proxies {
+		if p == "unix" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("has an invalid address %q", p)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("has an invalid CIDR range %q", p)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "********"
+}
+
+func checkSecret(verr *ValidationError, key, env, value string) {
+	if value == "" {
+		verr.add("%s (%s) is required but not set", key, env)
+	} else if len(value) < minSecretLength {
+		verr.add("%s (%s) must be at least %d characters (got %d)", key, env, minSecretLength, len(value))
+	}
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -73,33 +401,33 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-// getEnvRequiredMinLength returns an error if the environment variable is not set
-// or if its value is shorter than the minimum required length
-func getEnvRequiredMinLength(key string, minLength int) (string, error) {
+// getEnvInt returns the integer value of an environment variable, recording
+// a problem if it is set but not a valid integer
+func getEnvInt(key string, defaultValue int, verr *ValidationError) int {
 	value := os.Getenv(key)
 	if value == "" {
-		return "", fmt.Errorf("%s environment variable is required but not set", key)
+		return defaultValue
 	}
-	if len(value) < minLength {
-		return "", fmt.Errorf("%s must be at least %d characters (got %d)", key, minLength, len(value))
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		verr.add("%s must be an integer (got %q)", key, value)
+		return defaultValue
 	}
-	return value, nil
+	return i
 }
 
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		var i int
-		if _, err := os.Stderr.WriteString(""); err == nil {
-			// Simple string to int conversion
-			for _, c := range value {
-				if c >= '0' && c <= '9' {
-					i = i*10 + int(c-'0')
-				} else {
-					return defaultValue
-				}
-			}
-			return i
+// getEnvList returns the comma-separated values of an environment
+// variable, or defaultValue if it is not set
+func getEnvList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
 		}
 	}
-	return defaultValue
+	return list
 }
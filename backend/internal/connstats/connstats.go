@@ -0,0 +1,338 @@
+// Package connstats aggregates smtpd connection log lines into per-client
+// statistics.
+package connstats
+
+import (
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	clientRe   = regexp.MustCompile(`from ([^\[\s]+)\[([^\]]+)\]`)
+	authRe     = regexp.MustCompile(`\bauth=(\d+)(?:/(\d+))?`)
+	starttlsRe = regexp.MustCompile(`\bstarttls=(\d+)`)
+)
+
+// Counts are connection counters for one client
+type Counts struct {
+	Connections   int `json:"connections"`
+	TLS           int `json:"tls"`
+	Plaintext     int `json:"plaintext"`
+	Lost          int `json:"lost"`
+	AuthAttempts  int `json:"authAttempts"`
+	AuthSuccesses int `json:"authSuccesses"`
+}
+
+func (c *Counts) add(o Counts) {
+	c.Connections += o.Connections
+	c.TLS += o.TLS
+	c.Plaintext += o.Plaintext
+	c.Lost += o.Lost
+	c.AuthAttempts += o.AuthAttempts
+	c.AuthSuccesses += o.AuthSuccesses
+}
+
+// session tracks one smtpd connection between its connect and disconnect
+// lines
+type session struct {
+	ip            string
+	tls           bool
+	authAttempts  int
+	authSuccesses int
+}
+
+type bucketKey struct {
+	minute time.Time
+	ip     string
+}
+
+type bucket struct {
+	hostname string
+	counts   Counts
+}
+
+// Collector consumes smtpd log entries and keeps per-client counts in
+// one-minute buckets, flushed to the database every minute
+type Collector struct {
+	db *sql.DB
+
+	mu       sync.Mutex
+	sessions map[string]*session // keyed by process/pid
+	pending  map[bucketKey]*bucket
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewCollector creates a collector
+func NewCollector(db *sql.DB) *Collector {
+	return &Collector{
+		db:       db,
+		sessions: make(map[string]*session),
+		pending:  make(map[bucketKey]*bucket),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start begins flushing counts to the database
+func (c *Collector) Start() {
+	c.done = make(chan struct{})
+	go c.loop()
+}
+
+// Stop flushes pending counts and stops the collector
+func (c *Collector) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+		if c.done != nil {
+			<-c.done
+		}
+	})
+}
+
+func (c *Collector) loop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+
+	for {
+		select {
+		case <-c.stopCh:
+			c.flush()
+			return
+		case now := <-ticker.C:
+			c.flush()
+			if now.Sub(lastPrune) >= time.Hour {
+				c.prune(now)
+				lastPrune = now
+			}
+		}
+	}
+}
+
+// Consume processes one log entry; entries from other processes are
+// ignored
+func (c *Collector) Consume(e logs.Entry) {
+	if !strings.HasSuffix(e.Process, "smtpd") {
+		return
+	}
+	msg := e.Message
+	key := e.Process + "/" + strconv.Itoa(e.PID)
+	ts := e.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(msg, "connect from "):
+		host, ip := parseClient(msg)
+		if ip == "" {
+			return
+		}
+		c.sessions[key] = &session{ip: ip}
+		c.record(ts, ip, host, Counts{Connections: 1})
+
+	case strings.HasPrefix(msg, "disconnect from "):
+		host, ip := parseClient(msg)
+		sess := c.sessions[key]
+		delete(c.sessions, key)
+		if ip == "" {
+			return
+		}
+		if sess == nil {
+			sess = &session{ip: ip}
+		}
+
+		counts := Counts{AuthAttempts: sess.authAttempts, AuthSuccesses: sess.authSuccesses}
+		// Postfix 3.0+ summarizes the session's commands on this line
+		if m := starttlsRe.FindStringSubmatch(msg); m != nil && m[1] != "0" {
+			sess.tls = true
+		}
+		if m := authRe.FindStringSubmatch(msg); m != nil {
+			counts.AuthSuccesses, _ = strconv.Atoi(m[1])
+			counts.AuthAttempts = counts.AuthSuccesses
+			if m[2] != "" {
+				counts.AuthAttempts, _ = strconv.Atoi(m[2])
+			}
+		}
+		if sess.tls {
+			counts.TLS = 1
+		} else {
+			counts.Plaintext = 1
+		}
+		c.record(ts, ip, host, counts)
+
+	case strings.HasPrefix(msg, "lost connection after "):
+		host, ip := parseClient(msg)
+		if ip != "" {
+			c.record(ts, ip, host, Counts{Lost: 1})
+		}
+
+	case strings.Contains(msg, "TLS connection established from "):
+		if sess := c.sessions[key]; sess != nil {
+			sess.tls = true
+		}
+
+	case strings.Contains(msg, "authentication failed"):
+		if sess := c.sessions[key]; sess != nil {
+			sess.authAttempts++
+		}
+
+	case strings.Contains(msg, "sasl_username="):
+		if sess := c.sessions[key]; sess != nil {
+			sess.authAttempts++
+			sess.authSuccesses++
+		}
+	}
+}
+
+// parseClient extracts the hostname and IP from "from host[ip]"
+func parseClient(msg string) (string, string) {
+	m := clientRe.FindStringSubmatch(msg)
+	if m == nil || m[2] == "unknown" {
+		return "", ""
+	}
+	return m[1], m[2]
+}
+
+func (c *Collector) record(ts time.Time, ip, host string, counts Counts) {
+	key := bucketKey{minute: ts.UTC().Truncate(time.Minute), ip: ip}
+	b := c.pending[key]
+	if b == nil {
+		b = &bucket{}
+		c.pending[key] = b
+	}
+	if host != "" && host != "unknown" {
+		b.hostname = host
+	}
+	b.counts.add(counts)
+}
+
+// flush writes pending buckets to the database
+func (c *Collector) flush() {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[bucketKey]*bucket)
+	c.mu.Unlock()
+
+	for key, b := range pending {
+		n := b.counts
+		_, err := c.db.Exec(`
+			INSERT INTO smtpd_client_stats (bucket, client_ip, hostname, connections, tls_connections,
+				plaintext_connections, lost_connections, auth_attempts, auth_successes)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(bucket, client_ip) DO UPDATE SET
+				hostname = COALESCE(NULLIF(excluded.hostname, ''), hostname),
+				connections = connections + excluded.connections,
+				tls_connections = tls_connections + excluded.tls_connections,
+				plaintext_connections = plaintext_connections + excluded.plaintext_connections,
+				lost_connections = lost_connections + excluded.lost_connections,
+				auth_attempts = auth_attempts + excluded.auth_attempts,
+				auth_successes = auth_successes + excluded.auth_successes
+		`, key.minute.Format(time.RFC3339), key.ip, b.hostname, n.Connections, n.TLS,
+			n.Plaintext, n.Lost, n.AuthAttempts, n.AuthSuccesses)
+		if err != nil {
+			log.Error().Err(err).Str("client", key.ip).Msg("Failed to store connection statistics")
+		}
+	}
+}
+
+// prune removes buckets older than connstats_retention_days
+func (c *Collector) prune(now time.Time) {
+	days := 7
+	var value string
+	if err := c.db.QueryRow(`SELECT value FROM settings WHERE key = 'connstats_retention_days'`).Scan(&value); err == nil {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			days = n
+		}
+	}
+	cutoff := now.UTC().AddDate(0, 0, -days).Format(time.RFC3339)
+	c.db.Exec(`DELETE FROM smtpd_client_stats WHERE bucket < ?`, cutoff)
+}
+
+// Client is a client IP's statistics over a period
+type Client struct {
+	IP       string    `json:"ip"`
+	Hostname string    `json:"hostname,omitempty"`
+	LastSeen time.Time `json:"lastSeen"`
+	Counts
+	TLSRatio        float64 `json:"tlsRatio"`        // Share of finished sessions that used TLS
+	AuthSuccessRate float64 `json:"authSuccessRate"` // Share of auth attempts that succeeded
+}
+
+// sortColumns are the orderings TopClients accepts
+var sortColumns = map[string]string{
+	"connections":   "connections",
+	"plaintext":     "plaintext",
+	"lost":          "lost",
+	"auth_failures": "auth_attempts - auth_successes",
+}
+
+// ValidSort reports whether TopClients can sort by key
+func ValidSort(key string) bool {
+	_, ok := sortColumns[key]
+	return ok
+}
+
+// TopClients returns the busiest clients since a time, ordered by sortBy
+// (connections, plaintext, lost or auth_failures). An ip filters to one
+// client.
+func TopClients(db *sql.DB, since time.Time, sortBy, ip string, limit int) ([]Client, error) {
+	order, ok := sortColumns[sortBy]
+	if !ok {
+		order = sortColumns["connections"]
+	}
+
+	query := `
+		SELECT client_ip, COALESCE(MAX(hostname), ''), MAX(bucket),
+			SUM(connections) AS connections, SUM(tls_connections) AS tls,
+			SUM(plaintext_connections) AS plaintext, SUM(lost_connections) AS lost,
+			SUM(auth_attempts) AS auth_attempts, SUM(auth_successes) AS auth_successes
+		FROM smtpd_client_stats
+		WHERE bucket >= ?`
+	args := []interface{}{since.UTC().Format(time.RFC3339)}
+	if ip != "" {
+		query += ` AND client_ip = ?`
+		args = append(args, ip)
+	}
+	query += ` GROUP BY client_ip ORDER BY ` + order + ` DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []Client{}
+	for rows.Next() {
+		var cl Client
+		var lastSeen string
+		if err := rows.Scan(&cl.IP, &cl.Hostname, &lastSeen, &cl.Connections, &cl.TLS, &cl.Plaintext,
+			&cl.Lost, &cl.AuthAttempts, &cl.AuthSuccesses); err != nil {
+			continue
+		}
+		cl.LastSeen, _ = time.Parse(time.RFC3339, lastSeen)
+		if finished := cl.TLS + cl.Plaintext; finished > 0 {
+			cl.TLSRatio = float64(cl.TLS) / float64(finished)
+		}
+		if cl.AuthAttempts > 0 {
+			cl.AuthSuccessRate = float64(cl.AuthSuccesses) / float64(cl.AuthAttempts)
+		}
+		clients = append(clients, cl)
+	}
+	return clients, nil
+}
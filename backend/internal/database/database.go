@@ -2,8 +2,10 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 	_ "modernc.org/sqlite"
@@ -36,8 +38,17 @@ func New(dbPath string) (*DB, error) {
 	return &DB{db}, nil
 }
 
-// Migrate runs database migrations
+// Migrate applies pending schema migrations and initializes default data
 func (db *DB) Migrate() error {
+	if _, err := db.MigrateUp(); err != nil {
+		return err
+	}
+	return db.initDefaults()
+}
+
+// baseline builds the schema as it stood before versioned migrations.
+// Later schema changes go in migrations/, not here.
+func (db *DB) baseline() error {
 	migrations := []string{
 		migrationUsers,
 		migrationSessions,
@@ -62,6 +73,31 @@ func (db *DB) Migrate() error {
 		migrationMailContacts,
 		migrationMailContactGroups,
 		migrationMailSignatures,
+		migrationMailSendLimits,
+		migrationDLP,
+		migrationAttachmentScanning,
+		migrationNotificationPreferences,
+		migrationIncidents,
+		migrationCanary,
+		migrationConnectionStats,
+		migrationTLSStats,
+		migrationSavedSearches,
+		migrationDeliveryStats,
+		migrationQueueSamples,
+		migrationNotificationTemplates,
+		migrationAlertRoutes,
+		migrationLegalHolds,
+		migrationArchiveEvents,
+		migrationActionApprovals,
+		migrationBackscatter,
+		migrationAppPasswords,
+		migrationAPITokens,
+		migrationSendAPI,
+		migrationSendTemplates,
+		migrationConfigTags,
+		migrationMailboxUsage,
+		migrationMailCleanup,
+		migrationReplicationStatus,
 	}
 
 	for _, m := range migrations {
@@ -70,8 +106,101 @@ func (db *DB) Migrate() error {
 		}
 	}
 
-	// Initialize default data
-	return db.initDefaults()
+	// Columns added to tables that already exist in older databases
+	for _, c := range columnMigrations {
+		if err := db.addColumn(c.table, c.column, c.definition); err != nil {
+			return fmt.Errorf("failed to add %s.%s: %w", c.table, c.column, err)
+		}
+	}
+
+	// Tables whose constraints changed are rebuilt, since SQLite cannot
+	// alter a CHECK constraint in place
+	if err := db.rebuildTable("notification_channels", "'discord'", migrationNotificationChannels); err != nil {
+		return fmt.Errorf("failed to rebuild notification_channels: %w", err)
+	}
+	return nil
+}
+
+// rebuildTable recreates a table from its current definition unless its
+// schema already contains marker, copying the rows across. The new
+// definition must have the same columns in the same order.
+func (db *DB) rebuildTable(table, marker, create string) error {
+	var schema string
+	if err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&schema); err != nil {
+		return err
+	}
+	if strings.Contains(schema, marker) {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	old := table + "_old"
+	stmts := []string{
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s", table, old),
+		create,
+		fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", table, old),
+		fmt.Sprintf("DROP TABLE %s", old),
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	log.Info().Str("table", table).Msg("Rebuilt table with updated schema")
+	return tx.Commit()
+}
+
+// columnMigration adds a column to an existing table
+type columnMigration struct {
+	table, column, definition string
+}
+
+var columnMigrations = []columnMigration{
+	{"user_notification_preferences", "locale", "TEXT NOT NULL DEFAULT 'en'"},
+	{"alert_rules", "runbook_updated_at", "DATETIME"},
+	{"alert_rules", "runbook_updated_by", "TEXT"},
+	{"alert_rules", "runbook_reviewed_at", "DATETIME"},
+	{"alert_rules", "runbook_reviewed_by", "TEXT"},
+	{"alerts", "incident_id", "INTEGER REFERENCES incidents(id)"},
+	{"mail_domains", "archive_enabled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"api_tokens", "senders", "TEXT NOT NULL DEFAULT ''"},
+	{"api_send_messages", "template_id", "INTEGER REFERENCES send_templates(id)"},
+	{"api_send_messages", "template_version", "INTEGER"},
+	{"config_versions", "ticket", "TEXT"},
+	{"config_versions", "auto_rollback_at", "DATETIME"},
+	{"config_versions", "auto_rollback_reason", "TEXT"},
+}
+
+// addColumn adds a column unless the table already has it. CREATE TABLE IF
+// NOT EXISTS leaves tables from older versions untouched, so new columns on
+// existing tables go through here.
+func (db *DB) addColumn(table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		if name == column {
+			rows.Close()
+			return nil
+		}
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
 }
 
 func (db *DB) initDefaults() error {
@@ -90,11 +219,119 @@ func (db *DB) initDefaults() error {
 
 	// Initialize default settings
 	defaultSettings := map[string]string{
-		"log_retention_days":        "7",
-		"audit_retention_days":      "90",
-		"session_timeout_hours":     "8",
-		"alert_silence_default_min": "60",
-		"log_source":                "auto",
+		"log_retention_days":         "7",
+		"audit_retention_days":       "90",
+		"session_timeout_hours":      "8",
+		"alert_silence_default_min":  "60",
+		"log_source":                 "auto",
+		"rate_limit_global_rps":      "10",
+		"rate_limit_global_burst":    "30",
+		"rate_limit_auth_rps":        "1",
+		"rate_limit_auth_burst":      "5",
+		"rate_limit_user_rps":        "20",
+		"rate_limit_user_burst":      "60",
+		"rate_limit_mail_send_rps":   "0.5",
+		"rate_limit_mail_send_burst": "10",
+		"rate_limit_export_rps":      "0.1",
+		"rate_limit_export_burst":    "3",
+		"rate_limit_api_send_rps":    "1",
+		"rate_limit_api_send_burst":  "20",
+		"mail_send_max_per_hour":     "100",
+		"mail_send_max_recipients":   "50",
+		"scan_engine":                "none",
+		"scan_address":               "",
+		"scan_action":                "reject",
+		"scan_on_error":              "allow",
+		"scan_queue_release":         "false",
+		"storage_backend":            "local",
+		"storage_local_path":         "",
+		"storage_s3_endpoint":        "",
+		"storage_s3_region":          "",
+		"storage_s3_bucket":          "",
+		"storage_s3_access_key":      "",
+		"storage_s3_secret_key":      "",
+		"storage_s3_prefix":          "",
+		"storage_s3_path_style":      "false",
+		"digest_hour":                "7",
+		"digest_weekday":             "1",
+		"digest_from":                "",
+		"public_url":                 "",
+		"incident_window_minutes":    "15",
+		"canary_enabled":             "false",
+		"canary_interval_minutes":    "15",
+		"canary_timeout_seconds":     "600",
+		"canary_from":                "",
+		"canary_to":                  "",
+		"canary_imap_address":        "",
+		"canary_imap_username":       "",
+		"canary_imap_password":       "",
+		"canary_imap_tls":            "true",
+		"canary_imap_mailbox":        "INBOX",
+		"connstats_retention_days":   "7",
+		"tlsstats_retention_days":    "30",
+		"delivery_retention_days":    "90",
+		"cert_expiry_warning_days":   "30",
+		"queuestats_retention_days":  "30",
+		"mailbox_growth_days":        "365",
+		"hoststats_retention_days":   "7",
+		"flowstats_retention_days":   "30",
+		"ntp_server":                 "pool.ntp.org",
+		"dns_check_names":            "example.com",
+		"update_check_enabled":       "true",
+		"update_manifest_url":        "",
+		"grafana_token":              "",
+		"metrics_token":              "",
+		"alert_action_secret":        "",
+		"usage_webhook_url":          "",
+		"usage_webhook_secret":       "",
+		"security_notify_enabled":    "false",
+		"security_notify_channels":   "",
+		"alert_default_channels":     "",
+		"snmp_enabled":               "false",
+		"snmp_listen":                ":1161",
+		"snmp_community":             "",
+		"snmp_base_oid":              "1.3.6.1.4.1.99999.1",
+		"incident_retention_days":    "180",
+		"canary_retention_days":      "30",
+		"export_retention_days":      "30",
+		"contact_retention_days":     "0",
+		"archive_mode":               "off",
+		"archive_smtp_address":       "",
+		"archive_listen":             "127.0.0.1:10027",
+		"sink_enabled":               "false",
+		"sink_listen":                "127.0.0.1:2525",
+		"sink_max_messages":          "1000",
+		"archive_retention_days":     "30",
+		"trash_retention_days":       "30",
+		"login_retention_days":       "90",
+		"destructive_second_admin":   "false",
+		"branding_product_name":      "",
+		"branding_logo_url":          "",
+		"branding_support_email":     "",
+		"branding_support_url":       "",
+		"branding_primary_color":     "",
+		"branding_accent_color":      "",
+		"branding_default_theme":     "system",
+		"map_type_transport":         "",
+		"map_type_sender_relay":      "",
+		"map_type_sasl_passwd":       "",
+		"map_type_vmailbox":          "",
+		"map_type_virtual":           "",
+		"map_type_archive_bcc":       "",
+		"map_type_backscatter":       "",
+		"soft_bounce":                "false",
+		"mail_client_hostname":       "",
+		"mail_client_imap_port":      "993",
+		"mail_client_imap_tls":       "ssl",
+		"mail_client_smtp_port":      "587",
+		"mail_client_smtp_tls":       "starttls",
+		"config_require_ticket":      "false",
+		"config_second_admin":        "false",
+		"config_bake_minutes":        "0",
+		"config_bake_queue_growth":   "200",
+		"config_bake_smtpd_errors":   "20",
+		"dkim_signer":                "none",
+		"dkim_key_dir":               "/etc/postfixrelay/dkim",
 	}
 
 	for key, value := range defaultSettings {
@@ -126,6 +363,24 @@ func (db *DB) initDefaultAlertRules() error {
 		{"Auth Failures", "SMTP authentication failures detected", "auth_failure_rate", 10, 3600, "warning"},
 		{"TLS Failures", "TLS handshake failures detected", "tls_failure_rate", 20, 3600, "warning"},
 		{"Postfix Down", "Postfix service not running", "service_check", 0, 0, "critical"},
+		{"Canary Delivery Failure", "Synthetic probe messages are not being delivered", "canary_failure", 2, 0, "critical"},
+		{"Canary Delivery Latency", "Synthetic probe delivery is slow", "canary_latency", 300, 0, "warning"},
+		{"Connection Flood", "Excessive SMTP connections from a single client", "connection_flood", 300, 300, "warning"},
+		{"Saved Search Threshold", "A scheduled saved search returned more rows than its alert threshold", "saved_search", 0, 0, "warning"},
+		{"Archive Delivery Failure", "Copies of mail are not reaching the archive", "archive_failure", 0, 900, "critical"},
+		{"Config Auto-Rollback", "A config apply was rolled back after Postfix's health regressed", "config_auto_rollback", 0, 3600, "critical"},
+		{"Replication Lag", "A mailbox hasn't replicated to the other Dovecot node in time", "replication_lag", 3600, 0, "warning"},
+		{"Disk Space Low", "A queue, mail or data volume is filling up", "disk_usage", 90, 0, "critical"},
+		{"Inodes Low", "A queue, mail or data volume is running out of inodes", "inode_usage", 90, 0, "critical"},
+		{"Memory Usage High", "The mail host is short of memory", "memory_usage", 95, 0, "warning"},
+		{"CPU Usage High", "The mail host's CPU has been busy for a sustained period", "cpu_usage", 95, 600, "warning"},
+		{"Load Average High", "The 5 minute load average per CPU is high", "load_average", 2, 0, "warning"},
+		{"Clock Skew", "The host clock differs from NTP, which breaks DKIM and TLS", "clock_skew", 5, 0, "warning"},
+		{"DNS Resolver Failure", "A DNS resolver of the mail host is failing lookups", "dns_failure", 0, 0, "critical"},
+		{"DNS Resolver Latency", "A DNS resolver of the mail host is slow, in milliseconds", "dns_latency", 1000, 0, "warning"},
+		{"Background Task Failures", "Background work such as a Dovecot sync keeps failing after retries", "background_failure", 3, 0, "warning"},
+		{"Login Failures", "Many failed admin or webmail sign-ins from one address", "login_failures", 20, 900, "warning"},
+		{"Admin Login From New Address", "An admin signed in from an address the account hasn't used in the threshold's number of days", "login_new_ip", 30, 3600, "warning"},
 	}
 
 	for _, r := range rules {
@@ -268,7 +523,7 @@ const migrationNotificationChannels = `
 CREATE TABLE IF NOT EXISTS notification_channels (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     name TEXT NOT NULL,
-    type TEXT NOT NULL CHECK (type IN ('email', 'webhook', 'slack')),
+    type TEXT NOT NULL CHECK (type IN ('email', 'webhook', 'slack', 'teams', 'discord')),
     config TEXT NOT NULL,
     enabled BOOLEAN DEFAULT TRUE,
     created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
@@ -476,3 +731,471 @@ CREATE TABLE IF NOT EXISTS mail_signatures (
 CREATE INDEX IF NOT EXISTS idx_mail_signatures_owner ON mail_signatures(owner_email);
 CREATE INDEX IF NOT EXISTS idx_mail_signatures_default ON mail_signatures(owner_email, is_default);
 `
+
+// PSFXMail outbound send quotas - per-mailbox overrides and send history
+const migrationMailSendLimits = `
+CREATE TABLE IF NOT EXISTS mailbox_send_limits (
+    mailbox_id INTEGER PRIMARY KEY REFERENCES mailboxes(id) ON DELETE CASCADE,
+    max_messages_per_hour INTEGER,
+    max_recipients_per_message INTEGER,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS mail_send_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sender_email TEXT NOT NULL,
+    recipient_count INTEGER NOT NULL,
+    sent_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_mail_send_log_sender ON mail_send_log(sender_email, sent_at);
+`
+
+// Data-loss-prevention rules, match events and quarantined outbound messages
+const migrationDLP = `
+CREATE TABLE IF NOT EXISTS dlp_rules (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    description TEXT,
+    type TEXT NOT NULL CHECK(type IN ('regex', 'keyword', 'credit_card', 'ssn')),
+    pattern TEXT,
+    action TEXT NOT NULL CHECK(action IN ('warn', 'quarantine', 'block')),
+    enabled BOOLEAN DEFAULT TRUE,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS dlp_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sender_email TEXT NOT NULL,
+    recipients TEXT NOT NULL,
+    subject TEXT,
+    action TEXT NOT NULL,
+    matches TEXT NOT NULL, -- JSON array of matched rules
+    quarantine_id INTEGER,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_dlp_events_created ON dlp_events(created_at);
+CREATE INDEX IF NOT EXISTS idx_dlp_events_sender ON dlp_events(sender_email);
+
+CREATE TABLE IF NOT EXISTS dlp_quarantine (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sender_email TEXT NOT NULL,
+    message TEXT NOT NULL, -- JSON ComposeMessage
+    matches TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK(status IN ('pending', 'approved', 'rejected')),
+    reviewed_by TEXT,
+    reviewed_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_dlp_quarantine_status ON dlp_quarantine(status);
+`
+
+// Webmail attachment staging and malware scan results
+const migrationAttachmentScanning = `
+CREATE TABLE IF NOT EXISTS scan_results (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source TEXT NOT NULL CHECK(source IN ('webmail_upload', 'queue_release')),
+    reference TEXT NOT NULL, -- attachment ID or queue ID
+    owner_email TEXT,
+    filename TEXT,
+    status TEXT NOT NULL CHECK(status IN ('clean', 'infected', 'error', 'skipped')),
+    signature TEXT,
+    engine TEXT NOT NULL,
+    error TEXT,
+    message_id TEXT, -- set once an uploaded attachment is sent
+    scanned_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_scan_results_scanned ON scan_results(scanned_at);
+CREATE INDEX IF NOT EXISTS idx_scan_results_status ON scan_results(status);
+CREATE INDEX IF NOT EXISTS idx_scan_results_message ON scan_results(message_id);
+
+CREATE TABLE IF NOT EXISTS mail_attachments (
+    id TEXT PRIMARY KEY,
+    owner_email TEXT NOT NULL,
+    filename TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    storage_path TEXT NOT NULL, -- object storage key
+    scan_result_id INTEGER REFERENCES scan_results(id),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_mail_attachments_owner ON mail_attachments(owner_email);
+`
+
+// Per-user notification preferences (digest subscriptions)
+const migrationNotificationPreferences = `
+CREATE TABLE IF NOT EXISTS user_notification_preferences (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    digest_frequency TEXT NOT NULL DEFAULT 'none' CHECK(digest_frequency IN ('none', 'daily', 'weekly')),
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_notification_prefs_digest ON user_notification_preferences(digest_frequency);
+`
+
+// Alert incidents and their timelines
+const migrationIncidents = `
+CREATE TABLE IF NOT EXISTS incidents (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    title TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'acknowledged', 'resolved')),
+    severity TEXT NOT NULL CHECK (severity IN ('warning', 'critical')),
+    opened_at DATETIME NOT NULL,
+    last_activity_at DATETIME NOT NULL,
+    acknowledged_at DATETIME,
+    acknowledged_by TEXT,
+    resolved_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_incidents_status ON incidents(status);
+CREATE INDEX IF NOT EXISTS idx_incidents_opened ON incidents(opened_at);
+
+CREATE TABLE IF NOT EXISTS incident_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    incident_id INTEGER NOT NULL REFERENCES incidents(id) ON DELETE CASCADE,
+    timestamp DATETIME NOT NULL,
+    type TEXT NOT NULL, -- alert_fired, alert_acknowledged, alert_resolved, acknowledged, note, resolved
+    alert_id INTEGER REFERENCES alerts(id),
+    username TEXT,
+    message TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_incident_events_incident ON incident_events(incident_id, timestamp);
+`
+
+// Synthetic delivery probes sent by the canary
+const migrationCanary = `
+CREATE TABLE IF NOT EXISTS canary_probes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token TEXT NOT NULL UNIQUE,
+    recipient TEXT NOT NULL,
+    sent_at DATETIME NOT NULL,
+    delivered_at DATETIME,
+    latency_ms INTEGER,
+    status TEXT NOT NULL CHECK(status IN ('pending', 'delivered', 'failed')),
+    error TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_canary_probes_sent ON canary_probes(sent_at);
+`
+
+// Per-client smtpd connection counts in one-minute buckets
+const migrationConnectionStats = `
+CREATE TABLE IF NOT EXISTS smtpd_client_stats (
+    bucket DATETIME NOT NULL, -- start of the minute (UTC)
+    client_ip TEXT NOT NULL,
+    hostname TEXT,
+    connections INTEGER NOT NULL DEFAULT 0,
+    tls_connections INTEGER NOT NULL DEFAULT 0,
+    plaintext_connections INTEGER NOT NULL DEFAULT 0,
+    lost_connections INTEGER NOT NULL DEFAULT 0,
+    auth_attempts INTEGER NOT NULL DEFAULT 0,
+    auth_successes INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (bucket, client_ip)
+);
+CREATE INDEX IF NOT EXISTS idx_smtpd_client_stats_ip ON smtpd_client_stats(client_ip, bucket);
+`
+
+// TLS sessions per peer, protocol and cipher in hourly buckets
+const migrationTLSStats = `
+CREATE TABLE IF NOT EXISTS tls_peer_stats (
+    bucket DATETIME NOT NULL, -- start of the hour (UTC)
+    direction TEXT NOT NULL, -- inbound (smtpd) or outbound (smtp)
+    peer_ip TEXT NOT NULL,
+    peer_host TEXT,
+    protocol TEXT NOT NULL, -- 'none' for plaintext sessions
+    cipher TEXT NOT NULL DEFAULT '',
+    trust TEXT NOT NULL DEFAULT '', -- Anonymous, Untrusted, Trusted or Verified
+    sessions INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (bucket, direction, peer_ip, protocol, cipher, trust)
+);
+CREATE INDEX IF NOT EXISTS idx_tls_peer_stats_peer ON tls_peer_stats(peer_ip, bucket);
+`
+
+// Named log/queue searches, optionally run on a schedule
+const migrationSavedSearches = `
+CREATE TABLE IF NOT EXISTS saved_searches (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    source TEXT NOT NULL CHECK (source IN ('logs', 'queue')),
+    filters TEXT NOT NULL DEFAULT '{}', -- JSON
+    schedule_minutes INTEGER NOT NULL DEFAULT 0, -- 0 = on demand only
+    email_to TEXT,
+    alert_threshold INTEGER,
+    last_run_at DATETIME,
+    last_result_count INTEGER,
+    last_error TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, name)
+);
+CREATE INDEX IF NOT EXISTS idx_saved_searches_schedule ON saved_searches(schedule_minutes);
+`
+
+// Delivery attempts per status in hourly buckets
+const migrationDeliveryStats = `
+CREATE TABLE IF NOT EXISTS delivery_stats (
+    bucket DATETIME NOT NULL, -- start of the hour (UTC)
+    status TEXT NOT NULL, -- sent, bounced, deferred, ...
+    count INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (bucket, status)
+);
+`
+
+// Queue size sampled every minute
+const migrationQueueSamples = `
+CREATE TABLE IF NOT EXISTS queue_samples (
+    sampled_at DATETIME PRIMARY KEY,
+    active INTEGER NOT NULL DEFAULT 0,
+    deferred INTEGER NOT NULL DEFAULT 0,
+    hold INTEGER NOT NULL DEFAULT 0,
+    corrupt INTEGER NOT NULL DEFAULT 0
+);
+`
+
+// Notification templates per channel (channel_id 0 for all channels) and event
+const migrationNotificationTemplates = `
+CREATE TABLE IF NOT EXISTS notification_templates (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    channel_id INTEGER NOT NULL DEFAULT 0,
+    event TEXT NOT NULL,
+    subject TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL DEFAULT '',
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_by TEXT,
+    UNIQUE(channel_id, event)
+);
+`
+
+// Alert routing rules, evaluated in position order
+const migrationAlertRoutes = `
+CREATE TABLE IF NOT EXISTS alert_routes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    severity TEXT NOT NULL DEFAULT '',
+    rule_types TEXT NOT NULL DEFAULT '[]', -- JSON
+    domain TEXT NOT NULL DEFAULT '',
+    days TEXT NOT NULL DEFAULT '[]', -- JSON
+    start_time TEXT NOT NULL DEFAULT '',
+    end_time TEXT NOT NULL DEFAULT '',
+    timezone TEXT NOT NULL DEFAULT '',
+    channel_ids TEXT NOT NULL DEFAULT '[]', -- JSON
+    continue_matching BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+// Legal holds on mailboxes and log date ranges; released holds are kept
+const migrationLegalHolds = `
+CREATE TABLE IF NOT EXISTS legal_holds (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    scope TEXT NOT NULL CHECK (scope IN ('mailbox', 'logs')),
+    mailbox_id INTEGER, -- no foreign key, the record outlives the mailbox
+    mailbox_email TEXT,
+    range_start DATETIME,
+    range_end DATETIME,
+    reason TEXT NOT NULL,
+    placed_by TEXT NOT NULL,
+    placed_at DATETIME NOT NULL,
+    released_by TEXT,
+    released_at DATETIME,
+    release_reason TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_legal_holds_active ON legal_holds(scope, released_at);
+`
+
+// Failed deliveries to the mail archive
+const migrationArchiveEvents = `
+CREATE TABLE IF NOT EXISTS archive_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    occurred_at DATETIME NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('failed', 'deferred', 'bounced')),
+    queue_id TEXT,
+    detail TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_archive_events_occurred ON archive_events(occurred_at);
+`
+
+// Destructive actions waiting for confirmation or a second admin, and
+// their outcome
+const migrationActionApprovals = `
+CREATE TABLE IF NOT EXISTS action_approvals (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action TEXT NOT NULL,
+    target TEXT,
+    summary TEXT NOT NULL,
+    token TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('pending', 'awaiting_approval', 'executing', 'executed', 'failed', 'rejected')),
+    requires_approval BOOLEAN NOT NULL DEFAULT FALSE,
+    requested_by_id INTEGER NOT NULL,
+    requested_by TEXT NOT NULL,
+    requested_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    decided_by TEXT,
+    decided_at DATETIME,
+    result TEXT
+);
+`
+
+// Custom bounce templates, one per notification class, and the domains
+// whose recipients are verified before mail is accepted
+const migrationBackscatter = `
+CREATE TABLE IF NOT EXISTS bounce_templates (
+    class TEXT PRIMARY KEY CHECK (class IN ('failure', 'delay', 'success', 'verify')),
+    from_header TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    postmaster_subject TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_by TEXT
+);
+
+CREATE TABLE IF NOT EXISTS backscatter_domains (
+    domain TEXT PRIMARY KEY,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    created_by TEXT
+);
+`
+
+// App-specific passwords for IMAP/SMTP clients. Each takes one of a
+// mailbox's Dovecot passdb slots until it is revoked.
+const migrationAppPasswords = `
+CREATE TABLE IF NOT EXISTS mailbox_app_passwords (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    mailbox_id INTEGER NOT NULL REFERENCES mailboxes(id) ON DELETE CASCADE,
+    label TEXT NOT NULL,
+    slot INTEGER NOT NULL,
+    password_hash TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (mailbox_id, slot),
+    UNIQUE (mailbox_id, label)
+);
+`
+
+// Tokens for external systems calling the integration API. Only a hash of
+// the token is kept; scopes is a comma-separated list of what it may call.
+const migrationAPITokens = `
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    token_prefix TEXT NOT NULL,
+    scopes TEXT NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    last_used_at DATETIME,
+    revoked_at DATETIME
+);
+`
+
+// Messages sent through the REST send API, and the delivery status of each
+// recipient as Postfix logs it
+const migrationSendAPI = `
+CREATE TABLE IF NOT EXISTS api_send_messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_id INTEGER NOT NULL REFERENCES api_tokens(id),
+    message_id TEXT NOT NULL UNIQUE,
+    queue_id TEXT,
+    sender TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    recipient_count INTEGER NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('accepted', 'failed')),
+    error TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_api_send_messages_token ON api_send_messages(token_id, created_at);
+
+CREATE TABLE IF NOT EXISTS api_send_recipients (
+    message_id INTEGER NOT NULL REFERENCES api_send_messages(id) ON DELETE CASCADE,
+    recipient TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    dsn TEXT,
+    relay TEXT,
+    detail TEXT,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (message_id, recipient)
+);
+`
+
+// Stored templates for the send API. Every edit adds a version; sends use
+// the current one unless they ask for another.
+const migrationSendTemplates = `
+CREATE TABLE IF NOT EXISTS send_templates (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    current_version INTEGER NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS send_template_versions (
+    template_id INTEGER NOT NULL REFERENCES send_templates(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    subject TEXT NOT NULL,
+    text_body TEXT NOT NULL DEFAULT '',
+    html_body TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (template_id, version)
+);
+`
+
+// Named config versions, such as a known-good baseline, that rollbacks can
+// refer to instead of a version number. A tag names one version at a time.
+const migrationConfigTags = `
+CREATE TABLE IF NOT EXISTS config_version_tags (
+    tag TEXT PRIMARY KEY,
+    version_number INTEGER NOT NULL REFERENCES config_versions(version_number),
+    created_by TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_config_version_tags_version ON config_version_tags(version_number);
+`
+
+// Daily mailbox sizes, for charting a mailbox's growth
+const migrationMailboxUsage = `
+CREATE TABLE IF NOT EXISTS mailbox_usage_samples (
+    mailbox_id INTEGER NOT NULL REFERENCES mailboxes(id) ON DELETE CASCADE,
+    sampled_on DATE NOT NULL,
+    bytes INTEGER NOT NULL DEFAULT 0,
+    messages INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (mailbox_id, sampled_on)
+);
+CREATE INDEX IF NOT EXISTS idx_mailbox_usage_samples_day ON mailbox_usage_samples(sampled_on);
+`
+
+// Policies that delete or move old mail in a folder, for every domain
+// (domain_id NULL) or one domain, overriding the every-domain policy
+const migrationMailCleanup = `
+CREATE TABLE IF NOT EXISTS mail_cleanup_policies (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    domain_id INTEGER REFERENCES mail_domains(id) ON DELETE CASCADE,
+    folder TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target_folder TEXT,
+    older_than_days INTEGER NOT NULL,
+    enabled BOOLEAN DEFAULT TRUE,
+    created_by TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_mail_cleanup_policies_domain ON mail_cleanup_policies(domain_id);
+`
+
+// Dovecot replicator state per mailbox, polled from doveadm, with the last
+// dsync error logged for it
+const migrationReplicationStatus = `
+CREATE TABLE IF NOT EXISTS replication_status (
+    email TEXT PRIMARY KEY,
+    priority TEXT,
+    last_fast_sync DATETIME,
+    last_full_sync DATETIME,
+    last_success_sync DATETIME,
+    failed BOOLEAN DEFAULT FALSE,
+    last_error TEXT,
+    last_error_at DATETIME,
+    first_seen DATETIME NOT NULL,
+    checked_at DATETIME NOT NULL
+);
+`
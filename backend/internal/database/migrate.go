@@ -0,0 +1,354 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Schema changes after the baseline are SQL files named
+// NNNN_name.up.sql, with an optional NNNN_name.down.sql that reverts it.
+// Each file runs in one transaction.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// baselineVersion is the schema as it stood before versioned migrations.
+// It is built by idempotent statements, so a database from any earlier
+// release reaches the same state.
+const baselineVersion = 1
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// Reversible reports whether the migration can be rolled back
+func (m Migration) Reversible() bool {
+	return m.down != ""
+}
+
+// MigrationStatus is a migration and whether it has been applied
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// Dirty is set while a migration runs; it stays set when the process
+	// died part way through
+	Dirty bool
+}
+
+// ErrDirty is returned when a migration was interrupted. The schema must be
+// checked by hand and the version recorded with -migrate force.
+var ErrDirty = errors.New("database schema is dirty")
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migrations returns every known migration, oldest first
+func Migrations() ([]Migration, error) {
+	byVersion := map[int]*Migration{
+		baselineVersion: {Version: baselineVersion, Name: "baseline"},
+	}
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		match := migrationFileName.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %s: name must be NNNN_name.up.sql or NNNN_name.down.sql", e.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		if version <= baselineVersion {
+			return nil, fmt.Errorf("migration %s: versions after the baseline start at %d", e.Name(), baselineVersion+1)
+		}
+		data, err := migrationFiles.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.up = string(data)
+		} else {
+			m.down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Version != baselineVersion && m.up == "" {
+			return nil, fmt.Errorf("migration %d (%s) has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+const migrationSchemaMigrations = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    dirty BOOLEAN NOT NULL DEFAULT FALSE,
+    applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+// appliedMigrations returns the recorded migrations by version
+func (db *DB) appliedMigrations() (map[int]MigrationStatus, error) {
+	if _, err := db.Exec(migrationSchemaMigrations); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT version, name, dirty, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]MigrationStatus{}
+	for rows.Next() {
+		st := MigrationStatus{Applied: true}
+		var appliedAt sql.NullTime
+		if err := rows.Scan(&st.Version, &st.Name, &st.Dirty, &appliedAt); err != nil {
+			return nil, err
+		}
+		st.AppliedAt = appliedAt.Time
+		applied[st.Version] = st
+	}
+	return applied, rows.Err()
+}
+
+func dirtyError(applied map[int]MigrationStatus) error {
+	for _, st := range applied {
+		if st.Dirty {
+			return fmt.Errorf("%w: migration %d (%s) did not finish; check the schema and run -migrate force %d or %d",
+				ErrDirty, st.Version, st.Name, st.Version, st.Version-1)
+		}
+	}
+	return nil
+}
+
+// MigrationStatuses lists every known migration and any applied migration
+// this build doesn't know, which a newer release recorded
+func (db *DB) MigrationStatuses() ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	var statuses []MigrationStatus
+	for _, m := range migrations {
+		st, ok := applied[m.Version]
+		if !ok {
+			st = MigrationStatus{Version: m.Version, Name: m.Name}
+		}
+		delete(applied, m.Version)
+		statuses = append(statuses, st)
+	}
+	for _, st := range applied {
+		statuses = append(statuses, st)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// SchemaVersion returns the highest applied migration and whether the
+// schema is dirty
+func (db *DB) SchemaVersion() (int, bool, error) {
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return 0, false, err
+	}
+	version, dirty := 0, false
+	for v, st := range applied {
+		if v > version {
+			version = v
+		}
+		dirty = dirty || st.Dirty
+	}
+	return version, dirty, nil
+}
+
+// MigrateUp applies every pending migration in order and returns how many
+// ran. It refuses to run on a dirty schema.
+func (db *DB) MigrateUp() (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if err := dirtyError(applied); err != nil {
+		return 0, err
+	}
+
+	ran := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if m.Version == baselineVersion {
+			// The baseline is idempotent, so an interrupted run is simply
+			// repeated and it is recorded only once it succeeds
+			if err := db.baseline(); err != nil {
+				return ran, fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+			}
+			if _, err := db.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.Version, m.Name); err != nil {
+				return ran, err
+			}
+		} else if err := db.runMigration(m.Version, m.Name, m.up, true); err != nil {
+			return ran, err
+		}
+		log.Info().Int("version", m.Version).Str("name", m.Name).Msg("Applied schema migration")
+		ran++
+	}
+	return ran, nil
+}
+
+// MigrateDown rolls back the latest steps applied migrations. The baseline
+// can't be rolled back.
+func (db *DB) MigrateDown(steps int) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return err
+	}
+	if err := dirtyError(applied); err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if !m.Reversible() {
+			return fmt.Errorf("migration %d (%s) can't be rolled back", m.Version, m.Name)
+		}
+		if err := db.runMigration(m.Version, m.Name, m.down, false); err != nil {
+			return err
+		}
+		log.Info().Int("version", m.Version).Str("name", m.Name).Msg("Rolled back schema migration")
+		steps--
+	}
+	return nil
+}
+
+// runMigration runs one migration's SQL in a transaction. Its row is marked
+// dirty beforehand and settled in the same transaction as the change, so a
+// row stays dirty only if the process dies part way through.
+func (db *DB) runMigration(version int, name, stmts string, up bool) error {
+	if _, err := db.Exec(`
+		INSERT INTO schema_migrations (version, name, dirty) VALUES (?, ?, TRUE)
+		ON CONFLICT(version) DO UPDATE SET dirty = TRUE
+	`, version, name); err != nil {
+		return err
+	}
+	// A failed migration is rolled back whole, leaving the schema as it was
+	undo := func() {
+		if up {
+			db.Exec(`DELETE FROM schema_migrations WHERE version = ?`, version)
+		} else {
+			db.Exec(`UPDATE schema_migrations SET dirty = FALSE WHERE version = ?`, version)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		undo()
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(stmts); err != nil {
+		tx.Rollback()
+		undo()
+		return fmt.Errorf("migration %d (%s): %w", version, name, err)
+	}
+	if up {
+		_, err = tx.Exec(`UPDATE schema_migrations SET dirty = FALSE, applied_at = CURRENT_TIMESTAMP WHERE version = ?`, version)
+	} else {
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, version)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		tx.Rollback()
+		undo()
+		return fmt.Errorf("migration %d (%s): %w", version, name, err)
+	}
+	return nil
+}
+
+// ForceVersion records the schema as being at version without running
+// anything: migrations up to it are marked applied and clean, later ones
+// unapplied. It is how a dirty schema is recovered after fixing it by hand.
+func (db *DB) ForceVersion(version int) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	known := false
+	for _, m := range migrations {
+		known = known || m.Version == version
+	}
+	if !known && version != 0 {
+		return fmt.Errorf("unknown migration version %d", version)
+	}
+	if _, err := db.appliedMigrations(); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM schema_migrations WHERE version > ?`, version); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE schema_migrations SET dirty = FALSE`); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.Version > version {
+			break
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO schema_migrations (version, name) VALUES (?, ?)`, m.Version, m.Name); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Warn().Int("version", version).Msg("Forced schema version")
+	return nil
+}
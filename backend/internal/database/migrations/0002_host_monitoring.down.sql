@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS host_checks;
+DROP TABLE IF EXISTS host_disk_samples;
+DROP TABLE IF EXISTS host_samples;
@@ -0,0 +1,34 @@
+-- Host CPU, memory and load sampled every minute, with the disk and inode
+-- usage of each watched volume
+CREATE TABLE IF NOT EXISTS host_samples (
+    sampled_at DATETIME PRIMARY KEY,
+    cpus INTEGER NOT NULL,
+    cpu_percent REAL NOT NULL,
+    memory_total INTEGER NOT NULL,
+    memory_available INTEGER NOT NULL,
+    load1 REAL NOT NULL,
+    load5 REAL NOT NULL,
+    load15 REAL NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS host_disk_samples (
+    sampled_at DATETIME NOT NULL,
+    volume TEXT NOT NULL,
+    path TEXT NOT NULL,
+    bytes_total INTEGER NOT NULL,
+    bytes_free INTEGER NOT NULL,
+    used_percent REAL NOT NULL,
+    inodes_total INTEGER NOT NULL,
+    inodes_free INTEGER NOT NULL,
+    inodes_used_percent REAL NOT NULL,
+    PRIMARY KEY (sampled_at, volume)
+);
+
+-- Latest clock and DNS resolver check results, one row per check
+CREATE TABLE IF NOT EXISTS host_checks (
+    name TEXT PRIMARY KEY,
+    ok BOOLEAN NOT NULL,
+    value REAL NOT NULL,
+    detail TEXT,
+    checked_at DATETIME NOT NULL
+);
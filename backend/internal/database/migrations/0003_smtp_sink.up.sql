@@ -0,0 +1,14 @@
+-- Messages captured by the SMTP sink, which accepts mail in staging and
+-- never delivers it
+CREATE TABLE IF NOT EXISTS sink_messages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    received_at DATETIME NOT NULL,
+    remote_addr TEXT,
+    mail_from TEXT NOT NULL,
+    rcpt_to TEXT NOT NULL, -- comma-separated envelope recipients
+    subject TEXT,
+    message_id TEXT,
+    size INTEGER NOT NULL,
+    raw BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sink_messages_received ON sink_messages(received_at);
@@ -0,0 +1,11 @@
+-- Deleted transport maps, sender relays and backscatter domains, kept so
+-- they can be restored until trash_retention_days have passed
+CREATE TABLE IF NOT EXISTS routing_trash (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL, -- transport_map, sender_relay or backscatter_domain
+    entry_key TEXT NOT NULL,
+    entry TEXT NOT NULL, -- the deleted entry as JSON
+    deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    deleted_by TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_routing_trash_deleted ON routing_trash(deleted_at);
@@ -0,0 +1,14 @@
+-- Hourly delivery counts from each source through each relay to each
+-- destination domain, for the mail flow report
+CREATE TABLE IF NOT EXISTS flow_stats (
+    bucket TEXT NOT NULL, -- start of the hour, RFC 3339 UTC
+    client TEXT NOT NULL, -- host that submitted the message, or "local"
+    sender_domain TEXT NOT NULL,
+    relay TEXT NOT NULL, -- next hop host, or "none"
+    destination TEXT NOT NULL, -- recipient domain
+    status TEXT NOT NULL, -- sent, deferred or bounced
+    count INTEGER NOT NULL DEFAULT 0,
+    delay_total REAL NOT NULL DEFAULT 0, -- seconds, summed over count
+    PRIMARY KEY (bucket, client, sender_domain, relay, destination, status)
+);
+CREATE INDEX IF NOT EXISTS idx_flow_stats_bucket ON flow_stats(bucket);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_audit_log_tenant;
+DROP INDEX IF EXISTS idx_mail_domains_tenant;
+DROP INDEX IF EXISTS idx_users_tenant;
+ALTER TABLE audit_log DROP COLUMN tenant_id;
+ALTER TABLE mail_domains DROP COLUMN tenant_id;
+ALTER TABLE users DROP COLUMN tenant_id;
+DROP TABLE IF EXISTS tenant_settings;
+DROP TABLE IF EXISTS tenants;
@@ -0,0 +1,28 @@
+-- Customer organizations served by one backend. Users and mail domains
+-- with a tenant_id belong to that tenant; mailboxes and aliases belong to
+-- the tenant of their domain. NULL is the platform itself.
+CREATE TABLE IF NOT EXISTS tenants (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    slug TEXT NOT NULL UNIQUE, -- used in URLs, such as /api/v1/branding?tenant=
+    description TEXT,
+    active BOOLEAN DEFAULT TRUE,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Settings a tenant overrides, such as its branding
+CREATE TABLE IF NOT EXISTS tenant_settings (
+    tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, key)
+);
+
+ALTER TABLE users ADD COLUMN tenant_id INTEGER;
+ALTER TABLE mail_domains ADD COLUMN tenant_id INTEGER;
+ALTER TABLE audit_log ADD COLUMN tenant_id INTEGER;
+CREATE INDEX IF NOT EXISTS idx_users_tenant ON users(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_mail_domains_tenant ON mail_domains(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_tenant ON audit_log(tenant_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS tenant_usage_notifications;
+DROP TABLE IF EXISTS tenant_usage_thresholds;
+DROP TABLE IF EXISTS tenant_usage;
@@ -0,0 +1,29 @@
+-- What each tenant used per calendar month (YYYY-MM, UTC), for billing.
+-- messages counts messages relayed; the peaks are sampled hourly.
+CREATE TABLE IF NOT EXISTS tenant_usage (
+    tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    month TEXT NOT NULL,
+    messages INTEGER NOT NULL DEFAULT 0,
+    storage_bytes_peak INTEGER NOT NULL DEFAULT 0,
+    mailboxes_peak INTEGER NOT NULL DEFAULT 0,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, month)
+);
+
+-- Usage levels that notify usage_webhook_url when a tenant reaches them
+CREATE TABLE IF NOT EXISTS tenant_usage_thresholds (
+    tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    metric TEXT NOT NULL CHECK (metric IN ('messages', 'storage_bytes', 'mailboxes')),
+    threshold INTEGER NOT NULL,
+    PRIMARY KEY (tenant_id, metric, threshold)
+);
+
+-- Thresholds already notified, so each fires once a month
+CREATE TABLE IF NOT EXISTS tenant_usage_notifications (
+    tenant_id INTEGER NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    month TEXT NOT NULL,
+    metric TEXT NOT NULL,
+    threshold INTEGER NOT NULL,
+    notified_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant_id, month, metric, threshold)
+);
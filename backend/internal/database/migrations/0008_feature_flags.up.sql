@@ -0,0 +1,8 @@
+-- Experimental subsystems an admin has switched on or off. A flag without
+-- a row is off.
+CREATE TABLE IF NOT EXISTS feature_flags (
+    key TEXT PRIMARY KEY,
+    enabled INTEGER NOT NULL DEFAULT 0,
+    updated_by TEXT,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,18 @@
+-- External hooks: scripts in the hooks directory or webhooks, run at an
+-- extension point in position order
+CREATE TABLE IF NOT EXISTS hooks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    point TEXT NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('script', 'webhook')),
+    target TEXT NOT NULL,
+    secret TEXT,
+    timeout_seconds INTEGER NOT NULL DEFAULT 5,
+    fail_open INTEGER NOT NULL DEFAULT 0,
+    enabled INTEGER NOT NULL DEFAULT 1,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_hooks_point ON hooks(point, position);
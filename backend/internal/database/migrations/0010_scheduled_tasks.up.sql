@@ -0,0 +1,15 @@
+-- Settings and last outcome of the scheduler's tasks. A task without a row
+-- runs on its default schedule; a NULL schedule or jitter is the default.
+CREATE TABLE IF NOT EXISTS scheduled_tasks (
+    name TEXT PRIMARY KEY,
+    schedule TEXT,
+    enabled INTEGER NOT NULL DEFAULT 1,
+    jitter_seconds INTEGER,
+    last_run_at DATETIME,
+    last_status TEXT,
+    last_error TEXT,
+    last_duration_ms INTEGER,
+    last_trigger TEXT,
+    updated_by TEXT,
+    updated_at DATETIME
+);
@@ -0,0 +1,13 @@
+-- Footers appended to outbound webmail and send API messages, by sender
+-- domain. Exemptions are JSON arrays of address patterns.
+CREATE TABLE IF NOT EXISTS domain_disclaimers (
+    domain TEXT PRIMARY KEY,
+    enabled INTEGER NOT NULL DEFAULT 1,
+    text_footer TEXT NOT NULL DEFAULT '',
+    html_footer TEXT NOT NULL DEFAULT '',
+    skip_internal INTEGER NOT NULL DEFAULT 0,
+    exempt_senders TEXT NOT NULL DEFAULT '[]',
+    exempt_recipients TEXT NOT NULL DEFAULT '[]',
+    updated_by TEXT,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
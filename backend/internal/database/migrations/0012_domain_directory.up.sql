@@ -0,0 +1,3 @@
+-- Domains whose mailboxes and aliases are shared with their webmail users
+-- as a global address list. Off until an admin turns it on.
+ALTER TABLE mail_domains ADD COLUMN directory_enabled BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1 @@
+ALTER TABLE mail_domains DROP COLUMN confidential;
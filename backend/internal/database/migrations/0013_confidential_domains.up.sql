@@ -0,0 +1,4 @@
+-- Domains whose mail is confidential: queue and trace views redact the
+-- subjects and bodies of messages to or from them unless an admin
+-- unredacts one with a justification.
+ALTER TABLE mail_domains ADD COLUMN confidential BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_mail_logs_mail_from;
+DROP INDEX IF EXISTS idx_mail_logs_mail_to;
+ALTER TABLE mail_logs DROP COLUMN delays;
+ALTER TABLE mail_logs DROP COLUMN message_id;
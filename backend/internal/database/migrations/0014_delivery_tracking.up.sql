@@ -0,0 +1,7 @@
+-- Delivery attempts correlated from the mail log: the Message-ID from
+-- cleanup and Postfix's delays=a/b/c/d breakdown, next to the sender
+-- from qmgr that mail_logs already has room for.
+ALTER TABLE mail_logs ADD COLUMN message_id TEXT;
+ALTER TABLE mail_logs ADD COLUMN delays TEXT;
+CREATE INDEX IF NOT EXISTS idx_mail_logs_mail_to ON mail_logs(mail_to);
+CREATE INDEX IF NOT EXISTS idx_mail_logs_mail_from ON mail_logs(mail_from);
@@ -0,0 +1,4 @@
+ALTER TABLE mail_signatures DROP COLUMN domain_template;
+ALTER TABLE mailboxes DROP COLUMN app_passwords_enabled;
+ALTER TABLE mailboxes DROP COLUMN webmail_enabled;
+DROP TABLE IF EXISTS domain_mailbox_defaults;
@@ -0,0 +1,22 @@
+-- Settings a domain gives mailboxes created under it: quota, the features
+-- they may use, a signature template and the password policy. A domain
+-- without a row uses the built-in defaults.
+CREATE TABLE IF NOT EXISTS domain_mailbox_defaults (
+    domain_id INTEGER PRIMARY KEY REFERENCES mail_domains(id) ON DELETE CASCADE,
+    quota_bytes INTEGER NOT NULL DEFAULT 1073741824,
+    webmail_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    app_passwords_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    signature_name TEXT NOT NULL DEFAULT '',
+    signature_html TEXT NOT NULL DEFAULT '',
+    signature_text TEXT NOT NULL DEFAULT '',
+    password_min_length INTEGER NOT NULL DEFAULT 8,
+    password_min_classes INTEGER NOT NULL DEFAULT 1,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_by INTEGER
+);
+
+ALTER TABLE mailboxes ADD COLUMN webmail_enabled BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE mailboxes ADD COLUMN app_passwords_enabled BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- Signatures made from the domain template, replaced when it is re-applied
+ALTER TABLE mail_signatures ADD COLUMN domain_template BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,19 @@
+-- DKIM signing keys of mail domains, one row per selector. The private key
+-- is encrypted with the database encryption key. At most one key per
+-- domain is active: that one goes into the signer's key tables.
+CREATE TABLE IF NOT EXISTS dkim_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    domain_id INTEGER NOT NULL REFERENCES mail_domains(id) ON DELETE CASCADE,
+    selector TEXT NOT NULL,
+    algorithm TEXT NOT NULL CHECK (algorithm IN ('rsa', 'ed25519')),
+    bits INTEGER NOT NULL DEFAULT 0,
+    private_key TEXT NOT NULL,
+    public_key TEXT NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT FALSE,
+    dns_status TEXT,
+    dns_checked_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    created_by INTEGER,
+    UNIQUE (domain_id, selector)
+);
+CREATE INDEX IF NOT EXISTS idx_dkim_keys_domain ON dkim_keys(domain_id);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS sender_classes;
@@ -0,0 +1,16 @@
+-- Sender classes: senders whose mail leaves through an smtp transport of
+-- its own, with its own process limit, concurrency and rate. Senders is
+-- a JSON array of addresses and @domains.
+CREATE TABLE IF NOT EXISTS sender_classes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    senders TEXT NOT NULL DEFAULT '[]',
+    process_limit INTEGER NOT NULL DEFAULT 0,
+    destination_concurrency INTEGER NOT NULL DEFAULT 0,
+    rate_delay TEXT NOT NULL DEFAULT '',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_by TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
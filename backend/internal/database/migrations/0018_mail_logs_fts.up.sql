@@ -0,0 +1,27 @@
+-- Full-text index over the searchable mail_logs columns. It holds no copy
+-- of the text (content='mail_logs'); the triggers keep it in step with
+-- inserts, erasures and retention deletes.
+CREATE VIRTUAL TABLE IF NOT EXISTS mail_logs_fts USING fts5(
+    message, mail_from, mail_to, relay,
+    content='mail_logs', content_rowid='id'
+);
+
+CREATE TRIGGER IF NOT EXISTS mail_logs_fts_insert AFTER INSERT ON mail_logs BEGIN
+    INSERT INTO mail_logs_fts(rowid, message, mail_from, mail_to, relay)
+    VALUES (new.id, new.message, new.mail_from, new.mail_to, new.relay);
+END;
+
+CREATE TRIGGER IF NOT EXISTS mail_logs_fts_delete AFTER DELETE ON mail_logs BEGIN
+    INSERT INTO mail_logs_fts(mail_logs_fts, rowid, message, mail_from, mail_to, relay)
+    VALUES ('delete', old.id, old.message, old.mail_from, old.mail_to, old.relay);
+END;
+
+CREATE TRIGGER IF NOT EXISTS mail_logs_fts_update AFTER UPDATE OF message, mail_from, mail_to, relay ON mail_logs BEGIN
+    INSERT INTO mail_logs_fts(mail_logs_fts, rowid, message, mail_from, mail_to, relay)
+    VALUES ('delete', old.id, old.message, old.mail_from, old.mail_to, old.relay);
+    INSERT INTO mail_logs_fts(rowid, message, mail_from, mail_to, relay)
+    VALUES (new.id, new.message, new.mail_from, new.mail_to, new.relay);
+END;
+
+-- Index the lines already stored
+INSERT INTO mail_logs_fts(mail_logs_fts) VALUES ('rebuild');
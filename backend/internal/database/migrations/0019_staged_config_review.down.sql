@@ -0,0 +1,5 @@
+ALTER TABLE staged_config DROP COLUMN review_comment;
+ALTER TABLE staged_config DROP COLUMN reviewed_at;
+ALTER TABLE staged_config DROP COLUMN reviewed_by;
+ALTER TABLE staged_config DROP COLUMN reviewed_by_id;
+ALTER TABLE staged_config DROP COLUMN status;
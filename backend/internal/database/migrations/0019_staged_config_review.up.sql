@@ -0,0 +1,9 @@
+-- Review state of staged config changes. Staging a key again puts it back
+-- to pending; with config_second_admin set, apply needs every staged key
+-- approved by an admin who didn't stage it.
+ALTER TABLE staged_config ADD COLUMN status TEXT NOT NULL DEFAULT 'pending'
+    CHECK (status IN ('pending', 'approved', 'rejected'));
+ALTER TABLE staged_config ADD COLUMN reviewed_by_id INTEGER;
+ALTER TABLE staged_config ADD COLUMN reviewed_by TEXT;
+ALTER TABLE staged_config ADD COLUMN reviewed_at DATETIME;
+ALTER TABLE staged_config ADD COLUMN review_comment TEXT;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_login_history_ip;
+DROP INDEX IF EXISTS idx_login_history_user;
+DROP INDEX IF EXISTS idx_login_history_occurred;
+DROP TABLE IF EXISTS login_history;
@@ -0,0 +1,18 @@
+-- Every admin and webmail sign-in attempt. user_id is set for admin users
+-- that exist; username is what was typed, so attempts against unknown
+-- accounts are kept too. Times are RFC 3339 UTC.
+CREATE TABLE IF NOT EXISTS login_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    occurred_at TEXT NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('admin', 'webmail')),
+    user_id INTEGER,
+    username TEXT NOT NULL,
+    success BOOLEAN NOT NULL,
+    failure_reason TEXT, -- unknown_user, bad_password, locked, disabled, unavailable
+    auth_source TEXT NOT NULL, -- local (users table) or imap (Dovecot)
+    ip_address TEXT,
+    user_agent TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_login_history_occurred ON login_history(occurred_at);
+CREATE INDEX IF NOT EXISTS idx_login_history_user ON login_history(kind, username, occurred_at);
+CREATE INDEX IF NOT EXISTS idx_login_history_ip ON login_history(ip_address, occurred_at);
@@ -0,0 +1,33 @@
+package database
+
+import "strconv"
+
+// GetSetting returns the value of a settings row, or def if it is not set
+func (db *DB) GetSetting(key, def string) string {
+	var value string
+	err := db.QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// GetSettingInt returns the integer value of a settings row, or def if it
+// is not set or not a valid integer
+func (db *DB) GetSettingInt(key string, def int) int {
+	i, err := strconv.Atoi(db.GetSetting(key, ""))
+	if err != nil {
+		return def
+	}
+	return i
+}
+
+// SetSetting stores the value of a settings row. Subsystems use it for
+// state they keep in settings; changes made here aren't published.
+func (db *DB) SetSetting(key, value string) error {
+	_, err := db.Exec(`
+		INSERT OR REPLACE INTO settings (key, value, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+	`, key, value)
+	return err
+}
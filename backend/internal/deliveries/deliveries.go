@@ -0,0 +1,330 @@
+// Package deliveries follows each message through the mail log. The
+// cleanup, qmgr and delivery agent lines of a message share its queue ID;
+// the tracker joins them so every delivery attempt is stored in mail_logs
+// with the sender, Message-ID and delay breakdown that only appear on
+// other lines.
+package deliveries
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/rs/zerolog/log"
+)
+
+// Statuses a delivery can end in, besides the delivery agents' sent,
+// bounced and deferred
+const (
+	StatusSent     = "sent"
+	StatusBounced  = "bounced"
+	StatusDeferred = "deferred"
+	StatusExpired  = "expired"
+)
+
+const (
+	// flushInterval is how often attempts are written to the database
+	flushInterval = 2 * time.Second
+
+	// maxTracked bounds the messages held in memory. Messages are dropped
+	// when qmgr removes them, so only a backlog of deferred mail comes
+	// close.
+	maxTracked = 100000
+
+	// trackedLifetime is how long a message is remembered without a new
+	// line; Postfix gives up on deferred mail after 5 days by default
+	trackedLifetime = 7 * 24 * time.Hour
+)
+
+var (
+	messageIDRe = regexp.MustCompile(`^message-id=<?([^>\s]*)>?`)
+	qmgrFromRe  = regexp.MustCompile(`^from=<([^>]*)>, size=(\d+), nrcpt=(\d+)`)
+	delaysRe    = regexp.MustCompile(`delays=([\d.]+)/([\d.]+)/([\d.]+)/([\d.]+)`)
+	detailRe    = regexp.MustCompile(`status=\w+ \((.*)\)\s*$`)
+	expiredRe   = regexp.MustCompile(`^from=<([^>]*)>, status=expired`)
+)
+
+// Delays is Postfix's delays=a/b/c/d breakdown of a delivery, in seconds
+type Delays struct {
+	BeforeQueue  float64 `json:"beforeQueue"`  // until the queue manager saw it
+	InQueue      float64 `json:"inQueue"`      // waiting in the queue
+	Connection   float64 `json:"connection"`   // connection setup, DNS, HELO and TLS
+	Transmission float64 `json:"transmission"` // sending the message
+}
+
+func (d *Delays) String() string {
+	if d == nil {
+		return ""
+	}
+	return fmt.Sprintf("%g/%g/%g/%g", d.BeforeQueue, d.InQueue, d.Connection, d.Transmission)
+}
+
+func parseDelays(s string) *Delays {
+	m := delaysRe.FindStringSubmatch(s)
+	if m == nil {
+		return nil
+	}
+	var v [4]float64
+	for i := range v {
+		v[i], _ = strconv.ParseFloat(m[i+1], 64)
+	}
+	return &Delays{BeforeQueue: v[0], InQueue: v[1], Connection: v[2], Transmission: v[3]}
+}
+
+// message is what the tracker knows about a queued message
+type message struct {
+	sender    string
+	senderSet bool
+	messageID string
+	lastSeen  time.Time
+	// pending are recipients whose latest attempt was deferred
+	pending map[string]bool
+}
+
+// attempt is one delivery attempt waiting to be stored
+type attempt struct {
+	entry     logs.Entry
+	sender    string
+	senderSet bool
+	messageID string
+	status    string
+	recipient string
+	delays    *Delays
+}
+
+// Tracker consumes mail log entries and stores every delivery attempt in
+// mail_logs
+type Tracker struct {
+	db *sql.DB
+
+	mu       sync.Mutex
+	messages map[string]*message
+	pending  []attempt
+
+	// flushMu keeps attempts in order when Flush runs alongside the loop
+	flushMu sync.Mutex
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewTracker creates a tracker
+func NewTracker(db *sql.DB) *Tracker {
+	return &Tracker{
+		db:       db,
+		messages: make(map[string]*message),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start begins writing attempts to the database
+func (t *Tracker) Start() {
+	t.done = make(chan struct{})
+	go t.loop()
+}
+
+// Stop writes pending attempts and stops the tracker
+func (t *Tracker) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stopCh)
+		if t.done != nil {
+			<-t.done
+		}
+	})
+}
+
+func (t *Tracker) loop() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	lastPrune := time.Now()
+
+	for {
+		select {
+		case <-t.stopCh:
+			t.Flush()
+			return
+		case now := <-ticker.C:
+			t.Flush()
+			if now.Sub(lastPrune) >= time.Hour {
+				t.prune(now)
+				lastPrune = now
+			}
+		}
+	}
+}
+
+// message returns the tracked message for a queue ID, starting to track
+// it if needed. Callers hold t.mu.
+func (t *Tracker) message(queueID string, ts time.Time) *message {
+	m, ok := t.messages[queueID]
+	if !ok {
+		if len(t.messages) >= maxTracked {
+			t.evictOldest()
+		}
+		m = &message{pending: map[string]bool{}}
+		t.messages[queueID] = m
+	}
+	m.lastSeen = ts
+	return m
+}
+
+func (t *Tracker) evictOldest() {
+	var oldestID string
+	var oldest time.Time
+	for id, m := range t.messages {
+		if oldestID == "" || m.lastSeen.Before(oldest) {
+			oldestID, oldest = id, m.lastSeen
+		}
+	}
+	delete(t.messages, oldestID)
+}
+
+// Consume follows one log entry
+func (t *Tracker) Consume(e logs.Entry) {
+	if e.QueueID == "" {
+		return
+	}
+	ts := e.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	text := strings.TrimSpace(e.Message)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case text == "removed":
+		delete(t.messages, e.QueueID)
+
+	case messageIDRe.MatchString(text):
+		t.message(e.QueueID, ts).messageID = messageIDRe.FindStringSubmatch(text)[1]
+
+	case expiredRe.MatchString(text):
+		// qmgr gives up on the message: every recipient still deferred
+		// has expired
+		m := t.message(e.QueueID, ts)
+		if !m.senderSet {
+			m.sender, m.senderSet = expiredRe.FindStringSubmatch(text)[1], true
+		}
+		for rcpt := range m.pending {
+			t.pending = append(t.pending, attempt{
+				entry: e, sender: m.sender, senderSet: true, messageID: m.messageID,
+				status: StatusExpired, recipient: rcpt,
+			})
+		}
+		m.pending = map[string]bool{}
+
+	case qmgrFromRe.MatchString(text):
+		m := t.message(e.QueueID, ts)
+		m.sender, m.senderSet = qmgrFromRe.FindStringSubmatch(text)[1], true
+
+	case e.Status != "" && e.MailTo != "":
+		m := t.message(e.QueueID, ts)
+		rcpt := strings.ToLower(strings.Trim(e.MailTo, "<>"))
+		if e.Status == StatusDeferred {
+			m.pending[rcpt] = true
+		} else {
+			delete(m.pending, rcpt)
+		}
+		sender, senderSet := m.sender, m.senderSet
+		if !senderSet && e.MailFrom != "" {
+			sender, senderSet = strings.Trim(e.MailFrom, "<>"), true
+		}
+		t.pending = append(t.pending, attempt{
+			entry: e, sender: sender, senderSet: senderSet, messageID: m.messageID,
+			status: e.Status, recipient: rcpt, delays: parseDelays(text),
+		})
+	}
+}
+
+// Flush writes the attempts seen so far, so a query made now finds them
+func (t *Tracker) Flush() {
+	t.flushMu.Lock()
+	defer t.flushMu.Unlock()
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	tx, err := t.db.Begin()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to store delivery attempts")
+		return
+	}
+	// A sender not seen since the service started is taken from an earlier
+	// attempt of the same message
+	stmt, err := tx.Prepare(`
+		INSERT INTO mail_logs (timestamp, hostname, process, pid, queue_id, message, severity,
+			mail_from, mail_to, status, relay, delay, dsn, message_id, delays)
+		VALUES (?, ?, ?, ?, ?, ?, ?,
+			COALESCE(?, (SELECT mail_from FROM mail_logs WHERE queue_id = ? AND mail_from IS NOT NULL ORDER BY id DESC LIMIT 1)),
+			?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		tx.Rollback()
+		log.Error().Err(err).Msg("Failed to store delivery attempts")
+		return
+	}
+	defer stmt.Close()
+
+	for _, a := range pending {
+		e := a.entry
+		ts := e.Timestamp
+		if ts.IsZero() {
+			ts = time.Now()
+		}
+		var sender interface{}
+		if a.senderSet {
+			sender = a.sender
+		}
+		_, err := stmt.Exec(ts.UTC().Format(time.RFC3339), e.Hostname, e.Process, e.PID, e.QueueID, e.Message,
+			severity(e.Severity), sender, e.QueueID, a.recipient, a.status, nullable(e.Relay), e.Delay,
+			nullable(e.DSN), nullable(a.messageID), nullable(a.delays.String()))
+		if err != nil {
+			log.Error().Err(err).Str("queue_id", e.QueueID).Msg("Failed to store delivery attempt")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Error().Err(err).Msg("Failed to store delivery attempts")
+	}
+}
+
+// prune forgets messages not seen for trackedLifetime
+func (t *Tracker) prune(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, m := range t.messages {
+		if now.Sub(m.lastSeen) > trackedLifetime {
+			delete(t.messages, id)
+		}
+	}
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// severity maps an entry's severity onto the values mail_logs allows
+func severity(s string) string {
+	switch s {
+	case "warning", "error":
+		return s
+	default:
+		return "info"
+	}
+}
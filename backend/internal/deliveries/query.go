@@ -0,0 +1,139 @@
+package deliveries
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// Delivery is where one recipient of a message stands: its latest
+// attempt and how many there were
+type Delivery struct {
+	QueueID      string    `json:"queueId"`
+	MessageID    string    `json:"messageId,omitempty"`
+	Sender       string    `json:"sender" redact:"view:addresses,mask"`
+	Recipient    string    `json:"recipient" redact:"view:addresses,mask"`
+	Status       string    `json:"status"`
+	Relay        string    `json:"relay,omitempty"`
+	DSN          string    `json:"dsn,omitempty"`
+	Detail       string    `json:"detail,omitempty" redact:"view:addresses,inline"` // the remote server's reply or Postfix's reason
+	Delay        float64   `json:"delay"`
+	Delays       *Delays   `json:"delays,omitempty"`
+	Attempts     int       `json:"attempts"`
+	FirstAttempt time.Time `json:"firstAttempt"`
+	LastAttempt  time.Time `json:"lastAttempt"`
+	// Final is false while the message is deferred and Postfix will try
+	// again
+	Final bool `json:"final"`
+}
+
+// Filter selects deliveries. Recipient and Sender match substrings;
+// Since and Until bound the latest attempt.
+type Filter struct {
+	Recipient string
+	Sender    string
+	Status    string
+	QueueID   string
+	Since     time.Time
+	Until     time.Time
+	// Domains, when set, limits results to mail from or to these domains
+	Domains []string
+	Limit   int
+	Offset  int
+}
+
+// Query returns deliveries matching f, latest attempt first, and how many
+// match in all
+func Query(db *sql.DB, f Filter) ([]Delivery, int, error) {
+	var where []string
+	var args []interface{}
+	if f.Recipient != "" {
+		where = append(where, `l.mail_to LIKE ?`)
+		args = append(args, "%"+strings.ToLower(f.Recipient)+"%")
+	}
+	if f.Sender != "" {
+		where = append(where, `LOWER(l.mail_from) LIKE ?`)
+		args = append(args, "%"+strings.ToLower(f.Sender)+"%")
+	}
+	if f.Status != "" {
+		where = append(where, `l.status = ?`)
+		args = append(args, f.Status)
+	}
+	if f.QueueID != "" {
+		where = append(where, `l.queue_id = ?`)
+		args = append(args, f.QueueID)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, `l.timestamp >= ?`)
+		args = append(args, f.Since.UTC().Format(time.RFC3339))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, `l.timestamp < ?`)
+		args = append(args, f.Until.UTC().Format(time.RFC3339))
+	}
+	if f.Domains != nil {
+		var domainConds []string
+		for _, d := range f.Domains {
+			domainConds = append(domainConds, `LOWER(l.mail_from) LIKE ? OR l.mail_to LIKE ?`)
+			args = append(args, "%@"+strings.ToLower(d), "%@"+strings.ToLower(d))
+		}
+		if len(domainConds) == 0 {
+			return []Delivery{}, 0, nil
+		}
+		where = append(where, "("+strings.Join(domainConds, " OR ")+")")
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	// Each (queue ID, recipient) pair's latest attempt, with the count and
+	// time of the first
+	from := `
+		FROM mail_logs l JOIN (
+			SELECT MAX(id) AS id, COUNT(*) AS attempts, MIN(timestamp) AS first_attempt
+			FROM mail_logs WHERE mail_to IS NOT NULL AND status IS NOT NULL
+			GROUP BY queue_id, mail_to
+		) g ON l.id = g.id` + cond
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*)`+from, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := db.Query(`
+		SELECT l.queue_id, COALESCE(l.message_id, ''), COALESCE(l.mail_from, ''), l.mail_to, l.status,
+			COALESCE(l.relay, ''), COALESCE(l.dsn, ''), l.message, COALESCE(l.delay, 0), COALESCE(l.delays, ''),
+			g.attempts, g.first_attempt, l.timestamp`+from+`
+		ORDER BY l.timestamp DESC, l.id DESC LIMIT ? OFFSET ?
+	`, append(args, limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	result := []Delivery{}
+	for rows.Next() {
+		var d Delivery
+		var message, delays, first, last string
+		if err := rows.Scan(&d.QueueID, &d.MessageID, &d.Sender, &d.Recipient, &d.Status, &d.Relay, &d.DSN,
+			&message, &d.Delay, &delays, &d.Attempts, &first, &last); err != nil {
+			return nil, 0, err
+		}
+		if m := detailRe.FindStringSubmatch(message); m != nil {
+			d.Detail = m[1]
+		}
+		if delays != "" {
+			d.Delays = parseDelays("delays=" + delays)
+		}
+		d.FirstAttempt, _ = time.Parse(time.RFC3339, first)
+		d.LastAttempt, _ = time.Parse(time.RFC3339, last)
+		d.Final = d.Status != StatusDeferred
+		result = append(result, d)
+	}
+	return result, total, rows.Err()
+}
@@ -0,0 +1,238 @@
+// Package deliverystats counts delivery attempts by status (sent, bounced,
+// deferred, ...) from the mail log in hourly buckets.
+package deliverystats
+
+import (
+	"database/sql"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/rs/zerolog/log"
+)
+
+// Delivery statuses reported by Postfix delivery agents
+const (
+	StatusSent     = "sent"
+	StatusBounced  = "bounced"
+	StatusDeferred = "deferred"
+)
+
+// recentLimit is how many bounced and deferred deliveries are remembered
+// per status for linking to their message traces
+const recentLimit = 50
+
+// Delivery is one bounced or deferred delivery attempt, by queue ID
+type Delivery struct {
+	QueueID   string    `json:"queueId"`
+	Status    string    `json:"status"`
+	Recipient string    `json:"recipient,omitempty"`
+	Relay     string    `json:"relay,omitempty"`
+	DSN       string    `json:"dsn,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type bucketKey struct {
+	hour   time.Time
+	status string
+}
+
+// Collector consumes delivery log entries and flushes per-status counts to
+// the database every minute
+type Collector struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	pending map[bucketKey]int
+	recent  map[string][]Delivery // newest last
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewCollector creates a collector
+func NewCollector(db *sql.DB) *Collector {
+	return &Collector{
+		db:      db,
+		pending: make(map[bucketKey]int),
+		recent:  make(map[string][]Delivery),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// Start begins flushing counts to the database
+func (c *Collector) Start() {
+	c.done = make(chan struct{})
+	go c.loop()
+}
+
+// Stop flushes pending counts and stops the collector
+func (c *Collector) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+		if c.done != nil {
+			<-c.done
+		}
+	})
+}
+
+func (c *Collector) loop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+
+	for {
+		select {
+		case <-c.stopCh:
+			c.flush()
+			return
+		case now := <-ticker.C:
+			c.flush()
+			if now.Sub(lastPrune) >= time.Hour {
+				c.prune(now)
+				lastPrune = now
+			}
+		}
+	}
+}
+
+// Consume counts one log entry if it records a delivery attempt
+func (c *Collector) Consume(e logs.Entry) {
+	if e.Status == "" || e.QueueID == "" {
+		return
+	}
+	ts := e.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	c.mu.Lock()
+	c.pending[bucketKey{hour: ts.UTC().Truncate(time.Hour), status: e.Status}]++
+	if e.Status == StatusBounced || e.Status == StatusDeferred {
+		recent := append(c.recent[e.Status], Delivery{
+			QueueID: e.QueueID, Status: e.Status, Recipient: e.MailTo, Relay: e.Relay, DSN: e.DSN, Timestamp: ts,
+		})
+		if len(recent) > recentLimit {
+			recent = recent[len(recent)-recentLimit:]
+		}
+		c.recent[e.Status] = recent
+	}
+	c.mu.Unlock()
+}
+
+// Recent returns up to limit of the latest deliveries with status (bounced
+// or deferred) since this process started, newest first
+func (c *Collector) Recent(status string, limit int) []Delivery {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	recent := c.recent[status]
+	result := []Delivery{}
+	for i := len(recent) - 1; i >= 0 && len(result) < limit; i-- {
+		result = append(result, recent[i])
+	}
+	return result
+}
+
+// RecentQueueIDs returns the distinct queue IDs of Recent deliveries
+func (c *Collector) RecentQueueIDs(status string, limit int) []string {
+	seen := map[string]bool{}
+	ids := []string{}
+	for _, d := range c.Recent(status, recentLimit) {
+		if !seen[d.QueueID] && len(ids) < limit {
+			seen[d.QueueID] = true
+			ids = append(ids, d.QueueID)
+		}
+	}
+	return ids
+}
+
+func (c *Collector) flush() {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[bucketKey]int)
+	c.mu.Unlock()
+
+	for key, n := range pending {
+		_, err := c.db.Exec(`
+			INSERT INTO delivery_stats (bucket, status, count) VALUES (?, ?, ?)
+			ON CONFLICT(bucket, status) DO UPDATE SET count = count + excluded.count
+		`, key.hour.Format(time.RFC3339), key.status, n)
+		if err != nil {
+			log.Error().Err(err).Str("status", key.status).Msg("Failed to store delivery statistics")
+		}
+	}
+}
+
+// prune removes buckets older than delivery_retention_days
+func (c *Collector) prune(now time.Time) {
+	days := 90
+	var value string
+	if err := c.db.QueryRow(`SELECT value FROM settings WHERE key = 'delivery_retention_days'`).Scan(&value); err == nil {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			days = n
+		}
+	}
+	cutoff := now.UTC().AddDate(0, 0, -days).Format(time.RFC3339)
+	c.db.Exec(`DELETE FROM delivery_stats WHERE bucket < ?`, cutoff)
+}
+
+// Totals returns delivery counts per status for hourly buckets starting in
+// [from, to)
+func Totals(db *sql.DB, from, to time.Time) (map[string]int, error) {
+	rows, err := db.Query(`
+		SELECT status, SUM(count) FROM delivery_stats
+		WHERE bucket >= ? AND bucket < ?
+		GROUP BY status
+	`, from.UTC().Truncate(time.Hour).Format(time.RFC3339), to.UTC().Truncate(time.Hour).Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err == nil {
+			totals[status] = n
+		}
+	}
+	return totals, nil
+}
+
+// Bucket is the number of deliveries with one status in one hour
+type Bucket struct {
+	Hour   time.Time `json:"hour"`
+	Status string    `json:"status"`
+	Count  int       `json:"count"`
+}
+
+// Series returns the hourly buckets starting in [from, to], oldest first
+func Series(db *sql.DB, from, to time.Time) ([]Bucket, error) {
+	rows, err := db.Query(`
+		SELECT bucket, status, count FROM delivery_stats
+		WHERE bucket >= ? AND bucket <= ?
+		ORDER BY bucket
+	`, from.UTC().Truncate(time.Hour).Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []Bucket{}
+	for rows.Next() {
+		var b Bucket
+		var hour string
+		if err := rows.Scan(&hour, &b.Status, &b.Count); err != nil {
+			continue
+		}
+		b.Hour, _ = time.Parse(time.RFC3339, hour)
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
@@ -0,0 +1,323 @@
+// Package demo seeds sample data for evaluating the panel without a mail
+// server: a domain with mailboxes and aliases, a week of Postfix log
+// history, delivery and queue statistics, alerts and a made-up mail queue.
+// Everything uses the reserved demo.example domain, documentation IP
+// ranges and a "[demo]" prefix on alerts, so it can't be mistaken for
+// real mail.
+package demo
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Domain is the mail domain the demo data lives in
+const Domain = "demo.example"
+
+// Password is the password of every demo mailbox
+const Password = "demo"
+
+// Marker prefixes the messages of demo alerts
+const Marker = "[demo]"
+
+// history is how far back the demo log and statistics reach
+const history = 7 * 24 * time.Hour
+
+var mailboxes = []struct {
+	localPart, displayName string
+	usedBytes              int64
+	messages               int
+}{
+	{"alice", "Alice Example", 412 << 20, 5230},
+	{"bob", "Bob Example", 96 << 20, 1187},
+	{"carol", "Carol Example", 870 << 20, 10412},
+	{"support", "Support Desk", 233 << 20, 3954},
+}
+
+var aliases = [][2]string{
+	{"info", "support"},
+	{"sales", "bob"},
+	{"postmaster", "alice"},
+}
+
+var remoteRecipients = []string{
+	"jane@example.net", "ops@example.org", "billing@example.com",
+	"team@example.net", "orders@example.org", "no-reply@example.com",
+}
+
+var remoteRelays = []string{
+	"mx1.example.net[198.51.100.25]:25",
+	"mx.example.org[203.0.113.7]:25",
+	"mail.example.com[192.0.2.44]:25",
+}
+
+var deferReasons = []string{
+	"connect to mx.example.org[203.0.113.7]:25: Connection timed out",
+	"host mx1.example.net[198.51.100.25] said: 451 4.7.1 Greylisted, please try again later",
+	"host mail.example.com[192.0.2.44] said: 452 4.2.2 Mailbox full",
+}
+
+var bounceReasons = []string{
+	"host mx1.example.net[198.51.100.25] said: 550 5.1.1 <%s>: Recipient address rejected: User unknown",
+	"host mx.example.org[203.0.113.7] said: 554 5.7.1 Message rejected as spam",
+}
+
+// Seeded reports whether demo data has already been created
+func Seeded(db *sql.DB) bool {
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM mail_domains WHERE domain = ?`, Domain).Scan(&n)
+	return n > 0
+}
+
+// Seed creates the demo data unless it exists. The log history is written
+// to dir/mail.log, which becomes the log source.
+func Seed(db *sql.DB, dir string, now time.Time) error {
+	if Seeded(db) {
+		return nil
+	}
+	now = now.UTC()
+	rng := rand.New(rand.NewSource(now.Unix()))
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(Password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		INSERT INTO mail_domains (domain, description, max_mailboxes, max_aliases)
+		VALUES (?, 'Demo data, not a real domain', 25, 50)
+	`, Domain)
+	if err != nil {
+		return err
+	}
+	domainID, _ := res.LastInsertId()
+
+	for _, m := range mailboxes {
+		res, err := tx.Exec(`
+			INSERT INTO mailboxes (email, local_part, domain_id, password_hash, display_name)
+			VALUES (?, ?, ?, ?, ?)
+		`, m.localPart+"@"+Domain, m.localPart, domainID, string(hash), m.displayName)
+		if err != nil {
+			return err
+		}
+		id, _ := res.LastInsertId()
+		if _, err := tx.Exec(`
+			INSERT INTO mailbox_quota (mailbox_id, bytes_used, message_count) VALUES (?, ?, ?)
+		`, id, m.usedBytes, m.messages); err != nil {
+			return err
+		}
+	}
+	for _, a := range aliases {
+		if _, err := tx.Exec(`
+			INSERT INTO mail_aliases (source_email, destination_email, domain_id) VALUES (?, ?, ?)
+		`, a[0]+"@"+Domain, a[1]+"@"+Domain, domainID); err != nil {
+			return err
+		}
+	}
+
+	// A week of traffic, written as a Postfix log and counted into the
+	// hourly delivery statistics the log pipeline would have kept
+	var lines []string
+	counts := map[[2]string]int{}
+	pid := 2000
+	for at := now.Add(-history); at.Before(now); at = at.Add(time.Duration(2+rng.Intn(8)) * time.Minute) {
+		pid++
+		sender := mailboxes[rng.Intn(len(mailboxes))].localPart + "@" + Domain
+		recipient := remoteRecipients[rng.Intn(len(remoteRecipients))]
+		status, msgLines := delivery(rng, at, QueueID(rng), sender, recipient, pid)
+		lines = append(lines, msgLines...)
+		counts[[2]string{at.Truncate(time.Hour).Format(time.RFC3339), status}]++
+
+		// Now and then a client fails to authenticate
+		if rng.Intn(40) == 0 {
+			lines = append(lines, syslog(at, "smtpd", pid,
+				"warning: unknown[203.0.113.%d]: SASL LOGIN authentication failed: authentication failure", 1+rng.Intn(250)))
+		}
+	}
+	for key, n := range counts {
+		if _, err := tx.Exec(`
+			INSERT INTO delivery_stats (bucket, status, count) VALUES (?, ?, ?)
+			ON CONFLICT(bucket, status) DO UPDATE SET count = count + excluded.count
+		`, key[0], key[1], n); err != nil {
+			return err
+		}
+	}
+
+	// A day of queue samples with a deferred backlog building up and
+	// clearing in the afternoon
+	for at := now.Add(-24 * time.Hour).Truncate(time.Minute); at.Before(now); at = at.Add(time.Minute) {
+		deferred := 3 + rng.Intn(4)
+		if h := at.Hour(); h >= 13 && h < 16 {
+			deferred += (h - 12) * 40
+		}
+		if _, err := tx.Exec(`
+			INSERT OR REPLACE INTO queue_samples (sampled_at, active, deferred, hold, corrupt)
+			VALUES (?, ?, ?, 2, 0)
+		`, at.Format(time.RFC3339), rng.Intn(5), deferred); err != nil {
+			return err
+		}
+	}
+
+	if err := seedAlerts(tx, now); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	logFile := filepath.Join(dir, "mail.log")
+	if err := os.WriteFile(logFile, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO settings (key, value) VALUES ('log_source', ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP
+	`, logFile); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Info().Str("domain", Domain).Str("log", logFile).Int("lines", len(lines)).Msg("Seeded demo data")
+	return nil
+}
+
+// seedAlerts raises one alert of each state against the default rules
+func seedAlerts(tx *sql.Tx, now time.Time) error {
+	alerts := []struct {
+		ruleType, status, message string
+		age                       time.Duration
+	}{
+		{"queue_count", "firing", "Mail queue holds 128 messages (threshold 100)", 25 * time.Minute},
+		{"deferred_rate", "acknowledged", "62 deferred deliveries in the last hour (threshold 50)", 3 * time.Hour},
+		{"auth_failure_rate", "resolved", "14 SMTP authentication failures in the last hour (threshold 10)", 30 * time.Hour},
+	}
+	for _, a := range alerts {
+		var ruleID int64
+		var severity string
+		err := tx.QueryRow(`SELECT id, severity FROM alert_rules WHERE type = ? ORDER BY id LIMIT 1`, a.ruleType).Scan(&ruleID, &severity)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		triggered := now.Add(-a.age)
+		var acknowledgedAt, resolvedAt interface{}
+		var acknowledgedBy interface{}
+		switch a.status {
+		case "acknowledged":
+			acknowledgedAt, acknowledgedBy = triggered.Add(10*time.Minute).Format(time.RFC3339), "demo"
+		case "resolved":
+			resolvedAt = triggered.Add(40 * time.Minute).Format(time.RFC3339)
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO alerts (rule_id, status, severity, triggered_at, acknowledged_at, acknowledged_by, resolved_at, message, context)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, '{}')
+		`, ruleID, a.status, severity, triggered.Format(time.RFC3339), acknowledgedAt, acknowledgedBy, resolvedAt,
+			Marker+" "+a.message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueueID returns a random Postfix-style queue ID
+func QueueID(rng *rand.Rand) string {
+	const hex = "0123456789ABCDEF"
+	b := make([]byte, 10)
+	for i := range b {
+		b[i] = hex[rng.Intn(len(hex))]
+	}
+	return string(b)
+}
+
+// syslog formats one Postfix log line from the demo host
+func syslog(at time.Time, process string, pid int, format string, args ...interface{}) string {
+	return fmt.Sprintf("%s demo postfix/%s[%d]: %s", at.Format(time.Stamp), process, pid, fmt.Sprintf(format, args...))
+}
+
+// delivery returns the log lines of one outbound message and its outcome:
+// mostly sent, sometimes deferred or bounced
+func delivery(rng *rand.Rand, at time.Time, queueID, sender, recipient string, pid int) (string, []string) {
+	relay := remoteRelays[rng.Intn(len(remoteRelays))]
+	size := 1500 + rng.Intn(80000)
+	delay := 0.2 + rng.Float64()*2
+
+	status, dsn, reply := "sent", "2.0.0", "250 2.0.0 Ok: queued"
+	switch n := rng.Intn(100); {
+	case n < 6:
+		status, dsn = "deferred", "4.4.1"
+		reply = deferReasons[rng.Intn(len(deferReasons))]
+	case n < 9:
+		status, dsn = "bounced", "5.1.1"
+		reply = fmt.Sprintf(bounceReasons[rng.Intn(len(bounceReasons))], recipient)
+	}
+
+	done := at.Add(time.Duration(delay * float64(time.Second)))
+	lines := []string{
+		syslog(at, "smtpd", pid, "%s: client=client.%s[192.0.2.%d], sasl_method=PLAIN, sasl_username=%s",
+			queueID, Domain, 10+pid%200, sender),
+		syslog(at, "cleanup", pid+1, "%s: message-id=<%s.%d@%s>", queueID, strings.ToLower(queueID), at.Unix(), Domain),
+		syslog(at, "qmgr", 1001, "%s: from=<%s>, size=%d, nrcpt=1 (queue active)", queueID, sender, size),
+		syslog(done, "smtp", pid+2, "%s: to=<%s>, relay=%s, delay=%.1f, delays=0.1/0/%.1f/%.1f, dsn=%s, status=%s (%s)",
+			queueID, recipient, relay, delay, delay/2, delay/2-0.1, dsn, status, reply),
+	}
+	if status != "deferred" {
+		lines = append(lines, syslog(done, "qmgr", 1001, "%s: removed", queueID))
+	}
+	return status, lines
+}
+
+// Queue returns a made-up mail queue of active, deferred and held messages
+func Queue(now time.Time) []postfix.QueueMessage {
+	rng := rand.New(rand.NewSource(now.Unix()))
+	var queue []postfix.QueueMessage
+	add := func(status, reason string, age time.Duration) {
+		queue = append(queue, postfix.QueueMessage{
+			QueueID:     QueueID(rng),
+			Status:      status,
+			Size:        int64(1500 + rng.Intn(80000)),
+			ArrivalTime: now.Add(-age).Truncate(time.Second),
+			Sender:      mailboxes[rng.Intn(len(mailboxes))].localPart + "@" + Domain,
+			Recipients:  []string{remoteRecipients[rng.Intn(len(remoteRecipients))]},
+			Reason:      reason,
+		})
+	}
+	for i := 0; i < 3; i++ {
+		add("active", "", time.Duration(rng.Intn(60))*time.Second)
+	}
+	for i := 0; i < 7; i++ {
+		add("deferred", deferReasons[i%len(deferReasons)], time.Duration(10+rng.Intn(600))*time.Minute)
+	}
+	for i := 0; i < 2; i++ {
+		add("hold", "", time.Duration(1+rng.Intn(48))*time.Hour)
+	}
+	return queue
+}
+
+// LargeQueue returns n made-up queue messages in the same mix as Queue,
+// for load tests and benchmarks
+func LargeQueue(now time.Time, n int) []postfix.QueueMessage {
+	queue := make([]postfix.QueueMessage, 0, n)
+	for batch := now; len(queue) < n; batch = batch.Add(-time.Second) {
+		queue = append(queue, Queue(batch)...)
+	}
+	return queue[:n]
+}
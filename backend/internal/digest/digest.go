@@ -0,0 +1,234 @@
+package digest
+
+import (
+	"bytes"
+	"database/sql"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/i18n"
+)
+
+// Period is how often a digest is sent
+type Period string
+
+const (
+	PeriodNone   Period = "none"
+	PeriodDaily  Period = "daily"
+	PeriodWeekly Period = "weekly"
+)
+
+// Valid reports whether p is a known period
+func (p Period) Valid() bool {
+	return p == PeriodNone || p == PeriodDaily || p == PeriodWeekly
+}
+
+// Duration returns the span of time a digest for this period covers
+func (p Period) Duration() time.Duration {
+	if p == PeriodWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// RuleCount is the number of alerts fired by one rule
+type RuleCount struct {
+	Rule     string
+	Severity string
+	Count    int
+}
+
+// ConfigChange is a configuration version applied during the period
+type ConfigChange struct {
+	Version   int
+	AppliedAt time.Time
+	AppliedBy string
+	Notes     string
+}
+
+// NewUser is an admin console user created during the period
+type NewUser struct {
+	Username  string
+	Role      string
+	CreatedAt time.Time
+}
+
+// NewMailbox is a mailbox created during the period
+type NewMailbox struct {
+	Email     string
+	CreatedAt time.Time
+}
+
+// QueueHealth is a snapshot of the Postfix queue when the digest was built
+type QueueHealth struct {
+	Active   int
+	Deferred int
+	Hold     int
+	Corrupt  int
+}
+
+// Report is the data a digest is rendered from
+type Report struct {
+	Period        Period
+	From          time.Time
+	To            time.Time
+	Hostname      string
+	AlertsTotal   int
+	AlertsByRule  []RuleCount
+	Critical      int
+	ConfigChanges []ConfigChange
+	NewUsers      []NewUser
+	NewMailboxes  []NewMailbox
+	Queue         QueueHealth
+}
+
+// Build collects the report for the period ending at now
+func Build(db *sql.DB, period Period, now time.Time, queue QueueHealth) (*Report, error) {
+	now = now.UTC()
+	r := &Report{
+		Period: period,
+		From:   now.Add(-period.Duration()),
+		To:     now,
+		Queue:  queue,
+	}
+
+	rows, err := db.Query(`
+		SELECT r.name, a.severity, COUNT(*)
+		FROM alerts a JOIN alert_rules r ON a.rule_id = r.id
+		WHERE a.triggered_at >= ? AND a.triggered_at < ?
+		GROUP BY r.name, a.severity
+		ORDER BY COUNT(*) DESC
+	`, r.From, r.To)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize alerts: %w", err)
+	}
+	for rows.Next() {
+		var rc RuleCount
+		if err := rows.Scan(&rc.Rule, &rc.Severity, &rc.Count); err != nil {
+			continue
+		}
+		r.AlertsByRule = append(r.AlertsByRule, rc)
+		r.AlertsTotal += rc.Count
+		if rc.Severity == "critical" {
+			r.Critical += rc.Count
+		}
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT v.version_number, v.applied_at, COALESCE(u.username, v.created_by_username, ''), COALESCE(v.notes, '')
+		FROM config_versions v LEFT JOIN users u ON u.id = v.applied_by_id
+		WHERE v.applied_at >= ? AND v.applied_at < ?
+		ORDER BY v.applied_at
+	`, r.From, r.To)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list config changes: %w", err)
+	}
+	for rows.Next() {
+		var c ConfigChange
+		if err := rows.Scan(&c.Version, &c.AppliedAt, &c.AppliedBy, &c.Notes); err != nil {
+			continue
+		}
+		r.ConfigChanges = append(r.ConfigChanges, c)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT username, role, created_at FROM users
+		WHERE created_at >= ? AND created_at < ? ORDER BY created_at
+	`, r.From, r.To)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list new users: %w", err)
+	}
+	for rows.Next() {
+		var u NewUser
+		if err := rows.Scan(&u.Username, &u.Role, &u.CreatedAt); err != nil {
+			continue
+		}
+		r.NewUsers = append(r.NewUsers, u)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT email, created_at FROM mailboxes
+		WHERE created_at >= ? AND created_at < ? ORDER BY created_at
+	`, r.From, r.To)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list new mailboxes: %w", err)
+	}
+	for rows.Next() {
+		var m NewMailbox
+		if err := rows.Scan(&m.Email, &m.CreatedAt); err != nil {
+			continue
+		}
+		r.NewMailboxes = append(r.NewMailboxes, m)
+	}
+	rows.Close()
+
+	return r, nil
+}
+
+//go:embed templates/*
+var templateFS embed.FS
+
+// Templates are parsed once with a placeholder "t" function that Render
+// replaces with one bound to the recipient's locale
+var (
+	textTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+		"date": formatDate, "upper": strings.ToUpper, "t": i18n.T,
+	}).ParseFS(templateFS, "templates/*.txt.tmpl"))
+	htmlTemplates = htmltemplate.Must(htmltemplate.New("").Funcs(htmltemplate.FuncMap{
+		"date": formatDate, "t": i18n.T,
+	}).ParseFS(templateFS, "templates/*.html.tmpl"))
+)
+
+func formatDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+// Rendered is a digest ready to send
+type Rendered struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// Render renders the subject, plain text and HTML bodies of a report in
+// the given locale
+func Render(r *Report, locale string) (*Rendered, error) {
+	tr := func(msg string, args ...interface{}) string {
+		return i18n.T(locale, msg, args...)
+	}
+
+	textTmpl, err := textTemplates.Clone()
+	if err != nil {
+		return nil, err
+	}
+	textTmpl.Funcs(template.FuncMap{"t": tr})
+
+	htmlTmpl, err := htmlTemplates.Clone()
+	if err != nil {
+		return nil, err
+	}
+	htmlTmpl.Funcs(htmltemplate.FuncMap{"t": tr})
+
+	var subject, text, html bytes.Buffer
+	if err := textTmpl.ExecuteTemplate(&subject, "subject.txt.tmpl", r); err != nil {
+		return nil, err
+	}
+	if err := textTmpl.ExecuteTemplate(&text, "digest.txt.tmpl", r); err != nil {
+		return nil, err
+	}
+	if err := htmlTmpl.ExecuteTemplate(&html, "digest.html.tmpl", r); err != nil {
+		return nil, err
+	}
+	return &Rendered{
+		Subject: string(bytes.TrimSpace(subject.Bytes())),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
@@ -0,0 +1,211 @@
+package digest
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SendFunc delivers a rendered digest to one recipient
+type SendFunc func(to string, d *Rendered) error
+
+// QueueFunc returns the current queue health for the report
+type QueueFunc func() QueueHealth
+
+// Scheduler sends digests to subscribed users. Daily digests go out at
+// digest_hour (UTC) and weekly ones at the same hour on digest_weekday
+// (0 = Sunday); the last send time of each is kept in settings so a restart
+// does not send twice.
+type Scheduler struct {
+	db       *sql.DB
+	send     SendFunc
+	queue    QueueFunc
+	hostname string
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewScheduler creates a digest scheduler
+func NewScheduler(db *sql.DB, hostname string, send SendFunc, queue QueueFunc) *Scheduler {
+	return &Scheduler{
+		db:       db,
+		send:     send,
+		queue:    queue,
+		hostname: hostname,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start begins the scheduling loop
+func (s *Scheduler) Start() {
+	s.done = make(chan struct{})
+	go s.loop()
+	log.Info().Msg("Digest scheduler started")
+}
+
+// Stop stops the scheduler and waits for an in-progress send to finish
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+		if s.done != nil {
+			<-s.done
+		}
+		log.Info().Msg("Digest scheduler stopped")
+	})
+}
+
+func (s *Scheduler) loop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case now := <-ticker.C:
+			s.runDue(now.UTC())
+		}
+	}
+}
+
+// runDue sends any digest whose scheduled time has passed since it was
+// last sent
+func (s *Scheduler) runDue(now time.Time) {
+	hour := s.settingInt("digest_hour", 7)
+	if now.Hour() < hour {
+		return
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, time.UTC)
+
+	if s.lastSent(PeriodDaily).Before(today) {
+		s.sendPeriod(PeriodDaily, now)
+	}
+	if int(now.Weekday()) == s.settingInt("digest_weekday", 1) && s.lastSent(PeriodWeekly).Before(today) {
+		s.sendPeriod(PeriodWeekly, now)
+	}
+}
+
+func (s *Scheduler) sendPeriod(period Period, now time.Time) {
+	sent, err := s.SendNow(period, now)
+	if err != nil {
+		log.Error().Err(err).Str("period", string(period)).Msg("Failed to send digest")
+		return
+	}
+	s.db.Exec(`
+		INSERT OR REPLACE INTO settings (key, value, updated_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+	`, "digest_last_"+string(period), now.Format(time.RFC3339))
+	log.Info().Str("period", string(period)).Int("recipients", sent).Msg("Digest sent")
+}
+
+// Preview builds and renders the digest for a period without sending it
+func (s *Scheduler) Preview(period Period, now time.Time, locale string) (*Rendered, error) {
+	report, err := s.build(period, now)
+	if err != nil {
+		return nil, err
+	}
+	return Render(report, locale)
+}
+
+func (s *Scheduler) build(period Period, now time.Time) (*Report, error) {
+	report, err := Build(s.db, period, now, s.queue())
+	if err != nil {
+		return nil, err
+	}
+	report.Hostname = s.hostname
+	return report, nil
+}
+
+// SendNow renders the digest for a period and sends it to every user
+// subscribed to it, returning the number of successful deliveries
+func (s *Scheduler) SendNow(period Period, now time.Time) (int, error) {
+	if period != PeriodDaily && period != PeriodWeekly {
+		return 0, fmt.Errorf("invalid digest period %q", period)
+	}
+
+	recipients, err := s.subscribers(period)
+	if err != nil {
+		return 0, err
+	}
+	if len(recipients) == 0 {
+		return 0, nil
+	}
+
+	report, err := s.build(period, now)
+	if err != nil {
+		return 0, err
+	}
+
+	// Render once per locale
+	rendered := make(map[string]*Rendered)
+	sent := 0
+	for _, sub := range recipients {
+		d, ok := rendered[sub.locale]
+		if !ok {
+			if d, err = Render(report, sub.locale); err != nil {
+				return sent, fmt.Errorf("failed to render digest: %w", err)
+			}
+			rendered[sub.locale] = d
+		}
+		if err := s.send(sub.email, d); err != nil {
+			log.Error().Err(err).Str("to", sub.email).Msg("Failed to deliver digest")
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// subscriber is a digest recipient
+type subscriber struct {
+	email  string
+	locale string
+}
+
+// subscribers returns the users subscribed to a period
+func (s *Scheduler) subscribers(period Period) ([]subscriber, error) {
+	rows, err := s.db.Query(`
+		SELECT u.email, p.locale FROM user_notification_preferences p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.digest_frequency = ? AND u.email != ''
+	`, string(period))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load digest subscribers: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []subscriber
+	for rows.Next() {
+		var sub subscriber
+		if err := rows.Scan(&sub.email, &sub.locale); err == nil {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+func (s *Scheduler) lastSent(period Period) time.Time {
+	var value string
+	s.db.QueryRow(`SELECT value FROM settings WHERE key = ?`, "digest_last_"+string(period)).Scan(&value)
+	t, _ := time.Parse(time.RFC3339, value)
+	return t
+}
+
+func (s *Scheduler) settingInt(key string, def int) int {
+	var value string
+	if err := s.db.QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value); err != nil {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,192 @@
+// Package disclaimer appends a sending domain's footer, such as a legal
+// disclaimer, to outbound mail composed in webmail or sent through the
+// send API.
+//
+// Footers are added to the message's body parts before it is built, so a
+// multipart message gets the text footer in its text/plain part and the
+// HTML footer in its text/html part, and attachments are left alone. A
+// domain can give either format or both; the missing one is derived from
+// the other. A part that already carries the footer, such as a reply
+// quoting an earlier message, doesn't get it again.
+package disclaimer
+
+import (
+	"fmt"
+	"html"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/mail"
+)
+
+// Disclaimer is a domain's footer and when it is left off
+type Disclaimer struct {
+	Domain  string `json:"domain"`
+	Enabled bool   `json:"enabled"`
+	Text    string `json:"text,omitempty"`
+	HTML    string `json:"html,omitempty"`
+	// SkipInternal leaves the footer off messages whose recipients are
+	// all in the sender's domain
+	SkipInternal bool `json:"skipInternal"`
+	// ExemptSenders and ExemptRecipients are address patterns, such as
+	// "noreply@example.com" or "*@partner.example", that leave the footer
+	// off. A message is exempt when its sender matches, or when every
+	// recipient does.
+	ExemptSenders    []string  `json:"exemptSenders"`
+	ExemptRecipients []string  `json:"exemptRecipients"`
+	UpdatedBy        string    `json:"updatedBy,omitempty"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+// Validate checks that a disclaimer has a footer and that its patterns
+// parse, returning the problems by field
+func (d *Disclaimer) Validate() map[string]string {
+	problems := make(map[string]string)
+	if strings.TrimSpace(d.Text) == "" && strings.TrimSpace(d.HTML) == "" {
+		problems["text"] = "a text or HTML footer is required"
+	}
+	for field, patterns := range map[string][]string{"exemptSenders": d.ExemptSenders, "exemptRecipients": d.ExemptRecipients} {
+		for _, p := range patterns {
+			if _, err := path.Match(strings.ToLower(p), ""); err != nil || strings.TrimSpace(p) == "" {
+				problems[field] = fmt.Sprintf("invalid pattern %q", p)
+				break
+			}
+		}
+	}
+	return problems
+}
+
+// Exempt says why a message from sender to recipients goes without the
+// footer, or returns "" when it gets it
+func (d *Disclaimer) Exempt(sender string, recipients []string) string {
+	if !d.Enabled {
+		return "disclaimer is disabled"
+	}
+	if matchAny(d.ExemptSenders, sender) {
+		return "sender is exempt"
+	}
+	if len(recipients) > 0 && len(d.ExemptRecipients) > 0 {
+		all := true
+		for _, rcpt := range recipients {
+			if !matchAny(d.ExemptRecipients, rcpt) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return "all recipients are exempt"
+		}
+	}
+	if d.SkipInternal && len(recipients) > 0 {
+		domain := "@" + Domain(sender)
+		internal := true
+		for _, rcpt := range recipients {
+			if !strings.HasSuffix(strings.ToLower(rcpt), domain) {
+				internal = false
+				break
+			}
+		}
+		if internal {
+			return "all recipients are internal"
+		}
+	}
+	return ""
+}
+
+// matchAny reports whether address matches one of the patterns, ignoring
+// case
+func matchAny(patterns []string, address string) bool {
+	address = strings.ToLower(strings.TrimSpace(address))
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(strings.TrimSpace(p)), address); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Domain returns the lowercased domain of an address
+func Domain(address string) string {
+	if i := strings.LastIndexByte(address, '@'); i >= 0 {
+		return strings.ToLower(strings.TrimSpace(address[i+1:]))
+	}
+	return ""
+}
+
+// Apply appends the footer to msg's text and HTML bodies, whichever it
+// has, and reports whether it changed anything
+func (d *Disclaimer) Apply(msg *mail.ComposeMessage) bool {
+	text, htmlFooter := d.footers()
+	changed := false
+
+	if msg.Body != "" && !contains(msg.Body, text) {
+		msg.Body = strings.TrimRight(msg.Body, "\r\n") + "\n\n" + text + "\n"
+		changed = true
+	}
+	if msg.HTMLBody != "" && !contains(HTMLToText(msg.HTMLBody), HTMLToText(htmlFooter)) {
+		block := `<div class="disclaimer">` + htmlFooter + `</div>`
+		if i := strings.LastIndex(strings.ToLower(msg.HTMLBody), "</body>"); i >= 0 {
+			msg.HTMLBody = msg.HTMLBody[:i] + block + msg.HTMLBody[i:]
+		} else {
+			msg.HTMLBody += block
+		}
+		changed = true
+	}
+	// A message with no body at all still gets the footer
+	if msg.Body == "" && msg.HTMLBody == "" {
+		msg.Body = text + "\n"
+		changed = true
+	}
+	return changed
+}
+
+// footers returns the text and HTML footers, deriving a missing one
+func (d *Disclaimer) footers() (string, string) {
+	text := strings.TrimSpace(d.Text)
+	htmlFooter := strings.TrimSpace(d.HTML)
+	if text == "" {
+		text = HTMLToText(htmlFooter)
+	}
+	if htmlFooter == "" {
+		htmlFooter = "<p>" + strings.ReplaceAll(html.EscapeString(text), "\n", "<br>") + "</p>"
+	}
+	return text, htmlFooter
+}
+
+// contains reports whether body already holds footer, ignoring how
+// whitespace is laid out and any quoting marks of a reply
+func contains(body, footer string) bool {
+	f := normalize(footer)
+	return f != "" && strings.Contains(normalize(body), f)
+}
+
+var (
+	quoteMarks = regexp.MustCompile(`(?m)^[ \t]*(>[ \t]?)+`)
+	spaceRuns  = regexp.MustCompile(`\s+`)
+)
+
+func normalize(s string) string {
+	s = quoteMarks.ReplaceAllString(s, "")
+	return strings.TrimSpace(spaceRuns.ReplaceAllString(s, " "))
+}
+
+var (
+	lineBreaks = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|tr|li|h[1-6])>`)
+	tags       = regexp.MustCompile(`(?s)<(script|style)[^>]*>.*?</(script|style)>|<[^>]*>`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// HTMLToText renders HTML as plain text, roughly: tags are dropped and
+// line breaks kept
+func HTMLToText(s string) string {
+	s = lineBreaks.ReplaceAllString(s, "\n")
+	s = tags.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
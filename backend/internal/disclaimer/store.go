@@ -0,0 +1,107 @@
+package disclaimer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// ErrNotFound is returned when a domain has no disclaimer
+var ErrNotFound = errors.New("disclaimer not found")
+
+const selectColumns = `
+	SELECT domain, enabled, text_footer, html_footer, skip_internal, exempt_senders, exempt_recipients,
+		COALESCE(updated_by, ''), updated_at
+	FROM domain_disclaimers`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (*Disclaimer, error) {
+	var d Disclaimer
+	var senders, recipients string
+	err := row.Scan(&d.Domain, &d.Enabled, &d.Text, &d.HTML, &d.SkipInternal, &senders, &recipients,
+		&d.UpdatedBy, &d.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	d.ExemptSenders, d.ExemptRecipients = []string{}, []string{}
+	json.Unmarshal([]byte(senders), &d.ExemptSenders)
+	json.Unmarshal([]byte(recipients), &d.ExemptRecipients)
+	return &d, nil
+}
+
+// List returns every domain's disclaimer
+func List(db *sql.DB) ([]Disclaimer, error) {
+	rows, err := db.Query(selectColumns + ` ORDER BY domain`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Disclaimer{}
+	for rows.Next() {
+		d, err := scan(rows)
+		if err != nil {
+			continue
+		}
+		list = append(list, *d)
+	}
+	return list, rows.Err()
+}
+
+// Get returns a domain's disclaimer
+func Get(db *sql.DB, domain string) (*Disclaimer, error) {
+	d, err := scan(db.QueryRow(selectColumns+` WHERE domain = ?`, strings.ToLower(domain)))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return d, err
+}
+
+// Save creates or replaces a domain's disclaimer
+func Save(db *sql.DB, d *Disclaimer) error {
+	d.Domain = strings.ToLower(d.Domain)
+	if d.ExemptSenders == nil {
+		d.ExemptSenders = []string{}
+	}
+	if d.ExemptRecipients == nil {
+		d.ExemptRecipients = []string{}
+	}
+	senders, _ := json.Marshal(d.ExemptSenders)
+	recipients, _ := json.Marshal(d.ExemptRecipients)
+	_, err := db.Exec(`
+		INSERT INTO domain_disclaimers (domain, enabled, text_footer, html_footer, skip_internal,
+			exempt_senders, exempt_recipients, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(domain) DO UPDATE SET enabled = excluded.enabled, text_footer = excluded.text_footer,
+			html_footer = excluded.html_footer, skip_internal = excluded.skip_internal,
+			exempt_senders = excluded.exempt_senders, exempt_recipients = excluded.exempt_recipients,
+			updated_by = excluded.updated_by, updated_at = CURRENT_TIMESTAMP
+	`, d.Domain, d.Enabled, d.Text, d.HTML, d.SkipInternal, string(senders), string(recipients), d.UpdatedBy)
+	return err
+}
+
+// Delete removes a domain's disclaimer
+func Delete(db *sql.DB, domain string) error {
+	result, err := db.Exec(`DELETE FROM domain_disclaimers WHERE domain = ?`, strings.ToLower(domain))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ForSender returns the disclaimer of sender's domain, or nil when the
+// domain has none
+func ForSender(db *sql.DB, sender string) (*Disclaimer, error) {
+	d, err := Get(db, Domain(sender))
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return d, err
+}
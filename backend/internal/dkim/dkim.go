@@ -0,0 +1,129 @@
+// Package dkim manages DKIM signing keys: it generates selector keypairs,
+// renders the DNS records that publish them, checks what is published and
+// writes the key tables OpenDKIM and rspamd sign with.
+package dkim
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Key algorithms
+const (
+	AlgorithmRSA     = "rsa"
+	AlgorithmEd25519 = "ed25519"
+)
+
+// DefaultRSABits is the RSA key size used when none is given
+const DefaultRSABits = 2048
+
+// selectorRe matches a selector: dot-separated DNS labels
+var selectorRe = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?(?:\.[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+
+// ValidSelector reports whether s can be used as a selector
+func ValidSelector(s string) bool {
+	return len(s) <= 63 && selectorRe.MatchString(s)
+}
+
+// Key is a domain's signing key under one selector
+type Key struct {
+	Domain    string
+	Selector  string
+	Algorithm string
+	// Bits is the size of an RSA key
+	Bits int
+	// PrivateKeyPEM is the PKCS#8 private key
+	PrivateKeyPEM string
+	// PublicKey is the base64 value published in p=
+	PublicKey string
+}
+
+// Generate creates a keypair for domain under selector. bits applies to
+// RSA keys: 1024 to 4096, DefaultRSABits when zero.
+func Generate(domain, selector, algorithm string, bits int) (*Key, error) {
+	if !ValidSelector(selector) {
+		return nil, fmt.Errorf("invalid selector %q", selector)
+	}
+	k := &Key{Domain: strings.ToLower(domain), Selector: selector, Algorithm: algorithm}
+
+	var private, public interface{}
+	switch algorithm {
+	case AlgorithmRSA:
+		if bits == 0 {
+			bits = DefaultRSABits
+		}
+		if bits < 1024 || bits > 4096 {
+			return nil, fmt.Errorf("RSA keys must be 1024 to 4096 bits, not %d", bits)
+		}
+		rsaKey, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, err
+		}
+		private, public, k.Bits = rsaKey, &rsaKey.PublicKey, bits
+	case AlgorithmEd25519:
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private, public = priv, pub
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", algorithm)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	k.PrivateKeyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	// RFC 8463: an ed25519 record holds the bare 32-byte key, an RSA one
+	// the SubjectPublicKeyInfo
+	if pub, ok := public.(ed25519.PublicKey); ok {
+		k.PublicKey = base64.StdEncoding.EncodeToString(pub)
+	} else {
+		spki, err := x509.MarshalPKIXPublicKey(public)
+		if err != nil {
+			return nil, err
+		}
+		k.PublicKey = base64.StdEncoding.EncodeToString(spki)
+	}
+	return k, nil
+}
+
+// RecordName is where the key is published
+func (k *Key) RecordName() string {
+	return k.Selector + "._domainkey." + k.Domain
+}
+
+// Record is the TXT record that publishes the key
+func (k *Key) Record() string {
+	return "v=DKIM1; k=" + k.Algorithm + "; p=" + k.PublicKey
+}
+
+// RecordStrings splits the record into the strings of at most 255 bytes a
+// TXT record is made of; resolvers join them back
+func (k *Key) RecordStrings() []string {
+	record := k.Record()
+	var parts []string
+	for len(record) > 255 {
+		parts = append(parts, record[:255])
+		record = record[255:]
+	}
+	return append(parts, record)
+}
+
+// ZoneEntry is the record in zone file syntax, ready to paste
+func (k *Key) ZoneEntry() string {
+	quoted := make([]string, 0, 2)
+	for _, s := range k.RecordStrings() {
+		quoted = append(quoted, `"`+s+`"`)
+	}
+	return fmt.Sprintf("%s. 3600 IN TXT ( %s )", k.RecordName(), strings.Join(quoted, " "))
+}
@@ -0,0 +1,143 @@
+package dkim
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Signers that key tables can be written for
+const (
+	SignerOpenDKIM = "opendkim"
+	SignerRspamd   = "rspamd"
+)
+
+const generatedHeader = "# Generated by PostfixRelay - DO NOT EDIT MANUALLY\n"
+
+// Tables are the files a signer reads its keys from, by path
+type Tables map[string][]byte
+
+// sortKeys orders keys by domain and selector so the files are stable
+func sortKeys(keys []Key) []Key {
+	sorted := append([]Key{}, keys...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Domain != sorted[j].Domain {
+			return sorted[i].Domain < sorted[j].Domain
+		}
+		return sorted[i].Selector < sorted[j].Selector
+	})
+	return sorted
+}
+
+func keyFile(dir string, k Key) string {
+	return filepath.Join(dir, "keys", k.Domain, k.Selector+".private")
+}
+
+// OpenDKIMTables renders a KeyTable, a SigningTable and the key files
+// under dir. opendkim.conf points at them with
+//
+//	KeyTable     file:<dir>/KeyTable
+//	SigningTable refile:<dir>/SigningTable
+func OpenDKIMTables(dir string, keys []Key) Tables {
+	tables := Tables{}
+	var keyTable, signingTable strings.Builder
+	keyTable.WriteString(generatedHeader)
+	signingTable.WriteString(generatedHeader)
+	for _, k := range sortKeys(keys) {
+		path := keyFile(dir, k)
+		fmt.Fprintf(&keyTable, "%s %s:%s:%s\n", k.RecordName(), k.Domain, k.Selector, path)
+		fmt.Fprintf(&signingTable, "*@%s %s\n", k.Domain, k.RecordName())
+		tables[path] = []byte(k.PrivateKeyPEM)
+	}
+	tables[filepath.Join(dir, "KeyTable")] = []byte(keyTable.String())
+	tables[filepath.Join(dir, "SigningTable")] = []byte(signingTable.String())
+	return tables
+}
+
+// RspamdTables renders the dkim_signing module settings and the key files
+// under dir. The settings go to <dir>/dkim_signing.conf, which rspamd's
+// local.d/dkim_signing.conf includes with
+//
+//	.include "<dir>/dkim_signing.conf"
+func RspamdTables(dir string, keys []Key) Tables {
+	tables := Tables{}
+	var conf strings.Builder
+	conf.WriteString(generatedHeader)
+	conf.WriteString("use_domain = \"header\";\nallow_username_mismatch = true;\n")
+	conf.WriteString("domain {\n")
+
+	byDomain := map[string][]Key{}
+	var domains []string
+	for _, k := range sortKeys(keys) {
+		if _, ok := byDomain[k.Domain]; !ok {
+			domains = append(domains, k.Domain)
+		}
+		byDomain[k.Domain] = append(byDomain[k.Domain], k)
+	}
+	for _, domain := range domains {
+		fmt.Fprintf(&conf, "  %q {\n    selectors [\n", domain)
+		for _, k := range byDomain[domain] {
+			path := keyFile(dir, k)
+			fmt.Fprintf(&conf, "      { path = %q; selector = %q; }\n", path, k.Selector)
+			tables[path] = []byte(k.PrivateKeyPEM)
+		}
+		conf.WriteString("    ]\n  }\n")
+	}
+	conf.WriteString("}\n")
+	tables[filepath.Join(dir, "dkim_signing.conf")] = []byte(conf.String())
+	return tables
+}
+
+// Write writes the tables, each file replaced in one step. Key files are
+// readable by the owner and group only, so run the signer in the group of
+// the user writing them. Key files under <dir>/keys that no table names
+// any more are removed.
+func (t Tables) Write(dir string) error {
+	for path, data := range t {
+		perm := os.FileMode(0644)
+		if strings.HasSuffix(path, ".private") {
+			perm = 0640
+		}
+		if err := writeFile(path, data, perm); err != nil {
+			return err
+		}
+	}
+
+	keysDir := filepath.Join(dir, "keys")
+	return filepath.Walk(keysDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".private") {
+			if _, ok := t[path]; !ok {
+				return os.Remove(path)
+			}
+		}
+		return nil
+	})
+}
+
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	// WriteFile leaves the mode of an existing file alone
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
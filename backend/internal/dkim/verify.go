@@ -0,0 +1,85 @@
+package dkim
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/postfixrelay/postfixrelay/internal/mailauth"
+)
+
+// Outcomes of checking a published record
+const (
+	DNSOK       = "ok"       // the record publishes this key
+	DNSMissing  = "missing"  // nothing is published at the name
+	DNSMismatch = "mismatch" // a different key is published
+	DNSRevoked  = "revoked"  // the record has an empty p=
+	DNSError    = "error"    // the lookup failed
+)
+
+// Resolver looks up TXT records; net.DefaultResolver is one
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// DNSCheck is what is published for a key
+type DNSCheck struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Published string `json:"published,omitempty"`
+	Expected  string `json:"expected"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// Verify looks up the key's record and compares its public key with k's
+func Verify(ctx context.Context, r Resolver, k *Key) DNSCheck {
+	check := DNSCheck{Name: k.RecordName(), Expected: k.Record()}
+	records, err := r.LookupTXT(ctx, check.Name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			check.Status, check.Reason = DNSMissing, "no TXT record at "+check.Name
+			return check
+		}
+		check.Status, check.Reason = DNSError, err.Error()
+		return check
+	}
+
+	for _, rec := range records {
+		tags, err := mailauth.ParseTags(rec)
+		if err != nil {
+			continue
+		}
+		if v, ok := tags["v"]; ok && v != "DKIM1" {
+			continue
+		}
+		p, ok := tags["p"]
+		if !ok {
+			continue
+		}
+		// Resolvers may return a long key as folded strings
+		p = strings.Join(strings.Fields(p), "")
+		check.Published = rec
+		switch {
+		case p == "":
+			check.Status, check.Reason = DNSRevoked, "the published record has an empty p=, which revokes the key"
+		case p != k.PublicKey:
+			check.Status, check.Reason = DNSMismatch, "the published key isn't this selector's key"
+		case !strings.EqualFold(orDefault(tags["k"], AlgorithmRSA), k.Algorithm):
+			check.Status, check.Reason = DNSMismatch, "the record gives k="+tags["k"]+" for a "+k.Algorithm+" key"
+		default:
+			check.Status, check.Reason = DNSOK, ""
+		}
+		return check
+	}
+	check.Status, check.Reason = DNSMissing, "no DKIM key record at "+check.Name
+	return check
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+package dkim
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+type txtResolver map[string][]string
+
+func (r txtResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if records, ok := r[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func TestVerify(t *testing.T) {
+	k, err := Generate("example.com", "mail", AlgorithmEd25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := Generate("example.com", "mail", AlgorithmEd25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folded := "v=DKIM1; k=ed25519; p=" + k.PublicKey[:20] + " " + k.PublicKey[20:]
+
+	tests := []struct {
+		name    string
+		records []string
+		want    string
+	}{
+		{"published", []string{k.Record()}, DNSOK},
+		{"folded key", []string{folded}, DNSOK},
+		{"among other records", []string{"google-site-verification=abc", "v=DKIM1; p=; p=", k.Record()}, DNSOK},
+		{"other key", []string{other.Record()}, DNSMismatch},
+		{"wrong k=", []string{"v=DKIM1; k=rsa; p=" + k.PublicKey}, DNSMismatch},
+		{"default k= is rsa", []string{"v=DKIM1; p=" + k.PublicKey}, DNSMismatch},
+		{"revoked", []string{"v=DKIM1; k=ed25519; p="}, DNSRevoked},
+		{"not a key record", []string{"v=spf1 -all"}, DNSMissing},
+		{"nothing published", nil, DNSMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := txtResolver{}
+			if tt.records != nil {
+				r[k.RecordName()] = tt.records
+			}
+			check := Verify(context.Background(), r, k)
+			if check.Status != tt.want {
+				t.Errorf("status = %s (%s), want %s", check.Status, check.Reason, tt.want)
+			}
+			if check.Status == DNSOK && !strings.HasPrefix(check.Published, "v=DKIM1") {
+				t.Errorf("published = %q", check.Published)
+			}
+		})
+	}
+}
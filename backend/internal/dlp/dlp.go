@@ -0,0 +1,195 @@
+package dlp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Action is what happens to a message that matches a rule
+type Action string
+
+const (
+	ActionWarn       Action = "warn"
+	ActionQuarantine Action = "quarantine"
+	ActionBlock      Action = "block"
+)
+
+// severity orders actions so the strictest matching rule wins
+var severity = map[Action]int{
+	ActionWarn:       1,
+	ActionQuarantine: 2,
+	ActionBlock:      3,
+}
+
+// Rule types
+const (
+	TypeRegex      = "regex"       // Pattern is a regular expression
+	TypeKeyword    = "keyword"     // Pattern is a comma-separated list of case-insensitive keywords
+	TypeCreditCard = "credit_card" // Built-in: card numbers passing the Luhn check
+	TypeSSN        = "ssn"         // Built-in: US social security numbers
+)
+
+// Rule is an admin-defined DLP rule
+type Rule struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Type        string    `json:"type"`
+	Pattern     string    `json:"pattern,omitempty"`
+	Action      Action    `json:"action"`
+	Enabled     bool      `json:"enabled"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Match records a rule that matched a message
+type Match struct {
+	RuleID   int64  `json:"ruleId"`
+	RuleName string `json:"ruleName"`
+	Action   Action `json:"action"`
+	Count    int    `json:"count"`
+}
+
+var (
+	// 13-19 digits, optionally separated by single spaces or dashes
+	creditCardRegex = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	ssnRegex        = regexp.MustCompile(`\b(\d{3})-(\d{2})-(\d{4})\b`)
+)
+
+// Validate checks that a rule is well-formed
+func (r *Rule) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if _, ok := severity[r.Action]; !ok {
+		return fmt.Errorf("action must be one of: warn, quarantine, block")
+	}
+
+	switch r.Type {
+	case TypeRegex:
+		if r.Pattern == "" {
+			return fmt.Errorf("pattern is required for regex rules")
+		}
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("invalid regular expression: %w", err)
+		}
+	case TypeKeyword:
+		if len(keywords(r.Pattern)) == 0 {
+			return fmt.Errorf("at least one keyword is required")
+		}
+	case TypeCreditCard, TypeSSN:
+	default:
+		return fmt.Errorf("type must be one of: regex, keyword, credit_card, ssn")
+	}
+	return nil
+}
+
+// Evaluate runs the enabled rules against the given message parts (subject,
+// text body, HTML body, ...) and returns the rules that matched
+func Evaluate(rules []Rule, parts ...string) []Match {
+	content := strings.Join(parts, "\n")
+	matches := make([]Match, 0)
+
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		if n := count(rule, content); n > 0 {
+			matches = append(matches, Match{
+				RuleID:   rule.ID,
+				RuleName: rule.Name,
+				Action:   rule.Action,
+				Count:    n,
+			})
+		}
+	}
+	return matches
+}
+
+// Decide returns the strictest action among the matches, or "" if none
+func Decide(matches []Match) Action {
+	var action Action
+	for _, m := range matches {
+		if severity[m.Action] > severity[action] {
+			action = m.Action
+		}
+	}
+	return action
+}
+
+// count returns how many times a rule matches the content
+func count(rule Rule, content string) int {
+	switch rule.Type {
+	case TypeRegex:
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return 0
+		}
+		return len(re.FindAllStringIndex(content, -1))
+
+	case TypeKeyword:
+		lower := strings.ToLower(content)
+		n := 0
+		for _, kw := range keywords(rule.Pattern) {
+			n += strings.Count(lower, kw)
+		}
+		return n
+
+	case TypeCreditCard:
+		n := 0
+		for _, candidate := range creditCardRegex.FindAllString(content, -1) {
+			if luhnValid(candidate) {
+				n++
+			}
+		}
+		return n
+
+	case TypeSSN:
+		n := 0
+		for _, m := range ssnRegex.FindAllStringSubmatch(content, -1) {
+			// Area 000, 666 and 9xx, group 00 and serial 0000 are never issued
+			if m[1] == "000" || m[1] == "666" || m[1][0] == '9' || m[2] == "00" || m[3] == "0000" {
+				continue
+			}
+			n++
+		}
+		return n
+	}
+	return 0
+}
+
+// keywords splits a comma-separated keyword list
+func keywords(pattern string) []string {
+	var out []string
+	for _, kw := range strings.Split(pattern, ",") {
+		if kw = strings.ToLower(strings.TrimSpace(kw)); kw != "" {
+			out = append(out, kw)
+		}
+	}
+	return out
+}
+
+// luhnValid reports whether a digit string (ignoring separators) passes
+// the Luhn checksum used by payment card numbers
+func luhnValid(s string) bool {
+	sum, digits := 0, 0
+	double := false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
@@ -0,0 +1,71 @@
+package dovecot
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// AppPasswordSlots is how many app passwords a mailbox can have. Dovecot's
+// passwd-file passdb holds one password per user, so each slot is its own
+// file and passdb, tried in turn after the primary one fails.
+const AppPasswordSlots = 5
+
+// AppPasswordFile returns the passwd-file holding slot n (0-based) of every
+// mailbox's app passwords
+func (s *Syncer) AppPasswordFile(slot int) string {
+	return filepath.Join(s.config.DovecotAppPasswordDir, fmt.Sprintf("slot%d", slot))
+}
+
+// SyncAppPasswords writes the app password passdb files. Every slot file is
+// written, empty if no mailbox uses it, so Dovecot never looks up a missing
+// file. Mailboxes or domains that are inactive, and mailboxes with app
+// passwords turned off, get no entries.
+func (s *Syncer) SyncAppPasswords() error {
+	rows, err := s.db.Query(`
+		SELECT m.email, a.slot, a.label, a.password_hash
+		FROM mailbox_app_passwords a
+		JOIN mailboxes m ON a.mailbox_id = m.id
+		JOIN mail_domains d ON m.domain_id = d.id
+		WHERE m.active = TRUE AND d.active = TRUE AND m.app_passwords_enabled = TRUE
+		ORDER BY m.email
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to query app passwords: %w", err)
+	}
+	defer rows.Close()
+
+	slots := make([]strings.Builder, AppPasswordSlots)
+	for i := range slots {
+		slots[i].WriteString("# Generated by PSFX Admin - DO NOT EDIT MANUALLY\n")
+	}
+
+	count := 0
+	for rows.Next() {
+		var email, label, hash string
+		var slot int
+		if err := rows.Scan(&email, &slot, &label, &hash); err != nil {
+			log.Warn().Err(err).Msg("Failed to scan app password row")
+			continue
+		}
+		if slot < 0 || slot >= AppPasswordSlots {
+			continue
+		}
+		// Unlike the primary passdb these files set no default scheme, so
+		// the hash carries its own. The label is an extra field Dovecot
+		// passes through, so logs and auth replies show which one was used.
+		fmt.Fprintf(&slots[slot], "%s:{BLF-CRYPT}%s::::::app_password=%s\n", email, hash, label)
+		count++
+	}
+
+	for i := range slots {
+		if err := atomicWriteFile(s.AppPasswordFile(i), []byte(slots[i].String()), 0644); err != nil {
+			return fmt.Errorf("failed to write app password slot %d: %w", i, err)
+		}
+	}
+
+	log.Info().Int("count", count).Msg("Dovecot app passwords synced")
+	return nil
+}
@@ -0,0 +1,76 @@
+package dovecot
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// AuthSocketCheck is the result of connecting to Dovecot's auth socket the
+// way Postfix's smtpd does for SMTP AUTH
+type AuthSocketCheck struct {
+	Network    string   `json:"network"`
+	Address    string   `json:"address"`
+	Reachable  bool     `json:"reachable"`
+	Mechanisms []string `json:"mechanisms,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// CheckAuthSocket connects to a Dovecot auth socket and completes the
+// client handshake, returning the mechanisms it offers
+func (s *Syncer) CheckAuthSocket(network, address string) *AuthSocketCheck {
+	check := &AuthSocketCheck{Network: network, Address: address}
+
+	mechanisms, err := authHandshake(network, address)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.Reachable = true
+	check.Mechanisms = mechanisms
+	return check
+}
+
+// authHandshake speaks the start of the Dovecot auth protocol: the client
+// sends its version and process ID, and the server lists its mechanisms
+// up to DONE
+func authHandshake(network, address string) ([]string, error) {
+	conn, err := net.DialTimeout(network, address, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	if _, err := fmt.Fprintf(conn, "VERSION\t1\t2\nCPID\t%d\n", os.Getpid()); err != nil {
+		return nil, err
+	}
+
+	var mechanisms []string
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("handshake not completed: %w", err)
+		}
+		fields := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+		switch fields[0] {
+		case "VERSION":
+			if len(fields) < 2 || fields[1] != "1" {
+				return nil, fmt.Errorf("unsupported auth protocol version %q", strings.Join(fields[1:], "."))
+			}
+		case "MECH":
+			if len(fields) > 1 {
+				mechanisms = append(mechanisms, fields[1])
+			}
+		case "DONE":
+			if len(mechanisms) == 0 {
+				return nil, fmt.Errorf("no authentication mechanisms offered")
+			}
+			return mechanisms, nil
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package dovecot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ImportedUser is a mailbox read from an existing Dovecot userdb
+type ImportedUser struct {
+	Line         int    `json:"line,omitempty"`
+	Email        string `json:"email"`
+	PasswordHash string `json:"-"`
+	QuotaBytes   int64  `json:"quotaBytes"`
+}
+
+// ImportProblem is a userdb entry that can't be imported, and why
+type ImportProblem struct {
+	Line   int    `json:"line,omitempty"`
+	Email  string `json:"email,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// UserRow is one row of an SQL userdb, exported by the admin with a query
+// like SELECT username AS user, password, quota_bytes FROM users
+type UserRow struct {
+	User       string `json:"user"`
+	Password   string `json:"password"`
+	QuotaBytes int64  `json:"quotaBytes"`
+}
+
+// cryptSchemes are the Dovecot schemes of crypt(3) hashes, by their prefix
+var cryptSchemes = []struct{ prefix, scheme string }{
+	{"$6$", "SHA512-CRYPT"},
+	{"$5$", "SHA256-CRYPT"},
+	{"$1$", "MD5-CRYPT"},
+	{"$argon2id$", "ARGON2ID"},
+	{"$argon2i$", "ARGON2I"},
+}
+
+var schemePrefix = regexp.MustCompile(`^\{[A-Z0-9.-]+\}`)
+
+// NormalizePasswordHash returns a hash in the form the generated passwd file
+// needs. The passdb defaults to BLF-CRYPT, so bcrypt hashes are stored bare
+// and every other hash carries its {SCHEME} prefix, which Dovecot honours per
+// password. Hashes without a prefix get defaultScheme, the source's
+// default_pass_scheme, unless their crypt prefix names the scheme.
+func NormalizePasswordHash(hash, defaultScheme string) (string, error) {
+	if hash == "" {
+		return "", fmt.Errorf("no password")
+	}
+	if strings.HasPrefix(hash, "{BLF-CRYPT}") {
+		return strings.TrimPrefix(hash, "{BLF-CRYPT}"), nil
+	}
+	if schemePrefix.MatchString(hash) {
+		return hash, nil
+	}
+	for _, p := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(hash, p) {
+			return hash, nil
+		}
+	}
+	for _, c := range cryptSchemes {
+		if strings.HasPrefix(hash, c.prefix) {
+			return "{" + c.scheme + "}" + hash, nil
+		}
+	}
+	if defaultScheme == "" {
+		return "", fmt.Errorf("password scheme unknown; set the source's default scheme")
+	}
+	defaultScheme = strings.ToUpper(defaultScheme)
+	if defaultScheme == "BLF-CRYPT" {
+		return hash, nil
+	}
+	return "{" + defaultScheme + "}" + hash, nil
+}
+
+// parseQuotaRule returns the byte limit of a userdb quota_rule value such
+// as *:storage=1G or *:bytes=1073741824, or 0 if it has none
+func parseQuotaRule(rule string) int64 {
+	for _, part := range strings.Split(rule, ":") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || (key != "storage" && key != "bytes") {
+			continue
+		}
+		multiplier := int64(1)
+		if n := len(value); n > 0 {
+			switch value[n-1] {
+			case 'k', 'K':
+				multiplier, value = 1024, value[:n-1]
+			case 'M':
+				multiplier, value = 1024*1024, value[:n-1]
+			case 'G':
+				multiplier, value = 1024*1024*1024, value[:n-1]
+			case 'T':
+				multiplier, value = 1024*1024*1024*1024, value[:n-1]
+			case 'b', 'B':
+				value = value[:n-1]
+			}
+		}
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return v * multiplier
+		}
+	}
+	return 0
+}
+
+// newImportedUser checks and normalizes one user from either source
+func newImportedUser(line int, email, password string, quota int64, defaultScheme string) (ImportedUser, *ImportProblem) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" || domain == "" || strings.Contains(domain, "@") {
+		return ImportedUser{}, &ImportProblem{Line: line, Email: email, Reason: "user isn't an email address"}
+	}
+	hash, err := NormalizePasswordHash(password, defaultScheme)
+	if err != nil {
+		return ImportedUser{}, &ImportProblem{Line: line, Email: email, Reason: err.Error()}
+	}
+	return ImportedUser{Line: line, Email: email, PasswordHash: hash, QuotaBytes: quota}, nil
+}
+
+// ParsePasswdFile reads users from a Dovecot passwd-file:
+// user:password:uid:gid:gecos:home:shell:extra_fields
+func ParsePasswdFile(r io.Reader, defaultScheme string) ([]ImportedUser, []ImportProblem, error) {
+	users := []ImportedUser{}
+	problems := []ImportProblem{}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, ":", 8)
+		if len(fields) < 2 {
+			problems = append(problems, ImportProblem{Line: lineNo, Reason: "expected user:password"})
+			continue
+		}
+
+		var quota int64
+		if len(fields) == 8 {
+			for _, extra := range strings.Fields(fields[7]) {
+				key, value, _ := strings.Cut(extra, "=")
+				if key == "userdb_quota_rule" || key == "quota_rule" {
+					quota = parseQuotaRule(value)
+				}
+			}
+		}
+		user, problem := newImportedUser(lineNo, fields[0], fields[1], quota, defaultScheme)
+		if problem != nil {
+			problems = append(problems, *problem)
+			continue
+		}
+		users = append(users, user)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return users, problems, nil
+}
+
+// ParseUserRows reads users from rows exported from an SQL userdb
+func ParseUserRows(rows []UserRow, defaultScheme string) ([]ImportedUser, []ImportProblem) {
+	users := []ImportedUser{}
+	problems := []ImportProblem{}
+	for i, row := range rows {
+		user, problem := newImportedUser(i+1, row.User, row.Password, row.QuotaBytes, defaultScheme)
+		if problem != nil {
+			problems = append(problems, *problem)
+			continue
+		}
+		users = append(users, user)
+	}
+	return users, problems
+}
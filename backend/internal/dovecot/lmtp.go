@@ -0,0 +1,232 @@
+package dovecot
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+)
+
+// defaultBaseDir is where Dovecot puts unix listeners with relative paths
+const defaultBaseDir = "/var/run/dovecot"
+
+// dialTimeout bounds the connectivity tests to the LMTP listener and
+// the auth socket
+const dialTimeout = 5 * time.Second
+
+// LMTPListener is a listener of Dovecot's lmtp service
+type LMTPListener struct {
+	Network string `json:"network"`           // "unix" or "tcp"
+	Address string `json:"address,omitempty"` // listen addresses of a tcp listener
+	Port    string `json:"port,omitempty"`
+	Path    string `json:"path,omitempty"` // socket path of a unix listener
+}
+
+// DeliveryCheck is the result of comparing Postfix's virtual_transport with
+// the Dovecot LMTP service and connecting to it
+type DeliveryCheck struct {
+	VirtualTransport string                   `json:"virtualTransport"`
+	Destination      *postfix.LMTPDestination `json:"destination,omitempty"`
+	Listeners        []LMTPListener           `json:"listeners"`
+	LMTPEnabled      bool                     `json:"lmtpEnabled"` // lmtp is in Dovecot's protocols
+	Matches          bool                     `json:"matches"`     // the destination is one of the listeners
+	Reachable        bool                     `json:"reachable"`
+	Greeting         string                   `json:"greeting,omitempty"`
+	Problems         []string                 `json:"problems"`
+}
+
+// OK reports whether delivery is expected to work
+func (c *DeliveryCheck) OK() bool {
+	return len(c.Problems) == 0
+}
+
+func (c *DeliveryCheck) problem(format string, args ...interface{}) {
+	c.Problems = append(c.Problems, fmt.Sprintf(format, args...))
+}
+
+// CheckDelivery checks that Postfix's virtual_transport delivers to a
+// listener of Dovecot's lmtp service and that the listener answers
+func (s *Syncer) CheckDelivery(virtualTransport string) *DeliveryCheck {
+	check := &DeliveryCheck{VirtualTransport: virtualTransport, Problems: []string{}}
+
+	conf, err := readDovecotConf(filepath.Join(s.config.DovecotConfDir, "dovecot.conf"))
+	if err != nil {
+		check.problem("failed to read Dovecot configuration: %v", err)
+	} else {
+		check.LMTPEnabled = conf.protocols["lmtp"]
+		check.Listeners = conf.lmtpListeners
+		if !check.LMTPEnabled {
+			check.problem("lmtp is not in Dovecot's protocols")
+		}
+		if len(check.Listeners) == 0 {
+			check.problem("Dovecot's lmtp service has no listeners")
+		}
+	}
+
+	if virtualTransport == "" {
+		check.problem("virtual_transport is not set, so Postfix delivers virtual mailboxes itself")
+		return check
+	}
+	dest, err := postfix.ParseLMTPTransport(virtualTransport)
+	if err != nil {
+		check.problem("virtual_transport: %v", err)
+		return check
+	}
+	check.Destination = &dest
+
+	for _, l := range check.Listeners {
+		if l.Network != dest.Network {
+			continue
+		}
+		if (l.Network == "unix" && l.Path == dest.Address) || (l.Network == "tcp" && l.Port == dest.Port()) {
+			check.Matches = true
+			break
+		}
+	}
+	if conf != nil && !check.Matches {
+		check.problem("virtual_transport delivers to %s %s, which is not a Dovecot LMTP listener", dest.Network, dest.Address)
+	}
+
+	greeting, err := dialLMTP(dest)
+	if err != nil {
+		check.problem("failed to connect to %s: %v", dest.Address, err)
+		return check
+	}
+	check.Reachable = true
+	check.Greeting = greeting
+	if !strings.HasPrefix(greeting, "220") || !strings.Contains(greeting, "LMTP") {
+		check.problem("%s did not greet as an LMTP server: %s", dest.Address, greeting)
+	}
+	return check
+}
+
+// dialLMTP connects to an LMTP destination and returns its greeting
+func dialLMTP(dest postfix.LMTPDestination) (string, error) {
+	conn, err := net.DialTimeout(dest.Network, dest.Address, dialTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	greeting, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("no greeting: %w", err)
+	}
+	fmt.Fprint(conn, "QUIT\r\n")
+	return strings.TrimSpace(greeting), nil
+}
+
+// dovecotConf is what the delivery check needs from Dovecot's configuration
+type dovecotConf struct {
+	protocols     map[string]bool
+	baseDir       string
+	lmtpListeners []LMTPListener
+}
+
+// readDovecotConf reads dovecot.conf and the files it includes. Only the
+// protocols and base_dir settings and the lmtp service are picked out.
+func readDovecotConf(path string) (*dovecotConf, error) {
+	conf := &dovecotConf{protocols: map[string]bool{}, baseDir: defaultBaseDir}
+	if err := conf.read(path, 0); err != nil {
+		return nil, err
+	}
+	for i, l := range conf.lmtpListeners {
+		if l.Network == "unix" && !filepath.IsAbs(l.Path) {
+			conf.lmtpListeners[i].Path = filepath.Join(conf.baseDir, l.Path)
+		}
+	}
+	return conf, nil
+}
+
+func (c *dovecotConf) read(path string, depth int) error {
+	if depth > 8 {
+		return fmt.Errorf("includes nested too deeply at %s", path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// blocks holds the open sections, e.g. ["service lmtp", "inet_listener lmtp"]
+	var blocks []string
+	var listener *LMTPListener
+	inLMTP := func() bool { return len(blocks) > 0 && blocks[0] == "service lmtp" }
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "!include"):
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			pattern := fields[1]
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(filepath.Dir(path), pattern)
+			}
+			matches, _ := filepath.Glob(pattern)
+			if len(matches) == 0 && fields[0] == "!include" && !strings.ContainsAny(pattern, "*?[") {
+				return fmt.Errorf("included file %s not found", pattern)
+			}
+			for _, match := range matches {
+				if err := c.read(match, depth+1); err != nil {
+					return err
+				}
+			}
+		case strings.HasSuffix(line, "{"):
+			block := strings.Join(strings.Fields(strings.TrimSuffix(line, "{")), " ")
+			blocks = append(blocks, block)
+			if inLMTP() && len(blocks) == 2 {
+				name, arg, _ := strings.Cut(block, " ")
+				switch name {
+				case "inet_listener":
+					listener = &LMTPListener{Network: "tcp", Address: "*, ::"}
+				case "unix_listener":
+					listener = &LMTPListener{Network: "unix", Path: arg}
+				}
+			}
+		case line == "}":
+			if inLMTP() && len(blocks) == 2 && listener != nil {
+				c.lmtpListeners = append(c.lmtpListeners, *listener)
+				listener = nil
+			}
+			if len(blocks) > 0 {
+				blocks = blocks[:len(blocks)-1]
+			}
+		default:
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			switch {
+			case len(blocks) == 0 && key == "protocols":
+				if !strings.Contains(value, "$protocols") {
+					c.protocols = map[string]bool{}
+				}
+				for _, p := range strings.Fields(strings.ReplaceAll(value, "$protocols", "")) {
+					c.protocols[p] = true
+				}
+			case len(blocks) == 0 && key == "base_dir":
+				c.baseDir = value
+			case listener != nil && key == "port":
+				listener.Port = value
+			case listener != nil && key == "address":
+				listener.Address = value
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -7,8 +7,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/postfixrelay/postfixrelay/internal/archive"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
 	"github.com/rs/zerolog/log"
 )
 
@@ -17,10 +20,15 @@ type Config struct {
 	// Dovecot paths
 	DovecotPasswdFile string // e.g., /etc/dovecot/users
 	DovecotUserDBFile string // e.g., /etc/dovecot/userdb
+	DovecotConfDir    string // e.g., /etc/dovecot
+
+	// Directory of the app password passdb files, one per slot
+	DovecotAppPasswordDir string // e.g., /etc/dovecot/app-passwords
 
 	// Postfix paths
 	PostfixVirtualMailbox string // e.g., /etc/postfix/vmailbox
 	PostfixVirtualAlias   string // e.g., /etc/postfix/virtual
+	PostfixArchiveBCC     string // e.g., /etc/postfix/archive_bcc
 
 	// Mail storage
 	MailDir string // e.g., /var/mail/vhosts
@@ -35,18 +43,26 @@ func DefaultConfig() *Config {
 	return &Config{
 		DovecotPasswdFile:     "/etc/dovecot/users",
 		DovecotUserDBFile:     "/etc/dovecot/userdb",
+		DovecotConfDir:        "/etc/dovecot",
+		DovecotAppPasswordDir: "/etc/dovecot/app-passwords",
 		PostfixVirtualMailbox: "/etc/postfix/vmailbox",
 		PostfixVirtualAlias:   "/etc/postfix/virtual",
+		PostfixArchiveBCC:     "/etc/postfix/archive_bcc",
 		MailDir:               "/var/mail/vhosts",
 		VmailUID:              5000,
 		VmailGID:              5000,
 	}
 }
 
+// AliasHook may rewrite the aliases, by source address, before they go
+// into the virtual alias map. An error fails the sync.
+type AliasHook func(aliases map[string][]string) (map[string][]string, error)
+
 // Syncer handles synchronization between the database and mail server config files
 type Syncer struct {
-	db     *sql.DB
-	config *Config
+	db        *sql.DB
+	config    *Config
+	aliasHook AliasHook
 }
 
 // NewSyncer creates a new syncer with the given database and configuration
@@ -143,6 +159,11 @@ func (s *Syncer) SyncDovecotUsers() error {
 		return fmt.Errorf("failed to write dovecot passwd file: %w", err)
 	}
 
+	// App passwords follow their mailbox being disabled or removed
+	if err := s.SyncAppPasswords(); err != nil {
+		return err
+	}
+
 	// Create mail directories for new users
 	for _, m := range mailboxes {
 		home := filepath.Join(s.config.MailDir, m.domain, strings.Split(m.email, "@")[0])
@@ -155,7 +176,8 @@ func (s *Syncer) SyncDovecotUsers() error {
 	return nil
 }
 
-// SyncPostfixMaps generates Postfix virtual mailbox and alias maps
+// SyncPostfixMaps generates Postfix virtual mailbox, alias and archive
+// BCC maps
 func (s *Syncer) SyncPostfixMaps() error {
 	log.Info().Msg("Syncing Postfix virtual maps")
 
@@ -169,9 +191,90 @@ func (s *Syncer) SyncPostfixMaps() error {
 		return fmt.Errorf("virtual alias sync failed: %w", err)
 	}
 
+	// Generate archive BCC map
+	if err := s.SyncArchiveBCC(); err != nil {
+		return fmt.Errorf("archive bcc sync failed: %w", err)
+	}
+
 	return nil
 }
 
+// SyncArchiveBCC generates the map that copies mail from and to domains
+// with archiving enabled to the archive address. It is used as both
+// sender_bcc_maps and recipient_bcc_maps, and is empty when archiving is
+// off.
+func (s *Syncer) SyncArchiveBCC() error {
+	settings := map[string]string{}
+	rows, err := s.db.Query(`SELECT key, value FROM settings WHERE key IN ('archive_mode', 'archive_smtp_address')`)
+	if err != nil {
+		return fmt.Errorf("failed to query archive settings: %w", err)
+	}
+	for rows.Next() {
+		var key, value string
+		if rows.Scan(&key, &value) == nil {
+			settings[key] = value
+		}
+	}
+	rows.Close()
+
+	domainRows, err := s.db.Query(`SELECT domain FROM mail_domains WHERE active = TRUE AND archive_enabled = TRUE`)
+	if err != nil {
+		return fmt.Errorf("failed to query archived domains: %w", err)
+	}
+	defer domainRows.Close()
+
+	var domains []string
+	for domainRows.Next() {
+		var domain string
+		if err := domainRows.Scan(&domain); err != nil {
+			continue
+		}
+		domains = append(domains, domain)
+	}
+
+	mapType := postfix.MapType("archive_bcc")
+	address := archive.BCCAddress(settings["archive_mode"], settings["archive_smtp_address"])
+	content := archive.BCCMap(domains, address, mapType)
+	if err := atomicWriteFile(s.config.PostfixArchiveBCC, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write archive_bcc: %w", err)
+	}
+
+	if err := postfix.CompileMap(mapType, s.config.PostfixArchiveBCC, false); err != nil {
+		return fmt.Errorf("postmap archive_bcc failed: %w", err)
+	}
+
+	if address == "" {
+		domains = nil
+	}
+	log.Info().Int("domains", len(domains)).Msg("Postfix archive BCC map synced")
+	return nil
+}
+
+// SetAliasHook sets the hook the aliases pass through each time the
+// virtual alias map is built. Call it before the first sync.
+func (s *Syncer) SetAliasHook(hook AliasHook) {
+	s.aliasHook = hook
+}
+
+// Config returns the paths and IDs the syncer was created with
+func (s *Syncer) Config() Config {
+	return *s.config
+}
+
+// MapPath returns the file of a map the syncer writes (vmailbox, virtual
+// or archive_bcc), or "" for other maps
+func (s *Syncer) MapPath(name string) string {
+	switch name {
+	case "vmailbox":
+		return s.config.PostfixVirtualMailbox
+	case "virtual":
+		return s.config.PostfixVirtualAlias
+	case "archive_bcc":
+		return s.config.PostfixArchiveBCC
+	}
+	return ""
+}
+
 func (s *Syncer) syncVirtualMailbox() error {
 	// Query all active mailboxes
 	rows, err := s.db.Query(`
@@ -206,7 +309,7 @@ func (s *Syncer) syncVirtualMailbox() error {
 		return fmt.Errorf("failed to write vmailbox: %w", err)
 	}
 
-	if err := runPostmap(s.config.PostfixVirtualMailbox); err != nil {
+	if err := postfix.CompileMap(postfix.MapType("vmailbox"), s.config.PostfixVirtualMailbox, false); err != nil {
 		return fmt.Errorf("postmap vmailbox failed: %w", err)
 	}
 
@@ -215,6 +318,38 @@ func (s *Syncer) syncVirtualMailbox() error {
 }
 
 func (s *Syncer) syncVirtualAlias() error {
+	aliases, domains, err := s.loadVirtualAliases()
+	if err != nil {
+		return err
+	}
+
+	// Write and postmap
+	if err := atomicWriteFile(s.config.PostfixVirtualAlias, []byte(renderVirtualAlias(aliases, domains)), 0644); err != nil {
+		return fmt.Errorf("failed to write virtual: %w", err)
+	}
+
+	if err := postfix.CompileMap(postfix.MapType("virtual"), s.config.PostfixVirtualAlias, false); err != nil {
+		return fmt.Errorf("postmap virtual failed: %w", err)
+	}
+
+	log.Info().Int("aliases", len(aliases)).Int("domains", len(domains)).Msg("Postfix virtual alias map synced")
+	return nil
+}
+
+// PreviewAddAlias returns the virtual alias map change adding an alias
+// would make, without writing it
+func (s *Syncer) PreviewAddAlias(source, destination string) (postfix.FileChange, error) {
+	aliases, domains, err := s.loadVirtualAliases()
+	if err != nil {
+		return postfix.FileChange{}, err
+	}
+	aliases[source] = append(aliases[source], destination)
+	return postfix.DiffFile(s.config.PostfixVirtualAlias, renderVirtualAlias(aliases, domains))
+}
+
+// loadVirtualAliases returns the active aliases by source address and the
+// active domains
+func (s *Syncer) loadVirtualAliases() (map[string][]string, []string, error) {
 	// Query all active aliases
 	rows, err := s.db.Query(`
 		SELECT a.source_email, a.destination_email
@@ -224,7 +359,7 @@ func (s *Syncer) syncVirtualAlias() error {
 		ORDER BY a.source_email
 	`)
 	if err != nil {
-		return fmt.Errorf("failed to query aliases: %w", err)
+		return nil, nil, fmt.Errorf("failed to query aliases: %w", err)
 	}
 	defer rows.Close()
 
@@ -237,11 +372,16 @@ func (s *Syncer) syncVirtualAlias() error {
 		}
 		aliases[source] = append(aliases[source], dest)
 	}
+	if s.aliasHook != nil {
+		if aliases, err = s.aliasHook(aliases); err != nil {
+			return nil, nil, fmt.Errorf("alias hook: %w", err)
+		}
+	}
 
 	// Also query domains for domain-level catchall capability
 	domainRows, err := s.db.Query("SELECT domain FROM mail_domains WHERE active = TRUE")
 	if err != nil {
-		return fmt.Errorf("failed to query domains: %w", err)
+		return nil, nil, fmt.Errorf("failed to query domains: %w", err)
 	}
 	defer domainRows.Close()
 
@@ -254,6 +394,12 @@ func (s *Syncer) syncVirtualAlias() error {
 		domains = append(domains, domain)
 	}
 
+	return aliases, domains, nil
+}
+
+// renderVirtualAlias returns the virtual alias map content, with aliases
+// sorted by source so the file only changes when they do
+func renderVirtualAlias(aliases map[string][]string, domains []string) string {
 	// Format: source destination[,destination...]
 	content := strings.Builder{}
 	content.WriteString("# Generated by PSFX Admin - DO NOT EDIT MANUALLY\n")
@@ -263,22 +409,18 @@ func (s *Syncer) syncVirtualAlias() error {
 		content.WriteString(fmt.Sprintf("@%s\t@%s\n", domain, domain))
 	}
 
-	content.WriteString("\n# Aliases\n")
-	for source, destinations := range aliases {
-		content.WriteString(fmt.Sprintf("%s\t%s\n", source, strings.Join(destinations, ", ")))
-	}
-
-	// Write and postmap
-	if err := atomicWriteFile(s.config.PostfixVirtualAlias, []byte(content.String()), 0644); err != nil {
-		return fmt.Errorf("failed to write virtual: %w", err)
+	sources := make([]string, 0, len(aliases))
+	for source := range aliases {
+		sources = append(sources, source)
 	}
+	sort.Strings(sources)
 
-	if err := runPostmap(s.config.PostfixVirtualAlias); err != nil {
-		return fmt.Errorf("postmap virtual failed: %w", err)
+	content.WriteString("\n# Aliases\n")
+	for _, source := range sources {
+		content.WriteString(fmt.Sprintf("%s\t%s\n", source, strings.Join(aliases[source], ", ")))
 	}
 
-	log.Info().Int("aliases", len(aliases)).Int("domains", len(domains)).Msg("Postfix virtual alias map synced")
-	return nil
+	return content.String()
 }
 
 // ReloadServices reloads Dovecot and Postfix to pick up configuration changes
@@ -345,15 +487,6 @@ func atomicWriteFile(path string, data []byte, perm os.FileMode) error {
 	return nil
 }
 
-func runPostmap(path string) error {
-	cmd := exec.Command("postmap", path)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("postmap failed: %s - %w", string(output), err)
-	}
-	return nil
-}
-
 func ensureMailDir(path string, uid, gid int) error {
 	// Create directory structure
 	if err := os.MkdirAll(path, 0700); err != nil {
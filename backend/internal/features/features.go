@@ -0,0 +1,172 @@
+// Package features keeps the feature flags that gate experimental
+// subsystems. A subsystem ships dark: its code is in the build but its
+// routes and background work stay off until an admin enables the flag for
+// the deployment, and it can be switched off again without a release.
+package features
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Flag keys
+const (
+	JMAP          = "jmap"
+	PolicyService = "policy_service"
+	Greylisting   = "greylisting"
+	MultiNode     = "multi_node"
+)
+
+// Flag describes an experimental subsystem behind a flag
+type Flag struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Known lists the flags an admin can set, in display order. Flags are off
+// until enabled.
+var Known = []Flag{
+	{JMAP, "JMAP", "JMAP access to mailboxes alongside IMAP, for webmail and clients that speak it"},
+	{PolicyService, "Policy service", "A Postfix policy delegation service (check_policy_service) answering from the panel's rules"},
+	{Greylisting, "Greylisting", "Temporarily reject mail from unknown sender and client pairs, through the policy service"},
+	{MultiNode, "Multi-node", "Manage several relay nodes from one panel and apply configuration to each"},
+}
+
+// ErrUnknown is returned when setting a flag that isn't in Known
+var ErrUnknown = errors.New("unknown feature flag")
+
+// State is a flag with its current setting
+type State struct {
+	Flag
+	Enabled   bool       `json:"enabled"`
+	UpdatedBy string     `json:"updatedBy,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// Store reads and sets flags. Settings are cached, so Enabled is cheap
+// enough to call on every request.
+type Store struct {
+	db *sql.DB
+
+	mu      sync.RWMutex
+	enabled map[string]bool
+}
+
+// NewStore creates a store and loads the current settings
+func NewStore(db *sql.DB) *Store {
+	s := &Store{db: db, enabled: make(map[string]bool)}
+	if err := s.Reload(); err != nil {
+		log.Error().Err(err).Msg("Failed to load feature flags; all are off")
+	}
+	return s
+}
+
+// Reload reads the settings from the database again
+func (s *Store) Reload() error {
+	rows, err := s.db.Query("SELECT key, enabled FROM feature_flags")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	enabled := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		var on bool
+		if err := rows.Scan(&key, &on); err != nil {
+			return err
+		}
+		enabled[key] = on
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.enabled = enabled
+	s.mu.Unlock()
+	return nil
+}
+
+// Enabled reports whether a flag is on
+func (s *Store) Enabled(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.enabled[key]
+}
+
+// EnabledKeys returns the keys of the flags that are on, in display order
+func (s *Store) EnabledKeys() []string {
+	keys := []string{}
+	for _, f := range Known {
+		if s.Enabled(f.Key) {
+			keys = append(keys, f.Key)
+		}
+	}
+	return keys
+}
+
+// List returns every known flag with its setting
+func (s *Store) List() ([]State, error) {
+	states := make(map[string]State)
+	rows, err := s.db.Query("SELECT key, enabled, COALESCE(updated_by, ''), updated_at FROM feature_flags")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var st State
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&st.Key, &st.Enabled, &st.UpdatedBy, &updatedAt); err != nil {
+			return nil, err
+		}
+		if updatedAt.Valid {
+			st.UpdatedAt = &updatedAt.Time
+		}
+		states[st.Key] = st
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := make([]State, 0, len(Known))
+	for _, f := range Known {
+		st := states[f.Key]
+		st.Flag = f
+		list = append(list, st)
+	}
+	return list, nil
+}
+
+// Set turns a flag on or off, recording who did it
+func (s *Store) Set(key string, enabled bool, by string) error {
+	if !isKnown(key) {
+		return ErrUnknown
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO feature_flags (key, enabled, updated_by, updated_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(key) DO UPDATE SET enabled = excluded.enabled, updated_by = excluded.updated_by, updated_at = CURRENT_TIMESTAMP
+	`, key, enabled, by)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.enabled[key] = enabled
+	s.mu.Unlock()
+	return nil
+}
+
+func isKnown(key string) bool {
+	for _, f := range Known {
+		if f.Key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,379 @@
+// Package flowstats aggregates the mail log into source → relay →
+// destination flows in hourly buckets, for topology and Sankey diagrams.
+package flowstats
+
+import (
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/postfixrelay/postfixrelay/internal/queuetrack"
+	"github.com/rs/zerolog/log"
+)
+
+// Sources a flow report can group by
+const (
+	SourceClient = "client" // the host that handed the message to Postfix
+	SourceSender = "sender" // the sender's domain
+)
+
+// LocalSource is the source of mail submitted on this host (pickup)
+const LocalSource = queuetrack.LocalSource
+
+// messageTTL is how long a queue ID's source is remembered without a
+// delivery before it is forgotten
+const messageTTL = 24 * time.Hour
+
+type bucketKey struct {
+	hour        time.Time
+	client      string
+	sender      string
+	relay       string
+	destination string
+	status      string
+}
+
+type bucket struct {
+	count int
+	delay float64
+}
+
+// Collector consumes mail log entries, follows each queue ID from the
+// client that submitted it to its deliveries, and flushes flow counts to
+// the database every minute
+type Collector struct {
+	db *sql.DB
+
+	mu       sync.Mutex
+	messages *queuetrack.Tracker[struct{}]
+	pending  map[bucketKey]*bucket
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewCollector creates a collector
+func NewCollector(db *sql.DB) *Collector {
+	return &Collector{
+		db:       db,
+		messages: queuetrack.New[struct{}](messageTTL),
+		pending:  make(map[bucketKey]*bucket),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start begins flushing counts to the database
+func (c *Collector) Start() {
+	c.done = make(chan struct{})
+	go c.loop()
+}
+
+// Stop flushes pending counts and stops the collector
+func (c *Collector) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+		if c.done != nil {
+			<-c.done
+		}
+	})
+}
+
+func (c *Collector) loop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+
+	for {
+		select {
+		case <-c.stopCh:
+			c.flush()
+			return
+		case now := <-ticker.C:
+			c.flush()
+			if now.Sub(lastPrune) >= time.Hour {
+				c.prune(now)
+				lastPrune = now
+			}
+		}
+	}
+}
+
+// Consume follows one log entry. Entries without a queue ID are ignored.
+func (c *Collector) Consume(e logs.Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msg := c.messages.Track(e)
+	if msg != nil && e.Status != "" && e.MailTo != "" {
+		c.record(msg, e)
+	}
+}
+
+// record counts one delivery attempt of msg
+func (c *Collector) record(msg *queuetrack.Message[struct{}], e logs.Entry) {
+	client := msg.Client
+	if client == "" {
+		client = "unknown"
+	}
+	key := bucketKey{
+		hour:        msg.Seen.UTC().Truncate(time.Hour),
+		client:      client,
+		sender:      queuetrack.DomainOf(msg.Sender),
+		relay:       RelayHost(e.Relay),
+		destination: queuetrack.DomainOf(e.MailTo),
+		status:      e.Status,
+	}
+	b := c.pending[key]
+	if b == nil {
+		b = &bucket{}
+		c.pending[key] = b
+	}
+	b.count++
+	b.delay += e.Delay
+}
+
+// RelayHost returns the host of a relay= value such as
+// mx.example.com[192.0.2.1]:25, or "none" when there was none
+func RelayHost(relay string) string {
+	if relay == "" {
+		return "none"
+	}
+	if i := strings.IndexByte(relay, '['); i > 0 {
+		return strings.ToLower(relay[:i])
+	}
+	if i := strings.IndexByte(relay, ','); i > 0 {
+		relay = relay[:i]
+	}
+	return strings.ToLower(relay)
+}
+
+// flush writes pending buckets to the database and forgets messages that
+// have gone quiet
+func (c *Collector) flush() {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[bucketKey]*bucket)
+	c.messages.Expire(time.Now())
+	c.mu.Unlock()
+
+	for key, b := range pending {
+		_, err := c.db.Exec(`
+			INSERT INTO flow_stats (bucket, client, sender_domain, relay, destination, status, count, delay_total)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(bucket, client, sender_domain, relay, destination, status) DO UPDATE SET
+				count = count + excluded.count,
+				delay_total = delay_total + excluded.delay_total
+		`, key.hour.Format(time.RFC3339), key.client, key.sender, key.relay, key.destination, key.status, b.count, b.delay)
+		if err != nil {
+			log.Error().Err(err).Str("relay", key.relay).Msg("Failed to store flow statistics")
+		}
+	}
+}
+
+// prune removes buckets older than flowstats_retention_days
+func (c *Collector) prune(now time.Time) {
+	days := 30
+	var value string
+	if err := c.db.QueryRow(`SELECT value FROM settings WHERE key = 'flowstats_retention_days'`).Scan(&value); err == nil {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			days = n
+		}
+	}
+	cutoff := now.UTC().AddDate(0, 0, -days).Format(time.RFC3339)
+	c.db.Exec(`DELETE FROM flow_stats WHERE bucket < ?`, cutoff)
+}
+
+// Layers of the flow graph, left to right
+const (
+	LayerSource      = "source"
+	LayerRelay       = "relay"
+	LayerDestination = "destination"
+)
+
+// OtherNode is the label of the node the smaller nodes of a layer are
+// folded into
+const OtherNode = "(other)"
+
+// Node is a source, relay or destination in the flow graph
+type Node struct {
+	ID       string `json:"id"` // layer:label, unique across layers
+	Label    string `json:"label"`
+	Layer    string `json:"layer"`
+	Messages int    `json:"messages"`
+}
+
+// Edge is the mail that went from one node to the next. Source and Target
+// are node IDs, so the edges can be handed to a Sankey layout as links.
+type Edge struct {
+	Source      string  `json:"source"`
+	Target      string  `json:"target"`
+	Messages    int     `json:"messages"` // delivery attempts, one per recipient
+	Sent        int     `json:"sent"`
+	Deferred    int     `json:"deferred"`
+	Bounced     int     `json:"bounced"`
+	AvgDelay    float64 `json:"avgDelaySeconds"`
+	FailureRate float64 `json:"failureRate"` // deferred and bounced share, 0 to 1
+}
+
+// Graph is the mail flow over a period
+type Graph struct {
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+	Source string    `json:"source"` // client or sender
+	Nodes  []Node    `json:"nodes"`
+	Edges  []Edge    `json:"edges"`
+	Total  int       `json:"total"`
+}
+
+type edgeKey struct{ source, target string }
+
+type edgeTotals struct {
+	count, sent, deferred, bounced int
+	delay                          float64
+}
+
+// Flow builds the flow graph for buckets starting in [from, to). Sources
+// are clients or sender domains, by source. Each layer keeps its limit
+// busiest nodes and folds the rest into OtherNode.
+func Flow(db *sql.DB, from, to time.Time, source string, limit int) (*Graph, error) {
+	sourceCol := "client"
+	if source == SourceSender {
+		sourceCol = "sender_domain"
+	}
+	rows, err := db.Query(`
+		SELECT `+sourceCol+`, relay, destination, status, SUM(count), SUM(delay_total)
+		FROM flow_stats
+		WHERE bucket >= ? AND bucket < ?
+		GROUP BY `+sourceCol+`, relay, destination, status
+	`, from.UTC().Truncate(time.Hour).Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type flow struct {
+		source, relay, destination, status string
+		count                              int
+		delay                              float64
+	}
+	var flows []flow
+	totals := map[string]map[string]int{LayerSource: {}, LayerRelay: {}, LayerDestination: {}}
+	for rows.Next() {
+		var f flow
+		if err := rows.Scan(&f.source, &f.relay, &f.destination, &f.status, &f.count, &f.delay); err != nil {
+			continue
+		}
+		flows = append(flows, f)
+		totals[LayerSource][f.source] += f.count
+		totals[LayerRelay][f.relay] += f.count
+		totals[LayerDestination][f.destination] += f.count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Keep the busiest nodes of each layer
+	kept := map[string]map[string]bool{}
+	for layer, counts := range totals {
+		labels := make([]string, 0, len(counts))
+		for label := range counts {
+			labels = append(labels, label)
+		}
+		sort.Slice(labels, func(i, j int) bool {
+			if counts[labels[i]] != counts[labels[j]] {
+				return counts[labels[i]] > counts[labels[j]]
+			}
+			return labels[i] < labels[j]
+		})
+		kept[layer] = map[string]bool{}
+		for i, label := range labels {
+			if limit <= 0 || i < limit {
+				kept[layer][label] = true
+			}
+		}
+	}
+	nodeID := func(layer, label string) string {
+		if !kept[layer][label] {
+			label = OtherNode
+		}
+		return layer + ":" + label
+	}
+
+	graph := &Graph{From: from, To: to, Source: source, Nodes: []Node{}, Edges: []Edge{}}
+	nodes := map[string]*Node{}
+	addNode := func(layer, id string, count int) {
+		n := nodes[id]
+		if n == nil {
+			n = &Node{ID: id, Label: strings.TrimPrefix(id, layer+":"), Layer: layer}
+			nodes[id] = n
+		}
+		n.Messages += count
+	}
+	edges := map[edgeKey]*edgeTotals{}
+	addEdge := func(source, target string, f flow) {
+		t := edges[edgeKey{source, target}]
+		if t == nil {
+			t = &edgeTotals{}
+			edges[edgeKey{source, target}] = t
+		}
+		t.count += f.count
+		t.delay += f.delay
+		switch f.status {
+		case "sent":
+			t.sent += f.count
+		case "deferred":
+			t.deferred += f.count
+		case "bounced":
+			t.bounced += f.count
+		}
+	}
+	for _, f := range flows {
+		src := nodeID(LayerSource, f.source)
+		relay := nodeID(LayerRelay, f.relay)
+		dest := nodeID(LayerDestination, f.destination)
+		addNode(LayerSource, src, f.count)
+		addNode(LayerRelay, relay, f.count)
+		addNode(LayerDestination, dest, f.count)
+		addEdge(src, relay, f)
+		addEdge(relay, dest, f)
+		graph.Total += f.count
+	}
+
+	layerOrder := map[string]int{LayerSource: 0, LayerRelay: 1, LayerDestination: 2}
+	for _, n := range nodes {
+		graph.Nodes = append(graph.Nodes, *n)
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		a, b := graph.Nodes[i], graph.Nodes[j]
+		if a.Layer != b.Layer {
+			return layerOrder[a.Layer] < layerOrder[b.Layer]
+		}
+		if a.Messages != b.Messages {
+			return a.Messages > b.Messages
+		}
+		return a.ID < b.ID
+	})
+	for key, t := range edges {
+		e := Edge{Source: key.source, Target: key.target, Messages: t.count, Sent: t.sent, Deferred: t.deferred, Bounced: t.bounced}
+		if t.count > 0 {
+			e.AvgDelay = t.delay / float64(t.count)
+			e.FailureRate = float64(t.deferred+t.bounced) / float64(t.count)
+		}
+		graph.Edges = append(graph.Edges, e)
+	}
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		a, b := graph.Edges[i], graph.Edges[j]
+		if a.Messages != b.Messages {
+			return a.Messages > b.Messages
+		}
+		return a.Source+a.Target < b.Source+b.Target
+	})
+	return graph, nil
+}
@@ -0,0 +1,148 @@
+// Package hooks lets site-specific logic run at fixed extension points
+// without forking: before a message is sent, when aliases are resolved
+// into the virtual map, after an alert fires and around a config apply.
+//
+// A hook is either a plugin compiled into the binary (see Register) or an
+// external hook configured by an admin: an executable in the hooks
+// directory, or a webhook. Both speak the same JSON contract. The hook
+// receives a Request and answers a Response:
+//
+//	{"hook": "send.pre", "payload": {...}}
+//	{"action": "continue", "payload": {...}}   or   {"action": "reject", "reason": "..."}
+//
+// An empty answer means continue unchanged. Where the point allows it, a
+// returned payload replaces the one the next hook and the caller see.
+// Scripts read the request on stdin and write the response to stdout;
+// a non-zero exit is a failure. Webhooks get it as a POST and answer in
+// the body, signed in X-Hook-Signature when the hook has a secret.
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Extension points
+const (
+	// SendPre runs before a message is sent from webmail or the send API.
+	// It may rewrite the message or reject it.
+	SendPre = "send.pre"
+	// AliasResolve runs when the virtual alias map is built. It may
+	// rewrite the aliases or reject, which fails the sync.
+	AliasResolve = "alias.resolve"
+	// AlertPost runs after an alert fires, before its notifications. It
+	// may rewrite the alert or reject, which drops the notifications.
+	AlertPost = "alert.post"
+	// ConfigApplyPre runs before staged Postfix configuration is written.
+	// It may reject the apply.
+	ConfigApplyPre = "config.apply.pre"
+	// ConfigApplyPost runs after a successful apply. Its answer is ignored.
+	ConfigApplyPost = "config.apply.post"
+)
+
+// Point describes an extension point
+type Point struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Mutable     bool   `json:"mutable"` // hooks may replace the payload
+}
+
+// Points lists the extension points in the order they're documented
+var Points = []Point{
+	{SendPre, "Before a webmail or send API message is sent: rewrite or reject it", true},
+	{AliasResolve, "When the Postfix virtual alias map is built: rewrite the aliases", true},
+	{AlertPost, "After an alert fires, before notifications: rewrite it or drop the notifications", true},
+	{ConfigApplyPre, "Before staged Postfix configuration is written: veto the apply", false},
+	{ConfigApplyPost, "After configuration is applied: notification only", false},
+}
+
+// Request is what a hook receives
+type Request struct {
+	Hook    string          `json:"hook"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Response is what a hook answers
+type Response struct {
+	Action  string          `json:"action,omitempty"` // "continue" (default) or "reject"
+	Reason  string          `json:"reason,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Plugin is a hook compiled into the binary. Handle gets the payload as
+// JSON and may return nil to continue unchanged.
+type Plugin interface {
+	Name() string
+	Points() []string
+	Handle(ctx context.Context, point string, payload json.RawMessage) (*Response, error)
+}
+
+// RejectedError is returned when a hook rejects
+type RejectedError struct {
+	Hook   string
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	if e.Reason == "" {
+		return "rejected by hook " + e.Hook
+	}
+	return fmt.Sprintf("rejected by hook %s: %s", e.Hook, e.Reason)
+}
+
+// IsRejected reports whether err is a hook's rejection, and its reason
+func IsRejected(err error) (string, bool) {
+	var rejected *RejectedError
+	if errors.As(err, &rejected) {
+		return rejected.Error(), true
+	}
+	return "", false
+}
+
+var (
+	pluginsMu sync.RWMutex
+	plugins   []Plugin
+)
+
+// Register adds a compiled-in plugin, typically from an init function in
+// a file the site adds to the build. Plugins run before external hooks,
+// in the order registered.
+func Register(p Plugin) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+	plugins = append(plugins, p)
+}
+
+// Plugins returns the registered plugins
+func Plugins() []Plugin {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+	return append([]Plugin(nil), plugins...)
+}
+
+// ValidPoint reports whether name is an extension point
+func ValidPoint(name string) bool {
+	_, ok := point(name)
+	return ok
+}
+
+func point(name string) (Point, bool) {
+	for _, p := range Points {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Point{}, false
+}
+
+func handles(p Plugin, name string) bool {
+	for _, pt := range p.Points() {
+		if pt == name {
+			return true
+		}
+	}
+	return false
+}
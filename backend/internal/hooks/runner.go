@@ -0,0 +1,264 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxResponse caps what is read from a hook's answer
+const maxResponse = 4 << 20
+
+// Runner passes payloads through the plugins and external hooks of an
+// extension point
+type Runner struct {
+	db     *sql.DB
+	dir    string
+	client *http.Client
+}
+
+// NewRunner creates a runner. Script hooks are looked up in dir.
+func NewRunner(db *sql.DB, dir string) *Runner {
+	return &Runner{db: db, dir: dir, client: &http.Client{}}
+}
+
+// Dir is the directory script hooks live in
+func (r *Runner) Dir() string {
+	return r.dir
+}
+
+// Run passes payload through the hooks of a point: compiled-in plugins
+// first, then the enabled external hooks by position. When out is not nil
+// and a hook replaced the payload, the final payload is decoded into it.
+// A rejection is returned as a *RejectedError. A failing hook fails the
+// run unless it is set to fail open.
+func (r *Runner) Run(ctx context.Context, name string, payload, out interface{}) error {
+	pt, ok := point(name)
+	if !ok {
+		return fmt.Errorf("unknown hook point %q", name)
+	}
+	external, err := Enabled(r.db, name)
+	if err != nil {
+		return fmt.Errorf("failed to load hooks: %w", err)
+	}
+	var local []Plugin
+	for _, p := range Plugins() {
+		if handles(p, name) {
+			local = append(local, p)
+		}
+	}
+	if len(local) == 0 && len(external) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	changed := false
+	next := func(hook string, resp *Response) error {
+		if resp == nil {
+			return nil
+		}
+		switch resp.Action {
+		case "", "continue":
+		case "reject":
+			return &RejectedError{Hook: hook, Reason: resp.Reason}
+		default:
+			return fmt.Errorf("unknown action %q", resp.Action)
+		}
+		if pt.Mutable && len(resp.Payload) > 0 && string(resp.Payload) != "null" {
+			data, changed = resp.Payload, true
+		}
+		return nil
+	}
+
+	for _, p := range local {
+		resp, err := p.Handle(ctx, name, data)
+		if err == nil {
+			err = next(p.Name(), resp)
+		}
+		if err != nil {
+			if _, rejected := IsRejected(err); rejected {
+				return err
+			}
+			return fmt.Errorf("plugin %s: %w", p.Name(), err)
+		}
+	}
+	for _, h := range external {
+		resp, err := r.Call(ctx, h, data)
+		if err == nil {
+			err = next(h.Name, resp)
+		}
+		if err == nil {
+			continue
+		}
+		if _, rejected := IsRejected(err); rejected {
+			log.Info().Str("hook", h.Name).Str("point", name).Err(err).Msg("Hook rejected")
+			return err
+		}
+		if h.FailOpen {
+			log.Warn().Err(err).Str("hook", h.Name).Str("point", name).Msg("Hook failed; skipping it")
+			continue
+		}
+		return fmt.Errorf("hook %s: %w", h.Name, err)
+	}
+
+	if out != nil && changed {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("hooks returned an invalid %s payload: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ResolveAliases runs the alias.resolve hooks on the aliases, by source
+// address. Its signature fits dovecot.Syncer.SetAliasHook.
+func (r *Runner) ResolveAliases(aliases map[string][]string) (map[string][]string, error) {
+	type payload struct {
+		Aliases map[string][]string `json:"aliases"`
+	}
+	var out payload
+	if err := r.Run(context.Background(), AliasResolve, payload{aliases}, &out); err != nil {
+		return nil, err
+	}
+	if out.Aliases == nil {
+		return aliases, nil
+	}
+	return out.Aliases, nil
+}
+
+// Call runs one external hook with a payload and returns its answer, or
+// nil when it answered nothing
+func (r *Runner) Call(ctx context.Context, h Hook, payload json.RawMessage) (*Response, error) {
+	timeout := time.Duration(h.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := json.Marshal(Request{Hook: h.Point, Payload: payload})
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	switch h.Kind {
+	case KindScript:
+		body, err = r.runScript(ctx, h, req)
+	case KindWebhook:
+		body, err = r.postWebhook(ctx, h, req)
+	default:
+		err = fmt.Errorf("unknown hook kind %q", h.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+	var resp Response
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return &resp, nil
+}
+
+// ScriptPath is where a script hook's executable is, or an error when the
+// name would point outside the hooks directory
+func (r *Runner) ScriptPath(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", errors.New("must be a file name in the hooks directory")
+	}
+	return filepath.Join(r.dir, name), nil
+}
+
+// runScript runs a script hook with the request on stdin and returns
+// what it wrote to stdout
+func (r *Runner) runScript(ctx context.Context, h Hook, req []byte) ([]byte, error) {
+	path, err := r.ScriptPath(h.Target)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, h.Point)
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = &limitedWriter{w: &stdout, n: maxResponse}
+	cmd.Stderr = &limitedWriter{w: &stderr, n: 4096}
+	cmd.Env = append(os.Environ(), "HOOK_POINT="+h.Point, "HOOK_NAME="+h.Name)
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("timed out after %ds", h.TimeoutSeconds)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// postWebhook posts the request to a webhook hook and returns the body of
+// its answer. With a secret, the body is signed in X-Hook-Signature:
+// sha256=<hex HMAC-SHA256>.
+func (r *Runner) postWebhook(ctx context.Context, h Hook, req []byte) ([]byte, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, h.Target, bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Hook-Point", h.Point)
+	if h.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.Secret))
+		mac.Write(req)
+		httpReq.Header.Set("X-Hook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := r.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return body, nil
+}
+
+// limitedWriter keeps the first n bytes written and discards the rest
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.n > 0 {
+		keep := p
+		if len(keep) > l.n {
+			keep = keep[:l.n]
+		}
+		l.n -= len(keep)
+		l.w.Write(keep)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,131 @@
+package hooks
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Kinds of external hook
+const (
+	KindScript  = "script"  // an executable in the hooks directory
+	KindWebhook = "webhook" // an HTTP(S) URL
+)
+
+// ErrNotFound is returned when a hook does not exist
+var ErrNotFound = errors.New("hook not found")
+
+// Hook is an external hook configured by an admin
+type Hook struct {
+	ID             int64     `json:"id"`
+	Name           string    `json:"name"`
+	Point          string    `json:"point"`
+	Kind           string    `json:"kind"`
+	Target         string    `json:"target"` // script file name, or webhook URL
+	Secret         string    `json:"-"`      // signs webhook requests
+	HasSecret      bool      `json:"hasSecret"`
+	TimeoutSeconds int       `json:"timeoutSeconds"`
+	FailOpen       bool      `json:"failOpen"` // skip the hook when it fails, instead of failing the operation
+	Enabled        bool      `json:"enabled"`
+	Position       int       `json:"position"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+const selectColumns = `
+	SELECT id, name, point, kind, target, COALESCE(secret, ''), timeout_seconds, fail_open, enabled, position,
+		created_at, updated_at
+	FROM hooks`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (*Hook, error) {
+	var h Hook
+	err := row.Scan(&h.ID, &h.Name, &h.Point, &h.Kind, &h.Target, &h.Secret, &h.TimeoutSeconds, &h.FailOpen,
+		&h.Enabled, &h.Position, &h.CreatedAt, &h.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	h.HasSecret = h.Secret != ""
+	return &h, nil
+}
+
+func query(db *sql.DB, where string, args ...interface{}) ([]Hook, error) {
+	rows, err := db.Query(selectColumns+" "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []Hook{}
+	for rows.Next() {
+		h, err := scan(rows)
+		if err != nil {
+			continue
+		}
+		hooks = append(hooks, *h)
+	}
+	return hooks, rows.Err()
+}
+
+// List returns every external hook, by point and position
+func List(db *sql.DB) ([]Hook, error) {
+	return query(db, `ORDER BY point, position, id`)
+}
+
+// Enabled returns the enabled hooks of a point, in the order they run
+func Enabled(db *sql.DB, point string) ([]Hook, error) {
+	return query(db, `WHERE point = ? AND enabled = 1 ORDER BY position, id`, point)
+}
+
+// Get returns a hook by ID
+func Get(db *sql.DB, id int64) (*Hook, error) {
+	h, err := scan(db.QueryRow(selectColumns+` WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return h, err
+}
+
+// Create stores a new hook, setting its ID
+func Create(db *sql.DB, h *Hook) error {
+	result, err := db.Exec(`
+		INSERT INTO hooks (name, point, kind, target, secret, timeout_seconds, fail_open, enabled, position)
+		VALUES (?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?)
+	`, h.Name, h.Point, h.Kind, h.Target, h.Secret, h.TimeoutSeconds, h.FailOpen, h.Enabled, h.Position)
+	if err != nil {
+		return err
+	}
+	h.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// Update saves a hook
+func Update(db *sql.DB, h *Hook) error {
+	result, err := db.Exec(`
+		UPDATE hooks SET name = ?, point = ?, kind = ?, target = ?, secret = NULLIF(?, ''), timeout_seconds = ?,
+			fail_open = ?, enabled = ?, position = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, h.Name, h.Point, h.Kind, h.Target, h.Secret, h.TimeoutSeconds, h.FailOpen, h.Enabled, h.Position, h.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete removes a hook
+func Delete(db *sql.DB, id int64) error {
+	result, err := db.Exec(`DELETE FROM hooks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
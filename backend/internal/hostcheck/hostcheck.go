@@ -0,0 +1,268 @@
+// Package hostcheck periodically checks the mail host's clock against an
+// NTP server and the latency and failures of its DNS resolvers. Bad clocks
+// break DKIM signatures and TLS; failing resolvers stall delivery.
+package hostcheck
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// checkInterval is how often the checks run
+const checkInterval = 5 * time.Minute
+
+// lookupTimeout bounds one NTP query or DNS lookup
+const lookupTimeout = 5 * time.Second
+
+// Result is the outcome of one check. For the clock Value is the offset
+// from the NTP server in seconds (positive when the host is ahead); for a
+// resolver it is the slowest lookup in milliseconds.
+type Result struct {
+	Name      string    `json:"name"`
+	OK        bool      `json:"ok"`
+	Value     float64   `json:"value"`
+	Detail    string    `json:"detail,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// ntpEpochOffset is the seconds between 1900 (NTP) and 1970 (Unix)
+const ntpEpochOffset = 2208988800
+
+func ntpTime(b []byte) time.Time {
+	secs := binary.BigEndian.Uint32(b[0:4])
+	frac := binary.BigEndian.Uint32(b[4:8])
+	nanos := (int64(frac) * 1e9) >> 32
+	return time.Unix(int64(secs)-ntpEpochOffset, nanos)
+}
+
+// ClockOffset asks an NTP server for the time and returns how far the
+// local clock is ahead of it
+func ClockOffset(server string) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", server, lookupTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(lookupTimeout))
+
+	req := make([]byte, 48)
+	req[0] = 0x23 // leap indicator 0, version 4, client mode
+	sent := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	received := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	if n < 48 || resp[0]&0x7 != 4 {
+		return 0, errors.New("invalid NTP response")
+	}
+	if resp[1] == 0 {
+		return 0, errors.New("NTP server is unsynchronized")
+	}
+
+	// Offset of the server's clock from ours, from the server's receive and
+	// transmit times, as in RFC 5905
+	serverRecv, serverSent := ntpTime(resp[32:40]), ntpTime(resp[40:48])
+	serverAhead := (serverRecv.Sub(sent) + serverSent.Sub(received)) / 2
+	return -serverAhead, nil
+}
+
+// Resolvers returns the nameservers listed in resolv.conf
+func Resolvers(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+// CheckResolver looks up each name through one nameserver and returns the
+// slowest lookup, or the first failure
+func CheckResolver(server string, names []string) (time.Duration, error) {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, net.JoinHostPort(server, "53"))
+		},
+	}
+	var slowest time.Duration
+	for _, name := range names {
+		ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+		start := time.Now()
+		_, err := resolver.LookupHost(ctx, name)
+		took := time.Since(start)
+		cancel()
+		if err != nil {
+			return took, fmt.Errorf("%s: %w", name, err)
+		}
+		if took > slowest {
+			slowest = took
+		}
+	}
+	return slowest, nil
+}
+
+// Checker runs the clock and DNS checks and stores each result in
+// host_checks
+type Checker struct {
+	db         *sql.DB
+	resolvConf string
+
+	mu      sync.Mutex
+	results []Result
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewChecker creates a host checker reading nameservers from resolvConf
+func NewChecker(db *sql.DB, resolvConf string) *Checker {
+	return &Checker{
+		db:         db,
+		resolvConf: resolvConf,
+		stopCh:     make(chan struct{}),
+	}
+}
+
+// Start begins checking
+func (c *Checker) Start() {
+	c.done = make(chan struct{})
+	go c.loop()
+}
+
+// Stop stops checking
+func (c *Checker) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+		if c.done != nil {
+			<-c.done
+		}
+	})
+}
+
+func (c *Checker) loop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	c.Run(time.Now())
+
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case now := <-ticker.C:
+			c.Run(now)
+		}
+	}
+}
+
+func (c *Checker) setting(key, fallback string) string {
+	var value string
+	if err := c.db.QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value); err != nil {
+		return fallback
+	}
+	return value
+}
+
+// Run performs every check once and stores the results
+func (c *Checker) Run(now time.Time) []Result {
+	now = now.UTC().Truncate(time.Second)
+	results := []Result{}
+
+	if server := strings.TrimSpace(c.setting("ntp_server", "pool.ntp.org")); server != "" {
+		res := Result{Name: "clock", CheckedAt: now}
+		offset, err := ClockOffset(server)
+		if err != nil {
+			res.Detail = server + ": " + err.Error()
+		} else {
+			res.OK = true
+			res.Value = math.Round(offset.Seconds()*1000) / 1000
+			res.Detail = server
+		}
+		results = append(results, res)
+	}
+
+	var names []string
+	for _, name := range strings.Split(c.setting("dns_check_names", "example.com"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		for _, server := range Resolvers(c.resolvConf) {
+			res := Result{Name: "dns:" + server, CheckedAt: now}
+			took, err := CheckResolver(server, names)
+			res.Value = float64(took.Milliseconds())
+			if err != nil {
+				res.Detail = err.Error()
+			} else {
+				res.OK = true
+			}
+			results = append(results, res)
+		}
+	}
+
+	c.store(results, now)
+	c.mu.Lock()
+	c.results = results
+	c.mu.Unlock()
+	return results
+}
+
+func (c *Checker) store(results []Result, now time.Time) {
+	at := now.Format(time.RFC3339)
+	for _, res := range results {
+		_, err := c.db.Exec(`
+			INSERT OR REPLACE INTO host_checks (name, ok, value, detail, checked_at)
+			VALUES (?, ?, ?, ?, ?)
+		`, res.Name, res.OK, res.Value, res.Detail, at)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to store host check")
+			return
+		}
+		if !res.OK {
+			log.Warn().Str("check", res.Name).Str("detail", res.Detail).Msg("Host check failed")
+		}
+	}
+	// Checks that no longer run, such as a removed nameserver, are dropped
+	c.db.Exec(`DELETE FROM host_checks WHERE checked_at < ?`, at)
+}
+
+// Results returns the most recent results, or nil before the first run
+func (c *Checker) Results() []Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.results
+}
@@ -0,0 +1,338 @@
+// Package hoststats samples the mail host's CPU, memory, load average and
+// the disk and inode usage of the volumes mail lives on every minute.
+package hoststats
+
+import (
+	"bufio"
+	"database/sql"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Volume is a directory whose filesystem is watched
+type Volume struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// Disk is the usage of one volume's filesystem
+type Disk struct {
+	Name              string  `json:"name"`
+	Path              string  `json:"path"`
+	BytesTotal        uint64  `json:"bytesTotal"`
+	BytesFree         uint64  `json:"bytesFree"`
+	UsedPercent       float64 `json:"usedPercent"`
+	InodesTotal       uint64  `json:"inodesTotal"`
+	InodesFree        uint64  `json:"inodesFree"`
+	InodesUsedPercent float64 `json:"inodesUsedPercent"`
+}
+
+// Sample is the host's resource usage at one point in time
+type Sample struct {
+	Time              time.Time `json:"time"`
+	CPUs              int       `json:"cpus"`
+	CPUPercent        float64   `json:"cpuPercent"`
+	MemoryTotal       uint64    `json:"memoryTotal"`
+	MemoryAvailable   uint64    `json:"memoryAvailable"`
+	MemoryUsedPercent float64   `json:"memoryUsedPercent"`
+	Load1             float64   `json:"load1"`
+	Load5             float64   `json:"load5"`
+	Load15            float64   `json:"load15"`
+	Disks             []Disk    `json:"disks"`
+}
+
+func percent(used, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(int(float64(used)/float64(total)*1000+0.5)) / 10
+}
+
+// cpuTimes returns the idle and total jiffies from /proc/stat
+func cpuTimes() (idle, total uint64, ok bool) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return 0, 0, false
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, false
+	}
+	for i, field := range fields[1:] {
+		n, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		total += n
+		// idle and iowait
+		if i == 3 || i == 4 {
+			idle += n
+		}
+	}
+	return idle, total, true
+}
+
+// memory returns MemTotal and MemAvailable from /proc/meminfo in bytes
+func memory() (total, available uint64) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		n, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total = n * 1024
+		case "MemAvailable:":
+			available = n * 1024
+		}
+	}
+	return total, available
+}
+
+// loadAverage returns the 1, 5 and 15 minute load averages
+func loadAverage() (load1, load5, load15 float64) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, 0, 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return 0, 0, 0
+	}
+	load1, _ = strconv.ParseFloat(fields[0], 64)
+	load5, _ = strconv.ParseFloat(fields[1], 64)
+	load15, _ = strconv.ParseFloat(fields[2], 64)
+	return load1, load5, load15
+}
+
+// diskUsage returns the usage of the filesystem holding a volume
+func diskUsage(v Volume) (Disk, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(v.Path, &st); err != nil {
+		return Disk{}, err
+	}
+	bsize := uint64(st.Bsize)
+	d := Disk{
+		Name:        v.Name,
+		Path:        v.Path,
+		BytesTotal:  uint64(st.Blocks) * bsize,
+		BytesFree:   uint64(st.Bavail) * bsize,
+		InodesTotal: uint64(st.Files),
+		InodesFree:  uint64(st.Ffree),
+	}
+	// Space reserved for root counts as used, as df reports it
+	usable := d.BytesTotal - (uint64(st.Bfree)-uint64(st.Bavail))*bsize
+	d.UsedPercent = percent(usable-d.BytesFree, usable)
+	d.InodesUsedPercent = percent(d.InodesTotal-d.InodesFree, d.InodesTotal)
+	return d, nil
+}
+
+// Sampler records host samples every minute and keeps the latest in memory
+type Sampler struct {
+	db      *sql.DB
+	volumes []Volume
+
+	mu       sync.Mutex
+	latest   *Sample
+	lastIdle uint64
+	lastCPU  uint64
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewSampler creates a host sampler watching the given volumes
+func NewSampler(db *sql.DB, volumes []Volume) *Sampler {
+	return &Sampler{
+		db:      db,
+		volumes: volumes,
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// Start begins sampling
+func (s *Sampler) Start() {
+	s.done = make(chan struct{})
+	s.lastIdle, s.lastCPU, _ = cpuTimes()
+	go s.loop()
+}
+
+// Stop stops sampling
+func (s *Sampler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+		if s.done != nil {
+			<-s.done
+		}
+	})
+}
+
+func (s *Sampler) loop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case now := <-ticker.C:
+			s.store(s.Collect(now))
+			if now.Sub(lastPrune) >= time.Hour {
+				s.prune(now)
+				lastPrune = now
+			}
+		}
+	}
+}
+
+// Collect takes a sample now. CPU usage is measured since the previous
+// sample.
+func (s *Sampler) Collect(now time.Time) *Sample {
+	sm := &Sample{Time: now.UTC().Truncate(time.Minute), CPUs: runtime.NumCPU(), Disks: []Disk{}}
+
+	if idle, total, ok := cpuTimes(); ok {
+		s.mu.Lock()
+		if total > s.lastCPU {
+			busy := (total - s.lastCPU) - (idle - s.lastIdle)
+			sm.CPUPercent = percent(busy, total-s.lastCPU)
+		}
+		s.lastIdle, s.lastCPU = idle, total
+		s.mu.Unlock()
+	}
+
+	sm.MemoryTotal, sm.MemoryAvailable = memory()
+	sm.MemoryUsedPercent = percent(sm.MemoryTotal-sm.MemoryAvailable, sm.MemoryTotal)
+	sm.Load1, sm.Load5, sm.Load15 = loadAverage()
+
+	for _, v := range s.volumes {
+		d, err := diskUsage(v)
+		if err != nil {
+			// The queue or mail store may live in another container
+			log.Debug().Err(err).Str("path", v.Path).Msg("Failed to read volume usage")
+			continue
+		}
+		sm.Disks = append(sm.Disks, d)
+	}
+
+	s.mu.Lock()
+	s.latest = sm
+	s.mu.Unlock()
+	return sm
+}
+
+// Latest returns the most recent sample, or nil before the first one
+func (s *Sampler) Latest() *Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latest
+}
+
+func (s *Sampler) store(sm *Sample) {
+	at := sm.Time.Format(time.RFC3339)
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO host_samples (sampled_at, cpus, cpu_percent, memory_total, memory_available, load1, load5, load15)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, at, sm.CPUs, sm.CPUPercent, sm.MemoryTotal, sm.MemoryAvailable, sm.Load1, sm.Load5, sm.Load15)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to store host sample")
+		return
+	}
+	for _, d := range sm.Disks {
+		s.db.Exec(`
+			INSERT OR REPLACE INTO host_disk_samples (sampled_at, volume, path, bytes_total, bytes_free, used_percent,
+				inodes_total, inodes_free, inodes_used_percent)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, at, d.Name, d.Path, d.BytesTotal, d.BytesFree, d.UsedPercent, d.InodesTotal, d.InodesFree, d.InodesUsedPercent)
+	}
+}
+
+// prune removes samples older than hoststats_retention_days
+func (s *Sampler) prune(now time.Time) {
+	days := 7
+	var value string
+	if err := s.db.QueryRow(`SELECT value FROM settings WHERE key = 'hoststats_retention_days'`).Scan(&value); err == nil {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			days = n
+		}
+	}
+	cutoff := now.UTC().AddDate(0, 0, -days).Format(time.RFC3339)
+	s.db.Exec(`DELETE FROM host_samples WHERE sampled_at < ?`, cutoff)
+	s.db.Exec(`DELETE FROM host_disk_samples WHERE sampled_at < ?`, cutoff)
+}
+
+// Series returns the samples taken in [from, to], oldest first
+func Series(db *sql.DB, from, to time.Time) ([]Sample, error) {
+	fromAt, toAt := from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339)
+	rows, err := db.Query(`
+		SELECT sampled_at, cpus, cpu_percent, memory_total, memory_available, load1, load5, load15
+		FROM host_samples WHERE sampled_at >= ? AND sampled_at <= ?
+		ORDER BY sampled_at
+	`, fromAt, toAt)
+	if err != nil {
+		return nil, err
+	}
+	samples := []Sample{}
+	index := map[string]int{}
+	for rows.Next() {
+		var sm Sample
+		var at string
+		if err := rows.Scan(&at, &sm.CPUs, &sm.CPUPercent, &sm.MemoryTotal, &sm.MemoryAvailable, &sm.Load1, &sm.Load5, &sm.Load15); err != nil {
+			continue
+		}
+		sm.Time, _ = time.Parse(time.RFC3339, at)
+		sm.MemoryUsedPercent = percent(sm.MemoryTotal-sm.MemoryAvailable, sm.MemoryTotal)
+		sm.Disks = []Disk{}
+		index[at] = len(samples)
+		samples = append(samples, sm)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT sampled_at, volume, path, bytes_total, bytes_free, used_percent, inodes_total, inodes_free, inodes_used_percent
+		FROM host_disk_samples WHERE sampled_at >= ? AND sampled_at <= ?
+		ORDER BY sampled_at, volume
+	`, fromAt, toAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d Disk
+		var at string
+		if err := rows.Scan(&at, &d.Name, &d.Path, &d.BytesTotal, &d.BytesFree, &d.UsedPercent, &d.InodesTotal, &d.InodesFree, &d.InodesUsedPercent); err != nil {
+			continue
+		}
+		if i, ok := index[at]; ok {
+			samples[i].Disks = append(samples[i].Disks, d)
+		}
+	}
+	return samples, nil
+}
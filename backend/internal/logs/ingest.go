@@ -0,0 +1,156 @@
+package logs
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// ingestInterval is how often buffered lines are written
+	ingestInterval = 5 * time.Second
+
+	// maxBuffered bounds the lines held between writes. A database that
+	// can't keep up loses lines rather than the service's memory.
+	maxBuffered = 50000
+)
+
+// Ingester stores mail log lines in mail_logs, so they can be searched
+// after the log file has been rotated away. Delivery attempts are left to
+// the deliveries tracker, which stores them with the sender and delays
+// joined in; everything else (connections, rejects, warnings, queue
+// manager lines) is stored here as it was logged. Rows are removed by the
+// retention pruner once they are older than log_retention_days.
+type Ingester struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	pending []Entry
+	dropped int
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewIngester creates an ingester
+func NewIngester(db *sql.DB) *Ingester {
+	return &Ingester{
+		db:     db,
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start begins writing lines to the database
+func (i *Ingester) Start() {
+	i.done = make(chan struct{})
+	go i.loop()
+}
+
+// Stop writes buffered lines and stops the ingester
+func (i *Ingester) Stop() {
+	i.stopOnce.Do(func() {
+		close(i.stopCh)
+		if i.done != nil {
+			<-i.done
+		}
+	})
+}
+
+func (i *Ingester) loop() {
+	defer close(i.done)
+
+	ticker := time.NewTicker(ingestInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-i.stopCh:
+			i.Flush()
+			return
+		case <-ticker.C:
+			i.Flush()
+		}
+	}
+}
+
+// Consume buffers one log entry
+func (i *Ingester) Consume(e Entry) {
+	// The deliveries tracker stores these
+	if e.QueueID != "" && e.Status != "" && e.MailTo != "" {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(i.pending) >= maxBuffered {
+		i.dropped++
+		return
+	}
+	i.pending = append(i.pending, e)
+}
+
+// Flush writes the lines buffered so far
+func (i *Ingester) Flush() {
+	i.mu.Lock()
+	pending, dropped := i.pending, i.dropped
+	i.pending, i.dropped = nil, 0
+	i.mu.Unlock()
+	if dropped > 0 {
+		log.Warn().Int("dropped", dropped).Msg("Mail log ingestion fell behind; lines were not stored")
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	tx, err := i.db.Begin()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to store mail log lines")
+		return
+	}
+	stmt, err := tx.Prepare(`
+		INSERT INTO mail_logs (timestamp, hostname, process, pid, queue_id, message, severity,
+			mail_from, mail_to, relay, dsn)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		tx.Rollback()
+		log.Error().Err(err).Msg("Failed to store mail log lines")
+		return
+	}
+	defer stmt.Close()
+
+	for _, e := range pending {
+		ts := e.Timestamp
+		if ts.IsZero() {
+			ts = time.Now()
+		}
+		_, err := stmt.Exec(ts.UTC().Format(time.RFC3339), e.Hostname, e.Process, e.PID, nullIfEmpty(e.QueueID), e.Message,
+			ingestSeverity(e.Severity), nullIfEmpty(e.MailFrom), nullIfEmpty(e.MailTo), nullIfEmpty(e.Relay), nullIfEmpty(e.DSN))
+		if err != nil {
+			log.Error().Err(err).Str("queue_id", e.QueueID).Msg("Failed to store mail log line")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Error().Err(err).Msg("Failed to store mail log lines")
+	}
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// ingestSeverity maps an entry's severity onto the values mail_logs allows
+func ingestSeverity(s string) string {
+	switch s {
+	case "warning", "error":
+		return s
+	default:
+		return "info"
+	}
+}
@@ -0,0 +1,220 @@
+package logs
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrBadQuery is returned for a search query that can't be turned into a
+// full-text match
+var ErrBadQuery = errors.New("invalid search query")
+
+// searchFields maps the field: prefixes a query can use onto the indexed
+// mail_logs columns
+var searchFields = map[string]string{
+	"message":   "message",
+	"from":      "mail_from",
+	"sender":    "mail_from",
+	"to":        "mail_to",
+	"recipient": "mail_to",
+	"relay":     "relay",
+}
+
+// SearchFilter selects stored mail log lines. Query is matched against the
+// full-text index; the other fields narrow the result.
+type SearchFilter struct {
+	Query    string
+	QueueID  string
+	Severity string
+	Since    time.Time
+	Until    time.Time
+	// Domains, when set, limits results to lines from or to these domains
+	Domains []string
+	Limit   int
+	Offset  int
+}
+
+// SearchResult is a stored mail log line
+type SearchResult struct {
+	ID int64 `json:"id"`
+	Entry
+}
+
+// MatchQuery turns a search into an FTS5 match expression. Terms are
+// matched as phrases, so addresses and hostnames need no quoting; several
+// terms must all match. A term can be limited to a field (from:, to:,
+// relay:, message:), end in * to match a prefix, or be combined with AND,
+// OR and NOT. Double quotes group words into one phrase.
+func MatchQuery(q string) (string, error) {
+	terms, err := splitQuery(q)
+	if err != nil {
+		return "", err
+	}
+	if len(terms) == 0 {
+		return "", fmt.Errorf("%w: no search terms", ErrBadQuery)
+	}
+
+	var parts []string
+	operand := false
+	for i, term := range terms {
+		if !term.quoted && (term.text == "AND" || term.text == "OR" || term.text == "NOT") {
+			if !operand || i == len(terms)-1 {
+				return "", fmt.Errorf("%w: %s must stand between two terms", ErrBadQuery, term.text)
+			}
+			parts = append(parts, term.text)
+			operand = false
+			continue
+		}
+
+		text, column := term.text, ""
+		if !term.quoted {
+			if idx := strings.Index(text, ":"); idx > 0 {
+				if col, ok := searchFields[strings.ToLower(text[:idx])]; ok {
+					column, text = col, text[idx+1:]
+				}
+			}
+		}
+		prefix := !term.quoted && strings.HasSuffix(text, "*")
+		text = strings.TrimSuffix(text, "*")
+		if text == "" {
+			return "", fmt.Errorf("%w: empty term", ErrBadQuery)
+		}
+
+		expr := `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+		if prefix {
+			expr += " *"
+		}
+		if column != "" {
+			expr = column + " : " + expr
+		}
+		parts = append(parts, expr)
+		operand = true
+	}
+	return strings.Join(parts, " "), nil
+}
+
+type queryTerm struct {
+	text   string
+	quoted bool
+}
+
+// splitQuery splits a search on whitespace, keeping double-quoted phrases
+// together
+func splitQuery(q string) ([]queryTerm, error) {
+	var terms []queryTerm
+	var current strings.Builder
+	inQuotes := false
+	flush := func(quoted bool) {
+		if current.Len() > 0 || quoted {
+			terms = append(terms, queryTerm{text: current.String(), quoted: quoted})
+		}
+		current.Reset()
+	}
+	for _, r := range q {
+		switch {
+		case r == '"':
+			if inQuotes {
+				flush(true)
+			} else {
+				flush(false)
+			}
+			inQuotes = !inQuotes
+		case !inQuotes && (r == ' ' || r == '\t' || r == '\n'):
+			flush(false)
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("%w: unbalanced quotes", ErrBadQuery)
+	}
+	flush(false)
+	return terms, nil
+}
+
+// Search returns the stored lines matching f, newest first, and how many
+// match in all
+func Search(db *sql.DB, f SearchFilter) ([]SearchResult, int, error) {
+	match, err := MatchQuery(f.Query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	where := []string{"mail_logs_fts MATCH ?"}
+	args := []interface{}{match}
+	if f.QueueID != "" {
+		where = append(where, `l.queue_id = ?`)
+		args = append(args, f.QueueID)
+	}
+	if f.Severity != "" {
+		where = append(where, `l.severity = ?`)
+		args = append(args, f.Severity)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, `l.timestamp >= ?`)
+		args = append(args, f.Since.UTC().Format(time.RFC3339))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, `l.timestamp < ?`)
+		args = append(args, f.Until.UTC().Format(time.RFC3339))
+	}
+	if f.Domains != nil {
+		var domainConds []string
+		for _, d := range f.Domains {
+			domainConds = append(domainConds, `LOWER(l.mail_from) LIKE ? OR LOWER(l.mail_to) LIKE ?`)
+			args = append(args, "%@"+strings.ToLower(d), "%@"+strings.ToLower(d))
+		}
+		if len(domainConds) == 0 {
+			return []SearchResult{}, 0, nil
+		}
+		where = append(where, "("+strings.Join(domainConds, " OR ")+")")
+	}
+	from := `
+		FROM mail_logs_fts JOIN mail_logs l ON l.id = mail_logs_fts.rowid
+		WHERE ` + strings.Join(where, " AND ")
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*)`+from, args...).Scan(&total); err != nil {
+		return nil, 0, searchError(err)
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := db.Query(`
+		SELECT l.id, l.timestamp, COALESCE(l.hostname, ''), COALESCE(l.process, ''), COALESCE(l.pid, 0),
+			COALESCE(l.queue_id, ''), l.message, COALESCE(l.severity, ''), COALESCE(l.mail_from, ''),
+			COALESCE(l.mail_to, ''), COALESCE(l.status, ''), COALESCE(l.relay, ''), COALESCE(l.delay, 0),
+			COALESCE(l.dsn, '')`+from+`
+		ORDER BY l.timestamp DESC, l.id DESC LIMIT ? OFFSET ?
+	`, append(args, limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, searchError(err)
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var res SearchResult
+		var ts string
+		if err := rows.Scan(&res.ID, &ts, &res.Hostname, &res.Process, &res.PID, &res.QueueID, &res.Message,
+			&res.Severity, &res.MailFrom, &res.MailTo, &res.Status, &res.Relay, &res.Delay, &res.DSN); err != nil {
+			return nil, 0, err
+		}
+		res.Timestamp, _ = time.Parse(time.RFC3339, ts)
+		results = append(results, res)
+	}
+	return results, total, rows.Err()
+}
+
+// searchError reports FTS5's complaints about a query as ErrBadQuery
+func searchError(err error) error {
+	if strings.Contains(err.Error(), "fts5") {
+		return fmt.Errorf("%w: %v", ErrBadQuery, err)
+	}
+	return err
+}
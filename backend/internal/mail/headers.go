@@ -0,0 +1,87 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrHeaderInjection is returned for a message whose addresses or header
+// values hold line breaks, which would let them add headers or, in an
+// address, SMTP commands
+var ErrHeaderInjection = errors.New("header injection")
+
+// InjectionError says which field carried the line break
+type InjectionError struct {
+	Field string
+}
+
+func (e *InjectionError) Error() string {
+	return fmt.Sprintf("%s: %s contains a line break or NUL", ErrHeaderInjection, e.Field)
+}
+
+func (e *InjectionError) Unwrap() error { return ErrHeaderInjection }
+
+// hasLineBreak reports whether s holds a CR, LF or NUL
+func hasLineBreak(s string) bool {
+	return strings.ContainsAny(s, "\r\n\x00")
+}
+
+// ValidateHeaders checks the envelope sender, every recipient and the
+// header values of msg for CR, LF and NUL. Bodies may hold line breaks;
+// they are normalized when the message is built.
+func ValidateHeaders(from string, msg *ComposeMessage) error {
+	if hasLineBreak(from) {
+		return &InjectionError{Field: "from"}
+	}
+	for field, addrs := range map[string][]string{"to": msg.To, "cc": msg.Cc, "bcc": msg.Bcc} {
+		for _, addr := range addrs {
+			if hasLineBreak(addr) {
+				return &InjectionError{Field: field}
+			}
+		}
+	}
+	for field, value := range map[string]string{
+		"subject":    msg.Subject,
+		"messageId":  msg.MessageID,
+		"inReplyTo":  msg.InReplyTo,
+		"references": msg.References,
+	} {
+		if hasLineBreak(value) {
+			return &InjectionError{Field: field}
+		}
+	}
+	for _, f := range msg.Files {
+		if hasLineBreak(f.Filename) || hasLineBreak(f.ContentType) || strings.Contains(f.ContentType, `"`) {
+			return &InjectionError{Field: "attachment"}
+		}
+	}
+	return nil
+}
+
+// NormalizeLineEndings turns every bare LF and bare CR in data into CRLF,
+// the only line ending SMTP allows. A bare LF or CR next to a dot is what
+// SMTP smuggling uses to end a message early at a server that reads line
+// endings loosely. With only CRLF left, the DATA writer's dot-stuffing
+// also sees the start of every line.
+func NormalizeLineEndings(data []byte) []byte {
+	if !bytes.ContainsAny(data, "\r\n") {
+		return data
+	}
+	out := make([]byte, 0, len(data)+len(data)/32)
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '\r':
+			out = append(out, '\r', '\n')
+			if i+1 < len(data) && data[i+1] == '\n' {
+				i++
+			}
+		case '\n':
+			out = append(out, '\r', '\n')
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -2,6 +2,7 @@ package mail
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
 	"net/textproto"
@@ -14,6 +15,10 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// ErrAuthFailed is returned when the IMAP server rejects the credentials,
+// as opposed to not being reachable
+var ErrAuthFailed = errors.New("authentication failed")
+
 // Session represents an authenticated mail session with IMAP connection
 type Session struct {
 	ID        string
@@ -31,6 +36,7 @@ type SessionManager struct {
 	mu       sync.RWMutex
 	imapHost string
 	imapPort string
+	stopOnce sync.Once
 }
 
 // NewSessionManager creates a new session manager
@@ -50,9 +56,6 @@ func NewSessionManager() *SessionManager {
 		imapPort: port,
 	}
 
-	// Start cleanup goroutine
-	go sm.cleanupLoop()
-
 	return sm
 }
 
@@ -79,7 +82,7 @@ func (sm *SessionManager) Authenticate(email, password string) (*Session, error)
 	if err := c.Login(email, password); err != nil {
 		c.Logout()
 		log.Warn().Err(err).Str("email", email).Msg("IMAP authentication failed")
-		return nil, fmt.Errorf("authentication failed: %w", err)
+		return nil, fmt.Errorf("%w: %v", ErrAuthFailed, err)
 	}
 
 	// Generate session ID
@@ -134,18 +137,31 @@ func (sm *SessionManager) CloseSession(sessionID string) {
 	log.Debug().Str("sessionId", sessionID).Msg("Mail session closed")
 }
 
-// cleanupLoop periodically removes stale sessions
-func (sm *SessionManager) cleanupLoop() {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
+// Close logs out every open IMAP session
+func (sm *SessionManager) Close() {
+	sm.stopOnce.Do(func() {
+		sm.mu.Lock()
+		sessions := sm.sessions
+		sm.sessions = make(map[string]*Session)
+		sm.mu.Unlock()
 
-	for range ticker.C {
-		sm.cleanupStaleSessions()
-	}
+		for _, session := range sessions {
+			session.mu.Lock()
+			if session.client != nil {
+				session.client.Logout()
+			}
+			session.mu.Unlock()
+		}
+
+		log.Info().Int("sessions", len(sessions)).Msg("Mail sessions closed")
+	})
 }
 
-func (sm *SessionManager) cleanupStaleSessions() {
-	threshold := time.Now().Add(-30 * time.Minute)
+// CleanupStale logs out sessions unused for maxIdle and returns how many
+// were removed. The session cleanup task calls it.
+func (sm *SessionManager) CleanupStale(maxIdle time.Duration) int {
+	threshold := time.Now().Add(-maxIdle)
+	removed := 0
 
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
@@ -160,9 +176,11 @@ func (sm *SessionManager) cleanupStaleSessions() {
 				session.client.Logout()
 			}
 			delete(sm.sessions, id)
+			removed++
 			log.Debug().Str("sessionId", id).Msg("Cleaned up stale mail session")
 		}
 	}
+	return removed
 }
 
 // GenerateSessionID creates a random session ID
@@ -439,8 +457,14 @@ func (s *Session) SetFlags(folder string, uid uint32, flags []string, add bool)
 		flagsOp = imap.RemoveFlags
 	}
 
+	// The client only formats flag lists given as []interface{}
+	values := make([]interface{}, len(flags))
+	for i, flag := range flags {
+		values[i] = flag
+	}
+
 	item := imap.FormatFlagsOp(flagsOp, false)
-	return s.client.UidStore(seqSet, item, flags, nil)
+	return s.client.UidStore(seqSet, item, values, nil)
 }
 
 // MoveMessage moves a message to another folder
@@ -75,9 +75,15 @@ func (s *SMTPSender) Send(from string, password string, msg *ComposeMessage) (*S
 	if len(msg.To) == 0 {
 		return nil, fmt.Errorf("at least one recipient is required")
 	}
+	if err := ValidateHeaders(from, msg); err != nil {
+		return nil, err
+	}
 
-	// Generate message ID
-	msgID := generateMessageID(from)
+	// Use the caller's message ID, or generate one
+	msgID := msg.MessageID
+	if msgID == "" {
+		msgID = GenerateMessageID(from)
+	}
 
 	// Build MIME message
 	mimeMsg, err := s.buildMIMEMessage(from, msg, msgID)
@@ -114,8 +120,8 @@ func (s *SMTPSender) Send(from string, password string, msg *ComposeMessage) (*S
 		}
 	}
 
-	// Authenticate
-	if ok, _ := client.Extension("AUTH"); ok {
+	// Authenticate (skipped for trusted relay submissions without a password)
+	if ok, _ := client.Extension("AUTH"); ok && password != "" {
 		auth := smtp.PlainAuth("", from, password, s.config.Host)
 		if err := client.Auth(auth); err != nil {
 			return nil, fmt.Errorf("SMTP authentication failed: %w", err)
@@ -135,7 +141,8 @@ func (s *SMTPSender) Send(from string, password string, msg *ComposeMessage) (*S
 		}
 	}
 
-	// Send message data
+	// Send message data. The DATA writer dot-stuffs every line, which the
+	// CRLF-only message built above makes complete.
 	wc, err := client.Data()
 	if err != nil {
 		return nil, fmt.Errorf("DATA command failed: %w", err)
@@ -166,8 +173,20 @@ func (s *SMTPSender) Send(from string, password string, msg *ComposeMessage) (*S
 	}, nil
 }
 
-// buildMIMEMessage constructs a MIME-formatted email message
+// buildMIMEMessage constructs a MIME-formatted email message, with CRLF
+// line endings throughout
 func (s *SMTPSender) buildMIMEMessage(from string, msg *ComposeMessage, msgID string) ([]byte, error) {
+	if err := ValidateHeaders(from, msg); err != nil {
+		return nil, err
+	}
+	raw, err := s.buildMIMEParts(from, msg, msgID)
+	if err != nil {
+		return nil, err
+	}
+	return NormalizeLineEndings(raw), nil
+}
+
+func (s *SMTPSender) buildMIMEParts(from string, msg *ComposeMessage, msgID string) ([]byte, error) {
 	var buf bytes.Buffer
 
 	// Headers
@@ -196,7 +215,7 @@ func (s *SMTPSender) buildMIMEMessage(from string, msg *ComposeMessage, msgID st
 	// Determine content type based on what we have
 	hasHTML := msg.HTMLBody != ""
 	hasText := msg.Body != ""
-	hasAttachments := len(msg.Attachments) > 0
+	hasAttachments := len(msg.Files) > 0
 
 	if hasAttachments {
 		// multipart/mixed with attachments
@@ -308,9 +327,29 @@ func (s *SMTPSender) buildMultipartMixed(buf *bytes.Buffer, msg *ComposeMessage,
 	}
 	buf.WriteString("\r\n")
 
-	// Attachments would be added here
-	// For now, we don't support actual file attachments in this implementation
-	// This would require a separate upload mechanism
+	// Attachments
+	for _, f := range msg.Files {
+		contentType := f.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		filename := mime.QEncoding.Encode("utf-8", f.Filename)
+
+		buf.WriteString(fmt.Sprintf("--%s\r\n", mixedBoundary))
+		buf.WriteString(fmt.Sprintf("Content-Type: %s; name=\"%s\"\r\n", contentType, filename))
+		buf.WriteString("Content-Transfer-Encoding: base64\r\n")
+		buf.WriteString(fmt.Sprintf("Content-Disposition: attachment; filename=\"%s\"\r\n", filename))
+		buf.WriteString("\r\n")
+
+		encoded := base64.StdEncoding.EncodeToString(f.Data)
+		for len(encoded) > 76 {
+			buf.WriteString(encoded[:76])
+			buf.WriteString("\r\n")
+			encoded = encoded[76:]
+		}
+		buf.WriteString(encoded)
+		buf.WriteString("\r\n")
+	}
 
 	// End boundary
 	buf.WriteString(fmt.Sprintf("--%s--\r\n", mixedBoundary))
@@ -325,7 +364,8 @@ func (s *SMTPSender) SaveToSent(session *Session, mimeMessage []byte) error {
 
 // Helper functions
 
-func generateMessageID(from string) string {
+// GenerateMessageID returns a unique Message-ID in the sender's domain
+func GenerateMessageID(from string) string {
 	domain := "localhost"
 	if idx := strings.Index(from, "@"); idx != -1 {
 		domain = from[idx+1:]
@@ -93,15 +93,24 @@ type Attachment struct {
 
 // ComposeMessage represents a message being composed/sent
 type ComposeMessage struct {
-	To          []string `json:"to"`
-	Cc          []string `json:"cc,omitempty"`
-	Bcc         []string `json:"bcc,omitempty"`
-	Subject     string   `json:"subject"`
-	Body        string   `json:"body"`
-	HTMLBody    string   `json:"htmlBody,omitempty"`
-	InReplyTo   string   `json:"inReplyTo,omitempty"`
-	References  string   `json:"references,omitempty"`
-	Attachments []string `json:"attachments,omitempty"` // Attachment IDs
+	To          []string         `json:"to"`
+	Cc          []string         `json:"cc,omitempty"`
+	Bcc         []string         `json:"bcc,omitempty"`
+	Subject     string           `json:"subject"`
+	Body        string           `json:"body"`
+	HTMLBody    string           `json:"htmlBody,omitempty"`
+	InReplyTo   string           `json:"inReplyTo,omitempty"`
+	References  string           `json:"references,omitempty"`
+	Attachments []string         `json:"attachments,omitempty"` // Attachment IDs
+	Files       []AttachmentFile `json:"-"`                     // Uploaded attachments resolved from Attachments
+	MessageID   string           `json:"-"`                     // Set to choose the Message-ID instead of generating one
+}
+
+// AttachmentFile is an uploaded attachment ready to be added to a message
+type AttachmentFile struct {
+	Filename    string
+	ContentType string
+	Data        []byte
 }
 
 // SearchQuery represents email search parameters
@@ -0,0 +1,206 @@
+//go:build integration
+
+package mail_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/postfixrelay/postfixrelay/internal/mail"
+	"github.com/postfixrelay/postfixrelay/internal/testharness"
+)
+
+const welcomeMessage = "From: Alice Example <alice@example.com>\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: Welcome\r\n" +
+	"Message-ID: <welcome@example.com>\r\n" +
+	"Date: Mon, 02 Jan 2006 15:04:05 +0000\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Hello Bob, your mailbox is ready.\r\n"
+
+const invoiceMessage = "From: billing@example.net\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: Invoice 42\r\n" +
+	"Message-ID: <invoice42@example.net>\r\n" +
+	"Date: Tue, 03 Jan 2006 15:04:05 +0000\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Your invoice is attached.\r\n"
+
+func webmailSession(t *testing.T) (*testharness.IMAPServer, *mail.Session) {
+	t.Helper()
+	srv := testharness.NewIMAPServer(t)
+	srv.AddUser("bob@example.com", "correct horse")
+	if err := srv.Deliver("bob@example.com", "INBOX", welcomeMessage, `\Seen`); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Deliver("bob@example.com", "INBOX", invoiceMessage); err != nil {
+		t.Fatal(err)
+	}
+
+	sm := mail.NewSessionManager()
+	t.Cleanup(sm.Close)
+	session, err := sm.Authenticate("bob@example.com", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, session
+}
+
+func TestWebmailLogin(t *testing.T) {
+	srv := testharness.NewIMAPServer(t)
+	srv.AddUser("bob@example.com", "correct horse")
+	sm := mail.NewSessionManager()
+	t.Cleanup(sm.Close)
+
+	if _, err := sm.Authenticate("bob@example.com", "wrong"); !errors.Is(err, mail.ErrAuthFailed) {
+		t.Errorf("Authenticate with a wrong password: err = %v, want ErrAuthFailed", err)
+	}
+	session, err := sm.Authenticate("bob@example.com", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := sm.GetSession(session.ID); !ok || got != session {
+		t.Error("GetSession did not return the new session")
+	}
+	sm.CloseSession(session.ID)
+	if _, ok := sm.GetSession(session.ID); ok {
+		t.Error("session still found after CloseSession")
+	}
+}
+
+func TestWebmailFoldersAndMessages(t *testing.T) {
+	_, session := webmailSession(t)
+
+	folders, err := session.ListFolders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	totals := map[string]int{}
+	for _, f := range folders {
+		totals[f.Name] = f.Total
+	}
+	if len(totals) != 5 {
+		t.Errorf("ListFolders = %v, want the five standard folders", totals)
+	}
+	if totals["INBOX"] != 2 {
+		t.Errorf("INBOX total = %d, want 2", totals["INBOX"])
+	}
+
+	summaries, err := session.FetchMessages("INBOX", 0, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("FetchMessages: got %d messages, want 2", len(summaries))
+	}
+	bySubject := map[string]mail.MessageSummary{}
+	for _, s := range summaries {
+		bySubject[s.Subject] = s
+	}
+	welcome := bySubject["Welcome"]
+	if !welcome.Read || welcome.From != "alice@example.com" || welcome.FromName != "Alice Example" {
+		t.Errorf("Welcome summary = %+v", welcome)
+	}
+	if bySubject["Invoice 42"].Read {
+		t.Error("Invoice 42 is marked read")
+	}
+
+	// Paging returns the newest message first
+	page, err := session.FetchMessages("INBOX", 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || page[0].Subject != "Invoice 42" {
+		t.Errorf("first page = %+v", page)
+	}
+
+	msg, err := session.FetchMessage("INBOX", welcome.UID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Welcome" || msg.From.Name != "Alice Example" || !strings.Contains(msg.RawBody, "your mailbox is ready") {
+		t.Errorf("FetchMessage = subject %q, from %+v, raw %q", msg.Subject, msg.From, msg.RawBody)
+	}
+
+	if _, err := session.FetchMessages("Archive", 0, 50); err == nil {
+		t.Error("FetchMessages of a missing folder succeeded")
+	}
+}
+
+func TestWebmailFlagsMoveDelete(t *testing.T) {
+	srv, session := webmailSession(t)
+	summaries, err := session.FetchMessages("INBOX", 0, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uids := map[string]uint32{}
+	for _, s := range summaries {
+		uids[s.Subject] = s.UID
+	}
+
+	if err := session.SetFlags("INBOX", uids["Invoice 42"], []string{`\Seen`, `\Flagged`}, true); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := session.FetchMessage("INBOX", uids["Invoice 42"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !msg.Read || !msg.Starred {
+		t.Errorf("after SetFlags: read %v, starred %v", msg.Read, msg.Starred)
+	}
+
+	if err := session.MoveMessage("INBOX", uids["Invoice 42"], "Trash"); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.Messages("bob@example.com", "INBOX"); len(got) != 1 {
+		t.Errorf("INBOX has %d messages after move, want 1", len(got))
+	}
+	if got := srv.Messages("bob@example.com", "Trash"); len(got) != 1 || !strings.Contains(got[0], "Invoice 42") {
+		t.Errorf("Trash after move = %q", got)
+	}
+
+	if err := session.DeleteMessage("INBOX", uids["Welcome"]); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.Messages("bob@example.com", "INBOX"); len(got) != 0 {
+		t.Errorf("INBOX has %d messages after delete, want 0", len(got))
+	}
+}
+
+func TestWebmailAppendAndSearch(t *testing.T) {
+	srv, session := webmailSession(t)
+
+	sent := "From: bob@example.com\r\nTo: alice@example.com\r\nSubject: Re: Welcome\r\n\r\nThanks!\r\n"
+	if err := session.AppendMessage("Sent", []byte(sent), []string{`\Seen`}); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.Messages("bob@example.com", "Sent"); len(got) != 1 || !strings.Contains(got[0], "Subject: Re: Welcome") {
+		t.Errorf("Sent = %q", got)
+	}
+
+	tests := []struct {
+		query mail.SearchQuery
+		want  []string
+	}{
+		{mail.SearchQuery{Subject: "invoice"}, []string{"Invoice 42"}},
+		{mail.SearchQuery{From: "alice@example.com"}, []string{"Welcome"}},
+		{mail.SearchQuery{Text: "mailbox is ready"}, []string{"Welcome"}},
+		{mail.SearchQuery{Subject: "no such subject"}, nil},
+	}
+	for _, tt := range tests {
+		found, err := session.SearchMessages("INBOX", &tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, s := range found {
+			got = append(got, s.Subject)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("SearchMessages(%+v) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package mailauth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256" // crypto.SHA256
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DKIMResult is the verdict on one DKIM-Signature header
+type DKIMResult struct {
+	Domain    string `json:"domain"`
+	Selector  string `json:"selector"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Result    string `json:"result"`
+	Reason    string `json:"reason,omitempty"`
+	// KeyBits is the size of an RSA key
+	KeyBits int    `json:"keyBits,omitempty"`
+	Headers string `json:"headers,omitempty"`
+}
+
+// dkimFailure is a verification error and the result it leads to
+type dkimFailure struct {
+	result string
+	reason string
+}
+
+func (f *dkimFailure) Error() string { return f.reason }
+
+func permFail(format string, args ...interface{}) error {
+	return &dkimFailure{ResultPermError, fmt.Sprintf(format, args...)}
+}
+
+func fail(format string, args ...interface{}) error {
+	return &dkimFailure{ResultFail, fmt.Sprintf(format, args...)}
+}
+
+// verifyDKIM checks every DKIM-Signature on msg. A message without one
+// gets a single "none" result.
+func verifyDKIM(ctx context.Context, r Resolver, msg *message) []DKIMResult {
+	var results []DKIMResult
+	for i, h := range msg.headers {
+		if !strings.EqualFold(h.name, "DKIM-Signature") {
+			continue
+		}
+		res := DKIMResult{Result: ResultPass}
+		if err := verifySignature(ctx, r, msg, i, &res); err != nil {
+			res.Result, res.Reason = ResultPermError, err.Error()
+			var f *dkimFailure
+			if errors.As(err, &f) {
+				res.Result = f.result
+			}
+		}
+		results = append(results, res)
+	}
+	if len(results) == 0 {
+		return []DKIMResult{{Result: ResultNone, Reason: "the message isn't signed"}}
+	}
+	return results
+}
+
+// ParseTags reads a tag=value list, as in DKIM-Signature headers, DKIM key
+// records and DMARC records
+func ParseTags(s string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, part := range strings.Split(unfold(s), ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eq := strings.IndexByte(part, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("malformed tag %q", part)
+		}
+		name := strings.TrimSpace(part[:eq])
+		if _, dup := tags[name]; dup {
+			return nil, fmt.Errorf("duplicate tag %q", name)
+		}
+		tags[name] = strings.TrimSpace(part[eq+1:])
+	}
+	return tags, nil
+}
+
+func stripSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+func verifySignature(ctx context.Context, r Resolver, msg *message, index int, res *DKIMResult) error {
+	sigHeader := msg.headers[index]
+	tags, err := ParseTags(sigHeader.value())
+	if err != nil {
+		return permFail("%v", err)
+	}
+	res.Domain = strings.ToLower(tags["d"])
+	res.Selector = tags["s"]
+	res.Algorithm = tags["a"]
+	res.Headers = stripSpace(tags["h"])
+
+	for _, required := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if _, ok := tags[required]; !ok {
+			return permFail("signature has no %s= tag", required)
+		}
+	}
+	if tags["v"] != "1" {
+		return permFail("unsupported signature version %q", tags["v"])
+	}
+	if !containsFold(strings.Split(res.Headers, ":"), "from") {
+		return permFail("the From header isn't signed")
+	}
+	if i, ok := tags["i"]; ok {
+		if d := domainOf(i); d != res.Domain && !strings.HasSuffix(d, "."+res.Domain) {
+			return permFail("identity %s isn't in the signing domain", i)
+		}
+	}
+	if x, ok := tags["x"]; ok {
+		expires, err := strconv.ParseInt(x, 10, 64)
+		if err != nil {
+			return permFail("malformed x= tag")
+		}
+		if time.Now().Unix() > expires {
+			return fail("the signature expired at %s", time.Unix(expires, 0).UTC().Format(time.RFC3339))
+		}
+	}
+
+	var hashAlg crypto.Hash
+	var keyType string
+	switch strings.ToLower(res.Algorithm) {
+	case "rsa-sha256":
+		hashAlg, keyType = crypto.SHA256, "rsa"
+	case "ed25519-sha256":
+		hashAlg, keyType = crypto.SHA256, "ed25519"
+	case "rsa-sha1":
+		// RFC 8301: SHA-1 signatures must not be considered valid
+		return permFail("rsa-sha1 signatures are no longer accepted; sign with rsa-sha256")
+	default:
+		return permFail("unsupported algorithm %q", res.Algorithm)
+	}
+
+	headerCanon, bodyCanon := "simple", "simple"
+	if c, ok := tags["c"]; ok {
+		parts := strings.SplitN(strings.ToLower(c), "/", 2)
+		headerCanon = parts[0]
+		if len(parts) == 2 {
+			bodyCanon = parts[1]
+		}
+	}
+	for _, c := range []string{headerCanon, bodyCanon} {
+		if c != "simple" && c != "relaxed" {
+			return permFail("unsupported canonicalization %q", c)
+		}
+	}
+
+	// Body hash
+	body := canonicalBody(msg.body, bodyCanon)
+	if l, ok := tags["l"]; ok {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			return permFail("malformed l= tag")
+		}
+		if n > len(body) {
+			return fail("l= is longer than the body")
+		}
+		body = body[:n]
+	}
+	bh := hashAlg.New()
+	bh.Write(body)
+	wantBH, err := base64.StdEncoding.DecodeString(stripSpace(tags["bh"]))
+	if err != nil {
+		return permFail("malformed bh= tag")
+	}
+	if !bytes.Equal(bh.Sum(nil), wantBH) {
+		return fail("body hash mismatch: the body was changed after signing")
+	}
+
+	// Header hash: the signed headers, bottom-most instance first, then
+	// the signature itself with b= emptied
+	hh := hashAlg.New()
+	used := map[int]bool{}
+	for _, name := range strings.Split(res.Headers, ":") {
+		for i := len(msg.headers) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(msg.headers[i].name, name) {
+				continue
+			}
+			used[i] = true
+			hh.Write([]byte(canonicalHeader(msg.headers[i], headerCanon)))
+			break
+		}
+	}
+	unsigned := header{name: sigHeader.name, raw: emptySignature(sigHeader.raw)}
+	hh.Write([]byte(strings.TrimSuffix(canonicalHeader(unsigned, headerCanon), "\r\n")))
+	digest := hh.Sum(nil)
+
+	sig, err := base64.StdEncoding.DecodeString(stripSpace(tags["b"]))
+	if err != nil {
+		return permFail("malformed b= tag")
+	}
+
+	key, err := lookupKey(ctx, r, res.Selector, res.Domain, keyType)
+	if err != nil {
+		return err
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		res.KeyBits = k.N.BitLen()
+		if res.KeyBits < 1024 {
+			return permFail("the %d-bit key is too short; use at least 1024 bits (2048 recommended)", res.KeyBits)
+		}
+		if err := rsa.VerifyPKCS1v15(k, hashAlg, digest, sig); err != nil {
+			return fail("signature doesn't match the published key")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, digest, sig) {
+			return fail("signature doesn't match the published key")
+		}
+	}
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(strings.TrimSpace(v), s) {
+			return true
+		}
+	}
+	return false
+}
+
+var signatureB = regexp.MustCompile(`(^|;)(\s*b\s*=)[^;]*`)
+
+// emptySignature removes the value of the b= tag from a DKIM-Signature
+// header, keeping everything else, folding included
+func emptySignature(raw string) string {
+	colon := strings.IndexByte(raw, ':')
+	value := strings.TrimSuffix(raw[colon+1:], "\r\n")
+	return raw[:colon+1] + signatureB.ReplaceAllString(value, "$1$2") + "\r\n"
+}
+
+// lookupKey fetches and parses the key record at selector._domainkey.domain
+func lookupKey(ctx context.Context, r Resolver, selector, domain, keyType string) (interface{}, error) {
+	name := selector + "._domainkey." + domain
+	records, err := r.LookupTXT(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, permFail("no key published at %s", name)
+		}
+		return nil, &dkimFailure{ResultTempError, fmt.Sprintf("looking up %s: %v", name, err)}
+	}
+	if len(records) == 0 {
+		return nil, permFail("no key published at %s", name)
+	}
+
+	// Resolvers return the strings of one record joined
+	tags, err := ParseTags(records[0])
+	if err != nil {
+		return nil, permFail("key record at %s: %v", name, err)
+	}
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		return nil, permFail("key record at %s has version %q", name, v)
+	}
+	if k, ok := tags["k"]; ok && !strings.EqualFold(k, keyType) {
+		return nil, permFail("the key at %s is %s, but the signature is %s", name, k, keyType)
+	} else if !ok && keyType != "rsa" {
+		return nil, permFail("the key at %s is rsa, but the signature is %s", name, keyType)
+	}
+	if h, ok := tags["h"]; ok && !containsFold(strings.Split(h, ":"), "sha256") {
+		return nil, permFail("the key at %s doesn't allow sha256", name)
+	}
+	p := stripSpace(tags["p"])
+	if p == "" {
+		return nil, permFail("the key at %s has been revoked (empty p=)", name)
+	}
+	der, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return nil, permFail("the key at %s isn't valid base64", name)
+	}
+
+	if keyType == "ed25519" {
+		if len(der) != ed25519.PublicKeySize {
+			return nil, permFail("the ed25519 key at %s is %d bytes, not %d", name, len(der), ed25519.PublicKeySize)
+		}
+		return ed25519.PublicKey(der), nil
+	}
+	if pub, err := x509.ParsePKIXPublicKey(der); err == nil {
+		if rsaKey, ok := pub.(*rsa.PublicKey); ok {
+			return rsaKey, nil
+		}
+		return nil, permFail("the key at %s isn't an RSA key", name)
+	}
+	if rsaKey, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return rsaKey, nil
+	}
+	return nil, permFail("the key at %s can't be parsed", name)
+}
+
+// canonicalHeader applies the simple or relaxed header canonicalization
+// (RFC 6376 section 3.4)
+func canonicalHeader(h header, canon string) string {
+	if canon == "simple" {
+		return h.raw
+	}
+	value := unfold(h.value())
+	value = strings.Join(strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == '\t' }), " ")
+	return strings.ToLower(strings.TrimSpace(h.name)) + ":" + value + "\r\n"
+}
+
+// canonicalBody applies the simple or relaxed body canonicalization
+func canonicalBody(body []byte, canon string) []byte {
+	if canon == "relaxed" {
+		lines := bytes.Split(body, []byte("\r\n"))
+		for i, line := range lines {
+			fields := bytes.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == '\t' })
+			joined := bytes.Join(fields, []byte(" "))
+			// A line of only whitespace becomes empty
+			if len(fields) > 0 && (line[0] == ' ' || line[0] == '\t') {
+				joined = append([]byte(" "), joined...)
+			}
+			lines[i] = joined
+		}
+		body = bytes.Join(lines, []byte("\r\n"))
+	}
+
+	// Both drop trailing empty lines; simple keeps one CRLF for an empty
+	// body, relaxed keeps nothing
+	for bytes.HasSuffix(body, []byte("\r\n")) {
+		body = body[:len(body)-2]
+	}
+	if len(body) == 0 {
+		if canon == "relaxed" {
+			return nil
+		}
+		return []byte("\r\n")
+	}
+	return append(body[:len(body):len(body)], '\r', '\n')
+}
@@ -0,0 +1,302 @@
+package mailauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	rsaKeyOnce sync.Once
+	rsaKey     *rsa.PrivateKey
+)
+
+func testRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	rsaKeyOnce.Do(func() {
+		var err error
+		if rsaKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return rsaKey
+}
+
+// signMessage adds a DKIM-Signature for example.com, selector brisbane,
+// to the given header block and body
+func signMessage(t *testing.T, key crypto.Signer, alg, canon, signed, headers, body string) []byte {
+	t.Helper()
+	headerCanon, bodyCanon := canon, canon
+	if i := strings.IndexByte(canon, '/'); i >= 0 {
+		headerCanon, bodyCanon = canon[:i], canon[i+1:]
+	}
+	bh := sha256.Sum256(canonicalBody([]byte(body), bodyCanon))
+	sigValue := " v=1; a=" + alg + "; c=" + canon + "; d=example.com; s=brisbane;\r\n" +
+		"\th=" + signed + "; bh=" + base64.StdEncoding.EncodeToString(bh[:]) + ";\r\n\tb="
+
+	msg, err := parseMessage([]byte(headers + "\r\n" + body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.New()
+	names := strings.Split(signed, ":")
+	used := map[int]bool{}
+	for _, name := range names {
+		for i := len(msg.headers) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(msg.headers[i].name, name) {
+				used[i] = true
+				h.Write([]byte(canonicalHeader(msg.headers[i], headerCanon)))
+				break
+			}
+		}
+	}
+	sigHeader := header{name: "DKIM-Signature", raw: "DKIM-Signature:" + sigValue + "\r\n"}
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader(sigHeader, headerCanon), "\r\n")))
+
+	var sig []byte
+	if k, ok := key.(ed25519.PrivateKey); ok {
+		sig = ed25519.Sign(k, h.Sum(nil))
+	} else if sig, err = key.Sign(rand.Reader, h.Sum(nil), crypto.SHA256); err != nil {
+		t.Fatal(err)
+	}
+	return []byte("DKIM-Signature:" + sigValue + base64.StdEncoding.EncodeToString(sig) + "\r\n" + headers + "\r\n" + body)
+}
+
+// The examples from RFC 6376 section 3.4.6
+func TestCanonicalization(t *testing.T) {
+	msg, err := parseMessage([]byte("A: X\r\nB : Y\t\r\n\tZ  \r\n\r\n C \r\nD \t E\r\n\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var relaxed, simple string
+	for _, h := range msg.headers {
+		relaxed += canonicalHeader(h, "relaxed")
+		simple += canonicalHeader(h, "simple")
+	}
+	if want := "a:X\r\nb:Y Z\r\n"; relaxed != want {
+		t.Errorf("relaxed headers = %q, want %q", relaxed, want)
+	}
+	if want := "A: X\r\nB : Y\t\r\n\tZ  \r\n"; simple != want {
+		t.Errorf("simple headers = %q, want %q", simple, want)
+	}
+
+	if got, want := string(canonicalBody(msg.body, "relaxed")), " C\r\nD E\r\n"; got != want {
+		t.Errorf("relaxed body = %q, want %q", got, want)
+	}
+	if got, want := string(canonicalBody(msg.body, "simple")), " C \r\nD \t E\r\n"; got != want {
+		t.Errorf("simple body = %q, want %q", got, want)
+	}
+}
+
+func TestCanonicalBodyEmpty(t *testing.T) {
+	tests := []struct {
+		body, canon, want string
+	}{
+		{"", "simple", "\r\n"},
+		{"\r\n\r\n", "simple", "\r\n"},
+		{"", "relaxed", ""},
+		{" \r\n\r\n", "relaxed", ""},
+		{"no newline", "simple", "no newline\r\n"},
+		{"no newline ", "relaxed", "no newline\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(canonicalBody([]byte(tt.body), tt.canon)); got != tt.want {
+			t.Errorf("canonicalBody(%q, %s) = %q, want %q", tt.body, tt.canon, got, tt.want)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tags, err := ParseTags("v=1; a=rsa-sha256; d=example.net; s=brisbane;\r\n  c=simple; q=dns/txt; i=@eng.example.net;\r\n  h=from:to:subject:date; bh=MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI=;\r\n  b=dzdVyOfAKCdLXdJOc9G2q8LoXSlEniSbav+yuU4zGeeruD00lszZ VoG4ZHRNiYzR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"v": "1", "a": "rsa-sha256", "d": "example.net", "s": "brisbane", "c": "simple",
+		"q": "dns/txt", "i": "@eng.example.net", "h": "from:to:subject:date",
+		"bh": "MTIzNDU2Nzg5MDEyMzQ1Njc4OTAxMjM0NTY3ODkwMTI=",
+		"b":  "dzdVyOfAKCdLXdJOc9G2q8LoXSlEniSbav+yuU4zGeeruD00lszZ VoG4ZHRNiYzR",
+	}
+	for name, value := range want {
+		if tags[name] != value {
+			t.Errorf("%s = %q, want %q", name, tags[name], value)
+		}
+	}
+	if len(tags) != len(want) {
+		t.Errorf("got %d tags, want %d", len(tags), len(want))
+	}
+
+	for _, bad := range []string{"v=1; v=1", "v=1; novalue", "=1"} {
+		if _, err := ParseTags(bad); err == nil {
+			t.Errorf("ParseTags(%q): no error", bad)
+		}
+	}
+}
+
+func TestEmptySignature(t *testing.T) {
+	raw := "DKIM-Signature: v=1; bh=abc=;\r\n\tb=dzdV\r\n\t yuU4;\r\n\td=example.com\r\n"
+	want := "DKIM-Signature: v=1; bh=abc=;\r\n\tb=;\r\n\td=example.com\r\n"
+	if got := emptySignature(raw); got != want {
+		t.Errorf("emptySignature = %q, want %q", got, want)
+	}
+}
+
+const (
+	dkimHeaders = "From: Joe SixPack <joe@football.example.com>\r\n" +
+		"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+		"Subject: Is dinner ready?\r\n" +
+		"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+		"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n"
+	dkimBody = "Hi.\r\n\r\nWe lost the game. Are you hungry yet?\r\n\r\nJoe.\r\n"
+)
+
+func TestVerifyDKIM(t *testing.T) {
+	rsaPriv := testRSAKey(t)
+	rsaDER, _ := x509.MarshalPKIXPublicKey(&rsaPriv.PublicKey)
+	edPub, edPriv, _ := ed25519.GenerateKey(nil)
+	rsaRecord := "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(rsaDER)
+	edRecord := "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPub)
+	signed := "from:to:subject:date:message-id"
+
+	tests := []struct {
+		name   string
+		record string
+		msg    []byte
+		edit   func(string) string
+		want   string
+		reason string
+	}{
+		{name: "rsa relaxed", record: rsaRecord,
+			msg:  signMessage(t, rsaPriv, "rsa-sha256", "relaxed/relaxed", signed, dkimHeaders, dkimBody),
+			want: ResultPass},
+		{name: "rsa simple", record: rsaRecord,
+			msg:  signMessage(t, rsaPriv, "rsa-sha256", "simple/simple", signed, dkimHeaders, dkimBody),
+			want: ResultPass},
+		{name: "rsa pkcs1 key", record: "p=" + base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PublicKey(&rsaPriv.PublicKey)),
+			msg:  signMessage(t, rsaPriv, "rsa-sha256", "relaxed/simple", signed, dkimHeaders, dkimBody),
+			want: ResultPass},
+		{name: "ed25519", record: edRecord,
+			msg:  signMessage(t, edPriv, "ed25519-sha256", "relaxed/relaxed", signed, dkimHeaders, dkimBody),
+			want: ResultPass},
+		{name: "relaxed survives rewrapping", record: rsaRecord,
+			msg: signMessage(t, rsaPriv, "rsa-sha256", "relaxed/relaxed", signed, dkimHeaders, dkimBody),
+			edit: func(m string) string {
+				m = strings.Replace(m, "Subject: Is dinner ready?", "subject:  Is dinner\r\n ready?", 1)
+				return strings.Replace(m, "the game.", "the  game. ", 1)
+			},
+			want: ResultPass},
+		{name: "simple breaks on rewrapping", record: rsaRecord,
+			msg:    signMessage(t, rsaPriv, "rsa-sha256", "simple/simple", signed, dkimHeaders, dkimBody),
+			edit:   func(m string) string { return strings.Replace(m, "Subject: Is", "Subject:  Is", 1) },
+			want:   ResultFail,
+			reason: "doesn't match"},
+		{name: "body changed", record: rsaRecord,
+			msg:    signMessage(t, rsaPriv, "rsa-sha256", "relaxed/relaxed", signed, dkimHeaders, dkimBody),
+			edit:   func(m string) string { return strings.Replace(m, "We lost", "We won", 1) },
+			want:   ResultFail,
+			reason: "body hash"},
+		{name: "header changed", record: edRecord,
+			msg:    signMessage(t, edPriv, "ed25519-sha256", "relaxed/relaxed", signed, dkimHeaders, dkimBody),
+			edit:   func(m string) string { return strings.Replace(m, "Is dinner", "Was dinner", 1) },
+			want:   ResultFail,
+			reason: "doesn't match"},
+		{name: "unsigned header added", record: rsaRecord,
+			msg:  signMessage(t, rsaPriv, "rsa-sha256", "relaxed/relaxed", signed, dkimHeaders, dkimBody),
+			edit: func(m string) string { return strings.Replace(m, "\r\n\r\nHi.", "\r\nX-Spam: no\r\n\r\nHi.", 1) },
+			want: ResultPass},
+		{name: "wrong key type", record: rsaRecord,
+			msg:    signMessage(t, edPriv, "ed25519-sha256", "relaxed/relaxed", signed, dkimHeaders, dkimBody),
+			want:   ResultPermError,
+			reason: "is rsa"},
+		{name: "revoked key", record: "v=DKIM1; p=",
+			msg:    signMessage(t, rsaPriv, "rsa-sha256", "relaxed/relaxed", signed, dkimHeaders, dkimBody),
+			want:   ResultPermError,
+			reason: "revoked"},
+		{name: "no key",
+			msg:    signMessage(t, rsaPriv, "rsa-sha256", "relaxed/relaxed", signed, dkimHeaders, dkimBody),
+			want:   ResultPermError,
+			reason: "no key published at brisbane._domainkey.example.com"},
+		{name: "from not signed", record: rsaRecord,
+			msg:    signMessage(t, rsaPriv, "rsa-sha256", "relaxed/relaxed", "to:subject", dkimHeaders, dkimBody),
+			want:   ResultPermError,
+			reason: "From header"},
+		{name: "sha1", record: rsaRecord,
+			msg:    signMessage(t, rsaPriv, "rsa-sha1", "relaxed/relaxed", signed, dkimHeaders, dkimBody),
+			want:   ResultPermError,
+			reason: "rsa-sha1"},
+		{name: "expired", record: rsaRecord,
+			msg:    signMessage(t, rsaPriv, "rsa-sha256", "relaxed/relaxed", signed, dkimHeaders, dkimBody),
+			edit:   func(m string) string { return strings.Replace(m, "s=brisbane;", "s=brisbane; x=1058000000;", 1) },
+			want:   ResultFail,
+			reason: "expired"},
+		{name: "identity outside domain", record: rsaRecord,
+			msg:    signMessage(t, rsaPriv, "rsa-sha256", "relaxed/relaxed", signed, dkimHeaders, dkimBody),
+			edit:   func(m string) string { return strings.Replace(m, "s=brisbane;", "s=brisbane; i=joe@example.net;", 1) },
+			want:   ResultPermError,
+			reason: "signing domain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &fakeResolver{txt: map[string][]string{}}
+			if tt.record != "" {
+				r.txt["brisbane._domainkey.example.com"] = []string{tt.record}
+			}
+			raw := string(tt.msg)
+			if tt.edit != nil {
+				raw = tt.edit(raw)
+			}
+			msg, err := parseMessage([]byte(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			results := verifyDKIM(context.Background(), r, msg)
+			if len(results) != 1 {
+				t.Fatalf("got %d results, want 1", len(results))
+			}
+			res := results[0]
+			if res.Result != tt.want || !strings.Contains(res.Reason, tt.reason) {
+				t.Errorf("result = %s (%s), want %s (%s)", res.Result, res.Reason, tt.want, tt.reason)
+			}
+			if res.Domain != "example.com" || res.Selector != "brisbane" {
+				t.Errorf("domain/selector = %s/%s", res.Domain, res.Selector)
+			}
+		})
+	}
+}
+
+func TestVerifyDKIMKeyBits(t *testing.T) {
+	key := testRSAKey(t)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	r := &fakeResolver{txt: map[string][]string{
+		"brisbane._domainkey.example.com": {"v=DKIM1; p=" + base64.StdEncoding.EncodeToString(der)},
+	}}
+	msg, _ := parseMessage(signMessage(t, key, "rsa-sha256", "relaxed/relaxed", "from", dkimHeaders, dkimBody))
+	if res := verifyDKIM(context.Background(), r, msg)[0]; res.Result != ResultPass || res.KeyBits != 2048 {
+		t.Errorf("result = %+v", res)
+	}
+}
+
+func TestVerifyDKIMTempError(t *testing.T) {
+	r := &fakeResolver{tempfail: map[string]bool{"brisbane._domainkey.example.com": true}}
+	msg, _ := parseMessage(signMessage(t, testRSAKey(t), "rsa-sha256", "relaxed/relaxed", "from", dkimHeaders, dkimBody))
+	if res := verifyDKIM(context.Background(), r, msg)[0]; res.Result != ResultTempError {
+		t.Errorf("result = %+v, want temperror", res)
+	}
+}
+
+func TestVerifyDKIMUnsigned(t *testing.T) {
+	msg, _ := parseMessage([]byte(dkimHeaders + "\r\n" + dkimBody))
+	results := verifyDKIM(context.Background(), &fakeResolver{}, msg)
+	if len(results) != 1 || results[0].Result != ResultNone {
+		t.Errorf("results = %+v, want one none", results)
+	}
+}
@@ -0,0 +1,123 @@
+package mailauth
+
+import (
+	"context"
+	"strings"
+)
+
+// DMARCResult is the From domain's policy applied to the DKIM and SPF
+// results
+type DMARCResult struct {
+	Domain string `json:"domain"`
+	Result string `json:"result"`
+	// Policy is what the domain asks receivers to do with failing mail:
+	// none, quarantine or reject
+	Policy string `json:"policy,omitempty"`
+	Record string `json:"record,omitempty"`
+	// DKIMAligned and SPFAligned say which check passed for the From
+	// domain
+	DKIMAligned bool   `json:"dkimAligned"`
+	SPFAligned  bool   `json:"spfAligned"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// secondLevelSuffixes are common public suffixes with two labels. Without
+// the full Public Suffix List, the organizational domain is the last two
+// labels, or three under one of these.
+var secondLevelSuffixes = map[string]bool{
+	"co.uk": true, "org.uk": true, "ac.uk": true, "gov.uk": true,
+	"com.au": true, "net.au": true, "org.au": true, "edu.au": true,
+	"co.nz": true, "co.jp": true, "co.za": true, "com.br": true,
+	"com.cn": true, "com.mx": true, "co.in": true, "com.tr": true,
+}
+
+// organizationalDomain approximates the registered domain of d
+func organizationalDomain(d string) string {
+	labels := strings.Split(strings.TrimSuffix(strings.ToLower(d), "."), ".")
+	if len(labels) <= 2 {
+		return strings.Join(labels, ".")
+	}
+	keep := 2
+	if secondLevelSuffixes[strings.Join(labels[len(labels)-2:], ".")] {
+		keep = 3
+	}
+	return strings.Join(labels[len(labels)-keep:], ".")
+}
+
+// aligned compares an authenticated domain with the From domain in
+// relaxed ("r") or strict ("s") mode
+func aligned(authenticated, from, mode string) bool {
+	if authenticated == "" || from == "" {
+		return false
+	}
+	if mode == "s" {
+		return strings.EqualFold(authenticated, from)
+	}
+	return organizationalDomain(authenticated) == organizationalDomain(from)
+}
+
+// lookupDMARC finds the policy record for domain, falling back to its
+// organizational domain
+func lookupDMARC(ctx context.Context, r Resolver, domain string) (string, map[string]string, bool, error) {
+	candidates := []string{domain}
+	if org := organizationalDomain(domain); org != domain {
+		candidates = append(candidates, org)
+	}
+	for i, d := range candidates {
+		records, err := r.LookupTXT(ctx, "_dmarc."+d)
+		if err != nil && isTemporary(err) {
+			return "", nil, false, err
+		}
+		for _, rec := range records {
+			if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(rec)), "v=dmarc1") {
+				continue
+			}
+			tags, err := ParseTags(rec)
+			if err != nil {
+				continue
+			}
+			return rec, tags, i > 0, nil
+		}
+	}
+	return "", nil, false, nil
+}
+
+func checkDMARC(ctx context.Context, r Resolver, fromDomain string, dkim []DKIMResult, spf SPFResult) DMARCResult {
+	res := DMARCResult{Domain: fromDomain}
+	if fromDomain == "" {
+		res.Result, res.Reason = ResultPermError, "the message has no usable From address"
+		return res
+	}
+
+	record, tags, inherited, err := lookupDMARC(ctx, r, fromDomain)
+	if err != nil {
+		res.Result, res.Reason = ResultTempError, err.Error()
+		return res
+	}
+	if record == "" {
+		res.Result, res.Reason = ResultNone, "no DMARC record at _dmarc."+fromDomain
+		return res
+	}
+	res.Record = record
+	res.Policy = strings.ToLower(tags["p"])
+	if sp, ok := tags["sp"]; ok && inherited {
+		res.Policy = strings.ToLower(sp)
+	}
+
+	adkim, aspf := strings.ToLower(tags["adkim"]), strings.ToLower(tags["aspf"])
+	for _, d := range dkim {
+		if d.Result == ResultPass && aligned(d.Domain, fromDomain, adkim) {
+			res.DKIMAligned = true
+		}
+	}
+	res.SPFAligned = spf.Result == ResultPass && aligned(spf.Domain, fromDomain, aspf)
+
+	switch {
+	case res.DKIMAligned || res.SPFAligned:
+		res.Result = ResultPass
+	default:
+		res.Result = ResultFail
+		res.Reason = "neither DKIM nor SPF passed for a domain aligned with " + fromDomain
+	}
+	return res
+}
@@ -0,0 +1,113 @@
+package mailauth
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestOrganizationalDomain(t *testing.T) {
+	tests := []struct {
+		domain, want string
+	}{
+		{"example.com", "example.com"},
+		{"mail.example.com", "example.com"},
+		{"a.b.c.example.com", "example.com"},
+		{"Mail.Example.COM.", "example.com"},
+		{"example.co.uk", "example.co.uk"},
+		{"mail.example.co.uk", "example.co.uk"},
+		{"com", "com"},
+	}
+	for _, tt := range tests {
+		if got := organizationalDomain(tt.domain); got != tt.want {
+			t.Errorf("organizationalDomain(%q) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestAligned(t *testing.T) {
+	tests := []struct {
+		authenticated, from, mode string
+		want                      bool
+	}{
+		{"example.com", "example.com", "r", true},
+		{"example.com", "example.com", "s", true},
+		{"EXAMPLE.com", "example.com", "s", true},
+		{"mail.example.com", "example.com", "r", true},
+		{"mail.example.com", "example.com", "", true},
+		{"mail.example.com", "example.com", "s", false},
+		{"example.com", "news.example.com", "r", true},
+		{"example.net", "example.com", "r", false},
+		{"example.co.uk", "other.co.uk", "r", false},
+		{"", "example.com", "r", false},
+	}
+	for _, tt := range tests {
+		if got := aligned(tt.authenticated, tt.from, tt.mode); got != tt.want {
+			t.Errorf("aligned(%q, %q, %q) = %v, want %v", tt.authenticated, tt.from, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestCheckDMARC(t *testing.T) {
+	// Records in the style of RFC 7489 Appendix B
+	r := &fakeResolver{
+		txt: map[string][]string{
+			"_dmarc.example.com":      {"v=DMARC1; p=reject; sp=quarantine; adkim=s; aspf=r; rua=mailto:dmarc-feedback@example.com"},
+			"_dmarc.example.net":      {"v=DMARC1; p=none"},
+			"_dmarc.news.example.net": {"v=DMARC1; p=quarantine; sp=reject"},
+			"_dmarc.example.org":      {"google-site-verification=abc", "v=DMARC1; p=quarantine"},
+		},
+		tempfail: map[string]bool{"_dmarc.example.info": true},
+	}
+	dkimPass := func(domain string) []DKIMResult { return []DKIMResult{{Domain: domain, Result: ResultPass}} }
+	noDKIM := []DKIMResult{{Result: ResultNone}}
+	spfPass := func(domain string) SPFResult { return SPFResult{Domain: domain, Result: ResultPass} }
+
+	tests := []struct {
+		name       string
+		from       string
+		dkim       []DKIMResult
+		spf        SPFResult
+		want       string
+		policy     string
+		dkimOK     bool
+		spfOK      bool
+		wantRecord bool
+	}{
+		{name: "dkim aligned", from: "example.com", dkim: dkimPass("example.com"),
+			want: ResultPass, policy: "reject", dkimOK: true, wantRecord: true},
+		{name: "adkim=s subdomain", from: "example.com", dkim: dkimPass("mail.example.com"),
+			want: ResultFail, policy: "reject", wantRecord: true},
+		{name: "aspf=r subdomain", from: "example.com", dkim: noDKIM, spf: spfPass("bounce.example.com"),
+			want: ResultPass, policy: "reject", spfOK: true, wantRecord: true},
+		{name: "failing dkim for the domain", from: "example.com",
+			dkim: []DKIMResult{{Domain: "example.com", Result: ResultFail}},
+			spf:  SPFResult{Domain: "example.com", Result: ResultSoftFail},
+			want: ResultFail, policy: "reject", wantRecord: true},
+		{name: "unaligned passes", from: "example.com", dkim: dkimPass("esp.example.net"), spf: spfPass("esp.example.net"),
+			want: ResultFail, policy: "reject", wantRecord: true},
+		{name: "one of several signatures", from: "example.net",
+			dkim: []DKIMResult{{Domain: "esp.example", Result: ResultPass}, {Domain: "mail.example.net", Result: ResultPass}},
+			want: ResultPass, policy: "none", dkimOK: true, wantRecord: true},
+		{name: "subdomain inherits sp", from: "mail.example.com", dkim: noDKIM,
+			want: ResultFail, policy: "quarantine", wantRecord: true},
+		{name: "own record wins", from: "news.example.net", dkim: dkimPass("example.net"),
+			want: ResultPass, policy: "quarantine", dkimOK: true, wantRecord: true},
+		{name: "other TXT records skipped", from: "example.org", dkim: dkimPass("example.org"),
+			want: ResultPass, policy: "quarantine", dkimOK: true, wantRecord: true},
+		{name: "no record", from: "example.edu", dkim: dkimPass("example.edu"), want: ResultNone},
+		{name: "temperror", from: "example.info", dkim: dkimPass("example.info"), want: ResultTempError},
+		{name: "no from", want: ResultPermError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkDMARC(context.Background(), r, tt.from, tt.dkim, tt.spf)
+			if got.Result != tt.want || got.Policy != tt.policy || got.DKIMAligned != tt.dkimOK || got.SPFAligned != tt.spfOK {
+				t.Errorf("checkDMARC = %+v", got)
+			}
+			if (got.Record != "") != tt.wantRecord || (tt.wantRecord && !strings.HasPrefix(got.Record, "v=DMARC1")) {
+				t.Errorf("record = %q", got.Record)
+			}
+		})
+	}
+}
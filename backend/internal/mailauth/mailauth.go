@@ -0,0 +1,222 @@
+// Package mailauth evaluates a message the way a receiving server would:
+// it verifies DKIM signatures against the keys published in DNS, checks
+// the sending IP against the envelope sender's SPF record, and applies the
+// From domain's DMARC policy to the two.
+package mailauth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// Results, as used in Authentication-Results headers (RFC 8601)
+const (
+	ResultPass      = "pass"
+	ResultFail      = "fail"
+	ResultSoftFail  = "softfail"
+	ResultNeutral   = "neutral"
+	ResultNone      = "none"
+	ResultTempError = "temperror"
+	ResultPermError = "permerror"
+)
+
+var errMalformed = errors.New("malformed message header")
+
+// Resolver is the part of *net.Resolver the checks use
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// DefaultResolver is the system resolver
+var DefaultResolver Resolver = net.DefaultResolver
+
+// Report is the outcome of all three checks for one message
+type Report struct {
+	FromDomain string       `json:"fromDomain"`
+	MailFrom   string       `json:"mailFrom,omitempty"`
+	ClientIP   string       `json:"clientIp,omitempty"`
+	DKIM       []DKIMResult `json:"dkim"`
+	SPF        SPFResult    `json:"spf"`
+	DMARC      DMARCResult  `json:"dmarc"`
+
+	// AuthenticationResults are the receiving server's own verdicts, when
+	// the message went through one
+	AuthenticationResults []string `json:"authenticationResults,omitempty"`
+}
+
+// Evaluate checks raw as if it had arrived from clientIP with the given
+// envelope sender. An empty mailFrom falls back to Return-Path, then From;
+// a nil clientIP is read from the newest Received header, and without one
+// SPF is reported as none.
+func Evaluate(ctx context.Context, r Resolver, raw []byte, clientIP net.IP, mailFrom string) (*Report, error) {
+	msg, err := parseMessage(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{DKIM: []DKIMResult{}}
+	fromAddr := addressOf(msg.get("From"))
+	report.FromDomain = domainOf(fromAddr)
+
+	if mailFrom == "" {
+		mailFrom = addressOf(msg.get("Return-Path"))
+	}
+	if mailFrom == "" {
+		mailFrom = fromAddr
+	}
+	report.MailFrom = mailFrom
+
+	if clientIP == nil {
+		clientIP = receivedFromIP(msg.all("Received"))
+	}
+
+	report.DKIM = verifyDKIM(ctx, r, msg)
+	if clientIP != nil {
+		report.ClientIP = clientIP.String()
+		report.SPF = CheckSPF(ctx, r, clientIP, mailFrom)
+	} else {
+		report.SPF = SPFResult{Domain: domainOf(mailFrom), Result: ResultNone, Reason: "the sending IP is unknown"}
+	}
+	report.DMARC = checkDMARC(ctx, r, report.FromDomain, report.DKIM, report.SPF)
+
+	for _, h := range msg.all("Authentication-Results") {
+		report.AuthenticationResults = append(report.AuthenticationResults, unfold(h))
+	}
+	return report, nil
+}
+
+// header is one header field as it appears in the message, folding
+// included
+type header struct {
+	name string
+	raw  string // "Name: value\r\n", with continuation lines
+}
+
+func (h header) value() string {
+	return strings.TrimSuffix(h.raw[len(h.name)+1:], "\r\n")
+}
+
+type message struct {
+	headers []header
+	body    []byte
+}
+
+func (m *message) get(name string) string {
+	for _, h := range m.headers {
+		if strings.EqualFold(h.name, name) {
+			return strings.TrimSpace(unfold(h.value()))
+		}
+	}
+	return ""
+}
+
+func (m *message) all(name string) []string {
+	var values []string
+	for _, h := range m.headers {
+		if strings.EqualFold(h.name, name) {
+			values = append(values, h.value())
+		}
+	}
+	return values
+}
+
+// parseMessage splits raw into header fields and body, converting bare
+// LF line endings (as in a saved .eml) to CRLF first
+func parseMessage(raw []byte) (*message, error) {
+	raw = toCRLF(raw)
+	// An mbox "From " line isn't part of the message
+	if bytes.HasPrefix(raw, []byte("From ")) {
+		if i := bytes.Index(raw, []byte("\r\n")); i >= 0 {
+			raw = raw[i+2:]
+		}
+	}
+
+	msg := &message{}
+	head := raw
+	if end := bytes.Index(raw, []byte("\r\n\r\n")); end >= 0 {
+		head, msg.body = raw[:end+2], raw[end+4:]
+	} else if !bytes.HasSuffix(head, []byte("\r\n")) {
+		head = append(head[:len(head):len(head)], '\r', '\n')
+	}
+
+	for len(head) > 0 {
+		n := bytes.Index(head, []byte("\r\n"))
+		// Continuation lines start with whitespace
+		for n+2 < len(head) && (head[n+2] == ' ' || head[n+2] == '\t') {
+			n += 2 + bytes.Index(head[n+2:], []byte("\r\n"))
+		}
+		line := string(head[:n+2])
+		head = head[n+2:]
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 {
+			return nil, errMalformed
+		}
+		msg.headers = append(msg.headers, header{name: line[:colon], raw: line})
+	}
+	return msg, nil
+}
+
+func toCRLF(raw []byte) []byte {
+	if bytes.Count(raw, []byte("\n")) == bytes.Count(raw, []byte("\r\n")) {
+		return raw
+	}
+	out := make([]byte, 0, len(raw)+len(raw)/32)
+	for i, c := range raw {
+		if c == '\n' && (i == 0 || raw[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func unfold(s string) string {
+	return strings.NewReplacer("\r\n", "", "\n", "").Replace(s)
+}
+
+// addressOf returns the bare address of a header such as From or
+// Return-Path
+func addressOf(v string) string {
+	v = strings.TrimSpace(v)
+	if v == "" || v == "<>" {
+		return ""
+	}
+	if addr, err := mail.ParseAddress(v); err == nil {
+		return strings.ToLower(addr.Address)
+	}
+	return strings.ToLower(strings.Trim(v, "<>"))
+}
+
+func domainOf(addr string) string {
+	if i := strings.LastIndexByte(addr, '@'); i >= 0 {
+		return strings.ToLower(strings.TrimSuffix(addr[i+1:], "."))
+	}
+	return strings.ToLower(strings.TrimSuffix(addr, "."))
+}
+
+var receivedIP = regexp.MustCompile(`\[(?:IPv6:)?([0-9A-Fa-f.:]+)\]`)
+
+// receivedFromIP returns the connecting IP recorded in the newest
+// Received header that has a public one: the server that handed the
+// message to the receiving organisation
+func receivedFromIP(received []string) net.IP {
+	for _, h := range received {
+		h = unfold(h)
+		if i := strings.Index(h, " by "); i >= 0 {
+			h = h[:i]
+		}
+		for _, m := range receivedIP.FindAllStringSubmatch(h, -1) {
+			if ip := net.ParseIP(m[1]); ip != nil && !ip.IsLoopback() && !ip.IsPrivate() {
+				return ip
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package mailauth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeResolver answers from fixed records. Names without records are
+// NXDOMAIN; names in tempfail time out.
+type fakeResolver struct {
+	txt      map[string][]string
+	ip       map[string][]string
+	mx       map[string][]string
+	tempfail map[string]bool
+}
+
+func (f *fakeResolver) err(name string) error {
+	if f.tempfail[name] {
+		return &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true, IsTemporary: true}
+	}
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (f *fakeResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if records, ok := f.txt[name]; ok && !f.tempfail[name] {
+		return records, nil
+	}
+	return nil, f.err(name)
+}
+
+func (f *fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := f.ip[host]
+	if !ok || f.tempfail[host] {
+		return nil, f.err(host)
+	}
+	var addrs []net.IPAddr
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func (f *fakeResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	hosts, ok := f.mx[name]
+	if !ok || f.tempfail[name] {
+		return nil, f.err(name)
+	}
+	var mxs []*net.MX
+	for i, host := range hosts {
+		mxs = append(mxs, &net.MX{Host: host + ".", Pref: uint16(10 * (i + 1))})
+	}
+	return mxs, nil
+}
+
+func TestParseMessage(t *testing.T) {
+	raw := "From MAILER-DAEMON Thu Jan  1 00:00:00 2026\n" +
+		"Received: from mail.example.com (mail.example.com [192.0.2.10])\n" +
+		"\tby mx.example.net (Postfix) with ESMTPS id 4Xy\n" +
+		"From: Joe SixPack <Joe@Football.Example.com>\n" +
+		"Subject: Is dinner ready?\n" +
+		"\n" +
+		"Hi.\n"
+	msg, err := parseMessage([]byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.headers) != 3 {
+		t.Fatalf("got %d headers, want 3", len(msg.headers))
+	}
+	if got := msg.headers[0].raw; !strings.HasSuffix(got, "[192.0.2.10])\r\n\tby mx.example.net (Postfix) with ESMTPS id 4Xy\r\n") {
+		t.Errorf("folded header = %q", got)
+	}
+	if got := addressOf(msg.get("from")); got != "joe@football.example.com" {
+		t.Errorf("From address = %q", got)
+	}
+	if got := string(msg.body); got != "Hi.\r\n" {
+		t.Errorf("body = %q", got)
+	}
+
+	if _, err := parseMessage([]byte("not a header\r\n\r\nbody")); err != errMalformed {
+		t.Errorf("malformed header: err = %v, want errMalformed", err)
+	}
+}
+
+func TestReceivedFromIP(t *testing.T) {
+	tests := []struct {
+		received []string
+		want     string
+	}{
+		{[]string{"from relay.example.net (relay.example.net [203.0.113.5]) by mx.example.org"}, "203.0.113.5"},
+		// Internal hops are skipped for the first public address
+		{[]string{
+			"from app (unknown [10.0.0.7]) by relay.example.net",
+			"from localhost ([127.0.0.1]) by app",
+			"from mail.example.com ([IPv6:2001:db8::25]) by mx.example.org",
+		}, "2001:db8::25"},
+		// The address of the receiving host doesn't count
+		{[]string{"from app by mx.example.org ([192.0.2.1])"}, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		got := receivedFromIP(tt.received)
+		if (got == nil && tt.want != "") || (got != nil && got.String() != tt.want) {
+			t.Errorf("receivedFromIP(%q) = %v, want %q", tt.received, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	r := &fakeResolver{
+		txt: map[string][]string{
+			"example.com":                     {"v=spf1 ip4:192.0.2.0/24 -all"},
+			"_dmarc.example.com":              {"v=DMARC1; p=reject"},
+			"brisbane._domainkey.example.com": {"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub)},
+		},
+	}
+	raw := signMessage(t, priv, "ed25519-sha256", "relaxed/relaxed", "from:to:subject",
+		"Received: from mail.example.com (mail.example.com [192.0.2.10])\r\n\tby mx.example.net (Postfix)\r\n"+
+			"Return-Path: <bounces@example.com>\r\n"+
+			"From: Joe SixPack <joe@example.com>\r\n"+
+			"To: Suzie Q <suzie@shopping.example.net>\r\n"+
+			"Subject: Is dinner ready?\r\n"+
+			"Authentication-Results: mx.example.net; spf=pass\r\n",
+		"Hi.\r\n\r\nWe lost the game.  Are you hungry yet?\r\n\r\nJoe.\r\n")
+
+	report, err := Evaluate(context.Background(), r, raw, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FromDomain != "example.com" || report.MailFrom != "bounces@example.com" || report.ClientIP != "192.0.2.10" {
+		t.Errorf("report = %+v", report)
+	}
+	if len(report.DKIM) != 1 || report.DKIM[0].Result != ResultPass {
+		t.Errorf("DKIM = %+v", report.DKIM)
+	}
+	if report.SPF.Result != ResultPass {
+		t.Errorf("SPF = %+v", report.SPF)
+	}
+	if report.DMARC.Result != ResultPass || !report.DMARC.DKIMAligned || !report.DMARC.SPFAligned {
+		t.Errorf("DMARC = %+v", report.DMARC)
+	}
+	if len(report.AuthenticationResults) != 1 {
+		t.Errorf("AuthenticationResults = %q", report.AuthenticationResults)
+	}
+
+	// From somewhere else, SPF fails but the signature still carries DMARC
+	report, err = Evaluate(context.Background(), r, raw, net.ParseIP("198.51.100.1"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.SPF.Result != ResultFail || report.DMARC.Result != ResultPass || report.DMARC.SPFAligned {
+		t.Errorf("from 198.51.100.1: SPF = %+v, DMARC = %+v", report.SPF, report.DMARC)
+	}
+}
@@ -0,0 +1,208 @@
+package mailauth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Statuses of a domain record check
+const (
+	CheckPass = "pass"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+)
+
+// RecordCheck is the verdict on one of a sending domain's DNS records,
+// with what to change when it falls short
+type RecordCheck struct {
+	Status string   `json:"status"`
+	Record string   `json:"record,omitempty"`
+	Reason string   `json:"reason,omitempty"`
+	Hints  []string `json:"hints,omitempty"`
+}
+
+// warn downgrades a passing check, keeping the first reason given
+func (c *RecordCheck) warn(reason, hint string) {
+	if c.Status == CheckPass {
+		c.Status, c.Reason = CheckWarn, reason
+	}
+	c.Hints = append(c.Hints, hint)
+}
+
+// fail fails the check, keeping the first reason it failed for
+func (c *RecordCheck) fail(reason, hint string) {
+	if c.Status != CheckFail {
+		c.Status, c.Reason = CheckFail, reason
+	}
+	if hint != "" {
+		c.Hints = append(c.Hints, hint)
+	}
+}
+
+// WorstStatus is the least healthy of the statuses
+func WorstStatus(statuses ...string) string {
+	rank := map[string]int{CheckPass: 0, CheckWarn: 1, CheckFail: 2}
+	worst := CheckPass
+	for _, s := range statuses {
+		if rank[s] > rank[worst] {
+			worst = s
+		}
+	}
+	return worst
+}
+
+// HostAddresses resolves the addresses mail to host is sent to. With
+// useMX, as for an unbracketed relayhost, that is host's MX hosts,
+// falling back to host itself when it has none.
+func HostAddresses(ctx context.Context, r Resolver, host string, useMX bool) ([]net.IP, error) {
+	hosts := []string{host}
+	if useMX {
+		if mxs, err := r.LookupMX(ctx, host); err == nil && len(mxs) > 0 {
+			hosts = hosts[:0]
+			for _, mx := range mxs {
+				hosts = append(hosts, strings.TrimSuffix(mx.Host, "."))
+			}
+		}
+	}
+
+	var ips []net.IP
+	var lastErr error
+	for _, h := range hosts {
+		addrs, err := r.LookupIPAddr(ctx, h)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+	if len(ips) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return ips, nil
+}
+
+// suggestSPF is a record authorizing the given senders
+func suggestSPF(senders []net.IP) string {
+	terms := []string{"v=spf1"}
+	for _, ip := range senders {
+		if ip.To4() != nil {
+			terms = append(terms, "ip4:"+ip.String())
+		} else {
+			terms = append(terms, "ip6:"+ip.String())
+		}
+	}
+	return strings.Join(append(terms, "-all"), " ")
+}
+
+// CheckSPFRecord checks that domain's SPF record authorizes every one of
+// senders, the addresses its mail leaves from
+func CheckSPFRecord(ctx context.Context, r Resolver, domain string, senders []net.IP) RecordCheck {
+	check := RecordCheck{Status: CheckPass}
+	c := &spfCheck{r: r}
+	record, result, err := c.spfRecord(ctx, domain)
+	if err != nil {
+		switch result {
+		case ResultNone:
+			hint := "Publish a TXT record at " + domain
+			if len(senders) > 0 {
+				hint += ": " + suggestSPF(senders)
+			}
+			check.fail(err.Error(), hint)
+		case ResultPermError:
+			check.fail(err.Error(), "Merge the records into one; receivers treat several as a permanent error")
+		default:
+			check.fail(err.Error(), "Retry the check later")
+		}
+		return check
+	}
+	check.Record = record
+
+	for _, term := range strings.Fields(strings.ToLower(record))[1:] {
+		switch term {
+		case "+all", "all":
+			check.fail("the record ends in "+term+", which authorizes every host", "End the record with -all or ~all")
+		case "?all":
+			check.warn("?all leaves unlisted hosts neutral", "End the record with -all or ~all once every sender is listed")
+		}
+	}
+
+	if len(senders) == 0 {
+		check.warn("the addresses mail leaves from couldn't be determined",
+			"Check that relayhost or myhostname resolves, or pass the addresses with ?ip=")
+		return check
+	}
+	for _, ip := range senders {
+		res := CheckSPF(ctx, r, ip, "postmaster@"+domain)
+		switch res.Result {
+		case ResultPass:
+		case ResultTempError:
+			check.warn(fmt.Sprintf("%s couldn't be checked: %s", ip, res.Reason), "Retry the check later")
+		case ResultPermError:
+			hint := "Fix the record: " + res.Reason
+			if strings.Contains(res.Reason, "DNS lookups") {
+				hint = "Replace nested include: mechanisms with ip4:/ip6: ranges to stay within 10 lookups"
+			}
+			check.fail(res.Reason, hint)
+		default:
+			check.fail(fmt.Sprintf("%s isn't authorized (%s)", ip, res.Result),
+				fmt.Sprintf("Add ip%s:%s to the record", ipVersion(ip), ip))
+		}
+	}
+	return check
+}
+
+func ipVersion(ip net.IP) string {
+	if ip.To4() != nil {
+		return "4"
+	}
+	return "6"
+}
+
+// CheckDMARCRecord checks the DMARC policy that applies to domain
+func CheckDMARCRecord(ctx context.Context, r Resolver, domain string) RecordCheck {
+	check := RecordCheck{Status: CheckPass}
+	record, tags, inherited, err := lookupDMARC(ctx, r, domain)
+	if err != nil {
+		check.fail(err.Error(), "Retry the check later")
+		return check
+	}
+	suggested := "v=DMARC1; p=none; rua=mailto:postmaster@" + domain
+	if record == "" {
+		check.fail("no DMARC record at _dmarc."+domain, "Publish a TXT record at _dmarc."+domain+": "+suggested+
+			", then raise p= to quarantine or reject once the reports are clean")
+		return check
+	}
+	check.Record = record
+
+	policy := strings.ToLower(tags["p"])
+	if sp, ok := tags["sp"]; ok && inherited {
+		policy = strings.ToLower(sp)
+	}
+	switch policy {
+	case "quarantine", "reject":
+	case "none":
+		check.warn("the policy is p=none, which only monitors",
+			"Raise p= to quarantine, then reject, once the aggregate reports show only your own mail")
+	default:
+		check.fail("the record has no valid p= policy", "Set p= to none, quarantine or reject, e.g. "+suggested)
+		return check
+	}
+
+	if inherited {
+		check.warn("the policy is inherited from "+organizationalDomain(domain),
+			"Publish _dmarc."+domain+" to give the domain its own policy")
+	}
+	if pct, err := strconv.Atoi(tags["pct"]); err == nil && pct < 100 {
+		check.warn(fmt.Sprintf("the policy applies to %d%% of failing mail", pct), "Raise pct= to 100 or remove it")
+	}
+	if tags["rua"] == "" {
+		check.warn("no aggregate reports are requested",
+			"Add rua=mailto:postmaster@"+domain+" to learn which hosts send as the domain")
+	}
+	return check
+}
@@ -0,0 +1,414 @@
+package mailauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// SPF limits from RFC 7208 section 4.6.4
+const (
+	spfMaxLookups     = 10
+	spfMaxVoidLookups = 2
+)
+
+// SPFResult is the verdict on the sending IP for the envelope sender's
+// domain
+type SPFResult struct {
+	Domain string `json:"domain"`
+	Result string `json:"result"`
+	// Record is the SPF record that decided the result
+	Record string `json:"record,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// spfCheck carries the state of one check_host evaluation across includes
+type spfCheck struct {
+	r       Resolver
+	ip      net.IP
+	sender  string
+	lookups int
+	voids   int
+}
+
+// CheckSPF evaluates ip against the SPF record of mailFrom's domain. An
+// empty mailFrom (a bounce) is not checked.
+func CheckSPF(ctx context.Context, r Resolver, ip net.IP, mailFrom string) SPFResult {
+	domain := domainOf(mailFrom)
+	if domain == "" {
+		return SPFResult{Result: ResultNone, Reason: "no envelope sender"}
+	}
+	if !strings.Contains(mailFrom, "@") {
+		mailFrom = "postmaster@" + domain
+	}
+	c := &spfCheck{r: r, ip: ip, sender: mailFrom}
+	result, record, reason := c.checkHost(ctx, domain)
+	return SPFResult{Domain: domain, Result: result, Record: record, Reason: reason}
+}
+
+func (c *spfCheck) lookup() error {
+	c.lookups++
+	if c.lookups > spfMaxLookups {
+		return fmt.Errorf("more than %d DNS lookups", spfMaxLookups)
+	}
+	return nil
+}
+
+// void counts a lookup that found nothing
+func (c *spfCheck) void(err error) error {
+	var dnsErr *net.DNSError
+	if err == nil || (errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		c.voids++
+		if c.voids > spfMaxVoidLookups {
+			return fmt.Errorf("more than %d lookups found nothing", spfMaxVoidLookups)
+		}
+		return nil
+	}
+	return err
+}
+
+func isTemporary(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && !dnsErr.IsNotFound
+}
+
+// spfRecord returns domain's single v=spf1 record
+func (c *spfCheck) spfRecord(ctx context.Context, domain string) (string, string, error) {
+	records, err := c.r.LookupTXT(ctx, domain)
+	if err != nil && isTemporary(err) {
+		return "", ResultTempError, err
+	}
+	var spf []string
+	for _, rec := range records {
+		lower := strings.ToLower(rec)
+		if lower == "v=spf1" || strings.HasPrefix(lower, "v=spf1 ") {
+			spf = append(spf, rec)
+		}
+	}
+	switch len(spf) {
+	case 0:
+		return "", ResultNone, fmt.Errorf("%s has no SPF record", domain)
+	case 1:
+		return spf[0], "", nil
+	default:
+		return "", ResultPermError, fmt.Errorf("%s has %d SPF records", domain, len(spf))
+	}
+}
+
+// checkHost is RFC 7208's check_host(): the result, the record that gave
+// it and why
+func (c *spfCheck) checkHost(ctx context.Context, domain string) (string, string, string) {
+	record, result, err := c.spfRecord(ctx, domain)
+	if err != nil {
+		return result, "", err.Error()
+	}
+
+	var redirect string
+	for _, term := range strings.Fields(record)[1:] {
+		// Modifiers are name=value; a mechanism's ':' comes before any '='
+		if eq := strings.IndexByte(term, '='); eq > 0 && !strings.ContainsAny(term[:eq], ":/") {
+			name := strings.ToLower(term[:eq])
+			if name == "redirect" {
+				redirect = term[eq+1:]
+			}
+			continue
+		}
+
+		qualifier := ResultPass
+		switch term[0] {
+		case '+':
+			term = term[1:]
+		case '-':
+			qualifier, term = ResultFail, term[1:]
+		case '~':
+			qualifier, term = ResultSoftFail, term[1:]
+		case '?':
+			qualifier, term = ResultNeutral, term[1:]
+		}
+
+		matched, result, err := c.mechanism(ctx, domain, term)
+		if err != nil {
+			return result, record, err.Error()
+		}
+		if matched {
+			return qualifier, record, fmt.Sprintf("%s matched %s", c.ip, term)
+		}
+	}
+
+	if redirect != "" {
+		if err := c.lookup(); err != nil {
+			return ResultPermError, record, err.Error()
+		}
+		target, err := c.expand(redirect, domain)
+		if err != nil {
+			return ResultPermError, record, err.Error()
+		}
+		result, inner, reason := c.checkHost(ctx, target)
+		if result == ResultNone {
+			return ResultPermError, record, "redirect to " + target + ": " + reason
+		}
+		return result, inner, reason
+	}
+	return ResultNeutral, record, "no mechanism matched " + c.ip.String()
+}
+
+// mechanism evaluates one mechanism without its qualifier. On error it
+// returns the result the whole check ends with.
+func (c *spfCheck) mechanism(ctx context.Context, domain, term string) (bool, string, error) {
+	name, arg := term, ""
+	if i := strings.IndexAny(term, ":/"); i >= 0 {
+		name, arg = term[:i], term[i:]
+	}
+	name = strings.ToLower(name)
+
+	// domain-spec and dual CIDR lengths for a, mx
+	target, cidr4, cidr6 := domain, 32, 128
+	if name == "a" || name == "mx" {
+		spec := strings.TrimPrefix(arg, ":")
+		if i := strings.IndexByte(spec, '/'); i >= 0 {
+			var err error
+			if cidr4, cidr6, err = parseDualCIDR(spec[i:]); err != nil {
+				return false, ResultPermError, err
+			}
+			spec = spec[:i]
+		}
+		if spec != "" {
+			target = spec
+		}
+	} else if strings.HasPrefix(arg, ":") {
+		target = arg[1:]
+	}
+	if strings.Contains(target, "%") {
+		expanded, err := c.expand(target, domain)
+		if err != nil {
+			return false, ResultPermError, err
+		}
+		target = expanded
+	}
+
+	switch name {
+	case "all":
+		return true, "", nil
+
+	case "ip4", "ip6":
+		network := strings.TrimPrefix(arg, ":")
+		if !strings.Contains(network, "/") {
+			if name == "ip4" {
+				network += "/32"
+			} else {
+				network += "/128"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(network)
+		if err != nil {
+			return false, ResultPermError, fmt.Errorf("malformed %s", term)
+		}
+		return ipnet.Contains(c.ip), "", nil
+
+	case "include":
+		if err := c.lookup(); err != nil {
+			return false, ResultPermError, err
+		}
+		result, _, reason := c.checkHost(ctx, target)
+		switch result {
+		case ResultPass:
+			return true, "", nil
+		case ResultFail, ResultSoftFail, ResultNeutral:
+			return false, "", nil
+		case ResultTempError:
+			return false, ResultTempError, fmt.Errorf("include:%s: %s", target, reason)
+		default:
+			return false, ResultPermError, fmt.Errorf("include:%s: %s", target, reason)
+		}
+
+	case "a", "exists":
+		if err := c.lookup(); err != nil {
+			return false, ResultPermError, err
+		}
+		addrs, err := c.r.LookupIPAddr(ctx, target)
+		if len(addrs) == 0 {
+			if err := c.void(err); err != nil {
+				return false, resultFor(err), err
+			}
+			return false, "", nil
+		}
+		if name == "exists" {
+			return true, "", nil
+		}
+		return c.matchAddrs(addrs, cidr4, cidr6), "", nil
+
+	case "mx":
+		if err := c.lookup(); err != nil {
+			return false, ResultPermError, err
+		}
+		mxs, err := c.r.LookupMX(ctx, target)
+		if len(mxs) == 0 {
+			if err := c.void(err); err != nil {
+				return false, resultFor(err), err
+			}
+			return false, "", nil
+		}
+		if len(mxs) > spfMaxLookups {
+			return false, ResultPermError, fmt.Errorf("%s has more than %d MX hosts", target, spfMaxLookups)
+		}
+		for _, mx := range mxs {
+			addrs, _ := c.r.LookupIPAddr(ctx, strings.TrimSuffix(mx.Host, "."))
+			if c.matchAddrs(addrs, cidr4, cidr6) {
+				return true, "", nil
+			}
+		}
+		return false, "", nil
+
+	case "ptr":
+		// Deprecated (RFC 7208 section 5.5) and slow; counted, never matched
+		if err := c.lookup(); err != nil {
+			return false, ResultPermError, err
+		}
+		return false, "", nil
+
+	default:
+		return false, ResultPermError, fmt.Errorf("unknown mechanism %q", term)
+	}
+}
+
+func resultFor(err error) string {
+	if isTemporary(err) {
+		return ResultTempError
+	}
+	return ResultPermError
+}
+
+func (c *spfCheck) matchAddrs(addrs []net.IPAddr, cidr4, cidr6 int) bool {
+	for _, a := range addrs {
+		bits, ones := 32, cidr4
+		ip := a.IP.To4()
+		if ip == nil {
+			bits, ones, ip = 128, cidr6, a.IP
+		}
+		if (&net.IPNet{IP: ip.Mask(net.CIDRMask(ones, bits)), Mask: net.CIDRMask(ones, bits)}).Contains(c.ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDualCIDR reads "/24", "//64" or "/24//64"
+func parseDualCIDR(s string) (int, int, error) {
+	cidr4, cidr6 := 32, 128
+	v4, v6 := s, ""
+	if i := strings.Index(s, "//"); i >= 0 {
+		v4, v6 = s[:i], s[i+2:]
+	}
+	if v4 = strings.TrimPrefix(v4, "/"); v4 != "" {
+		n, err := strconv.Atoi(v4)
+		if err != nil || n < 0 || n > 32 {
+			return 0, 0, fmt.Errorf("malformed CIDR length %q", s)
+		}
+		cidr4 = n
+	}
+	if v6 != "" {
+		n, err := strconv.Atoi(v6)
+		if err != nil || n < 0 || n > 128 {
+			return 0, 0, fmt.Errorf("malformed CIDR length %q", s)
+		}
+		cidr6 = n
+	}
+	return cidr4, cidr6, nil
+}
+
+// expand fills in SPF macros (RFC 7208 section 7) in a domain-spec
+func (c *spfCheck) expand(spec, domain string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '%' {
+			out.WriteByte(spec[i])
+			continue
+		}
+		if i+1 >= len(spec) {
+			return "", fmt.Errorf("malformed macro in %q", spec)
+		}
+		i++
+		switch spec[i] {
+		case '%':
+			out.WriteByte('%')
+			continue
+		case '_':
+			out.WriteByte(' ')
+			continue
+		case '-':
+			out.WriteString("%20")
+			continue
+		case '{':
+		default:
+			return "", fmt.Errorf("malformed macro in %q", spec)
+		}
+		end := strings.IndexByte(spec[i:], '}')
+		if end < 2 {
+			return "", fmt.Errorf("malformed macro in %q", spec)
+		}
+		macro := spec[i+1 : i+end]
+		i += end
+
+		var value string
+		local := c.sender[:strings.LastIndexByte(c.sender, '@')]
+		switch strings.ToLower(macro[:1]) {
+		case "s":
+			value = c.sender
+		case "l":
+			value = local
+		case "o":
+			value = domainOf(c.sender)
+		case "d", "h":
+			value = domain
+		case "i":
+			if ip4 := c.ip.To4(); ip4 != nil {
+				value = ip4.String()
+			} else {
+				var nibbles []string
+				for _, b := range c.ip.To16() {
+					nibbles = append(nibbles, strconv.FormatInt(int64(b>>4), 16), strconv.FormatInt(int64(b&0xF), 16))
+				}
+				value = strings.Join(nibbles, ".")
+			}
+		case "v":
+			value = "in-addr"
+			if c.ip.To4() == nil {
+				value = "ip6"
+			}
+		default:
+			return "", fmt.Errorf("unsupported macro %%{%s}", macro)
+		}
+
+		// Transformers: a count of right-hand parts to keep, r to reverse,
+		// and the delimiters to split on
+		rest := macro[1:]
+		digits := 0
+		for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+			digits++
+		}
+		keep, _ := strconv.Atoi(rest[:digits])
+		rest = rest[digits:]
+		reverse := strings.HasPrefix(strings.ToLower(rest), "r")
+		if reverse {
+			rest = rest[1:]
+		}
+		delims := "."
+		if rest != "" {
+			delims = rest
+		}
+		parts := strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(delims, r) })
+		if reverse {
+			for a, b := 0, len(parts)-1; a < b; a, b = a+1, b-1 {
+				parts[a], parts[b] = parts[b], parts[a]
+			}
+		}
+		if keep > 0 && keep < len(parts) {
+			parts = parts[len(parts)-keep:]
+		}
+		out.WriteString(strings.Join(parts, "."))
+	}
+	return out.String(), nil
+}
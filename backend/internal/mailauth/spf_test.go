@@ -0,0 +1,304 @@
+package mailauth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// rfc7208Zone is the example zone from RFC 7208 Appendix A
+func rfc7208Zone(record string) *fakeResolver {
+	return &fakeResolver{
+		txt: map[string][]string{"example.com": {record}},
+		ip: map[string][]string{
+			"example.com":        {"192.0.2.10", "192.0.2.11"},
+			"amy.example.com":    {"192.0.2.65"},
+			"bob.example.com":    {"192.0.2.66"},
+			"mail-a.example.com": {"192.0.2.129"},
+			"mail-b.example.com": {"192.0.2.130"},
+			"mail-c.example.org": {"192.0.2.140"},
+		},
+		mx: map[string][]string{
+			"example.com": {"mail-a.example.com", "mail-b.example.com"},
+			"example.org": {"mail-c.example.org"},
+		},
+	}
+}
+
+// The examples from RFC 7208 Appendix A.1: which hosts each record allows
+func TestCheckSPFAppendixA(t *testing.T) {
+	hosts := []string{"192.0.2.10", "192.0.2.11", "192.0.2.65", "192.0.2.66",
+		"192.0.2.129", "192.0.2.130", "192.0.2.131", "192.0.2.140", "192.0.2.142", "198.51.100.1"}
+	tests := []struct {
+		record string
+		pass   []string
+	}{
+		{"v=spf1 +all", hosts},
+		{"v=spf1 a -all", []string{"192.0.2.10", "192.0.2.11"}},
+		{"v=spf1 a:example.org -all", nil},
+		{"v=spf1 mx -all", []string{"192.0.2.129", "192.0.2.130"}},
+		{"v=spf1 mx:example.org -all", []string{"192.0.2.140"}},
+		{"v=spf1 mx mx:example.org -all", []string{"192.0.2.129", "192.0.2.130", "192.0.2.140"}},
+		{"v=spf1 mx/30 mx:example.org/30 -all", []string{"192.0.2.129", "192.0.2.130", "192.0.2.131", "192.0.2.140", "192.0.2.142"}},
+		{"v=spf1 ip4:192.0.2.128/28 -all", []string{"192.0.2.129", "192.0.2.130", "192.0.2.131", "192.0.2.140", "192.0.2.142"}},
+		{"v=spf1 a:amy.example.com a:bob.example.com -all", []string{"192.0.2.65", "192.0.2.66"}},
+		{"v=spf1 a/31 -all", []string{"192.0.2.10", "192.0.2.11"}},
+		// ptr is counted but never matched
+		{"v=spf1 ptr -all", nil},
+	}
+	for _, tt := range tests {
+		for _, host := range hosts {
+			want := ResultFail
+			for _, p := range tt.pass {
+				if p == host {
+					want = ResultPass
+				}
+			}
+			got := CheckSPF(context.Background(), rfc7208Zone(tt.record), net.ParseIP(host), "joe@example.com")
+			if got.Result != want {
+				t.Errorf("%q from %s = %s (%s), want %s", tt.record, host, got.Result, got.Reason, want)
+			}
+		}
+	}
+}
+
+func TestCheckSPF(t *testing.T) {
+	tests := []struct {
+		name     string
+		txt      map[string][]string
+		tempfail string
+		ip       string
+		sender   string
+		want     string
+		reason   string
+	}{
+		{name: "qualifiers", txt: map[string][]string{"example.com": {"v=spf1 ?ip4:192.0.2.1 ~ip4:192.0.2.2 -ip4:192.0.2.3 ~all"}},
+			ip: "192.0.2.1", want: ResultNeutral},
+		{name: "softfail", txt: map[string][]string{"example.com": {"v=spf1 ?ip4:192.0.2.1 ~ip4:192.0.2.2 -ip4:192.0.2.3 ~all"}},
+			ip: "192.0.2.2", want: ResultSoftFail},
+		{name: "default all", txt: map[string][]string{"example.com": {"v=spf1 ?ip4:192.0.2.1 ~ip4:192.0.2.2 -ip4:192.0.2.3 ~all"}},
+			ip: "198.51.100.1", want: ResultSoftFail},
+		{name: "no match", txt: map[string][]string{"example.com": {"v=spf1 ip4:192.0.2.1"}},
+			ip: "198.51.100.1", want: ResultNeutral, reason: "no mechanism matched"},
+		{name: "ip6", txt: map[string][]string{"example.com": {"v=spf1 ip6:2001:db8::/32 -all"}},
+			ip: "2001:db8::cb01", want: ResultPass},
+		{name: "ip6 outside", txt: map[string][]string{"example.com": {"v=spf1 ip6:2001:db8::/32 -all"}},
+			ip: "2001:db9::1", want: ResultFail},
+		{name: "ip4 doesn't match ip6", txt: map[string][]string{"example.com": {"v=spf1 ip4:0.0.0.0/0 -all"}},
+			ip: "2001:db8::1", want: ResultFail},
+		{name: "case insensitive", txt: map[string][]string{"example.com": {"V=SPF1 IP4:192.0.2.1 -ALL"}},
+			ip: "192.0.2.1", want: ResultPass},
+		{name: "no record", txt: map[string][]string{"example.com": {"google-site-verification=abc"}},
+			ip: "192.0.2.1", want: ResultNone},
+		{name: "no domain", ip: "192.0.2.1", want: ResultNone},
+		{name: "multiple records", txt: map[string][]string{"example.com": {"v=spf1 -all", "v=spf1 +all"}},
+			ip: "192.0.2.1", want: ResultPermError, reason: "2 SPF records"},
+		{name: "v=spf10", txt: map[string][]string{"example.com": {"v=spf10 +all"}},
+			ip: "192.0.2.1", want: ResultNone},
+		{name: "temperror", tempfail: "example.com",
+			ip: "192.0.2.1", want: ResultTempError},
+		{name: "unknown mechanism", txt: map[string][]string{"example.com": {"v=spf1 ip5:192.0.2.1 -all"}},
+			ip: "192.0.2.1", want: ResultPermError, reason: "unknown mechanism"},
+		{name: "malformed ip4", txt: map[string][]string{"example.com": {"v=spf1 ip4:192.0.2 -all"}},
+			ip: "192.0.2.1", want: ResultPermError},
+		{name: "unknown modifier ignored", txt: map[string][]string{"example.com": {"v=spf1 exp=explain.example.com moo=cow ip4:192.0.2.1 -all"}},
+			ip: "192.0.2.1", want: ResultPass},
+		{name: "include pass", txt: map[string][]string{
+			"example.com":      {"v=spf1 include:_spf.example.net -all"},
+			"_spf.example.net": {"v=spf1 ip4:192.0.2.0/24 -all"},
+		}, ip: "192.0.2.1", want: ResultPass},
+		// A fail inside an include is just "no match"
+		{name: "include fail", txt: map[string][]string{
+			"example.com":      {"v=spf1 include:_spf.example.net ?all"},
+			"_spf.example.net": {"v=spf1 ip4:192.0.2.0/24 -all"},
+		}, ip: "198.51.100.1", want: ResultNeutral},
+		{name: "include none", txt: map[string][]string{
+			"example.com": {"v=spf1 include:_spf.example.net -all"},
+		}, ip: "192.0.2.1", want: ResultPermError, reason: "include:_spf.example.net"},
+		{name: "include temperror", txt: map[string][]string{
+			"example.com":      {"v=spf1 include:_spf.example.net -all"},
+			"_spf.example.net": {"v=spf1 -all"},
+		}, tempfail: "_spf.example.net", ip: "192.0.2.1", want: ResultTempError},
+		{name: "redirect", txt: map[string][]string{
+			"example.com":      {"v=spf1 redirect=_spf.example.net"},
+			"_spf.example.net": {"v=spf1 ip4:192.0.2.0/24 -all"},
+		}, ip: "198.51.100.1", want: ResultFail},
+		{name: "redirect after all ignored", txt: map[string][]string{
+			"example.com":      {"v=spf1 +all redirect=_spf.example.net"},
+			"_spf.example.net": {"v=spf1 -all"},
+		}, ip: "198.51.100.1", want: ResultPass},
+		{name: "redirect none", txt: map[string][]string{
+			"example.com": {"v=spf1 redirect=_spf.example.net"},
+		}, ip: "192.0.2.1", want: ResultPermError, reason: "redirect to _spf.example.net"},
+		{name: "exists macro", txt: map[string][]string{
+			"example.com": {"v=spf1 exists:%{ir}.%{l1r+-}._spf.%{d} -all"},
+		}, ip: "192.0.2.3", sender: "strong-bad@example.com", want: ResultPass},
+		{name: "exists macro miss", txt: map[string][]string{
+			"example.com": {"v=spf1 exists:%{ir}.%{l1r+-}._spf.%{d} -all"},
+		}, ip: "192.0.2.4", sender: "strong-bad@example.com", want: ResultFail},
+		{name: "bare domain sender", txt: map[string][]string{
+			"example.com": {"v=spf1 exists:%{l}.example.com -all"},
+		}, ip: "192.0.2.1", sender: "example.com", want: ResultPass},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &fakeResolver{
+				txt: tt.txt,
+				ip: map[string][]string{
+					"3.2.0.192.strong._spf.example.com": {"127.0.0.2"},
+					"postmaster.example.com":            {"127.0.0.2"},
+				},
+				tempfail: map[string]bool{tt.tempfail: true},
+			}
+			sender := tt.sender
+			if sender == "" && tt.name != "no domain" {
+				sender = "joe@example.com"
+			}
+			got := CheckSPF(context.Background(), r, net.ParseIP(tt.ip), sender)
+			if got.Result != tt.want || !strings.Contains(got.Reason, tt.reason) {
+				t.Errorf("result = %s (%s), want %s (%s)", got.Result, got.Reason, tt.want, tt.reason)
+			}
+		})
+	}
+}
+
+func TestCheckSPFLookupLimit(t *testing.T) {
+	// include chains of n records; the limit is 10 lookups in all
+	chain := func(n int) *fakeResolver {
+		r := &fakeResolver{txt: map[string][]string{}}
+		for i := 0; i < n; i++ {
+			r.txt[fmt.Sprintf("l%d.example.com", i)] = []string{fmt.Sprintf("v=spf1 include:l%d.example.com", i+1)}
+		}
+		r.txt[fmt.Sprintf("l%d.example.com", n)] = []string{"v=spf1 ip4:192.0.2.1 -all"}
+		return r
+	}
+	if got := CheckSPF(context.Background(), chain(10), net.ParseIP("192.0.2.1"), "joe@l0.example.com"); got.Result != ResultPass {
+		t.Errorf("10 includes = %s (%s), want pass", got.Result, got.Reason)
+	}
+	got := CheckSPF(context.Background(), chain(11), net.ParseIP("192.0.2.1"), "joe@l0.example.com")
+	if got.Result != ResultPermError || !strings.Contains(got.Reason, "more than 10 DNS lookups") {
+		t.Errorf("11 includes = %s (%s), want permerror", got.Result, got.Reason)
+	}
+
+	// An include loop stops at the limit too
+	loop := &fakeResolver{txt: map[string][]string{
+		"example.com": {"v=spf1 include:example.net -all"},
+		"example.net": {"v=spf1 include:example.com -all"},
+	}}
+	if got := CheckSPF(context.Background(), loop, net.ParseIP("192.0.2.1"), "joe@example.com"); got.Result != ResultPermError {
+		t.Errorf("include loop = %s (%s), want permerror", got.Result, got.Reason)
+	}
+
+	// Mechanisms that don't query DNS don't count
+	var terms []string
+	for i := 0; i < 20; i++ {
+		terms = append(terms, fmt.Sprintf("ip4:198.51.100.%d", i))
+	}
+	wide := &fakeResolver{txt: map[string][]string{
+		"example.com": {"v=spf1 " + strings.Join(terms, " ") + " a mx a mx a mx a mx a mx a -all"},
+	}, ip: map[string][]string{"example.com": {"198.51.100.200"}}, mx: map[string][]string{"example.com": {"mx.example.com"}}}
+	got = CheckSPF(context.Background(), wide, net.ParseIP("192.0.2.1"), "joe@example.com")
+	if got.Result != ResultPermError || !strings.Contains(got.Reason, "more than 10 DNS lookups") {
+		t.Errorf("11 a/mx = %s (%s), want permerror", got.Result, got.Reason)
+	}
+}
+
+func TestCheckSPFVoidLimit(t *testing.T) {
+	record := func(n int) *fakeResolver {
+		var terms []string
+		for i := 0; i < n; i++ {
+			terms = append(terms, fmt.Sprintf("a:gone%d.example.com", i))
+		}
+		return &fakeResolver{txt: map[string][]string{"example.com": {"v=spf1 " + strings.Join(terms, " ") + " -all"}}}
+	}
+	if got := CheckSPF(context.Background(), record(2), net.ParseIP("192.0.2.1"), "joe@example.com"); got.Result != ResultFail {
+		t.Errorf("2 void lookups = %s (%s), want fail", got.Result, got.Reason)
+	}
+	got := CheckSPF(context.Background(), record(3), net.ParseIP("192.0.2.1"), "joe@example.com")
+	if got.Result != ResultPermError || !strings.Contains(got.Reason, "found nothing") {
+		t.Errorf("3 void lookups = %s (%s), want permerror", got.Result, got.Reason)
+	}
+
+	temp := record(1)
+	temp.tempfail = map[string]bool{"gone0.example.com": true}
+	if got := CheckSPF(context.Background(), temp, net.ParseIP("192.0.2.1"), "joe@example.com"); got.Result != ResultTempError {
+		t.Errorf("a: timeout = %s (%s), want temperror", got.Result, got.Reason)
+	}
+}
+
+func TestParseDualCIDR(t *testing.T) {
+	tests := []struct {
+		in           string
+		cidr4, cidr6 int
+		bad          bool
+	}{
+		{in: "/24", cidr4: 24, cidr6: 128},
+		{in: "//64", cidr4: 32, cidr6: 64},
+		{in: "/24//64", cidr4: 24, cidr6: 64},
+		{in: "/0//0", cidr4: 0, cidr6: 0},
+		{in: "/33", bad: true},
+		{in: "//129", bad: true},
+		{in: "/x", bad: true},
+	}
+	for _, tt := range tests {
+		cidr4, cidr6, err := parseDualCIDR(tt.in)
+		if tt.bad {
+			if err == nil {
+				t.Errorf("parseDualCIDR(%q): no error", tt.in)
+			}
+			continue
+		}
+		if err != nil || cidr4 != tt.cidr4 || cidr6 != tt.cidr6 {
+			t.Errorf("parseDualCIDR(%q) = %d, %d, %v; want %d, %d", tt.in, cidr4, cidr6, err, tt.cidr4, tt.cidr6)
+		}
+	}
+}
+
+// The examples from RFC 7208 section 7.4
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		spec, want string
+	}{
+		{"%{s}", "strong-bad@email.example.com"},
+		{"%{o}", "email.example.com"},
+		{"%{d}", "email.example.com"},
+		{"%{d4}", "email.example.com"},
+		{"%{d3}", "email.example.com"},
+		{"%{d2}", "example.com"},
+		{"%{d1}", "com"},
+		{"%{dr}", "com.example.email"},
+		{"%{d2r}", "example.email"},
+		{"%{l}", "strong-bad"},
+		{"%{l-}", "strong.bad"},
+		{"%{lr}", "strong-bad"},
+		{"%{lr-}", "bad.strong"},
+		{"%{l1r-}", "strong"},
+		{"%{ir}.%{v}._spf.%{d2}", "3.2.0.192.in-addr._spf.example.com"},
+		{"%{lr-}.lp._spf.%{d2}", "bad.strong.lp._spf.example.com"},
+		{"%{lr-}.lp.%{ir}.%{v}._spf.%{d2}", "bad.strong.lp.3.2.0.192.in-addr._spf.example.com"},
+		{"%{ir}.%{v}.%{l1r-}.lp._spf.%{d2}", "3.2.0.192.in-addr.strong.lp._spf.example.com"},
+		{"%{d2}.trusted-domains.example.net", "example.com.trusted-domains.example.net"},
+		{"100%%_%_%-", "100%_ %20"},
+	}
+	c := &spfCheck{ip: net.ParseIP("192.0.2.3"), sender: "strong-bad@email.example.com"}
+	for _, tt := range tests {
+		got, err := c.expand(tt.spec, "email.example.com")
+		if err != nil || got != tt.want {
+			t.Errorf("expand(%q) = %q, %v; want %q", tt.spec, got, err, tt.want)
+		}
+	}
+
+	c.ip = net.ParseIP("2001:db8::cb01")
+	want := "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com"
+	if got, err := c.expand("%{ir}.%{v}._spf.%{d2}", "email.example.com"); err != nil || got != want {
+		t.Errorf("IPv6 expand = %q, %v; want %q", got, err, want)
+	}
+
+	for _, bad := range []string{"%", "%a", "%{}", "%{x}", "%{d"} {
+		if _, err := c.expand(bad, "email.example.com"); err == nil {
+			t.Errorf("expand(%q): no error", bad)
+		}
+	}
+}
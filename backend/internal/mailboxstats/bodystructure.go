@@ -0,0 +1,196 @@
+package mailboxstats
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// node is a parsed IMAP BODYSTRUCTURE value: a list, a string or NIL
+type node struct {
+	list   []node
+	isList bool
+	str    string
+	isNil  bool
+}
+
+// parseBodyStructure parses an IMAP BODYSTRUCTURE as doveadm prints it
+func parseBodyStructure(s string) (node, error) {
+	p := &bsParser{s: s}
+	n, err := p.value()
+	if err != nil {
+		return node{}, err
+	}
+	if !n.isList {
+		return node{}, fmt.Errorf("bodystructure isn't a list")
+	}
+	return n, nil
+}
+
+type bsParser struct {
+	s   string
+	pos int
+}
+
+func (p *bsParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\r' || p.s[p.pos] == '\n') {
+		p.pos++
+	}
+}
+
+func (p *bsParser) value() (node, error) {
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		return node{}, fmt.Errorf("unexpected end of bodystructure")
+	}
+	switch c := p.s[p.pos]; {
+	case c == '(':
+		p.pos++
+		n := node{isList: true}
+		for {
+			p.skipSpace()
+			if p.pos >= len(p.s) {
+				return node{}, fmt.Errorf("unterminated list")
+			}
+			if p.s[p.pos] == ')' {
+				p.pos++
+				return n, nil
+			}
+			child, err := p.value()
+			if err != nil {
+				return node{}, err
+			}
+			n.list = append(n.list, child)
+		}
+	case c == '"':
+		p.pos++
+		var b strings.Builder
+		for p.pos < len(p.s) && p.s[p.pos] != '"' {
+			if p.s[p.pos] == '\\' && p.pos+1 < len(p.s) {
+				p.pos++
+			}
+			b.WriteByte(p.s[p.pos])
+			p.pos++
+		}
+		if p.pos >= len(p.s) {
+			return node{}, fmt.Errorf("unterminated string")
+		}
+		p.pos++
+		return node{str: b.String()}, nil
+	case c == '{':
+		// A literal: {length} followed by a line break and length bytes
+		end := strings.IndexByte(p.s[p.pos:], '}')
+		if end < 0 {
+			return node{}, fmt.Errorf("unterminated literal")
+		}
+		length, err := strconv.Atoi(p.s[p.pos+1 : p.pos+end])
+		if err != nil {
+			return node{}, fmt.Errorf("bad literal length")
+		}
+		p.pos += end + 1
+		if strings.HasPrefix(p.s[p.pos:], "\r\n") {
+			p.pos += 2
+		} else if strings.HasPrefix(p.s[p.pos:], "\n") {
+			p.pos++
+		}
+		if p.pos+length > len(p.s) {
+			return node{}, fmt.Errorf("literal runs past the end")
+		}
+		n := node{str: p.s[p.pos : p.pos+length]}
+		p.pos += length
+		return n, nil
+	default:
+		start := p.pos
+		for p.pos < len(p.s) && !strings.ContainsRune(" ()\r\n", rune(p.s[p.pos])) {
+			p.pos++
+		}
+		atom := p.s[start:p.pos]
+		if strings.EqualFold(atom, "NIL") {
+			return node{isNil: true}, nil
+		}
+		return node{str: atom}, nil
+	}
+}
+
+// param returns a parameter from a body parameter list such as
+// ("name" "report.pdf")
+func param(params node, name string) string {
+	for i := 0; i+1 < len(params.list); i += 2 {
+		if strings.EqualFold(params.list[i].str, name) {
+			return params.list[i+1].str
+		}
+	}
+	return ""
+}
+
+type attachmentPart struct {
+	filename    string
+	contentType string
+	bytes       int64
+}
+
+// attachmentParts returns the attachments of a message from its
+// BODYSTRUCTURE. Sizes of base64 parts are their decoded size.
+func attachmentParts(bodystructure string) ([]attachmentPart, error) {
+	root, err := parseBodyStructure(bodystructure)
+	if err != nil {
+		return nil, err
+	}
+	var parts []attachmentPart
+	var walk func(n node)
+	walk = func(n node) {
+		if !n.isList || len(n.list) == 0 {
+			return
+		}
+		// Multipart: the sub-parts come first, then the subtype
+		if n.list[0].isList {
+			for _, child := range n.list {
+				if !child.isList {
+					break
+				}
+				walk(child)
+			}
+			return
+		}
+		if len(n.list) < 7 {
+			return
+		}
+		mediaType := strings.ToLower(n.list[0].str)
+		subtype := strings.ToLower(n.list[1].str)
+		size, _ := strconv.ParseInt(n.list[6].str, 10, 64)
+		if strings.EqualFold(n.list[5].str, "base64") {
+			size = size * 3 / 4
+		}
+
+		// Extension data follows the type-specific fields
+		ext := 7
+		switch {
+		case mediaType == "text":
+			ext = 8
+		case mediaType == "message" && subtype == "rfc822":
+			ext = 10
+		}
+
+		disposition := ""
+		filename := param(n.list[2], "name")
+		if len(n.list) > ext+1 && n.list[ext+1].isList && len(n.list[ext+1].list) > 0 {
+			d := n.list[ext+1]
+			disposition = strings.ToLower(d.list[0].str)
+			if len(d.list) > 1 {
+				if f := param(d.list[1], "filename"); f != "" {
+					filename = f
+				}
+			}
+		}
+
+		if disposition == "attachment" || (filename != "" && mediaType != "text") ||
+			(mediaType == "message" && subtype == "rfc822") {
+			if filename == "" && mediaType == "message" {
+				filename = "(forwarded message)"
+			}
+			parts = append(parts, attachmentPart{filename: filename, contentType: mediaType + "/" + subtype, bytes: size})
+		}
+	}
+	walk(root)
+	return parts, nil
+}
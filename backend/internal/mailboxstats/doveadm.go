@@ -0,0 +1,117 @@
+// Package mailboxstats reads mailbox folder sizes and large attachments
+// from Dovecot with doveadm, and samples each mailbox's size once a day so
+// its growth can be charted.
+package mailboxstats
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Folder is the size of one folder of a mailbox
+type Folder struct {
+	Name     string `json:"name"`
+	Messages int64  `json:"messages"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// Attachment is one attachment of a message
+type Attachment struct {
+	Folder      string `json:"folder"`
+	UID         string `json:"uid"`
+	Subject     string `json:"subject"`
+	Received    string `json:"received"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Bytes       int64  `json:"bytes"`
+}
+
+// attachmentScanMin is the smallest message searched for attachments;
+// smaller messages can't hold one worth reporting
+const attachmentScanMin = 512 * 1024
+
+// doveadmTab runs a doveadm command with tab-separated output and returns
+// its rows as maps of the header's field names
+func doveadmTab(args ...string) ([]map[string]string, error) {
+	cmd := exec.Command("doveadm", append([]string{"-f", "tab"}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("doveadm %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	var header []string
+	rows := []map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if header == nil {
+			header = fields
+			continue
+		}
+		row := map[string]string{}
+		for i, name := range header {
+			if i < len(fields) {
+				row[name] = fields[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// Folders returns the folders of a mailbox, largest first
+func Folders(email string) ([]Folder, error) {
+	rows, err := doveadmTab("mailbox", "status", "-u", email, "messages vsize", "*")
+	if err != nil {
+		return nil, err
+	}
+	folders := []Folder{}
+	for _, row := range rows {
+		f := Folder{Name: row["mailbox"]}
+		f.Messages, _ = strconv.ParseInt(row["messages"], 10, 64)
+		f.Bytes, _ = strconv.ParseInt(row["vsize"], 10, 64)
+		folders = append(folders, f)
+	}
+	sort.Slice(folders, func(i, j int) bool { return folders[i].Bytes > folders[j].Bytes })
+	return folders, nil
+}
+
+// LargestAttachments returns up to limit of a mailbox's largest attachments
+func LargestAttachments(email string, limit int) ([]Attachment, error) {
+	rows, err := doveadmTab("fetch", "-u", email, "mailbox uid date.received hdr.subject imap.bodystructure",
+		"mailbox", "*", "larger", strconv.Itoa(attachmentScanMin))
+	if err != nil {
+		return nil, err
+	}
+	attachments := []Attachment{}
+	for _, row := range rows {
+		parts, err := attachmentParts(row["imap.bodystructure"])
+		if err != nil {
+			continue
+		}
+		for _, p := range parts {
+			attachments = append(attachments, Attachment{
+				Folder:      row["mailbox"],
+				UID:         row["uid"],
+				Subject:     row["hdr.subject"],
+				Received:    row["date.received"],
+				Filename:    p.filename,
+				ContentType: p.contentType,
+				Bytes:       p.bytes,
+			})
+		}
+	}
+	sort.Slice(attachments, func(i, j int) bool { return attachments[i].Bytes > attachments[j].Bytes })
+	if len(attachments) > limit {
+		attachments = attachments[:limit]
+	}
+	return attachments, nil
+}
@@ -0,0 +1,160 @@
+package mailboxstats
+
+import (
+	"database/sql"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Sample is a mailbox's size on one day
+type Sample struct {
+	Date     string `json:"date"`
+	Bytes    int64  `json:"bytes"`
+	Messages int64  `json:"messages"`
+}
+
+// Sampler records each active mailbox's size once a day, and keeps
+// mailbox_quota up to date with it
+type Sampler struct {
+	db       *sql.DB
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewSampler creates a mailbox size sampler
+func NewSampler(db *sql.DB) *Sampler {
+	return &Sampler{
+		db:     db,
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start begins sampling
+func (s *Sampler) Start() {
+	s.done = make(chan struct{})
+	go s.loop()
+}
+
+// Stop stops sampling
+func (s *Sampler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+		if s.done != nil {
+			<-s.done
+		}
+	})
+}
+
+func (s *Sampler) loop() {
+	defer close(s.done)
+
+	// Sample shortly after startup if today has no samples yet, then once
+	// each day
+	timer := time.NewTimer(time.Minute)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case now := <-timer.C:
+			today := now.UTC().Format("2006-01-02")
+			var taken int
+			s.db.QueryRow(`SELECT COUNT(*) FROM mailbox_usage_samples WHERE sampled_on = ?`, today).Scan(&taken)
+			if taken == 0 {
+				s.sample(today)
+				s.prune(now)
+			}
+			timer.Reset(time.Hour)
+		}
+	}
+}
+
+func (s *Sampler) sample(today string) {
+	rows, err := s.db.Query(`SELECT id, email FROM mailboxes WHERE active = TRUE`)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to list mailboxes for size sampling")
+		return
+	}
+	type mailbox struct {
+		id    int64
+		email string
+	}
+	var mailboxes []mailbox
+	for rows.Next() {
+		var m mailbox
+		if rows.Scan(&m.id, &m.email) == nil {
+			mailboxes = append(mailboxes, m)
+		}
+	}
+	rows.Close()
+
+	sampled := 0
+	for _, m := range mailboxes {
+		select {
+		case <-s.stopCh:
+			return
+		default:
+		}
+		folders, err := Folders(m.email)
+		if err != nil {
+			log.Warn().Err(err).Str("email", m.email).Msg("Failed to read mailbox size")
+			continue
+		}
+		var bytes, messages int64
+		for _, f := range folders {
+			bytes += f.Bytes
+			messages += f.Messages
+		}
+		s.db.Exec(`
+			INSERT OR REPLACE INTO mailbox_usage_samples (mailbox_id, sampled_on, bytes, messages)
+			VALUES (?, ?, ?, ?)
+		`, m.id, today, bytes, messages)
+		s.db.Exec(`
+			INSERT OR REPLACE INTO mailbox_quota (mailbox_id, bytes_used, message_count, last_updated)
+			VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		`, m.id, bytes, messages)
+		sampled++
+	}
+	log.Info().Int("mailboxes", sampled).Msg("Mailbox sizes sampled")
+}
+
+// prune removes samples older than mailbox_growth_days
+func (s *Sampler) prune(now time.Time) {
+	days := 365
+	var value string
+	if err := s.db.QueryRow(`SELECT value FROM settings WHERE key = 'mailbox_growth_days'`).Scan(&value); err == nil {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			days = n
+		}
+	}
+	cutoff := now.UTC().AddDate(0, 0, -days).Format("2006-01-02")
+	s.db.Exec(`DELETE FROM mailbox_usage_samples WHERE sampled_on < ?`, cutoff)
+}
+
+// Growth returns a mailbox's daily samples since the given day, oldest
+// first
+func Growth(db *sql.DB, mailboxID int64, since time.Time) ([]Sample, error) {
+	rows, err := db.Query(`
+		SELECT sampled_on, bytes, messages FROM mailbox_usage_samples
+		WHERE mailbox_id = ? AND sampled_on >= ?
+		ORDER BY sampled_on
+	`, mailboxID, since.UTC().Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []Sample{}
+	for rows.Next() {
+		var sm Sample
+		if rows.Scan(&sm.Date, &sm.Bytes, &sm.Messages) == nil {
+			samples = append(samples, sm)
+		}
+	}
+	return samples, nil
+}
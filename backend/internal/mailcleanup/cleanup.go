@@ -0,0 +1,304 @@
+// Package mailcleanup applies per-domain policies that delete or archive
+// old mail in mailbox folders, such as emptying Trash after 30 days, with
+// doveadm once a day.
+package mailcleanup
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/retention"
+	"github.com/rs/zerolog/log"
+)
+
+// Policy actions
+const (
+	ActionDelete = "delete" // expunge matching messages
+	ActionMove   = "move"   // move matching messages to TargetFolder
+)
+
+// Policy cleans up messages in a folder saved more than OlderThanDays ago.
+// A policy without a domain applies to every domain; a domain's own policy
+// for the same folder replaces it there, and turns it off when disabled.
+type Policy struct {
+	ID            int64  `json:"id"`
+	DomainID      int64  `json:"domainId,omitempty"` // 0 for every domain
+	Domain        string `json:"domain,omitempty"`
+	Folder        string `json:"folder"`
+	Action        string `json:"action"`
+	TargetFolder  string `json:"targetFolder,omitempty"`
+	OlderThanDays int    `json:"olderThanDays"`
+	Enabled       bool   `json:"enabled"`
+}
+
+// Load returns every policy, global ones first
+func Load(db *sql.DB) ([]Policy, error) {
+	rows, err := db.Query(`
+		SELECT p.id, COALESCE(p.domain_id, 0), COALESCE(d.domain, ''), p.folder, p.action,
+			COALESCE(p.target_folder, ''), p.older_than_days, p.enabled
+		FROM mail_cleanup_policies p
+		LEFT JOIN mail_domains d ON p.domain_id = d.id
+		ORDER BY p.domain_id IS NOT NULL, d.domain, p.folder
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []Policy{}
+	for rows.Next() {
+		var p Policy
+		if err := rows.Scan(&p.ID, &p.DomainID, &p.Domain, &p.Folder, &p.Action, &p.TargetFolder, &p.OlderThanDays, &p.Enabled); err != nil {
+			continue
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// Effective returns the enabled policies for a domain after its overrides
+func Effective(policies []Policy, domainID int64) []Policy {
+	byFolder := map[string]Policy{}
+	var folders []string
+	for _, p := range policies {
+		if p.DomainID != 0 && p.DomainID != domainID {
+			continue
+		}
+		existing, ok := byFolder[p.Folder]
+		if !ok {
+			folders = append(folders, p.Folder)
+		}
+		if !ok || existing.DomainID == 0 {
+			byFolder[p.Folder] = p
+		}
+	}
+	effective := []Policy{}
+	for _, folder := range folders {
+		if p := byFolder[folder]; p.Enabled {
+			effective = append(effective, p)
+		}
+	}
+	return effective
+}
+
+// Action is what a policy did, or in a dry run would do, to one mailbox
+type Action struct {
+	Email        string `json:"email"`
+	PolicyID     int64  `json:"policyId"`
+	Folder       string `json:"folder"`
+	Action       string `json:"action"`
+	TargetFolder string `json:"targetFolder,omitempty"`
+	Messages     int    `json:"messages"`
+}
+
+// Result is one cleanup run
+type Result struct {
+	RanAt   time.Time `json:"ranAt"`
+	DryRun  bool      `json:"dryRun"`
+	Actions []Action  `json:"actions"`
+	Held    []string  `json:"held,omitempty"` // mailboxes skipped for a legal hold
+	Errors  []string  `json:"errors,omitempty"`
+}
+
+// query is the doveadm search query for a policy's messages
+func (p Policy) query() []string {
+	return []string{"mailbox", p.Folder, "savedbefore", strconv.Itoa(p.OlderThanDays) + "d"}
+}
+
+func doveadm(args ...string) ([]byte, error) {
+	cmd := exec.Command("doveadm", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("doveadm %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// count returns how many messages of a mailbox a policy matches
+func count(email string, p Policy) (int, error) {
+	out, err := doveadm(append([]string{"search", "-u", email}, p.query()...)...)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) != "" {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// apply deletes or moves the messages a policy matches
+func apply(email string, p Policy) error {
+	if p.Action == ActionMove {
+		// Creating a folder that exists fails harmlessly
+		doveadm("mailbox", "create", "-u", email, p.TargetFolder)
+		_, err := doveadm(append([]string{"move", "-u", email, p.TargetFolder}, p.query()...)...)
+		return err
+	}
+	_, err := doveadm(append([]string{"expunge", "-u", email}, p.query()...)...)
+	return err
+}
+
+// Run applies the policies to every active mailbox, or only reports what
+// they match when dryRun is set. Mailboxes under legal hold are skipped.
+func Run(db *sql.DB, dryRun bool, now time.Time) *Result {
+	result := &Result{RanAt: now.UTC(), DryRun: dryRun, Actions: []Action{}}
+	policies, err := Load(db)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result
+	}
+
+	rows, err := db.Query(`
+		SELECT m.email, m.domain_id FROM mailboxes m
+		JOIN mail_domains d ON m.domain_id = d.id
+		WHERE m.active = TRUE AND d.active = TRUE
+		ORDER BY m.email
+	`)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result
+	}
+	type mailbox struct {
+		email    string
+		domainID int64
+	}
+	var mailboxes []mailbox
+	for rows.Next() {
+		var m mailbox
+		if rows.Scan(&m.email, &m.domainID) == nil {
+			mailboxes = append(mailboxes, m)
+		}
+	}
+	rows.Close()
+
+	for _, m := range mailboxes {
+		effective := Effective(policies, m.domainID)
+		if len(effective) == 0 {
+			continue
+		}
+		if retention.MailboxHeld(db, m.email) {
+			result.Held = append(result.Held, m.email)
+			continue
+		}
+		for _, p := range effective {
+			n, err := count(m.email, p)
+			if err != nil {
+				result.Errors = append(result.Errors, m.email+": "+err.Error())
+				continue
+			}
+			if n == 0 {
+				continue
+			}
+			if !dryRun {
+				if err := apply(m.email, p); err != nil {
+					result.Errors = append(result.Errors, m.email+": "+err.Error())
+					continue
+				}
+			}
+			result.Actions = append(result.Actions, Action{
+				Email: m.email, PolicyID: p.ID, Folder: p.Folder, Action: p.Action, TargetFolder: p.TargetFolder, Messages: n,
+			})
+		}
+	}
+	return result
+}
+
+// Scheduler runs the cleanup once a day
+type Scheduler struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	last    *Result
+	lastDay string
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewScheduler creates a cleanup scheduler
+func NewScheduler(db *sql.DB) *Scheduler {
+	return &Scheduler{
+		db:     db,
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start begins the daily runs
+func (s *Scheduler) Start() {
+	s.done = make(chan struct{})
+	go s.loop()
+}
+
+// Stop stops the daily runs
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+		if s.done != nil {
+			<-s.done
+		}
+	})
+}
+
+func (s *Scheduler) loop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case now := <-ticker.C:
+			// Run at the first tick after 03:00 each day, when mail is quiet
+			day := now.Format("2006-01-02")
+			if now.Hour() < 3 || day == s.lastDay {
+				continue
+			}
+			s.lastDay = day
+			result := Run(s.db, false, now)
+			for _, e := range result.Errors {
+				log.Warn().Str("error", e).Msg("Mail cleanup failed for a mailbox")
+			}
+			log.Info().Int("actions", len(result.Actions)).Msg("Mail cleanup finished")
+			s.record(result)
+		}
+	}
+}
+
+// record keeps a run as the last one
+func (s *Scheduler) record(result *Result) {
+	s.mu.Lock()
+	s.last = result
+	s.mu.Unlock()
+}
+
+// LastRun returns the most recent run that changed mail, or nil
+func (s *Scheduler) LastRun() *Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// RunNow runs the cleanup immediately. Real runs are kept as the last run.
+func (s *Scheduler) RunNow(dryRun bool) *Result {
+	result := Run(s.db, dryRun, time.Now())
+	if !dryRun {
+		s.record(result)
+	}
+	return result
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RequestDurations times API requests by method, route and status code.
+// Routes are chi patterns such as /api/v1/queue/messages/{queueId}, so
+// IDs in paths don't add series.
+type RequestDurations struct {
+	hist *HistogramVec
+}
+
+// NewRequestDurations creates the API latency histogram
+func NewRequestDurations() *RequestDurations {
+	return &RequestDurations{hist: NewHistogramVec("postfixrelay_api_request_duration_seconds",
+		"API request latency, by method, route and status code.", DefaultBuckets, "method", "route", "code")}
+}
+
+// Middleware times requests under /api/. Log streams and WebSocket
+// upgrades stay open for as long as the client watches, so they aren't
+// timed.
+func (d *RequestDurations) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || r.Header.Get("Upgrade") != "" ||
+			strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		d.hist.Observe(time.Since(start).Seconds(), r.Method, route, strconv.Itoa(status))
+	})
+}
+
+// Write writes the histogram
+func (d *RequestDurations) Write(w *Writer) {
+	d.hist.Write(w)
+}
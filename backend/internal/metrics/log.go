@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"strings"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+)
+
+// tlsFailureMarkers are the log texts of a failed TLS handshake, on the
+// smtpd side and the smtp side
+var tlsFailureMarkers = []string{
+	"SSL_accept error",
+	"SSL_connect error",
+	"TLS handshake failed",
+	"Cannot start TLS",
+	"TLS library problem",
+}
+
+// LogCounters count deliveries, SASL authentication failures and TLS
+// handshake failures seen in the mail log since the service started
+type LogCounters struct {
+	deliveries   *CounterVec
+	authFailures *CounterVec
+	tlsFailures  *CounterVec
+}
+
+// NewLogCounters creates the counters, with every delivery status present
+// from the start so rate() has a series to work with
+func NewLogCounters() *LogCounters {
+	c := &LogCounters{
+		deliveries: NewCounterVec("postfixrelay_deliveries_total",
+			"Delivery attempts logged, by status.", "status"),
+		authFailures: NewCounterVec("postfixrelay_auth_failures_total",
+			"SASL authentication failures logged by smtpd."),
+		tlsFailures: NewCounterVec("postfixrelay_tls_failures_total",
+			"TLS handshake failures logged, by direction (inbound for smtpd, outbound for smtp).", "direction"),
+	}
+	for _, status := range []string{"sent", "bounced", "deferred"} {
+		c.deliveries.Add(0, status)
+	}
+	c.authFailures.Add(0)
+	c.tlsFailures.Add(0, "inbound")
+	c.tlsFailures.Add(0, "outbound")
+	return c
+}
+
+// Consume counts one log entry
+func (c *LogCounters) Consume(e logs.Entry) {
+	switch e.Status {
+	case "sent", "bounced", "deferred":
+		c.deliveries.Inc(e.Status)
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(e.Process, "smtpd"):
+		if strings.Contains(e.Message, "SASL") && strings.Contains(e.Message, "authentication failed") {
+			c.authFailures.Inc()
+		} else if isTLSFailure(e.Message) {
+			c.tlsFailures.Inc("inbound")
+		}
+	case e.Process == "smtp" || strings.HasSuffix(e.Process, "/smtp"):
+		if isTLSFailure(e.Message) {
+			c.tlsFailures.Inc("outbound")
+		}
+	}
+}
+
+func isTLSFailure(msg string) bool {
+	for _, m := range tlsFailureMarkers {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write writes the counters
+func (c *LogCounters) Write(w *Writer) {
+	c.deliveries.Write(w)
+	c.authFailures.Write(w)
+	c.tlsFailures.Write(w)
+}
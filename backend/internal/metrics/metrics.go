@@ -0,0 +1,251 @@
+// Package metrics exposes PostfixRelay's figures in the Prometheus text
+// exposition format (version 0.0.4), for scraping from an existing
+// monitoring stack.
+//
+// There is no client library behind it: counters fed from the mail log
+// and API latencies are kept here, and everything sampled at scrape time,
+// such as queue depth, is written by the caller through a Writer.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types
+const (
+	Counter   = "counter"
+	Gauge     = "gauge"
+	Histogram = "histogram"
+)
+
+// Label is a label name and value
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Writer writes metric families. Each family starts with Family and is
+// followed by its samples. The first write error is kept and returned by
+// Flush.
+type Writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+// NewWriter creates a writer on w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Family starts a metric family with its help text and type
+func (w *Writer) Family(name, help, typ string) {
+	w.printf("# HELP ", name, " ", escapeHelp(help), "\n")
+	w.printf("# TYPE ", name, " ", typ, "\n")
+}
+
+// Sample writes one sample of the current family
+func (w *Writer) Sample(name string, value float64, labels ...Label) {
+	w.printf(name)
+	if len(labels) > 0 {
+		w.printf("{")
+		for i, l := range labels {
+			if i > 0 {
+				w.printf(",")
+			}
+			w.printf(l.Name, `="`, escapeLabel(l.Value), `"`)
+		}
+		w.printf("}")
+	}
+	w.printf(" ", formatValue(value), "\n")
+}
+
+// Flush writes out what is buffered and returns the first error
+func (w *Writer) Flush() error {
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	return w.err
+}
+
+func (w *Writer) printf(parts ...string) {
+	for _, p := range parts {
+		if w.err != nil {
+			return
+		}
+		_, w.err = w.w.WriteString(p)
+	}
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// CounterVec is a counter family partitioned by label values
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec creates a counter family with the given label names
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+// Add adds delta to the counter with the given label values, which are in
+// the order of the label names
+func (c *CounterVec) Add(delta float64, values ...string) {
+	key := seriesKey(values)
+	c.mu.Lock()
+	c.values[key] += delta
+	c.mu.Unlock()
+}
+
+// Inc adds one to the counter with the given label values
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Write writes the family, its series sorted by label values
+func (c *CounterVec) Write(w *Writer) {
+	c.mu.Lock()
+	keys := sortedKeys(c.values)
+	values := make([]float64, len(keys))
+	for i, k := range keys {
+		values[i] = c.values[k]
+	}
+	c.mu.Unlock()
+
+	w.Family(c.name, c.help, Counter)
+	for i, k := range keys {
+		w.Sample(c.name, values[i], labelPairs(c.labels, k)...)
+	}
+}
+
+// DefaultBuckets are the latency buckets, in seconds, of API requests
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// HistogramVec is a histogram family partitioned by label values
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec creates a histogram family with the given upper bounds,
+// in increasing order, and label names
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+}
+
+// Observe records one value in the series with the given label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := seriesKey(values)
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[key]
+	if s == nil {
+		s = &histogram{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[i]++
+	s.count++
+	s.sum += v
+}
+
+// Write writes the family with cumulative buckets, its series sorted by
+// label values
+func (h *HistogramVec) Write(w *Writer) {
+	h.mu.Lock()
+	keys := sortedKeys(h.series)
+	series := make([]histogram, len(keys))
+	for i, k := range keys {
+		s := h.series[k]
+		series[i] = histogram{counts: append([]uint64(nil), s.counts...), count: s.count, sum: s.sum}
+	}
+	h.mu.Unlock()
+
+	w.Family(h.name, h.help, Histogram)
+	for i, k := range keys {
+		labels := labelPairs(h.labels, k)
+		var cumulative uint64
+		for b, count := range series[i].counts {
+			cumulative += count
+			le := math.Inf(1)
+			if b < len(h.buckets) {
+				le = h.buckets[b]
+			}
+			w.Sample(h.name+"_bucket", float64(cumulative), append(labels, Label{"le", formatValue(le)})...)
+		}
+		w.Sample(h.name+"_sum", series[i].sum, labels...)
+		w.Sample(h.name+"_count", float64(series[i].count), labels...)
+	}
+}
+
+// seriesKey joins label values into a map key; \xff can't occur in UTF-8
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func labelPairs(names []string, key string) []Label {
+	if len(names) == 0 {
+		return nil
+	}
+	values := strings.Split(key, "\xff")
+	labels := make([]Label, len(names), len(names)+1)
+	for i, name := range names {
+		if i < len(values) {
+			labels[i] = Label{name, values[i]}
+		} else {
+			labels[i] = Label{Name: name}
+		}
+	}
+	return labels
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,96 @@
+package postfix
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// backscatterRestriction is what the backscatter map returns for a
+// protected domain: the recipient is probed before the mail is accepted,
+// so unknown recipients are rejected in the SMTP session instead of being
+// bounced to a possibly forged sender later
+const backscatterRestriction = "reject_unverified_recipient"
+
+// SaveBackscatterDomains writes the map of domains whose recipients are
+// verified before mail is accepted, and adds its lookup to
+// smtpd_recipient_restrictions. With no domains the lookup is removed.
+func (m *ConfigManager) SaveBackscatterDomains(domains []string) error {
+	m.mu.Lock()
+	path := filepath.Join(m.configDir, "backscatter")
+	mapType := MapType("backscatter")
+
+	var content strings.Builder
+	content.WriteString("# Backscatter protection - Managed by PostfixRelay\n")
+	content.WriteString("# Format: domain " + backscatterRestriction + "\n\n")
+	for _, domain := range domains {
+		fmt.Fprintf(&content, "%s %s\n", MapKey(mapType, domain), backscatterRestriction)
+	}
+
+	err := os.WriteFile(path, []byte(content.String()), 0644)
+	if err == nil {
+		err = CompileMap(mapType, path, true)
+	}
+	params, parseErr := m.parseMainCf(filepath.Join(m.configDir, "main.cf"))
+	m.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to write backscatter map: %w", err)
+	}
+	if parseErr != nil {
+		return fmt.Errorf("failed to read config: %w", parseErr)
+	}
+
+	ref := ""
+	if len(domains) > 0 {
+		ref = MapRef(mapType, path)
+	}
+	current := params["smtpd_recipient_restrictions"]
+	if updated := withRecipientAccess(current, path, ref); updated != current {
+		return m.UpdateConfig(map[string]string{"smtpd_recipient_restrictions": updated})
+	}
+	return nil
+}
+
+// withRecipientAccess returns a restriction list with its
+// check_recipient_access lookup of the map file at path replaced by one of
+// ref, placed first, or removed if ref is "". A list that needs no change
+// is returned as it is.
+func withRecipientAccess(restrictions, path, ref string) string {
+	fields := strings.FieldsFunc(restrictions, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+
+	// Restrictions that take a table are kept together with it
+	var items, others []string
+	for i := 0; i < len(fields); i++ {
+		item := fields[i]
+		if strings.HasPrefix(item, "check_") && i+1 < len(fields) && strings.Contains(fields[i+1], ":") {
+			item += " " + fields[i+1]
+			i++
+		}
+		items = append(items, item)
+		if !strings.HasPrefix(item, "check_recipient_access ") || !strings.HasSuffix(item, ":"+path) {
+			others = append(others, item)
+		}
+	}
+
+	updated := others
+	if ref != "" {
+		updated = append([]string{"check_recipient_access " + ref}, others...)
+	}
+	if strings.Join(updated, ", ") == strings.Join(items, ", ") {
+		return restrictions
+	}
+	return strings.Join(updated, ", ")
+}
+
+// SetSoftBounce turns soft_bounce on or off. With it on, Postfix defers
+// mail it would otherwise bounce or reject, which is meant for testing
+// changes without losing mail.
+func (m *ConfigManager) SetSoftBounce(enabled bool) error {
+	value := ""
+	if enabled {
+		value = "yes"
+	}
+	return m.UpdateConfig(map[string]string{"soft_bounce": value})
+}
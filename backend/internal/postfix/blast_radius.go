@@ -0,0 +1,128 @@
+package postfix
+
+import "sort"
+
+// How a parameter change takes effect
+const (
+	ApplyReload  = "reload"  // postfix reload
+	ApplyRestart = "restart" // postfix stop and start
+)
+
+// Mail flows a parameter change can affect
+const (
+	FlowInbound  = "inbound"  // mail received by smtpd and delivered to mailboxes
+	FlowOutbound = "outbound" // mail sent on by the SMTP client
+)
+
+// BlastRadius is the expected impact of changing a parameter
+type BlastRadius struct {
+	Parameter string `json:"parameter"`
+	// Known is false for parameters missing from the metadata table, which
+	// are assumed to need a reload and to affect both flows
+	Known bool     `json:"known"`
+	Apply string   `json:"apply"`
+	Flows []string `json:"flows"`
+	// DropsConnections is set when applying the change ends SMTP sessions
+	// in progress. A reload lets them finish; a restart does not.
+	DropsConnections bool   `json:"dropsConnections"`
+	Note             string `json:"note,omitempty"`
+}
+
+var (
+	flowsIn   = []string{FlowInbound}
+	flowsOut  = []string{FlowOutbound}
+	flowsBoth = []string{FlowInbound, FlowOutbound}
+)
+
+// parameterRadius describes the parameters this service manages
+var parameterRadius = map[string]BlastRadius{
+	"myhostname": {Apply: ApplyReload, Flows: flowsBoth,
+		Note: "changes the SMTP banner and the HELO name remote servers check"},
+	"mydomain": {Apply: ApplyReload, Flows: flowsBoth,
+		Note: "mydestination and myorigin default to values derived from it"},
+	"myorigin":        {Apply: ApplyReload, Flows: flowsOut, Note: "rewrites unqualified sender addresses"},
+	"inet_interfaces": {Apply: ApplyRestart, Flows: flowsBoth, Note: "smtpd stops listening on addresses no longer listed"},
+	"inet_protocols":  {Apply: ApplyRestart, Flows: flowsBoth, Note: "turning off IPv4 or IPv6 loses the peers only reachable over it"},
+
+	"relayhost": {Apply: ApplyReload, Flows: flowsOut,
+		Note: "deferred mail is retried through the new next hop"},
+	"mynetworks": {Apply: ApplyReload, Flows: flowsIn,
+		Note: "clients dropped from the list can no longer relay without authenticating"},
+	"relay_domains": {Apply: ApplyReload, Flows: flowsIn,
+		Note: "mail for domains removed from the list is rejected as relay access denied"},
+
+	"smtp_tls_security_level": {Apply: ApplyReload, Flows: flowsOut,
+		Note: "a stricter level defers mail to servers that can't meet it"},
+	"smtp_tls_cert_file": {Apply: ApplyReload, Flows: flowsOut},
+	"smtp_tls_key_file":  {Apply: ApplyReload, Flows: flowsOut},
+	"smtp_tls_CAfile":    {Apply: ApplyReload, Flows: flowsOut, Note: "changes which server certificates verify"},
+	"smtp_tls_loglevel":  {Apply: ApplyReload, Flows: flowsOut, Note: "logging only"},
+	"smtpd_tls_security_level": {Apply: ApplyReload, Flows: flowsIn,
+		Note: "encrypt rejects clients that don't use STARTTLS"},
+	"smtpd_tls_cert_file": {Apply: ApplyReload, Flows: flowsIn, Note: "clients see the new certificate on their next connection"},
+	"smtpd_tls_key_file":  {Apply: ApplyReload, Flows: flowsIn},
+
+	"smtp_sasl_auth_enable":          {Apply: ApplyReload, Flows: flowsOut, Note: "relays that require AUTH defer mail without it"},
+	"smtp_sasl_password_maps":        {Apply: ApplyReload, Flows: flowsOut},
+	"smtp_sasl_security_options":     {Apply: ApplyReload, Flows: flowsOut},
+	"smtp_sasl_tls_security_options": {Apply: ApplyReload, Flows: flowsOut},
+
+	"smtpd_relay_restrictions": {Apply: ApplyReload, Flows: flowsIn,
+		Note: "a mistake here can reject all mail or open the relay"},
+	"smtpd_recipient_restrictions": {Apply: ApplyReload, Flows: flowsIn},
+	"smtpd_sender_restrictions":    {Apply: ApplyReload, Flows: flowsIn},
+
+	"virtual_transport": {Apply: ApplyReload, Flows: flowsIn,
+		Note: "mailbox delivery defers if the new transport is unreachable"},
+	"mailbox_size_limit":               {Apply: ApplyReload, Flows: flowsIn},
+	"lmtp_destination_recipient_limit": {Apply: ApplyReload, Flows: flowsIn},
+
+	"smtpd_sasl_auth_enable": {Apply: ApplyReload, Flows: flowsIn,
+		Note: "mail clients submitting with AUTH are refused when it is off"},
+	"smtpd_sasl_type":                 {Apply: ApplyReload, Flows: flowsIn},
+	"smtpd_sasl_path":                 {Apply: ApplyReload, Flows: flowsIn},
+	"smtpd_sasl_security_options":     {Apply: ApplyReload, Flows: flowsIn},
+	"smtpd_sasl_tls_security_options": {Apply: ApplyReload, Flows: flowsIn},
+	"smtpd_tls_auth_only":             {Apply: ApplyReload, Flows: flowsIn, Note: "clients that authenticate before STARTTLS are refused"},
+
+	"bounce_template_file": {Apply: ApplyReload, Flows: flowsOut, Note: "changes the wording of bounces and delay notices"},
+}
+
+// ParameterBlastRadius looks up the impact of changing a parameter
+func ParameterBlastRadius(name string) BlastRadius {
+	br, ok := parameterRadius[name]
+	if !ok {
+		return BlastRadius{Parameter: name, Apply: ApplyReload, Flows: flowsBoth}
+	}
+	br.Parameter, br.Known = name, true
+	br.DropsConnections = br.Apply == ApplyRestart
+	return br
+}
+
+// CombinedBlastRadius is the impact of applying several changes at once
+type CombinedBlastRadius struct {
+	Apply            string   `json:"apply"`
+	Flows            []string `json:"flows"`
+	DropsConnections bool     `json:"dropsConnections"`
+}
+
+// CombineBlastRadius sums up the impact of a set of changes: a restart if
+// any needs one, and every flow any of them affects
+func CombineBlastRadius(radii []BlastRadius) CombinedBlastRadius {
+	combined := CombinedBlastRadius{Apply: ApplyReload, Flows: []string{}}
+	flows := map[string]bool{}
+	for _, br := range radii {
+		if br.Apply == ApplyRestart {
+			combined.Apply = ApplyRestart
+		}
+		combined.DropsConnections = combined.DropsConnections || br.DropsConnections
+		for _, f := range br.Flows {
+			if !flows[f] {
+				flows[f] = true
+				combined.Flows = append(combined.Flows, f)
+			}
+		}
+	}
+	sort.Strings(combined.Flows)
+	return combined
+}
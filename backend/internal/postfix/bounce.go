@@ -0,0 +1,341 @@
+package postfix
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// BounceTemplateClasses are the notification classes bounce_template_file
+// can customise
+var BounceTemplateClasses = []string{"failure", "delay", "success", "verify"}
+
+// BounceTemplate is the text of one class of delivery status notification
+type BounceTemplate struct {
+	Class             string `json:"class"`
+	From              string `json:"from"`
+	Subject           string `json:"subject"`
+	PostmasterSubject string `json:"postmasterSubject,omitempty"` // failure and delay only
+	Body              string `json:"body"`
+}
+
+// HasPostmasterSubject reports whether Postfix sends a postmaster copy of
+// the class, and so accepts a Postmaster-Subject for it
+func HasPostmasterSubject(class string) bool {
+	return class == "failure" || class == "delay"
+}
+
+const bounceSignature = "\n\n                   The mail system\n"
+
+// defaultBounceTemplates are Postfix's built-in templates, from
+// bounce.cf.default
+var defaultBounceTemplates = map[string]BounceTemplate{
+	"failure": {
+		Subject:           "Undelivered Mail Returned to Sender",
+		PostmasterSubject: "Postmaster Copy: Undelivered Mail",
+		Body: "This is the mail system at host $myhostname.\n\n" +
+			"I'm sorry to have to inform you that your message could not\n" +
+			"be delivered to one or more recipients. It's attached below.\n\n" +
+			"For further assistance, please send mail to postmaster.\n\n" +
+			"If you do so, please include this problem report. You can\n" +
+			"delete your own text from the attached returned message." + bounceSignature,
+	},
+	"delay": {
+		Subject:           "Delayed Mail (still being retried)",
+		PostmasterSubject: "Postmaster Warning: Delayed Mail",
+		Body: "This is the mail system at host $myhostname.\n\n" +
+			"####################################################################\n" +
+			"# THIS IS A WARNING ONLY.  YOU DO NOT NEED TO RESEND YOUR MESSAGE. #\n" +
+			"####################################################################\n\n" +
+			"Your message could not be delivered for more than $delay_warning_time_hours hour(s).\n" +
+			"It will be retried until it is $maximal_queue_lifetime_days day(s) old.\n\n" +
+			"For further assistance, please send mail to postmaster.\n\n" +
+			"If you do so, please include this problem report. You can\n" +
+			"delete your own text from the attached returned message." + bounceSignature,
+	},
+	"success": {
+		Subject: "Successful Mail Delivery Report",
+		Body: "This is the mail system at host $myhostname.\n\n" +
+			"Your message was successfully delivered to the destination(s)\n" +
+			"listed below. If the message was delivered to mailbox you will\n" +
+			"receive no further notifications. Otherwise you may still receive\n" +
+			"notifications of mail delivery errors from other systems." + bounceSignature,
+	},
+	"verify": {
+		Subject: "Mail Delivery Status Report",
+		Body: "This is the mail system at host $myhostname.\n\n" +
+			"Enclosed is the mail delivery report that you requested." + bounceSignature,
+	},
+}
+
+// DefaultBounceTemplate returns Postfix's built-in template for a class
+func DefaultBounceTemplate(class string) BounceTemplate {
+	t := defaultBounceTemplates[class]
+	t.Class = class
+	t.From = "MAILER-DAEMON (Mail Delivery System)"
+	return t
+}
+
+// ValidateBounceTemplate returns what is wrong with a template, if anything
+func ValidateBounceTemplate(t BounceTemplate) error {
+	if _, ok := defaultBounceTemplates[t.Class]; !ok {
+		return fmt.Errorf("unknown notification class %q", t.Class)
+	}
+	for _, header := range []string{t.From, t.Subject, t.PostmasterSubject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return fmt.Errorf("headers must be a single line")
+		}
+	}
+	if t.PostmasterSubject != "" && !HasPostmasterSubject(t.Class) {
+		return fmt.Errorf("%s notifications have no postmaster copy", t.Class)
+	}
+	for _, line := range strings.Split(t.Body, "\n") {
+		if strings.TrimSpace(line) == "EOF" {
+			return fmt.Errorf("the body cannot contain a line with only EOF")
+		}
+	}
+	return nil
+}
+
+// RenderBounceTemplates returns the bounce_template_file content for the
+// given templates. Classes without a template keep Postfix's default.
+func RenderBounceTemplates(templates []BounceTemplate) string {
+	var content strings.Builder
+	content.WriteString("# Bounce templates - Managed by PostfixRelay\n")
+
+	for _, t := range templates {
+		charset := "us-ascii"
+		for _, r := range t.From + t.Subject + t.PostmasterSubject + t.Body {
+			if r > unicode.MaxASCII {
+				charset = "utf-8"
+				break
+			}
+		}
+
+		fmt.Fprintf(&content, "\n%s_template = <<EOF\n", t.Class)
+		fmt.Fprintf(&content, "Charset: %s\n", charset)
+		fmt.Fprintf(&content, "From: %s\n", t.From)
+		fmt.Fprintf(&content, "Subject: %s\n", t.Subject)
+		if t.PostmasterSubject != "" && HasPostmasterSubject(t.Class) {
+			fmt.Fprintf(&content, "Postmaster-Subject: %s\n", t.PostmasterSubject)
+		}
+		content.WriteString("\n")
+		content.WriteString(strings.TrimRight(t.Body, "\n"))
+		content.WriteString("\nEOF\n")
+	}
+	return content.String()
+}
+
+// BounceTemplatePath returns the file bounce_template_file points at
+func (m *ConfigManager) BounceTemplatePath() string {
+	return filepath.Join(m.configDir, "bounce.cf")
+}
+
+// WriteBounceTemplates writes the template file and points main.cf at it.
+// With no templates the file is removed and Postfix's defaults apply.
+func (m *ConfigManager) WriteBounceTemplates(templates []BounceTemplate) error {
+	path := m.BounceTemplatePath()
+	if len(templates) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove bounce templates: %w", err)
+		}
+		return m.UpdateConfig(map[string]string{"bounce_template_file": ""})
+	}
+
+	m.mu.Lock()
+	err := os.WriteFile(path, []byte(RenderBounceTemplates(templates)), 0644)
+	m.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to write bounce templates: %w", err)
+	}
+	return m.UpdateConfig(map[string]string{"bounce_template_file": path})
+}
+
+// PreviewBounceTemplates returns the change WriteBounceTemplates would make
+// to the template file
+func (m *ConfigManager) PreviewBounceTemplates(templates []BounceTemplate) (FileChange, error) {
+	content := ""
+	if len(templates) > 0 {
+		content = RenderBounceTemplates(templates)
+	}
+	return DiffFile(m.BounceTemplatePath(), content)
+}
+
+// bounceVariable matches $name, ${name} and $(name) references
+var bounceVariable = regexp.MustCompile(`\$(?:\{(\w+)\}|\((\w+)\)|(\w+))`)
+
+// bounceTimeParameters can be used in templates with a _seconds, _minutes,
+// _hours, _days or _weeks suffix, giving the value in that unit
+var bounceTimeParameters = map[string]string{
+	"delay_warning_time":     "0h",
+	"maximal_backoff_time":   "4000s",
+	"maximal_queue_lifetime": "5d",
+	"minimal_backoff_time":   "300s",
+	"queue_run_delay":        "300s",
+}
+
+var timeUnits = []struct {
+	suffix  string
+	seconds int64
+}{
+	{"_seconds", 1},
+	{"_minutes", 60},
+	{"_hours", 3600},
+	{"_days", 86400},
+	{"_weeks", 604800},
+}
+
+// BounceTemplateVariables returns the parameters a template refers to
+func BounceTemplateVariables(t BounceTemplate) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, text := range []string{t.From, t.Subject, t.PostmasterSubject, t.Body} {
+		for _, m := range bounceVariable.FindAllStringSubmatch(text, -1) {
+			name := m[1] + m[2] + m[3]
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UnknownBounceVariables returns the variables in a template that are
+// neither Postfix parameters nor time parameters with a unit suffix
+func UnknownBounceVariables(t BounceTemplate, parameters map[string]bool) []string {
+	var unknown []string
+	for _, name := range BounceTemplateVariables(t) {
+		if parameters[name] {
+			continue
+		}
+		if base, _ := splitTimeSuffix(name); base != "" {
+			continue
+		}
+		unknown = append(unknown, name)
+	}
+	return unknown
+}
+
+// splitTimeSuffix splits a name such as delay_warning_time_hours into the
+// time parameter and the unit's length in seconds, or returns "" if it
+// isn't one
+func splitTimeSuffix(name string) (string, int64) {
+	for _, unit := range timeUnits {
+		if base, ok := strings.CutSuffix(name, unit.suffix); ok {
+			if _, known := bounceTimeParameters[base]; known {
+				return base, unit.seconds
+			}
+		}
+	}
+	return "", 0
+}
+
+// parseTimeValue converts a Postfix time value such as 4h or 300 (seconds)
+// to seconds
+func parseTimeValue(value string) (int64, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	unit := int64(1)
+	switch value[len(value)-1] {
+	case 's':
+		value = value[:len(value)-1]
+	case 'm':
+		unit, value = 60, value[:len(value)-1]
+	case 'h':
+		unit, value = 3600, value[:len(value)-1]
+	case 'd':
+		unit, value = 86400, value[:len(value)-1]
+	case 'w':
+		unit, value = 604800, value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n * unit, true
+}
+
+var (
+	parameterNamesMu sync.Mutex
+	parameterNames   map[string]bool
+)
+
+// ParameterNames returns the names of all parameters this Postfix knows,
+// from postconf -d. The result is cached once it succeeds.
+func ParameterNames() (map[string]bool, error) {
+	parameterNamesMu.Lock()
+	defer parameterNamesMu.Unlock()
+
+	if parameterNames != nil {
+		return parameterNames, nil
+	}
+	output, err := exec.Command("postconf", "-d").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run postconf -d: %w", err)
+	}
+	names := map[string]bool{}
+	for _, line := range strings.Split(string(output), "\n") {
+		if name, _, ok := strings.Cut(line, " = "); ok {
+			names[strings.TrimSpace(name)] = true
+		}
+	}
+	parameterNames = names
+	return names, nil
+}
+
+// ExpandBounceTemplate returns a template with its variables replaced by
+// the values in main.cf, as Postfix would when sending the notification.
+// Unset parameters expand to nothing, apart from myhostname, which falls
+// back to the host name, and the time parameters, which use Postfix's
+// defaults.
+func (m *ConfigManager) ExpandBounceTemplate(t BounceTemplate) (BounceTemplate, error) {
+	m.mu.RLock()
+	params, err := m.parseMainCf(filepath.Join(m.configDir, "main.cf"))
+	m.mu.RUnlock()
+	if err != nil {
+		return t, fmt.Errorf("failed to read config: %w", err)
+	}
+	if params["myhostname"] == "" {
+		params["myhostname"], _ = os.Hostname()
+	}
+	for name, value := range bounceTimeParameters {
+		if params[name] == "" {
+			params[name] = value
+		}
+	}
+
+	var expand func(text string, depth int) string
+	expand = func(text string, depth int) string {
+		return bounceVariable.ReplaceAllStringFunc(text, func(ref string) string {
+			match := bounceVariable.FindStringSubmatch(ref)
+			name := match[1] + match[2] + match[3]
+			if base, unit := splitTimeSuffix(name); base != "" {
+				if seconds, ok := parseTimeValue(expand(params[base], depth+1)); ok {
+					return strconv.FormatInt(seconds/unit, 10)
+				}
+				return ""
+			}
+			if depth >= 8 {
+				return params[name]
+			}
+			return expand(params[name], depth+1)
+		})
+	}
+
+	t.From = expand(t.From, 0)
+	t.Subject = expand(t.Subject, 0)
+	t.PostmasterSubject = expand(t.PostmasterSubject, 0)
+	t.Body = expand(t.Body, 0)
+	return t, nil
+}
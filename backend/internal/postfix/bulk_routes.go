@@ -0,0 +1,357 @@
+package postfix
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Kinds of route in a bulk import or export
+const (
+	RouteTransport   = "transport"
+	RouteSenderRelay = "sender_relay"
+)
+
+// RouteBundle is the transport maps and sender relays of a bulk import or
+// export
+type RouteBundle struct {
+	TransportMaps []TransportMap         `json:"transportMaps"`
+	SenderRelays  []SenderDependentRelay `json:"senderRelays"`
+	// Lines maps each entry to its line (CSV) or position (JSON) in the
+	// import, keyed by kind and domain or sender
+	Lines map[string]int `json:"-"`
+}
+
+// RouteLine returns where an imported entry came from
+func (b *RouteBundle) RouteLine(kind, key string) int {
+	return b.Lines[kind+" "+key]
+}
+
+func (b *RouteBundle) addTransport(line int, tm TransportMap) {
+	b.TransportMaps = append(b.TransportMaps, tm)
+	b.Lines[RouteTransport+" "+tm.Domain] = line
+}
+
+func (b *RouteBundle) addSenderRelay(line int, relay SenderDependentRelay) {
+	b.SenderRelays = append(b.SenderRelays, relay)
+	b.Lines[RouteSenderRelay+" "+relay.Sender] = line
+}
+
+// csvColumns maps the header names a route CSV may use, including those of
+// an Exchange send connector export, to the column they fill
+var csvColumns = map[string]string{
+	"type":          "type",
+	"kind":          "type",
+	"key":           "key",
+	"domain":        "key",
+	"addressspace":  "key",
+	"addressspaces": "key",
+	"sender":        "sender",
+	"next_hop":      "next_hop",
+	"nexthop":       "next_hop",
+	"smarthost":     "next_hop",
+	"smarthosts":    "next_hop",
+	"relayhost":     "next_hop",
+	"port":          "port",
+	"enabled":       "enabled",
+}
+
+// RoutesCSVHeader is the header row RoutesCSV writes
+var RoutesCSVHeader = []string{"type", "key", "next_hop", "port", "enabled"}
+
+// RoutesCSV renders transport maps and sender relays as CSV that
+// ParseRoutesCSV reads back
+func RoutesCSV(maps []TransportMap, relays []SenderDependentRelay) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(RoutesCSVHeader)
+	for _, tm := range maps {
+		w.Write([]string{RouteTransport, tm.Domain, tm.NextHop, strconv.Itoa(tm.Port), strconv.FormatBool(tm.Enabled)})
+	}
+	for _, r := range relays {
+		w.Write([]string{RouteSenderRelay, r.Sender, r.Relayhost, "", strconv.FormatBool(r.Enabled)})
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// ParseRoutesCSV reads routes from CSV with a header row. Rows are
+// transports unless a type column or a sender column says otherwise.
+// Exchange address spaces such as SMTP:contoso.com;1 are accepted. Rows
+// that can't be read are returned as conflicts; the values of the rest
+// still need validating.
+func ParseRoutesCSV(data []byte) (*RouteBundle, []ImportConflict, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("the CSV is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	cols := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), " ", ""))
+		if col, ok := csvColumns[name]; ok {
+			if _, dup := cols[col]; !dup {
+				cols[col] = i
+			}
+		}
+	}
+	if _, ok := cols["next_hop"]; !ok {
+		return nil, nil, fmt.Errorf("the CSV needs a next_hop (or smarthosts or relayhost) column")
+	}
+	_, hasKey := cols["key"]
+	_, hasSender := cols["sender"]
+	if !hasKey && !hasSender {
+		return nil, nil, fmt.Errorf("the CSV needs a key, domain, address space or sender column")
+	}
+
+	bundle := &RouteBundle{Lines: map[string]int{}}
+	conflicts := []ImportConflict{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := r.FieldPos(0)
+		if err != nil {
+			conflicts = append(conflicts, ImportConflict{Line: line, Reason: err.Error()})
+			continue
+		}
+		field := func(col string) string {
+			if i, ok := cols[col]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		kind := strings.ToLower(field("type"))
+		key := field("key")
+		if sender := field("sender"); sender != "" && key == "" {
+			key = sender
+			if kind == "" {
+				kind = RouteSenderRelay
+			}
+		}
+		if key == "" && field("next_hop") == "" {
+			continue // blank row
+		}
+		enabled := true
+		if v := field("enabled"); v != "" {
+			b, err := strconv.ParseBool(strings.ToLower(v))
+			if err != nil {
+				conflicts = append(conflicts, ImportConflict{Line: line, Key: key, Reason: "enabled must be true or false"})
+				continue
+			}
+			enabled = b
+		}
+
+		switch kind {
+		case "", RouteTransport, "transport_map":
+			tm, reason := csvTransport(key, field("next_hop"), field("port"))
+			if reason != "" {
+				conflicts = append(conflicts, ImportConflict{Line: line, Key: key, Reason: reason})
+				continue
+			}
+			tm.Enabled = enabled
+			bundle.addTransport(line, tm)
+		case RouteSenderRelay, "sender":
+			relayhost := field("next_hop")
+			if port := field("port"); port != "" && !strings.Contains(strings.TrimPrefix(relayhost, "["), ":") {
+				relayhost = "[" + strings.Trim(relayhost, "[]") + "]:" + port
+			}
+			bundle.addSenderRelay(line, SenderDependentRelay{Sender: key, Relayhost: relayhost, Enabled: enabled})
+		default:
+			conflicts = append(conflicts, ImportConflict{Line: line, Key: key, Reason: "type must be transport or sender_relay"})
+		}
+	}
+	return bundle, conflicts, nil
+}
+
+// csvTransport builds a transport map from a CSV row. The next hop may
+// carry the port ([host]:port) when there is no port column.
+func csvTransport(key, nextHop, port string) (TransportMap, string) {
+	// Exchange writes address spaces as type:domain;cost
+	if i := strings.IndexByte(key, ':'); i >= 0 {
+		key = key[i+1:]
+	}
+	if i := strings.IndexByte(key, ';'); i >= 0 {
+		key = key[:i]
+	}
+	key = strings.ToLower(strings.TrimSpace(key))
+	if key == "*" {
+		return TransportMap{}, "a * address space is the default route; set relayhost instead"
+	}
+	if strings.ContainsAny(nextHop, ";, ") {
+		return TransportMap{}, "only one next hop per route is supported"
+	}
+
+	tm := TransportMap{Domain: key, Port: 25}
+	host := nextHop
+	if i := strings.LastIndexByte(host, ':'); i > 0 && i > strings.LastIndexByte(host, ']') {
+		p, err := strconv.Atoi(host[i+1:])
+		if err != nil {
+			return TransportMap{}, "invalid port in next hop"
+		}
+		tm.Port = p
+		host = host[:i]
+	}
+	if port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return TransportMap{}, "port must be a number"
+		}
+		tm.Port = p
+	}
+	tm.NextHop = strings.Trim(host, "[]")
+	tm.Transport = fmt.Sprintf("smtp:[%s]:%d", tm.NextHop, tm.Port)
+	return tm, ""
+}
+
+// ParseRoutesJSON reads routes in the shape of RouteBundle. Entries
+// without enabled are enabled, and transports without a port use 25.
+func ParseRoutesJSON(data []byte) (*RouteBundle, []ImportConflict, error) {
+	var in struct {
+		TransportMaps []struct {
+			Domain  string `json:"domain"`
+			NextHop string `json:"nextHop"`
+			Port    int    `json:"port"`
+			Enabled *bool  `json:"enabled"`
+		} `json:"transportMaps"`
+		SenderRelays []struct {
+			Sender    string `json:"sender"`
+			Relayhost string `json:"relayhost"`
+			Enabled   *bool  `json:"enabled"`
+		} `json:"senderRelays"`
+	}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	bundle := &RouteBundle{Lines: map[string]int{}}
+	for i, t := range in.TransportMaps {
+		tm := TransportMap{Domain: strings.ToLower(strings.TrimSpace(t.Domain)), NextHop: strings.Trim(strings.TrimSpace(t.NextHop), "[]"),
+			Port: t.Port, Enabled: t.Enabled == nil || *t.Enabled}
+		if tm.Port == 0 {
+			tm.Port = 25
+		}
+		tm.Transport = fmt.Sprintf("smtp:[%s]:%d", tm.NextHop, tm.Port)
+		bundle.addTransport(i+1, tm)
+	}
+	for i, s := range in.SenderRelays {
+		bundle.addSenderRelay(i+1, SenderDependentRelay{Sender: strings.TrimSpace(s.Sender), Relayhost: strings.TrimSpace(s.Relayhost),
+			Enabled: s.Enabled == nil || *s.Enabled})
+	}
+	return bundle, []ImportConflict{}, nil
+}
+
+// RouteDiff is what applying an import changes for one kind of route
+type RouteDiff struct {
+	Added     []string `json:"added"`
+	Updated   []string `json:"updated"`
+	Removed   []string `json:"removed"`
+	Unchanged int      `json:"unchanged"`
+}
+
+// Changed reports whether the diff changes anything
+func (d RouteDiff) Changed() bool {
+	return len(d.Added)+len(d.Updated)+len(d.Removed) > 0
+}
+
+// MergeTransportMaps returns current with imported added or replacing
+// entries by domain, or with replace just imported, and what changed
+func MergeTransportMaps(current, imported []TransportMap, replace bool) ([]TransportMap, RouteDiff) {
+	diff := RouteDiff{Added: []string{}, Updated: []string{}, Removed: []string{}}
+	index := map[string]int{}
+	var result []TransportMap
+	if !replace {
+		result = append(result, current...)
+	}
+	for i, tm := range result {
+		index[tm.Domain] = i
+	}
+	existing := map[string]TransportMap{}
+	for _, tm := range current {
+		existing[tm.Domain] = tm
+	}
+	seen := map[string]bool{}
+	for _, tm := range imported {
+		seen[tm.Domain] = true
+		old, had := existing[tm.Domain]
+		switch {
+		case !had:
+			diff.Added = append(diff.Added, tm.Domain)
+		case old == tm:
+			diff.Unchanged++
+		default:
+			diff.Updated = append(diff.Updated, tm.Domain)
+		}
+		if i, ok := index[tm.Domain]; ok {
+			result[i] = tm
+		} else {
+			index[tm.Domain] = len(result)
+			result = append(result, tm)
+		}
+	}
+	if replace {
+		for _, tm := range current {
+			if !seen[tm.Domain] {
+				diff.Removed = append(diff.Removed, tm.Domain)
+			}
+		}
+	}
+	return result, diff
+}
+
+// MergeSenderRelays is MergeTransportMaps for sender relays, keyed by
+// sender
+func MergeSenderRelays(current, imported []SenderDependentRelay, replace bool) ([]SenderDependentRelay, RouteDiff) {
+	diff := RouteDiff{Added: []string{}, Updated: []string{}, Removed: []string{}}
+	index := map[string]int{}
+	var result []SenderDependentRelay
+	if !replace {
+		result = append(result, current...)
+	}
+	for i, r := range result {
+		index[r.Sender] = i
+	}
+	existing := map[string]SenderDependentRelay{}
+	for _, r := range current {
+		existing[r.Sender] = r
+	}
+	seen := map[string]bool{}
+	for _, r := range imported {
+		seen[r.Sender] = true
+		old, had := existing[r.Sender]
+		switch {
+		case !had:
+			diff.Added = append(diff.Added, r.Sender)
+		case old == r:
+			diff.Unchanged++
+		default:
+			diff.Updated = append(diff.Updated, r.Sender)
+		}
+		if i, ok := index[r.Sender]; ok {
+			result[i] = r
+		} else {
+			index[r.Sender] = len(result)
+			result = append(result, r)
+		}
+	}
+	if replace {
+		for _, r := range current {
+			if !seen[r.Sender] {
+				diff.Removed = append(diff.Removed, r.Sender)
+			}
+		}
+	}
+	return result, diff
+}
@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -35,6 +36,8 @@ type Config struct {
 	TLS          TLSConfig          `json:"tls"`
 	SASL         SASLConfig         `json:"sasl"`
 	Restrictions RestrictionsConfig `json:"restrictions"`
+	Delivery     DeliveryConfig     `json:"delivery"`
+	SMTPDSASL    SMTPDSASLConfig    `json:"smtpd_sasl"`
 }
 
 type GeneralConfig struct {
@@ -55,16 +58,16 @@ type TLSConfig struct {
 	SMTPTLSSecurityLevel  string `json:"smtp_tls_security_level"`
 	SMTPDTLSSecurityLevel string `json:"smtpd_tls_security_level"`
 	SMTPTLSCertFile       string `json:"smtp_tls_cert_file"`
-	SMTPTLSKeyFile        string `json:"smtp_tls_key_file"`
+	SMTPTLSKeyFile        string `json:"smtp_tls_key_file" redact:"edit:config"`
 	SMTPDTLSCertFile      string `json:"smtpd_tls_cert_file"`
-	SMTPDTLSKeyFile       string `json:"smtpd_tls_key_file"`
+	SMTPDTLSKeyFile       string `json:"smtpd_tls_key_file" redact:"edit:config"`
 	SMTPTLSCAFile         string `json:"smtp_tls_CAfile"`
 	SMTPTLSLoglevel       string `json:"smtp_tls_loglevel"`
 }
 
 type SASLConfig struct {
 	SMTPSASLAuthEnable         string `json:"smtp_sasl_auth_enable"`
-	SMTPSASLPasswordMaps       string `json:"smtp_sasl_password_maps"`
+	SMTPSASLPasswordMaps       string `json:"smtp_sasl_password_maps" redact:"edit:config"`
 	SMTPSASLSecurityOptions    string `json:"smtp_sasl_security_options"`
 	SMTPSASLTLSSecurityOptions string `json:"smtp_sasl_tls_security_options"`
 }
@@ -75,11 +78,30 @@ type RestrictionsConfig struct {
 	SMTPDSenderRestrictions    string `json:"smtpd_sender_restrictions"`
 }
 
+// DeliveryConfig covers local delivery of virtual mailboxes, which Postfix
+// hands to Dovecot over LMTP
+type DeliveryConfig struct {
+	VirtualTransport              string `json:"virtual_transport"`
+	MailboxSizeLimit              string `json:"mailbox_size_limit"`
+	LMTPDestinationRecipientLimit string `json:"lmtp_destination_recipient_limit"`
+}
+
+// SMTPDSASLConfig covers SMTP AUTH for clients submitting mail, which
+// Postfix checks against Dovecot's auth service
+type SMTPDSASLConfig struct {
+	SMTPDSASLAuthEnable         string `json:"smtpd_sasl_auth_enable"`
+	SMTPDSASLType               string `json:"smtpd_sasl_type"`
+	SMTPDSASLPath               string `json:"smtpd_sasl_path"`
+	SMTPDSASLSecurityOptions    string `json:"smtpd_sasl_security_options"`
+	SMTPDSASLTLSSecurityOptions string `json:"smtpd_sasl_tls_security_options"`
+	SMTPDTLSAuthOnly            string `json:"smtpd_tls_auth_only"`
+}
+
 // Certificate represents TLS certificate info
 type Certificate struct {
 	Type      string    `json:"type"`
 	CertFile  string    `json:"certFile"`
-	KeyFile   string    `json:"keyFile"`
+	KeyFile   string    `json:"keyFile" redact:"edit:config"`
 	ValidFrom time.Time `json:"validFrom,omitempty"`
 	ValidTo   time.Time `json:"validTo,omitempty"`
 	Subject   string    `json:"subject,omitempty"`
@@ -131,6 +153,19 @@ func (m *ConfigManager) ReadConfig() (*Config, error) {
 			SMTPDRecipientRestrictions: params["smtpd_recipient_restrictions"],
 			SMTPDSenderRestrictions:    params["smtpd_sender_restrictions"],
 		},
+		Delivery: DeliveryConfig{
+			VirtualTransport:              params["virtual_transport"],
+			MailboxSizeLimit:              params["mailbox_size_limit"],
+			LMTPDestinationRecipientLimit: params["lmtp_destination_recipient_limit"],
+		},
+		SMTPDSASL: SMTPDSASLConfig{
+			SMTPDSASLAuthEnable:         params["smtpd_sasl_auth_enable"],
+			SMTPDSASLType:               params["smtpd_sasl_type"],
+			SMTPDSASLPath:               params["smtpd_sasl_path"],
+			SMTPDSASLSecurityOptions:    params["smtpd_sasl_security_options"],
+			SMTPDSASLTLSSecurityOptions: params["smtpd_sasl_tls_security_options"],
+			SMTPDTLSAuthOnly:            params["smtpd_tls_auth_only"],
+		},
 	}
 
 	return config, nil
@@ -162,6 +197,18 @@ func (m *ConfigManager) UpdateConfig(updates map[string]string) error {
 	return m.writeMainCf(mainCfPath, params)
 }
 
+// GetParameter returns one main.cf parameter, empty when it isn't set
+func (m *ConfigManager) GetParameter(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	params, err := m.parseMainCf(filepath.Join(m.configDir, "main.cf"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read config: %w", err)
+	}
+	return params[name], nil
+}
+
 // WriteConfig writes a complete Config struct to the filesystem
 func (m *ConfigManager) WriteConfig(cfg *Config) error {
 	m.mu.Lock()
@@ -272,6 +319,37 @@ func (m *ConfigManager) configToMap(cfg *Config) map[string]string {
 		params["smtpd_sender_restrictions"] = cfg.Restrictions.SMTPDSenderRestrictions
 	}
 
+	// Delivery
+	if cfg.Delivery.VirtualTransport != "" {
+		params["virtual_transport"] = cfg.Delivery.VirtualTransport
+	}
+	if cfg.Delivery.MailboxSizeLimit != "" {
+		params["mailbox_size_limit"] = cfg.Delivery.MailboxSizeLimit
+	}
+	if cfg.Delivery.LMTPDestinationRecipientLimit != "" {
+		params["lmtp_destination_recipient_limit"] = cfg.Delivery.LMTPDestinationRecipientLimit
+	}
+
+	// Submission AUTH
+	if cfg.SMTPDSASL.SMTPDSASLAuthEnable != "" {
+		params["smtpd_sasl_auth_enable"] = cfg.SMTPDSASL.SMTPDSASLAuthEnable
+	}
+	if cfg.SMTPDSASL.SMTPDSASLType != "" {
+		params["smtpd_sasl_type"] = cfg.SMTPDSASL.SMTPDSASLType
+	}
+	if cfg.SMTPDSASL.SMTPDSASLPath != "" {
+		params["smtpd_sasl_path"] = cfg.SMTPDSASL.SMTPDSASLPath
+	}
+	if cfg.SMTPDSASL.SMTPDSASLSecurityOptions != "" {
+		params["smtpd_sasl_security_options"] = cfg.SMTPDSASL.SMTPDSASLSecurityOptions
+	}
+	if cfg.SMTPDSASL.SMTPDSASLTLSSecurityOptions != "" {
+		params["smtpd_sasl_tls_security_options"] = cfg.SMTPDSASL.SMTPDSASLTLSSecurityOptions
+	}
+	if cfg.SMTPDSASL.SMTPDTLSAuthOnly != "" {
+		params["smtpd_tls_auth_only"] = cfg.SMTPDSASL.SMTPDTLSAuthOnly
+	}
+
 	return params
 }
 
@@ -360,9 +438,37 @@ func (m *ConfigManager) writeMainCf(path string, params map[string]string) error
 		return fmt.Errorf("failed to set file permissions: %w", err)
 	}
 
+	if _, err := file.WriteString(renderMainCf(params, time.Now())); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	// Sync to disk before close for durability
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+
+	// Atomic rename from temp to target
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+
+	success = true // Prevent deferred cleanup
+	return nil
+}
+
+// renderMainCf returns the main.cf content for params
+func renderMainCf(params map[string]string, modified time.Time) string {
+	var content strings.Builder
+
 	// Write header
-	fmt.Fprintf(file, "# Postfix main.cf - Managed by PostfixRelay\n")
-	fmt.Fprintf(file, "# Last modified: %s\n\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&content, "# Postfix main.cf - Managed by PostfixRelay\n")
+	fmt.Fprintf(&content, "# Last modified: %s\n\n", modified.Format(time.RFC3339))
 
 	// Write parameters in a sensible order
 	sections := []struct {
@@ -374,55 +480,46 @@ func (m *ConfigManager) writeMainCf(path string, params map[string]string) error
 		{"TLS", []string{"smtp_tls_security_level", "smtpd_tls_security_level", "smtp_tls_cert_file", "smtp_tls_key_file", "smtpd_tls_cert_file", "smtpd_tls_key_file", "smtp_tls_CAfile", "smtp_tls_loglevel"}},
 		{"SASL", []string{"smtp_sasl_auth_enable", "smtp_sasl_password_maps", "smtp_sasl_security_options", "smtp_sasl_tls_security_options"}},
 		{"Restrictions", []string{"smtpd_relay_restrictions", "smtpd_recipient_restrictions", "smtpd_sender_restrictions"}},
+		{"Delivery", []string{"virtual_transport", "mailbox_size_limit", "lmtp_destination_recipient_limit"}},
+		{"Submission AUTH", []string{"smtpd_sasl_auth_enable", "smtpd_sasl_type", "smtpd_sasl_path", "smtpd_sasl_security_options", "smtpd_sasl_tls_security_options", "smtpd_tls_auth_only"}},
 	}
 
 	written := make(map[string]bool)
 	for _, section := range sections {
-		fmt.Fprintf(file, "# %s\n", section.name)
+		fmt.Fprintf(&content, "# %s\n", section.name)
 		for _, key := range section.keys {
 			if value, ok := params[key]; ok && value != "" {
 				// Handle multi-line values
 				if strings.Contains(value, "\n") {
 					lines := strings.Split(value, "\n")
-					fmt.Fprintf(file, "%s = %s", key, lines[0])
+					fmt.Fprintf(&content, "%s = %s", key, lines[0])
 					for _, line := range lines[1:] {
-						fmt.Fprintf(file, ",\n    %s", strings.TrimSpace(line))
+						fmt.Fprintf(&content, ",\n    %s", strings.TrimSpace(line))
 					}
-					fmt.Fprintln(file)
+					fmt.Fprintln(&content)
 				} else {
-					fmt.Fprintf(file, "%s = %s\n", key, value)
+					fmt.Fprintf(&content, "%s = %s\n", key, value)
 				}
 				written[key] = true
 			}
 		}
-		fmt.Fprintln(file)
+		fmt.Fprintln(&content)
 	}
 
-	// Write remaining parameters
-	fmt.Fprintln(file, "# Other")
+	// Write remaining parameters, sorted so the output is stable
+	fmt.Fprintln(&content, "# Other")
+	var remaining []string
 	for key, value := range params {
 		if !written[key] && value != "" {
-			fmt.Fprintf(file, "%s = %s\n", key, value)
+			remaining = append(remaining, key)
 		}
 	}
-
-	// Sync to disk before close for durability
-	if err := file.Sync(); err != nil {
-		file.Close()
-		return fmt.Errorf("failed to sync file: %w", err)
-	}
-
-	if err := file.Close(); err != nil {
-		return fmt.Errorf("failed to close file: %w", err)
-	}
-
-	// Atomic rename from temp to target
-	if err := os.Rename(tmpPath, path); err != nil {
-		return fmt.Errorf("failed to rename temp file: %w", err)
+	sort.Strings(remaining)
+	for _, key := range remaining {
+		fmt.Fprintf(&content, "%s = %s\n", key, params[key])
 	}
 
-	success = true // Prevent deferred cleanup
-	return nil
+	return content.String()
 }
 
 // Validate validates the current configuration
@@ -695,17 +792,16 @@ func (m *ConfigManager) GetVersion() string {
 	return "unknown"
 }
 
-// SaveSASLCredentials saves SMTP authentication credentials
-func (m *ConfigManager) SaveSASLCredentials(relayhost, username, password string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	// sasl_passwd file format: [hostname]:port username:password
-	saslPasswdPath := filepath.Join(m.configDir, "sasl_passwd")
+// IsSenderKey reports whether a sasl_passwd key is a sender (user@domain or
+// @domain) rather than a relay host
+func IsSenderKey(key string) bool {
+	return strings.Contains(key, "@")
+}
 
-	// Read existing entries (if any)
+// readSASLPasswd reads the sasl_passwd entries, username:password by key
+func readSASLPasswd(path string) map[string]string {
 	entries := make(map[string]string)
-	if data, err := os.ReadFile(saslPasswdPath); err == nil {
+	if data, err := os.ReadFile(path); err == nil {
 		for _, line := range strings.Split(string(data), "\n") {
 			line = strings.TrimSpace(line)
 			if line == "" || strings.HasPrefix(line, "#") {
@@ -717,32 +813,77 @@ func (m *ConfigManager) SaveSASLCredentials(relayhost, username, password string
 			}
 		}
 	}
+	return entries
+}
 
-	// Add/update the entry
-	entries[relayhost] = fmt.Sprintf("%s:%s", username, password)
+// senderAuthentication returns smtp_sender_dependent_authentication for the
+// entries: Postfix only looks credentials up by sender when it is on
+func senderAuthentication(entries map[string]string) string {
+	for key := range entries {
+		if IsSenderKey(key) {
+			return "yes"
+		}
+	}
+	return "no"
+}
 
-	// Write the file
-	var content strings.Builder
-	content.WriteString("# SASL password file - Managed by PostfixRelay\n")
-	content.WriteString("# Format: [hostname]:port username:password\n\n")
-	for host, creds := range entries {
-		content.WriteString(fmt.Sprintf("%s %s\n", host, creds))
+// GetSASLCredentials reads the SMTP authentication credentials, relay hosts
+// first and then senders
+func (m *ConfigManager) GetSASLCredentials() []SASLCredential {
+	m.mu.RLock()
+	entries := readSASLPasswd(filepath.Join(m.configDir, "sasl_passwd"))
+	m.mu.RUnlock()
+
+	creds := make([]SASLCredential, 0, len(entries))
+	for key, value := range entries {
+		username, password, _ := strings.Cut(value, ":")
+		c := SASLCredential{Relayhost: key, Username: username, Password: password}
+		if IsSenderKey(key) {
+			c = SASLCredential{Sender: key, Username: username, Password: password}
+		}
+		creds = append(creds, c)
 	}
+	sort.Slice(creds, func(i, j int) bool {
+		if (creds[i].Sender == "") != (creds[j].Sender == "") {
+			return creds[i].Sender == ""
+		}
+		return creds[i].Key() < creds[j].Key()
+	})
+	return creds
+}
+
+// SaveSASLCredentials saves SMTP authentication credentials. key is the
+// relay host ([relay.example.com]:587) they are used for or, for
+// sender-dependent authentication, a sender address or @domain; sender keys
+// turn on smtp_sender_dependent_authentication.
+func (m *ConfigManager) SaveSASLCredentials(key, username, password string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// sasl_passwd file format: [hostname]:port username:password
+	saslPasswdPath := filepath.Join(m.configDir, "sasl_passwd")
+
+	// Read existing entries (if any)
+	entries := readSASLPasswd(saslPasswdPath)
+
+	// Add/update the entry
+	entries[key] = fmt.Sprintf("%s:%s", username, password)
 
 	// Write with restricted permissions
-	if err := os.WriteFile(saslPasswdPath, []byte(content.String()), 0600); err != nil {
+	if err := os.WriteFile(saslPasswdPath, []byte(renderSASLPasswd(entries)), 0600); err != nil {
 		return fmt.Errorf("failed to write sasl_passwd: %w", err)
 	}
 
-	// Generate the hash database
-	cmd := exec.Command("sudo", "postmap", saslPasswdPath)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to run postmap: %s", strings.TrimSpace(string(output)))
+	// Generate the lookup table
+	mapType := MapType("sasl_passwd")
+	if err := CompileMap(mapType, saslPasswdPath, true); err != nil {
+		return err
 	}
 
 	// Update main.cf to use the password map
 	updates := map[string]string{
-		"smtp_sasl_password_maps": "hash:" + saslPasswdPath,
+		"smtp_sasl_password_maps":              MapRef(mapType, saslPasswdPath),
+		"smtp_sender_dependent_authentication": senderAuthentication(entries),
 	}
 
 	// We need to release the lock before calling UpdateConfig
@@ -753,47 +894,59 @@ func (m *ConfigManager) SaveSASLCredentials(relayhost, username, password string
 	return err
 }
 
-// DeleteSASLCredentials removes SMTP authentication credentials for a relay host
-func (m *ConfigManager) DeleteSASLCredentials(relayhost string) error {
+// DeleteSASLCredentials removes SMTP authentication credentials for a relay
+// host or sender
+func (m *ConfigManager) DeleteSASLCredentials(key string) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	saslPasswdPath := filepath.Join(m.configDir, "sasl_passwd")
 
 	// Read existing entries
-	entries := make(map[string]string)
-	if data, err := os.ReadFile(saslPasswdPath); err == nil {
-		for _, line := range strings.Split(string(data), "\n") {
-			line = strings.TrimSpace(line)
-			if line == "" || strings.HasPrefix(line, "#") {
-				continue
-			}
-			parts := strings.SplitN(line, " ", 2)
-			if len(parts) == 2 && parts[0] != relayhost {
-				entries[parts[0]] = parts[1]
-			}
-		}
+	entries := readSASLPasswd(saslPasswdPath)
+	if _, ok := entries[key]; !ok {
+		return fmt.Errorf("no credentials for %s", key)
 	}
+	delete(entries, key)
 
-	// Write the file
-	var content strings.Builder
-	content.WriteString("# SASL password file - Managed by PostfixRelay\n")
-	content.WriteString("# Format: [hostname]:port username:password\n\n")
-	for host, creds := range entries {
-		content.WriteString(fmt.Sprintf("%s %s\n", host, creds))
+	if err := os.WriteFile(saslPasswdPath, []byte(renderSASLPasswd(entries)), 0600); err != nil {
+		return fmt.Errorf("failed to write sasl_passwd: %w", err)
 	}
 
-	if err := os.WriteFile(saslPasswdPath, []byte(content.String()), 0600); err != nil {
-		return fmt.Errorf("failed to write sasl_passwd: %w", err)
+	// Regenerate the lookup table
+	if err := CompileMap(MapType("sasl_passwd"), saslPasswdPath, true); err != nil {
+		return err
+	}
+	if !IsSenderKey(key) {
+		return nil
 	}
 
-	// Regenerate the hash database
-	cmd := exec.Command("sudo", "postmap", saslPasswdPath)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to run postmap: %s", strings.TrimSpace(string(output)))
+	// Sender-dependent authentication disables connection caching, so it is
+	// turned off again with the last sender's credentials
+	m.mu.Unlock()
+	err := m.UpdateConfig(map[string]string{"smtp_sender_dependent_authentication": senderAuthentication(entries)})
+	m.mu.Lock()
+
+	return err
+}
+
+// renderSASLPasswd returns the sasl_passwd file content for credentials by
+// relay host or sender, in key order
+func renderSASLPasswd(entries map[string]string) string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
-	return nil
+	var content strings.Builder
+	content.WriteString("# SASL password file - Managed by PostfixRelay\n")
+	content.WriteString("# Format: [hostname]:port username:password\n")
+	content.WriteString("#     or: sender@domain (or @domain) username:password\n\n")
+	for _, key := range keys {
+		content.WriteString(fmt.Sprintf("%s %s\n", key, entries[key]))
+	}
+	return content.String()
 }
 
 // TransportMap represents a domain routing entry
@@ -840,7 +993,7 @@ func (m *ConfigManager) GetTransportMaps() ([]TransportMap, error) {
 			continue
 		}
 
-		domain := parts[0]
+		domain := strings.TrimPrefix(ParseMapKey(parts[0]), "@")
 		transport := parts[1]
 
 		// Parse transport format: smtp:[host]:port or smtp:host:port
@@ -853,17 +1006,15 @@ func (m *ConfigManager) GetTransportMaps() ([]TransportMap, error) {
 		// Extract nexthop and port
 		if strings.HasPrefix(transport, "smtp:") {
 			rest := strings.TrimPrefix(transport, "smtp:")
-			rest = strings.Trim(rest, "[]")
-			if idx := strings.LastIndex(rest, ":"); idx > 0 {
-				tm.NextHop = rest[:idx]
-				tm.Port = 25
+			tm.Port = 25
+			// The port follows the bracketed host: [host]:port
+			if idx := strings.LastIndex(rest, ":"); idx > 0 && idx > strings.LastIndex(rest, "]") {
 				if portStr := rest[idx+1:]; portStr != "" {
 					fmt.Sscanf(portStr, "%d", &tm.Port)
 				}
-			} else {
-				tm.NextHop = rest
-				tm.Port = 25
+				rest = rest[:idx]
 			}
+			tm.NextHop = strings.Trim(rest, "[]")
 		}
 
 		maps = append(maps, tm)
@@ -878,37 +1029,21 @@ func (m *ConfigManager) SaveTransportMaps(maps []TransportMap) error {
 	defer m.mu.Unlock()
 
 	transportPath := filepath.Join(m.configDir, "transport")
-
-	// Build content
-	var content strings.Builder
-	content.WriteString("# Transport maps - Managed by PostfixRelay\n")
-	content.WriteString("# Format: domain transport:nexthop\n\n")
-
-	for _, tm := range maps {
-		prefix := ""
-		if !tm.Enabled {
-			prefix = "# "
-		}
-
-		// Build transport string
-		transport := fmt.Sprintf("smtp:[%s]:%d", tm.NextHop, tm.Port)
-		content.WriteString(fmt.Sprintf("%s%s\t%s\n", prefix, tm.Domain, transport))
-	}
+	mapType := MapType("transport")
 
 	// Write the file
-	if err := os.WriteFile(transportPath, []byte(content.String()), 0644); err != nil {
+	if err := os.WriteFile(transportPath, []byte(renderTransportMaps(maps, mapType)), 0644); err != nil {
 		return fmt.Errorf("failed to write transport file: %w", err)
 	}
 
-	// Generate the hash database
-	cmd := exec.Command("sudo", "postmap", transportPath)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to run postmap: %s", strings.TrimSpace(string(output)))
+	// Generate the lookup table
+	if err := CompileMap(mapType, transportPath, true); err != nil {
+		return err
 	}
 
 	// Update main.cf to use transport maps
 	updates := map[string]string{
-		"transport_maps": "hash:" + transportPath,
+		"transport_maps": MapRef(mapType, transportPath),
 	}
 
 	// Release lock before calling UpdateConfig
@@ -919,21 +1054,36 @@ func (m *ConfigManager) SaveTransportMaps(maps []TransportMap) error {
 	return err
 }
 
+// renderTransportMaps returns the transport file content for maps, as a
+// map of the given type
+func renderTransportMaps(maps []TransportMap, mapType string) string {
+	var content strings.Builder
+	content.WriteString("# Transport maps - Managed by PostfixRelay\n")
+	content.WriteString("# Format: domain transport:nexthop\n\n")
+
+	for _, tm := range maps {
+		prefix := ""
+		if !tm.Enabled {
+			prefix = "# "
+		}
+
+		// Build transport string
+		transport := fmt.Sprintf("smtp:[%s]:%d", tm.NextHop, tm.Port)
+		content.WriteString(fmt.Sprintf("%s%s\t%s\n", prefix, MapKey(mapType, tm.Domain), transport))
+	}
+
+	return content.String()
+}
+
 // AddTransportMap adds a single transport map entry
 func (m *ConfigManager) AddTransportMap(tm TransportMap) error {
 	maps, err := m.GetTransportMaps()
 	if err != nil {
 		return err
 	}
-
-	// Check for duplicate domain
-	for _, existing := range maps {
-		if existing.Domain == tm.Domain {
-			return fmt.Errorf("transport map for domain %s already exists", tm.Domain)
-		}
+	if maps, err = addTransportMap(maps, tm); err != nil {
+		return err
 	}
-
-	maps = append(maps, tm)
 	return m.SaveTransportMaps(maps)
 }
 
@@ -943,20 +1093,9 @@ func (m *ConfigManager) UpdateTransportMap(domain string, tm TransportMap) error
 	if err != nil {
 		return err
 	}
-
-	found := false
-	for i, existing := range maps {
-		if existing.Domain == domain {
-			maps[i] = tm
-			found = true
-			break
-		}
-	}
-
-	if !found {
-		return fmt.Errorf("transport map for domain %s not found", domain)
+	if maps, err = updateTransportMap(maps, domain, tm); err != nil {
+		return err
 	}
-
 	return m.SaveTransportMaps(maps)
 }
 
@@ -966,7 +1105,35 @@ func (m *ConfigManager) DeleteTransportMap(domain string) error {
 	if err != nil {
 		return err
 	}
+	if maps, err = deleteTransportMap(maps, domain); err != nil {
+		return err
+	}
+	return m.SaveTransportMaps(maps)
+}
+
+func addTransportMap(maps []TransportMap, tm TransportMap) ([]TransportMap, error) {
+	// Check for duplicate domain
+	for _, existing := range maps {
+		if existing.Domain == tm.Domain {
+			return nil, fmt.Errorf("transport map for domain %s already exists", tm.Domain)
+		}
+	}
+
+	return append(maps, tm), nil
+}
+
+func updateTransportMap(maps []TransportMap, domain string, tm TransportMap) ([]TransportMap, error) {
+	for i, existing := range maps {
+		if existing.Domain == domain {
+			maps[i] = tm
+			return maps, nil
+		}
+	}
+
+	return nil, fmt.Errorf("transport map for domain %s not found", domain)
+}
 
+func deleteTransportMap(maps []TransportMap, domain string) ([]TransportMap, error) {
 	var newMaps []TransportMap
 	found := false
 	for _, existing := range maps {
@@ -978,10 +1145,10 @@ func (m *ConfigManager) DeleteTransportMap(domain string) error {
 	}
 
 	if !found {
-		return fmt.Errorf("transport map for domain %s not found", domain)
+		return nil, fmt.Errorf("transport map for domain %s not found", domain)
 	}
 
-	return m.SaveTransportMaps(newMaps)
+	return newMaps, nil
 }
 
 // SenderDependentRelay represents a sender-based relay entry
@@ -1026,7 +1193,7 @@ func (m *ConfigManager) GetSenderDependentRelays() ([]SenderDependentRelay, erro
 		}
 
 		relays = append(relays, SenderDependentRelay{
-			Sender:    parts[0],
+			Sender:    ParseMapKey(parts[0]),
 			Relayhost: parts[1],
 			Enabled:   enabled,
 		})
@@ -1041,32 +1208,20 @@ func (m *ConfigManager) SaveSenderDependentRelays(relays []SenderDependentRelay)
 	defer m.mu.Unlock()
 
 	senderRelayPath := filepath.Join(m.configDir, "sender_relay")
+	mapType := MapType("sender_relay")
 
-	var content strings.Builder
-	content.WriteString("# Sender-dependent relay maps - Managed by PostfixRelay\n")
-	content.WriteString("# Format: sender@domain [relay]:port\n\n")
-
-	for _, relay := range relays {
-		prefix := ""
-		if !relay.Enabled {
-			prefix = "# "
-		}
-		content.WriteString(fmt.Sprintf("%s%s\t%s\n", prefix, relay.Sender, relay.Relayhost))
-	}
-
-	if err := os.WriteFile(senderRelayPath, []byte(content.String()), 0644); err != nil {
+	if err := os.WriteFile(senderRelayPath, []byte(renderSenderRelays(relays, mapType)), 0644); err != nil {
 		return fmt.Errorf("failed to write sender_relay file: %w", err)
 	}
 
-	// Generate the hash database
-	cmd := exec.Command("sudo", "postmap", senderRelayPath)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to run postmap: %s", strings.TrimSpace(string(output)))
+	// Generate the lookup table
+	if err := CompileMap(mapType, senderRelayPath, true); err != nil {
+		return err
 	}
 
 	// Update main.cf
 	updates := map[string]string{
-		"sender_dependent_relayhost_maps": "hash:" + senderRelayPath,
+		"sender_dependent_relayhost_maps": MapRef(mapType, senderRelayPath),
 	}
 
 	m.mu.Unlock()
@@ -1076,20 +1231,33 @@ func (m *ConfigManager) SaveSenderDependentRelays(relays []SenderDependentRelay)
 	return err
 }
 
+// renderSenderRelays returns the sender_relay file content for relays, as
+// a map of the given type
+func renderSenderRelays(relays []SenderDependentRelay, mapType string) string {
+	var content strings.Builder
+	content.WriteString("# Sender-dependent relay maps - Managed by PostfixRelay\n")
+	content.WriteString("# Format: sender@domain [relay]:port\n\n")
+
+	for _, relay := range relays {
+		prefix := ""
+		if !relay.Enabled {
+			prefix = "# "
+		}
+		content.WriteString(fmt.Sprintf("%s%s\t%s\n", prefix, MapKey(mapType, relay.Sender), relay.Relayhost))
+	}
+
+	return content.String()
+}
+
 // AddSenderDependentRelay adds a sender-dependent relay entry
 func (m *ConfigManager) AddSenderDependentRelay(relay SenderDependentRelay) error {
 	relays, err := m.GetSenderDependentRelays()
 	if err != nil {
 		return err
 	}
-
-	for _, existing := range relays {
-		if existing.Sender == relay.Sender {
-			return fmt.Errorf("sender relay for %s already exists", relay.Sender)
-		}
+	if relays, err = addSenderRelay(relays, relay); err != nil {
+		return err
 	}
-
-	relays = append(relays, relay)
 	return m.SaveSenderDependentRelays(relays)
 }
 
@@ -1099,20 +1267,9 @@ func (m *ConfigManager) UpdateSenderDependentRelay(sender string, relay SenderDe
 	if err != nil {
 		return err
 	}
-
-	found := false
-	for i, existing := range relays {
-		if existing.Sender == sender {
-			relays[i] = relay
-			found = true
-			break
-		}
-	}
-
-	if !found {
-		return fmt.Errorf("sender relay for %s not found", sender)
+	if relays, err = updateSenderRelay(relays, sender, relay); err != nil {
+		return err
 	}
-
 	return m.SaveSenderDependentRelays(relays)
 }
 
@@ -1122,7 +1279,34 @@ func (m *ConfigManager) DeleteSenderDependentRelay(sender string) error {
 	if err != nil {
 		return err
 	}
+	if relays, err = deleteSenderRelay(relays, sender); err != nil {
+		return err
+	}
+	return m.SaveSenderDependentRelays(relays)
+}
+
+func addSenderRelay(relays []SenderDependentRelay, relay SenderDependentRelay) ([]SenderDependentRelay, error) {
+	for _, existing := range relays {
+		if existing.Sender == relay.Sender {
+			return nil, fmt.Errorf("sender relay for %s already exists", relay.Sender)
+		}
+	}
+
+	return append(relays, relay), nil
+}
+
+func updateSenderRelay(relays []SenderDependentRelay, sender string, relay SenderDependentRelay) ([]SenderDependentRelay, error) {
+	for i, existing := range relays {
+		if existing.Sender == sender {
+			relays[i] = relay
+			return relays, nil
+		}
+	}
+
+	return nil, fmt.Errorf("sender relay for %s not found", sender)
+}
 
+func deleteSenderRelay(relays []SenderDependentRelay, sender string) ([]SenderDependentRelay, error) {
 	var newRelays []SenderDependentRelay
 	found := false
 	for _, existing := range relays {
@@ -1134,8 +1318,8 @@ func (m *ConfigManager) DeleteSenderDependentRelay(sender string) error {
 	}
 
 	if !found {
-		return fmt.Errorf("sender relay for %s not found", sender)
+		return nil, fmt.Errorf("sender relay for %s not found", sender)
 	}
 
-	return m.SaveSenderDependentRelays(newRelays)
+	return newRelays, nil
 }
@@ -0,0 +1,97 @@
+//go:build integration
+
+package postfix_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/postfixrelay/postfixrelay/internal/testharness"
+)
+
+func TestConfigWriteValidateReload(t *testing.T) {
+	pf := testharness.NewPostfix(t)
+	cm := postfix.NewConfigManager(pf.ConfigDir)
+
+	cfg, err := cm.ReadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.General.Myhostname = "relay.example.com"
+	cfg.Relay.Relayhost = "[smtp.example.net]:587"
+	if err := cm.WriteConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	mainCf, err := os.ReadFile(filepath.Join(pf.ConfigDir, "main.cf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"myhostname = relay.example.com", "relayhost = [smtp.example.net]:587"} {
+		if !strings.Contains(string(mainCf), want) {
+			t.Errorf("main.cf has no %q:\n%s", want, mainCf)
+		}
+	}
+	if got, _ := cm.GetParameter("relayhost"); got != "[smtp.example.net]:587" {
+		t.Errorf("GetParameter(relayhost) = %q", got)
+	}
+
+	if ok, errs := cm.Validate(); !ok {
+		t.Errorf("Validate: %v", errs)
+	}
+	if err := cm.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	calls := strings.Join(pf.Calls(), "\n")
+	for _, want := range []string{"postfix check", "postconf -c " + pf.ConfigDir + " -n", "postfix reload"} {
+		if !strings.Contains(calls, want) {
+			t.Errorf("no %q in calls:\n%s", want, calls)
+		}
+	}
+
+	pf.Fail("postfix", "postfix/postfix-script: fatal: the Postfix mail system is not running")
+	if err := cm.Reload(); err != nil {
+		t.Errorf("Reload with Postfix in another container: %v", err)
+	}
+	pf.Fail("postfix", "postfix: fatal: bad configuration")
+	if ok, errs := cm.Validate(); ok || len(errs) == 0 || !strings.Contains(errs[0], "bad configuration") {
+		t.Errorf("Validate with postfix check failing = %v, %v", ok, errs)
+	}
+	if err := cm.Reload(); err == nil {
+		t.Error("Reload succeeded with postfix failing")
+	}
+}
+
+func TestConfigRenderRunsPostconfUnprivileged(t *testing.T) {
+	pf := testharness.NewPostfix(t)
+	cm := postfix.NewConfigManager(pf.ConfigDir)
+
+	cfg, err := cm.ReadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.General.Myhostname = "relay.example.com"
+	rendered, err := cm.RenderConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rendered.Valid {
+		t.Errorf("RenderConfig: errors %v", rendered.Errors)
+	}
+	if !strings.Contains(rendered.Postconf, "myhostname = relay.example.com") {
+		t.Errorf("postconf output = %q", rendered.Postconf)
+	}
+	for _, call := range pf.Calls() {
+		if strings.HasPrefix(call, "sudo") {
+			t.Errorf("rendering ran %q", call)
+		}
+	}
+
+	// Nothing is written until the change is applied
+	if mainCf, _ := os.ReadFile(filepath.Join(pf.ConfigDir, "main.cf")); len(mainCf) != 0 {
+		t.Errorf("main.cf written by RenderConfig:\n%s", mainCf)
+	}
+}
@@ -0,0 +1,84 @@
+package postfix
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultQueueDirectory is where Postfix resolves relative unix socket
+// paths in transports such as lmtp:unix:private/dovecot-lmtp
+const DefaultQueueDirectory = "/var/spool/postfix"
+
+// defaultLMTPPort is used when an inet LMTP destination has no port
+const defaultLMTPPort = "24"
+
+// LMTPDestination is where virtual_transport hands mail over LMTP
+type LMTPDestination struct {
+	Network string `json:"network"` // "unix" or "tcp"
+	Address string `json:"address"` // socket path, or host:port
+}
+
+// Port returns the port of a tcp destination
+func (d LMTPDestination) Port() string {
+	if d.Network != "tcp" {
+		return ""
+	}
+	_, port, _ := net.SplitHostPort(d.Address)
+	return port
+}
+
+// ParseLMTPTransport parses a virtual_transport of the form
+// lmtp:unix:path, lmtp:inet:host:port or lmtp:host:port. Relative socket
+// paths are taken to be in the Postfix queue directory, as the lmtp client
+// runs chrooted or from there.
+func ParseLMTPTransport(transport string) (LMTPDestination, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(transport), "lmtp:")
+	if !ok {
+		return LMTPDestination{}, fmt.Errorf("%q does not deliver over LMTP", transport)
+	}
+
+	if path, ok := strings.CutPrefix(rest, "unix:"); ok {
+		if path == "" {
+			return LMTPDestination{}, fmt.Errorf("%q has no socket path", transport)
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(DefaultQueueDirectory, path)
+		}
+		return LMTPDestination{Network: "unix", Address: path}, nil
+	}
+
+	rest = strings.TrimPrefix(rest, "inet:")
+	host, port := rest, defaultLMTPPort
+	if h, p, err := net.SplitHostPort(rest); err == nil {
+		host, port = h, p
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if host == "" {
+		return LMTPDestination{}, fmt.Errorf("%q has no host", transport)
+	}
+	return LMTPDestination{Network: "tcp", Address: net.JoinHostPort(host, port)}, nil
+}
+
+// ParseSASLPath returns the socket smtpd_sasl_path points Postfix at for
+// Dovecot SASL: a unix socket path, relative to the queue directory unless
+// absolute, or inet:host:port
+func ParseSASLPath(path string) (network, address string, err error) {
+	path = strings.TrimSpace(path)
+	if rest, ok := strings.CutPrefix(path, "inet:"); ok {
+		host, port, err := net.SplitHostPort(rest)
+		if err != nil || host == "" || port == "" {
+			return "", "", fmt.Errorf("%q is not inet:host:port", path)
+		}
+		return "tcp", net.JoinHostPort(strings.Trim(host, "[]"), port), nil
+	}
+	path = strings.TrimPrefix(path, "unix:")
+	if path == "" {
+		return "", "", fmt.Errorf("no socket path")
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(DefaultQueueDirectory, path)
+	}
+	return "unix", path, nil
+}
@@ -0,0 +1,266 @@
+package postfix
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ImportableMaps are the map files an existing Postfix install may have
+// that the suite can take over
+var ImportableMaps = []string{"transport", "sender_relay", "virtual", "sasl_passwd", "access"}
+
+// managedMarkers are the header lines of files the suite wrote itself
+var managedMarkers = []string{"Managed by PostfixRelay", "Generated by PSFX Admin"}
+
+// MapEntry is one key and value of a map file
+type MapEntry struct {
+	Line  int    `json:"line"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// ImportConflict is an entry of an existing map that can't be adopted as it
+// is, and why
+type ImportConflict struct {
+	Line   int    `json:"line"`
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// ExistingMap is a map file found before the suite managed it
+type ExistingMap struct {
+	Name      string           `json:"name"`
+	Path      string           `json:"path"`
+	Exists    bool             `json:"exists"`
+	Managed   bool             `json:"managed"` // already written by the suite
+	Entries   []MapEntry       `json:"entries"`
+	Conflicts []ImportConflict `json:"conflicts"`
+}
+
+// ReadExistingMap parses the map file at path. A missing file is reported
+// with Exists false rather than as an error. Keys that appear twice are
+// conflicts, since Postfix only ever uses the first.
+func ReadExistingMap(name, path string) (ExistingMap, error) {
+	existing := ExistingMap{Name: name, Path: path, Entries: []MapEntry{}, Conflicts: []ImportConflict{}}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return existing, nil
+	}
+	if err != nil {
+		return existing, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	defer f.Close()
+	existing.Exists = true
+
+	seen := map[string]int{}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			for _, marker := range managedMarkers {
+				if strings.Contains(trimmed, marker) {
+					existing.Managed = true
+				}
+			}
+			continue
+		}
+		if trimmed == "" {
+			continue
+		}
+
+		// A line starting with whitespace continues the previous entry
+		if line[0] == ' ' || line[0] == '\t' {
+			if n := len(existing.Entries); n > 0 {
+				existing.Entries[n-1].Value += " " + trimmed
+			}
+			continue
+		}
+
+		fields := strings.Fields(trimmed)
+		entry := MapEntry{Line: lineNo, Key: fields[0], Value: strings.Join(fields[1:], " ")}
+		if entry.Value == "" {
+			existing.Conflicts = append(existing.Conflicts, ImportConflict{Line: lineNo, Key: entry.Key, Reason: "entry has no value"})
+			continue
+		}
+		if first, ok := seen[entry.Key]; ok {
+			existing.Conflicts = append(existing.Conflicts, ImportConflict{
+				Line: lineNo, Key: entry.Key, Reason: fmt.Sprintf("duplicate of line %d, which Postfix uses instead", first),
+			})
+			continue
+		}
+		seen[entry.Key] = lineNo
+		existing.Entries = append(existing.Entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return existing, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return existing, nil
+}
+
+// ImportTransportMaps converts transport entries to transport maps. Only
+// smtp transports to a host can be managed; other entries are conflicts.
+func ImportTransportMaps(entries []MapEntry) ([]TransportMap, []ImportConflict) {
+	maps := []TransportMap{}
+	conflicts := []ImportConflict{}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Value, "smtp:") || strings.Contains(e.Value, " ") {
+			conflicts = append(conflicts, ImportConflict{Line: e.Line, Key: e.Key, Reason: "only smtp:[host]:port transports can be managed"})
+			continue
+		}
+		tm := TransportMap{Domain: strings.TrimPrefix(ParseMapKey(e.Key), "@"), Transport: e.Value, Port: 25, Enabled: true}
+		rest := strings.TrimPrefix(e.Value, "smtp:")
+		if idx := strings.LastIndex(rest, ":"); idx > 0 && !strings.HasSuffix(rest, "]") {
+			fmt.Sscanf(rest[idx+1:], "%d", &tm.Port)
+			rest = rest[:idx]
+		}
+		if !strings.HasPrefix(rest, "[") || !strings.HasSuffix(rest, "]") || len(rest) == 2 {
+			// Managed entries always bracket the host, which turns off MX lookups
+			conflicts = append(conflicts, ImportConflict{Line: e.Line, Key: e.Key, Reason: "only smtp:[host]:port transports can be managed"})
+			continue
+		}
+		tm.NextHop = strings.Trim(rest, "[]")
+		maps = append(maps, tm)
+	}
+	return maps, conflicts
+}
+
+// ImportSenderRelays converts sender_relay entries to sender-dependent
+// relays
+func ImportSenderRelays(entries []MapEntry) ([]SenderDependentRelay, []ImportConflict) {
+	relays := []SenderDependentRelay{}
+	conflicts := []ImportConflict{}
+	for _, e := range entries {
+		if strings.Contains(e.Value, " ") {
+			conflicts = append(conflicts, ImportConflict{Line: e.Line, Key: e.Key, Reason: "relay host can't contain spaces"})
+			continue
+		}
+		relays = append(relays, SenderDependentRelay{Sender: ParseMapKey(e.Key), Relayhost: e.Value, Enabled: true})
+	}
+	return relays, conflicts
+}
+
+// SASLCredential is one relay host's, or with sender-dependent
+// authentication one sender's, login from sasl_passwd
+type SASLCredential struct {
+	Relayhost string
+	Sender    string
+	Username  string
+	Password  string
+}
+
+// Key returns the sasl_passwd key: the sender if there is one, else the
+// relay host
+func (c SASLCredential) Key() string {
+	if c.Sender != "" {
+		return c.Sender
+	}
+	return c.Relayhost
+}
+
+// ImportSASLCredentials converts sasl_passwd entries to credentials
+func ImportSASLCredentials(entries []MapEntry) ([]SASLCredential, []ImportConflict) {
+	creds := []SASLCredential{}
+	conflicts := []ImportConflict{}
+	for _, e := range entries {
+		username, password, ok := strings.Cut(e.Value, ":")
+		if !ok || username == "" || strings.Contains(e.Value, " ") {
+			conflicts = append(conflicts, ImportConflict{Line: e.Line, Key: e.Key, Reason: "expected username:password"})
+			continue
+		}
+		c := SASLCredential{Relayhost: e.Key, Username: username, Password: password}
+		if IsSenderKey(e.Key) {
+			c = SASLCredential{Sender: e.Key, Username: username, Password: password}
+		}
+		creds = append(creds, c)
+	}
+	return creds, conflicts
+}
+
+// AdoptSASLCredentials replaces sasl_passwd with creds in the suite's own
+// format and points smtp_sasl_password_maps at it
+func (m *ConfigManager) AdoptSASLCredentials(creds []SASLCredential) error {
+	entries := make(map[string]string, len(creds))
+	for _, c := range creds {
+		entries[c.Key()] = c.Username + ":" + c.Password
+	}
+
+	m.mu.Lock()
+	saslPasswdPath := filepath.Join(m.configDir, "sasl_passwd")
+	mapType := MapType("sasl_passwd")
+	err := os.WriteFile(saslPasswdPath, []byte(renderSASLPasswd(entries)), 0600)
+	if err == nil {
+		err = CompileMap(mapType, saslPasswdPath, true)
+	}
+	m.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to write sasl_passwd: %w", err)
+	}
+	return m.UpdateConfig(map[string]string{
+		"smtp_sasl_password_maps":              MapRef(mapType, saslPasswdPath),
+		"smtp_sender_dependent_authentication": senderAuthentication(entries),
+	})
+}
+
+// MaskMapValues hides the values of a map that holds secrets
+func MaskMapValues(entries []MapEntry) []MapEntry {
+	masked := make([]MapEntry, len(entries))
+	for i, e := range entries {
+		masked[i] = e
+		if username, _, ok := strings.Cut(e.Value, ":"); ok {
+			masked[i].Value = username + ":********"
+		} else {
+			masked[i].Value = "********"
+		}
+	}
+	return masked
+}
+
+// VirtualAlias is one source and destination from the virtual map
+type VirtualAlias struct {
+	Line        int
+	Source      string
+	Destination string
+}
+
+// ImportVirtualAliases converts virtual entries to aliases, one per
+// destination. Lines mapping a domain to itself, which the suite writes for
+// its mail domains, and virtual alias domain lines aren't aliases.
+func ImportVirtualAliases(entries []MapEntry) ([]VirtualAlias, []ImportConflict) {
+	aliases := []VirtualAlias{}
+	conflicts := []ImportConflict{}
+	for _, e := range entries {
+		if !strings.Contains(e.Key, "@") {
+			conflicts = append(conflicts, ImportConflict{Line: e.Line, Key: e.Key, Reason: "virtual alias domains are managed as mail domains"})
+			continue
+		}
+		if strings.HasPrefix(e.Key, "@") && e.Value == e.Key {
+			continue
+		}
+		for _, dest := range strings.FieldsFunc(e.Value, func(r rune) bool { return r == ',' || r == ' ' }) {
+			aliases = append(aliases, VirtualAlias{Line: e.Line, Source: strings.ToLower(e.Key), Destination: strings.ToLower(dest)})
+		}
+	}
+	return aliases, conflicts
+}
+
+// ImportBackscatterDomains returns the domains of access entries that
+// verify recipients, the only access rule the suite manages
+func ImportBackscatterDomains(entries []MapEntry) ([]string, []ImportConflict) {
+	domains := []string{}
+	conflicts := []ImportConflict{}
+	for _, e := range entries {
+		key := strings.TrimPrefix(ParseMapKey(e.Key), "@")
+		if e.Value != backscatterRestriction || strings.Contains(key, "@") {
+			conflicts = append(conflicts, ImportConflict{Line: e.Line, Key: e.Key, Reason: "only " + backscatterRestriction + " domain entries can be managed"})
+			continue
+		}
+		domains = append(domains, strings.ToLower(key))
+	}
+	return domains, conflicts
+}
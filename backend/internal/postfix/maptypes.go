@@ -0,0 +1,252 @@
+package postfix
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Lookup table types the map writers can produce. Indexed types are
+// compiled with postmap; texthash, regexp and pcre files are read by
+// Postfix as they are.
+const (
+	MapHash     = "hash"
+	MapLMDB     = "lmdb"
+	MapCDB      = "cdb"
+	MapTexthash = "texthash"
+	MapRegexp   = "regexp"
+	MapPCRE     = "pcre"
+)
+
+// keyedMapTypes are the types for exact-key maps, in order of preference
+// when a map's type is left to be detected
+var keyedMapTypes = []string{MapHash, MapLMDB, MapCDB, MapTexthash}
+
+// ManagedMap is a lookup table written by the suite
+type ManagedMap struct {
+	Name       string   `json:"name"`       // file name, and the map_type_<name> setting
+	Parameters []string `json:"parameters"` // main.cf parameters that use it
+	Patterns   bool     `json:"patterns"`   // whether regexp and pcre make sense for its keys
+}
+
+// ManagedMaps lists the maps whose lookup table type can be chosen
+var ManagedMaps = []ManagedMap{
+	{Name: "transport", Parameters: []string{"transport_maps"}, Patterns: true},
+	{Name: "sender_relay", Parameters: []string{"sender_dependent_relayhost_maps"}, Patterns: true},
+	{Name: "sasl_passwd", Parameters: []string{"smtp_sasl_password_maps"}},
+	{Name: "vmailbox", Parameters: []string{"virtual_mailbox_maps"}},
+	{Name: "virtual", Parameters: []string{"virtual_alias_maps"}},
+	{Name: "archive_bcc", Parameters: []string{"sender_bcc_maps", "recipient_bcc_maps"}, Patterns: true},
+	{Name: "backscatter", Parameters: []string{"smtpd_recipient_restrictions"}, Patterns: true},
+	{Name: "sender_transport", Parameters: []string{"sender_dependent_default_transport_maps"}, Patterns: true},
+}
+
+// FindManagedMap returns the managed map with the given name
+func FindManagedMap(name string) (ManagedMap, bool) {
+	for _, m := range ManagedMaps {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return ManagedMap{}, false
+}
+
+// AllowedMapTypes returns the types a map can use
+func (m ManagedMap) AllowedMapTypes() []string {
+	types := append([]string(nil), keyedMapTypes...)
+	if m.Patterns {
+		types = append(types, MapRegexp, MapPCRE)
+	}
+	return types
+}
+
+// IsIndexed reports whether a map type is compiled with postmap
+func IsIndexed(mapType string) bool {
+	switch mapType {
+	case MapHash, MapLMDB, MapCDB, "btree", "dbm", "sdbm":
+		return true
+	}
+	return false
+}
+
+// IsPattern reports whether a map type matches its keys as patterns
+func IsPattern(mapType string) bool {
+	return mapType == MapRegexp || mapType == MapPCRE
+}
+
+var (
+	supportedMu    sync.Mutex
+	supportedTypes []string
+
+	mapTypesMu sync.RWMutex
+	mapTypes   = map[string]string{}
+)
+
+// SupportedMapTypes returns the lookup table types this Postfix build
+// supports, from postconf -m. The result is cached once it succeeds.
+func SupportedMapTypes() ([]string, error) {
+	supportedMu.Lock()
+	defer supportedMu.Unlock()
+
+	if supportedTypes != nil {
+		return supportedTypes, nil
+	}
+	output, err := exec.Command("postconf", "-m").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run postconf -m: %w", err)
+	}
+	supportedTypes = strings.Fields(string(output))
+	return supportedTypes, nil
+}
+
+// DefaultMapType returns the first of hash, lmdb, cdb and texthash this
+// Postfix supports. hash is assumed if postconf can't be run.
+func DefaultMapType() string {
+	supported, err := SupportedMapTypes()
+	if err != nil {
+		return MapHash
+	}
+	for _, t := range keyedMapTypes {
+		for _, s := range supported {
+			if s == t {
+				return t
+			}
+		}
+	}
+	return MapTexthash // built into every Postfix
+}
+
+// SetMapTypes sets the lookup table type of each managed map by name; an
+// empty type means DefaultMapType
+func SetMapTypes(types map[string]string) {
+	mapTypesMu.Lock()
+	defer mapTypesMu.Unlock()
+
+	mapTypes = make(map[string]string, len(types))
+	for name, t := range types {
+		mapTypes[name] = t
+	}
+}
+
+// MapType returns the lookup table type a managed map is written as
+func MapType(name string) string {
+	mapTypesMu.RLock()
+	t := mapTypes[name]
+	mapTypesMu.RUnlock()
+
+	if t == "" {
+		return DefaultMapType()
+	}
+	return t
+}
+
+// MapRef returns the type:path reference main.cf uses for a map file
+func MapRef(mapType, path string) string {
+	return mapType + ":" + path
+}
+
+// CompileMap runs postmap for indexed map types, through sudo if asked.
+// Other types need no compiling.
+func CompileMap(mapType, path string, sudo bool) error {
+	if !IsIndexed(mapType) {
+		return nil
+	}
+	args := []string{"postmap", MapRef(mapType, path)}
+	if sudo {
+		args = append([]string{"sudo"}, args...)
+	}
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to run postmap: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// MapKey returns the lookup key for an address, @domain or domain as it
+// is written in a map of the given type. Pattern maps are queried with
+// the full address, so a domain or @domain matches any address in it.
+func MapKey(mapType, key string) string {
+	if !IsPattern(mapType) {
+		return key
+	}
+	quoted := strings.ReplaceAll(regexp.QuoteMeta(key), "/", `\/`)
+	switch {
+	case strings.HasPrefix(key, "@"):
+		return "/" + quoted + "$/"
+	case strings.Contains(key, "@"):
+		return "/^" + quoted + "$/"
+	default:
+		return "/@" + quoted + "$/"
+	}
+}
+
+// unescapeRegexp undoes regexp.QuoteMeta
+var unescapeRegexp = regexp.MustCompile(`\\(.)`)
+
+// ParseMapKey turns a key written by MapKey back into an address or
+// @domain. Keys that aren't patterns are returned as they are.
+func ParseMapKey(key string) string {
+	if len(key) < 2 || !strings.HasPrefix(key, "/") || !strings.HasSuffix(key, "/") {
+		return key
+	}
+	key = strings.TrimSuffix(strings.TrimPrefix(key[1:len(key)-1], "^"), "$")
+	return unescapeRegexp.ReplaceAllString(key, "$1")
+}
+
+// ReplaceMapRef points the given main.cf parameters' references to the map
+// file at path to ref. Parameters that don't use the file are left alone.
+func (m *ConfigManager) ReplaceMapRef(parameters []string, path, ref string) error {
+	m.mu.RLock()
+	params, err := m.parseMainCf(filepath.Join(m.configDir, "main.cf"))
+	m.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	updates := map[string]string{}
+	for _, p := range parameters {
+		value, ok := params[p]
+		if !ok {
+			continue
+		}
+		// References are replaced where they stand, so restriction lists
+		// that mention the map keep their layout
+		updated := value
+		for _, existing := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+			if idx := strings.Index(existing, ":"); idx > 0 && existing[idx+1:] == path && existing != ref {
+				updated = strings.ReplaceAll(updated, existing, ref)
+			}
+		}
+		if updated != value {
+			updates[p] = updated
+		}
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return m.UpdateConfig(updates)
+}
+
+// MapPath returns the file of a map the ConfigManager writes (transport,
+// sender_relay, sasl_passwd, backscatter or sender_transport)
+func (m *ConfigManager) MapPath(name string) string {
+	return filepath.Join(m.configDir, name)
+}
+
+// RecompileSASLCredentials rebuilds the SASL password lookup table, if
+// there is one, as the currently configured map type
+func (m *ConfigManager) RecompileSASLCredentials() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saslPasswdPath := filepath.Join(m.configDir, "sasl_passwd")
+	if _, err := os.Stat(saslPasswdPath); os.IsNotExist(err) {
+		return nil
+	}
+	return CompileMap(MapType("sasl_passwd"), saslPasswdPath, true)
+}
@@ -0,0 +1,346 @@
+package postfix
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileChange describes how a generated file would change, for dry runs
+type FileChange struct {
+	Path    string `json:"path"`
+	Changed bool   `json:"changed"`
+	Diff    string `json:"diff,omitempty"`    // unified diff against the file on disk
+	Content string `json:"content,omitempty"` // the whole new file, for staged map previews
+}
+
+// DiffFile compares the file at path with the content it would be written
+// with. A missing file counts as empty.
+func DiffFile(path, content string) (FileChange, error) {
+	before, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return FileChange{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return diffChange(path, string(before), content), nil
+}
+
+func diffChange(path, before, after string) FileChange {
+	diff := UnifiedDiff(path, before, after)
+	return FileChange{Path: path, Changed: diff != "", Diff: diff}
+}
+
+// PreviewConfig returns the main.cf change WriteConfig would make for cfg.
+// The "Last modified" header is left out of the comparison.
+func (m *ConfigManager) PreviewConfig(cfg *Config) (FileChange, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	mainCfPath := filepath.Join(m.configDir, "main.cf")
+	params, err := m.mergedParams(cfg)
+	if err != nil {
+		return FileChange{}, err
+	}
+
+	before, err := os.ReadFile(mainCfPath)
+	if err != nil && !os.IsNotExist(err) {
+		return FileChange{}, fmt.Errorf("failed to read config: %w", err)
+	}
+	return diffChange(mainCfPath, withoutModified(string(before)), withoutModified(renderMainCf(params, time.Now()))), nil
+}
+
+// mergedParams returns the main.cf parameters WriteConfig would write for
+// cfg. Callers hold m.mu.
+func (m *ConfigManager) mergedParams(cfg *Config) (map[string]string, error) {
+	params, err := m.parseMainCf(filepath.Join(m.configDir, "main.cf"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	for key, value := range m.configToMap(cfg) {
+		if value != "" {
+			params[key] = value
+		} else {
+			delete(params, key)
+		}
+	}
+	return params, nil
+}
+
+// RenderedConfig is main.cf as WriteConfig would write it, and what
+// postconf makes of it
+type RenderedConfig struct {
+	MainCf string `json:"mainCf"`
+	// Postconf is the postconf -n output: the parameters that differ from
+	// Postfix's defaults, as Postfix reads them
+	Postconf string   `json:"postconf"`
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// RenderConfig renders main.cf for cfg into a scratch directory, next to a
+// copy of master.cf, and runs postconf -n against it. The live
+// configuration is neither written nor reloaded.
+func (m *ConfigManager) RenderConfig(cfg *Config) (*RenderedConfig, error) {
+	m.mu.RLock()
+	params, err := m.mergedParams(cfg)
+	master, masterErr := os.ReadFile(filepath.Join(m.configDir, "master.cf"))
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if masterErr != nil && !os.IsNotExist(masterErr) {
+		return nil, fmt.Errorf("failed to read master.cf: %w", masterErr)
+	}
+
+	rendered := &RenderedConfig{MainCf: renderMainCf(params, time.Now())}
+
+	dir, err := os.MkdirTemp("", "postfixrelay-render-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "main.cf"), []byte(rendered.MainCf), 0640); err != nil {
+		return nil, fmt.Errorf("failed to write scratch main.cf: %w", err)
+	}
+	if masterErr == nil {
+		if err := os.WriteFile(filepath.Join(dir, "master.cf"), master, 0640); err != nil {
+			return nil, fmt.Errorf("failed to write scratch master.cf: %w", err)
+		}
+	}
+
+	var stdout, stderr strings.Builder
+	// The scratch directory belongs to the service user, so postconf runs
+	// without sudo; the sudoers rules only cover /etc/postfix
+	cmd := exec.Command("postconf", "-c", dir, "-n")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	runErr := cmd.Run()
+	rendered.Postconf = stdout.String()
+
+	// postconf reports problems on stderr, prefixed "postconf: warning:"
+	// or "postconf: fatal:"; the scratch path means nothing to the caller
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		line = strings.TrimSpace(strings.ReplaceAll(line, dir+"/", ""))
+		switch {
+		case line == "":
+		case strings.Contains(line, "warning:"):
+			rendered.Warnings = append(rendered.Warnings, line)
+		default:
+			rendered.Errors = append(rendered.Errors, line)
+		}
+	}
+	if runErr != nil && len(rendered.Errors) == 0 {
+		rendered.Errors = append(rendered.Errors, fmt.Sprintf("postconf failed: %v", runErr))
+	}
+	rendered.Valid = runErr == nil && len(rendered.Errors) == 0
+	return rendered, nil
+}
+
+// withoutModified drops the "Last modified" header line from main.cf content
+func withoutModified(content string) string {
+	lines := strings.Split(content, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "# Last modified:") {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// PreviewAddTransportMap returns the transport file change AddTransportMap
+// would make
+func (m *ConfigManager) PreviewAddTransportMap(tm TransportMap) (FileChange, error) {
+	maps, err := m.GetTransportMaps()
+	if err != nil {
+		return FileChange{}, err
+	}
+	if maps, err = addTransportMap(maps, tm); err != nil {
+		return FileChange{}, err
+	}
+	return DiffFile(filepath.Join(m.configDir, "transport"), renderTransportMaps(maps, MapType("transport")))
+}
+
+// PreviewUpdateTransportMap returns the transport file change
+// UpdateTransportMap would make
+func (m *ConfigManager) PreviewUpdateTransportMap(domain string, tm TransportMap) (FileChange, error) {
+	maps, err := m.GetTransportMaps()
+	if err != nil {
+		return FileChange{}, err
+	}
+	if maps, err = updateTransportMap(maps, domain, tm); err != nil {
+		return FileChange{}, err
+	}
+	return DiffFile(filepath.Join(m.configDir, "transport"), renderTransportMaps(maps, MapType("transport")))
+}
+
+// PreviewDeleteTransportMap returns the transport file change
+// DeleteTransportMap would make
+func (m *ConfigManager) PreviewDeleteTransportMap(domain string) (FileChange, error) {
+	maps, err := m.GetTransportMaps()
+	if err != nil {
+		return FileChange{}, err
+	}
+	if maps, err = deleteTransportMap(maps, domain); err != nil {
+		return FileChange{}, err
+	}
+	return DiffFile(filepath.Join(m.configDir, "transport"), renderTransportMaps(maps, MapType("transport")))
+}
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// maxDiffCells bounds the line comparison table; a larger changed region
+// is shown as removed and re-added in full
+const maxDiffCells = 4 << 20
+
+type diffOp struct {
+	kind         byte // ' ', '-' or '+'
+	text         string
+	aLine, bLine int // 1-based line numbers before and after
+}
+
+// UnifiedDiff returns a unified diff between two versions of a file, or ""
+// if they are the same
+func UnifiedDiff(path, before, after string) string {
+	if before == after {
+		return ""
+	}
+	ops := diffLines(splitLines(before), splitLines(after))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", path, path)
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].kind == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		// A hunk runs until the next stretch of more than two contexts'
+		// worth of unchanged lines
+		start, end := max(i-diffContext, 0), i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContext {
+				end = min(end+diffContext, len(ops))
+				break
+			}
+			end = next
+		}
+
+		aStart, bStart := ops[start].aLine, ops[start].bLine
+		aCount, bCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		if aCount == 0 {
+			aStart--
+		}
+		if bCount == 0 {
+			bStart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aCount, bStart, bCount)
+		for _, op := range ops[start:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.text)
+			out.WriteByte('\n')
+		}
+		i = end
+	}
+	return out.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines lines up a and b by their longest common subsequence, after
+// setting aside a common prefix and suffix
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	am, bm := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	var ops []diffOp
+	ai, bi := 0, 0
+	emit := func(kind byte, text string) {
+		ops = append(ops, diffOp{kind: kind, text: text, aLine: ai + 1, bLine: bi + 1})
+		if kind != '+' {
+			ai++
+		}
+		if kind != '-' {
+			bi++
+		}
+	}
+
+	for _, line := range a[:prefix] {
+		emit(' ', line)
+	}
+	n, m := len(am), len(bm)
+	if n*m > maxDiffCells {
+		for _, line := range am {
+			emit('-', line)
+		}
+		for _, line := range bm {
+			emit('+', line)
+		}
+	} else {
+		lcs := make([][]int, n+1)
+		for i := range lcs {
+			lcs[i] = make([]int, m+1)
+		}
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if am[i] == bm[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < n || j < m {
+			switch {
+			case i < n && j < m && am[i] == bm[j]:
+				emit(' ', am[i])
+				i++
+				j++
+			case j < m && (i == n || lcs[i][j+1] > lcs[i+1][j]):
+				emit('+', bm[j])
+				j++
+			default:
+				emit('-', am[i])
+				i++
+			}
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		emit(' ', line)
+	}
+	return ops
+}
@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,14 +24,18 @@ type QueueMessage struct {
 	Status      string    `json:"status"` // active, deferred, hold
 	Size        int64     `json:"size"`
 	ArrivalTime time.Time `json:"arrivalTime"`
-	Sender      string    `json:"sender"`
-	Recipients  []string  `json:"recipients"`
+	Sender      string    `json:"sender" redact:"view:addresses,mask"`
+	Recipients  []string  `json:"recipients" redact:"view:addresses,mask"`
 	Reason      string    `json:"reason,omitempty"`
 }
 
 // QueueManager handles Postfix queue operations
 type QueueManager struct {
 	configDir string
+
+	// demo, when set, is a made-up queue served instead of Postfix's
+	demoMu sync.Mutex
+	demo   []QueueMessage
 }
 
 // NewQueueManager creates a new queue manager
@@ -38,6 +43,44 @@ func NewQueueManager(configDir string) *QueueManager {
 	return &QueueManager{configDir: configDir}
 }
 
+// UseDemoQueue serves the given messages instead of the Postfix queue.
+// Hold, release and delete change them in memory; nothing reaches Postfix.
+func (m *QueueManager) UseDemoQueue(messages []QueueMessage) {
+	m.demoMu.Lock()
+	defer m.demoMu.Unlock()
+	m.demo = append([]QueueMessage{}, messages...)
+}
+
+// demoQueue returns a copy of the demo queue and whether demo mode is on
+func (m *QueueManager) demoQueue() ([]QueueMessage, bool) {
+	m.demoMu.Lock()
+	defer m.demoMu.Unlock()
+	if m.demo == nil {
+		return nil, false
+	}
+	return append([]QueueMessage{}, m.demo...), true
+}
+
+// updateDemo applies fn to the demo message with queueID, or removes it
+// when fn returns false. It reports whether demo mode is on.
+func (m *QueueManager) updateDemo(queueID string, fn func(*QueueMessage) bool) (bool, error) {
+	m.demoMu.Lock()
+	defer m.demoMu.Unlock()
+	if m.demo == nil {
+		return false, nil
+	}
+	for i := range m.demo {
+		if m.demo[i].QueueID != queueID {
+			continue
+		}
+		if !fn(&m.demo[i]) {
+			m.demo = append(m.demo[:i], m.demo[i+1:]...)
+		}
+		return true, nil
+	}
+	return true, fmt.Errorf("message not found: %s", queueID)
+}
+
 // ValidateQueueID validates that a queue ID matches the expected Postfix format
 // Queue IDs are 10-12 uppercase hexadecimal characters
 func ValidateQueueID(queueID string) error {
@@ -49,17 +92,19 @@ func ValidateQueueID(queueID string) error {
 
 // ListMessages returns all messages in the queue
 func (m *QueueManager) ListMessages(statusFilter string) ([]QueueMessage, error) {
-	cmd := exec.Command("mailq")
-	output, err := cmd.Output()
-	if err != nil {
-		// mailq returns exit code 1 if queue is empty
-		if len(output) == 0 {
-			return []QueueMessage{}, nil
+	messages, demo := m.demoQueue()
+	if !demo {
+		cmd := exec.Command("mailq")
+		output, err := cmd.Output()
+		if err != nil {
+			// mailq returns exit code 1 if queue is empty
+			if len(output) == 0 {
+				return []QueueMessage{}, nil
+			}
 		}
+		messages = ParseMailq(string(output))
 	}
 
-	messages := m.parseMailq(string(output))
-
 	// Filter by status if requested
 	if statusFilter != "" {
 		filtered := make([]QueueMessage, 0)
@@ -105,6 +150,13 @@ func (m *QueueManager) HoldMessage(queueID string) error {
 		return err
 	}
 
+	if demo, err := m.updateDemo(queueID, func(msg *QueueMessage) bool {
+		msg.Status = "hold"
+		return true
+	}); demo {
+		return err
+	}
+
 	// Use wrapper script via sudo for additional security
 	cmd := exec.Command("sudo", safePostsuperScript, "-h", queueID)
 	output, err := cmd.CombinedOutput()
@@ -121,6 +173,13 @@ func (m *QueueManager) ReleaseMessage(queueID string) error {
 		return err
 	}
 
+	if demo, err := m.updateDemo(queueID, func(msg *QueueMessage) bool {
+		msg.Status = "deferred"
+		return true
+	}); demo {
+		return err
+	}
+
 	// Use wrapper script via sudo for additional security
 	cmd := exec.Command("sudo", safePostsuperScript, "-H", queueID)
 	output, err := cmd.CombinedOutput()
@@ -130,6 +189,34 @@ func (m *QueueManager) ReleaseMessage(queueID string) error {
 	return nil
 }
 
+// safePostcatScript is the path to the wrapper script for postcat
+const safePostcatScript = "/opt/postfixrelay/scripts/safe-postcat.sh"
+
+// GetMessageContent returns the headers and body of a queued message
+func (m *QueueManager) GetMessageContent(queueID string) ([]byte, error) {
+	// Validate queue ID to prevent command injection (defense in depth)
+	if err := ValidateQueueID(queueID); err != nil {
+		return nil, err
+	}
+
+	if messages, demo := m.demoQueue(); demo {
+		for _, msg := range messages {
+			if msg.QueueID == queueID {
+				return []byte(fmt.Sprintf("From: <%s>\nTo: <%s>\nSubject: Demo message %s\nDate: %s\n\nThis message is demo data; no mail server is attached.\n",
+					msg.Sender, strings.Join(msg.Recipients, ">, <"), queueID, msg.ArrivalTime.Format(time.RFC1123Z))), nil
+			}
+		}
+		return nil, fmt.Errorf("message not found: %s", queueID)
+	}
+
+	cmd := exec.Command("sudo", safePostcatScript, queueID)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message content: %w", err)
+	}
+	return output, nil
+}
+
 // DeleteMessage deletes a message from the queue
 func (m *QueueManager) DeleteMessage(queueID string) error {
 	// Validate queue ID to prevent command injection (defense in depth)
@@ -137,6 +224,10 @@ func (m *QueueManager) DeleteMessage(queueID string) error {
 		return err
 	}
 
+	if demo, err := m.updateDemo(queueID, func(*QueueMessage) bool { return false }); demo {
+		return err
+	}
+
 	// Use wrapper script via sudo for additional security
 	cmd := exec.Command("sudo", safePostsuperScript, "-d", queueID)
 	output, err := cmd.CombinedOutput()
@@ -146,8 +237,45 @@ func (m *QueueManager) DeleteMessage(queueID string) error {
 	return nil
 }
 
+// DeleteAll deletes every queued message, or with queue "deferred" only
+// the deferred ones
+func (m *QueueManager) DeleteAll(queue string) error {
+	args := []string{safePostsuperScript, "-d", "ALL"}
+	switch queue {
+	case "":
+	case "deferred":
+		args = append(args, queue)
+	default:
+		return fmt.Errorf("unsupported queue: %s", queue)
+	}
+
+	m.demoMu.Lock()
+	if m.demo != nil {
+		kept := []QueueMessage{}
+		for _, msg := range m.demo {
+			if queue != "" && msg.Status != queue {
+				kept = append(kept, msg)
+			}
+		}
+		m.demo = kept
+		m.demoMu.Unlock()
+		return nil
+	}
+	m.demoMu.Unlock()
+
+	cmd := exec.Command("sudo", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to delete messages: %s", strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
 // FlushQueue attempts to deliver all queued messages
 func (m *QueueManager) FlushQueue() error {
+	if _, demo := m.demoQueue(); demo {
+		return nil
+	}
 	cmd := exec.Command("postqueue", "-f")
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -158,6 +286,9 @@ func (m *QueueManager) FlushQueue() error {
 
 // RequeueMessages requeues all messages (useful after config changes)
 func (m *QueueManager) RequeueMessages() error {
+	if _, demo := m.demoQueue(); demo {
+		return nil
+	}
 	cmd := exec.Command("postsuper", "-r", "ALL")
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -166,8 +297,8 @@ func (m *QueueManager) RequeueMessages() error {
 	return nil
 }
 
-// parseMailq parses the output of the mailq command
-func (m *QueueManager) parseMailq(output string) []QueueMessage {
+// ParseMailq parses the output of the mailq command
+func ParseMailq(output string) []QueueMessage {
 	var messages []QueueMessage
 
 	// mailq format:
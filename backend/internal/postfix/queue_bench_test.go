@@ -0,0 +1,72 @@
+package postfix_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/demo"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+)
+
+// benchQueueSize is how many messages the queue benchmarks list
+const benchQueueSize = 50000
+
+// BenchmarkParseMailq measures parsing mailq output for a queue of
+// benchQueueSize messages. One op is one full listing.
+func BenchmarkParseMailq(b *testing.B) {
+	output := mailqOutput(demo.LargeQueue(time.Now(), benchQueueSize))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if n := len(postfix.ParseMailq(output)); n != benchQueueSize {
+			b.Fatalf("parsed %d messages, want %d", n, benchQueueSize)
+		}
+	}
+}
+
+// BenchmarkListMessages measures what the queue endpoint does past mailq:
+// filtering a queue of benchQueueSize messages by status and encoding it
+// as JSON. One op is one listing.
+func BenchmarkListMessages(b *testing.B) {
+	qm := postfix.NewQueueManager("")
+	qm.UseDemoQueue(demo.LargeQueue(time.Now(), benchQueueSize))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		messages, err := qm.ListMessages("deferred")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := json.Marshal(messages); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// mailqOutput formats messages the way mailq prints them
+func mailqOutput(messages []postfix.QueueMessage) string {
+	var sb strings.Builder
+	sb.WriteString("-Queue ID-  --Size-- ----Arrival Time---- -Sender/Recipient-------\n")
+	for _, m := range messages {
+		mark := ""
+		switch m.Status {
+		case "active":
+			mark = "*"
+		case "hold":
+			mark = "!"
+		}
+		fmt.Fprintf(&sb, "%s%s %9d %s  %s\n", m.QueueID, mark, m.Size, m.ArrivalTime.Format("Mon Jan _2 15:04:05"), m.Sender)
+		if m.Reason != "" {
+			fmt.Fprintf(&sb, "(%s)\n", m.Reason)
+		}
+		for _, rcpt := range m.Recipients {
+			fmt.Fprintf(&sb, "%41s%s\n", "", rcpt)
+		}
+		sb.WriteString("\n")
+	}
+	fmt.Fprintf(&sb, "-- %d Kbytes in %d Requests.\n", len(messages), len(messages))
+	return sb.String()
+}
@@ -0,0 +1,156 @@
+package postfix
+
+import (
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// Bulk queue actions and the postsuper flag each runs
+var bulkActionFlags = map[string]string{
+	"hold":    "-h",
+	"release": "-H",
+	"delete":  "-d",
+	"requeue": "-r",
+}
+
+// ValidBulkAction reports whether action is one BulkAction accepts
+func ValidBulkAction(action string) bool {
+	_, ok := bulkActionFlags[action]
+	return ok
+}
+
+// QueueFilter selects queued messages for a bulk action. Empty fields
+// match every message.
+type QueueFilter struct {
+	Status string // active, deferred or hold
+	// Sender and Recipient are case-insensitive patterns where * matches
+	// any run of characters and ? one character, e.g. "*@example.com". A
+	// message matches Recipient when any of its recipients does.
+	Sender    string
+	Recipient string
+	// MinAge and MaxAge bound the time since the message arrived
+	MinAge time.Duration
+	MaxAge time.Duration
+	// Relay matches the deferral reason, which names the host Postfix
+	// failed to reach, e.g. "mx.example.com" or "[192.0.2.1]"
+	Relay string
+}
+
+// ValidatePattern checks a sender or recipient pattern
+func ValidatePattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("malformed pattern %q", pattern)
+	}
+	return nil
+}
+
+func matchPattern(pattern, s string) bool {
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(s))
+	return ok
+}
+
+// Match reports whether msg is selected by f at the given time
+func (f QueueFilter) Match(msg QueueMessage, now time.Time) bool {
+	if f.Status != "" && msg.Status != f.Status {
+		return false
+	}
+	if f.Sender != "" && !matchPattern(f.Sender, msg.Sender) {
+		return false
+	}
+	if f.Recipient != "" {
+		found := false
+		for _, rcpt := range msg.Recipients {
+			if matchPattern(f.Recipient, rcpt) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	age := now.Sub(msg.ArrivalTime)
+	if f.MinAge > 0 && age < f.MinAge {
+		return false
+	}
+	if f.MaxAge > 0 && age > f.MaxAge {
+		return false
+	}
+	if f.Relay != "" && !strings.Contains(strings.ToLower(msg.Reason), strings.ToLower(f.Relay)) {
+		return false
+	}
+	return true
+}
+
+// SelectMessages returns the queued messages matching f
+func (m *QueueManager) SelectMessages(f QueueFilter) ([]QueueMessage, error) {
+	messages, err := m.ListMessages(f.Status)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	selected := []QueueMessage{}
+	for _, msg := range messages {
+		if f.Match(msg, now) {
+			selected = append(selected, msg)
+		}
+	}
+	return selected, nil
+}
+
+// BulkAction holds, releases, deletes or requeues the given messages with
+// a single postsuper run. Messages that left the queue in the meantime are
+// skipped by postsuper.
+func (m *QueueManager) BulkAction(action string, queueIDs []string) error {
+	flag, ok := bulkActionFlags[action]
+	if !ok {
+		return fmt.Errorf("unsupported queue action: %s", action)
+	}
+	for _, id := range queueIDs {
+		if err := ValidateQueueID(id); err != nil {
+			return err
+		}
+	}
+	if len(queueIDs) == 0 {
+		return nil
+	}
+
+	m.demoMu.Lock()
+	if m.demo != nil {
+		selected := map[string]bool{}
+		for _, id := range queueIDs {
+			selected[id] = true
+		}
+		kept := []QueueMessage{}
+		for _, msg := range m.demo {
+			if selected[msg.QueueID] {
+				switch action {
+				case "delete":
+					continue
+				case "hold":
+					msg.Status = "hold"
+				case "release":
+					if msg.Status == "hold" {
+						msg.Status = "deferred"
+					}
+				}
+			}
+			kept = append(kept, msg)
+		}
+		m.demo = kept
+		m.demoMu.Unlock()
+		return nil
+	}
+	m.demoMu.Unlock()
+
+	cmd := exec.Command("sudo", safePostsuperScript, flag, "-")
+	cmd.Stdin = strings.NewReader(strings.Join(queueIDs, "\n") + "\n")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to %s messages: %s", action, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
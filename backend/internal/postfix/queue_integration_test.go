@@ -0,0 +1,268 @@
+//go:build integration
+
+package postfix_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+	"github.com/postfixrelay/postfixrelay/internal/testharness"
+)
+
+func queueFixture(t *testing.T) (*testharness.Postfix, *postfix.QueueManager) {
+	t.Helper()
+	pf := testharness.NewPostfix(t)
+	now := time.Now()
+	pf.AddMessage(testharness.QueueEntry{QueueID: "A1B2C3D4E5", Status: "deferred", Arrival: now.Add(-3 * time.Hour),
+		Sender: "alerts@example.com", Recipients: []string{"ops@example.net"},
+		Reason: "connect to mx.example.net[192.0.2.10]:25: Connection timed out"})
+	pf.AddMessage(testharness.QueueEntry{QueueID: "B1B2C3D4E5", Status: "deferred", Arrival: now.Add(-10 * time.Minute),
+		Sender: "billing@example.com", Recipients: []string{"finance@example.org", "audit@example.org"},
+		Reason: "host mx.example.org[198.51.100.7] said: 451 try again later"})
+	pf.AddMessage(testharness.QueueEntry{QueueID: "C1B2C3D4E5", Status: "active", Arrival: now,
+		Sender: "alerts@example.com", Recipients: []string{"oncall@example.org"}})
+	pf.AddMessage(testharness.QueueEntry{QueueID: "D1B2C3D4E5", Status: "hold", Arrival: now.Add(-time.Hour),
+		Sender: "newsletter@example.com", Recipients: []string{"list@example.net"}})
+	return pf, postfix.NewQueueManager(pf.ConfigDir)
+}
+
+func queueStatuses(pf *testharness.Postfix) map[string]string {
+	statuses := map[string]string{}
+	for _, e := range pf.Queue() {
+		statuses[e.QueueID] = e.Status
+	}
+	return statuses
+}
+
+func TestQueueList(t *testing.T) {
+	_, qm := queueFixture(t)
+
+	messages, err := qm.ListMessages("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 4 {
+		t.Fatalf("ListMessages: got %d messages, want 4", len(messages))
+	}
+	byID := map[string]postfix.QueueMessage{}
+	for _, msg := range messages {
+		byID[msg.QueueID] = msg
+	}
+	b := byID["B1B2C3D4E5"]
+	if b.Status != "deferred" || b.Sender != "billing@example.com" || len(b.Recipients) != 2 {
+		t.Errorf("B1B2C3D4E5 = %+v", b)
+	}
+	if !strings.Contains(b.Reason, "451 try again later") {
+		t.Errorf("B1B2C3D4E5 reason = %q", b.Reason)
+	}
+	if got := byID["C1B2C3D4E5"].Status; got != "active" {
+		t.Errorf("C1B2C3D4E5 status = %q, want active", got)
+	}
+	if got := byID["D1B2C3D4E5"].Status; got != "hold" {
+		t.Errorf("D1B2C3D4E5 status = %q, want hold", got)
+	}
+
+	deferred, err := qm.ListMessages("deferred")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deferred) != 2 {
+		t.Errorf("ListMessages(deferred): got %d messages, want 2", len(deferred))
+	}
+
+	active, deferredCount, hold, _ := qm.GetQueueSummary()
+	if active != 1 || deferredCount != 2 || hold != 1 {
+		t.Errorf("GetQueueSummary = %d active, %d deferred, %d hold; want 1, 2, 1", active, deferredCount, hold)
+	}
+
+	msg, err := qm.GetMessage("A1B2C3D4E5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Recipients[0] != "ops@example.net" {
+		t.Errorf("GetMessage recipients = %v", msg.Recipients)
+	}
+	if _, err := qm.GetMessage("FFFFFFFFFF"); err == nil {
+		t.Error("GetMessage of a missing ID succeeded")
+	}
+}
+
+func TestQueueEmpty(t *testing.T) {
+	pf := testharness.NewPostfix(t)
+	messages, err := postfix.NewQueueManager(pf.ConfigDir).ListMessages("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 0 {
+		t.Errorf("ListMessages on an empty queue = %v", messages)
+	}
+}
+
+func TestQueueHoldReleaseDelete(t *testing.T) {
+	pf, qm := queueFixture(t)
+
+	if err := qm.HoldMessage("A1B2C3D4E5"); err != nil {
+		t.Fatal(err)
+	}
+	if got := queueStatuses(pf)["A1B2C3D4E5"]; got != "hold" {
+		t.Errorf("after hold: status = %q, want hold", got)
+	}
+	if err := qm.ReleaseMessage("D1B2C3D4E5"); err != nil {
+		t.Fatal(err)
+	}
+	if got := queueStatuses(pf)["D1B2C3D4E5"]; got != "deferred" {
+		t.Errorf("after release: status = %q, want deferred", got)
+	}
+	if err := qm.DeleteMessage("B1B2C3D4E5"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := queueStatuses(pf)["B1B2C3D4E5"]; ok {
+		t.Error("B1B2C3D4E5 still queued after delete")
+	}
+
+	// The commands go through the sudo wrappers
+	calls := strings.Join(pf.Calls(), "\n")
+	for _, want := range []string{
+		"sudo /opt/postfixrelay/scripts/safe-postsuper.sh -h A1B2C3D4E5",
+		"sudo /opt/postfixrelay/scripts/safe-postsuper.sh -H D1B2C3D4E5",
+		"sudo /opt/postfixrelay/scripts/safe-postsuper.sh -d B1B2C3D4E5",
+	} {
+		if !strings.Contains(calls, want) {
+			t.Errorf("no %q in calls:\n%s", want, calls)
+		}
+	}
+}
+
+func TestQueueRejectsBadID(t *testing.T) {
+	pf, qm := queueFixture(t)
+	before := len(pf.Calls())
+
+	for _, id := range []string{"", "ALL", "A1B2; rm -rf /", "../etc/passwd"} {
+		if err := qm.DeleteMessage(id); err == nil {
+			t.Errorf("DeleteMessage(%q) succeeded", id)
+		}
+	}
+	if err := qm.BulkAction("delete", []string{"A1B2C3D4E5", "-r ALL"}); err == nil {
+		t.Error("BulkAction with a bad ID succeeded")
+	}
+	if calls := pf.Calls(); len(calls) != before {
+		t.Errorf("commands ran for invalid IDs: %v", calls[before:])
+	}
+	if len(pf.Queue()) != 4 {
+		t.Error("messages were removed for invalid IDs")
+	}
+}
+
+func TestQueueDeleteAll(t *testing.T) {
+	pf, qm := queueFixture(t)
+
+	if err := qm.DeleteAll("deferred"); err != nil {
+		t.Fatal(err)
+	}
+	statuses := queueStatuses(pf)
+	if len(statuses) != 2 || statuses["C1B2C3D4E5"] != "active" || statuses["D1B2C3D4E5"] != "hold" {
+		t.Errorf("after DeleteAll(deferred): queue = %v", statuses)
+	}
+
+	if err := qm.DeleteAll("hold"); err == nil {
+		t.Error("DeleteAll(hold) succeeded")
+	}
+
+	if err := qm.DeleteAll(""); err != nil {
+		t.Fatal(err)
+	}
+	if queue := pf.Queue(); len(queue) != 0 {
+		t.Errorf("after DeleteAll: %d messages left", len(queue))
+	}
+}
+
+func TestQueueSelectAndBulkAction(t *testing.T) {
+	pf, qm := queueFixture(t)
+
+	tests := []struct {
+		name   string
+		filter postfix.QueueFilter
+		want   []string
+	}{
+		{"status", postfix.QueueFilter{Status: "deferred"}, []string{"A1B2C3D4E5", "B1B2C3D4E5"}},
+		{"sender", postfix.QueueFilter{Sender: "ALERTS@example.com"}, []string{"A1B2C3D4E5", "C1B2C3D4E5"}},
+		{"recipient", postfix.QueueFilter{Recipient: "*@example.org"}, []string{"B1B2C3D4E5", "C1B2C3D4E5"}},
+		{"relay", postfix.QueueFilter{Relay: "mx.example.net"}, []string{"A1B2C3D4E5"}},
+		{"min age", postfix.QueueFilter{MinAge: 30 * time.Minute}, []string{"A1B2C3D4E5", "D1B2C3D4E5"}},
+		{"max age", postfix.QueueFilter{MaxAge: 30 * time.Minute}, []string{"B1B2C3D4E5", "C1B2C3D4E5"}},
+		{"combined", postfix.QueueFilter{Status: "deferred", Recipient: "audit@*"}, []string{"B1B2C3D4E5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := qm.SelectMessages(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, msg := range selected {
+				got = append(got, msg.QueueID)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("SelectMessages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if err := qm.BulkAction("hold", []string{"A1B2C3D4E5", "B1B2C3D4E5"}); err != nil {
+		t.Fatal(err)
+	}
+	statuses := queueStatuses(pf)
+	if statuses["A1B2C3D4E5"] != "hold" || statuses["B1B2C3D4E5"] != "hold" {
+		t.Errorf("after bulk hold: queue = %v", statuses)
+	}
+
+	// A message that left the queue meanwhile is skipped
+	if err := qm.BulkAction("delete", []string{"A1B2C3D4E5", "EEEEEEEEEE", "B1B2C3D4E5"}); err != nil {
+		t.Fatal(err)
+	}
+	statuses = queueStatuses(pf)
+	if len(statuses) != 2 {
+		t.Errorf("after bulk delete: queue = %v", statuses)
+	}
+
+	if err := qm.BulkAction("purge", []string{"C1B2C3D4E5"}); err == nil {
+		t.Error("BulkAction(purge) succeeded")
+	}
+}
+
+func TestQueueContentAndFailures(t *testing.T) {
+	pf, qm := queueFixture(t)
+
+	content, err := qm.GetMessageContent("A1B2C3D4E5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "Subject: Queued message A1B2C3D4E5") {
+		t.Errorf("GetMessageContent = %q", content)
+	}
+	if _, err := qm.GetMessageContent("FFFFFFFFFF"); err == nil {
+		t.Error("GetMessageContent of a missing ID succeeded")
+	}
+
+	pf.Fail("postsuper", "postsuper: fatal: queue directory is locked")
+	err = qm.HoldMessage("A1B2C3D4E5")
+	if err == nil || !strings.Contains(err.Error(), "queue directory is locked") {
+		t.Errorf("HoldMessage with postsuper failing: err = %v", err)
+	}
+	if got := queueStatuses(pf)["A1B2C3D4E5"]; got != "deferred" {
+		t.Errorf("status changed although postsuper failed: %q", got)
+	}
+	pf.Succeed("postsuper")
+	if err := qm.HoldMessage("A1B2C3D4E5"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := qm.FlushQueue(); err != nil {
+		t.Fatal(err)
+	}
+	if calls := pf.Calls(); calls[len(calls)-1] != "postqueue -f" {
+		t.Errorf("FlushQueue ran %q", calls[len(calls)-1])
+	}
+}
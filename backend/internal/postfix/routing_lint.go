@@ -0,0 +1,308 @@
+package postfix
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RoutingPrecedence explains, most specific first, how Postfix picks where
+// non-local mail goes. Lint findings refer back to it.
+var RoutingPrecedence = []string{
+	"transport_maps: an entry for the recipient domain (or a parent domain) decides both the transport and the next hop. It overrides everything below for that domain, whoever the sender is.",
+	"sender_dependent_relayhost_maps: the sender's address, then its @domain, picks a relay, but only for mail whose transport entry has no next hop of its own.",
+	"relayhost: the next hop for everything else, including relay_domains, unless a transport entry says otherwise.",
+	"DNS: without any of the above, mail goes to the recipient domain's MX hosts.",
+	"mydestination domains are delivered locally, and virtual mailbox domains by virtual_transport; a transport entry for one of them sends its mail elsewhere instead.",
+}
+
+// Severities of a routing lint finding
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+	LintInfo    = "info"
+)
+
+// RoutingFinding is one conflict or likely mistake in the routing setup
+type RoutingFinding struct {
+	Severity string   `json:"severity"`
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+	Entries  []string `json:"entries,omitempty"`
+	// Precedence is the index into RoutingPrecedence that explains it
+	Precedence *int `json:"precedence,omitempty"`
+}
+
+// RoutingConfig is everything that decides where relayed mail goes
+type RoutingConfig struct {
+	Relayhost      string                 `json:"relayhost"`
+	RelayDomains   []string               `json:"relayDomains"`
+	Mydestination  []string               `json:"mydestination"`
+	VirtualDomains []string               `json:"virtualDomains"`
+	Myhostname     string                 `json:"myhostname"`
+	TransportMaps  string                 `json:"transportMaps"`
+	SenderMaps     string                 `json:"senderDependentRelayhostMaps"`
+	Transports     []TransportMap         `json:"transports"`
+	SenderRelays   []SenderDependentRelay `json:"senderRelays"`
+}
+
+// RoutingConfig reads the routing parameters from main.cf, with overrides
+// (e.g. staged changes) taking the place of their values, and the
+// transport and sender relay maps
+func (m *ConfigManager) RoutingConfig(overrides map[string]string) (*RoutingConfig, error) {
+	m.mu.RLock()
+	params, err := m.parseMainCf(filepath.Join(m.configDir, "main.cf"))
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	for k, v := range overrides {
+		params[k] = v
+	}
+	cfg := RoutingConfigFromParams(params)
+	if cfg.Transports, err = m.GetTransportMaps(); err != nil {
+		return nil, err
+	}
+	if cfg.SenderRelays, err = m.GetSenderDependentRelays(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// RoutingConfigFromParams takes the routing parameters from main.cf
+// parameters, expanding $myhostname and $mydomain and filling in Postfix's
+// defaults for unset ones
+func RoutingConfigFromParams(params map[string]string) *RoutingConfig {
+	get := func(name, def string) string {
+		if v, ok := params[name]; ok {
+			return v
+		}
+		return def
+	}
+	hostname := get("myhostname", "")
+	domain := get("mydomain", "")
+	if domain == "" {
+		if i := strings.IndexByte(hostname, '.'); i >= 0 {
+			domain = hostname[i+1:]
+		}
+	}
+	expand := func(v string) string {
+		return strings.NewReplacer("${myhostname}", hostname, "$myhostname", hostname,
+			"${mydomain}", domain, "$mydomain", domain).Replace(v)
+	}
+	return &RoutingConfig{
+		Relayhost:      expand(get("relayhost", "")),
+		RelayDomains:   splitDomainList(expand(get("relay_domains", ""))),
+		Mydestination:  splitDomainList(expand(get("mydestination", "$myhostname, localhost.$mydomain, localhost"))),
+		VirtualDomains: splitDomainList(expand(get("virtual_mailbox_domains", ""))),
+		Myhostname:     hostname,
+		TransportMaps:  get("transport_maps", ""),
+		SenderMaps:     get("sender_dependent_relayhost_maps", ""),
+	}
+}
+
+// splitDomainList splits a comma or space separated domain list, leaving
+// out lookup tables ($ references and type:name) it can't resolve
+func splitDomainList(v string) []string {
+	var domains []string
+	for _, f := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' }) {
+		if strings.ContainsAny(f, "$:/") {
+			continue
+		}
+		domains = append(domains, strings.ToLower(f))
+	}
+	return domains
+}
+
+// nextHopHost returns the host of a next hop such as [relay.example.com]:587
+func nextHopHost(hop string) string {
+	hop = strings.TrimSpace(hop)
+	if strings.HasPrefix(hop, "[") {
+		if i := strings.IndexByte(hop, ']'); i > 0 {
+			return strings.ToLower(hop[1:i])
+		}
+	}
+	if i := strings.LastIndexByte(hop, ':'); i > 0 {
+		hop = hop[:i]
+	}
+	return strings.ToLower(strings.Trim(hop, "[]"))
+}
+
+// domainCovers reports whether a transport entry key applies to domain:
+// an exact match, or a parent domain (example.com and .example.com both
+// match sub.example.com with Postfix's default parent_domain_matches_transport_maps)
+func domainCovers(key, domain string) bool {
+	key = strings.ToLower(key)
+	if strings.HasPrefix(key, ".") {
+		return strings.HasSuffix(domain, key)
+	}
+	return domain == key || strings.HasSuffix(domain, "."+key)
+}
+
+// isPatternKey reports whether a map key is a regexp ParseMapKey couldn't
+// turn back into a domain
+func isPatternKey(key string) bool {
+	return strings.HasPrefix(key, "/")
+}
+
+// relayNextHop returns the next hop of a transport entry that relays over
+// SMTP, e.g. relay.example.com for smtp:[relay.example.com]:587. Local
+// transports such as lmtp, and entries without a next hop, return "".
+func relayNextHop(tm TransportMap) string {
+	name, hop, _ := strings.Cut(tm.Transport, ":")
+	if name != "smtp" && name != "relay" {
+		return ""
+	}
+	return nextHopHost(hop)
+}
+
+func precedence(i int) *int { return &i }
+
+// LintRouting finds rules that conflict or overlap, and setups that are
+// likely mistakes. Findings are sorted errors first.
+func LintRouting(cfg *RoutingConfig) []RoutingFinding {
+	findings := []RoutingFinding{}
+	add := func(severity, code string, prec int, entries []string, format string, args ...interface{}) {
+		findings = append(findings, RoutingFinding{
+			Severity:   severity,
+			Code:       code,
+			Message:    fmt.Sprintf(format, args...),
+			Entries:    entries,
+			Precedence: precedence(prec),
+		})
+	}
+
+	var transports []TransportMap
+	for _, tm := range cfg.Transports {
+		if tm.Enabled {
+			transports = append(transports, tm)
+		}
+	}
+	var relays []SenderDependentRelay
+	for _, r := range cfg.SenderRelays {
+		if r.Enabled {
+			relays = append(relays, r)
+		}
+	}
+
+	// Maps that exist but aren't referenced from main.cf do nothing
+	if len(transports) > 0 && cfg.TransportMaps == "" {
+		add(LintError, "transport_maps_unset", 0, nil,
+			"%d transport map entries exist but transport_maps isn't set in main.cf, so Postfix ignores them", len(transports))
+	}
+	if len(relays) > 0 && cfg.SenderMaps == "" {
+		add(LintError, "sender_maps_unset", 1, nil,
+			"%d sender relays exist but sender_dependent_relayhost_maps isn't set in main.cf, so Postfix ignores them", len(relays))
+	}
+
+	local := map[string]string{}
+	for _, d := range cfg.Mydestination {
+		local[d] = "mydestination"
+	}
+	for _, d := range cfg.VirtualDomains {
+		local[d] = "virtual_mailbox_domains"
+	}
+	localDomains := make([]string, 0, len(local))
+	for d := range local {
+		localDomains = append(localDomains, d)
+	}
+	sort.Strings(localDomains)
+	selfHosts := map[string]bool{"localhost": true, "127.0.0.1": true, "::1": true}
+	if cfg.Myhostname != "" {
+		selfHosts[strings.ToLower(cfg.Myhostname)] = true
+	}
+
+	for _, tm := range transports {
+		key := tm.Domain
+		if isPatternKey(key) {
+			add(LintInfo, "transport_pattern", 0, []string{key},
+				"Transport entry %s is a pattern; which domains it overrides can't be checked", key)
+			continue
+		}
+		hop := relayNextHop(tm)
+
+		// Loops back to this host
+		if hop != "" && selfHosts[hop] {
+			add(LintError, "transport_loop", 0, []string{key},
+				"Transport entry for %s sends mail to %s, which is this host; mail for it will loop", key, hop)
+		}
+
+		// Takes local mail away from local delivery
+		for _, d := range localDomains {
+			if param := local[d]; hop != "" && domainCovers(key, d) {
+				add(LintWarning, "transport_shadows_local", 4, []string{key},
+					"Transport entry for %s overrides %s: mail for %s is relayed to %s instead of delivered here",
+					key, param, d, hop)
+			}
+		}
+
+		// A next hop of its own wins over any sender relay
+		if hop != "" && len(relays) > 0 {
+			var shadowed []string
+			for _, r := range relays {
+				if nextHopHost(r.Relayhost) != hop {
+					shadowed = append(shadowed, r.Sender)
+				}
+			}
+			if len(shadowed) > 0 {
+				add(LintWarning, "transport_shadows_sender_relay", 0, append([]string{key}, shadowed...),
+					"Transport entry for %s has its own next hop (%s), so mail to %s ignores the sender relays for %s",
+					key, hop, key, strings.Join(shadowed, ", "))
+			}
+		}
+
+		// Two entries where one covers the other; the more specific wins
+		for _, other := range transports {
+			if other.Domain != key && !isPatternKey(other.Domain) && domainCovers(other.Domain, strings.TrimPrefix(key, ".")) {
+				add(LintInfo, "transport_overlap", 0, []string{other.Domain, key},
+					"Transport entries for %s and %s overlap; mail for %s uses the more specific one (%s)",
+					other.Domain, key, key, tm.Transport)
+			}
+		}
+	}
+
+	// Relay domains without a transport entry go to relayhost, not their MX
+	if cfg.Relayhost != "" {
+		var viaRelayhost []string
+		for _, d := range cfg.RelayDomains {
+			covered := false
+			for _, tm := range transports {
+				covered = covered || (!isPatternKey(tm.Domain) && domainCovers(tm.Domain, d))
+			}
+			if !covered {
+				viaRelayhost = append(viaRelayhost, d)
+			}
+		}
+		if len(viaRelayhost) > 0 {
+			add(LintWarning, "relay_domains_via_relayhost", 2, viaRelayhost,
+				"Mail for relay domains %s goes to relayhost %s rather than to their own servers; add transport entries if they should be delivered directly",
+				strings.Join(viaRelayhost, ", "), cfg.Relayhost)
+		}
+		if selfHosts[nextHopHost(cfg.Relayhost)] {
+			add(LintError, "relayhost_loop", 2, nil, "relayhost %s is this host; relayed mail will loop", cfg.Relayhost)
+		}
+	}
+
+	for _, r := range relays {
+		hop := nextHopHost(r.Relayhost)
+		if selfHosts[hop] {
+			add(LintError, "sender_relay_loop", 1, []string{r.Sender},
+				"Sender relay for %s sends mail to %s, which is this host; it will loop", r.Sender, hop)
+		}
+		if cfg.Relayhost != "" && hop == nextHopHost(cfg.Relayhost) {
+			add(LintInfo, "sender_relay_redundant", 1, []string{r.Sender},
+				"Sender relay for %s uses relayhost's host %s; it only matters if relayhost changes", r.Sender, r.Relayhost)
+		}
+	}
+	for _, d := range cfg.RelayDomains {
+		if param, ok := local[d]; ok {
+			add(LintWarning, "relay_domain_is_local", 4, []string{d},
+				"%s is in both relay_domains and %s; Postfix treats it as local and never relays it", d, param)
+		}
+	}
+
+	rank := map[string]int{LintError: 0, LintWarning: 1, LintInfo: 2}
+	sort.SliceStable(findings, func(i, j int) bool { return rank[findings[i].Severity] < rank[findings[j].Severity] })
+	return findings
+}
@@ -0,0 +1,257 @@
+package postfix
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SenderClass sends the mail of a set of senders through a transport of
+// its own, with its own process limit, concurrency and rate. Alerts and
+// invoices then don't queue behind a newsletter, and the newsletter can be
+// throttled without slowing anything else.
+type SenderClass struct {
+	Name string `json:"name"`
+	// Senders are addresses or @domains
+	Senders []string `json:"senders"`
+	// ProcessLimit is the transport's maxproc in master.cf; 0 uses
+	// default_process_limit
+	ProcessLimit int `json:"processLimit"`
+	// DestinationConcurrency caps parallel deliveries to one destination;
+	// 0 uses default_destination_concurrency_limit
+	DestinationConcurrency int `json:"destinationConcurrency"`
+	// RateDelay is the pause between deliveries to one destination, a
+	// Postfix time such as "1s"; empty for none
+	RateDelay string `json:"rateDelay,omitempty"`
+	Enabled   bool   `json:"enabled"`
+}
+
+// senderClassPrefix keeps generated transports apart from Postfix's own
+// and hand-written services
+const senderClassPrefix = "psfx_"
+
+// Markers around the services SaveSenderClasses manages in master.cf
+const (
+	masterBlockBegin = "# BEGIN sender classes - Managed by PostfixRelay"
+	masterBlockEnd   = "# END sender classes"
+)
+
+// Per-transport main.cf parameters a sender class sets
+var senderClassParams = []string{"_destination_concurrency_limit", "_destination_rate_delay"}
+
+var (
+	senderClassNameRe = regexp.MustCompile(`^[a-z][a-z0-9_]{0,29}$`)
+	postfixTimeRe     = regexp.MustCompile(`^\d+[smhdw]?$`)
+)
+
+// ValidSenderClassName reports whether name can name a sender class; it
+// becomes part of the transport's service name
+func ValidSenderClassName(name string) bool {
+	return senderClassNameRe.MatchString(name)
+}
+
+// ValidPostfixTime reports whether s is a Postfix time value such as 30s
+func ValidPostfixTime(s string) bool {
+	return postfixTimeRe.MatchString(s)
+}
+
+// Transport is the master.cf service the class's mail is delivered by
+func (c SenderClass) Transport() string {
+	return senderClassPrefix + c.Name
+}
+
+// activeClasses returns the enabled classes, by name
+func activeClasses(classes []SenderClass) []SenderClass {
+	var active []SenderClass
+	for _, c := range classes {
+		if c.Enabled {
+			active = append(active, c)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].Name < active[j].Name })
+	return active
+}
+
+// renderSenderTransport returns the sender_transport map: each sender of
+// an enabled class pointing at the class's transport
+func renderSenderTransport(classes []SenderClass, mapType string) string {
+	var content strings.Builder
+	content.WriteString("# Sender classes - Managed by PostfixRelay\n")
+	content.WriteString("# Format: sender@domain transport:\n\n")
+	for _, c := range activeClasses(classes) {
+		for _, sender := range c.Senders {
+			fmt.Fprintf(&content, "%s\t%s:\n", MapKey(mapType, sender), c.Transport())
+		}
+	}
+	return content.String()
+}
+
+// renderMasterBlock returns the master.cf services of the enabled classes,
+// each a copy of the smtp client, or "" without any
+func renderMasterBlock(classes []SenderClass) string {
+	active := activeClasses(classes)
+	if len(active) == 0 {
+		return ""
+	}
+	var block strings.Builder
+	block.WriteString(masterBlockBegin + "\n")
+	for _, c := range active {
+		maxproc := "-"
+		if c.ProcessLimit > 0 {
+			maxproc = strconv.Itoa(c.ProcessLimit)
+		}
+		fmt.Fprintf(&block, "%-9s unix  -       -       n       -       %-7s smtp\n", c.Transport(), maxproc)
+		fmt.Fprintf(&block, "  -o syslog_name=postfix/%s\n", c.Transport())
+	}
+	block.WriteString(masterBlockEnd + "\n")
+	return block.String()
+}
+
+// withMasterBlock replaces the managed block in master.cf content, or
+// appends it. An empty block removes it.
+func withMasterBlock(content, block string) string {
+	start := strings.Index(content, masterBlockBegin)
+	if start >= 0 {
+		if end := strings.Index(content[start:], masterBlockEnd); end >= 0 {
+			rest := content[start+end+len(masterBlockEnd):]
+			return content[:start] + block + strings.TrimPrefix(rest, "\n")
+		}
+	}
+	if block == "" {
+		return content
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content + block
+}
+
+// senderClassUpdates returns the main.cf changes for classes: the map
+// reference and the per-transport limits, with those of classes that are
+// gone or disabled removed
+func senderClassUpdates(params map[string]string, classes []SenderClass, ref string) map[string]string {
+	updates := map[string]string{"sender_dependent_default_transport_maps": ""}
+	for key := range params {
+		if !strings.HasPrefix(key, senderClassPrefix) {
+			continue
+		}
+		for _, suffix := range senderClassParams {
+			if strings.HasSuffix(key, suffix) {
+				updates[key] = ""
+			}
+		}
+	}
+
+	active := activeClasses(classes)
+	if len(active) > 0 {
+		updates["sender_dependent_default_transport_maps"] = ref
+	}
+	for _, c := range active {
+		if c.DestinationConcurrency > 0 {
+			updates[c.Transport()+"_destination_concurrency_limit"] = strconv.Itoa(c.DestinationConcurrency)
+		}
+		if c.RateDelay != "" {
+			updates[c.Transport()+"_destination_rate_delay"] = c.RateDelay
+		}
+	}
+	return updates
+}
+
+// SaveSenderClasses writes the sender_transport map, the classes'
+// services in master.cf and their main.cf parameters. The classes only
+// apply to mail that takes the default transport: a transport map entry
+// for the recipient domain still wins.
+func (m *ConfigManager) SaveSenderClasses(classes []SenderClass) error {
+	m.mu.Lock()
+	path := filepath.Join(m.configDir, "sender_transport")
+	mapType := MapType("sender_transport")
+
+	err := os.WriteFile(path, []byte(renderSenderTransport(classes, mapType)), 0644)
+	if err == nil {
+		err = CompileMap(mapType, path, true)
+	}
+	if err == nil {
+		err = m.writeMasterBlock(renderMasterBlock(classes))
+	}
+	params, parseErr := m.parseMainCf(filepath.Join(m.configDir, "main.cf"))
+	m.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to write sender classes: %w", err)
+	}
+	if parseErr != nil {
+		return fmt.Errorf("failed to read config: %w", parseErr)
+	}
+	return m.UpdateConfig(senderClassUpdates(params, classes, MapRef(mapType, path)))
+}
+
+// writeMasterBlock replaces the managed services in master.cf
+func (m *ConfigManager) writeMasterBlock(block string) error {
+	path := filepath.Join(m.configDir, "master.cf")
+	before, err := os.ReadFile(path)
+	if os.IsNotExist(err) && block != "" {
+		// A master.cf of only these services would leave Postfix without
+		// its own
+		return fmt.Errorf("%s not found", path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	after := withMasterBlock(string(before), block)
+	if after == string(before) {
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(after), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// PreviewSenderClasses returns the changes SaveSenderClasses would make
+func (m *ConfigManager) PreviewSenderClasses(classes []SenderClass) ([]FileChange, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	mapPath := filepath.Join(m.configDir, "sender_transport")
+	mapType := MapType("sender_transport")
+	mapChange, err := DiffFile(mapPath, renderSenderTransport(classes, mapType))
+	if err != nil {
+		return nil, err
+	}
+
+	masterPath := filepath.Join(m.configDir, "master.cf")
+	master, err := os.ReadFile(masterPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", masterPath, err)
+	}
+	masterChange := diffChange(masterPath, string(master), withMasterBlock(string(master), renderMasterBlock(classes)))
+
+	mainCfPath := filepath.Join(m.configDir, "main.cf")
+	params, err := m.parseMainCf(mainCfPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	before, err := os.ReadFile(mainCfPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	for key, value := range senderClassUpdates(params, classes, MapRef(mapType, mapPath)) {
+		if value != "" {
+			params[key] = value
+		} else {
+			delete(params, key)
+		}
+	}
+	mainChange := diffChange(mainCfPath, withoutModified(string(before)), withoutModified(renderMainCf(params, time.Now())))
+
+	return []FileChange{mapChange, masterChange, mainChange}, nil
+}
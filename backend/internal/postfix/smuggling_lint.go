@@ -0,0 +1,167 @@
+package postfix
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SmugglingFinding is one gap in Postfix's defenses against SMTP
+// smuggling, where a client hides a second message behind a bare-LF or
+// bare-CR end of data, and against unauthorized pipelining
+type SmugglingFinding struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	// Parameter is the main.cf parameter to change, with the value to
+	// set it to
+	Parameter   string `json:"parameter,omitempty"`
+	Recommended string `json:"recommended,omitempty"`
+	Message     string `json:"message"`
+}
+
+// smugglingFixes are the releases of each Postfix series that first have
+// smtpd_forbid_bare_newline and smtpd_forbid_unauth_pipelining. From 3.9
+// on both exist and are on by default.
+var smugglingFixes = map[[2]int]struct{ bareNewline, unauthPipelining int }{
+	{3, 5}: {23, 20},
+	{3, 6}: {13, 10},
+	{3, 7}: {9, 6},
+	{3, 8}: {4, 1},
+}
+
+// parsePostfixVersion reads "3.7.9" (or "3.7.9-RC1") as major, minor and
+// patch
+func parsePostfixVersion(v string) ([3]int, bool) {
+	var out [3]int
+	parts := strings.SplitN(strings.TrimSpace(v), ".", 3)
+	if len(parts) < 2 {
+		return out, false
+	}
+	for i, p := range parts {
+		if j := strings.IndexFunc(p, func(r rune) bool { return r < '0' || r > '9' }); j >= 0 {
+			p = p[:j]
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return out, false
+		}
+		out[i] = n
+	}
+	return out, true
+}
+
+// smugglingSupport says whether a Postfix version has the two parameters,
+// and whether they are on by default
+func smugglingSupport(version string) (bareNewline, unauthPipelining, onByDefault, known bool) {
+	v, ok := parsePostfixVersion(version)
+	if !ok {
+		return false, false, false, false
+	}
+	if v[0] > 3 || (v[0] == 3 && v[1] >= 9) {
+		return true, true, true, true
+	}
+	fix, ok := smugglingFixes[[2]int{v[0], v[1]}]
+	if !ok {
+		// Series before 3.5 are out of support and never got the fixes
+		return false, false, false, true
+	}
+	return v[2] >= fix.bareNewline, v[2] >= fix.unauthPipelining, false, true
+}
+
+// LintSMTPSmuggling checks main.cf parameters against what the Postfix
+// version offers against SMTP smuggling. An empty or "unknown" version
+// only checks the parameters that are set.
+func LintSMTPSmuggling(params map[string]string, version string) []SmugglingFinding {
+	var findings []SmugglingFinding
+	bareNewline, unauthPipelining, onByDefault, known := smugglingSupport(version)
+
+	if !known {
+		findings = append(findings, SmugglingFinding{
+			Severity: LintInfo,
+			Code:     "version_unknown",
+			Message:  "The Postfix version couldn't be read; check that it is 3.9, 3.8.4, 3.7.9, 3.6.13, 3.5.23 or later.",
+		})
+	} else if !bareNewline {
+		findings = append(findings, SmugglingFinding{
+			Severity:    LintError,
+			Code:        "smuggling_unpatched",
+			Parameter:   "smtpd_data_restrictions",
+			Recommended: "reject_unauth_pipelining",
+			Message: fmt.Sprintf("Postfix %s predates the SMTP smuggling fixes. Upgrade to 3.9, 3.8.4, 3.7.9, 3.6.13 or 3.5.23; "+
+				"until then set smtpd_data_restrictions = reject_unauth_pipelining and smtpd_discard_ehlo_keywords = chunking.", version),
+		})
+	}
+
+	// smtpd_forbid_bare_newline: "normal" (or the older "yes") rejects a
+	// bare LF in DATA from clients outside the exclusions
+	switch value, set := params["smtpd_forbid_bare_newline"]; {
+	case set && (value == "no" || value == ""):
+		findings = append(findings, SmugglingFinding{
+			Severity:    LintWarning,
+			Code:        "bare_newline_allowed",
+			Parameter:   "smtpd_forbid_bare_newline",
+			Recommended: "normal",
+			Message:     "smtpd_forbid_bare_newline = no accepts bare LF line endings, which SMTP smuggling relies on.",
+		})
+	case !set && bareNewline && !onByDefault:
+		findings = append(findings, SmugglingFinding{
+			Severity:    LintWarning,
+			Code:        "bare_newline_default",
+			Parameter:   "smtpd_forbid_bare_newline",
+			Recommended: "normal",
+			Message:     "smtpd_forbid_bare_newline is off by default before Postfix 3.9; set it to normal.",
+		})
+	}
+
+	if exclusions := params["smtpd_forbid_bare_newline_exclusions"]; exclusions != "" {
+		for _, net := range strings.FieldsFunc(exclusions, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' }) {
+			if net == "0.0.0.0/0" || net == "::/0" || net == "[::]/0" || net == "static:all" {
+				findings = append(findings, SmugglingFinding{
+					Severity:  LintWarning,
+					Code:      "bare_newline_excludes_all",
+					Parameter: "smtpd_forbid_bare_newline_exclusions",
+					Message:   fmt.Sprintf("smtpd_forbid_bare_newline_exclusions includes %s, which exempts every client.", net),
+				})
+				break
+			}
+		}
+	}
+
+	switch value, set := params["smtpd_forbid_unauth_pipelining"]; {
+	case set && value == "no":
+		findings = append(findings, SmugglingFinding{
+			Severity:    LintWarning,
+			Code:        "unauth_pipelining_allowed",
+			Parameter:   "smtpd_forbid_unauth_pipelining",
+			Recommended: "yes",
+			Message:     "smtpd_forbid_unauth_pipelining = no lets clients send commands ahead of replies without having seen PIPELINING.",
+		})
+	case !set && unauthPipelining && !onByDefault:
+		findings = append(findings, SmugglingFinding{
+			Severity:    LintWarning,
+			Code:        "unauth_pipelining_default",
+			Parameter:   "smtpd_forbid_unauth_pipelining",
+			Recommended: "yes",
+			Message:     "smtpd_forbid_unauth_pipelining is off by default before Postfix 3.9; set it to yes.",
+		})
+	}
+
+	return findings
+}
+
+// SmugglingLint reads main.cf, with overrides (e.g. staged changes) taking
+// the place of their values, and lints it against the running version
+func (m *ConfigManager) SmugglingLint(overrides map[string]string) ([]SmugglingFinding, string, error) {
+	m.mu.RLock()
+	params, err := m.parseMainCf(filepath.Join(m.configDir, "main.cf"))
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read config: %w", err)
+	}
+	for k, v := range overrides {
+		params[k] = v
+	}
+	version := m.GetVersion()
+	return LintSMTPSmuggling(params, version), version, nil
+}
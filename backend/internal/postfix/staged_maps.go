@@ -0,0 +1,89 @@
+package postfix
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Operations a staged map change can make
+const (
+	MapAdd    = "add"
+	MapUpdate = "update"
+	MapDelete = "delete"
+)
+
+// TransportChange is a staged change to one transport map entry
+type TransportChange struct {
+	Operation string `json:"operation"`
+	TransportMap
+}
+
+// SenderRelayChange is a staged change to one sender-dependent relay
+type SenderRelayChange struct {
+	Operation string `json:"operation"`
+	SenderDependentRelay
+}
+
+// ApplyTransportChanges returns maps with changes made to them in order.
+// It fails like the single-entry operations do, e.g. adding a domain that
+// already has an entry.
+func ApplyTransportChanges(maps []TransportMap, changes []TransportChange) ([]TransportMap, error) {
+	maps = append([]TransportMap(nil), maps...)
+	var err error
+	for _, c := range changes {
+		switch c.Operation {
+		case MapAdd:
+			maps, err = addTransportMap(maps, c.TransportMap)
+		case MapUpdate:
+			maps, err = updateTransportMap(maps, c.Domain, c.TransportMap)
+		case MapDelete:
+			maps, err = deleteTransportMap(maps, c.Domain)
+		default:
+			err = fmt.Errorf("unknown operation %q", c.Operation)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return maps, nil
+}
+
+// ApplySenderRelayChanges returns relays with changes made to them in order
+func ApplySenderRelayChanges(relays []SenderDependentRelay, changes []SenderRelayChange) ([]SenderDependentRelay, error) {
+	relays = append([]SenderDependentRelay(nil), relays...)
+	var err error
+	for _, c := range changes {
+		switch c.Operation {
+		case MapAdd:
+			relays, err = addSenderRelay(relays, c.SenderDependentRelay)
+		case MapUpdate:
+			relays, err = updateSenderRelay(relays, c.Sender, c.SenderDependentRelay)
+		case MapDelete:
+			relays, err = deleteSenderRelay(relays, c.Sender)
+		default:
+			err = fmt.Errorf("unknown operation %q", c.Operation)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return relays, nil
+}
+
+// PreviewTransportMaps returns the transport file change SaveTransportMaps
+// would make for maps
+func (m *ConfigManager) PreviewTransportMaps(maps []TransportMap) (FileChange, error) {
+	content := renderTransportMaps(maps, MapType("transport"))
+	change, err := DiffFile(filepath.Join(m.configDir, "transport"), content)
+	change.Content = content
+	return change, err
+}
+
+// PreviewSenderRelays returns the sender_relay file change
+// SaveSenderDependentRelays would make for relays
+func (m *ConfigManager) PreviewSenderRelays(relays []SenderDependentRelay) (FileChange, error) {
+	content := renderSenderRelays(relays, MapType("sender_relay"))
+	change, err := DiffFile(filepath.Join(m.configDir, "sender_relay"), content)
+	change.Content = content
+	return change, err
+}
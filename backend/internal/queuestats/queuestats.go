@@ -0,0 +1,133 @@
+// Package queuestats samples the Postfix queue size every minute so it can
+// be charted over time.
+package queuestats
+
+import (
+	"database/sql"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Sample is the queue size at one point in time
+type Sample struct {
+	Time     time.Time `json:"time"`
+	Active   int       `json:"active"`
+	Deferred int       `json:"deferred"`
+	Hold     int       `json:"hold"`
+	Corrupt  int       `json:"corrupt"`
+}
+
+// Total is the number of queued messages
+func (s Sample) Total() int {
+	return s.Active + s.Deferred + s.Hold + s.Corrupt
+}
+
+// QueueFunc returns the current queue counts
+type QueueFunc func() (active, deferred, hold, corrupt int)
+
+// Sampler records queue samples
+type Sampler struct {
+	db       *sql.DB
+	queue    QueueFunc
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewSampler creates a queue sampler
+func NewSampler(db *sql.DB, queue QueueFunc) *Sampler {
+	return &Sampler{
+		db:     db,
+		queue:  queue,
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start begins sampling
+func (s *Sampler) Start() {
+	s.done = make(chan struct{})
+	go s.loop()
+}
+
+// Stop stops sampling
+func (s *Sampler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+		if s.done != nil {
+			<-s.done
+		}
+	})
+}
+
+func (s *Sampler) loop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case now := <-ticker.C:
+			s.sample(now)
+			if now.Sub(lastPrune) >= time.Hour {
+				s.prune(now)
+				lastPrune = now
+			}
+		}
+	}
+}
+
+func (s *Sampler) sample(now time.Time) {
+	active, deferred, hold, corrupt := s.queue()
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO queue_samples (sampled_at, active, deferred, hold, corrupt)
+		VALUES (?, ?, ?, ?, ?)
+	`, now.UTC().Truncate(time.Minute).Format(time.RFC3339), active, deferred, hold, corrupt)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to store queue sample")
+	}
+}
+
+// prune removes samples older than queuestats_retention_days
+func (s *Sampler) prune(now time.Time) {
+	days := 30
+	var value string
+	if err := s.db.QueryRow(`SELECT value FROM settings WHERE key = 'queuestats_retention_days'`).Scan(&value); err == nil {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			days = n
+		}
+	}
+	cutoff := now.UTC().AddDate(0, 0, -days).Format(time.RFC3339)
+	s.db.Exec(`DELETE FROM queue_samples WHERE sampled_at < ?`, cutoff)
+}
+
+// Series returns the samples taken in [from, to], oldest first
+func Series(db *sql.DB, from, to time.Time) ([]Sample, error) {
+	rows, err := db.Query(`
+		SELECT sampled_at, active, deferred, hold, corrupt FROM queue_samples
+		WHERE sampled_at >= ? AND sampled_at <= ?
+		ORDER BY sampled_at
+	`, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []Sample{}
+	for rows.Next() {
+		var sm Sample
+		var at string
+		if err := rows.Scan(&at, &sm.Active, &sm.Deferred, &sm.Hold, &sm.Corrupt); err != nil {
+			continue
+		}
+		sm.Time, _ = time.Parse(time.RFC3339, at)
+		samples = append(samples, sm)
+	}
+	return samples, nil
+}
@@ -0,0 +1,101 @@
+// Package queuetrack follows queued messages through the mail log by queue
+// ID, remembering the client that submitted each one and its sender until
+// the message leaves the queue. Collectors that attribute deliveries to
+// where a message came from build on it.
+package queuetrack
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+)
+
+var clientRe = regexp.MustCompile(`\bclient=([^\[\s,]+)\[([^\]]+)\]`)
+
+// LocalSource is the client of mail submitted on this host (pickup)
+const LocalSource = "local"
+
+// Message is what is known about a queued message. Data holds whatever
+// the collector keeps per message.
+type Message[T any] struct {
+	Client string    // host name, or address when unknown; LocalSource for pickup
+	Sender string    // envelope sender as logged
+	Seen   time.Time // time of the message's last log entry
+	Data   T
+}
+
+// Tracker keeps the messages in the queue by queue ID. It is not safe for
+// concurrent use; collectors call it under their own lock.
+type Tracker[T any] struct {
+	ttl      time.Duration
+	messages map[string]*Message[T]
+}
+
+// New creates a tracker that forgets a message once it has gone ttl
+// without a log entry
+func New[T any](ttl time.Duration) *Tracker[T] {
+	return &Tracker[T]{ttl: ttl, messages: make(map[string]*Message[T])}
+}
+
+// Track follows one log entry and returns the message it is about. It
+// returns nil for entries without a queue ID and when the message is
+// removed from the queue.
+func (t *Tracker[T]) Track(e logs.Entry) *Message[T] {
+	if e.QueueID == "" {
+		return nil
+	}
+	if e.Message == "removed" {
+		delete(t.messages, e.QueueID)
+		return nil
+	}
+
+	msg := t.messages[e.QueueID]
+	if msg == nil {
+		msg = &Message[T]{}
+		t.messages[e.QueueID] = msg
+	}
+	msg.Seen = e.Timestamp
+	if msg.Seen.IsZero() {
+		msg.Seen = time.Now()
+	}
+
+	switch {
+	case strings.HasSuffix(e.Process, "smtpd"):
+		if m := clientRe.FindStringSubmatch(e.Message); m != nil {
+			msg.Client = m[1]
+			if msg.Client == "unknown" {
+				msg.Client = m[2]
+			}
+		}
+	case strings.HasSuffix(e.Process, "pickup"):
+		msg.Client = LocalSource
+	case e.MailFrom != "" && e.Status == "":
+		msg.Sender = e.MailFrom
+	}
+	return msg
+}
+
+// Expire forgets messages that have gone quiet
+func (t *Tracker[T]) Expire(now time.Time) {
+	cutoff := now.Add(-t.ttl)
+	for id, msg := range t.messages {
+		if msg.Seen.Before(cutoff) {
+			delete(t.messages, id)
+		}
+	}
+}
+
+// DomainOf returns the lower-cased domain of an address, the address
+// itself when it has none, or "<>" for the null sender
+func DomainOf(addr string) string {
+	addr = strings.Trim(addr, "<>")
+	if i := strings.LastIndexByte(addr, '@'); i >= 0 {
+		return strings.ToLower(addr[i+1:])
+	}
+	if addr == "" {
+		return "<>"
+	}
+	return strings.ToLower(addr)
+}
@@ -0,0 +1,65 @@
+package queuetrack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+)
+
+func TestTrack(t *testing.T) {
+	tr := New[int](time.Hour)
+	start := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	tr.Track(logs.Entry{Timestamp: start, Process: "postfix/smtpd", QueueID: "A1",
+		Message: "client=unknown[192.0.2.7]"})
+	tr.Track(logs.Entry{Timestamp: start, Process: "postfix/smtpd", QueueID: "B2",
+		Message: "client=app.example.com[192.0.2.8], sasl_method=PLAIN"})
+	tr.Track(logs.Entry{Timestamp: start, Process: "postfix/pickup", QueueID: "C3", Message: "uid=0 from=<root>"})
+	tr.Track(logs.Entry{Timestamp: start, Process: "postfix/qmgr", QueueID: "A1", MailFrom: "Alerts@Example.COM"})
+
+	delivery := logs.Entry{Timestamp: start.Add(time.Minute), Process: "postfix/smtp", QueueID: "A1",
+		MailTo: "ops@example.net", Status: "sent"}
+	msg := tr.Track(delivery)
+	if msg == nil || msg.Client != "192.0.2.7" || msg.Sender != "Alerts@Example.COM" || !msg.Seen.Equal(delivery.Timestamp) {
+		t.Fatalf("A1 = %+v", msg)
+	}
+	msg.Data++
+	if msg = tr.Track(delivery); msg.Data != 1 {
+		t.Errorf("data was not kept: %d", msg.Data)
+	}
+	if msg = tr.Track(logs.Entry{Timestamp: start, Process: "postfix/smtp", QueueID: "B2"}); msg.Client != "app.example.com" {
+		t.Errorf("B2 client = %q", msg.Client)
+	}
+	if msg = tr.Track(logs.Entry{Timestamp: start, Process: "postfix/qmgr", QueueID: "C3"}); msg.Client != LocalSource {
+		t.Errorf("C3 client = %q", msg.Client)
+	}
+
+	if msg = tr.Track(logs.Entry{Process: "postfix/qmgr", QueueID: "A1", Message: "removed"}); msg != nil {
+		t.Errorf("removed entry returned %+v", msg)
+	}
+	if msg = tr.Track(logs.Entry{Process: "postfix/smtpd", Message: "connect from unknown[192.0.2.7]"}); msg != nil {
+		t.Errorf("entry without a queue ID returned %+v", msg)
+	}
+
+	tr.Expire(start.Add(time.Hour + time.Second))
+	if len(tr.messages) != 0 {
+		t.Errorf("%d messages left after expiry", len(tr.messages))
+	}
+}
+
+func TestDomainOf(t *testing.T) {
+	tests := map[string]string{
+		"joe@Example.COM":   "example.com",
+		"<joe@example.com>": "example.com",
+		"a@b@example.org":   "example.org",
+		"MAILER-DAEMON":     "mailer-daemon",
+		"":                  "<>",
+		"<>":                "<>",
+	}
+	for addr, want := range tests {
+		if got := DomainOf(addr); got != want {
+			t.Errorf("DomainOf(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+// Package release checks the published release manifest for versions newer
+// than the running one, and advises on what upgrading to the latest
+// involves: breaking changes, database migrations and required stops.
+package release
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultManifestURL is where releases are published
+const DefaultManifestURL = "https://github.com/postfixrelay/postfixrelay/releases/latest/download/manifest.json"
+
+// checkInterval is how often the manifest is fetched
+const checkInterval = 24 * time.Hour
+
+// Release is one published version in the manifest
+type Release struct {
+	Version     string    `json:"version"`
+	PublishedAt time.Time `json:"publishedAt"`
+	URL         string    `json:"url,omitempty"`
+	Notes       string    `json:"notes,omitempty"`
+	// Breaking lists changes that need action from the admin
+	Breaking []string `json:"breaking,omitempty"`
+	// Migrations lists the database migrations the release runs on start
+	Migrations []string `json:"migrations,omitempty"`
+	// MinUpgradeFrom is the oldest version that can upgrade straight to
+	// this one; older installs must first upgrade to it
+	MinUpgradeFrom string `json:"minUpgradeFrom,omitempty"`
+}
+
+// Manifest is the published list of releases
+type Manifest struct {
+	Releases []Release `json:"releases"`
+}
+
+// Advice is the result of comparing the running version with the manifest
+type Advice struct {
+	Enabled         bool      `json:"enabled"`
+	CheckedAt       time.Time `json:"checkedAt,omitempty"`
+	Current         string    `json:"current"`
+	Latest          string    `json:"latest,omitempty"`
+	UpdateAvailable bool      `json:"updateAvailable"`
+	// Comparable is false for development builds, which can't be ordered
+	// against releases
+	Comparable bool      `json:"comparable"`
+	Newer      []Release `json:"newer,omitempty"` // releases after the current one, oldest first
+	Breaking   []string  `json:"breaking,omitempty"`
+	Migrations []string  `json:"migrations,omitempty"`
+	// UpgradePath lists intermediate versions to install first when the
+	// latest can't be upgraded to directly
+	UpgradePath []string `json:"upgradePath,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// parseVersion reads "1.2.3" or "v1.2.3"; a pre-release suffix is ignored
+func parseVersion(v string) ([3]int, bool) {
+	var parts [3]int
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	fields := strings.Split(v, ".")
+	if len(fields) == 0 || len(fields) > 3 {
+		return parts, false
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+// Compare orders two versions: -1 if a is older, 1 if newer, 0 if equal
+func Compare(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// Advise compares the current version with a manifest
+func Advise(current string, m Manifest, now time.Time) Advice {
+	adv := Advice{Enabled: true, CheckedAt: now.UTC(), Current: current}
+
+	type parsed struct {
+		Release
+		v [3]int
+	}
+	var releases []parsed
+	for _, r := range m.Releases {
+		if v, ok := parseVersion(r.Version); ok {
+			releases = append(releases, parsed{r, v})
+		}
+	}
+	if len(releases) == 0 {
+		adv.Error = "the release manifest lists no releases"
+		return adv
+	}
+	// Oldest first
+	for i := 1; i < len(releases); i++ {
+		for j := i; j > 0 && Compare(releases[j-1].v, releases[j].v) > 0; j-- {
+			releases[j-1], releases[j] = releases[j], releases[j-1]
+		}
+	}
+	latest := releases[len(releases)-1]
+	adv.Latest = latest.Version
+
+	cur, ok := parseVersion(current)
+	if !ok {
+		return adv
+	}
+	adv.Comparable = true
+
+	for _, r := range releases {
+		if Compare(r.v, cur) <= 0 {
+			continue
+		}
+		adv.Newer = append(adv.Newer, r.Release)
+		adv.Breaking = append(adv.Breaking, r.Breaking...)
+		adv.Migrations = append(adv.Migrations, r.Migrations...)
+	}
+	adv.UpdateAvailable = len(adv.Newer) > 0
+
+	// Walk back from the latest release through the versions each one
+	// requires until one can be upgraded to from the current version
+	target := latest
+	for i := 0; i < len(releases); i++ {
+		minVersion, ok := parseVersion(target.MinUpgradeFrom)
+		if !ok || Compare(cur, minVersion) >= 0 {
+			break
+		}
+		found := false
+		for _, r := range releases {
+			if Compare(r.v, minVersion) == 0 {
+				adv.UpgradePath = append([]string{r.Version}, adv.UpgradePath...)
+				target, found = r, true
+				break
+			}
+		}
+		if !found {
+			adv.UpgradePath = append([]string{target.MinUpgradeFrom}, adv.UpgradePath...)
+			break
+		}
+	}
+	return adv
+}
+
+// Fetch downloads the release manifest
+func Fetch(client *http.Client, url string) (Manifest, error) {
+	var m Manifest
+	resp, err := client.Get(url)
+	if err != nil {
+		return m, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return m, fmt.Errorf("release manifest: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return m, fmt.Errorf("release manifest: %w", err)
+	}
+	return m, nil
+}
+
+// Checker fetches the manifest once a day unless update_check_enabled is
+// off, and keeps the latest advice
+type Checker struct {
+	db      *sql.DB
+	current string
+	client  *http.Client
+
+	mu   sync.Mutex
+	last *Advice
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewChecker creates a release checker for the running version
+func NewChecker(db *sql.DB, current string) *Checker {
+	return &Checker{
+		db:      db,
+		current: current,
+		client:  &http.Client{Timeout: 15 * time.Second},
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// Start begins the daily checks
+func (c *Checker) Start() {
+	c.done = make(chan struct{})
+	go c.loop()
+}
+
+// Stop stops checking
+func (c *Checker) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+		if c.done != nil {
+			<-c.done
+		}
+	})
+}
+
+func (c *Checker) loop() {
+	defer close(c.done)
+
+	// First check shortly after startup, then daily
+	timer := time.NewTimer(time.Minute)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case now := <-timer.C:
+			c.Check(now)
+			timer.Reset(checkInterval)
+		}
+	}
+}
+
+func (c *Checker) setting(key, fallback string) string {
+	var value string
+	if err := c.db.QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value); err != nil || value == "" {
+		return fallback
+	}
+	return value
+}
+
+// Enabled reports whether release checks are turned on
+func (c *Checker) Enabled() bool {
+	return c.setting("update_check_enabled", "true") == "true"
+}
+
+// Check fetches the manifest now. With checks turned off nothing is
+// fetched and the advice says so.
+func (c *Checker) Check(now time.Time) Advice {
+	if !c.Enabled() {
+		return Advice{Current: c.current}
+	}
+	url := c.setting("update_manifest_url", DefaultManifestURL)
+	m, err := Fetch(c.client, url)
+	var adv Advice
+	if err != nil {
+		log.Warn().Err(err).Str("url", url).Msg("Release check failed")
+		adv = Advice{Enabled: true, CheckedAt: now.UTC(), Current: c.current, Error: err.Error()}
+	} else {
+		adv = Advise(c.current, m, now)
+		if adv.UpdateAvailable {
+			log.Info().Str("current", c.current).Str("latest", adv.Latest).Msg("A newer release is available")
+		}
+	}
+
+	c.mu.Lock()
+	c.last = &adv
+	c.mu.Unlock()
+	return adv
+}
+
+// Last returns the latest advice, or what is known before the first check
+func (c *Checker) Last() Advice {
+	if !c.Enabled() {
+		return Advice{Current: c.current}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last == nil {
+		return Advice{Enabled: true, Current: c.current}
+	}
+	return *c.last
+}
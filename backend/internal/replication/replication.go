@@ -0,0 +1,278 @@
+// Package replication watches Dovecot's replicator: when each mailbox was
+// last synced to the other node and which syncs failed, with the dsync
+// errors Dovecot logged for them.
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/rs/zerolog/log"
+)
+
+// pollInterval is how often the replicator's status is read
+const pollInterval = time.Minute
+
+// Status is the replication state of one mailbox
+type Status struct {
+	Email           string     `json:"email"`
+	Priority        string     `json:"priority"`
+	LastFastSync    *time.Time `json:"lastFastSync,omitempty"`
+	LastFullSync    *time.Time `json:"lastFullSync,omitempty"`
+	LastSuccessSync *time.Time `json:"lastSuccessSync,omitempty"`
+	Failed          bool       `json:"failed"`
+	LastError       string     `json:"lastError,omitempty"`
+	LastErrorAt     *time.Time `json:"lastErrorAt,omitempty"`
+	LagSeconds      int64      `json:"lagSeconds"`
+}
+
+// parseAgo turns a replicator time column, the time since the event as
+// hh:mm:ss or "-" for never, into when it happened
+func parseAgo(value string, now time.Time) *time.Time {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 3 {
+		return nil
+	}
+	var seconds int64
+	for _, p := range parts {
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			return nil
+		}
+		seconds = seconds*60 + n
+	}
+	t := now.Add(-time.Duration(seconds) * time.Second).UTC().Truncate(time.Second)
+	return &t
+}
+
+// ReadReplicator returns the replicator's status of every mailbox. It
+// fails when Dovecot has no replicator configured.
+func ReadReplicator(now time.Time) ([]Status, error) {
+	cmd := exec.Command("doveadm", "-f", "tab", "replicator", "status", "*")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("doveadm replicator status: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var header []string
+	statuses := []Status{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if header == nil {
+			header = fields
+			continue
+		}
+		row := map[string]string{}
+		for i, name := range header {
+			if i < len(fields) {
+				row[name] = fields[i]
+			}
+		}
+		st := Status{
+			Email:           strings.ToLower(row["username"]),
+			Priority:        row["priority"],
+			LastFastSync:    parseAgo(row["fast sync"], now),
+			LastFullSync:    parseAgo(row["full sync"], now),
+			LastSuccessSync: parseAgo(row["success sync"], now),
+			Failed:          row["failed"] != "" && row["failed"] != "-",
+		}
+		if st.Email != "" {
+			statuses = append(statuses, st)
+		}
+	}
+	return statuses, scanner.Err()
+}
+
+// dsyncUser finds the user a Dovecot log line is about, e.g.
+// "dsync-local(alice@example.com)<...>: Error: ..."
+var dsyncUser = regexp.MustCompile(`\(([^()\s]+@[^()\s]+)\)`)
+
+// Monitor polls the replicator and records each mailbox's state in
+// replication_status, and picks dsync errors out of the mail log
+type Monitor struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	available bool
+	lastErr   string
+	checkedAt time.Time
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewMonitor creates a replication monitor
+func NewMonitor(db *sql.DB) *Monitor {
+	return &Monitor{
+		db:     db,
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start begins polling
+func (m *Monitor) Start() {
+	m.done = make(chan struct{})
+	go m.loop()
+}
+
+// Stop stops polling
+func (m *Monitor) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopCh)
+		if m.done != nil {
+			<-m.done
+		}
+	})
+}
+
+func (m *Monitor) loop() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	m.Poll(time.Now())
+
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case now := <-ticker.C:
+			m.Poll(now)
+		}
+	}
+}
+
+// Poll reads the replicator once and stores what it reports
+func (m *Monitor) Poll(now time.Time) {
+	statuses, err := ReadReplicator(now)
+
+	m.mu.Lock()
+	wasAvailable := m.available
+	m.available = err == nil
+	m.checkedAt = now.UTC()
+	m.lastErr = ""
+	if err != nil {
+		m.lastErr = err.Error()
+	}
+	m.mu.Unlock()
+
+	if err != nil {
+		// Most installs don't replicate, so only a replicator that went away
+		// is worth a warning
+		if wasAvailable {
+			log.Warn().Err(err).Msg("Dovecot replicator status unavailable")
+		}
+		return
+	}
+
+	checked := now.UTC().Format(time.RFC3339)
+	for _, st := range statuses {
+		_, err := m.db.Exec(`
+			INSERT INTO replication_status (email, priority, last_fast_sync, last_full_sync, last_success_sync, failed, first_seen, checked_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(email) DO UPDATE SET priority = excluded.priority, last_fast_sync = excluded.last_fast_sync,
+				last_full_sync = excluded.last_full_sync, last_success_sync = excluded.last_success_sync,
+				failed = excluded.failed, checked_at = excluded.checked_at
+		`, st.Email, st.Priority, formatTime(st.LastFastSync), formatTime(st.LastFullSync), formatTime(st.LastSuccessSync), st.Failed, checked, checked)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to store replication status")
+			return
+		}
+	}
+	// Mailboxes the replicator no longer lists have been removed
+	m.db.Exec(`DELETE FROM replication_status WHERE checked_at < ?`, checked)
+}
+
+// State reports whether the replicator answered the last poll, and when
+func (m *Monitor) State() (available bool, checkedAt time.Time, lastErr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.available, m.checkedAt, m.lastErr
+}
+
+// Consume records dsync errors from Dovecot's log lines against the
+// mailbox they are about
+func (m *Monitor) Consume(e logs.Entry) {
+	if !strings.HasPrefix(e.Process, "dovecot") && !strings.HasPrefix(e.Process, "doveadm") {
+		return
+	}
+	if !strings.Contains(e.Message, "dsync") || !strings.Contains(e.Message, "Error:") {
+		return
+	}
+	match := dsyncUser.FindStringSubmatch(e.Message)
+	if match == nil {
+		return
+	}
+	msg := e.Message[strings.Index(e.Message, "Error:")+len("Error:"):]
+	at := e.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+	m.db.Exec(`UPDATE replication_status SET last_error = ?, last_error_at = ? WHERE email = ?`,
+		strings.TrimSpace(msg), at.UTC().Format(time.RFC3339), strings.ToLower(match[1]))
+}
+
+func formatTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Format(time.RFC3339)
+}
+
+// List returns the stored state of every replicated mailbox, most lagging
+// first. Lag is the time since the last successful sync, or for a mailbox
+// that never synced, since the replicator first listed it.
+func List(db *sql.DB, now time.Time) ([]Status, error) {
+	rows, err := db.Query(`
+		SELECT email, COALESCE(priority, ''), last_fast_sync, last_full_sync, last_success_sync, failed,
+			COALESCE(last_error, ''), last_error_at, COALESCE(last_success_sync, first_seen)
+		FROM replication_status
+		ORDER BY last_success_sync IS NOT NULL, last_success_sync, email
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := []Status{}
+	for rows.Next() {
+		var st Status
+		var fast, full, success, errorAt, since sql.NullString
+		if err := rows.Scan(&st.Email, &st.Priority, &fast, &full, &success, &st.Failed, &st.LastError, &errorAt, &since); err != nil {
+			continue
+		}
+		st.LastFastSync = parseTime(fast)
+		st.LastFullSync = parseTime(full)
+		st.LastSuccessSync = parseTime(success)
+		st.LastErrorAt = parseTime(errorAt)
+		if t := parseTime(since); t != nil {
+			st.LagSeconds = int64(now.Sub(*t).Seconds())
+		}
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+func parseTime(s sql.NullString) *time.Time {
+	if !s.Valid {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, s.String)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
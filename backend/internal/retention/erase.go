@@ -0,0 +1,150 @@
+package retention
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErasedPlaceholder replaces an erased address in the text it appeared in
+const ErasedPlaceholder = "[erased]"
+
+// scrubTargets are the text columns an address is replaced in, by table.
+// Log entries in a range under legal hold are left as they are.
+var scrubTargets = []struct {
+	table   string
+	columns []string
+	keep    string
+}{
+	{"mail_logs", []string{"mail_from", "mail_to", "message", "raw_line"}, notHeld("mail_logs", "timestamp")},
+	{"audit_log", []string{"username", "summary", "details", "diff"}, notHeld("audit_log", "timestamp")},
+	{"alerts", []string{"message"}, ""},
+	{"incident_events", []string{"message"}, ""},
+	{"dlp_events", []string{"recipients", "subject"}, ""},
+}
+
+// Erase removes an email address from logs and contact data: rows that
+// belong to the address (its send history, DLP events and quarantined
+// messages, its own address book) are deleted, contact entries for it are
+// deleted from every address book, and other mentions in log text are
+// replaced with ErasedPlaceholder. It returns the rows affected by table,
+// or ErrHeld if the address is a mailbox under legal hold.
+func Erase(db *sql.DB, address string) (map[string]int64, error) {
+	address = strings.ToLower(strings.TrimSpace(address))
+	if address == "" {
+		return nil, fmt.Errorf("address is required")
+	}
+	if MailboxHeld(db, address) {
+		return nil, ErrHeld
+	}
+	counts := make(map[string]int64)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	deletes := []struct {
+		key   string
+		query string
+	}{
+		{"mail_send_log", `DELETE FROM mail_send_log WHERE lower(sender_email) = ?`},
+		{"dlp_events", `DELETE FROM dlp_events WHERE lower(sender_email) = ?`},
+		{"dlp_quarantine", `DELETE FROM dlp_quarantine WHERE lower(sender_email) = ?`},
+		{"mail_contact_group_members", `DELETE FROM mail_contact_group_members WHERE contact_id IN (
+			SELECT id FROM mail_contacts WHERE lower(email) = ?1 OR lower(owner_email) = ?1)`},
+		{"mail_contacts", `DELETE FROM mail_contacts WHERE lower(email) = ?1 OR lower(owner_email) = ?1`},
+		{"mail_contact_groups", `DELETE FROM mail_contact_groups WHERE lower(owner_email) = ?`},
+		{"scan_results", `UPDATE scan_results SET owner_email = NULL WHERE lower(owner_email) = ?`},
+		{"login_history", `DELETE FROM login_history WHERE kind = 'webmail' AND username = ?`},
+		{"sink_messages", `DELETE FROM sink_messages WHERE lower(mail_from) = ?1 OR instr(',' || lower(rcpt_to) || ',', ',' || ?1 || ',') > 0`},
+	}
+	for _, d := range deletes {
+		res, err := tx.Exec(d.query, address)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", d.key, err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			counts[d.key] += n
+		}
+	}
+
+	pattern := regexp.MustCompile(`(?i)` + regexp.QuoteMeta(address))
+	for _, target := range scrubTargets {
+		n, err := scrub(tx, target.table, target.columns, target.keep, address, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", target.table, err)
+		}
+		if n > 0 {
+			counts[target.table] += n
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// scrub replaces the address in the given columns of every row that
+// mentions it and matches keep, if set. SQLite's replace() is
+// case-sensitive, so matching rows are rewritten here instead.
+func scrub(tx *sql.Tx, table string, columns []string, keep, address string, pattern *regexp.Regexp) (int64, error) {
+	like := "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(address) + "%"
+	conds := make([]string, len(columns))
+	args := make([]interface{}, len(columns))
+	for i, col := range columns {
+		conds[i] = col + ` LIKE ? ESCAPE '\'`
+		args[i] = like
+	}
+
+	where := `(` + strings.Join(conds, " OR ") + `)`
+	if keep != "" {
+		where += ` AND ` + keep
+	}
+	rows, err := tx.Query(`SELECT id, `+strings.Join(columns, ", ")+` FROM `+table+` WHERE `+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	type row struct {
+		id     int64
+		values []sql.NullString
+	}
+	var matched []row
+	for rows.Next() {
+		r := row{values: make([]sql.NullString, len(columns))}
+		dest := []interface{}{&r.id}
+		for i := range r.values {
+			dest = append(dest, &r.values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		matched = append(matched, r)
+	}
+	rows.Close()
+
+	sets := make([]string, len(columns))
+	for i, col := range columns {
+		sets[i] = col + ` = ?`
+	}
+	update := `UPDATE ` + table + ` SET ` + strings.Join(sets, ", ") + ` WHERE id = ?`
+	for _, r := range matched {
+		args := make([]interface{}, 0, len(columns)+1)
+		for _, v := range r.values {
+			if v.Valid {
+				args = append(args, pattern.ReplaceAllLiteralString(v.String, ErasedPlaceholder))
+			} else {
+				args = append(args, nil)
+			}
+		}
+		args = append(args, r.id)
+		if _, err := tx.Exec(update, args...); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(matched)), nil
+}
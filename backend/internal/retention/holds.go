@@ -0,0 +1,103 @@
+package retention
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Legal hold scopes
+const (
+	HoldMailbox = "mailbox" // a mailbox, its messages and its owner's data
+	HoldLogs    = "logs"    // mail and audit log entries in a time range
+)
+
+// ErrHeld is returned when data under an active legal hold would be
+// deleted
+var ErrHeld = errors.New("data is under legal hold")
+
+// Hold preserves a mailbox or a range of logs from pruning and deletion
+// until it is released. Released holds are kept as a record.
+type Hold struct {
+	ID            int64      `json:"id"`
+	Scope         string     `json:"scope"`
+	MailboxID     int64      `json:"mailboxId,omitempty"`
+	MailboxEmail  string     `json:"mailboxEmail,omitempty"`
+	RangeStart    *time.Time `json:"rangeStart,omitempty"`
+	RangeEnd      *time.Time `json:"rangeEnd,omitempty"`
+	Reason        string     `json:"reason"`
+	PlacedBy      string     `json:"placedBy"`
+	PlacedAt      time.Time  `json:"placedAt"`
+	ReleasedBy    string     `json:"releasedBy,omitempty"`
+	ReleasedAt    *time.Time `json:"releasedAt,omitempty"`
+	ReleaseReason string     `json:"releaseReason,omitempty"`
+}
+
+// Active reports whether the hold hasn't been released
+func (h Hold) Active() bool {
+	return h.ReleasedAt == nil
+}
+
+// HoldColumns are the legal_holds columns ScanHold reads
+const HoldColumns = `id, scope, COALESCE(mailbox_id, 0), COALESCE(mailbox_email, ''), range_start, range_end,
+	reason, placed_by, placed_at, COALESCE(released_by, ''), released_at, COALESCE(release_reason, '')`
+
+// ScanHold reads a hold selected with HoldColumns
+func ScanHold(scan func(...interface{}) error) (Hold, error) {
+	var h Hold
+	var start, end, released sql.NullString
+	var placed string
+	err := scan(&h.ID, &h.Scope, &h.MailboxID, &h.MailboxEmail, &start, &end,
+		&h.Reason, &h.PlacedBy, &placed, &h.ReleasedBy, &released, &h.ReleaseReason)
+	if err != nil {
+		return h, err
+	}
+	h.PlacedAt = parseTime(placed)
+	h.RangeStart = parseNullTime(start)
+	h.RangeEnd = parseNullTime(end)
+	h.ReleasedAt = parseNullTime(released)
+	return h, nil
+}
+
+func parseTime(s string) time.Time {
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func parseNullTime(s sql.NullString) *time.Time {
+	if !s.Valid || s.String == "" {
+		return nil
+	}
+	t := parseTime(s.String)
+	return &t
+}
+
+// MailboxHeld reports whether an active hold covers the mailbox with the
+// given email address
+func MailboxHeld(db *sql.DB, email string) bool {
+	var n int
+	db.QueryRow(`
+		SELECT COUNT(*) FROM legal_holds
+		WHERE scope = 'mailbox' AND released_at IS NULL AND lower(mailbox_email) = ?
+	`, strings.ToLower(strings.TrimSpace(email))).Scan(&n)
+	return n > 0
+}
+
+// notHeld returns a condition excluding rows of table whose column falls
+// in an active log hold's range
+func notHeld(table, column string) string {
+	return `NOT EXISTS (SELECT 1 FROM legal_holds h WHERE h.scope = 'logs' AND h.released_at IS NULL
+		AND datetime(` + table + `.` + column + `) BETWEEN datetime(h.range_start) AND datetime(h.range_end))`
+}
+
+// heldOwners is a condition matching rows whose column is the address of
+// a mailbox under an active hold
+func heldOwners(column string) string {
+	return `lower(` + column + `) IN (SELECT lower(mailbox_email) FROM legal_holds
+		WHERE scope = 'mailbox' AND released_at IS NULL)`
+}
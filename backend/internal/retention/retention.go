@@ -0,0 +1,216 @@
+// Package retention enforces how long logs, alerts, statistics, exports
+// and contact data are kept, and erases an address's personal data on
+// request.
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// Policy keeps one kind of data for the number of days in its setting.
+// Zero days keeps it forever.
+type Policy struct {
+	Name        string `json:"name"`
+	Setting     string `json:"setting"`
+	DefaultDays int    `json:"defaultDays"`
+	Description string `json:"description"`
+	// Collector names the stats package that prunes this data itself; the
+	// pruner leaves those tables alone
+	Collector string `json:"collector,omitempty"`
+	Days      int    `json:"days"`
+}
+
+// Policies lists the retention policies. Settings are read on every run,
+// so changes apply from the next prune.
+var Policies = []Policy{
+	{Name: "mail_logs", Setting: "log_retention_days", DefaultDays: 7, Description: "Parsed mail log lines"},
+	{Name: "audit_log", Setting: "audit_retention_days", DefaultDays: 90, Description: "Audit trail"},
+	{Name: "alerts", Setting: "incident_retention_days", DefaultDays: 180, Description: "Resolved alerts and incidents"},
+	{Name: "canary_probes", Setting: "canary_retention_days", DefaultDays: 30, Description: "Canary probe history"},
+	{Name: "archive_events", Setting: "archive_retention_days", DefaultDays: 30, Description: "Archive delivery failures"},
+	{Name: "smtpd_client_stats", Setting: "connstats_retention_days", DefaultDays: 7, Description: "Per-client connection counts", Collector: "connstats"},
+	{Name: "tls_peer_stats", Setting: "tlsstats_retention_days", DefaultDays: 30, Description: "TLS peer statistics", Collector: "tlsstats"},
+	{Name: "delivery_stats", Setting: "delivery_retention_days", DefaultDays: 90, Description: "Delivery statistics", Collector: "deliverystats"},
+	{Name: "flow_stats", Setting: "flowstats_retention_days", DefaultDays: 30, Description: "Mail flow between sources, relays and destinations", Collector: "flowstats"},
+	{Name: "queue_samples", Setting: "queuestats_retention_days", DefaultDays: 30, Description: "Queue size history", Collector: "queuestats"},
+	{Name: "mailbox_usage_samples", Setting: "mailbox_growth_days", DefaultDays: 365, Description: "Mailbox size history", Collector: "mailboxstats"},
+	{Name: "host_samples", Setting: "hoststats_retention_days", DefaultDays: 7, Description: "Host CPU, memory and disk history", Collector: "hoststats"},
+	{Name: "exports", Setting: "export_retention_days", DefaultDays: 30, Description: "Generated export files"},
+	{Name: "login_history", Setting: "login_retention_days", DefaultDays: 90, Description: "Admin and webmail sign-in attempts"},
+	{Name: "routing_trash", Setting: "trash_retention_days", DefaultDays: 30, Description: "Deleted transport maps, sender relays and backscatter domains"},
+	{Name: "contacts", Setting: "contact_retention_days", DefaultDays: 0, Description: "Webmail contacts not updated within the period"},
+}
+
+// Result is what one run removed, by policy name
+type Result struct {
+	RanAt   time.Time        `json:"ranAt"`
+	Deleted map[string]int64 `json:"deleted"`
+	Errors  []string         `json:"errors,omitempty"`
+}
+
+// Pruner deletes data that has outlived its policy. It runs as a
+// scheduled task.
+type Pruner struct {
+	db           *sql.DB
+	store        func() (storage.Store, error)
+	exportPrefix string
+
+	mu   sync.Mutex
+	last *Result
+}
+
+// NewPruner creates a pruner. Export files are looked up under
+// exportPrefix in the object store returned by store.
+func NewPruner(db *sql.DB, store func() (storage.Store, error), exportPrefix string) *Pruner {
+	return &Pruner{
+		db:           db,
+		store:        store,
+		exportPrefix: exportPrefix,
+	}
+}
+
+// LastRun returns the result of the most recent run, or nil
+func (p *Pruner) LastRun() *Result {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.last
+}
+
+// CurrentPolicies returns the policies with their configured days
+func CurrentPolicies(db *sql.DB) []Policy {
+	out := make([]Policy, len(Policies))
+	for i, pol := range Policies {
+		pol.Days = days(db, pol)
+		out[i] = pol
+	}
+	return out
+}
+
+// days reads a policy's setting, falling back to its default
+func days(db *sql.DB, pol Policy) int {
+	var value string
+	if err := db.QueryRow(`SELECT value FROM settings WHERE key = ?`, pol.Setting).Scan(&value); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return pol.DefaultDays
+}
+
+// Run applies every policy once
+func (p *Pruner) Run(ctx context.Context, now time.Time) *Result {
+	result := &Result{RanAt: now.UTC(), Deleted: make(map[string]int64)}
+
+	for _, pol := range Policies {
+		if pol.Collector != "" {
+			continue
+		}
+		d := days(p.db, pol)
+		if d == 0 {
+			continue
+		}
+		cutoff := now.UTC().AddDate(0, 0, -d)
+
+		var n int64
+		var err error
+		if pol.Name == "exports" {
+			n, err = p.pruneExports(ctx, cutoff)
+		} else {
+			n, err = p.pruneTable(pol.Name, cutoff.Format("2006-01-02 15:04:05"))
+		}
+		if err != nil {
+			log.Error().Err(err).Str("policy", pol.Name).Msg("Retention prune failed")
+			result.Errors = append(result.Errors, pol.Name+": "+err.Error())
+			continue
+		}
+		result.Deleted[pol.Name] = n
+	}
+
+	p.mu.Lock()
+	p.last = result
+	p.mu.Unlock()
+	return result
+}
+
+// pruneStatements are the deletes for each table policy. Timestamps are
+// stored both as RFC 3339 and as SQLite's CURRENT_TIMESTAMP, so they are
+// normalized with datetime() before comparing. Logs in a held range or
+// about a held mailbox, and held mailboxes' contacts, are kept.
+var pruneStatements = map[string][]string{
+	"mail_logs": {`DELETE FROM mail_logs WHERE datetime(timestamp) < ? AND ` + notHeld("mail_logs", "timestamp") +
+		` AND NOT (` + heldOwners("COALESCE(mail_from, '')") + ` OR ` + heldOwners("COALESCE(mail_to, '')") + `)`},
+	"audit_log": {`DELETE FROM audit_log WHERE datetime(timestamp) < ? AND ` + notHeld("audit_log", "timestamp")},
+	"alerts": {
+		`DELETE FROM incident_events WHERE incident_id IN (
+			SELECT id FROM incidents WHERE status = 'resolved' AND datetime(resolved_at) < ?)`,
+		`DELETE FROM incidents WHERE status = 'resolved' AND datetime(resolved_at) < ?`,
+		`DELETE FROM alerts WHERE status = 'resolved' AND datetime(resolved_at) < ?
+			AND (incident_id IS NULL OR incident_id NOT IN (SELECT id FROM incidents))
+			AND id NOT IN (SELECT alert_id FROM incident_events WHERE alert_id IS NOT NULL)`,
+	},
+	"canary_probes":  {`DELETE FROM canary_probes WHERE status != 'pending' AND datetime(sent_at) < ?`},
+	"archive_events": {`DELETE FROM archive_events WHERE datetime(occurred_at) < ?`},
+	"routing_trash":  {`DELETE FROM routing_trash WHERE datetime(deleted_at) < ?`},
+	"login_history": {`DELETE FROM login_history WHERE datetime(occurred_at) < ? AND ` + notHeld("login_history", "occurred_at") +
+		` AND NOT ` + heldOwners("username")},
+	"contacts": {
+		`DELETE FROM mail_contact_group_members WHERE contact_id IN (
+			SELECT id FROM mail_contacts WHERE favorite = FALSE AND datetime(updated_at) < ?
+			AND NOT ` + heldOwners("owner_email") + `)`,
+		`DELETE FROM mail_contacts WHERE favorite = FALSE AND datetime(updated_at) < ?
+			AND NOT ` + heldOwners("owner_email"),
+	},
+}
+
+// pruneTable runs a table policy's deletes in one transaction
+func (p *Pruner) pruneTable(name, cutoff string) (int64, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmts := pruneStatements[name]
+	var deleted int64
+	for i, stmt := range stmts {
+		res, err := tx.Exec(stmt, cutoff)
+		if err != nil {
+			return 0, err
+		}
+		// Count the rows of the policy's main table, the last statement
+		if i == len(stmts)-1 {
+			deleted, _ = res.RowsAffected()
+		}
+	}
+	return deleted, tx.Commit()
+}
+
+// pruneExports deletes export files last written before cutoff
+func (p *Pruner) pruneExports(ctx context.Context, cutoff time.Time) (int64, error) {
+	store, err := p.store()
+	if err != nil {
+		return 0, err
+	}
+	objects, err := store.List(ctx, p.exportPrefix)
+	if err != nil {
+		return 0, err
+	}
+	var deleted int64
+	for _, obj := range objects {
+		if obj.LastModified.Before(cutoff) {
+			if err := store.Delete(ctx, obj.Key); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
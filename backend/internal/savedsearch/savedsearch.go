@@ -0,0 +1,161 @@
+// Package savedsearch stores named log and queue filter sets and runs them
+// on demand or on a schedule.
+package savedsearch
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/postfixrelay/postfixrelay/internal/postfix"
+)
+
+// Sources a search can run against
+const (
+	SourceLogs  = "logs"
+	SourceQueue = "queue"
+)
+
+// maxLogEntries is how far back in the mail log a search looks
+const maxLogEntries = 10000
+
+// Filters select log entries or queue messages. Empty fields match
+// everything; text matches are case-insensitive substring matches.
+type Filters struct {
+	Search    string `json:"search,omitempty"`        // Message text or queue ID (logs), reason (queue)
+	QueueID   string `json:"queueId,omitempty"`       // Exact queue ID
+	Sender    string `json:"sender,omitempty"`        // Envelope sender
+	Recipient string `json:"recipient,omitempty"`     // Envelope recipient
+	Status    string `json:"status,omitempty"`        // Delivery status (logs) or queue (active, deferred, hold)
+	Process   string `json:"process,omitempty"`       // Logs only, e.g. "smtpd"
+	MinAge    int    `json:"minAgeMinutes,omitempty"` // Queue only: messages queued at least this long
+}
+
+func contains(s, substr string) bool {
+	return substr == "" || strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// MatchEntry reports whether a log entry matches the filters
+func (f Filters) MatchEntry(e logs.Entry) bool {
+	if f.Search != "" && !contains(e.Message, f.Search) && !strings.Contains(e.QueueID, f.Search) {
+		return false
+	}
+	if f.QueueID != "" && e.QueueID != f.QueueID {
+		return false
+	}
+	if f.Status != "" && !strings.EqualFold(e.Status, f.Status) {
+		return false
+	}
+	return contains(e.MailFrom, f.Sender) && contains(e.MailTo, f.Recipient) && contains(e.Process, f.Process)
+}
+
+// MatchMessage reports whether a queued message matches the filters
+func (f Filters) MatchMessage(m postfix.QueueMessage, now time.Time) bool {
+	if f.QueueID != "" && m.QueueID != f.QueueID {
+		return false
+	}
+	if !contains(m.Reason, f.Search) || !contains(m.Sender, f.Sender) {
+		return false
+	}
+	if f.Recipient != "" && !contains(strings.Join(m.Recipients, ","), f.Recipient) {
+		return false
+	}
+	if f.MinAge > 0 && now.Sub(m.ArrivalTime) < time.Duration(f.MinAge)*time.Minute {
+		return false
+	}
+	return true
+}
+
+// Search is a saved, named filter set
+type Search struct {
+	ID              int64      `json:"id"`
+	UserID          int64      `json:"userId"`
+	Name            string     `json:"name"`
+	Source          string     `json:"source"`
+	Filters         Filters    `json:"filters"`
+	ScheduleMinutes int        `json:"scheduleMinutes"`          // 0 = run on demand only
+	EmailTo         string     `json:"emailTo,omitempty"`        // Scheduled results are mailed here
+	AlertThreshold  *int       `json:"alertThreshold,omitempty"` // Alert when a scheduled run returns more rows
+	LastRunAt       *time.Time `json:"lastRunAt,omitempty"`
+	LastResultCount *int       `json:"lastResultCount,omitempty"`
+	LastError       string     `json:"lastError,omitempty"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
+
+// Result is the output of running a search
+type Result struct {
+	Count    int                    `json:"count"`
+	Logs     []logs.Entry           `json:"logs,omitempty"`
+	Messages []postfix.QueueMessage `json:"messages,omitempty"`
+	RanAt    time.Time              `json:"ranAt"`
+}
+
+// Sources give a search access to the mail log and queue
+type Sources struct {
+	ReadLogs  func(n int) ([]logs.Entry, error)
+	ListQueue func(status string) ([]postfix.QueueMessage, error)
+}
+
+// Run executes a search
+func (src Sources) Run(s *Search) (*Result, error) {
+	now := time.Now().UTC()
+	result := &Result{RanAt: now}
+
+	switch s.Source {
+	case SourceLogs:
+		entries, err := src.ReadLogs(maxLogEntries)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read logs: %w", err)
+		}
+		result.Logs = []logs.Entry{}
+		for _, e := range entries {
+			if s.Filters.MatchEntry(e) {
+				result.Logs = append(result.Logs, e)
+			}
+		}
+		result.Count = len(result.Logs)
+
+	case SourceQueue:
+		messages, err := src.ListQueue(s.Filters.Status)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list queue: %w", err)
+		}
+		result.Messages = []postfix.QueueMessage{}
+		for _, m := range messages {
+			if s.Filters.MatchMessage(m, now) {
+				result.Messages = append(result.Messages, m)
+			}
+		}
+		result.Count = len(result.Messages)
+
+	default:
+		return nil, fmt.Errorf("unknown source %q", s.Source)
+	}
+	return result, nil
+}
+
+// CSV renders a result as CSV
+func (r *Result) CSV() []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if r.Messages != nil {
+		w.Write([]string{"queue_id", "status", "size", "arrival_time", "sender", "recipients", "reason"})
+		for _, m := range r.Messages {
+			w.Write([]string{m.QueueID, m.Status, strconv.FormatInt(m.Size, 10),
+				m.ArrivalTime.Format(time.RFC3339), m.Sender, strings.Join(m.Recipients, " "), m.Reason})
+		}
+	} else {
+		w.Write([]string{"timestamp", "hostname", "process", "pid", "queue_id", "from", "to", "status", "relay", "message"})
+		for _, e := range r.Logs {
+			w.Write([]string{e.Timestamp.Format(time.RFC3339), e.Hostname, e.Process, strconv.Itoa(e.PID),
+				e.QueueID, e.MailFrom, e.MailTo, e.Status, e.Relay, e.Message})
+		}
+	}
+	w.Flush()
+	return buf.Bytes()
+}
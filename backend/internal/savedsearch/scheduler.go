@@ -0,0 +1,104 @@
+package savedsearch
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SendFunc mails the results of a scheduled run
+type SendFunc func(s *Search, r *Result) error
+
+// Scheduler runs saved searches that have a schedule. Results are mailed
+// to the search's emailTo when the run returns rows; the row count is
+// recorded for the saved search alert rule.
+type Scheduler struct {
+	db       *sql.DB
+	sources  Sources
+	send     SendFunc
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewScheduler creates a saved search scheduler
+func NewScheduler(db *sql.DB, sources Sources, send SendFunc) *Scheduler {
+	return &Scheduler{
+		db:      db,
+		sources: sources,
+		send:    send,
+		stopCh:  make(chan struct{}),
+	}
+}
+
+// Start begins the scheduling loop
+func (s *Scheduler) Start() {
+	s.done = make(chan struct{})
+	go s.loop()
+	log.Info().Msg("Saved search scheduler started")
+}
+
+// Stop stops the scheduler and waits for in-progress runs to finish
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+		if s.done != nil {
+			<-s.done
+		}
+		log.Info().Msg("Saved search scheduler stopped")
+	})
+}
+
+func (s *Scheduler) loop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case now := <-ticker.C:
+			s.runDue(now.UTC())
+		}
+	}
+}
+
+// runDue runs every search whose interval has passed since its last run
+func (s *Scheduler) runDue(now time.Time) {
+	searches, err := Scheduled(s.db)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load scheduled searches")
+		return
+	}
+
+	for i := range searches {
+		search := &searches[i]
+		interval := time.Duration(search.ScheduleMinutes) * time.Minute
+		if search.LastRunAt != nil && now.Sub(*search.LastRunAt) < interval {
+			continue
+		}
+		s.RunScheduled(search)
+	}
+}
+
+// RunScheduled runs a search, records the result and mails it
+func (s *Scheduler) RunScheduled(search *Search) (*Result, error) {
+	result, err := s.sources.Run(search)
+	if err != nil {
+		RecordRun(s.db, search.ID, time.Now().UTC(), 0, err)
+		log.Error().Err(err).Int64("search", search.ID).Msg("Scheduled search failed")
+		return nil, err
+	}
+	RecordRun(s.db, search.ID, result.RanAt, result.Count, nil)
+
+	if search.EmailTo != "" && result.Count > 0 {
+		if err := s.send(search, result); err != nil {
+			log.Error().Err(err).Int64("search", search.ID).Str("to", search.EmailTo).Msg("Failed to mail search results")
+		}
+	}
+	return result, nil
+}
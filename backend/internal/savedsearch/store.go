@@ -0,0 +1,136 @@
+package savedsearch
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when a saved search does not exist
+var ErrNotFound = errors.New("saved search not found")
+
+const selectColumns = `
+	SELECT id, user_id, name, source, filters, schedule_minutes, COALESCE(email_to, ''), alert_threshold,
+		last_run_at, last_result_count, COALESCE(last_error, ''), created_at, updated_at
+	FROM saved_searches`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scan(row scanner) (*Search, error) {
+	var s Search
+	var filters string
+	var threshold, lastCount sql.NullInt64
+	var lastRun sql.NullTime
+	err := row.Scan(&s.ID, &s.UserID, &s.Name, &s.Source, &filters, &s.ScheduleMinutes, &s.EmailTo, &threshold,
+		&lastRun, &lastCount, &s.LastError, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(filters), &s.Filters)
+	if threshold.Valid {
+		n := int(threshold.Int64)
+		s.AlertThreshold = &n
+	}
+	if lastRun.Valid {
+		s.LastRunAt = &lastRun.Time
+	}
+	if lastCount.Valid {
+		n := int(lastCount.Int64)
+		s.LastResultCount = &n
+	}
+	return &s, nil
+}
+
+func query(db *sql.DB, where string, args ...interface{}) ([]Search, error) {
+	rows, err := db.Query(selectColumns+" "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	searches := []Search{}
+	for rows.Next() {
+		s, err := scan(rows)
+		if err != nil {
+			continue
+		}
+		searches = append(searches, *s)
+	}
+	return searches, nil
+}
+
+// List returns a user's saved searches
+func List(db *sql.DB, userID int64) ([]Search, error) {
+	return query(db, `WHERE user_id = ? ORDER BY name`, userID)
+}
+
+// Scheduled returns every search with a schedule
+func Scheduled(db *sql.DB) ([]Search, error) {
+	return query(db, `WHERE schedule_minutes > 0 ORDER BY id`)
+}
+
+// Get returns a saved search by ID
+func Get(db *sql.DB, id int64) (*Search, error) {
+	s, err := scan(db.QueryRow(selectColumns+` WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return s, err
+}
+
+// Create stores a new saved search, setting its ID
+func Create(db *sql.DB, s *Search) error {
+	filters, _ := json.Marshal(s.Filters)
+	result, err := db.Exec(`
+		INSERT INTO saved_searches (user_id, name, source, filters, schedule_minutes, email_to, alert_threshold)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, s.UserID, s.Name, s.Source, string(filters), s.ScheduleMinutes, s.EmailTo, s.AlertThreshold)
+	if err != nil {
+		return err
+	}
+	s.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// Update saves a search's name, filters and schedule
+func Update(db *sql.DB, s *Search) error {
+	filters, _ := json.Marshal(s.Filters)
+	result, err := db.Exec(`
+		UPDATE saved_searches SET name = ?, source = ?, filters = ?, schedule_minutes = ?, email_to = ?,
+			alert_threshold = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, s.Name, s.Source, string(filters), s.ScheduleMinutes, s.EmailTo, s.AlertThreshold, s.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Delete removes a saved search
+func Delete(db *sql.DB, id int64) error {
+	result, err := db.Exec(`DELETE FROM saved_searches WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RecordRun stores the outcome of a scheduled run
+func RecordRun(db *sql.DB, id int64, ranAt time.Time, count int, runErr error) {
+	if runErr != nil {
+		db.Exec(`UPDATE saved_searches SET last_run_at = ?, last_result_count = NULL, last_error = ? WHERE id = ?`,
+			ranAt, runErr.Error(), id)
+		return
+	}
+	db.Exec(`UPDATE saved_searches SET last_run_at = ?, last_result_count = ?, last_error = NULL WHERE id = ?`,
+		ranAt, count, id)
+}
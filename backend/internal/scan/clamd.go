@@ -0,0 +1,120 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is the size of each INSTREAM chunk sent to clamd
+const clamdChunkSize = 64 * 1024
+
+// ClamdScanner scans content with a ClamAV daemon using the INSTREAM command
+type ClamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamd creates a clamd scanner. addr is "unix:/path/to/clamd.sock" or
+// "tcp:host:port" (a bare "host:port" is treated as TCP).
+func NewClamd(addr string, timeout time.Duration) (*ClamdScanner, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("clamd address is required")
+	}
+
+	network, address := "tcp", addr
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		network, address = "unix", strings.TrimPrefix(addr, "unix:")
+	case strings.HasPrefix(addr, "tcp:"):
+		address = strings.TrimPrefix(addr, "tcp:")
+	case strings.HasPrefix(addr, "/"):
+		network = "unix"
+	}
+
+	return &ClamdScanner{network: network, address: address, timeout: timeout}, nil
+}
+
+// Name returns the engine name
+func (c *ClamdScanner) Name() string {
+	return "clamd"
+}
+
+// Scan streams r to clamd and parses its verdict
+func (c *ClamdScanner) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, fmt.Errorf("failed to send INSTREAM: %w", err)
+	}
+
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return nil, fmt.Errorf("failed to stream to clamd: %w", err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return nil, fmt.Errorf("failed to stream to clamd: %w", err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read content: %w", readErr)
+		}
+	}
+
+	// Zero-length chunk terminates the stream
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return nil, fmt.Errorf("failed to finish stream: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return nil, fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply parses "stream: OK", "stream: Eicar-Signature FOUND" or
+// "... ERROR" replies
+func parseClamdReply(reply string) (*Result, error) {
+	result := &Result{Engine: "clamd", ScannedAt: time.Now().UTC()}
+	verdict := strings.TrimSpace(reply[strings.Index(reply, ":")+1:])
+
+	switch {
+	case verdict == "OK":
+		result.Status = StatusClean
+	case strings.HasSuffix(verdict, " FOUND"):
+		result.Status = StatusInfected
+		result.Signature = strings.TrimSuffix(verdict, " FOUND")
+	case strings.HasSuffix(verdict, " ERROR"):
+		return nil, fmt.Errorf("clamd error: %s", strings.TrimSuffix(verdict, " ERROR"))
+	default:
+		return nil, fmt.Errorf("unexpected clamd reply: %q", reply)
+	}
+	return result, nil
+}
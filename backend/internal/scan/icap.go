@@ -0,0 +1,131 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ICAPScanner scans content with an ICAP antivirus service (RFC 3507) using
+// RESPMOD, as supported by c-icap, Kaspersky, Symantec and similar gateways
+type ICAPScanner struct {
+	url     *url.URL
+	timeout time.Duration
+}
+
+// threatRegex extracts the threat name from X-Infection-Found
+var threatRegex = regexp.MustCompile(`Threat=([^;]+)`)
+
+// NewICAP creates an ICAP scanner for a service URL such as
+// icap://icap.example.com:1344/avscan
+func NewICAP(rawURL string, timeout time.Duration) (*ICAPScanner, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "icap" || u.Host == "" {
+		return nil, fmt.Errorf("invalid ICAP URL %q (expected icap://host:port/service)", rawURL)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "1344")
+	}
+	return &ICAPScanner{url: u, timeout: timeout}, nil
+}
+
+// Name returns the engine name
+func (c *ICAPScanner) Name() string {
+	return "icap"
+}
+
+// Scan submits r as the body of an HTTP response via RESPMOD
+func (c *ICAPScanner) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", c.url.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ICAP server: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	resHdr := "HTTP/1.1 200 OK\r\nContent-Type: application/octet-stream\r\n\r\n"
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "RESPMOD %s ICAP/1.0\r\n", c.url.String())
+	fmt.Fprintf(w, "Host: %s\r\n", c.url.Hostname())
+	fmt.Fprintf(w, "Allow: 204\r\n")
+	fmt.Fprintf(w, "Encapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(resHdr))
+	w.WriteString(resHdr)
+
+	// Body is sent with HTTP chunked encoding
+	buf := make([]byte, 64*1024)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(w, "%x\r\n", n)
+			w.Write(buf[:n])
+			w.WriteString("\r\n")
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read content: %w", readErr)
+		}
+	}
+	w.WriteString("0\r\n\r\n")
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to send to ICAP server: %w", err)
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(conn))
+	statusLine, err := tp.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ICAP response: %w", err)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return nil, fmt.Errorf("failed to read ICAP headers: %w", err)
+	}
+
+	return parseICAPResponse(statusLine, header)
+}
+
+// parseICAPResponse maps an ICAP status and headers to a result. 204 means
+// the content was not modified (clean); a 200 carrying an infection header
+// means the service replaced the content because it found malware.
+func parseICAPResponse(statusLine string, header textproto.MIMEHeader) (*Result, error) {
+	fields := strings.Fields(statusLine)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "ICAP/") {
+		return nil, fmt.Errorf("unexpected ICAP response: %q", statusLine)
+	}
+
+	result := &Result{Engine: "icap", Status: StatusClean, ScannedAt: time.Now().UTC()}
+
+	switch fields[1] {
+	case "204":
+		return result, nil
+	case "200":
+		if v := header.Get("X-Infection-Found"); v != "" {
+			result.Status = StatusInfected
+			result.Signature = v
+			if m := threatRegex.FindStringSubmatch(v); m != nil {
+				result.Signature = strings.TrimSpace(m[1])
+			}
+		} else if v := header.Get("X-Virus-ID"); v != "" {
+			result.Status = StatusInfected
+			result.Signature = v
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("ICAP server returned %s", strings.Join(fields[1:], " "))
+	}
+}
@@ -0,0 +1,79 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Status values for a scan result
+const (
+	StatusClean    = "clean"
+	StatusInfected = "infected"
+	StatusError    = "error"
+	StatusSkipped  = "skipped"
+)
+
+// Result is the outcome of scanning one object
+type Result struct {
+	Status    string    `json:"status"`
+	Signature string    `json:"signature,omitempty"` // Malware name reported by the engine
+	Engine    string    `json:"engine"`
+	Error     string    `json:"error,omitempty"`
+	ScannedAt time.Time `json:"scannedAt"`
+}
+
+// Infected reports whether the scanner found malware
+func (r *Result) Infected() bool {
+	return r.Status == StatusInfected
+}
+
+// Scanner checks content for malware
+type Scanner interface {
+	// Scan reads r to EOF and reports whether it contains malware. A non-nil
+	// error means the content could not be scanned.
+	Scan(ctx context.Context, r io.Reader) (*Result, error)
+	Name() string
+}
+
+// Config selects and configures a scanning engine
+type Config struct {
+	Engine  string        // "none", "clamd" or "icap"
+	Address string        // clamd: unix:/path or tcp:host:port; icap: icap://host:port/service
+	Timeout time.Duration // Per-scan timeout
+}
+
+// New returns the scanner for a configuration, or nil if scanning is disabled
+func New(cfg Config) (Scanner, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 60 * time.Second
+	}
+
+	switch strings.ToLower(cfg.Engine) {
+	case "", "none":
+		return nil, nil
+	case "clamd":
+		return NewClamd(cfg.Address, cfg.Timeout)
+	case "icap":
+		return NewICAP(cfg.Address, cfg.Timeout)
+	default:
+		return nil, fmt.Errorf("unknown scan engine %q (expected none, clamd or icap)", cfg.Engine)
+	}
+}
+
+// Run scans r with s and always returns a result: scanner errors and a nil
+// (disabled) scanner are reported as error/skipped statuses rather than
+// failing, so callers can apply their own policy.
+func Run(ctx context.Context, s Scanner, r io.Reader) *Result {
+	if s == nil {
+		return &Result{Status: StatusSkipped, Engine: "none", ScannedAt: time.Now().UTC()}
+	}
+
+	result, err := s.Scan(ctx, r)
+	if err != nil {
+		return &Result{Status: StatusError, Engine: s.Name(), Error: err.Error(), ScannedAt: time.Now().UTC()}
+	}
+	return result
+}
@@ -0,0 +1,425 @@
+// Package scheduler runs recurring jobs on cron schedules. Jobs register
+// as named tasks with a default schedule. Admins can change a task's
+// schedule, switch it off, add jitter and run it by hand, and each task
+// keeps the outcome of its last run. Settings and outcomes are kept in the
+// database, so they survive restarts.
+//
+// Schedules are evaluated in the server's local time zone.
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// MaxJitter bounds a task's jitter
+const MaxJitter = time.Hour
+
+var (
+	// ErrUnknown is returned for a task that isn't registered
+	ErrUnknown = errors.New("unknown scheduled task")
+	// ErrRunning is returned when triggering a task that is already running
+	ErrRunning = errors.New("task is already running")
+)
+
+// InvalidSettingError is returned by Update for a setting it can't accept
+type InvalidSettingError struct {
+	Field string // as in Settings' JSON
+	Err   error
+}
+
+func (e *InvalidSettingError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+// Task is a recurring job
+type Task struct {
+	Name        string
+	Description string
+	Schedule    string        // default cron expression
+	Jitter      time.Duration // default upper bound of a random delay before each scheduled run
+	Run         func(ctx context.Context) error
+}
+
+// Status is a task with its settings and last run
+type Status struct {
+	Name            string     `json:"name"`
+	Description     string     `json:"description"`
+	Schedule        string     `json:"schedule"`
+	DefaultSchedule string     `json:"defaultSchedule"`
+	Enabled         bool       `json:"enabled"`
+	JitterSeconds   int        `json:"jitterSeconds"`
+	Running         bool       `json:"running"`
+	NextRunAt       *time.Time `json:"nextRunAt,omitempty"`
+	LastRunAt       *time.Time `json:"lastRunAt,omitempty"`
+	LastStatus      string     `json:"lastStatus,omitempty"` // success or failed
+	LastError       string     `json:"lastError,omitempty"`
+	LastDurationMs  int64      `json:"lastDurationMs,omitempty"`
+	LastTrigger     string     `json:"lastTrigger,omitempty"` // "schedule" or who ran it by hand
+	UpdatedBy       string     `json:"updatedBy,omitempty"`
+	UpdatedAt       *time.Time `json:"updatedAt,omitempty"`
+}
+
+// Settings changes a task. Nil fields are left as they are; an empty
+// Schedule restores the default.
+type Settings struct {
+	Schedule      *string `json:"schedule"`
+	Enabled       *bool   `json:"enabled"`
+	JitterSeconds *int    `json:"jitterSeconds"`
+}
+
+// Runner starts a run in the background, such as supervisor.Go with a
+// policy. fn's error is the run's outcome.
+type Runner func(name string, fn func() error)
+
+// entry is a registered task and its state. Fields are guarded by the
+// scheduler's mutex.
+type entry struct {
+	task     Task
+	schedule *Schedule
+	status   Status
+	next     time.Time
+}
+
+// Scheduler runs registered tasks when they are due
+type Scheduler struct {
+	db  *sql.DB
+	run Runner
+
+	mu      sync.Mutex
+	entries []*entry
+	byName  map[string]*entry
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wake   chan struct{}
+	done   chan struct{}
+}
+
+// New creates a scheduler that starts runs with run
+func New(db *sql.DB, run Runner) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		db:     db,
+		run:    run,
+		byName: make(map[string]*entry),
+		ctx:    ctx,
+		cancel: cancel,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Register adds a task, with any settings saved for it. It panics on a
+// duplicate name or an invalid default schedule, which are programming
+// errors.
+func (s *Scheduler) Register(t Task) {
+	def, err := Parse(t.Schedule)
+	if err != nil {
+		panic(fmt.Sprintf("scheduler: task %s: %v", t.Name, err))
+	}
+	e := &entry{task: t, schedule: def, status: Status{
+		Name:            t.Name,
+		Description:     t.Description,
+		Schedule:        t.Schedule,
+		DefaultSchedule: t.Schedule,
+		Enabled:         true,
+		JitterSeconds:   int(t.Jitter / time.Second),
+	}}
+	if err := s.load(e); err != nil {
+		log.Error().Err(err).Str("task", t.Name).Msg("Failed to load scheduled task settings; using defaults")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, dup := s.byName[t.Name]; dup {
+		panic("scheduler: task " + t.Name + " registered twice")
+	}
+	e.next = e.schedule.Next(time.Now())
+	s.entries = append(s.entries, e)
+	s.byName[t.Name] = e
+	s.poke()
+}
+
+// load applies a task's saved settings and last run to e
+func (s *Scheduler) load(e *entry) error {
+	var schedule, lastStatus, lastError, lastTrigger, updatedBy sql.NullString
+	var jitter, lastDuration sql.NullInt64
+	var lastRunAt, updatedAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT schedule, enabled, jitter_seconds, last_run_at, last_status, last_error,
+			last_duration_ms, last_trigger, updated_by, updated_at
+		FROM scheduled_tasks WHERE name = ?
+	`, e.task.Name).Scan(&schedule, &e.status.Enabled, &jitter, &lastRunAt, &lastStatus, &lastError,
+		&lastDuration, &lastTrigger, &updatedBy, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if schedule.String != "" {
+		if sched, err := Parse(schedule.String); err != nil {
+			log.Warn().Err(err).Str("task", e.task.Name).Msg("Saved schedule is invalid; using the default")
+		} else {
+			e.schedule = sched
+			e.status.Schedule = sched.String()
+		}
+	}
+	if jitter.Valid {
+		e.status.JitterSeconds = int(jitter.Int64)
+	}
+	if lastRunAt.Valid {
+		e.status.LastRunAt = &lastRunAt.Time
+	}
+	if updatedAt.Valid {
+		e.status.UpdatedAt = &updatedAt.Time
+	}
+	e.status.LastStatus = lastStatus.String
+	e.status.LastError = lastError.String
+	e.status.LastDurationMs = lastDuration.Int64
+	e.status.LastTrigger = lastTrigger.String
+	e.status.UpdatedBy = updatedBy.String
+	return nil
+}
+
+// Start begins running tasks when they are due
+func (s *Scheduler) Start() {
+	s.done = make(chan struct{})
+	go s.loop()
+}
+
+// Stop stops scheduling and cancels the context of running tasks. Runs
+// already started are waited for by their Runner.
+func (s *Scheduler) Stop() {
+	s.cancel()
+	if s.done != nil {
+		<-s.done
+	}
+}
+
+func (s *Scheduler) loop() {
+	defer close(s.done)
+
+	for {
+		// Sleep until the next task is due, but wake at least every
+		// minute so a changed clock is noticed
+		wait := time.Minute
+		now := time.Now()
+		s.mu.Lock()
+		for _, e := range s.entries {
+			if e.status.Enabled && !e.next.IsZero() && e.next.Sub(now) < wait {
+				wait = e.next.Sub(now)
+			}
+		}
+		s.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		now = time.Now()
+		s.mu.Lock()
+		for _, e := range s.entries {
+			if !e.status.Enabled || e.next.IsZero() || e.next.After(now) {
+				continue
+			}
+			e.next = e.schedule.Next(now)
+			if e.status.Running {
+				log.Warn().Str("task", e.task.Name).Msg("Scheduled task still running; skipping this run")
+				continue
+			}
+			var delay time.Duration
+			if j := time.Duration(e.status.JitterSeconds) * time.Second; j > 0 {
+				delay = time.Duration(rand.Int63n(int64(j)))
+			}
+			s.start(e, "schedule", delay)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// poke wakes the loop to recompute when the next task is due
+func (s *Scheduler) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// start runs e after delay. Call with mu held.
+func (s *Scheduler) start(e *entry, trigger string, delay time.Duration) {
+	e.status.Running = true
+	s.run(e.task.Name, func() error {
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-s.ctx.Done():
+				s.finish(e, trigger, time.Now(), s.ctx.Err())
+				return nil
+			}
+		}
+		started := time.Now()
+		err := e.task.Run(s.ctx)
+		s.finish(e, trigger, started, err)
+		return err
+	})
+}
+
+// finish records the outcome of a run
+func (s *Scheduler) finish(e *entry, trigger string, started time.Time, err error) {
+	ranAt := started.UTC()
+	duration := time.Since(started).Milliseconds()
+	status, msg := "success", ""
+	if err != nil {
+		status, msg = "failed", err.Error()
+	}
+
+	s.mu.Lock()
+	e.status.Running = false
+	e.status.LastRunAt = &ranAt
+	e.status.LastStatus = status
+	e.status.LastError = msg
+	e.status.LastDurationMs = duration
+	e.status.LastTrigger = trigger
+	s.mu.Unlock()
+
+	_, dbErr := s.db.Exec(`
+		INSERT INTO scheduled_tasks (name, last_run_at, last_status, last_error, last_duration_ms, last_trigger)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET last_run_at = excluded.last_run_at, last_status = excluded.last_status,
+			last_error = excluded.last_error, last_duration_ms = excluded.last_duration_ms,
+			last_trigger = excluded.last_trigger
+	`, e.task.Name, ranAt, status, msg, duration, trigger)
+	if dbErr != nil {
+		log.Error().Err(dbErr).Str("task", e.task.Name).Msg("Failed to record scheduled task run")
+	}
+}
+
+// List returns every task in registration order
+func (s *Scheduler) List() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Status, 0, len(s.entries))
+	for _, e := range s.entries {
+		list = append(list, e.snapshot())
+	}
+	return list
+}
+
+// Get returns one task
+func (s *Scheduler) Get(name string) (Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.byName[name]
+	if e == nil {
+		return Status{}, ErrUnknown
+	}
+	return e.snapshot(), nil
+}
+
+// snapshot copies e's status with its next run. Call with mu held.
+func (e *entry) snapshot() Status {
+	st := e.status
+	if st.Enabled && !e.next.IsZero() {
+		next := e.next
+		st.NextRunAt = &next
+	}
+	return st
+}
+
+// Update changes a task's settings, recording who did it. An unacceptable
+// setting is returned as an *InvalidSettingError.
+func (s *Scheduler) Update(name string, set Settings, by string) (Status, error) {
+	s.mu.Lock()
+	e := s.byName[name]
+	s.mu.Unlock()
+	if e == nil {
+		return Status{}, ErrUnknown
+	}
+
+	sched := e.schedule
+	if set.Schedule != nil {
+		expr := *set.Schedule
+		if expr == "" {
+			expr = e.task.Schedule
+		}
+		var err error
+		if sched, err = Parse(expr); err != nil {
+			return Status{}, &InvalidSettingError{Field: "schedule", Err: err}
+		}
+		if sched.Next(time.Now()).IsZero() {
+			return Status{}, &InvalidSettingError{Field: "schedule", Err: fmt.Errorf("%q never matches", expr)}
+		}
+	}
+	if set.JitterSeconds != nil {
+		if j := time.Duration(*set.JitterSeconds) * time.Second; j < 0 || j > MaxJitter {
+			return Status{}, &InvalidSettingError{Field: "jitterSeconds",
+				Err: fmt.Errorf("must be between 0 and %d seconds", int(MaxJitter/time.Second))}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	enabled, jitter := e.status.Enabled, e.status.JitterSeconds
+	if set.Enabled != nil {
+		enabled = *set.Enabled
+	}
+	if set.JitterSeconds != nil {
+		jitter = *set.JitterSeconds
+	}
+	var saved interface{}
+	if sched.String() != e.task.Schedule {
+		saved = sched.String()
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO scheduled_tasks (name, schedule, enabled, jitter_seconds, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(name) DO UPDATE SET schedule = excluded.schedule, enabled = excluded.enabled,
+			jitter_seconds = excluded.jitter_seconds, updated_by = excluded.updated_by, updated_at = CURRENT_TIMESTAMP
+	`, name, saved, enabled, jitter, by)
+	if err != nil {
+		return Status{}, err
+	}
+
+	now := time.Now().UTC()
+	e.schedule = sched
+	e.status.Schedule = sched.String()
+	e.status.Enabled = enabled
+	e.status.JitterSeconds = jitter
+	e.status.UpdatedBy = by
+	e.status.UpdatedAt = &now
+	e.next = sched.Next(time.Now())
+	s.poke()
+	return e.snapshot(), nil
+}
+
+// Trigger runs a task now, whether or not it is enabled. by names who
+// asked, for the task's last run.
+func (s *Scheduler) Trigger(name, by string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.byName[name]
+	if e == nil {
+		return ErrUnknown
+	}
+	if e.status.Running {
+		return ErrRunning
+	}
+	s.start(e, by, 0)
+	return nil
+}
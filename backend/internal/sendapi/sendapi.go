@@ -0,0 +1,78 @@
+// Package sendapi backs the REST send API: it fills in message templates,
+// follows each sent message through the mail log to record per-recipient
+// delivery, and summarises sending per API token.
+package sendapi
+
+import (
+	"html"
+	"regexp"
+	"sort"
+)
+
+// templateVariable matches {{name}} placeholders
+var templateVariable = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// Render replaces {{name}} placeholders with vars. Values are HTML-escaped
+// when escapeHTML is set. It returns the names that had no value, sorted;
+// their placeholders are left as they are.
+func Render(text string, vars map[string]string, escapeHTML bool) (string, []string) {
+	missing := map[string]bool{}
+	out := templateVariable.ReplaceAllStringFunc(text, func(m string) string {
+		name := templateVariable.FindStringSubmatch(m)[1]
+		value, ok := vars[name]
+		if !ok {
+			missing[name] = true
+			return m
+		}
+		if escapeHTML {
+			return html.EscapeString(value)
+		}
+		return value
+	})
+
+	names := make([]string, 0, len(missing))
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return out, names
+}
+
+// RenderMessage renders a message's subject, text and HTML parts with
+// Render, escaping values only in the HTML. It returns the names that had
+// no value in any part.
+func RenderMessage(subject, text, htmlBody string, vars map[string]string) (string, string, string, []string) {
+	subject, missingSubject := Render(subject, vars, false)
+	text, missingText := Render(text, vars, false)
+	htmlBody, missingHTML := Render(htmlBody, vars, true)
+
+	seen := map[string]bool{}
+	missing := []string{}
+	for _, names := range [][]string{missingSubject, missingText, missingHTML} {
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				missing = append(missing, name)
+			}
+		}
+	}
+	sort.Strings(missing)
+	return subject, text, htmlBody, missing
+}
+
+// Variables returns the placeholder names used in texts, sorted and
+// without duplicates
+func Variables(texts ...string) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, text := range texts {
+		for _, m := range templateVariable.FindAllStringSubmatch(text, -1) {
+			if !seen[m[1]] {
+				seen[m[1]] = true
+				names = append(names, m[1])
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
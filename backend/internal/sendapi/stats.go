@@ -0,0 +1,72 @@
+package sendapi
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Stats summarises the messages sent by an API token, or with a template,
+// since a point in time. Recipient counts are by their latest delivery
+// status.
+type Stats struct {
+	Since      time.Time `json:"since"`
+	Messages   int       `json:"messages"`
+	Failed     int       `json:"failed"` // refused before Postfix queued them
+	Recipients int       `json:"recipients"`
+	Pending    int       `json:"pending"`
+	Delivered  int       `json:"delivered"`
+	Deferred   int       `json:"deferred"`
+	Bounced    int       `json:"bounced"`
+}
+
+// TokenStats returns the sending statistics of a token
+func TokenStats(db *sql.DB, tokenID int64, since time.Time) (Stats, error) {
+	return sum(db, "token_id", tokenID, since)
+}
+
+// TemplateStats returns the sending statistics of a template
+func TemplateStats(db *sql.DB, templateID int64, since time.Time) (Stats, error) {
+	return sum(db, "template_id", templateID, since)
+}
+
+// sum counts the messages whose column (token_id or template_id) is id
+func sum(db *sql.DB, column string, id int64, since time.Time) (Stats, error) {
+	stats := Stats{Since: since}
+
+	err := db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(status = 'failed'), 0), COALESCE(SUM(recipient_count), 0)
+		FROM api_send_messages WHERE `+column+` = ? AND created_at >= ?
+	`, id, since.UTC()).Scan(&stats.Messages, &stats.Failed, &stats.Recipients)
+	if err != nil {
+		return stats, err
+	}
+
+	rows, err := db.Query(`
+		SELECT r.status, COUNT(*) FROM api_send_recipients r
+		JOIN api_send_messages m ON m.id = r.message_id
+		WHERE m.`+column+` = ? AND m.created_at >= ?
+		GROUP BY r.status
+	`, id, since.UTC())
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var n int
+		if rows.Scan(&status, &n) != nil {
+			continue
+		}
+		switch status {
+		case "pending":
+			stats.Pending += n
+		case "sent":
+			stats.Delivered += n
+		case "deferred":
+			stats.Deferred += n
+		case "bounced", "expired":
+			stats.Bounced += n
+		}
+	}
+	return stats, nil
+}
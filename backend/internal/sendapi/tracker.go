@@ -0,0 +1,133 @@
+package sendapi
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/rs/zerolog/log"
+)
+
+// trackFor is how long a sent message is followed in the mail log. Later
+// retries of deferred recipients go unrecorded.
+const trackFor = 5 * 24 * time.Hour
+
+// messageIDField matches cleanup's message-id=<...> log line
+var messageIDField = regexp.MustCompile(`message-id=(<[^>]*>)`)
+
+// Tracker follows messages sent through the API in the mail log. cleanup
+// logs the Message-ID with the queue ID Postfix gave the message, and
+// delivery lines for that queue ID update the recipients' status. A content
+// filter re-queues the message under a new queue ID with the same
+// Message-ID, so one message can have several.
+type Tracker struct {
+	db *sql.DB
+
+	mu       sync.Mutex
+	messages map[string]trackedMessage // by Message-ID
+	queues   map[string]int64          // queue ID to api_send_messages.id
+}
+
+type trackedMessage struct {
+	id     int64
+	sentAt time.Time
+}
+
+// NewTracker creates a tracker following the messages sent in the last
+// trackFor, so tracking survives a restart
+func NewTracker(db *sql.DB) *Tracker {
+	t := &Tracker{
+		db:       db,
+		messages: map[string]trackedMessage{},
+		queues:   map[string]int64{},
+	}
+
+	rows, err := db.Query(`
+		SELECT id, message_id, COALESCE(queue_id, ''), created_at FROM api_send_messages
+		WHERE status = 'accepted' AND created_at > ?
+	`, time.Now().Add(-trackFor).UTC())
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load sent messages to track")
+		return t
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m trackedMessage
+		var messageID, queueID string
+		if rows.Scan(&m.id, &messageID, &queueID, &m.sentAt) != nil {
+			continue
+		}
+		t.messages[messageID] = m
+		if queueID != "" {
+			t.queues[queueID] = m.id
+		}
+	}
+	return t
+}
+
+// Track starts following a message the API handed to Postfix
+func (t *Tracker) Track(id int64, messageID string) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages[messageID] = trackedMessage{id: id, sentAt: now}
+
+	// Drop messages no longer followed
+	for mid, m := range t.messages {
+		if now.Sub(m.sentAt) > trackFor {
+			delete(t.messages, mid)
+			for q, qid := range t.queues {
+				if qid == m.id {
+					delete(t.queues, q)
+				}
+			}
+		}
+	}
+}
+
+// Consume records queue IDs and delivery results of tracked messages
+func (t *Tracker) Consume(e logs.Entry) {
+	if e.QueueID == "" {
+		return
+	}
+
+	if m := messageIDField.FindStringSubmatch(e.Message); m != nil {
+		t.mu.Lock()
+		tracked, ok := t.messages[m[1]]
+		if ok {
+			t.queues[e.QueueID] = tracked.id
+		}
+		t.mu.Unlock()
+		if ok {
+			t.db.Exec(`UPDATE api_send_messages SET queue_id = ? WHERE id = ? AND queue_id IS NULL`, e.QueueID, tracked.id)
+		}
+		return
+	}
+
+	switch e.Status {
+	case "sent", "deferred", "bounced", "expired":
+	default:
+		return
+	}
+	t.mu.Lock()
+	id, ok := t.queues[e.QueueID]
+	t.mu.Unlock()
+	if !ok || e.MailTo == "" {
+		return
+	}
+
+	recipient := strings.ToLower(strings.Trim(e.MailTo, "<>"))
+	if _, err := t.db.Exec(`
+		INSERT INTO api_send_recipients (message_id, recipient, status, dsn, relay, detail, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (message_id, recipient) DO UPDATE SET
+			status = excluded.status, dsn = excluded.dsn, relay = excluded.relay,
+			detail = excluded.detail, updated_at = excluded.updated_at
+	`, id, recipient, e.Status, e.DSN, e.Relay, e.Message, e.Timestamp.UTC()); err != nil {
+		log.Warn().Err(err).Str("queueId", e.QueueID).Msg("Failed to record API send delivery")
+	}
+}
@@ -0,0 +1,171 @@
+// Package services starts, stops and restarts Postfix and Dovecot through
+// the sudo wrapper script, and tracks each service's state while an action
+// is in progress.
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// safeServiceScript validates the service and action before running them
+// as root
+const safeServiceScript = "/opt/postfixrelay/scripts/safe-service.sh"
+
+// actionTimeout bounds how long a start, stop or restart may take
+const actionTimeout = 2 * time.Minute
+
+// Names are the services that can be controlled
+var Names = []string{"postfix", "dovecot"}
+
+// Actions
+const (
+	ActionStart   = "start"
+	ActionStop    = "stop"
+	ActionRestart = "restart"
+)
+
+// Valid reports whether name is a service that can be controlled
+func Valid(name string) bool {
+	for _, n := range Names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidAction reports whether action is start, stop or restart
+func ValidAction(action string) bool {
+	return action == ActionStart || action == ActionStop || action == ActionRestart
+}
+
+// Status is a service's current state. State is running or stopped, or
+// starting, stopping or restarting while an action is in progress.
+type Status struct {
+	Name           string     `json:"name"`
+	Running        bool       `json:"running"`
+	State          string     `json:"state"`
+	LastAction     string     `json:"lastAction,omitempty"`
+	LastActionBy   string     `json:"lastActionBy,omitempty"`
+	LastActionAt   *time.Time `json:"lastActionAt,omitempty"`
+	LastActionDone *time.Time `json:"lastActionDone,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+}
+
+// run calls the wrapper script for one service and action
+func run(ctx context.Context, name, action string) error {
+	cmd := exec.CommandContext(ctx, "sudo", safeServiceScript, name, action)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(out.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("%s %s failed: %s", action, name, msg)
+	}
+	return nil
+}
+
+// Running reports whether a service is up
+func Running(name string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return run(ctx, name, "status") == nil
+}
+
+// progress is the state shown while an action runs
+var progress = map[string]string{
+	ActionStart:   "starting",
+	ActionStop:    "stopping",
+	ActionRestart: "restarting",
+}
+
+// Controller runs one action per service at a time in the background, so
+// the caller can poll Status until it finishes
+type Controller struct {
+	mu    sync.Mutex
+	state map[string]*Status
+}
+
+// NewController creates a service controller
+func NewController() *Controller {
+	c := &Controller{state: map[string]*Status{}}
+	for _, name := range Names {
+		c.state[name] = &Status{Name: name}
+	}
+	return c
+}
+
+// ErrBusy is returned when a service already has an action in progress
+var ErrBusy = errors.New("an action is already in progress for this service")
+
+// Begin starts an action on a service and returns without waiting for it.
+// done, if set, is called with the outcome once the action finishes.
+func (c *Controller) Begin(name, action, by string, done func(error)) error {
+	if !Valid(name) {
+		return fmt.Errorf("unknown service: %s", name)
+	}
+	if !ValidAction(action) {
+		return fmt.Errorf("unknown action: %s", action)
+	}
+
+	c.mu.Lock()
+	st := c.state[name]
+	if st.State != "" {
+		c.mu.Unlock()
+		return ErrBusy
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	st.State = progress[action]
+	st.LastAction = action
+	st.LastActionBy = by
+	st.LastActionAt = &now
+	st.LastActionDone = nil
+	st.LastError = ""
+	c.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), actionTimeout)
+		err := run(ctx, name, action)
+		cancel()
+
+		finished := time.Now().UTC().Truncate(time.Second)
+		c.mu.Lock()
+		st.State = ""
+		st.LastActionDone = &finished
+		if err != nil {
+			st.LastError = err.Error()
+		}
+		c.mu.Unlock()
+
+		if done != nil {
+			done(err)
+		}
+	}()
+	return nil
+}
+
+// Status returns a service's state as it is now
+func (c *Controller) Status(name string) Status {
+	c.mu.Lock()
+	st := *c.state[name]
+	c.mu.Unlock()
+
+	st.Running = Running(name)
+	if st.State == "" {
+		st.State = "stopped"
+		if st.Running {
+			st.State = "running"
+		}
+	}
+	return st
+}
@@ -0,0 +1,228 @@
+// Package smtpserver is a minimal SMTP server, speaking just enough SMTP
+// for Postfix's smtp client and the mail libraries of applications. It
+// parses the envelope and the DATA section and leaves recipient checks and
+// storage to callbacks; the archive receiver and the staging sink are
+// built on it.
+package smtpserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionTimeout is how long a client may stay idle between commands
+const sessionTimeout = 5 * time.Minute
+
+// Options configure a server
+type Options struct {
+	// Banner follows "220 <hostname> ESMTP " in the greeting
+	Banner string
+	// MaxMessageSize is advertised in the EHLO reply; larger messages
+	// are rejected
+	MaxMessageSize int
+	// MaxRecipients limits RCPT commands per message; 0 is no limit
+	MaxRecipients int
+	// AcceptRecipient reports whether mail for addr is taken. Nil accepts
+	// every recipient.
+	AcceptRecipient func(addr string) bool
+	// Save stores an accepted message and returns the text that follows
+	// "250 2.0.0 Ok: " in the reply. On error the client is told to try
+	// again later.
+	Save func(remoteAddr, from string, rcpts []string, msg []byte) (string, error)
+}
+
+// Server accepts SMTP connections and hands each message to Options.Save
+type Server struct {
+	listen string
+	opts   Options
+
+	ln net.Listener
+	wg sync.WaitGroup
+}
+
+// New creates a server listening on listen (host:port)
+func New(listen string, opts Options) *Server {
+	return &Server{listen: listen, opts: opts}
+}
+
+// Start opens the listener and begins accepting connections
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return err
+	}
+	s.ln = ln
+
+	s.wg.Add(1)
+	go s.serve()
+	return nil
+}
+
+// Addr is the address the server listens on, nil before Start
+func (s *Server) Addr() net.Addr {
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// Stop closes the listener and waits for open sessions to finish
+func (s *Server) Stop() {
+	if s.ln == nil {
+		return
+	}
+	s.ln.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return // listener closed
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.session(conn)
+		}()
+	}
+}
+
+func (s *Server) session(conn net.Conn) {
+	defer conn.Close()
+	hostname, _ := os.Hostname()
+	rd := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	reply := func(line string) {
+		w.WriteString(line + "\r\n")
+		w.Flush()
+	}
+
+	conn.SetDeadline(time.Now().Add(sessionTimeout))
+	reply("220 " + hostname + " ESMTP " + s.opts.Banner)
+
+	var from string
+	var rcpts []string
+	var mailStarted bool
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return
+		}
+		conn.SetDeadline(time.Now().Add(sessionTimeout))
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(line)
+		if i := strings.IndexByte(verb, ' '); i >= 0 {
+			verb = verb[:i]
+		}
+
+		switch verb {
+		case "EHLO":
+			reply("250-" + hostname)
+			reply("250-8BITMIME")
+			reply("250-SMTPUTF8")
+			reply(fmt.Sprintf("250 SIZE %d", s.opts.MaxMessageSize))
+		case "HELO":
+			reply("250 " + hostname)
+		case "MAIL":
+			from, rcpts, mailStarted = pathArg(line), nil, true
+			reply("250 2.1.0 Ok")
+		case "RCPT":
+			if !mailStarted {
+				reply("503 5.5.1 Need MAIL command")
+				continue
+			}
+			if s.opts.MaxRecipients > 0 && len(rcpts) >= s.opts.MaxRecipients {
+				reply("452 4.5.3 Too many recipients")
+				continue
+			}
+			addr := pathArg(line)
+			if s.opts.AcceptRecipient != nil && !s.opts.AcceptRecipient(addr) {
+				reply("550 5.1.1 Recipient not accepted")
+				continue
+			}
+			rcpts = append(rcpts, addr)
+			reply("250 2.1.5 Ok")
+		case "DATA":
+			if len(rcpts) == 0 {
+				reply("503 5.5.1 No valid recipients")
+				continue
+			}
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			msg, err := readData(rd, s.opts.MaxMessageSize)
+			if err != nil {
+				if err == errTooLarge {
+					reply("552 5.3.4 Message too big")
+					continue
+				}
+				return
+			}
+			if result, err := s.opts.Save(conn.RemoteAddr().String(), from, rcpts, msg); err != nil {
+				reply("451 4.3.0 Storage unavailable")
+			} else {
+				reply("250 2.0.0 Ok: " + result)
+			}
+			from, rcpts, mailStarted = "", nil, false
+		case "RSET":
+			from, rcpts, mailStarted = "", nil, false
+			reply("250 2.0.0 Ok")
+		case "NOOP":
+			reply("250 2.0.0 Ok")
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+// pathArg returns the address in a MAIL FROM:<...> or RCPT TO:<...> line
+func pathArg(line string) string {
+	start := strings.IndexByte(line, '<')
+	end := strings.IndexByte(line, '>')
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
+
+// errTooLarge is returned by readData for a message over the size limit
+var errTooLarge = errors.New("message too large")
+
+// readData reads a DATA section up to the terminating dot, undoing dot
+// stuffing. A message over maxSize is read to the end and rejected.
+func readData(rd *bufio.Reader, maxSize int) ([]byte, error) {
+	var buf bytes.Buffer
+	tooLarge := false
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if line == ".\r\n" || line == ".\n" {
+			break
+		}
+		if strings.HasPrefix(line, ".") {
+			line = line[1:]
+		}
+		if buf.Len()+len(line) > maxSize {
+			tooLarge = true
+			continue
+		}
+		buf.WriteString(line)
+	}
+	if tooLarge {
+		return nil, errTooLarge
+	}
+	return buf.Bytes(), nil
+}
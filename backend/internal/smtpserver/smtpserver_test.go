@@ -0,0 +1,172 @@
+package smtpserver
+
+import (
+	"bufio"
+	"errors"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type saved struct {
+	from  string
+	rcpts []string
+	msg   string
+}
+
+// startServer runs a server on a loopback port that accepts recipients at
+// example.com and records what it saves
+func startServer(t *testing.T, maxSize, maxRcpts int, saveErr error) (string, func() []saved) {
+	t.Helper()
+	var mu sync.Mutex
+	var messages []saved
+	srv := New("127.0.0.1:0", Options{
+		Banner:         "test",
+		MaxMessageSize: maxSize,
+		MaxRecipients:  maxRcpts,
+		AcceptRecipient: func(addr string) bool {
+			return strings.HasSuffix(addr, "@example.com")
+		},
+		Save: func(_, from string, rcpts []string, msg []byte) (string, error) {
+			if saveErr != nil {
+				return "", saveErr
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			messages = append(messages, saved{from, rcpts, string(msg)})
+			return "saved", nil
+		},
+	})
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Stop)
+	return srv.Addr().String(), func() []saved {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]saved(nil), messages...)
+	}
+}
+
+func TestServerSendMail(t *testing.T) {
+	addr, messages := startServer(t, 1<<20, 0, nil)
+
+	body := "Subject: test\r\n\r\n.leading dot\r\n..two dots\r\n.\r\nend\r\n"
+	if err := smtp.SendMail(addr, nil, "sender@example.org", []string{"a@example.com", "b@example.com"}, []byte(body)); err != nil {
+		t.Fatal(err)
+	}
+	got := messages()
+	if len(got) != 1 {
+		t.Fatalf("saved %d messages, want 1", len(got))
+	}
+	if got[0].from != "sender@example.org" || strings.Join(got[0].rcpts, ",") != "a@example.com,b@example.com" {
+		t.Errorf("envelope = %+v", got[0])
+	}
+	if got[0].msg != body {
+		t.Errorf("message = %q, want %q", got[0].msg, body)
+	}
+}
+
+// dialog sends each command and returns the reply codes
+func dialog(t *testing.T, addr string, commands ...string) []int {
+	t.Helper()
+	conn, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, _, err := conn.ReadResponse(220); err != nil {
+		t.Fatal(err)
+	}
+	var codes []int
+	for _, cmd := range commands {
+		if err := conn.PrintfLine("%s", cmd); err != nil {
+			t.Fatal(err)
+		}
+		code, _, _ := conn.ReadResponse(0)
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+func TestServerEnvelope(t *testing.T) {
+	addr, messages := startServer(t, 1<<20, 2, nil)
+
+	tests := []struct {
+		name     string
+		commands []string
+		want     []int
+	}{
+		{"rcpt before mail", []string{"EHLO client", "RCPT TO:<a@example.com>"}, []int{250, 503}},
+		{"recipient refused", []string{"HELO client", "MAIL FROM:<s@example.org>", "RCPT TO:<a@example.net>", "DATA"}, []int{250, 250, 550, 503}},
+		{"too many recipients", []string{"HELO client", "MAIL FROM:<s@example.org>",
+			"RCPT TO:<a@example.com>", "RCPT TO:<b@example.com>", "RCPT TO:<c@example.com>"}, []int{250, 250, 250, 250, 452}},
+		{"rset", []string{"HELO client", "MAIL FROM:<s@example.org>", "RCPT TO:<a@example.com>", "RSET", "DATA"}, []int{250, 250, 250, 250, 503}},
+		{"unknown", []string{"VRFY root", "NOOP", "QUIT"}, []int{502, 250, 221}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := dialog(t, addr, tt.commands...)
+			if len(got) != len(tt.want) {
+				t.Fatalf("codes = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("%s: %d, want %d", tt.commands[i], got[i], tt.want[i])
+				}
+			}
+		})
+	}
+	if n := len(messages()); n != 0 {
+		t.Errorf("saved %d messages, want 0", n)
+	}
+}
+
+func TestServerTooLarge(t *testing.T) {
+	addr, messages := startServer(t, 64, 0, nil)
+
+	err := smtp.SendMail(addr, nil, "s@example.org", []string{"a@example.com"}, []byte(strings.Repeat("x", 100)+"\r\n"))
+	if err == nil || !strings.Contains(err.Error(), "552") {
+		t.Errorf("oversized message: err = %v, want 552", err)
+	}
+	// The session carries on after the rejected message
+	if err := smtp.SendMail(addr, nil, "s@example.org", []string{"a@example.com"}, []byte("small\r\n")); err != nil {
+		t.Errorf("small message: %v", err)
+	}
+	if n := len(messages()); n != 1 {
+		t.Errorf("saved %d messages, want 1", n)
+	}
+}
+
+func TestServerSaveFails(t *testing.T) {
+	addr, _ := startServer(t, 1<<20, 0, errors.New("disk full"))
+
+	err := smtp.SendMail(addr, nil, "s@example.org", []string{"a@example.com"}, []byte("hello\r\n"))
+	var tpErr *textproto.Error
+	if !errors.As(err, &tpErr) || tpErr.Code != 451 {
+		t.Errorf("err = %v, want 451", err)
+	}
+}
+
+func TestReadData(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      error
+	}{
+		{"line\r\n.\r\n", "line\r\n", nil},
+		{"..dot\r\n.\r\ntrailing", ".dot\r\n", nil},
+		{"bare lf\n.\n", "bare lf\n", nil},
+		{"12345678\r\n.\r\n", "", errTooLarge},
+	}
+	for _, tt := range tests {
+		got, err := readData(bufio.NewReader(strings.NewReader(tt.in)), 8)
+		if err != tt.err || string(got) != tt.want {
+			t.Errorf("readData(%q) = %q, %v; want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+	if _, err := readData(bufio.NewReader(strings.NewReader("no end\r\n")), 8); err == nil {
+		t.Error("readData without the final dot: no error")
+	}
+}
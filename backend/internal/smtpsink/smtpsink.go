@@ -0,0 +1,212 @@
+// Package smtpsink is an SMTP server for staging environments that accepts
+// every message and stores it instead of delivering it, so applications
+// under test can't reach real recipients. Captured messages are kept in
+// sink_messages, newest sink_max_messages only.
+package smtpsink
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/smtpserver"
+	"github.com/rs/zerolog/log"
+)
+
+// Sink limits
+const (
+	maxMessageSize = 25 << 20
+	maxRecipients  = 1000
+)
+
+// DefaultListen is where the sink listens unless sink_listen says otherwise
+const DefaultListen = "127.0.0.1:2525"
+
+// DefaultMaxMessages is how many captured messages are kept by default
+const DefaultMaxMessages = 1000
+
+// Message is a captured message without its content
+type Message struct {
+	ID         int64     `json:"id"`
+	ReceivedAt time.Time `json:"receivedAt"`
+	RemoteAddr string    `json:"remoteAddr,omitempty"`
+	From       string    `json:"from"`
+	To         []string  `json:"to"`
+	Subject    string    `json:"subject,omitempty"`
+	MessageID  string    `json:"messageId,omitempty"`
+	Size       int64     `json:"size"`
+}
+
+// Sink is a minimal SMTP server that stores every message it accepts
+type Sink struct {
+	db          *sql.DB
+	maxMessages int
+	srv         *smtpserver.Server
+}
+
+// New creates a sink listening on listen (host:port) that keeps at most
+// maxMessages messages
+func New(listen string, db *sql.DB, maxMessages int) *Sink {
+	if maxMessages < 1 {
+		maxMessages = DefaultMaxMessages
+	}
+	s := &Sink{db: db, maxMessages: maxMessages}
+	// Any sender and recipient is accepted; nothing is relayed
+	s.srv = smtpserver.New(listen, smtpserver.Options{
+		Banner:         "sink (mail is captured, not delivered)",
+		MaxMessageSize: maxMessageSize,
+		MaxRecipients:  maxRecipients,
+		Save:           s.save,
+	})
+	return s
+}
+
+// Start opens the listener and begins accepting connections
+func (s *Sink) Start() error {
+	if err := s.srv.Start(); err != nil {
+		return fmt.Errorf("failed to listen for the SMTP sink: %w", err)
+	}
+	log.Info().Str("listen", s.srv.Addr().String()).Msg("SMTP sink started; mail is captured, not delivered")
+	return nil
+}
+
+// Addr is the address the sink listens on, nil before Start
+func (s *Sink) Addr() net.Addr {
+	return s.srv.Addr()
+}
+
+// Stop closes the listener and waits for open sessions to finish
+func (s *Sink) Stop() {
+	if s.srv.Addr() == nil {
+		return
+	}
+	s.srv.Stop()
+	log.Info().Msg("SMTP sink stopped")
+}
+
+// save stores a message and drops the oldest beyond the limit
+func (s *Sink) save(remoteAddr, from string, rcpts []string, raw []byte) (string, error) {
+	var subject, messageID string
+	if msg, err := mail.ReadMessage(bytes.NewReader(raw)); err == nil {
+		subject = decodeHeader(msg.Header.Get("Subject"))
+		messageID = strings.Trim(msg.Header.Get("Message-Id"), "<> ")
+	}
+
+	res, err := s.db.Exec(`
+		INSERT INTO sink_messages (received_at, remote_addr, mail_from, rcpt_to, subject, message_id, size, raw)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, time.Now().UTC().Format(time.RFC3339), remoteAddr, from, strings.Join(rcpts, ","), subject, messageID, len(raw), raw)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to store captured message")
+		return "", err
+	}
+	id, _ := res.LastInsertId()
+
+	s.db.Exec(`
+		DELETE FROM sink_messages WHERE id <= (
+			SELECT id FROM sink_messages ORDER BY id DESC LIMIT 1 OFFSET ?
+		)
+	`, s.maxMessages)
+
+	log.Debug().Int64("id", id).Str("from", from).Strs("to", rcpts).Msg("Captured message in SMTP sink")
+	return fmt.Sprintf("captured as %d", id), nil
+}
+
+// decodeHeader decodes RFC 2047 encoded words
+func decodeHeader(s string) string {
+	dec := new(mime.WordDecoder)
+	if decoded, err := dec.DecodeHeader(s); err == nil {
+		return decoded
+	}
+	return s
+}
+
+// List returns captured messages, newest first. query matches the sender,
+// recipients and subject.
+func List(db *sql.DB, query string, limit, offset int) ([]Message, int, error) {
+	where, args := "", []interface{}{}
+	if query = strings.TrimSpace(query); query != "" {
+		like := "%" + query + "%"
+		where = ` WHERE mail_from LIKE ? OR rcpt_to LIKE ? OR subject LIKE ?`
+		args = append(args, like, like, like)
+	}
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sink_messages`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, received_at, COALESCE(remote_addr, ''), mail_from, rcpt_to, COALESCE(subject, ''),
+			COALESCE(message_id, ''), size
+		FROM sink_messages`+where+` ORDER BY id DESC LIMIT ? OFFSET ?
+	`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, total, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMessage(row scanner) (Message, error) {
+	var m Message
+	var receivedAt, to string
+	if err := row.Scan(&m.ID, &receivedAt, &m.RemoteAddr, &m.From, &to, &m.Subject, &m.MessageID, &m.Size); err != nil {
+		return m, err
+	}
+	m.ReceivedAt, _ = time.Parse(time.RFC3339, receivedAt)
+	m.To = strings.Split(to, ",")
+	return m, nil
+}
+
+// Get returns a captured message and its raw content
+func Get(db *sql.DB, id int64) (*Message, []byte, error) {
+	row := db.QueryRow(`
+		SELECT id, received_at, COALESCE(remote_addr, ''), mail_from, rcpt_to, COALESCE(subject, ''),
+			COALESCE(message_id, ''), size
+		FROM sink_messages WHERE id = ?
+	`, id)
+	m, err := scanMessage(row)
+	if err != nil {
+		return nil, nil, err
+	}
+	var raw []byte
+	if err := db.QueryRow(`SELECT raw FROM sink_messages WHERE id = ?`, id).Scan(&raw); err != nil {
+		return nil, nil, err
+	}
+	return &m, raw, nil
+}
+
+// Delete removes one captured message, or every one when id is 0. It
+// returns how many were removed.
+func Delete(db *sql.DB, id int64) (int64, error) {
+	var res sql.Result
+	var err error
+	if id == 0 {
+		res, err = db.Exec(`DELETE FROM sink_messages`)
+	} else {
+		res, err = db.Exec(`DELETE FROM sink_messages WHERE id = ?`, id)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,43 @@
+//go:build integration
+
+package smtpsink_test
+
+import (
+	"net/smtp"
+	"testing"
+
+	"github.com/postfixrelay/postfixrelay/internal/smtpsink"
+	"github.com/postfixrelay/postfixrelay/internal/testharness"
+)
+
+func TestSinkCapturesAndTrims(t *testing.T) {
+	db := testharness.NewDB(t)
+	sink := smtpsink.New("127.0.0.1:0", db.DB, 2)
+	if err := sink.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sink.Stop)
+
+	for _, subject := range []string{"first", "second", "=?UTF-8?B?dGhpcmQ=?="} {
+		msg := "From: app@example.com\r\nSubject: " + subject + "\r\nMessage-ID: <" + subject + "@example.com>\r\n\r\nhello\r\n"
+		if err := smtp.SendMail(sink.Addr().String(), nil, "app@example.com", []string{"user@example.net", "other@example.org"}, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages, total, err := smtpsink.List(db.DB, "", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(messages) != 2 {
+		t.Fatalf("kept %d messages, want the newest 2", total)
+	}
+	newest := messages[0]
+	if newest.Subject != "third" || newest.From != "app@example.com" || len(newest.To) != 2 {
+		t.Errorf("newest = %+v", newest)
+	}
+	_, raw, err := smtpsink.Get(db.DB, newest.ID)
+	if err != nil || string(raw) == "" {
+		t.Errorf("Get(%d) = %q, %v", newest.ID, raw, err)
+	}
+}
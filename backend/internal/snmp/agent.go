@@ -0,0 +1,395 @@
+// Package snmp is a minimal read-only SNMPv1/v2c agent exposing queue
+// sizes, service status and delivery counters under a private MIB (see
+// docs/PSFXSUITE-MIB.txt).
+package snmp
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultBaseOID is where the private MIB is rooted unless snmp_base_oid
+// says otherwise. 99999 is not an assigned enterprise number; sites with
+// their own can move the tree under it.
+const DefaultBaseOID = "1.3.6.1.4.1.99999.1"
+
+// snapshotTTL bounds how often a walk re-reads the queue and alerts
+const snapshotTTL = 10 * time.Second
+
+// maxBulkVarbinds caps the variables in one GetBulk response
+const maxBulkVarbinds = 64
+
+// SNMP error statuses
+const (
+	errNoError     = 0
+	errNoSuchName  = 2
+	errGenErr      = 5
+	errNotWritable = 17
+)
+
+// SNMP versions as encoded on the wire
+const (
+	versionV1  = 0
+	versionV2c = 1
+)
+
+var systemOID = OID{1, 3, 6, 1, 2, 1, 1}
+
+// Snapshot is the data the MIB is served from
+type Snapshot struct {
+	Hostname       string
+	PostfixRunning bool
+	PostfixVersion string
+	ActiveAlerts   int
+	CriticalAlerts int
+	QueueActive    int
+	QueueDeferred  int
+	QueueHold      int
+	QueueCorrupt   int
+	Sent           uint32
+	Bounced        uint32
+	Deferred       uint32
+}
+
+// SnapshotFunc collects the current values
+type SnapshotFunc func() Snapshot
+
+// Config configures the agent
+type Config struct {
+	Listen    string // UDP address, e.g. ":1161"
+	Community string // Read community; requests with any other are ignored
+	BaseOID   OID
+}
+
+// DeliveryCounters count delivery attempts by status from the mail log.
+// They wrap at 2^32 like any Counter32.
+type DeliveryCounters struct {
+	sent, bounced, deferred atomic.Uint32
+}
+
+// Consume counts one log entry if it records a delivery attempt
+func (c *DeliveryCounters) Consume(e logs.Entry) {
+	switch e.Status {
+	case "sent":
+		c.sent.Add(1)
+	case "bounced":
+		c.bounced.Add(1)
+	case "deferred":
+		c.deferred.Add(1)
+	}
+}
+
+// Values returns the sent, bounced and deferred counts
+func (c *DeliveryCounters) Values() (sent, bounced, deferred uint32) {
+	return c.sent.Load(), c.bounced.Load(), c.deferred.Load()
+}
+
+// variable is one MIB object and its encoded value
+type variable struct {
+	oid   OID
+	value []byte
+}
+
+// Agent answers SNMP requests on a UDP socket
+type Agent struct {
+	cfg      Config
+	snapshot SnapshotFunc
+	started  time.Time
+	conn     *net.UDPConn
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	cached   []variable
+	cachedAt time.Time
+}
+
+// NewAgent creates an agent
+func NewAgent(cfg Config, snapshot SnapshotFunc) *Agent {
+	return &Agent{cfg: cfg, snapshot: snapshot}
+}
+
+// Start binds the UDP socket and begins answering requests
+func (a *Agent) Start() error {
+	if a.cfg.Community == "" {
+		return fmt.Errorf("no SNMP community configured")
+	}
+	addr, err := net.ResolveUDPAddr("udp", a.cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("invalid SNMP listen address: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for SNMP: %w", err)
+	}
+	a.conn = conn
+	a.started = time.Now()
+
+	a.wg.Add(1)
+	go a.serve()
+	log.Info().Str("listen", conn.LocalAddr().String()).Str("baseOid", a.cfg.BaseOID.String()).Msg("SNMP agent started")
+	return nil
+}
+
+// Stop closes the socket and waits for the agent to exit
+func (a *Agent) Stop() {
+	if a.conn == nil {
+		return
+	}
+	a.conn.Close()
+	a.wg.Wait()
+	log.Info().Msg("SNMP agent stopped")
+}
+
+func (a *Agent) serve() {
+	defer a.wg.Done()
+
+	buf := make([]byte, 65535)
+	for {
+		n, peer, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return // socket closed
+		}
+		if resp := a.handle(buf[:n]); resp != nil {
+			a.conn.WriteToUDP(resp, peer)
+		}
+	}
+}
+
+// handle decodes a request and returns the encoded response, or nil for
+// malformed requests and unknown communities, which are dropped
+func (a *Agent) handle(packet []byte) []byte {
+	msg, _, err := readTLV(packet)
+	if err != nil || msg.tag != tagSequence {
+		return nil
+	}
+	versionTLV, rest, err := readTLV(msg.value)
+	if err != nil {
+		return nil
+	}
+	version, err := decodeInt(versionTLV.value)
+	if err != nil || (version != versionV1 && version != versionV2c) {
+		return nil
+	}
+	community, rest, err := readTLV(rest)
+	if err != nil || community.tag != tagOctetString {
+		return nil
+	}
+	if subtle.ConstantTimeCompare(community.value, []byte(a.cfg.Community)) != 1 {
+		return nil
+	}
+	pdu, _, err := readTLV(rest)
+	if err != nil {
+		return nil
+	}
+
+	// request-id, error-status/non-repeaters, error-index/max-repetitions
+	var fields [3]int64
+	rest = pdu.value
+	for i := range fields {
+		var t tlv
+		if t, rest, err = readTLV(rest); err != nil {
+			return nil
+		}
+		if fields[i], err = decodeInt(t.value); err != nil {
+			return nil
+		}
+	}
+	varbindList, _, err := readTLV(rest)
+	if err != nil || varbindList.tag != tagSequence {
+		return nil
+	}
+	var oids []OID
+	var requested [][]byte // original varbinds, echoed back on v1 errors
+	for rest = varbindList.value; len(rest) > 0; {
+		var vb, name tlv
+		if vb, rest, err = readTLV(rest); err != nil || vb.tag != tagSequence {
+			return nil
+		}
+		if name, _, err = readTLV(vb.value); err != nil || name.tag != tagOID {
+			return nil
+		}
+		oid, err := decodeOID(name.value)
+		if err != nil {
+			return nil
+		}
+		oids = append(oids, oid)
+		requested = append(requested, encodeTLV(tagSequence, vb.value))
+	}
+
+	vars := a.variables()
+	var results [][]byte
+	errStatus, errIndex := errNoError, 0
+
+	switch pdu.tag {
+	case tagGetRequest:
+		for i, oid := range oids {
+			if v, ok := lookup(vars, oid); ok {
+				results = append(results, varbind(oid, v.value))
+			} else if version == versionV1 {
+				errStatus, errIndex = errNoSuchName, i+1
+				break
+			} else {
+				results = append(results, varbind(oid, encodeTLV(tagNoSuchObject, nil)))
+			}
+		}
+
+	case tagGetNextRequest:
+		for i, oid := range oids {
+			if v, ok := next(vars, oid); ok {
+				results = append(results, varbind(v.oid, v.value))
+			} else if version == versionV1 {
+				errStatus, errIndex = errNoSuchName, i+1
+				break
+			} else {
+				results = append(results, varbind(oid, encodeTLV(tagEndOfMibView, nil)))
+			}
+		}
+
+	case tagGetBulkRequest:
+		if version == versionV1 {
+			return nil
+		}
+		nonRepeaters, maxRepetitions := int(fields[1]), int(fields[2])
+		if nonRepeaters < 0 {
+			nonRepeaters = 0
+		}
+		for i, oid := range oids {
+			if i >= nonRepeaters {
+				break
+			}
+			results = append(results, nextVarbind(vars, oid))
+		}
+		if nonRepeaters < len(oids) {
+			cursors := append([]OID(nil), oids[nonRepeaters:]...)
+			for r := 0; r < maxRepetitions && len(results)+len(cursors) <= maxBulkVarbinds; r++ {
+				for i, oid := range cursors {
+					results = append(results, nextVarbind(vars, oid))
+					if v, ok := next(vars, oid); ok {
+						cursors[i] = v.oid
+					}
+				}
+			}
+		}
+
+	case tagSetRequest:
+		errIndex = 1
+		if version == versionV1 {
+			errStatus = errNoSuchName
+		} else {
+			errStatus = errNotWritable
+		}
+
+	default:
+		return nil
+	}
+
+	if errStatus != errNoError {
+		results = requested
+	}
+	return encodeSequence(tagSequence,
+		encodeInt(tagInteger, version),
+		encodeTLV(tagOctetString, community.value),
+		encodeSequence(tagResponse,
+			encodeInt(tagInteger, fields[0]),
+			encodeInt(tagInteger, int64(errStatus)),
+			encodeInt(tagInteger, int64(errIndex)),
+			encodeSequence(tagSequence, results...),
+		),
+	)
+}
+
+func varbind(oid OID, value []byte) []byte {
+	return encodeSequence(tagSequence, encodeOID(oid), value)
+}
+
+func nextVarbind(vars []variable, oid OID) []byte {
+	if v, ok := next(vars, oid); ok {
+		return varbind(v.oid, v.value)
+	}
+	return varbind(oid, encodeTLV(tagEndOfMibView, nil))
+}
+
+func lookup(vars []variable, oid OID) (variable, bool) {
+	i := sort.Search(len(vars), func(i int) bool { return vars[i].oid.Compare(oid) >= 0 })
+	if i < len(vars) && vars[i].oid.Compare(oid) == 0 {
+		return vars[i], true
+	}
+	return variable{}, false
+}
+
+func next(vars []variable, oid OID) (variable, bool) {
+	i := sort.Search(len(vars), func(i int) bool { return vars[i].oid.Compare(oid) > 0 })
+	if i < len(vars) {
+		return vars[i], true
+	}
+	return variable{}, false
+}
+
+// variables returns the MIB in OID order, re-reading the snapshot when
+// the cached one is stale
+func (a *Agent) variables() []variable {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.cached != nil && time.Since(a.cachedAt) < snapshotTTL {
+		return a.withUptime(a.cached)
+	}
+
+	s := a.snapshot()
+	truth := int64(2) // TruthValue false
+	if s.PostfixRunning {
+		truth = 1
+	}
+	base := a.cfg.BaseOID
+	vars := []variable{
+		{systemOID.Append(1, 0), encodeTLV(tagOctetString, []byte("PSFXSuite Postfix relay "+s.PostfixVersion))},
+		{systemOID.Append(2, 0), encodeOID(base)},
+		{systemOID.Append(3, 0), nil}, // sysUpTime, filled per request
+		{systemOID.Append(5, 0), encodeTLV(tagOctetString, []byte(s.Hostname))},
+
+		{base.Append(1, 1, 0), encodeInt(tagInteger, truth)},
+		{base.Append(1, 2, 0), encodeTLV(tagOctetString, []byte(s.PostfixVersion))},
+		{base.Append(1, 3, 0), encodeUnsigned(tagGauge32, uint32(s.ActiveAlerts))},
+		{base.Append(1, 4, 0), encodeUnsigned(tagGauge32, uint32(s.CriticalAlerts))},
+
+		{base.Append(2, 1, 0), encodeUnsigned(tagGauge32, uint32(s.QueueActive))},
+		{base.Append(2, 2, 0), encodeUnsigned(tagGauge32, uint32(s.QueueDeferred))},
+		{base.Append(2, 3, 0), encodeUnsigned(tagGauge32, uint32(s.QueueHold))},
+		{base.Append(2, 4, 0), encodeUnsigned(tagGauge32, uint32(s.QueueCorrupt))},
+		{base.Append(2, 5, 0), encodeUnsigned(tagGauge32,
+			uint32(s.QueueActive+s.QueueDeferred+s.QueueHold+s.QueueCorrupt))},
+
+		{base.Append(3, 1, 0), encodeUnsigned(tagCounter32, s.Sent)},
+		{base.Append(3, 2, 0), encodeUnsigned(tagCounter32, s.Bounced)},
+		{base.Append(3, 3, 0), encodeUnsigned(tagCounter32, s.Deferred)},
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].oid.Compare(vars[j].oid) < 0 })
+
+	a.cached = vars
+	a.cachedAt = time.Now()
+	return a.withUptime(vars)
+}
+
+// withUptime returns a copy of vars with sysUpTime set to now
+func (a *Agent) withUptime(vars []variable) []variable {
+	out := append([]variable(nil), vars...)
+	ticks := uint32(time.Since(a.started) / (10 * time.Millisecond))
+	uptimeOID := systemOID.Append(3, 0)
+	for i := range out {
+		if out[i].oid.Compare(uptimeOID) == 0 {
+			out[i].value = encodeUnsigned(tagTimeTicks, ticks)
+		}
+	}
+	return out
+}
@@ -0,0 +1,210 @@
+package snmp
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BER tags used by SNMP
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+	tagCounter32   = 0x41
+	tagGauge32     = 0x42
+	tagTimeTicks   = 0x43
+
+	tagNoSuchObject   = 0x80
+	tagNoSuchInstance = 0x81
+	tagEndOfMibView   = 0x82
+
+	tagGetRequest     = 0xa0
+	tagGetNextRequest = 0xa1
+	tagResponse       = 0xa2
+	tagSetRequest     = 0xa3
+	tagGetBulkRequest = 0xa5
+)
+
+var errTruncated = errors.New("truncated BER data")
+
+// OID is an object identifier
+type OID []uint32
+
+// ParseOID parses a dotted OID such as "1.3.6.1.4.1"
+func ParseOID(s string) (OID, error) {
+	parts := strings.Split(strings.Trim(s, "."), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("OID %q needs at least two arcs", s)
+	}
+	oid := make(OID, len(parts))
+	for i, p := range parts {
+		n, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		oid[i] = uint32(n)
+	}
+	return oid, nil
+}
+
+func (o OID) String() string {
+	parts := make([]string, len(o))
+	for i, n := range o {
+		parts[i] = strconv.FormatUint(uint64(n), 10)
+	}
+	return strings.Join(parts, ".")
+}
+
+// Append returns a new OID with arcs added
+func (o OID) Append(arcs ...uint32) OID {
+	out := make(OID, 0, len(o)+len(arcs))
+	return append(append(out, o...), arcs...)
+}
+
+// Compare orders OIDs lexicographically by arc
+func (o OID) Compare(other OID) int {
+	for i := 0; i < len(o) && i < len(other); i++ {
+		if o[i] != other[i] {
+			if o[i] < other[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(o) < len(other):
+		return -1
+	case len(o) > len(other):
+		return 1
+	}
+	return 0
+}
+
+// tlv is one decoded BER element
+type tlv struct {
+	tag   byte
+	value []byte
+}
+
+// readTLV decodes the element at the start of data and returns the rest
+func readTLV(data []byte) (tlv, []byte, error) {
+	if len(data) < 2 {
+		return tlv{}, nil, errTruncated
+	}
+	tag := data[0]
+	length := int(data[1])
+	offset := 2
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(data) < 2+n {
+			return tlv{}, nil, errTruncated
+		}
+		length = 0
+		for _, b := range data[2 : 2+n] {
+			length = length<<8 | int(b)
+		}
+		offset += n
+	}
+	if length < 0 || len(data) < offset+length {
+		return tlv{}, nil, errTruncated
+	}
+	return tlv{tag: tag, value: data[offset : offset+length]}, data[offset+length:], nil
+}
+
+func decodeInt(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 8 {
+		return 0, errors.New("invalid integer")
+	}
+	n := int64(int8(b[0]))
+	for _, c := range b[1:] {
+		n = n<<8 | int64(c)
+	}
+	return n, nil
+}
+
+func decodeOID(b []byte) (OID, error) {
+	if len(b) == 0 {
+		return nil, errors.New("empty OID")
+	}
+	oid := OID{uint32(b[0]) / 40, uint32(b[0]) % 40}
+	var n uint32
+	for i, c := range b[1:] {
+		n = n<<7 | uint32(c&0x7f)
+		if c&0x80 == 0 {
+			oid = append(oid, n)
+			n = 0
+		} else if i == len(b)-2 {
+			return nil, errors.New("truncated OID")
+		}
+	}
+	return oid, nil
+}
+
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func encodeTLV(tag byte, value []byte) []byte {
+	out := append([]byte{tag}, encodeLength(len(value))...)
+	return append(out, value...)
+}
+
+func encodeSequence(tag byte, elements ...[]byte) []byte {
+	var body []byte
+	for _, e := range elements {
+		body = append(body, e...)
+	}
+	return encodeTLV(tag, body)
+}
+
+func encodeInt(tag byte, n int64) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(n)}, b...)
+		if (n >= -128 && n < 128) || len(b) == 8 {
+			break
+		}
+		n >>= 8
+	}
+	return encodeTLV(tag, b)
+}
+
+// encodeUnsigned encodes Counter32, Gauge32 and TimeTicks values, which
+// need a leading zero byte when the high bit is set
+func encodeUnsigned(tag byte, n uint32) []byte {
+	b := []byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	for len(b) > 1 && b[0] == 0 && b[1]&0x80 == 0 {
+		b = b[1:]
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return encodeTLV(tag, b)
+}
+
+func encodeOID(oid OID) []byte {
+	if len(oid) < 2 {
+		return encodeTLV(tagOID, []byte{0})
+	}
+	b := []byte{byte(oid[0]*40 + oid[1])}
+	for _, arc := range oid[2:] {
+		var chunk []byte
+		chunk = append(chunk, byte(arc&0x7f))
+		for arc >>= 7; arc > 0; arc >>= 7 {
+			chunk = append([]byte{byte(arc&0x7f) | 0x80}, chunk...)
+		}
+		b = append(b, chunk...)
+	}
+	return encodeTLV(tagOID, b)
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when an object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// Object describes a stored object
+type Object struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// Store is a flat key/value object store. Keys use "/" as a separator,
+// e.g. "backups/2024-01-01.db.gz".
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	// List returns objects whose key starts with prefix, newest first
+	List(ctx context.Context, prefix string) ([]Object, error)
+	Name() string
+}
+
+// Config selects and configures a storage backend
+type Config struct {
+	Backend   string // "local" or "s3"
+	LocalPath string // local: base directory
+
+	// S3-compatible services (AWS S3, MinIO, GCS interoperability API)
+	Endpoint  string // e.g. https://minio.example.com:9000; empty for AWS
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string // Optional key prefix inside the bucket
+	PathStyle bool   // Use endpoint/bucket/key URLs (MinIO) instead of bucket.endpoint/key
+}
+
+// New returns the store for a configuration
+func New(cfg Config) (Store, error) {
+	switch strings.ToLower(cfg.Backend) {
+	case "", "local":
+		return NewLocal(cfg.LocalPath)
+	case "s3", "minio", "gcs":
+		return NewS3(cfg)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (expected local or s3)", cfg.Backend)
+	}
+}
+
+// CleanKey validates an object key, rejecting absolute paths and parent
+// directory references
+func CleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	cleaned := path.Clean(key)
+	if cleaned != key || cleaned == "." || strings.HasPrefix(cleaned, "../") || cleaned == ".." {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,198 @@
+// Package supervisor runs background work started by request handlers,
+// such as Dovecot syncs after a mailbox change, so that it is retried,
+// survives panics, is counted, and is waited for on shutdown instead of
+// failing silently into the log.
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Policy says how a failed task is retried
+type Policy struct {
+	Retries int           // further attempts after the first fails
+	Backoff time.Duration // wait before the first retry, doubled for each one after
+}
+
+var (
+	// Once runs a task a single time, for work that can't be repeated
+	// safely or is cheap to lose
+	Once = Policy{}
+
+	// Retry suits idempotent work such as rewriting a map from the
+	// database, where a later attempt can succeed once a lock or a
+	// full disk clears
+	Retry = Policy{Retries: 3, Backoff: 5 * time.Second}
+)
+
+// TaskStats counts the runs of one named task
+type TaskStats struct {
+	Name                string     `json:"name"`
+	Running             int        `json:"running"`
+	Runs                int64      `json:"runs"`
+	Failures            int64      `json:"failures"` // runs that failed every attempt
+	Retries             int64      `json:"retries"`
+	Panics              int64      `json:"panics"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastError           string     `json:"lastError,omitempty"`
+	LastFailureAt       *time.Time `json:"lastFailureAt,omitempty"`
+	LastSuccessAt       *time.Time `json:"lastSuccessAt,omitempty"`
+}
+
+// Supervisor runs and tracks background tasks
+type Supervisor struct {
+	mu     sync.Mutex
+	tasks  map[string]*TaskStats
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a supervisor
+func New() *Supervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Supervisor{
+		tasks:  make(map[string]*TaskStats),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Go runs fn in the background as the named task, retrying it per policy.
+// Tasks started after Stop are dropped.
+func (s *Supervisor) Go(name string, policy Policy, fn func() error) {
+	s.mu.Lock()
+	if s.ctx.Err() != nil {
+		s.mu.Unlock()
+		log.Warn().Str("task", name).Msg("Not starting background task during shutdown")
+		return
+	}
+	t := s.task(name)
+	t.Running++
+	t.Runs++
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.wg.Done()
+		s.run(name, policy, fn)
+	}()
+}
+
+// run makes the attempts of one run and records how it went
+func (s *Supervisor) run(name string, policy Policy, fn func() error) {
+	err := s.attempt(name, fn)
+	backoff := policy.Backoff
+retries:
+	for retry := 0; err != nil && retry < policy.Retries; retry++ {
+		log.Warn().Err(err).Str("task", name).Dur("retryIn", backoff).Msg("Background task failed, retrying")
+		select {
+		case <-s.ctx.Done():
+			// Shutting down: keep the last error rather than wait
+			break retries
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+
+		s.mu.Lock()
+		s.task(name).Retries++
+		s.mu.Unlock()
+		err = s.attempt(name, fn)
+	}
+
+	now := time.Now().UTC()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.task(name)
+	t.Running--
+	if err == nil {
+		t.ConsecutiveFailures = 0
+		t.LastSuccessAt = &now
+		return
+	}
+	t.Failures++
+	t.ConsecutiveFailures++
+	t.LastError = err.Error()
+	t.LastFailureAt = &now
+	log.Error().Err(err).Str("task", name).Int("consecutiveFailures", t.ConsecutiveFailures).Msg("Background task failed")
+}
+
+// attempt calls fn once, turning a panic into an error
+func (s *Supervisor) attempt(name string, fn func() error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Error().Str("task", name).Interface("panic", p).Bytes("stack", debug.Stack()).Msg("Background task panicked")
+			s.mu.Lock()
+			s.task(name).Panics++
+			s.mu.Unlock()
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return fn()
+}
+
+// task returns the stats of a task, creating them. Call with mu held.
+func (s *Supervisor) task(name string) *TaskStats {
+	t := s.tasks[name]
+	if t == nil {
+		t = &TaskStats{Name: name}
+		s.tasks[name] = t
+	}
+	return t
+}
+
+// Stats returns the counters of every task that has run, by name
+func (s *Supervisor) Stats() []TaskStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]TaskStats, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// WorstFailing returns the task with the most consecutive failed runs, or
+// false when every task's last run succeeded
+func (s *Supervisor) WorstFailing() (TaskStats, bool) {
+	var worst TaskStats
+	for _, t := range s.Stats() {
+		if t.ConsecutiveFailures > worst.ConsecutiveFailures {
+			worst = t
+		}
+	}
+	return worst, worst.ConsecutiveFailures > 0
+}
+
+// Done is closed when Stop is called, for long tasks to give up on
+func (s *Supervisor) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Stop cancels pending retries and waits for running tasks to finish, or
+// for ctx to end
+func (s *Supervisor) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	s.cancel()
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,90 @@
+package testharness
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/postfixrelay/postfixrelay/internal/database"
+	"gopkg.in/yaml.v3"
+)
+
+// NewDB opens a fresh database for the test, migrated and seeded with the
+// default settings and alert rules as on first start, and loads the given
+// fixture files into it
+func NewDB(tb testing.TB, fixtures ...string) *database.DB {
+	tb.Helper()
+	db, err := database.New(filepath.Join(tb.TempDir(), "postfixrelay.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		tb.Fatal(err)
+	}
+	for _, f := range fixtures {
+		LoadFixtures(tb, db, f)
+	}
+	return db
+}
+
+// LoadFixtures inserts the rows in a YAML fixture file, which maps table
+// names to lists of rows:
+//
+//	mail_domains:
+//	  - {id: 1, domain: example.com, active: true}
+//	mailboxes:
+//	  - {id: 1, domain_id: 1, local_part: alice, email: alice@example.com, password_hash: x}
+//
+// Tables are filled in the order they appear in the file, so parents go
+// before the rows that refer to them. settings rows replace the defaults.
+func LoadFixtures(tb testing.TB, db *database.DB, path string) {
+	tb.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		tb.Fatalf("%s: %v", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return
+	}
+	tables := doc.Content[0]
+	if tables.Kind != yaml.MappingNode {
+		tb.Fatalf("%s: expected a mapping of table names to rows", path)
+	}
+
+	// A mapping node's content alternates keys and values, which keeps
+	// the file's table order
+	for i := 0; i+1 < len(tables.Content); i += 2 {
+		table := tables.Content[i].Value
+		var rows []map[string]interface{}
+		if err := tables.Content[i+1].Decode(&rows); err != nil {
+			tb.Fatalf("%s: %s: %v", path, table, err)
+		}
+		verb := "INSERT"
+		if table == "settings" {
+			verb = "INSERT OR REPLACE"
+		}
+		for _, row := range rows {
+			cols := make([]string, 0, len(row))
+			for col := range row {
+				cols = append(cols, col)
+			}
+			sort.Strings(cols)
+			args := make([]interface{}, len(cols))
+			for j, col := range cols {
+				args[j] = row[col]
+			}
+			query := verb + " INTO " + table + " (" + strings.Join(cols, ", ") +
+				") VALUES (?" + strings.Repeat(", ?", len(cols)-1) + ")"
+			if _, err := db.Exec(query, args...); err != nil {
+				tb.Fatalf("%s: %s: %v", path, table, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,520 @@
+package testharness
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/server"
+)
+
+// IMAPServer is an in-memory IMAP server standing in for Dovecot. It
+// supports what webmail uses: folders, envelopes, whole-message fetches,
+// flags, copy, expunge, append and simple searches.
+type IMAPServer struct {
+	Addr string // host:port it listens on
+
+	mu    sync.Mutex
+	users map[string]*imapUser
+}
+
+// NewIMAPServer starts an IMAP server for the test and points webmail at
+// it through DOVECOT_HOST and DOVECOT_IMAP_PORT
+func NewIMAPServer(tb testing.TB) *IMAPServer {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	s := &IMAPServer{Addr: ln.Addr().String(), users: make(map[string]*imapUser)}
+
+	srv := server.New(&imapBackend{s})
+	srv.AllowInsecureAuth = true
+	go srv.Serve(ln)
+	tb.Cleanup(func() { srv.Close() })
+
+	host, port, _ := net.SplitHostPort(s.Addr)
+	tb.Setenv("DOVECOT_HOST", host)
+	tb.Setenv("DOVECOT_IMAP_PORT", port)
+	return s
+}
+
+// AddUser creates a mailbox user with the usual folders
+func (s *IMAPServer) AddUser(email, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := &imapUser{server: s, email: email, password: password, folders: make(map[string]*imapFolder)}
+	for _, name := range []string{"INBOX", "Sent", "Drafts", "Trash", "Junk"} {
+		u.folders[name] = &imapFolder{user: u, name: name}
+	}
+	s.users[email] = u
+}
+
+// Deliver puts a raw RFC 5322 message in a user's folder
+func (s *IMAPServer) Deliver(email, folder, raw string, flags ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.users[email]
+	if u == nil {
+		return errors.New("no such user: " + email)
+	}
+	f := u.folders[folder]
+	if f == nil {
+		return backend.ErrNoSuchMailbox
+	}
+	f.add(flags, time.Now(), []byte(raw))
+	return nil
+}
+
+// Messages returns the raw messages in a user's folder, oldest first
+func (s *IMAPServer) Messages(email, folder string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	if u := s.users[email]; u != nil && u.folders[folder] != nil {
+		for _, m := range u.folders[folder].messages {
+			out = append(out, string(m.body))
+		}
+	}
+	return out
+}
+
+type imapBackend struct {
+	s *IMAPServer
+}
+
+func (b *imapBackend) Login(_ *imap.ConnInfo, username, password string) (backend.User, error) {
+	b.s.mu.Lock()
+	defer b.s.mu.Unlock()
+	if u := b.s.users[username]; u != nil && u.password == password {
+		return u, nil
+	}
+	return nil, backend.ErrInvalidCredentials
+}
+
+type imapUser struct {
+	server   *IMAPServer
+	email    string
+	password string
+	folders  map[string]*imapFolder
+}
+
+func (u *imapUser) Username() string { return u.email }
+
+func (u *imapUser) ListMailboxes(subscribed bool) ([]backend.Mailbox, error) {
+	u.server.mu.Lock()
+	defer u.server.mu.Unlock()
+	names := make([]string, 0, len(u.folders))
+	for name := range u.folders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]backend.Mailbox, 0, len(names))
+	for _, name := range names {
+		out = append(out, u.folders[name])
+	}
+	return out, nil
+}
+
+func (u *imapUser) GetMailbox(name string) (backend.Mailbox, error) {
+	u.server.mu.Lock()
+	defer u.server.mu.Unlock()
+	if f := u.folders[name]; f != nil {
+		return f, nil
+	}
+	return nil, backend.ErrNoSuchMailbox
+}
+
+func (u *imapUser) CreateMailbox(name string) error {
+	u.server.mu.Lock()
+	defer u.server.mu.Unlock()
+	if u.folders[name] != nil {
+		return backend.ErrMailboxAlreadyExists
+	}
+	u.folders[name] = &imapFolder{user: u, name: name}
+	return nil
+}
+
+func (u *imapUser) DeleteMailbox(name string) error {
+	u.server.mu.Lock()
+	defer u.server.mu.Unlock()
+	if u.folders[name] == nil || name == "INBOX" {
+		return backend.ErrNoSuchMailbox
+	}
+	delete(u.folders, name)
+	return nil
+}
+
+func (u *imapUser) RenameMailbox(existingName, newName string) error {
+	u.server.mu.Lock()
+	defer u.server.mu.Unlock()
+	f := u.folders[existingName]
+	if f == nil {
+		return backend.ErrNoSuchMailbox
+	}
+	if u.folders[newName] != nil {
+		return backend.ErrMailboxAlreadyExists
+	}
+	delete(u.folders, existingName)
+	f.name = newName
+	u.folders[newName] = f
+	return nil
+}
+
+func (u *imapUser) Logout() error { return nil }
+
+type imapMessage struct {
+	uid   uint32
+	date  time.Time
+	flags []string
+	body  []byte
+}
+
+type imapFolder struct {
+	user     *imapUser
+	name     string
+	messages []*imapMessage
+	nextUID  uint32
+}
+
+// add appends a message. Call with the server's mu held.
+func (f *imapFolder) add(flags []string, date time.Time, body []byte) {
+	f.nextUID++
+	f.messages = append(f.messages, &imapMessage{uid: f.nextUID, date: date, flags: append([]string{}, flags...), body: body})
+}
+
+func (f *imapFolder) Name() string { return f.name }
+
+func (f *imapFolder) Info() (*imap.MailboxInfo, error) {
+	return &imap.MailboxInfo{Delimiter: "/", Name: f.name}, nil
+}
+
+func (f *imapFolder) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	f.user.server.mu.Lock()
+	defer f.user.server.mu.Unlock()
+	status := imap.NewMailboxStatus(f.name, items)
+	status.Flags = []string{imap.SeenFlag, imap.AnsweredFlag, imap.FlaggedFlag, imap.DeletedFlag, imap.DraftFlag}
+	status.PermanentFlags = []string{"\\*"}
+	var unseen uint32
+	for i, m := range f.messages {
+		if !hasFlag(m.flags, imap.SeenFlag) {
+			if status.UnseenSeqNum == 0 {
+				status.UnseenSeqNum = uint32(i + 1)
+			}
+			unseen++
+		}
+	}
+	for _, item := range items {
+		switch item {
+		case imap.StatusMessages:
+			status.Messages = uint32(len(f.messages))
+		case imap.StatusUidNext:
+			status.UidNext = f.nextUID + 1
+		case imap.StatusUidValidity:
+			status.UidValidity = 1
+		case imap.StatusUnseen:
+			status.Unseen = unseen
+		}
+	}
+	return status, nil
+}
+
+func (f *imapFolder) SetSubscribed(bool) error { return nil }
+
+func (f *imapFolder) Check() error { return nil }
+
+func (f *imapFolder) ListMessages(uid bool, seqSet *imap.SeqSet, items []imap.FetchItem, ch chan<- *imap.Message) error {
+	defer close(ch)
+	f.user.server.mu.Lock()
+	var fetched []*imap.Message
+	for i, m := range f.messages {
+		if !f.selected(uid, seqSet, i, m) {
+			continue
+		}
+		fetched = append(fetched, m.fetch(uint32(i+1), items))
+	}
+	f.user.server.mu.Unlock()
+
+	for _, m := range fetched {
+		ch <- m
+	}
+	return nil
+}
+
+func (f *imapFolder) SearchMessages(uid bool, criteria *imap.SearchCriteria) ([]uint32, error) {
+	f.user.server.mu.Lock()
+	defer f.user.server.mu.Unlock()
+	var ids []uint32
+	for i, m := range f.messages {
+		if !m.matches(uint32(i+1), criteria) {
+			continue
+		}
+		if uid {
+			ids = append(ids, m.uid)
+		} else {
+			ids = append(ids, uint32(i+1))
+		}
+	}
+	return ids, nil
+}
+
+func (f *imapFolder) CreateMessage(flags []string, date time.Time, body imap.Literal) error {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	f.user.server.mu.Lock()
+	defer f.user.server.mu.Unlock()
+	f.add(flags, date, b)
+	return nil
+}
+
+func (f *imapFolder) UpdateMessagesFlags(uid bool, seqSet *imap.SeqSet, op imap.FlagsOp, flags []string) error {
+	f.user.server.mu.Lock()
+	defer f.user.server.mu.Unlock()
+	for i, m := range f.messages {
+		if !f.selected(uid, seqSet, i, m) {
+			continue
+		}
+		switch op {
+		case imap.SetFlags:
+			m.flags = append([]string{}, flags...)
+		case imap.AddFlags:
+			for _, flag := range flags {
+				if !hasFlag(m.flags, flag) {
+					m.flags = append(m.flags, flag)
+				}
+			}
+		case imap.RemoveFlags:
+			kept := m.flags[:0]
+			for _, flag := range m.flags {
+				if !hasFlag(flags, flag) {
+					kept = append(kept, flag)
+				}
+			}
+			m.flags = kept
+		}
+	}
+	return nil
+}
+
+func (f *imapFolder) CopyMessages(uid bool, seqSet *imap.SeqSet, dest string) error {
+	f.user.server.mu.Lock()
+	defer f.user.server.mu.Unlock()
+	to := f.user.folders[dest]
+	if to == nil {
+		return backend.ErrNoSuchMailbox
+	}
+	for i, m := range f.messages {
+		if f.selected(uid, seqSet, i, m) {
+			to.add(m.flags, m.date, m.body)
+		}
+	}
+	return nil
+}
+
+func (f *imapFolder) Expunge() error {
+	f.user.server.mu.Lock()
+	defer f.user.server.mu.Unlock()
+	kept := f.messages[:0]
+	for _, m := range f.messages {
+		if !hasFlag(m.flags, imap.DeletedFlag) {
+			kept = append(kept, m)
+		}
+	}
+	f.messages = kept
+	return nil
+}
+
+// selected reports whether the i'th message is in a sequence or UID set
+func (f *imapFolder) selected(uid bool, seqSet *imap.SeqSet, i int, m *imapMessage) bool {
+	if uid {
+		return seqSet.Contains(m.uid)
+	}
+	return seqSet.Contains(uint32(i + 1))
+}
+
+// fetch answers a FETCH for the message. Body sections other than the
+// whole message, HEADER and TEXT come back empty.
+func (m *imapMessage) fetch(seqNum uint32, items []imap.FetchItem) *imap.Message {
+	out := imap.NewMessage(seqNum, items)
+	header, text := m.split()
+	for _, item := range items {
+		switch item {
+		case imap.FetchEnvelope:
+			out.Envelope = m.envelope()
+		case imap.FetchFlags:
+			out.Flags = append([]string{}, m.flags...)
+		case imap.FetchInternalDate:
+			out.InternalDate = m.date
+		case imap.FetchRFC822Size:
+			out.Size = uint32(len(m.body))
+		case imap.FetchUid:
+			out.Uid = m.uid
+		default:
+			section, err := imap.ParseBodySectionName(item)
+			if err != nil {
+				continue
+			}
+			var b []byte
+			switch {
+			case len(section.Path) > 0:
+			case section.Specifier == imap.EntireSpecifier:
+				b = m.body
+			case section.Specifier == imap.HeaderSpecifier:
+				b = header
+			case section.Specifier == imap.TextSpecifier:
+				b = text
+			}
+			out.Body[section] = bytes.NewReader(section.ExtractPartial(b))
+		}
+	}
+	return out
+}
+
+// split returns the header, with its blank line, and the text
+func (m *imapMessage) split() ([]byte, []byte) {
+	for _, sep := range []string{"\r\n\r\n", "\n\n"} {
+		if i := bytes.Index(m.body, []byte(sep)); i >= 0 {
+			return m.body[:i+len(sep)], m.body[i+len(sep):]
+		}
+	}
+	return m.body, nil
+}
+
+func (m *imapMessage) envelope() *imap.Envelope {
+	msg, err := mail.ReadMessage(bytes.NewReader(m.body))
+	if err != nil {
+		return &imap.Envelope{}
+	}
+	h := msg.Header
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(h.Get("Subject"))
+	if err != nil {
+		subject = h.Get("Subject")
+	}
+	env := &imap.Envelope{
+		Subject:   subject,
+		From:      envelopeAddresses(h, "From"),
+		Sender:    envelopeAddresses(h, "Sender"),
+		ReplyTo:   envelopeAddresses(h, "Reply-To"),
+		To:        envelopeAddresses(h, "To"),
+		Cc:        envelopeAddresses(h, "Cc"),
+		Bcc:       envelopeAddresses(h, "Bcc"),
+		InReplyTo: h.Get("In-Reply-To"),
+		MessageId: h.Get("Message-Id"),
+	}
+	if date, err := h.Date(); err == nil {
+		env.Date = date
+	}
+	if len(env.Sender) == 0 {
+		env.Sender = env.From
+	}
+	if len(env.ReplyTo) == 0 {
+		env.ReplyTo = env.From
+	}
+	return env
+}
+
+func envelopeAddresses(h mail.Header, key string) []*imap.Address {
+	list, err := h.AddressList(key)
+	if err != nil {
+		return nil
+	}
+	var out []*imap.Address
+	for _, a := range list {
+		local, domain, _ := strings.Cut(a.Address, "@")
+		out = append(out, &imap.Address{PersonalName: a.Name, MailboxName: local, HostName: domain})
+	}
+	return out
+}
+
+// matches checks the search criteria webmail sends: headers, body and
+// text substrings, dates, flags, sizes, NOT and OR
+func (m *imapMessage) matches(seqNum uint32, c *imap.SearchCriteria) bool {
+	if c.SeqNum != nil && !c.SeqNum.Contains(seqNum) {
+		return false
+	}
+	if c.Uid != nil && !c.Uid.Contains(m.uid) {
+		return false
+	}
+	if !c.Since.IsZero() && m.date.Before(c.Since) {
+		return false
+	}
+	if !c.Before.IsZero() && !m.date.Before(c.Before) {
+		return false
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(m.body))
+	if err != nil {
+		return false
+	}
+	for key, values := range c.Header {
+		for _, v := range values {
+			if !containsFold(msg.Header.Get(key), v) {
+				return false
+			}
+		}
+	}
+	_, text := m.split()
+	for _, v := range c.Body {
+		if !containsFold(string(text), v) {
+			return false
+		}
+	}
+	for _, v := range c.Text {
+		if !containsFold(string(m.body), v) {
+			return false
+		}
+	}
+	for _, flag := range c.WithFlags {
+		if !hasFlag(m.flags, flag) {
+			return false
+		}
+	}
+	for _, flag := range c.WithoutFlags {
+		if hasFlag(m.flags, flag) {
+			return false
+		}
+	}
+	if c.Larger > 0 && uint32(len(m.body)) <= c.Larger {
+		return false
+	}
+	if c.Smaller > 0 && uint32(len(m.body)) >= c.Smaller {
+		return false
+	}
+	for _, not := range c.Not {
+		if m.matches(seqNum, not) {
+			return false
+		}
+	}
+	for _, or := range c.Or {
+		if !m.matches(seqNum, or[0]) && !m.matches(seqNum, or[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
+}
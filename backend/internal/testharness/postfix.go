@@ -0,0 +1,346 @@
+// Package testharness stands in for the mail host in integration tests: a
+// fake Postfix command line (postconf, postqueue, postsuper, postmap,
+// postcat, mailq and sudo) backed by a queue on disk, an in-memory IMAP
+// server for webmail, and a migrated SQLite database loaded from YAML
+// fixtures. With them ConfigManager, QueueManager and the webmail handlers
+// run as they would against a live host.
+//
+// A queue test looks like:
+//
+//	pf := testharness.NewPostfix(t)
+//	pf.AddMessage(testharness.QueueEntry{QueueID: "A1B2C3D4E5", Status: "deferred",
+//		Sender: "a@example.com", Recipients: []string{"b@example.net"}})
+//	qm := postfix.NewQueueManager(pf.ConfigDir)
+//	if err := qm.HoldMessage("A1B2C3D4E5"); err != nil { ... }
+//	// pf.Queue()[0].Status is now "hold"
+//
+// The fakes are shell scripts, so tests using them need a POSIX sh.
+package testharness
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// QueueEntry is a message in the fake Postfix queue
+type QueueEntry struct {
+	QueueID    string
+	Status     string // active, deferred or hold
+	Size       int64
+	Arrival    time.Time
+	Sender     string
+	Recipients []string
+	Reason     string // deferral reason, shown by mailq
+	Content    string // what postcat prints; a minimal message if empty
+}
+
+// Postfix is a fake Postfix installation. Its commands come first on PATH
+// for the rest of the test, and keep their state under Dir.
+type Postfix struct {
+	Dir       string // state: queue/, calls.log, fail/
+	ConfigDir string // stands in for /etc/postfix, with an empty main.cf
+
+	tb testing.TB
+}
+
+// mailqTime is how mailq prints arrival times
+const mailqTime = "Mon Jan _2 15:04:05"
+
+// postconfDefaults is what postconf -d prints, enough for the parameter
+// names and version the backend looks up
+var postconfDefaults = []string{
+	"alias_maps = hash:/etc/aliases",
+	"bounce_template_file =",
+	"delay_warning_time = 0h",
+	"mail_version = 3.7.9",
+	"maximal_queue_lifetime = 5d",
+	"mydestination = $myhostname, localhost.$mydomain, localhost",
+	"myhostname = localhost",
+	"relayhost =",
+	"smtp_tls_security_level =",
+	"smtpd_tls_security_level =",
+	"transport_maps =",
+	"virtual_alias_maps =",
+}
+
+// NewPostfix installs the fake Postfix commands for the test
+func NewPostfix(tb testing.TB) *Postfix {
+	tb.Helper()
+	p := &Postfix{Dir: tb.TempDir(), tb: tb}
+	p.ConfigDir = filepath.Join(p.Dir, "etc")
+	bin := filepath.Join(p.Dir, "bin")
+	for _, dir := range []string{bin, p.ConfigDir, filepath.Join(p.Dir, "queue"), filepath.Join(p.Dir, "fail")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	p.write(filepath.Join(p.ConfigDir, "main.cf"), "")
+	p.write(filepath.Join(p.ConfigDir, "master.cf"), "")
+	p.write(filepath.Join(p.Dir, "postconf.defaults"), strings.Join(postconfDefaults, "\n")+"\n")
+	p.write(filepath.Join(p.Dir, "calls.log"), "")
+
+	for name, body := range shims {
+		script := "#!/bin/sh\n" + strings.ReplaceAll(shimPrelude+body, "@DIR@", p.Dir)
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	tb.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return p
+}
+
+// AddMessage puts a message in the queue
+func (p *Postfix) AddMessage(e QueueEntry) {
+	p.tb.Helper()
+	if e.Status == "" {
+		e.Status = "deferred"
+	}
+	if e.Arrival.IsZero() {
+		e.Arrival = time.Now()
+	}
+	if e.Size == 0 {
+		e.Size = 1024
+	}
+	sender := e.Sender
+	if sender == "" {
+		sender = "MAILER-DAEMON"
+	}
+	lines := []string{
+		e.Status + " " + strconv.FormatInt(e.Size, 10) + " " + sender,
+		e.Arrival.Format(mailqTime),
+	}
+	if e.Reason != "" {
+		lines = append(lines, "("+e.Reason+")")
+	}
+	lines = append(lines, e.Recipients...)
+	p.write(p.queueFile(e.QueueID, ".meta"), strings.Join(lines, "\n")+"\n")
+
+	content := e.Content
+	if content == "" {
+		content = "From: <" + e.Sender + ">\nTo: <" + strings.Join(e.Recipients, ">, <") + ">\nSubject: Queued message " + e.QueueID + "\n\nTest message.\n"
+	}
+	p.write(p.queueFile(e.QueueID, ".eml"), content)
+}
+
+// Queue returns the messages in the queue, by queue ID
+func (p *Postfix) Queue() []QueueEntry {
+	p.tb.Helper()
+	files, err := filepath.Glob(filepath.Join(p.Dir, "queue", "*.meta"))
+	if err != nil {
+		p.tb.Fatal(err)
+	}
+	sort.Strings(files)
+
+	var entries []QueueEntry
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			p.tb.Fatal(err)
+		}
+		e := QueueEntry{QueueID: strings.TrimSuffix(filepath.Base(f), ".meta")}
+		scanner := bufio.NewScanner(strings.NewReader(string(data)))
+		for i := 0; scanner.Scan(); i++ {
+			line := scanner.Text()
+			switch {
+			case i == 0:
+				fields := strings.Fields(line)
+				if len(fields) == 3 {
+					e.Status, e.Sender = fields[0], fields[2]
+					e.Size, _ = strconv.ParseInt(fields[1], 10, 64)
+				}
+			case i == 1:
+				// mailq leaves out the year
+				if t, err := time.ParseInLocation(mailqTime, line, time.Local); err == nil {
+					e.Arrival = t.AddDate(time.Now().Year(), 0, 0)
+				}
+			case strings.HasPrefix(line, "("):
+				e.Reason = strings.Trim(line, "()")
+			default:
+				e.Recipients = append(e.Recipients, line)
+			}
+		}
+		if content, err := os.ReadFile(p.queueFile(e.QueueID, ".eml")); err == nil {
+			e.Content = string(content)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// Calls returns the fake commands run so far, one "name args..." line
+// each, in order. Commands run through sudo show twice: sudo, then the
+// command itself.
+func (p *Postfix) Calls() []string {
+	p.tb.Helper()
+	data, err := os.ReadFile(filepath.Join(p.Dir, "calls.log"))
+	if err != nil {
+		p.tb.Fatal(err)
+	}
+	return strings.FieldsFunc(string(data), func(r rune) bool { return r == '\n' })
+}
+
+// Fail makes a command exit 1 after printing message to stderr, until
+// Succeed is called. postfix fails for "check" and "reload" alike.
+func (p *Postfix) Fail(command, message string) {
+	p.tb.Helper()
+	p.write(filepath.Join(p.Dir, "fail", command), message+"\n")
+}
+
+// Succeed undoes Fail
+func (p *Postfix) Succeed(command string) {
+	os.Remove(filepath.Join(p.Dir, "fail", command))
+}
+
+func (p *Postfix) queueFile(queueID, ext string) string {
+	return filepath.Join(p.Dir, "queue", queueID+ext)
+}
+
+func (p *Postfix) write(path, content string) {
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		p.tb.Fatal(err)
+	}
+}
+
+// shimPrelude starts every fake command: it logs the call and fails if
+// asked to. @DIR@ is replaced with the state directory.
+const shimPrelude = `dir='@DIR@'
+name=$(basename "$0")
+echo "$name $*" >> "$dir/calls.log"
+if [ -f "$dir/fail/$name" ]; then
+	cat "$dir/fail/$name" >&2
+	exit 1
+fi
+`
+
+// shims are the fake commands, by name
+var shims = map[string]string{
+	// The wrapper scripts under /opt/postfixrelay/scripts map to the
+	// commands they wrap
+	"sudo": `case "$1" in
+*/safe-postsuper.sh) shift; exec postsuper "$@" ;;
+*/safe-postcat.sh) shift; exec postcat "$@" ;;
+esac
+exec "$@"
+`,
+
+	"mailq": `set -- "$dir"/queue/*.meta
+if [ ! -f "$1" ]; then
+	echo "Mail queue is empty"
+	exit 0
+fi
+echo "-Queue ID-  --Size-- ----Arrival Time---- -Sender/Recipient-------"
+for f in "$@"; do
+	id=$(basename "$f" .meta)
+	{
+		read -r status size sender
+		read -r arrival
+		case "$status" in
+		active) mark='*' ;;
+		hold) mark='!' ;;
+		*) mark='' ;;
+		esac
+		printf '%s%s %9s %s  %s\n' "$id" "$mark" "$size" "$arrival" "$sender"
+		while read -r line; do
+			printf '                                         %s\n' "$line"
+		done
+	} < "$f"
+	echo
+done
+`,
+
+	"postqueue": `case "$1" in
+-p) exec mailq ;;
+esac
+exit 0
+`,
+
+	// A queue ID of - reads the IDs from stdin, one per line
+	"postsuper": `ids=$2
+[ "$2" = - ] && ids=$(cat)
+case "$1" in
+-h|-H)
+	status=deferred
+	[ "$1" = -h ] && status=hold
+	for id in $ids; do
+		f="$dir/queue/$id.meta"
+		if [ ! -f "$f" ]; then
+			echo "postsuper: warning: $id: no such queue file" >&2
+			continue
+		fi
+		{ read -r old rest; echo "$status $rest"; cat; } < "$f" > "$f.tmp" && mv "$f.tmp" "$f"
+	done
+	;;
+-d)
+	if [ "$2" != ALL ]; then
+		for id in $ids; do
+			rm -f "$dir/queue/$id.meta" "$dir/queue/$id.eml"
+		done
+		exit 0
+	fi
+	for f in "$dir"/queue/*.meta; do
+		[ -f "$f" ] || continue
+		if [ -n "$3" ]; then
+			read -r status rest < "$f"
+			[ "$status" = "$3" ] || continue
+		fi
+		rm -f "$f" "${f%.meta}.eml"
+	done
+	;;
+esac
+exit 0
+`,
+
+	"postcat": `id=$1
+[ "$1" = -q ] && id=$2
+if [ -f "$dir/queue/$id.eml" ]; then
+	cat "$dir/queue/$id.eml"
+	exit 0
+fi
+echo "postcat: fatal: open queue file $id: No such file or directory" >&2
+exit 1
+`,
+
+	"postconf": `case "$1" in
+-m)
+	printf '%s\n' btree cidr environ hash inline lmdb pcre regexp static tcp texthash unix
+	;;
+-d)
+	shift
+	if [ $# -eq 0 ]; then
+		cat "$dir/postconf.defaults"
+		exit 0
+	fi
+	for p in "$@"; do
+		grep "^$p = " "$dir/postconf.defaults"
+	done
+	;;
+-c)
+	grep -v '^[[:space:]]*#' "$2/main.cf" | grep -v '^[[:space:]]*$'
+	;;
+esac
+exit 0
+`,
+
+	// Compiles type:path maps by touching path.db
+	"postmap": `for arg in "$@"; do
+	case "$arg" in
+	-*) ;;
+	*:*) touch "${arg#*:}.db" ;;
+	*) touch "$arg.db" ;;
+	esac
+done
+exit 0
+`,
+
+	"postfix": `case "$1" in
+status) echo "postfix/postfix-script: the Postfix mail system is running: PID: 1" ;;
+esac
+exit 0
+`,
+}
@@ -0,0 +1,441 @@
+// Package tlsstats aggregates smtp and smtpd TLS log lines into per-peer
+// protocol and cipher statistics.
+package tlsstats
+
+import (
+	"database/sql"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/rs/zerolog/log"
+)
+
+// Directions
+const (
+	Inbound  = "inbound"  // Clients connecting to smtpd
+	Outbound = "outbound" // Servers smtp delivers to
+)
+
+// Plaintext is the protocol recorded for sessions without TLS
+const Plaintext = "none"
+
+// Peer flags
+const (
+	FlagLegacyProtocol = "legacy_protocol" // Negotiated SSLv3, TLS 1.0 or TLS 1.1
+	FlagPlaintext      = "plaintext"       // Had sessions without TLS
+)
+
+var (
+	// "Trusted TLS connection established to mx.example.com[192.0.2.1]:25:
+	// TLSv1.2 with cipher ECDHE-RSA-AES256-GCM-SHA384 (256/256 bits)"
+	establishedRe = regexp.MustCompile(`(\w+) TLS connection established (from|to) ([^\[\s]+)\[([^\]]+)\](?::\d+)?: (\S+) with cipher (\S+)`)
+	peerRe        = regexp.MustCompile(`([^\[\s]+)\[([^\]]+)\]`)
+	starttlsRe    = regexp.MustCompile(`\bstarttls=(\d+)`)
+)
+
+// legacyProtocols are the protocol versions flagged for policy tightening
+var legacyProtocols = map[string]bool{
+	"SSLv3":   true,
+	"TLSv1":   true,
+	"TLSv1.1": true,
+}
+
+// IsLegacyProtocol reports whether a negotiated protocol is deprecated
+func IsLegacyProtocol(protocol string) bool {
+	return legacyProtocols[protocol]
+}
+
+type sessionKey struct {
+	bucket    time.Time
+	direction string
+	ip        string
+	protocol  string
+	cipher    string
+	trust     string
+}
+
+// inboundSession tracks an smtpd connection so plaintext sessions can be
+// counted at disconnect
+type inboundSession struct {
+	ip   string
+	host string
+	tls  bool
+}
+
+// Collector consumes smtp/smtpd log entries and keeps session counts in
+// hourly buckets, flushed to the database every minute
+type Collector struct {
+	db *sql.DB
+
+	mu       sync.Mutex
+	inbound  map[string]*inboundSession // smtpd connections keyed by process/pid
+	outbound map[string]string          // peer IP of the last TLS handshake per smtp process/pid
+	pending  map[sessionKey]int
+	hosts    map[string]string // hostname per peer IP in pending
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewCollector creates a collector
+func NewCollector(db *sql.DB) *Collector {
+	return &Collector{
+		db:       db,
+		inbound:  make(map[string]*inboundSession),
+		outbound: make(map[string]string),
+		pending:  make(map[sessionKey]int),
+		hosts:    make(map[string]string),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start begins flushing counts to the database
+func (c *Collector) Start() {
+	c.done = make(chan struct{})
+	go c.loop()
+}
+
+// Stop flushes pending counts and stops the collector
+func (c *Collector) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+		if c.done != nil {
+			<-c.done
+		}
+	})
+}
+
+func (c *Collector) loop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+
+	for {
+		select {
+		case <-c.stopCh:
+			c.flush()
+			return
+		case now := <-ticker.C:
+			c.flush()
+			if now.Sub(lastPrune) >= time.Hour {
+				c.prune(now)
+				lastPrune = now
+			}
+		}
+	}
+}
+
+// Consume processes one log entry; entries from processes other than smtp
+// and smtpd are ignored
+func (c *Collector) Consume(e logs.Entry) {
+	var smtpd bool
+	switch {
+	case strings.HasSuffix(e.Process, "smtpd"):
+		smtpd = true
+	case e.Process == "smtp" || strings.HasSuffix(e.Process, "/smtp"):
+	default:
+		return
+	}
+
+	msg := e.Message
+	key := e.Process + "/" + strconv.Itoa(e.PID)
+	ts := e.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if m := establishedRe.FindStringSubmatch(msg); m != nil {
+		trust, direction, host, ip, protocol, cipher := m[1], Inbound, m[3], m[4], m[5], m[6]
+		if m[2] == "to" {
+			direction = Outbound
+			c.outbound[key] = ip
+		} else if sess := c.inbound[key]; sess != nil {
+			sess.tls = true
+		}
+		c.record(ts, direction, ip, host, protocol, cipher, trust)
+		return
+	}
+
+	if smtpd {
+		switch {
+		case strings.HasPrefix(msg, "connect from "):
+			if host, ip := parsePeer(msg); ip != "" {
+				c.inbound[key] = &inboundSession{ip: ip, host: host}
+			}
+		case strings.HasPrefix(msg, "disconnect from "):
+			sess := c.inbound[key]
+			delete(c.inbound, key)
+			if sess == nil || sess.tls {
+				return
+			}
+			if m := starttlsRe.FindStringSubmatch(msg); m != nil && m[1] != "0" {
+				return
+			}
+			// Local submissions (pickup, sendmail via loopback) never use TLS
+			if parsed := net.ParseIP(sess.ip); parsed != nil && parsed.IsLoopback() {
+				return
+			}
+			c.record(ts, Inbound, sess.ip, sess.host, Plaintext, "", "")
+		}
+		return
+	}
+
+	// A delivery over a connection without a TLS handshake to the same peer
+	// went out in plaintext
+	if e.Status == "" || e.Relay == "" {
+		return
+	}
+	m := peerRe.FindStringSubmatch(e.Relay)
+	if m == nil {
+		return
+	}
+	if c.outbound[key] != m[2] {
+		c.record(ts, Outbound, m[2], m[1], Plaintext, "", "")
+	}
+}
+
+// parsePeer extracts the hostname and IP from "host[ip]"
+func parsePeer(msg string) (string, string) {
+	m := peerRe.FindStringSubmatch(msg)
+	if m == nil || m[2] == "unknown" {
+		return "", ""
+	}
+	return m[1], m[2]
+}
+
+func (c *Collector) record(ts time.Time, direction, ip, host, protocol, cipher, trust string) {
+	key := sessionKey{
+		bucket:    ts.UTC().Truncate(time.Hour),
+		direction: direction,
+		ip:        ip,
+		protocol:  protocol,
+		cipher:    cipher,
+		trust:     trust,
+	}
+	c.pending[key]++
+	if host != "" && host != "unknown" {
+		c.hosts[ip] = host
+	}
+}
+
+// flush writes pending counts to the database
+func (c *Collector) flush() {
+	c.mu.Lock()
+	pending, hosts := c.pending, c.hosts
+	c.pending = make(map[sessionKey]int)
+	c.hosts = make(map[string]string)
+	c.mu.Unlock()
+
+	for key, n := range pending {
+		_, err := c.db.Exec(`
+			INSERT INTO tls_peer_stats (bucket, direction, peer_ip, peer_host, protocol, cipher, trust, sessions)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(bucket, direction, peer_ip, protocol, cipher, trust) DO UPDATE SET
+				peer_host = COALESCE(NULLIF(excluded.peer_host, ''), peer_host),
+				sessions = sessions + excluded.sessions
+		`, key.bucket.Format(time.RFC3339), key.direction, key.ip, hosts[key.ip],
+			key.protocol, key.cipher, key.trust, n)
+		if err != nil {
+			log.Error().Err(err).Str("peer", key.ip).Msg("Failed to store TLS statistics")
+		}
+	}
+}
+
+// prune removes buckets older than tlsstats_retention_days
+func (c *Collector) prune(now time.Time) {
+	days := 30
+	var value string
+	if err := c.db.QueryRow(`SELECT value FROM settings WHERE key = 'tlsstats_retention_days'`).Scan(&value); err == nil {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			days = n
+		}
+	}
+	cutoff := now.UTC().AddDate(0, 0, -days).Format(time.RFC3339)
+	c.db.Exec(`DELETE FROM tls_peer_stats WHERE bucket < ?`, cutoff)
+}
+
+// ProtocolCount is the number of sessions that negotiated a protocol
+type ProtocolCount struct {
+	Direction string `json:"direction"`
+	Protocol  string `json:"protocol"`
+	Sessions  int    `json:"sessions"`
+	Legacy    bool   `json:"legacy,omitempty"`
+}
+
+// CipherCount is the number of sessions that negotiated a cipher
+type CipherCount struct {
+	Direction string `json:"direction"`
+	Protocol  string `json:"protocol"`
+	Cipher    string `json:"cipher"`
+	Sessions  int    `json:"sessions"`
+}
+
+// Peer is one peer's TLS usage in one direction
+type Peer struct {
+	Direction string         `json:"direction"`
+	IP        string         `json:"ip"`
+	Hostname  string         `json:"hostname,omitempty"`
+	Sessions  int            `json:"sessions"`
+	Plaintext int            `json:"plaintext"`
+	Protocols map[string]int `json:"protocols"`
+	Ciphers   map[string]int `json:"ciphers"`
+	Trust     map[string]int `json:"trust"`
+	LastSeen  time.Time      `json:"lastSeen"`
+	Flags     []string       `json:"flags"`
+}
+
+// Report summarizes TLS usage over a period
+type Report struct {
+	Since     time.Time       `json:"since"`
+	Protocols []ProtocolCount `json:"protocols"`
+	Ciphers   []CipherCount   `json:"ciphers"`
+	Peers     []Peer          `json:"peers"`
+	Flagged   int             `json:"flagged"` // Peers with at least one flag
+}
+
+// ReportOptions filter a report
+type ReportOptions struct {
+	Direction   string // Inbound, Outbound or empty for both
+	IP          string
+	FlaggedOnly bool
+	Limit       int // Maximum number of peers; flagged peers sort first
+}
+
+// BuildReport aggregates sessions since a time
+func BuildReport(db *sql.DB, since time.Time, opts ReportOptions) (*Report, error) {
+	query := `
+		SELECT direction, peer_ip, COALESCE(MAX(peer_host), ''), protocol, cipher, trust,
+			SUM(sessions), MAX(bucket)
+		FROM tls_peer_stats
+		WHERE bucket >= ?`
+	args := []interface{}{since.UTC().Truncate(time.Hour).Format(time.RFC3339)}
+	if opts.Direction != "" {
+		query += ` AND direction = ?`
+		args = append(args, opts.Direction)
+	}
+	if opts.IP != "" {
+		query += ` AND peer_ip = ?`
+		args = append(args, opts.IP)
+	}
+	query += ` GROUP BY direction, peer_ip, protocol, cipher, trust`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type protocolKey struct{ direction, protocol string }
+	type cipherKey struct{ direction, protocol, cipher string }
+	type peerKey struct{ direction, ip string }
+	protocols := make(map[protocolKey]int)
+	ciphers := make(map[cipherKey]int)
+	peers := make(map[peerKey]*Peer)
+
+	for rows.Next() {
+		var direction, ip, host, protocol, cipher, trust, lastSeen string
+		var n int
+		if err := rows.Scan(&direction, &ip, &host, &protocol, &cipher, &trust, &n, &lastSeen); err != nil {
+			continue
+		}
+
+		protocols[protocolKey{direction, protocol}] += n
+		if cipher != "" {
+			ciphers[cipherKey{direction, protocol, cipher}] += n
+		}
+
+		p := peers[peerKey{direction, ip}]
+		if p == nil {
+			p = &Peer{
+				Direction: direction,
+				IP:        ip,
+				Protocols: make(map[string]int),
+				Ciphers:   make(map[string]int),
+				Trust:     make(map[string]int),
+				Flags:     []string{},
+			}
+			peers[peerKey{direction, ip}] = p
+		}
+		if host != "" {
+			p.Hostname = host
+		}
+		p.Sessions += n
+		p.Protocols[protocol] += n
+		if protocol == Plaintext {
+			p.Plaintext += n
+		} else {
+			p.Ciphers[cipher] += n
+			if trust != "" {
+				p.Trust[trust] += n
+			}
+		}
+		if t, err := time.Parse(time.RFC3339, lastSeen); err == nil && t.After(p.LastSeen) {
+			p.LastSeen = t
+		}
+	}
+
+	report := &Report{
+		Since:     since,
+		Protocols: []ProtocolCount{},
+		Ciphers:   []CipherCount{},
+		Peers:     []Peer{},
+	}
+	for k, n := range protocols {
+		report.Protocols = append(report.Protocols, ProtocolCount{
+			Direction: k.direction, Protocol: k.protocol, Sessions: n, Legacy: IsLegacyProtocol(k.protocol),
+		})
+	}
+	sort.Slice(report.Protocols, func(i, j int) bool {
+		return report.Protocols[i].Sessions > report.Protocols[j].Sessions
+	})
+	for k, n := range ciphers {
+		report.Ciphers = append(report.Ciphers, CipherCount{
+			Direction: k.direction, Protocol: k.protocol, Cipher: k.cipher, Sessions: n,
+		})
+	}
+	sort.Slice(report.Ciphers, func(i, j int) bool {
+		return report.Ciphers[i].Sessions > report.Ciphers[j].Sessions
+	})
+
+	for _, p := range peers {
+		for protocol := range p.Protocols {
+			if IsLegacyProtocol(protocol) {
+				p.Flags = append(p.Flags, FlagLegacyProtocol)
+				break
+			}
+		}
+		if p.Plaintext > 0 {
+			p.Flags = append(p.Flags, FlagPlaintext)
+		}
+		if len(p.Flags) > 0 {
+			report.Flagged++
+		} else if opts.FlaggedOnly {
+			continue
+		}
+		report.Peers = append(report.Peers, *p)
+	}
+	sort.Slice(report.Peers, func(i, j int) bool {
+		a, b := report.Peers[i], report.Peers[j]
+		if (len(a.Flags) > 0) != (len(b.Flags) > 0) {
+			return len(a.Flags) > 0
+		}
+		return a.Sessions > b.Sessions
+	})
+	if opts.Limit > 0 && len(report.Peers) > opts.Limit {
+		report.Peers = report.Peers[:opts.Limit]
+	}
+
+	return report, nil
+}
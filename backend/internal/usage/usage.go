@@ -0,0 +1,428 @@
+// Package usage meters what each tenant uses per calendar month, for
+// billing: messages relayed, mailbox storage and mailboxes provisioned. It
+// notifies a webhook when a tenant's usage crosses one of its thresholds.
+package usage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/logs"
+	"github.com/postfixrelay/postfixrelay/internal/queuetrack"
+	"github.com/rs/zerolog/log"
+)
+
+// Metrics a tenant is metered and can have thresholds on
+const (
+	MetricMessages  = "messages"      // messages relayed in the month
+	MetricStorage   = "storage_bytes" // peak mailbox storage in the month
+	MetricMailboxes = "mailboxes"     // peak mailboxes provisioned in the month
+)
+
+// Metrics lists the metrics in export order
+var Metrics = []string{MetricMessages, MetricStorage, MetricMailboxes}
+
+// MonthFormat is the layout of the month a usage row is for
+const MonthFormat = "2006-01"
+
+// messageTTL is how long a queue ID's sender is remembered without a
+// delivery before it is forgotten
+const messageTTL = 24 * time.Hour
+
+// sampleInterval is how often storage and mailboxes are sampled and
+// thresholds are checked
+const sampleInterval = time.Hour
+
+// metered is kept per queued message
+type metered struct {
+	counted bool // the message has been counted for its tenant
+}
+
+// Meter consumes mail log entries and counts each message relayed for a
+// tenant once, on its first successful delivery. A message belongs to the
+// tenant of its sender's domain, or failing that of its recipient's.
+type Meter struct {
+	db     *sql.DB
+	client *http.Client
+
+	mu       sync.Mutex
+	messages *queuetrack.Tracker[metered]
+	pending  map[int64]int    // messages relayed by tenant, not yet flushed
+	domains  map[string]int64 // mail domain to tenant
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewMeter creates a meter
+func NewMeter(db *sql.DB) *Meter {
+	m := &Meter{
+		db:       db,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		messages: queuetrack.New[metered](messageTTL),
+		pending:  make(map[int64]int),
+		domains:  make(map[string]int64),
+		stopCh:   make(chan struct{}),
+	}
+	m.loadDomains()
+	return m
+}
+
+// Start begins flushing counts and sampling usage
+func (m *Meter) Start() {
+	m.done = make(chan struct{})
+	go m.loop()
+}
+
+// Stop flushes pending counts and stops the meter
+func (m *Meter) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopCh)
+		if m.done != nil {
+			<-m.done
+		}
+	})
+}
+
+func (m *Meter) loop() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	lastSample := time.Time{}
+
+	for {
+		select {
+		case <-m.stopCh:
+			m.flush()
+			return
+		case now := <-ticker.C:
+			m.flush()
+			m.loadDomains()
+			if now.Sub(lastSample) >= sampleInterval {
+				m.sample(now)
+				m.checkThresholds(now)
+				lastSample = now
+			}
+		}
+	}
+}
+
+// loadDomains refreshes which tenant each mail domain belongs to
+func (m *Meter) loadDomains() {
+	rows, err := m.db.Query(`SELECT LOWER(domain), tenant_id FROM mail_domains WHERE tenant_id IS NOT NULL`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	domains := make(map[string]int64)
+	for rows.Next() {
+		var domain string
+		var tenant int64
+		if rows.Scan(&domain, &tenant) == nil {
+			domains[domain] = tenant
+		}
+	}
+
+	m.mu.Lock()
+	m.domains = domains
+	m.mu.Unlock()
+}
+
+// Consume follows one log entry. Entries without a queue ID are ignored.
+func (m *Meter) Consume(e logs.Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msg := m.messages.Track(e)
+	if msg == nil || msg.Data.counted || e.Status != "sent" || e.MailTo == "" {
+		return
+	}
+	tenant := m.domains[queuetrack.DomainOf(msg.Sender)]
+	if tenant == 0 {
+		tenant = m.domains[queuetrack.DomainOf(e.MailTo)]
+	}
+	if tenant != 0 {
+		m.pending[tenant]++
+		msg.Data.counted = true
+	}
+}
+
+// flush adds pending message counts to the current month and forgets
+// messages that have gone quiet
+func (m *Meter) flush() {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = make(map[int64]int)
+	m.messages.Expire(time.Now())
+	m.mu.Unlock()
+
+	month := time.Now().UTC().Format(MonthFormat)
+	for tenant, n := range pending {
+		_, err := m.db.Exec(`
+			INSERT INTO tenant_usage (tenant_id, month, messages, updated_at)
+			VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(tenant_id, month) DO UPDATE SET
+				messages = messages + excluded.messages,
+				updated_at = CURRENT_TIMESTAMP
+		`, tenant, month, n)
+		if err != nil {
+			log.Error().Err(err).Int64("tenant", tenant).Msg("Failed to store tenant usage")
+		}
+	}
+}
+
+// sample records each tenant's mailbox storage and mailbox count, keeping
+// the month's peak
+func (m *Meter) sample(now time.Time) {
+	_, err := m.db.Exec(`
+		INSERT INTO tenant_usage (tenant_id, month, storage_bytes_peak, mailboxes_peak, updated_at)
+		SELECT d.tenant_id, ?, COALESCE(SUM(q.bytes_used), 0), COUNT(mb.id), CURRENT_TIMESTAMP
+		FROM mail_domains d
+		LEFT JOIN mailboxes mb ON mb.domain_id = d.id
+		LEFT JOIN mailbox_quota q ON q.mailbox_id = mb.id
+		WHERE d.tenant_id IS NOT NULL
+		GROUP BY d.tenant_id
+		ON CONFLICT(tenant_id, month) DO UPDATE SET
+			storage_bytes_peak = MAX(storage_bytes_peak, excluded.storage_bytes_peak),
+			mailboxes_peak = MAX(mailboxes_peak, excluded.mailboxes_peak),
+			updated_at = CURRENT_TIMESTAMP
+	`, now.UTC().Format(MonthFormat))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to sample tenant usage")
+	}
+}
+
+// Crossing is the webhook payload sent when a tenant's usage reaches one
+// of its thresholds
+type Crossing struct {
+	Event      string    `json:"event"`
+	TenantID   int64     `json:"tenantId"`
+	TenantSlug string    `json:"tenantSlug"`
+	Month      string    `json:"month"`
+	Metric     string    `json:"metric"`
+	Threshold  int64     `json:"threshold"`
+	Value      int64     `json:"value"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+// checkThresholds notifies each threshold the current month's usage has
+// reached. A threshold is notified once per month.
+func (m *Meter) checkThresholds(now time.Time) {
+	month := now.UTC().Format(MonthFormat)
+	rows, err := m.db.Query(`
+		SELECT t.tenant_id, tn.slug, t.metric, t.threshold,
+			CASE t.metric
+				WHEN 'messages' THEN COALESCE(u.messages, 0)
+				WHEN 'storage_bytes' THEN COALESCE(u.storage_bytes_peak, 0)
+				ELSE COALESCE(u.mailboxes_peak, 0)
+			END
+		FROM tenant_usage_thresholds t
+		JOIN tenants tn ON tn.id = t.tenant_id
+		LEFT JOIN tenant_usage u ON u.tenant_id = t.tenant_id AND u.month = ?
+		WHERE NOT EXISTS (
+			SELECT 1 FROM tenant_usage_notifications n
+			WHERE n.tenant_id = t.tenant_id AND n.month = ? AND n.metric = t.metric AND n.threshold = t.threshold
+		)
+	`, month, month)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to check usage thresholds")
+		return
+	}
+	var crossed []Crossing
+	for rows.Next() {
+		c := Crossing{Event: "usage.threshold_crossed", Month: month, OccurredAt: now.UTC()}
+		if rows.Scan(&c.TenantID, &c.TenantSlug, &c.Metric, &c.Threshold, &c.Value) == nil && c.Value >= c.Threshold {
+			crossed = append(crossed, c)
+		}
+	}
+	rows.Close()
+
+	for _, c := range crossed {
+		if err := m.notify(c); err != nil {
+			log.Warn().Err(err).Int64("tenant", c.TenantID).Str("metric", c.Metric).Msg("Failed to send usage webhook")
+			continue
+		}
+		m.db.Exec(`
+			INSERT OR IGNORE INTO tenant_usage_notifications (tenant_id, month, metric, threshold)
+			VALUES (?, ?, ?, ?)
+		`, c.TenantID, c.Month, c.Metric, c.Threshold)
+	}
+}
+
+// notify posts a crossing to usage_webhook_url. With usage_webhook_secret
+// set, the body is signed in X-Usage-Signature: sha256=<hex HMAC-SHA256>.
+// Without a URL there is nothing to send, and the crossing counts as
+// notified.
+func (m *Meter) notify(c Crossing) error {
+	var url, secret string
+	m.db.QueryRow(`SELECT value FROM settings WHERE key = 'usage_webhook_url'`).Scan(&url)
+	m.db.QueryRow(`SELECT value FROM settings WHERE key = 'usage_webhook_secret'`).Scan(&secret)
+	if url == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Usage-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Usage is one tenant's usage in one month
+type Usage struct {
+	TenantID         int64  `json:"tenantId"`
+	TenantName       string `json:"tenantName"`
+	TenantSlug       string `json:"tenantSlug"`
+	Month            string `json:"month"`
+	Messages         int64  `json:"messages"`
+	StorageBytesPeak int64  `json:"storageBytesPeak"`
+	MailboxesPeak    int64  `json:"mailboxesPeak"`
+}
+
+// Month returns every tenant's usage in a month, tenants without usage
+// included with zeros
+func Month(db *sql.DB, month string) ([]Usage, error) {
+	rows, err := db.Query(`
+		SELECT t.id, t.name, t.slug, COALESCE(u.messages, 0), COALESCE(u.storage_bytes_peak, 0), COALESCE(u.mailboxes_peak, 0)
+		FROM tenants t
+		LEFT JOIN tenant_usage u ON u.tenant_id = t.id AND u.month = ?
+		ORDER BY t.name
+	`, month)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []Usage{}
+	for rows.Next() {
+		u := Usage{Month: month}
+		if err := rows.Scan(&u.TenantID, &u.TenantName, &u.TenantSlug, &u.Messages, &u.StorageBytesPeak, &u.MailboxesPeak); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// History returns a tenant's usage in its most recent months, newest first
+func History(db *sql.DB, tenantID int64, months int) ([]Usage, error) {
+	rows, err := db.Query(`
+		SELECT t.id, t.name, t.slug, u.month, u.messages, u.storage_bytes_peak, u.mailboxes_peak
+		FROM tenant_usage u JOIN tenants t ON t.id = u.tenant_id
+		WHERE u.tenant_id = ?
+		ORDER BY u.month DESC LIMIT ?
+	`, tenantID, months)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []Usage{}
+	for rows.Next() {
+		var u Usage
+		if err := rows.Scan(&u.TenantID, &u.TenantName, &u.TenantSlug, &u.Month, &u.Messages, &u.StorageBytesPeak, &u.MailboxesPeak); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// CSV renders usage rows as a billing export
+func CSV(usage []Usage) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("tenant_id,tenant_slug,tenant_name,month,messages_relayed,storage_bytes_peak,mailboxes_peak\n")
+	for _, u := range usage {
+		fmt.Fprintf(&buf, "%d,%s,%s,%s,%d,%d,%d\n", u.TenantID, csvField(u.TenantSlug), csvField(u.TenantName),
+			u.Month, u.Messages, u.StorageBytesPeak, u.MailboxesPeak)
+	}
+	return buf.Bytes()
+}
+
+// csvField quotes a value containing a comma, quote or newline
+func csvField(s string) string {
+	if strings.ContainsAny(s, ",\"\r\n") {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return s
+}
+
+// Threshold is a usage level that notifies the webhook when reached
+type Threshold struct {
+	Metric    string `json:"metric"`
+	Threshold int64  `json:"threshold"`
+}
+
+// Thresholds returns a tenant's thresholds
+func Thresholds(db *sql.DB, tenantID int64) ([]Threshold, error) {
+	rows, err := db.Query(`
+		SELECT metric, threshold FROM tenant_usage_thresholds
+		WHERE tenant_id = ? ORDER BY metric, threshold
+	`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	thresholds := []Threshold{}
+	for rows.Next() {
+		var t Threshold
+		if err := rows.Scan(&t.Metric, &t.Threshold); err != nil {
+			return nil, err
+		}
+		thresholds = append(thresholds, t)
+	}
+	return thresholds, rows.Err()
+}
+
+// SetThresholds replaces a tenant's thresholds
+func SetThresholds(db *sql.DB, tenantID int64, thresholds []Threshold) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM tenant_usage_thresholds WHERE tenant_id = ?`, tenantID); err != nil {
+		return err
+	}
+	for _, t := range thresholds {
+		if _, err := tx.Exec(`
+			INSERT OR IGNORE INTO tenant_usage_thresholds (tenant_id, metric, threshold) VALUES (?, ?, ?)
+		`, tenantID, t.Metric, t.Threshold); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
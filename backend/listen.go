@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/postfixrelay/postfixrelay/internal/config"
+	"github.com/rs/zerolog/log"
+)
+
+// listenFDsStart is the first file descriptor systemd passes to a
+// socket-activated service (SD_LISTEN_FDS_START)
+const listenFDsStart = 3
+
+// listen opens the API listener: the socket systemd passed in, a unix
+// domain socket for a "unix:" listen_addr, or else TCP
+func listen(cfg *config.Config) (net.Listener, error) {
+	if ln, err := systemdListener(); ln != nil || err != nil {
+		return ln, err
+	}
+
+	path, ok := cfg.ListenSocket()
+	if !ok {
+		return net.Listen("tcp", cfg.ListenAddr)
+	}
+
+	mode, err := cfg.SocketMode()
+	if err != nil {
+		return nil, err
+	}
+	// A socket left behind by an unclean exit would make Listen fail.
+	// Only sockets are removed, never a file that happens to be there.
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set the mode of %s: %w", path, err)
+	}
+	return ln, nil
+}
+
+// systemdListener returns the first socket passed by systemd socket
+// activation, or nil when the process wasn't socket-activated
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, errors.New("socket activation passed no sockets (LISTEN_FDS)")
+	}
+	// Keep the sockets from being passed on to processes we start
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if n > 1 {
+		log.Warn().Int("sockets", n).Msg("Socket activation passed several sockets; serving on the first")
+	}
+	f := os.NewFile(uintptr(listenFDsStart), "LISTEN_FD_3")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("socket activation: %w", err)
+	}
+	return ln, nil
+}
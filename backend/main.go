@@ -3,16 +3,22 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/postfixrelay/postfixrelay/internal/api"
 	"github.com/postfixrelay/postfixrelay/internal/config"
 	"github.com/postfixrelay/postfixrelay/internal/database"
+	"github.com/postfixrelay/postfixrelay/internal/demo"
 	"github.com/postfixrelay/postfixrelay/internal/dovecot"
+	"github.com/postfixrelay/postfixrelay/internal/hooks"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -20,7 +26,23 @@ import (
 func main() {
 	// CLI flags
 	syncOnly := flag.Bool("sync", false, "Run mail config sync and exit")
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "Path to YAML config file (env vars override file values)")
+	validateOnly := flag.Bool("validate-config", false, "Validate configuration and exit")
+	demoMode := flag.Bool("demo", false, "Seed sample data and serve a made-up mail queue, for evaluation without a mail server")
+	migrateCmd := flag.String("migrate", "", "Run a schema migration command and exit: status, up, down [steps] or force <version>")
+	demoQueueSize := flag.Int("demo-queue-size", 0, "Messages in the demo queue, e.g. 50000 for load tests (default a dozen)")
 	flag.Parse()
+
+	// Handle validate-only mode before any logging setup so the output is plain
+	if *validateOnly {
+		if _, err := config.LoadFile(*configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("configuration OK")
+		return
+	}
+
 	// Initialize logger
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	if os.Getenv("LOG_FORMAT") != "json" {
@@ -43,7 +65,7 @@ func main() {
 	log.Info().Msg("Starting PostfixRelay server")
 
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.LoadFile(*configFile)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to load configuration")
 	}
@@ -55,15 +77,34 @@ func main() {
 	}
 	defer db.Close()
 
+	// Handle migrate mode before the automatic migrations run
+	if *migrateCmd != "" {
+		if err := runMigrate(db, *migrateCmd, flag.Args()); err != nil {
+			log.Fatal().Err(err).Msg("Migration command failed")
+		}
+		return
+	}
+
 	// Run migrations
 	if err := db.Migrate(); err != nil {
 		log.Fatal().Err(err).Msg("Failed to run database migrations")
 	}
 
+	// Seed sample data for evaluation
+	if *demoMode {
+		cfg.Demo = true
+		cfg.DemoQueueSize = *demoQueueSize
+		log.Warn().Msg("Demo mode: serving sample data, not a real mail server")
+		if err := demo.Seed(db.DB, filepath.Join(filepath.Dir(cfg.DBPath), "demo"), time.Now()); err != nil {
+			log.Fatal().Err(err).Msg("Failed to seed demo data")
+		}
+	}
+
 	// Handle sync-only mode
 	if *syncOnly {
 		log.Info().Msg("Running mail configuration sync...")
 		syncer := dovecot.NewSyncer(db.DB, dovecot.DefaultConfig())
+		syncer.SetAliasHook(hooks.NewRunner(db.DB, cfg.HooksDir).ResolveAliases)
 		if err := syncer.SyncAll(); err != nil {
 			log.Fatal().Err(err).Msg("Sync failed")
 		}
@@ -86,13 +127,37 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	redirectServer, err := setupTLS(cfg, httpServer)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up TLS")
+	}
+
+	ln, err := listen(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Str("addr", cfg.ListenAddr).Msg("Failed to listen")
+	}
+
 	// Start server in goroutine
 	go func() {
-		log.Info().Str("addr", cfg.ListenAddr).Msg("Server listening")
-		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Info().Str("addr", ln.Addr().String()).Bool("tls", httpServer.TLSConfig != nil).Msg("Server listening")
+		var err error
+		if httpServer.TLSConfig != nil {
+			err = httpServer.ServeTLS(ln, "", "")
+		} else {
+			err = httpServer.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatal().Err(err).Msg("Server failed")
 		}
 	}()
+	if redirectServer != nil {
+		go func() {
+			log.Info().Str("addr", redirectServer.Addr).Msg("Redirecting HTTP to HTTPS")
+			if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatal().Err(err).Msg("HTTP redirect server failed")
+			}
+		}()
+	}
 
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
@@ -101,6 +166,9 @@ func main() {
 
 	log.Info().Msg("Shutting down server...")
 
+	// Tell streaming clients we're going away so Shutdown isn't held open by them
+	server.Drain()
+
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -108,6 +176,66 @@ func main() {
 	if err := httpServer.Shutdown(ctx); err != nil {
 		log.Error().Err(err).Msg("Server forced to shutdown")
 	}
+	if redirectServer != nil {
+		redirectServer.Shutdown(ctx)
+	}
+
+	// Stop background subsystems before the database is closed
+	server.Close()
 
 	log.Info().Msg("Server stopped")
 }
+
+// runMigrate runs a -migrate command against the database
+func runMigrate(db *database.DB, cmd string, args []string) error {
+	switch cmd {
+	case "status":
+		statuses, err := db.MigrationStatuses()
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATE")
+		for _, st := range statuses {
+			state := "pending"
+			switch {
+			case st.Dirty:
+				state = "dirty"
+			case st.Applied:
+				state = "applied " + st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", st.Version, st.Name, state)
+		}
+		return tw.Flush()
+
+	case "up":
+		ran, err := db.MigrateUp()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applied %d migrations\n", ran)
+		return nil
+
+	case "down":
+		steps := 1
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[0])
+			}
+			steps = n
+		}
+		return db.MigrateDown(steps)
+
+	case "force":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: -migrate force <version>")
+		}
+		version, err := strconv.Atoi(args[0])
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[0])
+		}
+		return db.ForceVersion(version)
+	}
+	return fmt.Errorf("unknown migrate command %q: use status, up, down [steps] or force <version>", cmd)
+}
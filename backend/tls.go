@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/postfixrelay/postfixrelay/internal/config"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// setupTLS sets httpServer up to serve HTTPS when the configuration asks
+// for it. It returns the server for http_redirect_addr, which redirects
+// plain HTTP to HTTPS and answers ACME http-01 challenges, or nil.
+func setupTLS(cfg *config.Config, httpServer *http.Server) (*http.Server, error) {
+	if !cfg.TLSEnabled() {
+		return nil, nil
+	}
+
+	var redirect http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, httpsURL(cfg.ListenAddr, r), http.StatusPermanentRedirect)
+	})
+
+	if cfg.TLSCertFile != "" {
+		certs := &certReloader{certFile: cfg.TLSCertFile, keyFile: cfg.TLSKeyFile}
+		if _, err := certs.load(); err != nil {
+			return nil, err
+		}
+		httpServer.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+	} else {
+		cacheDir := cfg.ACMECacheDir
+		if cacheDir == "" {
+			cacheDir = filepath.Join(filepath.Dir(cfg.DBPath), "acme")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cacheDir),
+			HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+			Email:      cfg.ACMEEmail,
+		}
+		if cfg.ACMEDirectoryURL != "" {
+			m.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectoryURL}
+		}
+		// Answers tls-alpn-01 challenges on the HTTPS listener as well
+		httpServer.TLSConfig = m.TLSConfig()
+		redirect = m.HTTPHandler(redirect)
+		log.Info().Strs("domains", cfg.ACMEDomains).Str("cache", cacheDir).Msg("Certificates from ACME")
+	}
+	httpServer.TLSConfig.MinVersion = tls.VersionTLS12
+
+	if cfg.HTTPRedirectAddr == "" {
+		return nil, nil
+	}
+	return &http.Server{
+		Addr:         cfg.HTTPRedirectAddr,
+		Handler:      redirect,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}, nil
+}
+
+// httpsURL is r's URL on the HTTPS listener at listenAddr
+func httpsURL(listenAddr string, r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if _, port, err := net.SplitHostPort(listenAddr); err == nil && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	return "https://" + host + r.URL.RequestURI()
+}
+
+// certReloader serves a certificate from files, reading them again when
+// they change so renewals need no restart
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// load reads the certificate if the files changed since the last read
+func (c *certReloader) load() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	modTime := time.Time{}
+	for _, f := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	if c.cert != nil && !modTime.After(c.modTime) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if c.cert != nil {
+		log.Info().Str("cert", c.certFile).Msg("Reloaded TLS certificate")
+	}
+	c.cert, c.modTime = &cert, modTime
+	return c.cert, nil
+}
+
+// GetCertificate serves the current certificate, or the last good one
+// when the files can't be read
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := c.load()
+	if err != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.cert != nil {
+			log.Warn().Err(err).Str("cert", c.certFile).Msg("Failed to reload TLS certificate")
+			return c.cert, nil
+		}
+		return nil, err
+	}
+	return cert, nil
+}
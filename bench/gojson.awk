@@ -0,0 +1,39 @@
+# Turns "go test -bench" output into the JSON report bench/run.sh writes
+# to go.json, one result per benchmark:
+#
+#   {"name": "BenchmarkParseMailq", "iterations": 20, "nsPerOp": 55012345,
+#    "bytesPerOp": 1234, "allocsPerOp": 12, "metrics": {"entries/s": 2.1e+05}}
+#
+# Set version and goversion with -v; they are recorded with the results.
+
+/^goos:/ { goos = $2 }
+/^goarch:/ { goarch = $2 }
+
+/^Benchmark/ && $4 == "ns/op" {
+	name = $1
+	sub(/-[0-9]+$/, "", name) # the GOMAXPROCS suffix
+	result = sprintf("{\"name\": \"%s\", \"iterations\": %d", name, $2)
+	metrics = ""
+	for (i = 3; i < NF; i += 2) {
+		unit = $(i + 1)
+		if (unit == "ns/op")
+			result = result sprintf(", \"nsPerOp\": %.0f", $i)
+		else if (unit == "B/op")
+			result = result sprintf(", \"bytesPerOp\": %.0f", $i)
+		else if (unit == "allocs/op")
+			result = result sprintf(", \"allocsPerOp\": %.0f", $i)
+		else
+			metrics = metrics (metrics == "" ? "" : ", ") sprintf("\"%s\": %s", unit, $i)
+	}
+	if (metrics != "")
+		result = result ", \"metrics\": {" metrics "}"
+	results[n++] = result "}"
+}
+
+END {
+	printf "{\n  \"version\": \"%s\",\n  \"goVersion\": \"%s\",\n  \"os\": \"%s\",\n  \"arch\": \"%s\",\n  \"results\": [\n",
+		version, goversion, goos, goarch
+	for (i = 0; i < n; i++)
+		printf "    %s%s\n", results[i], (i < n - 1 ? "," : "")
+	print "  ]\n}"
+}
@@ -0,0 +1,84 @@
+// Load scenario for the API: operators watching the dashboard and paging
+// through a large mail queue. Run against a demo instance so the numbers
+// don't depend on a mail server:
+//
+//   postfixrelay -demo -demo-queue-size 50000
+//   k6 run -e BASE_URL=http://127.0.0.1:8080 bench/k6/api.js
+//
+// bench/run.sh does both and collects the results with the Go benchmarks.
+import http from 'k6/http';
+import { check, fail } from 'k6';
+
+const BASE_URL = __ENV.BASE_URL || 'http://127.0.0.1:8080';
+const USERNAME = __ENV.BENCH_USERNAME || 'bench';
+const PASSWORD = __ENV.BENCH_PASSWORD || 'bench-password-1234';
+
+export const options = {
+  scenarios: {
+    dashboard: {
+      executor: 'constant-vus',
+      exec: 'dashboard',
+      vus: 10,
+      duration: __ENV.DURATION || '1m',
+    },
+    queue: {
+      executor: 'constant-vus',
+      exec: 'queue',
+      vus: 5,
+      duration: __ENV.DURATION || '1m',
+    },
+  },
+  // A run that misses these fails, which is what catches regressions
+  thresholds: {
+    'http_req_failed': ['rate<0.01'],
+    'http_req_duration{endpoint:dashboard}': ['p(95)<250'],
+    'http_req_duration{endpoint:queue_summary}': ['p(95)<500'],
+    'http_req_duration{endpoint:queue_messages}': ['p(95)<2000'],
+  },
+  summaryTrendStats: ['avg', 'min', 'med', 'p(90)', 'p(95)', 'p(99)', 'max'],
+};
+
+// setup creates the admin account on a fresh instance, logs in and hands
+// the session token to the virtual users, which send it as a bearer token
+export function setup() {
+  const csrf = http.get(`${BASE_URL}/api/v1/csrf-token`);
+  const headers = {
+    'Content-Type': 'application/json',
+    'X-CSRF-Token': csrf.headers['X-Csrf-Token'],
+  };
+  const credentials = JSON.stringify({ username: USERNAME, password: PASSWORD });
+
+  const status = http.get(`${BASE_URL}/api/v1/setup/status`).json();
+  if (status.setupRequired) {
+    const res = http.post(`${BASE_URL}/api/v1/setup/complete`,
+      JSON.stringify({ username: USERNAME, email: `${USERNAME}@demo.example`, password: PASSWORD }), { headers });
+    if (res.status >= 300) {
+      fail(`setup failed: ${res.status} ${res.body}`);
+    }
+  }
+
+  const res = http.post(`${BASE_URL}/api/v1/auth/login`, credentials, { headers });
+  const session = res.cookies.session && res.cookies.session[0];
+  if (res.status !== 200 || !session) {
+    fail(`login failed: ${res.status} ${res.body}`);
+  }
+  return { token: session.value };
+}
+
+function get(path, endpoint, token) {
+  const res = http.get(`${BASE_URL}/api/v1${path}`, {
+    headers: { Authorization: `Bearer ${token}` },
+    tags: { endpoint },
+  });
+  check(res, { [`${endpoint} 200`]: (r) => r.status === 200 });
+  return res;
+}
+
+export function dashboard(data) {
+  get('/dashboard', 'dashboard', data.token);
+}
+
+export function queue(data) {
+  get('/queue', 'queue_summary', data.token);
+  get('/queue/messages?status=deferred', 'queue_messages', data.token);
+}
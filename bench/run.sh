@@ -0,0 +1,58 @@
+#!/bin/sh
+# Runs the benchmark suite and writes the results to a directory:
+#
+#   go.txt     Go benchmarks (go test -bench): log ingest, 50k queue
+#   go.json    the same as JSON, for comparing releases
+#   k6.json    k6 summary of the API scenario against a demo instance
+#              holding a 50k message queue
+#
+# Usage: bench/run.sh [output dir]    (default bench/results/<git describe>)
+#
+# Needs Go and k6 on PATH. DURATION sets the length of the k6 run (1m).
+set -eu
+
+root=$(cd "$(dirname "$0")/.." && pwd)
+version=$(git -C "$root" describe --tags --always --dirty 2>/dev/null || echo dev)
+out=${1:-$root/bench/results/$version}
+addr=${BENCH_ADDR:-127.0.0.1:18080}
+mkdir -p "$out"
+work=$(mktemp -d)
+trap 'kill "$pid" 2>/dev/null || true; rm -rf "$work"' EXIT
+pid=
+
+echo "Building postfixrelay $version"
+(cd "$root/backend" && go build \
+	-ldflags "-X github.com/postfixrelay/postfixrelay/internal/about.Version=$version" \
+	-o "$work/postfixrelay" .)
+
+echo "Running Go benchmarks"
+if ! (cd "$root/backend" && go test -run '^$' -bench . -benchmem ./...) > "$out/go.txt"; then
+	cat "$out/go.txt" >&2
+	exit 1
+fi
+cat "$out/go.txt"
+awk -v version="$version" -v goversion="$(cd "$root/backend" && go env GOVERSION)" \
+	-f "$root/bench/gojson.awk" "$out/go.txt" > "$out/go.json"
+
+echo "Starting demo instance on $addr"
+secret=$(head -c 32 /dev/urandom | od -An -tx1 | tr -d ' \n')
+LISTEN_ADDR=$addr DB_PATH=$work/data/bench.db APP_SECRET=$secret DB_ENCRYPTION_KEY=$secret \
+	POSTFIX_CONFIG_DIR=$work/postfix LOG_LEVEL=warn \
+	"$work/postfixrelay" -demo -demo-queue-size 50000 > "$work/server.log" 2>&1 &
+pid=$!
+i=0
+until curl -sf "http://$addr/healthz" > /dev/null; do
+	i=$((i + 1))
+	if [ $i -gt 60 ] || ! kill -0 "$pid" 2>/dev/null; then
+		cat "$work/server.log" >&2
+		echo "demo instance did not start" >&2
+		exit 1
+	fi
+	sleep 1
+done
+
+echo "Running k6 API scenario"
+k6 run --quiet -e BASE_URL="http://$addr" -e DURATION="${DURATION:-1m}" \
+	--summary-export "$out/k6.json" "$root/bench/k6/api.js"
+
+echo "Results in $out"
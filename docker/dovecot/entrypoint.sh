@@ -23,5 +23,11 @@ if [ ! -f /etc/dovecot/passwd ]; then
     chmod 600 /etc/dovecot/passwd
 fi
 
+# Create app password slot files until the backend first syncs them
+mkdir -p /etc/dovecot/app-passwords
+for slot in 0 1 2 3 4; do
+    [ -f /etc/dovecot/app-passwords/slot$slot ] || touch /etc/dovecot/app-passwords/slot$slot
+done
+
 echo "Dovecot starting..."
 exec "$@"
@@ -9,6 +9,7 @@ import { AdminLoginPage } from '@/pages/AdminLoginPage';
 import { SetupPage } from '@/pages/SetupPage';
 import { Toaster } from '@/components/ui/toaster';
 import { initCSRF, setupApi } from '@/lib/api';
+import { useBrandingStore } from '@/stores/branding';
 import { MailProtectedRoute, AdminProtectedRoute } from '@/components/auth';
 
 // Lazy load pages for better performance
@@ -18,6 +19,8 @@ const ConfigPage = lazy(() => import('@/pages/ConfigPage').then(m => ({ default:
 const LogsPage = lazy(() => import('@/pages/LogsPage').then(m => ({ default: m.LogsPage })));
 const AlertsPage = lazy(() => import('@/pages/AlertsPage').then(m => ({ default: m.AlertsPage })));
 const QueuePage = lazy(() => import('@/pages/QueuePage').then(m => ({ default: m.QueuePage })));
+const TracePage = lazy(() => import('@/pages/TracePage').then(m => ({ default: m.TracePage })));
+const CapturedMailPage = lazy(() => import('@/pages/CapturedMailPage').then(m => ({ default: m.CapturedMailPage })));
 const AuditPage = lazy(() => import('@/pages/AuditPage').then(m => ({ default: m.AuditPage })));
 const SettingsPage = lazy(() => import('@/pages/SettingsPage').then(m => ({ default: m.SettingsPage })));
 const SetupWizardPage = lazy(() => import('@/pages/SetupWizardPage').then(m => ({ default: m.SetupWizardPage })));
@@ -97,9 +100,10 @@ function SetupGuard({ children }: { children: React.ReactNode }) {
 }
 
 export default function App() {
-  // Initialize CSRF token on app mount
+  // Initialize CSRF token and branding on app mount
   useEffect(() => {
     initCSRF();
+    useBrandingStore.getState().load();
   }, []);
 
   return (
@@ -273,6 +277,22 @@ export default function App() {
                 </Suspense>
               }
             />
+            <Route
+              path="/admin/relay/trace"
+              element={
+                <Suspense fallback={<PageLoader />}>
+                  <TracePage />
+                </Suspense>
+              }
+            />
+            <Route
+              path="/admin/relay/captured"
+              element={
+                <Suspense fallback={<PageLoader />}>
+                  <CapturedMailPage />
+                </Suspense>
+              }
+            />
             <Route
               path="/admin/relay/audit"
               element={
@@ -1,10 +1,23 @@
 import { Outlet } from 'react-router-dom';
+import { useQuery } from '@tanstack/react-query';
+import { setupApi } from '@/lib/api';
 import { TopNav } from './TopNav';
 import { SideNav } from './SideNav';
 
 export function Layout() {
+  const { data: setupStatus } = useQuery({
+    queryKey: ['setup-status'],
+    queryFn: setupApi.getStatus,
+    staleTime: 5 * 60 * 1000,
+  });
+
   return (
     <div className="min-h-screen bg-background">
+      {setupStatus?.demo && (
+        <div className="bg-yellow-100 text-yellow-800 text-sm text-center py-1">
+          Demo mode: the domains, logs, queue and alerts shown are sample data, not a real mail server
+        </div>
+      )}
       <TopNav />
       <div className="flex">
         <SideNav />
@@ -20,6 +20,8 @@ interface Module {
   path: string;
   color: string;
   adminOnly?: boolean;
+  // platformOnly modules are hidden from tenant users
+  platformOnly?: boolean;
 }
 
 const modules: Module[] = [
@@ -30,6 +32,7 @@ const modules: Module[] = [
     icon: <Send className="h-6 w-6" />,
     path: '/relay',
     color: 'bg-blue-500',
+    platformOnly: true,
   },
   {
     id: 'admin',
@@ -58,8 +61,11 @@ export function ModuleSwitcher() {
   const location = useLocation();
   const currentModule = getCurrentModule(location.pathname);
   const canEdit = useAuthStore((state) => state.canEdit());
+  const isTenantUser = useAuthStore((state) => !!state.user?.tenantId);
 
-  const availableModules = modules.filter((m) => !m.adminOnly || canEdit);
+  const availableModules = modules.filter(
+    (m) => (!m.adminOnly || canEdit) && (!m.platformOnly || !isTenantUser)
+  );
 
   return (
     <DropdownMenu>
@@ -20,6 +20,8 @@ import {
   Star,
   Trash2,
   Archive,
+  FlaskConical,
+  Route,
 } from 'lucide-react';
 import { cn } from '@/lib/utils';
 import { useAuthStore } from '@/stores/auth';
@@ -30,6 +32,8 @@ interface NavItem {
   label: string;
   end?: boolean;
   adminOnly?: boolean;
+  // platformOnly items are hidden from tenant users
+  platformOnly?: boolean;
 }
 
 interface NavSection {
@@ -58,6 +62,8 @@ const relayNavSections: NavSection[] = [
       { to: '/relay/logs', icon: FileText, label: 'Mail Logs' },
       { to: '/relay/alerts', icon: AlertTriangle, label: 'Alerts' },
       { to: '/relay/queue', icon: Inbox, label: 'Queue' },
+      { to: '/relay/trace', icon: Route, label: 'Message Trace' },
+      { to: '/relay/captured', icon: FlaskConical, label: 'Captured Mail', adminOnly: true },
       { to: '/relay/audit', icon: ClipboardList, label: 'Audit Log' },
     ],
   },
@@ -89,7 +95,7 @@ const adminNavSections: NavSection[] = [
     title: 'System',
     items: [
       { to: '/admin/users', icon: Users, label: 'Admin Users' },
-      { to: '/settings', icon: Cog, label: 'Settings' },
+      { to: '/settings', icon: Cog, label: 'Settings', platformOnly: true },
     ],
   },
 ];
@@ -124,7 +130,7 @@ const suiteNavSections: NavSection[] = [
   {
     title: 'Modules',
     items: [
-      { to: '/relay', icon: Send, label: 'PSFXRelay' },
+      { to: '/relay', icon: Send, label: 'PSFXRelay', platformOnly: true },
       { to: '/admin', icon: Users, label: 'PSFXAdmin', adminOnly: true },
       { to: '/mail', icon: Mail, label: 'PSFXMail' },
     ],
@@ -132,7 +138,7 @@ const suiteNavSections: NavSection[] = [
   {
     title: 'System',
     items: [
-      { to: '/settings', icon: Cog, label: 'Settings' },
+      { to: '/settings', icon: Cog, label: 'Settings', platformOnly: true },
     ],
   },
 ];
@@ -160,6 +166,7 @@ function getNavSections(module: string): NavSection[] {
 export function SideNav() {
   const location = useLocation();
   const canEdit = useAuthStore((state) => state.canEdit());
+  const isTenantUser = useAuthStore((state) => !!state.user?.tenantId);
   const currentModule = getCurrentModule(location.pathname);
   const navSections = getNavSections(currentModule);
 
@@ -175,7 +182,7 @@ export function SideNav() {
             )}
             <ul className="space-y-1">
               {section.items
-                .filter((item) => !item.adminOnly || canEdit)
+                .filter((item) => (!item.adminOnly || canEdit) && (!item.platformOnly || !isTenantUser))
                 .map((item) => (
                   <li key={item.to}>
                     <NavLink
@@ -0,0 +1,24 @@
+import { useBrandingStore } from '@/stores/branding';
+
+// SupportContact shows the deployment's support contact, if one is set
+export function SupportContact({ className }: { className?: string }) {
+  const { supportEmail, supportUrl } = useBrandingStore((state) => state.branding);
+  if (!supportEmail && !supportUrl) return null;
+
+  return (
+    <p className={className}>
+      Need help?{' '}
+      {supportUrl && (
+        <a href={supportUrl} target="_blank" rel="noopener noreferrer" className="hover:underline font-medium">
+          Contact support
+        </a>
+      )}
+      {supportUrl && supportEmail && ' or '}
+      {supportEmail && (
+        <a href={`mailto:${supportEmail}`} className="hover:underline font-medium">
+          {supportEmail}
+        </a>
+      )}
+    </p>
+  );
+}
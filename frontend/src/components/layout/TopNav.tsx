@@ -10,6 +10,7 @@ import {
 } from '@/components/ui/dropdown-menu';
 import { useAuthStore } from '@/stores/auth';
 import { useAlertsStore } from '@/stores/alerts';
+import { useBrandingStore } from '@/stores/branding';
 import { authApi } from '@/lib/api';
 import { ThemeToggle } from '@/components/ui/theme-toggle';
 import { ModuleSwitcher, ModuleIndicator } from '@/components/layout/ModuleSwitcher';
@@ -17,6 +18,7 @@ import { ModuleSwitcher, ModuleIndicator } from '@/components/layout/ModuleSwitc
 export function TopNav() {
   const { user, logout } = useAuthStore();
   const firingCount = useAlertsStore((state) => state.firingCount);
+  const branding = useBrandingStore((state) => state.branding);
 
   const handleLogout = async () => {
     try {
@@ -32,22 +34,26 @@ export function TopNav() {
         <ModuleSwitcher />
 
         <Link to="/" className="flex items-center space-x-2">
-          <div className="flex h-8 w-8 items-center justify-center rounded-lg bg-gradient-to-br from-blue-500 via-purple-500 to-green-500">
-            <svg
-              xmlns="http://www.w3.org/2000/svg"
-              viewBox="0 0 24 24"
-              fill="none"
-              stroke="white"
-              strokeWidth="2"
-              strokeLinecap="round"
-              strokeLinejoin="round"
-              className="h-5 w-5"
-            >
-              <path d="M4 4h16c1.1 0 2 .9 2 2v12c0 1.1-.9 2-2 2H4c-1.1 0-2-.9-2-2V6c0-1.1.9-2 2-2z" />
-              <polyline points="22,6 12,13 2,6" />
-            </svg>
-          </div>
-          <span className="font-bold text-lg">PSFX Suite</span>
+          {branding.logoUrl ? (
+            <img src={branding.logoUrl} alt="" className="h-8 max-w-[8rem] object-contain" />
+          ) : (
+            <div className="flex h-8 w-8 items-center justify-center rounded-lg bg-gradient-to-br from-blue-500 via-purple-500 to-green-500">
+              <svg
+                xmlns="http://www.w3.org/2000/svg"
+                viewBox="0 0 24 24"
+                fill="none"
+                stroke="white"
+                strokeWidth="2"
+                strokeLinecap="round"
+                strokeLinejoin="round"
+                className="h-5 w-5"
+              >
+                <path d="M4 4h16c1.1 0 2 .9 2 2v12c0 1.1-.9 2-2 2H4c-1.1 0-2-.9-2-2V6c0-1.1.9-2 2-2z" />
+                <polyline points="22,6 12,13 2,6" />
+              </svg>
+            </div>
+          )}
+          <span className="font-bold text-lg">{branding.productName}</span>
         </Link>
 
         <div className="ml-4">
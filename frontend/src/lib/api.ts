@@ -127,6 +127,75 @@ export interface LoginResponse {
   // Token is now stored in httpOnly cookie, not returned in response
 }
 
+// Tenants (platform admins) and the signed-in tenant user's own tenant
+export interface Tenant {
+  id: number;
+  name: string;
+  slug: string;
+  description: string;
+  active: boolean;
+  createdAt: string;
+  updatedAt: string;
+  domainCount: number;
+  userCount: number;
+}
+
+export const tenantsApi = {
+  list: () => api.get<Tenant[]>('/tenants'),
+  get: (id: number) => api.get<Tenant>(`/tenants/${id}`),
+  create: (data: { name: string; slug: string; description?: string }) => api.post<Tenant>('/tenants', data),
+  update: (id: number, data: { name: string; description?: string; active?: boolean }) =>
+    api.put<Tenant>(`/tenants/${id}`, data),
+  delete: (id: number) => api.delete(`/tenants/${id}`),
+  getSettings: (id: number) => api.get<{ settings: Record<string, string> }>(`/tenants/${id}/settings`),
+  updateSettings: (id: number, settings: Record<string, string>) => api.put(`/tenants/${id}/settings`, settings),
+  current: () => api.get<Tenant>('/tenant'),
+  currentSettings: () => api.get<{ settings: Record<string, string> }>('/tenant/settings'),
+  updateCurrentSettings: (settings: Record<string, string>) => api.put('/tenant/settings', settings),
+  usage: (id: number, months = 12) => api.get<TenantUsageReport>(`/tenants/${id}/usage?months=${months}`),
+  updateUsageThresholds: (id: number, thresholds: UsageThreshold[]) =>
+    api.put(`/tenants/${id}/usage/thresholds`, thresholds),
+  currentUsage: (months = 12) => api.get<TenantUsageReport>(`/tenant/usage?months=${months}`),
+  usageExportUrl: (month: string, format: 'csv' | 'json') =>
+    `${API_BASE}/tenants/usage?month=${encodeURIComponent(month)}&format=${format}`,
+};
+
+// Usage metering per tenant and month (YYYY-MM, UTC)
+export interface TenantUsage {
+  tenantId: number;
+  tenantName: string;
+  tenantSlug: string;
+  month: string;
+  messages: number;
+  storageBytesPeak: number;
+  mailboxesPeak: number;
+}
+
+export interface UsageThreshold {
+  metric: 'messages' | 'storage_bytes' | 'mailboxes';
+  threshold: number;
+}
+
+export interface TenantUsageReport {
+  usage: TenantUsage[];
+  thresholds: UsageThreshold[];
+}
+
+// Branding (public, see Settings > Branding)
+export interface Branding {
+  productName: string;
+  logoUrl?: string;
+  supportEmail?: string;
+  supportUrl?: string;
+  primaryColor?: string;
+  accentColor?: string;
+  defaultTheme: 'light' | 'dark' | 'system';
+}
+
+export const brandingApi = {
+  get: (tenant?: string) => api.get<Branding>(`/branding${tenant ? `?tenant=${encodeURIComponent(tenant)}` : ''}`),
+};
+
 export const authApi = {
   login: (data: LoginRequest) => api.post<LoginResponse>('/auth/login', data),
   logout: () => api.post('/auth/logout'),
@@ -136,6 +205,8 @@ export const authApi = {
 // Setup API - for initial admin user creation
 export interface SetupStatusResponse {
   setupRequired: boolean;
+  // Started with -demo: the data shown is made up
+  demo?: boolean;
 }
 
 export interface SetupRequest {
@@ -183,6 +254,36 @@ export const statusApi = {
   get: () => api.get<SystemStatus>('/status'),
 };
 
+// Release checks
+export interface Release {
+  version: string;
+  publishedAt: string;
+  url?: string;
+  notes?: string;
+  breaking?: string[];
+  migrations?: string[];
+  minUpgradeFrom?: string;
+}
+
+export interface UpdateAdvice {
+  enabled: boolean;
+  checkedAt?: string;
+  current: string;
+  latest?: string;
+  updateAvailable: boolean;
+  comparable: boolean;
+  newer?: Release[];
+  breaking?: string[];
+  migrations?: string[];
+  upgradePath?: string[];
+  error?: string;
+}
+
+export const updatesApi = {
+  get: () => api.get<UpdateAdvice>('/system/updates'),
+  check: () => api.post<UpdateAdvice>('/system/updates/check'),
+};
+
 // Config API
 export interface ConfigValue {
   key: string;
@@ -329,11 +430,28 @@ export const configApi = {
   deleteCertificate: (type: 'smtp' | 'smtpd') =>
     api.delete<void>(`/config/certificates/${type}`),
 
-  // SASL credentials management
-  saveCredentials: (data: { relayhost: string; username: string; password: string }) =>
+  // SASL credentials management. Sender credentials (an address or
+  // @domain) are used with sender-dependent authentication; leaving the
+  // password out of an update keeps the saved one.
+  getCredentials: () => api.get<CredentialsResponse>('/config/credentials'),
+  saveCredentials: (data: { relayhost?: string; sender?: string; username: string; password?: string }) =>
     api.post<void>('/config/credentials', data),
+  deleteCredentials: (key: string) =>
+    api.delete<void>(`/config/credentials/${encodeURIComponent(key)}`),
 };
 
+export interface SavedCredential {
+  key: string;
+  relayhost?: string;
+  sender?: string;
+  username: string;
+}
+
+export interface CredentialsResponse {
+  credentials: SavedCredential[];
+  senderDependentAuthentication: boolean;
+}
+
 // Logs API
 export interface LogEntry {
   id: number;
@@ -396,6 +514,14 @@ export interface Alert {
   acknowledgedBy?: string;
   resolvedAt?: string;
   context: Record<string, unknown>;
+  // Messages the alert is about, e.g. the latest bounces behind a bounce
+  // rate alert
+  references?: AlertReference[];
+}
+
+export interface AlertReference {
+  type: 'queue_id';
+  id: string;
 }
 
 export const alertsApi = {
@@ -420,6 +546,66 @@ export interface QueueMessage {
   reason?: string;
 }
 
+// Message traces by queue ID
+export interface MessageTrace {
+  queueId: string;
+  found: boolean;
+  from?: string;
+  to: string[];
+  status?: string;
+  firstSeen?: string;
+  lastSeen?: string;
+  queued?: QueueMessage;
+  entries: LogEntry[];
+  error?: string;
+}
+
+export const traceApi = {
+  get: (ids: string[]) =>
+    api.get<{ traces: MessageTrace[]; found: number }>(`/trace?ids=${encodeURIComponent(ids.join(','))}`),
+};
+
+// tracePath is the page showing the traces of queue IDs
+export const tracePath = (ids: string[]) => `/admin/relay/trace?ids=${ids.join(',')}`;
+
+export interface FlowNode {
+  id: string;
+  label: string;
+  layer: 'source' | 'relay' | 'destination';
+  messages: number;
+}
+
+export interface FlowEdge {
+  source: string;
+  target: string;
+  messages: number;
+  sent: number;
+  deferred: number;
+  bounced: number;
+  avgDelaySeconds: number;
+  failureRate: number;
+}
+
+export interface FlowGraph {
+  from: string;
+  to: string;
+  source: 'client' | 'sender';
+  nodes: FlowNode[];
+  edges: FlowEdge[];
+  total: number;
+}
+
+export const reportsApi = {
+  flow: (params: { window?: string; source?: 'client' | 'sender'; limit?: number } = {}) => {
+    const query = new URLSearchParams();
+    if (params.window) query.set('window', params.window);
+    if (params.source) query.set('source', params.source);
+    if (params.limit) query.set('limit', String(params.limit));
+    const qs = query.toString();
+    return api.get<{ window: string; flow: FlowGraph }>(`/reports/flow${qs ? `?${qs}` : ''}`);
+  },
+};
+
 export const queueApi = {
   summary: () => api.get<SystemStatus['queue']>('/queue'),
   list: (status?: string) => {
@@ -433,6 +619,53 @@ export const queueApi = {
   flush: () => api.post<void>('/queue/flush'),
 };
 
+// SMTP sink API - mail captured in staging instead of being delivered
+export interface SinkStatus {
+  enabled: boolean;
+  running: boolean;
+  listen: string;
+  contentFilter: string;
+  // Postfix hands every message to the sink
+  capturing: boolean;
+  maxMessages: number;
+  count: number;
+}
+
+export interface SinkMessage {
+  id: number;
+  receivedAt: string;
+  remoteAddr?: string;
+  from: string;
+  to: string[];
+  subject?: string;
+  messageId?: string;
+  size: number;
+}
+
+export interface SinkMessageDetail {
+  message: SinkMessage;
+  headerFrom?: { name?: string; email: string };
+  headerTo?: { name?: string; email: string }[];
+  cc?: { name?: string; email: string }[];
+  date?: string;
+  textBody?: string;
+  htmlBody?: string;
+  attachments?: { id: string; filename: string; contentType: string; size: number }[];
+  parseError?: string;
+}
+
+export const sinkApi = {
+  status: () => api.get<SinkStatus>('/system/sink'),
+  list: (q = '', offset = 0) =>
+    api.get<{ messages: SinkMessage[]; total: number }>(
+      `/system/sink/messages?q=${encodeURIComponent(q)}&offset=${offset}`
+    ),
+  get: (id: number) => api.get<SinkMessageDetail>(`/system/sink/messages/${id}`),
+  rawUrl: (id: number) => `${API_BASE}/system/sink/messages/${id}/raw`,
+  delete: (id: number) => api.delete<void>(`/system/sink/messages/${id}`),
+  clear: () => api.delete<{ deleted: number }>('/system/sink/messages'),
+};
+
 // Audit API
 export interface AuditEntry {
   id: number;
@@ -509,7 +742,7 @@ export const transportApi = {
   update: (domain: string, data: Partial<TransportMap>) =>
     api.put<void>(`/transport/${encodeURIComponent(domain)}`, data),
   delete: (domain: string) =>
-    api.delete<void>(`/transport/${encodeURIComponent(domain)}`),
+    api.delete<DeletedResponse>(`/transport/${encodeURIComponent(domain)}`),
 };
 
 // Sender-Dependent Relay API
@@ -526,7 +759,83 @@ export const senderRelayApi = {
   update: (sender: string, data: Partial<SenderRelay>) =>
     api.put<void>(`/sender-relays/${encodeURIComponent(sender)}`, data),
   delete: (sender: string) =>
-    api.delete<void>(`/sender-relays/${encodeURIComponent(sender)}`),
+    api.delete<DeletedResponse>(`/sender-relays/${encodeURIComponent(sender)}`),
+};
+
+// Bulk route import/export
+export interface RouteDiff {
+  added: string[];
+  updated: string[];
+  removed: string[];
+  unchanged: number;
+}
+
+export interface RouteImportResult {
+  dryRun?: boolean;
+  summary: string;
+  mode: 'merge' | 'replace';
+  transport: RouteDiff;
+  senderRelays: RouteDiff;
+  conflicts: { line: number; key: string; reason: string }[];
+  routing?: RoutingFinding[];
+  files?: { path: string; diff: string }[];
+}
+
+export const routesApi = {
+  exportUrl: (format: 'csv' | 'json') => `${API_BASE}/routes/export?format=${format}`,
+  import: (content: string, format: 'csv' | 'json', mode: 'merge' | 'replace', dryRun: boolean) =>
+    request<RouteImportResult>(`/routes/import?format=${format}&mode=${mode}${dryRun ? '&dryRun=true' : ''}`, {
+      method: 'POST',
+      body: content,
+      headers: { 'Content-Type': format === 'csv' ? 'text/csv' : 'application/json' },
+    }),
+};
+
+// Routing lint - conflicts between relayhost, relay_domains, transport maps
+// and sender relays
+export interface RoutingFinding {
+  severity: 'error' | 'warning' | 'info';
+  code: string;
+  message: string;
+  entries?: string[];
+  // Index into the precedence list that explains the finding
+  precedence?: number;
+}
+
+export interface RoutingLintResponse {
+  staged: boolean;
+  findings: RoutingFinding[];
+  counts: Record<RoutingFinding['severity'], number>;
+  precedence: string[];
+}
+
+export const routingApi = {
+  lint: (staged = false) =>
+    api.get<RoutingLintResponse>(`/config/routing/lint${staged ? '?staged=true' : ''}`),
+};
+
+// Deleted routing entries (transport maps, sender relays, backscatter
+// domains), restorable until the retention period purges them
+export interface DeletedResponse {
+  // Absent when the entry couldn't be kept
+  trashId?: number;
+}
+
+export interface TrashEntry {
+  id: number;
+  kind: 'transport_map' | 'sender_relay' | 'backscatter_domain';
+  key: string;
+  entry: Record<string, unknown>;
+  deletedAt: string;
+  deletedBy?: string;
+  purgeAt?: string;
+}
+
+export const trashApi = {
+  list: (kind?: TrashEntry['kind']) =>
+    api.get<{ entries: TrashEntry[]; retentionDays: number }>(`/trash${kind ? `?kind=${kind}` : ''}`),
+  restore: (id: number) => api.post<{ kind: string; key: string }>(`/trash/${id}/restore`),
+  purge: (id: number) => api.delete<void>(`/trash/${id}`),
 };
 
 // Settings API
@@ -591,6 +900,7 @@ export interface MailDomain {
   maxAliases: number;
   quotaBytes: number;
   active: boolean;
+  tenantId?: number;
   createdAt: string;
   updatedAt: string;
   mailboxCount: number;
@@ -12,6 +12,8 @@ import {
   CardTitle,
 } from '@/components/ui/card';
 import { useAuthStore } from '@/stores/auth';
+import { useBrandingStore } from '@/stores/branding';
+import { SupportContact } from '@/components/layout/SupportContact';
 import { authApi } from '@/lib/api';
 import { toast } from '@/components/ui/use-toast';
 import { Shield, Loader2 } from 'lucide-react';
@@ -22,6 +24,7 @@ export function AdminLoginPage() {
   const [isLoading, setIsLoading] = useState(false);
   const login = useAuthStore((state) => state.login);
   const isAuthenticated = useAuthStore((state) => state.isAuthenticated);
+  const { branding, custom } = useBrandingStore();
   const navigate = useNavigate();
 
   // Redirect if already authenticated
@@ -58,12 +61,16 @@ export function AdminLoginPage() {
     <div className="min-h-screen flex items-center justify-center bg-gradient-to-br from-slate-900 via-purple-900 to-slate-900 p-4">
       <Card className="w-full max-w-md shadow-xl border-slate-700 bg-slate-800/50 backdrop-blur">
         <CardHeader className="text-center space-y-4">
-          <div className="mx-auto flex h-16 w-16 items-center justify-center rounded-full bg-gradient-to-br from-blue-500 to-purple-600">
-            <Shield className="h-8 w-8 text-white" />
-          </div>
+          {branding.logoUrl ? (
+            <img src={branding.logoUrl} alt="" className="mx-auto h-16 max-w-[12rem] object-contain" />
+          ) : (
+            <div className="mx-auto flex h-16 w-16 items-center justify-center rounded-full bg-gradient-to-br from-blue-500 to-purple-600">
+              <Shield className="h-8 w-8 text-white" />
+            </div>
+          )}
           <div>
             <CardTitle className="text-2xl font-bold text-white">
-              PSFX Admin Portal
+              {custom ? `${branding.productName} Admin` : 'PSFX Admin Portal'}
             </CardTitle>
             <CardDescription className="mt-2 text-slate-400">
               Sign in to manage your email infrastructure
@@ -129,13 +136,16 @@ export function AdminLoginPage() {
               Webmail Login
             </Link>
           </p>
-          <div className="flex items-center justify-center gap-4 text-xs text-slate-500">
-            <span>PSFXRelay</span>
-            <span className="text-slate-600">|</span>
-            <span>PSFXAdmin</span>
-            <span className="text-slate-600">|</span>
-            <span>PSFXMail</span>
-          </div>
+          <SupportContact className="text-slate-400" />
+          {!custom && (
+            <div className="flex items-center justify-center gap-4 text-xs text-slate-500">
+              <span>PSFXRelay</span>
+              <span className="text-slate-600">|</span>
+              <span>PSFXAdmin</span>
+              <span className="text-slate-600">|</span>
+              <span>PSFXMail</span>
+            </div>
+          )}
         </CardFooter>
       </Card>
     </div>
@@ -1,5 +1,5 @@
 import { useState } from 'react';
-import { Routes, Route, NavLink } from 'react-router-dom';
+import { Routes, Route, NavLink, Link } from 'react-router-dom';
 import { useQuery, useMutation, useQueryClient } from '@tanstack/react-query';
 import {
   Card,
@@ -45,7 +45,7 @@ import {
   Eye,
   BookOpen,
 } from 'lucide-react';
-import { alertsApi, type Alert, type AlertRule } from '@/lib/api';
+import { alertsApi, tracePath, type Alert, type AlertRule } from '@/lib/api';
 import { formatDistanceToNow } from 'date-fns';
 import { cn } from '@/lib/utils';
 import { useAuthStore } from '@/stores/auth';
@@ -78,6 +78,7 @@ function AlertCard({
   };
 
   const StatusIcon = statusIcons[alert.status] || AlertTriangle;
+  const queueIds = (alert.references ?? []).filter((r) => r.type === 'queue_id').map((r) => r.id);
 
   return (
     <>
@@ -103,7 +104,22 @@ function AlertCard({
             Triggered {formatDistanceToNow(new Date(alert.triggeredAt), { addSuffix: true })}
           </CardDescription>
         </CardHeader>
-        <CardContent>
+        <CardContent className="space-y-3">
+          {!!queueIds.length && (
+            <div className="flex flex-wrap items-center gap-1 text-sm">
+              <span className="text-muted-foreground">Messages:</span>
+              {queueIds.slice(0, 5).map((id) => (
+                <Link key={id} to={tracePath([id])} className="font-mono text-primary hover:underline">
+                  {id}
+                </Link>
+              ))}
+              {queueIds.length > 1 && (
+                <Link to={tracePath(queueIds)} className="text-primary hover:underline">
+                  trace all {queueIds.length}
+                </Link>
+              )}
+            </div>
+          )}
           <div className="flex flex-wrap gap-2">
             {canEdit && alert.status === 'firing' && (
               <>
@@ -0,0 +1,253 @@
+import { useState } from 'react';
+import { useQuery, useMutation, useQueryClient } from '@tanstack/react-query';
+import {
+  Card,
+  CardContent,
+  CardDescription,
+  CardHeader,
+  CardTitle,
+} from '@/components/ui/card';
+import { Button } from '@/components/ui/button';
+import { Input } from '@/components/ui/input';
+import {
+  Table,
+  TableBody,
+  TableCell,
+  TableHead,
+  TableHeader,
+  TableRow,
+} from '@/components/ui/table';
+import {
+  Dialog,
+  DialogContent,
+  DialogDescription,
+  DialogHeader,
+  DialogTitle,
+} from '@/components/ui/dialog';
+import { Badge } from '@/components/ui/badge';
+import { RefreshCw, Trash2, Download, Eye, AlertTriangle } from 'lucide-react';
+import { sinkApi, SinkMessage } from '@/lib/api';
+import { formatDistanceToNow } from 'date-fns';
+
+function formatSize(bytes: number): string {
+  if (bytes < 1024) return `${bytes} B`;
+  if (bytes < 1024 * 1024) return `${(bytes / 1024).toFixed(1)} KB`;
+  return `${(bytes / (1024 * 1024)).toFixed(1)} MB`;
+}
+
+function MessageDialog({ message, onClose }: { message: SinkMessage; onClose: () => void }) {
+  const [showHTML, setShowHTML] = useState(true);
+  const { data, isLoading } = useQuery({
+    queryKey: ['sink-message', message.id],
+    queryFn: () => sinkApi.get(message.id),
+  });
+
+  return (
+    <Dialog open onOpenChange={(open) => !open && onClose()}>
+      <DialogContent className="max-w-4xl max-h-[85vh] overflow-y-auto">
+        <DialogHeader>
+          <DialogTitle>{message.subject || '(no subject)'}</DialogTitle>
+          <DialogDescription>
+            From {message.from || '<>'} to {message.to.join(', ')}
+          </DialogDescription>
+        </DialogHeader>
+        {isLoading && <div className="text-muted-foreground">Loading...</div>}
+        {data && (
+          <div className="space-y-4">
+            <div className="grid grid-cols-[8rem_1fr] gap-1 text-sm">
+              <span className="text-muted-foreground">Received</span>
+              <span>{new Date(message.receivedAt).toLocaleString()}</span>
+              <span className="text-muted-foreground">Client</span>
+              <span>{message.remoteAddr || '-'}</span>
+              <span className="text-muted-foreground">Message-ID</span>
+              <span className="break-all">{message.messageId || '-'}</span>
+              <span className="text-muted-foreground">Size</span>
+              <span>{formatSize(message.size)}</span>
+              {data.attachments && data.attachments.length > 0 && (
+                <>
+                  <span className="text-muted-foreground">Attachments</span>
+                  <span>
+                    {data.attachments.map((a) => `${a.filename} (${formatSize(a.size)})`).join(', ')}
+                  </span>
+                </>
+              )}
+            </div>
+            {data.parseError && (
+              <p className="text-sm text-red-600">Could not parse the message: {data.parseError}</p>
+            )}
+            {data.htmlBody && data.textBody && (
+              <div className="flex gap-2">
+                <Button size="sm" variant={showHTML ? 'default' : 'outline'} onClick={() => setShowHTML(true)}>
+                  HTML
+                </Button>
+                <Button size="sm" variant={showHTML ? 'outline' : 'default'} onClick={() => setShowHTML(false)}>
+                  Text
+                </Button>
+              </div>
+            )}
+            {data.htmlBody && (showHTML || !data.textBody) ? (
+              <iframe
+                title="Message body"
+                sandbox=""
+                srcDoc={data.htmlBody}
+                className="w-full h-96 rounded border bg-white"
+              />
+            ) : (
+              <pre className="whitespace-pre-wrap text-sm rounded border p-3 bg-muted">
+                {data.textBody || '(empty body)'}
+              </pre>
+            )}
+            <Button variant="outline" asChild>
+              <a href={sinkApi.rawUrl(message.id)}>
+                <Download className="h-4 w-4 mr-2" />
+                Download .eml
+              </a>
+            </Button>
+          </div>
+        )}
+      </DialogContent>
+    </Dialog>
+  );
+}
+
+export function CapturedMailPage() {
+  const queryClient = useQueryClient();
+  const [search, setSearch] = useState('');
+  const [selected, setSelected] = useState<SinkMessage | null>(null);
+
+  const { data: status } = useQuery({
+    queryKey: ['sink-status'],
+    queryFn: sinkApi.status,
+    refetchInterval: 10000,
+  });
+
+  const { data, isLoading, refetch } = useQuery({
+    queryKey: ['sink-messages', search],
+    queryFn: () => sinkApi.list(search),
+    refetchInterval: 5000,
+  });
+
+  const invalidate = () => {
+    queryClient.invalidateQueries({ queryKey: ['sink-messages'] });
+    queryClient.invalidateQueries({ queryKey: ['sink-status'] });
+  };
+
+  const deleteMutation = useMutation({ mutationFn: sinkApi.delete, onSuccess: invalidate });
+  const clearMutation = useMutation({ mutationFn: sinkApi.clear, onSuccess: invalidate });
+
+  return (
+    <div className="space-y-6">
+      <div className="flex items-center justify-between">
+        <div>
+          <h1 className="text-3xl font-bold">Captured Mail</h1>
+          <p className="text-muted-foreground">
+            Messages accepted by the SMTP sink. They are stored here and never delivered.
+          </p>
+        </div>
+        <div className="flex gap-2">
+          <Button variant="outline" onClick={() => refetch()}>
+            <RefreshCw className="h-4 w-4 mr-2" />
+            Refresh
+          </Button>
+          <Button
+            variant="destructive"
+            disabled={!data?.total || clearMutation.isPending}
+            onClick={() => {
+              if (confirm('Delete every captured message?')) clearMutation.mutate();
+            }}
+          >
+            <Trash2 className="h-4 w-4 mr-2" />
+            Delete all
+          </Button>
+        </div>
+      </div>
+
+      {status && !status.capturing && (
+        <Card className="border-yellow-500">
+          <CardContent className="flex items-start gap-3 pt-6 text-sm">
+            <AlertTriangle className="h-5 w-5 text-yellow-600 shrink-0" />
+            <div>
+              {!status.enabled
+                ? 'The SMTP sink is off. Turn on sink_enabled in Settings to capture mail in this environment; until then Postfix delivers normally.'
+                : !status.running
+                  ? `The SMTP sink is enabled but could not listen on ${status.listen}.`
+                  : `Postfix isn't handing mail to the sink (content_filter is "${status.contentFilter || 'unset'}"), so mail may still be delivered.`}
+            </div>
+          </CardContent>
+        </Card>
+      )}
+
+      <Card>
+        <CardHeader>
+          <CardTitle className="flex items-center gap-2">
+            Messages
+            {status?.capturing && <Badge>Capturing</Badge>}
+          </CardTitle>
+          <CardDescription>
+            {data ? `${data.total} captured` : ''}
+            {status ? `, newest ${status.maxMessages} kept` : ''}
+          </CardDescription>
+        </CardHeader>
+        <CardContent className="space-y-4">
+          <Input
+            placeholder="Search sender, recipient or subject"
+            value={search}
+            onChange={(e) => setSearch(e.target.value)}
+            className="max-w-sm"
+          />
+          <Table>
+            <TableHeader>
+              <TableRow>
+                <TableHead>Received</TableHead>
+                <TableHead>From</TableHead>
+                <TableHead>To</TableHead>
+                <TableHead>Subject</TableHead>
+                <TableHead>Size</TableHead>
+                <TableHead className="w-24" />
+              </TableRow>
+            </TableHeader>
+            <TableBody>
+              {isLoading && (
+                <TableRow>
+                  <TableCell colSpan={6} className="text-center text-muted-foreground">
+                    Loading...
+                  </TableCell>
+                </TableRow>
+              )}
+              {data?.messages.length === 0 && (
+                <TableRow>
+                  <TableCell colSpan={6} className="text-center text-muted-foreground">
+                    No captured messages
+                  </TableCell>
+                </TableRow>
+              )}
+              {data?.messages.map((msg) => (
+                <TableRow key={msg.id} className="cursor-pointer" onClick={() => setSelected(msg)}>
+                  <TableCell title={new Date(msg.receivedAt).toLocaleString()}>
+                    {formatDistanceToNow(new Date(msg.receivedAt), { addSuffix: true })}
+                  </TableCell>
+                  <TableCell>{msg.from || '<>'}</TableCell>
+                  <TableCell>{msg.to.join(', ')}</TableCell>
+                  <TableCell>{msg.subject || '(no subject)'}</TableCell>
+                  <TableCell>{formatSize(msg.size)}</TableCell>
+                  <TableCell onClick={(e) => e.stopPropagation()}>
+                    <div className="flex gap-1">
+                      <Button size="icon" variant="ghost" onClick={() => setSelected(msg)}>
+                        <Eye className="h-4 w-4" />
+                      </Button>
+                      <Button size="icon" variant="ghost" onClick={() => deleteMutation.mutate(msg.id)}>
+                        <Trash2 className="h-4 w-4" />
+                      </Button>
+                    </div>
+                  </TableCell>
+                </TableRow>
+              ))}
+            </TableBody>
+          </Table>
+        </CardContent>
+      </Card>
+
+      {selected && <MessageDialog message={selected} onClose={() => setSelected(null)} />}
+    </div>
+  );
+}
@@ -15,8 +15,10 @@ import {
   Mail,
   Clock,
   Pause,
+  ArrowUpCircle,
 } from 'lucide-react';
-import { statusApi, type SystemStatus } from '@/lib/api';
+import { statusApi, updatesApi, type SystemStatus, type UpdateAdvice } from '@/lib/api';
+import { useAuthStore } from '@/stores/auth';
 import { formatRelativeTime } from '@/lib/utils';
 import { cn } from '@/lib/utils';
 
@@ -58,12 +60,59 @@ function StatusCard({
   );
 }
 
+function UpdateCard({ advice }: { advice: UpdateAdvice }) {
+  return (
+    <Card className="border-blue-300">
+      <CardHeader>
+        <CardTitle className="flex items-center gap-2">
+          <ArrowUpCircle className="h-5 w-5 text-blue-600" />
+          Update available: {advice.latest}
+        </CardTitle>
+        <CardDescription>
+          Running {advice.current}
+          {advice.checkedAt && `, checked ${formatRelativeTime(advice.checkedAt)}`}
+        </CardDescription>
+      </CardHeader>
+      <CardContent className="space-y-3 text-sm">
+        {advice.upgradePath && advice.upgradePath.length > 0 && (
+          <p>
+            Upgrade to {advice.upgradePath.join(', then ')} first, then to{' '}
+            {advice.latest}.
+          </p>
+        )}
+        {advice.breaking && advice.breaking.length > 0 && (
+          <div>
+            <p className="font-medium text-yellow-600">Breaking changes</p>
+            <ul className="list-disc pl-5">
+              {advice.breaking.map((change) => (
+                <li key={change}>{change}</li>
+              ))}
+            </ul>
+          </div>
+        )}
+        {advice.migrations && advice.migrations.length > 0 && (
+          <p className="text-muted-foreground">
+            Database migrations: {advice.migrations.join(', ')}
+          </p>
+        )}
+      </CardContent>
+    </Card>
+  );
+}
+
 export function DashboardPage() {
   const { data: status, isLoading, refetch } = useQuery<SystemStatus>({
     queryKey: ['status'],
     queryFn: statusApi.get,
     refetchInterval: 10000, // Refresh every 10 seconds
   });
+  const isAdmin = useAuthStore((state) => state.canEdit());
+  const { data: updates } = useQuery<UpdateAdvice>({
+    queryKey: ['updates'],
+    queryFn: updatesApi.get,
+    enabled: isAdmin,
+    refetchInterval: 3600000,
+  });
 
   if (isLoading) {
     return (
@@ -94,6 +143,8 @@ export function DashboardPage() {
         </Button>
       </div>
 
+      {updates?.updateAvailable && <UpdateCard advice={updates} />}
+
       <div className="grid gap-4 md:grid-cols-2 lg:grid-cols-4">
         <StatusCard
           title="Postfix Status"
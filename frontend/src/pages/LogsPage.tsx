@@ -18,6 +18,13 @@ import { Switch } from '@/components/ui/switch';
 import { Label } from '@/components/ui/label';
 import { Search, Pause, Play, Download, Wifi, WifiOff, Filter } from 'lucide-react';
 import { logsApi, type LogEntry } from '@/lib/api';
+
+// A message of the WebSocket log stream
+interface LogStreamMessage {
+  type: 'connected' | 'log' | 'filter' | 'dropped' | 'error' | 'shutdown';
+  entry?: LogEntry;
+  dropped?: number;
+}
 import { formatDate, cn } from '@/lib/utils';
 
 function LogLine({ entry, onQueueClick }: { entry: LogEntry; onQueueClick?: (queueId: string) => void }) {
@@ -72,6 +79,8 @@ export function LogsPage() {
   const [liveConnected, setLiveConnected] = useState(false);
   const [severityFilter, setSeverityFilter] = useState<string>('all');
   const [liveLogs, setLiveLogs] = useState<LogEntry[]>([]);
+  const [droppedCount, setDroppedCount] = useState(0);
+  const socketRef = useRef<WebSocket | null>(null);
   const eventSourceRef = useRef<EventSource | null>(null);
   const logContainerRef = useRef<HTMLDivElement>(null);
 
@@ -82,64 +91,117 @@ export function LogsPage() {
     refetchInterval: !isLive && !isPaused ? 5000 : false,
   });
 
-  // Connect to SSE stream for live logs
-  const connectLive = useCallback(() => {
-    if (eventSourceRef.current) {
-      eventSourceRef.current.close();
+  const appendLiveLog = useCallback((entry: LogEntry) => {
+    if (isPaused) {
+      return;
     }
+    setLiveLogs((prev) => {
+      const newLogs = [...prev, entry];
+      // Keep last 500 entries
+      if (newLogs.length > 500) {
+        return newLogs.slice(-500);
+      }
+      return newLogs;
+    });
+  }, [isPaused]);
+
+  const closeLive = useCallback(() => {
+    socketRef.current?.close();
+    socketRef.current = null;
+    eventSourceRef.current?.close();
+    eventSourceRef.current = null;
+  }, []);
 
-    const url = `/api/v1/logs/stream`;
-    const eventSource = new EventSource(url);
+  // SSE stream, for when WebSockets don't get through
+  const connectSSE = useCallback(() => {
+    const params = new URLSearchParams();
+    if (severityFilter !== 'all') {
+      params.set('severity', severityFilter);
+    }
+    const eventSource = new EventSource(`/api/v1/logs/stream?${params}`);
 
     eventSource.addEventListener('connected', () => {
       setLiveConnected(true);
     });
 
     eventSource.addEventListener('log', (event) => {
-      if (!isPaused) {
-        const entry = JSON.parse(event.data) as LogEntry;
-        setLiveLogs((prev) => {
-          const newLogs = [...prev, entry];
-          // Keep last 500 entries
-          if (newLogs.length > 500) {
-            return newLogs.slice(-500);
-          }
-          return newLogs;
-        });
-      }
+      appendLiveLog(JSON.parse(event.data) as LogEntry);
     });
 
     eventSource.onerror = () => {
       setLiveConnected(false);
+    };
+
+    eventSourceRef.current = eventSource;
+  }, [appendLiveLog, severityFilter]);
+
+  // Connect to the WebSocket stream for live logs, falling back to SSE if
+  // it never opens
+  const connectLive = useCallback(() => {
+    closeLive();
+
+    const params = new URLSearchParams();
+    if (severityFilter !== 'all') {
+      params.set('severity', severityFilter);
+    }
+    const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+    const socket = new WebSocket(`${protocol}//${window.location.host}/api/v1/logs/stream?${params}`);
+    let opened = false;
+
+    socket.onopen = () => {
+      opened = true;
+    };
+
+    socket.onmessage = (event) => {
+      const msg = JSON.parse(event.data) as LogStreamMessage;
+      switch (msg.type) {
+        case 'connected':
+          setLiveConnected(true);
+          setDroppedCount(0);
+          break;
+        case 'log':
+          if (msg.entry) {
+            appendLiveLog(msg.entry);
+          }
+          break;
+        case 'dropped':
+          setDroppedCount((n) => n + (msg.dropped || 0));
+          break;
+      }
+    };
+
+    socket.onclose = () => {
+      if (socketRef.current !== socket) {
+        return;
+      }
+      socketRef.current = null;
+      setLiveConnected(false);
+      if (!opened) {
+        connectSSE();
+        return;
+      }
       // Attempt reconnect after 5 seconds
       setTimeout(() => {
-        if (isLive) {
+        if (isLive && !socketRef.current && !eventSourceRef.current) {
           connectLive();
         }
       }, 5000);
     };
 
-    eventSourceRef.current = eventSource;
-  }, [isPaused, isLive]);
+    socketRef.current = socket;
+  }, [appendLiveLog, closeLive, connectSSE, isLive, severityFilter]);
 
   // Manage live connection
   useEffect(() => {
     if (isLive) {
       connectLive();
     } else {
-      if (eventSourceRef.current) {
-        eventSourceRef.current.close();
-        eventSourceRef.current = null;
-      }
+      closeLive();
       setLiveConnected(false);
     }
 
-    return () => {
-      if (eventSourceRef.current) {
-        eventSourceRef.current.close();
-      }
-    };
-  }, [isLive, connectLive]);
+    return closeLive;
+  }, [isLive, connectLive, closeLive]);
 
   // Auto-scroll to bottom for live logs
   useEffect(() => {
@@ -277,6 +339,7 @@ export function LogsPage() {
           <div className="mt-2 flex items-center justify-between text-sm text-muted-foreground">
             <span>
               {isPaused ? 'Paused' : isLive ? 'Live' : 'Polling'} - Showing {filteredLogs.length} entries
+              {isLive && droppedCount > 0 && ` (${droppedCount} skipped while catching up)`}
             </span>
             {!isLive && (
               <Button variant="ghost" size="sm" onClick={() => refetch()}>
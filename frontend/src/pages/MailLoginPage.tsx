@@ -12,6 +12,8 @@ import {
   CardTitle,
 } from '@/components/ui/card';
 import { useMailStore } from '@/stores/mail';
+import { useBrandingStore } from '@/stores/branding';
+import { SupportContact } from '@/components/layout/SupportContact';
 import { toast } from '@/components/ui/use-toast';
 import { Mail, Loader2 } from 'lucide-react';
 
@@ -21,6 +23,7 @@ export function MailLoginPage() {
   const [isLoading, setIsLoading] = useState(false);
   const login = useMailStore((state) => state.login);
   const isAuthenticated = useMailStore((state) => state.isAuthenticated);
+  const { branding, custom } = useBrandingStore();
   const navigate = useNavigate();
 
   // Redirect if already authenticated
@@ -65,11 +68,15 @@ export function MailLoginPage() {
     <div className="min-h-screen flex items-center justify-center bg-gradient-to-br from-green-50 to-emerald-100 dark:from-green-950 dark:to-emerald-900 p-4">
       <Card className="w-full max-w-md shadow-xl">
         <CardHeader className="text-center space-y-4">
-          <div className="mx-auto flex h-16 w-16 items-center justify-center rounded-full bg-green-100 dark:bg-green-900">
-            <Mail className="h-8 w-8 text-green-600 dark:text-green-400" />
-          </div>
+          {branding.logoUrl ? (
+            <img src={branding.logoUrl} alt="" className="mx-auto h-16 max-w-[12rem] object-contain" />
+          ) : (
+            <div className="mx-auto flex h-16 w-16 items-center justify-center rounded-full bg-green-100 dark:bg-green-900">
+              <Mail className="h-8 w-8 text-green-600 dark:text-green-400" />
+            </div>
+          )}
           <div>
-            <CardTitle className="text-2xl font-bold">PSFXMail</CardTitle>
+            <CardTitle className="text-2xl font-bold">{custom ? `${branding.productName} Mail` : 'PSFXMail'}</CardTitle>
             <CardDescription className="mt-2">
               Sign in to access your mailbox
             </CardDescription>
@@ -130,8 +137,9 @@ export function MailLoginPage() {
               Admin Portal
             </Link>
           </p>
+          <SupportContact />
           <p className="text-xs">
-            PSFX Suite - Enterprise Email Platform
+            {custom ? branding.productName : 'PSFX Suite - Enterprise Email Platform'}
           </p>
         </CardFooter>
       </Card>
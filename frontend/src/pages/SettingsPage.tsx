@@ -49,6 +49,7 @@ import {
 import { api } from '@/lib/api';
 import { cn } from '@/lib/utils';
 import { useToast } from '@/components/ui/use-toast';
+import { useBrandingStore } from '@/stores/branding';
 
 interface NotificationChannel {
   id: number;
@@ -64,6 +65,13 @@ interface SystemSettings {
   session_timeout_hours: string;
   alert_silence_default_min: string;
   log_source: string;
+  branding_product_name: string;
+  branding_logo_url: string;
+  branding_support_email: string;
+  branding_support_url: string;
+  branding_primary_color: string;
+  branding_accent_color: string;
+  branding_default_theme: string;
 }
 
 // Notification Channels Settings
@@ -474,6 +482,13 @@ function SystemSettings() {
     session_timeout_hours: '8',
     alert_silence_default_min: '60',
     log_source: 'auto',
+    branding_product_name: '',
+    branding_logo_url: '',
+    branding_support_email: '',
+    branding_support_url: '',
+    branding_primary_color: '',
+    branding_accent_color: '',
+    branding_default_theme: 'system',
   });
 
   const { data } = useQuery({
@@ -491,6 +506,7 @@ function SystemSettings() {
     mutationFn: (settings: SystemSettings) => api.put('/settings/system', settings),
     onSuccess: () => {
       toast({ title: 'Settings saved', description: 'System settings have been updated.' });
+      useBrandingStore.getState().load();
     },
   });
 
@@ -609,6 +625,87 @@ function SystemSettings() {
         </CardContent>
       </Card>
 
+      <Card>
+        <CardHeader>
+          <CardTitle>Branding</CardTitle>
+          <CardDescription>
+            Rebrand the portal and login pages for this deployment
+          </CardDescription>
+        </CardHeader>
+        <CardContent className="space-y-4">
+          <div className="grid grid-cols-2 gap-4">
+            <div className="space-y-2">
+              <Label>Product Name</Label>
+              <Input
+                placeholder="PSFX Suite"
+                value={settings.branding_product_name}
+                onChange={(e) => setSettings({ ...settings, branding_product_name: e.target.value })}
+              />
+            </div>
+            <div className="space-y-2">
+              <Label>Logo URL</Label>
+              <Input
+                placeholder="https://example.com/logo.svg"
+                value={settings.branding_logo_url}
+                onChange={(e) => setSettings({ ...settings, branding_logo_url: e.target.value })}
+              />
+            </div>
+            <div className="space-y-2">
+              <Label>Support Email</Label>
+              <Input
+                type="email"
+                placeholder="support@example.com"
+                value={settings.branding_support_email}
+                onChange={(e) => setSettings({ ...settings, branding_support_email: e.target.value })}
+              />
+            </div>
+            <div className="space-y-2">
+              <Label>Support URL</Label>
+              <Input
+                placeholder="https://support.example.com"
+                value={settings.branding_support_url}
+                onChange={(e) => setSettings({ ...settings, branding_support_url: e.target.value })}
+              />
+            </div>
+            <div className="space-y-2">
+              <Label>Primary Color</Label>
+              <Input
+                placeholder="#1d4ed8"
+                value={settings.branding_primary_color}
+                onChange={(e) => setSettings({ ...settings, branding_primary_color: e.target.value })}
+              />
+            </div>
+            <div className="space-y-2">
+              <Label>Accent Color</Label>
+              <Input
+                placeholder="#f1f5f9"
+                value={settings.branding_accent_color}
+                onChange={(e) => setSettings({ ...settings, branding_accent_color: e.target.value })}
+              />
+            </div>
+          </div>
+          <div className="space-y-2">
+            <Label>Default Theme</Label>
+            <Select
+              value={settings.branding_default_theme}
+              onValueChange={(v) => setSettings({ ...settings, branding_default_theme: v })}
+            >
+              <SelectTrigger>
+                <SelectValue />
+              </SelectTrigger>
+              <SelectContent>
+                <SelectItem value="system">System</SelectItem>
+                <SelectItem value="light">Light</SelectItem>
+                <SelectItem value="dark">Dark</SelectItem>
+              </SelectContent>
+            </Select>
+            <p className="text-xs text-muted-foreground">
+              Used until a user picks a theme. Leave fields empty for the default branding.
+            </p>
+          </div>
+        </CardContent>
+      </Card>
+
       <div className="flex justify-end">
         <Button onClick={handleSave} disabled={saveMutation.isPending}>
           <Save className="h-4 w-4 mr-2" />
@@ -0,0 +1,135 @@
+import { useEffect, useState } from 'react';
+import { useSearchParams } from 'react-router-dom';
+import { useQuery } from '@tanstack/react-query';
+import {
+  Card,
+  CardContent,
+  CardDescription,
+  CardHeader,
+  CardTitle,
+} from '@/components/ui/card';
+import { Button } from '@/components/ui/button';
+import { Input } from '@/components/ui/input';
+import { Badge } from '@/components/ui/badge';
+import { Search } from 'lucide-react';
+import { traceApi, MessageTrace } from '@/lib/api';
+
+const statusVariants: Record<string, 'default' | 'secondary' | 'destructive' | 'outline'> = {
+  sent: 'default',
+  deferred: 'secondary',
+  bounced: 'destructive',
+};
+
+function TraceCard({ trace }: { trace: MessageTrace }) {
+  return (
+    <Card>
+      <CardHeader>
+        <CardTitle className="flex items-center gap-2 font-mono">
+          {trace.queueId}
+          {trace.status && <Badge variant={statusVariants[trace.status] ?? 'outline'}>{trace.status}</Badge>}
+          {trace.queued && <Badge variant="outline">in queue ({trace.queued.status})</Badge>}
+        </CardTitle>
+        <CardDescription>
+          {trace.error
+            ? trace.error
+            : !trace.found
+              ? 'Not in the recent mail log or the queue.'
+              : `From ${trace.from || '<>'} to ${trace.to.join(', ') || trace.queued?.recipients.join(', ') || 'unknown'}`}
+        </CardDescription>
+      </CardHeader>
+      {trace.found && (
+        <CardContent className="space-y-3">
+          {trace.firstSeen && (
+            <div className="text-sm text-muted-foreground">
+              Seen {new Date(trace.firstSeen).toLocaleString()}
+              {trace.lastSeen && trace.lastSeen !== trace.firstSeen && ` to ${new Date(trace.lastSeen).toLocaleString()}`}
+            </div>
+          )}
+          {trace.queued?.reason && (
+            <div className="text-sm">
+              <span className="text-muted-foreground">Queue reason: </span>
+              {trace.queued.reason}
+            </div>
+          )}
+          {trace.entries.length > 0 && (
+            <div className="rounded border bg-muted p-3 font-mono text-xs space-y-1 overflow-x-auto">
+              {trace.entries.map((entry, i) => (
+                <div key={i} className="whitespace-nowrap">
+                  <span className="text-muted-foreground">{new Date(entry.timestamp).toLocaleTimeString()}</span>{' '}
+                  <span className="text-blue-600">{entry.process}</span>{' '}
+                  {entry.message}
+                </div>
+              ))}
+            </div>
+          )}
+        </CardContent>
+      )}
+    </Card>
+  );
+}
+
+export function TracePage() {
+  const [searchParams, setSearchParams] = useSearchParams();
+  const idsParam = searchParams.get('ids') || '';
+  const ids = idsParam
+    .split(',')
+    .map((id) => id.trim())
+    .filter(Boolean);
+  const [input, setInput] = useState(ids.join(', '));
+
+  // Follow links to other traces while the page is open
+  useEffect(() => {
+    setInput(idsParam.split(',').filter(Boolean).join(', '));
+  }, [idsParam]);
+
+  const { data, isLoading, error } = useQuery({
+    queryKey: ['trace', ids.join(',')],
+    queryFn: () => traceApi.get(ids),
+    enabled: ids.length > 0,
+  });
+
+  const submit = (e: React.FormEvent) => {
+    e.preventDefault();
+    const next = input.split(/[\s,]+/).filter(Boolean);
+    setSearchParams(next.length ? { ids: next.join(',') } : {});
+  };
+
+  return (
+    <div className="space-y-6">
+      <div>
+        <h1 className="text-3xl font-bold">Message Trace</h1>
+        <p className="text-muted-foreground">
+          What the mail log and the queue show of messages, by queue ID
+        </p>
+      </div>
+
+      <form onSubmit={submit} className="flex gap-2 max-w-xl">
+        <Input
+          placeholder="Queue IDs, separated by commas"
+          value={input}
+          onChange={(e) => setInput(e.target.value)}
+          className="font-mono"
+        />
+        <Button type="submit">
+          <Search className="h-4 w-4 mr-2" />
+          Trace
+        </Button>
+      </form>
+
+      {isLoading && <div className="text-muted-foreground">Loading...</div>}
+      {error && <div className="text-destructive">{(error as Error).message}</div>}
+      {data && (
+        <>
+          <p className="text-sm text-muted-foreground">
+            {data.found} of {data.traces.length} found
+          </p>
+          <div className="space-y-4">
+            {data.traces.map((trace) => (
+              <TraceCard key={trace.queueId} trace={trace} />
+            ))}
+          </div>
+        </>
+      )}
+    </div>
+  );
+}
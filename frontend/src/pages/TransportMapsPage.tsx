@@ -42,9 +42,44 @@ import {
   Map,
   Send,
   RefreshCw,
+  Undo2,
+  History,
+  AlertTriangle,
+  Info,
+  XCircle,
+  KeyRound,
+  Upload,
+  Download,
 } from 'lucide-react';
-import { transportApi, senderRelayApi, TransportMap, SenderRelay } from '@/lib/api';
+import {
+  transportApi,
+  senderRelayApi,
+  trashApi,
+  routingApi,
+  routesApi,
+  configApi,
+  RouteImportResult,
+  TransportMap,
+  SenderRelay,
+  TrashEntry,
+  RoutingFinding,
+} from '@/lib/api';
 import { useAuthStore } from '@/stores/auth';
+import { useToast } from '@/components/ui/use-toast';
+import { ToastAction } from '@/components/ui/toast';
+import { formatDistanceToNow } from 'date-fns';
+
+const findingIcons: Record<RoutingFinding['severity'], JSX.Element> = {
+  error: <XCircle className="h-4 w-4 text-destructive shrink-0 mt-0.5" />,
+  warning: <AlertTriangle className="h-4 w-4 text-yellow-600 shrink-0 mt-0.5" />,
+  info: <Info className="h-4 w-4 text-muted-foreground shrink-0 mt-0.5" />,
+};
+
+const trashKindLabels: Record<TrashEntry['kind'], string> = {
+  transport_map: 'Transport map',
+  sender_relay: 'Sender relay',
+  backscatter_domain: 'Backscatter domain',
+};
 
 type TransportFormData = {
   domain: string;
@@ -55,10 +90,124 @@ type TransportFormData = {
 type SenderRelayFormData = {
   sender: string;
   relayhost: string;
+  username: string;
+  password: string;
 };
 
+function ImportRoutesDialog({ open, onClose, onImported }: { open: boolean; onClose: () => void; onImported: () => void }) {
+  const [content, setContent] = useState('');
+  const [format, setFormat] = useState<'csv' | 'json'>('csv');
+  const [mode, setMode] = useState<'merge' | 'replace'>('merge');
+  const [preview, setPreview] = useState<RouteImportResult | null>(null);
+  const [error, setError] = useState('');
+
+  const reset = () => {
+    setContent('');
+    setPreview(null);
+    setError('');
+  };
+
+  const previewMutation = useMutation({
+    mutationFn: () => routesApi.import(content, format, mode, true),
+    onSuccess: (data) => {
+      setPreview(data);
+      setError('');
+    },
+    onError: (e: Error) => setError(e.message),
+  });
+
+  const applyMutation = useMutation({
+    mutationFn: () => routesApi.import(content, format, mode, false),
+    onSuccess: () => {
+      reset();
+      onImported();
+      onClose();
+    },
+    onError: (e: Error) => setError(e.message),
+  });
+
+  const handleFile = async (file: File) => {
+    setFormat(file.name.toLowerCase().endsWith('.json') ? 'json' : 'csv');
+    setContent(await file.text());
+    setPreview(null);
+  };
+
+  const diffLine = (label: string, keys: string[]) =>
+    keys.length > 0 && (
+      <div>
+        <span className="font-medium">{label}:</span> {keys.join(', ')}
+      </div>
+    );
+
+  return (
+    <Dialog open={open} onOpenChange={(o) => { if (!o) { reset(); onClose(); } }}>
+      <DialogContent className="max-w-3xl max-h-[85vh] overflow-y-auto">
+        <DialogHeader>
+          <DialogTitle>Import Routes</DialogTitle>
+          <DialogDescription>
+            CSV with type, key, next_hop, port and enabled columns (an Exchange send connector export with
+            AddressSpaces and SmartHosts also works), or JSON as exported. Nothing is written unless every row is valid.
+          </DialogDescription>
+        </DialogHeader>
+        <div className="space-y-4">
+          <Input type="file" accept=".csv,.json,text/csv,application/json" onChange={(e) => e.target.files?.[0] && handleFile(e.target.files[0])} />
+          <div className="flex items-center gap-2">
+            <Switch id="import-replace" checked={mode === 'replace'} onCheckedChange={(c) => { setMode(c ? 'replace' : 'merge'); setPreview(null); }} />
+            <Label htmlFor="import-replace" className="text-sm">
+              Replace: remove existing entries the file doesn't list (they go to Recently Deleted)
+            </Label>
+          </div>
+          {error && <p className="text-sm text-destructive">{error}</p>}
+          {preview && (
+            <div className="space-y-3 text-sm">
+              <p>{preview.summary}</p>
+              {preview.conflicts.length > 0 && (
+                <div className="rounded border border-destructive p-3 space-y-1">
+                  <p className="font-medium text-destructive">{preview.conflicts.length} rows need fixing before import</p>
+                  {preview.conflicts.map((c, i) => (
+                    <div key={i}>
+                      Line {c.line}{c.key && ` (${c.key})`}: {c.reason}
+                    </div>
+                  ))}
+                </div>
+              )}
+              {diffLine('New transport maps', preview.transport.added)}
+              {diffLine('Changed transport maps', preview.transport.updated)}
+              {diffLine('Removed transport maps', preview.transport.removed)}
+              {diffLine('New sender relays', preview.senderRelays.added)}
+              {diffLine('Changed sender relays', preview.senderRelays.updated)}
+              {diffLine('Removed sender relays', preview.senderRelays.removed)}
+              {preview.routing?.filter((f) => f.severity !== 'info').map((f, i) => (
+                <div key={i} className="flex items-start gap-2">
+                  {findingIcons[f.severity]}
+                  {f.message}
+                </div>
+              ))}
+              {preview.files?.map((f) => (
+                <pre key={f.path} className="rounded border bg-muted p-2 text-xs overflow-x-auto">{f.diff}</pre>
+              ))}
+            </div>
+          )}
+        </div>
+        <DialogFooter>
+          <Button variant="outline" onClick={() => previewMutation.mutate()} disabled={!content || previewMutation.isPending}>
+            Preview
+          </Button>
+          <Button
+            onClick={() => applyMutation.mutate()}
+            disabled={!preview || preview.conflicts.length > 0 || applyMutation.isPending}
+          >
+            Import
+          </Button>
+        </DialogFooter>
+      </DialogContent>
+    </Dialog>
+  );
+}
+
 export function TransportMapsPage() {
   const queryClient = useQueryClient();
+  const { toast } = useToast();
   const isAdmin = useAuthStore((state) => state.user?.role === 'admin');
 
   const [activeTab, setActiveTab] = useState('transport');
@@ -66,6 +215,7 @@ export function TransportMapsPage() {
   const [showSenderDialog, setShowSenderDialog] = useState(false);
   const [editingTransport, setEditingTransport] = useState<TransportMap | null>(null);
   const [editingSender, setEditingSender] = useState<SenderRelay | null>(null);
+  const [showImport, setShowImport] = useState(false);
   const [deleteConfirm, setDeleteConfirm] = useState<{ type: 'transport' | 'sender'; id: string } | null>(null);
 
   // Transport maps queries
@@ -79,6 +229,26 @@ export function TransportMapsPage() {
     queryFn: senderRelayApi.list,
   });
 
+  const { data: credentialData } = useQuery({
+    queryKey: ['credentials'],
+    queryFn: configApi.getCredentials,
+    enabled: isAdmin,
+  });
+  const senderLogin = (sender: string) =>
+    credentialData?.credentials.find((c) => c.sender === sender);
+
+  const { data: trashData, refetch: refetchTrash } = useQuery({
+    queryKey: ['routing-trash'],
+    queryFn: () => trashApi.list(),
+  });
+
+  const [lintStaged, setLintStaged] = useState(false);
+  const [showPrecedence, setShowPrecedence] = useState(false);
+  const { data: lintData } = useQuery({
+    queryKey: ['routing-lint', lintStaged],
+    queryFn: () => routingApi.lint(lintStaged),
+  });
+
   // Transport form
   const transportForm = useForm<TransportFormData>({
     defaultValues: {
@@ -93,6 +263,8 @@ export function TransportMapsPage() {
     defaultValues: {
       sender: '',
       relayhost: '',
+      username: '',
+      password: '',
     },
   });
 
@@ -101,6 +273,7 @@ export function TransportMapsPage() {
     mutationFn: transportApi.create,
     onSuccess: () => {
       queryClient.invalidateQueries({ queryKey: ['transport-maps'] });
+      queryClient.invalidateQueries({ queryKey: ['routing-lint'] });
       setShowTransportDialog(false);
       transportForm.reset();
     },
@@ -111,24 +284,61 @@ export function TransportMapsPage() {
       transportApi.update(domain, data),
     onSuccess: () => {
       queryClient.invalidateQueries({ queryKey: ['transport-maps'] });
+      queryClient.invalidateQueries({ queryKey: ['routing-lint'] });
       setShowTransportDialog(false);
       setEditingTransport(null);
       transportForm.reset();
     },
   });
 
+  const invalidateRouting = () => {
+    queryClient.invalidateQueries({ queryKey: ['routing-lint'] });
+    queryClient.invalidateQueries({ queryKey: ['transport-maps'] });
+    queryClient.invalidateQueries({ queryKey: ['sender-relays'] });
+    queryClient.invalidateQueries({ queryKey: ['routing-trash'] });
+  };
+
+  const restoreMutation = useMutation({
+    mutationFn: trashApi.restore,
+    onSuccess: (data) => {
+      invalidateRouting();
+      toast({ title: 'Restored', description: `${data.key} is back in place.` });
+    },
+    onError: (error: Error) => {
+      toast({ title: 'Restore Failed', description: error.message, variant: 'destructive' });
+    },
+  });
+
+  const purgeMutation = useMutation({
+    mutationFn: trashApi.purge,
+    onSuccess: () => queryClient.invalidateQueries({ queryKey: ['routing-trash'] }),
+  });
+
+  // Offer to undo a delete for as long as the toast is showing
+  const showUndo = (label: string, trashId?: number) => {
+    invalidateRouting();
+    setDeleteConfirm(null);
+    toast({
+      title: 'Deleted',
+      description: `${label} was deleted.`,
+      action: trashId ? (
+        <ToastAction altText="Undo delete" onClick={() => restoreMutation.mutate(trashId)}>
+          Undo
+        </ToastAction>
+      ) : undefined,
+    });
+  };
+
   const deleteTransportMutation = useMutation({
     mutationFn: transportApi.delete,
-    onSuccess: () => {
-      queryClient.invalidateQueries({ queryKey: ['transport-maps'] });
-      setDeleteConfirm(null);
-    },
+    onSuccess: (data, domain) => showUndo(`Transport map for ${domain}`, data.trashId),
   });
 
   const createSenderMutation = useMutation({
     mutationFn: senderRelayApi.create,
     onSuccess: () => {
       queryClient.invalidateQueries({ queryKey: ['sender-relays'] });
+      queryClient.invalidateQueries({ queryKey: ['routing-lint'] });
       setShowSenderDialog(false);
       senderForm.reset();
     },
@@ -139,6 +349,7 @@ export function TransportMapsPage() {
       senderRelayApi.update(sender, data),
     onSuccess: () => {
       queryClient.invalidateQueries({ queryKey: ['sender-relays'] });
+      queryClient.invalidateQueries({ queryKey: ['routing-lint'] });
       setShowSenderDialog(false);
       setEditingSender(null);
       senderForm.reset();
@@ -147,10 +358,7 @@ export function TransportMapsPage() {
 
   const deleteSenderMutation = useMutation({
     mutationFn: senderRelayApi.delete,
-    onSuccess: () => {
-      queryClient.invalidateQueries({ queryKey: ['sender-relays'] });
-      setDeleteConfirm(null);
-    },
+    onSuccess: (data, sender) => showUndo(`Sender relay for ${sender}`, data.trashId),
   });
 
   // Handlers
@@ -169,6 +377,8 @@ export function TransportMapsPage() {
     senderForm.reset({
       sender: sr.sender,
       relayhost: sr.relayhost,
+      username: senderLogin(sr.sender)?.username ?? '',
+      password: '',
     });
     setShowSenderDialog(true);
   };
@@ -184,14 +394,39 @@ export function TransportMapsPage() {
     }
   };
 
-  const onSenderSubmit = (data: SenderRelayFormData) => {
+  // Saves, or with the username cleared removes, the login the relay host
+  // gets from this sender
+  const saveSenderLogin = async ({ sender, username, password }: SenderRelayFormData) => {
+    const existing = senderLogin(sender);
+    if (username && (username !== existing?.username || password)) {
+      await configApi.saveCredentials({ sender, username, password: password || undefined });
+    } else if (!username && existing) {
+      await configApi.deleteCredentials(sender);
+    } else {
+      return;
+    }
+    queryClient.invalidateQueries({ queryKey: ['credentials'] });
+  };
+
+  const onSenderSubmit = async (data: SenderRelayFormData) => {
+    try {
+      await saveSenderLogin(data);
+    } catch (error) {
+      toast({
+        title: 'Login Not Saved',
+        description: error instanceof Error ? error.message : 'Failed to save the sender login',
+        variant: 'destructive',
+      });
+      return;
+    }
+    const relay = { sender: data.sender, relayhost: data.relayhost };
     if (editingSender) {
       updateSenderMutation.mutate({
         sender: editingSender.sender,
-        data: { ...data, enabled: editingSender.enabled },
+        data: { ...relay, enabled: editingSender.enabled },
       });
     } else {
-      createSenderMutation.mutate(data);
+      createSenderMutation.mutate(relay);
     }
   };
 
@@ -218,18 +453,83 @@ export function TransportMapsPage() {
             Configure domain-based and sender-based email routing
           </p>
         </div>
-        <Button
-          variant="outline"
-          onClick={() => {
-            refetchTransport();
-            refetchSender();
-          }}
-        >
-          <RefreshCw className="h-4 w-4 mr-2" />
-          Refresh
-        </Button>
+        <div className="flex gap-2">
+          <Button variant="outline" asChild>
+            <a href={routesApi.exportUrl('csv')}>
+              <Download className="h-4 w-4 mr-2" />
+              Export CSV
+            </a>
+          </Button>
+          <Button variant="outline" asChild>
+            <a href={routesApi.exportUrl('json')}>
+              <Download className="h-4 w-4 mr-2" />
+              Export JSON
+            </a>
+          </Button>
+          {isAdmin && (
+            <Button variant="outline" onClick={() => setShowImport(true)}>
+              <Upload className="h-4 w-4 mr-2" />
+              Import
+            </Button>
+          )}
+          <Button
+            variant="outline"
+            onClick={() => {
+              refetchTransport();
+              refetchSender();
+              refetchTrash();
+            }}
+          >
+            <RefreshCw className="h-4 w-4 mr-2" />
+            Refresh
+          </Button>
+        </div>
       </div>
 
+      {lintData && (
+        <Card>
+          <CardHeader>
+            <div className="flex items-center justify-between">
+              <div>
+                <CardTitle>Routing Check</CardTitle>
+                <CardDescription>
+                  {lintData.findings.length === 0
+                    ? 'relayhost, relay_domains, transport maps and sender relays agree with each other.'
+                    : `${lintData.counts.error} errors, ${lintData.counts.warning} warnings and ${lintData.counts.info} notes across relayhost, relay_domains, transport maps and sender relays.`}
+                </CardDescription>
+              </div>
+              <div className="flex items-center gap-2">
+                <Label htmlFor="lint-staged" className="text-sm">Include staged changes</Label>
+                <Switch id="lint-staged" checked={lintStaged} onCheckedChange={setLintStaged} />
+              </div>
+            </div>
+          </CardHeader>
+          <CardContent className="space-y-3">
+            {lintData.findings.map((f, i) => (
+              <div key={i} className="flex items-start gap-2 text-sm">
+                {findingIcons[f.severity]}
+                <div>
+                  {f.message}
+                  {f.precedence !== undefined && showPrecedence && (
+                    <div className="text-muted-foreground">{lintData.precedence[f.precedence]}</div>
+                  )}
+                </div>
+              </div>
+            ))}
+            <Button variant="link" className="px-0" onClick={() => setShowPrecedence(!showPrecedence)}>
+              {showPrecedence ? 'Hide' : 'Show'} how Postfix picks a route
+            </Button>
+            {showPrecedence && (
+              <ol className="list-decimal pl-5 text-sm text-muted-foreground space-y-1">
+                {lintData.precedence.map((p, i) => (
+                  <li key={i}>{p}</li>
+                ))}
+              </ol>
+            )}
+          </CardContent>
+        </Card>
+      )}
+
       <Tabs value={activeTab} onValueChange={setActiveTab}>
         <TabsList>
           <TabsTrigger value="transport" className="flex items-center gap-2">
@@ -240,6 +540,11 @@ export function TransportMapsPage() {
             <Send className="h-4 w-4" />
             Sender Relays
           </TabsTrigger>
+          <TabsTrigger value="trash" className="flex items-center gap-2">
+            <History className="h-4 w-4" />
+            Recently Deleted
+            {!!trashData?.entries.length && <Badge variant="secondary">{trashData.entries.length}</Badge>}
+          </TabsTrigger>
         </TabsList>
 
         <TabsContent value="transport" className="mt-4">
@@ -346,7 +651,7 @@ export function TransportMapsPage() {
                 {isAdmin && (
                   <Button onClick={() => {
                     setEditingSender(null);
-                    senderForm.reset({ sender: '', relayhost: '' });
+                    senderForm.reset({ sender: '', relayhost: '', username: '', password: '' });
                     setShowSenderDialog(true);
                   }}>
                     <Plus className="h-4 w-4 mr-2" />
@@ -371,6 +676,7 @@ export function TransportMapsPage() {
                       <TableRow>
                         <TableHead>Sender</TableHead>
                         <TableHead>Relay Host</TableHead>
+                        {isAdmin && <TableHead>Login</TableHead>}
                         <TableHead>Status</TableHead>
                         {isAdmin && <TableHead className="text-right">Actions</TableHead>}
                       </TableRow>
@@ -380,6 +686,18 @@ export function TransportMapsPage() {
                         <TableRow key={sr.sender}>
                           <TableCell className="font-mono">{sr.sender}</TableCell>
                           <TableCell className="font-mono">{sr.relayhost}</TableCell>
+                          {isAdmin && (
+                            <TableCell>
+                              {senderLogin(sr.sender) ? (
+                                <span className="flex items-center gap-1 font-mono text-sm">
+                                  <KeyRound className="h-3 w-3" />
+                                  {senderLogin(sr.sender)?.username}
+                                </span>
+                              ) : (
+                                <span className="text-muted-foreground text-sm">Relay host default</span>
+                              )}
+                            </TableCell>
+                          )}
                           <TableCell>
                             {isAdmin ? (
                               <Switch
@@ -421,6 +739,78 @@ export function TransportMapsPage() {
             </CardContent>
           </Card>
         </TabsContent>
+
+        <TabsContent value="trash" className="mt-4">
+          <Card>
+            <CardHeader>
+              <CardTitle>Recently Deleted</CardTitle>
+              <CardDescription>
+                Deleted transport maps, sender relays and backscatter domains.{' '}
+                {trashData?.retentionDays
+                  ? `They are purged ${trashData.retentionDays} days after deletion.`
+                  : 'They are kept until purged by hand.'}
+              </CardDescription>
+            </CardHeader>
+            <CardContent>
+              {!trashData?.entries.length ? (
+                <div className="text-center py-8 text-muted-foreground">Nothing has been deleted</div>
+              ) : (
+                <div className="rounded-md border">
+                  <Table>
+                    <TableHeader>
+                      <TableRow>
+                        <TableHead>Type</TableHead>
+                        <TableHead>Entry</TableHead>
+                        <TableHead>Deleted</TableHead>
+                        <TableHead>Purged</TableHead>
+                        {isAdmin && <TableHead className="text-right">Actions</TableHead>}
+                      </TableRow>
+                    </TableHeader>
+                    <TableBody>
+                      {trashData.entries.map((entry) => (
+                        <TableRow key={entry.id}>
+                          <TableCell>{trashKindLabels[entry.kind] ?? entry.kind}</TableCell>
+                          <TableCell className="font-mono">{entry.key}</TableCell>
+                          <TableCell>
+                            {formatDistanceToNow(new Date(entry.deletedAt), { addSuffix: true })}
+                            {entry.deletedBy && ` by ${entry.deletedBy}`}
+                          </TableCell>
+                          <TableCell>
+                            {entry.purgeAt ? formatDistanceToNow(new Date(entry.purgeAt), { addSuffix: true }) : 'Never'}
+                          </TableCell>
+                          {isAdmin && (
+                            <TableCell className="text-right">
+                              <div className="flex justify-end gap-1">
+                                <Button
+                                  variant="ghost"
+                                  size="sm"
+                                  onClick={() => restoreMutation.mutate(entry.id)}
+                                  disabled={restoreMutation.isPending}
+                                >
+                                  <Undo2 className="h-4 w-4 mr-1" />
+                                  Restore
+                                </Button>
+                                <Button
+                                  variant="ghost"
+                                  size="icon"
+                                  onClick={() => {
+                                    if (confirm(`Purge ${entry.key} for good?`)) purgeMutation.mutate(entry.id);
+                                  }}
+                                >
+                                  <Trash2 className="h-4 w-4 text-destructive" />
+                                </Button>
+                              </div>
+                            </TableCell>
+                          )}
+                        </TableRow>
+                      ))}
+                    </TableBody>
+                  </Table>
+                </div>
+              )}
+            </CardContent>
+          </Card>
+        </TabsContent>
       </Tabs>
 
       {/* Transport Map Dialog */}
@@ -527,6 +917,36 @@ export function TransportMapsPage() {
                   Format: [hostname]:port (brackets are optional)
                 </p>
               </div>
+              <div className="grid grid-cols-2 gap-4">
+                <div className="space-y-2">
+                  <Label htmlFor="sender-username">Username</Label>
+                  <Input
+                    id="sender-username"
+                    autoComplete="off"
+                    {...senderForm.register('username')}
+                  />
+                </div>
+                <div className="space-y-2">
+                  <Label htmlFor="sender-password">Password</Label>
+                  <Input
+                    id="sender-password"
+                    type="password"
+                    autoComplete="new-password"
+                    placeholder={editingSender && senderLogin(editingSender.sender) ? 'Unchanged' : ''}
+                    {...senderForm.register('password', {
+                      validate: (password, { sender, username }) =>
+                        !username || !!password || !!senderLogin(sender) || 'A password is needed with a username',
+                    })}
+                  />
+                </div>
+              </div>
+              <p className="text-xs text-muted-foreground">
+                Optional. The relay host is sent this login for mail from this sender instead of the
+                one saved for the relay host. Clear the username to remove it.
+              </p>
+              {senderForm.formState.errors.password && (
+                <p className="text-xs text-destructive">{senderForm.formState.errors.password.message}</p>
+              )}
             </div>
             <DialogFooter>
               <Button
@@ -547,6 +967,8 @@ export function TransportMapsPage() {
         </DialogContent>
       </Dialog>
 
+      <ImportRoutesDialog open={showImport} onClose={() => setShowImport(false)} onImported={invalidateRouting} />
+
       {/* Delete Confirmation Dialog */}
       <Dialog open={!!deleteConfirm} onOpenChange={() => setDeleteConfirm(null)}>
         <DialogContent>
@@ -554,7 +976,7 @@ export function TransportMapsPage() {
             <DialogTitle>Confirm Deletion</DialogTitle>
             <DialogDescription>
               Are you sure you want to delete this {deleteConfirm?.type === 'transport' ? 'transport map' : 'sender relay'}?
-              This action cannot be undone.
+              It can be restored from Recently Deleted until it is purged.
             </DialogDescription>
           </DialogHeader>
           <DialogFooter>
@@ -8,6 +8,8 @@ export interface User {
   username: string;
   email: string;
   role: UserRole;
+  // tenantId confines the user to one tenant's domains and users
+  tenantId?: number;
 }
 
 interface AuthState {
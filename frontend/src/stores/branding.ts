@@ -0,0 +1,80 @@
+import { create } from 'zustand';
+import { brandingApi, Branding } from '@/lib/api';
+import { applyTheme } from '@/stores/theme';
+
+// DEFAULT_PRODUCT_NAME is what the backend reports when no product name is set
+export const DEFAULT_PRODUCT_NAME = 'PSFX Suite';
+
+interface BrandingState {
+  branding: Branding;
+  // custom is true once an MSP has set its own product name
+  custom: boolean;
+  load: () => Promise<void>;
+}
+
+// hexToHSL converts #rrggbb to hue, saturation and lightness, which the
+// theme's CSS variables are written in
+function hexToHSL(hex: string): { h: number; s: number; l: number } {
+  const r = parseInt(hex.slice(1, 3), 16) / 255;
+  const g = parseInt(hex.slice(3, 5), 16) / 255;
+  const b = parseInt(hex.slice(5, 7), 16) / 255;
+  const max = Math.max(r, g, b);
+  const min = Math.min(r, g, b);
+  const l = (max + min) / 2;
+  let h = 0;
+  let s = 0;
+  if (max !== min) {
+    const d = max - min;
+    s = l > 0.5 ? d / (2 - max - min) : d / (max + min);
+    if (max === r) h = (g - b) / d + (g < b ? 6 : 0);
+    else if (max === g) h = (b - r) / d + 2;
+    else h = (r - g) / d + 4;
+    h *= 60;
+  }
+  return { h: Math.round(h), s: Math.round(s * 100), l: Math.round(l * 100) };
+}
+
+function setColor(name: string, hex?: string) {
+  const style = document.documentElement.style;
+  if (!hex) {
+    style.removeProperty(`--${name}`);
+    style.removeProperty(`--${name}-foreground`);
+    return;
+  }
+  const { h, s, l } = hexToHSL(hex);
+  style.setProperty(`--${name}`, `${h} ${s}% ${l}%`);
+  // Keep text on the color readable
+  style.setProperty(`--${name}-foreground`, l > 60 ? '222.2 47.4% 11.2%' : '210 40% 98%');
+}
+
+function applyBranding(branding: Branding) {
+  document.title = branding.productName;
+  setColor('primary', branding.primaryColor);
+  setColor('accent', branding.accentColor);
+  if (branding.primaryColor) {
+    const { h, s, l } = hexToHSL(branding.primaryColor);
+    document.documentElement.style.setProperty('--ring', `${h} ${s}% ${l}%`);
+  } else {
+    document.documentElement.style.removeProperty('--ring');
+  }
+
+  // The deployment's default theme applies until the user picks one
+  if (localStorage.getItem('psfx-theme') === null) {
+    applyTheme(branding.defaultTheme);
+  }
+}
+
+export const useBrandingStore = create<BrandingState>((set) => ({
+  branding: { productName: DEFAULT_PRODUCT_NAME, defaultTheme: 'system' },
+  custom: false,
+
+  load: async () => {
+    try {
+      const branding = await brandingApi.get();
+      applyBranding(branding);
+      set({ branding, custom: branding.productName !== DEFAULT_PRODUCT_NAME });
+    } catch {
+      // Keep the default branding
+    }
+  },
+}));